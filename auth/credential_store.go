@@ -36,6 +36,17 @@ const (
 	PermBackup = "backup"
 	// PermLoad means user can load a SQLite dump into a node.
 	PermLoad = "load"
+	// PermApprove means user can approve operations submitted by other users.
+	PermApprove = "approve"
+	// PermStandby means user can read the change feed and fence the cluster,
+	// as required by a warm standby cluster.
+	PermStandby = "standby"
+	// PermRestart means user can restart nodes, including a rolling restart of
+	// the cluster.
+	PermRestart = "restart"
+	// PermSandbox means user can run read-only queries on the sandbox
+	// endpoint, which cannot change the database.
+	PermSandbox = "sandbox"
 )
 
 // BasicAuther is the interface an object must support to return basic auth information.
@@ -4,21 +4,70 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"os"
+	"time"
 
 	"github.com/rqlite/rqlite/auto"
+	"github.com/rqlite/rqlite/auto/encryption"
+	"github.com/rqlite/rqlite/auto/webhook"
 	"github.com/rqlite/rqlite/aws"
+	"github.com/rqlite/rqlite/azure"
+	"github.com/rqlite/rqlite/gcp"
+	"github.com/rqlite/rqlite/sftp"
 )
 
+// DefaultFullInterval is the default interval between full backups, when
+// incremental backups are enabled.
+const DefaultFullInterval = 24 * time.Hour
+
 // Config is the config file format for the upload service
 type Config struct {
 	Version    int              `json:"version"`
 	Type       auto.StorageType `json:"type"`
 	NoCompress bool             `json:"no_compress,omitempty"`
 	Interval   auto.Duration    `json:"interval"`
-	Sub        json.RawMessage  `json:"sub"`
+
+	// Compression is the codec with which backups are compressed, either
+	// gzip, the default, or zstd. CompressionLevel is the compression level,
+	// the codec's default if not set.
+	Compression      string `json:"compression,omitempty"`
+	CompressionLevel int    `json:"compression_level,omitempty"`
+
+	// Schedule, if set, is a cron expression setting the times at which
+	// backups are uploaded, in which case Interval is ignored. See Schedule
+	// for the supported syntax.
+	Schedule string `json:"schedule,omitempty"`
+
+	// Incremental enables incremental backups, which upload only the pages
+	// changed since the last full backup. A full backup is uploaded every
+	// FullInterval, or every DefaultFullInterval if not set.
+	Incremental  bool          `json:"incremental,omitempty"`
+	FullInterval auto.Duration `json:"full_interval,omitempty"`
+
+	// Retention, if set, keeps each full backup as a version alongside the
+	// backup, and prunes versions which the policy does not keep.
+	Retention *RetentionPolicy `json:"retention,omitempty"`
+
+	// Encryption, if set, enables encryption of the data before it is
+	// uploaded, so that it is never held unencrypted by the storage service.
+	Encryption *encryption.Config `json:"encryption,omitempty"`
+
+	// Verify enables verification of backups, by downloading each once
+	// uploaded and checking it matches what was uploaded.
+	Verify bool `json:"verify,omitempty"`
+
+	// Webhook, if set, is notified of each backup, and each failed attempt to back up.
+	Webhook *webhook.Config `json:"webhook,omitempty"`
+
+	// SnapshotOffload, if set, enables offloading of Raft snapshots to the
+	// storage service, alongside the backup.
+	SnapshotOffload *OffloadConfig `json:"snapshot_offload,omitempty"`
+
+	Sub json.RawMessage `json:"sub"`
 }
 
-// Unmarshal unmarshals the config file and returns the config and subconfig
+// Unmarshal unmarshals the config file and returns the config and subconfig.
+// The subconfig is only meaningful if the storage type is S3. Use
+// NewStorageClient to create a client for any supported storage type.
 func Unmarshal(data []byte) (*Config, *aws.S3Config, error) {
 	cfg := &Config{}
 	err := json.Unmarshal(data, cfg)
@@ -30,6 +79,12 @@ func Unmarshal(data []byte) (*Config, *aws.S3Config, error) {
 		return nil, nil, auto.ErrInvalidVersion
 	}
 
+	if cfg.Schedule != "" {
+		if _, err := ParseSchedule(cfg.Schedule); err != nil {
+			return nil, nil, err
+		}
+	}
+
 	s3cfg := &aws.S3Config{}
 	err = json.Unmarshal(cfg.Sub, s3cfg)
 	if err != nil {
@@ -38,6 +93,45 @@ func Unmarshal(data []byte) (*Config, *aws.S3Config, error) {
 	return cfg, s3cfg, nil
 }
 
+// NewStorageClient returns a client for the storage service set in the
+// config, configured by the config's subconfig.
+func NewStorageClient(cfg *Config) (StorageClient, error) {
+	switch cfg.Type {
+	case auto.StorageTypeS3:
+		s3cfg := &aws.S3Config{}
+		if err := json.Unmarshal(cfg.Sub, s3cfg); err != nil {
+			return nil, err
+		}
+		c := aws.NewS3Client(s3cfg.Endpoint, s3cfg.Region, s3cfg.AccessKeyID, s3cfg.SecretAccessKey,
+			s3cfg.Bucket, s3cfg.Path)
+		if err := c.SetMultipart(s3cfg.PartSize, s3cfg.Concurrency); err != nil {
+			return nil, err
+		}
+		return c, nil
+	case auto.StorageTypeGCS:
+		gcsCfg := &gcp.GCSConfig{}
+		if err := json.Unmarshal(cfg.Sub, gcsCfg); err != nil {
+			return nil, err
+		}
+		return gcp.NewGCSClient(gcsCfg.Endpoint, gcsCfg.CredentialsFile, gcsCfg.Bucket, gcsCfg.Name), nil
+	case auto.StorageTypeAzure:
+		azCfg := &azure.BlobConfig{}
+		if err := json.Unmarshal(cfg.Sub, azCfg); err != nil {
+			return nil, err
+		}
+		return azure.NewBlobClient(azCfg.Account, azCfg.Endpoint, azCfg.Container, azCfg.Blob,
+			azCfg.SASToken, azCfg.ClientID), nil
+	case auto.StorageTypeSFTP:
+		sftpCfg := &sftp.SFTPConfig{}
+		if err := json.Unmarshal(cfg.Sub, sftpCfg); err != nil {
+			return nil, err
+		}
+		return sftp.NewSFTPClient(sftpCfg.Host, sftpCfg.Username, sftpCfg.PrivateKeyFile,
+			sftpCfg.Passphrase, sftpCfg.KnownHostsFile, sftpCfg.Path), nil
+	}
+	return nil, auto.ErrUnsupportedStorageType
+}
+
 // ReadConfigFile reads the config file and returns the data. It also expands
 // any environment variables in the config file.
 func ReadConfigFile(filename string) ([]byte, error) {
@@ -3,6 +3,7 @@ package backup
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"reflect"
@@ -10,6 +11,7 @@ import (
 	"time"
 
 	"github.com/rqlite/rqlite/auto"
+	"github.com/rqlite/rqlite/auto/encryption"
 	"github.com/rqlite/rqlite/aws"
 )
 
@@ -125,20 +127,38 @@ func TestUnmarshal(t *testing.T) {
 				"type": "s3",
 				"no_compress": true,
 				"interval": "24h",
+				"schedule": "0 3 * * *",
+				"compression": "zstd",
+				"compression_level": 3,
+				"incremental": true,
+				"full_interval": "168h",
+				"retention": {"keep_last": 3, "keep_daily": 7},
+				"encryption": {"type": "aes-256-gcm", "key_env": "BACKUP_KEY"},
+				"snapshot_offload": {"every": 10, "name": "snapshots/{node_id}"},
 				"sub": {
 					"access_key_id": "test_id",
 					"secret_access_key": "test_secret",
 					"region": "us-west-2",
 					"bucket": "test_bucket",
-					"path": "test/path"
+					"path": "test/path",
+					"part_size": 67108864,
+					"concurrency": 8
 				}
 			}
 			`),
 			expectedCfg: &Config{
-				Version:    1,
-				Type:       "s3",
-				NoCompress: true,
-				Interval:   24 * auto.Duration(time.Hour),
+				Version:          1,
+				Type:             "s3",
+				NoCompress:       true,
+				Interval:         24 * auto.Duration(time.Hour),
+				Schedule:         "0 3 * * *",
+				Compression:      "zstd",
+				CompressionLevel: 3,
+				Incremental:      true,
+				FullInterval:     168 * auto.Duration(time.Hour),
+				Retention:        &RetentionPolicy{KeepLast: 3, KeepDaily: 7},
+				Encryption:       &encryption.Config{Type: encryption.TypeAES256GCM, KeyEnv: "BACKUP_KEY"},
+				SnapshotOffload:  &OffloadConfig{Every: 10, Name: "snapshots/{node_id}"},
 			},
 			expectedS3: &aws.S3Config{
 				AccessKeyID:     "test_id",
@@ -146,6 +166,8 @@ func TestUnmarshal(t *testing.T) {
 				Region:          "us-west-2",
 				Bucket:          "test_bucket",
 				Path:            "test/path",
+				PartSize:        64 * 1024 * 1024,
+				Concurrency:     8,
 			},
 			expectedErr: nil,
 		},
@@ -200,6 +222,22 @@ func TestUnmarshal(t *testing.T) {
 			expectedS3:  nil,
 			expectedErr: auto.ErrInvalidVersion,
 		},
+		{
+			name: "InvalidSchedule",
+			input: []byte(`
+			{
+				"version": 1,
+				"type": "s3",
+				"schedule": "0 25 * * *",
+				"sub": {
+					"bucket": "test_bucket",
+					"path": "test/path"
+				}
+			}			`),
+			expectedCfg: nil,
+			expectedS3:  nil,
+			expectedErr: ErrInvalidSchedule,
+		},
 		{
 			name: "UnsupportedType",
 			input: []byte(`
@@ -244,6 +282,43 @@ func TestUnmarshal(t *testing.T) {
 	}
 }
 
+func Test_NewStorageClient(t *testing.T) {
+	for _, tc := range []struct {
+		typ auto.StorageType
+		sub string
+		exp string
+	}{
+		{auto.StorageTypeS3, `{"region": "us-west-2", "bucket": "b", "path": "p"}`, "s3://b/p"},
+		{auto.StorageTypeGCS, `{"bucket": "b", "name": "n"}`, "gs://b/n"},
+		{auto.StorageTypeAzure, `{"account": "a", "container": "c", "blob": "b", "sas_token": "sv=x"}`,
+			"https://a.blob.core.windows.net/c/b"},
+		{auto.StorageTypeSFTP, `{"host": "h", "username": "u", "private_key_file": "k", "known_hosts_file": "kh", "path": "/p"}`,
+			"sftp://u@h:22/p"},
+	} {
+		sc, err := NewStorageClient(&Config{Type: tc.typ, Sub: []byte(tc.sub)})
+		if err != nil {
+			t.Fatalf("failed to create %s storage client: %s", tc.typ, err)
+		}
+		if got := sc.(fmt.Stringer).String(); got != tc.exp {
+			t.Fatalf("wrong %s storage client, exp %s, got %s", tc.typ, tc.exp, got)
+		}
+		if _, ok := sc.(DeltaStorageClient); !ok {
+			t.Fatalf("%s storage client does not support incremental backups", tc.typ)
+		}
+		if _, ok := sc.(VersionedStorageClient); !ok {
+			t.Fatalf("%s storage client does not support retention", tc.typ)
+		}
+	}
+
+	if _, err := NewStorageClient(&Config{Type: auto.StorageTypeS3, Sub: []byte(`{"bucket": "b", "path": "p", "part_size": 1024}`)}); err == nil {
+		t.Fatalf("expected error for S3 part size below minimum")
+	}
+
+	if _, err := NewStorageClient(&Config{Type: "unsupported"}); !errors.Is(err, auto.ErrUnsupportedStorageType) {
+		t.Fatalf("expected ErrUnsupportedStorageType, got %v", err)
+	}
+}
+
 func compareConfig(a, b *Config) bool {
 	if a == nil || b == nil {
 		return a == b
@@ -251,5 +326,13 @@ func compareConfig(a, b *Config) bool {
 	return a.Version == b.Version &&
 		a.Type == b.Type &&
 		a.NoCompress == b.NoCompress &&
-		a.Interval == b.Interval
+		a.Interval == b.Interval &&
+		a.Schedule == b.Schedule &&
+		a.Compression == b.Compression &&
+		a.CompressionLevel == b.CompressionLevel &&
+		a.Incremental == b.Incremental &&
+		a.FullInterval == b.FullInterval &&
+		reflect.DeepEqual(a.Retention, b.Retention) &&
+		reflect.DeepEqual(a.Encryption, b.Encryption) &&
+		reflect.DeepEqual(a.SnapshotOffload, b.SnapshotOffload)
 }
@@ -0,0 +1,239 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// An incremental backup consists of a full backup, and a delta holding the
+// pages of the database which have changed since that full backup was made.
+// Each delta replaces the previous one, so restoring requires only the full
+// backup and the latest delta. A delta is laid out as follows, with all
+// integers big-endian:
+//
+//	magic       8 bytes, "RQDELTA1"
+//	base sum    32 bytes, the SHA-256 sum of the uncompressed full backup
+//	page size   4 bytes
+//	page count  4 bytes, the number of pages in the database
+//	pages       repeated 4-byte page number, followed by the page itself
+//
+// Page numbers start at 1, as they do in SQLite.
+
+var deltaMagic = []byte("RQDELTA1")
+
+const sqliteHeaderSize = 100
+
+var (
+	// ErrInvalidDelta is returned when data is not a valid delta.
+	ErrInvalidDelta = errors.New("invalid delta")
+
+	// ErrDeltaBaseMismatch is returned when a delta is applied to a database
+	// other than the full backup it was made against.
+	ErrDeltaBaseMismatch = errors.New("delta was not made against this database")
+
+	// errPageSizeChanged is returned when the page size of a database differs
+	// from that of the full backup a delta is to be made against.
+	errPageSizeChanged = errors.New("page size changed")
+)
+
+// pageHashes are the SHA-256 sums of the pages of a database, and of the
+// database as a whole.
+type pageHashes struct {
+	pageSize int
+	sum      SHA256Sum
+	pages    [][sha256.Size]byte
+}
+
+// hashPages returns the page hashes of the SQLite database at path.
+func hashPages(path string) (*pageHashes, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	pageSize, err := readPageSize(fd)
+	if err != nil {
+		return nil, err
+	}
+	ph := &pageHashes{pageSize: pageSize}
+	h := sha256.New()
+	buf := make([]byte, pageSize)
+	for {
+		if _, err := io.ReadFull(fd, buf); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		ph.pages = append(ph.pages, sha256.Sum256(buf))
+		h.Write(buf)
+	}
+	ph.sum = h.Sum(nil)
+	return ph, nil
+}
+
+// writeDelta writes to w the delta between the SQLite database at path and
+// the full backup whose page hashes are base. It returns the number of pages
+// in the delta, and the number of pages in the database.
+func writeDelta(w io.Writer, path string, base *pageHashes) (int, int, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer fd.Close()
+
+	pageSize, err := readPageSize(fd)
+	if err != nil {
+		return 0, 0, err
+	}
+	if pageSize != base.pageSize {
+		return 0, 0, errPageSizeChanged
+	}
+	info, err := fd.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	if info.Size()%int64(pageSize) != 0 {
+		return 0, 0, fmt.Errorf("size of %s is not a multiple of page size %d", path, pageSize)
+	}
+	count := int(info.Size() / int64(pageSize))
+
+	hdr := make([]byte, 0, len(deltaMagic)+sha256.Size+8)
+	hdr = append(hdr, deltaMagic...)
+	hdr = append(hdr, base.sum...)
+	hdr = appendUint32(hdr, uint32(pageSize))
+	hdr = appendUint32(hdr, uint32(count))
+	if _, err := w.Write(hdr); err != nil {
+		return 0, 0, err
+	}
+
+	changed := 0
+	num := make([]byte, 4)
+	buf := make([]byte, pageSize)
+	for i := 0; i < count; i++ {
+		if _, err := io.ReadFull(fd, buf); err != nil {
+			return 0, 0, err
+		}
+		if i < len(base.pages) && sha256.Sum256(buf) == base.pages[i] {
+			continue
+		}
+		binary.BigEndian.PutUint32(num, uint32(i+1))
+		if _, err := w.Write(num); err != nil {
+			return 0, 0, err
+		}
+		if _, err := w.Write(buf); err != nil {
+			return 0, 0, err
+		}
+		changed++
+	}
+	return changed, count, nil
+}
+
+// ApplyDelta applies the delta read from r to the SQLite database at path,
+// which must be the full backup the delta was made against. The delta may be
+// gzip or zstd-compressed. Once it returns successfully, the database at path is
+// that from which the delta was made.
+func ApplyDelta(path string, r io.Reader) error {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		br = bufio.NewReader(gr)
+	} else if magic, err := br.Peek(4); err == nil && bytes.Equal(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}) {
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		br = bufio.NewReader(zr)
+	}
+
+	hdr := make([]byte, len(deltaMagic)+sha256.Size+8)
+	if _, err := io.ReadFull(br, hdr); err != nil || !bytes.Equal(hdr[:len(deltaMagic)], deltaMagic) {
+		return ErrInvalidDelta
+	}
+	baseSum := SHA256Sum(hdr[len(deltaMagic) : len(deltaMagic)+sha256.Size])
+	pageSize := int64(binary.BigEndian.Uint32(hdr[len(hdr)-8:]))
+	count := int64(binary.BigEndian.Uint32(hdr[len(hdr)-4:]))
+	if pageSize == 0 {
+		return ErrInvalidDelta
+	}
+
+	sum, err := FileSHA256(path)
+	if err != nil {
+		return err
+	}
+	if !sum.Equals(baseSum) {
+		return ErrDeltaBaseMismatch
+	}
+
+	fd, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	num := make([]byte, 4)
+	buf := make([]byte, pageSize)
+	for {
+		if _, err := io.ReadFull(br, num); err == io.EOF {
+			break
+		} else if err != nil {
+			return ErrInvalidDelta
+		}
+		n := int64(binary.BigEndian.Uint32(num))
+		if n < 1 || n > count {
+			return ErrInvalidDelta
+		}
+		if _, err := io.ReadFull(br, buf); err != nil {
+			return ErrInvalidDelta
+		}
+		if _, err := fd.WriteAt(buf, (n-1)*pageSize); err != nil {
+			return err
+		}
+	}
+	if err := fd.Truncate(count * pageSize); err != nil {
+		return err
+	}
+	return fd.Sync()
+}
+
+// readPageSize returns the page size of the SQLite database read from fd,
+// leaving fd positioned at the start of the database.
+func readPageSize(fd *os.File) (int, error) {
+	hdr := make([]byte, sqliteHeaderSize)
+	if _, err := io.ReadFull(fd, hdr); err != nil {
+		return 0, fmt.Errorf("failed to read SQLite header: %w", err)
+	}
+	if !bytes.HasPrefix(hdr, []byte("SQLite format 3\x00")) {
+		return 0, errors.New("not a SQLite database")
+	}
+	if _, err := fd.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	// A page size of 65536 is stored as 1, since it does not fit in 16 bits.
+	pageSize := int(binary.BigEndian.Uint16(hdr[16:18]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+	if pageSize < 512 {
+		return 0, fmt.Errorf("invalid page size %d", pageSize)
+	}
+	return pageSize, nil
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
@@ -0,0 +1,121 @@
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/rqlite/rqlite/db"
+)
+
+func Test_Delta(t *testing.T) {
+	dir := t.TempDir()
+	d, err := db.Open(filepath.Join(dir, "db.sqlite"), false, true)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err)
+	}
+	defer d.Close()
+	mustExecute(t, d, "CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT)")
+	for i := 0; i < 500; i++ {
+		mustExecute(t, d, fmt.Sprintf(`INSERT INTO foo(name) VALUES("%s")`, strings.Repeat("x", 100)))
+	}
+	basePath := filepath.Join(dir, "base.sqlite")
+	if err := d.Backup(basePath); err != nil {
+		t.Fatalf("failed to back up database: %s", err)
+	}
+	base, err := hashPages(basePath)
+	if err != nil {
+		t.Fatalf("failed to hash pages: %s", err)
+	}
+	if sum, err := FileSHA256(basePath); err != nil || !sum.Equals(base.sum) {
+		t.Fatalf("wrong sum of base, exp %s, got %s", sum, base.sum)
+	}
+
+	mustExecute(t, d, `UPDATE foo SET name="y" WHERE id=1`)
+	mustExecute(t, d, fmt.Sprintf(`INSERT INTO foo(name) VALUES("%s")`, strings.Repeat("z", 3000)))
+	newPath := filepath.Join(dir, "new.sqlite")
+	if err := d.Backup(newPath); err != nil {
+		t.Fatalf("failed to back up database: %s", err)
+	}
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	changed, count, err := writeDelta(gw, newPath, base)
+	if err != nil {
+		t.Fatalf("failed to write delta: %s", err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatalf("failed to compress delta: %s", err)
+	}
+	if changed == 0 || changed*4 > count {
+		t.Fatalf("unexpected number of changed pages, %d of %d", changed, count)
+	}
+
+	restorePath := filepath.Join(dir, "restore.sqlite")
+	mustCopyFile(t, basePath, restorePath)
+	if err := ApplyDelta(restorePath, bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("failed to apply delta: %s", err)
+	}
+	if !bytes.Equal(mustReadFile(t, newPath), mustReadFile(t, restorePath)) {
+		t.Fatalf("database with delta applied differs from that the delta was made from")
+	}
+
+	if err := ApplyDelta(restorePath, bytes.NewReader(buf.Bytes())); err != ErrDeltaBaseMismatch {
+		t.Fatalf("expected ErrDeltaBaseMismatch, got %v", err)
+	}
+	if err := ApplyDelta(restorePath, strings.NewReader("not a delta")); err != ErrInvalidDelta {
+		t.Fatalf("expected ErrInvalidDelta, got %v", err)
+	}
+
+	// Deltas may also be zstd-compressed.
+	buf.Reset()
+	zw, err := zstd.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("failed to create zstd writer: %s", err)
+	}
+	if _, _, err := writeDelta(zw, newPath, base); err != nil {
+		t.Fatalf("failed to write delta: %s", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to compress delta: %s", err)
+	}
+	mustCopyFile(t, basePath, restorePath)
+	if err := ApplyDelta(restorePath, bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("failed to apply zstd-compressed delta: %s", err)
+	}
+	if !bytes.Equal(mustReadFile(t, newPath), mustReadFile(t, restorePath)) {
+		t.Fatalf("database with zstd-compressed delta applied differs from that the delta was made from")
+	}
+}
+
+func mustExecute(t *testing.T, d *db.DB, stmt string) {
+	t.Helper()
+	r, err := d.ExecuteStringStmt(stmt)
+	if err != nil {
+		t.Fatalf("failed to execute %s: %s", stmt, err)
+	}
+	if r[0].Error != "" {
+		t.Fatalf("failed to execute %s: %s", stmt, r[0].Error)
+	}
+}
+
+func mustCopyFile(t *testing.T, src, dst string) {
+	t.Helper()
+	if err := os.WriteFile(dst, mustReadFile(t, src), 0644); err != nil {
+		t.Fatalf("failed to write %s: %s", dst, err)
+	}
+}
+
+func mustReadFile(t *testing.T, path string) []byte {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", path, err)
+	}
+	return b
+}
@@ -0,0 +1,168 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rqlite/rqlite/auto"
+)
+
+var (
+	// ErrOffloadNotSupported is returned when snapshot offload is enabled for
+	// a storage client which cannot upload to names other than its own.
+	ErrOffloadNotSupported = errors.New("storage client does not support snapshot offload")
+
+	// ErrInvalidOffloadConfig is returned when the number of snapshots taken
+	// for each one offloaded is negative.
+	ErrInvalidOffloadConfig = errors.New("snapshot offload interval must not be negative")
+)
+
+// OffloadConfig is the config for offloading Raft snapshots.
+type OffloadConfig struct {
+	// Every is the number of snapshots taken for each one offloaded, so that
+	// every Every-th snapshot is offloaded. If not set, every snapshot is
+	// offloaded.
+	Every int `json:"every,omitempty"`
+
+	// Name is the name to which snapshots are offloaded, which may contain
+	// the timestamp and node ID placeholders. If not set, snapshots are
+	// offloaded to the name of the backup, plus auto.SnapshotSuffix.
+	Name string `json:"name,omitempty"`
+}
+
+// SnapshotProvider is an interface for opening Raft snapshots, so that they
+// can be offloaded.
+type SnapshotProvider interface {
+	// OpenSnapshot opens the snapshot with the given ID for reading.
+	OpenSnapshot(id string) (io.ReadCloser, error)
+}
+
+// Offloader is a service that uploads Raft snapshots, as they are taken, to
+// a storage service. Each snapshot is uploaded as the stream Raft would send
+// to install it on another node, so that a node can be rebuilt from what was
+// offloaded if its own snapshots are lost. It is separate from the Uploader,
+// which uploads a copy of the database.
+type Offloader struct {
+	storageClient TemplatedStorageClient
+	provider      SnapshotProvider
+	every         int
+	nameTmpl      *nameTemplate
+
+	logger *log.Logger
+
+	mu              sync.Mutex
+	numSnapshots    int
+	lastOffloadID   string
+	lastOffloadName string
+	lastOffloadTime time.Time
+	lastOffloadSize int64
+}
+
+// NewOffloader returns an Offloader uploading snapshots opened by provider
+// to storageClient, as configured by cfg, with nodeID as the node ID in the
+// name to which they are offloaded.
+func NewOffloader(storageClient StorageClient, provider SnapshotProvider, cfg *OffloadConfig,
+	nodeID string) (*Offloader, error) {
+	tc, ok := storageClient.(TemplatedStorageClient)
+	if !ok {
+		return nil, ErrOffloadNotSupported
+	}
+	if cfg.Every < 0 {
+		return nil, ErrInvalidOffloadConfig
+	}
+	every := cfg.Every
+	if every == 0 {
+		every = 1
+	}
+	name := cfg.Name
+	if name == "" {
+		name = tc.Name() + auto.SnapshotSuffix
+	}
+	nt, err := newNameTemplate(name, nodeID)
+	if err != nil {
+		return nil, err
+	}
+	if nt.usesDBHash() {
+		return nil, fmt.Errorf("placeholder %s is not supported in %q", PlaceholderDBHash, name)
+	}
+	return &Offloader{
+		storageClient: tc,
+		provider:      provider,
+		every:         every,
+		nameTmpl:      nt,
+		logger:        log.New(os.Stderr, "[offloader] ", log.LstdFlags),
+	}, nil
+}
+
+// Start starts the Offloader service. The ID of each snapshot taken must be
+// sent on ch, and every Every-th snapshot is offloaded. The service runs
+// until ctx is done.
+func (o *Offloader) Start(ctx context.Context, ch <-chan string) {
+	o.logger.Printf("starting offload of every %d snapshot(s) to %s", o.every, o.storageClient)
+	for {
+		select {
+		case <-ctx.Done():
+			o.logger.Println("offload service shutting down")
+			return
+		case id := <-ch:
+			o.mu.Lock()
+			o.numSnapshots++
+			n := o.numSnapshots
+			o.mu.Unlock()
+			if n%o.every != 0 {
+				continue
+			}
+			if err := o.offload(ctx, id); err != nil {
+				stats.Add(numOffloadsFail, 1)
+				o.logger.Printf("failed to offload snapshot %s to %s: %v", id, o.storageClient, err)
+			}
+		}
+	}
+}
+
+// Stats returns the stats for the Offloader service.
+func (o *Offloader) Stats() (map[string]interface{}, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return map[string]interface{}{
+		"offload_destination": o.storageClient.String(),
+		"offload_every":       o.every,
+		"num_snapshots":       o.numSnapshots,
+		"last_offload_id":     o.lastOffloadID,
+		"last_offload_name":   o.lastOffloadName,
+		"last_offload_time":   o.lastOffloadTime.Format(time.RFC3339),
+		"last_offload_size":   o.lastOffloadSize,
+	}, nil
+}
+
+// offload uploads the snapshot with the given ID.
+func (o *Offloader) offload(ctx context.Context, id string) error {
+	rc, err := o.provider.OpenSnapshot(id)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	startT := time.Now()
+	name := o.nameTmpl.expand(startT, nil)
+	cr := &countingReader{reader: rc}
+	if err := o.storageClient.UploadTo(ctx, name, cr); err != nil {
+		return err
+	}
+	stats.Add(numOffloadsOK, 1)
+	stats.Add(totalOffloadBytes, cr.count)
+	o.mu.Lock()
+	o.lastOffloadID = id
+	o.lastOffloadName = name
+	o.lastOffloadTime = time.Now()
+	o.lastOffloadSize = cr.count
+	o.mu.Unlock()
+	o.logger.Printf("offloaded snapshot %s to %s (%d bytes) in %s", id, name, cr.count, time.Since(startT))
+	return nil
+}
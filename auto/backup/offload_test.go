@@ -0,0 +1,159 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_NewOffloader(t *testing.T) {
+	p := &mockSnapshotProvider{}
+	if _, err := NewOffloader(&mockStorageClient{}, p, &OffloadConfig{}, "node1"); err != ErrOffloadNotSupported {
+		t.Fatalf("expected ErrOffloadNotSupported, got %v", err)
+	}
+	tc := &mockTemplatedStorageClient{name: "backups/db.sqlite"}
+	if _, err := NewOffloader(tc, p, &OffloadConfig{Every: -1}, "node1"); err != ErrInvalidOffloadConfig {
+		t.Fatalf("expected ErrInvalidOffloadConfig, got %v", err)
+	}
+	if _, err := NewOffloader(tc, p, &OffloadConfig{Name: "snaps/{db_hash}"}, "node1"); err == nil {
+		t.Fatalf("expected error for database hash placeholder")
+	}
+	if _, err := NewOffloader(tc, p, &OffloadConfig{Name: "snaps/{nope}"}, "node1"); err == nil {
+		t.Fatalf("expected error for unsupported placeholder")
+	}
+
+	o, err := NewOffloader(tc, p, &OffloadConfig{}, "node1")
+	if err != nil {
+		t.Fatalf("failed to create offloader: %s", err)
+	}
+	if o.every != 1 {
+		t.Fatalf("expected every 1, got %d", o.every)
+	}
+	if exp, got := "backups/db.sqlite.snapshot", o.nameTmpl.expand(time.Now(), nil); exp != got {
+		t.Fatalf("expected name %s, got %s", exp, got)
+	}
+}
+
+func Test_OffloaderEvery(t *testing.T) {
+	ResetStats()
+	var mu sync.Mutex
+	uploaded := make(map[string]string)
+	uploadedCh := make(chan struct{}, 10)
+	tc := &mockTemplatedStorageClient{
+		name: "backups/db.sqlite",
+		uploadToFn: func(ctx context.Context, name string, reader io.Reader) error {
+			b, err := ioutil.ReadAll(reader)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			uploaded[name] = string(b)
+			mu.Unlock()
+			uploadedCh <- struct{}{}
+			return nil
+		},
+	}
+	p := &mockSnapshotProvider{}
+	o, err := NewOffloader(tc, p, &OffloadConfig{Every: 2, Name: "snaps/{node_id}"}, "node1")
+	if err != nil {
+		t.Fatalf("failed to create offloader: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := make(chan string, 4)
+	go o.Start(ctx, ch)
+	for _, id := range []string{"1-10", "1-20", "1-30", "1-40"} {
+		ch <- id
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-uploadedCh:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for offload")
+		}
+	}
+
+	waitForStat(t, numOffloadsOK, "2")
+	mu.Lock()
+	if exp, got := "snapshot 1-40", uploaded["snaps/node1"]; exp != got {
+		t.Fatalf("expected last offload %q, got %q", exp, got)
+	}
+	mu.Unlock()
+	if exp, got := []string{"1-20", "1-40"}, p.opened(); strings.Join(exp, ",") != strings.Join(got, ",") {
+		t.Fatalf("expected snapshots %v to be opened, got %v", exp, got)
+	}
+	if exp, got := "26", stats.Get(totalOffloadBytes).String(); exp != got {
+		t.Fatalf("expected %s bytes offloaded, got %s", exp, got)
+	}
+
+	st, err := o.Stats()
+	if err != nil {
+		t.Fatalf("failed to get stats: %s", err)
+	}
+	if st["last_offload_id"] != "1-40" {
+		t.Fatalf("expected last offload ID 1-40, got %v", st["last_offload_id"])
+	}
+	if st["num_snapshots"] != 4 {
+		t.Fatalf("expected 4 snapshots, got %v", st["num_snapshots"])
+	}
+}
+
+func Test_OffloaderFail(t *testing.T) {
+	ResetStats()
+	tc := &mockTemplatedStorageClient{name: "backups/db.sqlite"}
+	p := &mockSnapshotProvider{err: errors.New("snapshot not found")}
+	o, err := NewOffloader(tc, p, &OffloadConfig{}, "node1")
+	if err != nil {
+		t.Fatalf("failed to create offloader: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := make(chan string, 1)
+	go o.Start(ctx, ch)
+	ch <- "1-10"
+	waitForStat(t, numOffloadsFail, "1")
+	if v := stats.Get(numOffloadsOK).String(); v != "0" {
+		t.Fatalf("expected no successful offloads, got %s", v)
+	}
+}
+
+type mockSnapshotProvider struct {
+	mu  sync.Mutex
+	ids []string
+	err error
+}
+
+func (mp *mockSnapshotProvider) OpenSnapshot(id string) (io.ReadCloser, error) {
+	if mp.err != nil {
+		return nil, mp.err
+	}
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.ids = append(mp.ids, id)
+	return ioutil.NopCloser(strings.NewReader("snapshot " + id)), nil
+}
+
+func (mp *mockSnapshotProvider) opened() []string {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	return append([]string(nil), mp.ids...)
+}
+
+// waitForStat waits for the stat with the given name to have the expected value.
+func waitForStat(t *testing.T, name, exp string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for stats.Get(name).String() != exp {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s to be %s, is %s", name, exp, stats.Get(name).String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,158 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+)
+
+// VersionFormat is the layout of the UTC timestamps naming the versions of a
+// backup. A version is stored alongside the backup, with a name formed by
+// appending a period and the version to the name of the backup.
+const VersionFormat = "20060102T150405Z"
+
+// VersionedStorageClient is a StorageClient which can keep copies of the
+// backup as versions, so that a retention policy can be applied to them.
+type VersionedStorageClient interface {
+	StorageClient
+
+	// CopyVersion copies the backup to the given version of it.
+	CopyVersion(ctx context.Context, version string) error
+
+	// ListVersions returns the versions of the backup. It may also return
+	// names which are not versions, which are ignored.
+	ListVersions(ctx context.Context) ([]string, error)
+
+	// DeleteVersion deletes the given version of the backup.
+	DeleteVersion(ctx context.Context, version string) error
+}
+
+var (
+	// ErrRetentionNotSupported is returned when a retention policy is set for
+	// a storage client which cannot keep versions of the backup.
+	ErrRetentionNotSupported = errors.New("storage client does not support retention")
+
+	// ErrInvalidRetentionPolicy is returned when a retention policy would
+	// keep no versions.
+	ErrInvalidRetentionPolicy = errors.New("retention policy must keep at least one version")
+)
+
+// RetentionPolicy determines which versions of a backup are kept. A version
+// is kept if any part of the policy keeps it, and is deleted otherwise.
+type RetentionPolicy struct {
+	// KeepLast is the number of most recent versions kept.
+	KeepLast int `json:"keep_last,omitempty"`
+
+	// KeepDaily is the number of days, including today, for which the most
+	// recent version made on each day is kept. Days are in UTC.
+	KeepDaily int `json:"keep_daily,omitempty"`
+
+	// KeepWeekly is the number of weeks, including this one, for which the
+	// most recent version made in each week is kept. Weeks start on Monday.
+	KeepWeekly int `json:"keep_weekly,omitempty"`
+}
+
+// Validate checks that the policy keeps at least one version.
+func (p *RetentionPolicy) Validate() error {
+	if p.KeepLast < 0 || p.KeepDaily < 0 || p.KeepWeekly < 0 {
+		return ErrInvalidRetentionPolicy
+	}
+	if p.KeepLast == 0 && p.KeepDaily == 0 && p.KeepWeekly == 0 {
+		return ErrInvalidRetentionPolicy
+	}
+	return nil
+}
+
+// Expired returns those of versions which the policy does not keep, as of
+// now. Names which are not versions are never returned.
+func (p *RetentionPolicy) Expired(versions []string, now time.Time) []string {
+	type version struct {
+		name string
+		t    time.Time
+	}
+	var vs []version
+	for _, v := range versions {
+		t, err := time.Parse(VersionFormat, v)
+		if err != nil {
+			continue
+		}
+		vs = append(vs, version{v, t})
+	}
+	sort.Slice(vs, func(i, j int) bool { return vs[i].t.After(vs[j].t) })
+
+	today := day(now)
+	thisWeek := week(now)
+	days := make(map[time.Time]bool)
+	weeks := make(map[time.Time]bool)
+	var expired []string
+	for i, v := range vs {
+		keep := i < p.KeepLast
+		if d := day(v.t); !days[d] && d.After(today.AddDate(0, 0, -p.KeepDaily)) {
+			days[d] = true
+			keep = true
+		}
+		if w := week(v.t); !weeks[w] && w.After(thisWeek.AddDate(0, 0, -7*p.KeepWeekly)) {
+			weeks[w] = true
+			keep = true
+		}
+		if !keep {
+			expired = append(expired, v.name)
+		}
+	}
+	return expired
+}
+
+// day returns the start of the UTC day containing t.
+func day(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// week returns the start of the UTC week, starting on Monday, containing t.
+func week(t time.Time) time.Time {
+	d := day(t)
+	return d.AddDate(0, 0, -(int(d.Weekday())+6)%7)
+}
+
+// EnableRetention enables keeping versions of the backup, which are pruned
+// according to p. Each time the backup is uploaded in full, it is copied to
+// a new version by the storage service, so no data is uploaded to create
+// versions. It must be called before Start.
+func (u *Uploader) EnableRetention(p RetentionPolicy) error {
+	vc, ok := u.storageClient.(VersionedStorageClient)
+	if !ok {
+		return ErrRetentionNotSupported
+	}
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	u.versionedClient = vc
+	u.retention = &p
+	return nil
+}
+
+// keepVersion copies the backup just uploaded to a new version, and deletes
+// any versions which the retention policy no longer keeps.
+func (u *Uploader) keepVersion(ctx context.Context) error {
+	if u.versionedClient == nil {
+		return nil
+	}
+	now := time.Now()
+	if err := u.versionedClient.CopyVersion(ctx, now.UTC().Format(VersionFormat)); err != nil {
+		return err
+	}
+	stats.Add(numVersionsCreated, 1)
+
+	versions, err := u.versionedClient.ListVersions(ctx)
+	if err != nil {
+		return err
+	}
+	for _, v := range u.retention.Expired(versions, now) {
+		if err := u.versionedClient.DeleteVersion(ctx, v); err != nil {
+			return err
+		}
+		stats.Add(numVersionsDeleted, 1)
+	}
+	return nil
+}
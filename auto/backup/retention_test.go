@@ -0,0 +1,153 @@
+package backup
+
+import (
+	"context"
+	"expvar"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_RetentionPolicyValidate(t *testing.T) {
+	for _, p := range []RetentionPolicy{{}, {KeepLast: -1, KeepDaily: 1}} {
+		if err := p.Validate(); err != ErrInvalidRetentionPolicy {
+			t.Fatalf("expected ErrInvalidRetentionPolicy for %+v, got %v", p, err)
+		}
+	}
+	for _, p := range []RetentionPolicy{{KeepLast: 1}, {KeepDaily: 1}, {KeepWeekly: 1}} {
+		if err := p.Validate(); err != nil {
+			t.Fatalf("unexpected error for %+v: %s", p, err)
+		}
+	}
+}
+
+func Test_RetentionPolicyExpired(t *testing.T) {
+	// A Wednesday.
+	now := time.Date(2024, 3, 13, 12, 0, 0, 0, time.UTC)
+	versions := []string{
+		"20240311T100000Z",
+		"20240313T110000Z",
+		"20240312T230000Z",
+		"20240313T100000Z",
+		"20240312T100000Z",
+		"20240310T100000Z",
+		"20240303T100000Z",
+		"20240225T100000Z",
+		"delta",
+	}
+
+	for _, tc := range []struct {
+		name   string
+		policy RetentionPolicy
+		exp    []string
+	}{
+		{
+			name:   "last",
+			policy: RetentionPolicy{KeepLast: 6},
+			exp:    []string{"20240303T100000Z", "20240225T100000Z"},
+		},
+		{
+			name:   "daily",
+			policy: RetentionPolicy{KeepDaily: 3},
+			exp: []string{"20240313T100000Z", "20240312T100000Z", "20240310T100000Z",
+				"20240303T100000Z", "20240225T100000Z"},
+		},
+		{
+			name:   "weekly",
+			policy: RetentionPolicy{KeepWeekly: 2},
+			exp: []string{"20240313T100000Z", "20240312T230000Z", "20240312T100000Z",
+				"20240311T100000Z", "20240303T100000Z", "20240225T100000Z"},
+		},
+		{
+			name:   "combined",
+			policy: RetentionPolicy{KeepLast: 2, KeepDaily: 3, KeepWeekly: 2},
+			exp:    []string{"20240312T100000Z", "20240303T100000Z", "20240225T100000Z"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.policy.Expired(versions, now); !reflect.DeepEqual(tc.exp, got) {
+				t.Fatalf("wrong expired versions\nexp: %v\ngot: %v", tc.exp, got)
+			}
+		})
+	}
+}
+
+func Test_UploaderRetention(t *testing.T) {
+	ResetStats()
+	if err := NewUploader(&mockStorageClient{}, &mockDataProvider{}, time.Second, UploadNoCompress).EnableRetention(RetentionPolicy{KeepLast: 1}); err != ErrRetentionNotSupported {
+		t.Fatalf("expected ErrRetentionNotSupported, got %v", err)
+	}
+
+	sc := &mockVersionedStorageClient{versions: map[string]bool{
+		"20200101T000000Z": true,
+		"20200102T000000Z": true,
+		"not-a-version":    true,
+	}}
+	dp := &mockDataProvider{data: "my upload data"}
+	uploader := NewUploader(sc, dp, time.Second, UploadNoCompress)
+	if err := uploader.EnableRetention(RetentionPolicy{}); err != ErrInvalidRetentionPolicy {
+		t.Fatalf("expected ErrInvalidRetentionPolicy, got %v", err)
+	}
+	if err := uploader.EnableRetention(RetentionPolicy{KeepLast: 2}); err != nil {
+		t.Fatalf("failed to enable retention: %s", err)
+	}
+
+	if err := uploader.upload(context.Background()); err != nil {
+		t.Fatalf("failed to upload: %s", err)
+	}
+	versions := sc.list()
+	if len(versions) != 3 || versions[0] != "20200102T000000Z" || versions[2] != "not-a-version" {
+		t.Fatalf("wrong versions after upload: %v", versions)
+	}
+	if _, err := time.Parse(VersionFormat, versions[1]); err != nil {
+		t.Fatalf("new version %s has wrong format: %s", versions[1], err)
+	}
+
+	// An upload which is skipped makes no version.
+	if err := uploader.upload(context.Background()); err != nil {
+		t.Fatalf("failed to upload: %s", err)
+	}
+	if exp, got := int64(1), stats.Get(numVersionsCreated).(*expvar.Int).Value(); exp != got {
+		t.Fatalf("expected %d versions created, got %d", exp, got)
+	}
+	if exp, got := int64(1), stats.Get(numVersionsDeleted).(*expvar.Int).Value(); exp != got {
+		t.Fatalf("expected %d versions deleted, got %d", exp, got)
+	}
+}
+
+type mockVersionedStorageClient struct {
+	mockStorageClient
+	mu       sync.Mutex
+	versions map[string]bool
+}
+
+func (mc *mockVersionedStorageClient) CopyVersion(ctx context.Context, version string) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.versions[version] = true
+	return nil
+}
+
+func (mc *mockVersionedStorageClient) ListVersions(ctx context.Context) ([]string, error) {
+	return mc.list(), nil
+}
+
+func (mc *mockVersionedStorageClient) DeleteVersion(ctx context.Context, version string) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	delete(mc.versions, version)
+	return nil
+}
+
+func (mc *mockVersionedStorageClient) list() []string {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	var versions []string
+	for v := range mc.versions {
+		versions = append(versions, v)
+	}
+	sort.Strings(versions)
+	return versions
+}
@@ -0,0 +1,240 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidSchedule is returned when a schedule's cron expression is invalid.
+var ErrInvalidSchedule = errors.New("invalid schedule")
+
+// Schedule is a schedule for uploads, set by a cron expression. The
+// expression has the five standard fields, minute, hour, day of month, month,
+// and day of week, each of which may be a wildcard, a value, a range, or a
+// comma-separated list of those, with an optional step. Months and days of
+// the week may be given by name. If both day of month and day of week are
+// restricted, a time matching either matches the schedule. The descriptors
+// @yearly, @monthly, @weekly, @daily and @hourly are also supported.
+//
+// Times are in the local time zone, unless the expression is prefixed by
+// CRON_TZ=<zone>, for example "CRON_TZ=UTC 0 3 * * *".
+type Schedule struct {
+	expr string
+	loc  *time.Location
+
+	minute, hour, dom, month, dow uint64
+
+	// domStar and dowStar are set if the day of month and day of week fields
+	// are unrestricted.
+	domStar, dowStar bool
+}
+
+// scheduleField describes a field of a cron expression.
+type scheduleField struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteField = scheduleField{name: "minute", min: 0, max: 59}
+	hourField   = scheduleField{name: "hour", min: 0, max: 23}
+	domField    = scheduleField{name: "day of month", min: 1, max: 31}
+	monthField  = scheduleField{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// Day of week 7 is also Sunday.
+	dowField = scheduleField{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+
+	scheduleDescriptors = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+)
+
+// ParseSchedule parses a cron expression. It returns an error if the
+// expression is invalid, or never matches any time.
+func ParseSchedule(expr string) (*Schedule, error) {
+	s := &Schedule{
+		expr: expr,
+		loc:  time.Local,
+	}
+
+	spec := strings.TrimSpace(expr)
+	if strings.HasPrefix(spec, "CRON_TZ=") {
+		i := strings.IndexAny(spec, " \t")
+		if i == -1 {
+			return nil, fmt.Errorf("%w %q: missing fields", ErrInvalidSchedule, expr)
+		}
+		loc, err := time.LoadLocation(spec[len("CRON_TZ="):i])
+		if err != nil {
+			return nil, fmt.Errorf("%w %q: %s", ErrInvalidSchedule, expr, err)
+		}
+		s.loc = loc
+		spec = strings.TrimSpace(spec[i:])
+	}
+	if d, ok := scheduleDescriptors[strings.ToLower(spec)]; ok {
+		spec = d
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%w %q: expected 5 fields, got %d", ErrInvalidSchedule, expr, len(fields))
+	}
+	var err error
+	if s.minute, _, err = parseScheduleField(fields[0], minuteField); err != nil {
+		return nil, fmt.Errorf("%w %q: %s", ErrInvalidSchedule, expr, err)
+	}
+	if s.hour, _, err = parseScheduleField(fields[1], hourField); err != nil {
+		return nil, fmt.Errorf("%w %q: %s", ErrInvalidSchedule, expr, err)
+	}
+	if s.dom, s.domStar, err = parseScheduleField(fields[2], domField); err != nil {
+		return nil, fmt.Errorf("%w %q: %s", ErrInvalidSchedule, expr, err)
+	}
+	if s.month, _, err = parseScheduleField(fields[3], monthField); err != nil {
+		return nil, fmt.Errorf("%w %q: %s", ErrInvalidSchedule, expr, err)
+	}
+	if s.dow, s.dowStar, err = parseScheduleField(fields[4], dowField); err != nil {
+		return nil, fmt.Errorf("%w %q: %s", ErrInvalidSchedule, expr, err)
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1 << 0
+	}
+
+	if s.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("%w %q: never matches", ErrInvalidSchedule, expr)
+	}
+	return s, nil
+}
+
+// Next returns the first time matching the schedule which is after t, or the
+// zero time if there is none within the next five years.
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.In(s.loc)
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, s.loc).Add(time.Minute)
+	yearLimit := t.Year() + 5
+
+	// Each field is advanced until it matches, starting again from the month
+	// whenever a larger field changes as a result.
+wrap:
+	if t.Year() > yearLimit {
+		return time.Time{}
+	}
+	for s.month&(1<<uint(t.Month())) == 0 {
+		t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.loc)
+		if t.Month() == time.January {
+			goto wrap
+		}
+	}
+	for !s.dayMatches(t) {
+		t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.loc)
+		if t.Day() == 1 {
+			goto wrap
+		}
+	}
+	for s.hour&(1<<uint(t.Hour())) == 0 {
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, s.loc)
+		if t.Hour() == 0 {
+			goto wrap
+		}
+	}
+	for s.minute&(1<<uint(t.Minute())) == 0 {
+		t = t.Add(time.Minute)
+		if t.Minute() == 0 {
+			goto wrap
+		}
+	}
+	return t
+}
+
+// String returns the cron expression of the schedule.
+func (s *Schedule) String() string {
+	return s.expr
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// parseScheduleField parses a field of a cron expression, returning the set
+// of values it matches, and whether it is a wildcard.
+func parseScheduleField(field string, f scheduleField) (uint64, bool, error) {
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		rng, stepStr := item, ""
+		if i := strings.Index(item, "/"); i != -1 {
+			rng, stepStr = item[:i], item[i+1:]
+		}
+
+		var lo, hi int
+		switch {
+		case rng == "*":
+			lo, hi = f.min, f.max
+		case strings.Contains(rng, "-"):
+			parts := strings.SplitN(rng, "-", 2)
+			var err error
+			if lo, err = f.value(parts[0]); err != nil {
+				return 0, false, err
+			}
+			if hi, err = f.value(parts[1]); err != nil {
+				return 0, false, err
+			}
+			if lo > hi {
+				return 0, false, fmt.Errorf("invalid %s range %q", f.name, rng)
+			}
+		default:
+			var err error
+			if lo, err = f.value(rng); err != nil {
+				return 0, false, err
+			}
+			hi = lo
+			if stepStr != "" {
+				hi = f.max
+			}
+		}
+
+		step := 1
+		if stepStr != "" {
+			var err error
+			step, err = strconv.Atoi(stepStr)
+			if err != nil || step <= 0 {
+				return 0, false, fmt.Errorf("invalid %s step %q", f.name, stepStr)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, field == "*" || field == "*/1", nil
+}
+
+// value parses a single value of the field, which may be a name.
+func (f scheduleField) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", f.name, s)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("%s %d out of range %d-%d", f.name, v, f.min, f.max)
+	}
+	return v, nil
+}
@@ -0,0 +1,82 @@
+package backup
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_ParseScheduleInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+		"* * * foo *",
+		"0 0 30 2 *",
+		"CRON_TZ=Nowhere/Nothing 0 3 * * *",
+		"CRON_TZ=UTC",
+	} {
+		if _, err := ParseSchedule(expr); err == nil {
+			t.Errorf("expected error parsing %q", expr)
+		}
+	}
+}
+
+func Test_ScheduleNext(t *testing.T) {
+	from := time.Date(2023, time.March, 15, 10, 30, 45, 0, time.UTC) // A Wednesday.
+	for _, tc := range []struct {
+		expr string
+		exp  time.Time
+	}{
+		{"* * * * *", time.Date(2023, time.March, 15, 10, 31, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2023, time.March, 16, 3, 0, 0, 0, time.UTC)},
+		{"30 10 * * *", time.Date(2023, time.March, 16, 10, 30, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2023, time.March, 15, 10, 45, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2023, time.March, 15, 13, 0, 0, 0, time.UTC)},
+		{"0,20,40 * * * *", time.Date(2023, time.March, 15, 10, 40, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2023, time.April, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * SUN", time.Date(2023, time.March, 19, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2023, time.March, 19, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * mon-fri", time.Date(2023, time.March, 16, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 jan *", time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 * *", time.Date(2023, time.March, 31, 0, 0, 0, 0, time.UTC)},
+		// Day of month or day of week, if both are restricted.
+		{"0 0 20 * 5", time.Date(2023, time.March, 17, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2023, time.March, 15, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2023, time.March, 16, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2023, time.March, 19, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2023, time.April, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)},
+	} {
+		s, err := ParseSchedule("CRON_TZ=UTC " + tc.expr)
+		if err != nil {
+			t.Fatalf("failed to parse %q: %s", tc.expr, err)
+		}
+		if got := s.Next(from); !got.Equal(tc.exp) {
+			t.Errorf("wrong next time for %q, exp %s, got %s", tc.expr, tc.exp, got)
+		}
+	}
+}
+
+func Test_ScheduleTimezone(t *testing.T) {
+	s, err := ParseSchedule("CRON_TZ=America/New_York 0 3 * * *")
+	if err != nil {
+		t.Fatalf("failed to parse schedule: %s", err)
+	}
+	from := time.Date(2023, time.July, 1, 0, 0, 0, 0, time.UTC)
+	exp := time.Date(2023, time.July, 1, 7, 0, 0, 0, time.UTC)
+	if got := s.Next(from); !got.Equal(exp) {
+		t.Fatalf("wrong next time, exp %s, got %s", exp, got)
+	}
+	if exp, got := "CRON_TZ=America/New_York 0 3 * * *", s.String(); exp != got {
+		t.Fatalf("wrong string, exp %s, got %s", exp, got)
+	}
+}
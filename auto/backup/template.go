@@ -0,0 +1,91 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Placeholders which may appear in the name to which backups are uploaded,
+// such as the S3 key, and which are expanded for each upload.
+const (
+	// PlaceholderTimestamp is expanded to the UTC time of the upload, in
+	// VersionFormat.
+	PlaceholderTimestamp = "{timestamp}"
+
+	// PlaceholderNodeID is expanded to the ID of the node uploading.
+	PlaceholderNodeID = "{node_id}"
+
+	// PlaceholderDBHash is expanded to the hex-encoded SHA256 hash of the
+	// database uploaded, before any compression or encryption.
+	PlaceholderDBHash = "{db_hash}"
+)
+
+// TemplatedStorageClient is a StorageClient which can upload to names other
+// than its own, so that its name may be a template expanded for each upload.
+type TemplatedStorageClient interface {
+	StorageClient
+
+	// Name returns the name to which the client uploads.
+	Name() string
+
+	// UploadTo uploads data to the given name.
+	UploadTo(ctx context.Context, name string, reader io.Reader) error
+}
+
+var (
+	// ErrTemplateNotSupported is returned when the name of a storage client
+	// which cannot upload to other names is a template.
+	ErrTemplateNotSupported = errors.New("storage client does not support name templates")
+
+	// ErrTemplateConflict is returned when a name template is used with
+	// incremental backups or a retention policy, both of which need every
+	// backup uploaded to the same name.
+	ErrTemplateConflict = errors.New("name templates cannot be used with incremental backups or retention")
+
+	placeholderRe = regexp.MustCompile(`\{[^{}]*\}`)
+)
+
+// IsNameTemplate returns whether name contains any placeholders.
+func IsNameTemplate(name string) bool {
+	return placeholderRe.MatchString(name)
+}
+
+// nameTemplate expands the placeholders in a name.
+type nameTemplate struct {
+	tmpl   string
+	nodeID string
+}
+
+// newNameTemplate returns a template for tmpl, checking that it contains
+// only supported placeholders.
+func newNameTemplate(tmpl, nodeID string) (*nameTemplate, error) {
+	for _, p := range placeholderRe.FindAllString(tmpl, -1) {
+		switch p {
+		case PlaceholderTimestamp, PlaceholderNodeID, PlaceholderDBHash:
+		default:
+			return nil, fmt.Errorf("unsupported placeholder %s in %q", p, tmpl)
+		}
+	}
+	return &nameTemplate{tmpl: tmpl, nodeID: nodeID}, nil
+}
+
+// expand returns the name for an upload at time t of the database with the
+// given hash.
+func (n *nameTemplate) expand(t time.Time, dbHash SHA256Sum) string {
+	return strings.NewReplacer(
+		PlaceholderTimestamp, t.UTC().Format(VersionFormat),
+		PlaceholderNodeID, n.nodeID,
+		PlaceholderDBHash, dbHash.String(),
+	).Replace(n.tmpl)
+}
+
+// usesDBHash returns whether the template contains the database hash, which
+// then needs to be calculated.
+func (n *nameTemplate) usesDBHash() bool {
+	return strings.Contains(n.tmpl, PlaceholderDBHash)
+}
@@ -0,0 +1,40 @@
+package backup
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_IsNameTemplate(t *testing.T) {
+	for name, exp := range map[string]bool{
+		"backups/db.sqlite.gz":                   false,
+		"backups/{timestamp}.sqlite.gz":          true,
+		"backups/{node_id}/{db_hash}.sqlite.gz":  true,
+		"backups/{unknown}.sqlite.gz":            true,
+		"backups/{node_id.sqlite.gz":             false,
+		"backups/node_id-timestamp-db.sqlite.gz": false,
+	} {
+		if got := IsNameTemplate(name); got != exp {
+			t.Errorf("IsNameTemplate(%q) = %t, exp %t", name, got, exp)
+		}
+	}
+}
+
+func Test_NameTemplateExpand(t *testing.T) {
+	nt, err := newNameTemplate("backups/{node_id}/{timestamp}-{db_hash}.sqlite.gz", "node1")
+	if err != nil {
+		t.Fatalf("failed to create name template: %s", err)
+	}
+	if !nt.usesDBHash() {
+		t.Fatalf("template should use database hash")
+	}
+	tm := time.Date(2023, 4, 5, 6, 7, 8, 0, time.FixedZone("X", 3600))
+	got := nt.expand(tm, SHA256Sum{0xde, 0xad, 0xbe, 0xef})
+	if exp := "backups/node1/20230405T050708Z-deadbeef.sqlite.gz"; exp != got {
+		t.Fatalf("wrong expansion, exp %s, got %s", exp, got)
+	}
+
+	if _, err := newNameTemplate("backups/{hostname}.sqlite.gz", "node1"); err == nil {
+		t.Fatalf("expected error for unsupported placeholder")
+	}
+}
@@ -3,12 +3,17 @@ package backup
 import (
 	"compress/gzip"
 	"context"
+	"errors"
 	"expvar"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/rqlite/rqlite/auto/encryption"
+	"github.com/rqlite/rqlite/auto/webhook"
 )
 
 // StorageClient is an interface for uploading data to a storage service.
@@ -17,6 +22,19 @@ type StorageClient interface {
 	fmt.Stringer
 }
 
+// DeltaStorageClient is a StorageClient which can also store the delta of
+// an incremental backup, alongside the full backup.
+type DeltaStorageClient interface {
+	StorageClient
+	UploadDelta(ctx context.Context, reader io.Reader) error
+}
+
+// Notifier is an interface for notifying something of backup events.
+type Notifier interface {
+	Notify(ctx context.Context, ev *webhook.Event) error
+	fmt.Stringer
+}
+
 // DataProvider is an interface for providing data to be uploaded. The Uploader
 // service will call Provide() to have the data-for-upload to be written to the
 // to the file specified by path.
@@ -28,14 +46,32 @@ type DataProvider interface {
 var stats *expvar.Map
 
 const (
-	numUploadsOK      = "num_uploads_ok"
-	numUploadsFail    = "num_uploads_fail"
-	numUploadsSkipped = "num_uploads_skipped"
-	totalUploadBytes  = "total_upload_bytes"
-	lastUploadBytes   = "last_upload_bytes"
+	numUploadsOK         = "num_uploads_ok"
+	numUploadsFail       = "num_uploads_fail"
+	numUploadsSkipped    = "num_uploads_skipped"
+	totalUploadBytes     = "total_upload_bytes"
+	lastUploadBytes      = "last_upload_bytes"
+	numFullUploads       = "num_full_uploads"
+	numDeltaUploads      = "num_delta_uploads"
+	lastDeltaPages       = "last_delta_pages"
+	numVersionsCreated   = "num_versions_created"
+	numVersionsDeleted   = "num_versions_deleted"
+	numVerificationsOK   = "num_verifications_ok"
+	numVerificationsFail = "num_verifications_fail"
+	numOffloadsOK        = "num_snapshot_offloads_ok"
+	numOffloadsFail      = "num_snapshot_offloads_fail"
+	totalOffloadBytes    = "total_snapshot_offload_bytes"
 
 	UploadCompress   = true
 	UploadNoCompress = false
+
+	// CompressionGzip and CompressionZstd are the codecs with which uploads
+	// may be compressed.
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+
+	// DefaultZstdLevel is the default zstd compression level.
+	DefaultZstdLevel = 3
 )
 
 func init() {
@@ -51,8 +87,22 @@ func ResetStats() {
 	stats.Add(numUploadsSkipped, 0)
 	stats.Add(totalUploadBytes, 0)
 	stats.Add(lastUploadBytes, 0)
+	stats.Add(numFullUploads, 0)
+	stats.Add(numDeltaUploads, 0)
+	stats.Add(lastDeltaPages, 0)
+	stats.Add(numVersionsCreated, 0)
+	stats.Add(numVersionsDeleted, 0)
+	stats.Add(numVerificationsOK, 0)
+	stats.Add(numVerificationsFail, 0)
+	stats.Add(numOffloadsOK, 0)
+	stats.Add(numOffloadsFail, 0)
+	stats.Add(totalOffloadBytes, 0)
 }
 
+// ErrIncrementalNotSupported is returned when incremental backups are enabled
+// for a storage client which cannot store deltas.
+var ErrIncrementalNotSupported = errors.New("storage client does not support incremental backups")
+
 // Uploader is a service that periodically uploads data to a storage service.
 type Uploader struct {
 	storageClient StorageClient
@@ -60,12 +110,55 @@ type Uploader struct {
 	interval      time.Duration
 	compress      bool
 
+	// codec and level set how uploads are compressed, if compress is set.
+	// A zero level is the codec's default.
+	codec string
+	level int
+
+	// schedule is set if uploads happen at the times it sets, rather than
+	// every interval.
+	schedule *Schedule
+
 	logger             *log.Logger
 	lastUploadTime     time.Time
 	lastUploadDuration time.Duration
+	lastUploadSize     int64
 
 	lastSum SHA256Sum
 
+	// deltaClient is set if incremental backups are enabled, in which case
+	// a full backup is uploaded every fullInterval, and a delta against the
+	// last full backup is uploaded otherwise.
+	deltaClient        DeltaStorageClient
+	fullInterval       time.Duration
+	base               *pageHashes
+	lastFullUploadTime time.Time
+
+	// versionedClient is set if a retention policy is set, in which case each
+	// full upload is also kept as a version, subject to the policy.
+	versionedClient VersionedStorageClient
+	retention       *RetentionPolicy
+
+	// encrypter is set if encryption is enabled, in which case everything
+	// uploaded is encrypted, after any compression.
+	encrypter encryption.Encrypter
+
+	// templatedClient and nameTmpl are set if the name of the storage client
+	// is a template, in which case each backup is uploaded to the name it
+	// expands to.
+	templatedClient TemplatedStorageClient
+	nameTmpl        *nameTemplate
+	lastUploadName  string
+
+	// verifyClient is set if verification is enabled, in which case each
+	// full backup is downloaded after upload, and the upload only succeeds
+	// if the download matches what was uploaded.
+	verifyClient VerifiableStorageClient
+
+	// notifier is set if notifications are enabled, in which case it is
+	// notified of each upload, and of each failed attempt to upload.
+	notifier Notifier
+
 	// disableSumCheck is used for testing purposes to disable the check that
 	// prevents uploading the same data twice.
 	disableSumCheck bool
@@ -78,35 +171,157 @@ func NewUploader(storageClient StorageClient, dataProvider DataProvider, interva
 		dataProvider:  dataProvider,
 		interval:      interval,
 		compress:      compress,
+		codec:         CompressionGzip,
 		logger:        log.New(os.Stderr, "[uploader] ", log.LstdFlags),
 	}
 }
 
+// EnableIncremental enables incremental backups. Rather than uploading the
+// entire database every interval, only the pages changed since the last full
+// backup are uploaded, as a delta stored alongside the full backup. A full
+// backup is uploaded every fullInterval, or sooner if a delta would be large.
+// It must be called before Start.
+func (u *Uploader) EnableIncremental(fullInterval time.Duration) error {
+	dc, ok := u.storageClient.(DeltaStorageClient)
+	if !ok {
+		return ErrIncrementalNotSupported
+	}
+	u.deltaClient = dc
+	u.fullInterval = fullInterval
+	return nil
+}
+
+// SetCompression sets the codec with which uploads are compressed, and the
+// compression level, which if zero is the codec's default. It has no effect
+// if compression is disabled. It must be called before Start.
+func (u *Uploader) SetCompression(codec string, level int) error {
+	switch codec {
+	case CompressionGzip:
+		if level != 0 && (level < gzip.BestSpeed || level > gzip.BestCompression) {
+			return fmt.Errorf("invalid gzip compression level %d", level)
+		}
+	case CompressionZstd:
+		if level < 0 || level > 22 {
+			return fmt.Errorf("invalid zstd compression level %d", level)
+		}
+	default:
+		return fmt.Errorf("unsupported compression codec %q", codec)
+	}
+	u.codec = codec
+	u.level = level
+	return nil
+}
+
+// EnableEncryption enables encryption of the data uploaded, with e. Both full
+// backups and deltas are encrypted. It must be called before Start.
+func (u *Uploader) EnableEncryption(e encryption.Encrypter) {
+	u.encrypter = e
+}
+
+// EnableNameTemplate uploads each backup to the name the storage client's
+// name expands to, with nodeID as the node ID, so that earlier backups are
+// not overwritten. It must be called before Start, and after any call to
+// EnableIncremental or EnableRetention, neither of which may be enabled.
+func (u *Uploader) EnableNameTemplate(nodeID string) error {
+	tc, ok := u.storageClient.(TemplatedStorageClient)
+	if !ok {
+		return ErrTemplateNotSupported
+	}
+	if u.deltaClient != nil || u.versionedClient != nil {
+		return ErrTemplateConflict
+	}
+	nt, err := newNameTemplate(tc.Name(), nodeID)
+	if err != nil {
+		return err
+	}
+	u.templatedClient = tc
+	u.nameTmpl = nt
+	return nil
+}
+
+// EnableVerification enables verification of uploads. Each full backup is
+// downloaded once uploaded, and the upload fails unless what was downloaded
+// matches what was uploaded. Deltas of incremental backups, and backups
+// uploaded to names expanded from a template, are not verified. It must be
+// called before Start.
+func (u *Uploader) EnableVerification() error {
+	vc, ok := u.storageClient.(VerifiableStorageClient)
+	if !ok {
+		return ErrVerificationNotSupported
+	}
+	u.verifyClient = vc
+	return nil
+}
+
+// EnableNotifications enables notification of n after each upload, and
+// after each failed attempt to upload. Attempts which upload nothing, since
+// nothing has changed, are not notified. It must be called before Start.
+func (u *Uploader) EnableNotifications(n Notifier) {
+	u.notifier = n
+}
+
+// EnableSchedule sets the times at which uploads happen to those set by s,
+// rather than every interval. It must be called before Start.
+func (u *Uploader) EnableSchedule(s *Schedule) {
+	u.schedule = s
+}
+
 // Start starts the Uploader service.
 func (u *Uploader) Start(ctx context.Context, isUploadEnabled func() bool) {
 	if isUploadEnabled == nil {
 		isUploadEnabled = func() bool { return true }
 	}
 
-	u.logger.Printf("starting upload to %s every %s", u.storageClient, u.interval)
-	ticker := time.NewTicker(u.interval)
-	defer ticker.Stop()
+	when := fmt.Sprintf("every %s", u.interval)
+	if u.schedule != nil {
+		when = fmt.Sprintf("on schedule %q", u.schedule)
+	}
+	if u.deltaClient != nil {
+		u.logger.Printf("starting incremental upload to %s %s, with full upload every %s",
+			u.storageClient, when, u.fullInterval)
+	} else {
+		u.logger.Printf("starting upload to %s %s", u.storageClient, when)
+	}
+	var ticker *time.Ticker
+	if u.schedule == nil {
+		ticker = time.NewTicker(u.interval)
+		defer ticker.Stop()
+	}
 
 	for {
+		var tickCh <-chan time.Time
+		var timer *time.Timer
+		if ticker != nil {
+			tickCh = ticker.C
+		} else {
+			timer = time.NewTimer(time.Until(u.schedule.Next(time.Now())))
+			tickCh = timer.C
+		}
+
 		select {
 		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
 			u.logger.Println("upload service shutting down")
 			return
-		case <-ticker.C:
+		case <-tickCh:
 			if !isUploadEnabled() {
 				// Reset the lastSum so that the next time we're enabled upload will
 				// happen. We do this to be conservative, as we don't know what was
 				// happening while upload was disabled.
 				u.lastSum = nil
+				u.base = nil
 				continue
 			}
-			if err := u.upload(ctx); err != nil {
+			startT := time.Now()
+			prevUploadTime := u.lastUploadTime
+			err := u.upload(ctx)
+			if err != nil {
 				u.logger.Printf("failed to upload to %s: %v", u.storageClient, err)
+				u.notify(ctx, webhook.NewEvent(webhook.EventBackupFailed, time.Since(startT), 0, err))
+			} else if u.lastUploadTime != prevUploadTime {
+				u.notify(ctx, webhook.NewEvent(webhook.EventBackupSucceeded, time.Since(startT), u.lastUploadSize, nil))
 			}
 		}
 	}
@@ -118,9 +333,28 @@ func (u *Uploader) Stats() (map[string]interface{}, error) {
 		"upload_destination":   u.storageClient.String(),
 		"upload_interval":      u.interval.String(),
 		"compress":             u.compress,
+		"compression":          u.codec,
 		"last_upload_time":     u.lastUploadTime.Format(time.RFC3339),
 		"last_upload_duration": u.lastUploadDuration.String(),
 		"last_upload_sum":      u.lastSum.String(),
+		"incremental":          u.deltaClient != nil,
+		"encrypted":            u.encrypter != nil,
+		"verified":             u.verifyClient != nil,
+	}
+	if u.schedule != nil {
+		delete(status, "upload_interval")
+		status["upload_schedule"] = u.schedule.String()
+		status["next_upload_time"] = u.schedule.Next(time.Now()).Format(time.RFC3339)
+	}
+	if u.deltaClient != nil {
+		status["full_upload_interval"] = u.fullInterval.String()
+		status["last_full_upload_time"] = u.lastFullUploadTime.Format(time.RFC3339)
+	}
+	if u.retention != nil {
+		status["retention"] = u.retention
+	}
+	if u.nameTmpl != nil {
+		status["last_upload_name"] = u.lastUploadName
 	}
 	return status, nil
 }
@@ -136,39 +370,169 @@ func (u *Uploader) upload(ctx context.Context) error {
 	if err := u.dataProvider.Provide(filetoUpload); err != nil {
 		return err
 	}
-	if err := u.compressIfNeeded(filetoUpload); err != nil {
+	if u.deltaClient != nil {
+		return u.uploadIncremental(ctx, filetoUpload)
+	}
+	uploadFn := u.storageClient.Upload
+	if u.nameTmpl != nil {
+		uploadFn, err = u.templatedUploadFn(filetoUpload)
+		if err != nil {
+			return err
+		}
+	}
+	uploaded, err := u.uploadFile(ctx, filetoUpload, uploadFn, u.nameTmpl == nil)
+	if err != nil || !uploaded {
 		return err
 	}
+	return u.keepVersion(ctx)
+}
 
-	sum, err := FileSHA256(filetoUpload)
+// templatedUploadFn returns a function uploading the database at path to the
+// name the template expands to. The hash of the database is calculated now,
+// before the file is compressed or encrypted.
+func (u *Uploader) templatedUploadFn(path string) (func(context.Context, io.Reader) error, error) {
+	var dbHash SHA256Sum
+	if u.nameTmpl.usesDBHash() {
+		var err error
+		if dbHash, err = FileSHA256(path); err != nil {
+			return nil, err
+		}
+	}
+	return func(ctx context.Context, reader io.Reader) error {
+		name := u.nameTmpl.expand(time.Now(), dbHash)
+		if err := u.templatedClient.UploadTo(ctx, name, reader); err != nil {
+			return err
+		}
+		u.lastUploadName = name
+		return nil
+	}, nil
+}
+
+// uploadIncremental uploads either a full backup of the database at path, or
+// the delta between it and the last full backup.
+func (u *Uploader) uploadIncremental(ctx context.Context, path string) error {
+	ph, err := hashPages(path)
 	if err != nil {
 		return err
 	}
-	if !u.disableSumCheck && sum.Equals(u.lastSum) {
+	if u.base == nil || ph.pageSize != u.base.pageSize || time.Since(u.lastFullUploadTime) >= u.fullInterval {
+		return u.uploadBase(ctx, path, ph)
+	}
+	if ph.sum.Equals(u.base.sum) {
+		// Nothing has changed since the last full backup.
 		stats.Add(numUploadsSkipped, 1)
 		return nil
 	}
 
-	fd, err := os.Open(filetoUpload)
+	deltaPath, err := tempFilename()
+	if err != nil {
+		return err
+	}
+	defer os.Remove(deltaPath)
+	fd, err := os.Create(deltaPath)
+	if err != nil {
+		return err
+	}
+	changed, count, err := writeDelta(fd, path, u.base)
+	if err != nil {
+		fd.Close()
+		return err
+	}
+	if err := fd.Close(); err != nil {
+		return err
+	}
+	if changed*2 > count {
+		// A delta this large saves little, so re-base instead.
+		return u.uploadBase(ctx, path, ph)
+	}
+
+	uploaded, err := u.uploadFile(ctx, deltaPath, u.deltaClient.UploadDelta, false)
+	if uploaded {
+		stats.Add(numDeltaUploads, 1)
+		stats.Get(lastDeltaPages).(*expvar.Int).Set(int64(changed))
+	}
+	return err
+}
+
+// uploadBase uploads the database at path, whose page hashes are ph, as the
+// full backup against which later deltas are made.
+func (u *Uploader) uploadBase(ctx context.Context, path string, ph *pageHashes) error {
+	uploaded, err := u.uploadFile(ctx, path, u.storageClient.Upload, true)
 	if err != nil {
 		return err
 	}
+	if uploaded {
+		stats.Add(numFullUploads, 1)
+	}
+	u.base = ph
+	u.lastFullUploadTime = time.Now()
+	if !uploaded {
+		return nil
+	}
+	return u.keepVersion(ctx)
+}
+
+// uploadFile uploads the file at path using uploadFn, compressing and
+// encrypting it first if needed. It returns whether the file was uploaded,
+// which it is not if it is the same as the last file uploaded. Since data is
+// encrypted differently each time, that is decided before encryption. If
+// verify is set, and verification is enabled, the upload is also verified.
+func (u *Uploader) uploadFile(ctx context.Context, path string, uploadFn func(context.Context, io.Reader) error,
+	verify bool) (bool, error) {
+	if err := u.compressIfNeeded(path); err != nil {
+		return false, err
+	}
+
+	sum, err := FileSHA256(path)
+	if err != nil {
+		return false, err
+	}
+	if !u.disableSumCheck && sum.Equals(u.lastSum) {
+		stats.Add(numUploadsSkipped, 1)
+		return false, nil
+	}
+
+	if err := u.encryptIfNeeded(path); err != nil {
+		return false, err
+	}
+
+	fd, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
 	defer fd.Close()
 
 	cr := &countingReader{reader: fd}
 	startTime := time.Now()
-	err = u.storageClient.Upload(ctx, cr)
+	err = uploadFn(ctx, cr)
 	if err != nil {
 		stats.Add(numUploadsFail, 1)
-	} else {
-		u.lastSum = sum
-		stats.Add(numUploadsOK, 1)
-		stats.Add(totalUploadBytes, cr.count)
-		stats.Get(lastUploadBytes).(*expvar.Int).Set(cr.count)
-		u.lastUploadTime = time.Now()
-		u.lastUploadDuration = time.Since(startTime)
+		return false, err
+	}
+	if verify && u.verifyClient != nil {
+		if err := u.verifyUpload(ctx, path); err != nil {
+			stats.Add(numUploadsFail, 1)
+			return false, err
+		}
+	}
+	u.lastSum = sum
+	stats.Add(numUploadsOK, 1)
+	stats.Add(totalUploadBytes, cr.count)
+	stats.Get(lastUploadBytes).(*expvar.Int).Set(cr.count)
+	u.lastUploadSize = cr.count
+	u.lastUploadTime = time.Now()
+	u.lastUploadDuration = time.Since(startTime)
+	return true, nil
+}
+
+// notify notifies the notifier, if any, of ev.
+func (u *Uploader) notify(ctx context.Context, ev *webhook.Event) {
+	if u.notifier == nil {
+		return
+	}
+	if err := u.notifier.Notify(ctx, ev); err != nil {
+		u.logger.Printf("failed to notify %s of %s: %v", u.notifier, ev.Type, err)
 	}
-	return err
 }
 
 func (u *Uploader) compressIfNeeded(path string) error {
@@ -182,14 +546,58 @@ func (u *Uploader) compressIfNeeded(path string) error {
 	}
 	defer os.Remove(compressedFile)
 
-	if err = compressFromTo(path, compressedFile); err != nil {
+	if err = compressFromTo(path, compressedFile, u.codec, u.level); err != nil {
 		return err
 	}
 
 	return os.Rename(compressedFile, path)
 }
 
-func compressFromTo(from, to string) error {
+func (u *Uploader) encryptIfNeeded(path string) error {
+	if u.encrypter == nil {
+		return nil
+	}
+
+	encryptedFile, err := tempFilename()
+	if err != nil {
+		return err
+	}
+	defer os.Remove(encryptedFile)
+
+	if err = encryptFromTo(u.encrypter, path, encryptedFile); err != nil {
+		return err
+	}
+
+	return os.Rename(encryptedFile, path)
+}
+
+func encryptFromTo(e encryption.Encrypter, from, to string) error {
+	plainFd, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer plainFd.Close()
+
+	encryptedFd, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	defer encryptedFd.Close()
+
+	ew, err := e.Encrypt(encryptedFd)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(ew, plainFd); err != nil {
+		return err
+	}
+	if err := ew.Close(); err != nil {
+		return err
+	}
+	return encryptedFd.Close()
+}
+
+func compressFromTo(from, to, codec string, level int) error {
 	uncompressedFd, err := os.Open(from)
 	if err != nil {
 		return err
@@ -202,12 +610,27 @@ func compressFromTo(from, to string) error {
 	}
 	defer compressedFd.Close()
 
-	gw := gzip.NewWriter(compressedFd)
-	_, err = io.Copy(gw, uncompressedFd)
+	var cw io.WriteCloser
+	switch codec {
+	case CompressionZstd:
+		if level == 0 {
+			level = DefaultZstdLevel
+		}
+		cw, err = zstd.NewWriter(compressedFd, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	default:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		cw, err = gzip.NewWriterLevel(compressedFd, level)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(cw, uncompressedFd)
 	if err != nil {
 		return err
 	}
-	err = gw.Close()
+	err = cw.Close()
 	if err != nil {
 		return err
 	}
@@ -1,16 +1,26 @@
 package backup
 
 import (
+	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"errors"
 	"expvar"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/rqlite/rqlite/auto/encryption"
+	"github.com/rqlite/rqlite/auto/webhook"
+	"github.com/rqlite/rqlite/db"
 )
 
 func Test_NewUploader(t *testing.T) {
@@ -92,6 +102,119 @@ func Test_UploaderSingleUploadCompress(t *testing.T) {
 	}
 }
 
+func Test_UploaderSingleUploadZstd(t *testing.T) {
+	ResetStats()
+	var uploadedData []byte
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	sc := &mockStorageClient{
+		uploadFn: func(ctx context.Context, reader io.Reader) error {
+			defer wg.Done()
+			zr, err := zstd.NewReader(reader)
+			if err != nil {
+				return err
+			}
+			defer zr.Close()
+
+			uploadedData, err = io.ReadAll(zr)
+			return err
+		},
+	}
+	dp := &mockDataProvider{data: "my upload data"}
+	uploader := NewUploader(sc, dp, 100*time.Millisecond, UploadCompress)
+	if err := uploader.SetCompression(CompressionZstd, 0); err != nil {
+		t.Fatalf("failed to set compression: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go uploader.Start(ctx, nil)
+	wg.Wait()
+	cancel()
+	<-ctx.Done()
+
+	if exp, got := "my upload data", string(uploadedData); exp != got {
+		t.Errorf("expected uploadedData to be %s, got %s", exp, got)
+	}
+}
+
+func Test_UploaderSetCompression(t *testing.T) {
+	uploader := NewUploader(&mockStorageClient{}, &mockDataProvider{}, time.Second, UploadCompress)
+	for _, tc := range []struct {
+		codec string
+		level int
+		ok    bool
+	}{
+		{CompressionGzip, 0, true},
+		{CompressionGzip, 9, true},
+		{CompressionGzip, 10, false},
+		{CompressionZstd, 19, true},
+		{CompressionZstd, 23, false},
+		{CompressionZstd, -1, false},
+		{"lz4", 0, false},
+	} {
+		err := uploader.SetCompression(tc.codec, tc.level)
+		if tc.ok && err != nil {
+			t.Fatalf("unexpected error for %s level %d: %s", tc.codec, tc.level, err)
+		}
+		if !tc.ok && err == nil {
+			t.Fatalf("expected error for %s level %d", tc.codec, tc.level)
+		}
+	}
+}
+
+func Test_UploaderEncrypt(t *testing.T) {
+	ResetStats()
+	key := bytes.Repeat([]byte{0x42}, encryption.KeySize)
+	enc, err := encryption.NewAESGCM(key)
+	if err != nil {
+		t.Fatalf("failed to create encrypter: %s", err)
+	}
+
+	var uploads [][]byte
+	sc := &mockStorageClient{
+		uploadFn: func(ctx context.Context, reader io.Reader) error {
+			b, err := io.ReadAll(reader)
+			uploads = append(uploads, b)
+			return err
+		},
+	}
+	dp := &mockDataProvider{data: "my upload data"}
+	uploader := NewUploader(sc, dp, time.Second, UploadCompress)
+	uploader.EnableEncryption(enc)
+
+	// The same data is uploaded only once, though it is encrypted
+	// differently each time.
+	for i := 0; i < 2; i++ {
+		if err := uploader.upload(context.Background()); err != nil {
+			t.Fatalf("failed to upload: %s", err)
+		}
+	}
+	if len(uploads) != 1 {
+		t.Fatalf("expected 1 upload, got %d", len(uploads))
+	}
+	if bytes.Contains(uploads[0], []byte("my upload data")) {
+		t.Fatalf("uploaded data is not encrypted")
+	}
+
+	dr, err := enc.Decrypt(bytes.NewReader(uploads[0]))
+	if err != nil {
+		t.Fatalf("failed to decrypt: %s", err)
+	}
+	gzReader, err := gzip.NewReader(dr)
+	if err != nil {
+		t.Fatalf("failed to decompress: %s", err)
+	}
+	defer gzReader.Close()
+	b, err := io.ReadAll(gzReader)
+	if err != nil {
+		t.Fatalf("failed to read uploaded data: %s", err)
+	}
+	if exp, got := "my upload data", string(b); exp != got {
+		t.Errorf("expected uploadedData to be %s, got %s", exp, got)
+	}
+}
+
 func Test_UploaderDoubleUpload(t *testing.T) {
 	ResetStats()
 
@@ -281,6 +404,317 @@ func Test_UploaderStats(t *testing.T) {
 	}
 }
 
+func Test_UploaderStatsSchedule(t *testing.T) {
+	s, err := ParseSchedule("0 3 * * *")
+	if err != nil {
+		t.Fatalf("failed to parse schedule: %s", err)
+	}
+	uploader := NewUploader(&mockStorageClient{}, &mockDataProvider{}, 0, UploadNoCompress)
+	uploader.EnableSchedule(s)
+
+	stats, err := uploader.Stats()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp, got := "0 3 * * *", stats["upload_schedule"]; exp != got {
+		t.Errorf("expected upload_schedule to be %s, got %s", exp, got)
+	}
+	if exp, got := s.Next(time.Now()).Format(time.RFC3339), stats["next_upload_time"]; exp != got {
+		t.Errorf("expected next_upload_time to be %s, got %s", exp, got)
+	}
+}
+
+func Test_UploaderNameTemplate(t *testing.T) {
+	ResetStats()
+	var names []string
+	var mu sync.Mutex
+	sc := &mockTemplatedStorageClient{
+		name: "backups/{node_id}/{db_hash}-{timestamp}.sqlite",
+		uploadToFn: func(ctx context.Context, name string, reader io.Reader) error {
+			mu.Lock()
+			defer mu.Unlock()
+			names = append(names, name)
+			return nil
+		},
+	}
+	sc.uploadFn = func(ctx context.Context, reader io.Reader) error {
+		t.Errorf("upload to client's own name")
+		return nil
+	}
+	dp := &mockDataProvider{data: "my upload data"}
+	uploader := NewUploader(sc, dp, time.Second, UploadCompress)
+	if err := uploader.EnableNameTemplate("node1"); err != nil {
+		t.Fatalf("failed to enable name template: %s", err)
+	}
+	uploader.disableSumCheck = true
+
+	ctx := context.Background()
+	if err := uploader.upload(ctx); err != nil {
+		t.Fatalf("failed to upload: %s", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(names) != 1 {
+		t.Fatalf("expected 1 upload, got %d", len(names))
+	}
+
+	// The hash is of the database before it is compressed.
+	sum := sha256.Sum256([]byte("my upload data"))
+	prefix := fmt.Sprintf("backups/node1/%x-", sum)
+	if !strings.HasPrefix(names[0], prefix) || !strings.HasSuffix(names[0], ".sqlite") {
+		t.Fatalf("wrong name uploaded to, exp prefix %s, got %s", prefix, names[0])
+	}
+	ts := strings.TrimSuffix(strings.TrimPrefix(names[0], prefix), ".sqlite")
+	if _, err := time.Parse(VersionFormat, ts); err != nil {
+		t.Fatalf("name has invalid timestamp %s: %s", ts, err)
+	}
+
+	st, err := uploader.Stats()
+	if err != nil {
+		t.Fatalf("failed to get stats: %s", err)
+	}
+	if exp, got := names[0], st["last_upload_name"]; exp != got {
+		t.Fatalf("wrong last upload name, exp %s, got %v", exp, got)
+	}
+}
+
+func Test_UploaderNameTemplateInvalid(t *testing.T) {
+	dp := &mockDataProvider{data: "my upload data"}
+	if err := NewUploader(&mockStorageClient{}, dp, time.Second, UploadCompress).EnableNameTemplate("node1"); err != ErrTemplateNotSupported {
+		t.Fatalf("expected ErrTemplateNotSupported, got %v", err)
+	}
+
+	sc := &mockTemplatedStorageClient{name: "backups/{hostname}.sqlite"}
+	if err := NewUploader(sc, dp, time.Second, UploadCompress).EnableNameTemplate("node1"); err == nil {
+		t.Fatalf("expected error for unsupported placeholder")
+	}
+}
+
+func Test_UploaderVerify(t *testing.T) {
+	ResetStats()
+	var stored []byte
+	corrupt := true
+	sc := &mockVerifiableStorageClient{
+		mockStorageClient: mockStorageClient{
+			uploadFn: func(ctx context.Context, reader io.Reader) error {
+				var err error
+				stored, err = io.ReadAll(reader)
+				return err
+			},
+		},
+		downloadFn: func(ctx context.Context, writer io.WriterAt) error {
+			b := stored
+			if corrupt {
+				b = []byte("corrupted data")
+			}
+			_, err := writer.WriteAt(b, 0)
+			return err
+		},
+	}
+	dp := &mockDataProvider{data: "my upload data"}
+	uploader := NewUploader(sc, dp, time.Second, UploadCompress)
+	if err := uploader.EnableVerification(); err != nil {
+		t.Fatalf("failed to enable verification: %s", err)
+	}
+
+	err := uploader.upload(context.Background())
+	if !errors.Is(err, ErrVerificationFailed) {
+		t.Fatalf("expected ErrVerificationFailed, got %v", err)
+	}
+	if exp, got := int64(1), stats.Get(numVerificationsFail).(*expvar.Int).Value(); exp != got {
+		t.Fatalf("expected %d failed verifications, got %d", exp, got)
+	}
+	if exp, got := int64(1), stats.Get(numUploadsFail).(*expvar.Int).Value(); exp != got {
+		t.Fatalf("expected %d failed uploads, got %d", exp, got)
+	}
+
+	// A failed upload must be retried, even though the data is unchanged.
+	corrupt = false
+	if err := uploader.upload(context.Background()); err != nil {
+		t.Fatalf("failed to upload: %s", err)
+	}
+	if exp, got := int64(1), stats.Get(numVerificationsOK).(*expvar.Int).Value(); exp != got {
+		t.Fatalf("expected %d successful verifications, got %d", exp, got)
+	}
+	if exp, got := int64(1), stats.Get(numUploadsOK).(*expvar.Int).Value(); exp != got {
+		t.Fatalf("expected %d successful uploads, got %d", exp, got)
+	}
+}
+
+func Test_UploaderVerifyNotSupported(t *testing.T) {
+	dp := &mockDataProvider{data: "my upload data"}
+	if err := NewUploader(&mockStorageClient{}, dp, time.Second, UploadCompress).EnableVerification(); err != ErrVerificationNotSupported {
+		t.Fatalf("expected ErrVerificationNotSupported, got %v", err)
+	}
+}
+
+func Test_UploaderNotify(t *testing.T) {
+	ResetStats()
+	fail := true
+	sc := &mockStorageClient{
+		uploadFn: func(ctx context.Context, reader io.Reader) error {
+			if fail {
+				fail = false
+				return errors.New("upload failed")
+			}
+			_, err := io.ReadAll(reader)
+			return err
+		},
+	}
+	dp := &mockDataProvider{data: "my upload data"}
+	uploader := NewUploader(sc, dp, 100*time.Millisecond, UploadNoCompress)
+	n := &mockNotifier{ch: make(chan *webhook.Event, 10)}
+	uploader.EnableNotifications(n)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go uploader.Start(ctx, nil)
+
+	ev := n.next(t)
+	if ev.Type != webhook.EventBackupFailed {
+		t.Fatalf("expected event %s, got %s", webhook.EventBackupFailed, ev.Type)
+	}
+	if ev.Error != "upload failed" {
+		t.Fatalf("expected error 'upload failed', got %s", ev.Error)
+	}
+	ev = n.next(t)
+	if ev.Type != webhook.EventBackupSucceeded {
+		t.Fatalf("expected event %s, got %s", webhook.EventBackupSucceeded, ev.Type)
+	}
+	if exp, got := int64(len("my upload data")), ev.Bytes; exp != got {
+		t.Fatalf("expected %d bytes, got %d", exp, got)
+	}
+
+	// Unchanged data is not uploaded, so nothing is notified.
+	select {
+	case ev := <-n.ch:
+		t.Fatalf("unexpected event %s", ev.Type)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func Test_UploaderIncremental(t *testing.T) {
+	ResetStats()
+	if err := NewUploader(&mockStorageClient{}, &mockDataProvider{}, time.Second, UploadNoCompress).EnableIncremental(time.Hour); err != ErrIncrementalNotSupported {
+		t.Fatalf("expected ErrIncrementalNotSupported, got %v", err)
+	}
+
+	dir := t.TempDir()
+	d, err := db.Open(filepath.Join(dir, "db.sqlite"), false, true)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err)
+	}
+	defer d.Close()
+	mustExecute(t, d, "CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT)")
+	for i := 0; i < 500; i++ {
+		mustExecute(t, d, fmt.Sprintf(`INSERT INTO foo(name) VALUES("%s")`, strings.Repeat("x", 100)))
+	}
+
+	var full, delta []byte
+	sc := &mockDeltaStorageClient{
+		mockStorageClient: mockStorageClient{
+			uploadFn: func(ctx context.Context, reader io.Reader) (err error) {
+				full, err = io.ReadAll(reader)
+				return err
+			},
+		},
+		uploadDeltaFn: func(ctx context.Context, reader io.Reader) (err error) {
+			delta, err = io.ReadAll(reader)
+			return err
+		},
+	}
+	dp := &mockDBProvider{db: d}
+	uploader := NewUploader(sc, dp, time.Second, UploadCompress)
+	if err := uploader.EnableIncremental(time.Hour); err != nil {
+		t.Fatalf("failed to enable incremental uploads: %s", err)
+	}
+
+	// The first upload is a full one.
+	if err := uploader.upload(context.Background()); err != nil {
+		t.Fatalf("failed to upload: %s", err)
+	}
+	if full == nil || delta != nil {
+		t.Fatalf("expected full upload only")
+	}
+	restorePath := filepath.Join(dir, "restore.sqlite")
+	gr, err := gzip.NewReader(bytes.NewReader(full))
+	if err != nil {
+		t.Fatalf("failed to decompress full upload: %s", err)
+	}
+	b, err := io.ReadAll(gr)
+	if err != nil {
+		t.Fatalf("failed to decompress full upload: %s", err)
+	}
+	if err := os.WriteFile(restorePath, b, 0644); err != nil {
+		t.Fatalf("failed to write full upload: %s", err)
+	}
+
+	// With nothing changed, nothing is uploaded.
+	if err := uploader.upload(context.Background()); err != nil {
+		t.Fatalf("failed to upload: %s", err)
+	}
+	if exp, got := int64(1), stats.Get(numUploadsSkipped).(*expvar.Int).Value(); exp != got {
+		t.Fatalf("expected %d skipped uploads, got %d", exp, got)
+	}
+
+	// Later uploads are deltas, which restore the database when applied to
+	// the full upload.
+	mustExecute(t, d, `UPDATE foo SET name="y" WHERE id=1`)
+	if err := uploader.upload(context.Background()); err != nil {
+		t.Fatalf("failed to upload: %s", err)
+	}
+	if delta == nil {
+		t.Fatalf("expected delta upload")
+	}
+	if len(delta) >= len(full) {
+		t.Fatalf("delta upload of %d bytes not smaller than full upload of %d bytes", len(delta), len(full))
+	}
+	if err := ApplyDelta(restorePath, bytes.NewReader(delta)); err != nil {
+		t.Fatalf("failed to apply delta: %s", err)
+	}
+	if err := d.Backup(filepath.Join(dir, "exp.sqlite")); err != nil {
+		t.Fatalf("failed to back up database: %s", err)
+	}
+	if !bytes.Equal(mustReadFile(t, filepath.Join(dir, "exp.sqlite")), mustReadFile(t, restorePath)) {
+		t.Fatalf("restored database differs from database")
+	}
+	if exp, got := int64(1), stats.Get(numFullUploads).(*expvar.Int).Value(); exp != got {
+		t.Fatalf("expected %d full uploads, got %d", exp, got)
+	}
+	if exp, got := int64(1), stats.Get(numDeltaUploads).(*expvar.Int).Value(); exp != got {
+		t.Fatalf("expected %d delta uploads, got %d", exp, got)
+	}
+
+	// Once the full interval has passed, the next upload is a full one.
+	uploader.lastFullUploadTime = time.Now().Add(-2 * time.Hour)
+	mustExecute(t, d, `UPDATE foo SET name="z" WHERE id=1`)
+	if err := uploader.upload(context.Background()); err != nil {
+		t.Fatalf("failed to upload: %s", err)
+	}
+	if exp, got := int64(2), stats.Get(numFullUploads).(*expvar.Int).Value(); exp != got {
+		t.Fatalf("expected %d full uploads, got %d", exp, got)
+	}
+}
+
+type mockDeltaStorageClient struct {
+	mockStorageClient
+	uploadDeltaFn func(ctx context.Context, reader io.Reader) error
+}
+
+func (mc *mockDeltaStorageClient) UploadDelta(ctx context.Context, reader io.Reader) error {
+	if mc.uploadDeltaFn != nil {
+		return mc.uploadDeltaFn(ctx, reader)
+	}
+	return nil
+}
+
+type mockDBProvider struct {
+	db *db.DB
+}
+
+func (mp *mockDBProvider) Provide(path string) error {
+	return mp.db.Backup(path)
+}
+
 type mockStorageClient struct {
 	uploadFn func(ctx context.Context, reader io.Reader) error
 }
@@ -296,6 +730,23 @@ func (mc *mockStorageClient) String() string {
 	return "mockStorageClient"
 }
 
+type mockTemplatedStorageClient struct {
+	mockStorageClient
+	name       string
+	uploadToFn func(ctx context.Context, name string, reader io.Reader) error
+}
+
+func (mc *mockTemplatedStorageClient) Name() string {
+	return mc.name
+}
+
+func (mc *mockTemplatedStorageClient) UploadTo(ctx context.Context, name string, reader io.Reader) error {
+	if mc.uploadToFn != nil {
+		return mc.uploadToFn(ctx, name, reader)
+	}
+	return nil
+}
+
 type mockDataProvider struct {
 	data string
 	err  error
@@ -307,3 +758,39 @@ func (mp *mockDataProvider) Provide(path string) error {
 	}
 	return os.WriteFile(path, []byte(mp.data), 0644)
 }
+
+type mockNotifier struct {
+	ch chan *webhook.Event
+}
+
+func (mn *mockNotifier) Notify(ctx context.Context, ev *webhook.Event) error {
+	mn.ch <- ev
+	return nil
+}
+
+func (mn *mockNotifier) String() string {
+	return "mockNotifier"
+}
+
+func (mn *mockNotifier) next(t *testing.T) *webhook.Event {
+	t.Helper()
+	select {
+	case ev := <-mn.ch:
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for event")
+	}
+	return nil
+}
+
+type mockVerifiableStorageClient struct {
+	mockStorageClient
+	downloadFn func(ctx context.Context, writer io.WriterAt) error
+}
+
+func (mc *mockVerifiableStorageClient) Download(ctx context.Context, writer io.WriterAt) error {
+	if mc.downloadFn != nil {
+		return mc.downloadFn(ctx, writer)
+	}
+	return nil
+}
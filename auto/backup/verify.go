@@ -0,0 +1,69 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// VerifiableStorageClient is a StorageClient which can also download what it
+// uploaded, so that uploads can be verified.
+type VerifiableStorageClient interface {
+	StorageClient
+	Download(ctx context.Context, writer io.WriterAt) error
+}
+
+var (
+	// ErrVerificationNotSupported is returned when verification is enabled
+	// for a storage client which cannot download what it uploaded.
+	ErrVerificationNotSupported = errors.New("storage client does not support verification")
+
+	// ErrVerificationFailed is returned when the data downloaded after an
+	// upload differs from the data uploaded.
+	ErrVerificationFailed = errors.New("verification of upload failed")
+)
+
+// verifyUpload downloads what was just uploaded, and checks that it is the
+// same as the file at path, which is exactly what was uploaded.
+func (u *Uploader) verifyUpload(ctx context.Context, path string) (retErr error) {
+	defer func() {
+		if retErr != nil {
+			stats.Add(numVerificationsFail, 1)
+		} else {
+			stats.Add(numVerificationsOK, 1)
+		}
+	}()
+
+	exp, err := FileSHA256(path)
+	if err != nil {
+		return err
+	}
+
+	downloadPath, err := tempFilename()
+	if err != nil {
+		return err
+	}
+	defer os.Remove(downloadPath)
+	fd, err := os.Create(downloadPath)
+	if err != nil {
+		return err
+	}
+	if err := u.verifyClient.Download(ctx, fd); err != nil {
+		fd.Close()
+		return fmt.Errorf("%w: failed to download: %s", ErrVerificationFailed, err)
+	}
+	if err := fd.Close(); err != nil {
+		return err
+	}
+
+	got, err := FileSHA256(downloadPath)
+	if err != nil {
+		return err
+	}
+	if !got.Equals(exp) {
+		return fmt.Errorf("%w: downloaded data has SHA256 %s, expected %s", ErrVerificationFailed, got, exp)
+	}
+	return nil
+}
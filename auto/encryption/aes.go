@@ -0,0 +1,83 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+)
+
+// Data encrypted with AES-256-GCM starts with a header of the 8-byte magic
+// "RQAESGC1" and a random 32-byte salt. From the key and the salt a key is
+// derived for the data, so that each encryption uses a different key, and
+// nonces are never reused. The chunks of the data follow the header.
+
+var aesGCMMagic = []byte("RQAESGC1")
+
+const (
+	aesGCMSaltSize = 32
+	aesGCMInfo     = "rqlite-aes-256-gcm-payload"
+)
+
+// AESGCM encrypts and decrypts data with AES-256-GCM.
+type AESGCM struct {
+	key []byte
+}
+
+// NewAESGCM returns an AESGCM using key, which must be KeySize bytes.
+func NewAESGCM(key []byte) (*AESGCM, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("%w: key must be %d bytes", ErrInvalidConfig, KeySize)
+	}
+	return &AESGCM{key: key}, nil
+}
+
+// Encrypt implements Encrypter.
+func (a *AESGCM) Encrypt(w io.Writer) (io.WriteCloser, error) {
+	salt := make([]byte, aesGCMSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := a.aead(salt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(append(append([]byte(nil), aesGCMMagic...), salt...)); err != nil {
+		return nil, err
+	}
+	return newStreamWriter(aead, w), nil
+}
+
+// Decrypt implements Decrypter.
+func (a *AESGCM) Decrypt(r io.Reader) (io.Reader, error) {
+	ok, err := hasPrefix(r, aesGCMMagic)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: data is not encrypted with AES-256-GCM", ErrDecrypt)
+	}
+	salt := make([]byte, aesGCMSaltSize)
+	if _, err := io.ReadFull(r, salt); err != nil {
+		return nil, fmt.Errorf("%w: header is truncated", ErrDecrypt)
+	}
+	aead, err := a.aead(salt)
+	if err != nil {
+		return nil, err
+	}
+	return newStreamReader(aead, r), nil
+}
+
+// String returns the type of encryption.
+func (a *AESGCM) String() string {
+	return TypeAES256GCM
+}
+
+func (a *AESGCM) aead(salt []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(deriveKey(a.key, salt, aesGCMInfo, KeySize))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
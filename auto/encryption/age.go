@@ -0,0 +1,348 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+)
+
+// The age format is specified at https://age-encryption.org/v1. Data starts
+// with a textual header, holding a random 16-byte file key wrapped for each
+// recipient in a "stanza", and a MAC of the header made with the file key.
+// A random 16-byte nonce follows, from which with the file key a key for the
+// data is derived, and then the chunks of the data, sealed with
+// ChaCha20-Poly1305. Only X25519 recipients are supported.
+
+const (
+	ageVersionLine  = "age-encryption.org/v1"
+	ageX25519Label  = "age-encryption.org/v1/X25519"
+	ageStanzaPrefix = "-> "
+	ageMACPrefix    = "---"
+	ageFileKeySize  = 16
+	ageNonceSize    = 16
+	ageColumns      = 64
+	ageMaxLine      = 4096
+
+	ageRecipientHRP = "age"
+	ageIdentityHRP  = "AGE-SECRET-KEY-"
+)
+
+var ageB64 = base64.RawStdEncoding.Strict()
+
+// AgeRecipients encrypts data in the age format, to X25519 recipients.
+type AgeRecipients struct {
+	recipients [][]byte
+}
+
+// ParseAgeRecipients parses X25519 recipients, of the form "age1...".
+func ParseAgeRecipients(recipients []string) (*AgeRecipients, error) {
+	a := &AgeRecipients{}
+	for _, s := range recipients {
+		hrp, key, err := bech32Decode(s)
+		if err != nil || hrp != ageRecipientHRP || len(key) != curve25519.PointSize {
+			return nil, fmt.Errorf("%w: invalid age recipient %q", ErrInvalidConfig, s)
+		}
+		a.recipients = append(a.recipients, key)
+	}
+	return a, nil
+}
+
+// Encrypt implements Encrypter.
+func (a *AgeRecipients) Encrypt(w io.Writer) (io.WriteCloser, error) {
+	fileKey := make([]byte, ageFileKeySize)
+	if _, err := rand.Read(fileKey); err != nil {
+		return nil, err
+	}
+
+	hdr := &bytes.Buffer{}
+	hdr.WriteString(ageVersionLine + "\n")
+	for _, r := range a.recipients {
+		share, body, err := ageWrap(fileKey, r)
+		if err != nil {
+			return nil, err
+		}
+		hdr.WriteString(ageStanzaPrefix + "X25519 " + ageB64.EncodeToString(share) + "\n")
+		enc := ageB64.EncodeToString(body)
+		for {
+			n := ageColumns
+			if n > len(enc) {
+				n = len(enc)
+			}
+			hdr.WriteString(enc[:n] + "\n")
+			if n < ageColumns {
+				// A line shorter than a full line ends the body, so a body
+				// which fills its last line is followed by an empty one.
+				break
+			}
+			enc = enc[n:]
+		}
+	}
+	hdr.WriteString(ageMACPrefix)
+	hdr.WriteString(" " + ageB64.EncodeToString(ageHeaderMAC(fileKey, hdr.Bytes())) + "\n")
+
+	nonce := make([]byte, ageNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	hdr.Write(nonce)
+	aead, err := chacha20poly1305.New(deriveKey(fileKey, nonce, "payload", chacha20poly1305.KeySize))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(hdr.Bytes()); err != nil {
+		return nil, err
+	}
+	return newStreamWriter(aead, w), nil
+}
+
+// String returns the type of encryption.
+func (a *AgeRecipients) String() string {
+	return TypeAge
+}
+
+// AgeIdentities decrypts data in the age format, with X25519 identities.
+type AgeIdentities struct {
+	identities [][]byte
+}
+
+// ParseAgeIdentities parses X25519 identities, of the form
+// "AGE-SECRET-KEY-1...", one per line. Empty lines, and lines starting with
+// "#", are ignored.
+func ParseAgeIdentities(b []byte) (*AgeIdentities, error) {
+	a := &AgeIdentities{}
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hrp, key, err := bech32Decode(line)
+		if err != nil || hrp != ageIdentityHRP || len(key) != curve25519.ScalarSize {
+			return nil, fmt.Errorf("%w: invalid age identity", ErrInvalidConfig)
+		}
+		a.identities = append(a.identities, key)
+	}
+	if len(a.identities) == 0 {
+		return nil, fmt.Errorf("%w: no age identities", ErrInvalidConfig)
+	}
+	return a, nil
+}
+
+// Decrypt implements Decrypter.
+func (a *AgeIdentities) Decrypt(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	hdr := &bytes.Buffer{}
+	readLine := func() (string, error) {
+		line, err := br.ReadString('\n')
+		if err != nil || len(line) > ageMaxLine {
+			return "", fmt.Errorf("%w: invalid age header", ErrDecrypt)
+		}
+		hdr.WriteString(line)
+		return strings.TrimSuffix(line, "\n"), nil
+	}
+
+	if line, err := readLine(); err != nil || line != ageVersionLine {
+		return nil, fmt.Errorf("%w: data is not encrypted in the age format", ErrDecrypt)
+	}
+	var fileKey []byte
+	var line string
+	for {
+		var err error
+		if line, err = readLine(); err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, ageStanzaPrefix) {
+			break
+		}
+		args := strings.Split(strings.TrimPrefix(line, ageStanzaPrefix), " ")
+		var body []byte
+		for {
+			l, err := readLine()
+			if err != nil {
+				return nil, err
+			}
+			b, err := ageB64.DecodeString(l)
+			if err != nil || len(l) > ageColumns {
+				return nil, fmt.Errorf("%w: invalid age stanza", ErrDecrypt)
+			}
+			body = append(body, b...)
+			if len(l) < ageColumns {
+				break
+			}
+		}
+		if fileKey == nil && len(args) == 2 && args[0] == "X25519" {
+			fileKey = a.unwrap(args[1], body)
+		}
+	}
+
+	if !strings.HasPrefix(line, ageMACPrefix+" ") {
+		return nil, fmt.Errorf("%w: invalid age header", ErrDecrypt)
+	}
+	if fileKey == nil {
+		return nil, fmt.Errorf("%w: no identity matches any recipient", ErrDecrypt)
+	}
+	mac, err := ageB64.DecodeString(strings.TrimPrefix(line, ageMACPrefix+" "))
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid age header", ErrDecrypt)
+	}
+	// The MAC is of the header up to and including "---".
+	h := hdr.Bytes()[:hdr.Len()-len(line)-1+len(ageMACPrefix)]
+	if !hmac.Equal(mac, ageHeaderMAC(fileKey, h)) {
+		return nil, fmt.Errorf("%w: age header failed authentication", ErrDecrypt)
+	}
+
+	nonce := make([]byte, ageNonceSize)
+	if _, err := io.ReadFull(br, nonce); err != nil {
+		return nil, fmt.Errorf("%w: data is truncated", ErrDecrypt)
+	}
+	aead, err := chacha20poly1305.New(deriveKey(fileKey, nonce, "payload", chacha20poly1305.KeySize))
+	if err != nil {
+		return nil, err
+	}
+	return newStreamReader(aead, br), nil
+}
+
+// unwrap returns the file key wrapped in the body of an X25519 stanza whose
+// argument is arg, or nil if no identity can unwrap it.
+func (a *AgeIdentities) unwrap(arg string, body []byte) []byte {
+	share, err := ageB64.DecodeString(arg)
+	if err != nil || len(share) != curve25519.PointSize {
+		return nil
+	}
+	for _, id := range a.identities {
+		shared, err := curve25519.X25519(id, share)
+		if err != nil {
+			continue
+		}
+		recipient, err := curve25519.X25519(id, curve25519.Basepoint)
+		if err != nil {
+			continue
+		}
+		aead, err := chacha20poly1305.New(deriveKey(shared, append(append([]byte(nil), share...), recipient...),
+			ageX25519Label, chacha20poly1305.KeySize))
+		if err != nil {
+			continue
+		}
+		fileKey, err := aead.Open(nil, make([]byte, chacha20poly1305.NonceSize), body, nil)
+		if err == nil && len(fileKey) == ageFileKeySize {
+			return fileKey
+		}
+	}
+	return nil
+}
+
+// String returns the type of encryption.
+func (a *AgeIdentities) String() string {
+	return TypeAge
+}
+
+// ageWrap wraps the file key for recipient, returning the ephemeral share
+// and the body of the stanza.
+func ageWrap(fileKey, recipient []byte) ([]byte, []byte, error) {
+	ephemeral := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(ephemeral); err != nil {
+		return nil, nil, err
+	}
+	share, err := curve25519.X25519(ephemeral, curve25519.Basepoint)
+	if err != nil {
+		return nil, nil, err
+	}
+	shared, err := curve25519.X25519(ephemeral, recipient)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := chacha20poly1305.New(deriveKey(shared, append(append([]byte(nil), share...), recipient...),
+		ageX25519Label, chacha20poly1305.KeySize))
+	if err != nil {
+		return nil, nil, err
+	}
+	return share, aead.Seal(nil, make([]byte, chacha20poly1305.NonceSize), fileKey, nil), nil
+}
+
+// ageHeaderMAC returns the MAC of the header hdr.
+func ageHeaderMAC(fileKey, hdr []byte) []byte {
+	h := hmac.New(sha256.New, deriveKey(fileKey, nil, "header", sha256.Size))
+	h.Write(hdr)
+	return h.Sum(nil)
+}
+
+// bech32Decode decodes a Bech32 string, as specified by BIP 173, returning
+// its human-readable part and its data. Unlike BIP 173 there is no limit to
+// the length of the string, as in the age format.
+func bech32Decode(s string) (string, []byte, error) {
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, errors.New("mixed case")
+	}
+	pos := strings.LastIndex(s, "1")
+	if pos < 1 || pos+7 > len(s) {
+		return "", nil, errors.New("invalid separator position")
+	}
+	hrp := s[:pos]
+	lower := strings.ToLower(s)
+	var values []byte
+	for _, c := range lower[pos+1:] {
+		i := strings.IndexRune(bech32Charset, c)
+		if i < 0 {
+			return "", nil, errors.New("invalid character")
+		}
+		values = append(values, byte(i))
+	}
+	if bech32Polymod(append(bech32ExpandHRP(lower[:pos]), values...)) != 1 {
+		return "", nil, errors.New("invalid checksum")
+	}
+
+	// Convert the data, less the checksum, from 5-bit to 8-bit groups.
+	var data []byte
+	acc, bits := 0, 0
+	for _, v := range values[:len(values)-6] {
+		acc = acc<<5 | int(v)
+		bits += 5
+		for bits >= 8 {
+			bits -= 8
+			data = append(data, byte(acc>>bits))
+		}
+		acc &= 1<<bits - 1
+	}
+	if bits >= 5 || acc != 0 {
+		return "", nil, errors.New("invalid padding")
+	}
+	return hrp, data, nil
+}
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+func bech32Polymod(values []byte) uint32 {
+	gen := []uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>i)&1 == 1 {
+				chk ^= gen[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32ExpandHRP(hrp string) []byte {
+	v := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		v = append(v, hrp[i]>>5)
+	}
+	v = append(v, 0)
+	for i := 0; i < len(hrp); i++ {
+		v = append(v, hrp[i]&31)
+	}
+	return v
+}
@@ -0,0 +1,355 @@
+// Package encryption provides client-side encryption of automatic backups,
+// so that the data held by a storage service cannot be read without a key
+// which is never given to that service.
+//
+// Data is encrypted in chunks, as a stream, so that large backups need not be
+// held in memory. Two formats are supported. The "aes-256-gcm" format
+// encrypts data with a 32-byte key shared by the uploader and the downloader.
+// The "age" format encrypts data to the X25519 recipients of the age file
+// encryption tool, so that only the holder of a matching identity can decrypt
+// it. Data encrypted in the age format can be decrypted by the age tool.
+package encryption
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// TypeAES256GCM is the type of encryption with AES-256-GCM.
+	TypeAES256GCM = "aes-256-gcm"
+
+	// TypeAge is the type of encryption in the age format.
+	TypeAge = "age"
+
+	// KeySize is the size of the keys used for AES-256-GCM.
+	KeySize = 32
+
+	// chunkSize is the size of the chunks of data encrypted separately.
+	chunkSize = 64 * 1024
+)
+
+var (
+	// ErrDecrypt is returned when data cannot be decrypted, either because it
+	// was not encrypted with a matching key, or because it has been altered.
+	ErrDecrypt = errors.New("failed to decrypt data")
+
+	// ErrInvalidConfig is returned when the encryption config is invalid.
+	ErrInvalidConfig = errors.New("invalid encryption config")
+)
+
+// Config is the config of encryption, within the config file of the
+// automatic backup or restore service.
+type Config struct {
+	// Type is the type of encryption, either TypeAES256GCM or TypeAge.
+	Type string `json:"type"`
+
+	// KeyFile is the path of a file holding the key used for AES-256-GCM,
+	// hex- or base64-encoded.
+	KeyFile string `json:"key_file,omitempty"`
+
+	// KeyEnv is the name of an environment variable holding the key used for
+	// AES-256-GCM, hex- or base64-encoded. It is read only if KeyFile is not
+	// set.
+	KeyEnv string `json:"key_env,omitempty"`
+
+	// Recipients are the age recipients, starting "age1", to which data is
+	// encrypted. They are needed only to encrypt.
+	Recipients []string `json:"recipients,omitempty"`
+
+	// IdentityFile is the path of a file holding the age identities,
+	// starting "AGE-SECRET-KEY-1", with which data is decrypted, in the
+	// format written by age-keygen. It is needed only to decrypt.
+	IdentityFile string `json:"identity_file,omitempty"`
+}
+
+// Encrypter encrypts data.
+type Encrypter interface {
+	// Encrypt returns a writer which encrypts the data written to it, and
+	// writes it to w. The writer must be closed to write the end of the data.
+	Encrypt(w io.Writer) (io.WriteCloser, error)
+}
+
+// Decrypter decrypts data.
+type Decrypter interface {
+	// Decrypt returns a reader of the data decrypted from r. The reader
+	// returns an error wrapping ErrDecrypt if the data cannot be decrypted,
+	// which may be after some data has been read from it.
+	Decrypt(r io.Reader) (io.Reader, error)
+}
+
+// NewEncrypter returns an Encrypter for the config.
+func NewEncrypter(cfg *Config) (Encrypter, error) {
+	switch cfg.Type {
+	case TypeAES256GCM:
+		key, err := cfg.key()
+		if err != nil {
+			return nil, err
+		}
+		return NewAESGCM(key)
+	case TypeAge:
+		if len(cfg.Recipients) == 0 {
+			return nil, fmt.Errorf("%w: no age recipients", ErrInvalidConfig)
+		}
+		return ParseAgeRecipients(cfg.Recipients)
+	}
+	return nil, fmt.Errorf("%w: unsupported type %q", ErrInvalidConfig, cfg.Type)
+}
+
+// NewDecrypter returns a Decrypter for the config.
+func NewDecrypter(cfg *Config) (Decrypter, error) {
+	switch cfg.Type {
+	case TypeAES256GCM:
+		key, err := cfg.key()
+		if err != nil {
+			return nil, err
+		}
+		return NewAESGCM(key)
+	case TypeAge:
+		if cfg.IdentityFile == "" {
+			return nil, fmt.Errorf("%w: no age identity file", ErrInvalidConfig)
+		}
+		b, err := os.ReadFile(cfg.IdentityFile)
+		if err != nil {
+			return nil, err
+		}
+		return ParseAgeIdentities(b)
+	}
+	return nil, fmt.Errorf("%w: unsupported type %q", ErrInvalidConfig, cfg.Type)
+}
+
+// key returns the AES-256-GCM key, read from the key file or environment.
+func (c *Config) key() ([]byte, error) {
+	var s string
+	switch {
+	case c.KeyFile != "":
+		b, err := os.ReadFile(c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		s = string(b)
+	case c.KeyEnv != "":
+		var ok bool
+		s, ok = os.LookupEnv(c.KeyEnv)
+		if !ok {
+			return nil, fmt.Errorf("%w: environment variable %s is not set", ErrInvalidConfig, c.KeyEnv)
+		}
+	default:
+		return nil, fmt.Errorf("%w: no key file or environment variable", ErrInvalidConfig)
+	}
+	return ParseKey(s)
+}
+
+// ParseKey parses a hex- or base64-encoded AES-256-GCM key. Surrounding
+// whitespace is ignored.
+func ParseKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if b, err := hex.DecodeString(s); err == nil && len(b) == KeySize {
+		return b, nil
+	}
+	if b, err := base64.StdEncoding.DecodeString(s); err == nil && len(b) == KeySize {
+		return b, nil
+	}
+	return nil, fmt.Errorf("%w: key must be %d bytes, hex- or base64-encoded", ErrInvalidConfig, KeySize)
+}
+
+// deriveKey derives a key of the given size from secret, salt and info, with
+// HKDF-SHA256.
+func deriveKey(secret, salt []byte, info string, size int) []byte {
+	key := make([]byte, size)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(info)), key); err != nil {
+		panic(err) // Only possible if size is too large.
+	}
+	return key
+}
+
+// The encrypted data of both formats is a sequence of chunks, each holding
+// chunkSize bytes of data other than the last, which holds between 1 and
+// chunkSize bytes, or none if there is no data. Each chunk is sealed with an
+// AEAD whose 12-byte nonce is the 11-byte big-endian index of the chunk,
+// followed by 1 for the last chunk and 0 otherwise. So chunks cannot be
+// reordered, and the data cannot be truncated, without detection. This is
+// the STREAM construction used by the age format.
+
+// setNonce sets nonce to that of the chunk with the given index.
+func setNonce(nonce []byte, index uint64, last bool) {
+	for i := range nonce {
+		nonce[i] = 0
+	}
+	for i := 10; i >= 3; i-- {
+		nonce[i] = byte(index)
+		index >>= 8
+	}
+	if last {
+		nonce[11] = 1
+	}
+}
+
+// streamWriter encrypts the data written to it in chunks.
+type streamWriter struct {
+	aead  cipher.AEAD
+	w     io.Writer
+	buf   []byte
+	nonce []byte
+	index uint64
+	err   error
+}
+
+func newStreamWriter(aead cipher.AEAD, w io.Writer) *streamWriter {
+	return &streamWriter{
+		aead:  aead,
+		w:     w,
+		buf:   make([]byte, 0, chunkSize+aead.Overhead()),
+		nonce: make([]byte, aead.NonceSize()),
+	}
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	n := 0
+	for len(p) > 0 {
+		// A full chunk is only sealed once more data is written, since until
+		// then it may be the last.
+		if len(s.buf) == chunkSize {
+			if s.err = s.flush(false); s.err != nil {
+				return n, s.err
+			}
+		}
+		m := chunkSize - len(s.buf)
+		if m > len(p) {
+			m = len(p)
+		}
+		s.buf = append(s.buf, p[:m]...)
+		p = p[m:]
+		n += m
+	}
+	return n, nil
+}
+
+// Close writes the last chunk. It does not close the underlying writer.
+func (s *streamWriter) Close() error {
+	if s.err != nil {
+		return s.err
+	}
+	s.err = s.flush(true)
+	if s.err == nil {
+		s.err = errors.New("write to closed writer")
+		return nil
+	}
+	return s.err
+}
+
+func (s *streamWriter) flush(last bool) error {
+	setNonce(s.nonce, s.index, last)
+	s.buf = s.aead.Seal(s.buf[:0], s.nonce, s.buf, nil)
+	if _, err := s.w.Write(s.buf); err != nil {
+		return err
+	}
+	s.buf = s.buf[:0]
+	s.index++
+	return nil
+}
+
+// streamReader decrypts the data read from r in chunks.
+type streamReader struct {
+	aead  cipher.AEAD
+	r     io.Reader
+	buf   []byte
+	out   []byte
+	data  []byte
+	nonce []byte
+	index uint64
+	done  bool
+	err   error
+}
+
+func newStreamReader(aead cipher.AEAD, r io.Reader) *streamReader {
+	return &streamReader{
+		aead: aead,
+		r:    r,
+		// One byte more than a chunk is read, to learn whether it is the last.
+		buf:   make([]byte, chunkSize+aead.Overhead()+1),
+		out:   make([]byte, 0, chunkSize),
+		nonce: make([]byte, aead.NonceSize()),
+	}
+}
+
+func (s *streamReader) Read(p []byte) (int, error) {
+	for len(s.data) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		if s.done {
+			return 0, io.EOF
+		}
+		s.err = s.next()
+	}
+	n := copy(p, s.data)
+	s.data = s.data[n:]
+	return n, nil
+}
+
+// next decrypts the next chunk. Any byte read beyond the chunk is kept at the
+// start of buf, for the chunk which follows.
+func (s *streamReader) next() error {
+	enc := chunkSize + s.aead.Overhead()
+	have := 0
+	if s.index > 0 {
+		have = 1
+	}
+	n, err := io.ReadFull(s.r, s.buf[have:])
+	n += have
+	last := false
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		last = true
+	case err != nil:
+		return err
+	}
+	if n > enc {
+		n = enc
+	}
+	if n < s.aead.Overhead() {
+		return fmt.Errorf("%w: data is truncated", ErrDecrypt)
+	}
+
+	setNonce(s.nonce, s.index, last)
+	data, err := s.aead.Open(s.out[:0], s.nonce, s.buf[:n], nil)
+	if err != nil {
+		return fmt.Errorf("%w: chunk %d failed authentication", ErrDecrypt, s.index)
+	}
+	if last && len(data) == 0 && s.index > 0 {
+		return fmt.Errorf("%w: last chunk is empty", ErrDecrypt)
+	}
+	if !last {
+		s.buf[0] = s.buf[enc]
+	}
+	s.data = data
+	s.done = last
+	s.index++
+	return nil
+}
+
+// hasPrefix reads len(prefix) bytes from r, and returns whether they are
+// prefix.
+func hasPrefix(r io.Reader, prefix []byte) (bool, error) {
+	b := make([]byte, len(prefix))
+	if _, err := io.ReadFull(r, b); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return bytes.Equal(b, prefix), nil
+}
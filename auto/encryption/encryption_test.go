@@ -0,0 +1,179 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	testAgeIdentity  = "AGE-SECRET-KEY-125XW5G2W6WXNGEH2JRCRR3H4DSA3U4JGFNJ0DPUWRKH7XNWUM70QTDD2Q9"
+	testAgeRecipient = "age1f98zm6l7wulcj9p49j0x4ahfkepjr43rsp7kzjq2sp0w0en3uy5qxwjz2w"
+
+	// testAgeData is "encrypted by age", encrypted to testAgeRecipient by
+	// the age tool.
+	testAgeData = "YWdlLWVuY3J5cHRpb24ub3JnL3YxCi0+IFgyNTUxOSB1djZGWW9tc2FMdDl4aWRNbGg0bXV4SFQyOEJ3am0yTUtLUzlGVW1qYmk0ClgrL3RZTDNBRUdFMWljRm5ybVEwUHZIelluT3h1RFZJcVo5bkx1SGdqUEEKLS0tIG10QTBLbmZ3S3lSMThtWFB3SncvUXNrV2xhVlRiRFI1Y3hDU0dNZzlGNlEKAHjCp5aLmLCAvy2rLnZ14yr5YRQwvukK4VVsc7WT/47/uiBTp0eJTPr4drEvUFl9"
+)
+
+func Test_AESGCM(t *testing.T) {
+	key := make([]byte, KeySize)
+	rand.Read(key)
+	a, err := NewAESGCM(key)
+	if err != nil {
+		t.Fatalf("failed to create AESGCM: %s", err)
+	}
+	testRoundTrip(t, a, a)
+
+	// A different key must fail to decrypt.
+	other := make([]byte, KeySize)
+	rand.Read(other)
+	b, _ := NewAESGCM(other)
+	enc := mustEncrypt(t, a, []byte("secret"))
+	if _, err := decrypt(b, enc); !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("expected ErrDecrypt with wrong key, got %v", err)
+	}
+
+	if _, err := NewAESGCM(key[1:]); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("expected ErrInvalidConfig for short key, got %v", err)
+	}
+}
+
+func Test_Age(t *testing.T) {
+	r, err := ParseAgeRecipients([]string{testAgeRecipient})
+	if err != nil {
+		t.Fatalf("failed to parse recipient: %s", err)
+	}
+	ids, err := ParseAgeIdentities([]byte("# created: by age-keygen\n" + testAgeIdentity + "\n"))
+	if err != nil {
+		t.Fatalf("failed to parse identity: %s", err)
+	}
+	testRoundTrip(t, r, ids)
+
+	enc, _ := base64.StdEncoding.DecodeString(testAgeData)
+	if b, err := decrypt(ids, enc); err != nil || string(b) != "encrypted by age" {
+		t.Fatalf("failed to decrypt data encrypted by age tool: %q, %v", b, err)
+	}
+
+	if _, err := ParseAgeRecipients([]string{testAgeRecipient[:len(testAgeRecipient)-1] + "q"}); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("expected ErrInvalidConfig for bad checksum, got %v", err)
+	}
+	if _, err := ParseAgeIdentities([]byte("# nothing\n")); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("expected ErrInvalidConfig for no identities, got %v", err)
+	}
+}
+
+func Test_AgeWrongIdentity(t *testing.T) {
+	// An identity for a different recipient, created by age-keygen.
+	ids, err := ParseAgeIdentities([]byte("AGE-SECRET-KEY-10D8YDFT6PSXPNSCE6XF2FXLK798PJT8VNQJDA5MAZQSGD2ECSETQ7TTA05"))
+	if err != nil {
+		t.Fatalf("failed to parse identity: %s", err)
+	}
+	enc, _ := base64.StdEncoding.DecodeString(testAgeData)
+	if _, err := decrypt(ids, enc); !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("expected ErrDecrypt with wrong identity, got %v", err)
+	}
+}
+
+func Test_NewEncrypterDecrypter(t *testing.T) {
+	dir := t.TempDir()
+	key := make([]byte, KeySize)
+	rand.Read(key)
+	keyPath := filepath.Join(dir, "key")
+	if err := os.WriteFile(keyPath, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+		t.Fatalf("failed to write key: %s", err)
+	}
+	os.Setenv("RQLITE_TEST_BACKUP_KEY", base64.StdEncoding.EncodeToString(key))
+	defer os.Unsetenv("RQLITE_TEST_BACKUP_KEY")
+
+	e, err := NewEncrypter(&Config{Type: TypeAES256GCM, KeyFile: keyPath})
+	if err != nil {
+		t.Fatalf("failed to create encrypter: %s", err)
+	}
+	d, err := NewDecrypter(&Config{Type: TypeAES256GCM, KeyEnv: "RQLITE_TEST_BACKUP_KEY"})
+	if err != nil {
+		t.Fatalf("failed to create decrypter: %s", err)
+	}
+	testRoundTrip(t, e, d)
+
+	idPath := filepath.Join(dir, "identity")
+	if err := os.WriteFile(idPath, []byte(testAgeIdentity), 0600); err != nil {
+		t.Fatalf("failed to write identity: %s", err)
+	}
+	e, err = NewEncrypter(&Config{Type: TypeAge, Recipients: []string{testAgeRecipient}})
+	if err != nil {
+		t.Fatalf("failed to create encrypter: %s", err)
+	}
+	d, err = NewDecrypter(&Config{Type: TypeAge, IdentityFile: idPath})
+	if err != nil {
+		t.Fatalf("failed to create decrypter: %s", err)
+	}
+	testRoundTrip(t, e, d)
+
+	for _, cfg := range []*Config{
+		{Type: "rot13"},
+		{Type: TypeAES256GCM},
+		{Type: TypeAES256GCM, KeyEnv: "RQLITE_TEST_NO_SUCH_KEY"},
+		{Type: TypeAge},
+	} {
+		if _, err := NewEncrypter(cfg); !errors.Is(err, ErrInvalidConfig) {
+			t.Fatalf("expected ErrInvalidConfig for %+v, got %v", cfg, err)
+		}
+	}
+}
+
+func testRoundTrip(t *testing.T, e Encrypter, d Decrypter) {
+	t.Helper()
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3*chunkSize + 100} {
+		data := make([]byte, size)
+		rand.Read(data)
+		enc := mustEncrypt(t, e, data)
+		dec, err := decrypt(d, enc)
+		if err != nil {
+			t.Fatalf("failed to decrypt %d bytes: %s", size, err)
+		}
+		if !bytes.Equal(data, dec) {
+			t.Fatalf("decrypted data differs from that encrypted, for %d bytes", size)
+		}
+
+		// Truncated or altered data must fail to decrypt.
+		if size > chunkSize {
+			if _, err := decrypt(d, enc[:len(enc)-size%chunkSize-16]); !errors.Is(err, ErrDecrypt) {
+				t.Fatalf("expected ErrDecrypt for truncated data, got %v", err)
+			}
+		}
+		enc[len(enc)-1] ^= 1
+		if _, err := decrypt(d, enc); !errors.Is(err, ErrDecrypt) {
+			t.Fatalf("expected ErrDecrypt for altered data, got %v", err)
+		}
+	}
+}
+
+func mustEncrypt(t *testing.T, e Encrypter, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := e.Encrypt(&buf)
+	if err != nil {
+		t.Fatalf("failed to start encryption: %s", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("failed to encrypt: %s", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to finish encryption: %s", err)
+	}
+	return buf.Bytes()
+}
+
+func decrypt(d Decrypter, enc []byte) ([]byte, error) {
+	r, err := d.Decrypt(bytes.NewReader(enc))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
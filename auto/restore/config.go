@@ -1,13 +1,22 @@
 package restore
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"time"
 
 	"github.com/rqlite/rqlite/auto"
+	"github.com/rqlite/rqlite/auto/encryption"
+	"github.com/rqlite/rqlite/auto/webhook"
 	"github.com/rqlite/rqlite/aws"
+	"github.com/rqlite/rqlite/azure"
+	"github.com/rqlite/rqlite/gcp"
+	"github.com/rqlite/rqlite/sftp"
 )
 
 // Config is the config file format for the upload service
@@ -16,10 +25,66 @@ type Config struct {
 	Type              auto.StorageType `json:"type"`
 	Timeout           auto.Duration    `json:"timeout,omitempty"`
 	ContinueOnFailure bool             `json:"continue_on_failure,omitempty"`
-	Sub               json.RawMessage  `json:"sub"`
+	Checksum          string           `json:"checksum,omitempty"`
+	ChecksumSidecar   bool             `json:"checksum_sidecar,omitempty"`
+	MaxAttempts       int              `json:"max_attempts,omitempty"`
+	RetryBackoff      auto.Duration    `json:"retry_backoff,omitempty"`
+
+	// Encryption, if set, is the config of the decryption of the data, which
+	// was encrypted by the client which uploaded it.
+	Encryption *encryption.Config `json:"encryption,omitempty"`
+
+	// Webhook, if set, is notified of the outcome of the auto-restore.
+	Webhook *webhook.Config `json:"webhook,omitempty"`
+
+	Sub json.RawMessage `json:"sub"`
+
+	// Sources, if set, lists further sources of the data, in priority order.
+	// Each is tried in turn, should the source set by Type and Sub, if any,
+	// and every source before it fail.
+	Sources []*SourceConfig `json:"sources,omitempty"`
 }
 
-// Unmarshal unmarshals the config file and returns the config and subconfig
+// SourceConfig is the config of a source of the data to be restored. Any
+// timeout or checksum not set is that of the Config.
+type SourceConfig struct {
+	Type            auto.StorageType `json:"type"`
+	Timeout         auto.Duration    `json:"timeout,omitempty"`
+	Checksum        string           `json:"checksum,omitempty"`
+	ChecksumSidecar bool             `json:"checksum_sidecar,omitempty"`
+	Sub             json.RawMessage  `json:"sub"`
+}
+
+// SourceConfigs returns a config for each source of the data, in priority
+// order, starting with the source set by Type and Sub, if any. Settings
+// not particular to a source are those of c.
+func (c *Config) SourceConfigs() []*Config {
+	var cfgs []*Config
+	if c.Type != "" {
+		cfg := *c
+		cfg.Sources = nil
+		cfgs = append(cfgs, &cfg)
+	}
+	for _, src := range c.Sources {
+		cfg := *c
+		cfg.Type = src.Type
+		cfg.Sub = src.Sub
+		cfg.ChecksumSidecar = src.ChecksumSidecar
+		cfg.Sources = nil
+		if src.Timeout != 0 {
+			cfg.Timeout = src.Timeout
+		}
+		if src.Checksum != "" {
+			cfg.Checksum = src.Checksum
+		}
+		cfgs = append(cfgs, &cfg)
+	}
+	return cfgs
+}
+
+// Unmarshal unmarshals the config file and returns the config and subconfig.
+// The subconfig is only meaningful if the storage type is S3. Use
+// NewStorageClient to create a client for any supported storage type.
 func Unmarshal(data []byte) (*Config, *aws.S3Config, error) {
 	cfg := &Config{}
 	err := json.Unmarshal(data, cfg)
@@ -35,6 +100,37 @@ func Unmarshal(data []byte) (*Config, *aws.S3Config, error) {
 		cfg.Timeout = auto.Duration(30 * time.Second)
 	}
 
+	if cfg.MaxAttempts < 0 {
+		return nil, nil, fmt.Errorf("max_attempts must not be negative")
+	}
+	if cfg.MaxAttempts == 0 {
+		cfg.MaxAttempts = DefaultMaxAttempts
+	}
+	if cfg.RetryBackoff == 0 {
+		cfg.RetryBackoff = auto.Duration(DefaultRetryBackoff)
+	}
+
+	checksums := []string{cfg.Checksum}
+	for _, src := range cfg.Sources {
+		checksums = append(checksums, src.Checksum)
+	}
+	for _, sum := range checksums {
+		if sum == "" {
+			continue
+		}
+		if b, err := hex.DecodeString(sum); err != nil || len(b) != sha256.Size {
+			return nil, nil, fmt.Errorf("checksum %s is not a hex-encoded SHA-256 checksum", sum)
+		}
+	}
+
+	if cfg.Type == "" {
+		if len(cfg.Sources) == 0 {
+			return nil, nil, fmt.Errorf("no source of data is set")
+		}
+		// Sources alone are set, so there is no subconfig.
+		return cfg, &aws.S3Config{}, nil
+	}
+
 	s3cfg := &aws.S3Config{}
 	err = json.Unmarshal(cfg.Sub, s3cfg)
 	if err != nil {
@@ -43,6 +139,111 @@ func Unmarshal(data []byte) (*Config, *aws.S3Config, error) {
 	return cfg, s3cfg, nil
 }
 
+// NewStorageClient returns a client for the storage service set in the
+// config, configured by the config's subconfig.
+func NewStorageClient(cfg *Config) (StorageClient, error) {
+	switch cfg.Type {
+	case auto.StorageTypeS3:
+		s3cfg := &aws.S3Config{}
+		if err := json.Unmarshal(cfg.Sub, s3cfg); err != nil {
+			return nil, err
+		}
+		return aws.NewS3Client(s3cfg.Endpoint, s3cfg.Region, s3cfg.AccessKeyID, s3cfg.SecretAccessKey,
+			s3cfg.Bucket, s3cfg.Path), nil
+	case auto.StorageTypeGCS:
+		gcsCfg := &gcp.GCSConfig{}
+		if err := json.Unmarshal(cfg.Sub, gcsCfg); err != nil {
+			return nil, err
+		}
+		return gcp.NewGCSClient(gcsCfg.Endpoint, gcsCfg.CredentialsFile, gcsCfg.Bucket, gcsCfg.Name), nil
+	case auto.StorageTypeAzure:
+		azCfg := &azure.BlobConfig{}
+		if err := json.Unmarshal(cfg.Sub, azCfg); err != nil {
+			return nil, err
+		}
+		return azure.NewBlobClient(azCfg.Account, azCfg.Endpoint, azCfg.Container, azCfg.Blob,
+			azCfg.SASToken, azCfg.ClientID), nil
+	case auto.StorageTypeSFTP:
+		sftpCfg := &sftp.SFTPConfig{}
+		if err := json.Unmarshal(cfg.Sub, sftpCfg); err != nil {
+			return nil, err
+		}
+		return sftp.NewSFTPClient(sftpCfg.Host, sftpCfg.Username, sftpCfg.PrivateKeyFile,
+			sftpCfg.Passphrase, sftpCfg.KnownHostsFile, sftpCfg.Path), nil
+	case auto.StorageTypeURL:
+		urlCfg := &URLConfig{}
+		if err := json.Unmarshal(cfg.Sub, urlCfg); err != nil {
+			return nil, err
+		}
+		u, err := url.Parse(urlCfg.URL)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "https" {
+			return nil, fmt.Errorf("URL %s must use https", u.Redacted())
+		}
+		return NewURLClient(urlCfg.URL, urlCfg.Username, urlCfg.Password, urlCfg.BearerToken,
+			urlCfg.Headers), nil
+	case auto.StorageTypeFile:
+		fileCfg := &FileConfig{}
+		if err := json.Unmarshal(cfg.Sub, fileCfg); err != nil {
+			return nil, err
+		}
+		if fileCfg.Path == "" {
+			return nil, fmt.Errorf("path must be set")
+		}
+		return NewFileClient(fileCfg.Path), nil
+	}
+	return nil, auto.ErrUnsupportedStorageType
+}
+
+// NewSidecarStorageClient returns a client for the sidecar object stored
+// alongside the object set in the config. The name of the sidecar object is
+// that of the object, with suffix appended.
+func NewSidecarStorageClient(cfg *Config, suffix string) (StorageClient, error) {
+	sub := make(map[string]interface{})
+	if err := json.Unmarshal(cfg.Sub, &sub); err != nil {
+		return nil, err
+	}
+
+	var field string
+	switch cfg.Type {
+	case auto.StorageTypeS3, auto.StorageTypeSFTP, auto.StorageTypeFile:
+		field = "path"
+	case auto.StorageTypeGCS:
+		field = "name"
+	case auto.StorageTypeAzure:
+		field = "blob"
+	case auto.StorageTypeURL:
+		field = "url"
+	default:
+		return nil, auto.ErrUnsupportedStorageType
+	}
+	name, ok := sub[field].(string)
+	if !ok || name == "" {
+		return nil, fmt.Errorf("%s must be set to locate sidecar object", field)
+	}
+	if cfg.Type == auto.StorageTypeURL {
+		u, err := url.Parse(name)
+		if err != nil {
+			return nil, err
+		}
+		u.Path += suffix
+		u.RawPath = ""
+		sub[field] = u.String()
+	} else {
+		sub[field] = name + suffix
+	}
+
+	b, err := json.Marshal(sub)
+	if err != nil {
+		return nil, err
+	}
+	sidecarCfg := *cfg
+	sidecarCfg.Sub = b
+	return NewStorageClient(&sidecarCfg)
+}
+
 // ReadConfigFile reads the config file and returns the data. It also expands
 // any environment variables in the config file.
 func ReadConfigFile(filename string) ([]byte, error) {
@@ -1,34 +1,126 @@
 package restore
 
 import (
+	"bufio"
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"expvar"
 	"fmt"
 	"io"
 	"log"
+	"net"
+	"net/http"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/rqlite/rqlite/auto/encryption"
+	"github.com/rqlite/rqlite/db"
+	"github.com/rqlite/rqlite/random"
+	"github.com/ulikunitz/xz"
 )
 
+const (
+	// SidecarSuffix is appended to the name of the object being restored to
+	// form the name of the sidecar object holding its SHA-256 checksum.
+	SidecarSuffix = ".sha256"
+
+	// maxSidecarSize is the largest sidecar object which will be read.
+	maxSidecarSize = 4096
+
+	// DefaultMaxAttempts is the default number of attempts made to download
+	// the data.
+	DefaultMaxAttempts = 3
+
+	// DefaultRetryBackoff is the default time waited before the first retry
+	// of a failed download. It doubles with each further retry, up to
+	// maxRetryBackoff.
+	DefaultRetryBackoff = time.Second
+
+	maxRetryBackoff = time.Minute
+)
+
+// Formats of downloaded data, once decompressed.
+const (
+	FormatUnknown = "unknown"
+	FormatSQLite  = "sqlite"
+	FormatSQLDump = "sql"
+
+	// maxSniffSize is the amount of data from its start used to detect
+	// the format of downloaded data.
+	maxSniffSize = 4096
+)
+
+// ErrChecksumMismatch is returned when the downloaded data does not match
+// its expected checksum.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
 // StorageClient is an interface for downloading data from a storage service.
 type StorageClient interface {
 	Download(ctx context.Context, writer io.WriterAt) error
 	fmt.Stringer
 }
 
+// Sizer is implemented by storage clients which can report the size of the
+// data before it is downloaded.
+type Sizer interface {
+	Size(ctx context.Context) (int64, error)
+}
+
+// SequentialStorageClient is implemented by storage clients which can
+// download data sequentially, from start to end. Data downloaded by such
+// clients is decompressed as it is downloaded, instead of first being
+// written in full to a temporary file.
+type SequentialStorageClient interface {
+	DownloadSequential(ctx context.Context, w io.Writer) error
+}
+
 // stats captures stats for the Uploader service.
 var stats *expvar.Map
 
+// Compression formats of downloaded data which are detected, and decompressed.
+const (
+	compressionNone = ""
+	compressionGzip = "gzip"
+	compressionZstd = "zstd"
+	compressionXz   = "xz"
+)
+
 var (
 	gzipMagic = []byte{0x1f, 0x8b, 0x08}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	xzMagic   = []byte{0xfd, 0x37, 0x7a, 0x58, 0x5a, 0x00}
 )
 
 const (
 	numDownloadsOK   = "num_downloads_ok"
 	numDownloadsFail = "num_downloads_fail"
 	numDownloadBytes = "download_bytes"
+	numChecksumsOK   = "num_checksums_ok"
+	numChecksumsFail = "num_checksums_fail"
+
+	numDownloadAttempts     = "num_download_attempts"
+	numDownloadAttemptsFail = "num_download_attempts_fail"
+	numDownloadRetries      = "num_download_retries"
+	numDownloadsStreamed    = "num_downloads_streamed"
+	numSourceFailovers      = "num_source_failovers"
+
+	// Progress of the current, or most recent, download.
+	downloadProgressBytes = "download_progress_bytes"
+	downloadTotalBytes    = "download_total_bytes"
+	downloadPercent       = "download_percent"
+	downloadRate          = "download_rate_bytes_per_sec"
+
+	// defaultProgressInterval is the default interval between log lines
+	// reporting the progress of a download.
+	defaultProgressInterval = 10 * time.Second
 )
 
 func init() {
@@ -42,33 +134,90 @@ func ResetStats() {
 	stats.Add(numDownloadsOK, 0)
 	stats.Add(numDownloadsFail, 0)
 	stats.Add(numDownloadBytes, 0)
+	stats.Add(numChecksumsOK, 0)
+	stats.Add(numChecksumsFail, 0)
+	stats.Add(numDownloadAttempts, 0)
+	stats.Add(numDownloadAttemptsFail, 0)
+	stats.Add(numDownloadRetries, 0)
+	stats.Add(numDownloadsStreamed, 0)
+	stats.Add(numSourceFailovers, 0)
+	stats.Add(downloadProgressBytes, 0)
+	stats.Add(downloadTotalBytes, 0)
+	stats.AddFloat(downloadPercent, 0)
+	stats.AddFloat(downloadRate, 0)
 }
 
 type Downloader struct {
 	storageClient StorageClient
-	logger        *log.Logger
+
+	// Checksum, if set, is the expected hex-encoded SHA-256 checksum of the
+	// data, as stored.
+	Checksum string
+
+	// Sidecar, if set, is a client for a sidecar object holding the expected
+	// SHA-256 checksum of the data, in the format written by sha256sum.
+	Sidecar StorageClient
+
+	// Decrypter, if set, decrypts the data, which is decrypted after its
+	// checksum is verified and before it is decompressed.
+	Decrypter encryption.Decrypter
+
+	// ProgressInterval is the interval between log lines reporting the
+	// progress of a download.
+	ProgressInterval time.Duration
+
+	// MaxAttempts is the maximum number of attempts made to download the
+	// data. Only failures which may be transient, such as timeouts and server
+	// errors, are retried.
+	MaxAttempts int
+
+	// RetryBackoff is the time waited before the first retry. It doubles
+	// with each further retry, and jitter is added to each wait.
+	RetryBackoff time.Duration
+
+	format string
+	logger *log.Logger
 }
 
 func NewDownloader(storageClient StorageClient) *Downloader {
 	return &Downloader{
-		storageClient: storageClient,
-		logger:        log.New(os.Stderr, "[downloader] ", log.LstdFlags),
+		storageClient:    storageClient,
+		ProgressInterval: defaultProgressInterval,
+		MaxAttempts:      DefaultMaxAttempts,
+		RetryBackoff:     DefaultRetryBackoff,
+		logger:           log.New(os.Stderr, "[downloader] ", log.LstdFlags),
 	}
 }
 
+// Do downloads the data, writing it to w, decrypted and decompressed if
+// necessary. The timeout applies to each attempt to download the data.
+//
+// If the storage client supports sequential downloads the data is streamed
+// to w as it is downloaded. Otherwise it is first downloaded to a temporary
+// file, since the client may write it in any order. If Do returns an error,
+// anything written to w must be discarded.
 func (d *Downloader) Do(ctx context.Context, w io.Writer, timeout time.Duration) (err error) {
-	var cw *countingWriterAt
 	defer func() {
 		if err == nil {
 			stats.Add(numDownloadsOK, 1)
-			if cw != nil {
-				stats.Add(numDownloadBytes, int64(cw.count))
-			}
 		} else {
 			stats.Add(numDownloadsFail, 1)
 		}
 	}()
 
+	sw := &sniffWriter{w: w}
+	defer func() {
+		if err == nil {
+			d.format = detectFormat(sw.head)
+			d.logger.Printf("data downloaded from %v is in %s format", d.storageClient, d.format)
+		}
+	}()
+	w = sw
+
+	if sc, ok := d.storageClient.(SequentialStorageClient); ok {
+		return d.stream(ctx, sc, w, timeout)
+	}
+
 	// Create a temporary file for the download.
 	f, err := os.CreateTemp("", "rqlite-downloader")
 	if err != nil {
@@ -77,74 +226,558 @@ func (d *Downloader) Do(ctx context.Context, w io.Writer, timeout time.Duration)
 	defer os.Remove(f.Name())
 	defer f.Close()
 
-	ctx, cancel := context.WithTimeout(ctx, timeout)
+	if err := d.withRetry(ctx, func() error {
+		return d.download(ctx, f, timeout)
+	}); err != nil {
+		return err
+	}
+
+	vctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
+	if err := d.verify(vctx, f); err != nil {
+		stats.Add(numChecksumsFail, 1)
+		return err
+	}
+
+	if d.Decrypter != nil {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		return d.decryptStream(w, bufio.NewReader(f))
+	}
+
+	// Check if the download data is compressed.
+	comp, err := detectCompression(f)
+	if err != nil {
+		return err
+	}
+	return d.decompressTo(w, f, comp)
+}
+
+// Format returns the format of the data written by the last successful call
+// to Do, either a SQLite database or a SQL dump, or FormatUnknown if it is
+// neither.
+func (d *Downloader) Format() string {
+	if d.format == "" {
+		return FormatUnknown
+	}
+	return d.format
+}
 
-	cw = &countingWriterAt{writerAt: f}
-	err = d.storageClient.Download(ctx, cw)
+// stream downloads the data from sc, decompressing it as it is downloaded
+// and writing it to w. Since data written to w cannot be taken back, a failed
+// attempt is only retried if it failed before any data was written, and the
+// checksum of the data is only verified once it has all been written.
+func (d *Downloader) stream(ctx context.Context, sc SequentialStorageClient, w io.Writer, timeout time.Duration) error {
+	stats.Add(numDownloadsStreamed, 1)
+
+	// Fetch any checksum from the sidecar first, so that an unreadable
+	// sidecar fails the restore before any data is written.
+	vctx, cancel := context.WithTimeout(ctx, timeout)
+	expected, err := d.expectedChecksums(vctx)
+	cancel()
 	if err != nil {
+		stats.Add(numChecksumsFail, 1)
+		return err
+	}
+
+	cw := &countingWriterAt{writer: w, total: -1}
+	var actual string
+	if err := d.withRetry(ctx, func() error {
+		sum, err := d.streamOnce(ctx, sc, cw, timeout)
+		if err != nil && cw.Count() > 0 {
+			return &permanentError{err: err}
+		}
+		actual = sum
+		return err
+	}); err != nil {
+		return err
+	}
+
+	if err := d.checkSum(actual, expected); err != nil {
+		stats.Add(numChecksumsFail, 1)
 		return err
 	}
+	return nil
+}
+
+// streamOnce makes a single attempt to stream the data from sc to w,
+// returning the hex-encoded SHA-256 checksum of the data as downloaded. The
+// storage client writes to one end of a pipe, while the data is decompressed
+// from the other.
+func (d *Downloader) streamOnce(ctx context.Context, sc SequentialStorageClient, w io.Writer, timeout time.Duration) (string, error) {
+	stats.Add(numDownloadAttempts, 1)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	pr, pw := io.Pipe()
+	cw := &countingWriterAt{writer: pw, total: d.size(ctx)}
+	dlErrCh := make(chan error, 1)
+	go func() {
+		err := sc.DownloadSequential(ctx, cw)
+		pw.CloseWithError(err)
+		dlErrCh <- err
+	}()
+	done := make(chan struct{})
+	go d.logProgress(cw, done)
+	start := time.Now()
+
+	h := sha256.New()
+	br := bufio.NewReader(io.TeeReader(pr, h))
+	err := d.decryptStream(w, br)
+	// Closing the reader unblocks the download if decompression failed.
+	pr.Close()
+	dlErr := <-dlErrCh
+	close(done)
+	cw.updateStats()
+
+	// A failed download is the cause of any failure to decompress it, while
+	// a failure to decompress ends the download by closing the pipe.
+	if dlErr != nil && !errors.Is(dlErr, io.ErrClosedPipe) {
+		return "", dlErr
+	}
+	if err != nil {
+		return "", err
+	}
+	rate := float64(cw.Count()) / time.Since(start).Seconds()
+	stats.Set(downloadRate, expvarFloat(rate))
+	stats.Add(numDownloadBytes, cw.Count())
+	d.logger.Printf("streamed %d bytes from %v at %.0f bytes/sec", cw.Count(), d.storageClient, rate)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
 
-	// Check if the download data is gzip compressed.
-	compressed, err := isGzip(f)
+// decryptStream writes the data read from br to w, decrypting it if a
+// Decrypter is set, and decompressing it if its compression format is
+// detected. It reads br to the end.
+func (d *Downloader) decryptStream(w io.Writer, br *bufio.Reader) error {
+	if d.Decrypter == nil {
+		return d.decompressStream(w, br)
+	}
+	dr, err := d.Decrypter.Decrypt(br)
 	if err != nil {
 		return err
 	}
+	d.logger.Printf("decrypting data downloaded from %v", d.storageClient)
+	if err := d.decompressStream(w, bufio.NewReader(dr)); err != nil {
+		return err
+	}
+	_, err = io.Copy(io.Discard, br)
+	return err
+}
 
-	if compressed {
-		gzr, err := gzip.NewReader(f)
+// decompressStream writes the data read from br to w, decompressing it if
+// its compression format is detected. It reads br to the end, even if the
+// compressed data ends first.
+func (d *Downloader) decompressStream(w io.Writer, br *bufio.Reader) error {
+	magic, err := br.Peek(len(xzMagic))
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if err := d.decompressTo(w, br, compressionOf(magic)); err != nil {
+		return err
+	}
+	_, err = io.Copy(io.Discard, br)
+	return err
+}
+
+// decompressTo writes the data read from r to w, decompressing it from the
+// given compression format.
+func (d *Downloader) decompressTo(w io.Writer, r io.Reader, comp string) error {
+	switch comp {
+	case compressionGzip:
+		gzr, err := gzip.NewReader(r)
 		if err != nil {
 			return err
 		}
 		defer gzr.Close()
-
-		_, err = io.Copy(w, gzr)
+		r = gzr
+	case compressionZstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	case compressionXz:
+		xzr, err := xz.NewReader(r)
 		if err != nil {
-			return fmt.Errorf("failed to decompress data: %s", err)
+			return err
+		}
+		r = xzr
+	}
+
+	if comp == compressionNone {
+		if _, err := io.Copy(w, r); err != nil {
+			return fmt.Errorf("failed to write data: %w", err)
+		}
+		return nil
+	}
+	d.logger.Printf("decompressing %s data downloaded from %v", comp, d.storageClient)
+	if _, err := io.Copy(w, r); err != nil {
+		return fmt.Errorf("failed to decompress data: %w", err)
+	}
+	return nil
+}
+
+// withRetry makes attempts to download the data, retrying failed attempts
+// which may succeed if retried, with exponential backoff.
+func (d *Downloader) withRetry(ctx context.Context, attempt func() error) error {
+	attempts := d.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	backoff := d.RetryBackoff
+	for i := 1; ; i++ {
+		err := attempt()
+		if err == nil {
+			return nil
 		}
-	} else {
-		_, err = io.Copy(w, f)
+		stats.Add(numDownloadAttemptsFail, 1)
+		if i >= attempts || ctx.Err() != nil || !retryable(err) {
+			return err
+		}
+
+		wait := random.Jitter(backoff)
+		d.logger.Printf("download attempt %d of %d from %v failed, retrying in %s: %s",
+			i, attempts, d.storageClient, wait, err.Error())
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		stats.Add(numDownloadRetries, 1)
+		backoff *= 2
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+// download makes a single attempt to download the data to f, replacing
+// anything written by previous attempts.
+func (d *Downloader) download(ctx context.Context, f *os.File, timeout time.Duration) error {
+	stats.Add(numDownloadAttempts, 1)
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cw := &countingWriterAt{writerAt: f, total: d.size(ctx)}
+	done := make(chan struct{})
+	go d.logProgress(cw, done)
+	start := time.Now()
+	err := d.storageClient.Download(ctx, cw)
+	close(done)
+	cw.updateStats()
+	if err != nil {
+		return err
+	}
+	rate := float64(cw.Count()) / time.Since(start).Seconds()
+	stats.Set(downloadRate, expvarFloat(rate))
+	stats.Add(numDownloadBytes, cw.Count())
+	d.logger.Printf("downloaded %d bytes from %v at %.0f bytes/sec", cw.Count(), d.storageClient, rate)
+	return nil
+}
+
+// retryable returns whether a failed download may succeed if retried.
+// Timeouts, network errors, and server errors are retryable, while errors
+// such as missing data or lack of permission to read it are not.
+func retryable(err error) bool {
+	var pe *permanentError
+	if errors.As(err, &pe) {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, encryption.ErrDecrypt) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var sc interface{ StatusCode() int }
+	if errors.As(err, &sc) {
+		code := sc.StatusCode()
+		return code >= 500 || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests
+	}
+	var ne net.Error
+	if errors.As(err, &ne) {
+		return true
+	}
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) {
+		return false
+	}
+	// Errors of unknown cause, such as connections closed before the data
+	// was fully downloaded, are assumed to be transient.
+	return true
+}
+
+// verify verifies the checksum of the downloaded data in f against any
+// expected checksums. A checksum read from the sidecar object must match any
+// checksum set explicitly.
+func (d *Downloader) verify(ctx context.Context, f io.ReadSeeker) error {
+	expected, err := d.expectedChecksums(ctx)
+	if err != nil || len(expected) == 0 {
+		return err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return d.checkSum(hex.EncodeToString(h.Sum(nil)), expected)
+}
+
+// expectedChecksums returns the checksums the downloaded data is expected
+// to have, set explicitly or read from the sidecar object.
+func (d *Downloader) expectedChecksums(ctx context.Context) ([]string, error) {
+	var expected []string
+	if d.Checksum != "" {
+		expected = append(expected, d.Checksum)
+	}
+	if d.Sidecar != nil {
+		sum, err := d.sidecarChecksum(ctx)
 		if err != nil {
-			return fmt.Errorf("failed to write data: %s", err)
+			return nil, err
+		}
+		expected = append(expected, sum)
+	}
+	return expected, nil
+}
+
+// checkSum checks the actual checksum of the downloaded data matches every
+// expected checksum.
+func (d *Downloader) checkSum(actual string, expected []string) error {
+	if len(expected) == 0 {
+		return nil
+	}
+	for _, e := range expected {
+		if !strings.EqualFold(actual, e) {
+			return fmt.Errorf("%w: downloaded data from %v has SHA-256 checksum %s, expected %s",
+				ErrChecksumMismatch, d.storageClient, actual, e)
 		}
 	}
+	stats.Add(numChecksumsOK, 1)
+	d.logger.Printf("verified SHA-256 checksum %s of data downloaded from %v", actual, d.storageClient)
 	return nil
 }
 
+// sidecarChecksum returns the checksum held by the sidecar object. The
+// checksum is the first field of the object, so the output of sha256sum may
+// be uploaded as is.
+func (d *Downloader) sidecarChecksum(ctx context.Context) (string, error) {
+	buf := &bufferWriterAt{}
+	if err := d.Sidecar.Download(ctx, buf); err != nil {
+		return "", fmt.Errorf("failed to download checksum from %v: %w", d.Sidecar, err)
+	}
+	fields := strings.Fields(string(buf.b))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("checksum in %v is empty", d.Sidecar)
+	}
+	if b, err := hex.DecodeString(fields[0]); err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("checksum in %v is not a hex-encoded SHA-256 checksum", d.Sidecar)
+	}
+	return fields[0], nil
+}
+
+// bufferWriterAt is an in-memory io.WriterAt, for downloading small objects.
+type bufferWriterAt struct {
+	mu sync.Mutex
+	b  []byte
+}
+
+func (b *bufferWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	end := off + int64(len(p))
+	if end > maxSidecarSize {
+		return 0, fmt.Errorf("object larger than %d bytes", maxSidecarSize)
+	}
+	if end > int64(len(b.b)) {
+		b.b = append(b.b, make([]byte, end-int64(len(b.b)))...)
+	}
+	copy(b.b[off:], p)
+	return len(p), nil
+}
+
+// size returns the size of the data to be downloaded, or -1 if the storage
+// client cannot report it. It also resets the progress stats.
+func (d *Downloader) size(ctx context.Context) int64 {
+	total := int64(-1)
+	if sz, ok := d.storageClient.(Sizer); ok {
+		n, err := sz.Size(ctx)
+		if err != nil {
+			d.logger.Printf("failed to get size of data at %v: %s", d.storageClient, err.Error())
+		} else {
+			total = n
+		}
+	}
+	stats.Set(downloadProgressBytes, new(expvar.Int))
+	stats.Set(downloadTotalBytes, expvarInt(total))
+	stats.Set(downloadPercent, new(expvar.Float))
+	stats.Set(downloadRate, new(expvar.Float))
+	return total
+}
+
+// logProgress periodically logs the progress of the download, until done is
+// closed.
+func (d *Downloader) logProgress(cw *countingWriterAt, done <-chan struct{}) {
+	if d.ProgressInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(d.ProgressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			cw.updateStats()
+			if pct, ok := cw.Percent(); ok {
+				d.logger.Printf("downloaded %d of %d bytes (%.1f%%) from %v", cw.Count(), cw.total, pct, d.storageClient)
+			} else {
+				d.logger.Printf("downloaded %d bytes from %v", cw.Count(), d.storageClient)
+			}
+		}
+	}
+}
+
+// countingWriterAt counts the bytes written through it, at offsets to
+// writerAt or sequentially to writer. It is safe for concurrent use, since
+// some storage clients download ranges in parallel.
 type countingWriterAt struct {
 	writerAt io.WriterAt
+	writer   io.Writer
 	count    int64
+	total    int64 // -1 if unknown.
 }
 
 func (c *countingWriterAt) WriteAt(p []byte, off int64) (n int, err error) {
 	n, err = c.writerAt.WriteAt(p, off)
-	c.count += int64(n)
+	atomic.AddInt64(&c.count, int64(n))
 	return
 }
 
-// isGzip returns true if the data in the reader is gzip compressed.
-// It does this by reading the first three bytes of the reader, and checking
-// if they match the gzip magic number. When f is returned it will be
-// positioned at the start of the reader.
-func isGzip(f io.ReadSeeker) (bool, error) {
-	_, err := f.Seek(0, io.SeekStart)
-	if err != nil {
-		return false, err
+func (c *countingWriterAt) Write(p []byte) (n int, err error) {
+	n, err = c.writer.Write(p)
+	atomic.AddInt64(&c.count, int64(n))
+	return
+}
+
+// Count returns the number of bytes written.
+func (c *countingWriterAt) Count() int64 {
+	return atomic.LoadInt64(&c.count)
+}
+
+// Percent returns the percentage of the total bytes written, if the total is
+// known.
+func (c *countingWriterAt) Percent() (float64, bool) {
+	if c.total <= 0 {
+		return 0, false
+	}
+	return 100 * float64(c.Count()) / float64(c.total), true
+}
+
+// updateStats updates the progress stats.
+func (c *countingWriterAt) updateStats() {
+	stats.Set(downloadProgressBytes, expvarInt(c.Count()))
+	if pct, ok := c.Percent(); ok {
+		stats.Set(downloadPercent, expvarFloat(pct))
 	}
-	data := make([]byte, len(gzipMagic))
-	n, err := f.Read(data)
+}
+
+func expvarInt(v int64) *expvar.Int {
+	i := new(expvar.Int)
+	i.Set(v)
+	return i
+}
+
+func expvarFloat(v float64) *expvar.Float {
+	f := new(expvar.Float)
+	f.Set(v)
+	return f
+}
+
+// detectCompression returns the compression format of the data in the
+// reader, detected by the magic number at its start. When f is returned it
+// will be positioned at the start of the reader.
+func detectCompression(f io.ReadSeeker) (string, error) {
+	_, err := f.Seek(0, io.SeekStart)
 	if err != nil {
-		return false, err
+		return "", err
 	}
-	if n != len(gzipMagic) {
-		return false, nil
+	data := make([]byte, len(xzMagic))
+	n, err := io.ReadFull(f, data)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
 	}
+	data = data[:n]
 
 	_, err = f.Seek(0, io.SeekStart)
 	if err != nil {
-		return false, err
+		return "", err
+	}
+
+	return compressionOf(data), nil
+}
+
+// compressionOf returns the compression format of data starting with magic.
+func compressionOf(magic []byte) string {
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return compressionGzip
+	case bytes.HasPrefix(magic, zstdMagic):
+		return compressionZstd
+	case bytes.HasPrefix(magic, xzMagic):
+		return compressionXz
+	}
+	return compressionNone
+}
+
+// permanentError wraps the error of a failed download which must not be
+// retried.
+type permanentError struct {
+	err error
+}
+
+func (p *permanentError) Error() string {
+	return p.err.Error()
+}
+
+func (p *permanentError) Unwrap() error {
+	return p.err
+}
+
+// detectFormat returns the format of the data starting with head.
+func detectFormat(head []byte) string {
+	switch {
+	case db.IsValidSQLiteData(head):
+		return FormatSQLite
+	case db.IsSQLDumpData(head):
+		return FormatSQLDump
 	}
+	return FormatUnknown
+}
+
+// sniffWriter keeps a copy of the start of the data written through it, so
+// that the format of the data can be detected.
+type sniffWriter struct {
+	w    io.Writer
+	head []byte
+}
 
-	return bytes.Equal(gzipMagic, data[0:len(gzipMagic)]), nil
+func (s *sniffWriter) Write(p []byte) (int, error) {
+	if n := maxSniffSize - len(s.head); n > 0 {
+		if n > len(p) {
+			n = len(p)
+		}
+		s.head = append(s.head, p[:n]...)
+	}
+	return s.w.Write(p)
 }
@@ -4,11 +4,22 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"expvar"
 	"fmt"
 	"io"
+	"net"
+	"os"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/rqlite/rqlite/auto"
+	"github.com/rqlite/rqlite/auto/encryption"
+	"github.com/ulikunitz/xz"
 )
 
 func TestDownloader_Do(t *testing.T) {
@@ -45,6 +56,7 @@ func TestDownloader_Do(t *testing.T) {
 				mockClient.Compress()
 			}
 			downloader := NewDownloader(mockClient)
+			downloader.RetryBackoff = time.Millisecond
 
 			f := new(bytes.Buffer)
 			err := downloader.Do(context.Background(), f, 5*time.Second)
@@ -67,6 +79,382 @@ func TestDownloader_Do(t *testing.T) {
 	}
 }
 
+func TestDownloader_Decompress(t *testing.T) {
+	data := bytes.Repeat([]byte("test data"), 100)
+
+	var zbuf bytes.Buffer
+	zw, err := zstd.NewWriter(&zbuf)
+	if err != nil {
+		t.Fatalf("failed to create zstd writer: %v", err)
+	}
+	zw.Write(data)
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to compress data with zstd: %v", err)
+	}
+
+	var xbuf bytes.Buffer
+	xw, err := xz.NewWriter(&xbuf)
+	if err != nil {
+		t.Fatalf("failed to create xz writer: %v", err)
+	}
+	xw.Write(data)
+	if err := xw.Close(); err != nil {
+		t.Fatalf("failed to compress data with xz: %v", err)
+	}
+
+	for name, compressed := range map[string][]byte{
+		compressionZstd: zbuf.Bytes(),
+		compressionXz:   xbuf.Bytes(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			if comp, err := detectCompression(bytes.NewReader(compressed)); err != nil || comp != name {
+				t.Fatalf("wrong compression detected, exp %s, got %s (%v)", name, comp, err)
+			}
+			d := NewDownloader(&mockStorageClient{data: compressed})
+			f := new(bytes.Buffer)
+			if err := d.Do(context.Background(), f, 5*time.Second); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.Equal(data, f.Bytes()) {
+				t.Fatalf("data not decompressed correctly")
+			}
+		})
+	}
+}
+
+func Test_DetectCompression(t *testing.T) {
+	for _, tt := range []struct {
+		data []byte
+		exp  string
+	}{
+		{nil, compressionNone},
+		{[]byte{0x1f}, compressionNone},
+		{[]byte("SQLite format 3\x00"), compressionNone},
+		{[]byte{0x1f, 0x8b, 0x08, 0x00}, compressionGzip},
+		{[]byte{0x28, 0xb5, 0x2f, 0xfd, 0x00}, compressionZstd},
+		{[]byte{0xfd, 0x37, 0x7a, 0x58, 0x5a, 0x00, 0x00}, compressionXz},
+	} {
+		r := bytes.NewReader(tt.data)
+		comp, err := detectCompression(r)
+		if err != nil {
+			t.Fatalf("failed to detect compression of %v: %v", tt.data, err)
+		}
+		if comp != tt.exp {
+			t.Fatalf("wrong compression detected for %v, exp %q, got %q", tt.data, tt.exp, comp)
+		}
+		if pos, _ := r.Seek(0, io.SeekCurrent); pos != 0 {
+			t.Fatalf("reader not rewound after detecting compression")
+		}
+	}
+}
+
+func TestDownloader_Retry(t *testing.T) {
+	tests := []struct {
+		name        string
+		errs        []error
+		expAttempts int
+		expOK       bool
+	}{
+		{
+			name:        "Transient errors",
+			errs:        []error{context.DeadlineExceeded, &auto.StatusError{Code: 503, Status: "503 Service Unavailable"}},
+			expAttempts: 3,
+			expOK:       true,
+		},
+		{
+			name:        "Too many transient errors",
+			errs:        []error{io.ErrUnexpectedEOF, io.ErrUnexpectedEOF, io.ErrUnexpectedEOF},
+			expAttempts: 3,
+		},
+		{
+			name:        "Permanent error",
+			errs:        []error{fmt.Errorf("failed to download: %w", &auto.StatusError{Code: 404, Status: "404 Not Found"})},
+			expAttempts: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ResetStats()
+			mockClient := &mockFlakyStorageClient{
+				mockStorageClient: mockStorageClient{data: []byte("test data")},
+				errs:              tt.errs,
+			}
+			d := NewDownloader(mockClient)
+			d.RetryBackoff = time.Millisecond
+
+			f := new(bytes.Buffer)
+			err := d.Do(context.Background(), f, 5*time.Second)
+			if tt.expOK && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !tt.expOK && err == nil {
+				t.Fatalf("expected error, got nil")
+			}
+			if mockClient.attempts != tt.expAttempts {
+				t.Fatalf("wrong number of attempts, exp %d, got %d", tt.expAttempts, mockClient.attempts)
+			}
+			if got := stats.Get(numDownloadAttempts).String(); got != fmt.Sprint(tt.expAttempts) {
+				t.Fatalf("wrong attempts stat, exp %d, got %s", tt.expAttempts, got)
+			}
+			if tt.expOK && f.String() != "test data" {
+				t.Fatalf("wrong data downloaded: %s", f.String())
+			}
+		})
+	}
+}
+
+func Test_Retryable(t *testing.T) {
+	for _, tt := range []struct {
+		err error
+		exp bool
+	}{
+		{context.DeadlineExceeded, true},
+		{context.Canceled, false},
+		{&auto.StatusError{Code: 500}, true},
+		{&auto.StatusError{Code: 429}, true},
+		{&auto.StatusError{Code: 403}, false},
+		{&auto.StatusError{Code: 404}, false},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{fmt.Errorf("failed to open: %w", os.ErrNotExist), false},
+		{io.ErrUnexpectedEOF, true},
+	} {
+		if got := retryable(tt.err); got != tt.exp {
+			t.Errorf("wrong retryable for %v, exp %v, got %v", tt.err, tt.exp, got)
+		}
+	}
+}
+
+// mockFlakyStorageClient fails to download with each of its errors in
+// turn, before downloading successfully.
+type mockFlakyStorageClient struct {
+	mockStorageClient
+	errs     []error
+	attempts int
+}
+
+func (m *mockFlakyStorageClient) Download(ctx context.Context, writer io.WriterAt) error {
+	m.attempts++
+	if m.attempts <= len(m.errs) {
+		// Write partial data, which a later attempt must replace.
+		writer.WriteAt([]byte("partial data which is longer"), 0)
+		return m.errs[m.attempts-1]
+	}
+	return m.mockStorageClient.Download(ctx, writer)
+}
+
+func TestDownloader_Progress(t *testing.T) {
+	ResetStats()
+	data := bytes.Repeat([]byte("test data"), 100)
+	d := NewDownloader(&mockSizedStorageClient{
+		mockStorageClient: mockStorageClient{data: data},
+		size:              int64(len(data)),
+	})
+	d.ProgressInterval = time.Millisecond
+	if err := d.Do(context.Background(), new(bytes.Buffer), 5*time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := stats.Get(downloadProgressBytes).String(); got != fmt.Sprint(len(data)) {
+		t.Fatalf("wrong progress bytes, exp %d, got %s", len(data), got)
+	}
+	if got := stats.Get(downloadTotalBytes).String(); got != fmt.Sprint(len(data)) {
+		t.Fatalf("wrong total bytes, exp %d, got %s", len(data), got)
+	}
+	if got := stats.Get(downloadPercent).String(); got != "100" {
+		t.Fatalf("wrong percent, exp 100, got %s", got)
+	}
+	if got := stats.Get(downloadRate).(*expvar.Float).Value(); got <= 0 {
+		t.Fatalf("expected positive download rate, got %f", got)
+	}
+
+	// Total size is unknown if the storage client cannot report it.
+	d = NewDownloader(&mockStorageClient{data: data})
+	if err := d.Do(context.Background(), new(bytes.Buffer), 5*time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := stats.Get(downloadTotalBytes).String(); got != "-1" {
+		t.Fatalf("wrong total bytes, exp -1, got %s", got)
+	}
+	if got := stats.Get(downloadPercent).String(); got != "0" {
+		t.Fatalf("wrong percent, exp 0, got %s", got)
+	}
+}
+
+func TestDownloader_Stream(t *testing.T) {
+	data := []byte("test data, written as it is downloaded")
+	var gz bytes.Buffer
+	gzw := gzip.NewWriter(&gz)
+	gzw.Write(data)
+	gzw.Close()
+	sum := sha256.Sum256(gz.Bytes())
+
+	tests := []struct {
+		name        string
+		data        []byte
+		checksum    string
+		errs        []error
+		partial     bool
+		expAttempts int
+		expOK       bool
+	}{
+		{name: "Uncompressed", data: data, expAttempts: 1, expOK: true},
+		{name: "Compressed, verified", data: gz.Bytes(), checksum: hex.EncodeToString(sum[:]), expAttempts: 1, expOK: true},
+		{name: "Mismatched checksum", data: gz.Bytes(), checksum: strings.Repeat("0", 64), expAttempts: 1},
+		{name: "Retried before data written", data: data, errs: []error{io.ErrUnexpectedEOF}, expAttempts: 2, expOK: true},
+		{name: "Not retried after data written", data: data, errs: []error{io.ErrUnexpectedEOF}, partial: true, expAttempts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ResetStats()
+			mockClient := &mockSequentialStorageClient{
+				mockStorageClient: mockStorageClient{data: tt.data},
+				errs:              tt.errs,
+				partial:           tt.partial,
+			}
+			d := NewDownloader(mockClient)
+			d.Checksum = tt.checksum
+			d.RetryBackoff = time.Millisecond
+
+			buf := new(bytes.Buffer)
+			err := d.Do(context.Background(), buf, 5*time.Second)
+			if tt.expOK && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !tt.expOK && err == nil {
+				t.Fatalf("expected error, got nil")
+			}
+			if mockClient.attempts != tt.expAttempts {
+				t.Fatalf("wrong number of attempts, exp %d, got %d", tt.expAttempts, mockClient.attempts)
+			}
+			if tt.expOK && !bytes.Equal(buf.Bytes(), data) {
+				t.Fatalf("wrong data downloaded: %s", buf.String())
+			}
+			if got := stats.Get(numDownloadsStreamed).String(); got != "1" {
+				t.Fatalf("wrong streamed downloads stat, exp 1, got %s", got)
+			}
+		})
+	}
+}
+
+func TestDownloader_Decrypt(t *testing.T) {
+	data := bytes.Repeat([]byte("test data, encrypted before upload"), 5000)
+	var gz bytes.Buffer
+	gzw := gzip.NewWriter(&gz)
+	gzw.Write(data)
+	gzw.Close()
+
+	enc, err := encryption.NewAESGCM(bytes.Repeat([]byte{1}, encryption.KeySize))
+	if err != nil {
+		t.Fatalf("failed to create encrypter: %v", err)
+	}
+	var encrypted bytes.Buffer
+	ew, err := enc.Encrypt(&encrypted)
+	if err != nil {
+		t.Fatalf("failed to start encryption: %v", err)
+	}
+	ew.Write(gz.Bytes())
+	if err := ew.Close(); err != nil {
+		t.Fatalf("failed to encrypt: %v", err)
+	}
+	// The checksum is of the data as stored, so encrypted.
+	sum := sha256.Sum256(encrypted.Bytes())
+	wrong, _ := encryption.NewAESGCM(bytes.Repeat([]byte{2}, encryption.KeySize))
+
+	for name, newClient := range map[string]func() StorageClient{
+		"Temporary file": func() StorageClient {
+			return &mockStorageClient{data: encrypted.Bytes()}
+		},
+		"Streamed": func() StorageClient {
+			return &mockSequentialStorageClient{mockStorageClient: mockStorageClient{data: encrypted.Bytes()}}
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			d := NewDownloader(newClient())
+			d.Checksum = hex.EncodeToString(sum[:])
+			d.Decrypter = enc
+			buf := new(bytes.Buffer)
+			if err := d.Do(context.Background(), buf, 5*time.Second); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.Equal(buf.Bytes(), data) {
+				t.Fatalf("data not decrypted correctly")
+			}
+
+			d = NewDownloader(newClient())
+			d.Decrypter = wrong
+			d.RetryBackoff = time.Millisecond
+			if err := d.Do(context.Background(), new(bytes.Buffer), 5*time.Second); !errors.Is(err, encryption.ErrDecrypt) {
+				t.Fatalf("expected ErrDecrypt with wrong key, got %v", err)
+			}
+		})
+	}
+}
+
+type mockSequentialStorageClient struct {
+	mockStorageClient
+	errs     []error
+	partial  bool
+	attempts int
+}
+
+func (m *mockSequentialStorageClient) DownloadSequential(ctx context.Context, w io.Writer) error {
+	m.attempts++
+	if m.attempts <= len(m.errs) {
+		if m.partial {
+			w.Write(m.data[:len(m.data)/2])
+		}
+		return m.errs[m.attempts-1]
+	}
+	_, err := w.Write(m.data)
+	return err
+}
+
+func TestDownloader_Format(t *testing.T) {
+	sqlite, err := os.ReadFile("../../store/testdata/load.sqlite")
+	if err != nil {
+		t.Fatalf("failed to read SQLite file: %v", err)
+	}
+	dump := []byte("PRAGMA foreign_keys=OFF;\nBEGIN TRANSACTION;\nCOMMIT;\n")
+
+	tests := []struct {
+		name     string
+		client   StorageClient
+		compress bool
+		exp      string
+	}{
+		{name: "SQLite", client: &mockStorageClient{data: sqlite}, exp: FormatSQLite},
+		{name: "SQL dump", client: &mockStorageClient{data: dump}, exp: FormatSQLDump},
+		{name: "Compressed SQL dump", client: &mockStorageClient{data: dump}, compress: true, exp: FormatSQLDump},
+		{name: "Streamed SQL dump", client: &mockSequentialStorageClient{mockStorageClient: mockStorageClient{data: dump}}, exp: FormatSQLDump},
+		{name: "Unknown", client: &mockStorageClient{data: []byte("test data")}, exp: FormatUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.compress {
+				if err := tt.client.(*mockStorageClient).Compress(); err != nil {
+					t.Fatalf("failed to compress data: %v", err)
+				}
+			}
+			d := NewDownloader(tt.client)
+			if err := d.Do(context.Background(), new(bytes.Buffer), 5*time.Second); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := d.Format(); got != tt.exp {
+				t.Fatalf("wrong format, exp %s, got %s", tt.exp, got)
+			}
+		})
+	}
+}
+
+type mockSizedStorageClient struct {
+	mockStorageClient
+	size int64
+}
+
+func (m *mockSizedStorageClient) Size(ctx context.Context) (int64, error) {
+	return m.size, nil
+}
+
 type mockStorageClient struct {
 	data  []byte
 	error error
@@ -104,3 +492,53 @@ func (m *mockStorageClient) Compress() error {
 func (m *mockStorageClient) String() string {
 	return "mockStorageClient"
 }
+
+func TestDownloader_Checksum(t *testing.T) {
+	data := []byte("test data")
+	sum := sha256.Sum256(data)
+	good := hex.EncodeToString(sum[:])
+	bad := strings.Repeat("0", 64)
+
+	tests := []struct {
+		name     string
+		checksum string
+		sidecar  *mockStorageClient
+		ok       bool
+	}{
+		{name: "Matching checksum", checksum: good, ok: true},
+		{name: "Matching checksum, upper case", checksum: strings.ToUpper(good), ok: true},
+		{name: "Mismatched checksum", checksum: bad},
+		{name: "Matching sidecar", sidecar: &mockStorageClient{data: []byte(good + "  backup.sqlite\n")}, ok: true},
+		{name: "Mismatched sidecar", sidecar: &mockStorageClient{data: []byte(bad + "\n")}},
+		{name: "Invalid sidecar", sidecar: &mockStorageClient{data: []byte("not a checksum\n")}},
+		{name: "Missing sidecar", sidecar: &mockStorageClient{error: errors.New("not found")}},
+		{name: "Sidecar disagrees with checksum", checksum: good, sidecar: &mockStorageClient{data: []byte(bad)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDownloader(&mockStorageClient{data: data})
+			d.Checksum = tt.checksum
+			if tt.sidecar != nil {
+				d.Sidecar = tt.sidecar
+			}
+			buf := new(bytes.Buffer)
+			err := d.Do(context.Background(), buf, 5*time.Second)
+			if tt.ok {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !bytes.Equal(buf.Bytes(), data) {
+					t.Fatalf("expected output data %v, got %v", data, buf.Bytes())
+				}
+			} else if err == nil {
+				t.Fatalf("expected checksum verification to fail")
+			}
+		})
+	}
+
+	d := NewDownloader(&mockStorageClient{data: data})
+	d.Checksum = bad
+	if err := d.Do(context.Background(), new(bytes.Buffer), 5*time.Second); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected ErrChecksumMismatch, got %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package restore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrUnknownFormat is returned when downloaded data is neither a SQLite
+// database nor a SQL dump.
+var ErrUnknownFormat = errors.New("data is neither a SQLite database nor a SQL dump")
+
+// Source is a source from which the data may be downloaded.
+type Source struct {
+	Downloader *Downloader
+	Timeout    time.Duration
+}
+
+// Resetter is an io.Writer which can be emptied, so that the data written
+// by a failed download can be discarded before the next is attempted.
+// *os.File is a Resetter.
+type Resetter interface {
+	io.Writer
+	io.Seeker
+	Truncate(size int64) error
+}
+
+// FallbackDownloader downloads the data from the first of a number of
+// sources which succeeds, trying each in turn.
+type FallbackDownloader struct {
+	sources []*Source
+
+	format string
+	source string
+	logger *log.Logger
+}
+
+// NewFallbackDownloader returns a FallbackDownloader for the given sources,
+// in priority order.
+func NewFallbackDownloader(sources ...*Source) *FallbackDownloader {
+	return &FallbackDownloader{
+		sources: sources,
+		logger:  log.New(os.Stderr, "[downloader] ", log.LstdFlags),
+	}
+}
+
+// Do downloads the data to w from each source in turn, with the source's
+// timeout, until a download succeeds. A download fails over to the next
+// source if it fails, or if the data downloaded is neither a SQLite database
+// nor a SQL dump. Anything written to w by a failed download is discarded.
+// If every source fails, the returned error includes that of each.
+func (f *FallbackDownloader) Do(ctx context.Context, w Resetter) error {
+	if len(f.sources) == 0 {
+		return fmt.Errorf("no source of data is set")
+	}
+	var lastErr error
+	var errs []string
+	for i, src := range f.sources {
+		if i > 0 {
+			stats.Add(numSourceFailovers, 1)
+			if err := reset(w); err != nil {
+				return err
+			}
+		}
+		err := src.Downloader.Do(ctx, w, src.Timeout)
+		if err == nil && src.Downloader.Format() == FormatUnknown {
+			err = ErrUnknownFormat
+		}
+		if err == nil {
+			f.format = src.Downloader.Format()
+			f.source = src.Downloader.storageClient.String()
+			if len(f.sources) > 1 {
+				f.logger.Printf("downloaded data from source %d of %d, %s", i+1, len(f.sources), f.source)
+			}
+			return nil
+		}
+		f.logger.Printf("failed to download data from source %d of %d, %v: %s",
+			i+1, len(f.sources), src.Downloader.storageClient, err.Error())
+		lastErr = err
+		errs = append(errs, fmt.Sprintf("%v: %s", src.Downloader.storageClient, err.Error()))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if len(errs) == 1 {
+		return lastErr
+	}
+	return fmt.Errorf("all %d sources failed: %s", len(f.sources), strings.Join(errs, "; "))
+}
+
+// Format returns the format of the data written by the last successful call
+// to Do, or FormatUnknown if there was none.
+func (f *FallbackDownloader) Format() string {
+	if f.format == "" {
+		return FormatUnknown
+	}
+	return f.format
+}
+
+// Source returns the source from which the last successful call to Do
+// downloaded the data.
+func (f *FallbackDownloader) Source() string {
+	return f.source
+}
+
+func reset(w Resetter) error {
+	if err := w.Truncate(0); err != nil {
+		return err
+	}
+	_, err := w.Seek(0, io.SeekStart)
+	return err
+}
@@ -0,0 +1,96 @@
+package restore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"expvar"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_FallbackDownloader(t *testing.T) {
+	ResetStats()
+	sqlite, err := os.ReadFile("../../store/testdata/load.sqlite")
+	if err != nil {
+		t.Fatalf("failed to read SQLite file: %s", err)
+	}
+	path := filepath.Join(t.TempDir(), "backup.sqlite")
+	if err := os.WriteFile(path, sqlite, 0644); err != nil {
+		t.Fatalf("failed to write backup file: %s", err)
+	}
+
+	failing := NewDownloader(&mockStorageClient{error: os.ErrNotExist})
+	unknown := NewDownloader(&mockStorageClient{data: []byte("not a database at all")})
+	file := NewDownloader(NewFileClient(path))
+	fd := NewFallbackDownloader(
+		&Source{Downloader: failing, Timeout: time.Second},
+		&Source{Downloader: unknown, Timeout: time.Second},
+		&Source{Downloader: file, Timeout: time.Second},
+	)
+
+	f, err := os.CreateTemp(t.TempDir(), "restore")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %s", err)
+	}
+	defer f.Close()
+	if err := fd.Do(context.Background(), f); err != nil {
+		t.Fatalf("failed to download: %s", err)
+	}
+	if exp, got := FormatSQLite, fd.Format(); exp != got {
+		t.Fatalf("expected format %s, got %s", exp, got)
+	}
+	if exp, got := "file://"+path, fd.Source(); exp != got {
+		t.Fatalf("expected source %s, got %s", exp, got)
+	}
+	got, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatalf("failed to read downloaded file: %s", err)
+	}
+	if !bytes.Equal(got, sqlite) {
+		t.Fatalf("downloaded data does not match, data from failed sources was not discarded")
+	}
+	if exp, got := int64(2), stats.Get(numSourceFailovers).(*expvar.Int).Value(); exp != got {
+		t.Fatalf("expected %d failovers, got %d", exp, got)
+	}
+}
+
+func Test_FallbackDownloaderAllFail(t *testing.T) {
+	fd := NewFallbackDownloader(
+		&Source{Downloader: NewDownloader(&mockStorageClient{error: os.ErrNotExist}), Timeout: time.Second},
+		&Source{Downloader: NewDownloader(NewFileClient(filepath.Join(t.TempDir(), "missing"))), Timeout: time.Second},
+	)
+	f, err := os.CreateTemp(t.TempDir(), "restore")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %s", err)
+	}
+	defer f.Close()
+	err = fd.Do(context.Background(), f)
+	if err == nil {
+		t.Fatalf("expected error when all sources fail")
+	}
+	if !strings.Contains(err.Error(), "all 2 sources failed") {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if fd.Format() != FormatUnknown {
+		t.Fatalf("expected unknown format, got %s", fd.Format())
+	}
+}
+
+func Test_FallbackDownloaderSingle(t *testing.T) {
+	fd := NewFallbackDownloader(&Source{
+		Downloader: NewDownloader(&mockStorageClient{data: []byte("not a database at all")}),
+		Timeout:    time.Second,
+	})
+	f, err := os.CreateTemp(t.TempDir(), "restore")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %s", err)
+	}
+	defer f.Close()
+	if err := fd.Do(context.Background(), f); !errors.Is(err, ErrUnknownFormat) {
+		t.Fatalf("expected ErrUnknownFormat, got %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package restore
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/rqlite/rqlite/internal/offset"
+)
+
+// FileConfig is the subconfig for the file storage type.
+type FileConfig struct {
+	Path string `json:"path"`
+}
+
+// FileClient is a client for "downloading" data from a file on the local
+// filesystem, such as a copy of a backup on a mounted volume.
+type FileClient struct {
+	path string
+}
+
+// NewFileClient returns an instance of a FileClient.
+func NewFileClient(path string) *FileClient {
+	return &FileClient{path: path}
+}
+
+// String returns a string representation of the FileClient.
+func (f *FileClient) String() string {
+	return "file://" + f.path
+}
+
+// Size returns the size of the file.
+func (f *FileClient) Size(ctx context.Context) (int64, error) {
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// Download copies the file to writer.
+func (f *FileClient) Download(ctx context.Context, writer io.WriterAt) error {
+	return f.DownloadSequential(ctx, offset.NewWriter(writer))
+}
+
+// DownloadSequential copies the file to w in order.
+func (f *FileClient) DownloadSequential(ctx context.Context, w io.Writer) error {
+	fd, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	_, err = io.Copy(w, &ctxReader{ctx: ctx, r: fd})
+	return err
+}
+
+// ctxReader is an io.Reader which fails once its context is done, so that
+// copying a large file respects the download timeout.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
@@ -0,0 +1,138 @@
+package restore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func Test_URLClientDownload(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/db.sqlite" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("unexpected Authorization header: %q", got)
+		}
+		if got := r.Header.Get("X-Custom"); got != "value" {
+			t.Errorf("custom header not sent, got %q", got)
+		}
+		w.Write([]byte("test data"))
+	}))
+	defer ts.Close()
+
+	c := NewURLClient(ts.URL+"/db.sqlite", "", "", "secret", map[string]string{"X-Custom": "value"})
+	c.client = ts.Client()
+	if got := mustURLDownload(t, c); got != "test data" {
+		t.Fatalf("unexpected downloaded data %q", got)
+	}
+}
+
+func Test_URLClientDownloadBasicAuth(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != "user1" || password != "pass1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("test data"))
+	}))
+	defer ts.Close()
+
+	c := NewURLClient(ts.URL, "user1", "pass1", "", nil)
+	c.client = ts.Client()
+	if got := mustURLDownload(t, c); got != "test data" {
+		t.Fatalf("unexpected downloaded data %q", got)
+	}
+
+	c = NewURLClient(ts.URL, "user1", "wrong", "", nil)
+	c.client = ts.Client()
+	if err := c.Download(context.Background(), mustTempFileURL(t)); err == nil {
+		t.Fatalf("expected error downloading with wrong credentials")
+	}
+}
+
+func Test_URLClientDownloadContentEncoding(t *testing.T) {
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	gzw.Write([]byte("test data"))
+	gzw.Close()
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/unsupported" {
+			w.Header().Set("Content-Encoding", "br")
+		} else {
+			w.Header().Set("Content-Encoding", "gzip")
+		}
+		w.Write(buf.Bytes())
+	}))
+	defer ts.Close()
+
+	c := NewURLClient(ts.URL, "", "", "", nil)
+	c.client = ts.Client()
+	if got := mustURLDownload(t, c); got != "test data" {
+		t.Fatalf("unexpected downloaded data %q", got)
+	}
+
+	c = NewURLClient(ts.URL+"/unsupported", "", "", "", nil)
+	c.client = ts.Client()
+	if err := c.Download(context.Background(), mustTempFileURL(t)); err == nil {
+		t.Fatalf("expected error downloading with unsupported content encoding")
+	}
+}
+
+func Test_URLClientSize(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			t.Errorf("expected HEAD, got %s", r.Method)
+		}
+		if r.URL.Path == "/unknown" {
+			w.Header().Set("Content-Encoding", "gzip")
+		}
+		w.Header().Set("Content-Length", "1234")
+	}))
+	defer ts.Close()
+
+	c := NewURLClient(ts.URL, "", "", "", nil)
+	c.client = ts.Client()
+	n, err := c.Size(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get size: %s", err.Error())
+	}
+	if n != 1234 {
+		t.Fatalf("expected size to be 1234, got %d", n)
+	}
+
+	c = NewURLClient(ts.URL+"/unknown", "", "", "", nil)
+	c.client = ts.Client()
+	if _, err := c.Size(context.Background()); err == nil {
+		t.Fatalf("expected error getting size of encoded data")
+	}
+}
+
+func mustURLDownload(t *testing.T, c *URLClient) string {
+	t.Helper()
+	f := mustTempFileURL(t)
+	if err := c.Download(context.Background(), f); err != nil {
+		t.Fatalf("failed to download: %s", err.Error())
+	}
+	b, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatalf("failed to read downloaded file: %s", err.Error())
+	}
+	return string(b)
+}
+
+func mustTempFileURL(t *testing.T) *os.File {
+	t.Helper()
+	f, err := os.CreateTemp(t.TempDir(), "rqlite-url-test")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %s", err.Error())
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
@@ -9,6 +9,34 @@ import (
 const (
 	// Version is the max version of the config file format supported
 	Version = 1
+
+	// DeltaSuffix is appended to the name of a full backup to give the name
+	// of the delta of an incremental backup, which is stored alongside it.
+	DeltaSuffix = ".delta"
+
+	// SnapshotSuffix is appended to the name of a backup to give the name to
+	// which Raft snapshots are offloaded, if no other name is set.
+	SnapshotSuffix = ".snapshot"
+
+	// StorageTypeS3 is the storage type for Amazon S3, and S3-compatible services.
+	StorageTypeS3 StorageType = "s3"
+
+	// StorageTypeGCS is the storage type for Google Cloud Storage.
+	StorageTypeGCS StorageType = "gcs"
+
+	// StorageTypeAzure is the storage type for Azure Blob Storage.
+	StorageTypeAzure StorageType = "azure"
+
+	// StorageTypeSFTP is the storage type for SFTP servers.
+	StorageTypeSFTP StorageType = "sftp"
+
+	// StorageTypeURL is the storage type for plain HTTPS URLs. It is only
+	// supported by auto-restore.
+	StorageTypeURL StorageType = "url"
+
+	// StorageTypeFile is the storage type for files on the local filesystem.
+	// It is only supported by auto-restore.
+	StorageTypeFile StorageType = "file"
 )
 
 var (
@@ -19,6 +47,23 @@ var (
 	ErrUnsupportedStorageType = errors.New("unsupported storage type")
 )
 
+// StatusError is returned by storage clients when a storage service responds
+// to a request with an unsuccessful HTTP status.
+type StatusError struct {
+	Code   int
+	Status string
+}
+
+// Error returns the status of the response.
+func (e *StatusError) Error() string {
+	return e.Status
+}
+
+// StatusCode returns the HTTP status code of the response.
+func (e *StatusError) StatusCode() int {
+	return e.Code
+}
+
 // Duration is a wrapper around time.Duration that allows us to unmarshal
 type Duration time.Duration
 
@@ -61,10 +106,11 @@ func (s *StorageType) UnmarshalJSON(b []byte) error {
 	switch value := v.(type) {
 	case string:
 		*s = StorageType(value)
-		if *s != "s3" {
-			return ErrUnsupportedStorageType
+		switch *s {
+		case StorageTypeS3, StorageTypeGCS, StorageTypeAzure, StorageTypeSFTP, StorageTypeURL, StorageTypeFile:
+			return nil
 		}
-		return nil
+		return ErrUnsupportedStorageType
 	default:
 		return ErrUnsupportedStorageType
 	}
@@ -0,0 +1,158 @@
+// Package webhook notifies an HTTP endpoint of auto-backup and auto-restore
+// events, so that operators can alert on them without scraping expvar.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/rqlite/rqlite/auto"
+)
+
+// DefaultTimeout is the default timeout of a notification.
+const DefaultTimeout = 10 * time.Second
+
+// Event types.
+const (
+	EventBackupSucceeded  = "backup_succeeded"
+	EventBackupFailed     = "backup_failed"
+	EventRestoreSucceeded = "restore_succeeded"
+	EventRestoreFailed    = "restore_failed"
+)
+
+// stats captures stats for notifications.
+var stats *expvar.Map
+
+const (
+	numNotificationsOK   = "num_notifications_ok"
+	numNotificationsFail = "num_notifications_fail"
+)
+
+func init() {
+	stats = expvar.NewMap("webhook")
+	ResetStats()
+}
+
+// ResetStats resets the expvar stats for this module. Mostly for test purposes.
+func ResetStats() {
+	stats.Init()
+	stats.Add(numNotificationsOK, 0)
+	stats.Add(numNotificationsFail, 0)
+}
+
+// Config is the config of a webhook, as set in the auto-backup and
+// auto-restore config files.
+type Config struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Timeout auto.Duration     `json:"timeout,omitempty"`
+}
+
+// Event is the JSON payload POSTed to the webhook.
+type Event struct {
+	Type       string    `json:"type"`
+	NodeID     string    `json:"node_id"`
+	Timestamp  time.Time `json:"timestamp"`
+	DurationMS int64     `json:"duration_ms"`
+	Bytes      int64     `json:"bytes"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// NewEvent returns an event of the given type, for an operation which took
+// d and transferred n bytes. If err is not nil, it is the event's error.
+func NewEvent(typ string, d time.Duration, n int64, err error) *Event {
+	ev := &Event{
+		Type:       typ,
+		DurationMS: d.Milliseconds(),
+		Bytes:      n,
+	}
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	return ev
+}
+
+// Notifier POSTs events to a webhook.
+type Notifier struct {
+	url     string
+	host    string
+	headers map[string]string
+	nodeID  string
+	client  *http.Client
+}
+
+// NewNotifier returns a Notifier for the webhook configured by cfg, for
+// events on the node with ID nodeID.
+func NewNotifier(cfg *Config, nodeID string) (*Notifier, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook URL: %s", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("webhook URL scheme %q is not http or https", u.Scheme)
+	}
+	timeout := time.Duration(cfg.Timeout)
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	return &Notifier{
+		url:     cfg.URL,
+		host:    u.Scheme + "://" + u.Host,
+		headers: cfg.Headers,
+		nodeID:  nodeID,
+		client:  &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// Notify POSTs ev to the webhook, setting its node ID and timestamp. Any
+// response other than 2xx is an error.
+func (n *Notifier) Notify(ctx context.Context, ev *Event) (retErr error) {
+	defer func() {
+		if retErr != nil {
+			stats.Add(numNotificationsFail, 1)
+		} else {
+			stats.Add(numNotificationsOK, 1)
+		}
+	}()
+
+	ev.NodeID = n.nodeID
+	if ev.Timestamp.IsZero() {
+		ev.Timestamp = time.Now().UTC()
+	}
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range n.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &auto.StatusError{Code: resp.StatusCode, Status: resp.Status}
+	}
+	return nil
+}
+
+// String returns a string representation of the Notifier. Only the scheme
+// and host of the URL are included, since the rest may hold a secret.
+func (n *Notifier) String() string {
+	return n.host
+}
@@ -0,0 +1,103 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rqlite/rqlite/auto"
+)
+
+func Test_NewNotifierBadURL(t *testing.T) {
+	if _, err := NewNotifier(&Config{URL: "ftp://example.com/hook"}, "node1"); err == nil {
+		t.Fatalf("expected error for non-HTTP URL")
+	}
+	if _, err := NewNotifier(&Config{URL: "://"}, "node1"); err == nil {
+		t.Fatalf("expected error for invalid URL")
+	}
+}
+
+func Test_NotifierNotify(t *testing.T) {
+	ResetStats()
+	var got Event
+	var gotHeader, gotContentType string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("expected POST, got %s", r.Method)
+		}
+		gotHeader = r.Header.Get("X-Token")
+		gotContentType = r.Header.Get("Content-Type")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("failed to decode event: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	n, err := NewNotifier(&Config{
+		URL:     ts.URL + "/hook?secret=abc",
+		Headers: map[string]string{"X-Token": "1234"},
+	}, "node1")
+	if err != nil {
+		t.Fatalf("failed to create notifier: %s", err)
+	}
+	if n.String() != ts.URL {
+		t.Fatalf("expected string %s, got %s", ts.URL, n.String())
+	}
+
+	ev := NewEvent(EventBackupFailed, 1500*time.Millisecond, 100, errors.New("upload failed"))
+	if err := n.Notify(context.Background(), ev); err != nil {
+		t.Fatalf("failed to notify: %s", err)
+	}
+	if got.Type != EventBackupFailed {
+		t.Fatalf("expected type %s, got %s", EventBackupFailed, got.Type)
+	}
+	if got.NodeID != "node1" {
+		t.Fatalf("expected node ID node1, got %s", got.NodeID)
+	}
+	if got.DurationMS != 1500 {
+		t.Fatalf("expected duration 1500ms, got %d", got.DurationMS)
+	}
+	if got.Bytes != 100 {
+		t.Fatalf("expected 100 bytes, got %d", got.Bytes)
+	}
+	if got.Error != "upload failed" {
+		t.Fatalf("expected error 'upload failed', got %s", got.Error)
+	}
+	if got.Timestamp.IsZero() {
+		t.Fatalf("expected timestamp to be set")
+	}
+	if gotHeader != "1234" {
+		t.Fatalf("expected header 1234, got %s", gotHeader)
+	}
+	if gotContentType != "application/json" {
+		t.Fatalf("expected content type application/json, got %s", gotContentType)
+	}
+	if v := stats.Get(numNotificationsOK).String(); v != "1" {
+		t.Fatalf("expected 1 notification OK, got %s", v)
+	}
+}
+
+func Test_NotifierNotifyFail(t *testing.T) {
+	ResetStats()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	n, err := NewNotifier(&Config{URL: ts.URL}, "node1")
+	if err != nil {
+		t.Fatalf("failed to create notifier: %s", err)
+	}
+	err = n.Notify(context.Background(), NewEvent(EventRestoreSucceeded, time.Second, 0, nil))
+	var se *auto.StatusError
+	if !errors.As(err, &se) || se.StatusCode() != http.StatusServiceUnavailable {
+		t.Fatalf("expected status error 503, got %v", err)
+	}
+	if v := stats.Get(numNotificationsFail).String(); v != "1" {
+		t.Fatalf("expected 1 notification failure, got %s", v)
+	}
+}
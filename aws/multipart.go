@@ -0,0 +1,316 @@
+package aws
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"expvar"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	// DefaultPartSize is the default size of each part of a multipart upload.
+	DefaultPartSize = 16 * 1024 * 1024
+
+	// MinPartSize is the minimum size of each part of a multipart upload,
+	// other than the last, allowed by S3.
+	MinPartSize = 5 * 1024 * 1024
+
+	// DefaultConcurrency is the default number of parts of a multipart upload
+	// which are uploaded at once.
+	DefaultConcurrency = 4
+
+	// maxParts is the maximum number of parts in a multipart upload allowed
+	// by S3.
+	maxParts = 10000
+)
+
+// stats captures stats for S3 uploads.
+var stats *expvar.Map
+
+const (
+	numMultipartUploads = "num_multipart_uploads"
+	numMultipartResumed = "num_multipart_resumed"
+	numPartsUploaded    = "num_parts_uploaded"
+	numPartsSkipped     = "num_parts_skipped"
+	numPartsFailed      = "num_parts_failed"
+	totalPartBytes      = "total_part_bytes"
+	currentUploadParts  = "current_upload_parts"
+	currentUploadBytes  = "current_upload_bytes"
+)
+
+func init() {
+	stats = expvar.NewMap("s3")
+	ResetStats()
+}
+
+// ResetStats resets the expvar stats for this module. Mostly for test purposes.
+func ResetStats() {
+	stats.Init()
+	stats.Add(numMultipartUploads, 0)
+	stats.Add(numMultipartResumed, 0)
+	stats.Add(numPartsUploaded, 0)
+	stats.Add(numPartsSkipped, 0)
+	stats.Add(numPartsFailed, 0)
+	stats.Add(totalPartBytes, 0)
+	stats.Add(currentUploadParts, 0)
+	stats.Add(currentUploadBytes, 0)
+}
+
+// SetMultipart sets the size of each part of multipart uploads, and the number
+// of parts uploaded at once. Data larger than a single part is uploaded in
+// parts. Zero values leave the respective setting unchanged.
+func (s *S3Client) SetMultipart(partSize int64, concurrency int) error {
+	if partSize < 0 || (partSize > 0 && partSize < MinPartSize) {
+		return fmt.Errorf("part size must be at least %d bytes", MinPartSize)
+	}
+	if concurrency < 0 {
+		return fmt.Errorf("invalid concurrency %d", concurrency)
+	}
+	if partSize > 0 {
+		s.partSize = partSize
+	}
+	if concurrency > 0 {
+		s.concurrency = concurrency
+	}
+	return nil
+}
+
+// uploadMultipart uploads the data read from reader to key in parts, the first
+// of which has already been read. If an earlier multipart upload to the key
+// was interrupted, it is resumed, with parts which were uploaded and are
+// unchanged not uploaded again. If this upload fails it is not aborted, so that
+// it may be resumed in turn.
+func (s *S3Client) uploadMultipart(ctx context.Context, key string, first []byte, reader io.Reader) error {
+	mp, err := s.multipartAPI()
+	if err != nil {
+		return err
+	}
+	stats.Add(numMultipartUploads, 1)
+	stats.Get(currentUploadParts).(*expvar.Int).Set(0)
+	stats.Get(currentUploadBytes).(*expvar.Int).Set(0)
+
+	uploadID, uploaded, err := s.resumableUpload(ctx, mp, key)
+	if err != nil {
+		return err
+	}
+	if uploadID == "" {
+		out, err := mp.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create multipart upload: %w", err)
+		}
+		uploadID = aws.StringValue(out.UploadId)
+	} else {
+		stats.Add(numMultipartResumed, 1)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var mu sync.Mutex
+	var completed []*s3.CompletedPart
+	var uploadErr error
+	setErr := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if uploadErr == nil {
+			uploadErr = err
+			cancel()
+		}
+	}
+	complete := func(n int64, etag string, sz int) {
+		mu.Lock()
+		defer mu.Unlock()
+		completed = append(completed, &s3.CompletedPart{
+			PartNumber: aws.Int64(n),
+			ETag:       aws.String(etag),
+		})
+		stats.Add(currentUploadParts, 1)
+		stats.Add(currentUploadBytes, int64(sz))
+	}
+
+	type part struct {
+		num  int64
+		data []byte
+		sum  []byte
+	}
+	parts := make(chan part)
+	var wg sync.WaitGroup
+	for i := 0; i < s.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range parts {
+				out, err := mp.UploadPartWithContext(ctx, &s3.UploadPartInput{
+					Bucket:     aws.String(s.bucket),
+					Key:        aws.String(key),
+					UploadId:   aws.String(uploadID),
+					PartNumber: aws.Int64(p.num),
+					Body:       bytes.NewReader(p.data),
+					ContentMD5: aws.String(base64.StdEncoding.EncodeToString(p.sum)),
+				})
+				if err != nil {
+					stats.Add(numPartsFailed, 1)
+					setErr(fmt.Errorf("failed to upload part %d: %w", p.num, err))
+					continue
+				}
+				stats.Add(numPartsUploaded, 1)
+				stats.Add(totalPartBytes, int64(len(p.data)))
+				complete(p.num, aws.StringValue(out.ETag), len(p.data))
+			}
+		}()
+	}
+
+	// Parts are read one after another, each into its own buffer, as the
+	// reader need not support seeking.
+	func() {
+		defer close(parts)
+		data := first
+		for n := int64(1); ; n++ {
+			if n > maxParts {
+				setErr(fmt.Errorf("data exceeds %d parts of %d bytes", maxParts, s.partSize))
+				return
+			}
+			sum := md5.Sum(data)
+			if etag, ok := uploaded[n]; ok && etag == partETag(sum[:]) {
+				stats.Add(numPartsSkipped, 1)
+				complete(n, etag, len(data))
+			} else {
+				select {
+				case parts <- part{num: n, data: data, sum: sum[:]}:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			data = make([]byte, s.partSize)
+			sz, err := io.ReadFull(reader, data)
+			if err == io.EOF {
+				return
+			} else if err != nil && err != io.ErrUnexpectedEOF {
+				setErr(fmt.Errorf("failed to read part %d: %w", n+1, err))
+				return
+			}
+			data = data[:sz]
+		}
+	}()
+	wg.Wait()
+	if uploadErr != nil {
+		return fmt.Errorf("multipart upload %s interrupted: %w", uploadID, uploadErr)
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("multipart upload %s interrupted: %w", uploadID, err)
+	}
+
+	sort.Slice(completed, func(i, j int) bool {
+		return aws.Int64Value(completed[i].PartNumber) < aws.Int64Value(completed[j].PartNumber)
+	})
+	_, err = mp.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to complete multipart upload %s: %w", uploadID, err)
+	}
+	return nil
+}
+
+// resumableUpload returns the ID of the most recently started multipart
+// upload to key which has not completed, and the ETags of the parts uploaded
+// so far, by part number. An empty ID is returned if there is no such upload.
+// Any other incomplete uploads to key are aborted.
+func (s *S3Client) resumableUpload(ctx context.Context, mp multipart, key string) (string, map[int64]string, error) {
+	out, err := mp.ListMultipartUploadsWithContext(ctx, &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(key),
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to list multipart uploads: %w", err)
+	}
+	var latest *s3.MultipartUpload
+	for _, u := range out.Uploads {
+		if aws.StringValue(u.Key) != key {
+			continue
+		}
+		if latest != nil && !aws.TimeValue(u.Initiated).After(aws.TimeValue(latest.Initiated)) {
+			s.abortUpload(ctx, mp, key, u.UploadId)
+			continue
+		}
+		if latest != nil {
+			s.abortUpload(ctx, mp, key, latest.UploadId)
+		}
+		latest = u
+	}
+	if latest == nil {
+		return "", nil, nil
+	}
+
+	uploadID := aws.StringValue(latest.UploadId)
+	uploaded := make(map[int64]string)
+	err = mp.ListPartsPagesWithContext(ctx, &s3.ListPartsInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	}, func(page *s3.ListPartsOutput, lastPage bool) bool {
+		for _, p := range page.Parts {
+			uploaded[aws.Int64Value(p.PartNumber)] = aws.StringValue(p.ETag)
+		}
+		return true
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", nil, err
+		}
+		// The upload may have completed or been aborted since it was
+		// listed, so start another.
+		return "", nil, nil
+	}
+	return uploadID, uploaded, nil
+}
+
+func (s *S3Client) abortUpload(ctx context.Context, mp multipart, key string, uploadID *string) {
+	mp.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: uploadID,
+	})
+}
+
+func (s *S3Client) multipartAPI() (multipart, error) {
+	if s.multipart != nil {
+		return s.multipart, nil
+	}
+	sess, err := s.createSession()
+	if err != nil {
+		return nil, err
+	}
+	return s3.New(sess), nil
+}
+
+// partETag returns the ETag S3 assigns to a part with the given MD5 sum.
+func partETag(sum []byte) string {
+	return `"` + hex.EncodeToString(sum) + `"`
+}
+
+type multipart interface {
+	CreateMultipartUploadWithContext(ctx aws.Context, input *s3.CreateMultipartUploadInput, opts ...request.Option) (*s3.CreateMultipartUploadOutput, error)
+	UploadPartWithContext(ctx aws.Context, input *s3.UploadPartInput, opts ...request.Option) (*s3.UploadPartOutput, error)
+	CompleteMultipartUploadWithContext(ctx aws.Context, input *s3.CompleteMultipartUploadInput, opts ...request.Option) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUploadWithContext(ctx aws.Context, input *s3.AbortMultipartUploadInput, opts ...request.Option) (*s3.AbortMultipartUploadOutput, error)
+	ListMultipartUploadsWithContext(ctx aws.Context, input *s3.ListMultipartUploadsInput, opts ...request.Option) (*s3.ListMultipartUploadsOutput, error)
+	ListPartsPagesWithContext(ctx aws.Context, input *s3.ListPartsInput, fn func(*s3.ListPartsOutput, bool) bool, opts ...request.Option) error
+}
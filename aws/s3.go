@@ -1,15 +1,20 @@
 package aws
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
+	"net/url"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/rqlite/rqlite/auto"
 )
 
 // S3Config is the subconfig for the S3 storage type
@@ -20,6 +25,12 @@ type S3Config struct {
 	SecretAccessKey string `json:"secret_access_key"`
 	Bucket          string `json:"bucket"`
 	Path            string `json:"path"`
+
+	// PartSize is the size in bytes of each part of a multipart upload, and
+	// Concurrency the number of parts uploaded at once. If not set
+	// DefaultPartSize and DefaultConcurrency are used.
+	PartSize    int64 `json:"part_size,omitempty"`
+	Concurrency int   `json:"concurrency,omitempty"`
 }
 
 // S3Client is a client for uploading data to S3.
@@ -31,9 +42,15 @@ type S3Client struct {
 	bucket    string
 	key       string
 
+	partSize    int64
+	concurrency int
+
 	// These fields are used for testing via dependency injection.
 	uploader   uploader
 	downloader downloader
+	header     header
+	objects    objects
+	multipart  multipart
 }
 
 // NewS3Client returns an instance of an S3Client.
@@ -45,6 +62,9 @@ func NewS3Client(endpoint, region, accessKey, secretKey, bucket, key string) *S3
 		secretKey: secretKey,
 		bucket:    bucket,
 		key:       key,
+
+		partSize:    DefaultPartSize,
+		concurrency: DefaultConcurrency,
 	}
 }
 
@@ -55,6 +75,39 @@ func (s *S3Client) String() string {
 
 // Upload uploads data to S3.
 func (s *S3Client) Upload(ctx context.Context, reader io.Reader) error {
+	return s.upload(ctx, s.key, reader)
+}
+
+// Name returns the key to which data is uploaded.
+func (s *S3Client) Name() string {
+	return s.key
+}
+
+// UploadTo uploads data to S3, at the given key rather than the client's own.
+func (s *S3Client) UploadTo(ctx context.Context, key string, reader io.Reader) error {
+	return s.upload(ctx, key, reader)
+}
+
+// UploadDelta uploads the delta of an incremental backup to S3, alongside
+// the full backup.
+func (s *S3Client) UploadDelta(ctx context.Context, reader io.Reader) error {
+	return s.upload(ctx, s.key+auto.DeltaSuffix, reader)
+}
+
+// upload uploads the data read from reader to key. Data larger than a single
+// part is uploaded in parts, so that an interrupted upload may be resumed.
+func (s *S3Client) upload(ctx context.Context, key string, reader io.Reader) error {
+	if s.partSize > 0 {
+		first := make([]byte, s.partSize)
+		n, err := io.ReadFull(reader, first)
+		if err == nil {
+			return s.uploadMultipart(ctx, key, first, reader)
+		} else if err != io.EOF && err != io.ErrUnexpectedEOF {
+			return fmt.Errorf("failed to read data for s3://%s/%s: %w", s.bucket, key, err)
+		}
+		reader = bytes.NewReader(first[:n])
+	}
+
 	sess, err := s.createSession()
 	if err != nil {
 		return err
@@ -70,11 +123,11 @@ func (s *S3Client) Upload(ctx context.Context, reader io.Reader) error {
 
 	_, err = uploader.UploadWithContext(ctx, &s3manager.UploadInput{
 		Bucket: aws.String(s.bucket),
-		Key:    aws.String(s.key),
+		Key:    aws.String(key),
 		Body:   reader,
 	})
 	if err != nil {
-		return fmt.Errorf("failed to upload to %v: %w", s, err)
+		return fmt.Errorf("failed to upload to s3://%s/%s: %w", s.bucket, key, err)
 	}
 
 	return nil
@@ -106,6 +159,101 @@ func (s *S3Client) Download(ctx context.Context, writer io.WriterAt) error {
 	return nil
 }
 
+// Size returns the size of the object in S3.
+func (s *S3Client) Size(ctx context.Context) (int64, error) {
+	var h header
+	if s.header == nil {
+		sess, err := s.createSession()
+		if err != nil {
+			return 0, err
+		}
+		h = s3.New(sess)
+	} else {
+		h = s.header
+	}
+
+	out, err := h.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get size of %v: %w", s, err)
+	}
+	return aws.Int64Value(out.ContentLength), nil
+}
+
+// CopyVersion copies the object in S3 to the given version of it, which is
+// stored alongside it.
+func (s *S3Client) CopyVersion(ctx context.Context, version string) error {
+	o, err := s.objectsAPI()
+	if err != nil {
+		return err
+	}
+	_, err = o.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(s.bucket),
+		Key:        aws.String(s.versionKey(version)),
+		CopySource: aws.String(url.PathEscape(s.bucket + "/" + s.key)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to copy %v to version %s: %w", s, version, err)
+	}
+	return nil
+}
+
+// ListVersions returns the versions of the object stored in S3.
+func (s *S3Client) ListVersions(ctx context.Context) ([]string, error) {
+	o, err := s.objectsAPI()
+	if err != nil {
+		return nil, err
+	}
+	prefix := s.versionKey("")
+	var versions []string
+	err = o.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			versions = append(versions, strings.TrimPrefix(aws.StringValue(obj.Key), prefix))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list versions of %v: %w", s, err)
+	}
+	return versions, nil
+}
+
+// DeleteVersion deletes the given version of the object from S3.
+func (s *S3Client) DeleteVersion(ctx context.Context, version string) error {
+	o, err := s.objectsAPI()
+	if err != nil {
+		return err
+	}
+	_, err = o.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.versionKey(version)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete version %s of %v: %w", version, s, err)
+	}
+	return nil
+}
+
+func (s *S3Client) versionKey(version string) string {
+	return s.key + "." + version
+}
+
+func (s *S3Client) objectsAPI() (objects, error) {
+	if s.objects != nil {
+		return s.objects, nil
+	}
+	sess, err := s.createSession()
+	if err != nil {
+		return nil, err
+	}
+	return s3.New(sess), nil
+}
+
 func (s *S3Client) createSession() (*session.Session, error) {
 	sess, err := session.NewSession(&aws.Config{
 		Endpoint:    aws.String(s.endpoint),
@@ -125,3 +273,13 @@ type uploader interface {
 type downloader interface {
 	DownloadWithContext(ctx aws.Context, w io.WriterAt, input *s3.GetObjectInput, opts ...func(*s3manager.Downloader)) (n int64, err error)
 }
+
+type header interface {
+	HeadObjectWithContext(ctx aws.Context, input *s3.HeadObjectInput, opts ...request.Option) (*s3.HeadObjectOutput, error)
+}
+
+type objects interface {
+	CopyObjectWithContext(ctx aws.Context, input *s3.CopyObjectInput, opts ...request.Option) (*s3.CopyObjectOutput, error)
+	ListObjectsV2PagesWithContext(ctx aws.Context, input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool, opts ...request.Option) error
+	DeleteObjectWithContext(ctx aws.Context, input *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, error)
+}
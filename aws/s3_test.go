@@ -3,12 +3,17 @@ package aws
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"expvar"
 	"fmt"
 	"io"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
@@ -88,6 +93,66 @@ func TestS3ClientUploadOK(t *testing.T) {
 	}
 }
 
+func TestS3ClientUploadDelta(t *testing.T) {
+	var uploadedKey string
+	client := &S3Client{
+		region: "us-west-2",
+		bucket: "your-bucket",
+		key:    "your/key/path",
+		uploader: &mockUploader{
+			uploadFn: func(ctx aws.Context, input *s3manager.UploadInput, opts ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+				uploadedKey = *input.Key
+				return &s3manager.UploadOutput{}, nil
+			},
+		},
+	}
+	if err := client.UploadDelta(context.Background(), strings.NewReader("test data")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if exp := "your/key/path.delta"; uploadedKey != exp {
+		t.Errorf("expected key to be %q, got %q", exp, uploadedKey)
+	}
+}
+
+func TestS3ClientVersions(t *testing.T) {
+	m := &mockObjects{}
+	client := &S3Client{
+		region:  "us-west-2",
+		bucket:  "your-bucket",
+		key:     "your/key",
+		objects: m,
+	}
+	ctx := context.Background()
+
+	if err := client.CopyVersion(ctx, "v1"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if exp, got := "your/key.v1", aws.StringValue(m.copyInput.Key); exp != got {
+		t.Errorf("expected copy to %q, got %q", exp, got)
+	}
+	if exp, got := "your-bucket%2Fyour%2Fkey", aws.StringValue(m.copyInput.CopySource); exp != got {
+		t.Errorf("expected copy from %q, got %q", exp, got)
+	}
+
+	versions, err := client.ListVersions(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if exp, got := "your/key.", aws.StringValue(m.listInput.Prefix); exp != got {
+		t.Errorf("expected list prefix %q, got %q", exp, got)
+	}
+	if exp, got := "v1,v2", strings.Join(versions, ","); exp != got {
+		t.Errorf("expected versions %q, got %q", exp, got)
+	}
+
+	if err := client.DeleteVersion(ctx, "v1"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if exp, got := "your/key.v1", aws.StringValue(m.deleteInput.Key); exp != got {
+		t.Errorf("expected delete of %q, got %q", exp, got)
+	}
+}
+
 func TestS3ClientUploadFail(t *testing.T) {
 	region := "us-west-2"
 	accessKey := "your-access-key"
@@ -197,6 +262,46 @@ func TestS3ClientDownloadFail(t *testing.T) {
 	}
 }
 
+func TestS3ClientSize(t *testing.T) {
+	client := &S3Client{
+		region: "us-west-2",
+		bucket: "your-bucket",
+		key:    "your/key/path",
+		header: &mockHeader{
+			headFn: func(ctx aws.Context, input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+				if *input.Bucket != "your-bucket" || *input.Key != "your/key/path" {
+					t.Errorf("unexpected object s3://%s/%s", *input.Bucket, *input.Key)
+				}
+				return &s3.HeadObjectOutput{ContentLength: aws.Int64(1234)}, nil
+			},
+		},
+	}
+	n, err := client.Size(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n != 1234 {
+		t.Fatalf("expected size to be 1234, got %d", n)
+	}
+
+	client.header = &mockHeader{
+		headFn: func(ctx aws.Context, input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+			return nil, fmt.Errorf("some error related to S3")
+		},
+	}
+	if _, err := client.Size(context.Background()); err == nil {
+		t.Fatal("Expected error, got nil")
+	}
+}
+
+type mockHeader struct {
+	headFn func(ctx aws.Context, input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error)
+}
+
+func (m *mockHeader) HeadObjectWithContext(ctx aws.Context, input *s3.HeadObjectInput, opts ...request.Option) (*s3.HeadObjectOutput, error) {
+	return m.headFn(ctx, input)
+}
+
 type mockDownloader struct {
 	downloadFn func(ctx aws.Context, w io.WriterAt, input *s3.GetObjectInput, opts ...func(*s3manager.Downloader)) (n int64, err error)
 }
@@ -218,3 +323,260 @@ func (m *mockUploader) UploadWithContext(ctx aws.Context, input *s3manager.Uploa
 	}
 	return &s3manager.UploadOutput{}, nil
 }
+
+type mockObjects struct {
+	copyInput   *s3.CopyObjectInput
+	listInput   *s3.ListObjectsV2Input
+	deleteInput *s3.DeleteObjectInput
+}
+
+func (m *mockObjects) CopyObjectWithContext(ctx aws.Context, input *s3.CopyObjectInput, opts ...request.Option) (*s3.CopyObjectOutput, error) {
+	m.copyInput = input
+	return &s3.CopyObjectOutput{}, nil
+}
+
+func (m *mockObjects) ListObjectsV2PagesWithContext(ctx aws.Context, input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool, opts ...request.Option) error {
+	m.listInput = input
+	for i, key := range []string{"your/key.v1", "your/key.v2"} {
+		page := &s3.ListObjectsV2Output{Contents: []*s3.Object{{Key: aws.String(key)}}}
+		if !fn(page, i == 1) {
+			break
+		}
+	}
+	return nil
+}
+
+func (m *mockObjects) DeleteObjectWithContext(ctx aws.Context, input *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, error) {
+	m.deleteInput = input
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func TestS3ClientSetMultipart(t *testing.T) {
+	c := NewS3Client("endpoint1", "region1", "access", "secret", "bucket2", "key3")
+	if c.partSize != DefaultPartSize || c.concurrency != DefaultConcurrency {
+		t.Fatalf("unexpected defaults: part size %d, concurrency %d", c.partSize, c.concurrency)
+	}
+	if err := c.SetMultipart(MinPartSize-1, 0); err == nil {
+		t.Fatal("Expected error for part size below minimum, got nil")
+	}
+	if err := c.SetMultipart(0, -1); err == nil {
+		t.Fatal("Expected error for negative concurrency, got nil")
+	}
+	if err := c.SetMultipart(MinPartSize, 0); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if c.partSize != MinPartSize || c.concurrency != DefaultConcurrency {
+		t.Fatalf("unexpected settings: part size %d, concurrency %d", c.partSize, c.concurrency)
+	}
+}
+
+func TestS3ClientUploadSinglePart(t *testing.T) {
+	var uploaded bool
+	m := newMockMultipart()
+	client := &S3Client{
+		bucket:      "your-bucket",
+		key:         "your/key",
+		partSize:    16,
+		concurrency: 2,
+		multipart:   m,
+		uploader: &mockUploader{
+			uploadFn: func(ctx aws.Context, input *s3manager.UploadInput, opts ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+				b, _ := io.ReadAll(input.Body)
+				uploaded = string(b) == "0123456789"
+				return &s3manager.UploadOutput{}, nil
+			},
+		},
+	}
+	if err := client.Upload(context.Background(), strings.NewReader("0123456789")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !uploaded {
+		t.Fatal("expected data smaller than a part to be uploaded in one request")
+	}
+	if m.created != 0 {
+		t.Fatal("expected no multipart upload to be created")
+	}
+}
+
+func TestS3ClientUploadMultipart(t *testing.T) {
+	ResetStats()
+	m := newMockMultipart()
+	client := &S3Client{
+		bucket:      "your-bucket",
+		key:         "your/key",
+		partSize:    4,
+		concurrency: 2,
+		multipart:   m,
+	}
+	if err := client.Upload(context.Background(), strings.NewReader("0123456789")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if exp, got := "0123456789", string(m.objects["your/key"]); exp != got {
+		t.Fatalf("expected object %q, got %q", exp, got)
+	}
+	if len(m.uploads) != 0 {
+		t.Fatalf("expected no incomplete uploads, got %d", len(m.uploads))
+	}
+	if exp, got := int64(3), stats.Get(numPartsUploaded).(*expvar.Int).Value(); exp != got {
+		t.Fatalf("expected %d parts uploaded, got %d", exp, got)
+	}
+	if exp, got := int64(10), stats.Get(currentUploadBytes).(*expvar.Int).Value(); exp != got {
+		t.Fatalf("expected %d bytes uploaded, got %d", exp, got)
+	}
+}
+
+func TestS3ClientUploadMultipartResume(t *testing.T) {
+	ResetStats()
+	m := newMockMultipart()
+	m.failPart = 2
+	client := &S3Client{
+		bucket:      "your-bucket",
+		key:         "your/key",
+		partSize:    4,
+		concurrency: 1,
+		multipart:   m,
+	}
+	if err := client.Upload(context.Background(), strings.NewReader("0123456789")); err == nil {
+		t.Fatal("Expected error, got nil")
+	}
+	if len(m.uploads) != 1 {
+		t.Fatalf("expected interrupted upload to be kept, got %d uploads", len(m.uploads))
+	}
+	if _, ok := m.objects["your/key"]; ok {
+		t.Fatal("expected no object after interrupted upload")
+	}
+
+	// When the upload is resumed, parts uploaded before it was interrupted
+	// are not uploaded again, unless they have changed.
+	m.failPart = 0
+	m.partsUploaded = nil
+	if err := client.Upload(context.Background(), strings.NewReader("0123ABCD89")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if exp, got := "0123ABCD89", string(m.objects["your/key"]); exp != got {
+		t.Fatalf("expected object %q, got %q", exp, got)
+	}
+	if m.created != 1 {
+		t.Fatalf("expected 1 multipart upload to be created, got %d", m.created)
+	}
+	if exp, got := "[2 3]", fmt.Sprint(m.partsUploaded); exp != got {
+		t.Fatalf("unexpected parts uploaded on resumption: %v", m.partsUploaded)
+	}
+	if exp, got := int64(1), stats.Get(numMultipartResumed).(*expvar.Int).Value(); exp != got {
+		t.Fatalf("expected %d resumed uploads, got %d", exp, got)
+	}
+	if got := stats.Get(numPartsSkipped).(*expvar.Int).Value(); got == 0 {
+		t.Fatal("expected unchanged parts to be skipped")
+	}
+}
+
+// mockMultipart simulates the S3 multipart upload API.
+type mockMultipart struct {
+	mu            sync.Mutex
+	created       int
+	uploads       map[string]map[int64][]byte
+	keys          map[string]string
+	objects       map[string][]byte
+	failPart      int64
+	partsUploaded []int64
+}
+
+func newMockMultipart() *mockMultipart {
+	return &mockMultipart{
+		uploads: make(map[string]map[int64][]byte),
+		keys:    make(map[string]string),
+		objects: make(map[string][]byte),
+	}
+}
+
+func (m *mockMultipart) CreateMultipartUploadWithContext(ctx aws.Context, input *s3.CreateMultipartUploadInput, opts ...request.Option) (*s3.CreateMultipartUploadOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.created++
+	id := fmt.Sprintf("upload-%d", m.created)
+	m.uploads[id] = make(map[int64][]byte)
+	m.keys[id] = aws.StringValue(input.Key)
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String(id)}, nil
+}
+
+func (m *mockMultipart) UploadPartWithContext(ctx aws.Context, input *s3.UploadPartInput, opts ...request.Option) (*s3.UploadPartOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	n := aws.Int64Value(input.PartNumber)
+	if n == m.failPart {
+		return nil, fmt.Errorf("some error related to S3")
+	}
+	b, err := io.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	sum := md5.Sum(b)
+	if exp := base64.StdEncoding.EncodeToString(sum[:]); aws.StringValue(input.ContentMD5) != exp {
+		return nil, fmt.Errorf("bad Content-MD5")
+	}
+	m.uploads[aws.StringValue(input.UploadId)][n] = b
+	m.partsUploaded = append(m.partsUploaded, n)
+	return &s3.UploadPartOutput{ETag: aws.String(partETag(sum[:]))}, nil
+}
+
+func (m *mockMultipart) CompleteMultipartUploadWithContext(ctx aws.Context, input *s3.CompleteMultipartUploadInput, opts ...request.Option) (*s3.CompleteMultipartUploadOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := aws.StringValue(input.UploadId)
+	parts := m.uploads[id]
+	var data []byte
+	for i, p := range input.MultipartUpload.Parts {
+		n := aws.Int64Value(p.PartNumber)
+		if n != int64(i+1) {
+			return nil, fmt.Errorf("parts out of order")
+		}
+		sum := md5.Sum(parts[n])
+		if aws.StringValue(p.ETag) != partETag(sum[:]) {
+			return nil, fmt.Errorf("wrong ETag for part %d", n)
+		}
+		data = append(data, parts[n]...)
+	}
+	m.objects[m.keys[id]] = data
+	delete(m.uploads, id)
+	return &s3.CompleteMultipartUploadOutput{}, nil
+}
+
+func (m *mockMultipart) AbortMultipartUploadWithContext(ctx aws.Context, input *s3.AbortMultipartUploadInput, opts ...request.Option) (*s3.AbortMultipartUploadOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.uploads, aws.StringValue(input.UploadId))
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func (m *mockMultipart) ListMultipartUploadsWithContext(ctx aws.Context, input *s3.ListMultipartUploadsInput, opts ...request.Option) (*s3.ListMultipartUploadsOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := &s3.ListMultipartUploadsOutput{}
+	for id := range m.uploads {
+		if strings.HasPrefix(m.keys[id], aws.StringValue(input.Prefix)) {
+			out.Uploads = append(out.Uploads, &s3.MultipartUpload{
+				Key:      aws.String(m.keys[id]),
+				UploadId: aws.String(id),
+			})
+		}
+	}
+	return out, nil
+}
+
+func (m *mockMultipart) ListPartsPagesWithContext(ctx aws.Context, input *s3.ListPartsInput, fn func(*s3.ListPartsOutput, bool) bool, opts ...request.Option) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	page := &s3.ListPartsOutput{}
+	for n, b := range m.uploads[aws.StringValue(input.UploadId)] {
+		sum := md5.Sum(b)
+		page.Parts = append(page.Parts, &s3.Part{
+			PartNumber: aws.Int64(n),
+			ETag:       aws.String(partETag(sum[:])),
+		})
+	}
+	fn(page, true)
+	return nil
+}
@@ -0,0 +1,358 @@
+// Package azure provides a client for Azure Blob Storage, used for automatic
+// backups to, and restores from, Azure containers.
+package azure
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rqlite/rqlite/auto"
+	"github.com/rqlite/rqlite/internal/offset"
+)
+
+const (
+	// apiVersion is the version of the Blob service REST API used. It must
+	// be at least 2017-11-09 for OAuth tokens to be accepted.
+	apiVersion = "2020-04-08"
+
+	// storageResource is the resource for which managed identity tokens
+	// are requested.
+	storageResource = "https://storage.azure.com/"
+
+	// imdsTokenEndpoint is the Azure Instance Metadata Service endpoint which
+	// issues tokens for the managed identity of the VM or container.
+	imdsTokenEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+
+	// blockSize is the size of each block uploaded. Blobs may contain at
+	// most 50,000 blocks.
+	blockSize = 8 * 1024 * 1024
+
+	// tokenExpiryMargin is how long before expiry a token is refreshed.
+	tokenExpiryMargin = 5 * time.Minute
+)
+
+// BlobConfig is the subconfig for the Azure storage type
+type BlobConfig struct {
+	Account   string `json:"account"`
+	Endpoint  string `json:"endpoint,omitempty"`
+	Container string `json:"container"`
+	Blob      string `json:"blob"`
+	SASToken  string `json:"sas_token,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+}
+
+// BlobClient is a client for uploading data to, and downloading data from,
+// Azure Blob Storage.
+type BlobClient struct {
+	endpoint  string
+	container string
+	blob      string
+	sasToken  string
+	clientID  string
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+
+	// These fields are used for testing via dependency injection.
+	client        *http.Client
+	tokenEndpoint string
+}
+
+// NewBlobClient returns an instance of a BlobClient. If endpoint is not set,
+// the public endpoint of account is used. If sasToken is set, requests are
+// authorized with it. Otherwise a managed identity is used, which is the
+// user-assigned identity clientID if set, and the system-assigned identity
+// if not.
+func NewBlobClient(account, endpoint, container, blob, sasToken, clientID string) *BlobClient {
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", account)
+	}
+	return &BlobClient{
+		endpoint:      strings.TrimSuffix(endpoint, "/"),
+		container:     container,
+		blob:          blob,
+		sasToken:      strings.TrimPrefix(sasToken, "?"),
+		clientID:      clientID,
+		client:        http.DefaultClient,
+		tokenEndpoint: imdsTokenEndpoint,
+	}
+}
+
+// String returns a string representation of the BlobClient.
+func (b *BlobClient) String() string {
+	return fmt.Sprintf("%s/%s/%s", b.endpoint, b.container, b.blob)
+}
+
+// Upload uploads data to Azure Blob Storage. The data is uploaded as a
+// series of blocks, which are then committed together, so that the size of
+// the data need not be known in advance.
+func (b *BlobClient) Upload(ctx context.Context, reader io.Reader) error {
+	return b.upload(ctx, b.blob, reader)
+}
+
+// Name returns the name of the blob to which data is uploaded.
+func (b *BlobClient) Name() string {
+	return b.blob
+}
+
+// UploadTo uploads data to the given blob, rather than the client's own.
+func (b *BlobClient) UploadTo(ctx context.Context, blob string, reader io.Reader) error {
+	return b.upload(ctx, blob, reader)
+}
+
+// UploadDelta uploads the delta of an incremental backup to Azure Blob
+// Storage, alongside the full backup.
+func (b *BlobClient) UploadDelta(ctx context.Context, reader io.Reader) error {
+	return b.upload(ctx, b.blob+auto.DeltaSuffix, reader)
+}
+
+func (b *BlobClient) upload(ctx context.Context, blob string, reader io.Reader) error {
+	var ids []string
+	buf := make([]byte, blockSize)
+	for {
+		n, err := io.ReadFull(reader, buf)
+		if n > 0 {
+			id := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%010d", len(ids))))
+			q := url.Values{}
+			q.Set("comp", "block")
+			q.Set("blockid", id)
+			if err := b.do(ctx, http.MethodPut, blob, q, buf[:n], nil); err != nil {
+				return fmt.Errorf("failed to upload block to %s: %w", b.blobURL(blob), err)
+			}
+			ids = append(ids, id)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	var list struct {
+		XMLName xml.Name `xml:"BlockList"`
+		Latest  []string `xml:"Latest"`
+	}
+	list.Latest = ids
+	body, err := xml.Marshal(list)
+	if err != nil {
+		return err
+	}
+	q := url.Values{}
+	q.Set("comp", "blocklist")
+	if err := b.do(ctx, http.MethodPut, blob, q, append([]byte(xml.Header), body...), nil); err != nil {
+		return fmt.Errorf("failed to commit upload to %s: %w", b.blobURL(blob), err)
+	}
+	return nil
+}
+
+// Download downloads data from Azure Blob Storage.
+func (b *BlobClient) Download(ctx context.Context, writer io.WriterAt) error {
+	return b.DownloadSequential(ctx, offset.NewWriter(writer))
+}
+
+// DownloadSequential downloads data from Azure Blob Storage, writing it to w
+// in order.
+func (b *BlobClient) DownloadSequential(ctx context.Context, w io.Writer) error {
+	if err := b.do(ctx, http.MethodGet, b.blob, nil, nil, w); err != nil {
+		return fmt.Errorf("failed to download from %v: %w", b, err)
+	}
+	return nil
+}
+
+// CopyVersion copies the blob to the given version of it, which is stored
+// alongside it. The copy is made by Azure Blob Storage itself.
+func (b *BlobClient) CopyVersion(ctx context.Context, version string) error {
+	hdr := http.Header{}
+	hdr.Set("x-ms-copy-source", b.withSAS(b.blobURL(b.blob), nil))
+	if err := b.doURL(ctx, http.MethodPut, b.blobURL(b.versionBlob(version)), nil, hdr, nil, nil); err != nil {
+		return fmt.Errorf("failed to copy %v to version %s: %w", b, version, err)
+	}
+	return nil
+}
+
+// ListVersions returns the versions of the blob stored in Azure Blob Storage.
+func (b *BlobClient) ListVersions(ctx context.Context) ([]string, error) {
+	prefix := b.versionBlob("")
+	var versions []string
+	marker := ""
+	for {
+		q := url.Values{}
+		q.Set("restype", "container")
+		q.Set("comp", "list")
+		q.Set("prefix", prefix)
+		if marker != "" {
+			q.Set("marker", marker)
+		}
+		var buf bytes.Buffer
+		if err := b.doURL(ctx, http.MethodGet, b.containerURL(), q, nil, nil, &buf); err != nil {
+			return nil, fmt.Errorf("failed to list versions of %v: %w", b, err)
+		}
+		var list struct {
+			Blobs []struct {
+				Name string `xml:"Name"`
+			} `xml:"Blobs>Blob"`
+			NextMarker string `xml:"NextMarker"`
+		}
+		if err := xml.Unmarshal(buf.Bytes(), &list); err != nil {
+			return nil, fmt.Errorf("failed to decode versions of %v: %w", b, err)
+		}
+		for _, bl := range list.Blobs {
+			versions = append(versions, strings.TrimPrefix(bl.Name, prefix))
+		}
+		if list.NextMarker == "" {
+			return versions, nil
+		}
+		marker = list.NextMarker
+	}
+}
+
+// DeleteVersion deletes the given version of the blob from Azure Blob
+// Storage.
+func (b *BlobClient) DeleteVersion(ctx context.Context, version string) error {
+	if err := b.do(ctx, http.MethodDelete, b.versionBlob(version), nil, nil, nil); err != nil {
+		return fmt.Errorf("failed to delete version %s of %v: %w", version, b, err)
+	}
+	return nil
+}
+
+func (b *BlobClient) versionBlob(version string) string {
+	return b.blob + "." + version
+}
+
+// containerURL returns the URL of the container.
+func (b *BlobClient) containerURL() string {
+	return fmt.Sprintf("%s/%s", b.endpoint, url.PathEscape(b.container))
+}
+
+// blobURL returns the URL of the named blob in the container.
+func (b *BlobClient) blobURL(blob string) string {
+	return fmt.Sprintf("%s/%s", b.containerURL(), escapeBlob(blob))
+}
+
+// withSAS returns u with the query q, and the SAS token if set, appended.
+func (b *BlobClient) withSAS(u string, q url.Values) string {
+	query := q.Encode()
+	if b.sasToken != "" {
+		if query != "" {
+			query += "&"
+		}
+		query += b.sasToken
+	}
+	if query != "" {
+		u += "?" + query
+	}
+	return u
+}
+
+// do performs a request against the named blob. If body is not nil, it is
+// sent as the request body. If dst is not nil, the response body is copied
+// to it.
+func (b *BlobClient) do(ctx context.Context, method, blob string, q url.Values, body []byte, dst io.Writer) error {
+	return b.doURL(ctx, method, b.blobURL(blob), q, nil, body, dst)
+}
+
+// doURL performs a request against u, with the additional headers hdr.
+func (b *BlobClient) doURL(ctx context.Context, method, u string, q url.Values, hdr http.Header, body []byte, dst io.Writer) error {
+	u = b.withSAS(u, q)
+
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return err
+	}
+	for k, v := range hdr {
+		req.Header[k] = v
+	}
+	req.Header.Set("x-ms-version", apiVersion)
+	if b.sasToken == "" {
+		token, err := b.managedIdentityToken(ctx)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &auto.StatusError{Code: resp.StatusCode, Status: resp.Status}
+	}
+	if dst != nil {
+		_, err = io.Copy(dst, resp.Body)
+	}
+	return err
+}
+
+// managedIdentityToken returns a token for the managed identity, requesting
+// a new one from the Instance Metadata Service if needed.
+func (b *BlobClient) managedIdentityToken(ctx context.Context) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.token != "" && time.Now().Before(b.tokenExpiry.Add(-tokenExpiryMargin)) {
+		return b.token, nil
+	}
+
+	q := url.Values{}
+	q.Set("api-version", "2018-02-01")
+	q.Set("resource", storageResource)
+	if b.clientID != "" {
+		q.Set("client_id", b.clientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.tokenEndpoint+"?"+q.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata", "true")
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get managed identity token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get managed identity token: %s", resp.Status)
+	}
+
+	var tr struct {
+		AccessToken string `json:"access_token"`
+		ExpiresOn   string `json:"expires_on"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		return "", fmt.Errorf("failed to decode managed identity token: %w", err)
+	}
+	expiresOn, err := strconv.ParseInt(tr.ExpiresOn, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid managed identity token expiry: %w", err)
+	}
+	b.token = tr.AccessToken
+	b.tokenExpiry = time.Unix(expiresOn, 0)
+	return b.token, nil
+}
+
+// escapeBlob escapes a blob name for use in a URL, retaining any slashes
+// so that virtual directories are preserved.
+func escapeBlob(name string) string {
+	parts := strings.Split(name, "/")
+	for i := range parts {
+		parts[i] = url.PathEscape(parts[i])
+	}
+	return strings.Join(parts, "/")
+}
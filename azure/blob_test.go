@@ -0,0 +1,246 @@
+package azure
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_NewBlobClient(t *testing.T) {
+	c := NewBlobClient("account1", "", "container2", "blob3", "?sv=x", "")
+	if c.endpoint != "https://account1.blob.core.windows.net" {
+		t.Fatalf("unexpected endpoint %q", c.endpoint)
+	}
+	if c.container != "container2" {
+		t.Fatalf("expected container to be %q, got %q", "container2", c.container)
+	}
+	if c.blob != "blob3" {
+		t.Fatalf("expected blob to be %q, got %q", "blob3", c.blob)
+	}
+	if c.sasToken != "sv=x" {
+		t.Fatalf("expected SAS token to be %q, got %q", "sv=x", c.sasToken)
+	}
+}
+
+func Test_BlobClient_String(t *testing.T) {
+	c := NewBlobClient("account1", "http://localhost:10000/", "container2", "blob3", "", "")
+	if c.String() != "http://localhost:10000/container2/blob3" {
+		t.Fatalf("unexpected String() %q", c.String())
+	}
+}
+
+func Test_BlobClientUploadSAS(t *testing.T) {
+	var mu sync.Mutex
+	blocks := make(map[string][]byte)
+	var committed []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method != http.MethodPut {
+			t.Errorf("expected PUT, got %s", r.Method)
+		}
+		if r.URL.EscapedPath() != "/container2/dir/blob3" {
+			t.Errorf("unexpected path: %s", r.URL.EscapedPath())
+		}
+		q := r.URL.Query()
+		if q.Get("sig") != "secret" {
+			t.Errorf("SAS token not sent, query %q", r.URL.RawQuery)
+		}
+		if r.Header.Get("Authorization") != "" {
+			t.Errorf("unexpected Authorization header with SAS token")
+		}
+		b, _ := io.ReadAll(r.Body)
+		switch q.Get("comp") {
+		case "block":
+			blocks[q.Get("blockid")] = b
+		case "blocklist":
+			var list struct {
+				Latest []string `xml:"Latest"`
+			}
+			if err := xml.Unmarshal(b, &list); err != nil {
+				t.Errorf("failed to unmarshal block list: %s", err)
+			}
+			committed = list.Latest
+		default:
+			t.Errorf("unexpected comp %q", q.Get("comp"))
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+
+	c := NewBlobClient("", ts.URL, "container2", "dir/blob3", "sv=x&sig=secret", "")
+	c.client = ts.Client()
+
+	data := strings.Repeat("a", blockSize) + "test data"
+	if err := c.Upload(context.Background(), strings.NewReader(data)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(committed) != 2 {
+		t.Fatalf("expected 2 blocks to be committed, got %d", len(committed))
+	}
+	var got string
+	for _, id := range committed {
+		got += string(blocks[id])
+	}
+	if got != data {
+		t.Fatalf("committed data does not match uploaded data")
+	}
+}
+
+func Test_BlobClientUploadFail(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer ts.Close()
+
+	c := NewBlobClient("", ts.URL, "container2", "blob3", "sv=x", "")
+	c.client = ts.Client()
+	err := c.Upload(context.Background(), strings.NewReader("test data"))
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if !strings.Contains(err.Error(), "403") {
+		t.Fatalf("expected error to contain status, got %q", err.Error())
+	}
+}
+
+func Test_BlobClientVersions(t *testing.T) {
+	var mu sync.Mutex
+	var copySource, deleted string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			if r.URL.EscapedPath() != "/container2/dir/blob3.v1" {
+				t.Errorf("unexpected path: %s", r.URL.EscapedPath())
+			}
+			copySource = r.Header.Get("x-ms-copy-source")
+			w.WriteHeader(http.StatusAccepted)
+		case http.MethodGet:
+			q := r.URL.Query()
+			if r.URL.EscapedPath() != "/container2" || q.Get("comp") != "list" || q.Get("prefix") != "dir/blob3." {
+				t.Errorf("unexpected list request: %s", r.URL)
+			}
+			if q.Get("marker") == "" {
+				w.Write([]byte(`<EnumerationResults><Blobs><Blob><Name>dir/blob3.v1</Name></Blob></Blobs><NextMarker>m</NextMarker></EnumerationResults>`))
+			} else {
+				w.Write([]byte(`<EnumerationResults><Blobs><Blob><Name>dir/blob3.v2</Name></Blob></Blobs><NextMarker/></EnumerationResults>`))
+			}
+		case http.MethodDelete:
+			deleted = r.URL.EscapedPath()
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	defer ts.Close()
+
+	c := NewBlobClient("", ts.URL, "container2", "dir/blob3", "sv=x&sig=secret", "")
+	c.client = ts.Client()
+	ctx := context.Background()
+
+	if err := c.CopyVersion(ctx, "v1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp := ts.URL + "/container2/dir/blob3?sv=x&sig=secret"; copySource != exp {
+		t.Fatalf("wrong copy source, exp %s, got %s", exp, copySource)
+	}
+	versions, err := c.ListVersions(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp, got := "v1,v2", strings.Join(versions, ","); exp != got {
+		t.Fatalf("wrong versions, exp %s, got %s", exp, got)
+	}
+	if err := c.DeleteVersion(ctx, "v1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp := "/container2/dir/blob3.v1"; deleted != exp {
+		t.Fatalf("wrong blob deleted, exp %s, got %s", exp, deleted)
+	}
+}
+
+func Test_BlobClientDownloadManagedIdentity(t *testing.T) {
+	expectedData := "test data"
+	tokenRequests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			tokenRequests++
+			if r.Header.Get("Metadata") != "true" {
+				t.Errorf("Metadata header not set on token request")
+			}
+			if r.URL.Query().Get("client_id") != "id1" {
+				t.Errorf("expected client_id id1, got %q", r.URL.Query().Get("client_id"))
+			}
+			if r.URL.Query().Get("resource") != storageResource {
+				t.Errorf("unexpected resource %q", r.URL.Query().Get("resource"))
+			}
+			fmt.Fprintf(w, `{"access_token":"tok","expires_on":"%d"}`, time.Now().Add(time.Hour).Unix())
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			t.Errorf("unexpected Authorization header %q", r.Header.Get("Authorization"))
+		}
+		if r.Header.Get("x-ms-version") == "" {
+			t.Errorf("x-ms-version header not set")
+		}
+		w.Write([]byte(expectedData))
+	}))
+	defer ts.Close()
+
+	c := NewBlobClient("", ts.URL, "container2", "blob3", "", "id1")
+	c.client = ts.Client()
+	c.tokenEndpoint = ts.URL + "/token"
+
+	for i := 0; i < 2; i++ {
+		f := mustTempFile(t)
+		if err := c.Download(context.Background(), f); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		b, err := os.ReadFile(f.Name())
+		if err != nil {
+			t.Fatalf("failed to read downloaded file: %v", err)
+		}
+		if string(b) != expectedData {
+			t.Fatalf("expected downloaded data to be %q, got %q", expectedData, string(b))
+		}
+	}
+	if tokenRequests != 1 {
+		t.Fatalf("expected token to be cached, got %d token requests", tokenRequests)
+	}
+}
+
+func Test_BlobClientDownloadTokenFail(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	c := NewBlobClient("", ts.URL, "container2", "blob3", "", "")
+	c.client = ts.Client()
+	c.tokenEndpoint = ts.URL + "/token"
+	err := c.Download(context.Background(), mustTempFile(t))
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if !strings.Contains(err.Error(), "managed identity") {
+		t.Fatalf("expected managed identity error, got %q", err.Error())
+	}
+}
+
+func mustTempFile(t *testing.T) *os.File {
+	t.Helper()
+	f, err := os.CreateTemp(t.TempDir(), "rqlite-azure-test")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
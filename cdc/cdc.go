@@ -0,0 +1,671 @@
+// Package cdc implements change data capture. While this node is leader, a
+// Publisher tails the changes to rows made by committed Raft log entries,
+// and delivers an event for each to a sink: a Kafka topic, an HTTP webhook,
+// or a NATS subject. Each sink configured has its own Publisher.
+//
+// Delivery is at least once. The index of the last log entry whose changes
+// have been delivered, the high-water mark, is written to the database
+// through Raft once the sink has acknowledged them, so that a restarted
+// node, or a new leader, resumes delivery from it. Changes are only
+// delivered twice if a node stops between their acknowledgement and the
+// write.
+//
+// A sink which fails, or asks for delivery to slow, is retried with backoff,
+// and changes wait in the log of retained changes meanwhile, rather than in
+// memory of the Publisher.
+package cdc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rqlite/rqlite/auto"
+	"github.com/rqlite/rqlite/command"
+	"github.com/rqlite/rqlite/command/encoding"
+	"github.com/rqlite/rqlite/store"
+)
+
+const (
+	// DefaultInterval is the default interval between checks for changes
+	// to publish.
+	DefaultInterval = time.Second
+
+	// DefaultBatchSize is the default number of changes published to Kafka
+	// in a single request.
+	DefaultBatchSize = 500
+
+	// DefaultLogSize is the default number of the latest row changes each
+	// node retains for publication.
+	DefaultLogSize = 100000
+
+	// DefaultMaxRetries is the default number of times delivery of a batch
+	// is retried before the Publisher waits for its next check.
+	DefaultMaxRetries = 3
+
+	// DefaultRetryBackoff is the default delay before delivery of a batch is
+	// first retried. The delay doubles with each retry, up to maxBackoff.
+	DefaultRetryBackoff = time.Second
+
+	maxBackoff = 30 * time.Second
+
+	// FormatJSON and FormatAvro are the serializations of published events.
+	FormatJSON = "json"
+	FormatAvro = "avro"
+
+	// positionTable is the table in which the high-water mark of each sink
+	// is recorded. Because it is written through the Raft log, it is shared
+	// by every node, and survives leader changes. Changes to it, and to any
+	// other internal table, are never published.
+	positionTable = "_rqlite_cdc"
+
+	internalTablePrefix = "_rqlite_"
+)
+
+var (
+	// ErrInvalidConfig is returned when the CDC config is invalid.
+	ErrInvalidConfig = errors.New("invalid CDC config")
+)
+
+// stats captures stats for change data capture.
+var stats *expvar.Map
+
+const (
+	numEventsPublished  = "events_published"
+	numBatchesPublished = "batches_published"
+	numPublishErrors    = "publish_errors"
+	numPublishRetries   = "publish_retries"
+	numChangesMissed    = "changes_missed"
+)
+
+func init() {
+	stats = expvar.NewMap("cdc")
+	ResetStats()
+}
+
+// ResetStats resets the expvar stats for this module. Mostly for test purposes.
+func ResetStats() {
+	stats.Init()
+	stats.Add(numEventsPublished, 0)
+	stats.Add(numBatchesPublished, 0)
+	stats.Add(numPublishErrors, 0)
+	stats.Add(numPublishRetries, 0)
+	stats.Add(numChangesMissed, 0)
+}
+
+// Config is the config of change data capture, as read from the CDC config
+// file.
+type Config struct {
+	// RESTProxy is the URL of the Kafka REST Proxy through which events are
+	// produced, for example http://kafka-rest:8082, and Topic the Kafka
+	// topic to which they are published. If not set, events are not
+	// published to Kafka.
+	RESTProxy string `json:"rest_proxy,omitempty"`
+	Topic     string `json:"topic,omitempty"`
+
+	// Format is the serialization of events published to Kafka, FormatJSON
+	// or FormatAvro. If not set, FormatJSON is used.
+	Format string `json:"format,omitempty"`
+
+	// Webhook configures delivery of events to an HTTP webhook. May be nil.
+	Webhook *WebhookConfig `json:"webhook,omitempty"`
+
+	// NATS configures delivery of events to a NATS subject. May be nil.
+	NATS *NATSConfig `json:"nats,omitempty"`
+
+	// Tables are the tables whose changes are published. If not set, the
+	// changes of every table are published.
+	Tables []string `json:"tables,omitempty"`
+
+	// Headers are set on every request to the Kafka REST Proxy, such as for
+	// authentication.
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Interval is the interval between checks for changes to publish. If
+	// not set, DefaultInterval is used.
+	Interval auto.Duration `json:"interval,omitempty"`
+
+	// BatchSize is the number of changes delivered to a sink at once. If
+	// not set, DefaultBatchSize is used.
+	BatchSize int `json:"batch_size,omitempty"`
+
+	// Timeout is the timeout of each delivery to a sink. If not set,
+	// DefaultTimeout is used.
+	Timeout auto.Duration `json:"timeout,omitempty"`
+
+	// MaxRetries is the number of times delivery of a batch is retried, and
+	// RetryBackoff the delay before the first retry. If not set,
+	// DefaultMaxRetries and DefaultRetryBackoff are used.
+	MaxRetries   int           `json:"max_retries,omitempty"`
+	RetryBackoff auto.Duration `json:"retry_backoff,omitempty"`
+
+	// LogSize is the number of the latest row changes each node retains for
+	// publication. If publishing falls further behind than this, changes
+	// are missed. If not set, DefaultLogSize is used.
+	LogSize int `json:"log_size,omitempty"`
+}
+
+// ParseConfig parses the config read from r, expanding any environment
+// variables in it.
+func ParseConfig(r io.Reader) (*Config, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := json.Unmarshal([]byte(os.ExpandEnv(string(b))), cfg); err != nil {
+		return nil, err
+	}
+	if cfg.RESTProxy != "" || cfg.Topic != "" {
+		if !isHTTPURL(cfg.RESTProxy) {
+			return nil, fmt.Errorf("%w: REST Proxy URL must be http or https", ErrInvalidConfig)
+		}
+		if cfg.Topic == "" {
+			return nil, fmt.Errorf("%w: topic not set", ErrInvalidConfig)
+		}
+	} else if cfg.Webhook == nil && cfg.NATS == nil {
+		return nil, fmt.Errorf("%w: no sink configured", ErrInvalidConfig)
+	}
+	if cfg.Format == "" {
+		cfg.Format = FormatJSON
+	}
+	if cfg.Format != FormatJSON && cfg.Format != FormatAvro {
+		return nil, fmt.Errorf("%w: format must be %s or %s", ErrInvalidConfig, FormatJSON, FormatAvro)
+	}
+	if cfg.Webhook != nil && !isHTTPURL(cfg.Webhook.URL) {
+		return nil, fmt.Errorf("%w: webhook URL must be http or https", ErrInvalidConfig)
+	}
+	if cfg.NATS != nil {
+		if err := cfg.NATS.validate(); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidConfig, err)
+		}
+	}
+	if cfg.Interval < 0 || cfg.BatchSize < 0 || cfg.Timeout < 0 || cfg.LogSize < 0 ||
+		cfg.MaxRetries < 0 || cfg.RetryBackoff < 0 {
+		return nil, fmt.Errorf("%w: negative interval, batch size, timeout, log size, or retries", ErrInvalidConfig)
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = auto.Duration(DefaultInterval)
+	}
+	if cfg.BatchSize == 0 {
+		cfg.BatchSize = DefaultBatchSize
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = auto.Duration(DefaultTimeout)
+	}
+	if cfg.LogSize == 0 {
+		cfg.LogSize = DefaultLogSize
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = DefaultMaxRetries
+	}
+	if cfg.RetryBackoff == 0 {
+		cfg.RetryBackoff = auto.Duration(DefaultRetryBackoff)
+	}
+	return cfg, nil
+}
+
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// Event is a change to a row, as published to Kafka.
+type Event struct {
+	// Index is the index of the log entry which made the change.
+	Index uint64 `json:"index"`
+
+	Table string `json:"table"`
+	Op    string `json:"op"`
+	RowID int64  `json:"rowid"`
+
+	// Row is the changed row, keyed by column, read when the change is
+	// published. It may therefore reflect later changes to the row. It is
+	// nil for deletes, and if the row no longer exists.
+	Row map[string]interface{} `json:"row"`
+}
+
+// Key returns the key of the event, such as of its Kafka record. Since the
+// key determines the partition, the events of each row are delivered in
+// order.
+func (e *Event) Key() string {
+	return fmt.Sprintf("%s:%d", e.Table, e.RowID)
+}
+
+// Sink is a destination to which events are delivered.
+type Sink interface {
+	// Send delivers the events, in order. It returns nil only once every
+	// event has been acknowledged.
+	Send(ctx context.Context, events []*Event) error
+
+	// String describes the destination of the events.
+	String() string
+}
+
+// RetryAfterError is returned by a Sink asking for delivery to be retried no
+// sooner than after a delay.
+type RetryAfterError struct {
+	Err   error
+	After time.Duration
+}
+
+// Error returns the error of the sink.
+func (e *RetryAfterError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the error of the sink.
+func (e *RetryAfterError) Unwrap() error {
+	return e.Err
+}
+
+// Store is the interface the Store must implement.
+type Store interface {
+	// RowChanges returns the changes to rows made by the log entries with
+	// an index greater than since.
+	RowChanges(since uint64, max int) ([]*store.RowChange, uint64, error)
+
+	// Execute executes a request through the Raft log.
+	Execute(er *command.ExecuteRequest) ([]*command.ExecuteResult, error)
+
+	// Query executes a query against the local database.
+	Query(qr *command.QueryRequest) ([]*command.QueryRows, error)
+
+	// AppliedIndex returns the index of the last log entry applied.
+	AppliedIndex() uint64
+}
+
+// Publisher publishes the changes to rows to a sink. It is run as a leader
+// job, so that only the leader publishes.
+type Publisher struct {
+	str    Store
+	name   string
+	key    string // Key of the sink's high-water mark.
+	sink   Sink
+	tables map[string]bool
+
+	interval     time.Duration
+	batchSize    int
+	maxRetries   int
+	retryBackoff time.Duration
+
+	mu          sync.Mutex
+	running     bool
+	cursor      uint64 // Index of the last log entry examined.
+	position    uint64 // Index recorded as the high-water mark.
+	recorded    bool   // Whether any high-water mark has been recorded.
+	lastErr     error
+	lastPublish time.Time
+
+	logger *log.Logger
+}
+
+// New returns a Publisher of the changes of str to each sink configured by
+// cfg. Any setting not configured takes its default.
+func New(cfg *Config, str Store) (Publishers, error) {
+	var pubs Publishers
+	if cfg.RESTProxy != "" {
+		producer, err := NewProducer(cfg)
+		if err != nil {
+			return nil, err
+		}
+		pubs = append(pubs, NewPublisher("kafka", "kafka:"+cfg.Topic, producer, cfg, str))
+	}
+	if cfg.Webhook != nil {
+		wh, err := NewWebhook(cfg.Webhook, time.Duration(cfg.Timeout))
+		if err != nil {
+			return nil, err
+		}
+		pubs = append(pubs, NewPublisher("webhook", "webhook", wh, cfg, str))
+	}
+	if cfg.NATS != nil {
+		nc := NewNATS(cfg.NATS, time.Duration(cfg.Timeout))
+		pubs = append(pubs, NewPublisher("nats", "nats:"+cfg.NATS.Subject, nc, cfg, str))
+	}
+	return pubs, nil
+}
+
+// NewPublisher returns a Publisher, named name, of the changes of str to
+// sink. The high-water mark of the sink is recorded under key, which must
+// be unique to the sink.
+func NewPublisher(name, key string, sink Sink, cfg *Config, str Store) *Publisher {
+	p := &Publisher{
+		str:          str,
+		name:         name,
+		key:          key,
+		sink:         sink,
+		interval:     time.Duration(cfg.Interval),
+		batchSize:    cfg.BatchSize,
+		maxRetries:   cfg.MaxRetries,
+		retryBackoff: time.Duration(cfg.RetryBackoff),
+		logger:       log.New(os.Stderr, "[cdc] ", log.LstdFlags),
+	}
+	if p.interval == 0 {
+		p.interval = DefaultInterval
+	}
+	if p.batchSize == 0 {
+		p.batchSize = DefaultBatchSize
+	}
+	if p.retryBackoff == 0 {
+		p.retryBackoff = DefaultRetryBackoff
+	}
+	if len(cfg.Tables) > 0 {
+		p.tables = make(map[string]bool, len(cfg.Tables))
+		for _, t := range cfg.Tables {
+			p.tables[strings.ToLower(t)] = true
+		}
+	}
+	return p
+}
+
+// Name returns the name of the Publisher.
+func (p *Publisher) Name() string {
+	return p.name
+}
+
+// Run publishes changes, resuming from the recorded high-water mark, until
+// ctx is done.
+func (p *Publisher) Run(ctx context.Context) error {
+	pos, recorded, err := p.highWaterMark()
+	if err != nil {
+		return fmt.Errorf("failed to read high-water mark: %s", err)
+	}
+	p.mu.Lock()
+	p.cursor, p.position, p.recorded = pos, pos, recorded
+	p.running = true
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.running = false
+	}()
+	p.logger.Printf("publishing changes to %s after index %d", p.sink, pos)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			err := p.publish(ctx)
+			p.mu.Lock()
+			p.lastErr = err
+			p.mu.Unlock()
+			if err == store.ErrNotLeader {
+				return err
+			}
+			if err != nil && ctx.Err() == nil {
+				stats.Add(numPublishErrors, 1)
+				p.logger.Printf("failed to publish changes to %s: %s", p.sink, err)
+			}
+		}
+	}
+}
+
+// Stats returns stats on the Publisher.
+func (p *Publisher) Stats() (map[string]interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	m := map[string]interface{}{
+		"sink":            p.sink.String(),
+		"running":         p.running,
+		"interval":        p.interval.String(),
+		"high_water_mark": p.position,
+		"cursor":          p.cursor,
+		"lag":             p.lagLocked(),
+	}
+	if !p.lastPublish.IsZero() {
+		m["last_publish"] = p.lastPublish
+	}
+	if p.lastErr != nil {
+		m["last_error"] = p.lastErr.Error()
+	}
+	return m, nil
+}
+
+// Lag returns the number of log entries applied but not yet examined for
+// changes to publish, or zero if the Publisher is not running.
+func (p *Publisher) Lag() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lagLocked()
+}
+
+func (p *Publisher) lagLocked() int {
+	if !p.running {
+		return 0
+	}
+	if applied := p.str.AppliedIndex(); applied > p.cursor {
+		return int(applied - p.cursor)
+	}
+	return 0
+}
+
+// publish publishes every change not yet published, a batch at a time.
+func (p *Publisher) publish(ctx context.Context) error {
+	for {
+		p.mu.Lock()
+		cursor, recorded := p.cursor, p.recorded
+		p.mu.Unlock()
+
+		changes, next, err := p.str.RowChanges(cursor, p.batchSize)
+		if err == store.ErrRowChangesMissed {
+			// Only changes made before publishing first began are expected
+			// to be missing.
+			if recorded {
+				stats.Add(numChangesMissed, 1)
+				p.logger.Printf("changes after index %d were missed, resuming after index %d", cursor, next)
+			}
+			p.setCursor(next)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		var events []*Event
+		for _, rc := range changes {
+			table := strings.ToLower(rc.Table)
+			if strings.HasPrefix(table, internalTablePrefix) || (p.tables != nil && !p.tables[table]) {
+				continue
+			}
+			events = append(events, &Event{Index: rc.Index, Table: rc.Table, Op: rc.Op, RowID: rc.RowID})
+		}
+		if len(events) > 0 {
+			if err := p.readRows(events); err != nil {
+				return err
+			}
+			if err := p.send(ctx, events); err != nil {
+				return err
+			}
+			stats.Add(numEventsPublished, int64(len(events)))
+			stats.Add(numBatchesPublished, 1)
+			if err := p.setHighWaterMark(next); err != nil {
+				return fmt.Errorf("failed to record high-water mark: %s", err)
+			}
+		}
+		p.setCursor(next)
+		if len(changes) < p.batchSize {
+			return nil
+		}
+	}
+}
+
+// send delivers events to the sink, retrying with backoff on failure.
+func (p *Publisher) send(ctx context.Context, events []*Event) error {
+	backoff := p.retryBackoff
+	for attempt := 0; ; attempt++ {
+		err := p.sink.Send(ctx, events)
+		if err == nil || attempt >= p.maxRetries || ctx.Err() != nil {
+			return err
+		}
+		delay := backoff
+		var ra *RetryAfterError
+		if errors.As(err, &ra) && ra.After > delay {
+			delay = ra.After
+		}
+		stats.Add(numPublishRetries, 1)
+		p.logger.Printf("failed to deliver %d events to %s, retrying in %s: %s", len(events), p.sink, delay, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (p *Publisher) setCursor(idx uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cursor = idx
+}
+
+// readRows sets the rows of the insert and update events, as they now are.
+func (p *Publisher) readRows(events []*Event) error {
+	var reads []*Event
+	var stmts []*command.Statement
+	for _, ev := range events {
+		if ev.Op == "delete" {
+			continue
+		}
+		reads = append(reads, ev)
+		stmts = append(stmts, &command.Statement{
+			Sql: fmt.Sprintf(`SELECT * FROM "%s" WHERE _rowid_ = ?`, strings.ReplaceAll(ev.Table, `"`, `""`)),
+			Parameters: []*command.Parameter{
+				{Value: &command.Parameter_I{I: ev.RowID}},
+			},
+		})
+	}
+	if len(stmts) == 0 {
+		return nil
+	}
+	rows, err := p.str.Query(&command.QueryRequest{
+		Request: &command.Request{Statements: stmts},
+		Level:   command.QueryRequest_QUERY_REQUEST_LEVEL_NONE,
+	})
+	if err != nil {
+		return err
+	}
+	if len(rows) != len(reads) {
+		return fmt.Errorf("read %d rows, expected %d", len(rows), len(reads))
+	}
+	for i, ev := range reads {
+		if rows[i].Error != "" {
+			// The table may since have been dropped.
+			continue
+		}
+		ar, err := encoding.NewAssociativeRowsFromQueryRows(rows[i])
+		if err != nil {
+			return err
+		}
+		if len(ar.Rows) == 1 {
+			ev.Row = ar.Rows[0]
+		}
+	}
+	return nil
+}
+
+// highWaterMark returns the recorded high-water mark of the sink, and
+// whether one has been recorded.
+func (p *Publisher) highWaterMark() (uint64, bool, error) {
+	rows, err := p.str.Query(&command.QueryRequest{
+		Request: &command.Request{
+			Statements: []*command.Statement{{
+				Sql: fmt.Sprintf("SELECT idx FROM %s WHERE sink = ?", positionTable),
+				Parameters: []*command.Parameter{
+					{Value: &command.Parameter_S{S: p.key}},
+				},
+			}},
+		},
+		Level: command.QueryRequest_QUERY_REQUEST_LEVEL_NONE,
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	if len(rows) != 1 {
+		return 0, false, fmt.Errorf("unexpected number of results: %d", len(rows))
+	}
+	if rows[0].Error != "" {
+		if strings.Contains(rows[0].Error, "no such table") {
+			return 0, false, nil
+		}
+		return 0, false, errors.New(rows[0].Error)
+	}
+	if len(rows[0].Values) == 0 {
+		return 0, false, nil
+	}
+	return uint64(rows[0].Values[0].Parameters[0].GetI()), true, nil
+}
+
+// setHighWaterMark records idx as the high-water mark of the sink.
+func (p *Publisher) setHighWaterMark(idx uint64) error {
+	results, err := p.str.Execute(&command.ExecuteRequest{
+		Request: &command.Request{
+			Transaction: true,
+			Statements: []*command.Statement{
+				{Sql: fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (sink TEXT NOT NULL PRIMARY KEY, idx INTEGER NOT NULL)", positionTable)},
+				{
+					Sql: fmt.Sprintf("INSERT OR REPLACE INTO %s(sink, idx) VALUES(?, ?)", positionTable),
+					Parameters: []*command.Parameter{
+						{Value: &command.Parameter_S{S: p.key}},
+						{Value: &command.Parameter_I{I: int64(idx)}},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	for _, r := range results {
+		if r.Error != "" {
+			return errors.New(r.Error)
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.position = idx
+	p.recorded = true
+	p.lastPublish = time.Now()
+	return nil
+}
+
+// Publishers are the Publishers of every sink configured.
+type Publishers []*Publisher
+
+// Lag returns the greatest lag of any of the Publishers.
+func (ps Publishers) Lag() int {
+	lag := 0
+	for _, p := range ps {
+		if l := p.Lag(); l > lag {
+			lag = l
+		}
+	}
+	return lag
+}
+
+// Stats returns stats on each of the Publishers, keyed by name.
+func (ps Publishers) Stats() (map[string]interface{}, error) {
+	m := make(map[string]interface{}, len(ps))
+	for _, p := range ps {
+		st, err := p.Stats()
+		if err != nil {
+			return nil, err
+		}
+		m[p.name] = st
+	}
+	return m, nil
+}
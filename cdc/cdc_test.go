@@ -0,0 +1,317 @@
+package cdc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rqlite/rqlite/command"
+	"github.com/rqlite/rqlite/store"
+)
+
+func Test_ParseConfig(t *testing.T) {
+	cfg, err := ParseConfig(strings.NewReader(`{"rest_proxy": "http://localhost:8082", "topic": "changes", "tables": ["foo"], "interval": "5s"}`))
+	if err != nil {
+		t.Fatalf("failed to parse config: %s", err)
+	}
+	if cfg.Format != FormatJSON || cfg.BatchSize != DefaultBatchSize || cfg.LogSize != DefaultLogSize ||
+		cfg.MaxRetries != DefaultMaxRetries || time.Duration(cfg.Interval) != 5*time.Second {
+		t.Fatalf("defaults not applied to config: %+v", cfg)
+	}
+	cfg, err = ParseConfig(strings.NewReader(`{"webhook": {"url": "http://localhost:8000/changes"}, "nats": {"url": "nats://localhost", "subject": "changes.{table}"}}`))
+	if err != nil {
+		t.Fatalf("failed to parse config: %s", err)
+	}
+	pubs, err := New(cfg, &mockStore{})
+	if err != nil {
+		t.Fatalf("failed to create publishers: %s", err)
+	}
+	if len(pubs) != 2 || pubs[0].Name() != "webhook" || pubs[1].Name() != "nats" {
+		t.Fatalf("wrong publishers created: %v", pubs)
+	}
+
+	for _, s := range []string{
+		`{}`,
+		`{"topic": "changes"}`,
+		`{"rest_proxy": "ftp://localhost", "topic": "changes"}`,
+		`{"rest_proxy": "http://localhost:8082"}`,
+		`{"rest_proxy": "http://localhost:8082", "topic": "changes", "format": "protobuf"}`,
+		`{"rest_proxy": "http://localhost:8082", "topic": "changes", "batch_size": -1}`,
+		`{"webhook": {"url": "localhost:8000"}}`,
+		`{"nats": {"url": "http://localhost", "subject": "changes"}}`,
+		`{"nats": {"url": "nats://localhost"}}`,
+	} {
+		if _, err := ParseConfig(strings.NewReader(s)); !errors.Is(err, ErrInvalidConfig) {
+			t.Fatalf("expected invalid config error for %s, got %v", s, err)
+		}
+	}
+}
+
+func Test_PublisherJSON(t *testing.T) {
+	proxy := newMockProxy()
+	defer proxy.Close()
+	str := &mockStore{
+		applied: 4,
+		changes: []*store.RowChange{
+			{Index: 2, Table: "foo", Op: "insert", RowID: 1},
+			{Index: 2, Table: "bar", Op: "insert", RowID: 1},
+			{Index: 3, Table: "_rqlite_cdc", Op: "insert", RowID: 1},
+			{Index: 4, Table: "foo", Op: "delete", RowID: 1},
+		},
+	}
+	p := mustNewPublisher(t, proxy.URL, str, `"tables": ["FOO"]`)
+
+	if err := p.publish(context.Background()); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+	if len(proxy.requests) != 1 {
+		t.Fatalf("expected 1 request to REST Proxy, got %d", len(proxy.requests))
+	}
+	if exp, got := jsonContentType, proxy.contentTypes[0]; exp != got {
+		t.Fatalf("wrong content type, exp %s, got %s", exp, got)
+	}
+	if exp, got := `{"records":[{"key":"foo:1","value":{"index":2,"table":"foo","op":"insert","rowid":1,"row":{"id":1,"name":"fiona"}}},`+
+		`{"key":"foo:1","value":{"index":4,"table":"foo","op":"delete","rowid":1,"row":null}}]}`, proxy.requests[0]; exp != got {
+		t.Fatalf("wrong request to REST Proxy\nexp: %s\ngot: %s", exp, got)
+	}
+	if str.hwm != 4 {
+		t.Fatalf("wrong high-water mark, exp 4, got %d", str.hwm)
+	}
+
+	// Nothing more is published until more changes are made, and a new
+	// Publisher resumes from the high-water mark.
+	p = mustNewPublisher(t, proxy.URL, str, `"tables": ["FOO"]`)
+	if err := p.Run(cancelledContext()); err != nil {
+		t.Fatalf("failed to run publisher: %s", err)
+	}
+	if p.cursor != 4 {
+		t.Fatalf("publisher did not resume from high-water mark, cursor is %d", p.cursor)
+	}
+	str.add(5, &store.RowChange{Index: 5, Table: "foo", Op: "update", RowID: 1})
+	if err := p.publish(context.Background()); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+	if len(proxy.requests) != 2 || !strings.Contains(proxy.requests[1], `"op":"update"`) || str.hwm != 5 {
+		t.Fatalf("update not published, requests %v, high-water mark %d", proxy.requests, str.hwm)
+	}
+}
+
+func Test_PublisherFailure(t *testing.T) {
+	proxy := newMockProxy()
+	defer proxy.Close()
+	str := &mockStore{
+		applied: 2,
+		changes: []*store.RowChange{{Index: 2, Table: "foo", Op: "delete", RowID: 1}},
+	}
+	p := mustNewPublisher(t, proxy.URL, str, `"max_retries": 1, "retry_backoff": "10ms"`)
+
+	// Changes are retried until acknowledged.
+	proxy.fail = true
+	if err := p.publish(context.Background()); err == nil {
+		t.Fatalf("expected error publishing to failing REST Proxy")
+	}
+	if str.hwm != 0 || p.cursor != 0 {
+		t.Fatalf("high-water mark advanced after failure to %d, cursor %d", str.hwm, p.cursor)
+	}
+	if proxy.attempts != 2 {
+		t.Fatalf("expected delivery to be retried once, got %d attempts", proxy.attempts)
+	}
+	proxy.fail = false
+	if err := p.publish(context.Background()); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+	if str.hwm != 2 {
+		t.Fatalf("wrong high-water mark, exp 2, got %d", str.hwm)
+	}
+
+	// Missed changes are skipped.
+	str.start = 10
+	str.add(12, &store.RowChange{Index: 12, Table: "foo", Op: "delete", RowID: 2})
+	if err := p.publish(context.Background()); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+	if str.hwm != 12 || stats.Get(numChangesMissed).String() != "1" {
+		t.Fatalf("missed changes not skipped, high-water mark %d, missed %s", str.hwm, stats.Get(numChangesMissed))
+	}
+}
+
+func Test_PublisherAvro(t *testing.T) {
+	proxy := newMockProxy()
+	defer proxy.Close()
+	str := &mockStore{
+		applied: 3,
+		changes: []*store.RowChange{
+			{Index: 2, Table: "foo", Op: "insert", RowID: 1},
+			{Index: 3, Table: "foo", Op: "delete", RowID: 1},
+		},
+	}
+	p := mustNewPublisher(t, proxy.URL, str, `"format": "avro", "batch_size": 1`)
+	if err := p.publish(context.Background()); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+	if len(proxy.requests) != 2 || proxy.contentTypes[0] != avroContentType {
+		t.Fatalf("expected 2 Avro requests, got %v, %v", proxy.contentTypes, proxy.requests)
+	}
+
+	// The schemas are sent until their IDs are known.
+	var req produceRequest
+	if err := json.Unmarshal([]byte(proxy.requests[0]), &req); err != nil {
+		t.Fatalf("failed to unmarshal request: %s", err)
+	}
+	if req.ValueSchema != avroValueSchema || req.KeySchema != avroKeySchema {
+		t.Fatalf("schemas not sent in first request: %s", proxy.requests[0])
+	}
+	if !strings.Contains(proxy.requests[0], `"row":{"string":"{\"id\":1,\"name\":\"fiona\"}"}`) {
+		t.Fatalf("row not encoded as Avro union: %s", proxy.requests[0])
+	}
+	if exp, got := `{"key_schema_id":1,"value_schema_id":2,"records":[{"key":"foo:1","value":{"index":3,"op":"delete","row":null,"rowid":1,"table":"foo"}}]}`,
+		proxy.requests[1]; exp != got {
+		t.Fatalf("wrong second request\nexp: %s\ngot: %s", exp, got)
+	}
+}
+
+func mustNewPublisher(t *testing.T, proxyURL string, str Store, extra string) *Publisher {
+	t.Helper()
+	if extra != "" {
+		extra = ", " + extra
+	}
+	cfg, err := ParseConfig(strings.NewReader(`{"rest_proxy": "` + proxyURL + `", "topic": "changes"` + extra + `}`))
+	if err != nil {
+		t.Fatalf("failed to parse config: %s", err)
+	}
+	pubs, err := New(cfg, str)
+	if err != nil {
+		t.Fatalf("failed to create publisher: %s", err)
+	}
+	return pubs[0]
+}
+
+func cancelledContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
+
+type mockProxy struct {
+	*httptest.Server
+	fail         bool
+	attempts     int
+	requests     []string
+	contentTypes []string
+}
+
+func newMockProxy() *mockProxy {
+	m := &mockProxy{}
+	m.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.attempts++
+		if m.fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Path != "/topics/changes" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		m.requests = append(m.requests, string(b))
+		m.contentTypes = append(m.contentTypes, r.Header.Get("Content-Type"))
+		var req produceRequest
+		json.Unmarshal(b, &req)
+		offsets := make([]string, len(req.Records))
+		for i := range offsets {
+			offsets[i] = `{"partition":0,"offset":1}`
+		}
+		w.Write([]byte(`{"key_schema_id":1,"value_schema_id":2,"offsets":[` + strings.Join(offsets, ",") + `]}`))
+	}))
+	return m
+}
+
+type mockStore struct {
+	mu      sync.Mutex
+	changes []*store.RowChange
+	applied uint64
+	start   uint64
+	hwm     uint64
+	hwmKey  string
+	hwmSet  bool
+}
+
+func (m *mockStore) add(applied uint64, rc *store.RowChange) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.changes = append(m.changes, rc)
+	m.applied = applied
+}
+
+func (m *mockStore) RowChanges(since uint64, max int) ([]*store.RowChange, uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if since < m.start {
+		return nil, m.start, store.ErrRowChangesMissed
+	}
+	var changes []*store.RowChange
+	for _, rc := range m.changes {
+		if rc.Index <= since {
+			continue
+		}
+		if len(changes) >= max && rc.Index != changes[len(changes)-1].Index {
+			return changes, changes[len(changes)-1].Index, nil
+		}
+		changes = append(changes, rc)
+	}
+	return changes, m.applied, nil
+}
+
+func (m *mockStore) AppliedIndex() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.applied
+}
+
+func (m *mockStore) Execute(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stmts := er.Request.Statements
+	m.hwmKey = stmts[len(stmts)-1].Parameters[0].GetS()
+	m.hwm = uint64(stmts[len(stmts)-1].Parameters[1].GetI())
+	m.hwmSet = true
+	results := make([]*command.ExecuteResult, len(stmts))
+	for i := range results {
+		results[i] = &command.ExecuteResult{}
+	}
+	return results, nil
+}
+
+func (m *mockStore) Query(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var rows []*command.QueryRows
+	for _, stmt := range qr.Request.Statements {
+		if strings.Contains(stmt.Sql, positionTable) {
+			r := &command.QueryRows{Columns: []string{"idx"}, Types: []string{"integer"}}
+			if !m.hwmSet {
+				r.Error = "no such table: " + positionTable
+			} else {
+				r.Values = []*command.Values{{Parameters: []*command.Parameter{{Value: &command.Parameter_I{I: int64(m.hwm)}}}}}
+			}
+			rows = append(rows, r)
+			continue
+		}
+		rows = append(rows, &command.QueryRows{
+			Columns: []string{"id", "name"},
+			Types:   []string{"integer", "text"},
+			Values: []*command.Values{{Parameters: []*command.Parameter{
+				{Value: &command.Parameter_I{I: 1}},
+				{Value: &command.Parameter_S{S: "fiona"}},
+			}}},
+		})
+	}
+	return rows, nil
+}
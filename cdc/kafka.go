@@ -0,0 +1,209 @@
+package cdc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rqlite/rqlite/auto"
+)
+
+// DefaultTimeout is the default timeout of each request to the REST Proxy.
+const DefaultTimeout = 10 * time.Second
+
+const (
+	jsonContentType = "application/vnd.kafka.json.v2+json"
+	avroContentType = "application/vnd.kafka.avro.v2+json"
+	acceptType      = "application/vnd.kafka.v2+json"
+
+	// avroKeySchema and avroValueSchema are the Avro schemas of the keys
+	// and values of published records. Since the columns of each table
+	// differ, the row is carried as a JSON-encoded string.
+	avroKeySchema   = `"string"`
+	avroValueSchema = `{"type":"record","name":"RowChange","namespace":"io.rqlite.cdc","fields":[` +
+		`{"name":"index","type":"long"},{"name":"table","type":"string"},{"name":"op","type":"string"},` +
+		`{"name":"rowid","type":"long"},{"name":"row","type":["null","string"],"default":null}]}`
+)
+
+// Producer produces records to a Kafka topic through the Kafka REST Proxy,
+// using version 2 of its API.
+type Producer struct {
+	url     string
+	topic   string
+	format  string
+	headers map[string]string
+	client  *http.Client
+
+	// The IDs of the registered Avro schemas, once known, so that the
+	// schemas need not be sent with every request.
+	mu            sync.Mutex
+	keySchemaID   int
+	valueSchemaID int
+}
+
+// NewProducer returns a Producer of records to the topic set in cfg.
+func NewProducer(cfg *Config) (*Producer, error) {
+	if _, err := url.Parse(cfg.RESTProxy); err != nil {
+		return nil, fmt.Errorf("invalid REST Proxy URL: %s", err)
+	}
+	timeout := time.Duration(cfg.Timeout)
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	format := cfg.Format
+	if format == "" {
+		format = FormatJSON
+	}
+	return &Producer{
+		url:     strings.TrimSuffix(cfg.RESTProxy, "/") + "/topics/" + url.PathEscape(cfg.Topic),
+		topic:   cfg.Topic,
+		format:  format,
+		headers: cfg.Headers,
+		client:  &http.Client{Timeout: timeout},
+	}, nil
+}
+
+type produceRecord struct {
+	Key   string      `json:"key"`
+	Value interface{} `json:"value"`
+}
+
+type produceRequest struct {
+	KeySchema     string          `json:"key_schema,omitempty"`
+	KeySchemaID   int             `json:"key_schema_id,omitempty"`
+	ValueSchema   string          `json:"value_schema,omitempty"`
+	ValueSchemaID int             `json:"value_schema_id,omitempty"`
+	Records       []produceRecord `json:"records"`
+}
+
+type produceResponse struct {
+	KeySchemaID   int `json:"key_schema_id"`
+	ValueSchemaID int `json:"value_schema_id"`
+	Offsets       []struct {
+		Partition int    `json:"partition"`
+		Offset    int64  `json:"offset"`
+		ErrorCode *int   `json:"error_code"`
+		Error     string `json:"error"`
+	} `json:"offsets"`
+}
+
+// String describes the topic to which records are produced.
+func (p *Producer) String() string {
+	return "Kafka topic " + p.topic
+}
+
+// Send produces a record for each event. It returns nil only once every
+// record has been acknowledged.
+func (p *Producer) Send(ctx context.Context, events []*Event) error {
+	req := &produceRequest{Records: make([]produceRecord, len(events))}
+	contentType := jsonContentType
+	if p.format == FormatAvro {
+		contentType = avroContentType
+		p.mu.Lock()
+		req.KeySchemaID, req.ValueSchemaID = p.keySchemaID, p.valueSchemaID
+		p.mu.Unlock()
+		if req.KeySchemaID == 0 || req.ValueSchemaID == 0 {
+			req.KeySchemaID, req.ValueSchemaID = 0, 0
+			req.KeySchema, req.ValueSchema = avroKeySchema, avroValueSchema
+		}
+	}
+	for i, ev := range events {
+		req.Records[i].Key = ev.Key()
+		if p.format == FormatAvro {
+			v, err := avroValue(ev)
+			if err != nil {
+				return err
+			}
+			req.Records[i].Value = v
+		} else {
+			req.Records[i].Value = ev
+		}
+	}
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	hr, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	hr.Header.Set("Content-Type", contentType)
+	hr.Header.Set("Accept", acceptType)
+	for k, v := range p.headers {
+		hr.Header.Set(k, v)
+	}
+	resp, err := p.client.Do(hr)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return statusError(resp)
+	}
+
+	var pr produceResponse
+	if err := json.NewDecoder(resp.Body).Decode(&pr); err != nil {
+		return fmt.Errorf("failed to decode REST Proxy response: %s", err)
+	}
+	if len(pr.Offsets) != len(events) {
+		return fmt.Errorf("REST Proxy acknowledged %d records, expected %d", len(pr.Offsets), len(events))
+	}
+	for _, o := range pr.Offsets {
+		if o.ErrorCode != nil {
+			return fmt.Errorf("failed to produce record to partition %d: %s (error code %d)",
+				o.Partition, o.Error, *o.ErrorCode)
+		}
+	}
+	if p.format == FormatAvro && pr.KeySchemaID != 0 && pr.ValueSchemaID != 0 {
+		p.mu.Lock()
+		p.keySchemaID, p.valueSchemaID = pr.KeySchemaID, pr.ValueSchemaID
+		p.mu.Unlock()
+	}
+	return nil
+}
+
+// statusError returns the error of an unsuccessful HTTP response. If the
+// server is overloaded and says when to retry, a RetryAfterError is
+// returned.
+func statusError(resp *http.Response) error {
+	err := &auto.StatusError{Code: resp.StatusCode, Status: resp.Status}
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return err
+	}
+	secs, perr := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if perr != nil || secs <= 0 {
+		return err
+	}
+	return &RetryAfterError{Err: err, After: time.Duration(secs) * time.Second}
+}
+
+// avroValue returns the value of an event in the JSON encoding of Avro
+// expected by the REST Proxy, in which a non-null union is keyed by type.
+func avroValue(ev *Event) (map[string]interface{}, error) {
+	var row interface{}
+	if ev.Row != nil {
+		b, err := json.Marshal(ev.Row)
+		if err != nil {
+			return nil, err
+		}
+		row = map[string]string{"string": string(b)}
+	}
+	return map[string]interface{}{
+		"index": ev.Index,
+		"table": ev.Table,
+		"op":    ev.Op,
+		"rowid": ev.RowID,
+		"row":   row,
+	}, nil
+}
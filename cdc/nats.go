@@ -0,0 +1,359 @@
+package cdc
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// natsDefaultPort is the port of a NATS server if its URL does not set one.
+const natsDefaultPort = "4222"
+
+// tablePlaceholder is replaced, in the subject of a NATS message, by the
+// table of its event.
+const tablePlaceholder = "{table}"
+
+// NATSConfig is the configuration of delivery to a NATS subject.
+type NATSConfig struct {
+	// URL is the URL of the NATS server, nats://host:port, or tls://host:port
+	// to connect over TLS.
+	URL string `json:"url"`
+
+	// Subject is the subject to which events are published. Any {table} in
+	// the subject is replaced by the table of the event, for example
+	// rqlite.changes.{table}.
+	Subject string `json:"subject"`
+
+	// User and Password, or Token, authenticate the connection. If not set,
+	// the connection is not authenticated.
+	User     string `json:"user,omitempty"`
+	Password string `json:"password,omitempty"`
+	Token    string `json:"token,omitempty"`
+
+	// JetStream sets whether events are published to a JetStream stream, in
+	// which case each is only acknowledged once the stream has stored it.
+	// Otherwise events are acknowledged once the server has received them.
+	JetStream bool `json:"jetstream,omitempty"`
+}
+
+func (c *NATSConfig) validate() error {
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "nats" && u.Scheme != "tls") || u.Host == "" {
+		return errors.New("NATS URL must be nats or tls")
+	}
+	if c.Subject == "" || strings.ContainsAny(c.Subject, " \t\r\n") {
+		return errors.New("NATS subject not set, or contains whitespace")
+	}
+	return nil
+}
+
+// NATS delivers events to a NATS subject, each as a message whose payload
+// is the JSON-encoded event. It speaks the NATS client protocol directly,
+// and reconnects on the next delivery after any failure.
+type NATS struct {
+	cfg     *NATSConfig
+	timeout time.Duration
+
+	mu    sync.Mutex
+	conn  net.Conn
+	r     *bufio.Reader
+	inbox string // Prefix of the subjects of JetStream acknowledgements.
+}
+
+// NewNATS returns a NATS sink delivering events as configured by cfg. If
+// timeout is zero, DefaultTimeout is used.
+func NewNATS(cfg *NATSConfig, timeout time.Duration) *NATS {
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	return &NATS{
+		cfg:     cfg,
+		timeout: timeout,
+	}
+}
+
+// String describes the NATS subject.
+func (n *NATS) String() string {
+	return "NATS subject " + n.cfg.Subject
+}
+
+// Send publishes a message for each event. It returns nil only once the
+// server, or the JetStream stream, has acknowledged every message.
+func (n *NATS) Send(ctx context.Context, events []*Event) (retErr error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	deadline := time.Now().Add(n.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if n.conn == nil {
+		if err := n.connect(deadline); err != nil {
+			return err
+		}
+	}
+	defer func() {
+		if retErr != nil {
+			n.closeLocked()
+		}
+	}()
+	if err := n.conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+
+	// Close the connection if the context is cancelled, so that blocked
+	// reads and writes return.
+	done := make(chan struct{})
+	defer close(done)
+	conn := n.conn
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	w := bufio.NewWriter(n.conn)
+	for i, ev := range events {
+		b, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		subject := strings.Replace(n.cfg.Subject, tablePlaceholder, ev.Table, -1)
+		if n.cfg.JetStream {
+			fmt.Fprintf(w, "PUB %s %s.%d %d\r\n", subject, n.inbox, i, len(b))
+		} else {
+			fmt.Fprintf(w, "PUB %s %d\r\n", subject, len(b))
+		}
+		w.Write(b)
+		w.WriteString("\r\n")
+	}
+	if !n.cfg.JetStream {
+		// The server handles a connection's messages in order, so the reply
+		// to a PING follows its handling of every message.
+		w.WriteString("PING\r\n")
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if !n.cfg.JetStream {
+		return n.waitPong()
+	}
+	return n.waitAcks(len(events))
+}
+
+// Close closes the connection to the server, if any.
+func (n *NATS) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.closeLocked()
+}
+
+func (n *NATS) closeLocked() error {
+	if n.conn == nil {
+		return nil
+	}
+	err := n.conn.Close()
+	n.conn, n.r = nil, nil
+	return err
+}
+
+// connect connects to the server, and subscribes to acknowledgements of
+// JetStream messages, if needed.
+func (n *NATS) connect(deadline time.Time) error {
+	u, err := url.Parse(n.cfg.URL)
+	if err != nil {
+		return err
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), natsDefaultPort)
+	}
+	dialer := &net.Dialer{Deadline: deadline}
+	var conn net.Conn
+	if u.Scheme == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, &tls.Config{ServerName: u.Hostname()})
+	} else {
+		conn, err = dialer.Dial("tcp", host)
+	}
+	if err != nil {
+		return err
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return err
+	}
+	n.conn, n.r = conn, bufio.NewReader(conn)
+
+	if err := n.handshake(); err != nil {
+		n.closeLocked()
+		return fmt.Errorf("failed to connect to NATS server %s: %s", host, err)
+	}
+	return nil
+}
+
+func (n *NATS) handshake() error {
+	line, err := n.readLine()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return fmt.Errorf("unexpected greeting %q", line)
+	}
+
+	connect := map[string]interface{}{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     "rqlite-cdc",
+		"lang":     "go",
+		"version":  "1.0.0",
+		"protocol": 1,
+	}
+	if n.cfg.User != "" {
+		connect["user"], connect["pass"] = n.cfg.User, n.cfg.Password
+	}
+	if n.cfg.Token != "" {
+		connect["auth_token"] = n.cfg.Token
+	}
+	b, err := json.Marshal(connect)
+	if err != nil {
+		return err
+	}
+	cmd := "CONNECT " + string(b) + "\r\n"
+	if n.cfg.JetStream {
+		inbox, err := newInbox()
+		if err != nil {
+			return err
+		}
+		n.inbox = inbox
+		cmd += "SUB " + inbox + ".* 1\r\n"
+	}
+	if _, err := n.conn.Write([]byte(cmd + "PING\r\n")); err != nil {
+		return err
+	}
+	return n.waitPong()
+}
+
+// waitPong reads from the server until its reply to a PING.
+func (n *NATS) waitPong() error {
+	for {
+		line, err := n.readOp()
+		if err != nil {
+			return err
+		}
+		if line == "PONG" {
+			return nil
+		}
+		if strings.HasPrefix(line, "MSG ") {
+			if _, err := n.readPayload(line); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// waitAcks reads from the server until JetStream has acknowledged count
+// messages.
+func (n *NATS) waitAcks(count int) error {
+	acked := make([]bool, count)
+	for remaining := count; remaining > 0; {
+		line, err := n.readOp()
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(line, "MSG ") {
+			continue
+		}
+		payload, err := n.readPayload(line)
+		if err != nil {
+			return err
+		}
+		subject := strings.Fields(line)[1]
+		i, err := strconv.Atoi(strings.TrimPrefix(subject, n.inbox+"."))
+		if err != nil || i < 0 || i >= count || acked[i] {
+			continue
+		}
+		var ack struct {
+			Stream string `json:"stream"`
+			Error  *struct {
+				Code        int    `json:"code"`
+				Description string `json:"description"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(payload, &ack); err != nil {
+			return fmt.Errorf("invalid JetStream acknowledgement: %s", err)
+		}
+		if ack.Error != nil {
+			return fmt.Errorf("JetStream rejected message: %s (code %d)", ack.Error.Description, ack.Error.Code)
+		}
+		acked[i] = true
+		remaining--
+	}
+	return nil
+}
+
+// readOp reads the next operation from the server which is not handled
+// here. PINGs are answered, and errors returned.
+func (n *NATS) readOp() (string, error) {
+	for {
+		line, err := n.readLine()
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case line == "PING":
+			if _, err := n.conn.Write([]byte("PONG\r\n")); err != nil {
+				return "", err
+			}
+		case line == "+OK", strings.HasPrefix(line, "INFO "):
+		case strings.HasPrefix(line, "-ERR"):
+			return "", fmt.Errorf("NATS server error: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		default:
+			return line, nil
+		}
+	}
+}
+
+// readPayload reads the payload of the message whose MSG line is line.
+func (n *NATS) readPayload(line string) ([]byte, error) {
+	fields := strings.Fields(line)
+	size, err := strconv.Atoi(fields[len(fields)-1])
+	if err != nil || size < 0 {
+		return nil, fmt.Errorf("invalid message from NATS server: %q", line)
+	}
+	b := make([]byte, size+2)
+	if _, err := io.ReadFull(n.r, b); err != nil {
+		return nil, err
+	}
+	return b[:size], nil
+}
+
+func (n *NATS) readLine() (string, error) {
+	line, err := n.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func newInbox() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "_INBOX." + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,180 @@
+package cdc
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func Test_NATSSend(t *testing.T) {
+	srv := newMockNATS(t)
+	defer srv.Close()
+
+	n := NewNATS(&NATSConfig{URL: "nats://" + srv.Addr(), Subject: "changes.{table}", Token: "secret"}, 0)
+	defer n.Close()
+	events := []*Event{
+		{Index: 2, Table: "foo", Op: "insert", RowID: 1},
+		{Index: 3, Table: "bar", Op: "delete", RowID: 2},
+	}
+	if err := n.Send(context.Background(), events); err != nil {
+		t.Fatalf("failed to send: %s", err)
+	}
+	msgs := srv.Messages()
+	if len(msgs) != 2 || msgs[0] != `changes.foo {"index":2,"table":"foo","op":"insert","rowid":1,"row":null}` ||
+		!strings.HasPrefix(msgs[1], "changes.bar ") {
+		t.Fatalf("wrong messages published: %v", msgs)
+	}
+	if !strings.Contains(srv.Connect(), `"auth_token":"secret"`) {
+		t.Fatalf("token not sent on connect: %s", srv.Connect())
+	}
+
+	// Errors from the server fail delivery, and the next delivery reconnects.
+	srv.SetErr("Permissions Violation")
+	if err := n.Send(context.Background(), events); err == nil || !strings.Contains(err.Error(), "Permissions Violation") {
+		t.Fatalf("expected server error, got %v", err)
+	}
+	srv.SetErr("")
+	if err := n.Send(context.Background(), events[:1]); err != nil {
+		t.Fatalf("failed to send after reconnecting: %s", err)
+	}
+	if len(srv.Messages()) != 3 {
+		t.Fatalf("expected 3 messages, got %d", len(srv.Messages()))
+	}
+}
+
+func Test_NATSSendJetStream(t *testing.T) {
+	srv := newMockNATS(t)
+	defer srv.Close()
+
+	n := NewNATS(&NATSConfig{URL: "nats://" + srv.Addr(), Subject: "changes", JetStream: true}, 0)
+	defer n.Close()
+	events := []*Event{
+		{Index: 2, Table: "foo", Op: "insert", RowID: 1},
+		{Index: 3, Table: "foo", Op: "delete", RowID: 1},
+	}
+	if err := n.Send(context.Background(), events); err != nil {
+		t.Fatalf("failed to send: %s", err)
+	}
+	if len(srv.Messages()) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(srv.Messages()))
+	}
+
+	// Messages rejected by the stream fail delivery.
+	srv.mu.Lock()
+	srv.reject = true
+	srv.mu.Unlock()
+	if err := n.Send(context.Background(), events); err == nil || !strings.Contains(err.Error(), "no space") {
+		t.Fatalf("expected rejection by stream, got %v", err)
+	}
+}
+
+// mockNATS is a NATS server speaking just enough of the protocol to receive
+// messages, and to acknowledge them as a JetStream stream would.
+type mockNATS struct {
+	ln net.Listener
+
+	mu       sync.Mutex
+	messages []string
+	connect  string
+	err      string
+	reject   bool
+}
+
+func newMockNATS(t *testing.T) *mockNATS {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	m := &mockNATS{ln: ln}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go m.serve(conn)
+		}
+	}()
+	return m
+}
+
+func (m *mockNATS) Addr() string {
+	return m.ln.Addr().String()
+}
+
+func (m *mockNATS) Close() {
+	m.ln.Close()
+}
+
+func (m *mockNATS) Messages() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.messages...)
+}
+
+func (m *mockNATS) Connect() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.connect
+}
+
+func (m *mockNATS) SetErr(e string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.err = e
+}
+
+func (m *mockNATS) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	fmt.Fprintf(conn, "INFO {\"server_id\":\"mock\",\"max_payload\":1048576}\r\n")
+	seq := 0
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		fields := strings.Fields(line)
+		switch {
+		case strings.HasPrefix(line, "CONNECT "):
+			m.mu.Lock()
+			m.connect = line
+			m.mu.Unlock()
+		case line == "PING":
+			fmt.Fprintf(conn, "PONG\r\n")
+		case strings.HasPrefix(line, "SUB "):
+		case strings.HasPrefix(line, "PUB "):
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+			b := make([]byte, size+2)
+			if _, err := io.ReadFull(r, b); err != nil {
+				return
+			}
+			m.mu.Lock()
+			e, reject := m.err, m.reject
+			if e == "" && !reject {
+				m.messages = append(m.messages, fields[1]+" "+string(b[:size]))
+			}
+			m.mu.Unlock()
+			if e != "" {
+				fmt.Fprintf(conn, "-ERR '%s'\r\n", e)
+				continue
+			}
+			if len(fields) == 4 {
+				ack := fmt.Sprintf(`{"stream":"changes","seq":%d}`, seq+1)
+				if reject {
+					ack = `{"error":{"code":503,"description":"no space"}}`
+				} else {
+					seq++
+				}
+				fmt.Fprintf(conn, "MSG %s 1 %d\r\n%s\r\n", fields[2], len(ack), ack)
+			}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package cdc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// WebhookConfig is the configuration of delivery to an HTTP webhook.
+type WebhookConfig struct {
+	// URL is the URL to which batches of events are POSTed.
+	URL string `json:"url"`
+
+	// Headers are set on every request to the webhook, such as for
+	// authentication.
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// Webhook delivers events to an HTTP webhook. Each batch is POSTed as a JSON
+// object whose "events" member is the array of events, in order. Any 2xx
+// response acknowledges the batch. A 429 or 503 response with a Retry-After
+// header slows delivery.
+type Webhook struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+type webhookRequest struct {
+	Events []*Event `json:"events"`
+}
+
+// NewWebhook returns a Webhook delivering events as configured by cfg. If
+// timeout is zero, DefaultTimeout is used.
+func NewWebhook(cfg *WebhookConfig, timeout time.Duration) (*Webhook, error) {
+	if !isHTTPURL(cfg.URL) {
+		return nil, fmt.Errorf("%w: webhook URL must be http or https", ErrInvalidConfig)
+	}
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	return &Webhook{
+		url:     cfg.URL,
+		headers: cfg.Headers,
+		client:  &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// String describes the webhook.
+func (w *Webhook) String() string {
+	return "webhook " + w.url
+}
+
+// Send POSTs the events to the webhook.
+func (w *Webhook) Send(ctx context.Context, events []*Event) error {
+	b, err := json.Marshal(&webhookRequest{Events: events})
+	if err != nil {
+		return err
+	}
+	hr, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	hr.Header.Set("Content-Type", "application/json")
+	for k, v := range w.headers {
+		hr.Header.Set(k, v)
+	}
+	resp, err := w.client.Do(hr)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return statusError(resp)
+	}
+	return nil
+}
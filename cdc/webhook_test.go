@@ -0,0 +1,97 @@
+package cdc
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rqlite/rqlite/store"
+)
+
+func Test_WebhookSend(t *testing.T) {
+	var bodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+	}))
+	defer ts.Close()
+
+	cfg, err := ParseConfig(strings.NewReader(`{"webhook": {"url": "` + ts.URL + `", "headers": {"Authorization": "Bearer secret"}}}`))
+	if err != nil {
+		t.Fatalf("failed to parse config: %s", err)
+	}
+	str := &mockStore{
+		applied: 2,
+		changes: []*store.RowChange{{Index: 2, Table: "foo", Op: "insert", RowID: 1}},
+	}
+	pubs, err := New(cfg, str)
+	if err != nil {
+		t.Fatalf("failed to create publishers: %s", err)
+	}
+	if err := pubs[0].publish(context.Background()); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+	if exp, got := []string{`{"events":[{"index":2,"table":"foo","op":"insert","rowid":1,"row":{"id":1,"name":"fiona"}}]}`}, bodies; len(got) != 1 || got[0] != exp[0] {
+		t.Fatalf("wrong requests to webhook\nexp: %s\ngot: %s", exp, got)
+	}
+	if str.hwm != 2 || str.hwmKey != "webhook" {
+		t.Fatalf("wrong high-water mark, exp 2 for webhook, got %d for %s", str.hwm, str.hwmKey)
+	}
+}
+
+func Test_WebhookRetryAfter(t *testing.T) {
+	attempts := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer ts.Close()
+
+	wh, err := NewWebhook(&WebhookConfig{URL: ts.URL}, 0)
+	if err != nil {
+		t.Fatalf("failed to create webhook: %s", err)
+	}
+	err = wh.Send(context.Background(), []*Event{{Index: 1, Table: "foo", Op: "delete", RowID: 1}})
+	var ra *RetryAfterError
+	if !errors.As(err, &ra) || ra.After != time.Second {
+		t.Fatalf("expected Retry-After error of 1s, got %v", err)
+	}
+
+	// The Publisher waits for at least as long as the webhook asks.
+	p := NewPublisher("webhook", "webhook", wh, &Config{MaxRetries: 1, RetryBackoff: 1}, &mockStore{})
+	start := time.Now()
+	attempts = 0
+	if err := p.send(context.Background(), []*Event{{Index: 1, Table: "foo", Op: "delete", RowID: 1}}); err != nil {
+		t.Fatalf("failed to send after retry: %s", err)
+	}
+	if attempts != 2 || time.Since(start) < time.Second {
+		t.Fatalf("Retry-After not honored, %d attempts in %s", attempts, time.Since(start))
+	}
+}
+
+func Test_PublishersLag(t *testing.T) {
+	str := &mockStore{applied: 10}
+	p := NewPublisher("webhook", "webhook", &Webhook{}, &Config{}, str)
+	pubs := Publishers{p}
+	if pubs.Lag() != 0 {
+		t.Fatalf("expected no lag from publisher not running, got %d", pubs.Lag())
+	}
+	p.mu.Lock()
+	p.running, p.cursor = true, 4
+	p.mu.Unlock()
+	if pubs.Lag() != 6 {
+		t.Fatalf("wrong lag, exp 6, got %d", pubs.Lag())
+	}
+}
@@ -0,0 +1,283 @@
+// Package client is a client of the HTTP API of rqlite. It executes and
+// queries statements on a cluster, given the addresses of any of its nodes,
+// failing over to another node when the one in use cannot be reached, and
+// following redirects to the leader.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTimeout is the default timeout of each request to a node.
+	DefaultTimeout = 30 * time.Second
+
+	// DefaultMaxRedirects is the default number of redirects a request
+	// follows before failing.
+	DefaultMaxRedirects = 10
+)
+
+// protobufContentType is the content type of the bodies of requests, which
+// are command.Request messages encoded using Protocol Buffers.
+const protobufContentType = "application/x-protobuf"
+
+var (
+	// ErrNoHosts is returned by New when no node addresses are given.
+	ErrNoHosts = errors.New("no hosts")
+
+	// ErrTooManyRedirects is returned when a request is redirected more
+	// times than allowed, such as while the cluster elects a leader.
+	ErrTooManyRedirects = errors.New("too many redirects")
+)
+
+// StatusError is returned when a node responds to a request with an
+// unsuccessful HTTP status.
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("unexpected status %d", e.Code)
+	}
+	return fmt.Sprintf("unexpected status %d: %s", e.Code, e.Message)
+}
+
+// Config is the configuration of a Client.
+type Config struct {
+	// TLSConfig is the TLS configuration used to connect to nodes. If set,
+	// nodes are connected to over HTTPS.
+	TLSConfig *tls.Config
+
+	// Username and Password are sent using HTTP basic authentication, if
+	// Username is set.
+	Username string
+	Password string
+
+	// Timeout is the timeout of each request to a node. If zero,
+	// DefaultTimeout is used.
+	Timeout time.Duration
+
+	// MaxRedirects is the number of redirects a request follows before
+	// failing. If zero, DefaultMaxRedirects is used.
+	MaxRedirects int
+
+	// Level is the read consistency of queries which do not set their own.
+	// If not set, the node's default, LevelWeak, applies.
+	Level Level
+}
+
+// Client is a client of an rqlite cluster. It is safe for concurrent use.
+type Client struct {
+	hosts        []string
+	scheme       string
+	username     string
+	password     string
+	maxRedirects int
+	level        Level
+	client       *http.Client
+
+	// The index in hosts of the node last reachable, to which requests
+	// are sent first.
+	mu      sync.Mutex
+	current int
+}
+
+// New returns a Client of the cluster of which the nodes at hosts, each a
+// host and port such as "localhost:4001", are members. If cfg is nil, the
+// defaults are used.
+func New(hosts []string, cfg *Config) (*Client, error) {
+	if len(hosts) == 0 {
+		return nil, ErrNoHosts
+	}
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	for _, h := range hosts {
+		if _, _, err := net.SplitHostPort(h); err != nil {
+			return nil, fmt.Errorf("invalid host %q: %s", h, err)
+		}
+	}
+	if cfg.Level != "" && !cfg.Level.valid() {
+		return nil, fmt.Errorf("invalid read consistency level %q", cfg.Level)
+	}
+
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	maxRedirects := cfg.MaxRedirects
+	if maxRedirects == 0 {
+		maxRedirects = DefaultMaxRedirects
+	}
+	scheme := "http"
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.TLSConfig != nil {
+		scheme = "https"
+		transport.TLSClientConfig = cfg.TLSConfig
+	}
+
+	return &Client{
+		hosts:        append([]string(nil), hosts...),
+		scheme:       scheme,
+		username:     cfg.Username,
+		password:     cfg.Password,
+		maxRedirects: maxRedirects,
+		level:        cfg.Level,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   timeout,
+			// Redirects are followed by the client, since net/http would
+			// resend a POST as a GET, without its body.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}, nil
+}
+
+// Close closes any idle connections to nodes.
+func (c *Client) Close() error {
+	c.client.CloseIdleConnections()
+	return nil
+}
+
+// Host returns the address of the node to which requests are sent first,
+// which is the node which last responded.
+func (c *Client) Host() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hosts[c.current]
+}
+
+// do sends a request with the given method, path, query parameters and body
+// to the nodes of the cluster in turn, starting with the node which last
+// responded, until one responds. Redirects are followed. A response with an
+// unsuccessful status is returned as a StatusError. Unless the request is
+// idempotent, it is only sent to another node if the last could not be
+// connected to, so that it is never applied twice.
+func (c *Client) do(ctx context.Context, method, path string, params url.Values, body []byte, idempotent bool) ([]byte, error) {
+	c.mu.Lock()
+	start := c.current
+	c.mu.Unlock()
+
+	var lastErr error
+	for i := 0; i < len(c.hosts); i++ {
+		n := (start + i) % len(c.hosts)
+		u := url.URL{
+			Scheme:   c.scheme,
+			Host:     c.hosts[n],
+			Path:     path,
+			RawQuery: params.Encode(),
+		}
+		b, err := c.doHost(ctx, method, u.String(), body)
+		if err == nil || !isUnreachable(err, idempotent) {
+			if i > 0 {
+				c.mu.Lock()
+				c.current = n
+				c.mu.Unlock()
+			}
+			return b, err
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		lastErr = err
+	}
+	return nil, fmt.Errorf("no host could be reached: %w", lastErr)
+}
+
+// doHost sends a request to a single node, following any redirects.
+func (c *Client) doHost(ctx context.Context, method, urlStr string, body []byte) ([]byte, error) {
+	for redirects := 0; ; redirects++ {
+		var rd io.Reader
+		if body != nil {
+			rd = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, urlStr, rd)
+		if err != nil {
+			return nil, err
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", protobufContentType)
+		}
+		if c.username != "" {
+			req.SetBasicAuth(c.username, c.password)
+		}
+
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		b, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+			return b, nil
+		case http.StatusMovedPermanently, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+			if redirects >= c.maxRedirects {
+				return nil, ErrTooManyRedirects
+			}
+			loc, err := resp.Location()
+			if err != nil {
+				return nil, fmt.Errorf("invalid redirect: %s", err)
+			}
+			urlStr = loc.String()
+		default:
+			return nil, &StatusError{Code: resp.StatusCode, Message: strings.TrimSpace(string(b))}
+		}
+	}
+}
+
+// isUnreachable returns whether err means a node could not be reached, so
+// that the request may be sent to another. A request which is not idempotent
+// may have been received, unless the node could not be connected to.
+func isUnreachable(err error, idempotent bool) bool {
+	var se *StatusError
+	if errors.As(err, &se) || errors.Is(err, ErrTooManyRedirects) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var oe *net.OpError
+	if errors.As(err, &oe) && oe.Op == "dial" {
+		return true
+	}
+	return idempotent
+}
+
+// response is the body of a response of the HTTP API to a request to
+// execute or query statements.
+type response struct {
+	Results []json.RawMessage `json:"results"`
+	Error   string            `json:"error"`
+}
+
+// decodeResponse decodes the body of a response, returning the JSON of the
+// result of each statement.
+func decodeResponse(b []byte) ([]json.RawMessage, error) {
+	var resp response
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %s", err)
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	return resp.Results, nil
+}
@@ -0,0 +1,291 @@
+package client
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rqlite/rqlite/command"
+	"google.golang.org/protobuf/proto"
+)
+
+func Test_NewClient(t *testing.T) {
+	if _, err := New(nil, nil); err != ErrNoHosts {
+		t.Fatalf("expected ErrNoHosts, got %v", err)
+	}
+	if _, err := New([]string{"localhost"}, nil); err == nil {
+		t.Fatalf("expected error for host without port")
+	}
+	if _, err := New([]string{"localhost:4001"}, &Config{Level: "eventual"}); err == nil {
+		t.Fatalf("expected error for invalid level")
+	}
+	c, err := New([]string{"localhost:4001", "localhost:4003"}, nil)
+	if err != nil {
+		t.Fatalf("failed to create client: %s", err)
+	}
+	defer c.Close()
+	if c.Host() != "localhost:4001" {
+		t.Fatalf("wrong first host: %s", c.Host())
+	}
+}
+
+func Test_ClientExecute(t *testing.T) {
+	var got *command.Request
+	var query string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/db/execute" || r.Method != http.MethodPost {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != protobufContentType {
+			t.Errorf("wrong content type: %s", ct)
+		}
+		if u, p, _ := r.BasicAuth(); u != "fiona" || p != "secret" {
+			t.Errorf("wrong credentials %s:%s", u, p)
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		got = &command.Request{}
+		if err := proto.Unmarshal(b, got); err != nil {
+			t.Errorf("failed to decode request: %s", err)
+		}
+		query = r.URL.RawQuery
+		w.Write([]byte(`{"results":[{"last_insert_id":9007199254740993,"rows_affected":1},{"error":"no such table: bar"}]}`))
+	}))
+	defer ts.Close()
+
+	c := mustNewClient(t, []string{hostOf(ts)}, &Config{Username: "fiona", Password: "secret"})
+	results, err := c.ExecuteWithOptions(context.Background(), &ExecuteOptions{Transaction: true},
+		NewStatement("INSERT INTO foo(id, name, data) VALUES(?, ?, ?)", int64(9007199254740993), "fiona", []byte{0, 1}),
+		NewStatement("INSERT INTO bar(name) VALUES(:name)", Named("name", nil)))
+	var se *StatementError
+	if !errors.As(err, &se) || se.Index != 1 || se.Message != "no such table: bar" {
+		t.Fatalf("expected error of second statement, got %v", err)
+	}
+	if len(results) != 2 || results[0].LastInsertID != 9007199254740993 || results[0].RowsAffected != 1 {
+		t.Fatalf("wrong results: %+v", results)
+	}
+	if query != "transaction=" {
+		t.Fatalf("wrong query parameters: %s", query)
+	}
+
+	params := got.Statements[0].Parameters
+	if len(params) != 3 || params[0].GetI() != 9007199254740993 || params[1].GetS() != "fiona" || string(params[2].GetY()) != "\x00\x01" {
+		t.Fatalf("wrong parameters: %v", params)
+	}
+	if p := got.Statements[1].Parameters[0]; p.Name != "name" || p.Value != nil {
+		t.Fatalf("wrong named parameter: %v", p)
+	}
+
+	if _, err := c.Execute(context.Background(), NewStatement("INSERT", struct{}{})); err == nil {
+		t.Fatalf("expected error for unsupported parameter type")
+	}
+}
+
+func Test_ClientQueryScan(t *testing.T) {
+	var query string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		w.Write([]byte(`{"results":[{"columns":["id","name","score","data","created","nick"],` +
+			`"types":["integer","text","real","blob","datetime","text"],` +
+			`"values":[[1,"fiona",2.5,"AAE=","2024-03-01 12:00:00",null],[2,"declan",3,null,"2024-03-02T12:00:00Z","dec"]]}]}`))
+	}))
+	defer ts.Close()
+
+	c := mustNewClient(t, []string{hostOf(ts)}, &Config{Level: LevelStrong})
+	rows, err := c.Query(context.Background(), NewStatement("SELECT * FROM foo"))
+	if err != nil {
+		t.Fatalf("failed to query: %s", err)
+	}
+	if query != "level=strong" {
+		t.Fatalf("wrong query parameters: %s", query)
+	}
+	r := rows[0]
+
+	var id int
+	var name string
+	var score float64
+	var data []byte
+	var created time.Time
+	var nick sql.NullString
+	if !r.Next() {
+		t.Fatalf("no first row")
+	}
+	if err := r.Scan(&id, &name, &score, &data, &created, &nick); err != nil {
+		t.Fatalf("failed to scan first row: %s", err)
+	}
+	if id != 1 || name != "fiona" || score != 2.5 || string(data) != "\x00\x01" || nick.Valid ||
+		!created.Equal(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Fatalf("wrong first row: %d %s %f %v %s %v", id, name, score, data, created, nick)
+	}
+	if !r.Next() {
+		t.Fatalf("no second row")
+	}
+	var scoreInt int64
+	if err := r.Scan(&id, &name, &scoreInt, &data, &created, &nick); err != nil {
+		t.Fatalf("failed to scan second row: %s", err)
+	}
+	if id != 2 || scoreInt != 3 || data != nil || !nick.Valid || nick.String != "dec" {
+		t.Fatalf("wrong second row: %d %d %v %v", id, scoreInt, data, nick)
+	}
+	if r.Next() {
+		t.Fatalf("unexpected third row")
+	}
+
+	// NULL cannot be scanned into a string, nor text into an integer.
+	r.row = 1
+	if err := r.Scan(&id, &name, &score, &data, &created, &name); err == nil {
+		t.Fatalf("expected error scanning NULL into string")
+	}
+	if err := r.Scan(&name, &id, &score, &data, &created, &nick); err == nil {
+		t.Fatalf("expected error scanning text into integer")
+	}
+
+	_, err = c.QueryWithOptions(context.Background(), &QueryOptions{Level: LevelNone, Freshness: time.Second},
+		NewStatement("SELECT * FROM foo"))
+	if err != nil {
+		t.Fatalf("failed to query: %s", err)
+	}
+	if query != "freshness=1s&level=none" {
+		t.Fatalf("wrong query parameters: %s", query)
+	}
+}
+
+func Test_ClientQueryLinearizable(t *testing.T) {
+	var query string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		w.Write([]byte(`{"results":[{"columns":["COUNT(*)"],"types":["integer"],"values":[[1]]}]}`))
+	}))
+	defer ts.Close()
+
+	c := mustNewClient(t, []string{hostOf(ts)}, &Config{Level: LevelLinearizable})
+	if _, err := c.Query(context.Background(), NewStatement("SELECT COUNT(*) FROM foo")); err != nil {
+		t.Fatalf("failed to query: %s", err)
+	}
+	if query != "level=linearizable" {
+		t.Fatalf("wrong query parameters: %s", query)
+	}
+
+	c = mustNewClient(t, []string{hostOf(ts)}, nil)
+	_, err := c.QueryWithOptions(context.Background(), &QueryOptions{Level: LevelLinearizable},
+		NewStatement("SELECT COUNT(*) FROM foo"))
+	if err != nil {
+		t.Fatalf("failed to query: %s", err)
+	}
+	if query != "level=linearizable" {
+		t.Fatalf("wrong query parameters: %s", query)
+	}
+}
+
+func Test_ClientRequest(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"results":[{"last_insert_id":1,"rows_affected":1},{"columns":["COUNT(*)"],"types":["integer"],"values":[[1]]}]}`))
+	}))
+	defer ts.Close()
+
+	c := mustNewClient(t, []string{hostOf(ts)}, nil)
+	results, err := c.Request(context.Background(), nil,
+		NewStatement("INSERT INTO foo(name) VALUES('fiona')"),
+		NewStatement("SELECT COUNT(*) FROM foo"))
+	if err != nil {
+		t.Fatalf("failed to request: %s", err)
+	}
+	if results[0].Execute == nil || results[0].Execute.LastInsertID != 1 || results[0].Rows != nil {
+		t.Fatalf("wrong first result: %+v", results[0])
+	}
+	if results[1].Rows == nil || results[1].Rows.Values[0][0] != int64(1) {
+		t.Fatalf("wrong second result: %+v", results[1])
+	}
+}
+
+func Test_ClientRedirect(t *testing.T) {
+	leader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if b, _ := ioutil.ReadAll(r.Body); len(b) == 0 || r.Method != http.MethodPost {
+			t.Errorf("redirected request lost its method or body")
+		}
+		w.Write([]byte(`{"results":[{"rows_affected":1}]}`))
+	}))
+	defer leader.Close()
+	follower := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, leader.URL+r.URL.Path, http.StatusMovedPermanently)
+	}))
+	defer follower.Close()
+
+	c := mustNewClient(t, []string{hostOf(follower)}, nil)
+	results, err := c.Execute(context.Background(), NewStatement("DELETE FROM foo"))
+	if err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	if results[0].RowsAffected != 1 {
+		t.Fatalf("wrong results: %+v", results)
+	}
+
+	loop := httptest.NewServer(nil)
+	loop.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, loop.URL+r.URL.Path, http.StatusMovedPermanently)
+	})
+	defer loop.Close()
+	c = mustNewClient(t, []string{hostOf(loop)}, &Config{MaxRedirects: 2})
+	if _, err := c.Execute(context.Background(), NewStatement("DELETE FROM foo")); !errors.Is(err, ErrTooManyRedirects) {
+		t.Fatalf("expected ErrTooManyRedirects, got %v", err)
+	}
+}
+
+func Test_ClientFailover(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/db/query" {
+			w.Write([]byte(`{"results":[{"columns":["x"],"types":["integer"]}]}`))
+			return
+		}
+		http.Error(w, "not authorized", http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+
+	// Find an address on which nothing listens.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	down := ln.Addr().String()
+	ln.Close()
+
+	c := mustNewClient(t, []string{down, hostOf(ts)}, nil)
+	if _, err := c.Query(context.Background(), NewStatement("SELECT 1")); err != nil {
+		t.Fatalf("failed to query: %s", err)
+	}
+	if c.Host() != hostOf(ts) {
+		t.Fatalf("client did not fail over, host is %s", c.Host())
+	}
+
+	// Errors returned by a node are not retried on another.
+	_, err = c.Execute(context.Background(), NewStatement("DELETE FROM foo"))
+	var se *StatusError
+	if !errors.As(err, &se) || se.Code != http.StatusUnauthorized || !strings.Contains(se.Message, "not authorized") {
+		t.Fatalf("expected unauthorized status error, got %v", err)
+	}
+
+	c = mustNewClient(t, []string{down}, nil)
+	if _, err := c.Query(context.Background(), NewStatement("SELECT 1")); err == nil {
+		t.Fatalf("expected error when no host is reachable")
+	}
+}
+
+func mustNewClient(t *testing.T, hosts []string, cfg *Config) *Client {
+	t.Helper()
+	c, err := New(hosts, cfg)
+	if err != nil {
+		t.Fatalf("failed to create client: %s", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func hostOf(ts *httptest.Server) string {
+	return strings.TrimPrefix(ts.URL, "http://")
+}
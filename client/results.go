@@ -0,0 +1,418 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// StatementError is returned when a statement fails. The results of the
+// other statements of the request are returned with it.
+type StatementError struct {
+	// Index is the position of the statement in the request.
+	Index   int
+	Message string
+}
+
+func (e *StatementError) Error() string {
+	return fmt.Sprintf("statement %d: %s", e.Index, e.Message)
+}
+
+// ExecuteResult is the result of a statement which changes the database.
+type ExecuteResult struct {
+	LastInsertID int64
+	RowsAffected int64
+
+	// Error is the error of the statement, if it failed.
+	Error string
+
+	// Time is the time taken by the statement, in seconds, if timings were
+	// requested.
+	Time float64
+}
+
+// Rows are the rows returned by a query. Read them by calling Next, then
+// Scan, for each row in turn, or directly from Values.
+type Rows struct {
+	Columns []string
+	Types   []string
+
+	// Values are the values of each row. Each is nil, an int64, a float64,
+	// a bool, a string, or a []byte for columns of type blob.
+	Values [][]interface{}
+
+	// Error is the error of the query, if it failed.
+	Error string
+
+	// Time is the time taken by the query, in seconds, if timings were
+	// requested.
+	Time float64
+
+	row int
+}
+
+// RequestResult is the result of a statement of a request which may both
+// query and change the database. Rows is set if the statement returned
+// rows, and Execute otherwise.
+type RequestResult struct {
+	Rows    *Rows
+	Execute *ExecuteResult
+}
+
+// result is the JSON of the result of a statement, which has the fields of
+// either an ExecuteResult or Rows.
+type result struct {
+	LastInsertID int64           `json:"last_insert_id"`
+	RowsAffected int64           `json:"rows_affected"`
+	Columns      []string        `json:"columns"`
+	Types        []string        `json:"types"`
+	Values       [][]interface{} `json:"values"`
+	Error        string          `json:"error"`
+	Time         float64         `json:"time"`
+}
+
+func (r *result) isRows() bool {
+	return r.Columns != nil || r.Types != nil
+}
+
+func (r *result) executeResult() *ExecuteResult {
+	return &ExecuteResult{
+		LastInsertID: r.LastInsertID,
+		RowsAffected: r.RowsAffected,
+		Error:        r.Error,
+		Time:         r.Time,
+	}
+}
+
+func (r *result) rows() *Rows {
+	rows := &Rows{
+		Columns: r.Columns,
+		Types:   r.Types,
+		Values:  r.Values,
+		Error:   r.Error,
+		Time:    r.Time,
+	}
+	for _, vals := range rows.Values {
+		for i, v := range vals {
+			vals[i] = normalizeValue(v, rows.columnType(i))
+		}
+	}
+	return rows
+}
+
+// Execute executes statements which change the database. If any statement
+// fails, a StatementError is returned for the first to fail, along with the
+// results of every statement.
+func (c *Client) Execute(ctx context.Context, stmts ...Statement) ([]ExecuteResult, error) {
+	return c.ExecuteWithOptions(ctx, nil, stmts...)
+}
+
+// ExecuteWithOptions executes statements as Execute does, with the given
+// options.
+func (c *Client) ExecuteWithOptions(ctx context.Context, opts *ExecuteOptions, stmts ...Statement) ([]ExecuteResult, error) {
+	results, err := c.send(ctx, "/db/execute", opts.params(), stmts, false)
+	if err != nil {
+		return nil, err
+	}
+	ers := make([]ExecuteResult, len(results))
+	for i, r := range results {
+		ers[i] = *r.executeResult()
+	}
+	return ers, firstError(results)
+}
+
+// Query executes queries which read the database. If any query fails, a
+// StatementError is returned for the first to fail, along with the rows of
+// every query.
+func (c *Client) Query(ctx context.Context, stmts ...Statement) ([]*Rows, error) {
+	return c.QueryWithOptions(ctx, nil, stmts...)
+}
+
+// QueryWithOptions executes queries as Query does, with the given options.
+func (c *Client) QueryWithOptions(ctx context.Context, opts *QueryOptions, stmts ...Statement) ([]*Rows, error) {
+	params, err := opts.params(c.level)
+	if err != nil {
+		return nil, err
+	}
+	results, err := c.send(ctx, "/db/query", params, stmts, true)
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]*Rows, len(results))
+	for i, r := range results {
+		rows[i] = r.rows()
+	}
+	return rows, firstError(results)
+}
+
+// Request executes statements which may both read and change the database.
+// Options which apply only to queries apply to the statements which only
+// read the database. If any statement fails, a StatementError is returned
+// for the first to fail, along with the results of every statement.
+func (c *Client) Request(ctx context.Context, opts *QueryOptions, stmts ...Statement) ([]RequestResult, error) {
+	params, err := opts.params(c.level)
+	if err != nil {
+		return nil, err
+	}
+	results, err := c.send(ctx, "/db/request", params, stmts, false)
+	if err != nil {
+		return nil, err
+	}
+	rrs := make([]RequestResult, len(results))
+	for i, r := range results {
+		if r.isRows() {
+			rrs[i].Rows = r.rows()
+		} else {
+			rrs[i].Execute = r.executeResult()
+		}
+	}
+	return rrs, firstError(results)
+}
+
+// send sends the statements to the given endpoint, returning the result of
+// each.
+func (c *Client) send(ctx context.Context, path string, params url.Values, stmts []Statement, idempotent bool) ([]*result, error) {
+	body, err := marshalStatements(stmts)
+	if err != nil {
+		return nil, err
+	}
+	b, err := c.do(ctx, http.MethodPost, path, params, body, idempotent)
+	if err != nil {
+		return nil, err
+	}
+	raws, err := decodeResponse(b)
+	if err != nil {
+		return nil, err
+	}
+	if len(raws) != len(stmts) {
+		return nil, fmt.Errorf("got %d results for %d statements", len(raws), len(stmts))
+	}
+	results := make([]*result, len(raws))
+	for i, raw := range raws {
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		results[i] = &result{}
+		if err := dec.Decode(results[i]); err != nil {
+			return nil, fmt.Errorf("failed to decode result %d: %s", i, err)
+		}
+	}
+	return results, nil
+}
+
+// firstError returns a StatementError for the first of results to fail, or
+// nil if none did.
+func firstError(results []*result) error {
+	for i, r := range results {
+		if r.Error != "" {
+			return &StatementError{Index: i, Message: r.Error}
+		}
+	}
+	return nil
+}
+
+// normalizeValue converts a value decoded from JSON to the type documented
+// for Rows.Values.
+func normalizeValue(v interface{}, typ string) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+	case string:
+		if typ == "blob" {
+			if b, err := base64.StdEncoding.DecodeString(v); err == nil {
+				return b
+			}
+		}
+	}
+	return v
+}
+
+func (r *Rows) columnType(i int) string {
+	if i < len(r.Types) {
+		return r.Types[i]
+	}
+	return ""
+}
+
+// Err returns the error of the query, if it failed.
+func (r *Rows) Err() error {
+	if r.Error == "" {
+		return nil
+	}
+	return errors.New(r.Error)
+}
+
+// Next advances to the next row, returning false once there are none.
+func (r *Rows) Next() bool {
+	if r.row >= len(r.Values) {
+		return false
+	}
+	r.row++
+	return true
+}
+
+// Scan copies the values of the columns of the current row into dest, which
+// must have a pointer for each column. Values are converted to the types
+// pointed to where possible, as database/sql does: to any integer or float,
+// bool, string, []byte, time.Time or interface{}, or by an sql.Scanner,
+// such as sql.NullString. Only an interface{}, []byte or sql.Scanner may
+// receive NULL.
+func (r *Rows) Scan(dest ...interface{}) error {
+	if r.row == 0 || r.row > len(r.Values) {
+		return errors.New("Scan called without a current row")
+	}
+	vals := r.Values[r.row-1]
+	if len(dest) != len(vals) {
+		return fmt.Errorf("expected %d destinations, got %d", len(vals), len(dest))
+	}
+	for i, v := range vals {
+		if err := convertAssign(dest[i], v); err != nil {
+			name := strconv.Itoa(i)
+			if i < len(r.Columns) {
+				name = r.Columns[i]
+			}
+			return fmt.Errorf("column %s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// timeLayouts are the layouts of times stored as text, as SQLite's date and
+// time functions accept them.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// convertAssign copies src, a value of Rows.Values, into dest.
+func convertAssign(dest, src interface{}) error {
+	if s, ok := dest.(sql.Scanner); ok {
+		return s.Scan(src)
+	}
+	switch d := dest.(type) {
+	case *interface{}:
+		*d = src
+		return nil
+	case *[]byte:
+		switch s := src.(type) {
+		case nil:
+			*d = nil
+		case []byte:
+			*d = append([]byte(nil), s...)
+		case string:
+			*d = []byte(s)
+		default:
+			*d = []byte(asString(s))
+		}
+		return nil
+	case *string:
+		if src == nil {
+			return errors.New("cannot scan NULL into *string")
+		}
+		*d = asString(src)
+		return nil
+	case *time.Time:
+		switch s := src.(type) {
+		case string:
+			for _, layout := range timeLayouts {
+				if t, err := time.Parse(layout, s); err == nil {
+					*d = t
+					return nil
+				}
+			}
+			return fmt.Errorf("cannot parse %q as a time", s)
+		case int64:
+			*d = time.Unix(s, 0).UTC()
+			return nil
+		case float64:
+			sec := int64(s)
+			*d = time.Unix(sec, int64((s-float64(sec))*1e9)).UTC()
+			return nil
+		}
+		return fmt.Errorf("cannot scan %T into *time.Time", src)
+	}
+
+	dv := reflect.ValueOf(dest)
+	if dv.Kind() != reflect.Ptr || dv.IsNil() {
+		return errors.New("destination is not a non-nil pointer")
+	}
+	if src == nil {
+		return fmt.Errorf("cannot scan NULL into %T", dest)
+	}
+	dv = dv.Elem()
+	switch dv.Kind() {
+	case reflect.Bool:
+		switch s := src.(type) {
+		case bool:
+			dv.SetBool(s)
+		case int64:
+			dv.SetBool(s != 0)
+		case string:
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				return err
+			}
+			dv.SetBool(b)
+		default:
+			return fmt.Errorf("cannot scan %T into %T", src, dest)
+		}
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(asString(src), 10, dv.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("cannot scan %v into %T: %s", src, dest, err)
+		}
+		dv.SetInt(i)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(asString(src), 10, dv.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("cannot scan %v into %T: %s", src, dest, err)
+		}
+		dv.SetUint(u)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(asString(src), dv.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("cannot scan %v into %T: %s", src, dest, err)
+		}
+		dv.SetFloat(f)
+		return nil
+	}
+	return fmt.Errorf("unsupported destination %T", dest)
+}
+
+// asString returns the text of a value.
+func asString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return fmt.Sprintf("%v", v)
+}
@@ -0,0 +1,225 @@
+package client
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"net/url"
+	"time"
+
+	"github.com/rqlite/rqlite/command"
+	"google.golang.org/protobuf/proto"
+)
+
+// Level is the read consistency of a query. See the documentation of the
+// HTTP API for the guarantees of each.
+type Level string
+
+const (
+	// LevelNone reads the database of the node queried, without checking
+	// whether it is the leader.
+	LevelNone Level = "none"
+
+	// LevelWeak reads the database of the leader, which checks it is the
+	// leader before reading.
+	LevelWeak Level = "weak"
+
+	// LevelLinearizable reads the database of the leader, once the leader
+	// has confirmed with a quorum that it is still the leader, and applied
+	// every write committed before the read. It gives the same guarantee as
+	// LevelStrong, without writing the read to the Raft log.
+	LevelLinearizable Level = "linearizable"
+
+	// LevelStrong reads the database through the Raft log, so the read
+	// reflects every write committed before it.
+	LevelStrong Level = "strong"
+)
+
+func (l Level) valid() bool {
+	return l == LevelNone || l == LevelWeak || l == LevelLinearizable || l == LevelStrong
+}
+
+// Statement is an SQL statement, and the values of its parameters.
+type Statement struct {
+	SQL string
+
+	// Args are the values of the parameters of the statement, in the order
+	// of the positional parameters, and as NamedArgs for named parameters.
+	// Each value is nil, a bool, an integer, a float, a string, a []byte,
+	// a time.Time, or a driver.Valuer returning one of them.
+	Args []interface{}
+}
+
+// NewStatement returns a Statement of the SQL with the given values of its
+// parameters.
+func NewStatement(sql string, args ...interface{}) Statement {
+	return Statement{SQL: sql, Args: args}
+}
+
+// NamedArg is the value of a named parameter of a statement.
+type NamedArg struct {
+	// Name is the name of the parameter, without its prefix, so that a
+	// parameter :name, @name or $name is named "name".
+	Name  string
+	Value interface{}
+}
+
+// Named returns the value of the named parameter of a statement.
+func Named(name string, value interface{}) NamedArg {
+	return NamedArg{Name: name, Value: value}
+}
+
+// ExecuteOptions are the options of a request to execute statements.
+type ExecuteOptions struct {
+	// Transaction executes the statements in a single transaction, so that
+	// none take effect if any fails.
+	Transaction bool
+
+	// Timings includes the time taken by each statement in its result.
+	Timings bool
+}
+
+// QueryOptions are the options of a request to query the database.
+type QueryOptions struct {
+	// Level is the read consistency of the query. If not set, that set
+	// for the Client applies.
+	Level Level
+
+	// Freshness bounds how stale the database read with LevelNone may be.
+	// If zero, not bounded.
+	Freshness time.Duration
+
+	// Transaction executes the statements in a single transaction, so they
+	// read the database as of the same moment.
+	Transaction bool
+
+	// Timings includes the time taken by each statement in its result.
+	Timings bool
+}
+
+// params returns the URL query parameters which set the options.
+func (o *ExecuteOptions) params() url.Values {
+	v := url.Values{}
+	if o == nil {
+		return v
+	}
+	if o.Transaction {
+		v.Set("transaction", "")
+	}
+	if o.Timings {
+		v.Set("timings", "")
+	}
+	return v
+}
+
+// params returns the URL query parameters which set the options, using def
+// as the level if the options set none.
+func (o *QueryOptions) params(def Level) (url.Values, error) {
+	v := url.Values{}
+	if o == nil {
+		o = &QueryOptions{}
+	}
+	lvl := o.Level
+	if lvl == "" {
+		lvl = def
+	}
+	if lvl != "" {
+		if !lvl.valid() {
+			return nil, fmt.Errorf("invalid read consistency level %q", lvl)
+		}
+		v.Set("level", string(lvl))
+	}
+	if o.Freshness > 0 {
+		v.Set("freshness", o.Freshness.String())
+	}
+	if o.Transaction {
+		v.Set("transaction", "")
+	}
+	if o.Timings {
+		v.Set("timings", "")
+	}
+	return v, nil
+}
+
+// marshalStatements encodes statements as the body of a request to the
+// HTTP API, using Protocol Buffers, so that integers and blobs are sent
+// exactly.
+func marshalStatements(stmts []Statement) ([]byte, error) {
+	if len(stmts) == 0 {
+		return nil, fmt.Errorf("no statements")
+	}
+	req := &command.Request{Statements: make([]*command.Statement, len(stmts))}
+	for i, s := range stmts {
+		cs := &command.Statement{Sql: s.SQL}
+		for j, a := range s.Args {
+			name := ""
+			if na, ok := a.(NamedArg); ok {
+				name, a = na.Name, na.Value
+			}
+			p, err := parameter(name, a)
+			if err != nil {
+				return nil, fmt.Errorf("statement %d, parameter %d: %s", i, j+1, err)
+			}
+			cs.Parameters = append(cs.Parameters, p)
+		}
+		req.Statements[i] = cs
+	}
+	return proto.Marshal(req)
+}
+
+// parameter returns the parameter of a statement with the given name and
+// value.
+func parameter(name string, v interface{}) (*command.Parameter, error) {
+	if vr, ok := v.(driver.Valuer); ok {
+		var err error
+		if v, err = vr.Value(); err != nil {
+			return nil, err
+		}
+	}
+
+	p := &command.Parameter{Name: name}
+	switch v := v.(type) {
+	case nil:
+	case bool:
+		p.Value = &command.Parameter_B{B: v}
+	case int:
+		p.Value = &command.Parameter_I{I: int64(v)}
+	case int8:
+		p.Value = &command.Parameter_I{I: int64(v)}
+	case int16:
+		p.Value = &command.Parameter_I{I: int64(v)}
+	case int32:
+		p.Value = &command.Parameter_I{I: int64(v)}
+	case int64:
+		p.Value = &command.Parameter_I{I: v}
+	case uint:
+		if uint64(v) > math.MaxInt64 {
+			return nil, fmt.Errorf("value %d overflows int64", v)
+		}
+		p.Value = &command.Parameter_I{I: int64(v)}
+	case uint8:
+		p.Value = &command.Parameter_I{I: int64(v)}
+	case uint16:
+		p.Value = &command.Parameter_I{I: int64(v)}
+	case uint32:
+		p.Value = &command.Parameter_I{I: int64(v)}
+	case uint64:
+		if v > math.MaxInt64 {
+			return nil, fmt.Errorf("value %d overflows int64", v)
+		}
+		p.Value = &command.Parameter_I{I: int64(v)}
+	case float32:
+		p.Value = &command.Parameter_D{D: float64(v)}
+	case float64:
+		p.Value = &command.Parameter_D{D: v}
+	case string:
+		p.Value = &command.Parameter_S{S: v}
+	case []byte:
+		p.Value = &command.Parameter_Y{Y: v}
+	case time.Time:
+		p.Value = &command.Parameter_S{S: v.Format(time.RFC3339Nano)}
+	default:
+		return nil, fmt.Errorf("unsupported type %T", v)
+	}
+	return p, nil
+}
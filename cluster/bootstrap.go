@@ -72,6 +72,8 @@ type Bootstrapper struct {
 	username string
 	password string
 
+	zone string
+
 	logger   *log.Logger
 	Interval time.Duration
 
@@ -96,6 +98,12 @@ func (b *Bootstrapper) SetBasicAuth(username, password string) {
 	b.username, b.password = username, password
 }
 
+// SetZone sets the zone of this node, which is sent with any bootstrap
+// attempt.
+func (b *Bootstrapper) SetZone(zone string) {
+	b.zone = zone
+}
+
 // Boot performs the bootstrapping process for this node. This means it will
 // ensure this node becomes part of a cluster. It does this by either joining
 // an existing cluster by explicitly joining it through one of these nodes,
@@ -141,6 +149,7 @@ func (b *Bootstrapper) Boot(id, raftAddr string, done func() bool, timeout time.
 			// Try an explicit join first. Joining an existing cluster is always given priority
 			// over trying to form a new cluster.
 			b.joiner.SetBasicAuth(b.username, b.password)
+			b.joiner.SetZone(b.zone)
 			if j, err := b.joiner.Do(targets, id, raftAddr, true); err == nil {
 				b.logger.Printf("succeeded directly joining cluster via node at %s", j)
 				b.setBootStatus(BootJoin)
@@ -179,10 +188,14 @@ func (b *Bootstrapper) notify(targets []string, id, raftAddr string) error {
 	}
 	client := &http.Client{Transport: tr}
 
-	buf, err := json.Marshal(map[string]interface{}{
+	md := map[string]interface{}{
 		"id":   id,
 		"addr": raftAddr,
-	})
+	}
+	if b.zone != "" {
+		md["zone"] = b.zone
+	}
+	buf, err := json.Marshal(md)
 	if err != nil {
 		return err
 	}
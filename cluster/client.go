@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/rqlite/rqlite/command"
+	"github.com/rqlite/rqlite/overload"
 	"github.com/rqlite/rqlite/tcp/pool"
 	"google.golang.org/protobuf/proto"
 )
@@ -21,6 +22,14 @@ const (
 	maxPoolCapacity = 64
 	maxRetries      = 8
 
+	// defaultPoolIdleTimeout is the default time after which idle pooled
+	// connections to a node are closed.
+	defaultPoolIdleTimeout = 5 * time.Minute
+
+	// poolHealthCheckAfter is the time a pooled connection must have been
+	// idle before it is checked for failure, before reuse.
+	poolHealthCheckAfter = time.Second
+
 	protoBufferLengthSize = 8
 )
 
@@ -33,9 +42,23 @@ type Client struct {
 	localNodeAddr string
 	localServ     *Service
 
-	mu            sync.RWMutex
-	poolInitialSz int
-	pools         map[string]pool.Pool
+	mu              sync.RWMutex
+	poolInitialSz   int
+	poolMaxIdle     int
+	poolIdleTimeout time.Duration
+	poolMaxLifetime time.Duration
+	pools           map[string]pool.Pool
+
+	// pipeline is set if forwarded requests are pipelined, on a single
+	// connection to each node.
+	pipeline      bool
+	pipeMu        sync.Mutex
+	pipes         map[string]*pipelinedConn
+	pipeFailTimes map[string]time.Time
+
+	// forwardQueue tracks the requests forwarded to other nodes, awaiting
+	// their responses. May be nil.
+	forwardQueue *overload.Queue
 }
 
 // NewClient returns a client instance for talking to a remote node.
@@ -46,11 +69,68 @@ type Client struct {
 // usually retry these operations.
 func NewClient(dl Dialer, t time.Duration) *Client {
 	return &Client{
-		dialer:        dl,
-		timeout:       t,
-		poolInitialSz: initialPoolSize,
-		pools:         make(map[string]pool.Pool),
+		dialer:          dl,
+		timeout:         t,
+		poolInitialSz:   initialPoolSize,
+		poolMaxIdle:     maxPoolCapacity,
+		poolIdleTimeout: defaultPoolIdleTimeout,
+		pools:           make(map[string]pool.Pool),
+		pipes:           make(map[string]*pipelinedConn),
+		pipeFailTimes:   make(map[string]time.Time),
+	}
+}
+
+// SetPoolConfig configures the pools of connections the client keeps to each
+// node. At most maxIdle idle connections are kept to a node. Idle connections
+// are closed after idleTimeout, and connections are closed once older than
+// maxLifetime, rather than being reused. A zero idleTimeout or maxLifetime
+// disables the respective limit. Existing pools are closed, so that the
+// configuration applies to all connections.
+func (c *Client) SetPoolConfig(maxIdle int, idleTimeout, maxLifetime time.Duration) error {
+	if maxIdle <= 0 {
+		return fmt.Errorf("invalid maximum idle connections: %d", maxIdle)
+	}
+	if idleTimeout < 0 || maxLifetime < 0 {
+		return errors.New("invalid connection pool timeout")
 	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.poolMaxIdle = maxIdle
+	c.poolIdleTimeout = idleTimeout
+	c.poolMaxLifetime = maxLifetime
+	if c.poolInitialSz > maxIdle {
+		c.poolInitialSz = maxIdle
+	}
+	for addr, pl := range c.pools {
+		pl.Close()
+		delete(c.pools, addr)
+	}
+	return nil
+}
+
+// EnablePipelining sets whether requests forwarded to other nodes, that is
+// Execute, Query and Request, are pipelined. If enabled, such requests to a
+// node share a single connection, on which a request is sent without waiting
+// for the responses to earlier requests. Requests to nodes which do not
+// support pipelining are sent as if it were disabled.
+func (c *Client) EnablePipelining(b bool) {
+	c.pipeMu.Lock()
+	defer c.pipeMu.Unlock()
+	c.pipeline = b
+	if !b {
+		for addr, pc := range c.pipes {
+			pc.Close()
+			delete(c.pipes, addr)
+		}
+	}
+}
+
+// SetForwardQueue sets the queue which tracks requests forwarded to other
+// nodes while they await their responses. It must be called before the
+// client is used.
+func (c *Client) SetForwardQueue(q *overload.Queue) {
+	c.forwardQueue = q
 }
 
 // SetLocal informs the client instance of the node address for the node
@@ -102,7 +182,7 @@ func (c *Client) Execute(er *command.ExecuteRequest, nodeAddr string, creds *Cre
 		},
 		Credentials: creds,
 	}
-	p, err := c.retry(command, nodeAddr, timeout)
+	p, err := c.forward(command, nodeAddr, timeout)
 	if err != nil {
 		return nil, err
 	}
@@ -128,7 +208,7 @@ func (c *Client) Query(qr *command.QueryRequest, nodeAddr string, creds *Credent
 		},
 		Credentials: creds,
 	}
-	p, err := c.retry(command, nodeAddr, timeout)
+	p, err := c.forward(command, nodeAddr, timeout)
 	if err != nil {
 		return nil, err
 	}
@@ -154,7 +234,7 @@ func (c *Client) Request(r *command.ExecuteQueryRequest, nodeAddr string, creds
 		},
 		Credentials: creds,
 	}
-	p, err := c.retry(command, nodeAddr, timeout)
+	p, err := c.forward(command, nodeAddr, timeout)
 	if err != nil {
 		return nil, err
 	}
@@ -375,15 +455,205 @@ func (c *Client) Join(jr *command.JoinRequest, nodeAddr string, timeout time.Dur
 	return nil
 }
 
+// Restart requests that the node at nodeAddr restart. It returns once the
+// restart has been scheduled, not once it has completed.
+func (c *Client) Restart(nodeAddr string, creds *Credentials, timeout time.Duration) error {
+	conn, err := c.dial(nodeAddr, c.timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Create the request.
+	command := &Command{
+		Type:        Command_COMMAND_TYPE_RESTART,
+		Credentials: creds,
+	}
+	if err := writeCommand(conn, command, timeout); err != nil {
+		handleConnError(conn)
+		return err
+	}
+
+	p, err := readResponse(conn, timeout)
+	if err != nil {
+		handleConnError(conn)
+		return err
+	}
+
+	a := &CommandRestartResponse{}
+	err = proto.Unmarshal(p, a)
+	if err != nil {
+		return err
+	}
+
+	if a.Error != "" {
+		return errors.New(a.Error)
+	}
+	return nil
+}
+
+// GetNodeStatus retrieves the status of the node at nodeAddr. Unlike most
+// other requests it is not retried, so that callers waiting for a node to
+// restart learn quickly that it is unavailable.
+func (c *Client) GetNodeStatus(nodeAddr string, timeout time.Duration) (*CommandNodeStatusResponse, error) {
+	conn, err := c.dial(nodeAddr, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	command := &Command{
+		Type: Command_COMMAND_TYPE_GET_NODE_STATUS,
+	}
+	if err := writeCommand(conn, command, timeout); err != nil {
+		handleConnError(conn)
+		return nil, err
+	}
+
+	p, err := readResponse(conn, timeout)
+	if err != nil {
+		handleConnError(conn)
+		return nil, err
+	}
+
+	a := &CommandNodeStatusResponse{}
+	err = proto.Unmarshal(p, a)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.Error != "" {
+		return nil, errors.New(a.Error)
+	}
+	return a, nil
+}
+
+// GetChecksum retrieves the checksum of each table in the database of the
+// node at nodeAddr, taken at the given log index.
+func (c *Client) GetChecksum(idx uint64, nodeAddr string, timeout time.Duration) (map[string]string, error) {
+	conn, err := c.dial(nodeAddr, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	command := &Command{
+		Type: Command_COMMAND_TYPE_GET_CHECKSUM,
+		Request: &Command_ChecksumRequest{
+			ChecksumRequest: &ChecksumRequest{
+				Index: idx,
+			},
+		},
+	}
+	if err := writeCommand(conn, command, timeout); err != nil {
+		handleConnError(conn)
+		return nil, err
+	}
+
+	p, err := readResponse(conn, timeout)
+	if err != nil {
+		handleConnError(conn)
+		return nil, err
+	}
+
+	a := &CommandChecksumResponse{}
+	err = proto.Unmarshal(p, a)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.Error != "" {
+		return nil, errors.New(a.Error)
+	}
+	return a.Checksums, nil
+}
+
+// Resync requests that the node at nodeAddr resync its database from the
+// leader's. It returns once the resync has been scheduled, not once it has
+// completed.
+func (c *Client) Resync(nodeAddr string, creds *Credentials, timeout time.Duration) error {
+	conn, err := c.dial(nodeAddr, c.timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	command := &Command{
+		Type:        Command_COMMAND_TYPE_RESYNC,
+		Credentials: creds,
+	}
+	if err := writeCommand(conn, command, timeout); err != nil {
+		handleConnError(conn)
+		return err
+	}
+
+	p, err := readResponse(conn, timeout)
+	if err != nil {
+		handleConnError(conn)
+		return err
+	}
+
+	a := &CommandResyncResponse{}
+	err = proto.Unmarshal(p, a)
+	if err != nil {
+		return err
+	}
+
+	if a.Error != "" {
+		return errors.New(a.Error)
+	}
+	return nil
+}
+
+// BackupWithIndex retrieves a binary copy of the database of the node at
+// nodeAddr, writes it to w, and returns the index of the last log entry
+// reflected in the copy.
+func (c *Client) BackupWithIndex(nodeAddr string, creds *Credentials, timeout time.Duration, w io.Writer) (uint64, error) {
+	command := &Command{
+		Type:        Command_COMMAND_TYPE_BACKUP_WITH_INDEX,
+		Credentials: creds,
+	}
+	p, err := c.retry(command, nodeAddr, timeout)
+	if err != nil {
+		return 0, err
+	}
+
+	p, err = gzUncompress(p)
+	if err != nil {
+		return 0, fmt.Errorf("backup decompress: %w", err)
+	}
+
+	resp := &CommandBackupWithIndexResponse{}
+	if err := proto.Unmarshal(p, resp); err != nil {
+		return 0, fmt.Errorf("backup unmarshal: %w", err)
+	}
+	if resp.Error != "" {
+		return 0, errors.New(resp.Error)
+	}
+
+	if _, err := w.Write(resp.Data); err != nil {
+		return 0, fmt.Errorf("backup write: %w", err)
+	}
+	return resp.Index, nil
+}
+
 // Stats returns stats on the Client instance
 func (c *Client) Stats() (map[string]interface{}, error) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
+	c.pipeMu.Lock()
+	pipelined := make([]string, 0, len(c.pipes))
+	for addr := range c.pipes {
+		pipelined = append(pipelined, addr)
+	}
 	stats := map[string]interface{}{
 		"timeout":         c.timeout.String(),
 		"local_node_addr": c.localNodeAddr,
+		"pipelining":      c.pipeline,
+		"pipelined_nodes": pipelined,
 	}
+	c.pipeMu.Unlock()
 
 	if (len(c.pools)) == 0 {
 		return stats, nil
@@ -421,7 +691,14 @@ func (c *Client) dial(nodeAddr string, timeout time.Duration) (net.Conn, error)
 
 			// New pool is needed for given address.
 			factory := func() (net.Conn, error) { return c.dialer.Dial(nodeAddr, c.timeout) }
-			p, err := pool.NewChannelPool(c.poolInitialSz, maxPoolCapacity, factory)
+			p, err := pool.NewChannelPoolWithConfig(pool.Config{
+				InitialCap:       c.poolInitialSz,
+				MaxIdle:          c.poolMaxIdle,
+				IdleTimeout:      c.poolIdleTimeout,
+				MaxLifetime:      c.poolMaxLifetime,
+				HealthCheck:      pool.CheckAlive,
+				HealthCheckAfter: poolHealthCheckAfter,
+			}, factory)
 			if err != nil {
 				return err
 			}
@@ -441,6 +718,83 @@ func (c *Client) dial(nodeAddr string, timeout time.Duration) (net.Conn, error)
 	return conn, nil
 }
 
+// forward sends a command forwarded to a remote node, pipelined if
+// pipelining is enabled and supported by the node. If the pipelined
+// connection to the node fails, the command is retried as if pipelining were
+// disabled.
+func (c *Client) forward(command *Command, nodeAddr string, timeout time.Duration) ([]byte, error) {
+	defer c.forwardQueue.Enter()()
+	pc := c.pipelined(nodeAddr)
+	if pc == nil {
+		return c.retry(command, nodeAddr, timeout)
+	}
+	stats.Add(numClientPipelined, 1)
+	p, err := pc.Do(command, timeout)
+	if errors.Is(err, ErrPipelineClosed) {
+		c.pipeMu.Lock()
+		if c.pipes[nodeAddr] == pc {
+			delete(c.pipes, nodeAddr)
+		}
+		c.pipeMu.Unlock()
+		stats.Add(numClientRetries, 1)
+		return c.retry(command, nodeAddr, timeout)
+	}
+	return p, err
+}
+
+// pipelined returns the pipelined connection to the node at nodeAddr,
+// creating it if necessary. It returns nil if pipelining is disabled, or
+// the node does not support it. While the connection is being created,
+// other requests to the node are not pipelined.
+func (c *Client) pipelined(nodeAddr string) *pipelinedConn {
+	c.pipeMu.Lock()
+	if !c.pipeline {
+		c.pipeMu.Unlock()
+		return nil
+	}
+	if pc, ok := c.pipes[nodeAddr]; ok {
+		if !pc.Failed() {
+			c.pipeMu.Unlock()
+			return pc
+		}
+		delete(c.pipes, nodeAddr)
+	}
+	if t, ok := c.pipeFailTimes[nodeAddr]; ok && (t.IsZero() || time.Since(t) < pipelineRetryInterval) {
+		// Either being created, or failed to be created recently.
+		c.pipeMu.Unlock()
+		return nil
+	}
+	c.pipeFailTimes[nodeAddr] = time.Time{}
+	c.pipeMu.Unlock()
+
+	pc, err := func() (*pipelinedConn, error) {
+		conn, err := c.dialer.Dial(nodeAddr, c.timeout)
+		if err != nil {
+			return nil, err
+		}
+		pc, err := newPipelinedConn(conn, pipelineHandshakeTimeout)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return pc, nil
+	}()
+
+	c.pipeMu.Lock()
+	defer c.pipeMu.Unlock()
+	if err != nil {
+		c.pipeFailTimes[nodeAddr] = time.Now()
+		return nil
+	}
+	delete(c.pipeFailTimes, nodeAddr)
+	if !c.pipeline {
+		pc.Close()
+		return nil
+	}
+	c.pipes[nodeAddr] = pc
+	return pc
+}
+
 // retry retries a command on a remote node. It does this so we churn through connections
 // in the pool if we hit an error, as the remote node may have restarted and the pool's
 // connections are now stale.
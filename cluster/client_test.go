@@ -5,6 +5,7 @@ import (
 	"io"
 	"net"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -95,6 +96,59 @@ func Test_ClientExecute(t *testing.T) {
 	}
 }
 
+func Test_ClientPoolConfig(t *testing.T) {
+	var nConns int32
+	srv := servicetest.NewService()
+	srv.Handler = func(conn net.Conn) {
+		atomic.AddInt32(&nConns, 1)
+		for {
+			c := readCommand(conn)
+			if c == nil {
+				return
+			}
+			p, err := proto.Marshal(&CommandExecuteResponse{})
+			if err != nil {
+				return
+			}
+			writeBytesWithLength(conn, p)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	c := NewClient(&simpleDialer{}, 0)
+	if err := c.SetPoolConfig(0, 0, 0); err == nil {
+		t.Fatalf("expected error for zero maximum idle connections")
+	}
+	if err := c.SetPoolConfig(1, -time.Second, 0); err == nil {
+		t.Fatalf("expected error for negative idle timeout")
+	}
+	if err := c.SetPoolConfig(1, time.Minute, 200*time.Millisecond); err != nil {
+		t.Fatalf("failed to set pool config: %s", err)
+	}
+
+	execute := func() {
+		t.Helper()
+		_, err := c.Execute(executeRequestFromString("INSERT INTO foo (id) VALUES (1)"),
+			srv.Addr(), nil, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Connections are reused until they exceed their maximum lifetime.
+	execute()
+	execute()
+	if n := atomic.LoadInt32(&nConns); n != 1 {
+		t.Fatalf("expected 1 connection, got %d", n)
+	}
+	time.Sleep(300 * time.Millisecond)
+	execute()
+	if n := atomic.LoadInt32(&nConns); n != 2 {
+		t.Fatalf("expected 2 connections, got %d", n)
+	}
+}
+
 func Test_ClientQuery(t *testing.T) {
 	srv := servicetest.NewService()
 	srv.Handler = func(conn net.Conn) {
@@ -13,8 +13,14 @@ import (
 	"os"
 	"strings"
 	"time"
+
+	"github.com/hashicorp/raft"
 )
 
+// SnapshotMetaHeader is the HTTP header carrying the JSON-encoded Raft meta
+// of the snapshot served to joining nodes.
+const SnapshotMetaHeader = "X-RQLITE-SNAPSHOT-META"
+
 var (
 	// ErrInvalidRedirect is returned when a node returns an invalid HTTP redirect.
 	ErrInvalidRedirect = errors.New("invalid redirect received")
@@ -39,6 +45,8 @@ type Joiner struct {
 	username string
 	password string
 
+	zone string
+
 	client *http.Client
 
 	logger *log.Logger
@@ -87,6 +95,12 @@ func (j *Joiner) SetBasicAuth(username, password string) {
 	j.username, j.password = username, password
 }
 
+// SetZone sets the zone of the joining node, which is sent with any join
+// attempt.
+func (j *Joiner) SetZone(zone string) {
+	j.zone = zone
+}
+
 // Do makes the actual join request. If any of the join addresses do not contain a
 // protocol, both http:// and https:// are tried for that address. If the join is successful
 // with any address, the Join URL of the node that joined is returned. Otherwise, an error
@@ -119,11 +133,15 @@ func (j *Joiner) Do(joinAddrs []string, id, addr string, voter bool) (string, er
 
 func (j *Joiner) join(joinAddr, id, addr string, voter bool) (string, error) {
 	fullAddr := fmt.Sprintf("%s/join", joinAddr)
-	reqBody, err := json.Marshal(map[string]interface{}{
+	md := map[string]interface{}{
 		"id":    id,
 		"addr":  addr,
 		"voter": voter,
-	})
+	}
+	if j.zone != "" {
+		md["zone"] = j.zone
+	}
+	reqBody, err := json.Marshal(md)
 	if err != nil {
 		return "", err
 	}
@@ -175,6 +193,65 @@ func (j *Joiner) join(joinAddr, id, addr string, voter bool) (string, error) {
 	}
 }
 
+// FetchSnapshot fetches the latest snapshot of the leader of the cluster
+// reachable through any of the join addresses, trying each in turn. It
+// returns the meta of the snapshot, and a reader of the stream Raft would
+// send to install it on a node, which the caller must close.
+func (j *Joiner) FetchSnapshot(joinAddrs []string) (*raft.SnapshotMeta, io.ReadCloser, error) {
+	if len(joinAddrs) == 0 {
+		return nil, nil, errors.New("no join addresses")
+	}
+	var err error
+	for _, a := range normalizeAddrs(joinAddrs) {
+		var meta *raft.SnapshotMeta
+		var rc io.ReadCloser
+		meta, rc, err = j.fetchSnapshot(a)
+		if err == nil {
+			return meta, rc, nil
+		}
+		j.logger.Printf("failed to fetch snapshot via node at %s: %s", a, err)
+	}
+	return nil, nil, err
+}
+
+func (j *Joiner) fetchSnapshot(joinAddr string) (*raft.SnapshotMeta, io.ReadCloser, error) {
+	fullAddr := fmt.Sprintf("%s/join/snapshot", joinAddr)
+	for {
+		req, err := http.NewRequest("GET", fullAddr, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		if j.username != "" && j.password != "" {
+			req.SetBasicAuth(j.username, j.password)
+		}
+		resp, err := j.client.Do(req)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+			meta := &raft.SnapshotMeta{}
+			if err := json.Unmarshal([]byte(resp.Header.Get(SnapshotMetaHeader)), meta); err != nil {
+				resp.Body.Close()
+				return nil, nil, fmt.Errorf("invalid snapshot meta: %s", err)
+			}
+			return meta, resp.Body, nil
+		case http.StatusMovedPermanently:
+			resp.Body.Close()
+			fullAddr = resp.Header.Get("location")
+			if fullAddr == "" {
+				return nil, nil, ErrInvalidRedirect
+			}
+			continue
+		default:
+			respB, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			return nil, nil, fmt.Errorf("%s: (%s)", resp.Status, string(respB))
+		}
+	}
+}
+
 func normalizeAddrs(addrs []string) []string {
 	var a []string
 	for _, addr := range addrs {
@@ -82,6 +82,38 @@ func Test_SingleJoinOK(t *testing.T) {
 	}
 }
 
+func Test_SingleJoinZoneOK(t *testing.T) {
+	var body map[string]interface{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err := json.Unmarshal(b, &body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}))
+	defer ts.Close()
+
+	joiner := NewJoiner("127.0.0.1", numAttempts, attemptInterval, nil)
+	if _, err := joiner.Do([]string{ts.URL}, "id0", "127.0.0.1:9090", true); err != nil {
+		t.Fatalf("failed to join a single node: %s", err.Error())
+	}
+	if _, ok := body["zone"]; ok {
+		t.Fatalf("zone supplied when not set")
+	}
+
+	joiner.SetZone("zone1")
+	if _, err := joiner.Do([]string{ts.URL}, "id0", "127.0.0.1:9090", true); err != nil {
+		t.Fatalf("failed to join a single node: %s", err.Error())
+	}
+	if got, exp := body["zone"].(string), "zone1"; got != exp {
+		t.Fatalf("wrong zone supplied, exp %s, got %s", exp, got)
+	}
+}
+
 func Test_SingleJoinHTTPSOK(t *testing.T) {
 	var body map[string]interface{}
 	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -320,3 +352,58 @@ func Test_DoubleJoinOKSecondNodeRedirect(t *testing.T) {
 		t.Fatalf("node joined using wrong endpoint, exp: %s, got: %s", redirectAddr, j)
 	}
 }
+
+func Test_FetchSnapshotOK(t *testing.T) {
+	leader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			t.Fatalf("Client did not use GET")
+		}
+		if r.URL.Path != "/join/snapshot" {
+			t.Fatalf("unexpected path %s", r.URL.Path)
+		}
+		if u, p, ok := r.BasicAuth(); !ok || u != "user1" || p != "pass1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set(SnapshotMetaHeader, `{"ID":"2-18-1686659761026","Index":18,"Term":2,"Size":8}`)
+		w.Write([]byte("snapshot"))
+	}))
+	defer leader.Close()
+	follower := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, leader.URL+r.URL.Path, http.StatusMovedPermanently)
+	}))
+	defer follower.Close()
+
+	joiner := NewJoiner("127.0.0.1", numAttempts, attemptInterval, nil)
+	joiner.SetBasicAuth("user1", "pass1")
+	meta, rc, err := joiner.FetchSnapshot([]string{follower.URL})
+	if err != nil {
+		t.Fatalf("failed to fetch snapshot: %s", err.Error())
+	}
+	defer rc.Close()
+	if meta.ID != "2-18-1686659761026" || meta.Index != 18 || meta.Term != 2 || meta.Size != 8 {
+		t.Fatalf("unexpected snapshot meta: %+v", meta)
+	}
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("failed to read snapshot: %s", err.Error())
+	}
+	if string(b) != "snapshot" {
+		t.Fatalf("unexpected snapshot data: %s", b)
+	}
+}
+
+func Test_FetchSnapshotFail(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no snapshot available", http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	joiner := NewJoiner("127.0.0.1", numAttempts, attemptInterval, nil)
+	if _, _, err := joiner.FetchSnapshot([]string{ts.URL}); err == nil {
+		t.Fatalf("expected error fetching snapshot")
+	}
+	if _, _, err := joiner.FetchSnapshot(nil); err == nil {
+		t.Fatalf("expected error fetching snapshot without join addresses")
+	}
+}
@@ -0,0 +1,252 @@
+package cluster
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// A client may switch a connection to the cluster service into pipelined
+// mode, by sending a command of type COMMAND_TYPE_PIPELINE and reading the
+// response. From then on the client may send requests without waiting for
+// the responses to earlier requests, and the service handles the requests
+// concurrently, writing each response once it is ready. So that responses
+// can be matched to requests, each request and response on a pipelined
+// connection is framed as follows, with integers little-endian:
+//
+//	id      8 bytes, chosen by the client, and copied to the response
+//	length  8 bytes, the length of the protobuf which follows
+//	payload the Command, or response to it
+//
+// Only the requests a follower forwards to the leader, that is Execute,
+// Query and Request, are pipelined by the client.
+
+const (
+	pipelineFrameHeaderSize = 16
+
+	// maxPipelinedRequests is the maximum number of requests on a pipelined
+	// connection the service handles at once.
+	maxPipelinedRequests = 64
+
+	// pipelineHandshakeTimeout is the time a client waits for a node to
+	// switch a connection to pipelined mode. Nodes which do not support
+	// pipelining never respond.
+	pipelineHandshakeTimeout = 5 * time.Second
+
+	// pipelineRetryInterval is the time after which a client which failed to
+	// pipeline requests to a node tries again, in case the node has been
+	// upgraded to support it.
+	pipelineRetryInterval = time.Minute
+)
+
+// ErrPipelineClosed is returned for requests on a pipelined connection which
+// has failed, or been closed.
+var ErrPipelineClosed = errors.New("pipelined connection closed")
+
+// handlePipelinedConn serves requests pipelined on conn. Each request is
+// handled in its own goroutine, so responses may be written in a different
+// order than that in which requests were read.
+func (s *Service) handlePipelinedConn(conn net.Conn) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	var wMu sync.Mutex
+	sem := make(chan struct{}, maxPipelinedRequests)
+	for {
+		id, p, err := readFrame(conn)
+		if err != nil {
+			return
+		}
+		c := &Command{}
+		if err := proto.Unmarshal(p, c); err != nil {
+			return
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			p, err := s.handleCommand(c)
+			wMu.Lock()
+			defer wMu.Unlock()
+			if err != nil {
+				conn.Close()
+				return
+			}
+			if err := writeFrame(conn, id, p); err != nil {
+				conn.Close()
+			}
+		}()
+	}
+}
+
+// pipelinedConn is a connection to a remote node, on which requests are
+// pipelined.
+type pipelinedConn struct {
+	conn net.Conn
+
+	wMu sync.Mutex // Serializes writes of requests.
+
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan []byte
+	err     error
+
+	done chan struct{} // Closed once the connection has failed.
+}
+
+// newPipelinedConn switches conn to pipelined mode, returning a
+// pipelinedConn for sending requests on it. If the remote node does not
+// support pipelining, an error is returned once timeout has elapsed.
+func newPipelinedConn(conn net.Conn, timeout time.Duration) (*pipelinedConn, error) {
+	if err := writeCommand(conn, &Command{Type: Command_COMMAND_TYPE_PIPELINE}, timeout); err != nil {
+		return nil, err
+	}
+	p, err := readResponse(conn, timeout)
+	if err != nil {
+		return nil, err
+	}
+	resp := &CommandPipelineResponse{}
+	if err := proto.Unmarshal(p, resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+
+	// Responses are read as they arrive, however long requests take.
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	pc := &pipelinedConn{
+		conn:    conn,
+		pending: make(map[uint64]chan []byte),
+		done:    make(chan struct{}),
+	}
+	go pc.readResponses()
+	return pc, nil
+}
+
+// Do sends the command on the connection, and returns the response to it.
+// An error wrapping ErrPipelineClosed is returned if the connection fails,
+// in which case the request may or may not have been handled.
+func (pc *pipelinedConn) Do(c *Command, timeout time.Duration) ([]byte, error) {
+	p, err := proto.Marshal(c)
+	if err != nil {
+		return nil, fmt.Errorf("command marshal: %w", err)
+	}
+
+	ch := make(chan []byte, 1)
+	pc.mu.Lock()
+	if pc.err != nil {
+		pc.mu.Unlock()
+		return nil, pc.err
+	}
+	id := pc.nextID
+	pc.nextID++
+	pc.pending[id] = ch
+	pc.mu.Unlock()
+	defer func() {
+		pc.mu.Lock()
+		delete(pc.pending, id)
+		pc.mu.Unlock()
+	}()
+
+	pc.wMu.Lock()
+	err = pc.conn.SetWriteDeadline(time.Now().Add(timeout))
+	if err == nil {
+		err = writeFrame(pc.conn, id, p)
+	}
+	pc.wMu.Unlock()
+	if err != nil {
+		pc.fail(err)
+		return nil, pc.err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case p := <-ch:
+		return p, nil
+	case <-pc.done:
+		return nil, pc.err
+	case <-timer.C:
+		return nil, fmt.Errorf("pipelined request timed out after %s", timeout)
+	}
+}
+
+// Close closes the connection, failing any requests awaiting responses.
+func (pc *pipelinedConn) Close() error {
+	pc.fail(errors.New("closed"))
+	return nil
+}
+
+// Failed returns whether the connection has failed.
+func (pc *pipelinedConn) Failed() bool {
+	select {
+	case <-pc.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// readResponses reads responses from the connection, passing each to the
+// request awaiting it, until the connection fails. Responses to requests
+// which are no longer awaited, since they timed out, are discarded.
+func (pc *pipelinedConn) readResponses() {
+	for {
+		id, p, err := readFrame(pc.conn)
+		if err != nil {
+			pc.fail(err)
+			return
+		}
+		pc.mu.Lock()
+		ch, ok := pc.pending[id]
+		pc.mu.Unlock()
+		if ok {
+			ch <- p
+		}
+	}
+}
+
+func (pc *pipelinedConn) fail(err error) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if pc.err != nil {
+		return
+	}
+	pc.err = fmt.Errorf("%w: %s", ErrPipelineClosed, err.Error())
+	pc.conn.Close()
+	close(pc.done)
+}
+
+// readFrame reads a frame from a pipelined connection.
+func readFrame(conn net.Conn) (uint64, []byte, error) {
+	b := make([]byte, pipelineFrameHeaderSize)
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return 0, nil, err
+	}
+	id := binary.LittleEndian.Uint64(b[0:])
+	p := make([]byte, binary.LittleEndian.Uint64(b[8:]))
+	if _, err := io.ReadFull(conn, p); err != nil {
+		return 0, nil, err
+	}
+	return id, p, nil
+}
+
+// writeFrame writes a frame to a pipelined connection.
+func writeFrame(conn net.Conn, id uint64, p []byte) error {
+	b := make([]byte, pipelineFrameHeaderSize, pipelineFrameHeaderSize+len(p))
+	binary.LittleEndian.PutUint64(b[0:], id)
+	binary.LittleEndian.PutUint64(b[8:], uint64(len(p)))
+	_, err := conn.Write(append(b, p...))
+	return err
+}
@@ -0,0 +1,142 @@
+package cluster
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rqlite/rqlite/cluster/servicetest"
+	"github.com/rqlite/rqlite/command"
+	"google.golang.org/protobuf/proto"
+)
+
+func Test_ServicePipelined(t *testing.T) {
+	ln, mux := mustNewMux()
+	go mux.Serve()
+	tn := mux.Listen(1)
+	db := mustNewMockDatabase()
+	s := New(tn, db, mustNewMockManager(), mustNewMockCredentialStore())
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open cluster service: %s", err.Error())
+	}
+	defer s.Close()
+	defer ln.Close()
+
+	// Each request takes a while, and a slow one longer still.
+	db.executeFn = func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+		if er.Request.Statements[0].Sql == "slow" {
+			time.Sleep(time.Second)
+		} else {
+			time.Sleep(100 * time.Millisecond)
+		}
+		return []*command.ExecuteResult{{LastInsertId: 1, RowsAffected: 1}}, nil
+	}
+	db.queryFn = func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+		return []*command.QueryRows{{Columns: []string{qr.Request.Statements[0].Sql}}}, nil
+	}
+
+	c := NewClient(mustNewDialer(1, false, false), 30*time.Second)
+	c.EnablePipelining(true)
+
+	// The slow request must not hold up those sent after it, which are all
+	// handled at once.
+	start := time.Now()
+	var wg sync.WaitGroup
+	slowDone := make(chan time.Time, 1)
+	go func() {
+		if _, err := c.Execute(executeRequestFromString("slow"), s.Addr(), NO_CREDS, longWait); err != nil {
+			t.Errorf("slow execute failed: %s", err)
+		}
+		slowDone <- time.Now()
+	}()
+	time.Sleep(50 * time.Millisecond)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := c.Execute(executeRequestFromString("fast"), s.Addr(), NO_CREDS, longWait)
+			if err != nil {
+				t.Errorf("execute failed: %s", err)
+				return
+			}
+			if len(res) != 1 || res[0].RowsAffected != 1 {
+				t.Errorf("unexpected results: %v", res)
+			}
+		}()
+	}
+	wg.Wait()
+	fastElapsed := time.Since(start)
+	if slowT := <-slowDone; !slowT.After(start.Add(fastElapsed)) {
+		t.Fatalf("slow request completed before fast requests")
+	}
+	if fastElapsed > 700*time.Millisecond {
+		t.Fatalf("pipelined requests took too long: %s", fastElapsed)
+	}
+
+	// Responses must be matched to their requests.
+	for _, sql := range []string{"a", "b", "c"} {
+		rows, err := c.Query(queryRequestFromString(sql), s.Addr(), NO_CREDS, longWait)
+		if err != nil {
+			t.Fatalf("query failed: %s", err)
+		}
+		if rows[0].Columns[0] != sql {
+			t.Fatalf("wrong response to query %s: %v", sql, rows)
+		}
+	}
+
+	st, err := c.Stats()
+	if err != nil {
+		t.Fatalf("failed to get client stats: %s", err)
+	}
+	if nodes := st["pipelined_nodes"].([]string); len(nodes) != 1 || nodes[0] != s.Addr() {
+		t.Fatalf("wrong pipelined nodes: %v", nodes)
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if len(c.pools) != 0 {
+		t.Fatalf("pooled connections used while pipelining")
+	}
+}
+
+func Test_ClientPipelineFallback(t *testing.T) {
+	// A node which does not support pipelining.
+	srv := servicetest.NewService()
+	srv.Handler = func(conn net.Conn) {
+		for {
+			c := readCommand(conn)
+			if c == nil {
+				return
+			}
+			if c.Type != Command_COMMAND_TYPE_EXECUTE {
+				conn.Close()
+				return
+			}
+			p, err := proto.Marshal(&CommandExecuteResponse{
+				Results: []*command.ExecuteResult{{LastInsertId: 2}},
+			})
+			if err != nil {
+				conn.Close()
+				return
+			}
+			writeBytesWithLength(conn, p)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	c := NewClient(&simpleDialer{}, 5*time.Second)
+	c.EnablePipelining(true)
+	for i := 0; i < 2; i++ {
+		res, err := c.Execute(executeRequestFromString("INSERT INTO foo (id) VALUES (1)"), srv.Addr(), nil, time.Second)
+		if err != nil {
+			t.Fatalf("execute failed: %s", err)
+		}
+		if res[0].LastInsertId != 2 {
+			t.Fatalf("unexpected results: %v", res)
+		}
+	}
+	if pc := c.pipelined(srv.Addr()); pc != nil {
+		t.Fatalf("pipelined connection created for node which does not support it")
+	}
+}
@@ -0,0 +1,207 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rqlite/rqlite/command"
+)
+
+const (
+	replaceCatchupTimeout = 10 * time.Minute
+	replacePollInterval   = time.Second
+	replaceReqTimeout     = 5 * time.Second
+)
+
+var (
+	// ErrReplaceInProgress is returned when a node replacement is requested
+	// while one is already in progress.
+	ErrReplaceInProgress = errors.New("node replacement already in progress")
+
+	// ErrReplaceNotLeader is returned when a node replacement is requested of
+	// a node which is not the leader.
+	ErrReplaceNotLeader = errors.New("node replacement must be performed by leader")
+)
+
+// ReplaceControl is an interface for controlling the cluster during a node
+// replacement.
+type ReplaceControl interface {
+	IsLeader() bool
+	Join(jr *command.JoinRequest) error
+	SwapVoter(oldID, newID string) error
+	AppliedIndex() uint64
+}
+
+// Replacement describes the replacement of one node by another.
+type Replacement struct {
+	OldID   string `json:"old_id"`
+	NewID   string `json:"new_id"`
+	NewAddr string `json:"new_addr"`
+	Zone    string `json:"zone,omitempty"`
+}
+
+// Replacer replaces one node in the cluster with another. The successor is
+// first registered as a non-voter, so Raft brings it up to date without it
+// affecting quorum, sending it a snapshot if it is too far behind for the
+// log alone. Once it has caught up with the leader, it takes over the voting
+// rights of the node it replaces, which is then removed from the cluster.
+type Replacer struct {
+	client  *Client
+	control ReplaceControl
+
+	// CatchupTimeout is the maximum time the successor may take to catch up
+	// with the leader.
+	CatchupTimeout time.Duration
+
+	// PollInterval is the interval between checks of the successor.
+	PollInterval time.Duration
+
+	mu      sync.Mutex
+	running bool
+	current *Replacement
+	stage   string
+	lastErr error
+	startT  time.Time
+	endT    time.Time
+
+	logger *log.Logger
+}
+
+// NewReplacer returns an instantiated Replacer.
+func NewReplacer(client *Client, control ReplaceControl) *Replacer {
+	return &Replacer{
+		client:         client,
+		control:        control,
+		CatchupTimeout: replaceCatchupTimeout,
+		PollInterval:   replacePollInterval,
+		logger:         log.New(os.Stderr, "[cluster-replace] ", log.LstdFlags),
+	}
+}
+
+// Start starts the given replacement in the background. Start returns an
+// error if this node is not the leader, or a replacement is already in
+// progress.
+func (r *Replacer) Start(rp Replacement) error {
+	if rp.OldID == "" || rp.NewID == "" || rp.NewAddr == "" {
+		return fmt.Errorf("old node ID, new node ID, and new node address must be set")
+	}
+	if rp.OldID == rp.NewID {
+		return fmt.Errorf("node %s cannot replace itself", rp.OldID)
+	}
+	if !r.control.IsLeader() {
+		return ErrReplaceNotLeader
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running {
+		return ErrReplaceInProgress
+	}
+	r.running = true
+	r.current = &rp
+	r.stage = ""
+	r.lastErr = nil
+	r.startT = time.Now()
+	r.endT = time.Time{}
+
+	go func() {
+		err := r.do(rp)
+		if err != nil {
+			r.logger.Printf("replacement of node %s by node %s failed: %s", rp.OldID, rp.NewID, err.Error())
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.running = false
+		r.lastErr = err
+		r.endT = time.Now()
+	}()
+	return nil
+}
+
+// Stats returns the status of the current, or most recent, replacement.
+func (r *Replacer) Stats() (map[string]interface{}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := map[string]interface{}{
+		"running": r.running,
+		"stage":   r.stage,
+	}
+	if r.current != nil {
+		stats["replacement"] = *r.current
+	}
+	if !r.startT.IsZero() {
+		stats["start_time"] = r.startT
+	}
+	if !r.endT.IsZero() {
+		stats["end_time"] = r.endT
+	}
+	if r.lastErr != nil {
+		stats["error"] = r.lastErr.Error()
+	}
+	return stats, nil
+}
+
+func (r *Replacer) do(rp Replacement) error {
+	r.setStage("registering")
+	r.logger.Printf("registering node %s at %s as non-voter to replace node %s", rp.NewID, rp.NewAddr, rp.OldID)
+	if err := r.control.Join(&command.JoinRequest{
+		Id:      rp.NewID,
+		Address: rp.NewAddr,
+		Voter:   false,
+		Zone:    rp.Zone,
+	}); err != nil {
+		return fmt.Errorf("failed to register node %s: %w", rp.NewID, err)
+	}
+
+	r.setStage("catching_up")
+	if err := r.waitForCatchup(rp); err != nil {
+		return err
+	}
+
+	r.setStage("swapping")
+	if !r.control.IsLeader() {
+		return fmt.Errorf("leadership lost before swapping node %s for node %s", rp.OldID, rp.NewID)
+	}
+	if err := r.control.SwapVoter(rp.OldID, rp.NewID); err != nil {
+		return fmt.Errorf("failed to swap node %s for node %s: %w", rp.OldID, rp.NewID, err)
+	}
+	r.setStage("done")
+	r.logger.Printf("node %s replaced by node %s", rp.OldID, rp.NewID)
+	return nil
+}
+
+// waitForCatchup waits until the successor is ready and has applied all log
+// entries the leader had applied by the time the successor was registered.
+func (r *Replacer) waitForCatchup(rp Replacement) error {
+	target := r.control.AppliedIndex()
+
+	timer := time.NewTimer(r.CatchupTimeout)
+	defer timer.Stop()
+	ticker := time.NewTicker(r.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-timer.C:
+			return fmt.Errorf("node %s did not catch up within %s", rp.NewID, r.CatchupTimeout)
+		case <-ticker.C:
+			st, err := r.client.GetNodeStatus(rp.NewAddr, replaceReqTimeout)
+			if err != nil || !st.Ready {
+				continue
+			}
+			if st.AppliedIndex >= target {
+				r.logger.Printf("node %s caught up to index %d", rp.NewID, target)
+				return nil
+			}
+		}
+	}
+}
+
+func (r *Replacer) setStage(stage string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stage = stage
+}
@@ -0,0 +1,135 @@
+package cluster
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rqlite/rqlite/command"
+)
+
+func Test_ReplaceOK(t *testing.T) {
+	n := newFakeRestartNode(t, 10)
+	defer n.Close()
+
+	control := &mockReplaceControl{leader: true, appliedIndex: 10}
+	r := NewReplacer(NewClient(&simpleDialer{}, 0), control)
+	r.PollInterval = 10 * time.Millisecond
+	rp := Replacement{OldID: "1", NewID: "4", NewAddr: n.Addr(), Zone: "a"}
+	if err := r.Start(rp); err != nil {
+		t.Fatalf("failed to start replacement: %s", err.Error())
+	}
+	if err := r.Start(rp); err != ErrReplaceInProgress {
+		t.Fatalf("expected replacement in progress error, got %v", err)
+	}
+
+	stats := waitForReplaceDone(t, r)
+	if _, ok := stats["error"]; ok {
+		t.Fatalf("replacement failed: %s", stats["error"])
+	}
+	if stats["stage"] != "done" {
+		t.Fatalf("wrong final stage: %s", stats["stage"])
+	}
+
+	control.mu.Lock()
+	defer control.mu.Unlock()
+	jr := control.joined
+	if jr == nil || jr.Id != "4" || jr.Address != n.Addr() || jr.Voter || jr.Zone != "a" {
+		t.Fatalf("successor not registered as non-voter, got %v", jr)
+	}
+	if control.swapped != "1->4" {
+		t.Fatalf("voters not swapped, got %q", control.swapped)
+	}
+}
+
+func Test_ReplaceBadRequest(t *testing.T) {
+	r := NewReplacer(NewClient(&simpleDialer{}, 0), &mockReplaceControl{leader: true})
+	if err := r.Start(Replacement{OldID: "1", NewID: "2"}); err == nil {
+		t.Fatalf("expected error for replacement without address")
+	}
+	if err := r.Start(Replacement{OldID: "1", NewID: "1", NewAddr: "localhost:4002"}); err == nil {
+		t.Fatalf("expected error for node replacing itself")
+	}
+
+	r = NewReplacer(NewClient(&simpleDialer{}, 0), &mockReplaceControl{})
+	if err := r.Start(Replacement{OldID: "1", NewID: "2", NewAddr: "localhost:4002"}); err != ErrReplaceNotLeader {
+		t.Fatalf("expected not leader error, got %v", err)
+	}
+}
+
+func Test_ReplaceCatchupTimeout(t *testing.T) {
+	n := newFakeRestartNode(t, 5)
+	defer n.Close()
+
+	control := &mockReplaceControl{leader: true, appliedIndex: 10}
+	r := NewReplacer(NewClient(&simpleDialer{}, 0), control)
+	r.PollInterval = 10 * time.Millisecond
+	r.CatchupTimeout = 200 * time.Millisecond
+	if err := r.Start(Replacement{OldID: "1", NewID: "4", NewAddr: n.Addr()}); err != nil {
+		t.Fatalf("failed to start replacement: %s", err.Error())
+	}
+
+	stats := waitForReplaceDone(t, r)
+	if _, ok := stats["error"]; !ok {
+		t.Fatalf("expected successor which did not catch up to fail replacement")
+	}
+	control.mu.Lock()
+	defer control.mu.Unlock()
+	if control.swapped != "" {
+		t.Fatalf("voters swapped even though successor did not catch up")
+	}
+}
+
+type mockReplaceControl struct {
+	leader       bool
+	appliedIndex uint64
+
+	mu      sync.Mutex
+	joined  *command.JoinRequest
+	swapped string
+}
+
+func (m *mockReplaceControl) IsLeader() bool {
+	return m.leader
+}
+
+func (m *mockReplaceControl) Join(jr *command.JoinRequest) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.joined = jr
+	return nil
+}
+
+func (m *mockReplaceControl) SwapVoter(oldID, newID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.swapped = fmt.Sprintf("%s->%s", oldID, newID)
+	return nil
+}
+
+func (m *mockReplaceControl) AppliedIndex() uint64 {
+	return m.appliedIndex
+}
+
+func waitForReplaceDone(t *testing.T, r *Replacer) map[string]interface{} {
+	t.Helper()
+	timer := time.NewTimer(10 * time.Second)
+	defer timer.Stop()
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-timer.C:
+			t.Fatalf("timed out waiting for replacement to finish")
+		case <-ticker.C:
+			stats, err := r.Stats()
+			if err != nil {
+				t.Fatalf("failed to get replacement stats: %s", err.Error())
+			}
+			if !stats["running"].(bool) {
+				return stats
+			}
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	restartNodeTimeout  = 5 * time.Minute
+	restartPollInterval = time.Second
+	restartReqTimeout   = 5 * time.Second
+)
+
+var (
+	// ErrRestartInProgress is returned when a rolling restart is requested
+	// while one is already in progress.
+	ErrRestartInProgress = errors.New("rolling restart already in progress")
+
+	// ErrRestartNotLeader is returned when a rolling restart is requested of
+	// a node which is not the leader.
+	ErrRestartNotLeader = errors.New("rolling restart must be performed by leader")
+)
+
+// RestartControl is an interface for controlling this node during a rolling
+// restart.
+type RestartControl interface {
+	ID() string
+	IsLeader() bool
+	Stepdown(wait bool) error
+	AppliedIndex() uint64
+}
+
+// RestartNode is a node which is part of a rolling restart.
+type RestartNode struct {
+	ID   string `json:"id"`
+	Addr string `json:"addr"`
+}
+
+// RollingRestarter restarts every node in a cluster, one at a time. After
+// each node is restarted, it waits for the node to return and catch up with
+// the leader before moving on to the next. The leader, which orchestrates the
+// restart, is restarted last, after transferring leadership to another node.
+type RollingRestarter struct {
+	client  *Client
+	control RestartControl
+
+	// NodeTimeout is the maximum time a node may take to restart and catch
+	// up with the leader.
+	NodeTimeout time.Duration
+
+	// PollInterval is the interval between checks of a restarting node.
+	PollInterval time.Duration
+
+	mu        sync.Mutex
+	running   bool
+	current   string
+	restarted []string
+	lastErr   error
+	startT    time.Time
+	endT      time.Time
+
+	logger *log.Logger
+}
+
+// NewRollingRestarter returns an instantiated RollingRestarter.
+func NewRollingRestarter(client *Client, control RestartControl) *RollingRestarter {
+	return &RollingRestarter{
+		client:       client,
+		control:      control,
+		NodeTimeout:  restartNodeTimeout,
+		PollInterval: restartPollInterval,
+		logger:       log.New(os.Stderr, "[cluster-restart] ", log.LstdFlags),
+	}
+}
+
+// Start starts a rolling restart of the given nodes in the background. creds
+// are passed with each restart request. Start returns an error if this node
+// is not the leader, or a rolling restart is already in progress.
+func (r *RollingRestarter) Start(nodes []RestartNode, creds *Credentials) error {
+	if !r.control.IsLeader() {
+		return ErrRestartNotLeader
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running {
+		return ErrRestartInProgress
+	}
+	r.running = true
+	r.current = ""
+	r.restarted = nil
+	r.lastErr = nil
+	r.startT = time.Now()
+	r.endT = time.Time{}
+
+	go func() {
+		err := r.do(nodes, creds)
+		if err != nil {
+			r.logger.Printf("rolling restart failed: %s", err.Error())
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.running = false
+		r.current = ""
+		r.lastErr = err
+		r.endT = time.Now()
+	}()
+	return nil
+}
+
+// Stats returns the status of the current, or most recent, rolling restart.
+func (r *RollingRestarter) Stats() (map[string]interface{}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	restarted := make([]string, len(r.restarted))
+	copy(restarted, r.restarted)
+	stats := map[string]interface{}{
+		"running":   r.running,
+		"current":   r.current,
+		"restarted": restarted,
+	}
+	if !r.startT.IsZero() {
+		stats["start_time"] = r.startT
+	}
+	if !r.endT.IsZero() {
+		stats["end_time"] = r.endT
+	}
+	if r.lastErr != nil {
+		stats["error"] = r.lastErr.Error()
+	}
+	return stats, nil
+}
+
+func (r *RollingRestarter) do(nodes []RestartNode, creds *Credentials) error {
+	sorted := make([]RestartNode, len(nodes))
+	copy(sorted, nodes)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	var self *RestartNode
+	for i := range sorted {
+		n := sorted[i]
+		if n.ID == r.control.ID() {
+			self = &n
+			continue
+		}
+		if !r.control.IsLeader() {
+			return fmt.Errorf("leadership lost before restarting node %s", n.ID)
+		}
+		r.setCurrent(n.ID)
+		if err := r.restartNode(n, creds); err != nil {
+			return fmt.Errorf("failed to restart node %s: %w", n.ID, err)
+		}
+		r.setRestarted(n.ID)
+	}
+
+	if self == nil {
+		return nil
+	}
+	r.setCurrent(self.ID)
+	r.logger.Printf("transferring leadership before restarting this node")
+	if err := r.control.Stepdown(true); err != nil {
+		return fmt.Errorf("failed to transfer leadership: %w", err)
+	}
+	r.logger.Printf("restarting this node, completing rolling restart")
+	if err := r.client.Restart(self.Addr, creds, restartReqTimeout); err != nil {
+		return fmt.Errorf("failed to restart node %s: %w", self.ID, err)
+	}
+	r.setRestarted(self.ID)
+	return nil
+}
+
+// restartNode restarts the given node, and waits until it has restarted,
+// is ready, and has applied all log entries this node had applied by the
+// time it returned.
+func (r *RollingRestarter) restartNode(n RestartNode, creds *Credentials) error {
+	before, err := r.client.GetNodeStatus(n.Addr, restartReqTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to get status before restart: %w", err)
+	}
+	r.logger.Printf("restarting node %s at %s", n.ID, n.Addr)
+	if err := r.client.Restart(n.Addr, creds, restartReqTimeout); err != nil {
+		return err
+	}
+
+	timer := time.NewTimer(r.NodeTimeout)
+	defer timer.Stop()
+	ticker := time.NewTicker(r.PollInterval)
+	defer ticker.Stop()
+
+	var target uint64
+	for {
+		select {
+		case <-timer.C:
+			return fmt.Errorf("node did not restart and catch up within %s", r.NodeTimeout)
+		case <-ticker.C:
+			st, err := r.client.GetNodeStatus(n.Addr, restartReqTimeout)
+			if err != nil || st.StartTime == before.StartTime || !st.Ready {
+				continue
+			}
+			if target == 0 {
+				target = r.control.AppliedIndex()
+			}
+			if st.AppliedIndex >= target {
+				r.logger.Printf("node %s restarted and caught up to index %d", n.ID, target)
+				return nil
+			}
+		}
+	}
+}
+
+func (r *RollingRestarter) setCurrent(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.current = id
+}
+
+func (r *RollingRestarter) setRestarted(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.restarted = append(r.restarted, id)
+}
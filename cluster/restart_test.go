@@ -0,0 +1,183 @@
+package cluster
+
+import (
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rqlite/rqlite/cluster/servicetest"
+	"google.golang.org/protobuf/proto"
+)
+
+func Test_RollingRestartOK(t *testing.T) {
+	n2 := newFakeRestartNode(t, 10)
+	defer n2.Close()
+	n3 := newFakeRestartNode(t, 10)
+	defer n3.Close()
+	n1 := newFakeRestartNode(t, 10)
+	defer n1.Close()
+
+	control := &mockRestartControl{id: "1", leader: true, appliedIndex: 10}
+	r := NewRollingRestarter(NewClient(&simpleDialer{}, 0), control)
+	r.PollInterval = 10 * time.Millisecond
+	err := r.Start([]RestartNode{
+		{ID: "3", Addr: n3.Addr()},
+		{ID: "1", Addr: n1.Addr()},
+		{ID: "2", Addr: n2.Addr()},
+	}, nil)
+	if err != nil {
+		t.Fatalf("failed to start rolling restart: %s", err.Error())
+	}
+	if err := r.Start(nil, nil); err != ErrRestartInProgress {
+		t.Fatalf("expected restart in progress error, got %v", err)
+	}
+
+	stats := waitForRestartDone(t, r)
+	if _, ok := stats["error"]; ok {
+		t.Fatalf("rolling restart failed: %s", stats["error"])
+	}
+	if exp, got := []string{"2", "3", "1"}, stats["restarted"]; !reflect.DeepEqual(exp, got) {
+		t.Fatalf("wrong nodes restarted, exp %v, got %v", exp, got)
+	}
+	for i, n := range []*fakeRestartNode{n1, n2, n3} {
+		if n.Restarts() != 1 {
+			t.Fatalf("node %d restarted %d times", i+1, n.Restarts())
+		}
+	}
+	if !control.steppedDown {
+		t.Fatalf("leader did not step down before restarting")
+	}
+}
+
+func Test_RollingRestartNotLeader(t *testing.T) {
+	r := NewRollingRestarter(NewClient(&simpleDialer{}, 0), &mockRestartControl{id: "1"})
+	if err := r.Start(nil, nil); err != ErrRestartNotLeader {
+		t.Fatalf("expected not leader error, got %v", err)
+	}
+}
+
+func Test_RollingRestartNodeTimeout(t *testing.T) {
+	n2 := newFakeRestartNode(t, 5)
+	defer n2.Close()
+
+	control := &mockRestartControl{id: "1", leader: true, appliedIndex: 10}
+	r := NewRollingRestarter(NewClient(&simpleDialer{}, 0), control)
+	r.PollInterval = 10 * time.Millisecond
+	r.NodeTimeout = 200 * time.Millisecond
+	if err := r.Start([]RestartNode{{ID: "2", Addr: n2.Addr()}}, nil); err != nil {
+		t.Fatalf("failed to start rolling restart: %s", err.Error())
+	}
+
+	stats := waitForRestartDone(t, r)
+	if _, ok := stats["error"]; !ok {
+		t.Fatalf("expected node which did not catch up to fail rolling restart")
+	}
+	if len(stats["restarted"].([]string)) != 0 {
+		t.Fatalf("expected no nodes to be restarted, got %v", stats["restarted"])
+	}
+}
+
+// fakeRestartNode simulates a node which restarts when asked, and returns
+// having applied a fixed number of log entries.
+type fakeRestartNode struct {
+	*servicetest.Service
+
+	mu       sync.Mutex
+	startT   int64
+	restarts int
+}
+
+func newFakeRestartNode(t *testing.T, appliedIndex uint64) *fakeRestartNode {
+	n := &fakeRestartNode{
+		Service: servicetest.NewService(),
+		startT:  1,
+	}
+	n.Handler = func(conn net.Conn) {
+		c := readCommand(conn)
+		if c == nil {
+			return
+		}
+		var resp proto.Message
+		switch c.Type {
+		case Command_COMMAND_TYPE_RESTART:
+			n.mu.Lock()
+			n.startT++
+			n.restarts++
+			n.mu.Unlock()
+			resp = &CommandRestartResponse{}
+		case Command_COMMAND_TYPE_GET_NODE_STATUS:
+			n.mu.Lock()
+			resp = &CommandNodeStatusResponse{
+				Ready:        true,
+				AppliedIndex: appliedIndex,
+				StartTime:    n.startT,
+			}
+			n.mu.Unlock()
+		default:
+			t.Errorf("unexpected command type %s", c.Type)
+			return
+		}
+		p, err := proto.Marshal(resp)
+		if err != nil {
+			t.Errorf("failed to marshal response: %s", err.Error())
+			return
+		}
+		writeBytesWithLength(conn, p)
+	}
+	n.Start()
+	return n
+}
+
+func (n *fakeRestartNode) Restarts() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.restarts
+}
+
+type mockRestartControl struct {
+	id           string
+	leader       bool
+	appliedIndex uint64
+	steppedDown  bool
+}
+
+func (m *mockRestartControl) ID() string {
+	return m.id
+}
+
+func (m *mockRestartControl) IsLeader() bool {
+	return m.leader
+}
+
+func (m *mockRestartControl) Stepdown(wait bool) error {
+	m.steppedDown = true
+	return nil
+}
+
+func (m *mockRestartControl) AppliedIndex() uint64 {
+	return m.appliedIndex
+}
+
+func waitForRestartDone(t *testing.T, r *RollingRestarter) map[string]interface{} {
+	t.Helper()
+	timer := time.NewTimer(10 * time.Second)
+	defer timer.Stop()
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-timer.C:
+			t.Fatalf("timed out waiting for rolling restart to finish")
+		case <-ticker.C:
+			stats, err := r.Stats()
+			if err != nil {
+				t.Fatalf("failed to get rolling restart stats: %s", err.Error())
+			}
+			if !stats["running"].(bool) {
+				return stats
+			}
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package cluster
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	resyncRetries    = 5
+	resyncRetryDelay = time.Second
+	resyncReqTimeout = 5 * time.Minute
+)
+
+var (
+	// ErrResyncInProgress is returned when a resync is requested while one
+	// is already in progress.
+	ErrResyncInProgress = errors.New("resync already in progress")
+
+	// ErrResyncLeader is returned when a resync is requested of the leader,
+	// which has no other database to resync from.
+	ErrResyncLeader = errors.New("leader cannot be resynced")
+)
+
+// ResyncControl is an interface for controlling this node during a resync.
+type ResyncControl interface {
+	IsLeader() bool
+	LeaderAddr() (string, error)
+
+	// Resync replaces this node's database with the copy read from r, which
+	// reflects every log entry up to and including idx.
+	Resync(r io.Reader, idx uint64) error
+
+	// BackupWithIndex writes a copy of this node's database to dst, and
+	// returns the index of the last log entry reflected in it.
+	BackupWithIndex(dst io.Writer) (uint64, error)
+}
+
+// NodeResyncer resyncs this node, when it is a follower, by discarding its
+// database and installing a copy of the leader's, without removing the node
+// from the cluster. It also serves copies of this node's database to other
+// nodes resyncing from it.
+type NodeResyncer struct {
+	client  *Client
+	control ResyncControl
+
+	// Retries is the number of times a failed resync is retried. A resync
+	// fails if, for example, the copy of the leader's database is older
+	// than this node's by the time it arrives.
+	Retries int
+
+	// RetryDelay is the delay between retries.
+	RetryDelay time.Duration
+
+	mu      sync.Mutex
+	running bool
+	lastErr error
+	startT  time.Time
+	endT    time.Time
+	lastIdx uint64
+
+	logger *log.Logger
+}
+
+// NewNodeResyncer returns an instantiated NodeResyncer.
+func NewNodeResyncer(client *Client, control ResyncControl) *NodeResyncer {
+	return &NodeResyncer{
+		client:     client,
+		control:    control,
+		Retries:    resyncRetries,
+		RetryDelay: resyncRetryDelay,
+		logger:     log.New(os.Stderr, "[cluster-resync] ", log.LstdFlags),
+	}
+}
+
+// Resync starts a resync of this node in the background. creds are passed
+// with the request for a copy of the leader's database. Resync returns an
+// error if this node is the leader, or a resync is already in progress.
+func (r *NodeResyncer) Resync(creds *Credentials) error {
+	if r.control.IsLeader() {
+		return ErrResyncLeader
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running {
+		return ErrResyncInProgress
+	}
+	r.running = true
+	r.lastErr = nil
+	r.startT = time.Now()
+	r.endT = time.Time{}
+
+	go func() {
+		idx, err := r.Do(creds)
+		if err != nil {
+			r.logger.Printf("resync failed: %s", err.Error())
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.running = false
+		r.lastErr = err
+		r.endT = time.Now()
+		if err == nil {
+			r.lastIdx = idx
+		}
+	}()
+	return nil
+}
+
+// Do resyncs this node, returning once the resync has completed, and the
+// index of the last log entry reflected in the installed copy.
+func (r *NodeResyncer) Do(creds *Credentials) (uint64, error) {
+	var err error
+	for i := 0; i <= r.Retries; i++ {
+		if i > 0 {
+			time.Sleep(r.RetryDelay)
+		}
+		var idx uint64
+		if idx, err = r.resync(creds); err == nil {
+			return idx, nil
+		}
+		r.logger.Printf("attempt %d to resync failed: %s", i+1, err.Error())
+	}
+	return 0, err
+}
+
+// BackupWithIndex writes a copy of this node's database to dst, and returns
+// the index of the last log entry reflected in it.
+func (r *NodeResyncer) BackupWithIndex(dst io.Writer) (uint64, error) {
+	return r.control.BackupWithIndex(dst)
+}
+
+// Stats returns the status of the current, or most recent, resync.
+func (r *NodeResyncer) Stats() (map[string]interface{}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := map[string]interface{}{
+		"running":    r.running,
+		"last_index": r.lastIdx,
+	}
+	if !r.startT.IsZero() {
+		stats["start_time"] = r.startT
+	}
+	if !r.endT.IsZero() {
+		stats["end_time"] = r.endT
+	}
+	if r.lastErr != nil {
+		stats["error"] = r.lastErr.Error()
+	}
+	return stats, nil
+}
+
+func (r *NodeResyncer) resync(creds *Credentials) (uint64, error) {
+	if r.control.IsLeader() {
+		return 0, ErrResyncLeader
+	}
+	laddr, err := r.control.LeaderAddr()
+	if err != nil {
+		return 0, err
+	}
+	if laddr == "" {
+		return 0, fmt.Errorf("no leader available")
+	}
+
+	r.logger.Printf("retrieving copy of leader's database from %s", laddr)
+	buf := new(bytes.Buffer)
+	idx, err := r.client.BackupWithIndex(laddr, creds, resyncReqTimeout, buf)
+	if err != nil {
+		return 0, fmt.Errorf("failed to retrieve copy of leader's database: %w", err)
+	}
+	if err := r.control.Resync(buf, idx); err != nil {
+		return 0, err
+	}
+	return idx, nil
+}
@@ -0,0 +1,101 @@
+package cluster
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_NodeResyncer(t *testing.T) {
+	ml := mustNewMockTransport()
+	s := New(ml, mustNewMockDatabase(), mustNewMockManager(), mustNewMockCredentialStore())
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open cluster service")
+	}
+	defer s.Close()
+	c := NewClient(ml, 30*time.Second)
+
+	if err := c.Resync(s.Addr(), nil, 5*time.Second); err == nil || err.Error() != "resync not supported" {
+		t.Fatalf("expected resync not supported error, got %v", err)
+	}
+
+	// The service acts as both the leader, serving the copy, and the node
+	// resyncing from it.
+	ctrl := &mockResyncControl{leaderAddr: s.Addr(), data: "database copy", idx: 7, done: make(chan struct{})}
+	r := NewNodeResyncer(c, ctrl)
+	s.SetResyncer(r)
+
+	if err := c.Resync(s.Addr(), nil, 5*time.Second); err != nil {
+		t.Fatalf("failed to request resync: %s", err.Error())
+	}
+	select {
+	case <-ctrl.done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for resync")
+	}
+	data, idx := ctrl.resynced()
+	if data != "database copy" || idx != 7 {
+		t.Fatalf("wrong copy installed, got %q at index %d", data, idx)
+	}
+
+	ctrl.setLeader(true)
+	if err := r.Resync(nil); err != ErrResyncLeader {
+		t.Fatalf("expected ErrResyncLeader, got %v", err)
+	}
+}
+
+type mockResyncControl struct {
+	leaderAddr string
+	data       string
+	idx        uint64
+	done       chan struct{}
+
+	mu          sync.Mutex
+	leader      bool
+	resyncData  string
+	resyncIndex uint64
+}
+
+func (m *mockResyncControl) IsLeader() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.leader
+}
+
+func (m *mockResyncControl) setLeader(b bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.leader = b
+}
+
+func (m *mockResyncControl) LeaderAddr() (string, error) {
+	return m.leaderAddr, nil
+}
+
+func (m *mockResyncControl) Resync(r io.Reader, idx uint64) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.resyncData = string(b)
+	m.resyncIndex = idx
+	m.mu.Unlock()
+	close(m.done)
+	return nil
+}
+
+func (m *mockResyncControl) BackupWithIndex(dst io.Writer) (uint64, error) {
+	if _, err := io.Copy(dst, bytes.NewBufferString(m.data)); err != nil {
+		return 0, err
+	}
+	return m.idx, nil
+}
+
+func (m *mockResyncControl) resynced() (string, uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.resyncData, m.resyncIndex
+}
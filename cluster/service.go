@@ -34,7 +34,14 @@ const (
 	numRemoveNodeRequest  = "num_remove_node_req"
 	numNotifyRequest      = "num_notify_req"
 	numJoinRequest        = "num_join_req"
+	numRestartRequest     = "num_restart_req"
+	numNodeStatusRequest  = "num_node_status_req"
+	numPipelineRequest    = "num_pipeline_req"
+	numChecksumRequest    = "num_checksum_req"
+	numResyncRequest      = "num_resync_req"
+	numBackupIndexRequest = "num_backup_index_req"
 	numClientRetries      = "num_client_retries"
+	numClientPipelined    = "num_client_pipelined"
 
 	// Client stats for this package.
 	numGetNodeAPIRequestLocal = "num_get_node_api_req_local"
@@ -62,7 +69,14 @@ func init() {
 	stats.Add(numGetNodeAPIRequestLocal, 0)
 	stats.Add(numNotifyRequest, 0)
 	stats.Add(numJoinRequest, 0)
+	stats.Add(numRestartRequest, 0)
+	stats.Add(numNodeStatusRequest, 0)
+	stats.Add(numPipelineRequest, 0)
+	stats.Add(numChecksumRequest, 0)
+	stats.Add(numResyncRequest, 0)
+	stats.Add(numBackupIndexRequest, 0)
 	stats.Add(numClientRetries, 0)
+	stats.Add(numClientPipelined, 0)
 }
 
 // Dialer is the interface dialers must implement.
@@ -105,6 +119,21 @@ type Manager interface {
 
 	// Join joins a remote node to the cluster.
 	Join(n *command.JoinRequest) error
+
+	// Ready returns whether this node is ready to serve requests.
+	Ready() bool
+
+	// AppliedIndex returns the index of the last log entry applied by
+	// this node.
+	AppliedIndex() uint64
+}
+
+// Restarter is the interface systems which can restart this node must
+// implement.
+type Restarter interface {
+	// Restart schedules a restart of this node, returning before the
+	// restart takes place.
+	Restart() error
 }
 
 // CredentialStore is the interface credential stores must support.
@@ -113,6 +142,27 @@ type CredentialStore interface {
 	AA(username, password, perm string) bool
 }
 
+// Checksummer is the interface systems which keep checksums of the database
+// must implement.
+type Checksummer interface {
+	// ChecksumAt returns the checksum of each table in the database, taken
+	// at the given log index.
+	ChecksumAt(idx uint64) (map[string]string, error)
+}
+
+// Resyncer is the interface systems which resync databases from the Leader's
+// must implement.
+type Resyncer interface {
+	// Resync schedules a resync of this node's database from the Leader's,
+	// returning before the resync takes place.
+	Resync(creds *Credentials) error
+
+	// BackupWithIndex writes a copy of this node's database, from which
+	// other nodes may resync, to dst, and returns the index of the last log
+	// entry reflected in it.
+	BackupWithIndex(dst io.Writer) (uint64, error)
+}
+
 // Transport is the interface the network layer must provide.
 type Transport interface {
 	net.Listener
@@ -129,6 +179,11 @@ type Service struct {
 
 	credentialStore CredentialStore
 
+	restarter   Restarter   // Restarts this node, if set.
+	checksummer Checksummer // Provides checksums of the database, if set.
+	resyncer    Resyncer    // Resyncs this node, and serves copies for resyncs, if set.
+	startT      time.Time   // Time this service was created.
+
 	mu      sync.RWMutex
 	https   bool   // Serving HTTPS?
 	apiAddr string // host:port this node serves the HTTP API.
@@ -145,6 +200,7 @@ func New(tn Transport, db Database, m Manager, credentialStore CredentialStore)
 		mgr:             m,
 		logger:          log.New(os.Stderr, "[cluster] ", log.LstdFlags),
 		credentialStore: credentialStore,
+		startT:          time.Now(),
 	}
 }
 
@@ -180,6 +236,31 @@ func (s *Service) SetAPIAddr(addr string) {
 	s.apiAddr = addr
 }
 
+// SetRestarter sets the system which restarts this node when requested. If
+// not set, restart requests are rejected.
+func (s *Service) SetRestarter(r Restarter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.restarter = r
+}
+
+// SetChecksummer sets the system which provides checksums of the database
+// when requested. If not set, checksum requests are rejected.
+func (s *Service) SetChecksummer(c Checksummer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checksummer = c
+}
+
+// SetResyncer sets the system which resyncs this node, and serves copies of
+// the database from which other nodes resync, when requested. If not set,
+// such requests are rejected.
+func (s *Service) SetResyncer(r Resyncer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resyncer = r
+}
+
 // GetAPIAddr returns the previously-set API address
 func (s *Service) GetAPIAddr() string {
 	s.mu.RLock()
@@ -275,189 +356,296 @@ func (s *Service) handleConn(conn net.Conn) {
 		c := &Command{}
 		err = proto.Unmarshal(p, c)
 		if err != nil {
-			conn.Close()
+			return
+		}
+
+		if c.Type == Command_COMMAND_TYPE_PIPELINE {
+			stats.Add(numPipelineRequest, 1)
+			marshalAndWrite(conn, &CommandPipelineResponse{})
+			s.handlePipelinedConn(conn)
+			return
 		}
 
-		switch c.Type {
-		case Command_COMMAND_TYPE_GET_NODE_API_URL:
-			stats.Add(numGetNodeAPIRequest, 1)
-			p, err = proto.Marshal(&Address{
-				Url: s.GetNodeAPIURL(),
-			})
+		p, err = s.handleCommand(c)
+		if err != nil {
+			return
+		}
+		writeBytesWithLength(conn, p)
+	}
+}
+
+// handleCommand handles the command c, returning the response to be written
+// to the client.
+func (s *Service) handleCommand(c *Command) ([]byte, error) {
+	switch c.Type {
+	case Command_COMMAND_TYPE_GET_NODE_API_URL:
+		stats.Add(numGetNodeAPIRequest, 1)
+		p, err := proto.Marshal(&Address{
+			Url: s.GetNodeAPIURL(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		stats.Add(numGetNodeAPIResponse, 1)
+		return p, nil
+
+	case Command_COMMAND_TYPE_EXECUTE:
+		stats.Add(numExecuteRequest, 1)
+		resp := &CommandExecuteResponse{}
+
+		er := c.GetExecuteRequest()
+		if er == nil {
+			resp.Error = "ExecuteRequest is nil"
+		} else if !s.checkCommandPerm(c, auth.PermExecute) {
+			resp.Error = "unauthorized"
+		} else {
+			res, err := s.db.Execute(er)
 			if err != nil {
-				conn.Close()
+				resp.Error = err.Error()
+			} else {
+				resp.Results = make([]*command.ExecuteResult, len(res))
+				copy(resp.Results, res)
 			}
-			writeBytesWithLength(conn, p)
-			stats.Add(numGetNodeAPIResponse, 1)
-
-		case Command_COMMAND_TYPE_EXECUTE:
-			stats.Add(numExecuteRequest, 1)
-			resp := &CommandExecuteResponse{}
-
-			er := c.GetExecuteRequest()
-			if er == nil {
-				resp.Error = "ExecuteRequest is nil"
-			} else if !s.checkCommandPerm(c, auth.PermExecute) {
-				resp.Error = "unauthorized"
+		}
+		return proto.Marshal(resp)
+
+	case Command_COMMAND_TYPE_QUERY:
+		stats.Add(numQueryRequest, 1)
+		resp := &CommandQueryResponse{}
+
+		qr := c.GetQueryRequest()
+		if qr == nil {
+			resp.Error = "QueryRequest is nil"
+		} else if !s.checkCommandPerm(c, auth.PermQuery) {
+			resp.Error = "unauthorized"
+		} else {
+			res, err := s.db.Query(qr)
+			if err != nil {
+				resp.Error = err.Error()
 			} else {
-				res, err := s.db.Execute(er)
-				if err != nil {
-					resp.Error = err.Error()
-				} else {
-					resp.Results = make([]*command.ExecuteResult, len(res))
-					copy(resp.Results, res)
-				}
+				resp.Rows = make([]*command.QueryRows, len(res))
+				copy(resp.Rows, res)
 			}
-			marshalAndWrite(conn, resp)
-
-		case Command_COMMAND_TYPE_QUERY:
-			stats.Add(numQueryRequest, 1)
-			resp := &CommandQueryResponse{}
-
-			qr := c.GetQueryRequest()
-			if qr == nil {
-				resp.Error = "QueryRequest is nil"
-			} else if !s.checkCommandPerm(c, auth.PermQuery) {
-				resp.Error = "unauthorized"
+		}
+		return proto.Marshal(resp)
+
+	case Command_COMMAND_TYPE_REQUEST:
+		stats.Add(numRequestRequest, 1)
+		resp := &CommandRequestResponse{}
+
+		rr := c.GetExecuteQueryRequest()
+		if rr == nil {
+			resp.Error = "RequestRequest is nil"
+		} else if !s.checkCommandPermAll(c, auth.PermQuery, auth.PermExecute) {
+			resp.Error = "unauthorized"
+		} else {
+			res, err := s.db.Request(rr)
+			if err != nil {
+				resp.Error = err.Error()
 			} else {
-				res, err := s.db.Query(qr)
-				if err != nil {
-					resp.Error = err.Error()
-				} else {
-					resp.Rows = make([]*command.QueryRows, len(res))
-					copy(resp.Rows, res)
-				}
+				resp.Response = make([]*command.ExecuteQueryResponse, len(res))
+				copy(resp.Response, res)
 			}
-			marshalAndWrite(conn, resp)
-
-		case Command_COMMAND_TYPE_REQUEST:
-			stats.Add(numRequestRequest, 1)
-			resp := &CommandRequestResponse{}
-
-			rr := c.GetExecuteQueryRequest()
-			if rr == nil {
-				resp.Error = "RequestRequest is nil"
-			} else if !s.checkCommandPermAll(c, auth.PermQuery, auth.PermExecute) {
-				resp.Error = "unauthorized"
+		}
+		return proto.Marshal(resp)
+
+	case Command_COMMAND_TYPE_BACKUP:
+		stats.Add(numBackupRequest, 1)
+		resp := &CommandBackupResponse{}
+
+		br := c.GetBackupRequest()
+		if br == nil {
+			resp.Error = "BackupRequest is nil"
+		} else if !s.checkCommandPerm(c, auth.PermBackup) {
+			resp.Error = "unauthorized"
+		} else {
+			buf := new(bytes.Buffer)
+			if err := s.db.Backup(br, buf); err != nil {
+				resp.Error = err.Error()
 			} else {
-				res, err := s.db.Request(rr)
-				if err != nil {
-					resp.Error = err.Error()
-				} else {
-					resp.Response = make([]*command.ExecuteQueryResponse, len(res))
-					copy(resp.Response, res)
-				}
+				resp.Data = buf.Bytes()
 			}
-			marshalAndWrite(conn, resp)
-
-		case Command_COMMAND_TYPE_BACKUP:
-			stats.Add(numBackupRequest, 1)
-			resp := &CommandBackupResponse{}
+		}
+		p, err := proto.Marshal(resp)
+		if err != nil {
+			return nil, err
+		}
 
-			br := c.GetBackupRequest()
-			if br == nil {
-				resp.Error = "BackupRequest is nil"
-			} else if !s.checkCommandPerm(c, auth.PermBackup) {
-				resp.Error = "unauthorized"
-			} else {
-				buf := new(bytes.Buffer)
-				if err := s.db.Backup(br, buf); err != nil {
-					resp.Error = err.Error()
-				} else {
-					resp.Data = buf.Bytes()
-				}
+		// Compress the backup for less space on the wire between nodes.
+		return gzCompress(p)
+
+	case Command_COMMAND_TYPE_LOAD:
+		stats.Add(numLoadRequest, 1)
+		resp := &CommandLoadResponse{}
+
+		lr := c.GetLoadRequest()
+		if lr == nil {
+			resp.Error = "LoadRequest is nil"
+		} else if !s.checkCommandPerm(c, auth.PermLoad) {
+			resp.Error = "unauthorized"
+		} else {
+			if err := s.db.Load(lr); err != nil {
+				resp.Error = fmt.Sprintf("remote node failed to load: %s", err.Error())
 			}
-			p, err = proto.Marshal(resp)
-			if err != nil {
-				conn.Close()
-				return
+		}
+		return proto.Marshal(resp)
+
+	case Command_COMMAND_TYPE_LOAD_CHUNK:
+		stats.Add(numLoadChunkRequest, 1)
+		resp := &CommandLoadChunkResponse{}
+
+		lcr := c.GetLoadChunkRequest()
+		if lcr == nil {
+			resp.Error = "LoadChunkRequest is nil"
+		} else if !s.checkCommandPerm(c, auth.PermLoad) {
+			resp.Error = "unauthorized"
+		} else {
+			if err := s.db.LoadChunk(lcr); err != nil {
+				resp.Error = fmt.Sprintf("remote node failed to load chunk: %s", err.Error())
 			}
-
-			// Compress the backup for less space on the wire between nodes.
-			p, err = gzCompress(p)
-			if err != nil {
-				conn.Close()
-				return
+		}
+		return proto.Marshal(resp)
+
+	case Command_COMMAND_TYPE_REMOVE_NODE:
+		stats.Add(numRemoveNodeRequest, 1)
+		resp := &CommandRemoveNodeResponse{}
+
+		rn := c.GetRemoveNodeRequest()
+		if rn == nil {
+			resp.Error = "LoadRequest is nil"
+		} else if !s.checkCommandPerm(c, auth.PermRemove) {
+			resp.Error = "unauthorized"
+		} else {
+			if err := s.mgr.Remove(rn); err != nil {
+				resp.Error = err.Error()
 			}
-			writeBytesWithLength(conn, p)
-
-		case Command_COMMAND_TYPE_LOAD:
-			stats.Add(numLoadRequest, 1)
-			resp := &CommandLoadResponse{}
-
-			lr := c.GetLoadRequest()
-			if lr == nil {
-				resp.Error = "LoadRequest is nil"
-			} else if !s.checkCommandPerm(c, auth.PermLoad) {
-				resp.Error = "unauthorized"
-			} else {
-				if err := s.db.Load(lr); err != nil {
-					resp.Error = fmt.Sprintf("remote node failed to load: %s", err.Error())
-				}
+		}
+		return proto.Marshal(resp)
+
+	case Command_COMMAND_TYPE_NOTIFY:
+		stats.Add(numNotifyRequest, 1)
+		resp := &CommandNotifyResponse{}
+
+		nr := c.GetNotifyRequest()
+		if nr == nil {
+			resp.Error = "NotifyRequest is nil"
+		} else {
+			if err := s.mgr.Notify(nr); err != nil {
+				resp.Error = err.Error()
 			}
-			marshalAndWrite(conn, resp)
-
-		case Command_COMMAND_TYPE_LOAD_CHUNK:
-			stats.Add(numLoadChunkRequest, 1)
-			resp := &CommandLoadChunkResponse{}
-
-			lcr := c.GetLoadChunkRequest()
-			if lcr == nil {
-				resp.Error = "LoadChunkRequest is nil"
-			} else if !s.checkCommandPerm(c, auth.PermLoad) {
-				resp.Error = "unauthorized"
-			} else {
-				if err := s.db.LoadChunk(lcr); err != nil {
-					resp.Error = fmt.Sprintf("remote node failed to load chunk: %s", err.Error())
-				}
+		}
+		return proto.Marshal(resp)
+
+	case Command_COMMAND_TYPE_JOIN:
+		stats.Add(numJoinRequest, 1)
+		resp := &CommandJoinResponse{}
+
+		jr := c.GetJoinRequest()
+		if jr == nil {
+			resp.Error = "JoinRequest is nil"
+		} else {
+			if err := s.mgr.Join(jr); err != nil {
+				resp.Error = err.Error()
 			}
-			marshalAndWrite(conn, resp)
-
-		case Command_COMMAND_TYPE_REMOVE_NODE:
-			stats.Add(numRemoveNodeRequest, 1)
-			resp := &CommandRemoveNodeResponse{}
-
-			rn := c.GetRemoveNodeRequest()
-			if rn == nil {
-				resp.Error = "LoadRequest is nil"
-			} else if !s.checkCommandPerm(c, auth.PermRemove) {
-				resp.Error = "unauthorized"
-			} else {
-				if err := s.mgr.Remove(rn); err != nil {
-					resp.Error = err.Error()
-				}
+		}
+		return proto.Marshal(resp)
+
+	case Command_COMMAND_TYPE_RESTART:
+		stats.Add(numRestartRequest, 1)
+		resp := &CommandRestartResponse{}
+
+		s.mu.RLock()
+		restarter := s.restarter
+		s.mu.RUnlock()
+		if !s.checkCommandPerm(c, auth.PermRestart) {
+			resp.Error = "unauthorized"
+		} else if restarter == nil {
+			resp.Error = "restart not supported"
+		} else {
+			s.logger.Printf("received request to restart this node")
+			if err := restarter.Restart(); err != nil {
+				resp.Error = err.Error()
 			}
-			marshalAndWrite(conn, resp)
-
-		case Command_COMMAND_TYPE_NOTIFY:
-			stats.Add(numNotifyRequest, 1)
-			resp := &CommandNotifyResponse{}
-
-			nr := c.GetNotifyRequest()
-			if nr == nil {
-				resp.Error = "NotifyRequest is nil"
+		}
+		return proto.Marshal(resp)
+
+	case Command_COMMAND_TYPE_GET_NODE_STATUS:
+		stats.Add(numNodeStatusRequest, 1)
+		return proto.Marshal(&CommandNodeStatusResponse{
+			Ready:        s.mgr.Ready(),
+			AppliedIndex: s.mgr.AppliedIndex(),
+			StartTime:    s.startT.UnixNano(),
+		})
+
+	case Command_COMMAND_TYPE_GET_CHECKSUM:
+		stats.Add(numChecksumRequest, 1)
+		resp := &CommandChecksumResponse{}
+
+		s.mu.RLock()
+		checksummer := s.checksummer
+		s.mu.RUnlock()
+		cr := c.GetChecksumRequest()
+		if cr == nil {
+			resp.Error = "ChecksumRequest is nil"
+		} else if checksummer == nil {
+			resp.Error = "checksums not supported"
+		} else {
+			sums, err := checksummer.ChecksumAt(cr.Index)
+			if err != nil {
+				resp.Error = err.Error()
 			} else {
-				if err := s.mgr.Notify(nr); err != nil {
-					resp.Error = err.Error()
-				}
+				resp.Checksums = sums
 			}
-			marshalAndWrite(conn, resp)
-
-		case Command_COMMAND_TYPE_JOIN:
-			stats.Add(numJoinRequest, 1)
-			resp := &CommandJoinResponse{}
-
-			jr := c.GetJoinRequest()
-			if jr == nil {
-				resp.Error = "JoinRequest is nil"
+		}
+		return proto.Marshal(resp)
+
+	case Command_COMMAND_TYPE_RESYNC:
+		stats.Add(numResyncRequest, 1)
+		resp := &CommandResyncResponse{}
+
+		s.mu.RLock()
+		resyncer := s.resyncer
+		s.mu.RUnlock()
+		if !s.checkCommandPerm(c, auth.PermLoad) {
+			resp.Error = "unauthorized"
+		} else if resyncer == nil {
+			resp.Error = "resync not supported"
+		} else {
+			s.logger.Printf("received request to resync this node")
+			if err := resyncer.Resync(c.Credentials); err != nil {
+				resp.Error = err.Error()
+			}
+		}
+		return proto.Marshal(resp)
+
+	case Command_COMMAND_TYPE_BACKUP_WITH_INDEX:
+		stats.Add(numBackupIndexRequest, 1)
+		resp := &CommandBackupWithIndexResponse{}
+
+		s.mu.RLock()
+		resyncer := s.resyncer
+		s.mu.RUnlock()
+		if !s.checkCommandPerm(c, auth.PermBackup) {
+			resp.Error = "unauthorized"
+		} else if resyncer == nil {
+			resp.Error = "resync not supported"
+		} else {
+			buf := new(bytes.Buffer)
+			idx, err := resyncer.BackupWithIndex(buf)
+			if err != nil {
+				resp.Error = err.Error()
 			} else {
-				if err := s.mgr.Join(jr); err != nil {
-					resp.Error = err.Error()
-				}
+				resp.Index = idx
+				resp.Data = buf.Bytes()
 			}
-			marshalAndWrite(conn, resp)
 		}
+		p, err := proto.Marshal(resp)
+		if err != nil {
+			return nil, err
+		}
+		return gzCompress(p)
 	}
+	return nil, fmt.Errorf("unsupported command type %s", c.Type)
 }
 
 func marshalAndWrite(conn net.Conn, m proto.Message) {
@@ -2,6 +2,7 @@ package cluster
 
 import (
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -357,6 +358,90 @@ func Test_NewServiceJoin(t *testing.T) {
 	wg.Wait()
 }
 
+func Test_NewServiceRestart(t *testing.T) {
+	ml := mustNewMockTransport()
+	mm := mustNewMockManager()
+	mm.appliedIndex = 5
+	cred := mustNewMockCredentialStore()
+	s := New(ml, mustNewMockDatabase(), mm, cred)
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open cluster service")
+	}
+	defer s.Close()
+	c := NewClient(ml, 30*time.Second)
+
+	if err := c.Restart(s.Addr(), nil, 5*time.Second); err == nil || err.Error() != "restart not supported" {
+		t.Fatalf("expected restart not supported error, got %v", err)
+	}
+
+	restarted := make(chan struct{}, 1)
+	s.SetRestarter(mockRestarter(restarted))
+	cred.HasPermOK = false
+	if err := c.Restart(s.Addr(), nil, 5*time.Second); err == nil || err.Error() != "unauthorized" {
+		t.Fatalf("expected unauthorized error, got %v", err)
+	}
+	cred.HasPermOK = true
+	if err := c.Restart(s.Addr(), nil, 5*time.Second); err != nil {
+		t.Fatalf("failed to restart node: %s", err.Error())
+	}
+	select {
+	case <-restarted:
+	default:
+		t.Fatalf("restarter not called")
+	}
+
+	st, err := c.GetNodeStatus(s.Addr(), 5*time.Second)
+	if err != nil {
+		t.Fatalf("failed to get node status: %s", err.Error())
+	}
+	if !st.Ready || st.AppliedIndex != 5 || st.StartTime != s.startT.UnixNano() {
+		t.Fatalf("wrong node status returned: %v", st)
+	}
+}
+
+func Test_NewServiceGetChecksum(t *testing.T) {
+	ml := mustNewMockTransport()
+	s := New(ml, mustNewMockDatabase(), mustNewMockManager(), mustNewMockCredentialStore())
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open cluster service")
+	}
+	defer s.Close()
+	c := NewClient(ml, 30*time.Second)
+
+	if _, err := c.GetChecksum(5, s.Addr(), 5*time.Second); err == nil || err.Error() != "checksums not supported" {
+		t.Fatalf("expected checksums not supported error, got %v", err)
+	}
+
+	s.SetChecksummer(mockChecksummer{5: {"foo": "abc"}})
+	sums, err := c.GetChecksum(5, s.Addr(), 5*time.Second)
+	if err != nil {
+		t.Fatalf("failed to get checksum: %s", err.Error())
+	}
+	if len(sums) != 1 || sums["foo"] != "abc" {
+		t.Fatalf("wrong checksums returned: %v", sums)
+	}
+	if _, err := c.GetChecksum(6, s.Addr(), 5*time.Second); err == nil || err.Error() != "checksum not found" {
+		t.Fatalf("expected checksum not found error, got %v", err)
+	}
+}
+
+type mockChecksummer map[uint64]map[string]string
+
+func (m mockChecksummer) ChecksumAt(idx uint64) (map[string]string, error) {
+	sums, ok := m[idx]
+	if !ok {
+		return nil, errors.New("checksum not found")
+	}
+	return sums, nil
+}
+
+type mockRestarter chan struct{}
+
+func (m mockRestarter) Restart() error {
+	m <- struct{}{}
+	return nil
+}
+
 type mockTransport struct {
 	tn              net.Listener
 	remoteEncrypted bool
@@ -468,6 +553,8 @@ type MockManager struct {
 	removeNodeFn func(rn *command.RemoveNodeRequest) error
 	notifyFn     func(n *command.NotifyRequest) error
 	joinFn       func(j *command.JoinRequest) error
+	notReady     bool
+	appliedIndex uint64
 }
 
 func (m *MockManager) Remove(rn *command.RemoveNodeRequest) error {
@@ -491,6 +578,14 @@ func (m *MockManager) Join(j *command.JoinRequest) error {
 	return m.joinFn(j)
 }
 
+func (m *MockManager) Ready() bool {
+	return !m.notReady
+}
+
+func (m *MockManager) AppliedIndex() uint64 {
+	return m.appliedIndex
+}
+
 func mustNewMockManager() *MockManager {
 	return &MockManager{}
 }
@@ -48,22 +48,22 @@ type argT struct {
 }
 
 var cliHelp = []string{
-	`.backup <file>                      Write database backup to SQLite file`,
-	`.consistency [none|weak|strong]     Show or set read consistency level`,
-	`.dump <file>                        Dump the database in SQL text format to a file`,
-	`.exit                               Exit this program`,
-	`.expvar                             Show expvar (Go runtime) information for connected node`,
-	`.help                               Show this message`,
-	`.indexes                            Show names of all indexes`,
-	`.ready                              Show ready status for connected node`,
-	`.restore <file>                     Restore the database from a SQLite database file or dump file`,
-	`.nodes                              Show connection status of all nodes in cluster`,
-	`.schema                             Show CREATE statements for all tables`,
-	`.status                             Show status and diagnostic information for connected node`,
-	`.sysdump <file>                     Dump system diagnostics to a file for offline analysis`,
-	`.tables                             List names of tables`,
-	`.timer on|off                       Turn query timer on or off`,
-	`.remove <raft ID>                   Remove a node from the cluster`,
+	`.backup <file>                               Write database backup to SQLite file`,
+	`.consistency [none|weak|linearizable|strong] Show or set read consistency level`,
+	`.dump <file>                                 Dump the database in SQL text format to a file`,
+	`.exit                                        Exit this program`,
+	`.expvar                                      Show expvar (Go runtime) information for connected node`,
+	`.help                                        Show this message`,
+	`.indexes                                     Show names of all indexes`,
+	`.ready                                       Show ready status for connected node`,
+	`.restore <file>                              Restore the database from a SQLite database file or dump file`,
+	`.nodes                                       Show connection status of all nodes in cluster`,
+	`.schema                                      Show CREATE statements for all tables`,
+	`.status                                      Show status and diagnostic information for connected node`,
+	`.sysdump <file>                              Dump system diagnostics to a file for offline analysis`,
+	`.tables                                      List names of tables`,
+	`.timer on|off                                Turn query timer on or off`,
+	`.remove <raft ID>                            Remove a node from the cluster`,
 }
 
 func main() {
@@ -242,8 +242,8 @@ func toggleTimer(op string, flag *bool) error {
 }
 
 func setConsistency(r string, c *string) error {
-	if r != "strong" && r != "weak" && r != "none" {
-		return fmt.Errorf("invalid consistency '%s'. Use 'none', 'weak', or 'strong'", r)
+	if r != "strong" && r != "linearizable" && r != "weak" && r != "none" {
+		return fmt.Errorf("invalid consistency '%s'. Use 'none', 'weak', 'linearizable', or 'strong'", r)
 	}
 	*c = r
 	return nil
@@ -14,6 +14,10 @@ import (
 	"runtime"
 	"strings"
 	"time"
+
+	httpd "github.com/rqlite/rqlite/http"
+	"github.com/rqlite/rqlite/overload"
+	"github.com/rqlite/rqlite/store"
 )
 
 const (
@@ -34,6 +38,21 @@ const (
 	NodeX509KeyFlag  = "node-key"
 )
 
+// lowMemoryFlags are the values to which flags are defaulted in low-memory
+// mode. Flags set explicitly are not changed.
+var lowMemoryFlags = map[string]string{
+	"max-cursors":             "2",
+	"max-transactions":        "2",
+	"query-mem-budget":        "16777216",
+	"bulk-chunk-size":         "65536",
+	"raft-snap-chunk-size":    "65536",
+	"raft-execute-chunk-size": "1048576",
+	"log-buffer":              "0",
+	"cluster-pool-max-idle":   "2",
+	"write-queue-capacity":    "128",
+	"write-queue-batch-size":  "32",
+}
+
 // Config represents the configuration as set by command-line flags.
 // All variables will be set, unless explicit noted.
 type Config struct {
@@ -47,15 +66,144 @@ type Config struct {
 	// HTTPAdv is the advertised HTTP server network.
 	HTTPAdv string
 
+	// GRPCAddr is the bind network address for the gRPC API. May not be set,
+	// in which case the gRPC API is only served if GRPCMux is set.
+	GRPCAddr string
+
+	// GRPCMux serves the gRPC API through the node-to-node mux, on the Raft
+	// address, using any node-to-node TLS configuration.
+	GRPCMux bool
+
 	// AuthFile is the path to the authentication file. May not be set.
 	AuthFile string `filepath:"true"`
 
+	// PolicyFile is the path to the statement policy file. May not be set.
+	PolicyFile string `filepath:"true"`
+
+	// NonDeterministic is the policy for writes which may apply differently on
+	// each node. One of allow, warn, rewrite or reject.
+	NonDeterministic string
+
+	// RemoveNeedsApproval sets whether node removal, including replacement, must be
+	// approved by a second user.
+	RemoveNeedsApproval bool
+
+	// ApprovalTimeout is the time after which operations awaiting approval are discarded.
+	ApprovalTimeout time.Duration
+
+	// TrashRetention is how long dropped tables are kept in the trash. If zero,
+	// DROP TABLE drops tables immediately.
+	TrashRetention time.Duration
+
+	// OverloadApplyLatency is the smoothed write latency above which the node is
+	// considered overloaded. If zero, write latency is not checked.
+	OverloadApplyLatency time.Duration
+
+	// OverloadQueryLatency is the smoothed read latency above which the node is
+	// considered overloaded. If zero, read latency is not checked.
+	OverloadQueryLatency time.Duration
+
+	// OverloadGoroutines is the number of goroutines above which the node is
+	// considered overloaded. If zero, goroutines are not checked.
+	OverloadGoroutines int
+
+	// OverloadHeap is the heap size, in bytes, above which the node is considered
+	// overloaded. If zero, heap size is not checked.
+	OverloadHeap uint64
+
+	// OverloadQueueTimeout is how long low-priority reads wait for overload to clear
+	// before they are shed.
+	OverloadQueueTimeout time.Duration
+
+	// PoolLow, PoolNormal, and PoolHigh are the number of requests of each priority
+	// which may execute concurrently. If zero, requests of that priority are not limited.
+	PoolLow    int
+	PoolNormal int
+	PoolHigh   int
+
+	// LimitBackup, LimitLoad, and LimitQuery are the number of requests to the backup,
+	// load, and query endpoints which may execute concurrently. If zero, not limited.
+	LimitBackup int
+	LimitLoad   int
+	LimitQuery  int
+
+	// PoolTimeout is how long requests wait for a slot in their concurrency pool.
+	PoolTimeout time.Duration
+
+	// QueryMemoryBudget is the approximate number of bytes the results of all in-flight
+	// queries may use. If zero, not limited.
+	QueryMemoryBudget int64
+
+	// QueueSLOs sets the depths of, and times waited in, the node's queues
+	// above which the node is not ready, as parsed by overload.ParseQueueSLOs.
+	QueueSLOs string
+
+	// BatchWindows sets the windows in which Raft log entries of each
+	// consistency domain are batched, as parsed by store.ParseBatchWindows.
+	BatchWindows string
+
+	// CursorTimeout is the time a query cursor may go unused before it is closed.
+	CursorTimeout time.Duration
+
+	// MaxCursors is the number of query cursors which may be open at once.
+	MaxCursors int
+
+	// TransactionTimeout is the time an interactive transaction may go
+	// unused before it is rolled back.
+	TransactionTimeout time.Duration
+
+	// MaxTransactions is the number of interactive transactions which may
+	// be open at once.
+	MaxTransactions int
+
+	// BulkChunkSize is the target size in bytes of the rows applied by each Raft entry
+	// during a bulk write.
+	BulkChunkSize int
+
+	// StandbyPrimary is the HTTP API URL of the primary cluster, if this node is part of
+	// a warm standby cluster. May include credentials. If not set, not a standby.
+	StandbyPrimary string
+
+	// StandbyPollInterval is the interval between polls of the primary's change feed.
+	StandbyPollInterval time.Duration
+
+	// MirrorURL is the HTTP API URL of a second cluster to which queries are also
+	// sent. May include credentials. If not set, queries are not mirrored.
+	MirrorURL string
+
+	// MirrorCompare sets whether the results of mirrored queries are compared with
+	// those returned by this node, rather than discarded.
+	MirrorCompare bool
+
+	// UserPriorities is a comma-separated list of user=priority pairs, setting
+	// the priority of requests made by each user. May not be set.
+	UserPriorities string
+
 	// AutoBackupFile is the path to the auto-backup file. May not be set.
 	AutoBackupFile string `filepath:"true"`
 
 	// AutoRestoreFile is the path to the auto-restore file. May not be set.
 	AutoRestoreFile string `filepath:"true"`
 
+	// LeaderDNSFile is the path to the leader DNS publishing config file. May not be set.
+	LeaderDNSFile string `filepath:"true"`
+
+	// CDCFile is the path to the change data capture config file. May not be set.
+	CDCFile string `filepath:"true"`
+
+	// K8sLeaderLabel is the label set on this node's Kubernetes Pod to whether
+	// the node is the leader. May not be set.
+	K8sLeaderLabel string
+
+	// DivergenceCheckInterval is the interval between checks, while this node
+	// is the leader, that the databases of other nodes match its own. Zero
+	// disables the checks.
+	DivergenceCheckInterval time.Duration
+
+	// DivergenceAutoResync enables the automatic resync of nodes found to
+	// have diverged from the leader.
+	DivergenceAutoResync bool
+
 	// HTTPx509CACert is the path to the CA certficate file for when this node verifies
 	// other certificates for any HTTP communications. May not be set.
 	HTTPx509CACert string `filepath:"true"`
@@ -116,6 +264,11 @@ type Config struct {
 	// JoinInterval is the time between retrying failed join operations.
 	JoinInterval time.Duration
 
+	// JoinSnapshot, if set, has a brand-new node install the latest snapshot
+	// of the Leader of the cluster it is joining before it joins, so that it
+	// need only catch up on the log entries which follow the snapshot.
+	JoinSnapshot bool
+
 	// BootstrapExpect is the minimum number of nodes required for a bootstrap.
 	BootstrapExpect int
 
@@ -131,12 +284,66 @@ type Config struct {
 	// DiscoConfig sets the path to any discovery configuration file. May not be set.
 	DiscoConfig string
 
-	// OnDiskPath sets the path to the SQLite file. May not be set.
+	// OnDiskPath sets the path to the SQLite file. May not be set. The SQLite
+	// WAL file is always written beside the SQLite file.
 	OnDiskPath string
 
+	// InMemory keeps the SQLite database in memory, in a file in InMemoryDir,
+	// in place of OnDiskPath. Committed writes remain durable through the Raft
+	// log and snapshots.
+	InMemory bool
+
+	// InMemoryDir sets the directory, on a memory-backed filesystem, in which
+	// an in-memory database is kept.
+	InMemoryDir string
+
+	// RaftLogDir sets the directory for the Raft log. May not be set, in which
+	// case the data directory is used.
+	RaftLogDir string
+
+	// RaftSnapDir sets the directory for the snapshot store. May not be set,
+	// in which case a directory within the data directory is used.
+	RaftSnapDir string
+
 	// FKConstraints enables SQLite foreign key constraints.
 	FKConstraints bool
 
+	// DeterministicTime enables the rewriting, by the Leader, of the current
+	// date and time in statements, so that all nodes apply the same values.
+	DeterministicTime bool
+
+	// ArchivePath sets the path to the archive SQLite file, into which rows
+	// may be moved. May not be set, in which case archiving is disabled.
+	ArchivePath string
+
+	// RemotesFile is the path to the file configuring the remote clusters
+	// whose tables may be queried through remote tables. May not be set.
+	RemotesFile string
+
+	// PartitionMaintenanceInterval sets the interval between checks, by the
+	// Leader, for time partitions to create or drop.
+	PartitionMaintenanceInterval time.Duration
+
+	// ClusterEventsCapacity is the number of events held by the replicated
+	// cluster event log. If zero, no events are recorded.
+	ClusterEventsCapacity int
+
+	// IdempotencyKeysCapacity is the number of idempotency keys recorded
+	// before the oldest are forgotten.
+	IdempotencyKeysCapacity int
+
+	// LogBufferLines is the number of recent log lines held in memory, for
+	// inclusion in support bundles. If zero, none are held.
+	LogBufferLines int
+
+	// StatusVersion is the version of the /status schema served by default.
+	StatusVersion int
+
+	// LowMemory enables low-memory mode, for nodes with little memory. The
+	// database connection pools, page caches, and Raft buffers are shrunk,
+	// and the defaults of flags in lowMemoryFlags are changed.
+	LowMemory bool
+
 	// RaftLogLevel sets the minimum logging level for the Raft subsystem.
 	RaftLogLevel string
 
@@ -149,6 +356,31 @@ type Config struct {
 	// RaftSnapInterval sets the threshold check interval.
 	RaftSnapInterval time.Duration
 
+	// RaftSnapCompress enables zstd compression of snapshot data on disk.
+	RaftSnapCompress bool
+
+	// RaftSnapConsolidate is the number of snapshots which may be held before
+	// their WAL files are consolidated into the base SQLite file.
+	RaftSnapConsolidate int
+
+	// RaftSnapMaxSize is the number of bytes the snapshot store may use before
+	// snapshots are consolidated early. If not set, not limited.
+	RaftSnapMaxSize int64
+
+	// RaftSnapChunkSize is the size of the chunks in which snapshot data is
+	// streamed to other nodes. If not set, the default is used. If -1, snapshot
+	// data is streamed unchunked.
+	RaftSnapChunkSize int64
+
+	// RaftExecuteChunkSize is the maximum size of the statements of an execute
+	// request written to a single Raft log entry. Larger requests are split
+	// across multiple entries. If zero, requests are never split.
+	RaftExecuteChunkSize int64
+
+	// RaftSnapUpgradeDryRun, if set, rehearses the upgrade of snapshots
+	// written by earlier versions, reports what would change, and exits.
+	RaftSnapUpgradeDryRun bool
+
 	// RaftLeaderLeaseTimeout sets the leader lease timeout.
 	RaftLeaderLeaseTimeout time.Duration
 
@@ -177,6 +409,9 @@ type Config struct {
 	// a full database re-sync during recovery.
 	RaftNoFreelistSync bool
 
+	// StartupCheck enables a consistency self-check of the node's state on startup.
+	StartupCheck bool
+
 	// RaftReapNodeTimeout sets the duration after which a non-reachable voting node is
 	// reaped i.e. removed from the cluster.
 	RaftReapNodeTimeout time.Duration
@@ -185,10 +420,36 @@ type Config struct {
 	// reaped i.e. removed from the cluster.
 	RaftReapReadOnlyNodeTimeout time.Duration
 
+	// Zone is the zone, such as an availability zone, in which this node runs.
+	Zone string
+
+	// RaftMinVotersPerZone is the minimum number of voters each zone should have. If 0,
+	// not constrained.
+	RaftMinVotersPerZone int
+
+	// RaftMaxVotersPerZone is the maximum number of voters any zone may have. If 0,
+	// not constrained.
+	RaftMaxVotersPerZone int
+
 	// ClusterConnectTimeout sets the timeout when initially connecting to another node in
 	// the cluster, for non-Raft communications.
 	ClusterConnectTimeout time.Duration
 
+	// ClusterPipeline enables pipelining of requests forwarded to other nodes.
+	ClusterPipeline bool
+
+	// ClusterPoolMaxIdle is the maximum number of idle connections kept open
+	// to each other node.
+	ClusterPoolMaxIdle int
+
+	// ClusterPoolIdleTimeout is the time after which idle connections to other
+	// nodes are closed.
+	ClusterPoolIdleTimeout time.Duration
+
+	// ClusterPoolMaxLifetime is the time after which connections to other nodes
+	// are closed, rather than reused.
+	ClusterPoolMaxLifetime time.Duration
+
 	// WriteQueueCap is the default capacity of Execute queues
 	WriteQueueCap int
 
@@ -202,6 +463,10 @@ type Config struct {
 	// WriteQueueTx controls whether writes from the queue are done within a transaction.
 	WriteQueueTx bool
 
+	// WriteQueueDurable controls whether the queue is backed by a log on disk, so
+	// that queued writes survive a restart.
+	WriteQueueDurable bool
+
 	// CPUProfile enables CPU profiling.
 	CPUProfile string
 
@@ -236,6 +501,103 @@ func (c *Config) Validate() error {
 	if c.RaftAddr == c.HTTPAddr {
 		return errors.New("HTTP and Raft addresses must differ")
 	}
+	if c.GRPCAddr != "" {
+		if c.GRPCMux {
+			return errors.New("gRPC bind address cannot be set when gRPC is served through the mux")
+		}
+		if _, _, err := net.SplitHostPort(c.GRPCAddr); err != nil {
+			return errors.New("gRPC bind address not valid")
+		}
+		if c.GRPCAddr == c.HTTPAddr || c.GRPCAddr == c.RaftAddr {
+			return errors.New("gRPC address must differ from HTTP and Raft addresses")
+		}
+	}
+
+	for _, p := range []*string{&c.RaftLogDir, &c.RaftSnapDir} {
+		if *p == "" {
+			continue
+		}
+		abs, err := filepath.Abs(*p)
+		if err != nil {
+			return fmt.Errorf("failed to determine absolute path for %s: %s", *p, err.Error())
+		}
+		*p = abs
+	}
+	if c.RaftSnapDir != "" && (c.RaftSnapDir == c.DataPath || c.RaftSnapDir == c.RaftLogDir) {
+		return errors.New("snapshot directory must differ from data and Raft log directories")
+	}
+	if c.RaftSnapConsolidate < 2 {
+		return errors.New("snapshot consolidation threshold must be at least 2")
+	}
+	if c.RaftSnapMaxSize < 0 {
+		return errors.New("snapshot store maximum size must not be negative")
+	}
+	if c.RaftSnapChunkSize < -1 {
+		return errors.New("snapshot chunk size must be -1 or greater")
+	}
+	if c.RaftExecuteChunkSize < 0 {
+		return errors.New("execute chunk size must not be negative")
+	}
+	if c.ClusterEventsCapacity < 0 {
+		return errors.New("cluster events capacity must not be negative")
+	}
+	if c.IdempotencyKeysCapacity < 0 {
+		return errors.New("idempotency keys capacity must not be negative")
+	}
+	if _, err := overload.ParseQueueSLOs(c.QueueSLOs); err != nil {
+		return err
+	}
+	if _, err := store.ParseBatchWindows(c.BatchWindows); err != nil {
+		return err
+	}
+	if c.CursorTimeout <= 0 {
+		return errors.New("cursor timeout must be positive")
+	}
+	if c.MaxCursors <= 0 {
+		return errors.New("max cursors must be positive")
+	}
+	if c.TransactionTimeout <= 0 {
+		return errors.New("transaction timeout must be positive")
+	}
+	if c.MaxTransactions <= 0 {
+		return errors.New("max transactions must be positive")
+	}
+	if c.LogBufferLines < 0 {
+		return errors.New("log buffer lines must not be negative")
+	}
+	if c.StatusVersion < httpd.StatusVersionLegacy || c.StatusVersion > httpd.StatusVersion {
+		return fmt.Errorf("status version must be between %d and %d", httpd.StatusVersionLegacy, httpd.StatusVersion)
+	}
+	switch c.NonDeterministic {
+	case "allow", "warn", "rewrite", "reject":
+	default:
+		return errors.New("non-deterministic write policy must be one of allow, warn, rewrite, or reject")
+	}
+	if (c.GRPCAddr != "" || c.GRPCMux) &&
+		(c.PolicyFile != "" || c.TrashRetention > 0 || c.NonDeterministic != "allow") {
+		// The gRPC API applies none of these to the statements it is sent.
+		return errors.New("gRPC API cannot be served with a statement policy, the trash, or a non-deterministic write policy other than allow")
+	}
+
+	if c.InMemory {
+		if c.OnDiskPath != "" {
+			return errors.New("on-disk path cannot be set with an in-memory database")
+		}
+		if fi, err := os.Stat(c.InMemoryDir); err != nil || !fi.IsDir() {
+			return fmt.Errorf("in-memory directory %s is not an existing directory", c.InMemoryDir)
+		}
+	}
+
+	if c.ArchivePath != "" {
+		archivePath, err := filepath.Abs(c.ArchivePath)
+		if err != nil {
+			return fmt.Errorf("failed to determine absolute archive path: %s", err.Error())
+		}
+		c.ArchivePath = archivePath
+		if c.OnDiskPath != "" && filepath.Clean(c.OnDiskPath) == archivePath {
+			return errors.New("archive path must differ from on-disk path")
+		}
+	}
 
 	// Enforce policies regarding addresses
 	if c.RaftAdv == "" {
@@ -285,6 +647,18 @@ func (c *Config) Validate() error {
 		return errors.New("advertised HTTP and Raft addresses must differ")
 	}
 
+	// Placement constraints OK?
+	if c.RaftMinVotersPerZone < 0 || c.RaftMaxVotersPerZone < 0 {
+		return errors.New("voters per zone must not be negative")
+	}
+	if c.RaftMaxVotersPerZone > 0 && c.RaftMinVotersPerZone > c.RaftMaxVotersPerZone {
+		return errors.New("minimum voters per zone must not exceed maximum voters per zone")
+	}
+
+	if c.BulkChunkSize <= 0 {
+		return errors.New("bulk chunk size must be greater than zero")
+	}
+
 	// Enforce bootstrapping policies
 	if c.BootstrapExpect > 0 && c.RaftNonVoter {
 		return errors.New("bootstrapping only applicable to voting nodes")
@@ -312,6 +686,10 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("invalid join source IP address: %s", c.JoinSrcIP)
 	}
 
+	if _, err := c.UserPriorityMap(); err != nil {
+		return err
+	}
+
 	// Valid disco mode?
 	switch c.DiscoMode {
 	case "":
@@ -340,6 +718,29 @@ func (c *Config) JoinAddresses() []string {
 	return strings.Split(c.JoinAddr, ",")
 }
 
+// UserPriorityMap returns the priority of requests made by each user, as set
+// at the command line. Returns nil if no user priorities were set.
+func (c *Config) UserPriorityMap() (map[string]string, error) {
+	if c.UserPriorities == "" {
+		return nil, nil
+	}
+	m := make(map[string]string)
+	for _, pair := range strings.Split(c.UserPriorities, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid user priority: %s", pair)
+		}
+		user := parts[0]
+		priority, err := overload.ParsePriority(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid priority %s for user %s, must be one of %s, %s, or %s",
+				parts[1], user, overload.PriorityLow, overload.PriorityNormal, overload.PriorityHigh)
+		}
+		m[user] = priority
+	}
+	return m, nil
+}
+
 // HTTPURL returns the fully-formed, advertised HTTP API address for this config, including
 // protocol, host and port.
 func (c *Config) HTTPURL() string {
@@ -416,6 +817,8 @@ func ParseFlags(name, desc string, build *BuildInfo) (*Config, error) {
 	flag.StringVar(&config.NodeID, "node-id", "", "Unique ID for node. If not set, set to advertised Raft address")
 	flag.StringVar(&config.HTTPAddr, HTTPAddrFlag, "localhost:4001", "HTTP server bind address. To enable HTTPS, set X.509 certificate and key")
 	flag.StringVar(&config.HTTPAdv, HTTPAdvAddrFlag, "", "Advertised HTTP address. If not set, same as HTTP server bind address")
+	flag.StringVar(&config.GRPCAddr, "grpc-addr", "", "gRPC API bind address, served over TLS with the HTTP certificate and key if set. If not set, the gRPC API is not served, unless through the mux")
+	flag.BoolVar(&config.GRPCMux, "grpc-mux", false, "Serve the gRPC API through the node-to-node mux, on the Raft address")
 	flag.StringVar(&config.HTTPx509CACert, "http-ca-cert", "", "Path to X.509 CA certificate for HTTPS")
 	flag.StringVar(&config.HTTPx509Cert, HTTPx509CertFlag, "", "Path to HTTPS X.509 certificate")
 	flag.StringVar(&config.HTTPx509Key, HTTPx509KeyFlag, "", "Path to HTTPS X.509 private key")
@@ -427,8 +830,43 @@ func ParseFlags(name, desc string, build *BuildInfo) (*Config, error) {
 	flag.BoolVar(&config.NoNodeVerify, "node-no-verify", false, "Skip verification of any node-node certificate")
 	flag.BoolVar(&config.NodeVerifyClient, "node-verify-client", false, "Enable mutual TLS for node-to-node communication")
 	flag.StringVar(&config.AuthFile, "auth", "", "Path to authentication and authorization file. If not set, not enabled")
+	flag.StringVar(&config.PolicyFile, "policy", "", "Path to statement policy file. If not set, not enabled")
+	flag.StringVar(&config.NonDeterministic, "nondeterministic", "allow", "Policy for non-deterministic writes: allow, warn, rewrite, or reject")
+	flag.BoolVar(&config.RemoveNeedsApproval, "remove-approval", false, "Require node removal, including replacement, to be approved by a second user. Approvals are held in memory on the Leader")
+	flag.DurationVar(&config.ApprovalTimeout, "approval-timeout", time.Hour, "Time after which operations awaiting approval are discarded")
+	flag.DurationVar(&config.TrashRetention, "trash-retention", 0, "Time dropped tables are kept in the trash. If not set, DROP TABLE drops tables immediately")
+	flag.DurationVar(&config.OverloadApplyLatency, "overload-apply-latency", 0, "Write latency above which low-priority reads are shed. If not set, not checked")
+	flag.DurationVar(&config.OverloadQueryLatency, "overload-query-latency", 0, "Read latency above which low-priority reads are shed. If not set, not checked")
+	flag.IntVar(&config.OverloadGoroutines, "overload-goroutines", 0, "Number of goroutines above which low-priority reads are shed. If not set, not checked")
+	flag.Uint64Var(&config.OverloadHeap, "overload-heap", 0, "Heap size in bytes above which low-priority reads are shed. If not set, not checked")
+	flag.DurationVar(&config.OverloadQueueTimeout, "overload-queue-timeout", time.Second, "Time low-priority reads wait for overload to clear before being shed")
+	flag.IntVar(&config.PoolLow, "pool-low", 0, "Maximum concurrent low-priority requests. If not set, not limited")
+	flag.IntVar(&config.PoolNormal, "pool-normal", 0, "Maximum concurrent normal-priority requests. If not set, not limited")
+	flag.IntVar(&config.PoolHigh, "pool-high", 0, "Maximum concurrent high-priority requests. If not set, not limited")
+	flag.IntVar(&config.LimitBackup, "limit-backup", 0, "Maximum concurrent backup requests. If not set, not limited")
+	flag.IntVar(&config.LimitLoad, "limit-load", 0, "Maximum concurrent load requests. If not set, not limited")
+	flag.IntVar(&config.LimitQuery, "limit-query", 0, "Maximum concurrent query requests. If not set, not limited")
+	flag.DurationVar(&config.PoolTimeout, "pool-timeout", 5*time.Second, "Time requests wait for a free slot in their concurrency pool")
+	flag.Int64Var(&config.QueryMemoryBudget, "query-mem-budget", 0, "Approximate bytes of memory the results of all in-flight queries may use. If not set, not limited")
+	flag.StringVar(&config.QueueSLOs, "queue-slo", "", "Comma-separated queue SLOs of the form name=depth/wait, e.g. raft_apply=1000/500ms. While any is exceeded /readyz reports the node not ready. Queues are raft_apply, http_pool, queued_writes, forward, and cdc")
+	flag.StringVar(&config.BatchWindows, "batch-windows", "", "Comma-separated windows in which Raft log entries are batched, of the form domain=window, e.g. writes=2ms. Domains are writes and strong_reads")
+	flag.DurationVar(&config.CursorTimeout, "cursor-timeout", store.DefaultCursorTimeout, "Time a query cursor may go unused before it is closed")
+	flag.IntVar(&config.MaxCursors, "max-cursors", store.DefaultMaxCursors, "Maximum number of query cursors open at once")
+	flag.DurationVar(&config.TransactionTimeout, "transaction-timeout", store.DefaultTransactionTimeout, "Time an interactive transaction may go unused before it is rolled back")
+	flag.IntVar(&config.MaxTransactions, "max-transactions", store.DefaultMaxTransactions, "Maximum number of interactive transactions open at once")
+	flag.IntVar(&config.BulkChunkSize, "bulk-chunk-size", 512*1024, "Target size in bytes of the rows applied by each Raft entry during a bulk write")
+	flag.StringVar(&config.StandbyPrimary, "standby-primary", "", "HTTP API URL of primary cluster, making this node part of a warm standby cluster. If not set, not a standby")
+	flag.DurationVar(&config.StandbyPollInterval, "standby-poll-interval", time.Second, "Interval between polls of the primary cluster's change feed")
+	flag.StringVar(&config.MirrorURL, "mirror-url", "", "HTTP API URL of a second cluster to which queries are also sent, asynchronously. If not set, not enabled")
+	flag.BoolVar(&config.MirrorCompare, "mirror-compare", false, "Compare the results of mirrored queries with this node's, rather than discarding them")
+	flag.StringVar(&config.UserPriorities, "user-priorities", "", "Comma-delimited list of user=priority pairs, setting the priority (low, normal, or high) of requests made by each user")
 	flag.StringVar(&config.AutoBackupFile, "auto-backup", "", "Path to automatic backup configuration file. If not set, not enabled")
 	flag.StringVar(&config.AutoRestoreFile, "auto-restore", "", "Path to automatic restore configuration file. If not set, not enabled")
+	flag.StringVar(&config.LeaderDNSFile, "leader-dns", "", "Path to configuration file for publishing a DNS record pointing at the leader. If not set, not enabled")
+	flag.StringVar(&config.CDCFile, "cdc", "", "Path to configuration file for publishing changes to rows to Kafka, a webhook, or NATS. If not set, not enabled")
+	flag.StringVar(&config.K8sLeaderLabel, "k8s-leader-label", "", "Kubernetes Pod label to set to whether this node is the leader, e.g. rqlite.io/leader. If not set, not enabled")
+	flag.DurationVar(&config.DivergenceCheckInterval, "divergence-check-interval", 0, "Interval between checks, while leader, that other nodes' databases match this node's. If 0, not enabled")
+	flag.BoolVar(&config.DivergenceAutoResync, "divergence-auto-resync", false, "Resync nodes found to have diverged from the leader with a copy of the leader's database")
 	flag.StringVar(&config.RaftAddr, RaftAddrFlag, "localhost:4002", "Raft communication bind address")
 	flag.StringVar(&config.RaftAdv, RaftAdvAddrFlag, "", "Advertised Raft communication address. If not set, same as Raft bind address")
 	flag.StringVar(&config.JoinSrcIP, "join-source-ip", "", "Set source IP address during HTTP Join request")
@@ -436,13 +874,24 @@ func ParseFlags(name, desc string, build *BuildInfo) (*Config, error) {
 	flag.StringVar(&config.JoinAs, "join-as", "", "Username in authentication file to join as. If not set, joins anonymously")
 	flag.IntVar(&config.JoinAttempts, "join-attempts", 5, "Number of join attempts to make")
 	flag.DurationVar(&config.JoinInterval, "join-interval", 3*time.Second, "Period between join attempts")
+	flag.BoolVar(&config.JoinSnapshot, "join-snapshot", false, "If a new node, install the Leader's latest snapshot before joining")
 	flag.IntVar(&config.BootstrapExpect, "bootstrap-expect", 0, "Minimum number of nodes required for a bootstrap")
 	flag.DurationVar(&config.BootstrapExpectTimeout, "bootstrap-expect-timeout", 120*time.Second, "Maximum time for bootstrap process")
 	flag.StringVar(&config.DiscoMode, "disco-mode", "", "Choose clustering discovery mode. If not set, no node discovery is performed")
 	flag.StringVar(&config.DiscoKey, "disco-key", "rqlite", "Key prefix for cluster discovery service")
 	flag.StringVar(&config.DiscoConfig, "disco-config", "", "Set discovery config, or path to cluster discovery config file")
 	flag.StringVar(&config.OnDiskPath, "on-disk-path", "", "Path for SQLite on-disk database file. If not set, use a file in data directory")
+	flag.BoolVar(&config.InMemory, "in-memory", false, "Keep the SQLite database in memory. Committed writes remain durable through the Raft log and snapshots, and the database is rebuilt from them at startup")
+	flag.StringVar(&config.InMemoryDir, "in-memory-dir", store.DefaultMemoryDir, "Directory on a memory-backed filesystem in which an in-memory SQLite database is kept")
+	flag.StringVar(&config.RaftLogDir, "raft-log-dir", "", "Directory for the Raft log. If not set, use data directory")
+	flag.StringVar(&config.RaftSnapDir, "raft-snap-dir", "", "Directory for the Raft snapshot store. If not set, use a directory in data directory")
 	flag.BoolVar(&config.FKConstraints, "fk", false, "Enable SQLite foreign key constraints")
+	flag.BoolVar(&config.DeterministicTime, "deterministic-time", false, "Replace the current date and time in writes with the Leader's, so all nodes apply the same values")
+	flag.StringVar(&config.ArchivePath, "archive-path", "", "Path for archive SQLite file, attached as schema 'archive', into which rows may be moved. If not set, archiving is disabled")
+	flag.StringVar(&config.RemotesFile, "fdw-remotes", "", "Path to JSON file configuring remote rqlite clusters whose tables may be queried through remote tables")
+	flag.DurationVar(&config.PartitionMaintenanceInterval, "partition-maint-interval", time.Minute, "Interval between checks for time partitions to create or drop")
+	flag.IntVar(&config.ClusterEventsCapacity, "cluster-events", 0, "Number of significant cluster events held by the replicated event log, served at /cluster/events. If not set, no events are recorded")
+	flag.IntVar(&config.IdempotencyKeysCapacity, "idempotency-keys", store.DefaultIdempotencyKeysCapacity, "Number of idempotency keys recorded before the oldest are forgotten. The Leader's setting applies")
 	flag.BoolVar(&showVersion, "version", false, "Show version information and exit")
 	flag.BoolVar(&config.RaftNonVoter, "raft-non-voter", false, "Configure as non-voting node")
 	flag.DurationVar(&config.RaftHeartbeatTimeout, "raft-timeout", time.Second, "Raft heartbeat timeout")
@@ -450,19 +899,37 @@ func ParseFlags(name, desc string, build *BuildInfo) (*Config, error) {
 	flag.DurationVar(&config.RaftApplyTimeout, "raft-apply-timeout", 10*time.Second, "Raft apply timeout")
 	flag.Uint64Var(&config.RaftSnapThreshold, "raft-snap", 8192, "Number of outstanding log entries that trigger snapshot and Raft log compaction")
 	flag.DurationVar(&config.RaftSnapInterval, "raft-snap-int", 30*time.Second, "Snapshot threshold check interval")
+	flag.BoolVar(&config.RaftSnapCompress, "raft-snap-compress", false, "Compress snapshot data on disk with zstd")
+	flag.IntVar(&config.RaftSnapConsolidate, "raft-snap-consolidate", 2, "Number of snapshots held before their WAL files are consolidated")
+	flag.Int64Var(&config.RaftSnapMaxSize, "raft-snap-max-size", 0, "Bytes the snapshot store may use before snapshots are consolidated early. If not set, not limited")
+	flag.Int64Var(&config.RaftSnapChunkSize, "raft-snap-chunk-size", 0, "Size of the checksummed chunks snapshot data is streamed to other nodes in. If not set, 1MB. Use -1 when nodes run earlier versions")
+	flag.Int64Var(&config.RaftExecuteChunkSize, "raft-execute-chunk-size", 16*1024*1024, "Maximum size of the statements of an execute request written to a single Raft log entry. Use 0 when nodes run earlier versions")
+	flag.BoolVar(&config.RaftSnapUpgradeDryRun, "raft-snap-upgrade-dry-run", false, "Rehearse upgrade of snapshots from earlier versions, report what would change, and exit")
 	flag.DurationVar(&config.RaftLeaderLeaseTimeout, "raft-leader-lease-timeout", 0, "Raft leader lease timeout. Use 0s for Raft default")
 	flag.BoolVar(&config.RaftStepdownOnShutdown, "raft-shutdown-stepdown", true, "If leader, stepdown before shutting down. Enabled by default")
 	flag.BoolVar(&config.RaftShutdownOnRemove, "raft-remove-shutdown", false, "Shutdown Raft if node removed from cluster")
 	flag.BoolVar(&config.RaftClusterRemoveOnShutdown, "raft-cluster-remove-shutdown", false, "Node removes itself from cluster on graceful shutdown")
 	flag.BoolVar(&config.RaftNoFreelistSync, "raft-no-freelist-sync", false, "Do not sync Raft log database freelist to disk")
+	flag.BoolVar(&config.StartupCheck, "startup-check", false, "Check consistency of Raft log and database on startup, refusing to serve on failure")
+	flag.IntVar(&config.LogBufferLines, "log-buffer", 1000, "Number of recent log lines held in memory, for inclusion in support bundles served at /debug/bundle. If 0, none are held")
+	flag.BoolVar(&config.LowMemory, "low-memory", false, "Shrink connection pools, caches, and buffers, and disable optional subsystems, so as to run in little memory. Flags set explicitly are not changed")
+	flag.IntVar(&config.StatusVersion, "status-version", httpd.StatusVersion, "Version of the /status schema served when a request does not specify one. Set to 1 for the format used before versioning")
 	flag.StringVar(&config.RaftLogLevel, "raft-log-level", "INFO", "Minimum log level for Raft module")
 	flag.DurationVar(&config.RaftReapNodeTimeout, "raft-reap-node-timeout", 0*time.Hour, "Time after which a non-reachable voting node will be reaped. If not set, no reaping takes place")
 	flag.DurationVar(&config.RaftReapReadOnlyNodeTimeout, "raft-reap-read-only-node-timeout", 0*time.Hour, "Time after which a non-reachable non-voting node will be reaped. If not set, no reaping takes place")
+	flag.StringVar(&config.Zone, "zone", "", "Zone, such as an availability zone, in which this node runs")
+	flag.IntVar(&config.RaftMinVotersPerZone, "raft-min-voters-per-zone", 0, "Minimum number of voters per zone. While any zone has fewer, voters may only join such zones. If not set, not constrained")
+	flag.IntVar(&config.RaftMaxVotersPerZone, "raft-max-voters-per-zone", 0, "Maximum number of voters per zone. If not set, not constrained")
 	flag.DurationVar(&config.ClusterConnectTimeout, "cluster-connect-timeout", 30*time.Second, "Timeout for initial connection to other nodes")
+	flag.BoolVar(&config.ClusterPipeline, "cluster-pipeline", false, "Pipeline requests forwarded to other nodes on a single connection")
+	flag.IntVar(&config.ClusterPoolMaxIdle, "cluster-pool-max-idle", 64, "Maximum number of idle connections kept open to each other node")
+	flag.DurationVar(&config.ClusterPoolIdleTimeout, "cluster-pool-idle-timeout", 5*time.Minute, "Close connections to other nodes after they have been idle this long, 0 disables")
+	flag.DurationVar(&config.ClusterPoolMaxLifetime, "cluster-pool-max-lifetime", 0, "Close connections to other nodes once they are this old, 0 disables")
 	flag.IntVar(&config.WriteQueueCap, "write-queue-capacity", 1024, "QueuedWrites queue capacity")
 	flag.IntVar(&config.WriteQueueBatchSz, "write-queue-batch-size", 128, "QueuedWrites queue batch size")
 	flag.DurationVar(&config.WriteQueueTimeout, "write-queue-timeout", 50*time.Millisecond, "QueuedWrites queue timeout")
 	flag.BoolVar(&config.WriteQueueTx, "write-queue-tx", false, "Use a transaction when processing a queued write")
+	flag.BoolVar(&config.WriteQueueDurable, "write-queue-durable", false, "Back the write queue with a log on disk, so queued writes survive restarts")
 	flag.StringVar(&config.CPUProfile, "cpu-profile", "", "Path to file for CPU profiling information")
 	flag.StringVar(&config.MemProfile, "mem-profile", "", "Path to file for memory profiling information")
 	flag.Usage = func() {
@@ -492,6 +959,22 @@ func ParseFlags(name, desc string, build *BuildInfo) (*Config, error) {
 		}
 	})
 
+	// In low-memory mode, change the defaults of flags not set explicitly.
+	if config.LowMemory {
+		set := make(map[string]bool)
+		flag.Visit(func(f *flag.Flag) {
+			set[f.Name] = true
+		})
+		for name, value := range lowMemoryFlags {
+			if set[name] {
+				continue
+			}
+			if err := flag.Set(name, value); err != nil {
+				errorExit(1, fmt.Sprintf("failed to set -%s for low-memory mode: %s", name, err.Error()))
+			}
+		}
+	}
+
 	// Ensure the data path is set.
 	if flag.NArg() < 1 {
 		errorExit(1, "no data directory set")
@@ -9,7 +9,9 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -20,14 +22,28 @@ import (
 	etcd "github.com/rqlite/rqlite-disco-clients/etcd"
 	"github.com/rqlite/rqlite/auth"
 	"github.com/rqlite/rqlite/auto/backup"
+	"github.com/rqlite/rqlite/auto/encryption"
 	"github.com/rqlite/rqlite/auto/restore"
-	"github.com/rqlite/rqlite/aws"
+	"github.com/rqlite/rqlite/auto/webhook"
+	"github.com/rqlite/rqlite/cdc"
 	"github.com/rqlite/rqlite/cluster"
 	"github.com/rqlite/rqlite/cmd"
+	"github.com/rqlite/rqlite/command"
 	"github.com/rqlite/rqlite/db"
 	"github.com/rqlite/rqlite/disco"
+	"github.com/rqlite/rqlite/divergence"
+	"github.com/rqlite/rqlite/fdw"
+	"github.com/rqlite/rqlite/grpc"
 	httpd "github.com/rqlite/rqlite/http"
+	"github.com/rqlite/rqlite/k8s"
+	"github.com/rqlite/rqlite/leaderdns"
+	"github.com/rqlite/rqlite/leaderjob"
+	"github.com/rqlite/rqlite/logbuf"
+	"github.com/rqlite/rqlite/mirror"
+	"github.com/rqlite/rqlite/overload"
+	"github.com/rqlite/rqlite/policy"
 	"github.com/rqlite/rqlite/rtls"
+	"github.com/rqlite/rqlite/standby"
 	"github.com/rqlite/rqlite/store"
 	"github.com/rqlite/rqlite/tcp"
 )
@@ -50,6 +66,19 @@ storage engine. It provides an easy-to-use, fault-tolerant store for relational
 
 Visit https://www.rqlite.io to learn more.`
 
+// snapshotOffloadChanLen is the number of snapshot IDs which may be queued for
+// offload, while an earlier snapshot is still being offloaded.
+const snapshotOffloadChanLen = 16
+
+// lowMemoryReadConns and lowMemoryCacheSize are the number of read-only
+// connections to the database, and the page cache size in KiB of each, in
+// low-memory mode. Enough connections remain for the cursors and
+// transactions allowed by lowMemoryFlags, with some to spare for queries.
+const (
+	lowMemoryReadConns = 8
+	lowMemoryCacheSize = 512
+)
+
 func init() {
 	log.SetFlags(log.LstdFlags)
 	log.SetOutput(os.Stderr)
@@ -68,6 +97,17 @@ func main() {
 	}
 	fmt.Print(logo)
 
+	// Hold recent log lines, so they can be included in support bundles.
+	var logBuf *logbuf.Buffer
+	stopLogCapture := func() {}
+	if cfg.LogBufferLines > 0 {
+		logBuf = logbuf.New(cfg.LogBufferLines)
+		stopLogCapture, err = logbuf.CaptureStderr(logBuf)
+		if err != nil {
+			log.Fatalf("failed to capture log output: %s", err.Error())
+		}
+	}
+
 	mainCtx, mainCancel := context.WithCancel(context.Background())
 	defer mainCancel()
 
@@ -93,18 +133,47 @@ func main() {
 	raftTn := mux.Listen(cluster.MuxRaftHeader)
 	log.Printf("Raft TCP mux Listener registered with byte header %d", cluster.MuxRaftHeader)
 
+	// Enable remote tables before the database is opened.
+	if cfg.RemotesFile != "" {
+		if err := enableRemoteTables(cfg.RemotesFile); err != nil {
+			log.Fatalf("failed to enable remote tables: %s", err.Error())
+		}
+	}
+
 	// Create the store.
 	str, err := createStore(cfg, raftTn)
 	if err != nil {
 		log.Fatalf("failed to create store: %s", err.Error())
 	}
+	cdcPublishers, err := createCDCPublishers(cfg, str)
+	if err != nil {
+		log.Fatalf("failed to create CDC publisher: %s", err.Error())
+	}
+	queues := queueMonitor(cfg)
+	str.ApplyQueue = queues.Queue(overload.QueueRaftApply)
+	if cdcPublishers != nil {
+		queues.Register(overload.QueueCDC, cdcPublishers.Lag)
+	}
+	if cfg.RaftSnapUpgradeDryRun {
+		report, err := str.UpgradeSnapshotsDryRun()
+		if err != nil {
+			log.Fatalf("dry-run upgrade of snapshots failed: %s", err.Error())
+		}
+		log.Print(report)
+		return
+	}
 
 	// Install the auto-restore file, if necessary.
 	if cfg.AutoRestoreFile != "" {
 		log.Printf("auto-restore requested, initiating download")
+		notifier, err := autoRestoreNotifier(cfg.AutoRestoreFile, str.ID())
+		if err != nil {
+			log.Fatalf("failed to enable auto-restore webhook: %s", err.Error())
+		}
 		start := time.Now()
 		path, errOK, err := downloadRestoreFile(mainCtx, cfg.AutoRestoreFile)
 		if err != nil {
+			notifyAutoRestore(mainCtx, notifier, webhook.NewEvent(webhook.EventRestoreFailed, time.Since(start), 0, err))
 			var b strings.Builder
 			b.WriteString(fmt.Sprintf("failed to download auto-restore file: %s", err.Error()))
 			if errOK {
@@ -116,8 +185,12 @@ func main() {
 		} else {
 			log.Printf("auto-restore file downloaded in %s", time.Since(start))
 			if err := str.SetRestorePath(path); err != nil {
+				notifyAutoRestore(mainCtx, notifier, webhook.NewEvent(webhook.EventRestoreFailed, time.Since(start), 0, err))
 				log.Fatalf("failed to preload auto-restore data: %s", err.Error())
 			}
+			if notifier != nil {
+				go awaitAutoRestore(mainCtx, str, notifier, path, start)
+			}
 		}
 	}
 
@@ -127,6 +200,12 @@ func main() {
 		log.Fatalf("failed to get credential store: %s", err.Error())
 	}
 
+	// Prepare the cluster-joiner
+	joiner, err := createJoiner(cfg, credStr)
+	if err != nil {
+		log.Fatalf("failed to create cluster joiner: %s", err.Error())
+	}
+
 	// Create cluster service now, so nodes will be able to learn information about each other.
 	clstrServ, err := clusterService(cfg, mux.Listen(cluster.MuxClusterHeader), str, str, credStr)
 	if err != nil {
@@ -143,11 +222,58 @@ func main() {
 	if err != nil {
 		log.Fatalf("failed to create cluster client: %s", err.Error())
 	}
-	httpServ, err := startHTTPService(cfg, str, clstrClient, credStr)
+	clstrClient.SetForwardQueue(queues.Queue(overload.QueueForward))
+	if cfg.ClusterEventsCapacity > 0 {
+		fwd, err := createEventForwarder(cfg, clstrClient, credStr)
+		if err != nil {
+			log.Fatalf("failed to create cluster event forwarder: %s", err.Error())
+		}
+		str.SetEventForwarder(fwd)
+	}
+	stmtPolicy, err := statementPolicy(cfg, str)
+	if err != nil {
+		log.Fatalf("failed to load statement policy: %s", err.Error())
+	}
+	overloadCtrl := overloadController(cfg)
+	standbyConsumer, err := createStandbyConsumer(cfg, str)
+	if err != nil {
+		log.Fatalf("failed to create standby consumer: %s", err.Error())
+	}
+	queryMirror, err := createQueryMirror(cfg)
+	if err != nil {
+		log.Fatalf("failed to create query mirror: %s", err.Error())
+	}
+	resyncer := cluster.NewNodeResyncer(clstrClient, str)
+	clstrServ.SetResyncer(resyncer)
+	leaderJobs := leaderjob.New(str)
+	if cfg.ClusterEventsCapacity > 0 {
+		if err := leaderJobs.Register("cluster-events", leaderjob.JobFunc(str.WriteClusterEvents)); err != nil {
+			log.Fatalf("failed to register cluster event writer: %s", err.Error())
+		}
+	}
+	for _, p := range cdcPublishers {
+		if err := leaderJobs.Register("cdc-"+p.Name(), p); err != nil {
+			log.Fatalf("failed to register CDC publisher: %s", err.Error())
+		}
+	}
+	httpServ, err := startHTTPService(cfg, str, clstrClient, credStr, stmtPolicy, overloadCtrl, standbyConsumer, queryMirror, resyncer, leaderJobs, logBuf, queues)
 	if err != nil {
 		log.Fatalf("failed to start HTTP server: %s", err.Error())
 	}
 	log.Printf("HTTP server started")
+	grpcServ, err := startGRPCService(cfg, mux, str, clstrClient, credStr, httpServ)
+	if err != nil {
+		log.Fatalf("failed to start gRPC service: %s", err.Error())
+	}
+
+	// Install the Leader's latest snapshot, if requested and this is a new node
+	// about to join a cluster, so that it need only catch up on the log entries
+	// which follow the snapshot once it joins.
+	if cfg.JoinSnapshot && cfg.JoinAddresses() != nil && cfg.BootstrapExpect == 0 && store.IsNewNode(str.LogDir()) {
+		if err := installJoinSnapshot(joiner, cfg.JoinAddresses(), str); err != nil {
+			log.Printf("failed to install snapshot before joining, continuing with join anyway: %s", err.Error())
+		}
+	}
 
 	// Now, open store. How long this takes does depend on how much data is being stored by rqlite.
 	if err := str.Open(); err != nil {
@@ -157,11 +283,17 @@ func main() {
 	// Register remaining status providers.
 	httpServ.RegisterStatus("cluster", clstrServ)
 	httpServ.RegisterStatus("network", tcp.NetworkReporter{})
-
-	// Prepare the cluster-joiner
-	joiner, err := createJoiner(cfg, credStr)
-	if err != nil {
-		log.Fatalf("failed to create cluster joiner: %s", err.Error())
+	if stmtPolicy != nil {
+		httpServ.RegisterStatus("policy", stmtPolicy)
+	}
+	if overloadCtrl != nil {
+		httpServ.RegisterStatus("overload", overloadCtrl)
+	}
+	if cdcPublishers != nil {
+		httpServ.RegisterStatus("cdc", cdcPublishers)
+	}
+	if grpcServ != nil {
+		httpServ.RegisterStatus("grpc", grpcServ)
 	}
 
 	// Create the cluster!
@@ -180,25 +312,99 @@ func main() {
 
 	// Start any requested auto-backups
 	backupSrvStx, backupSrvCancel := context.WithCancel(mainCtx)
-	backupSrv, err := startAutoBackups(backupSrvStx, cfg, str)
+	backupSrv, offloadSrv, err := startAutoBackups(backupSrvStx, cfg, str)
 	if err != nil {
 		log.Fatalf("failed to start auto-backups: %s", err.Error())
 	}
 	if backupSrv != nil {
 		httpServ.RegisterStatus("auto_backups", backupSrv)
 	}
+	if offloadSrv != nil {
+		httpServ.RegisterStatus("snapshot_offload", offloadSrv)
+	}
+
+	// Start consuming changes from the primary, if this is a standby.
+	standbyCtx, standbyCancel := context.WithCancel(mainCtx)
+	if standbyConsumer != nil {
+		go standbyConsumer.Start(standbyCtx)
+		httpServ.RegisterStatus("standby", standbyConsumer)
+	}
+
+	// Mirror queries to a second cluster, if requested.
+	mirrorCtx, mirrorCancel := context.WithCancel(mainCtx)
+	if queryMirror != nil {
+		go queryMirror.Start(mirrorCtx)
+		httpServ.RegisterStatus("mirror", queryMirror)
+	}
+
+	// Run background jobs which must only run on the leader.
+	leaderJobsCtx, leaderJobsCancel := context.WithCancel(mainCtx)
+	leaderJobsDone := make(chan struct{})
+	go func() {
+		leaderJobs.Start(leaderJobsCtx)
+		close(leaderJobsDone)
+	}()
+
+	// Publish a DNS record pointing at the leader, if requested.
+	leaderDNSCtx, leaderDNSCancel := context.WithCancel(mainCtx)
+	leaderDNS, err := createLeaderDNSPublisher(cfg, str)
+	if err != nil {
+		log.Fatalf("failed to create leader DNS publisher: %s", err.Error())
+	}
+	if leaderDNS != nil {
+		go leaderDNS.Start(leaderDNSCtx)
+		httpServ.RegisterStatus("leader_dns", leaderDNS)
+	}
+
+	// Label this node's Kubernetes Pod with whether it is the leader, if requested.
+	k8sLabelerCtx, k8sLabelerCancel := context.WithCancel(mainCtx)
+	if cfg.K8sLeaderLabel != "" {
+		labeler, err := k8s.NewInClusterLabeler(str, cfg.K8sLeaderLabel)
+		if err != nil {
+			log.Fatalf("failed to create Kubernetes leader labeler: %s", err.Error())
+		}
+		go labeler.Start(k8sLabelerCtx)
+		httpServ.RegisterStatus("k8s", labeler)
+	}
+
+	// Check that the databases of other nodes have not diverged, if requested.
+	clstrServ.SetChecksummer(str)
+	divergenceCtx, divergenceCancel := context.WithCancel(mainCtx)
+	if cfg.DivergenceCheckInterval > 0 {
+		checker := divergence.New(str, clstrClient, cfg.DivergenceCheckInterval)
+		checker.AutoResync = cfg.DivergenceAutoResync
+		go checker.Start(divergenceCtx)
+		httpServ.RegisterStatus("divergence", checker)
+	}
+
+	// Allow this node to be restarted as part of a rolling restart.
+	restartCh := make(chan struct{}, 1)
+	clstrServ.SetRestarter(nodeRestarter(restartCh))
 
-	// Block until signalled.
+	// Block until signalled, or asked to restart.
 	terminate := make(chan os.Signal, 1)
 	signal.Notify(terminate, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
-	sig := <-terminate
-	log.Printf(`received signal "%s", shutting down`, sig.String())
+	restart := false
+	select {
+	case sig := <-terminate:
+		log.Printf(`received signal "%s", shutting down`, sig.String())
+	case <-restartCh:
+		log.Printf("restart requested, shutting down")
+		restart = true
+	}
 
 	// Stop the HTTP server first, so clients get notification as soon as
 	// possible that the node is going away.
 	httpServ.Close()
+	if grpcServ != nil {
+		grpcServ.Close()
+	}
+	if overloadCtrl != nil {
+		overloadCtrl.Close()
+	}
+	queues.Close()
 
-	if cfg.RaftClusterRemoveOnShutdown {
+	if cfg.RaftClusterRemoveOnShutdown && !restart {
 		remover := cluster.NewRemover(clstrClient, 5*time.Second, str)
 		log.Printf("initiating removal of this node from cluster before shutdown")
 		if err := remover.Do(cfg.NodeID, true); err != nil {
@@ -208,7 +414,7 @@ func main() {
 		}
 	}
 
-	if cfg.RaftStepdownOnShutdown {
+	if cfg.RaftStepdownOnShutdown || restart {
 		if str.IsLeader() {
 			// Don't log a confusing message if not (probably) Leader
 			log.Printf("stepping down as Leader before shutdown")
@@ -218,34 +424,164 @@ func main() {
 	}
 
 	backupSrvCancel()
+	standbyCancel()
+	mirrorCancel()
+	leaderDNSCancel()
+	leaderJobsCancel()
+	<-leaderJobsDone // Jobs may write to the store, so must stop before it closes.
+	k8sLabelerCancel()
+	divergenceCancel()
 	if err := str.Close(true); err != nil {
 		log.Printf("failed to close store: %s", err.Error())
 	}
 	clstrServ.Close()
 	muxLn.Close()
 	stopProfile()
+	stopLogCapture()
 	log.Println("rqlite server stopped")
+
+	if restart {
+		log.Println("restarting rqlite server")
+		if err := reexec(); err != nil {
+			log.Fatalf("failed to restart: %s", err.Error())
+		}
+	}
 }
 
-func startAutoBackups(ctx context.Context, cfg *Config, str *store.Store) (*backup.Uploader, error) {
+// nodeRestarter signals that this node should restart, by shutting down and
+// starting again with the same configuration.
+type nodeRestarter chan struct{}
+
+// Restart signals the restart of this node. It returns immediately.
+func (n nodeRestarter) Restart() error {
+	select {
+	case n <- struct{}{}:
+	default:
+		// Restart already requested.
+	}
+	return nil
+}
+
+// eventForwarder forwards the cluster events recorded by this node, while it
+// is not the Leader, to the Leader.
+type eventForwarder struct {
+	client  *cluster.Client
+	creds   *cluster.Credentials
+	timeout time.Duration
+}
+
+// Execute executes er on the node at nodeAddr.
+func (f *eventForwarder) Execute(er *command.ExecuteRequest, nodeAddr string) ([]*command.ExecuteResult, error) {
+	return f.client.Execute(er, nodeAddr, f.creds, f.timeout)
+}
+
+// createEventForwarder returns a forwarder of cluster events to the Leader,
+// which authenticates as the join user, if one is set.
+func createEventForwarder(cfg *Config, cltr *cluster.Client, credStr *auth.CredentialsStore) (*eventForwarder, error) {
+	f := &eventForwarder{
+		client:  cltr,
+		timeout: cfg.RaftApplyTimeout,
+	}
+	if cfg.JoinAs != "" {
+		pw, ok := credStr.Password(cfg.JoinAs)
+		if !ok {
+			return nil, fmt.Errorf("user %s does not exist in credential store", cfg.JoinAs)
+		}
+		f.creds = &cluster.Credentials{
+			Username: cfg.JoinAs,
+			Password: pw,
+		}
+	}
+	return f, nil
+}
+
+// startAutoBackups starts uploading backups, and offloading snapshots if that
+// is also configured, as set by the auto-backup file, if there is one.
+func startAutoBackups(ctx context.Context, cfg *Config, str *store.Store) (*backup.Uploader, *backup.Offloader, error) {
 	if cfg.AutoBackupFile == "" {
-		return nil, nil
+		return nil, nil, nil
 	}
 
 	b, err := backup.ReadConfigFile(cfg.AutoBackupFile)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read auto-backup file: %s", err.Error())
+		return nil, nil, fmt.Errorf("failed to read auto-backup file: %s", err.Error())
 	}
 
-	uCfg, s3cfg, err := backup.Unmarshal(b)
+	uCfg, _, err := backup.Unmarshal(b)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse auto-backup file: %s", err.Error())
+		return nil, nil, fmt.Errorf("failed to parse auto-backup file: %s", err.Error())
+	}
+	sc, err := backup.NewStorageClient(uCfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create auto-backup storage client: %s", err.Error())
 	}
-	sc := aws.NewS3Client(s3cfg.Endpoint, s3cfg.Region, s3cfg.AccessKeyID, s3cfg.SecretAccessKey,
-		s3cfg.Bucket, s3cfg.Path)
 	u := backup.NewUploader(sc, str, time.Duration(uCfg.Interval), !uCfg.NoCompress)
+	if uCfg.Compression != "" || uCfg.CompressionLevel != 0 {
+		codec := uCfg.Compression
+		if codec == "" {
+			codec = backup.CompressionGzip
+		}
+		if err := u.SetCompression(codec, uCfg.CompressionLevel); err != nil {
+			return nil, nil, fmt.Errorf("failed to set auto-backup compression: %s", err.Error())
+		}
+	}
+	if uCfg.Schedule != "" {
+		s, err := backup.ParseSchedule(uCfg.Schedule)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse auto-backup schedule: %s", err.Error())
+		}
+		u.EnableSchedule(s)
+	}
+	if uCfg.Incremental {
+		fullInterval := time.Duration(uCfg.FullInterval)
+		if fullInterval == 0 {
+			fullInterval = backup.DefaultFullInterval
+		}
+		if err := u.EnableIncremental(fullInterval); err != nil {
+			return nil, nil, fmt.Errorf("failed to enable incremental auto-backups: %s", err.Error())
+		}
+	}
+	if uCfg.Retention != nil {
+		if err := u.EnableRetention(*uCfg.Retention); err != nil {
+			return nil, nil, fmt.Errorf("failed to enable auto-backup retention: %s", err.Error())
+		}
+	}
+	if tc, ok := sc.(backup.TemplatedStorageClient); ok && backup.IsNameTemplate(tc.Name()) {
+		if err := u.EnableNameTemplate(str.ID()); err != nil {
+			return nil, nil, fmt.Errorf("failed to enable auto-backup name template: %s", err.Error())
+		}
+	}
+	if uCfg.Encryption != nil {
+		e, err := encryption.NewEncrypter(uCfg.Encryption)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to enable auto-backup encryption: %s", err.Error())
+		}
+		u.EnableEncryption(e)
+	}
+	if uCfg.Verify {
+		if err := u.EnableVerification(); err != nil {
+			return nil, nil, fmt.Errorf("failed to enable auto-backup verification: %s", err.Error())
+		}
+	}
+	if uCfg.Webhook != nil {
+		n, err := webhook.NewNotifier(uCfg.Webhook, str.ID())
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to enable auto-backup webhook: %s", err.Error())
+		}
+		u.EnableNotifications(n)
+	}
+	var o *backup.Offloader
+	if uCfg.SnapshotOffload != nil {
+		o, err = backup.NewOffloader(sc, str, uCfg.SnapshotOffload, str.ID())
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to enable snapshot offload: %s", err.Error())
+		}
+		ch := make(chan string, snapshotOffloadChanLen)
+		str.RegisterSnapshotPersisted(ch)
+		go o.Start(ctx, ch)
+	}
 	go u.Start(ctx, nil)
-	return u, nil
+	return u, o, nil
 }
 
 // downloadRestoreFile downloads the auto-restore file from the given URL, and returns the path to
@@ -268,13 +604,19 @@ func downloadRestoreFile(ctx context.Context, cfgPath string) (path string, errO
 		return "", false, fmt.Errorf("failed to read auto-restore file: %s", err.Error())
 	}
 
-	dCfg, s3cfg, err := restore.Unmarshal(b)
+	dCfg, _, err := restore.Unmarshal(b)
 	if err != nil {
 		return "", false, fmt.Errorf("failed to parse auto-restore file: %s", err.Error())
 	}
-	sc := aws.NewS3Client(s3cfg.Endpoint, s3cfg.Region, s3cfg.AccessKeyID, s3cfg.SecretAccessKey,
-		s3cfg.Bucket, s3cfg.Path)
-	d := restore.NewDownloader(sc)
+	var sources []*restore.Source
+	for _, sCfg := range dCfg.SourceConfigs() {
+		d, err := newRestoreDownloader(sCfg)
+		if err != nil {
+			return "", false, err
+		}
+		sources = append(sources, &restore.Source{Downloader: d, Timeout: time.Duration(sCfg.Timeout)})
+	}
+	fd := restore.NewFallbackDownloader(sources...)
 
 	// Create a temporary file to download to.
 	f, err = os.CreateTemp("", "rqlite-auto-restore")
@@ -283,30 +625,148 @@ func downloadRestoreFile(ctx context.Context, cfgPath string) (path string, errO
 	}
 	defer f.Close()
 
-	if err := d.Do(ctx, f, time.Duration(dCfg.Timeout)); err != nil {
+	if err := fd.Do(ctx, f); err != nil {
+		// Data streamed before the download failed must not be restored.
+		os.Remove(f.Name())
 		return "", dCfg.ContinueOnFailure, fmt.Errorf("failed to download auto-restore file: %s", err.Error())
 	}
+	log.Printf("auto-restore file downloaded from %s", fd.Source())
 
 	return f.Name(), false, nil
 }
 
+// newRestoreDownloader returns a Downloader for the source of auto-restore
+// data configured by cfg.
+func newRestoreDownloader(cfg *restore.Config) (*restore.Downloader, error) {
+	sc, err := restore.NewStorageClient(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create auto-restore storage client: %s", err.Error())
+	}
+	d := restore.NewDownloader(sc)
+	d.Checksum = cfg.Checksum
+	d.MaxAttempts = cfg.MaxAttempts
+	d.RetryBackoff = time.Duration(cfg.RetryBackoff)
+	if cfg.ChecksumSidecar {
+		d.Sidecar, err = restore.NewSidecarStorageClient(cfg, restore.SidecarSuffix)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create auto-restore checksum storage client: %s", err.Error())
+		}
+	}
+	if cfg.Encryption != nil {
+		d.Decrypter, err = encryption.NewDecrypter(cfg.Encryption)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create auto-restore decrypter: %s", err.Error())
+		}
+	}
+	return d, nil
+}
+
+// autoRestoreNotifier returns a Notifier for the webhook set in the auto-restore
+// config file at cfgPath, or nil if no webhook is set.
+func autoRestoreNotifier(cfgPath, nodeID string) (*webhook.Notifier, error) {
+	b, err := restore.ReadConfigFile(cfgPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read auto-restore file: %s", err.Error())
+	}
+	dCfg, _, err := restore.Unmarshal(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse auto-restore file: %s", err.Error())
+	}
+	if dCfg.Webhook == nil {
+		return nil, nil
+	}
+	return webhook.NewNotifier(dCfg.Webhook, nodeID)
+}
+
+// awaitAutoRestore waits for the Store to attempt the auto-restore from the
+// file at path, and notifies n of the outcome. Nothing is notified if the
+// restore was skipped because another node became leader.
+func awaitAutoRestore(ctx context.Context, str *store.Store, n *webhook.Notifier, path string, start time.Time) {
+	var size int64
+	if fi, err := os.Stat(path); err == nil {
+		size = fi.Size()
+	}
+	select {
+	case <-ctx.Done():
+		return
+	case <-str.AutoRestoreDone():
+	}
+	err := str.AutoRestoreErr()
+	if err == store.ErrAutoRestoreSkipped {
+		return
+	}
+	typ := webhook.EventRestoreSucceeded
+	if err != nil {
+		typ = webhook.EventRestoreFailed
+	}
+	notifyAutoRestore(ctx, n, webhook.NewEvent(typ, time.Since(start), size, err))
+}
+
+// notifyAutoRestore notifies n, if not nil, of ev.
+func notifyAutoRestore(ctx context.Context, n *webhook.Notifier, ev *webhook.Event) {
+	if n == nil {
+		return
+	}
+	if err := n.Notify(ctx, ev); err != nil {
+		log.Printf("failed to notify %s of %s: %s", n, ev.Type, err.Error())
+	}
+}
+
+// enableRemoteTables allows tables of the remote clusters configured in the
+// file at path to be queried through remote tables.
+func enableRemoteTables(path string) error {
+	if !fdw.Supported {
+		return fdw.ErrNotSupported
+	}
+	remotes, err := fdw.ReadConfigFile(path)
+	if err != nil {
+		return err
+	}
+	c, err := fdw.NewClient(remotes)
+	if err != nil {
+		return err
+	}
+	db.SetConnectHook(c.ConnectHook)
+	log.Printf("remote tables enabled for %d remote clusters", len(remotes))
+	return nil
+}
+
 func createStore(cfg *Config, ln *tcp.Layer) (*store.Store, error) {
+	if cfg.LowMemory {
+		db.SetPoolLimits(db.PoolLimits{
+			MaxReadConns: lowMemoryReadConns,
+			CacheSize:    lowMemoryCacheSize,
+		})
+	}
+
 	dbConf := store.NewDBConfig()
 	dbConf.OnDiskPath = cfg.OnDiskPath
+	dbConf.InMemory = cfg.InMemory
+	dbConf.MemoryDir = cfg.InMemoryDir
 	dbConf.FKConstraints = cfg.FKConstraints
+	dbConf.ArchivePath = cfg.ArchivePath
 
 	str := store.New(ln, &store.Config{
-		DBConf: dbConf,
-		Dir:    cfg.DataPath,
-		ID:     cfg.NodeID,
+		DBConf:      dbConf,
+		Dir:         cfg.DataPath,
+		LogDir:      cfg.RaftLogDir,
+		SnapshotDir: cfg.RaftSnapDir,
+		ID:          cfg.NodeID,
 	})
 
 	// Set optional parameters on store.
 	str.RaftLogLevel = cfg.RaftLogLevel
 	str.NoFreeListSync = cfg.RaftNoFreelistSync
+	str.StartupCheck = cfg.StartupCheck
 	str.ShutdownOnRemove = cfg.RaftShutdownOnRemove
 	str.SnapshotThreshold = cfg.RaftSnapThreshold
 	str.SnapshotInterval = cfg.RaftSnapInterval
+	str.SnapshotCompression = cfg.RaftSnapCompress
+	str.DeterministicTime = cfg.DeterministicTime
+	str.SnapshotConsolidateThreshold = cfg.RaftSnapConsolidate
+	str.SnapshotMaxSize = cfg.RaftSnapMaxSize
+	str.SnapshotChunkSize = cfg.RaftSnapChunkSize
+	str.ExecuteChunkSize = cfg.RaftExecuteChunkSize
 	str.LeaderLeaseTimeout = cfg.RaftLeaderLeaseTimeout
 	str.HeartbeatTimeout = cfg.RaftHeartbeatTimeout
 	str.ElectionTimeout = cfg.RaftElectionTimeout
@@ -314,11 +774,26 @@ func createStore(cfg *Config, ln *tcp.Layer) (*store.Store, error) {
 	str.BootstrapExpect = cfg.BootstrapExpect
 	str.ReapTimeout = cfg.RaftReapNodeTimeout
 	str.ReapReadOnlyTimeout = cfg.RaftReapReadOnlyNodeTimeout
-
-	if store.IsNewNode(cfg.DataPath) {
-		log.Printf("no preexisting node state detected in %s, node may be bootstrapping", cfg.DataPath)
+	str.Zone = cfg.Zone
+	str.Placement = store.PlacementConstraints{
+		MinVotersPerZone: cfg.RaftMinVotersPerZone,
+		MaxVotersPerZone: cfg.RaftMaxVotersPerZone,
+	}
+	str.QueryMemoryBudget = cfg.QueryMemoryBudget
+	str.CursorTimeout = cfg.CursorTimeout
+	str.MaxCursors = cfg.MaxCursors
+	str.TransactionTimeout = cfg.TransactionTimeout
+	str.BatchWindows, _ = store.ParseBatchWindows(cfg.BatchWindows)
+	str.MaxTransactions = cfg.MaxTransactions
+	str.PartitionMaintenanceInterval = cfg.PartitionMaintenanceInterval
+	str.ClusterEventsCapacity = cfg.ClusterEventsCapacity
+	str.IdempotencyKeysCapacity = cfg.IdempotencyKeysCapacity
+	str.LowMemory = cfg.LowMemory
+
+	if store.IsNewNode(str.LogDir()) {
+		log.Printf("no preexisting node state detected in %s, node may be bootstrapping", str.LogDir())
 	} else {
-		log.Printf("preexisting node state detected in %s", cfg.DataPath)
+		log.Printf("preexisting node state detected in %s", str.LogDir())
 	}
 
 	return str, nil
@@ -363,9 +838,171 @@ func createDiscoService(cfg *Config, str *store.Store) (*disco.Service, error) {
 	return disco.NewService(c, str), nil
 }
 
-func startHTTPService(cfg *Config, str *store.Store, cltr *cluster.Client, credStr *auth.CredentialsStore) (*httpd.Service, error) {
+// createLeaderDNSPublisher returns a publisher of a DNS record pointing at the
+// leader, if leader DNS publishing is enabled, otherwise nil.
+func createLeaderDNSPublisher(cfg *Config, str *store.Store) (*leaderdns.Publisher, error) {
+	if cfg.LeaderDNSFile == "" {
+		return nil, nil
+	}
+	f, err := os.Open(cfg.LeaderDNSFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open leader DNS file: %s", err.Error())
+	}
+	defer f.Close()
+	dCfg, err := leaderdns.ParseConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse leader DNS file: %s", err.Error())
+	}
+	p, err := leaderdns.NewProvider(dCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create leader DNS provider: %s", err.Error())
+	}
+	host, portStr, err := net.SplitHostPort(cfg.HTTPAdv)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse HTTP advertised address: %s", err.Error())
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HTTP advertised port: %s", err.Error())
+	}
+	return leaderdns.NewPublisher(p, str, host, port), nil
+}
+
+// createCDCPublishers returns a publisher of changes to rows for each
+// configured sink, if change data capture is enabled, otherwise nil. The
+// Store retains the changes for them on every node, so that any node may
+// publish them once leader.
+func createCDCPublishers(cfg *Config, str *store.Store) (cdc.Publishers, error) {
+	if cfg.CDCFile == "" {
+		return nil, nil
+	}
+	f, err := os.Open(cfg.CDCFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open CDC file: %s", err.Error())
+	}
+	defer f.Close()
+	cCfg, err := cdc.ParseConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CDC file: %s", err.Error())
+	}
+	str.RowChangeLogSize = cCfg.LogSize
+	return cdc.New(cCfg, str)
+}
+
+// createStandbyConsumer returns a consumer of the primary cluster's change feed
+// if this node is part of a warm standby cluster, otherwise nil.
+func createStandbyConsumer(cfg *Config, str *store.Store) (*standby.Consumer, error) {
+	if cfg.StandbyPrimary == "" {
+		return nil, nil
+	}
+	c, err := standby.New(str, cfg.StandbyPrimary)
+	if err != nil {
+		return nil, err
+	}
+	c.PollInterval = cfg.StandbyPollInterval
+	return c, nil
+}
+
+// createQueryMirror returns a mirror of queries to a second cluster, if
+// requested, otherwise nil.
+func createQueryMirror(cfg *Config) (*mirror.Mirror, error) {
+	if cfg.MirrorURL == "" {
+		return nil, nil
+	}
+	return mirror.New(cfg.MirrorURL, cfg.MirrorCompare, 0)
+}
+
+func startHTTPService(cfg *Config, str *store.Store, cltr *cluster.Client, credStr *auth.CredentialsStore,
+	stmtPolicy *policy.Engine, overloadCtrl *overload.Controller, standbyConsumer *standby.Consumer,
+	queryMirror *mirror.Mirror, resyncer *cluster.NodeResyncer, leaderJobs *leaderjob.Runner,
+	logBuf *logbuf.Buffer, queues *overload.Queues) (*httpd.Service, error) {
 	// Create HTTP server and load authentication information.
 	s := httpd.New(cfg.HTTPAddr, str, cltr, credStr)
+	if stmtPolicy != nil {
+		s.Policy = stmtPolicy
+	}
+	s.NonDeterministic = cfg.NonDeterministic
+	if overloadCtrl != nil {
+		s.Overload = overloadCtrl
+	}
+	s.ChangeFeed = str
+	s.Schema = str
+	s.TableStats = str
+	s.Validator = str
+	s.Sandbox = str
+	s.Cursors = str
+	s.Transactions = str
+	s.Subscriber = str
+	s.Batching = str
+	s.Snapshots = str
+	s.Partitions = str
+	if cfg.ClusterEventsCapacity > 0 {
+		s.Events = str
+	}
+	if cfg.ArchivePath != "" {
+		s.Archive = str
+	}
+	if logBuf != nil {
+		s.Logs = logBuf
+	}
+	s.Queues = queues
+	if err := s.RegisterStatus("queues", queues); err != nil {
+		return nil, err
+	}
+	s.StatusVersion = cfg.StatusVersion
+	restarter := cluster.NewRollingRestarter(cltr, str)
+	s.Restarter = restarter
+	if err := s.RegisterStatus("restart", restarter); err != nil {
+		return nil, err
+	}
+	replacer := cluster.NewReplacer(cltr, str)
+	s.Replacer = replacer
+	if err := s.RegisterStatus("replace", replacer); err != nil {
+		return nil, err
+	}
+	s.Resyncer = resyncer
+	if err := s.RegisterStatus("resync", resyncer); err != nil {
+		return nil, err
+	}
+	s.Jobs = leaderJobs
+	if err := s.RegisterStatus("leader_jobs", leaderJobs); err != nil {
+		return nil, err
+	}
+	if standbyConsumer != nil {
+		s.Standby = standbyConsumer
+	}
+	if queryMirror != nil {
+		s.Mirror = queryMirror
+	}
+	if cfg.PoolLow > 0 || cfg.PoolNormal > 0 || cfg.PoolHigh > 0 {
+		pools := overload.NewPools(map[string]int{
+			overload.PriorityLow:    cfg.PoolLow,
+			overload.PriorityNormal: cfg.PoolNormal,
+			overload.PriorityHigh:   cfg.PoolHigh,
+		}, cfg.PoolTimeout)
+		pools.SetQueue(queues.Queue(overload.QueueHTTPPool))
+		s.Pools = pools
+		if err := s.RegisterStatus("pools", pools); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.LimitBackup > 0 || cfg.LimitLoad > 0 || cfg.LimitQuery > 0 {
+		endpointPools := overload.NewPools(map[string]int{
+			httpd.EndpointBackup: cfg.LimitBackup,
+			httpd.EndpointLoad:   cfg.LimitLoad,
+			httpd.EndpointQuery:  cfg.LimitQuery,
+		}, cfg.PoolTimeout)
+		s.EndpointPools = endpointPools
+		if err := s.RegisterStatus("endpoint_pools", endpointPools); err != nil {
+			return nil, err
+		}
+	}
+	userPriorities, err := cfg.UserPriorityMap()
+	if err != nil {
+		return nil, err
+	}
+	s.UserPriorities = userPriorities
+	s.BulkChunkSize = cfg.BulkChunkSize
 
 	s.CACertFile = cfg.HTTPx509CACert
 	s.CertFile = cfg.HTTPx509Cert
@@ -375,6 +1012,12 @@ func startHTTPService(cfg *Config, str *store.Store, cltr *cluster.Client, credS
 	s.DefaultQueueBatchSz = cfg.WriteQueueBatchSz
 	s.DefaultQueueTimeout = cfg.WriteQueueTimeout
 	s.DefaultQueueTx = cfg.WriteQueueTx
+	if cfg.WriteQueueDurable {
+		s.DefaultQueueWAL = filepath.Join(cfg.DataPath, "write-queue.wal")
+	}
+	s.RemoveNeedsApproval = cfg.RemoveNeedsApproval
+	s.ApprovalTimeout = cfg.ApprovalTimeout
+	s.TrashRetention = cfg.TrashRetention
 	s.BuildInfo = map[string]interface{}{
 		"commit":     cmd.Commit,
 		"branch":     cmd.Branch,
@@ -427,12 +1070,57 @@ func credentialStore(cfg *Config) (*auth.CredentialsStore, error) {
 	return auth.NewCredentialsStoreFromFile(cfg.AuthFile)
 }
 
+func statementPolicy(cfg *Config, str *store.Store) (*policy.Engine, error) {
+	if cfg.PolicyFile == "" {
+		return nil, nil
+	}
+	e, err := policy.NewEngineFromFile(cfg.PolicyFile)
+	if err != nil {
+		return nil, err
+	}
+	e.SetAuthorizer(str)
+	return e, nil
+}
+
+// overloadController returns a started overload controller, or nil if no
+// overload thresholds are set.
+func overloadController(cfg *Config) *overload.Controller {
+	oCfg := overload.Config{
+		MaxApplyLatency: cfg.OverloadApplyLatency,
+		MaxQueryLatency: cfg.OverloadQueryLatency,
+		MaxGoroutines:   cfg.OverloadGoroutines,
+		MaxHeap:         cfg.OverloadHeap,
+		QueueTimeout:    cfg.OverloadQueueTimeout,
+	}
+	if !oCfg.Enabled() {
+		return nil
+	}
+	c := overload.New(oCfg)
+	c.Start()
+	log.Printf("overload controller enabled, low-priority reads will be shed under load")
+	return c
+}
+
+// queueMonitor returns a started monitor of the node's queues, checking them
+// against any configured SLOs.
+func queueMonitor(cfg *Config) *overload.Queues {
+	// The SLOs were checked when the configuration was validated.
+	slos, _ := overload.ParseQueueSLOs(cfg.QueueSLOs)
+	q := overload.NewQueues(slos, 0)
+	q.Start()
+	if len(slos) > 0 {
+		log.Printf("queue SLOs enabled, node reported not ready while queues are saturated")
+	}
+	return q
+}
+
 func createJoiner(cfg *Config, credStr *auth.CredentialsStore) (*cluster.Joiner, error) {
 	tlsConfig, err := createHTTPTLSConfig(cfg)
 	if err != nil {
 		return nil, err
 	}
 	joiner := cluster.NewJoiner(cfg.JoinSrcIP, cfg.JoinAttempts, cfg.JoinInterval, tlsConfig)
+	joiner.SetZone(cfg.Zone)
 	if cfg.JoinAs != "" {
 		pw, ok := credStr.Password(cfg.JoinAs)
 		if !ok {
@@ -443,6 +1131,46 @@ func createJoiner(cfg *Config, credStr *auth.CredentialsStore) (*cluster.Joiner,
 	return joiner, nil
 }
 
+// startGRPCService starts the gRPC API, if requested, either on its own
+// address or through the node-to-node mux. Its connections are listed, with
+// those of the HTTP API, by httpServ.
+func startGRPCService(cfg *Config, mux *tcp.Mux, str *store.Store, cltr *cluster.Client, credStr *auth.CredentialsStore, httpServ *httpd.Service) (*grpc.Service, error) {
+	var ln net.Listener
+	switch {
+	case cfg.GRPCMux:
+		ln = mux.Listen(grpc.MuxHeader)
+		log.Printf("gRPC TCP mux Listener registered with byte header %d", grpc.MuxHeader)
+	case cfg.GRPCAddr != "":
+		var err error
+		if ln, err = net.Listen("tcp", cfg.GRPCAddr); err != nil {
+			return nil, fmt.Errorf("failed to listen on %s: %s", cfg.GRPCAddr, err.Error())
+		}
+	default:
+		return nil, nil
+	}
+
+	var creds grpc.CredentialStore
+	if credStr != nil {
+		creds = credStr
+	}
+	s := grpc.New(ln, str, cltr, creds)
+	s.ConnTracker = httpServ
+	if cfg.GRPCAddr != "" && cfg.HTTPx509Cert != "" && cfg.HTTPx509Key != "" {
+		// Served on its own address, the gRPC API is secured as the HTTP API
+		// is. Through the mux it is secured as node-to-node traffic is.
+		tlsConfig, err := rtls.CreateServerConfig(cfg.HTTPx509Cert, cfg.HTTPx509Key, cfg.HTTPx509CACert, !cfg.HTTPVerifyClient)
+		if err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("failed to create gRPC TLS config: %s", err.Error())
+		}
+		s.TLSConfig = tlsConfig
+	}
+	if err := s.Start(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
 func clusterService(cfg *Config, tn cluster.Transport, db cluster.Database, mgr cluster.Manager, credStr *auth.CredentialsStore) (*cluster.Service, error) {
 	c := cluster.New(tn, db, mgr, credStr)
 	c.SetAPIAddr(cfg.HTTPAdv)
@@ -453,6 +1181,22 @@ func clusterService(cfg *Config, tn cluster.Transport, db cluster.Database, mgr
 	return c, nil
 }
 
+// installJoinSnapshot fetches the latest snapshot of the Leader of the cluster
+// reachable through the join addresses, and installs it in the store.
+func installJoinSnapshot(joiner *cluster.Joiner, joins []string, str *store.Store) error {
+	start := time.Now()
+	meta, rc, err := joiner.FetchSnapshot(joins)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if err := str.InstallSnapshot(meta, rc); err != nil {
+		return err
+	}
+	log.Printf("installed snapshot %s of cluster to join in %s", meta.ID, time.Since(start))
+	return nil
+}
+
 func createClusterClient(cfg *Config, clstr *cluster.Service) (*cluster.Client, error) {
 	var dialerTLSConfig *tls.Config
 	var err error
@@ -465,6 +1209,10 @@ func createClusterClient(cfg *Config, clstr *cluster.Service) (*cluster.Client,
 	}
 	clstrDialer := tcp.NewDialer(cluster.MuxClusterHeader, dialerTLSConfig)
 	clstrClient := cluster.NewClient(clstrDialer, cfg.ClusterConnectTimeout)
+	clstrClient.EnablePipelining(cfg.ClusterPipeline)
+	if err := clstrClient.SetPoolConfig(cfg.ClusterPoolMaxIdle, cfg.ClusterPoolIdleTimeout, cfg.ClusterPoolMaxLifetime); err != nil {
+		return nil, fmt.Errorf("failed to set cluster client connection pool config: %s", err.Error())
+	}
 	if err := clstrClient.SetLocal(cfg.RaftAdv, clstr); err != nil {
 		return nil, fmt.Errorf("failed to set cluster client local parameters: %s", err.Error())
 	}
@@ -510,6 +1258,7 @@ func createCluster(cfg *Config, hasPeers bool, joiner *cluster.Joiner, str *stor
 	if joins != nil && cfg.BootstrapExpect > 0 {
 		// Bootstrap with explicit join addresses requests.
 		bs := cluster.NewBootstrapper(cluster.NewAddressProviderString(joins), tlsConfig)
+		bs.SetZone(cfg.Zone)
 		if cfg.JoinAs != "" {
 			pw, ok := credStr.Password(cfg.JoinAs)
 			if !ok {
@@ -559,6 +1308,7 @@ func createCluster(cfg *Config, hasPeers bool, joiner *cluster.Joiner, str *stor
 		}
 
 		bs := cluster.NewBootstrapper(provider, tlsConfig)
+		bs.SetZone(cfg.Zone)
 		if cfg.JoinAs != "" {
 			pw, ok := credStr.Password(cfg.JoinAs)
 			if !ok {
@@ -0,0 +1,9 @@
+package main
+
+import "errors"
+
+// reexec is not supported on Windows. The node exits instead, and must be
+// restarted by its service manager.
+func reexec() error {
+	return errors.New("restarting in place is not supported on Windows")
+}
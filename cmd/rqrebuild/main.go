@@ -0,0 +1,65 @@
+// Command rqrebuild rebuilds an rqlite node from a snapshot store.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/rqlite/rqlite/store"
+)
+
+var nodeID string
+var raftAddr string
+
+const name = `rqrebuild`
+const desc = `rqrebuild creates the data directory of a fresh, single-node rqlite cluster from
+the latest snapshot in a Raft snapshot store, such as one rescued from a dead node.
+The snapshot directory may be the snapshot store itself, or the data directory of
+the dead node, and is not changed. The new data directory must not hold Raft state.
+Start rqlited with the new data directory, node ID and Raft address to bring the
+cluster up. Any writes which followed the snapshot are lost.`
+
+// snapshotsDirName is the name of the snapshot store in a node's data directory.
+const snapshotsDirName = "rsnapshots"
+
+func init() {
+	flag.StringVar(&nodeID, "node-id", "", "ID of the node of the new cluster")
+	flag.StringVar(&raftAddr, "raft-addr", "localhost:4002", "Raft address of the node of the new cluster")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "\n%s\n\n", desc)
+		fmt.Fprintf(os.Stderr, "Usage: %s [arguments] <snapshot directory> <data directory>\n", name)
+		flag.PrintDefaults()
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	snapDir, dataDir := flag.Args()[0], flag.Args()[1]
+	if d := filepath.Join(snapDir, snapshotsDirName); isDir(d) {
+		snapDir = d
+	}
+	if nodeID == "" {
+		nodeID = raftAddr
+	}
+
+	meta, err := store.RebuildNode(snapDir, dataDir, nodeID, raftAddr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to rebuild node: %s\n", err.Error())
+		os.Exit(1)
+	}
+	fmt.Printf("installed snapshot %s (term %d, index %d) in %s\n", meta.ID, meta.Term, meta.Index, dataDir)
+	fmt.Printf("start the node with: rqlited -node-id %s -raft-addr %s %s\n", nodeID, raftAddr, dataDir)
+}
+
+func isDir(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
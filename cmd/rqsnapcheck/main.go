@@ -0,0 +1,59 @@
+// Command rqsnapcheck checks the snapshot store of an rqlite node.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/rqlite/rqlite/snapshot"
+)
+
+var quick bool
+
+const name = `rqsnapcheck`
+const desc = `rqsnapcheck checks the Raft snapshot store of an rqlite node, which must not be running.
+It exits with a non-zero status if any problem is found. The directory may be
+the snapshot store itself, or the data directory of the node.`
+
+// snapshotsDirName is the name of the snapshot store in a node's data directory.
+const snapshotsDirName = "rsnapshots"
+
+func init() {
+	flag.BoolVar(&quick, "quick", false, "Run a quick check of SQLite files, instead of a full integrity check")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "\n%s\n\n", desc)
+		fmt.Fprintf(os.Stderr, "Usage: %s [arguments] <directory>\n", name)
+		flag.PrintDefaults()
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	dir := flag.Args()[0]
+	if d := filepath.Join(dir, snapshotsDirName); isDir(d) {
+		dir = d
+	}
+
+	report, err := snapshot.Inspect(dir, !quick)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to inspect snapshot store: %s\n", err.Error())
+		os.Exit(1)
+	}
+	fmt.Print(report.String())
+	if !report.OK() {
+		os.Exit(1)
+	}
+}
+
+func isDir(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
@@ -0,0 +1,168 @@
+package chunking
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/rqlite/rqlite/command"
+	"google.golang.org/protobuf/proto"
+)
+
+// SplitExecuteRequest splits er into ExecuteChunkRequests, each holding
+// statements with an encoded size of at most maxSize bytes, so that a very
+// large request can be written to the Raft log as multiple entries. A chunk
+// always holds at least one statement, so a single statement larger than
+// maxSize gets a chunk of its own. nil is returned if er need not be split,
+// either because it is no larger than maxSize, or because maxSize is not
+// greater than zero.
+func SplitExecuteRequest(er *command.ExecuteRequest, maxSize int64) []*command.ExecuteChunkRequest {
+	if maxSize <= 0 || int64(proto.Size(er)) <= maxSize {
+		return nil
+	}
+	stmts := er.GetRequest().GetStatements()
+	if len(stmts) < 2 {
+		return nil
+	}
+
+	streamID := generateStreamID()
+	var chunks []*command.ExecuteChunkRequest
+	var sz int64
+	for _, stmt := range stmts {
+		n := int64(proto.Size(stmt))
+		if len(chunks) == 0 || sz+n > maxSize {
+			chunks = append(chunks, &command.ExecuteChunkRequest{
+				StreamId:    streamID,
+				SequenceNum: int64(len(chunks) + 1),
+				Request: &command.Request{
+					Transaction:             er.Request.Transaction,
+					StopOnError:             er.Request.StopOnError,
+					IdempotencyKey:          er.Request.IdempotencyKey,
+					IdempotencyKeysCapacity: er.Request.IdempotencyKeysCapacity,
+				},
+				Timings: er.Timings,
+			})
+			sz = 0
+		}
+		c := chunks[len(chunks)-1]
+		c.Request.Statements = append(c.Request.Statements, stmt)
+		sz += n
+	}
+	if len(chunks) < 2 {
+		return nil
+	}
+	chunks[len(chunks)-1].IsLast = true
+	return chunks
+}
+
+// ExecuteDechunker reassembles an ExecuteRequest from its chunks.
+type ExecuteDechunker struct {
+	streamID string
+	seqNum   int64
+	term     uint64
+	er       *command.ExecuteRequest
+}
+
+// WriteChunk adds the statements of the chunk to the ExecuteRequest being
+// reassembled. If the chunk is the last chunk, the bool return value is true.
+func (d *ExecuteDechunker) WriteChunk(chunk *command.ExecuteChunkRequest) (bool, error) {
+	if d.streamID == "" {
+		d.streamID = chunk.StreamId
+	} else if d.streamID != chunk.StreamId {
+		return false, fmt.Errorf("chunk has unexpected stream ID: expected %s but got %s", d.streamID, chunk.StreamId)
+	}
+
+	if chunk.SequenceNum != d.seqNum+1 {
+		return false, fmt.Errorf("chunks received out of order: expected %d but got %d", d.seqNum+1, chunk.SequenceNum)
+	}
+	d.seqNum = chunk.SequenceNum
+
+	if d.er == nil {
+		d.er = &command.ExecuteRequest{
+			Request: &command.Request{
+				Transaction:             chunk.GetRequest().GetTransaction(),
+				StopOnError:             chunk.GetRequest().GetStopOnError(),
+				IdempotencyKey:          chunk.GetRequest().GetIdempotencyKey(),
+				IdempotencyKeysCapacity: chunk.GetRequest().GetIdempotencyKeysCapacity(),
+			},
+			Timings: chunk.Timings,
+		}
+	}
+	d.er.Request.Statements = append(d.er.Request.Statements, chunk.GetRequest().GetStatements()...)
+	return chunk.IsLast, nil
+}
+
+// Request returns the reassembled ExecuteRequest. It is only complete once
+// the last chunk has been written.
+func (d *ExecuteDechunker) Request() *command.ExecuteRequest {
+	return d.er
+}
+
+// ExecuteDechunkerManager manages ExecuteDechunkers. Since the chunks of an
+// ExecuteRequest are all written to the Raft log by the Leader of a single
+// term, the ExecuteDechunkers of earlier terms can never complete once a log
+// entry of a later term is seen.
+type ExecuteDechunkerManager struct {
+	mu   sync.Mutex
+	term uint64
+	m    map[string]*ExecuteDechunker
+}
+
+// NewExecuteDechunkerManager returns a new ExecuteDechunkerManager.
+func NewExecuteDechunkerManager() *ExecuteDechunkerManager {
+	return &ExecuteDechunkerManager{
+		m: make(map[string]*ExecuteDechunker),
+	}
+}
+
+// Get returns the ExecuteDechunker for the given stream ID. If the
+// ExecuteDechunker does not exist, it is created.
+func (d *ExecuteDechunkerManager) Get(id string) *ExecuteDechunker {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.m[id]; !ok {
+		d.m[id] = &ExecuteDechunker{term: d.term}
+	}
+	return d.m[id]
+}
+
+// Delete deletes the ExecuteDechunker for the given stream ID.
+func (d *ExecuteDechunkerManager) Delete(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.m, id)
+}
+
+// SetTerm sets the term of the log entry about to be applied. Any
+// ExecuteDechunkers created in earlier terms are deleted, and the number
+// deleted is returned.
+func (d *ExecuteDechunkerManager) SetTerm(term uint64) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if term <= d.term {
+		return 0
+	}
+	d.term = term
+	n := 0
+	for id, dec := range d.m {
+		if dec.term < term {
+			delete(d.m, id)
+			n++
+		}
+	}
+	return n
+}
+
+// Reset deletes all ExecuteDechunkers.
+func (d *ExecuteDechunkerManager) Reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.m = make(map[string]*ExecuteDechunker)
+}
+
+// Len returns the number of ExecuteDechunkers, each of which is an
+// ExecuteRequest whose last chunk has not yet been written.
+func (d *ExecuteDechunkerManager) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.m)
+}
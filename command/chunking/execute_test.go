@@ -0,0 +1,123 @@
+package chunking
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/rqlite/rqlite/command"
+)
+
+func Test_SplitExecuteRequest(t *testing.T) {
+	er := &command.ExecuteRequest{
+		Request: &command.Request{
+			Transaction: true,
+		},
+		Timings: true,
+	}
+	for i := 0; i < 100; i++ {
+		er.Request.Statements = append(er.Request.Statements, &command.Statement{
+			Sql: fmt.Sprintf(`INSERT INTO foo(id, name) VALUES(%d, "fiona")`, i),
+		})
+	}
+
+	if chunks := SplitExecuteRequest(er, 0); chunks != nil {
+		t.Fatalf("request split with no maximum size")
+	}
+	if chunks := SplitExecuteRequest(er, 1024*1024); chunks != nil {
+		t.Fatalf("request split though smaller than maximum size")
+	}
+
+	chunks := SplitExecuteRequest(er, 256)
+	if len(chunks) < 2 {
+		t.Fatalf("request not split, got %d chunks", len(chunks))
+	}
+	for i, c := range chunks {
+		if c.StreamId != chunks[0].StreamId {
+			t.Fatalf("chunk %d has wrong stream ID", i)
+		}
+		if exp, got := int64(i+1), c.SequenceNum; exp != got {
+			t.Fatalf("chunk %d has wrong sequence number, exp %d, got %d", i, exp, got)
+		}
+		if c.IsLast != (i == len(chunks)-1) {
+			t.Fatalf("chunk %d has wrong last flag", i)
+		}
+		if !c.Request.Transaction || !c.Timings {
+			t.Fatalf("chunk %d lost request flags", i)
+		}
+		if len(c.Request.Statements) == 0 {
+			t.Fatalf("chunk %d has no statements", i)
+		}
+	}
+
+	// A single statement larger than the maximum size is never split.
+	if chunks := SplitExecuteRequest(er, 1); len(chunks) != 100 {
+		t.Fatalf("expected 100 chunks, got %d", len(chunks))
+	}
+
+	// Reassemble the request.
+	dec := &ExecuteDechunker{}
+	for i, c := range chunks {
+		last, err := dec.WriteChunk(c)
+		if err != nil {
+			t.Fatalf("failed to write chunk %d: %s", i, err)
+		}
+		if last != c.IsLast {
+			t.Fatalf("chunk %d has wrong last flag", i)
+		}
+	}
+	got := dec.Request()
+	if !got.Request.Transaction || !got.Timings {
+		t.Fatalf("reassembled request lost request flags")
+	}
+	if exp, got := len(er.Request.Statements), len(got.Request.Statements); exp != got {
+		t.Fatalf("wrong number of statements reassembled, exp %d, got %d", exp, got)
+	}
+	for i := range er.Request.Statements {
+		if er.Request.Statements[i].Sql != got.Request.Statements[i].Sql {
+			t.Fatalf("statement %d reassembled wrongly", i)
+		}
+	}
+}
+
+func Test_ExecuteDechunker_OutOfOrder(t *testing.T) {
+	dec := &ExecuteDechunker{}
+	if _, err := dec.WriteChunk(&command.ExecuteChunkRequest{StreamId: "1", SequenceNum: 2}); err == nil {
+		t.Fatalf("expected error writing out-of-order chunk")
+	}
+	if _, err := dec.WriteChunk(&command.ExecuteChunkRequest{StreamId: "1", SequenceNum: 1}); err != nil {
+		t.Fatalf("failed to write chunk: %s", err)
+	}
+	if _, err := dec.WriteChunk(&command.ExecuteChunkRequest{StreamId: "2", SequenceNum: 2}); err == nil {
+		t.Fatalf("expected error writing chunk of other stream")
+	}
+}
+
+func Test_ExecuteDechunkerManager(t *testing.T) {
+	m := NewExecuteDechunkerManager()
+	m.SetTerm(1)
+	d1 := m.Get("1")
+	if d1 != m.Get("1") {
+		t.Fatalf("different dechunker returned for same stream")
+	}
+	if exp, got := 0, m.SetTerm(1); exp != got {
+		t.Fatalf("wrong number of dechunkers deleted, exp %d, got %d", exp, got)
+	}
+	m.Get("2")
+	m.Delete("2")
+	if exp, got := 1, m.Len(); exp != got {
+		t.Fatalf("wrong number of dechunkers, exp %d, got %d", exp, got)
+	}
+
+	// Dechunkers of earlier terms are deleted once a later term is seen.
+	if exp, got := 1, m.SetTerm(2); exp != got {
+		t.Fatalf("wrong number of dechunkers deleted, exp %d, got %d", exp, got)
+	}
+	m.Get("3")
+	if exp, got := 1, m.Len(); exp != got {
+		t.Fatalf("wrong number of dechunkers, exp %d, got %d", exp, got)
+	}
+	m.Reset()
+	if exp, got := 0, m.Len(); exp != got {
+		t.Fatalf("wrong number of dechunkers, exp %d, got %d", exp, got)
+	}
+}
@@ -23,21 +23,56 @@ type Result struct {
 	Time         float64 `json:"time,omitempty"`
 }
 
+// ColumnMetadata describes a column of query data. Table is empty if the
+// column is not read directly from a table, in which case nothing more is
+// known about it.
+type ColumnMetadata struct {
+	Table      string `json:"table,omitempty"`
+	Column     string `json:"column,omitempty"`
+	DeclType   string `json:"decl_type,omitempty"`
+	Nullable   *bool  `json:"nullable,omitempty"`
+	PrimaryKey bool   `json:"primary_key,omitempty"`
+}
+
 // Rows represents the outcome of an operation that returns query data.
 type Rows struct {
-	Columns []string        `json:"columns,omitempty"`
-	Types   []string        `json:"types,omitempty"`
-	Values  [][]interface{} `json:"values,omitempty"`
-	Error   string          `json:"error,omitempty"`
-	Time    float64         `json:"time,omitempty"`
+	Columns  []string          `json:"columns,omitempty"`
+	Types    []string          `json:"types,omitempty"`
+	Metadata []*ColumnMetadata `json:"metadata,omitempty"`
+	Values   [][]interface{}   `json:"values,omitempty"`
+	Error    string            `json:"error,omitempty"`
+	Time     float64           `json:"time,omitempty"`
 }
 
 // AssociativeRows represents the outcome of an operation that returns query data.
 type AssociativeRows struct {
-	Types map[string]string        `json:"types,omitempty"`
-	Rows  []map[string]interface{} `json:"rows"`
-	Error string                   `json:"error,omitempty"`
-	Time  float64                  `json:"time,omitempty"`
+	Types    map[string]string          `json:"types,omitempty"`
+	Metadata map[string]*ColumnMetadata `json:"metadata,omitempty"`
+	Rows     []map[string]interface{}   `json:"rows"`
+	Error    string                     `json:"error,omitempty"`
+	Time     float64                    `json:"time,omitempty"`
+}
+
+// NewColumnMetadata returns API ColumnMetadata objects from those of a
+// QueryRows. Returns nil if it has none.
+func NewColumnMetadata(md []*command.ColumnMetadata) []*ColumnMetadata {
+	if len(md) == 0 {
+		return nil
+	}
+	cms := make([]*ColumnMetadata, len(md))
+	for i, m := range md {
+		cm := &ColumnMetadata{}
+		if m.GetTable() != "" {
+			nullable := !m.NotNull
+			cm.Table = m.Table
+			cm.Column = m.Column
+			cm.DeclType = m.DeclType
+			cm.Nullable = &nullable
+			cm.PrimaryKey = m.PrimaryKey
+		}
+		cms[i] = cm
+	}
+	return cms
 }
 
 // ResultWithRows represents the outcome of an operation that changes rows, but also
@@ -103,11 +138,12 @@ func NewRowsFromQueryRows(q *command.QueryRows) (*Rows, error) {
 		return nil, err
 	}
 	return &Rows{
-		Columns: q.Columns,
-		Types:   q.Types,
-		Values:  values,
-		Error:   q.Error,
-		Time:    q.Time,
+		Columns:  q.Columns,
+		Types:    q.Types,
+		Metadata: NewColumnMetadata(q.Metadata),
+		Values:   values,
+		Error:    q.Error,
+		Time:     q.Time,
 	}, nil
 }
 
@@ -136,11 +172,20 @@ func NewAssociativeRowsFromQueryRows(q *command.QueryRows) (*AssociativeRows, er
 		types[q.Columns[i]] = q.Types[i]
 	}
 
+	var metadata map[string]*ColumnMetadata
+	if md := NewColumnMetadata(q.Metadata); len(md) == len(q.Columns) {
+		metadata = make(map[string]*ColumnMetadata, len(md))
+		for i := range md {
+			metadata[q.Columns[i]] = md[i]
+		}
+	}
+
 	return &AssociativeRows{
-		Types: types,
-		Rows:  rows,
-		Error: q.Error,
-		Time:  q.Time,
+		Types:    types,
+		Metadata: metadata,
+		Rows:     rows,
+		Error:    q.Error,
+		Time:     q.Time,
 	}, nil
 }
 
@@ -188,11 +233,13 @@ func NewValuesFromQueryValues(dest [][]interface{}, v []*command.Values) error {
 // and ExecuteQueryRequests.
 type Encoder struct {
 	Associative bool
+	Projection  *Projection // Columns of query results to render. May be nil.
+	Nulls       NullMode
 }
 
 // JSONMarshal implements the marshal interface
 func (e *Encoder) JSONMarshal(i interface{}) ([]byte, error) {
-	return jsonMarshal(i, noEscapeEncode, e.Associative)
+	return jsonMarshal(i, noEscapeEncode, e)
 }
 
 // JSONMarshalIndent implements the marshal indent interface
@@ -206,7 +253,7 @@ func (e *Encoder) JSONMarshalIndent(i interface{}, prefix, indent string) ([]byt
 		json.Indent(&out, b, prefix, indent)
 		return out.Bytes(), nil
 	}
-	return jsonMarshal(i, f, e.Associative)
+	return jsonMarshal(i, f, e)
 }
 
 func noEscapeEncode(i interface{}) ([]byte, error) {
@@ -219,9 +266,67 @@ func noEscapeEncode(i interface{}) ([]byte, error) {
 	return bytes.TrimRight(buf.Bytes(), "\n"), nil
 }
 
+// rows returns an API Rows object from q, with the Encoder's projection and
+// rendering of NULL values applied.
+func (e *Encoder) rows(q *command.QueryRows) (*Rows, error) {
+	r, err := NewRowsFromQueryRows(e.Projection.Apply(q))
+	if err != nil {
+		return nil, err
+	}
+	if e.Nulls == NullsAsEmpty {
+		for _, row := range r.Values {
+			for i := range row {
+				if row[i] == nil {
+					row[i] = ""
+				}
+			}
+		}
+	}
+	return r, nil
+}
+
+// associativeRows returns an associative API object from q, with the
+// Encoder's projection and rendering of NULL values applied.
+func (e *Encoder) associativeRows(q *command.QueryRows) (*AssociativeRows, error) {
+	r, err := NewAssociativeRowsFromQueryRows(e.Projection.Apply(q))
+	if err != nil {
+		return nil, err
+	}
+	if e.Nulls != NullsAsNull {
+		for _, row := range r.Rows {
+			for k, v := range row {
+				if v != nil {
+					continue
+				}
+				if e.Nulls == NullsOmitted {
+					delete(row, k)
+				} else {
+					row[k] = ""
+				}
+			}
+		}
+	}
+	return r, nil
+}
+
+// resultRows returns an API object from v, as the Encoder renders it.
+func (e *Encoder) resultRows(v *command.ExecuteQueryResponse) (interface{}, error) {
+	if qr := v.GetQ(); qr != nil {
+		if e.Associative {
+			return e.associativeRows(qr)
+		}
+		return e.rows(qr)
+	}
+	if e.Associative {
+		return NewAssociativeResultRowsFromExecuteQueryResponse(v)
+	}
+	return NewResultRowsFromExecuteQueryResponse(v)
+}
+
 type marshalFunc func(i interface{}) ([]byte, error)
 
-func jsonMarshal(i interface{}, f marshalFunc, assoc bool) ([]byte, error) {
+func jsonMarshal(i interface{}, f marshalFunc, e *Encoder) ([]byte, error) {
+	assoc := e.Associative
 	switch v := i.(type) {
 	case *command.ExecuteResult:
 		r, err := NewResultFromExecuteResult(v)
@@ -241,20 +346,20 @@ func jsonMarshal(i interface{}, f marshalFunc, assoc bool) ([]byte, error) {
 		return f(results)
 	case *command.QueryRows:
 		if assoc {
-			r, err := NewAssociativeRowsFromQueryRows(v)
+			r, err := e.associativeRows(v)
 			if err != nil {
 				return nil, err
 			}
 			return f(r)
 		} else {
-			r, err := NewRowsFromQueryRows(v)
+			r, err := e.rows(v)
 			if err != nil {
 				return nil, err
 			}
 			return f(r)
 		}
 	case *command.ExecuteQueryResponse:
-		r, err := NewResultRowsFromExecuteQueryResponse(v)
+		r, err := e.resultRows(v)
 		if err != nil {
 			return nil, err
 		}
@@ -265,7 +370,7 @@ func jsonMarshal(i interface{}, f marshalFunc, assoc bool) ([]byte, error) {
 		if assoc {
 			rows := make([]*AssociativeRows, len(v))
 			for j := range v {
-				rows[j], err = NewAssociativeRowsFromQueryRows(v[j])
+				rows[j], err = e.associativeRows(v[j])
 				if err != nil {
 					return nil, err
 				}
@@ -274,7 +379,7 @@ func jsonMarshal(i interface{}, f marshalFunc, assoc bool) ([]byte, error) {
 		} else {
 			rows := make([]*Rows, len(v))
 			for j := range v {
-				rows[j], err = NewRowsFromQueryRows(v[j])
+				rows[j], err = e.rows(v[j])
 				if err != nil {
 					return nil, err
 				}
@@ -282,27 +387,15 @@ func jsonMarshal(i interface{}, f marshalFunc, assoc bool) ([]byte, error) {
 			return f(rows)
 		}
 	case []*command.ExecuteQueryResponse:
-		if assoc {
-			res := make([]interface{}, len(v))
-			for j := range v {
-				r, err := NewAssociativeResultRowsFromExecuteQueryResponse(v[j])
-				if err != nil {
-					return nil, err
-				}
-				res[j] = r
-			}
-			return f(res)
-		} else {
-			res := make([]interface{}, len(v))
-			for j := range v {
-				r, err := NewResultRowsFromExecuteQueryResponse(v[j])
-				if err != nil {
-					return nil, err
-				}
-				res[j] = r
+		res := make([]interface{}, len(v))
+		for j := range v {
+			r, err := e.resultRows(v[j])
+			if err != nil {
+				return nil, err
 			}
-			return f(res)
+			res[j] = r
 		}
+		return f(res)
 	case []*command.Values:
 		values := make([][]interface{}, len(v))
 		if err := NewValuesFromQueryValues(values, v); err != nil {
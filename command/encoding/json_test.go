@@ -192,6 +192,64 @@ func Test_MarshalQueryRows(t *testing.T) {
 	}
 }
 
+func Test_MarshalQueryRowsMetadata(t *testing.T) {
+	r := &command.QueryRows{
+		Columns: []string{"id", "name", "n"},
+		Types:   []string{"integer", "text", "integer"},
+		Metadata: []*command.ColumnMetadata{
+			{Table: "foo", Column: "id", DeclType: "INTEGER", NotNull: true, PrimaryKey: true},
+			{Table: "foo", Column: "name", DeclType: "TEXT"},
+			{},
+		},
+		Values: []*command.Values{
+			{Parameters: []*command.Parameter{
+				{Value: &command.Parameter_I{I: 1}},
+				{Value: &command.Parameter_S{S: "fiona"}},
+				{Value: &command.Parameter_I{I: 2}},
+			}},
+		},
+	}
+
+	enc := Encoder{}
+	b, err := enc.JSONMarshal(r)
+	if err != nil {
+		t.Fatalf("failed to marshal QueryRows: %s", err.Error())
+	}
+	exp := `{"columns":["id","name","n"],"types":["integer","text","integer"],` +
+		`"metadata":[{"table":"foo","column":"id","decl_type":"INTEGER","nullable":false,"primary_key":true},` +
+		`{"table":"foo","column":"name","decl_type":"TEXT","nullable":true},{}],"values":[[1,"fiona",2]]}`
+	if got := string(b); exp != got {
+		t.Fatalf("failed to marshal QueryRows: exp %s, got %s", exp, got)
+	}
+
+	enc = Encoder{Associative: true}
+	b, err = enc.JSONMarshal(r)
+	if err != nil {
+		t.Fatalf("failed to marshal QueryRows: %s", err.Error())
+	}
+	exp = `{"types":{"id":"integer","n":"integer","name":"text"},` +
+		`"metadata":{"id":{"table":"foo","column":"id","decl_type":"INTEGER","nullable":false,"primary_key":true},` +
+		`"n":{},"name":{"table":"foo","column":"name","decl_type":"TEXT","nullable":true}},"rows":[{"id":1,"n":2,"name":"fiona"}]}`
+	if got := string(b); exp != got {
+		t.Fatalf("failed to marshal associative QueryRows: exp %s, got %s", exp, got)
+	}
+
+	// Projected columns keep their metadata.
+	p, err := ParseProjection("name:who")
+	if err != nil {
+		t.Fatalf("failed to parse projection: %s", err.Error())
+	}
+	enc = Encoder{Projection: p}
+	b, err = enc.JSONMarshal(r)
+	if err != nil {
+		t.Fatalf("failed to marshal QueryRows: %s", err.Error())
+	}
+	exp = `{"columns":["who"],"types":["text"],"metadata":[{"table":"foo","column":"name","decl_type":"TEXT","nullable":true}],"values":[["fiona"]]}`
+	if got := string(b); exp != got {
+		t.Fatalf("failed to marshal projected QueryRows: exp %s, got %s", exp, got)
+	}
+}
+
 // Test_MarshalQueryAssociativeRows tests JSON marshaling of a QueryRows
 func Test_MarshalQueryAssociativeRows(t *testing.T) {
 	var b []byte
@@ -0,0 +1,118 @@
+package encoding
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rqlite/rqlite/command"
+)
+
+// NullMode sets how NULL values in query results are rendered.
+type NullMode int
+
+const (
+	// NullsAsNull renders NULL values as JSON null.
+	NullsAsNull NullMode = iota
+
+	// NullsAsEmpty renders NULL values as empty strings.
+	NullsAsEmpty
+
+	// NullsOmitted omits NULL values from associative rows. NULL values are
+	// still rendered as JSON null in arrays of values, since their position
+	// identifies their column.
+	NullsOmitted
+)
+
+// ParseNullMode parses the name of a NullMode, which is one of "null",
+// "empty", or "omit". The empty string is NullsAsNull.
+func ParseNullMode(s string) (NullMode, error) {
+	switch strings.ToLower(s) {
+	case "", "null":
+		return NullsAsNull, nil
+	case "empty":
+		return NullsAsEmpty, nil
+	case "omit":
+		return NullsOmitted, nil
+	default:
+		return NullsAsNull, fmt.Errorf("invalid null mode %q", s)
+	}
+}
+
+// Projection selects, orders and renames the columns of query results.
+type Projection struct {
+	columns []string
+	names   []string
+}
+
+// ParseProjection parses a comma-delimited list of columns, each optionally
+// followed by a colon and the name under which the column is rendered, for
+// example "id,first_name:name".
+func ParseProjection(s string) (*Projection, error) {
+	p := &Projection{}
+	seen := make(map[string]bool)
+	for _, f := range strings.Split(s, ",") {
+		parts := strings.SplitN(f, ":", 2)
+		col := strings.TrimSpace(parts[0])
+		name := col
+		if len(parts) == 2 {
+			name = strings.TrimSpace(parts[1])
+		}
+		if col == "" || name == "" {
+			return nil, fmt.Errorf("invalid column projection %q", s)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate column %q in projection", name)
+		}
+		seen[name] = true
+		p.columns = append(p.columns, col)
+		p.names = append(p.names, name)
+	}
+	return p, nil
+}
+
+// Apply returns rows holding only the columns of q in the Projection, in the
+// order of the Projection, and with the names set by the Projection. Columns
+// of the Projection which q does not have are skipped, so that a Projection
+// can be applied to the results of every statement of a request. q itself is
+// not changed. If p is nil, q is returned.
+func (p *Projection) Apply(q *command.QueryRows) *command.QueryRows {
+	if p == nil || len(q.Columns) != len(q.Types) {
+		return q
+	}
+	idx := make(map[string]int, len(q.Columns))
+	for i := len(q.Columns) - 1; i >= 0; i-- {
+		idx[q.Columns[i]] = i
+	}
+
+	var src []int
+	r := &command.QueryRows{
+		Error: q.Error,
+		Time:  q.Time,
+	}
+	for i, c := range p.columns {
+		j, ok := idx[c]
+		if !ok {
+			continue
+		}
+		src = append(src, j)
+		r.Columns = append(r.Columns, p.names[i])
+		r.Types = append(r.Types, q.Types[j])
+		if len(q.Metadata) == len(q.Columns) {
+			r.Metadata = append(r.Metadata, q.Metadata[j])
+		}
+	}
+	r.Values = make([]*command.Values, len(q.Values))
+	for i, v := range q.Values {
+		params := v.GetParameters()
+		if params == nil {
+			continue
+		}
+		r.Values[i] = &command.Values{Parameters: make([]*command.Parameter, len(src))}
+		for k, j := range src {
+			if j < len(params) {
+				r.Values[i].Parameters[k] = params[j]
+			}
+		}
+	}
+	return r
+}
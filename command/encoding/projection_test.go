@@ -0,0 +1,95 @@
+package encoding
+
+import (
+	"testing"
+
+	"github.com/rqlite/rqlite/command"
+)
+
+func Test_ParseProjection(t *testing.T) {
+	for _, s := range []string{"", "id,", "id:", ":n", "id,name:id"} {
+		if _, err := ParseProjection(s); err == nil {
+			t.Fatalf("expected error parsing projection %q", s)
+		}
+	}
+	p, err := ParseProjection("name:n, id")
+	if err != nil {
+		t.Fatalf("failed to parse projection: %s", err.Error())
+	}
+	if exp, got := 2, len(p.columns); exp != got {
+		t.Fatalf("wrong number of columns, exp %d, got %d", exp, got)
+	}
+	if p.columns[0] != "name" || p.names[0] != "n" || p.columns[1] != "id" || p.names[1] != "id" {
+		t.Fatalf("wrong projection parsed: %v, %v", p.columns, p.names)
+	}
+}
+
+func Test_ParseNullMode(t *testing.T) {
+	for s, exp := range map[string]NullMode{"": NullsAsNull, "null": NullsAsNull, "empty": NullsAsEmpty, "OMIT": NullsOmitted} {
+		got, err := ParseNullMode(s)
+		if err != nil {
+			t.Fatalf("failed to parse null mode %q: %s", s, err.Error())
+		}
+		if exp != got {
+			t.Fatalf("wrong null mode for %q, exp %d, got %d", s, exp, got)
+		}
+	}
+	if _, err := ParseNullMode("zero"); err == nil {
+		t.Fatalf("expected error parsing invalid null mode")
+	}
+}
+
+func Test_MarshalQueryRowsProjection(t *testing.T) {
+	r := &command.QueryRows{
+		Columns: []string{"id", "name", "age"},
+		Types:   []string{"integer", "text", "integer"},
+		Values: []*command.Values{
+			{Parameters: []*command.Parameter{
+				{Value: &command.Parameter_I{I: 1}},
+				{Value: &command.Parameter_S{S: "fiona"}},
+				{Value: &command.Parameter_I{I: 20}},
+			}},
+			{Parameters: []*command.Parameter{
+				{Value: &command.Parameter_I{I: 2}},
+				{Value: nil},
+				{Value: &command.Parameter_I{I: 30}},
+			}},
+		},
+	}
+	p, err := ParseProjection("name:n,id,missing")
+	if err != nil {
+		t.Fatalf("failed to parse projection: %s", err.Error())
+	}
+
+	enc := Encoder{Projection: p}
+	b, err := enc.JSONMarshal(r)
+	if err != nil {
+		t.Fatalf("failed to marshal QueryRows: %s", err.Error())
+	}
+	if exp, got := `{"columns":["n","id"],"types":["text","integer"],"values":[["fiona",1],[null,2]]}`, string(b); exp != got {
+		t.Fatalf("failed to marshal QueryRows: exp %s, got %s", exp, got)
+	}
+
+	enc = Encoder{Projection: p, Nulls: NullsAsEmpty}
+	b, err = enc.JSONMarshal([]*command.QueryRows{r})
+	if err != nil {
+		t.Fatalf("failed to marshal QueryRows: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["n","id"],"types":["text","integer"],"values":[["fiona",1],["",2]]}]`, string(b); exp != got {
+		t.Fatalf("failed to marshal QueryRows: exp %s, got %s", exp, got)
+	}
+
+	enc = Encoder{Associative: true, Projection: p, Nulls: NullsOmitted}
+	b, err = enc.JSONMarshal([]*command.ExecuteQueryResponse{{Result: &command.ExecuteQueryResponse_Q{Q: r}}})
+	if err != nil {
+		t.Fatalf("failed to marshal ExecuteQueryResponse: %s", err.Error())
+	}
+	if exp, got := `[{"types":{"id":"integer","n":"text"},"rows":[{"id":1,"n":"fiona"},{"id":2}]}]`, string(b); exp != got {
+		t.Fatalf("failed to marshal ExecuteQueryResponse: exp %s, got %s", exp, got)
+	}
+
+	// The rows themselves are not changed.
+	if exp, got := 3, len(r.Columns); exp != got {
+		t.Fatalf("projection changed rows, exp %d columns, got %d", exp, got)
+	}
+}
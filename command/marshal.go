@@ -143,6 +143,26 @@ func UnmarshalNoop(b []byte, c *Noop) error {
 	return proto.Unmarshal(b, c)
 }
 
+// MarshalFenceRequest marshals a FenceRequest command
+func MarshalFenceRequest(c *FenceRequest) ([]byte, error) {
+	return proto.Marshal(c)
+}
+
+// UnmarshalFenceRequest unmarshals a FenceRequest command
+func UnmarshalFenceRequest(b []byte, c *FenceRequest) error {
+	return proto.Unmarshal(b, c)
+}
+
+// MarshalZoneRequest marshals a ZoneRequest command
+func MarshalZoneRequest(c *ZoneRequest) ([]byte, error) {
+	return proto.Marshal(c)
+}
+
+// UnmarshalZoneRequest unmarshals a ZoneRequest command
+func UnmarshalZoneRequest(b []byte, c *ZoneRequest) error {
+	return proto.Unmarshal(b, c)
+}
+
 // MarshalLoadRequest marshals a LoadRequest command
 func MarshalLoadRequest(lr *LoadRequest) ([]byte, error) {
 	b, err := proto.Marshal(lr)
@@ -0,0 +1,89 @@
+package command
+
+import (
+	"strings"
+
+	"github.com/rqlite/sql"
+)
+
+// NonDeterministic returns the non-deterministic functions and keywords used
+// by the SQL text in stmt, in the order they appear. Each node applying such
+// a statement may use a different value, so the nodes' databases may differ.
+// The text may contain multiple SQLite statements, separated by semicolons.
+// SELECT statements, and statements which create or alter schema objects,
+// are ignored, since they change no rows when applied.
+//
+// Detection works at the token level, rather than requiring a full parse,
+// since the parser does not support every expression. The current date and
+// time is only detected when given to the date and time functions as 'now',
+// or by giving them no time value, and not when a column default uses it.
+func NonDeterministic(stmt string) []string {
+	var found []string
+	var toks []sql.Token
+	var lits []string
+	sc := sql.NewScanner(strings.NewReader(stmt))
+	for {
+		_, tok, lit := sc.Scan()
+		if tok == sql.EOF || tok == sql.SEMI {
+			found = append(found, nonDeterministicSegment(toks, lits)...)
+			if tok == sql.EOF {
+				return found
+			}
+			toks, lits = nil, nil
+			continue
+		}
+		if tok == sql.ILLEGAL {
+			return found
+		}
+		toks = append(toks, tok)
+		lits = append(lits, lit)
+	}
+}
+
+// nonDeterministicSegment returns the non-deterministic functions and
+// keywords used by the tokens of a single statement.
+func nonDeterministicSegment(toks []sql.Token, lits []string) []string {
+	if len(toks) == 0 {
+		return nil
+	}
+	switch toks[0] {
+	case sql.SELECT, sql.CREATE, sql.ALTER:
+		return nil
+	}
+
+	var found []string
+	for i, tok := range toks {
+		if tok == sql.CURRENT_TIMESTAMP || tok == sql.CURRENT_DATE || tok == sql.CURRENT_TIME {
+			found = append(found, tok.String())
+			continue
+		}
+		if tok != sql.IDENT || i+1 == len(toks) || toks[i+1] != sql.LP {
+			continue
+		}
+		name := strings.ToLower(lits[i])
+		if name == "random" || name == "randomblob" {
+			found = append(found, name+"()")
+			continue
+		}
+		if _, ok := timeFuncArgs[name]; !ok {
+			continue
+		}
+		if i+2 < len(toks) && toks[i+2] == sql.RP && name != "strftime" {
+			found = append(found, name+"()")
+			continue
+		}
+		for j, depth := i+2, 1; j < len(toks) && depth > 0; j++ {
+			switch toks[j] {
+			case sql.LP:
+				depth++
+			case sql.RP:
+				depth--
+			case sql.STRING:
+				if strings.EqualFold(lits[j], "now") {
+					found = append(found, name+"('now')")
+				}
+			}
+		}
+	}
+	return found
+}
@@ -0,0 +1,31 @@
+package command
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_NonDeterministic(t *testing.T) {
+	for i, tt := range []struct {
+		sql string
+		exp string
+	}{
+		{`INSERT INTO foo(id, name) VALUES(1, 'fiona')`, ``},
+		{`INSERT INTO foo(id) VALUES(RANDOM())`, `random()`},
+		{`UPDATE foo SET b = randomblob(16), ts = CURRENT_TIMESTAMP`, `randomblob(), CURRENT_TIMESTAMP`},
+		{`INSERT INTO foo(d, t) VALUES(CURRENT_DATE, CURRENT_TIME)`, `CURRENT_DATE, CURRENT_TIME`},
+		{`UPDATE foo SET ts = datetime('now', '+1 day')`, `datetime('now')`},
+		{`UPDATE foo SET ts = datetime(ts, '+1 day')`, ``},
+		{`INSERT INTO foo(ts) VALUES(unixepoch())`, `unixepoch()`},
+		{`INSERT INTO foo(ts) VALUES(strftime('%s', 'NOW'))`, `strftime('now')`},
+		{`INSERT INTO foo(s) VALUES('random()')`, ``},
+		{`DELETE FROM foo WHERE ts < julianday('now') - 1`, `julianday('now')`},
+		{`SELECT random(), CURRENT_TIMESTAMP`, ``},
+		{`CREATE TABLE foo (id INTEGER, ts DATETIME DEFAULT CURRENT_TIMESTAMP)`, ``},
+		{`SELECT 1; INSERT INTO foo(id) VALUES(random())`, `random()`},
+	} {
+		if got := strings.Join(NonDeterministic(tt.sql), ", "); got != tt.exp {
+			t.Fatalf("test %d: wrong result for %s, exp %q, got %q", i, tt.sql, tt.exp, got)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package command
 
 import (
 	"strings"
+	"time"
 
 	"github.com/rqlite/sql"
 )
@@ -35,3 +36,126 @@ func Rewrite(stmts []*Statement, r bool) error {
 	}
 	return nil
 }
+
+// RewriteTime rewrites the statements such that the current date and time is
+// replaced by now, in UTC. CURRENT_TIMESTAMP, CURRENT_DATE and CURRENT_TIME
+// are rewritten, as are the date and time functions when given 'now' as, or
+// when given no, time value. Statements which create schema objects are not
+// rewritten, since any current date and time in them is that at which the
+// object is later used.
+func RewriteTime(stmts []*Statement, now time.Time) error {
+	now = now.UTC()
+	for i := range stmts {
+		if q, ok := rewriteTimeKeywords(stmts[i].Sql, now); ok {
+			stmts[i].Sql = q
+		}
+		s, err := sql.NewParser(strings.NewReader(stmts[i].Sql)).ParseStatement()
+		if err != nil {
+			continue
+		}
+		rw := &timeRewriter{now: now}
+		if err := sql.Walk(rw, s); err != nil || !rw.rewritten {
+			continue
+		}
+		stmts[i].Sql = s.String()
+	}
+	return nil
+}
+
+// rewriteTimeKeywords replaces CURRENT_TIMESTAMP, CURRENT_DATE and
+// CURRENT_TIME in the SQL text q with now, other than in statements which
+// create or alter schema objects. The parser does not accept them in every
+// expression, so they are replaced in the text. It returns whether any were.
+func rewriteTimeKeywords(q string, now time.Time) (string, bool) {
+	src := []rune(q)
+	var b strings.Builder
+	last, rewritten := 0, false
+	first, skip := true, false
+	sc := sql.NewScanner(strings.NewReader(q))
+	for {
+		pos, tok, _ := sc.Scan()
+		switch tok {
+		case sql.EOF:
+			if !rewritten {
+				return q, false
+			}
+			b.WriteString(string(src[last:]))
+			return b.String(), true
+		case sql.ILLEGAL:
+			return q, false
+		case sql.SEMI:
+			first = true
+			continue
+		}
+		if first {
+			skip = tok == sql.CREATE || tok == sql.ALTER
+			first = false
+		}
+		if skip {
+			continue
+		}
+
+		var v string
+		switch tok {
+		case sql.CURRENT_TIMESTAMP:
+			v = now.Format("2006-01-02 15:04:05")
+		case sql.CURRENT_DATE:
+			v = now.Format("2006-01-02")
+		case sql.CURRENT_TIME:
+			v = now.Format("15:04:05")
+		default:
+			continue
+		}
+		b.WriteString(string(src[last:pos.Offset]))
+		b.WriteString("'" + v + "'")
+		last = pos.Offset + len(tok.String())
+		rewritten = true
+	}
+}
+
+// timeFuncArgs maps the date and time functions to the index of their time
+// value argument.
+var timeFuncArgs = map[string]int{
+	"date":      0,
+	"time":      0,
+	"datetime":  0,
+	"julianday": 0,
+	"unixepoch": 0,
+	"strftime":  1,
+}
+
+// timeRewriter is a sql.Visitor which replaces the current date and time.
+type timeRewriter struct {
+	now       time.Time
+	rewritten bool
+}
+
+// Visit implements sql.Visitor.
+func (rw *timeRewriter) Visit(node sql.Node) (sql.Visitor, error) {
+	switch n := node.(type) {
+	case *sql.CreateTableStatement, *sql.CreateViewStatement, *sql.CreateTriggerStatement,
+		*sql.CreateIndexStatement, *sql.AlterTableStatement:
+		return nil, nil
+	case *sql.Call:
+		idx, ok := timeFuncArgs[strings.ToLower(n.Name.Name)]
+		if !ok {
+			return rw, nil
+		}
+		now := &sql.StringLit{Value: rw.now.Format("2006-01-02 15:04:05.000")}
+		if len(n.Args) == idx && idx == 0 {
+			n.Args = []sql.Expr{now}
+			rw.rewritten = true
+		} else if len(n.Args) > idx {
+			if lit, ok := n.Args[idx].(*sql.StringLit); ok && strings.EqualFold(lit.Value, "now") {
+				n.Args[idx] = now
+				rw.rewritten = true
+			}
+		}
+	}
+	return rw, nil
+}
+
+// VisitEnd implements sql.Visitor.
+func (rw *timeRewriter) VisitEnd(node sql.Node) error {
+	return nil
+}
@@ -3,6 +3,7 @@ package command
 import (
 	"regexp"
 	"testing"
+	"time"
 )
 
 func Test_NoRewrites(t *testing.T) {
@@ -80,3 +81,68 @@ func Test_Rewrites(t *testing.T) {
 		}
 	}
 }
+
+func Test_RewriteTime(t *testing.T) {
+	now := time.Date(2023, 6, 13, 12, 34, 56, 789000000, time.FixedZone("X", 3600))
+	for i, tt := range []struct {
+		sql string
+		exp string
+	}{
+		{
+			sql: `INSERT INTO "names" VALUES (1, 'bob', '123-45-678')`,
+			exp: `INSERT INTO "names" VALUES (1, 'bob', '123-45-678')`,
+		},
+		{
+			sql: `INSERT INTO foo(ts) VALUES(CURRENT_TIMESTAMP)`,
+			exp: `INSERT INTO foo(ts) VALUES('2023-06-13 11:34:56')`,
+		},
+		{
+			sql: `INSERT INTO foo(d, t) VALUES(CURRENT_DATE, CURRENT_TIME); INSERT INTO bar(s) VALUES('CURRENT_TIME')`,
+			exp: `INSERT INTO foo(d, t) VALUES('2023-06-13', '11:34:56'); INSERT INTO bar(s) VALUES('CURRENT_TIME')`,
+		},
+		{
+			sql: `INSERT INTO "名前"(ts, d) VALUES(current_timestamp, date('now'))`,
+			exp: `INSERT INTO "名前" ("ts", "d") VALUES ('2023-06-13 11:34:56', date('2023-06-13 11:34:56.789'))`,
+		},
+		{
+			sql: `UPDATE foo SET ts = datetime('now', '+1 day') WHERE id = 1`,
+			exp: `UPDATE "foo" SET "ts" = datetime('2023-06-13 11:34:56.789', '+1 day') WHERE "id" = 1`,
+		},
+		{
+			sql: `INSERT INTO foo(ts) VALUES(unixepoch())`,
+			exp: `INSERT INTO "foo" ("ts") VALUES (unixepoch('2023-06-13 11:34:56.789'))`,
+		},
+		{
+			sql: `INSERT INTO foo(ts) VALUES(strftime('%s', 'NOW'))`,
+			exp: `INSERT INTO "foo" ("ts") VALUES (strftime('%s', '2023-06-13 11:34:56.789'))`,
+		},
+		{
+			sql: `INSERT INTO foo(ts) VALUES(date('2020-01-01'))`,
+			exp: `INSERT INTO foo(ts) VALUES(date('2020-01-01'))`,
+		},
+		{
+			sql: `CREATE TABLE tbl (col1 TEXT, ts DATETIME DEFAULT CURRENT_TIMESTAMP)`,
+			exp: `CREATE TABLE tbl (col1 TEXT, ts DATETIME DEFAULT CURRENT_TIMESTAMP)`,
+		},
+		{
+			sql: `CREATE TRIGGER t AFTER INSERT ON foo BEGIN UPDATE foo SET ts = datetime('now'); END`,
+			exp: `CREATE TRIGGER t AFTER INSERT ON foo BEGIN UPDATE foo SET ts = datetime('now'); END`,
+		},
+		{
+			sql: `not SQL at all`,
+			exp: `not SQL at all`,
+		},
+	} {
+		stmts := []*Statement{
+			{
+				Sql: tt.sql,
+			},
+		}
+		if err := RewriteTime(stmts, now); err != nil {
+			t.Fatalf("test %d failed to rewrite: %s", i, err)
+		}
+		if stmts[0].Sql != tt.exp {
+			t.Fatalf("test %d failed, %s rewritten as %s, exp %s", i, tt.sql, stmts[0].Sql, tt.exp)
+		}
+	}
+}
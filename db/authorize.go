@@ -0,0 +1,202 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rqlite/go-sqlite3"
+)
+
+// Authorizer actions, named after the SQLite action codes which map to
+// them. See https://www.sqlite.org/c3ref/c_alter_table.html.
+const (
+	AuthAlterTable      = "alter_table"
+	AuthAnalyze         = "analyze"
+	AuthAttach          = "attach"
+	AuthCreateIndex     = "create_index"
+	AuthCreateTable     = "create_table"
+	AuthCreateTempIndex = "create_temp_index"
+	AuthCreateTempTable = "create_temp_table"
+	AuthCreateTempView  = "create_temp_view"
+	AuthCreateTrigger   = "create_trigger"
+	AuthCreateView      = "create_view"
+	AuthCreateVTable    = "create_vtable"
+	AuthDelete          = "delete"
+	AuthDetach          = "detach"
+	AuthDropIndex       = "drop_index"
+	AuthDropTable       = "drop_table"
+	AuthDropTrigger     = "drop_trigger"
+	AuthDropView        = "drop_view"
+	AuthDropVTable      = "drop_vtable"
+	AuthFunction        = "function"
+	AuthInsert          = "insert"
+	AuthPragma          = "pragma"
+	AuthRead            = "read"
+	AuthRecursive       = "recursive"
+	AuthReindex         = "reindex"
+	AuthSavepoint       = "savepoint"
+	AuthSelect          = "select"
+	AuthTransaction     = "transaction"
+	AuthUpdate          = "update"
+)
+
+// InternalTablePrefix is the prefix of the names of the tables rqlite keeps
+// in the database for its own use.
+const InternalTablePrefix = "_rqlite_"
+
+var (
+	// ErrNotAuthorized is returned when an authorizer denies an action needed
+	// by a statement.
+	ErrNotAuthorized = errors.New("not authorized")
+
+	// ErrInternalTable is returned when a statement accesses a table rqlite
+	// keeps for its own use.
+	ErrInternalTable = errors.New("statement accesses a table reserved for rqlite")
+)
+
+// authActions maps SQLite authorizer action codes to action names. Temporary
+// triggers are treated as any other trigger, and temporary drops as any other
+// drop, since the distinction matters only when they are created.
+var authActions = map[int]string{
+	sqlite3.SQLITE_ALTER_TABLE:         AuthAlterTable,
+	sqlite3.SQLITE_ANALYZE:             AuthAnalyze,
+	sqlite3.SQLITE_ATTACH:              AuthAttach,
+	sqlite3.SQLITE_CREATE_INDEX:        AuthCreateIndex,
+	sqlite3.SQLITE_CREATE_TABLE:        AuthCreateTable,
+	sqlite3.SQLITE_CREATE_TEMP_INDEX:   AuthCreateTempIndex,
+	sqlite3.SQLITE_CREATE_TEMP_TABLE:   AuthCreateTempTable,
+	sqlite3.SQLITE_CREATE_TEMP_TRIGGER: AuthCreateTrigger,
+	sqlite3.SQLITE_CREATE_TEMP_VIEW:    AuthCreateTempView,
+	sqlite3.SQLITE_CREATE_TRIGGER:      AuthCreateTrigger,
+	sqlite3.SQLITE_CREATE_VIEW:         AuthCreateView,
+	sqlite3.SQLITE_CREATE_VTABLE:       AuthCreateVTable,
+	sqlite3.SQLITE_DELETE:              AuthDelete,
+	sqlite3.SQLITE_DETACH:              AuthDetach,
+	sqlite3.SQLITE_DROP_INDEX:          AuthDropIndex,
+	sqlite3.SQLITE_DROP_TABLE:          AuthDropTable,
+	sqlite3.SQLITE_DROP_TEMP_INDEX:     AuthDropIndex,
+	sqlite3.SQLITE_DROP_TEMP_TABLE:     AuthDropTable,
+	sqlite3.SQLITE_DROP_TEMP_TRIGGER:   AuthDropTrigger,
+	sqlite3.SQLITE_DROP_TEMP_VIEW:      AuthDropView,
+	sqlite3.SQLITE_DROP_TRIGGER:        AuthDropTrigger,
+	sqlite3.SQLITE_DROP_VIEW:           AuthDropView,
+	sqlite3.SQLITE_DROP_VTABLE:         AuthDropVTable,
+	sqlite3.SQLITE_FUNCTION:            AuthFunction,
+	sqlite3.SQLITE_INSERT:              AuthInsert,
+	sqlite3.SQLITE_PRAGMA:              AuthPragma,
+	sqlite3.SQLITE_READ:                AuthRead,
+	sqliteRecursive:                    AuthRecursive,
+	sqlite3.SQLITE_REINDEX:             AuthReindex,
+	sqlite3.SQLITE_SAVEPOINT:           AuthSavepoint,
+	sqlite3.SQLITE_SELECT:              AuthSelect,
+	sqlite3.SQLITE_TRANSACTION:         AuthTransaction,
+	sqlite3.SQLITE_UPDATE:              AuthUpdate,
+}
+
+// IsAuthAction returns whether s is the name of an authorizer action.
+func IsAuthAction(s string) bool {
+	for _, a := range authActions {
+		if a == s {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthorizeFunc decides whether an action needed by a statement is allowed.
+// name is the object of the action: the table read, written or created, the
+// index, trigger or view created or dropped, the pragma, the function, the
+// file attached, or the schema detached. column is set only for reads and
+// updates, and is the column read or updated.
+type AuthorizeFunc func(action, name, column string) bool
+
+// authorizer returns a SQLite authorizer callback which calls allow for
+// every action it knows, denying any action allow rejects. Actions it
+// does not know are allowed.
+func authorizer(allow AuthorizeFunc) func(int, string, string, string) int {
+	return func(code int, arg1, arg2, arg3 string) int {
+		action, ok := authActions[code]
+		if !ok {
+			return sqlite3.SQLITE_OK
+		}
+		name, column := arg1, ""
+		switch code {
+		case sqlite3.SQLITE_READ, sqlite3.SQLITE_UPDATE:
+			column = arg2
+		case sqlite3.SQLITE_ALTER_TABLE, sqlite3.SQLITE_FUNCTION, sqlite3.SQLITE_SAVEPOINT:
+			name = arg2
+		}
+		if allow(action, name, column) {
+			return sqlite3.SQLITE_OK
+		}
+		return sqlite3.SQLITE_DENY
+	}
+}
+
+// Authorize checks that every action the SQL statement needs is allowed,
+// by compiling it with allow as SQLite's authorizer. The statement is never
+// executed. It returns an error wrapping ErrNotAuthorized if an action is
+// denied, or any other error if the statement cannot be compiled, such as
+// when it refers to a table which does not exist. Only the first statement
+// in the SQL text is checked.
+func (db *DB) Authorize(sql string, allow AuthorizeFunc) error {
+	return db.authorize(sql, authorizer(allow))
+}
+
+// CheckInternal returns ErrInternalTable if the SQL text accesses any table
+// whose name begins with InternalTablePrefix, or creates or drops an index
+// or trigger on one. Since only the first statement of the text can be
+// checked, text which holds more than one statement and mentions the prefix
+// at all is rejected. So is any such ALTER TABLE, since the authorizer is not
+// told the new name of a renamed table.
+func (db *DB) CheckInternal(sql string) error {
+	lower := strings.ToLower(sql)
+	if !strings.Contains(lower, InternalTablePrefix) {
+		return nil
+	}
+	if strings.Contains(strings.TrimRight(sql, "; \t\r\n"), ";") ||
+		strings.HasPrefix(strings.TrimSpace(lower), "alter") {
+		return ErrInternalTable
+	}
+	err := db.authorize(sql, func(code int, arg1, arg2, arg3 string) int {
+		for _, a := range []string{arg1, arg2, arg3} {
+			if strings.HasPrefix(strings.ToLower(a), InternalTablePrefix) {
+				return sqlite3.SQLITE_DENY
+			}
+		}
+		return sqlite3.SQLITE_OK
+	})
+	if errors.Is(err, ErrNotAuthorized) {
+		return ErrInternalTable
+	}
+	// Any other error means the statement cannot be compiled, so it will
+	// fail when executed.
+	return nil
+}
+
+// authorize compiles the first statement of the SQL text with cb as
+// SQLite's authorizer, without executing it.
+func (db *DB) authorize(sql string, cb func(int, string, string, string) int) error {
+	conn, err := db.roDB.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn interface{}) error {
+		c := driverConn.(*sqlite3.SQLiteConn)
+		c.RegisterAuthorizer(cb)
+		defer c.RegisterAuthorizer(nil)
+		stmt, err := c.Prepare(sql)
+		if err != nil {
+			var se sqlite3.Error
+			if errors.As(err, &se) && se.Code == sqlite3.ErrAuth {
+				return fmt.Errorf("%w: %s", ErrNotAuthorized, err.Error())
+			}
+			return err
+		}
+		return stmt.Close()
+	})
+}
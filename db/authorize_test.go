@@ -0,0 +1,118 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func Test_Authorize(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+	mustExecute(db, `CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT, secret TEXT)`)
+
+	denySecret := func(action, name, column string) bool {
+		return !(action == AuthRead && name == "foo" && column == "secret")
+	}
+	for _, stmt := range []string{
+		`SELECT id, name FROM foo`,
+		`INSERT INTO foo(id, name) VALUES(1, 'fiona')`,
+		`UPDATE foo SET name = 'declan' WHERE id = 1`,
+	} {
+		if err := db.Authorize(stmt, denySecret); err != nil {
+			t.Fatalf("statement %q unexpectedly denied: %s", stmt, err)
+		}
+	}
+	for _, stmt := range []string{
+		`SELECT * FROM foo`,
+		`SELECT name FROM foo WHERE secret = 'x'`,
+		`UPDATE foo SET name = secret`,
+	} {
+		if err := db.Authorize(stmt, denySecret); !errors.Is(err, ErrNotAuthorized) {
+			t.Fatalf("statement %q not denied, got %v", stmt, err)
+		}
+	}
+
+	for stmt, exp := range map[string]string{
+		`CREATE TEMP TABLE bar (id INTEGER)`:  AuthCreateTempTable,
+		`ATTACH DATABASE ':memory:' AS other`: AuthAttach,
+		`PRAGMA user_version=5`:               AuthPragma,
+	} {
+		if err := db.Authorize(stmt, denySecret); err != nil {
+			t.Fatalf("statement %q unexpectedly denied: %s", stmt, err)
+		}
+		denyAction := func(action, name, column string) bool {
+			return action != exp
+		}
+		if err := db.Authorize(stmt, denyAction); !errors.Is(err, ErrNotAuthorized) {
+			t.Fatalf("statement %q not denied, got %v", stmt, err)
+		}
+	}
+	denyPragma := func(action, name, column string) bool {
+		return !(action == AuthPragma && name == "user_version")
+	}
+	if err := db.Authorize(`PRAGMA user_version`, denyPragma); !errors.Is(err, ErrNotAuthorized) {
+		t.Fatalf("pragma not denied by name, got %v", err)
+	}
+
+	// Statements are only compiled, never executed.
+	if err := db.Authorize(`DELETE FROM foo`, denySecret); err != nil {
+		t.Fatalf("failed to authorize delete: %s", err)
+	}
+	mustExecute(db, `INSERT INTO foo(id, name) VALUES(1, 'fiona')`)
+	if err := db.Authorize(`DELETE FROM foo`, denySecret); err != nil {
+		t.Fatalf("failed to authorize delete: %s", err)
+	}
+	rows, err := db.QueryStringStmt(`SELECT COUNT(*) FROM foo`)
+	if err != nil {
+		t.Fatalf("failed to query: %s", err)
+	}
+	if exp, got := `[{"columns":["COUNT(*)"],"types":["integer"],"values":[[1]]}]`, asJSON(rows); exp != got {
+		t.Fatalf("exp %s, got %s", exp, got)
+	}
+
+	if err := db.Authorize(`SELECT * FROM qux`, denySecret); err == nil || errors.Is(err, ErrNotAuthorized) {
+		t.Fatalf("expected compile error for missing table, got %v", err)
+	}
+	if IsAuthAction("truncate") || !IsAuthAction(AuthRead) {
+		t.Fatalf("IsAuthAction returned wrong result")
+	}
+}
+
+func Test_CheckInternal(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+	mustExecute(db, `CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`)
+	mustExecute(db, `CREATE TABLE _rqlite_state (key TEXT NOT NULL PRIMARY KEY, value TEXT NOT NULL)`)
+
+	for _, stmt := range []string{
+		`SELECT * FROM foo`,
+		`INSERT INTO foo(name) VALUES('_rqlite_state')`,
+		`SELECT * FROM foo WHERE name = '_rqlite_state';`,
+		`CREATE TABLE my_rqlite_table (id INTEGER)`,
+		`SELECT * FROM no_such_rqlite_table`,
+	} {
+		if err := db.CheckInternal(stmt); err != nil {
+			t.Fatalf("statement %q unexpectedly rejected: %s", stmt, err)
+		}
+	}
+	for _, stmt := range []string{
+		`SELECT * FROM _rqlite_state`,
+		`DELETE FROM "_RQLITE_STATE"`,
+		`UPDATE _rqlite_state SET value = ''`,
+		`INSERT INTO _rqlite_state VALUES('a', 'b')`,
+		`DROP TABLE _rqlite_state`,
+		`ALTER TABLE _rqlite_state RENAME TO bar`,
+		`ALTER TABLE foo RENAME TO _rqlite_foo`,
+		`CREATE TABLE IF NOT EXISTS _rqlite_state (id INTEGER)`,
+		`CREATE INDEX foo_idx ON _rqlite_state(value)`,
+		`CREATE TRIGGER foo_trg AFTER INSERT ON foo BEGIN DELETE FROM _rqlite_state; END`,
+		`SELECT 1; DELETE FROM _rqlite_state`,
+	} {
+		if err := db.CheckInternal(stmt); err != ErrInternalTable {
+			t.Fatalf("statement %q not rejected, got %v", stmt, err)
+		}
+	}
+}
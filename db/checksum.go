@@ -0,0 +1,139 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"math"
+	"strings"
+	"time"
+)
+
+// Checksums returns a checksum of the schema and contents of each table in
+// the database, keyed by table name. The checksums are of the values stored,
+// rather than of the database file, so databases holding the same data have
+// the same checksums regardless of how the data is laid out in the file.
+// Internal SQLite tables and virtual tables are not included.
+func (db *DB) Checksums() (map[string]string, error) {
+	ctx := context.Background()
+	conn, err := db.roDB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// Read all tables within one transaction, for a consistent view.
+	tx, err := conn.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT "name", "sql" FROM "sqlite_master"
+		WHERE "type" = 'table' AND "name" NOT LIKE 'sqlite_%'
+		AND "sql" NOT LIKE 'CREATE VIRTUAL TABLE%' ORDER BY "name"`)
+	if err != nil {
+		return nil, err
+	}
+	schemas := make(map[string]string)
+	var names []string
+	for rows.Next() {
+		var name, schema string
+		if err := rows.Scan(&name, &schema); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		names = append(names, name)
+		schemas[name] = schema
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	sums := make(map[string]string, len(names))
+	for _, name := range names {
+		h := sha256.New()
+		writeChecksumValue(h, schemas[name])
+		if err := checksumTable(tx, name, h); err != nil {
+			return nil, fmt.Errorf("checksum of table %s: %s", name, err)
+		}
+		sums[name] = hex.EncodeToString(h.Sum(nil))
+	}
+	return sums, nil
+}
+
+// checksumTable writes every value in the table to h. The table is scanned
+// in the order of its rowid or primary key, which is the same for all
+// databases holding the same data.
+func checksumTable(tx *sql.Tx, name string, h hash.Hash) error {
+	rows, err := tx.Query(fmt.Sprintf(`SELECT * FROM "%s" NOT INDEXED`, strings.Replace(name, `"`, `""`, -1)))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	vals := make([]interface{}, len(cols))
+	ptrs := make([]interface{}, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		for _, v := range vals {
+			writeChecksumValue(h, v)
+		}
+	}
+	return rows.Err()
+}
+
+// writeChecksumValue writes v to h, prefixed by its type, and by its length
+// if variable-length, so that distinct rows never write the same bytes.
+func writeChecksumValue(h hash.Hash, v interface{}) {
+	var b [9]byte
+	writeLen := func(typ byte, n int) {
+		b[0] = typ
+		binary.BigEndian.PutUint64(b[1:], uint64(n))
+		h.Write(b[:])
+	}
+	switch v := v.(type) {
+	case nil:
+		h.Write([]byte{'n'})
+	case int64:
+		b[0] = 'i'
+		binary.BigEndian.PutUint64(b[1:], uint64(v))
+		h.Write(b[:])
+	case float64:
+		b[0] = 'f'
+		binary.BigEndian.PutUint64(b[1:], math.Float64bits(v))
+		h.Write(b[:])
+	case bool:
+		if v {
+			h.Write([]byte{'t'})
+		} else {
+			h.Write([]byte{'F'})
+		}
+	case string:
+		writeLen('s', len(v))
+		h.Write([]byte(v))
+	case []byte:
+		writeLen('b', len(v))
+		h.Write(v)
+	case time.Time:
+		s := v.UTC().Format(time.RFC3339Nano)
+		writeLen('T', len(s))
+		h.Write([]byte(s))
+	default:
+		s := fmt.Sprint(v)
+		writeLen('?', len(s))
+		h.Write([]byte(s))
+	}
+}
@@ -0,0 +1,68 @@
+package db
+
+import (
+	"os"
+	"testing"
+)
+
+func Test_Checksums(t *testing.T) {
+	db1, path1 := mustCreateOnDiskDatabaseWAL()
+	defer db1.Close()
+	defer os.Remove(path1)
+	db2, path2 := mustCreateOnDiskDatabaseWAL()
+	defer db2.Close()
+	defer os.Remove(path2)
+
+	// The same data, inserted in a different order and after deleting
+	// other data, must have the same checksums.
+	mustExecute(db1, `CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT, data BLOB, score REAL)`)
+	mustExecute(db1, `CREATE TABLE bar (id INTEGER NOT NULL PRIMARY KEY)`)
+	mustExecute(db1, `INSERT INTO foo VALUES(1, 'fiona', x'0102', 1.5)`)
+	mustExecute(db1, `INSERT INTO foo VALUES(2, NULL, NULL, 2)`)
+	mustExecute(db2, `CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT, data BLOB, score REAL)`)
+	mustExecute(db2, `CREATE TABLE bar (id INTEGER NOT NULL PRIMARY KEY)`)
+	mustExecute(db2, `INSERT INTO foo VALUES(3, 'declan', NULL, 0)`)
+	mustExecute(db2, `INSERT INTO foo VALUES(2, NULL, NULL, 2)`)
+	mustExecute(db2, `INSERT INTO foo VALUES(1, 'fiona', x'0102', 1.5)`)
+	mustExecute(db2, `DELETE FROM foo WHERE id = 3`)
+
+	sums1, err := db1.Checksums()
+	if err != nil {
+		t.Fatalf("failed to get checksums: %s", err)
+	}
+	sums2, err := db2.Checksums()
+	if err != nil {
+		t.Fatalf("failed to get checksums: %s", err)
+	}
+	if len(sums1) != 2 {
+		t.Fatalf("expected checksums of 2 tables, got %d", len(sums1))
+	}
+	for _, table := range []string{"foo", "bar"} {
+		if sums1[table] == "" || sums1[table] != sums2[table] {
+			t.Fatalf("checksums of table %s differ: %s, %s", table, sums1[table], sums2[table])
+		}
+	}
+
+	// A changed value changes the checksum of its table only.
+	mustExecute(db2, `UPDATE foo SET name = 'fionA' WHERE id = 1`)
+	sums2, err = db2.Checksums()
+	if err != nil {
+		t.Fatalf("failed to get checksums: %s", err)
+	}
+	if sums1["foo"] == sums2["foo"] {
+		t.Fatalf("checksums of changed table foo are the same")
+	}
+	if sums1["bar"] != sums2["bar"] {
+		t.Fatalf("checksums of unchanged table bar differ")
+	}
+
+	// A NULL differs from an empty string.
+	mustExecute(db1, `UPDATE foo SET name = '' WHERE id = 2`)
+	sums1b, err := db1.Checksums()
+	if err != nil {
+		t.Fatalf("failed to get checksums: %s", err)
+	}
+	if sums1["foo"] == sums1b["foo"] {
+		t.Fatalf("checksum unchanged after NULL replaced by empty string")
+	}
+}
@@ -0,0 +1,217 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/rqlite/rqlite/command"
+)
+
+// ErrCursorNotReadOnly is returned when a cursor is opened for a statement
+// which would change the database.
+var ErrCursorNotReadOnly = errors.New("attempt to change database via query operation")
+
+// Cursor reads the results of a query a page at a time, so that only a page
+// of them is held in memory at once. The statement of the query remains
+// active until the cursor is closed, so every page is read from the database
+// as it was when the cursor was opened, whatever is written meanwhile. A
+// Cursor is not safe for concurrent use.
+type Cursor struct {
+	conn    *sql.Conn
+	rows    *sql.Rows
+	columns []string
+	types   []string
+	budget  *MemoryBudget
+	done    bool
+
+	// The tables the query reads, against which returned rows are counted.
+	access *TableAccess
+	tables []string
+
+	// Whether the next row has been advanced to, but not yet read, to
+	// learn whether there is one.
+	pending bool
+
+	// Whether any empty types have been populated from the first row.
+	typed bool
+}
+
+// OpenCursor executes a query which returns rows, but does not modify the
+// database, returning a Cursor from which its results are read. The Cursor
+// holds a connection to the database until it is closed.
+func (db *DB) OpenCursor(stmt *command.Statement) (c *Cursor, retErr error) {
+	stats.Add(numQueries, 1)
+	stats.Add(numCursors, 1)
+	defer func() {
+		if retErr != nil {
+			stats.Add(numQueryErrors, 1)
+		}
+	}()
+
+	if len(stmt.ParamSets) > 0 {
+		return nil, ErrParamSetsQuery
+	}
+	parameters, err := parametersToValues(stmt.Parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := db.roDB.Conn(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if retErr != nil {
+			conn.Close()
+		}
+	}()
+
+	readOnly, tables, err := db.stmtTablesWithConn(stmt.Sql, conn, tracked)
+	if err != nil {
+		return nil, err
+	}
+	if !readOnly {
+		return nil, ErrCursorNotReadOnly
+	}
+
+	rs, err := conn.QueryContext(context.Background(), stmt.Sql, parameters...)
+	if err != nil {
+		return nil, err
+	}
+	columns, err := rs.Columns()
+	if err != nil {
+		rs.Close()
+		return nil, err
+	}
+	types, err := rs.ColumnTypes()
+	if err != nil {
+		rs.Close()
+		return nil, err
+	}
+	xTypes := make([]string, len(types))
+	for i := range types {
+		xTypes[i] = strings.ToLower(types[i].DatabaseTypeName())
+	}
+
+	c = &Cursor{
+		conn:    conn,
+		rows:    rs,
+		columns: columns,
+		types:   xTypes,
+		budget:  db.budget,
+		access:  db.access,
+		tables:  tables,
+	}
+	if c.access != nil {
+		c.access.read(tables)
+	}
+
+	// The database is only read once the first row is, so read it now,
+	// so that the cursor sees the database as it is when opened.
+	if c.advance() {
+		c.pending = true
+	}
+	return c, nil
+}
+
+// Next returns up to n further rows of the results, along with their columns
+// and types. Memory used by the rows is reserved from the memory budget until
+// the returned release func is called, and if the reservation fails Next
+// returns ErrQueryMemoryBudget. The caller must call release, which is never
+// nil, once it has finished with the rows. Any error reported by the database
+// while reading rows is returned in the Error of the results, after which the
+// cursor is done.
+func (c *Cursor) Next(n int) (_ *command.QueryRows, _ func(), retErr error) {
+	rows := &command.QueryRows{
+		Columns: c.columns,
+		Types:   c.types,
+	}
+	res := &reservation{b: c.budget}
+	defer func() {
+		if retErr != nil {
+			res.release()
+		}
+	}()
+	for len(rows.Values) < n {
+		if !c.advance() {
+			break
+		}
+		c.pending = false
+
+		dest := make([]interface{}, len(c.columns))
+		ptrs := make([]interface{}, len(dest))
+		for i := range ptrs {
+			ptrs[i] = &dest[i]
+		}
+		if err := c.rows.Scan(ptrs...); err != nil {
+			return nil, func() {}, err
+		}
+		params, err := normalizeRowValues(dest, c.types)
+		if err != nil {
+			return nil, func() {}, err
+		}
+		if !c.typed {
+			// One-time population of any empty types. Best effort, ignore
+			// error.
+			if containsEmptyType(c.types) {
+				populateEmptyTypes(c.types, params)
+			}
+			c.typed = true
+		}
+
+		if !res.reserve(rowSize(params)) {
+			stats.Add(numQueryErrors, 1)
+			stats.Add(numMemoryRejected, 1)
+			return nil, func() {}, ErrQueryMemoryBudget
+		}
+		rows.Values = append(rows.Values, &command.Values{Parameters: params})
+	}
+
+	if c.access != nil {
+		c.access.returned(c.tables, int64(len(rows.Values)))
+	}
+
+	// Look ahead, so that the cursor is done as soon as its last row has
+	// been returned.
+	if c.advance() {
+		c.pending = true
+	}
+	if c.done {
+		if err := c.rows.Err(); err != nil {
+			stats.Add(numQueryErrors, 1)
+			res.release()
+			return &command.QueryRows{Error: err.Error()}, func() {}, nil
+		}
+	}
+	return rows, res.release, nil
+}
+
+// advance advances to the next row, unless already there, returning false
+// if there are no more rows.
+func (c *Cursor) advance() bool {
+	if c.pending {
+		return true
+	}
+	if c.done || !c.rows.Next() {
+		c.done = true
+		return false
+	}
+	return true
+}
+
+// Done returns whether all the rows of the results have been returned.
+func (c *Cursor) Done() bool {
+	return c.done
+}
+
+// Close closes the cursor, releasing its connection to the database.
+func (c *Cursor) Close() error {
+	c.done = true
+	if err := c.rows.Close(); err != nil {
+		c.conn.Close()
+		return err
+	}
+	return c.conn.Close()
+}
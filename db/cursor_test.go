@@ -0,0 +1,78 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/rqlite/rqlite/command"
+)
+
+func Test_Cursor(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+	mustExecute(db, `CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`)
+	for i := 1; i <= 5; i++ {
+		mustExecute(db, fmt.Sprintf(`INSERT INTO foo(id, name) VALUES(%d, 'name%d')`, i, i))
+	}
+
+	c, err := db.OpenCursor(&command.Statement{Sql: `SELECT * FROM foo ORDER BY id`})
+	if err != nil {
+		t.Fatalf("failed to open cursor: %s", err)
+	}
+	defer c.Close()
+
+	// Changes made while the cursor is open are not seen by it.
+	mustExecute(db, `INSERT INTO foo(id, name) VALUES(6, 'name6')`)
+
+	var ids []int64
+	for _, exp := range []int{2, 2, 1} {
+		if c.Done() {
+			t.Fatalf("cursor done after %d rows", len(ids))
+		}
+		rows, release, err := c.Next(2)
+		if err != nil {
+			t.Fatalf("failed to read page: %s", err)
+		}
+		release()
+		if rows.Error != "" {
+			t.Fatalf("page returned error: %s", rows.Error)
+		}
+		if len(rows.Columns) != 2 || rows.Columns[0] != "id" || rows.Types[0] != "integer" {
+			t.Fatalf("wrong columns or types: %v %v", rows.Columns, rows.Types)
+		}
+		if len(rows.Values) != exp {
+			t.Fatalf("wrong number of rows in page, exp %d, got %d", exp, len(rows.Values))
+		}
+		for _, v := range rows.Values {
+			ids = append(ids, v.Parameters[0].GetI())
+		}
+	}
+	if !c.Done() {
+		t.Fatalf("cursor not done after all rows read")
+	}
+	if fmt.Sprint(ids) != "[1 2 3 4 5]" {
+		t.Fatalf("wrong rows read: %v", ids)
+	}
+
+	// A page ending with the last row leaves the cursor done.
+	c2, err := db.OpenCursor(&command.Statement{Sql: `SELECT id FROM foo LIMIT 3`})
+	if err != nil {
+		t.Fatalf("failed to open cursor: %s", err)
+	}
+	defer c2.Close()
+	if rows, _, err := c2.Next(3); err != nil || len(rows.Values) != 3 || !c2.Done() {
+		t.Fatalf("cursor not done after last row read: %v, %v", rows, err)
+	}
+
+	for _, stmt := range []*command.Statement{
+		{Sql: `SELECT * FROM bar`},
+		{Sql: `INSERT INTO foo(id, name) VALUES(7, 'name7')`},
+		{Sql: `SELECT * FROM foo WHERE id = ?`, ParamSets: []*command.ParameterSet{{}}},
+	} {
+		if _, err := db.OpenCursor(stmt); err == nil {
+			t.Fatalf("opened cursor for %q", stmt.Sql)
+		}
+	}
+}
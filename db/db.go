@@ -6,14 +6,17 @@ import (
 	"bytes"
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"expvar"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/rqlite/go-sqlite3"
@@ -40,10 +43,19 @@ const (
 	numETx               = "execute_transactions"
 	numQTx               = "query_transactions"
 	numRTx               = "request_transactions"
+	numMemoryRejected    = "query_memory_rejected"
+	numSandboxQueries    = "sandbox_queries"
+	numCursors           = "cursors"
+	numReadTxs           = "read_transactions"
+	numValidations       = "validations"
 )
 
 var (
 	ErrWALReplayDirectoryMismatch = errors.New("WAL file(s) not in same directory as database file")
+
+	// ErrParamSetsQuery is returned when a query supplies parameter sets,
+	// which are only supported by statements that modify the database.
+	ErrParamSetsQuery = errors.New("parameter sets are not supported by queries")
 )
 
 // DBVersion is the SQLite version.
@@ -74,6 +86,11 @@ func ResetStats() {
 	stats.Add(numETx, 0)
 	stats.Add(numQTx, 0)
 	stats.Add(numRTx, 0)
+	stats.Add(numSandboxQueries, 0)
+	stats.Add(numValidations, 0)
+	stats.Add(numCursors, 0)
+	stats.Add(numReadTxs, 0)
+	stats.Add(numMemoryRejected, 0)
 }
 
 // DB is the SQL database.
@@ -88,6 +105,22 @@ type DB struct {
 
 	rwDSN string // DSN used for read-write connection
 	roDSN string // DSN used for read-only connections
+
+	budget *MemoryBudget // Limits memory used by query results. May be nil.
+	access *TableAccess  // Counts the reads of each table by queries. May be nil.
+
+	attached map[string]string // Paths of attached databases, by schema name.
+
+	// rwAuthorizer is the authorizer registered on the read-write
+	// connection, if any, by RegisterRowChangeHook.
+	rwAuthorizer func(int, string, string, string) int
+
+	sbMu sync.Mutex
+	sbDB *sql.DB // Sandboxed read-only connections, opened on first use.
+
+	// Held for reading while the files are pinned, and for writing by
+	// checkpoints and Close, which change the SQLite file.
+	filesMu sync.RWMutex
 }
 
 // PoolStats represents connection pool statistics
@@ -125,6 +158,72 @@ func IsValidSQLiteData(b []byte) bool {
 	return len(b) > 13 && string(b[0:13]) == "SQLite format"
 }
 
+// sqlDumpKeywords are the keywords with which the first statement of a SQL
+// dump may start.
+var sqlDumpKeywords = []string{
+	"ALTER", "ANALYZE", "BEGIN", "CREATE", "DELETE", "DROP",
+	"INSERT", "PRAGMA", "REPLACE", "SAVEPOINT", "UPDATE",
+}
+
+// IsSQLDumpFile checks that the supplied path looks like a SQL dump.
+func IsSQLDumpFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	b := make([]byte, 4096)
+	n, err := io.ReadFull(f, b)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return false
+	}
+	return IsSQLDumpData(b[:n])
+}
+
+// IsSQLDumpData checks that the supplied data looks like the start of a SQL
+// dump, such as written by Dump or the sqlite3 shell's .dump command. That is,
+// text whose first statement, after any comments, starts with a keyword of a
+// statement which may appear in a dump.
+func IsSQLDumpData(b []byte) bool {
+	if bytes.IndexByte(b, 0) >= 0 {
+		return false
+	}
+	s := strings.TrimPrefix(string(b), "\ufeff")
+	for {
+		s = strings.TrimLeft(s, " \t\r\n")
+		if strings.HasPrefix(s, "--") {
+			i := strings.IndexByte(s, '\n')
+			if i < 0 {
+				return false
+			}
+			s = s[i+1:]
+		} else if strings.HasPrefix(s, "/*") {
+			i := strings.Index(s, "*/")
+			if i < 0 {
+				return false
+			}
+			s = s[i+2:]
+		} else {
+			break
+		}
+	}
+
+	i := 0
+	for i < len(s) && (s[i] >= 'A' && s[i] <= 'Z' || s[i] >= 'a' && s[i] <= 'z') {
+		i++
+	}
+	if i == 0 || i == len(s) {
+		return false
+	}
+	for _, k := range sqlDumpKeywords {
+		if strings.EqualFold(s[:i], k) {
+			return true
+		}
+	}
+	return false
+}
+
 // IsValidSQLiteWALFile checks that the supplied path looks like a SQLite
 // WAL file. See https://www.sqlite.org/fileformat2.html#walformat
 func IsValidSQLiteWALFile(path string) bool {
@@ -307,8 +406,27 @@ func ReplayWAL(path string, wals []string, deleteMode bool) error {
 // Open opens a file-based database, creating it if it does not exist. After this
 // function returns, an actual SQLite file will always exist.
 func Open(dbPath string, fkEnabled, wal bool) (*DB, error) {
+	return OpenWithAttached(dbPath, fkEnabled, wal, nil)
+}
+
+// OpenWithAttached opens a file-based database as Open does, and attaches
+// the databases at the given paths, keyed by schema name, to every
+// connection. Attached databases are created if they do not exist, and are
+// not part of any copy, backup, or serialization of the database.
+func OpenWithAttached(dbPath string, fkEnabled, wal bool, attached map[string]string) (*DB, error) {
+	rwDriverName, roDriverName := "sqlite3", "sqlite3"
+	hook := getConnectHook()
+	if len(attached) > 0 || hook != nil {
+		rwDriverName = registerDriver(attached, hook, false, false)
+		roDriverName = registerDriver(attached, hook, true, false)
+	}
+
+	limits := getPoolLimits()
 	rwDSN := fmt.Sprintf("file:%s?_fk=%s", dbPath, strconv.FormatBool(fkEnabled))
-	rwDB, err := sql.Open("sqlite3", rwDSN)
+	if limits.CacheSize > 0 {
+		rwDSN += fmt.Sprintf("&_cache_size=-%d", limits.CacheSize)
+	}
+	rwDB, err := sql.Open(rwDriverName, rwDSN)
 	if err != nil {
 		return nil, fmt.Errorf("open: %s", err.Error())
 	}
@@ -337,9 +455,12 @@ func Open(dbPath string, fkEnabled, wal bool) (*DB, error) {
 		"mode=ro",
 		fmt.Sprintf("_fk=%s", strconv.FormatBool(fkEnabled)),
 	}
+	if limits.CacheSize > 0 {
+		roOpts = append(roOpts, fmt.Sprintf("_cache_size=-%d", limits.CacheSize))
+	}
 
 	roDSN := fmt.Sprintf("file:%s?%s", dbPath, strings.Join(roOpts, "&"))
-	roDB, err := sql.Open("sqlite3", roDSN)
+	roDB, err := sql.Open(roDriverName, roDSN)
 	if err != nil {
 		return nil, err
 	}
@@ -354,6 +475,10 @@ func Open(dbPath string, fkEnabled, wal bool) (*DB, error) {
 	rwDB.SetMaxOpenConns(1) // Key to ensure a new connection doesn't enable checkpointing
 	roDB.SetConnMaxIdleTime(30 * time.Second)
 	roDB.SetConnMaxLifetime(0)
+	if limits.MaxReadConns > 0 {
+		roDB.SetMaxOpenConns(limits.MaxReadConns)
+		roDB.SetMaxIdleConns(1)
+	}
 
 	return &DB{
 		path:      dbPath,
@@ -364,14 +489,117 @@ func Open(dbPath string, fkEnabled, wal bool) (*DB, error) {
 		roDB:      roDB,
 		rwDSN:     rwDSN,
 		roDSN:     roDSN,
+		attached:  attached,
 	}, nil
 }
 
+// ConnectHook is called with each new connection to a database, and
+// whether the connection is read-only. Queries are served by read-only
+// connections, while changes, including those applied from the Raft log, are
+// made through a read-write connection.
+type ConnectHook func(conn *sqlite3.SQLiteConn, readOnly bool) error
+
+// PoolLimits bounds the memory used by the connections to a database.
+type PoolLimits struct {
+	// MaxReadConns is the number of read-only connections which may be open
+	// at once. Queries wait for a free connection once all are in use. If
+	// zero, not limited.
+	MaxReadConns int
+
+	// CacheSize is the size, in KiB, of the page cache of each connection.
+	// If zero, the SQLite default is used.
+	CacheSize int
+}
+
+var (
+	connectHookMu sync.Mutex
+	connectHook   ConnectHook
+	poolLimits    PoolLimits
+
+	driversMu  sync.Mutex
+	numDrivers int
+)
+
+// SetConnectHook sets the hook called with each new connection to databases
+// opened once it is set, such as to register SQLite modules.
+func SetConnectHook(hook ConnectHook) {
+	connectHookMu.Lock()
+	defer connectHookMu.Unlock()
+	connectHook = hook
+}
+
+func getConnectHook() ConnectHook {
+	connectHookMu.Lock()
+	defer connectHookMu.Unlock()
+	return connectHook
+}
+
+// SetPoolLimits sets the limits on the connections to databases opened once
+// they are set, such as to run in less memory.
+func SetPoolLimits(limits PoolLimits) {
+	connectHookMu.Lock()
+	defer connectHookMu.Unlock()
+	poolLimits = limits
+}
+
+func getPoolLimits() PoolLimits {
+	connectHookMu.Lock()
+	defer connectHookMu.Unlock()
+	return poolLimits
+}
+
+// registerDriver registers a SQLite driver which attaches the given
+// databases to every connection it opens, and calls any hook with the
+// connection, and returns its name. If sandbox is set, every connection
+// is then sandboxed, so that it can only read.
+func registerDriver(attached map[string]string, hook ConnectHook, readOnly, sandbox bool) string {
+	names := make([]string, 0, len(attached))
+	for name := range attached {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	numDrivers++
+	driverName := fmt.Sprintf("sqlite3-rqlite-%d", numDrivers)
+	sql.Register(driverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			for _, name := range names {
+				if _, err := conn.Exec(fmt.Sprintf(`ATTACH DATABASE ? AS "%s"`, name),
+					[]driver.Value{attached[name]}); err != nil {
+					return fmt.Errorf("attach %s: %s", name, err.Error())
+				}
+			}
+			if hook != nil {
+				if err := hook(conn, readOnly); err != nil {
+					return err
+				}
+			}
+			if sandbox {
+				return sandboxConn(conn)
+			}
+			return nil
+		},
+	})
+	return driverName
+}
+
 // Close closes the underlying database connection.
 func (db *DB) Close() error {
+	// Closing the last connection checkpoints the WAL.
+	db.filesMu.Lock()
+	defer db.filesMu.Unlock()
 	if err := db.rwDB.Close(); err != nil {
 		return err
 	}
+	db.sbMu.Lock()
+	defer db.sbMu.Unlock()
+	if db.sbDB != nil {
+		if err := db.sbDB.Close(); err != nil {
+			return err
+		}
+	}
 	return db.roDB.Close()
 }
 
@@ -417,9 +645,25 @@ func (db *DB) Stats() (map[string]interface{}, error) {
 			return nil, err
 		}
 	}
+	if db.budget != nil {
+		stats["memory_budget"] = db.budget.Stats()
+	}
 	return stats, nil
 }
 
+// SetMemoryBudget sets the budget limiting the memory used by the results of
+// queries. Queries whose results would exceed the budget fail. If b is nil,
+// memory used by query results is not limited.
+func (db *DB) SetMemoryBudget(b *MemoryBudget) {
+	db.budget = b
+}
+
+// MemoryBudget returns the budget limiting the memory used by the results
+// of queries. Returns nil if no budget is set.
+func (db *DB) MemoryBudget() *MemoryBudget {
+	return db.budget
+}
+
 // Size returns the size of the database in bytes. "Size" is defined as
 // page_count * schema.page_size.
 func (db *DB) Size() (int64, error) {
@@ -470,6 +714,9 @@ func (db *DB) Checkpoint() error {
 // complete within the given duration, an error is returned. If the duration is 0,
 // the checkpoint will be attempted only once.
 func (db *DB) CheckpointWithTimeout(dur time.Duration) (err error) {
+	db.filesMu.Lock()
+	defer db.filesMu.Unlock()
+
 	start := time.Now()
 	defer func() {
 		if err != nil {
@@ -523,6 +770,20 @@ func (db *DB) CheckpointWithTimeout(dur time.Duration) (err error) {
 	}
 }
 
+// PinFiles prevents the SQLite file of the database from being changed until
+// the returned function is called. Checkpoints, and Close, block until then.
+// With automatic checkpointing disabled, and the WAL enabled, writes only
+// append to the WAL file while the files are pinned, so the SQLite file and
+// the WAL file, up to its size when pinned, can be read as a consistent view
+// of the database while writes continue.
+func (db *DB) PinFiles() func() {
+	db.filesMu.RLock()
+	var once sync.Once
+	return func() {
+		once.Do(db.filesMu.RUnlock)
+	}
+}
+
 // DisableCheckpointing disables the automatic checkpointing that occurs when
 // the WAL reaches a certain size. This is key for full control of snapshotting.
 // and can be useful for testing.
@@ -592,6 +853,32 @@ func (db *DB) WALPath() string {
 	return db.walPath
 }
 
+// Attached returns the paths of the databases attached to the database, by
+// schema name.
+func (db *DB) Attached() map[string]string {
+	return db.attached
+}
+
+// BackupAttached writes a consistent copy of the named attached database to
+// the file at path, which must not exist.
+func (db *DB) BackupAttached(name, path string) error {
+	if _, ok := db.attached[name]; !ok {
+		return fmt.Errorf("database %s not attached", name)
+	}
+	_, err := db.rwDB.Exec(fmt.Sprintf(`VACUUM "%s" INTO ?`, name), path)
+	return err
+}
+
+// SchemaVersion returns the schema version of the database, which SQLite
+// changes whenever the schema is changed.
+func (db *DB) SchemaVersion() (int64, error) {
+	var v int64
+	if err := db.rwDB.QueryRow("PRAGMA schema_version").Scan(&v); err != nil {
+		return 0, err
+	}
+	return v, nil
+}
+
 // CompileOptions returns the SQLite compilation options.
 func (db *DB) CompileOptions() ([]string, error) {
 	res, err := db.QueryStringStmt("PRAGMA compile_options")
@@ -642,7 +929,10 @@ func (db *DB) ExecuteStringStmt(query string) ([]*command.ExecuteResult, error)
 	return db.Execute(r, false)
 }
 
-// Execute executes queries that modify the database.
+// Execute executes queries that modify the database. If the request is a
+// transaction, processing stops at the first statement which fails, and
+// every change is rolled back. Otherwise processing stops at the first
+// failure only if StopOnError is set, keeping the changes made before it.
 func (db *DB) Execute(req *command.Request, xTime bool) ([]*command.ExecuteResult, error) {
 	stats.Add(numExecutions, int64(len(req.Statements)))
 	conn, err := db.rwDB.Conn(context.Background())
@@ -650,20 +940,44 @@ func (db *DB) Execute(req *command.Request, xTime bool) ([]*command.ExecuteResul
 		return nil, err
 	}
 	defer conn.Close()
-	return db.executeWithConn(req, xTime, conn)
+	return db.executeWithConn(req, xTime, conn, nil)
+}
+
+// ExecuteRecordFunc returns the statements which record the results of an
+// executed request.
+type ExecuteRecordFunc func(results []*command.ExecuteResult) ([]*command.Statement, error)
+
+// ExecuteRecorded is the same as Execute, but then executes the statements
+// returned by record, in the same transaction as the request, so that they
+// are committed only with the changes made by the request. A transaction is
+// used even if the request is not one, but failing statements are still
+// handled as they are by Execute. If the request is a transaction which
+// fails, record is not called.
+func (db *DB) ExecuteRecorded(req *command.Request, xTime bool, record ExecuteRecordFunc) ([]*command.ExecuteResult, error) {
+	stats.Add(numExecutions, int64(len(req.Statements)))
+	conn, err := db.rwDB.Conn(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return db.executeWithConn(req, xTime, conn, record)
 }
 
 type execer interface {
 	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
 }
 
-func (db *DB) executeWithConn(req *command.Request, xTime bool, conn *sql.Conn) ([]*command.ExecuteResult, error) {
+func (db *DB) executeWithConn(req *command.Request, xTime bool, conn *sql.Conn,
+	record ExecuteRecordFunc) ([]*command.ExecuteResult, error) {
 	var err error
 
 	var execer execer
 	var tx *sql.Tx
-	if req.Transaction {
-		stats.Add(numETx, 1)
+	if req.Transaction || record != nil {
+		if req.Transaction {
+			stats.Add(numETx, 1)
+		}
 		tx, err = conn.BeginTx(context.Background(), nil)
 		if err != nil {
 			return nil, err
@@ -686,12 +1000,12 @@ func (db *DB) executeWithConn(req *command.Request, xTime bool, conn *sql.Conn)
 		stats.Add(numExecutionErrors, 1)
 		result.Error = err.Error()
 		allResults = append(allResults, result)
-		if tx != nil {
+		if tx != nil && req.Transaction {
 			tx.Rollback()
 			tx = nil
 			return false
 		}
-		return true
+		return !req.StopOnError
 	}
 
 	// Execute each statement.
@@ -711,13 +1025,40 @@ func (db *DB) executeWithConn(req *command.Request, xTime bool, conn *sql.Conn)
 		allResults = append(allResults, result)
 	}
 
+	if tx != nil && record != nil {
+		stmts, err := record(allResults)
+		if err == nil {
+			err = db.executeRecord(stmts, tx)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
 	if tx != nil {
 		err = tx.Commit()
 	}
 	return allResults, err
 }
 
+// executeRecord executes the statements which record the results of a
+// request, returning an error if any fails.
+func (db *DB) executeRecord(stmts []*command.Statement, e execer) error {
+	for _, stmt := range stmts {
+		result, err := db.executeStmtWithConn(stmt, false, e)
+		if err != nil {
+			return err
+		}
+		if result.Error != "" {
+			return errors.New(result.Error)
+		}
+	}
+	return nil
+}
+
 func (db *DB) executeStmtWithConn(stmt *command.Statement, xTime bool, e execer) (*command.ExecuteResult, error) {
+	if len(stmt.ParamSets) > 0 {
+		return db.executeManyWithConn(stmt, xTime, e)
+	}
 	result := &command.ExecuteResult{}
 	start := time.Now()
 
@@ -756,6 +1097,52 @@ func (db *DB) executeStmtWithConn(stmt *command.Statement, xTime bool, e execer)
 	return result, nil
 }
 
+// executeManyWithConn executes a statement once for each of its parameter
+// sets, preparing it only once. The result holds the total number of rows
+// affected, and the ID of the last row inserted. Execution stops at the first
+// set which fails, whose index is given in the error. Unless the statement is
+// part of a transaction, the changes made by the sets before it remain.
+func (db *DB) executeManyWithConn(stmt *command.Statement, xTime bool, e execer) (*command.ExecuteResult, error) {
+	result := &command.ExecuteResult{}
+	start := time.Now()
+
+	ps, err := e.PrepareContext(context.Background(), stmt.Sql)
+	if err != nil {
+		result.Error = err.Error()
+		return result, err
+	}
+	defer ps.Close()
+
+	for i, set := range stmt.ParamSets {
+		parameters, err := parametersToValues(set.GetParameters())
+		if err != nil {
+			err = fmt.Errorf("parameter set %d: %w", i, err)
+			return &command.ExecuteResult{Error: err.Error()}, err
+		}
+		r, err := ps.ExecContext(context.Background(), parameters...)
+		if err != nil {
+			err = fmt.Errorf("parameter set %d: %w", i, err)
+			return &command.ExecuteResult{Error: err.Error()}, err
+		}
+		lid, err := r.LastInsertId()
+		if err != nil {
+			result.Error = err.Error()
+			return result, err
+		}
+		result.LastInsertId = lid
+		ra, err := r.RowsAffected()
+		if err != nil {
+			result.Error = err.Error()
+			return result, err
+		}
+		result.RowsAffected += ra
+	}
+	if xTime {
+		result.Time = time.Since(start).Seconds()
+	}
+	return result, nil
+}
+
 // QueryStringStmt executes a single query that return rows, but don't modify database.
 func (db *DB) QueryStringStmt(query string) ([]*command.QueryRows, error) {
 	r := &command.Request{
@@ -770,153 +1157,53 @@ func (db *DB) QueryStringStmt(query string) ([]*command.QueryRows, error) {
 
 // Query executes queries that return rows, but don't modify the database.
 func (db *DB) Query(req *command.Request, xTime bool) ([]*command.QueryRows, error) {
+	rows, release, err := db.QueryReserved(req, xTime)
+	release()
+	return rows, err
+}
+
+// QueryReserved is the same as Query, but the memory reserved from the
+// budget for the results is held until the returned release func is called.
+// The caller must call release, which is never nil, once it has finished
+// with the results.
+func (db *DB) QueryReserved(req *command.Request, xTime bool) ([]*command.QueryRows, func(), error) {
 	stats.Add(numQueries, int64(len(req.Statements)))
 	conn, err := db.roDB.Conn(context.Background())
 	if err != nil {
-		return nil, err
+		return nil, func() {}, err
 	}
 	defer conn.Close()
-	return db.queryWithConn(req, xTime, conn)
+	res := &reservation{b: db.budget}
+	rows, err := db.queryWithConn(req, xTime, conn, res, tracked)
+	return rows, res.release, err
 }
 
 type queryer interface {
 	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
 }
 
-func (db *DB) queryWithConn(req *command.Request, xTime bool, conn *sql.Conn) ([]*command.QueryRows, error) {
-	var err error
-
-	var queryer queryer
-	var tx *sql.Tx
-	if req.Transaction {
-		stats.Add(numQTx, 1)
-		tx, err = conn.BeginTx(context.Background(), nil)
-		if err != nil {
-			return nil, err
-		}
-		defer tx.Rollback() // Will be ignored if tx is committed
-		queryer = tx
-	} else {
-		queryer = conn
-	}
-
-	var allRows []*command.QueryRows
-	for _, stmt := range req.Statements {
-		sql := stmt.Sql
-		if sql == "" {
-			continue
-		}
-
-		var rows *command.QueryRows
-		var err error
-
-		readOnly, err := db.StmtReadOnlyWithConn(sql, conn)
-		if err != nil {
-			stats.Add(numQueryErrors, 1)
-			rows = &command.QueryRows{
-				Error: err.Error(),
-			}
-			allRows = append(allRows, rows)
-			continue
-		}
-		if !readOnly {
-			stats.Add(numQueryErrors, 1)
-			rows = &command.QueryRows{
-				Error: "attempt to change database via query operation",
-			}
-			allRows = append(allRows, rows)
-			continue
-		}
+func (db *DB) queryWithConn(req *command.Request, xTime bool, conn *sql.Conn, res *reservation, track readTracking) ([]*command.QueryRows, error) {
+	c := &rowsCollector{res: res}
+	err := db.streamWithConn(req, xTime, conn, c, track)
+	return c.all, err
+}
 
-		rows, err = db.queryStmtWithConn(stmt, xTime, queryer)
-		if err != nil {
+// queryStmtWithConn executes a single query statement. Memory used by the
+// results is reserved from res, and if the reservation fails the statement
+// fails with ErrQueryMemoryBudget.
+func (db *DB) queryStmtWithConn(stmt *command.Statement, xTime bool, q queryer, res *reservation) (*command.QueryRows, error) {
+	c := &rowsCollector{res: res}
+	if err := db.streamStmtWithConn(stmt, xTime, q, c); err != nil {
+		if err == ErrQueryMemoryBudget {
 			stats.Add(numQueryErrors, 1)
-			rows = &command.QueryRows{
-				Error: err.Error(),
-			}
+			stats.Add(numMemoryRejected, 1)
+			return &command.QueryRows{
+				Error: ErrQueryMemoryBudget.Error(),
+			}, nil
 		}
-		allRows = append(allRows, rows)
-	}
-
-	if tx != nil {
-		err = tx.Commit()
-	}
-	return allRows, err
-}
-
-func (db *DB) queryStmtWithConn(stmt *command.Statement, xTime bool, q queryer) (*command.QueryRows, error) {
-	rows := &command.QueryRows{}
-	start := time.Now()
-
-	parameters, err := parametersToValues(stmt.Parameters)
-	if err != nil {
-		stats.Add(numQueryErrors, 1)
-		rows.Error = err.Error()
-		return rows, nil
-	}
-
-	rs, err := q.QueryContext(context.Background(), stmt.Sql, parameters...)
-	if err != nil {
-		stats.Add(numQueryErrors, 1)
-		rows.Error = err.Error()
-		return rows, nil
-	}
-	defer rs.Close()
-
-	columns, err := rs.Columns()
-	if err != nil {
 		return nil, err
 	}
-
-	types, err := rs.ColumnTypes()
-	if err != nil {
-		return nil, err
-	}
-	xTypes := make([]string, len(types))
-	for i := range types {
-		xTypes[i] = strings.ToLower(types[i].DatabaseTypeName())
-	}
-	needsQueryTypes := containsEmptyType(xTypes)
-
-	for rs.Next() {
-		dest := make([]interface{}, len(columns))
-		ptrs := make([]interface{}, len(dest))
-		for i := range ptrs {
-			ptrs[i] = &dest[i]
-		}
-		if err := rs.Scan(ptrs...); err != nil {
-			return nil, err
-		}
-		params, err := normalizeRowValues(dest, xTypes)
-		if err != nil {
-			return nil, err
-		}
-		rows.Values = append(rows.Values, &command.Values{
-			Parameters: params,
-		})
-
-		// One-time population of any empty types. Best effort, ignore
-		// error.
-		if needsQueryTypes {
-			populateEmptyTypes(xTypes, params)
-			needsQueryTypes = false
-		}
-	}
-
-	// Check for errors from iterating over rows.
-	if err := rs.Err(); err != nil {
-		stats.Add(numQueryErrors, 1)
-		rows.Error = err.Error()
-		return rows, nil
-	}
-
-	if xTime {
-		rows.Time = time.Since(start).Seconds()
-	}
-
-	rows.Columns = columns
-	rows.Types = xTypes
-	return rows, nil
+	return c.all[0], nil
 }
 
 // RequestStringStmts processes a request that can contain both executes and queries.
@@ -931,7 +1218,36 @@ func (db *DB) RequestStringStmts(stmts []string) ([]*command.ExecuteQueryRespons
 }
 
 // Request processes a request that can contain both executes and queries.
+// Failing statements are handled as they are by Execute.
 func (db *DB) Request(req *command.Request, xTime bool) ([]*command.ExecuteQueryResponse, error) {
+	return db.RequestRecorded(req, xTime, nil)
+}
+
+// RequestReserved is the same as Request, but the memory reserved from the
+// budget for the results of any queries is held until the returned release
+// func is called. The caller must call release, which is never nil, once it
+// has finished with the results.
+func (db *DB) RequestReserved(req *command.Request, xTime bool) ([]*command.ExecuteQueryResponse, func(), error) {
+	res := &reservation{b: db.budget}
+	results, err := db.request(req, xTime, nil, res)
+	return results, res.release, err
+}
+
+// RequestRecordFunc returns the statements which record the results of a
+// processed request.
+type RequestRecordFunc func(results []*command.ExecuteQueryResponse) ([]*command.Statement, error)
+
+// RequestRecorded is the same as Request, but then executes the statements
+// returned by record, if not nil, in the same transaction as the request, as
+// ExecuteRecorded does.
+func (db *DB) RequestRecorded(req *command.Request, xTime bool, record RequestRecordFunc) ([]*command.ExecuteQueryResponse, error) {
+	res := &reservation{b: db.budget}
+	results, err := db.request(req, xTime, record, res)
+	res.release()
+	return results, err
+}
+
+func (db *DB) request(req *command.Request, xTime bool, record RequestRecordFunc, res *reservation) ([]*command.ExecuteQueryResponse, error) {
 	stats.Add(numRequests, int64(len(req.Statements)))
 	conn, err := db.rwDB.Conn(context.Background())
 	if err != nil {
@@ -942,8 +1258,10 @@ func (db *DB) Request(req *command.Request, xTime bool) ([]*command.ExecuteQuery
 	var queryer queryer
 	var execer execer
 	var tx *sql.Tx
-	if req.Transaction {
-		stats.Add(numRTx, 1)
+	if req.Transaction || record != nil {
+		if req.Transaction {
+			stats.Add(numRTx, 1)
+		}
 		tx, err = conn.BeginTx(context.Background(), nil)
 		if err != nil {
 			return nil, err
@@ -959,12 +1277,15 @@ func (db *DB) Request(req *command.Request, xTime bool) ([]*command.ExecuteQuery
 	// abortOnError indicates whether the caller should continue
 	// processing or break.
 	abortOnError := func(err error) bool {
-		if err != nil && tx != nil {
+		if err == nil {
+			return false
+		}
+		if tx != nil && req.Transaction {
 			tx.Rollback()
 			tx = nil
 			return true
 		}
-		return false
+		return req.StopOnError
 	}
 
 	var eqResponse []*command.ExecuteQueryResponse
@@ -974,18 +1295,24 @@ func (db *DB) Request(req *command.Request, xTime bool) ([]*command.ExecuteQuery
 			continue
 		}
 
-		ro, err := db.StmtReadOnlyWithConn(ss, conn)
+		ro, tables, err := db.stmtTablesWithConn(ss, conn, trackedWritable)
 		if err != nil {
 			eqResponse = append(eqResponse, &command.ExecuteQueryResponse{
 				Result: &command.ExecuteQueryResponse_Error{
 					Error: err.Error(),
 				},
 			})
+			if abortOnError(err) {
+				break
+			}
 			continue
 		}
 
 		if ro {
-			rows, opErr := db.queryStmtWithConn(stmt, xTime, queryer)
+			rows, opErr := db.queryStmtWithConn(stmt, xTime, queryer, res)
+			if rows != nil {
+				db.recordReads(tables, int64(len(rows.Values)))
+			}
 			eqResponse = append(eqResponse, createEQQueryResponse(rows, opErr))
 			if abortOnError(opErr) {
 				break
@@ -999,6 +1326,15 @@ func (db *DB) Request(req *command.Request, xTime bool) ([]*command.ExecuteQuery
 		}
 	}
 
+	if tx != nil && record != nil {
+		stmts, err := record(eqResponse)
+		if err == nil {
+			err = db.executeRecord(stmts, tx)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
 	if tx != nil {
 		err = tx.Commit()
 	}
@@ -1075,6 +1411,8 @@ func (db *DB) Serialize() ([]byte, error) {
 
 // Dump writes a consistent snapshot of the database in SQL text format.
 // This function can be called when changes to the database are in flight.
+// Tables rqlite keeps for its own use are not included, since their contents
+// belong to the cluster the dump was taken from.
 func (db *DB) Dump(w io.Writer) error {
 	conn, err := db.roDB.Conn(context.Background())
 	if err != nil {
@@ -1100,7 +1438,7 @@ func (db *DB) Dump(w io.Writer) error {
 	// Get the schema.
 	query := `SELECT "name", "type", "sql" FROM "sqlite_master"
               WHERE "sql" NOT NULL AND "type" == 'table' ORDER BY "name"`
-	rows, err := db.queryWithConn(commReq(query), false, conn)
+	rows, err := db.queryWithConn(commReq(query), false, conn, nil, untracked)
 	if err != nil {
 		return err
 	}
@@ -1113,7 +1451,8 @@ func (db *DB) Dump(w io.Writer) error {
 			stmt = `DELETE FROM "sqlite_sequence";`
 		} else if table == "sqlite_stat1" {
 			stmt = `ANALYZE "sqlite_master";`
-		} else if strings.HasPrefix(table, "sqlite_") {
+		} else if strings.HasPrefix(table, "sqlite_") ||
+			strings.HasPrefix(strings.ToLower(table), InternalTablePrefix) {
 			continue
 		} else {
 			stmt = v.Parameters[2].GetS()
@@ -1125,7 +1464,7 @@ func (db *DB) Dump(w io.Writer) error {
 
 		tableIndent := strings.Replace(table, `"`, `""`, -1)
 		r, err := db.queryWithConn(commReq(fmt.Sprintf(`PRAGMA table_info("%s")`, tableIndent)),
-			false, conn)
+			false, conn, nil, untracked)
 		if err != nil {
 			return err
 		}
@@ -1138,7 +1477,7 @@ func (db *DB) Dump(w io.Writer) error {
 			tableIndent,
 			strings.Join(columnNames, ","),
 			tableIndent)
-		r, err = db.queryWithConn(commReq(query), false, conn)
+		r, err = db.queryWithConn(commReq(query), false, conn, nil, untracked)
 
 		if err != nil {
 			return err
@@ -1154,7 +1493,7 @@ func (db *DB) Dump(w io.Writer) error {
 	// Do indexes, triggers, and views.
 	query = `SELECT "name", "type", "sql" FROM "sqlite_master"
 			  WHERE "sql" NOT NULL AND "type" IN ('index', 'trigger', 'view')`
-	rows, err = db.queryWithConn(commReq(query), false, conn)
+	rows, err = db.queryWithConn(commReq(query), false, conn, nil, untracked)
 	if err != nil {
 		return err
 	}
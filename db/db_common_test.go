@@ -780,6 +780,95 @@ func testSimpleTwoParameterizedStatements(t *testing.T, db *DB) {
 	}
 }
 
+func testParamSetsStatements(t *testing.T, db *DB) {
+	_, err := db.ExecuteStringStmt("CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT UNIQUE)")
+	if err != nil {
+		t.Fatalf("failed to create table: %s", err.Error())
+	}
+
+	set := func(name string) *command.ParameterSet {
+		return &command.ParameterSet{
+			Parameters: []*command.Parameter{{Value: &command.Parameter_S{S: name}}},
+		}
+	}
+	req := &command.Request{
+		Statements: []*command.Statement{
+			{
+				Sql:       "INSERT INTO foo(name) VALUES(?)",
+				ParamSets: []*command.ParameterSet{set("fiona"), set("declan"), set("aoife")},
+			},
+		},
+	}
+	r, err := db.Execute(req, false)
+	if err != nil {
+		t.Fatalf("failed to insert records: %s", err.Error())
+	}
+	if exp, got := `[{"last_insert_id":3,"rows_affected":3}]`, asJSON(r); exp != got {
+		t.Fatalf("unexpected results for execute\nexp: %s\ngot: %s", exp, got)
+	}
+
+	// Within a transaction, a failing set rolls back all sets.
+	req.Transaction = true
+	req.Statements[0].ParamSets = []*command.ParameterSet{set("sinead"), set("fiona")}
+	r, err = db.Execute(req, false)
+	if err != nil {
+		t.Fatalf("failed to execute: %s", err.Error())
+	}
+	if exp, got := `[{"error":"parameter set 1: UNIQUE constraint failed: foo.name"}]`, asJSON(r); exp != got {
+		t.Fatalf("unexpected results for execute\nexp: %s\ngot: %s", exp, got)
+	}
+	q, err := db.QueryStringStmt(`SELECT name FROM foo`)
+	if err != nil {
+		t.Fatalf("failed to query table: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["name"],"types":["text"],"values":[["fiona"],["declan"],["aoife"]]}]`, asJSON(q); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+
+	// So does a set whose parameters can't be converted.
+	bad := &command.ParameterSet{
+		Parameters: []*command.Parameter{{Value: unsupportedParameter{&command.Parameter_I{I: 1}}}},
+	}
+	req.Statements[0].ParamSets = []*command.ParameterSet{set("sinead"), bad, set("niamh")}
+	r, err = db.Execute(req, false)
+	if err != nil {
+		t.Fatalf("failed to execute: %s", err.Error())
+	}
+	if exp, got := `[{"error":"parameter set 1: unsupported type: db.unsupportedParameter"}]`, asJSON(r); exp != got {
+		t.Fatalf("unexpected results for execute\nexp: %s\ngot: %s", exp, got)
+	}
+	q, err = db.QueryStringStmt(`SELECT name FROM foo`)
+	if err != nil {
+		t.Fatalf("failed to query table: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["name"],"types":["text"],"values":[["fiona"],["declan"],["aoife"]]}]`, asJSON(q); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+
+	// Queries do not support parameter sets.
+	req = &command.Request{
+		Statements: []*command.Statement{
+			{
+				Sql:       "SELECT * FROM foo WHERE name = ?",
+				ParamSets: []*command.ParameterSet{set("fiona")},
+			},
+		},
+	}
+	q, err = db.Query(req, false)
+	if err != nil {
+		t.Fatalf("failed to query table: %s", err.Error())
+	}
+	if exp, got := `[{"error":"parameter sets are not supported by queries"}]`, asJSON(q); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+}
+
+// unsupportedParameter is a parameter value of a type the database does not
+// support.
+type unsupportedParameter struct {
+	*command.Parameter_I
+}
+
 func testSimpleNilParameterizedStatements(t *testing.T, db *DB) {
 	_, err := db.ExecuteStringStmt("CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, first TEXT, last TEXT)")
 	if err != nil {
@@ -1514,6 +1603,7 @@ func Test_DatabaseCommonOperations(t *testing.T) {
 		{"SimpleParameterizedStatements", testSimpleParameterizedStatements},
 		{"SimpleTwoParameterizedStatements", testSimpleTwoParameterizedStatements},
 		{"SimpleNilParameterizedStatements", testSimpleNilParameterizedStatements},
+		{"ParamSetsStatements", testParamSetsStatements},
 		{"SimpleNamedParameterizedStatements", testSimpleNamedParameterizedStatements},
 		{"SimpleRequest", testSimpleRequest},
 		{"SimpleRequestTx", testSimpleRequestTx},
@@ -1,7 +1,9 @@
 package db
 
 import (
+	"bytes"
 	"database/sql"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -85,6 +87,136 @@ func Test_TableCreation(t *testing.T) {
 	}
 }
 
+func Test_SchemaVersion(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+
+	v0, err := db.SchemaVersion()
+	if err != nil {
+		t.Fatalf("failed to get schema version: %s", err.Error())
+	}
+	if _, err := db.ExecuteStringStmt("CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)"); err != nil {
+		t.Fatalf("failed to create table: %s", err.Error())
+	}
+	v1, err := db.SchemaVersion()
+	if err != nil {
+		t.Fatalf("failed to get schema version: %s", err.Error())
+	}
+	if v1 <= v0 {
+		t.Fatalf("schema version not changed by DDL, was %d, now %d", v0, v1)
+	}
+	if _, err := db.ExecuteStringStmt(`INSERT INTO foo(name) VALUES("fiona")`); err != nil {
+		t.Fatalf("failed to insert record: %s", err.Error())
+	}
+	v2, err := db.SchemaVersion()
+	if err != nil {
+		t.Fatalf("failed to get schema version: %s", err.Error())
+	}
+	if v2 != v1 {
+		t.Fatalf("schema version changed by DML, was %d, now %d", v1, v2)
+	}
+}
+
+func Test_AttachedDatabase(t *testing.T) {
+	dir := mustTempDir()
+	defer os.RemoveAll(dir)
+	archivePath := filepath.Join(dir, "archive.db")
+
+	db, err := OpenWithAttached(filepath.Join(dir, "db.sqlite"), false, true, map[string]string{"archive": archivePath})
+	if err != nil {
+		t.Fatalf("failed to open database with attached database: %s", err.Error())
+	}
+	defer db.Close()
+	if !fileExists(archivePath) {
+		t.Fatalf("attached database not created")
+	}
+
+	for _, stmt := range []string{
+		"CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)",
+		"CREATE TABLE archive.foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)",
+		`INSERT INTO archive.foo(id, name) VALUES(1, "fiona")`,
+	} {
+		r, err := db.ExecuteStringStmt(stmt)
+		if err != nil || r[0].Error != "" {
+			t.Fatalf("failed to execute %s: %v %v", stmt, err, r)
+		}
+	}
+	q, err := db.QueryStringStmt("SELECT * FROM archive.foo")
+	if err != nil {
+		t.Fatalf("failed to query attached database: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["id","name"],"types":["integer","text"],"values":[[1,"fiona"]]}]`, asJSON(q); exp != got {
+		t.Fatalf("unexpected results for query, expected %s, got %s", exp, got)
+	}
+
+	// A backup of the database does not include the attached database.
+	bkPath := filepath.Join(dir, "backup.db")
+	if err := db.Backup(bkPath); err != nil {
+		t.Fatalf("failed to back up database: %s", err.Error())
+	}
+	bkDB, err := Open(bkPath, false, false)
+	if err != nil {
+		t.Fatalf("failed to open backup: %s", err.Error())
+	}
+	defer bkDB.Close()
+	q, err = bkDB.QueryStringStmt("SELECT * FROM foo")
+	if err != nil {
+		t.Fatalf("failed to query backup: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["id","name"],"types":["integer","text"]}]`, asJSON(q); exp != got {
+		t.Fatalf("unexpected results for query of backup, expected %s, got %s", exp, got)
+	}
+
+	archiveBkPath := filepath.Join(dir, "archive-backup.db")
+	if err := db.BackupAttached("archive", archiveBkPath); err != nil {
+		t.Fatalf("failed to back up attached database: %s", err.Error())
+	}
+	archiveBkDB, err := Open(archiveBkPath, false, false)
+	if err != nil {
+		t.Fatalf("failed to open backup of attached database: %s", err.Error())
+	}
+	defer archiveBkDB.Close()
+	q, err = archiveBkDB.QueryStringStmt("SELECT * FROM foo")
+	if err != nil {
+		t.Fatalf("failed to query backup of attached database: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["id","name"],"types":["integer","text"],"values":[[1,"fiona"]]}]`, asJSON(q); exp != got {
+		t.Fatalf("unexpected results for query of attached backup, expected %s, got %s", exp, got)
+	}
+	if err := db.BackupAttached("other", filepath.Join(dir, "other.db")); err == nil {
+		t.Fatalf("expected error backing up database which is not attached")
+	}
+}
+
+func Test_PoolLimits(t *testing.T) {
+	SetPoolLimits(PoolLimits{MaxReadConns: 2, CacheSize: 512})
+	defer SetPoolLimits(PoolLimits{})
+
+	dir := mustTempDir()
+	defer os.RemoveAll(dir)
+	db, err := Open(filepath.Join(dir, "db.sqlite"), false, true)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err.Error())
+	}
+	defer db.Close()
+
+	if n := db.roDB.Stats().MaxOpenConnections; n != 2 {
+		t.Fatalf("wrong maximum read-only connections, exp 2, got %d", n)
+	}
+	q, err := db.QueryStringStmt("PRAGMA cache_size")
+	if err != nil {
+		t.Fatalf("failed to query cache size: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["cache_size"],"types":["integer"],"values":[[-512]]}]`, asJSON(q); exp != got {
+		t.Fatalf("unexpected results for query, expected %s, got %s", exp, got)
+	}
+	r, err := db.ExecuteStringStmt("CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY)")
+	if err != nil || r[0].Error != "" {
+		t.Fatalf("failed to create table: %v %v", err, r)
+	}
+}
+
 // Test_TableCreationFK ensures foreign key constraints work
 func Test_TableCreationFK(t *testing.T) {
 	createTableFoo := "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)"
@@ -279,6 +411,185 @@ func Test_PartialFailTransaction(t *testing.T) {
 	}
 }
 
+func Test_StopOnError(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+
+	_, err := db.ExecuteStringStmt("CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	if err != nil {
+		t.Fatalf("failed to create table: %s", err.Error())
+	}
+
+	req := &command.Request{
+		StopOnError: true,
+		Statements: []*command.Statement{
+			{
+				Sql: `INSERT INTO foo(id, name) VALUES(1, "fiona")`,
+			},
+			{
+				Sql: `INSERT INTO foo(id, name) VALUES(1, "fiona")`,
+			},
+			{
+				Sql: `INSERT INTO foo(id, name) VALUES(3, "fiona")`,
+			},
+		},
+	}
+	r, err := db.Execute(req, false)
+	if err != nil {
+		t.Fatalf("failed to insert records: %s", err.Error())
+	}
+	if exp, got := `[{"last_insert_id":1,"rows_affected":1},{"error":"UNIQUE constraint failed: foo.id"}]`, asJSON(r); exp != got {
+		t.Fatalf("unexpected results for execute\nexp: %s\ngot: %s", exp, got)
+	}
+
+	req = &command.Request{
+		StopOnError: true,
+		Statements: []*command.Statement{
+			{
+				Sql: `INSERT INTO foo(id, name) VALUES(2, "fiona")`,
+			},
+			{
+				Sql: `SELECT * FROM bar`,
+			},
+			{
+				Sql: `INSERT INTO foo(id, name) VALUES(3, "fiona")`,
+			},
+		},
+	}
+	rr, err := db.Request(req, false)
+	if err != nil {
+		t.Fatalf("failed to request: %s", err.Error())
+	}
+	if exp, got := `[{"last_insert_id":2,"rows_affected":1},{"error":"no such table: bar"}]`, asJSON(rr); exp != got {
+		t.Fatalf("unexpected results for request\nexp: %s\ngot: %s", exp, got)
+	}
+
+	// Changes made before the failing statement remain.
+	ro, err := db.QueryStringStmt(`SELECT * FROM foo`)
+	if err != nil {
+		t.Fatalf("failed to query table: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["id","name"],"types":["integer","text"],"values":[[1,"fiona"],[2,"fiona"]]}]`, asJSON(ro); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+}
+
+func Test_ExecuteRecorded(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+
+	_, err := db.ExecuteStringStmt("CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	if err != nil {
+		t.Fatalf("failed to create table: %s", err.Error())
+	}
+	_, err = db.ExecuteStringStmt("CREATE TABLE log (n INTEGER)")
+	if err != nil {
+		t.Fatalf("failed to create table: %s", err.Error())
+	}
+
+	record := func(n int) ExecuteRecordFunc {
+		return func(results []*command.ExecuteResult) ([]*command.Statement, error) {
+			return []*command.Statement{{Sql: fmt.Sprintf("INSERT INTO log(n) VALUES(%d)", n*len(results))}}, nil
+		}
+	}
+	req := &command.Request{
+		Statements: []*command.Statement{
+			{Sql: `INSERT INTO foo(id, name) VALUES(1, "fiona")`},
+			{Sql: `INSERT INTO foo(id, name) VALUES(1, "fiona")`},
+		},
+	}
+	r, err := db.ExecuteRecorded(req, false, record(1))
+	if err != nil {
+		t.Fatalf("failed to execute: %s", err.Error())
+	}
+	if exp, got := `[{"last_insert_id":1,"rows_affected":1},{"error":"UNIQUE constraint failed: foo.id"}]`, asJSON(r); exp != got {
+		t.Fatalf("unexpected results for execute\nexp: %s\ngot: %s", exp, got)
+	}
+
+	// A failed transaction is not recorded.
+	req.Transaction = true
+	req.Statements[0].Sql = `INSERT INTO foo(id, name) VALUES(2, "fiona")`
+	if _, err := db.ExecuteRecorded(req, false, record(10)); err != nil {
+		t.Fatalf("failed to execute: %s", err.Error())
+	}
+
+	// If the record fails, so does the request.
+	req = &command.Request{
+		Statements: []*command.Statement{
+			{Sql: `INSERT INTO foo(id, name) VALUES(3, "fiona")`},
+		},
+	}
+	_, err = db.ExecuteRecorded(req, false, func(results []*command.ExecuteResult) ([]*command.Statement, error) {
+		return []*command.Statement{{Sql: "INSERT INTO nonexistent(n) VALUES(1)"}}, nil
+	})
+	if err == nil {
+		t.Fatalf("expected error for failed record")
+	}
+	_, err = db.RequestRecorded(req, false, func(results []*command.ExecuteQueryResponse) ([]*command.Statement, error) {
+		return nil, errors.New("record failed")
+	})
+	if err == nil {
+		t.Fatalf("expected error for failed record")
+	}
+
+	rows, err := db.QueryStringStmt(`SELECT id FROM foo`)
+	if err != nil {
+		t.Fatalf("failed to query table: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["id"],"types":["integer"],"values":[[1]]}]`, asJSON(rows); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+	rows, err = db.QueryStringStmt(`SELECT n FROM log`)
+	if err != nil {
+		t.Fatalf("failed to query table: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["n"],"types":["integer"],"values":[[2]]}]`, asJSON(rows); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+}
+
+func Test_RequestTransactionInvalidStatement(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+
+	_, err := db.ExecuteStringStmt("CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	if err != nil {
+		t.Fatalf("failed to create table: %s", err.Error())
+	}
+
+	req := &command.Request{
+		Transaction: true,
+		Statements: []*command.Statement{
+			{
+				Sql: `INSERT INTO foo(id, name) VALUES(1, "fiona")`,
+			},
+			{
+				Sql: `SELECT * FROM bar`,
+			},
+			{
+				Sql: `INSERT INTO foo(id, name) VALUES(2, "fiona")`,
+			},
+		},
+	}
+	r, err := db.Request(req, false)
+	if err != nil {
+		t.Fatalf("failed to request: %s", err.Error())
+	}
+	if exp, got := `[{"last_insert_id":1,"rows_affected":1},{"error":"no such table: bar"}]`, asJSON(r); exp != got {
+		t.Fatalf("unexpected results for request\nexp: %s\ngot: %s", exp, got)
+	}
+	ro, err := db.QueryStringStmt(`SELECT * FROM foo`)
+	if err != nil {
+		t.Fatalf("failed to query table: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["id","name"],"types":["integer","text"]}]`, asJSON(ro); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+}
+
 func Test_IsValidSQLiteOnDisk(t *testing.T) {
 	path := mustTempFile()
 	defer os.Remove(path)
@@ -309,6 +620,50 @@ func Test_IsValidSQLiteOnDisk(t *testing.T) {
 	}
 }
 
+func Test_IsSQLDumpData(t *testing.T) {
+	tests := []struct {
+		data string
+		exp  bool
+	}{
+		{"PRAGMA foreign_keys=OFF;\nBEGIN TRANSACTION;\nCOMMIT;\n", true},
+		{"-- dumped by hand\n/* tables */ create table foo (id integer);", true},
+		{"\ufeffINSERT INTO foo VALUES(1);", true},
+		{"not valid SQLite data", false},
+		{"SELECT * FROM foo;", false},
+		{"-- only a comment", false},
+		{"BEGIN", false},
+		{"", false},
+		{"CREATE\x00TABLE foo (id integer);", false},
+	}
+	for _, tt := range tests {
+		if got := IsSQLDumpData([]byte(tt.data)); got != tt.exp {
+			t.Fatalf("wrong result for %q, exp %v, got %v", tt.data, tt.exp, got)
+		}
+	}
+
+	db, path := mustCreateOnDiskDatabase()
+	defer db.Close()
+	defer os.Remove(path)
+	if IsSQLDumpFile(path) {
+		t.Fatalf("SQLite file marked as SQL dump")
+	}
+	if _, err := db.ExecuteStringStmt("CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)"); err != nil {
+		t.Fatalf("failed to create table: %s", err.Error())
+	}
+	var buf bytes.Buffer
+	if err := db.Dump(&buf); err != nil {
+		t.Fatalf("failed to dump database: %s", err.Error())
+	}
+	dumpPath := mustTempFile()
+	defer os.Remove(dumpPath)
+	if err := os.WriteFile(dumpPath, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("failed to write dump: %s", err.Error())
+	}
+	if !IsSQLDumpFile(dumpPath) {
+		t.Fatalf("dump not marked as SQL dump")
+	}
+}
+
 func Test_CheckIntegrityOnDisk(t *testing.T) {
 	path := mustTempFile()
 	defer os.Remove(path)
@@ -608,6 +963,43 @@ func Test_WALDisableCheckpointing(t *testing.T) {
 	}
 }
 
+// Test_WALPinFiles tests that checkpoints block while the database files are
+// pinned, but writes do not.
+func Test_WALPinFiles(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+
+	if _, err := db.ExecuteStringStmt("CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)"); err != nil {
+		t.Fatalf("failed to create table: %s", err.Error())
+	}
+	unpin := db.PinFiles()
+	if _, err := db.ExecuteStringStmt(`INSERT INTO foo(name) VALUES("fiona")`); err != nil {
+		t.Fatalf("failed to insert record while files pinned: %s", err.Error())
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- db.Checkpoint()
+	}()
+	select {
+	case <-done:
+		t.Fatalf("checkpoint completed while files pinned")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	unpin()
+	unpin() // Unpinning twice is harmless.
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("failed to checkpoint database: %s", err.Error())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("checkpoint did not complete after files unpinned")
+	}
+}
+
 // Test_WALReplayOK tests that WAL files are replayed as expected.
 func Test_WALReplayOK(t *testing.T) {
 	testFunc := func(t *testing.T, replayIntoDelete bool) {
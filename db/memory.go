@@ -0,0 +1,121 @@
+package db
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/rqlite/rqlite/command"
+)
+
+const (
+	// rowOverhead is the approximate memory used by a row of a result set,
+	// excluding its values.
+	rowOverhead = 64
+
+	// valueOverhead is the approximate memory used by a single value in a
+	// result set, excluding any string or byte data.
+	valueOverhead = 32
+)
+
+// ErrQueryMemoryBudget is returned when a query result set would exceed
+// the memory budget.
+var ErrQueryMemoryBudget = errors.New("query result exceeds memory budget")
+
+// MemoryBudget limits the approximate memory used by the result sets of
+// all in-flight queries. Safe for use from multiple goroutines.
+type MemoryBudget struct {
+	limit int64
+
+	mu   sync.Mutex
+	used int64
+	peak int64
+}
+
+// NewMemoryBudget returns a budget allowing limit bytes of result sets
+// to be in memory at once.
+func NewMemoryBudget(limit int64) *MemoryBudget {
+	return &MemoryBudget{limit: limit}
+}
+
+// Used returns the number of bytes currently reserved.
+func (b *MemoryBudget) Used() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+// Stats returns stats on the MemoryBudget.
+func (b *MemoryBudget) Stats() map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return map[string]interface{}{
+		"limit": b.limit,
+		"used":  b.used,
+		"peak":  b.peak,
+	}
+}
+
+func (b *MemoryBudget) reserve(n int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.used+n > b.limit {
+		return false
+	}
+	b.used += n
+	if b.used > b.peak {
+		b.peak = b.used
+	}
+	return true
+}
+
+func (b *MemoryBudget) release(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used -= n
+}
+
+// reservation tracks the memory reserved from a budget while processing a
+// single request, so that it can all be released once the request is done.
+// A reservation with a nil budget always succeeds.
+type reservation struct {
+	b *MemoryBudget
+	n int64
+}
+
+func (r *reservation) reserve(n int64) bool {
+	if r == nil || r.b == nil {
+		return true
+	}
+	if !r.b.reserve(n) {
+		return false
+	}
+	r.n += n
+	return true
+}
+
+func (r *reservation) unreserve(n int64) {
+	if r == nil || r.b == nil {
+		return
+	}
+	r.b.release(n)
+	r.n -= n
+}
+
+func (r *reservation) release() {
+	r.unreserve(r.n)
+}
+
+// rowSize returns the approximate memory used by a row of a result set.
+func rowSize(params []*command.Parameter) int64 {
+	sz := int64(rowOverhead)
+	for _, p := range params {
+		sz += valueOverhead
+		switch v := p.GetValue().(type) {
+		case *command.Parameter_S:
+			sz += int64(len(v.S))
+		case *command.Parameter_Y:
+			sz += int64(len(v.Y))
+		}
+	}
+	return sz
+}
@@ -0,0 +1,143 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/rqlite/rqlite/command"
+)
+
+func Test_MemoryBudget(t *testing.T) {
+	b := NewMemoryBudget(100)
+	if !b.reserve(60) {
+		t.Fatalf("failed to reserve within budget")
+	}
+	if b.reserve(50) {
+		t.Fatalf("reserved beyond budget")
+	}
+	b.release(60)
+	if !b.reserve(100) {
+		t.Fatalf("failed to reserve entire budget")
+	}
+	b.release(100)
+	if got := b.Used(); got != 0 {
+		t.Fatalf("wrong used memory, exp 0, got %d", got)
+	}
+	if got := b.Stats()["peak"]; got != int64(100) {
+		t.Fatalf("wrong peak memory, exp 100, got %v", got)
+	}
+}
+
+func Test_RowSize(t *testing.T) {
+	params := []*command.Parameter{
+		{Value: &command.Parameter_I{I: 1}},
+		{Value: &command.Parameter_S{S: "fiona"}},
+		{Value: &command.Parameter_Y{Y: []byte{1, 2, 3}}},
+	}
+	if got, exp := rowSize(params), int64(rowOverhead+3*valueOverhead+5+3); got != exp {
+		t.Fatalf("wrong row size, exp %d, got %d", exp, got)
+	}
+}
+
+func Test_DBMemoryBudget(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+
+	if _, err := db.ExecuteStringStmt("CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)"); err != nil {
+		t.Fatalf("failed to create table: %s", err.Error())
+	}
+	for i := 0; i < 100; i++ {
+		stmt := fmt.Sprintf(`INSERT INTO foo(name) VALUES("%s")`, strings.Repeat("x", 100))
+		if _, err := db.ExecuteStringStmt(stmt); err != nil {
+			t.Fatalf("failed to insert record: %s", err.Error())
+		}
+	}
+
+	b := NewMemoryBudget(5000)
+	db.SetMemoryBudget(b)
+	if db.MemoryBudget() != b {
+		t.Fatalf("wrong memory budget returned")
+	}
+
+	r, err := db.QueryStringStmt("SELECT * FROM foo LIMIT 10")
+	if err != nil {
+		t.Fatalf("failed to query table: %s", err.Error())
+	}
+	if r[0].Error != "" || len(r[0].Values) != 10 {
+		t.Fatalf("query within budget failed: %v", r[0])
+	}
+
+	r, err = db.QueryStringStmt("SELECT * FROM foo")
+	if err != nil {
+		t.Fatalf("failed to query table: %s", err.Error())
+	}
+	if got, exp := r[0].Error, ErrQueryMemoryBudget.Error(); got != exp {
+		t.Fatalf("wrong error for query exceeding budget, exp %s, got %s", exp, got)
+	}
+
+	resp, err := db.RequestStringStmts([]string{"SELECT * FROM foo"})
+	if err != nil {
+		t.Fatalf("failed to run request: %s", err.Error())
+	}
+	if got, exp := resp[0].GetQ().Error, ErrQueryMemoryBudget.Error(); got != exp {
+		t.Fatalf("wrong error for request exceeding budget, exp %s, got %s", exp, got)
+	}
+
+	// Memory reserved for results is held until released.
+	req := &command.Request{Statements: []*command.Statement{{Sql: "SELECT * FROM foo LIMIT 10"}}}
+	r, release, err := db.QueryReserved(req, false)
+	if err != nil {
+		t.Fatalf("failed to query table: %s", err.Error())
+	}
+	if r[0].Error != "" || len(r[0].Values) != 10 {
+		t.Fatalf("reserved query within budget failed: %v", r[0])
+	}
+	if b.Used() == 0 {
+		t.Fatalf("memory not held by reserved query")
+	}
+	r, err = db.QueryStringStmt("SELECT * FROM foo LIMIT 20")
+	if err != nil {
+		t.Fatalf("failed to query table: %s", err.Error())
+	}
+	if got, exp := r[0].Error, ErrQueryMemoryBudget.Error(); got != exp {
+		t.Fatalf("wrong error for query exceeding held budget, exp %s, got %s", exp, got)
+	}
+	release()
+	r, err = db.QueryStringStmt("SELECT * FROM foo LIMIT 20")
+	if err != nil {
+		t.Fatalf("failed to query table: %s", err.Error())
+	}
+	if r[0].Error != "" || len(r[0].Values) != 20 {
+		t.Fatalf("query within released budget failed: %v", r[0])
+	}
+
+	// Memory reserved for the results of a request is held until released.
+	resp, release, err = db.RequestReserved(req, false)
+	if err != nil {
+		t.Fatalf("failed to run request: %s", err.Error())
+	}
+	if resp[0].GetQ().Error != "" || len(resp[0].GetQ().Values) != 10 {
+		t.Fatalf("reserved request within budget failed: %v", resp[0])
+	}
+	if b.Used() == 0 {
+		t.Fatalf("memory not held by reserved request")
+	}
+	release()
+
+	// All memory is released once each query completes.
+	if got := b.Used(); got != 0 {
+		t.Fatalf("memory not released after queries, %d bytes still used", got)
+	}
+
+	db.SetMemoryBudget(nil)
+	r, err = db.QueryStringStmt("SELECT * FROM foo")
+	if err != nil {
+		t.Fatalf("failed to query table: %s", err.Error())
+	}
+	if r[0].Error != "" || len(r[0].Values) != 100 {
+		t.Fatalf("query without budget failed: %v", r[0])
+	}
+}
@@ -0,0 +1,241 @@
+package db
+
+import (
+	"strings"
+
+	"github.com/rqlite/rqlite/command"
+	sqlparser "github.com/rqlite/sql"
+)
+
+// tableColumn is a column of a table, as PRAGMA table_info reports it.
+type tableColumn struct {
+	name     string
+	declType string
+	notNull  bool
+	pk       bool
+}
+
+// metadataSource is a table, or other source of rows, in the FROM clause of
+// a query.
+type metadataSource struct {
+	table string
+	alias string
+
+	// columns are the columns of the table, or nil if they are not known,
+	// such as for a subquery.
+	columns []tableColumn
+
+	// outer is whether the source is the right side of a LEFT JOIN, so any
+	// of its columns may be NULL.
+	outer bool
+}
+
+// ColumnMetadata returns metadata for each of the result columns of the
+// query stmt, which returned the given columns. The metadata of a column
+// read directly from a table gives the table, the name and declared type of
+// the column, whether it may be NULL, and whether it is part of the primary
+// key. The table of columns which are expressions, or which cannot be
+// resolved, is empty. Result columns are resolved by parsing the query, so
+// only simple SELECT statements, without compound SELECTs or common table
+// expressions, have their columns resolved.
+func (db *DB) ColumnMetadata(stmt string, columns []string) []*command.ColumnMetadata {
+	md := make([]*command.ColumnMetadata, len(columns))
+	for i := range md {
+		md[i] = &command.ColumnMetadata{}
+	}
+
+	s, err := sqlparser.NewParser(strings.NewReader(stmt)).ParseStatement()
+	if err != nil {
+		return md
+	}
+	sel, ok := s.(*sqlparser.SelectStatement)
+	if !ok || sel.Compound != nil || sel.WithClause != nil || sel.Source == nil {
+		return md
+	}
+
+	var sources []*metadataSource
+	if !db.metadataSources(sel.Source, false, &sources) {
+		return md
+	}
+
+	// Each entry is the source and index of the table column of a result
+	// column, or nil if it is not read directly from a table.
+	type origin struct {
+		src *metadataSource
+		col int
+	}
+	var origins []*origin
+	for _, rc := range sel.Columns {
+		if rc.Star.IsValid() {
+			// A bare *, which SQLite expands to every column of every
+			// source, in order.
+			for _, src := range sources {
+				if src.columns == nil {
+					return md
+				}
+				for j := range src.columns {
+					origins = append(origins, &origin{src, j})
+				}
+			}
+			continue
+		}
+		switch expr := rc.Expr.(type) {
+		case *sqlparser.QualifiedRef:
+			src := findSource(sources, sqlparser.IdentName(expr.Table))
+			if expr.Star.IsValid() {
+				if src == nil || src.columns == nil {
+					return md
+				}
+				for j := range src.columns {
+					origins = append(origins, &origin{src, j})
+				}
+				continue
+			}
+			if j := findColumn(src, sqlparser.IdentName(expr.Column)); j >= 0 {
+				origins = append(origins, &origin{src, j})
+			} else {
+				origins = append(origins, nil)
+			}
+		case *sqlparser.Ident:
+			var o *origin
+			for _, src := range sources {
+				if j := findColumn(src, expr.Name); j >= 0 {
+					if o != nil {
+						// Ambiguous, which SQLite would have rejected.
+						o = nil
+						break
+					}
+					o = &origin{src, j}
+				}
+			}
+			origins = append(origins, o)
+		default:
+			origins = append(origins, nil)
+		}
+	}
+	if len(origins) != len(columns) {
+		return md
+	}
+
+	for i, o := range origins {
+		if o == nil {
+			continue
+		}
+		tc := o.src.columns[o.col]
+		md[i].Table = o.src.table
+		md[i].Column = tc.name
+		md[i].DeclType = tc.declType
+		md[i].PrimaryKey = tc.pk
+		md[i].NotNull = tc.notNull && !o.src.outer
+	}
+	return md
+}
+
+// metadataSources appends the sources of src to sources, in the order SQLite
+// expands * over them. It returns false if the result columns of src cannot
+// be resolved, such as when a NATURAL join or USING clause would remove
+// columns from the expansion of *.
+func (db *DB) metadataSources(src sqlparser.Source, outer bool, sources *[]*metadataSource) bool {
+	switch s := src.(type) {
+	case *sqlparser.QualifiedTableName:
+		*sources = append(*sources, &metadataSource{
+			table:   sqlparser.IdentName(s.Name),
+			alias:   sqlparser.IdentName(s.Alias),
+			columns: db.tableColumns(sqlparser.IdentName(s.Name)),
+			outer:   outer,
+		})
+	case *sqlparser.JoinClause:
+		if s.Operator != nil && s.Operator.Natural.IsValid() {
+			return false
+		}
+		if _, ok := s.Constraint.(*sqlparser.UsingConstraint); ok {
+			return false
+		}
+		if !db.metadataSources(s.X, outer, sources) {
+			return false
+		}
+		left := s.Operator != nil && s.Operator.Left.IsValid()
+		return db.metadataSources(s.Y, outer || left, sources)
+	case *sqlparser.ParenSource:
+		if _, ok := s.X.(*sqlparser.SelectStatement); ok {
+			*sources = append(*sources, &metadataSource{
+				alias: sqlparser.IdentName(s.Alias),
+				outer: outer,
+			})
+			return true
+		}
+		return db.metadataSources(s.X, outer, sources)
+	default:
+		return false
+	}
+	return true
+}
+
+// tableColumns returns the columns of the table or view, or nil if there is
+// no such table.
+func (db *DB) tableColumns(table string) []tableColumn {
+	rows, err := db.roDB.Query(`SELECT name, type, "notnull", pk FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	var cols []tableColumn
+	numPK := 0
+	for rows.Next() {
+		var c tableColumn
+		var notNull, pk int
+		if err := rows.Scan(&c.name, &c.declType, &notNull, &pk); err != nil {
+			return nil
+		}
+		c.notNull = notNull != 0
+		c.pk = pk != 0
+		if c.pk {
+			numPK++
+		}
+		cols = append(cols, c)
+	}
+	if rows.Err() != nil {
+		return nil
+	}
+
+	// An INTEGER PRIMARY KEY is the rowid, which is never NULL, though
+	// PRAGMA table_info does not report it as NOT NULL.
+	if numPK == 1 {
+		for i := range cols {
+			if cols[i].pk && strings.EqualFold(cols[i].declType, "INTEGER") {
+				cols[i].notNull = true
+			}
+		}
+	}
+	return cols
+}
+
+// findSource returns the source with the given alias or, if it has no alias,
+// table name. It returns nil if there is none.
+func findSource(sources []*metadataSource, name string) *metadataSource {
+	for _, src := range sources {
+		n := src.alias
+		if n == "" {
+			n = src.table
+		}
+		if strings.EqualFold(n, name) {
+			return src
+		}
+	}
+	return nil
+}
+
+// findColumn returns the index of the named column of the source, or -1 if
+// the source, or its columns, are not known, or it has no such column.
+func findColumn(src *metadataSource, name string) int {
+	if src == nil {
+		return -1
+	}
+	for i, c := range src.columns {
+		if strings.EqualFold(c.name, name) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,82 @@
+package db
+
+import (
+	"os"
+	"testing"
+
+	"github.com/rqlite/rqlite/command"
+)
+
+func Test_ColumnMetadata(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+	mustExecute(db, `CREATE TABLE foo (id INTEGER PRIMARY KEY, name VARCHAR(64) NOT NULL, age INT)`)
+	mustExecute(db, `CREATE TABLE bar (foo_id INTEGER NOT NULL, tag TEXT, PRIMARY KEY (foo_id, tag))`)
+
+	md := func(table, column, declType string, notNull, pk bool) *command.ColumnMetadata {
+		return &command.ColumnMetadata{Table: table, Column: column, DeclType: declType, NotNull: notNull, PrimaryKey: pk}
+	}
+	id := md("foo", "id", "INTEGER", true, true)
+	name := md("foo", "name", "VARCHAR(64)", true, false)
+	age := md("foo", "age", "INT", false, false)
+	unknown := &command.ColumnMetadata{}
+
+	for _, tt := range []struct {
+		stmt    string
+		columns []string
+		exp     []*command.ColumnMetadata
+	}{
+		{
+			stmt:    `SELECT * FROM foo`,
+			columns: []string{"id", "name", "age"},
+			exp:     []*command.ColumnMetadata{id, name, age},
+		},
+		{
+			stmt:    `SELECT age AS years, f.name, COUNT(*), id + 1 FROM foo AS f`,
+			columns: []string{"years", "name", "COUNT(*)", "id + 1"},
+			exp:     []*command.ColumnMetadata{age, name, unknown, unknown},
+		},
+		{
+			stmt:    `SELECT f.id, b.* FROM foo f LEFT JOIN bar b ON b.foo_id = f.id`,
+			columns: []string{"id", "foo_id", "tag"},
+			exp: []*command.ColumnMetadata{
+				id,
+				md("bar", "foo_id", "INTEGER", false, true),
+				md("bar", "tag", "TEXT", false, true),
+			},
+		},
+		{
+			stmt:    `SELECT tag, name FROM bar JOIN foo ON foo.id = bar.foo_id`,
+			columns: []string{"tag", "name"},
+			exp:     []*command.ColumnMetadata{md("bar", "tag", "TEXT", false, true), name},
+		},
+		{
+			stmt:    `SELECT * FROM foo, (SELECT 1 AS x)`,
+			columns: []string{"id", "name", "age", "x"},
+			exp:     []*command.ColumnMetadata{unknown, unknown, unknown, unknown},
+		},
+		{
+			stmt:    `SELECT id FROM foo UNION SELECT foo_id FROM bar`,
+			columns: []string{"id"},
+			exp:     []*command.ColumnMetadata{unknown},
+		},
+		{
+			stmt:    `SELECT * FROM nonexistent`,
+			columns: []string{"a"},
+			exp:     []*command.ColumnMetadata{unknown},
+		},
+	} {
+		got := db.ColumnMetadata(tt.stmt, tt.columns)
+		if len(got) != len(tt.exp) {
+			t.Fatalf("%s: wrong number of columns: %d", tt.stmt, len(got))
+		}
+		for i := range got {
+			g, e := got[i], tt.exp[i]
+			if g.Table != e.Table || g.Column != e.Column || g.DeclType != e.DeclType ||
+				g.NotNull != e.NotNull || g.PrimaryKey != e.PrimaryKey {
+				t.Fatalf("%s: wrong metadata for column %d, exp %v, got %v", tt.stmt, i, e, g)
+			}
+		}
+	}
+}
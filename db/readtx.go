@@ -0,0 +1,112 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+
+	"github.com/rqlite/rqlite/command"
+)
+
+// ReadTx is a read-only transaction, held open across any number of
+// queries. Every query executed in it reads the database as it was when
+// the transaction began, whatever is written meanwhile. A ReadTx is not
+// safe for concurrent use.
+type ReadTx struct {
+	db    *DB
+	conn  *sql.Conn
+	tx    *sql.Tx
+	reads map[string]bool
+}
+
+// BeginRead begins a read-only transaction. The ReadTx holds a connection
+// to the database until it is closed.
+func (db *DB) BeginRead() (rtx *ReadTx, retErr error) {
+	stats.Add(numReadTxs, 1)
+	conn, err := db.roDB.Conn(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if retErr != nil {
+			conn.Close()
+		}
+	}()
+
+	tx, err := conn.BeginTx(context.Background(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// The database is only read once a query reads it, so read it now, so
+	// that the transaction sees the database as it is when begun.
+	var n int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM sqlite_master").Scan(&n); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return &ReadTx{db: db, conn: conn, tx: tx, reads: make(map[string]bool)}, nil
+}
+
+// Query executes queries that return rows, but do not modify the database,
+// within the transaction. The memory reserved from the budget for the
+// results is held until the returned release func is called. The caller must
+// call release, which is never nil, once it has finished with the results.
+func (t *ReadTx) Query(req *command.Request, xTime bool) ([]*command.QueryRows, func(), error) {
+	stats.Add(numQueries, int64(len(req.Statements)))
+	res := &reservation{b: t.db.budget}
+	c := &rowsCollector{res: res}
+	err := t.db.streamStmts(req.Statements, xTime, t.conn, t.tx, c, tracked, t.reads)
+	return c.all, res.release, err
+}
+
+// QueryAfter executes writes, and then the queries of req, in a transaction
+// on the read-write connection, which is then rolled back, so that the
+// queries see the changes the writes would make. Unlike those executed by
+// Query, these queries read the database as it is now, not as it was when
+// the transaction began, and nothing else may write to the database until
+// they are done. If a write fails, its error is returned, and no query is
+// executed. The caller must call release, which is never nil, once it has
+// finished with the results, as for Query.
+func (t *ReadTx) QueryAfter(writes []*command.Statement, req *command.Request, xTime bool) ([]*command.QueryRows, func(), error) {
+	stats.Add(numQueries, int64(len(req.Statements)))
+	conn, err := t.db.rwDB.Conn(context.Background())
+	if err != nil {
+		return nil, func() {}, err
+	}
+	defer conn.Close()
+	tx, err := conn.BeginTx(context.Background(), nil)
+	if err != nil {
+		return nil, func() {}, err
+	}
+	defer tx.Rollback()
+
+	if err := t.db.executeRecord(writes, tx); err != nil {
+		return nil, func() {}, err
+	}
+	res := &reservation{b: t.db.budget}
+	c := &rowsCollector{res: res}
+	err = t.db.streamStmts(req.Statements, xTime, conn, tx, c, trackedWritable, t.reads)
+	return c.all, res.release, err
+}
+
+// Reads returns the tables read by the queries of the transaction so far,
+// sorted by name. Tables of attached databases are named by schema and
+// table, such as "archive.foo".
+func (t *ReadTx) Reads() []string {
+	tables := make([]string, 0, len(t.reads))
+	for name := range t.reads {
+		tables = append(tables, name)
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+// Close ends the transaction, releasing its connection to the database.
+func (t *ReadTx) Close() error {
+	if err := t.tx.Rollback(); err != nil {
+		t.conn.Close()
+		return err
+	}
+	return t.conn.Close()
+}
@@ -0,0 +1,90 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/rqlite/rqlite/command"
+)
+
+func Test_ReadTx(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+	mustExecute(db, `CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`)
+	mustExecute(db, `INSERT INTO foo(id, name) VALUES(1, 'fiona')`)
+
+	rtx, err := db.BeginRead()
+	if err != nil {
+		t.Fatalf("failed to begin read transaction: %s", err)
+	}
+
+	// Changes made after the transaction began are not seen by it.
+	mustExecute(db, `INSERT INTO foo(id, name) VALUES(2, 'declan')`)
+
+	req := &command.Request{
+		Statements: []*command.Statement{
+			{Sql: `SELECT COUNT(*) FROM foo`},
+			{Sql: `SELECT name FROM foo WHERE id = ?`, Parameters: []*command.Parameter{{Value: &command.Parameter_I{I: 1}}}},
+			{Sql: `INSERT INTO foo(id, name) VALUES(3, 'aoife')`},
+		},
+	}
+	rows, release, err := rtx.Query(req, false)
+	if err != nil {
+		t.Fatalf("failed to query in read transaction: %s", err)
+	}
+	release()
+	if exp, got := `[{"columns":["COUNT(*)"],"types":["integer"],"values":[[1]]},{"columns":["name"],"types":["text"],"values":[["fiona"]]},{"error":"attempt to change database via query operation"}]`, asJSON(rows); exp != got {
+		t.Fatalf("wrong results\nexp: %s\ngot: %s", exp, got)
+	}
+	if exp, got := "[foo]", fmt.Sprint(rtx.Reads()); exp != got {
+		t.Fatalf("wrong tables read, exp %s, got %s", exp, got)
+	}
+
+	// Queries executed after writes see the changes the writes would make,
+	// to the database as it is now, but the writes are not kept.
+	mustExecute(db, `CREATE TABLE bar (id INTEGER NOT NULL PRIMARY KEY)`)
+	writes := []*command.Statement{
+		{Sql: `INSERT INTO foo(id, name) VALUES(3, 'aoife')`},
+		{Sql: `INSERT INTO bar(id) VALUES(1)`},
+	}
+	req = &command.Request{
+		Statements: []*command.Statement{
+			{Sql: `SELECT COUNT(*) FROM foo`},
+			{Sql: `SELECT COUNT(*) FROM bar`},
+		},
+	}
+	rows, release, err = rtx.QueryAfter(writes, req, false)
+	if err != nil {
+		t.Fatalf("failed to query after writes in read transaction: %s", err)
+	}
+	release()
+	if exp, got := `[{"columns":["COUNT(*)"],"types":["integer"],"values":[[3]]},{"columns":["COUNT(*)"],"types":["integer"],"values":[[1]]}]`, asJSON(rows); exp != got {
+		t.Fatalf("wrong results after writes\nexp: %s\ngot: %s", exp, got)
+	}
+	if exp, got := "[bar foo]", fmt.Sprint(rtx.Reads()); exp != got {
+		t.Fatalf("wrong tables read after writes, exp %s, got %s", exp, got)
+	}
+	if _, _, err := rtx.QueryAfter([]*command.Statement{{Sql: `INSERT INTO nonsense VALUES(1)`}}, req, false); err == nil {
+		t.Fatalf("expected error for failed write")
+	}
+	if err := rtx.Close(); err != nil {
+		t.Fatalf("failed to close read transaction: %s", err)
+	}
+	r, err := db.QueryStringStmt(`SELECT COUNT(*) FROM bar`)
+	if err != nil {
+		t.Fatalf("failed to query: %s", err)
+	}
+	if exp, got := `[{"columns":["COUNT(*)"],"types":["integer"],"values":[[0]]}]`, asJSON(r); exp != got {
+		t.Fatalf("writes kept after query\nexp: %s\ngot: %s", exp, got)
+	}
+
+	r, err = db.QueryStringStmt(`SELECT COUNT(*) FROM foo`)
+	if err != nil {
+		t.Fatalf("failed to query: %s", err)
+	}
+	if exp, got := `[{"columns":["COUNT(*)"],"types":["integer"],"values":[[2]]}]`, asJSON(r); exp != got {
+		t.Fatalf("wrong results after read transaction\nexp: %s\ngot: %s", exp, got)
+	}
+}
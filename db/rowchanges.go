@@ -0,0 +1,122 @@
+package db
+
+import (
+	"context"
+	"strings"
+
+	"github.com/rqlite/go-sqlite3"
+)
+
+// Operations which change a row.
+const (
+	RowInsert = "insert"
+	RowUpdate = "update"
+	RowDelete = "delete"
+)
+
+// RowChange is a change to a row of a table in the main database.
+type RowChange struct {
+	Table string
+	Op    string
+	RowID int64
+}
+
+// RowChangeHook is called with the rows changed by each transaction committed
+// through the read-write connection, in the order they were changed, and
+// with the tables of the main database the transaction may have written. If
+// the transaction changed more rows than the hook was registered to receive,
+// only that many are passed, and complete is false. The hook is called while
+// the transaction commits, so it must not block, nor use the database.
+type RowChangeHook func(changes []RowChange, tables []string, complete bool)
+
+// RegisterRowChangeHook registers hook to be called with the rows changed by
+// each committed transaction, up to max of them. Rows changed by transactions
+// which are rolled back are never passed to it. As SQLite reports them, rows
+// of WITHOUT ROWID tables, and rows deleted by a DELETE without a WHERE
+// clause, are not included, but their tables are among those written, which
+// are all the tables SQLite reports the statements of the transaction may
+// write when they are compiled. A nil hook unregisters any registered. The
+// hook remains registered until the database is closed.
+func (db *DB) RegisterRowChangeHook(hook RowChangeHook, max int) error {
+	conn, err := db.rwDB.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn interface{}) error {
+		c := driverConn.(*sqlite3.SQLiteConn)
+		if hook == nil {
+			db.rwAuthorizer = nil
+			c.RegisterAuthorizer(nil)
+			c.RegisterUpdateHook(nil)
+			c.RegisterCommitHook(nil)
+			c.RegisterRollbackHook(nil)
+			return nil
+		}
+
+		var pending []RowChange
+		var tables []string
+		written := make(map[string]bool)
+		complete := true
+		db.rwAuthorizer = func(code int, arg1, arg2, arg3 string) int {
+			schema, table := writtenTable(code, arg1, arg2, arg3)
+			if table == "" || schema != "main" || strings.HasPrefix(strings.ToLower(table), "sqlite_") {
+				return sqlite3.SQLITE_OK
+			}
+			if !written[table] {
+				written[table] = true
+				tables = append(tables, table)
+			}
+			return sqlite3.SQLITE_OK
+		}
+		c.RegisterAuthorizer(db.rwAuthorizer)
+		c.RegisterUpdateHook(func(op int, database, table string, rowid int64) {
+			if database != "main" {
+				return
+			}
+			if len(pending) >= max {
+				complete = false
+				return
+			}
+			pending = append(pending, RowChange{Table: table, Op: rowOp(op), RowID: rowid})
+		})
+		c.RegisterCommitHook(func() int {
+			if len(pending) > 0 || len(tables) > 0 || !complete {
+				hook(pending, tables, complete)
+			}
+			pending, tables, complete = nil, nil, true
+			written = make(map[string]bool)
+			return 0
+		})
+		c.RegisterRollbackHook(func() {
+			pending, tables, complete = nil, nil, true
+			written = make(map[string]bool)
+		})
+		return nil
+	})
+}
+
+// writtenTable returns the schema and name of the table written by the
+// action SQLite authorizes with the given code and arguments, or an empty
+// name if the action writes no table.
+func writtenTable(code int, arg1, arg2, arg3 string) (string, string) {
+	switch code {
+	case sqlite3.SQLITE_INSERT, sqlite3.SQLITE_UPDATE, sqlite3.SQLITE_DELETE, sqlite3.SQLITE_DROP_TABLE:
+		return arg3, arg1
+	case sqlite3.SQLITE_ALTER_TABLE:
+		return arg1, arg2
+	}
+	return "", ""
+}
+
+func rowOp(op int) string {
+	switch op {
+	case sqlite3.SQLITE_INSERT:
+		return RowInsert
+	case sqlite3.SQLITE_UPDATE:
+		return RowUpdate
+	default:
+		return RowDelete
+	}
+}
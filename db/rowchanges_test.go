@@ -0,0 +1,72 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/rqlite/rqlite/command"
+)
+
+func Test_RowChangeHook(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+	mustExecute(db, `CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`)
+	mustExecute(db, `CREATE TABLE bar (id INTEGER NOT NULL PRIMARY KEY) WITHOUT ROWID`)
+
+	var got []string
+	if err := db.RegisterRowChangeHook(func(changes []RowChange, tables []string, complete bool) {
+		got = append(got, fmt.Sprintf("%v %v %v", changes, tables, complete))
+	}, 2); err != nil {
+		t.Fatalf("failed to register row change hook: %s", err)
+	}
+
+	mustExecute(db, `INSERT INTO foo(id, name) VALUES(1, 'fiona')`)
+	mustExecute(db, `UPDATE foo SET name = 'declan' WHERE id = 1`)
+
+	// Changes rolled back are not passed to the hook.
+	req := &command.Request{
+		Transaction: true,
+		Statements: []*command.Statement{
+			{Sql: `INSERT INTO foo(id, name) VALUES(2, 'aoife')`},
+			{Sql: `INSERT INTO nonsense VALUES(1)`},
+		},
+	}
+	if _, err := db.Execute(req, false); err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+
+	// Only as many changes as requested are passed.
+	mustExecute(db, `INSERT INTO foo(id, name) SELECT id+10, name FROM foo UNION SELECT 20, 'x' UNION SELECT 21, 'y'`)
+	mustExecute(db, `DELETE FROM foo WHERE id = 1`)
+
+	// Tables whose changed rows SQLite does not report are still written.
+	mustExecute(db, `INSERT INTO bar(id) VALUES(1)`)
+	if _, err := db.RequestStringStmts([]string{`SELECT * FROM bar`}); err != nil {
+		t.Fatalf("failed to request: %s", err)
+	}
+	mustExecute(db, `DELETE FROM bar`)
+	mustExecute(db, `ALTER TABLE bar ADD COLUMN name TEXT`)
+
+	exp := []string{
+		"[{foo insert 1}] [foo] true",
+		"[{foo update 1}] [foo] true",
+		"[{foo insert 11} {foo insert 20}] [foo] false",
+		"[{foo delete 1}] [foo] true",
+		"[] [bar] true",
+		"[] [bar] true",
+		"[] [bar] true",
+	}
+	if fmt.Sprint(exp) != fmt.Sprint(got) {
+		t.Fatalf("wrong row changes\nexp: %v\ngot: %v", exp, got)
+	}
+
+	if err := db.RegisterRowChangeHook(nil, 0); err != nil {
+		t.Fatalf("failed to unregister row change hook: %s", err)
+	}
+	mustExecute(db, `DELETE FROM foo WHERE id = 11`)
+	if len(got) != len(exp) {
+		t.Fatalf("row change hook called once unregistered")
+	}
+}
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/rqlite/go-sqlite3"
+	"github.com/rqlite/rqlite/command"
+)
+
+// sqliteRecursive is the authorizer action code of a recursive CTE, which
+// the driver does not export.
+const sqliteRecursive = 33
+
+// sandboxPragmas are the pragmas which may be run in a sandbox. None of
+// them change the database or the connection.
+var sandboxPragmas = map[string]bool{
+	"collation_list":    true,
+	"compile_options":   true,
+	"database_list":     true,
+	"foreign_key_check": true,
+	"foreign_key_list":  true,
+	"freelist_count":    true,
+	"function_list":     true,
+	"index_info":        true,
+	"index_list":        true,
+	"index_xinfo":       true,
+	"integrity_check":   true,
+	"module_list":       true,
+	"page_count":        true,
+	"pragma_list":       true,
+	"quick_check":       true,
+	"table_info":        true,
+	"table_list":        true,
+	"table_xinfo":       true,
+}
+
+// sandboxAuthorizer allows only the actions needed to read the database,
+// denying everything else.
+func sandboxAuthorizer(action int, arg1, arg2, arg3 string) int {
+	switch action {
+	case sqlite3.SQLITE_SELECT, sqlite3.SQLITE_READ, sqlite3.SQLITE_FUNCTION, sqliteRecursive,
+		sqlite3.SQLITE_TRANSACTION, sqlite3.SQLITE_SAVEPOINT:
+		return sqlite3.SQLITE_OK
+	case sqlite3.SQLITE_PRAGMA:
+		if sandboxPragmas[arg1] {
+			return sqlite3.SQLITE_OK
+		}
+	}
+	return sqlite3.SQLITE_DENY
+}
+
+// sandboxConn sandboxes conn, so that it can only read. Writes to the
+// database are prevented by the query_only pragma, and everything else
+// which is not a read, such as changing pragmas or attaching databases, is
+// denied by the authorizer.
+func sandboxConn(conn *sqlite3.SQLiteConn) error {
+	if _, err := conn.Exec("PRAGMA query_only=1", nil); err != nil {
+		return err
+	}
+	conn.RegisterAuthorizer(sandboxAuthorizer)
+	return nil
+}
+
+// QuerySandboxed executes queries as QueryReserved does, but on a sandboxed
+// connection which can only read the database. Any statement which would
+// do anything else fails as not authorized. The caller must call release,
+// which is never nil, once it has finished with the results.
+func (db *DB) QuerySandboxed(req *command.Request, xTime bool) ([]*command.QueryRows, func(), error) {
+	stats.Add(numSandboxQueries, int64(len(req.Statements)))
+	sbDB, err := db.sandboxDB()
+	if err != nil {
+		return nil, func() {}, err
+	}
+	conn, err := sbDB.Conn(context.Background())
+	if err != nil {
+		return nil, func() {}, err
+	}
+	defer conn.Close()
+	res := &reservation{b: db.budget}
+	rows, err := db.queryWithConn(req, xTime, conn, res, trackedSandboxed)
+	return rows, res.release, err
+}
+
+// sandboxDB returns the pool of sandboxed connections, opening it if this
+// is its first use.
+func (db *DB) sandboxDB() (*sql.DB, error) {
+	db.sbMu.Lock()
+	defer db.sbMu.Unlock()
+	if db.sbDB != nil {
+		return db.sbDB, nil
+	}
+	driverName := registerDriver(db.attached, getConnectHook(), true, true)
+	sbDB, err := sql.Open(driverName, db.roDSN)
+	if err != nil {
+		return nil, err
+	}
+	sbDB.SetConnMaxIdleTime(30 * time.Second)
+	sbDB.SetConnMaxLifetime(0)
+	db.sbDB = sbDB
+	return sbDB, nil
+}
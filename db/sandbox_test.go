@@ -0,0 +1,74 @@
+package db
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/rqlite/rqlite/command"
+)
+
+func Test_QuerySandboxed(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+	mustExecute(db, `CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`)
+	mustExecute(db, `INSERT INTO foo(id, name) VALUES(1, 'fiona')`)
+
+	for _, stmt := range []string{
+		`SELECT * FROM foo`,
+		`WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x+1 FROM c WHERE x < 3) SELECT * FROM c`,
+		`PRAGMA table_info(foo)`,
+		`SELECT name FROM sqlite_master`,
+	} {
+		rows, _, err := db.QuerySandboxed(sandboxRequest(stmt), false)
+		if err != nil {
+			t.Fatalf("failed to query %q: %s", stmt, err)
+		}
+		if rows[0].Error != "" {
+			t.Fatalf("query %q failed: %s", stmt, rows[0].Error)
+		}
+	}
+
+	for _, stmt := range []string{
+		`INSERT INTO foo(id, name) VALUES(2, 'declan')`,
+		`UPDATE foo SET name = 'declan'`,
+		`DELETE FROM foo`,
+		`CREATE TABLE bar (id INTEGER)`,
+		`CREATE TEMP TABLE bar (id INTEGER)`,
+		`DROP TABLE foo`,
+		`PRAGMA query_only=0`,
+		`PRAGMA user_version=5`,
+		`ATTACH DATABASE ':memory:' AS other`,
+	} {
+		rows, _, err := db.QuerySandboxed(sandboxRequest(stmt), false)
+		if err != nil {
+			continue
+		}
+		if rows[0].Error == "" {
+			t.Fatalf("statement %q was not rejected by the sandbox", stmt)
+		}
+		if !strings.Contains(rows[0].Error, "not authorized") && !strings.Contains(rows[0].Error, "readonly") {
+			t.Fatalf("unexpected error for %q: %s", stmt, rows[0].Error)
+		}
+	}
+
+	// Nothing was changed, and the connection used for ordinary queries
+	// is not sandboxed.
+	rows, err := db.QueryStringStmt(`SELECT COUNT(*) FROM foo`)
+	if err != nil {
+		t.Fatalf("failed to query: %s", err)
+	}
+	if exp, got := `[{"columns":["COUNT(*)"],"types":["integer"],"values":[[1]]}]`, asJSON(rows); exp != got {
+		t.Fatalf("unexpected results, exp %s, got %s", exp, got)
+	}
+	if _, err := db.ExecuteStringStmt(`INSERT INTO foo(id, name) VALUES(2, 'declan')`); err != nil {
+		t.Fatalf("failed to insert: %s", err)
+	}
+}
+
+func sandboxRequest(stmt string) *command.Request {
+	return &command.Request{
+		Statements: []*command.Statement{{Sql: stmt}},
+	}
+}
@@ -0,0 +1,260 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/rqlite/rqlite/command"
+)
+
+// RowsWriter receives the results of queries as they are read from the
+// database, rather than once all have been read. For each statement,
+// WriteColumns is called at most once, before any call to WriteRow, and
+// EndStatement is called exactly once, after all calls to WriteRow. If any
+// method returns an error, the query is abandoned and that error returned.
+type RowsWriter interface {
+	// WriteColumns is called with the columns of the results of a statement,
+	// and their types.
+	WriteColumns(columns, types []string) error
+
+	// WriteRow is called with each row of the results of a statement.
+	WriteRow(values *command.Values) error
+
+	// EndStatement is called at the end of the results of a statement, with
+	// any error which occurred, and the time taken if timings were requested.
+	EndStatement(errMsg string, t float64) error
+}
+
+// QueryStream executes queries that return rows, but don't modify the
+// database, writing the results to w as they are read. Since results are not
+// held in memory, they are not subject to the memory budget.
+func (db *DB) QueryStream(req *command.Request, xTime bool, w RowsWriter) error {
+	stats.Add(numQueries, int64(len(req.Statements)))
+	conn, err := db.roDB.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return db.streamWithConn(req, xTime, conn, w, tracked)
+}
+
+// streamWithConn executes the queries of req using conn, writing the results
+// to w, and counting their reads of tables as track says.
+func (db *DB) streamWithConn(req *command.Request, xTime bool, conn *sql.Conn, w RowsWriter, track readTracking) error {
+	var err error
+
+	var queryer queryer
+	var tx *sql.Tx
+	if req.Transaction {
+		stats.Add(numQTx, 1)
+		tx, err = conn.BeginTx(context.Background(), nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback() // Will be ignored if tx is committed
+		queryer = tx
+	} else {
+		queryer = conn
+	}
+
+	if err := db.streamStmts(req.Statements, xTime, conn, queryer, w, track, nil); err != nil {
+		return err
+	}
+	if tx != nil {
+		return tx.Commit()
+	}
+	return nil
+}
+
+// streamStmts executes each of stmts, which must not modify the database,
+// through q, writing the results to w. conn is the connection underlying q.
+// Reads of tables are counted as track says, and if reads is not nil, the
+// tables read by tracked statements are added to it.
+func (db *DB) streamStmts(stmts []*command.Statement, xTime bool, conn *sql.Conn, q queryer, w RowsWriter,
+	track readTracking, reads map[string]bool) error {
+	for _, stmt := range stmts {
+		sql := stmt.Sql
+		if sql == "" {
+			continue
+		}
+
+		readOnly, tables, err := db.stmtTablesWithConn(sql, conn, track)
+		if err != nil {
+			stats.Add(numQueryErrors, 1)
+			if err := w.EndStatement(err.Error(), 0); err != nil {
+				return err
+			}
+			continue
+		}
+		if !readOnly {
+			stats.Add(numQueryErrors, 1)
+			if err := w.EndStatement("attempt to change database via query operation", 0); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if reads != nil {
+			for _, t := range tables {
+				reads[t] = true
+			}
+		}
+
+		rc := &rowCounter{RowsWriter: w}
+		err = db.streamStmtWithConn(stmt, xTime, q, rc)
+		db.recordReads(tables, rc.n)
+		if err != nil {
+			stats.Add(numQueryErrors, 1)
+			if err == ErrQueryMemoryBudget {
+				stats.Add(numMemoryRejected, 1)
+			}
+			if err := w.EndStatement(err.Error(), 0); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// streamStmtWithConn executes a single query statement, writing the results
+// to w. Errors reported by the database while reading rows are passed to
+// w.EndStatement. Any other error is returned, in which case w.EndStatement
+// has not been called.
+func (db *DB) streamStmtWithConn(stmt *command.Statement, xTime bool, q queryer, w RowsWriter) error {
+	start := time.Now()
+
+	if len(stmt.ParamSets) > 0 {
+		stats.Add(numQueryErrors, 1)
+		return w.EndStatement(ErrParamSetsQuery.Error(), 0)
+	}
+	parameters, err := parametersToValues(stmt.Parameters)
+	if err != nil {
+		stats.Add(numQueryErrors, 1)
+		return w.EndStatement(err.Error(), 0)
+	}
+
+	rs, err := q.QueryContext(context.Background(), stmt.Sql, parameters...)
+	if err != nil {
+		stats.Add(numQueryErrors, 1)
+		return w.EndStatement(err.Error(), 0)
+	}
+	defer rs.Close()
+
+	columns, err := rs.Columns()
+	if err != nil {
+		return err
+	}
+
+	types, err := rs.ColumnTypes()
+	if err != nil {
+		return err
+	}
+	xTypes := make([]string, len(types))
+	for i := range types {
+		xTypes[i] = strings.ToLower(types[i].DatabaseTypeName())
+	}
+
+	// The columns are written once the first row has been read, since
+	// any empty types are populated from it.
+	wroteColumns := false
+	for rs.Next() {
+		dest := make([]interface{}, len(columns))
+		ptrs := make([]interface{}, len(dest))
+		for i := range ptrs {
+			ptrs[i] = &dest[i]
+		}
+		if err := rs.Scan(ptrs...); err != nil {
+			return err
+		}
+		params, err := normalizeRowValues(dest, xTypes)
+		if err != nil {
+			return err
+		}
+
+		if !wroteColumns {
+			// One-time population of any empty types. Best effort, ignore
+			// error.
+			if containsEmptyType(xTypes) {
+				populateEmptyTypes(xTypes, params)
+			}
+			if err := w.WriteColumns(columns, xTypes); err != nil {
+				return err
+			}
+			wroteColumns = true
+		}
+		if err := w.WriteRow(&command.Values{Parameters: params}); err != nil {
+			return err
+		}
+	}
+
+	// Check for errors from iterating over rows.
+	if err := rs.Err(); err != nil {
+		stats.Add(numQueryErrors, 1)
+		return w.EndStatement(err.Error(), 0)
+	}
+
+	if !wroteColumns {
+		if err := w.WriteColumns(columns, xTypes); err != nil {
+			return err
+		}
+	}
+
+	var t float64
+	if xTime {
+		t = time.Since(start).Seconds()
+	}
+	return w.EndStatement("", t)
+}
+
+// rowsCollector is a RowsWriter which collects results in memory. Memory used
+// by the results is reserved from res, and if the reservation fails WriteRow
+// returns ErrQueryMemoryBudget.
+type rowsCollector struct {
+	res      *reservation
+	reserved int64
+	cur      *command.QueryRows
+	all      []*command.QueryRows
+}
+
+func (c *rowsCollector) WriteColumns(columns, types []string) error {
+	c.current().Columns = columns
+	c.current().Types = types
+	return nil
+}
+
+func (c *rowsCollector) WriteRow(values *command.Values) error {
+	sz := rowSize(values.Parameters)
+	if !c.res.reserve(sz) {
+		c.res.unreserve(c.reserved)
+		c.reserved = 0
+		c.cur = nil
+		return ErrQueryMemoryBudget
+	}
+	c.reserved += sz
+	c.current().Values = append(c.current().Values, values)
+	return nil
+}
+
+func (c *rowsCollector) EndStatement(errMsg string, t float64) error {
+	rows := c.current()
+	if errMsg != "" {
+		// Results which end in error are returned without their columns.
+		rows.Columns = nil
+		rows.Types = nil
+		rows.Error = errMsg
+	}
+	rows.Time = t
+	c.all = append(c.all, rows)
+	c.cur = nil
+	c.reserved = 0
+	return nil
+}
+
+func (c *rowsCollector) current() *command.QueryRows {
+	if c.cur == nil {
+		c.cur = &command.QueryRows{}
+	}
+	return c.cur
+}
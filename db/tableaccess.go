@@ -0,0 +1,260 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rqlite/go-sqlite3"
+	"github.com/rqlite/rqlite/command"
+)
+
+// TableReads are the reads of a table by queries.
+type TableReads struct {
+	// Reads is the number of query statements which read the table.
+	Reads int64 `json:"reads"`
+
+	// RowsReturned is the number of rows returned by those statements. A
+	// statement which reads many tables counts its rows against each.
+	RowsReturned int64 `json:"rows_returned"`
+
+	// LastRead is when the table was last read. It is zero if the table has
+	// not been read.
+	LastRead time.Time `json:"last_read"`
+}
+
+// TableAccess counts the reads of each table by queries, so that tables
+// which are never read, and those read most, can be found. The tables a
+// statement reads are those SQLite reports it reads when it is compiled,
+// including the tables underlying any views. It is safe for concurrent use.
+type TableAccess struct {
+	mu     sync.Mutex
+	tables map[string]*TableReads
+	since  time.Time
+}
+
+// NewTableAccess returns a TableAccess which has counted no reads.
+func NewTableAccess() *TableAccess {
+	return &TableAccess{
+		tables: make(map[string]*TableReads),
+		since:  time.Now(),
+	}
+}
+
+// Reads returns the reads of each table which has been read, and when
+// counting began.
+func (t *TableAccess) Reads() (map[string]TableReads, time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	m := make(map[string]TableReads, len(t.tables))
+	for name, r := range t.tables {
+		m[name] = *r
+	}
+	return m, t.since
+}
+
+// Reset discards all counts, so counting begins again.
+func (t *TableAccess) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tables = make(map[string]*TableReads)
+	t.since = time.Now()
+}
+
+// read counts a read of each of tables by a statement.
+func (t *TableAccess) read(tables []string) {
+	if len(tables) == 0 {
+		return
+	}
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, name := range tables {
+		r, ok := t.tables[name]
+		if !ok {
+			r = &TableReads{}
+			t.tables[name] = r
+		}
+		r.Reads++
+		r.LastRead = now
+	}
+}
+
+// returned counts n rows returned by a statement which read each of tables.
+func (t *TableAccess) returned(tables []string, n int64) {
+	if len(tables) == 0 || n == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, name := range tables {
+		if r, ok := t.tables[name]; ok {
+			r.RowsReturned += n
+		}
+	}
+}
+
+// SetTableAccess sets where the reads of each table by queries are counted.
+// If a is nil, reads are not counted.
+func (db *DB) SetTableAccess(a *TableAccess) {
+	db.access = a
+}
+
+// TableAccess returns where the reads of each table by queries are counted.
+// Returns nil if reads are not counted.
+func (db *DB) TableAccess() *TableAccess {
+	return db.access
+}
+
+// TableReads returns the reads of every table of the database, including
+// those which have never been read, and when counting began. Tables of
+// attached databases are named by schema and table, such as "archive.foo".
+func (db *DB) TableReads() (map[string]TableReads, time.Time, error) {
+	if db.access == nil {
+		return nil, time.Time{}, nil
+	}
+	names, err := db.tableNames()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	reads, since := db.access.Reads()
+	m := make(map[string]TableReads, len(names))
+	for _, name := range names {
+		m[name] = reads[name]
+	}
+	return m, since, nil
+}
+
+// tableNames returns the names of the tables of the database, and of any
+// attached databases, excluding SQLite's internal tables.
+func (db *DB) tableNames() ([]string, error) {
+	schemas := []string{"main"}
+	for name := range db.attached {
+		schemas = append(schemas, name)
+	}
+	sort.Strings(schemas[1:])
+
+	var names []string
+	for _, schema := range schemas {
+		rows, err := db.roDB.Query(fmt.Sprintf(`SELECT name FROM "%s".sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%%' ORDER BY name`, schema))
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			names = append(names, tableKey(schema, name))
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
+}
+
+// readTracking is how the reads of tables by the statements of a query are
+// counted.
+type readTracking int
+
+const (
+	// untracked queries are made internally, such as by Dump, so their
+	// reads are not counted.
+	untracked readTracking = iota
+
+	// tracked queries are made by clients.
+	tracked
+
+	// trackedSandboxed queries are made by clients on sandboxed connections.
+	trackedSandboxed
+
+	// trackedWritable queries are made by clients on the read-write
+	// connection, whose authorizer must be kept.
+	trackedWritable
+)
+
+// stmtTablesWithConn returns whether the given SQL statement is read-only,
+// as StmtReadOnlyWithConn does, along with the tables it reads if the query
+// is tracked.
+func (db *DB) stmtTablesWithConn(sql string, conn *sql.Conn, track readTracking) (bool, []string, error) {
+	if track == untracked {
+		readOnly, err := db.StmtReadOnlyWithConn(sql, conn)
+		return readOnly, nil, err
+	}
+
+	sandboxed := track == trackedSandboxed
+	var readOnly bool
+	var tables []string
+	seen := make(map[string]bool)
+	f := func(driverConn interface{}) error {
+		c := driverConn.(*sqlite3.SQLiteConn)
+		c.RegisterAuthorizer(func(code int, arg1, arg2, arg3 string) int {
+			if code == sqlite3.SQLITE_READ && arg3 != "temp" && !strings.HasPrefix(arg1, "sqlite_") {
+				if key := tableKey(arg3, arg1); !seen[key] {
+					seen[key] = true
+					tables = append(tables, key)
+				}
+			}
+			if sandboxed {
+				return sandboxAuthorizer(code, arg1, arg2, arg3)
+			}
+			return sqlite3.SQLITE_OK
+		})
+		switch track {
+		case trackedSandboxed:
+			defer c.RegisterAuthorizer(sandboxAuthorizer)
+		case trackedWritable:
+			defer c.RegisterAuthorizer(db.rwAuthorizer)
+		default:
+			defer c.RegisterAuthorizer(nil)
+		}
+
+		drvStmt, err := c.Prepare(sql)
+		if err != nil {
+			return err
+		}
+		defer drvStmt.Close()
+		readOnly = drvStmt.(*sqlite3.SQLiteStmt).Readonly()
+		return nil
+	}
+	if err := conn.Raw(f); err != nil {
+		return false, nil, err
+	}
+	return readOnly, tables, nil
+}
+
+// tableKey returns the name under which reads of the table of the given
+// schema are counted.
+func tableKey(schema, table string) string {
+	if schema == "" || schema == "main" {
+		return table
+	}
+	return schema + "." + table
+}
+
+// rowCounter is a RowsWriter which counts the rows written through it.
+type rowCounter struct {
+	RowsWriter
+	n int64
+}
+
+func (c *rowCounter) WriteRow(values *command.Values) error {
+	c.n++
+	return c.RowsWriter.WriteRow(values)
+}
+
+// recordReads counts a read of each of tables, and the rows returned.
+func (db *DB) recordReads(tables []string, rows int64) {
+	if db.access == nil {
+		return
+	}
+	db.access.read(tables)
+	db.access.returned(tables, rows)
+}
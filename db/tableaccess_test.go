@@ -0,0 +1,95 @@
+package db
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/rqlite/rqlite/command"
+)
+
+func Test_TableAccess(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+	mustExecute(db, `CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`)
+	mustExecute(db, `CREATE TABLE bar (id INTEGER NOT NULL PRIMARY KEY, fid INTEGER)`)
+	mustExecute(db, `CREATE TABLE unused (id INTEGER)`)
+	mustExecute(db, `CREATE VIEW fiona AS SELECT * FROM foo WHERE name = 'fiona'`)
+	mustExecute(db, `INSERT INTO foo(id, name) VALUES(1, 'fiona')`)
+	mustExecute(db, `INSERT INTO foo(id, name) VALUES(2, 'declan')`)
+	mustExecute(db, `INSERT INTO bar(id, fid) VALUES(1, 1)`)
+
+	a := NewTableAccess()
+	db.SetTableAccess(a)
+	for _, stmt := range []string{
+		`SELECT * FROM foo`,
+		`SELECT COUNT(*) FROM bar`,
+		`SELECT * FROM foo JOIN bar ON bar.fid = foo.id`,
+		`SELECT * FROM fiona`,
+		`SELECT name FROM sqlite_master`,
+	} {
+		if _, err := db.QueryStringStmt(stmt); err != nil {
+			t.Fatalf("failed to query %q: %s", stmt, err)
+		}
+	}
+	if _, _, err := db.QuerySandboxed(sandboxRequest(`SELECT * FROM bar`), false); err != nil {
+		t.Fatalf("failed to query sandboxed: %s", err)
+	}
+	if _, err := db.RequestStringStmts([]string{`SELECT * FROM foo WHERE id = 2`, `INSERT INTO bar(id, fid) VALUES(2, 2)`}); err != nil {
+		t.Fatalf("failed to request: %s", err)
+	}
+	c, err := db.OpenCursor(&command.Statement{Sql: `SELECT * FROM bar`})
+	if err != nil {
+		t.Fatalf("failed to open cursor: %s", err)
+	}
+	if _, _, err := c.Next(10); err != nil {
+		t.Fatalf("failed to read cursor: %s", err)
+	}
+	c.Close()
+
+	// Dumping the database reads every table, but is not counted.
+	if err := db.Dump(ioutil.Discard); err != nil {
+		t.Fatalf("failed to dump: %s", err)
+	}
+
+	reads, since, err := db.TableReads()
+	if err != nil {
+		t.Fatalf("failed to get table reads: %s", err)
+	}
+	if since.IsZero() {
+		t.Fatalf("counting start time not set")
+	}
+	if len(reads) != 3 {
+		t.Fatalf("wrong number of tables, exp 3, got %d: %v", len(reads), reads)
+	}
+	for name, exp := range map[string][2]int64{
+		"foo":    {4, 5},
+		"bar":    {4, 5},
+		"unused": {0, 0},
+	} {
+		r := reads[name]
+		if r.Reads != exp[0] || r.RowsReturned != exp[1] {
+			t.Fatalf("wrong reads of %s, exp %d reads returning %d rows, got %d returning %d",
+				name, exp[0], exp[1], r.Reads, r.RowsReturned)
+		}
+		if r.LastRead.IsZero() != (exp[0] == 0) {
+			t.Fatalf("wrong last read time of %s: %s", name, r.LastRead)
+		}
+	}
+
+	// The sandbox still applies to sandboxed connections.
+	rows, _, err := db.QuerySandboxed(sandboxRequest(`INSERT INTO foo(id, name) VALUES(3, 'aoife')`), false)
+	if err == nil && rows[0].Error == "" {
+		t.Fatalf("sandboxed write was not rejected")
+	}
+
+	a.Reset()
+	reads, _, err = db.TableReads()
+	if err != nil {
+		t.Fatalf("failed to get table reads: %s", err)
+	}
+	if reads["foo"].Reads != 0 {
+		t.Fatalf("reads not reset")
+	}
+}
@@ -0,0 +1,137 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/rqlite/go-sqlite3"
+	"github.com/rqlite/rqlite/command"
+)
+
+// Validation is the result of validating a statement.
+type Validation struct {
+	// Error is why the statement cannot be executed, such as a syntax error
+	// or a table or column which does not exist. It is empty if the
+	// statement is valid.
+	Error string `json:"error,omitempty"`
+
+	// ReadOnly is whether the statement only reads the database.
+	ReadOnly bool `json:"read_only"`
+
+	// NonDeterministic are the non-deterministic functions and keywords the
+	// statement uses, as reported by command.NonDeterministic.
+	NonDeterministic []string `json:"non_deterministic,omitempty"`
+}
+
+// schemaActions are the authorizer actions of statements which change the
+// schema.
+var schemaActions = map[int]bool{
+	sqlite3.SQLITE_CREATE_INDEX:        true,
+	sqlite3.SQLITE_CREATE_TABLE:        true,
+	sqlite3.SQLITE_CREATE_TEMP_INDEX:   true,
+	sqlite3.SQLITE_CREATE_TEMP_TABLE:   true,
+	sqlite3.SQLITE_CREATE_TEMP_TRIGGER: true,
+	sqlite3.SQLITE_CREATE_TEMP_VIEW:    true,
+	sqlite3.SQLITE_CREATE_TRIGGER:      true,
+	sqlite3.SQLITE_CREATE_VIEW:         true,
+	sqlite3.SQLITE_CREATE_VTABLE:       true,
+	sqlite3.SQLITE_DROP_INDEX:          true,
+	sqlite3.SQLITE_DROP_TABLE:          true,
+	sqlite3.SQLITE_DROP_TEMP_INDEX:     true,
+	sqlite3.SQLITE_DROP_TEMP_TABLE:     true,
+	sqlite3.SQLITE_DROP_TEMP_TRIGGER:   true,
+	sqlite3.SQLITE_DROP_TEMP_VIEW:      true,
+	sqlite3.SQLITE_DROP_TRIGGER:        true,
+	sqlite3.SQLITE_DROP_VIEW:           true,
+	sqlite3.SQLITE_DROP_VTABLE:         true,
+	sqlite3.SQLITE_ALTER_TABLE:         true,
+}
+
+// Validate compiles each statement of the request against the schema of the
+// database, without executing it, and reports whether it could be executed.
+// Statements are validated in order, and those which change the schema are
+// applied to an empty copy of the schema, so that later statements may use
+// the tables and columns earlier ones create, as a migration would. The
+// database itself is never changed. Only the first SQLite statement of the
+// SQL text of each statement is validated, and tables of attached databases
+// are not known.
+func (db *DB) Validate(req *command.Request) ([]*Validation, error) {
+	stats.Add(numValidations, int64(len(req.Statements)))
+	conn, err := db.schemaCopy()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	vals := make([]*Validation, len(req.Statements))
+	for i, stmt := range req.Statements {
+		v := &Validation{NonDeterministic: command.NonDeterministic(stmt.Sql)}
+		changesSchema := false
+		conn.RegisterAuthorizer(func(code int, arg1, arg2, arg3 string) int {
+			if schemaActions[code] {
+				changesSchema = true
+			}
+			return sqlite3.SQLITE_OK
+		})
+		drvStmt, err := conn.Prepare(stmt.Sql)
+		conn.RegisterAuthorizer(nil)
+		if err != nil {
+			v.Error = err.Error()
+			vals[i] = v
+			continue
+		}
+		v.ReadOnly = drvStmt.(*sqlite3.SQLiteStmt).Readonly()
+		n := drvStmt.NumInput()
+		drvStmt.Close()
+
+		if len(stmt.Parameters) > 0 && n != len(stmt.Parameters) {
+			v.Error = fmt.Sprintf("statement has %d parameters, %d values given", n, len(stmt.Parameters))
+		} else if changesSchema {
+			if _, err := conn.Exec(stmt.Sql, nil); err != nil {
+				v.Error = err.Error()
+			}
+		}
+		vals[i] = v
+	}
+	return vals, nil
+}
+
+// schemaCopy returns a connection to a new in-memory database with the
+// schema of the database, but none of its rows. Any connect hook is called
+// with it, so that any functions and modules it registers are available.
+// Schema objects which cannot be created in the copy are left out.
+func (db *DB) schemaCopy() (*sqlite3.SQLiteConn, error) {
+	rows, err := db.roDB.Query(`SELECT sql FROM sqlite_master WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite_%' ORDER BY rowid`)
+	if err != nil {
+		return nil, err
+	}
+	var schema []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		schema = append(schema, s)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	drv := &sqlite3.SQLiteDriver{}
+	if hook := getConnectHook(); hook != nil {
+		drv.ConnectHook = func(conn *sqlite3.SQLiteConn) error {
+			return hook(conn, false)
+		}
+	}
+	c, err := drv.Open(":memory:")
+	if err != nil {
+		return nil, err
+	}
+	conn := c.(*sqlite3.SQLiteConn)
+	for _, s := range schema {
+		conn.Exec(s, nil)
+	}
+	return conn, nil
+}
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/rqlite/rqlite/command"
+)
+
+func Test_Validate(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+	mustExecute(db, `CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`)
+	mustExecute(db, `CREATE INDEX foo_name ON foo(name)`)
+	mustExecute(db, `INSERT INTO foo(id, name) VALUES(1, 'fiona')`)
+
+	req := &command.Request{
+		Statements: []*command.Statement{
+			{Sql: `SELECT name FROM foo`},
+			{Sql: `SELEC name FROM foo`},
+			{Sql: `SELECT age FROM foo`},
+			{Sql: `INSERT INTO bar(name) VALUES('fiona')`},
+			{Sql: `CREATE TABLE bar (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`},
+			{Sql: `INSERT INTO bar(name) VALUES('fiona')`},
+			{Sql: `ALTER TABLE foo ADD COLUMN age INTEGER`},
+			{Sql: `UPDATE foo SET age = abs(random()) WHERE age > 5`},
+			{Sql: `CREATE TABLE foo (id INTEGER)`},
+			{Sql: `CREATE INDEX foo_name ON foo(name)`},
+			{
+				Sql: `INSERT INTO foo(id, name) VALUES(?, ?)`,
+				Parameters: []*command.Parameter{
+					{Value: &command.Parameter_I{I: 2}},
+				},
+			},
+		},
+	}
+	vals, err := db.Validate(req)
+	if err != nil {
+		t.Fatalf("failed to validate: %s", err)
+	}
+	if len(vals) != len(req.Statements) {
+		t.Fatalf("wrong number of validations: %d", len(vals))
+	}
+
+	for i, exp := range []struct {
+		err      string
+		readOnly bool
+	}{
+		{"", true},
+		{"syntax error", false},
+		{"no such column: age", false},
+		{"no such table: bar", false},
+		{"", false},
+		{"", false},
+		{"", false},
+		{"", false},
+		{"table foo already exists", false},
+		{"index foo_name already exists", false},
+		{"statement has 2 parameters, 1 values given", false},
+	} {
+		v := vals[i]
+		if exp.err == "" && v.Error != "" {
+			t.Fatalf("statement %d: unexpected error: %s", i, v.Error)
+		}
+		if !strings.Contains(v.Error, exp.err) {
+			t.Fatalf("statement %d: expected error %q, got %q", i, exp.err, v.Error)
+		}
+		if v.ReadOnly != exp.readOnly {
+			t.Fatalf("statement %d: wrong read-only: %v", i, v.ReadOnly)
+		}
+	}
+	if exp, got := []string{"random()"}, vals[7].NonDeterministic; len(got) != 1 || got[0] != exp[0] {
+		t.Fatalf("wrong non-deterministic functions: %v", got)
+	}
+
+	// The database itself is unchanged.
+	rows, err := db.QueryStringStmt(`SELECT * FROM foo`)
+	if err != nil {
+		t.Fatalf("failed to query: %s", err)
+	}
+	if exp, got := `[{"columns":["id","name"],"types":["integer","text"],"values":[[1,"fiona"]]}]`, asJSON(rows); exp != got {
+		t.Fatalf("unexpected results, exp %s, got %s", exp, got)
+	}
+	rows, err = db.QueryStringStmt(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'bar'`)
+	if err != nil {
+		t.Fatalf("failed to query: %s", err)
+	}
+	if exp, got := `[{"columns":["COUNT(*)"],"types":["integer"],"values":[[0]]}]`, asJSON(rows); exp != got {
+		t.Fatalf("unexpected results, exp %s, got %s", exp, got)
+	}
+}
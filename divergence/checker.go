@@ -0,0 +1,296 @@
+// Package divergence detects replicas whose databases have silently diverged
+// from the Leader's. The Leader periodically has every node take a checksum
+// of each table in its database at the same point in the Raft log, and then
+// compares the checksums of the other nodes with its own. Since every node
+// applies the same log, any difference means a replica has diverged, for
+// example due to a disk fault or a bug.
+package divergence
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rqlite/rqlite/cluster"
+	"github.com/rqlite/rqlite/store"
+)
+
+const (
+	// DefaultWaitTimeout is the default time to wait for a node to take its
+	// checksum, which it does when it applies the checksum log entry.
+	DefaultWaitTimeout = 30 * time.Second
+
+	retryInterval  = time.Second
+	requestTimeout = 10 * time.Second
+)
+
+const (
+	// StatusOK means the node's checksums match the Leader's.
+	StatusOK = "ok"
+
+	// StatusDiverged means the node's checksums differ from the Leader's.
+	StatusDiverged = "diverged"
+
+	// StatusUnavailable means the node's checksums could not be retrieved.
+	StatusUnavailable = "unavailable"
+)
+
+// stats captures stats for the divergence checker.
+var stats *expvar.Map
+
+const (
+	numChecks        = "num_checks"
+	numCheckFailures = "num_check_failures"
+	numDivergences   = "num_divergences"
+	numUnavailable   = "num_unavailable"
+	numResyncs       = "num_resyncs"
+)
+
+func init() {
+	stats = expvar.NewMap("divergence")
+	ResetStats()
+}
+
+// ResetStats resets the expvar stats for this module. Mostly for test purposes.
+func ResetStats() {
+	stats.Init()
+	stats.Add(numChecks, 0)
+	stats.Add(numCheckFailures, 0)
+	stats.Add(numDivergences, 0)
+	stats.Add(numUnavailable, 0)
+	stats.Add(numResyncs, 0)
+}
+
+// Store is the interface the consensus system must implement.
+type Store interface {
+	// ID returns the ID of this node.
+	ID() string
+
+	// IsLeader returns whether this node is the Leader.
+	IsLeader() bool
+
+	// Nodes returns the nodes in the cluster.
+	Nodes() ([]*store.Server, error)
+
+	// Checksum has every node take a checksum of each table in its
+	// database, and returns the log index at which they were taken and
+	// this node's checksums.
+	Checksum() (uint64, map[string]string, error)
+}
+
+// Client is the interface the cluster client must implement.
+type Client interface {
+	// GetChecksum returns the checksums of the node at nodeAddr, taken at
+	// the given log index.
+	GetChecksum(idx uint64, nodeAddr string, timeout time.Duration) (map[string]string, error)
+
+	// Resync requests that the node at nodeAddr resync its database from
+	// the Leader's.
+	Resync(nodeAddr string, creds *cluster.Credentials, timeout time.Duration) error
+}
+
+// NodeResult is the result of comparing a node's checksums with the Leader's.
+type NodeResult struct {
+	Status string `json:"status"`
+
+	// Tables are the tables whose checksums differ, if the node diverged.
+	Tables []string `json:"tables,omitempty"`
+
+	// Error is why the node's checksums could not be retrieved, if it was
+	// unavailable.
+	Error string `json:"error,omitempty"`
+}
+
+// Checker periodically checks, while this node is the Leader, that the
+// databases of all other nodes match its own.
+type Checker struct {
+	// WaitTimeout is the time to wait for each node to take its checksum.
+	WaitTimeout time.Duration
+
+	// AutoResync, if set, has each node found to have diverged resync its
+	// database from the Leader's.
+	AutoResync bool
+
+	str      Store
+	client   Client
+	interval time.Duration
+
+	logger *log.Logger
+
+	mu        sync.Mutex
+	lastCheck time.Time
+	lastIndex uint64
+	lastErr   error
+	results   map[string]*NodeResult
+}
+
+// New returns a Checker which checks for divergence every interval.
+func New(str Store, client Client, interval time.Duration) *Checker {
+	return &Checker{
+		WaitTimeout: DefaultWaitTimeout,
+		str:         str,
+		client:      client,
+		interval:    interval,
+		logger:      log.New(os.Stderr, "[divergence] ", log.LstdFlags),
+	}
+}
+
+// Start starts checking for divergence, until ctx is cancelled.
+func (c *Checker) Start(ctx context.Context) {
+	c.logger.Printf("starting divergence checks every %s", c.interval)
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !c.str.IsLeader() {
+				continue
+			}
+			if _, err := c.Check(ctx); err != nil {
+				c.logger.Printf("failed to check for divergence: %s", err.Error())
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Check compares the checksums of every other node with this node's, and
+// returns the result for each node, keyed by node ID. It must be called on
+// the Leader.
+func (c *Checker) Check(ctx context.Context) (map[string]*NodeResult, error) {
+	stats.Add(numChecks, 1)
+	idx, sums, err := c.str.Checksum()
+	if err != nil {
+		c.setResults(0, nil, err)
+		stats.Add(numCheckFailures, 1)
+		return nil, err
+	}
+	nodes, err := c.str.Nodes()
+	if err != nil {
+		c.setResults(idx, nil, err)
+		stats.Add(numCheckFailures, 1)
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]*NodeResult)
+	addrs := make(map[string]string)
+	for _, n := range nodes {
+		if n.ID == c.str.ID() {
+			continue
+		}
+		addrs[n.ID] = n.Addr
+		wg.Add(1)
+		go func(n *store.Server) {
+			defer wg.Done()
+			r := c.checkNode(ctx, n, idx, sums)
+			mu.Lock()
+			results[n.ID] = r
+			mu.Unlock()
+		}(n)
+	}
+	wg.Wait()
+
+	for id, r := range results {
+		switch r.Status {
+		case StatusDiverged:
+			stats.Add(numDivergences, 1)
+			c.logger.Printf("DIVERGENCE DETECTED: node %s differs from this node at log index %d in tables %v",
+				id, idx, r.Tables)
+			if c.AutoResync {
+				c.resync(id, addrs[id])
+			}
+		case StatusUnavailable:
+			stats.Add(numUnavailable, 1)
+			c.logger.Printf("failed to get checksums of node %s at log index %d: %s", id, idx, r.Error)
+		}
+	}
+	c.setResults(idx, results, nil)
+	return results, nil
+}
+
+// Stats returns diagnostic information on the checker.
+func (c *Checker) Stats() (map[string]interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m := map[string]interface{}{
+		"interval":     c.interval.String(),
+		"wait_timeout": c.WaitTimeout.String(),
+		"auto_resync":  c.AutoResync,
+		"last_check":   c.lastCheck,
+		"last_index":   c.lastIndex,
+		"nodes":        c.results,
+	}
+	if c.lastErr != nil {
+		m["last_error"] = c.lastErr.Error()
+	}
+	return m, nil
+}
+
+// checkNode waits for the node to take its checksums at idx, and compares
+// them with sums.
+func (c *Checker) checkNode(ctx context.Context, n *store.Server, idx uint64, sums map[string]string) *NodeResult {
+	deadline := time.Now().Add(c.WaitTimeout)
+	for {
+		nodeSums, err := c.client.GetChecksum(idx, n.Addr, requestTimeout)
+		if err == nil {
+			if tables := diff(sums, nodeSums); len(tables) > 0 {
+				return &NodeResult{Status: StatusDiverged, Tables: tables}
+			}
+			return &NodeResult{Status: StatusOK}
+		}
+
+		// The node may not have applied the checksum log entry yet.
+		if time.Now().Add(retryInterval).After(deadline) {
+			return &NodeResult{Status: StatusUnavailable, Error: err.Error()}
+		}
+		select {
+		case <-time.After(retryInterval):
+		case <-ctx.Done():
+			return &NodeResult{Status: StatusUnavailable, Error: ctx.Err().Error()}
+		}
+	}
+}
+
+// resync requests that the node resync its database from this node's.
+func (c *Checker) resync(id, addr string) {
+	if err := c.client.Resync(addr, nil, requestTimeout); err != nil {
+		c.logger.Printf("failed to request resync of node %s: %s", id, err.Error())
+		return
+	}
+	stats.Add(numResyncs, 1)
+	c.logger.Printf("requested resync of node %s", id)
+}
+
+func (c *Checker) setResults(idx uint64, results map[string]*NodeResult, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastCheck = time.Now()
+	c.lastIndex = idx
+	c.lastErr = err
+	c.results = results
+}
+
+// diff returns, in order, the tables whose checksums differ between a and
+// b, including those in only one of them.
+func diff(a, b map[string]string) []string {
+	var tables []string
+	for t, sum := range a {
+		if b[t] != sum {
+			tables = append(tables, t)
+		}
+	}
+	for t := range b {
+		if _, ok := a[t]; !ok {
+			tables = append(tables, t)
+		}
+	}
+	sort.Strings(tables)
+	return tables
+}
@@ -0,0 +1,192 @@
+package divergence
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rqlite/rqlite/cluster"
+	"github.com/rqlite/rqlite/store"
+)
+
+func Test_CheckerCheck(t *testing.T) {
+	ResetStats()
+	s := &mockStore{
+		idx:  10,
+		sums: map[string]string{"foo": "a", "bar": "b"},
+		nodes: []*store.Server{
+			{ID: "1", Addr: "node1"},
+			{ID: "2", Addr: "node2"},
+			{ID: "3", Addr: "node3"},
+			{ID: "4", Addr: "node4"},
+		},
+	}
+	cl := &mockClient{sums: map[string]map[string]string{
+		"node2": {"foo": "a", "bar": "b"},
+		"node3": {"foo": "a", "bar": "x", "baz": "c"},
+	}}
+	c := New(s, cl, time.Hour)
+	c.WaitTimeout = 100 * time.Millisecond
+
+	results, err := c.Check(context.Background())
+	if err != nil {
+		t.Fatalf("failed to check: %s", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected results for 3 nodes, got %d", len(results))
+	}
+	if exp, got := (&NodeResult{Status: StatusOK}), results["2"]; !reflect.DeepEqual(exp, got) {
+		t.Fatalf("wrong result for node 2, exp %v, got %v", exp, got)
+	}
+	if exp, got := (&NodeResult{Status: StatusDiverged, Tables: []string{"bar", "baz"}}), results["3"]; !reflect.DeepEqual(exp, got) {
+		t.Fatalf("wrong result for node 3, exp %v, got %v", exp, got)
+	}
+	if results["4"].Status != StatusUnavailable || results["4"].Error == "" {
+		t.Fatalf("wrong result for node 4, got %v", results["4"])
+	}
+	for _, addr := range cl.requested() {
+		if addr == "node1" {
+			t.Fatalf("checksums of this node requested")
+		}
+	}
+
+	if stats.Get(numDivergences).String() != "1" {
+		t.Fatalf("divergence not counted")
+	}
+	st, err := c.Stats()
+	if err != nil {
+		t.Fatalf("failed to get stats: %s", err)
+	}
+	if st["last_index"] != uint64(10) {
+		t.Fatalf("wrong last index in stats: %v", st["last_index"])
+	}
+}
+
+func Test_CheckerCheckWaits(t *testing.T) {
+	s := &mockStore{
+		idx:   10,
+		sums:  map[string]string{"foo": "a"},
+		nodes: []*store.Server{{ID: "1", Addr: "node1"}, {ID: "2", Addr: "node2"}},
+	}
+	cl := &mockClient{
+		sums:     map[string]map[string]string{"node2": {"foo": "a"}},
+		notFound: 1,
+	}
+	c := New(s, cl, time.Hour)
+
+	results, err := c.Check(context.Background())
+	if err != nil {
+		t.Fatalf("failed to check: %s", err)
+	}
+	if results["2"].Status != StatusOK {
+		t.Fatalf("wrong result for node 2, got %v", results["2"])
+	}
+	if n := len(cl.requested()); n != 2 {
+		t.Fatalf("expected 2 requests, got %d", n)
+	}
+}
+
+func Test_CheckerAutoResync(t *testing.T) {
+	ResetStats()
+	s := &mockStore{
+		idx:   10,
+		sums:  map[string]string{"foo": "a"},
+		nodes: []*store.Server{{ID: "1", Addr: "node1"}, {ID: "2", Addr: "node2"}, {ID: "3", Addr: "node3"}},
+	}
+	cl := &mockClient{sums: map[string]map[string]string{
+		"node2": {"foo": "a"},
+		"node3": {"foo": "x"},
+	}}
+	c := New(s, cl, time.Hour)
+	c.AutoResync = true
+
+	if _, err := c.Check(context.Background()); err != nil {
+		t.Fatalf("failed to check: %s", err)
+	}
+	if exp, got := []string{"node3"}, cl.resynced(); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("wrong nodes resynced, exp %v, got %v", exp, got)
+	}
+	if stats.Get(numResyncs).String() != "1" {
+		t.Fatalf("resync not counted")
+	}
+}
+
+func Test_CheckerCheckFailure(t *testing.T) {
+	s := &mockStore{err: store.ErrNotLeader}
+	c := New(s, &mockClient{}, time.Hour)
+	if _, err := c.Check(context.Background()); err != store.ErrNotLeader {
+		t.Fatalf("expected ErrNotLeader, got %v", err)
+	}
+	st, _ := c.Stats()
+	if st["last_error"] != store.ErrNotLeader.Error() {
+		t.Fatalf("expected last error in stats, got %v", st["last_error"])
+	}
+}
+
+type mockStore struct {
+	idx   uint64
+	sums  map[string]string
+	nodes []*store.Server
+	err   error
+}
+
+func (m *mockStore) ID() string {
+	return "1"
+}
+
+func (m *mockStore) IsLeader() bool {
+	return true
+}
+
+func (m *mockStore) Nodes() ([]*store.Server, error) {
+	return m.nodes, nil
+}
+
+func (m *mockStore) Checksum() (uint64, map[string]string, error) {
+	return m.idx, m.sums, m.err
+}
+
+type mockClient struct {
+	mu       sync.Mutex
+	sums     map[string]map[string]string
+	notFound int // Number of requests answered as not yet taken.
+	addrs    []string
+	resyncs  []string
+}
+
+func (m *mockClient) GetChecksum(idx uint64, nodeAddr string, timeout time.Duration) (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.addrs = append(m.addrs, nodeAddr)
+	if m.notFound > 0 {
+		m.notFound--
+		return nil, store.ErrChecksumNotFound
+	}
+	sums, ok := m.sums[nodeAddr]
+	if !ok {
+		return nil, errors.New("connection refused")
+	}
+	return sums, nil
+}
+
+func (m *mockClient) Resync(nodeAddr string, creds *cluster.Credentials, timeout time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.resyncs = append(m.resyncs, nodeAddr)
+	return nil
+}
+
+func (m *mockClient) resynced() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.resyncs...)
+}
+
+func (m *mockClient) requested() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.addrs...)
+}
@@ -0,0 +1,238 @@
+// Package fdw allows tables of remote rqlite clusters to be queried as if
+// they were local tables, through SQLite virtual tables. A remote table is
+// declared with a statement such as
+//
+//	CREATE VIRTUAL TABLE remote_users USING rqlite_remote(reporting, users, id INTEGER, name TEXT)
+//
+// where reporting is the name of a remote cluster configured on every node,
+// users is the table in the remote cluster, and the columns of the remote
+// table to be queried follow. Remote tables are read-only, and may only be
+// read by queries, since the data read from them may differ between nodes.
+package fdw
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rqlite/rqlite/rtls"
+)
+
+// ModuleName is the name of the SQLite module implementing remote tables.
+const ModuleName = "rqlite_remote"
+
+// DefaultTimeout is the default timeout of queries of remote clusters.
+const DefaultTimeout = 30 * time.Second
+
+// ErrNotSupported is returned when remote tables are used with a build of
+// rqlite without support for SQLite virtual tables.
+var ErrNotSupported = errors.New("remote tables not supported, rebuild with the sqlite_vtable tag")
+
+// stats captures stats for queries of remote clusters.
+var stats *expvar.Map
+
+const (
+	numRemoteQueries     = "remote_queries"
+	numRemoteQueryErrors = "remote_query_errors"
+	numRemoteRows        = "remote_rows"
+)
+
+func init() {
+	stats = expvar.NewMap("fdw")
+	ResetStats()
+}
+
+// ResetStats resets the expvar stats for this module. Mostly for test purposes.
+func ResetStats() {
+	stats.Init()
+	stats.Add(numRemoteQueries, 0)
+	stats.Add(numRemoteQueryErrors, 0)
+	stats.Add(numRemoteRows, 0)
+}
+
+// Remote is a remote rqlite cluster whose tables may be queried.
+type Remote struct {
+	// URL is the HTTP API address of a node of the remote cluster, such as
+	// https://host:4001.
+	URL string `json:"url"`
+
+	// Username and Password, if set, are sent with each query using basic
+	// auth.
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	// CACertFile is the path to the PEM-encoded CA certificate used to
+	// verify the remote cluster. InsecureSkipVerify disables verification.
+	CACertFile         string `json:"ca_cert_file,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+
+	// Level is the read consistency level of queries of the remote cluster.
+	// If not set, queries are made at weak consistency.
+	Level string `json:"level,omitempty"`
+}
+
+// Remotes maps names to remote clusters.
+type Remotes map[string]*Remote
+
+// ReadConfigFile reads remote clusters from the JSON file at path, which
+// maps names to remote clusters.
+func ReadConfigFile(path string) (Remotes, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var remotes Remotes
+	if err := json.Unmarshal(b, &remotes); err != nil {
+		return nil, fmt.Errorf("failed to parse remotes in %s: %s", path, err.Error())
+	}
+	for name, r := range remotes {
+		if r == nil || r.URL == "" {
+			return nil, fmt.Errorf("url of remote %s must be set", name)
+		}
+		if _, err := url.Parse(r.URL); err != nil {
+			return nil, fmt.Errorf("invalid url of remote %s: %s", name, err.Error())
+		}
+	}
+	return remotes, nil
+}
+
+// Rows are the rows returned by a query of a remote cluster.
+type Rows struct {
+	Columns []string
+	Types   []string
+	Values  [][]interface{}
+}
+
+// Client queries remote clusters.
+type Client struct {
+	remotes Remotes
+	clients map[string]*http.Client
+
+	// Timeout is the timeout of each query. If zero, DefaultTimeout is used.
+	Timeout time.Duration
+}
+
+// NewClient returns a client for querying the given remote clusters.
+func NewClient(remotes Remotes) (*Client, error) {
+	c := &Client{
+		remotes: remotes,
+		clients: make(map[string]*http.Client, len(remotes)),
+	}
+	for name, r := range remotes {
+		hc := http.DefaultClient
+		if strings.HasPrefix(r.URL, "https") {
+			tlsConfig, err := rtls.CreateClientConfig("", "", r.CACertFile, r.InsecureSkipVerify)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create TLS config for remote %s: %s", name, err.Error())
+			}
+			hc = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		}
+		c.clients[name] = hc
+	}
+	return c, nil
+}
+
+// Query runs the query, with the given arguments, against the named remote
+// cluster.
+func (c *Client) Query(ctx context.Context, remote, query string, args []interface{}) (rows *Rows, retErr error) {
+	stats.Add(numRemoteQueries, 1)
+	defer func() {
+		if retErr != nil {
+			stats.Add(numRemoteQueryErrors, 1)
+		} else {
+			stats.Add(numRemoteRows, int64(len(rows.Values)))
+		}
+	}()
+
+	r, ok := c.remotes[remote]
+	if !ok {
+		return nil, fmt.Errorf("remote %s is not configured", remote)
+	}
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	body, err := json.Marshal([][]interface{}{append([]interface{}{query}, args...)})
+	if err != nil {
+		return nil, err
+	}
+	level := r.Level
+	if level == "" {
+		level = "weak"
+	}
+	u := fmt.Sprintf("%s/db/query?level=%s&timeout=%s", strings.TrimSuffix(r.URL, "/"),
+		url.QueryEscape(level), timeout)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.Username != "" {
+		req.SetBasicAuth(r.Username, r.Password)
+	}
+
+	resp, err := c.clients[remote].Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query remote %s: %w", remote, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to query remote %s: %s", remote, resp.Status)
+	}
+
+	var qr struct {
+		Results []struct {
+			Columns []string        `json:"columns"`
+			Types   []string        `json:"types"`
+			Values  [][]interface{} `json:"values"`
+			Error   string          `json:"error"`
+		} `json:"results"`
+		Error string `json:"error"`
+	}
+	dec := json.NewDecoder(resp.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&qr); err != nil {
+		return nil, fmt.Errorf("failed to decode response from remote %s: %s", remote, err.Error())
+	}
+	if qr.Error != "" {
+		return nil, fmt.Errorf("remote %s: %s", remote, qr.Error)
+	}
+	if len(qr.Results) != 1 {
+		return nil, fmt.Errorf("unexpected response from remote %s", remote)
+	}
+	res := qr.Results[0]
+	if res.Error != "" {
+		return nil, fmt.Errorf("remote %s: %s", remote, res.Error)
+	}
+	for _, row := range res.Values {
+		for i := range row {
+			row[i] = convertValue(row[i])
+		}
+	}
+	return &Rows{Columns: res.Columns, Types: res.Types, Values: res.Values}, nil
+}
+
+// convertValue converts a value decoded from JSON to the type it has in
+// SQLite.
+func convertValue(v interface{}) interface{} {
+	n, ok := v.(json.Number)
+	if !ok {
+		return v
+	}
+	if i, err := n.Int64(); err == nil {
+		return i
+	}
+	f, _ := n.Float64()
+	return f
+}
@@ -0,0 +1,90 @@
+package fdw
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_ReadConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "remotes.json")
+	if err := os.WriteFile(path, []byte(`{"reporting": {"url": "http://localhost:4001", "username": "bob", "password": "secret"}}`), 0600); err != nil {
+		t.Fatalf("failed to write config: %s", err.Error())
+	}
+	remotes, err := ReadConfigFile(path)
+	if err != nil {
+		t.Fatalf("failed to read config: %s", err.Error())
+	}
+	exp := Remotes{"reporting": {URL: "http://localhost:4001", Username: "bob", Password: "secret"}}
+	if !reflect.DeepEqual(remotes, exp) {
+		t.Fatalf("wrong remotes, exp %v, got %v", exp, remotes)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"reporting": {"username": "bob"}}`), 0600); err != nil {
+		t.Fatalf("failed to write config: %s", err.Error())
+	}
+	if _, err := ReadConfigFile(path); err == nil {
+		t.Fatalf("expected error reading remote without url")
+	}
+}
+
+func Test_ClientQuery(t *testing.T) {
+	ResetStats()
+	var got [][]interface{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/db/query" || r.URL.Query().Get("level") != "weak" {
+			t.Fatalf("unexpected request %s", r.URL)
+		}
+		if u, p, _ := r.BasicAuth(); u != "bob" || p != "secret" {
+			t.Fatalf("wrong credentials %s:%s", u, p)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Fatalf("failed to decode request: %s", err.Error())
+		}
+		w.Write([]byte(`{"results":[{"columns":["id","name","score"],"types":["integer","text","real"],"values":[[1,"fiona",2.5],[2,null,3]]}]}`))
+	}))
+	defer ts.Close()
+
+	c, err := NewClient(Remotes{"reporting": {URL: ts.URL, Username: "bob", Password: "secret"}})
+	if err != nil {
+		t.Fatalf("failed to create client: %s", err.Error())
+	}
+	rows, err := c.Query(context.Background(), "reporting", `SELECT * FROM "users" WHERE "id" > ?`, []interface{}{int64(0)})
+	if err != nil {
+		t.Fatalf("failed to query remote: %s", err.Error())
+	}
+	if exp := []interface{}{`SELECT * FROM "users" WHERE "id" > ?`, float64(0)}; len(got) != 1 || !reflect.DeepEqual(got[0], exp) {
+		t.Fatalf("wrong query sent, exp %v, got %v", exp, got)
+	}
+	exp := [][]interface{}{{int64(1), "fiona", 2.5}, {int64(2), nil, int64(3)}}
+	if !reflect.DeepEqual(rows.Values, exp) {
+		t.Fatalf("wrong rows, exp %v, got %v", exp, rows.Values)
+	}
+	if got := stats.Get(numRemoteRows).String(); got != "2" {
+		t.Fatalf("wrong remote rows stat, exp 2, got %s", got)
+	}
+
+	if _, err := c.Query(context.Background(), "other", "SELECT 1", nil); err == nil {
+		t.Fatalf("expected error querying unknown remote")
+	}
+}
+
+func Test_ClientQueryError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"results":[{"error":"no such table: users"}]}`))
+	}))
+	defer ts.Close()
+
+	c, err := NewClient(Remotes{"reporting": {URL: ts.URL}})
+	if err != nil {
+		t.Fatalf("failed to create client: %s", err.Error())
+	}
+	if _, err := c.Query(context.Background(), "reporting", "SELECT * FROM users", nil); err == nil {
+		t.Fatalf("expected error querying table which does not exist")
+	}
+}
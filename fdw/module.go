@@ -0,0 +1,213 @@
+//go:build sqlite_vtable
+// +build sqlite_vtable
+
+package fdw
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/rqlite/go-sqlite3"
+)
+
+// Supported is whether this build of rqlite supports remote tables.
+const Supported = true
+
+// ConnectHook registers the module implementing remote tables with conn. It
+// is suitable for use as a db.ConnectHook. Remote tables may only be read
+// through read-only connections.
+func (c *Client) ConnectHook(conn *sqlite3.SQLiteConn, readOnly bool) error {
+	return conn.CreateModule(ModuleName, &module{client: c, readOnly: readOnly})
+}
+
+// module is the SQLite module implementing remote tables.
+type module struct {
+	client   *Client
+	readOnly bool
+}
+
+// Create is called when a remote table is created. The remote cluster is not
+// contacted, so that creating a remote table through the Raft log has the
+// same result on every node.
+func (m *module) Create(c *sqlite3.SQLiteConn, args []string) (sqlite3.VTab, error) {
+	return m.Connect(c, args)
+}
+
+// Connect is called when an existing remote table is first used by a
+// connection.
+func (m *module) Connect(c *sqlite3.SQLiteConn, args []string) (sqlite3.VTab, error) {
+	// The first three arguments are the module, database, and table names.
+	args = args[3:]
+	if len(args) < 3 {
+		return nil, fmt.Errorf("%s requires a remote, a remote table, and at least one column", ModuleName)
+	}
+	remote := unquote(args[0])
+	table := unquote(args[1])
+	defs := args[2:]
+	cols := make([]string, len(defs))
+	for i, d := range defs {
+		f := strings.Fields(strings.TrimSpace(d))
+		if len(f) == 0 {
+			return nil, fmt.Errorf("column %d of remote table has no name", i+1)
+		}
+		cols[i] = unquote(f[0])
+	}
+	if err := c.DeclareVTab(fmt.Sprintf("CREATE TABLE x(%s)", strings.Join(defs, ", "))); err != nil {
+		return nil, err
+	}
+	return &vtab{module: m, remote: remote, table: table, columns: cols}, nil
+}
+
+// DestroyModule is called when the connection is closed.
+func (m *module) DestroyModule() {}
+
+// vtab is a remote table.
+type vtab struct {
+	module  *module
+	remote  string
+	table   string
+	columns []string
+}
+
+// pushedOps are the operators of constraints which are evaluated by the
+// remote cluster, rather than locally.
+var pushedOps = map[sqlite3.Op]string{
+	sqlite3.OpEQ: "=",
+	sqlite3.OpGT: ">",
+	sqlite3.OpGE: ">=",
+	sqlite3.OpLT: "<",
+	sqlite3.OpLE: "<=",
+}
+
+// BestIndex pushes the comparisons of columns with values down to the remote
+// cluster, so that only matching rows are returned by it. The constraints
+// are encoded in the index string as column and operator pairs.
+func (v *vtab) BestIndex(csts []sqlite3.InfoConstraint, obs []sqlite3.InfoOrderBy) (*sqlite3.IndexResult, error) {
+	used := make([]bool, len(csts))
+	var pushed []string
+	for i, c := range csts {
+		if _, ok := pushedOps[c.Op]; !ok || !c.Usable || c.Column < 0 {
+			continue
+		}
+		used[i] = true
+		pushed = append(pushed, fmt.Sprintf("%d:%d", c.Column, c.Op))
+	}
+	return &sqlite3.IndexResult{
+		Used:          used,
+		IdxStr:        strings.Join(pushed, ","),
+		EstimatedCost: 1e6 / float64(1+10*len(pushed)),
+	}, nil
+}
+
+func (v *vtab) Disconnect() error { return nil }
+
+func (v *vtab) Destroy() error { return nil }
+
+func (v *vtab) Open() (sqlite3.VTabCursor, error) {
+	return &cursor{vtab: v}, nil
+}
+
+// cursor iterates over the rows returned by the remote cluster.
+type cursor struct {
+	vtab *vtab
+	rows [][]interface{}
+	i    int
+}
+
+// Filter queries the remote cluster for the rows matching the constraints
+// encoded by BestIndex, whose values are vals.
+func (c *cursor) Filter(idxNum int, idxStr string, vals []interface{}) error {
+	v := c.vtab
+	if !v.module.readOnly {
+		return errors.New("remote tables may only be read by queries")
+	}
+
+	quoted := make([]string, len(v.columns))
+	for i, col := range v.columns {
+		quoted[i] = quoteIdentifier(col)
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(quoted, ", "), quoteIdentifier(v.table))
+	if idxStr != "" {
+		var conds []string
+		for _, p := range strings.Split(idxStr, ",") {
+			parts := strings.SplitN(p, ":", 2)
+			col, err1 := strconv.Atoi(parts[0])
+			op, err2 := strconv.Atoi(parts[1])
+			if err1 != nil || err2 != nil || col >= len(quoted) {
+				return fmt.Errorf("invalid index %s", idxStr)
+			}
+			conds = append(conds, fmt.Sprintf("%s %s ?", quoted[col], pushedOps[sqlite3.Op(op)]))
+		}
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+
+	rows, err := v.module.client.Query(context.Background(), v.remote, query, vals)
+	if err != nil {
+		return err
+	}
+	c.rows = rows.Values
+	c.i = 0
+	return nil
+}
+
+func (c *cursor) Next() error {
+	c.i++
+	return nil
+}
+
+func (c *cursor) EOF() bool {
+	return c.i >= len(c.rows)
+}
+
+func (c *cursor) Column(ctx *sqlite3.SQLiteContext, col int) error {
+	row := c.rows[c.i]
+	if col >= len(row) {
+		ctx.ResultNull()
+		return nil
+	}
+	switch val := row[col].(type) {
+	case nil:
+		ctx.ResultNull()
+	case int64:
+		ctx.ResultInt64(val)
+	case float64:
+		ctx.ResultDouble(val)
+	case bool:
+		ctx.ResultBool(val)
+	case string:
+		ctx.ResultText(val)
+	default:
+		ctx.ResultText(fmt.Sprint(val))
+	}
+	return nil
+}
+
+func (c *cursor) Rowid() (int64, error) {
+	return int64(c.i), nil
+}
+
+func (c *cursor) Close() error {
+	return nil
+}
+
+// unquote removes any quotes around an SQLite identifier.
+func unquote(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 {
+		switch {
+		case s[0] == '"' && s[len(s)-1] == '"':
+			return strings.ReplaceAll(s[1:len(s)-1], `""`, `"`)
+		case s[0] == '`' && s[len(s)-1] == '`', s[0] == '[' && s[len(s)-1] == ']', s[0] == '\'' && s[len(s)-1] == '\'':
+			return s[1 : len(s)-1]
+		}
+	}
+	return s
+}
+
+// quoteIdentifier quotes a SQLite identifier.
+func quoteIdentifier(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
@@ -0,0 +1,17 @@
+//go:build !sqlite_vtable
+// +build !sqlite_vtable
+
+package fdw
+
+import (
+	"github.com/rqlite/go-sqlite3"
+)
+
+// Supported is whether this build of rqlite supports remote tables.
+const Supported = false
+
+// ConnectHook returns ErrNotSupported, since this build of rqlite does not
+// support SQLite virtual tables.
+func (c *Client) ConnectHook(conn *sqlite3.SQLiteConn, readOnly bool) error {
+	return ErrNotSupported
+}
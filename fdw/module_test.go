@@ -0,0 +1,92 @@
+//go:build sqlite_vtable
+// +build sqlite_vtable
+
+package fdw
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/rqlite/rqlite/db"
+)
+
+func Test_RemoteTable(t *testing.T) {
+	var queries []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var stmts [][]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&stmts); err != nil {
+			t.Fatalf("failed to decode request: %s", err.Error())
+		}
+		queries = append(queries, stmts[0][0].(string))
+		if len(stmts[0]) > 1 {
+			w.Write([]byte(`{"results":[{"values":[[2,"declan"]]}]}`))
+			return
+		}
+		w.Write([]byte(`{"results":[{"values":[[1,"fiona"],[2,"declan"]]}]}`))
+	}))
+	defer ts.Close()
+
+	c, err := NewClient(Remotes{"reporting": {URL: ts.URL}})
+	if err != nil {
+		t.Fatalf("failed to create client: %s", err.Error())
+	}
+	db.SetConnectHook(c.ConnectHook)
+	defer db.SetConnectHook(nil)
+
+	d, err := db.Open(filepath.Join(t.TempDir(), "db.sqlite"), false, true)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err.Error())
+	}
+	defer d.Close()
+
+	mustExecute := func(stmt string) {
+		t.Helper()
+		r, err := d.ExecuteStringStmt(stmt)
+		if err != nil {
+			t.Fatalf("failed to execute %s: %s", stmt, err.Error())
+		}
+		if r[0].Error != "" {
+			t.Fatalf("failed to execute %s: %s", stmt, r[0].Error)
+		}
+	}
+	mustExecute(`CREATE VIRTUAL TABLE remote_users USING rqlite_remote(reporting, users, id INTEGER, name TEXT)`)
+	mustExecute(`CREATE TABLE orders (id INTEGER PRIMARY KEY, user_id INTEGER)`)
+	mustExecute(`INSERT INTO orders(id, user_id) VALUES(10, 2)`)
+	if len(queries) != 0 {
+		t.Fatalf("remote queried when remote table created")
+	}
+
+	r, err := d.QueryStringStmt(`SELECT o.id, u.name FROM orders o JOIN remote_users u ON u.id = o.user_id`)
+	if err != nil {
+		t.Fatalf("failed to query: %s", err.Error())
+	}
+	if r[0].Error != "" {
+		t.Fatalf("failed to query: %s", r[0].Error)
+	}
+	if len(r[0].Values) != 1 || r[0].Values[0].Parameters[1].GetS() != "declan" {
+		t.Fatalf("wrong results of join with remote table: %v", r[0].Values)
+	}
+
+	r, err = d.QueryStringStmt(`SELECT name FROM remote_users WHERE id = 2`)
+	if err != nil {
+		t.Fatalf("failed to query: %s", err.Error())
+	}
+	if len(r[0].Values) != 1 || r[0].Values[0].Parameters[0].GetS() != "declan" {
+		t.Fatalf("wrong results of query of remote table: %v", r[0].Values)
+	}
+	if exp, got := `SELECT "id", "name" FROM "users" WHERE "id" = ?`, queries[len(queries)-1]; exp != got {
+		t.Fatalf("constraint not pushed to remote, exp %s, got %s", exp, got)
+	}
+
+	// Remote tables may not be read when changing the database.
+	res, err := d.ExecuteStringStmt(`INSERT INTO orders(id, user_id) SELECT 11, id FROM remote_users`)
+	if err != nil {
+		t.Fatalf("failed to execute: %s", err.Error())
+	}
+	if res[0].Error == "" {
+		t.Fatalf("expected error reading remote table during execute")
+	}
+}
@@ -0,0 +1,315 @@
+// Package gcp provides a client for Google Cloud Storage, used for automatic
+// backups to, and restores from, GCS buckets.
+package gcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/rqlite/rqlite/auto"
+	"github.com/rqlite/rqlite/internal/offset"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	// DefaultEndpoint is the default Google Cloud Storage endpoint.
+	DefaultEndpoint = "https://storage.googleapis.com"
+
+	// scopeReadWrite is the OAuth2 scope required to read and write objects.
+	scopeReadWrite = "https://www.googleapis.com/auth/devstorage.read_write"
+
+	// chunkSize is the size of each chunk of a resumable upload. It must be
+	// a multiple of 256 KiB.
+	chunkSize = 8 * 1024 * 1024
+)
+
+// GCSConfig is the subconfig for the GCS storage type
+type GCSConfig struct {
+	Endpoint        string `json:"endpoint,omitempty"`
+	CredentialsFile string `json:"credentials_file,omitempty"`
+	Bucket          string `json:"bucket"`
+	Name            string `json:"name"`
+}
+
+// GCSClient is a client for uploading data to, and downloading data from,
+// Google Cloud Storage.
+type GCSClient struct {
+	endpoint        string
+	credentialsFile string
+	bucket          string
+	name            string
+
+	// This field is used for testing via dependency injection.
+	client *http.Client
+}
+
+// NewGCSClient returns an instance of a GCSClient. If credentialsFile is
+// set, it must be the path to a service account key file. Otherwise
+// Application Default Credentials are used, which includes workload
+// identity when running in GKE.
+func NewGCSClient(endpoint, credentialsFile, bucket, name string) *GCSClient {
+	if endpoint == "" {
+		endpoint = DefaultEndpoint
+	}
+	return &GCSClient{
+		endpoint:        endpoint,
+		credentialsFile: credentialsFile,
+		bucket:          bucket,
+		name:            name,
+	}
+}
+
+// String returns a string representation of the GCSClient.
+func (g *GCSClient) String() string {
+	return fmt.Sprintf("gs://%s/%s", g.bucket, g.name)
+}
+
+// Upload uploads data to GCS. The data is uploaded in chunks, as a resumable
+// upload, so that the size of the data need not be known in advance.
+func (g *GCSClient) Upload(ctx context.Context, reader io.Reader) error {
+	return g.upload(ctx, g.name, reader)
+}
+
+// Name returns the name of the object to which data is uploaded.
+func (g *GCSClient) Name() string {
+	return g.name
+}
+
+// UploadTo uploads data to the given object, rather than the client's own.
+func (g *GCSClient) UploadTo(ctx context.Context, name string, reader io.Reader) error {
+	return g.upload(ctx, name, reader)
+}
+
+// UploadDelta uploads the delta of an incremental backup to GCS, alongside
+// the full backup.
+func (g *GCSClient) UploadDelta(ctx context.Context, reader io.Reader) error {
+	return g.upload(ctx, g.name+auto.DeltaSuffix, reader)
+}
+
+func (g *GCSClient) upload(ctx context.Context, name string, reader io.Reader) error {
+	client, err := g.httpClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Start the resumable upload, which returns the URI of the session to
+	// which the data is then uploaded.
+	q := url.Values{}
+	q.Set("uploadType", "resumable")
+	q.Set("name", name)
+	u := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?%s", g.endpoint, url.PathEscape(g.bucket), q.Encode())
+	resp, err := g.do(ctx, client, http.MethodPost, u, nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start upload to %s: %w", g.objectString(name), err)
+	}
+	resp.Body.Close()
+	session := resp.Header.Get("Location")
+	if session == "" {
+		return fmt.Errorf("failed to start upload to %s: no session URI returned", g.objectString(name))
+	}
+
+	buf := make([]byte, chunkSize)
+	var off int64
+	for {
+		n, err := io.ReadFull(reader, buf)
+		last := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !last {
+			return err
+		}
+
+		// Every chunk but the last leaves the total size unknown.
+		hdr := http.Header{}
+		switch {
+		case !last:
+			hdr.Set("Content-Range", fmt.Sprintf("bytes %d-%d/*", off, off+int64(n)-1))
+		case n > 0:
+			hdr.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", off, off+int64(n)-1, off+int64(n)))
+		default:
+			hdr.Set("Content-Range", fmt.Sprintf("bytes */%d", off))
+		}
+		resp, err := g.do(ctx, client, http.MethodPut, session, hdr, bytes.NewReader(buf[:n]))
+		if err != nil {
+			return fmt.Errorf("failed to upload to %s: %w", g.objectString(name), err)
+		}
+		resp.Body.Close()
+		if last {
+			return nil
+		}
+		off += int64(n)
+	}
+}
+
+// Download downloads data from GCS.
+func (g *GCSClient) Download(ctx context.Context, writer io.WriterAt) error {
+	return g.DownloadSequential(ctx, offset.NewWriter(writer))
+}
+
+// DownloadSequential downloads data from GCS, writing it to w in order.
+func (g *GCSClient) DownloadSequential(ctx context.Context, w io.Writer) error {
+	client, err := g.httpClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	u := fmt.Sprintf("%s/storage/v1/b/%s/o/%s?alt=media", g.endpoint,
+		url.PathEscape(g.bucket), url.PathEscape(g.name))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download from %v: %w", g, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download from %v: %w", g,
+			&auto.StatusError{Code: resp.StatusCode, Status: resp.Status})
+	}
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("failed to download from %v: %w", g, err)
+	}
+	return nil
+}
+
+// CopyVersion copies the object to the given version of it, which is stored
+// alongside it. The copy is made by GCS itself.
+func (g *GCSClient) CopyVersion(ctx context.Context, version string) error {
+	client, err := g.httpClient(ctx)
+	if err != nil {
+		return err
+	}
+	u := fmt.Sprintf("%s/copyTo/b/%s/o/%s", g.objectURL(g.name), url.PathEscape(g.bucket),
+		url.PathEscape(g.versionName(version)))
+	resp, err := g.do(ctx, client, http.MethodPost, u, nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to copy %v to version %s: %w", g, version, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// ListVersions returns the versions of the object stored in GCS.
+func (g *GCSClient) ListVersions(ctx context.Context) ([]string, error) {
+	client, err := g.httpClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	prefix := g.versionName("")
+	var versions []string
+	pageToken := ""
+	for {
+		q := url.Values{}
+		q.Set("prefix", prefix)
+		if pageToken != "" {
+			q.Set("pageToken", pageToken)
+		}
+		u := fmt.Sprintf("%s/storage/v1/b/%s/o?%s", g.endpoint, url.PathEscape(g.bucket), q.Encode())
+		resp, err := g.do(ctx, client, http.MethodGet, u, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list versions of %v: %w", g, err)
+		}
+		var list struct {
+			Items []struct {
+				Name string `json:"name"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode versions of %v: %w", g, err)
+		}
+		for _, item := range list.Items {
+			versions = append(versions, strings.TrimPrefix(item.Name, prefix))
+		}
+		if list.NextPageToken == "" {
+			return versions, nil
+		}
+		pageToken = list.NextPageToken
+	}
+}
+
+// DeleteVersion deletes the given version of the object from GCS.
+func (g *GCSClient) DeleteVersion(ctx context.Context, version string) error {
+	client, err := g.httpClient(ctx)
+	if err != nil {
+		return err
+	}
+	resp, err := g.do(ctx, client, http.MethodDelete, g.objectURL(g.versionName(version)), nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete version %s of %v: %w", version, g, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (g *GCSClient) versionName(version string) string {
+	return g.name + "." + version
+}
+
+// objectURL returns the URL of the named object's metadata.
+func (g *GCSClient) objectURL(name string) string {
+	return fmt.Sprintf("%s/storage/v1/b/%s/o/%s", g.endpoint, url.PathEscape(g.bucket), url.PathEscape(name))
+}
+
+func (g *GCSClient) objectString(name string) string {
+	return fmt.Sprintf("gs://%s/%s", g.bucket, name)
+}
+
+// do performs a request with the additional headers hdr, returning the
+// response if its status indicates success. A resumable upload returns
+// status 308 for each chunk but the last, which is also a success.
+func (g *GCSClient) do(ctx context.Context, client *http.Client, method, u string, hdr http.Header,
+	body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range hdr {
+		req.Header[k] = v
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if (resp.StatusCode < 200 || resp.StatusCode > 299) && resp.StatusCode != http.StatusPermanentRedirect {
+		resp.Body.Close()
+		return nil, &auto.StatusError{Code: resp.StatusCode, Status: resp.Status}
+	}
+	return resp, nil
+}
+
+func (g *GCSClient) httpClient(ctx context.Context) (*http.Client, error) {
+	if g.client != nil {
+		return g.client, nil
+	}
+
+	var creds *google.Credentials
+	var err error
+	if g.credentialsFile != "" {
+		b, err := os.ReadFile(g.credentialsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read GCS credentials file: %w", err)
+		}
+		creds, err = google.CredentialsFromJSON(ctx, b, scopeReadWrite)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load GCS credentials: %w", err)
+		}
+	} else {
+		creds, err = google.FindDefaultCredentials(ctx, scopeReadWrite)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find default GCS credentials: %w", err)
+		}
+	}
+	return oauth2.NewClient(ctx, creds.TokenSource), nil
+}
@@ -0,0 +1,245 @@
+package gcp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_NewGCSClient(t *testing.T) {
+	c := NewGCSClient("", "creds.json", "bucket2", "name3")
+	if c.endpoint != DefaultEndpoint {
+		t.Fatalf("expected endpoint to be %q, got %q", DefaultEndpoint, c.endpoint)
+	}
+	if c.credentialsFile != "creds.json" {
+		t.Fatalf("expected credentialsFile to be %q, got %q", "creds.json", c.credentialsFile)
+	}
+	if c.bucket != "bucket2" {
+		t.Fatalf("expected bucket to be %q, got %q", "bucket2", c.bucket)
+	}
+	if c.name != "name3" {
+		t.Fatalf("expected name to be %q, got %q", "name3", c.name)
+	}
+}
+
+func Test_GCSClient_String(t *testing.T) {
+	c := NewGCSClient("", "", "bucket2", "name3")
+	if c.String() != "gs://bucket2/name3" {
+		t.Fatalf("expected String() to be %q, got %q", "gs://bucket2/name3", c.String())
+	}
+}
+
+func Test_GCSClientDownloadOK(t *testing.T) {
+	expectedData := "test data"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.EscapedPath() != "/storage/v1/b/your-bucket/o/your%2Fname" {
+			t.Errorf("unexpected path: %s", r.URL.EscapedPath())
+		}
+		if r.URL.Query().Get("alt") != "media" {
+			t.Errorf("expected alt=media, got %q", r.URL.RawQuery)
+		}
+		w.Write([]byte(expectedData))
+	}))
+	defer ts.Close()
+
+	c := NewGCSClient(ts.URL, "", "your-bucket", "your/name")
+	c.client = ts.Client()
+
+	f := mustTempFile(t)
+	if err := c.Download(context.Background(), f); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatalf("failed to read downloaded file: %v", err)
+	}
+	if string(b) != expectedData {
+		t.Fatalf("expected downloaded data to be %q, got %q", expectedData, string(b))
+	}
+}
+
+func Test_GCSClientDownloadFail(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	c := NewGCSClient(ts.URL, "", "your-bucket", "your-name")
+	c.client = ts.Client()
+
+	f := mustTempFile(t)
+	err := c.Download(context.Background(), f)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if !strings.Contains(err.Error(), "404") {
+		t.Fatalf("expected error to contain status, got %q", err.Error())
+	}
+}
+
+func Test_GCSClientUploadOK(t *testing.T) {
+	var uploaded []byte
+	var ranges []string
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			if r.URL.EscapedPath() != "/upload/storage/v1/b/your-bucket/o" {
+				t.Errorf("unexpected path: %s", r.URL.EscapedPath())
+			}
+			if r.URL.Query().Get("uploadType") != "resumable" {
+				t.Errorf("expected uploadType=resumable, got %q", r.URL.RawQuery)
+			}
+			if r.URL.Query().Get("name") != "your/name" {
+				t.Errorf("expected name to be your/name, got %q", r.URL.Query().Get("name"))
+			}
+			w.Header().Set("Location", ts.URL+"/session/1")
+		case http.MethodPut:
+			if r.URL.Path != "/session/1" {
+				t.Errorf("unexpected session path: %s", r.URL.Path)
+			}
+			ranges = append(ranges, r.Header.Get("Content-Range"))
+			b, _ := io.ReadAll(r.Body)
+			uploaded = append(uploaded, b...)
+		default:
+			t.Errorf("unexpected method: %s", r.Method)
+		}
+	}))
+	defer ts.Close()
+
+	c := NewGCSClient(ts.URL, "", "your-bucket", "your/name")
+	c.client = ts.Client()
+	if err := c.Upload(context.Background(), strings.NewReader("test data")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(uploaded) != "test data" {
+		t.Fatalf("expected uploaded data to be %q, got %q", "test data", string(uploaded))
+	}
+	if len(ranges) != 1 || ranges[0] != "bytes 0-8/9" {
+		t.Fatalf("unexpected content ranges: %v", ranges)
+	}
+}
+
+func Test_GCSClientUploadChunks(t *testing.T) {
+	var uploaded int
+	var ranges []string
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.Header().Set("Location", ts.URL+"/session/1")
+			return
+		}
+		rng := r.Header.Get("Content-Range")
+		ranges = append(ranges, rng)
+		b, _ := io.ReadAll(r.Body)
+		uploaded += len(b)
+		if strings.HasSuffix(rng, "/*") {
+			w.WriteHeader(http.StatusPermanentRedirect)
+		}
+	}))
+	defer ts.Close()
+
+	c := NewGCSClient(ts.URL, "", "your-bucket", "your-name")
+	c.client = ts.Client()
+	if err := c.UploadTo(context.Background(), "other-name", bytes.NewReader(make([]byte, 2*chunkSize))); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if uploaded != 2*chunkSize {
+		t.Fatalf("expected %d bytes uploaded, got %d", 2*chunkSize, uploaded)
+	}
+	exp := []string{
+		fmt.Sprintf("bytes 0-%d/*", chunkSize-1),
+		fmt.Sprintf("bytes %d-%d/*", chunkSize, 2*chunkSize-1),
+		fmt.Sprintf("bytes */%d", 2*chunkSize),
+	}
+	if !reflect.DeepEqual(ranges, exp) {
+		t.Fatalf("unexpected content ranges, exp %v, got %v", exp, ranges)
+	}
+}
+
+func Test_GCSClientUploadFail(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer ts.Close()
+
+	c := NewGCSClient(ts.URL, "", "your-bucket", "your-name")
+	c.client = ts.Client()
+	err := c.Upload(context.Background(), strings.NewReader("test data"))
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if !strings.Contains(err.Error(), "403") {
+		t.Fatalf("expected error to contain status, got %q", err.Error())
+	}
+}
+
+func Test_GCSClientVersions(t *testing.T) {
+	var copied, deleted string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			copied = r.URL.EscapedPath()
+		case http.MethodGet:
+			if r.URL.Query().Get("prefix") != "your-name." {
+				t.Errorf("unexpected prefix: %q", r.URL.Query().Get("prefix"))
+			}
+			if r.URL.Query().Get("pageToken") == "" {
+				w.Write([]byte(`{"items": [{"name": "your-name.v1"}], "nextPageToken": "p2"}`))
+			} else {
+				w.Write([]byte(`{"items": [{"name": "your-name.v2"}]}`))
+			}
+		case http.MethodDelete:
+			deleted = r.URL.EscapedPath()
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer ts.Close()
+
+	c := NewGCSClient(ts.URL, "", "your-bucket", "your-name")
+	c.client = ts.Client()
+	if err := c.CopyVersion(context.Background(), "v3"); err != nil {
+		t.Fatalf("failed to copy version: %v", err)
+	}
+	if exp := "/storage/v1/b/your-bucket/o/your-name/copyTo/b/your-bucket/o/your-name.v3"; copied != exp {
+		t.Fatalf("unexpected copy path, exp %s, got %s", exp, copied)
+	}
+	versions, err := c.ListVersions(context.Background())
+	if err != nil {
+		t.Fatalf("failed to list versions: %v", err)
+	}
+	if exp := []string{"v1", "v2"}; !reflect.DeepEqual(versions, exp) {
+		t.Fatalf("unexpected versions, exp %v, got %v", exp, versions)
+	}
+	if err := c.DeleteVersion(context.Background(), "v1"); err != nil {
+		t.Fatalf("failed to delete version: %v", err)
+	}
+	if exp := "/storage/v1/b/your-bucket/o/your-name.v1"; deleted != exp {
+		t.Fatalf("unexpected delete path, exp %s, got %s", exp, deleted)
+	}
+}
+
+func Test_GCSClientBadCredentials(t *testing.T) {
+	c := NewGCSClient("", "/does/not/exist.json", "your-bucket", "your-name")
+	f := mustTempFile(t)
+	if err := c.Download(context.Background(), f); err == nil {
+		t.Fatal("expected error with missing credentials file, got nil")
+	}
+}
+
+func mustTempFile(t *testing.T) *os.File {
+	t.Helper()
+	f, err := os.CreateTemp(t.TempDir(), "gcs")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
@@ -0,0 +1,43 @@
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"net"
+
+	"github.com/rqlite/rqlite/tcp"
+)
+
+// MuxDialer returns a dialer of the gRPC API served over the node-to-node
+// mux, for use with grpc.WithContextDialer. If the mux uses TLS, tlsConfig
+// must be set, and since the connection is then already encrypted, the
+// client's transport credentials should be insecure.
+func MuxDialer(tlsConfig *tls.Config) func(context.Context, string) (net.Conn, error) {
+	d := tcp.NewDialer(MuxHeader, tlsConfig)
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		return d.Dial(addr, timeout(ctx))
+	}
+}
+
+// BasicAuth is per-RPC credentials, for use with grpc.WithPerRPCCredentials,
+// which authenticate requests with a username and password.
+type BasicAuth struct {
+	Username string
+	Password string
+
+	// Insecure allows the credentials to be sent over connections which are
+	// not encrypted by the gRPC transport, such as over a mux using TLS.
+	Insecure bool
+}
+
+// GetRequestMetadata implements credentials.PerRPCCredentials.
+func (b *BasicAuth) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	creds := base64.StdEncoding.EncodeToString([]byte(b.Username + ":" + b.Password))
+	return map[string]string{"authorization": "Basic " + creds}, nil
+}
+
+// RequireTransportSecurity implements credentials.PerRPCCredentials.
+func (b *BasicAuth) RequireTransportSecurity() bool {
+	return !b.Insecure
+}
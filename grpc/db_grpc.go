@@ -0,0 +1,173 @@
+package grpc
+
+import (
+	"context"
+
+	"github.com/rqlite/rqlite/command"
+	"google.golang.org/grpc"
+)
+
+// DBServer is the server API of the DB service.
+type DBServer interface {
+	// Execute executes statements which modify the database.
+	Execute(context.Context, *command.ExecuteRequest) (*ExecuteResponse, error)
+
+	// Query executes queries, returning all their results at once.
+	Query(context.Context, *command.QueryRequest) (*QueryResponse, error)
+
+	// QueryStream executes queries, streaming their results as they are
+	// read.
+	QueryStream(*command.QueryRequest, DB_QueryStreamServer) error
+}
+
+// DB_QueryStreamServer is the server side of a QueryStream call.
+type DB_QueryStreamServer interface {
+	Send(*QueryStreamResponse) error
+	grpc.ServerStream
+}
+
+type dbQueryStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *dbQueryStreamServer) Send(m *QueryStreamResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// RegisterDBServer registers srv as the DB service of s.
+func RegisterDBServer(s grpc.ServiceRegistrar, srv DBServer) {
+	s.RegisterService(&dbServiceDesc, srv)
+}
+
+func dbExecuteHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(command.ExecuteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DBServer).Execute(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rqlite.DB/Execute",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DBServer).Execute(ctx, req.(*command.ExecuteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func dbQueryHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(command.QueryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DBServer).Query(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rqlite.DB/Query",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DBServer).Query(ctx, req.(*command.QueryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func dbQueryStreamHandler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(command.QueryRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DBServer).QueryStream(m, &dbQueryStreamServer{stream})
+}
+
+// dbServiceDesc describes the DB service of rqlite.proto.
+var dbServiceDesc = grpc.ServiceDesc{
+	ServiceName: "rqlite.DB",
+	HandlerType: (*DBServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Execute",
+			Handler:    dbExecuteHandler,
+		},
+		{
+			MethodName: "Query",
+			Handler:    dbQueryHandler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "QueryStream",
+			Handler:       dbQueryStreamHandler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "rqlite.proto",
+}
+
+// DBClient is the client API of the DB service.
+type DBClient interface {
+	Execute(ctx context.Context, in *command.ExecuteRequest, opts ...grpc.CallOption) (*ExecuteResponse, error)
+	Query(ctx context.Context, in *command.QueryRequest, opts ...grpc.CallOption) (*QueryResponse, error)
+	QueryStream(ctx context.Context, in *command.QueryRequest, opts ...grpc.CallOption) (DB_QueryStreamClient, error)
+}
+
+// DB_QueryStreamClient is the client side of a QueryStream call.
+type DB_QueryStreamClient interface {
+	Recv() (*QueryStreamResponse, error)
+	grpc.ClientStream
+}
+
+type dbClient struct {
+	cc grpc.ClientConnInterface
+}
+
+// NewDBClient returns a client of the DB service over cc.
+func NewDBClient(cc grpc.ClientConnInterface) DBClient {
+	return &dbClient{cc}
+}
+
+func (c *dbClient) Execute(ctx context.Context, in *command.ExecuteRequest, opts ...grpc.CallOption) (*ExecuteResponse, error) {
+	out := new(ExecuteResponse)
+	if err := c.cc.Invoke(ctx, "/rqlite.DB/Execute", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dbClient) Query(ctx context.Context, in *command.QueryRequest, opts ...grpc.CallOption) (*QueryResponse, error) {
+	out := new(QueryResponse)
+	if err := c.cc.Invoke(ctx, "/rqlite.DB/Query", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dbClient) QueryStream(ctx context.Context, in *command.QueryRequest, opts ...grpc.CallOption) (DB_QueryStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &dbServiceDesc.Streams[0], "/rqlite.DB/QueryStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &dbQueryStreamClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type dbQueryStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *dbQueryStreamClient) Recv() (*QueryStreamResponse, error) {
+	m := new(QueryStreamResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
@@ -0,0 +1,208 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	grpcstats "google.golang.org/grpc/stats"
+)
+
+// ConnTracker is the interface to a registry of client connections, such as
+// that of the HTTP service, to which the service reports its connections and
+// the RPCs made on each. A connection is terminated by closing it.
+type ConnTracker interface {
+	// ConnOpened records a connection accepted by the named API.
+	ConnOpened(c net.Conn, api string)
+
+	// ConnClosed records that a connection is closed.
+	ConnClosed(c net.Conn)
+
+	// RequestBegun records the start of a request, described by desc, made
+	// on c by the named user, if any. It returns a function to be called
+	// once the request has been served.
+	RequestBegun(c net.Conn, user, desc string) func()
+}
+
+// connKey is the context key of the connection of an RPC.
+type connKey struct{}
+
+// rpcKey is the context key of the state of an RPC.
+type rpcKey struct{}
+
+// rpc is the state of an RPC recorded by the handler. It holds the func
+// which releases the memory reserved for the results of the RPC, until the
+// RPC has ended and the results have been sent.
+type rpc struct {
+	method string
+
+	mu      sync.Mutex
+	release func()
+	done    func()
+	ended   bool
+}
+
+// hold holds release until the RPC of ctx ends. If ctx is not that of an
+// RPC, or the RPC has already ended, release is called immediately.
+func hold(ctx context.Context, release func()) {
+	r, ok := ctx.Value(rpcKey{}).(*rpc)
+	if !ok {
+		release()
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.ended {
+		release()
+		return
+	}
+	r.release = release
+}
+
+// end calls any release func held for the RPC, which has ended, and reports
+// its end to any tracker.
+func (r *rpc) end() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ended = true
+	if r.release != nil {
+		r.release()
+		r.release = nil
+	}
+	if r.done != nil {
+		r.done()
+		r.done = nil
+	}
+}
+
+// handler is the stats handler of the service. It releases the memory held
+// for the results of each RPC once the RPC has ended, since the response of
+// a unary RPC is only sent after its handler has returned. If tracker is
+// set, it also reports each connection, and the RPCs on each, to tracker.
+type handler struct {
+	tracker ConnTracker
+
+	mu      sync.Mutex
+	pending map[string]*clientConn
+}
+
+func newHandler(tracker ConnTracker) *handler {
+	return &handler{
+		tracker: tracker,
+		pending: make(map[string]*clientConn),
+	}
+}
+
+// accepted records a connection accepted by the service. The gRPC server
+// identifies connections to a stats handler only by address, so the
+// connection is held, by remote address, until the server reports it.
+func (h *handler) accepted(c net.Conn) net.Conn {
+	cc := &clientConn{Conn: c, h: h}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.pending[c.RemoteAddr().String()] = cc
+	return cc
+}
+
+// take returns, and forgets, the accepted connection with the given remote
+// address, or nil if there is none.
+func (h *handler) take(addr string) *clientConn {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	cc := h.pending[addr]
+	delete(h.pending, addr)
+	return cc
+}
+
+// closed records that cc is closed, whether or not the server reported it.
+func (h *handler) closed(cc *clientConn) {
+	h.mu.Lock()
+	if h.pending[cc.RemoteAddr().String()] == cc {
+		delete(h.pending, cc.RemoteAddr().String())
+	}
+	h.mu.Unlock()
+	h.tracker.ConnClosed(cc)
+}
+
+// TagRPC implements stats.Handler.
+func (h *handler) TagRPC(ctx context.Context, info *grpcstats.RPCTagInfo) context.Context {
+	return context.WithValue(ctx, rpcKey{}, &rpc{method: info.FullMethodName})
+}
+
+// HandleRPC implements stats.Handler.
+func (h *handler) HandleRPC(ctx context.Context, s grpcstats.RPCStats) {
+	r, ok := ctx.Value(rpcKey{}).(*rpc)
+	if !ok {
+		return
+	}
+	switch s.(type) {
+	case *grpcstats.Begin:
+		c, ok := ctx.Value(connKey{}).(net.Conn)
+		if !ok {
+			return
+		}
+		user, _, _ := basicAuth(ctx)
+		done := h.tracker.RequestBegun(c, user, r.method)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.ended {
+			done()
+			return
+		}
+		r.done = done
+	case *grpcstats.End:
+		r.end()
+	}
+}
+
+// TagConn implements stats.Handler.
+func (h *handler) TagConn(ctx context.Context, info *grpcstats.ConnTagInfo) context.Context {
+	if h.tracker == nil || info.RemoteAddr == nil {
+		return ctx
+	}
+	cc := h.take(info.RemoteAddr.String())
+	if cc == nil {
+		return ctx
+	}
+	h.tracker.ConnOpened(cc, "grpc")
+	return context.WithValue(ctx, connKey{}, net.Conn(cc))
+}
+
+// HandleConn implements stats.Handler.
+func (h *handler) HandleConn(ctx context.Context, s grpcstats.ConnStats) {
+	if _, ok := s.(*grpcstats.ConnEnd); !ok {
+		return
+	}
+	if c, ok := ctx.Value(connKey{}).(net.Conn); ok {
+		h.tracker.ConnClosed(c)
+	}
+}
+
+// clientConn is a connection accepted by the service, which reports its
+// closing to the handler, so that it is forgotten however it is closed.
+type clientConn struct {
+	net.Conn
+	h    *handler
+	once sync.Once
+}
+
+// Close closes the connection.
+func (c *clientConn) Close() error {
+	c.once.Do(func() { c.h.closed(c) })
+	return c.Conn.Close()
+}
+
+// trackingListener passes each connection it accepts to a handler.
+type trackingListener struct {
+	net.Listener
+	h *handler
+}
+
+// Accept waits for and returns the next connection to the listener.
+func (l *trackingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return l.h.accepted(c), nil
+}
@@ -0,0 +1,66 @@
+package grpc
+
+import (
+	"net"
+	"sync"
+)
+
+// closableListener wraps a listener whose Close may not interrupt Accept,
+// such as a layer of the node-to-node mux, so that the gRPC server, which
+// waits for Accept to return when it stops, can be stopped.
+type closableListener struct {
+	net.Listener
+	accepted chan acceptResult
+	done     chan struct{}
+	once     sync.Once
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+func newClosableListener(ln net.Listener) *closableListener {
+	l := &closableListener{
+		Listener: ln,
+		accepted: make(chan acceptResult),
+		done:     make(chan struct{}),
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			select {
+			case l.accepted <- acceptResult{conn, err}:
+				if err != nil {
+					return
+				}
+			case <-l.done:
+				if conn != nil {
+					conn.Close()
+				}
+				return
+			}
+		}
+	}()
+	return l
+}
+
+// Accept waits for and returns the next connection to the listener.
+func (l *closableListener) Accept() (net.Conn, error) {
+	select {
+	case r := <-l.accepted:
+		return r.conn, r.err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes the listener, and the listener it wraps.
+func (l *closableListener) Close() error {
+	var err error
+	l.once.Do(func() {
+		close(l.done)
+		err = l.Listener.Close()
+	})
+	return err
+}
@@ -0,0 +1,449 @@
+// Package grpc provides a gRPC API to the database, alongside the HTTP API,
+// for clients which would rather not encode and decode JSON on every request.
+// Requests are the protobuf types of the command package, and the results of
+// queries may be streamed as they are read.
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"expvar"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rqlite/rqlite/auth"
+	"github.com/rqlite/rqlite/cluster"
+	"github.com/rqlite/rqlite/command"
+	"github.com/rqlite/rqlite/db"
+	"github.com/rqlite/rqlite/store"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// MuxHeader is the byte with which connections to the gRPC API over the
+	// node-to-node mux begin. It must differ from the headers of the cluster
+	// package.
+	MuxHeader = 3
+
+	// DefaultStreamBatchSize is the number of rows sent in each message of
+	// a streamed query, if StreamBatchSize is not set.
+	DefaultStreamBatchSize = 500
+
+	// defaultTimeout is the timeout of requests forwarded to the leader,
+	// if the client set no deadline.
+	defaultTimeout = 30 * time.Second
+)
+
+// stats captures stats for the gRPC service.
+var stats *expvar.Map
+
+const (
+	numExecutions     = "executions"
+	numQueries        = "queries"
+	numQueryStreams   = "query_streams"
+	numRemoteRequests = "remote_requests"
+	numAuthFail       = "auth_fail"
+)
+
+func init() {
+	stats = expvar.NewMap("grpc")
+	ResetStats()
+}
+
+// ResetStats resets the expvar stats for this module. Mostly for test purposes.
+func ResetStats() {
+	stats.Init()
+	stats.Add(numExecutions, 0)
+	stats.Add(numQueries, 0)
+	stats.Add(numQueryStreams, 0)
+	stats.Add(numRemoteRequests, 0)
+	stats.Add(numAuthFail, 0)
+}
+
+// Store is the interface the Store must implement.
+type Store interface {
+	// Execute executes statements which modify the database.
+	Execute(er *command.ExecuteRequest) ([]*command.ExecuteResult, error)
+
+	// QueryReserved executes queries which return rows. Memory reserved for
+	// the results is held until the returned release func is called.
+	QueryReserved(qr *command.QueryRequest) ([]*command.QueryRows, func(), error)
+
+	// CheckInternal returns an error if any of the statements accesses a
+	// table reserved for rqlite's own use.
+	CheckInternal(stmts []*command.Statement) error
+
+	// LeaderAddr returns the Raft address of the leader.
+	LeaderAddr() (string, error)
+}
+
+// StreamQuerier is implemented by a Store which can stream the results of
+// queries as they are read.
+type StreamQuerier interface {
+	QueryStream(qr *command.QueryRequest, w db.RowsWriter) error
+}
+
+// Cluster is the interface to the other nodes of the cluster, through which
+// requests are forwarded to the leader.
+type Cluster interface {
+	// Execute performs an Execute Request on a remote node.
+	Execute(er *command.ExecuteRequest, nodeAddr string, creds *cluster.Credentials, timeout time.Duration) ([]*command.ExecuteResult, error)
+
+	// Query performs an Query Request on a remote node.
+	Query(qr *command.QueryRequest, nodeAddr string, creds *cluster.Credentials, timeout time.Duration) ([]*command.QueryRows, error)
+}
+
+// CredentialStore is the interface credential stores must support.
+type CredentialStore interface {
+	// AA authenticates and checks authorization for the given perm.
+	AA(username, password, perm string) bool
+}
+
+// Service serves the gRPC API.
+type Service struct {
+	ln      net.Listener
+	store   Store
+	cluster Cluster
+	creds   CredentialStore
+	server  *grpc.Server
+
+	// StreamBatchSize is the number of rows sent in each message of a
+	// streamed query. If zero, DefaultStreamBatchSize is used.
+	StreamBatchSize int
+
+	// TLSConfig, if set, is the configuration with which connections are
+	// served over TLS. It must be set before the service is started.
+	TLSConfig *tls.Config
+
+	// ConnTracker, if set, is the registry to which client connections, and
+	// the RPCs on each, are reported. It must be set before the service is
+	// started.
+	ConnTracker ConnTracker
+
+	logger *log.Logger
+}
+
+// New returns a Service serving the gRPC API on ln. creds may be nil, in
+// which case requests are not authenticated.
+func New(ln net.Listener, str Store, clstr Cluster, creds CredentialStore) *Service {
+	return &Service{
+		ln:      newClosableListener(ln),
+		store:   str,
+		cluster: clstr,
+		creds:   creds,
+		logger:  log.New(os.Stderr, "[grpc] ", log.LstdFlags),
+	}
+}
+
+// Start starts the service.
+func (s *Service) Start() error {
+	h := newHandler(s.ConnTracker)
+	opts := []grpc.ServerOption{grpc.StatsHandler(h)}
+	if s.TLSConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(s.TLSConfig)))
+	}
+	s.server = grpc.NewServer(opts...)
+	RegisterDBServer(s.server, s)
+	var ln net.Listener = s.ln
+	if s.ConnTracker != nil {
+		ln = &trackingListener{Listener: s.ln, h: h}
+	}
+	go func() {
+		if err := s.server.Serve(ln); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+			s.logger.Printf("gRPC service on %s stopped: %s", s.ln.Addr(), err)
+		}
+	}()
+	s.logger.Printf("service listening on %s", s.ln.Addr())
+	return nil
+}
+
+// Close closes the service, waiting for streamed queries to finish.
+func (s *Service) Close() error {
+	if s.server != nil {
+		s.server.GracefulStop()
+	}
+	return nil
+}
+
+// Addr returns the address on which the service is listening.
+func (s *Service) Addr() net.Addr {
+	return s.ln.Addr()
+}
+
+// Stats returns stats on the service.
+func (s *Service) Stats() (map[string]interface{}, error) {
+	return map[string]interface{}{
+		"addr":              s.ln.Addr().String(),
+		"stream_batch_size": s.streamBatchSize(),
+		"tls":               s.TLSConfig != nil,
+	}, nil
+}
+
+// Execute implements DBServer.
+func (s *Service) Execute(ctx context.Context, er *command.ExecuteRequest) (*ExecuteResponse, error) {
+	username, password, err := s.checkPerm(ctx, auth.PermExecute)
+	if err != nil {
+		return nil, err
+	}
+	if er.GetRequest() == nil {
+		return nil, status.Error(codes.InvalidArgument, "request not set")
+	}
+	if err := s.store.CheckInternal(er.Request.Statements); err != nil {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	stats.Add(numExecutions, 1)
+
+	results, err := s.store.Execute(er)
+	if err == store.ErrNotLeader {
+		var addr string
+		if addr, err = s.leaderAddr(); err != nil {
+			return nil, err
+		}
+		stats.Add(numRemoteRequests, 1)
+		results, err = s.cluster.Execute(er, addr, makeCredentials(username, password), timeout(ctx))
+	}
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &ExecuteResponse{Results: results}, nil
+}
+
+// Query implements DBServer.
+func (s *Service) Query(ctx context.Context, qr *command.QueryRequest) (*QueryResponse, error) {
+	username, password, err := s.checkPerm(ctx, auth.PermQuery)
+	if err != nil {
+		return nil, err
+	}
+	if qr.GetRequest() == nil {
+		return nil, status.Error(codes.InvalidArgument, "request not set")
+	}
+	if err := s.store.CheckInternal(qr.Request.Statements); err != nil {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	stats.Add(numQueries, 1)
+
+	// Hold the memory reserved for the results until they are sent, which
+	// is after this returns.
+	results, release, err := s.query(ctx, qr, username, password)
+	hold(ctx, release)
+	if err != nil {
+		return nil, err
+	}
+	return &QueryResponse{Results: results}, nil
+}
+
+// QueryStream implements DBServer. If the Store can stream results, and the
+// queries need not go through the Raft log, rows are sent as they are read.
+// Otherwise they are sent once all are read.
+func (s *Service) QueryStream(qr *command.QueryRequest, stream DB_QueryStreamServer) error {
+	ctx := stream.Context()
+	username, password, err := s.checkPerm(ctx, auth.PermQuery)
+	if err != nil {
+		return err
+	}
+	if qr.GetRequest() == nil {
+		return status.Error(codes.InvalidArgument, "request not set")
+	}
+	if err := s.store.CheckInternal(qr.Request.Statements); err != nil {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	stats.Add(numQueryStreams, 1)
+
+	sw := &streamWriter{stream: stream, batchSize: s.streamBatchSize()}
+	if sq, ok := s.store.(StreamQuerier); ok && qr.Level != command.QueryRequest_QUERY_REQUEST_LEVEL_STRONG {
+		if err := sq.QueryStream(qr, sw); err != nil {
+			if sw.err != nil {
+				return sw.err
+			}
+			return toStatus(err)
+		}
+		return nil
+	}
+
+	results, release, err := s.query(ctx, qr, username, password)
+	defer release()
+	if err != nil {
+		return err
+	}
+	for _, rows := range results {
+		if err := sw.WriteColumns(rows.Columns, rows.Types); err != nil {
+			return err
+		}
+		for _, v := range rows.Values {
+			if err := sw.WriteRow(v); err != nil {
+				return err
+			}
+		}
+		if err := sw.EndStatement(rows.Error, rows.Time); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// query executes qr, forwarding it to the leader if need be. The caller must
+// call release, which is never nil, once it has finished with the results.
+func (s *Service) query(ctx context.Context, qr *command.QueryRequest, username, password string) ([]*command.QueryRows, func(), error) {
+	results, release, err := s.store.QueryReserved(qr)
+	if err == store.ErrNotLeader {
+		release()
+		release = func() {}
+		var addr string
+		if addr, err = s.leaderAddr(); err != nil {
+			return nil, release, err
+		}
+		stats.Add(numRemoteRequests, 1)
+		results, err = s.cluster.Query(qr, addr, makeCredentials(username, password), timeout(ctx))
+	}
+	if err != nil {
+		return nil, release, toStatus(err)
+	}
+	return results, release, nil
+}
+
+func (s *Service) leaderAddr() (string, error) {
+	addr, err := s.store.LeaderAddr()
+	if err != nil {
+		return "", toStatus(err)
+	}
+	if addr == "" {
+		return "", status.Error(codes.Unavailable, "leader not found")
+	}
+	return addr, nil
+}
+
+// checkPerm checks the credentials of the request, sent as HTTP basic
+// authentication in the authorization metadata, grant perm. It returns the
+// credentials, so that they may be passed on to the leader.
+func (s *Service) checkPerm(ctx context.Context, perm string) (string, string, error) {
+	username, password, ok := basicAuth(ctx)
+	if s.creds == nil {
+		return username, password, nil
+	}
+	if !s.creds.AA(username, password, perm) {
+		stats.Add(numAuthFail, 1)
+		if !ok {
+			return "", "", status.Error(codes.Unauthenticated, "credentials required")
+		}
+		return "", "", status.Errorf(codes.PermissionDenied, "%s not permitted", perm)
+	}
+	return username, password, nil
+}
+
+func (s *Service) streamBatchSize() int {
+	if s.StreamBatchSize > 0 {
+		return s.StreamBatchSize
+	}
+	return DefaultStreamBatchSize
+}
+
+// streamWriter is a db.RowsWriter which sends the results of queries to the
+// client, a batch of rows at a time.
+type streamWriter struct {
+	stream    DB_QueryStreamServer
+	batchSize int
+
+	statement int64
+	rows      *command.QueryRows
+	err       error
+}
+
+// WriteColumns implements db.RowsWriter.
+func (w *streamWriter) WriteColumns(columns, types []string) error {
+	w.rows = &command.QueryRows{Columns: columns, Types: types}
+	return nil
+}
+
+// WriteRow implements db.RowsWriter.
+func (w *streamWriter) WriteRow(values *command.Values) error {
+	if w.rows == nil {
+		w.rows = &command.QueryRows{}
+	}
+	w.rows.Values = append(w.rows.Values, values)
+	if len(w.rows.Values) >= w.batchSize {
+		return w.send(false)
+	}
+	return nil
+}
+
+// EndStatement implements db.RowsWriter.
+func (w *streamWriter) EndStatement(errMsg string, t float64) error {
+	if w.rows == nil {
+		w.rows = &command.QueryRows{}
+	}
+	w.rows.Error, w.rows.Time = errMsg, t
+	if err := w.send(true); err != nil {
+		return err
+	}
+	w.statement++
+	return nil
+}
+
+func (w *streamWriter) send(last bool) error {
+	w.err = w.stream.Send(&QueryStreamResponse{
+		Statement: w.statement,
+		Rows:      w.rows,
+		Last:      last,
+	})
+	w.rows = nil
+	return w.err
+}
+
+// basicAuth returns the credentials of HTTP basic authentication in the
+// authorization metadata of the request, if any.
+func basicAuth(ctx context.Context) (string, string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", "", false
+	}
+	for _, v := range md.Get("authorization") {
+		if !strings.HasPrefix(v, "Basic ") {
+			continue
+		}
+		b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(v, "Basic "))
+		if err != nil {
+			continue
+		}
+		if i := strings.IndexByte(string(b), ':'); i >= 0 {
+			return string(b[:i]), string(b[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+// timeout returns the time remaining before the deadline of ctx, or the
+// default timeout if it has none.
+func timeout(ctx context.Context) time.Duration {
+	if d, ok := ctx.Deadline(); ok {
+		return time.Until(d)
+	}
+	return defaultTimeout
+}
+
+// toStatus returns the gRPC status of an error from the Store or cluster.
+func toStatus(err error) error {
+	switch {
+	case errors.Is(err, store.ErrNotOpen), errors.Is(err, store.ErrNotReady), errors.Is(err, store.ErrNotLeader):
+		return status.Error(codes.Unavailable, err.Error())
+	case err.Error() == "unauthorized":
+		return status.Error(codes.PermissionDenied, "remote request not authorized")
+	default:
+		return status.Error(codes.Unknown, err.Error())
+	}
+}
+
+func makeCredentials(username, password string) *cluster.Credentials {
+	return &cluster.Credentials{
+		Username: username,
+		Password: password,
+	}
+}
@@ -0,0 +1,440 @@
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509/pkix"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rqlite/rqlite/cluster"
+	"github.com/rqlite/rqlite/command"
+	"github.com/rqlite/rqlite/db"
+	"github.com/rqlite/rqlite/rtls"
+	"github.com/rqlite/rqlite/store"
+	"github.com/rqlite/rqlite/tcp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	grpcstats "google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+)
+
+func Test_ServiceExecuteQuery(t *testing.T) {
+	str := &mockStore{rows: 1}
+	s := mustNewService(t, str, nil, nil)
+	defer s.Close()
+	c := mustNewClient(t, s.Addr().String())
+
+	er := &command.ExecuteRequest{Request: &command.Request{
+		Statements: []*command.Statement{{Sql: "INSERT INTO foo(id) VALUES(1)"}},
+	}}
+	eresp, err := c.Execute(context.Background(), er)
+	if err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	if len(eresp.Results) != 1 || eresp.Results[0].LastInsertId != 1 {
+		t.Fatalf("wrong execute response: %v", eresp)
+	}
+	if str.executed != "INSERT INTO foo(id) VALUES(1)" {
+		t.Fatalf("wrong statement executed: %s", str.executed)
+	}
+
+	qresp, err := c.Query(context.Background(), mustQueryRequest("SELECT * FROM foo"))
+	if err != nil {
+		t.Fatalf("failed to query: %s", err)
+	}
+	if len(qresp.Results) != 1 || len(qresp.Results[0].Values) != 1 || qresp.Results[0].Columns[0] != "id" {
+		t.Fatalf("wrong query response: %v", qresp)
+	}
+	// Memory held for the results is released once they have been sent.
+	for i := 0; atomic.LoadInt32(&str.released) != 1; i++ {
+		if i == 100 {
+			t.Fatalf("memory held for query results not released")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := c.Query(context.Background(), &command.QueryRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected invalid argument for empty request, got %v", err)
+	}
+	if _, err := c.Query(context.Background(), mustQueryRequest("SELECT * FROM _rqlite_state")); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected permission denied for internal table, got %v", err)
+	}
+	er.Request.Statements[0].Sql = "DELETE FROM _rqlite_state"
+	if _, err := c.Execute(context.Background(), er); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected permission denied for internal table, got %v", err)
+	}
+}
+
+func Test_ServiceQueryStream(t *testing.T) {
+	str := &mockStore{rows: 5}
+	s := mustNewService(t, str, nil, nil)
+	s.StreamBatchSize = 2
+	defer s.Close()
+	c := mustNewClient(t, s.Addr().String())
+
+	for _, lvl := range []command.QueryRequest_Level{
+		command.QueryRequest_QUERY_REQUEST_LEVEL_WEAK,
+		command.QueryRequest_QUERY_REQUEST_LEVEL_STRONG,
+	} {
+		str.streamed = false
+		qr := mustQueryRequest("SELECT * FROM foo")
+		qr.Level = lvl
+		stream, err := c.QueryStream(context.Background(), qr)
+		if err != nil {
+			t.Fatalf("failed to stream query: %s", err)
+		}
+		var sizes []int
+		var last bool
+		for {
+			m, err := stream.Recv()
+			if err != nil {
+				break
+			}
+			if len(sizes) == 0 && m.Rows.Columns[0] != "id" {
+				t.Fatalf("columns not sent in first message: %v", m)
+			}
+			sizes = append(sizes, len(m.Rows.Values))
+			last = m.Last
+		}
+		if exp, got := "[2 2 1]", fmt.Sprint(sizes); exp != got || !last {
+			t.Fatalf("wrong messages for level %s, exp %s, got %s (last %v)", lvl, exp, got, last)
+		}
+		if exp := lvl != command.QueryRequest_QUERY_REQUEST_LEVEL_STRONG; str.streamed != exp {
+			t.Fatalf("wrong use of store streaming for level %s, exp %v", lvl, exp)
+		}
+	}
+}
+
+func Test_ServiceForward(t *testing.T) {
+	str := &mockStore{notLeader: true, leader: "leader:4002"}
+	clstr := &mockCluster{}
+	creds := &mockCredentials{username: "fiona", password: "secret"}
+	s := mustNewService(t, str, clstr, creds)
+	defer s.Close()
+
+	// Requests without credentials are refused.
+	c := mustNewClient(t, s.Addr().String())
+	if _, err := c.Execute(context.Background(), &command.ExecuteRequest{Request: &command.Request{}}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected unauthenticated error, got %v", err)
+	}
+
+	// Requests are forwarded to the leader, with the credentials.
+	c = mustNewClient(t, s.Addr().String(), grpc.WithPerRPCCredentials(&BasicAuth{Username: "fiona", Password: "secret", Insecure: true}))
+	if _, err := c.Execute(context.Background(), &command.ExecuteRequest{Request: &command.Request{}}); err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+	if clstr.addr != "leader:4002" || clstr.creds.Username != "fiona" || clstr.creds.Password != "secret" {
+		t.Fatalf("request not forwarded to leader with credentials: %s, %v", clstr.addr, clstr.creds)
+	}
+
+	// Without a leader, requests are unavailable.
+	str.leader = ""
+	if _, err := c.Execute(context.Background(), &command.ExecuteRequest{Request: &command.Request{}}); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected unavailable error, got %v", err)
+	}
+}
+
+func Test_ServiceMux(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	mux, err := tcp.NewMux(ln, nil)
+	if err != nil {
+		t.Fatalf("failed to create mux: %s", err)
+	}
+	go mux.Serve()
+	defer ln.Close()
+
+	s := New(mux.Listen(MuxHeader), &mockStore{rows: 1}, nil, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service: %s", err)
+	}
+	defer s.Close()
+
+	c := mustNewClient(t, ln.Addr().String(), grpc.WithContextDialer(MuxDialer(nil)))
+	if _, err := c.Query(context.Background(), mustQueryRequest("SELECT * FROM foo")); err != nil {
+		t.Fatalf("failed to query over mux: %s", err)
+	}
+}
+
+func Test_ServiceTLS(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	cert, key, err := rtls.GenerateSelfSignedCert(pkix.Name{CommonName: "rqlite"}, time.Hour, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate self-signed cert: %s", err)
+	}
+	pair, err := tls.X509KeyPair(cert, key)
+	if err != nil {
+		t.Fatalf("failed to load key pair: %s", err)
+	}
+
+	s := New(ln, &mockStore{rows: 1}, nil, nil)
+	s.TLSConfig = &tls.Config{Certificates: []tls.Certificate{pair}}
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service: %s", err)
+	}
+	defer s.Close()
+
+	conn, err := grpc.Dial(s.Addr().String(),
+		grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{InsecureSkipVerify: true})))
+	if err != nil {
+		t.Fatalf("failed to dial: %s", err)
+	}
+	defer conn.Close()
+	if _, err := NewDBClient(conn).Query(context.Background(), mustQueryRequest("SELECT * FROM foo")); err != nil {
+		t.Fatalf("failed to query over TLS: %s", err)
+	}
+
+	c := mustNewClient(t, s.Addr().String())
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := c.Query(ctx, mustQueryRequest("SELECT * FROM foo")); err == nil {
+		t.Fatalf("expected query without TLS to fail")
+	}
+}
+
+func Test_ServiceConnTracker(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	tracker := &mockConnTracker{}
+	s := New(ln, &mockStore{rows: 1}, nil, nil)
+	s.ConnTracker = tracker
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service: %s", err)
+	}
+	defer s.Close()
+
+	c := mustNewClient(t, s.Addr().String(), grpc.WithPerRPCCredentials(&BasicAuth{Username: "fiona", Password: "secret", Insecure: true}))
+	if _, err := c.Query(context.Background(), mustQueryRequest("SELECT * FROM foo")); err != nil {
+		t.Fatalf("failed to query: %s", err)
+	}
+	conn := tracker.wait(t, func(m *mockConnTracker) bool { return len(m.conns) == 1 && m.inFlight == 0 })
+	if tracker.api != "grpc" || tracker.user != "fiona" || tracker.request != "/rqlite.DB/Query" {
+		t.Fatalf("wrong connection or request reported: %s, %s, %s", tracker.api, tracker.user, tracker.request)
+	}
+
+	// Closing the tracked connection terminates it.
+	conn.Close()
+	tracker.wait(t, func(m *mockConnTracker) bool { return len(m.conns) == 0 })
+}
+
+func Test_HoldRelease(t *testing.T) {
+	h := newHandler(nil)
+	ctx := h.TagRPC(context.Background(), &grpcstats.RPCTagInfo{FullMethodName: "/DB/Query"})
+	var released int
+	hold(ctx, func() { released++ })
+	if released != 0 {
+		t.Fatalf("release called before RPC ended")
+	}
+	h.HandleRPC(ctx, &grpcstats.End{})
+	if released != 1 {
+		t.Fatalf("release not called once RPC ended")
+	}
+	hold(ctx, func() { released++ })
+	if released != 2 {
+		t.Fatalf("release not called immediately for ended RPC")
+	}
+	hold(context.Background(), func() { released++ })
+	if released != 3 {
+		t.Fatalf("release not called immediately outside an RPC")
+	}
+}
+
+func mustNewService(t *testing.T, str Store, clstr Cluster, creds CredentialStore) *Service {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	s := New(ln, str, clstr, creds)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service: %s", err)
+	}
+	return s
+}
+
+func mustNewClient(t *testing.T, addr string, opts ...grpc.DialOption) DBClient {
+	t.Helper()
+	opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpc.Dial(addr, opts...)
+	if err != nil {
+		t.Fatalf("failed to dial: %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return NewDBClient(conn)
+}
+
+func mustQueryRequest(sql string) *command.QueryRequest {
+	return &command.QueryRequest{Request: &command.Request{
+		Statements: []*command.Statement{{Sql: sql}},
+	}}
+}
+
+type mockStore struct {
+	rows      int
+	notLeader bool
+	leader    string
+	executed  string
+	streamed  bool
+	released  int32
+}
+
+func (m *mockStore) Execute(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+	if m.notLeader {
+		return nil, store.ErrNotLeader
+	}
+	for _, stmt := range er.Request.Statements {
+		m.executed = stmt.Sql
+	}
+	return []*command.ExecuteResult{{LastInsertId: 1, RowsAffected: 1}}, nil
+}
+
+func (m *mockStore) QueryReserved(qr *command.QueryRequest) ([]*command.QueryRows, func(), error) {
+	rows, err := m.query()
+	return rows, func() { atomic.AddInt32(&m.released, 1) }, err
+}
+
+func (m *mockStore) query() ([]*command.QueryRows, error) {
+	if m.notLeader {
+		return nil, store.ErrNotLeader
+	}
+	rows := &command.QueryRows{Columns: []string{"id"}, Types: []string{"integer"}}
+	for i := 0; i < m.rows; i++ {
+		rows.Values = append(rows.Values, &command.Values{
+			Parameters: []*command.Parameter{{Value: &command.Parameter_I{I: int64(i)}}},
+		})
+	}
+	return []*command.QueryRows{rows}, nil
+}
+
+func (m *mockStore) QueryStream(qr *command.QueryRequest, w db.RowsWriter) error {
+	m.streamed = true
+	results, err := m.query()
+	if err != nil {
+		return err
+	}
+	for _, rows := range results {
+		if err := w.WriteColumns(rows.Columns, rows.Types); err != nil {
+			return err
+		}
+		for _, v := range rows.Values {
+			if err := w.WriteRow(v); err != nil {
+				return err
+			}
+		}
+		if err := w.EndStatement("", 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *mockStore) CheckInternal(stmts []*command.Statement) error {
+	for _, stmt := range stmts {
+		if strings.Contains(stmt.Sql, db.InternalTablePrefix) {
+			return db.ErrInternalTable
+		}
+	}
+	return nil
+}
+
+func (m *mockStore) LeaderAddr() (string, error) {
+	return m.leader, nil
+}
+
+type mockCluster struct {
+	addr  string
+	creds *cluster.Credentials
+}
+
+func (m *mockCluster) Execute(er *command.ExecuteRequest, nodeAddr string, creds *cluster.Credentials, timeout time.Duration) ([]*command.ExecuteResult, error) {
+	m.addr, m.creds = nodeAddr, creds
+	return []*command.ExecuteResult{{}}, nil
+}
+
+func (m *mockCluster) Query(qr *command.QueryRequest, nodeAddr string, creds *cluster.Credentials, timeout time.Duration) ([]*command.QueryRows, error) {
+	m.addr, m.creds = nodeAddr, creds
+	return []*command.QueryRows{{}}, nil
+}
+
+type mockCredentials struct {
+	username string
+	password string
+}
+
+func (m *mockCredentials) AA(username, password, perm string) bool {
+	return username == m.username && password == m.password
+}
+
+type mockConnTracker struct {
+	mu       sync.Mutex
+	conns    []net.Conn
+	api      string
+	user     string
+	request  string
+	inFlight int
+}
+
+func (m *mockConnTracker) ConnOpened(c net.Conn, api string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.conns = append(m.conns, c)
+	m.api = api
+}
+
+func (m *mockConnTracker) ConnClosed(c net.Conn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.conns {
+		if m.conns[i] == c {
+			m.conns = append(m.conns[:i], m.conns[i+1:]...)
+			return
+		}
+	}
+}
+
+func (m *mockConnTracker) RequestBegun(c net.Conn, user, desc string) func() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.user, m.request = user, desc
+	m.inFlight++
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.inFlight--
+	}
+}
+
+// wait waits for cond to hold, returning the first tracked connection, if any.
+func (m *mockConnTracker) wait(t *testing.T, cond func(m *mockConnTracker) bool) net.Conn {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		m.mu.Lock()
+		ok := cond(m)
+		var c net.Conn
+		if len(m.conns) > 0 {
+			c = m.conns[0]
+		}
+		m.mu.Unlock()
+		if ok {
+			return c
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for connection tracker")
+	return nil
+}
@@ -0,0 +1,373 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rqlite/rqlite/auth"
+	"github.com/rqlite/rqlite/command"
+	"github.com/rqlite/rqlite/overload"
+	"github.com/rqlite/rqlite/store"
+)
+
+const (
+	bulkFormatNDJSON = "ndjson"
+	bulkFormatCSV    = "csv"
+
+	// DefaultBulkChunkSize is the default target size, in bytes, of the rows
+	// applied by each Raft entry during a bulk write.
+	DefaultBulkChunkSize = 512 * 1024
+
+	// maxBulkChunkSize is the largest chunk size a client may request.
+	maxBulkChunkSize = 16 * 1024 * 1024
+)
+
+// bulkResponse is the response to a bulk write. Rows is the number of rows
+// applied, even if the write did not complete, so that a client can resume
+// from the first row not applied.
+type bulkResponse struct {
+	Rows   int64   `json:"rows"`
+	Chunks int     `json:"chunks"`
+	Error  string  `json:"error,omitempty"`
+	Time   float64 `json:"time,omitempty"`
+}
+
+// bulkRowReader reads rows to be inserted by a bulk write.
+type bulkRowReader interface {
+	// Read returns the columns and values of the next row, and the size of
+	// the row as read. It returns io.EOF when there are no more rows.
+	Read() ([]string, []interface{}, int, error)
+}
+
+// ndjsonRowReader reads rows from newline-delimited JSON objects, each
+// mapping column names to values.
+type ndjsonRowReader struct {
+	r    *bufio.Reader
+	line int
+}
+
+func newNDJSONRowReader(r io.Reader) *ndjsonRowReader {
+	return &ndjsonRowReader{r: bufio.NewReader(r)}
+}
+
+func (n *ndjsonRowReader) Read() ([]string, []interface{}, int, error) {
+	for {
+		b, err := n.r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, nil, 0, err
+		}
+		if len(bytes.TrimSpace(b)) == 0 {
+			if err == io.EOF {
+				return nil, nil, 0, io.EOF
+			}
+			n.line++
+			continue
+		}
+		n.line++
+
+		var obj map[string]interface{}
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		if err := dec.Decode(&obj); err != nil {
+			return nil, nil, 0, fmt.Errorf("line %d: %w", n.line, ErrInvalidJSON)
+		}
+		if len(obj) == 0 {
+			return nil, nil, 0, fmt.Errorf("line %d: row has no columns", n.line)
+		}
+		cols := make([]string, 0, len(obj))
+		for k := range obj {
+			cols = append(cols, k)
+		}
+		sort.Strings(cols)
+		vals := make([]interface{}, len(cols))
+		for i, c := range cols {
+			vals[i] = obj[c]
+		}
+		return cols, vals, len(b), nil
+	}
+}
+
+// csvRowReader reads rows from CSV, the first record of which names the
+// columns.
+type csvRowReader struct {
+	r    *csv.Reader
+	cols []string
+}
+
+func newCSVRowReader(r io.Reader) *csvRowReader {
+	cr := csv.NewReader(r)
+	cr.ReuseRecord = true
+	return &csvRowReader{r: cr}
+}
+
+func (c *csvRowReader) Read() ([]string, []interface{}, int, error) {
+	if c.cols == nil {
+		header, err := c.r.Read()
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		c.cols = append([]string(nil), header...)
+	}
+	rec, err := c.r.Read()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	vals := make([]interface{}, len(rec))
+	sz := len(rec)
+	for i := range rec {
+		vals[i] = rec[i]
+		sz += len(rec[i])
+	}
+	return c.cols, vals, sz, nil
+}
+
+// handleBulk handles streaming writes of rows into a table. Rows are applied
+// in chunks, each a single transaction of roughly the chunk size, as they
+// are read, so the body is consumed no faster than the cluster can apply
+// it. Between chunks the node may tell the client to slow down, by ending
+// the write with a 429 response and a Retry-After header. The response
+// always reports how many rows were applied.
+func (s *Service) handleBulk(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if !s.CheckRequestPerm(r, auth.PermExecute) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	table := strings.TrimSpace(q.Get("table"))
+	if table == "" {
+		http.Error(w, "table must be set", http.StatusBadRequest)
+		return
+	}
+	format, err := bulkFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	chunkSize, err := s.bulkChunkSize(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	release, ok := s.admit(w, r, false)
+	if !ok {
+		return
+	}
+	defer release()
+
+	var rr bulkRowReader
+	if format == bulkFormatCSV {
+		rr = newCSVRowReader(r.Body)
+	} else {
+		rr = newNDJSONRowReader(r.Body)
+	}
+
+	stats.Add(numBulkWrites, 1)
+	start := time.Now()
+	resp := &bulkResponse{}
+	sqls := make(map[string]string)
+	var stmts []*command.Statement
+	var size int
+
+	// flush applies the pending rows, writing an error response and
+	// returning false if the write cannot continue.
+	flush := func() bool {
+		if !s.applyBulkChunk(w, r, resp, stmts) {
+			return false
+		}
+		stats.Add(numBulkRows, int64(len(stmts)))
+		stmts = nil
+		size = 0
+		return true
+	}
+
+	for {
+		cols, vals, n, err := rr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if !flush() {
+				return
+			}
+			s.writeBulkError(w, r, http.StatusBadRequest, resp, err)
+			return
+		}
+
+		key := strings.Join(cols, "\x00")
+		sql, ok := sqls[key]
+		if !ok {
+			sql = bulkInsertSQL(table, cols)
+			sqls[key] = sql
+		}
+		stmt := &command.Statement{
+			Sql:        sql,
+			Parameters: make([]*command.Parameter, len(vals)),
+		}
+		for i := range vals {
+			p, err := makeParameter("", vals[i])
+			if err != nil {
+				if !flush() {
+					return
+				}
+				s.writeBulkError(w, r, http.StatusBadRequest, resp, err)
+				return
+			}
+			stmt.Parameters[i] = p
+		}
+		stmts = append(stmts, stmt)
+		size += n
+		if size >= chunkSize && !flush() {
+			return
+		}
+	}
+	if !flush() {
+		return
+	}
+
+	resp.Time = time.Since(start).Seconds()
+	s.writeJSON(w, r, http.StatusOK, resp)
+}
+
+// applyBulkChunk applies a chunk of a bulk write as a single transaction,
+// once the node admits it, and counts it in resp. It writes an error response
+// and returns false if the write cannot continue. A streamed bulk write
+// cannot be replayed, so a chunk which needs approval is rejected rather than
+// held for approval.
+func (s *Service) applyBulkChunk(w http.ResponseWriter, r *http.Request, resp *bulkResponse, stmts []*command.Statement) bool {
+	if len(stmts) == 0 {
+		return true
+	}
+	if s.Overload != nil {
+		if err := s.Overload.Admit(overload.PriorityLow, r.Context().Done()); err != nil {
+			stats.Add(numBulkThrottled, 1)
+			retry := int(math.Ceil(s.Overload.RetryAfter().Seconds()))
+			if retry < 1 {
+				retry = 1
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(retry))
+			s.writeBulkError(w, r, http.StatusTooManyRequests, resp, err)
+			return false
+		}
+	}
+	if code, _, err := s.policyCheck(r, stmts); err != nil {
+		s.writeBulkError(w, r, code, resp, err)
+		return false
+	}
+	if !s.checkTrash(w, stmts, false) {
+		return false
+	}
+
+	applyStart := time.Now()
+	results, err := s.store.Execute(&command.ExecuteRequest{
+		Request: &command.Request{
+			Transaction: true,
+			Statements:  stmts,
+		},
+	})
+	if err != nil {
+		if err == store.ErrNotLeader && resp.Rows == 0 {
+			s.redirectToLeader(w, r)
+			return false
+		}
+		s.writeBulkError(w, r, http.StatusServiceUnavailable, resp, err)
+		return false
+	}
+	if s.Overload != nil {
+		s.Overload.ObserveApply(time.Since(applyStart))
+	}
+	for i := range results {
+		if results[i].GetError() != "" {
+			s.writeBulkError(w, r, http.StatusBadRequest, resp,
+				fmt.Errorf("row %d: %s", resp.Rows+int64(i)+1, results[i].GetError()))
+			return false
+		}
+	}
+	resp.Rows += int64(len(stmts))
+	resp.Chunks++
+	return true
+}
+
+// writeBulkError writes the response to a bulk write which ended early.
+func (s *Service) writeBulkError(w http.ResponseWriter, r *http.Request, code int, resp *bulkResponse, err error) {
+	resp.Error = err.Error()
+	s.writeJSON(w, r, code, resp)
+}
+
+// bulkChunkSize returns the target chunk size of a bulk write.
+func (s *Service) bulkChunkSize(r *http.Request) (int, error) {
+	def := s.BulkChunkSize
+	if def <= 0 {
+		def = DefaultBulkChunkSize
+	}
+	v := r.URL.Query().Get("chunk_size")
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 || n > maxBulkChunkSize {
+		return 0, fmt.Errorf("chunk_size must be between 1 and %d", maxBulkChunkSize)
+	}
+	return n, nil
+}
+
+// bulkFormat returns the format of the rows of a bulk write, set by the
+// format query parameter or else the content type.
+func bulkFormat(r *http.Request) (string, error) {
+	if f := r.URL.Query().Get("format"); f != "" {
+		if f != bulkFormatNDJSON && f != bulkFormatCSV {
+			return "", fmt.Errorf("unsupported format %s", f)
+		}
+		return f, nil
+	}
+	ct := r.Header.Get("Content-Type")
+	if ct == "" {
+		return bulkFormatNDJSON, nil
+	}
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return "", err
+	}
+	switch mt {
+	case "application/x-ndjson", "application/ndjson", "application/json":
+		return bulkFormatNDJSON, nil
+	case "text/csv":
+		return bulkFormatCSV, nil
+	}
+	return "", errors.New("unsupported content type " + mt)
+}
+
+// bulkInsertSQL returns the parameterized statement inserting a row with the
+// given columns into table.
+func bulkInsertSQL(table string, cols []string) string {
+	quoted := make([]string, len(cols))
+	for i := range cols {
+		quoted[i] = quoteIdentifier(cols[i])
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", quoteIdentifier(table),
+		strings.Join(quoted, ", "), strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", "))
+}
+
+// quoteIdentifier quotes a SQLite identifier.
+func quoteIdentifier(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
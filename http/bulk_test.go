@@ -0,0 +1,236 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/rqlite/rqlite/command"
+	"github.com/rqlite/rqlite/overload"
+)
+
+func Test_BulkInsertSQL(t *testing.T) {
+	if got, exp := bulkInsertSQL("foo", []string{"id", `na"me`}),
+		`INSERT INTO "foo" ("id", "na""me") VALUES (?, ?)`; got != exp {
+		t.Fatalf("wrong SQL, exp %s, got %s", exp, got)
+	}
+}
+
+func Test_NDJSONRowReader(t *testing.T) {
+	rr := newNDJSONRowReader(strings.NewReader(`{"name": "fiona", "id": 1}` + "\n\n" + `{"id": 2.5, "name": null}`))
+	cols, vals, _, err := rr.Read()
+	if err != nil {
+		t.Fatalf("failed to read row: %s", err.Error())
+	}
+	if fmt.Sprint(cols) != "[id name]" || fmt.Sprint(vals) != "[1 fiona]" {
+		t.Fatalf("wrong row read, got %v %v", cols, vals)
+	}
+	cols, vals, _, err = rr.Read()
+	if err != nil {
+		t.Fatalf("failed to read row: %s", err.Error())
+	}
+	if fmt.Sprint(cols) != "[id name]" || fmt.Sprint(vals) != "[2.5 <nil>]" {
+		t.Fatalf("wrong row read, got %v %v", cols, vals)
+	}
+	if _, _, _, err := rr.Read(); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+
+	rr = newNDJSONRowReader(strings.NewReader(`{"id": 1}` + "\n" + `not json`))
+	rr.Read()
+	if _, _, _, err := rr.Read(); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("expected error on line 2, got %v", err)
+	}
+}
+
+func Test_CSVRowReader(t *testing.T) {
+	rr := newCSVRowReader(strings.NewReader("id,name\n1,fiona\n2,\"de, clerk\"\n"))
+	for _, exp := range []string{"[1 fiona]", "[2 de, clerk]"} {
+		cols, vals, _, err := rr.Read()
+		if err != nil {
+			t.Fatalf("failed to read row: %s", err.Error())
+		}
+		if fmt.Sprint(cols) != "[id name]" || fmt.Sprint(vals) != exp {
+			t.Fatalf("wrong row read, exp %s, got %v %v", exp, cols, vals)
+		}
+	}
+	if _, _, _, err := rr.Read(); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+}
+
+func Test_BulkWrite(t *testing.T) {
+	var chunks [][]*command.Statement
+	m := &MockStore{
+		executeFn: func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+			if !er.Request.Transaction {
+				t.Errorf("bulk chunk not applied as a transaction")
+			}
+			chunks = append(chunks, er.Request.Statements)
+			return make([]*command.ExecuteResult, len(er.Request.Statements)), nil
+		},
+	}
+	s := New("127.0.0.1:0", m, &mockClusterService{}, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	var body strings.Builder
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(&body, `{"id": %d, "name": "name%d"}`+"\n", i, i)
+	}
+	resp := mustDoRequest(t, "POST", host+"/db/bulk?table=foo&chunk_size=100", body.String(), "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status code for bulk write, exp %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	br := mustDecodeBulkResponse(t, resp)
+	if br.Rows != 10 || br.Chunks != len(chunks) || len(chunks) < 2 {
+		t.Fatalf("wrong bulk response %+v for %d chunks", br, len(chunks))
+	}
+	stmt := chunks[0][0]
+	if stmt.Sql != `INSERT INTO "foo" ("id", "name") VALUES (?, ?)` {
+		t.Fatalf("wrong SQL for bulk write: %s", stmt.Sql)
+	}
+	if stmt.Parameters[0].GetI() != 0 || stmt.Parameters[1].GetS() != "name0" {
+		t.Fatalf("wrong parameters for bulk write: %v", stmt.Parameters)
+	}
+
+	chunks = nil
+	req, err := http.NewRequest("POST", host+"/db/bulk?table=foo", strings.NewReader("id,name\n1,fiona\n2,declan\n"))
+	if err != nil {
+		t.Fatalf("failed to create request: %s", err.Error())
+	}
+	req.Header.Set("Content-Type", "text/csv")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to make request: %s", err.Error())
+	}
+	if br := mustDecodeBulkResponse(t, resp); br.Rows != 2 || len(chunks) != 1 {
+		t.Fatalf("wrong bulk response %+v for CSV", br)
+	}
+	if got := chunks[0][1].Parameters[1].GetS(); got != "declan" {
+		t.Fatalf("wrong parameter for CSV bulk write: %s", got)
+	}
+
+	resp = mustDoRequest(t, "POST", host+"/db/bulk", body.String(), "")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("wrong status code for bulk write without table, exp %d, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+	resp = mustDoRequest(t, "POST", host+"/db/bulk?table=foo", `{"id": 1}`+"\n"+"bad", "")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("wrong status code for bad row, exp %d, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+	if br := mustDecodeBulkResponse(t, resp); br.Rows != 1 || br.Error == "" {
+		t.Fatalf("wrong bulk response %+v for bad row", br)
+	}
+}
+
+func Test_BulkWriteThrottled(t *testing.T) {
+	m := &MockStore{
+		executeFn: func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+			return make([]*command.ExecuteResult, len(er.Request.Statements)), nil
+		},
+	}
+	s := New("127.0.0.1:0", m, &mockClusterService{}, nil)
+	admitted := 0
+	s.Overload = &mockOverloadController{
+		admitFn: func(priority string) error {
+			if priority != overload.PriorityLow {
+				t.Errorf("bulk write admitted at priority %s", priority)
+			}
+			admitted++
+			if admitted > 2 {
+				return fmt.Errorf("node overloaded")
+			}
+			return nil
+		},
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	var body strings.Builder
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(&body, `{"id": %d}`+"\n", i)
+	}
+	resp := mustDoRequest(t, "POST", host+"/db/bulk?table=foo&chunk_size=1", body.String(), "")
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("wrong status code for throttled bulk write, exp %d, got %d", http.StatusTooManyRequests, resp.StatusCode)
+	}
+	if resp.Header.Get("Retry-After") != "2" {
+		t.Fatalf("wrong Retry-After header: %s", resp.Header.Get("Retry-After"))
+	}
+	if br := mustDecodeBulkResponse(t, resp); br.Rows != 2 || br.Chunks != 2 {
+		t.Fatalf("wrong bulk response %+v for throttled write", br)
+	}
+}
+
+func Test_BulkWritePolicy(t *testing.T) {
+	executed := 0
+	m := &MockStore{
+		executeFn: func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+			executed++
+			return make([]*command.ExecuteResult, len(er.Request.Statements)), nil
+		},
+	}
+	s := New("127.0.0.1:0", m, &mockClusterService{}, nil)
+	s.Policy = &mockStatementPolicy{
+		checkFn: func(username string, stmts []*command.Statement, confirmed bool) error {
+			if username == "ops" {
+				return &mockApprovalError{}
+			}
+			if !confirmed {
+				return fmt.Errorf("confirmation required")
+			}
+			return nil
+		},
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	body := `{"id": 1}` + "\n"
+	resp := mustDoRequest(t, "POST", host+"/db/bulk?table=foo", body, "alice")
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("wrong status code for unconfirmed bulk write, exp %d, got %d", http.StatusForbidden, resp.StatusCode)
+	}
+	if br := mustDecodeBulkResponse(t, resp); br.Rows != 0 || br.Error == "" {
+		t.Fatalf("wrong bulk response %+v for unconfirmed write", br)
+	}
+
+	resp = mustDoRequest(t, "POST", host+"/db/bulk?table=foo&confirm", body, "alice")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status code for confirmed bulk write, exp %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	// Bulk writes cannot be replayed, so are never held for approval.
+	resp = mustDoRequest(t, "POST", host+"/db/bulk?table=foo", body, "ops")
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("wrong status code for bulk write needing approval, exp %d, got %d", http.StatusForbidden, resp.StatusCode)
+	}
+	if s.numPendingApprovals() != 0 {
+		t.Fatalf("bulk write held for approval")
+	}
+	if executed != 1 {
+		t.Fatalf("wrong number of chunks executed, exp 1, got %d", executed)
+	}
+}
+
+func mustDecodeBulkResponse(t *testing.T, resp *http.Response) *bulkResponse {
+	t.Helper()
+	defer resp.Body.Close()
+	br := &bulkResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(br); err != nil {
+		t.Fatalf("failed to decode bulk response: %s", err.Error())
+	}
+	return br
+}
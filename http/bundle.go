@@ -0,0 +1,445 @@
+package http
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rqlite/rqlite/auth"
+	"github.com/rqlite/rqlite/rtls"
+	"github.com/rqlite/rqlite/store"
+)
+
+const (
+	// BundleContentType is the content type of support bundles.
+	BundleContentType = "application/gzip"
+
+	// maxBundleNodeSize is the largest part of a support bundle accepted
+	// from another node.
+	maxBundleNodeSize = 64 * 1024 * 1024
+
+	// maxBundleEvents is the maximum number of cluster events included in
+	// a support bundle.
+	maxBundleEvents = 1000
+
+	// bundleRedacted replaces sensitive values in support bundles.
+	bundleRedacted = "[REDACTED]"
+)
+
+var (
+	// sensitiveKey matches the keys of values redacted from support bundles.
+	sensitiveKey = regexp.MustCompile(`(?i)passw|secret|token|credential|access_key|private_key|api_key`)
+
+	// urlPassword matches the password in the user information of a URL.
+	urlPassword = regexp.MustCompile(`(://[^:/@\s]*:)[^@/\s]+@`)
+)
+
+// RecentLogs is the interface a source of the lines recently logged by this
+// node must implement.
+type RecentLogs interface {
+	// Lines returns the lines held, oldest first.
+	Lines() []string
+}
+
+// bundleFile is a file in a support bundle.
+type bundleFile struct {
+	name string
+	data []byte
+}
+
+// bundleNode describes a node in the manifest of a support bundle.
+type bundleNode struct {
+	ID      string `json:"id"`
+	Addr    string `json:"addr,omitempty"`
+	APIAddr string `json:"api_addr,omitempty"`
+	Local   bool   `json:"local,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// bundleManifest describes a support bundle. It is the first file in the
+// bundle.
+type bundleManifest struct {
+	Created time.Time     `json:"created"`
+	Error   string        `json:"error,omitempty"`
+	Nodes   []*bundleNode `json:"nodes"`
+}
+
+// handleBundle returns a support bundle, a gzipped tar archive of the state
+// of every node in the cluster. The files of each node are in a directory
+// named after its ID. Unless the local query parameter is set, every other
+// node is asked for its files through its own HTTP API, with the credentials
+// of the request. Sensitive values, such as passwords, are redacted.
+func (s *Service) handleBundle(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if !s.CheckRequestPerm(r, auth.PermStatus) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	timeout, err := timeoutParam(r, defaultTimeout)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	local, err := queryParam(r, "local")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var files []bundleFile
+	localID, localAddr, localFiles := s.bundleLocal(r)
+	if local {
+		files = prefixBundleFiles(localID, localFiles)
+	} else {
+		files, err = s.bundleCluster(r, timeout, localID, localAddr, localFiles)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", BundleContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="rqlite-bundle-%s.tar.gz"`,
+		time.Now().UTC().Format("20060102T150405Z")))
+	if err := writeBundle(w, files); err != nil {
+		s.logger.Printf("failed to write support bundle: %s", err.Error())
+	}
+}
+
+// bundleCluster returns the files of a support bundle of every node in the
+// cluster, preceded by its manifest.
+func (s *Service) bundleCluster(r *http.Request, timeout time.Duration, localID, localAddr string,
+	localFiles []bundleFile) ([]bundleFile, error) {
+	manifest := &bundleManifest{Created: time.Now().UTC()}
+	nodes, err := s.store.Nodes()
+	if err != nil {
+		manifest.Error = fmt.Sprintf("store nodes: %s", err.Error())
+	}
+
+	client, err := s.bundleClient(timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	var wg sync.WaitGroup
+	nodeFiles := make([][]bundleFile, len(nodes))
+	foundLocal := false
+	for i, n := range nodes {
+		bn := &bundleNode{ID: n.ID, Addr: n.Addr}
+		manifest.Nodes = append(manifest.Nodes, bn)
+		if localAddr != "" && n.Addr == localAddr {
+			bn.Local = true
+			foundLocal = true
+			nodeFiles[i] = prefixBundleFiles(n.ID, localFiles)
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, n *store.Server, bn *bundleNode) {
+			defer wg.Done()
+			apiAddr, err := s.cluster.GetNodeAPIAddr(n.Addr, timeout)
+			if err != nil {
+				bn.Error = fmt.Sprintf("get API address: %s", err.Error())
+				return
+			}
+			bn.APIAddr = apiAddr
+			files, err := fetchBundle(client, r, apiAddr)
+			if err != nil {
+				bn.Error = err.Error()
+				return
+			}
+			nodeFiles[i] = prefixBundleFiles(n.ID, files)
+		}(i, n, bn)
+	}
+	wg.Wait()
+
+	var files []bundleFile
+	if !foundLocal {
+		manifest.Nodes = append(manifest.Nodes, &bundleNode{ID: localID, Addr: localAddr, Local: true})
+		files = prefixBundleFiles(localID, localFiles)
+	}
+	for _, nf := range nodeFiles {
+		files = append(files, nf...)
+	}
+
+	b, err := json.MarshalIndent(manifest, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]bundleFile{{name: "manifest.json", data: b}}, files...), nil
+}
+
+// bundleLocal returns the files of a support bundle of this node, named
+// relative to the node's directory, and the ID and Raft address of this node.
+// Any file which cannot be created is described in a file named errors.txt.
+func (s *Service) bundleLocal(r *http.Request) (string, string, []bundleFile) {
+	var files []bundleFile
+	var errs []string
+	// Every file is decoded as generic JSON before it is redacted, so that
+	// values of all types are redacted the same way.
+	addRaw := func(name string, raw []byte) {
+		var v interface{}
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		err := dec.Decode(&v)
+		var b []byte
+		if err == nil {
+			b, err = json.MarshalIndent(redactValue(v), "", "    ")
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", name, err.Error()))
+			return
+		}
+		files = append(files, bundleFile{name: name, data: b})
+	}
+	addJSON := func(name string, v interface{}) {
+		b, err := json.Marshal(v)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", name, err.Error()))
+			return
+		}
+		addRaw(name, b)
+	}
+	addEndpoint := func(name, target string) {
+		code, body := s.serveInternal(r, target)
+		if code != http.StatusOK {
+			errs = append(errs, fmt.Sprintf("%s: %s returned %d: %s", name, target, code,
+				strings.TrimSpace(string(body))))
+			return
+		}
+		addRaw(name, body)
+	}
+
+	addEndpoint("status.json", "/status")
+	addEndpoint("nodes.json", "/nodes?nonvoters")
+	addEndpoint("expvar.json", "/debug/vars")
+	if s.Events != nil {
+		addEndpoint("events.json", fmt.Sprintf("/cluster/events?limit=%d", maxBundleEvents))
+	}
+
+	var id, addr string
+	storeStatus, err := s.store.Stats()
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("raft.json: store stats: %s", err.Error()))
+	} else {
+		id, _ = storeStatus["node_id"].(string)
+		addr, _ = storeStatus["addr"].(string)
+		addJSON("raft.json", storeStatus["raft"])
+	}
+	if id == "" {
+		id = "local"
+	}
+
+	if s.Snapshots != nil {
+		snaps, err := s.Snapshots.Snapshots()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("snapshots.json: %s", err.Error()))
+		} else {
+			metas := make([]map[string]interface{}, len(snaps))
+			for i, m := range snaps {
+				metas[i] = map[string]interface{}{
+					"id":                  m.ID,
+					"index":               m.Index,
+					"term":                m.Term,
+					"size":                m.Size,
+					"version":             m.Version,
+					"configuration_index": m.ConfigurationIndex,
+					"servers":             m.Configuration.Servers,
+				}
+			}
+			addJSON("snapshots.json", metas)
+		}
+	}
+
+	if s.Logs != nil {
+		var buf bytes.Buffer
+		for _, l := range s.Logs.Lines() {
+			buf.WriteString(redactString(l))
+			buf.WriteByte('\n')
+		}
+		files = append(files, bundleFile{name: "logs.txt", data: buf.Bytes()})
+	}
+
+	if len(errs) > 0 {
+		files = append(files, bundleFile{name: "errors.txt", data: []byte(strings.Join(errs, "\n") + "\n")})
+	}
+	return id, addr, files
+}
+
+// serveInternal serves a GET request for target, with the credentials of r,
+// returning the status code and body of the response.
+func (s *Service) serveInternal(r *http.Request, target string) (int, []byte) {
+	req, err := http.NewRequest("GET", target, nil)
+	if err != nil {
+		return http.StatusInternalServerError, []byte(err.Error())
+	}
+	req.Header = r.Header.Clone()
+	req.RemoteAddr = r.RemoteAddr
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec.Code, rec.Body.Bytes()
+}
+
+// bundleClient returns an HTTP client for fetching support bundles from other
+// nodes, whose certificates are verified as those of this node would be.
+func (s *Service) bundleClient(timeout time.Duration) (*http.Client, error) {
+	tr := &http.Transport{}
+	if s.HTTPS() {
+		var certFile, keyFile string
+		if s.ClientVerify {
+			certFile, keyFile = s.CertFile, s.KeyFile
+		}
+		tlsConfig, err := rtls.CreateClientConfig(certFile, keyFile, s.CACertFile, false)
+		if err != nil {
+			return nil, fmt.Errorf("create TLS config: %s", err.Error())
+		}
+		tr.TLSClientConfig = tlsConfig
+	}
+	return &http.Client{Transport: tr, Timeout: timeout}, nil
+}
+
+// fetchBundle fetches the support bundle of the node whose HTTP API is at
+// apiAddr, with the credentials of r, returning its files named relative to
+// the node's directory.
+func fetchBundle(client *http.Client, r *http.Request, apiAddr string) ([]bundleFile, error) {
+	req, err := http.NewRequestWithContext(r.Context(), "GET", strings.TrimSuffix(apiAddr, "/")+"/debug/bundle?local", nil)
+	if err != nil {
+		return nil, err
+	}
+	if a := r.Header.Get("Authorization"); a != "" {
+		req.Header.Set("Authorization", a)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("bundle request returned %s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	return readBundle(io.LimitReader(resp.Body, maxBundleNodeSize))
+}
+
+// readBundle reads the files of the support bundle of a single node,
+// returning them named relative to the node's directory.
+func readBundle(r io.Reader) ([]bundleFile, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("read bundle: %s", err.Error())
+	}
+	tr := tar.NewReader(gr)
+	var files []bundleFile
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read bundle: %s", err.Error())
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Clean(hdr.Name)
+		if i := strings.IndexByte(name, '/'); i >= 0 {
+			name = name[i+1:]
+		}
+		if name == "" || strings.HasPrefix(name, "..") {
+			continue
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("read bundle: %s", err.Error())
+		}
+		files = append(files, bundleFile{name: name, data: b})
+	}
+}
+
+// writeBundle writes files to w as a gzipped tar archive.
+func writeBundle(w io.Writer, files []bundleFile) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	now := time.Now()
+	for _, f := range files {
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     f.name,
+			Mode:     0644,
+			Size:     int64(len(f.data)),
+			ModTime:  now,
+		}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// prefixBundleFiles returns files in the directory of the node with the
+// given ID.
+func prefixBundleFiles(id string, files []bundleFile) []bundleFile {
+	dir := strings.NewReplacer("/", "_", `\`, "_").Replace(id)
+	if dir == "" || dir == "." || dir == ".." {
+		dir = "node"
+	}
+	prefixed := make([]bundleFile, len(files))
+	for i, f := range files {
+		prefixed[i] = bundleFile{name: dir + "/" + f.name, data: f.data}
+	}
+	return prefixed
+}
+
+// redactValue returns v, a decoded JSON value, with the values of sensitive
+// keys and any passwords in URLs redacted.
+func redactValue(v interface{}) interface{} {
+	switch vv := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(vv))
+		for k, e := range vv {
+			if s, ok := e.(string); ok && s != "" && sensitiveKey.MatchString(k) {
+				m[k] = bundleRedacted
+				continue
+			}
+			m[k] = redactValue(e)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(vv))
+		for i, e := range vv {
+			l[i] = redactValue(e)
+		}
+		return l
+	case string:
+		return redactString(vv)
+	default:
+		return v
+	}
+}
+
+// redactString returns s with any passwords in URLs redacted.
+func redactString(s string) string {
+	return urlPassword.ReplaceAllString(s, "${1}"+bundleRedacted+"@")
+}
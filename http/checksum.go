@@ -0,0 +1,71 @@
+package http
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"net/http"
+)
+
+// isChecksum returns whether the request asks for a checksum of the
+// response.
+func isChecksum(req *http.Request) (bool, error) {
+	return queryParam(req, "checksum")
+}
+
+// checksum returns the checksum of a response body, the hex-encoded SHA-256
+// of its bytes.
+func checksum(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// removeTrailer removes name from the trailers announced in h, leaving any
+// others.
+func removeTrailer(h http.Header, name string) {
+	var keep []string
+	for _, t := range h.Values("Trailer") {
+		if t != name {
+			keep = append(keep, t)
+		}
+	}
+	h.Del("Trailer")
+	for _, t := range keep {
+		h.Add("Trailer", t)
+	}
+}
+
+// checksumWriter hashes a streamed response as it is written, so that its
+// checksum may follow the body as a trailer, once the body is complete.
+type checksumWriter struct {
+	http.ResponseWriter
+	h hash.Hash
+}
+
+// newChecksumWriter returns a checksumWriter wrapping w. It must be created
+// before anything is written to w, so that the trailer is announced.
+func newChecksumWriter(w http.ResponseWriter) *checksumWriter {
+	w.Header().Add("Trailer", ChecksumHTTPHeader)
+	return &checksumWriter{
+		ResponseWriter: w,
+		h:              sha256.New(),
+	}
+}
+
+func (c *checksumWriter) Write(b []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(b)
+	c.h.Write(b[:n])
+	return n, err
+}
+
+// Flush flushes the response, if the underlying writer supports it.
+func (c *checksumWriter) Flush() {
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// finish sets the trailer to the checksum of everything written.
+func (c *checksumWriter) finish() {
+	c.Header().Set(ChecksumHTTPHeader, hex.EncodeToString(c.h.Sum(nil)))
+}
@@ -0,0 +1,69 @@
+package http
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/rqlite/rqlite/command"
+)
+
+func Test_QueryChecksum(t *testing.T) {
+	m := &MockStore{}
+	m.queryFn = func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+		return []*command.QueryRows{{
+			Columns: []string{"id"},
+			Types:   []string{"integer"},
+			Values: []*command.Values{
+				{Parameters: []*command.Parameter{{Value: &command.Parameter_I{I: 1}}}},
+			},
+		}}, nil
+	}
+	s := New("127.0.0.1:0", m, &mockClusterService{}, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	for _, tt := range []struct {
+		params  string
+		trailer bool
+	}{
+		{params: "pretty", trailer: false},
+		{params: "", trailer: true},
+		{params: "format=ndjson", trailer: true},
+		{params: "format=csv", trailer: true},
+		{params: "format=parquet", trailer: true},
+	} {
+		resp := mustDoRequest(t, "GET", host+"/db/query?checksum&q=SELECT%20id%20FROM%20foo&"+tt.params, "", "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("wrong status code for %s, exp %d, got %d", tt.params, http.StatusOK, resp.StatusCode)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("failed to read response body: %s", err)
+		}
+		header, trailer := resp.Header.Get(ChecksumHTTPHeader), resp.Trailer.Get(ChecksumHTTPHeader)
+		got := header
+		if tt.trailer {
+			if header != "" {
+				t.Fatalf("checksum of streamed response for %s sent as header", tt.params)
+			}
+			got = trailer
+		}
+		if exp := checksum(body); exp != got {
+			t.Fatalf("wrong checksum for %s, exp %s, got %s (trailer %s)", tt.params, exp, got, trailer)
+		}
+	}
+
+	// No checksum unless requested.
+	resp := mustDoRequest(t, "GET", host+"/db/query?q=SELECT%20id%20FROM%20foo", "", "")
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.Header.Get(ChecksumHTTPHeader) != "" || resp.Trailer.Get(ChecksumHTTPHeader) != "" {
+		t.Fatalf("checksum sent when not requested")
+	}
+}
@@ -0,0 +1,214 @@
+package http
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Connection describes a client connection to the HTTP service, or to
+// another API served by the node which reports its connections to it.
+type Connection struct {
+	// ID identifies the connection, so that it may be terminated.
+	ID uint64 `json:"id"`
+
+	// API is the API served on the connection, such as http or grpc.
+	API string `json:"api"`
+
+	// RemoteAddr is the address of the client.
+	RemoteAddr string `json:"remote_addr"`
+
+	// User is the user named by the most recent request on the connection,
+	// if any.
+	User string `json:"user,omitempty"`
+
+	// InFlight is the number of requests on the connection being served.
+	InFlight int `json:"in_flight"`
+
+	// Requests is the number of requests made on the connection.
+	Requests uint64 `json:"requests"`
+
+	// LastRequest is the method and path of the most recent request, or
+	// the full method name of the most recent RPC.
+	LastRequest string `json:"last_request,omitempty"`
+
+	// ConnectedAt is when the connection was accepted.
+	ConnectedAt time.Time `json:"connected_at"`
+
+	// Idle is how long the connection has been without a request in
+	// flight. It is zero while any request is being served.
+	Idle string `json:"idle"`
+}
+
+type connContextKey struct{}
+
+// trackedConn is the state of a connection recorded by a connTracker.
+type trackedConn struct {
+	id          uint64
+	api         string
+	conn        net.Conn
+	connectedAt time.Time
+	lastActive  time.Time
+	user        string
+	lastRequest string
+	inFlight    int
+	requests    uint64
+}
+
+// connTracker records the connections accepted by an http.Server, and by
+// any other API reporting its connections, and the requests being served on
+// each, so that operators can find, and terminate, the connection of a
+// misbehaving client.
+type connTracker struct {
+	mu     sync.Mutex
+	nextID uint64
+	conns  map[net.Conn]*trackedConn
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{
+		conns: make(map[net.Conn]*trackedConn),
+	}
+}
+
+// connState implements http.Server.ConnState.
+func (t *connTracker) connState(c net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		t.open(c, "http")
+	case http.StateHijacked, http.StateClosed:
+		t.close(c)
+	}
+}
+
+// open records the connection c, accepted by the named API.
+func (t *connTracker) open(c net.Conn, api string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.nextID++
+	now := time.Now()
+	t.conns[c] = &trackedConn{
+		id:          t.nextID,
+		api:         api,
+		conn:        c,
+		connectedAt: now,
+		lastActive:  now,
+	}
+}
+
+// close forgets the connection c.
+func (t *connTracker) close(c net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.conns, c)
+}
+
+// connContext implements http.Server.ConnContext, recording the connection
+// in the context of each request made on it.
+func (t *connTracker) connContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, c)
+}
+
+// begin records the start of serving r, returning a function to be called
+// once it has been served.
+func (t *connTracker) begin(r *http.Request) func() {
+	c, ok := r.Context().Value(connContextKey{}).(net.Conn)
+	if !ok {
+		return func() {}
+	}
+	user, _, _ := r.BasicAuth()
+	return t.request(c, user, r.Method+" "+r.URL.Path)
+}
+
+// request records the start of the request, described by desc, made on c by
+// the named user, if any, returning a function to be called once it has
+// been served.
+func (t *connTracker) request(c net.Conn, user, desc string) func() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tc, ok := t.conns[c]
+	if !ok {
+		return func() {}
+	}
+	tc.inFlight++
+	tc.requests++
+	tc.lastActive = time.Now()
+	tc.lastRequest = desc
+	if user != "" {
+		tc.user = user
+	}
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		tc.inFlight--
+		tc.lastActive = time.Now()
+	}
+}
+
+// list returns the tracked connections, in the order they were accepted.
+func (t *connTracker) list() []*Connection {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	conns := make([]*Connection, 0, len(t.conns))
+	for _, tc := range t.conns {
+		var idle time.Duration
+		if tc.inFlight == 0 {
+			idle = now.Sub(tc.lastActive)
+		}
+		conns = append(conns, &Connection{
+			ID:          tc.id,
+			API:         tc.api,
+			RemoteAddr:  tc.conn.RemoteAddr().String(),
+			User:        tc.user,
+			InFlight:    tc.inFlight,
+			Requests:    tc.requests,
+			LastRequest: tc.lastRequest,
+			ConnectedAt: tc.connectedAt,
+			Idle:        idle.String(),
+		})
+	}
+	sort.Slice(conns, func(i, j int) bool { return conns[i].ID < conns[j].ID })
+	return conns
+}
+
+// terminate closes the connection with the given ID, cancelling any
+// requests being served on it. It returns false if there is no such
+// connection.
+func (t *connTracker) terminate(id uint64) (bool, error) {
+	t.mu.Lock()
+	var c net.Conn
+	for _, tc := range t.conns {
+		if tc.id == id {
+			c = tc.conn
+			break
+		}
+	}
+	t.mu.Unlock()
+	if c == nil {
+		return false, nil
+	}
+	return true, c.Close()
+}
+
+// ConnOpened records a client connection accepted by another API served by
+// this node, such as the gRPC API, so that it is listed, and may be
+// terminated, alongside those of the HTTP API.
+func (s *Service) ConnOpened(c net.Conn, api string) {
+	s.conns.open(c, api)
+}
+
+// ConnClosed records that a connection recorded by ConnOpened is closed.
+func (s *Service) ConnClosed(c net.Conn) {
+	s.conns.close(c)
+}
+
+// RequestBegun records the start of a request, described by desc, made on a
+// connection recorded by ConnOpened by the named user, if any. It returns a
+// function to be called once the request has been served.
+func (s *Service) RequestBegun(c net.Conn, user, desc string) func() {
+	return s.conns.request(c, user, desc)
+}
@@ -0,0 +1,143 @@
+package http
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_Connections(t *testing.T) {
+	s := New("127.0.0.1:0", &MockStore{}, &mockClusterService{}, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	// Leave a connection open after a request by a named user.
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect to service: %s", err)
+	}
+	defer conn.Close()
+	req, err := http.NewRequest("GET", host+"/jobs", nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %s", err)
+	}
+	req.SetBasicAuth("fiona", "password")
+	if err := req.Write(conn); err != nil {
+		t.Fatalf("failed to write request: %s", err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatalf("failed to read response: %s", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	listConnections := func() []*Connection {
+		t.Helper()
+		resp := mustDoRequest(t, "GET", host+"/debug/connections", "", "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("wrong status code listing connections, exp %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		var r struct {
+			Connections []*Connection `json:"connections"`
+		}
+		if err := json.Unmarshal([]byte(mustReadBody(t, resp)), &r); err != nil {
+			t.Fatalf("failed to unmarshal connections: %s", err)
+		}
+		return r.Connections
+	}
+
+	var fiona *Connection
+	for _, c := range listConnections() {
+		if c.RemoteAddr == conn.LocalAddr().String() {
+			fiona = c
+		} else if c.InFlight != 1 {
+			t.Fatalf("listing connection has wrong in-flight requests: %+v", c)
+		}
+	}
+	if fiona == nil {
+		t.Fatalf("connection not listed")
+	}
+	if fiona.API != "http" || fiona.User != "fiona" || fiona.InFlight != 0 || fiona.Requests != 1 || fiona.LastRequest != "GET /jobs" {
+		t.Fatalf("wrong connection listed: %+v", fiona)
+	}
+
+	for path, code := range map[string]int{
+		"/debug/connections/x":       http.StatusBadRequest,
+		"/debug/connections/1000000": http.StatusNotFound,
+	} {
+		resp := mustDoRequest(t, "DELETE", host+path, "", "")
+		if resp.StatusCode != code {
+			t.Fatalf("wrong status code for DELETE %s, exp %d, got %d", path, code, resp.StatusCode)
+		}
+	}
+	resp = mustDoRequest(t, "POST", host+"/debug/connections", "", "")
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("wrong status code for POST, exp %d, got %d", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+
+	resp = mustDoRequest(t, "DELETE", fmt.Sprintf("%s/debug/connections/%d", host, fiona.ID), "", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status code terminating connection, exp %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Fatalf("expected EOF reading terminated connection, got %v", err)
+	}
+	for _, c := range listConnections() {
+		if c.ID == fiona.ID {
+			t.Fatalf("terminated connection still listed")
+		}
+	}
+}
+
+func Test_ConnectionsOtherAPI(t *testing.T) {
+	s := New("127.0.0.1:0", &MockStore{}, &mockClusterService{}, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	s.ConnOpened(c1, "grpc")
+	done := s.RequestBegun(c1, "fiona", "/rqlite.DB/Query")
+
+	var conn *Connection
+	for _, c := range s.conns.list() {
+		if c.API == "grpc" {
+			conn = c
+		}
+	}
+	if conn == nil {
+		t.Fatalf("connection of other API not listed")
+	}
+	if conn.User != "fiona" || conn.InFlight != 1 || conn.LastRequest != "/rqlite.DB/Query" {
+		t.Fatalf("wrong connection listed: %+v", conn)
+	}
+	done()
+
+	resp := mustDoRequest(t, "DELETE", fmt.Sprintf("http://%s/debug/connections/%d", s.Addr().String(), conn.ID), "", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status code terminating connection, exp %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if _, err := c2.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected EOF reading terminated connection, got %v", err)
+	}
+
+	s.ConnClosed(c1)
+	for _, c := range s.conns.list() {
+		if c.ID == conn.ID {
+			t.Fatalf("closed connection still listed")
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package http
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/rqlite/rqlite/command"
+	"github.com/rqlite/rqlite/command/encoding"
+)
+
+// CSVErrorTrailer is the HTTP trailer holding any errors which occurred while
+// writing query results as CSV, since CSV itself cannot represent them.
+const CSVErrorTrailer = "X-RQLITE-ERROR"
+
+// CSVOptions are the options for writing query results as CSV.
+type CSVOptions struct {
+	// Header is whether the results of each statement start with a row
+	// holding the names of the columns.
+	Header bool
+
+	// Delimiter is the field delimiter.
+	Delimiter rune
+
+	// Null is the string written for NULL values.
+	Null string
+}
+
+// csvOptions returns the options for writing query results as CSV, set by
+// the URL params 'noheader', 'delimiter', which may be "tab", and 'null'.
+func csvOptions(req *http.Request) (*CSVOptions, error) {
+	q := req.URL.Query()
+	opts := &CSVOptions{
+		Header:    true,
+		Delimiter: ',',
+		Null:      q.Get("null"),
+	}
+	if _, ok := q["noheader"]; ok {
+		opts.Header = false
+	}
+	if d := q.Get("delimiter"); d != "" {
+		if strings.ToLower(d) == "tab" || d == `\t` {
+			d = "\t"
+		}
+		r, n := utf8.DecodeRuneInString(d)
+		if n != len(d) || r == utf8.RuneError || r == '"' || r == '\r' || r == '\n' {
+			return nil, fmt.Errorf("invalid CSV delimiter %q", d)
+		}
+		opts.Delimiter = r
+	}
+	return opts, nil
+}
+
+// csvWriter is a db.RowsWriter which writes the results of queries as CSV.
+// The results of each statement are separated by an empty line. Errors are
+// collected, and written to the CSVErrorTrailer once all results have been
+// written.
+type csvWriter struct {
+	w    http.ResponseWriter
+	bw   *bufio.Writer
+	cw   *csv.Writer
+	opts *CSVOptions
+	proj *encoding.Projection
+
+	started bool
+	nStmts  int
+	nRows   int
+	columns []string
+	types   []string
+	errs    []string
+	err     error
+}
+
+func newCSVWriter(w http.ResponseWriter, opts *CSVOptions, proj *encoding.Projection) *csvWriter {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Add("Trailer", CSVErrorTrailer)
+	bw := bufio.NewWriterSize(w, streamBufferSize)
+	cw := csv.NewWriter(bw)
+	cw.Comma = opts.Delimiter
+	return &csvWriter{
+		w:    w,
+		bw:   bw,
+		cw:   cw,
+		opts: opts,
+		proj: proj,
+	}
+}
+
+// WriteColumns implements db.RowsWriter.
+func (c *csvWriter) WriteColumns(columns, types []string) error {
+	c.columns = columns
+	c.types = types
+	c.nRows = 0
+	if c.started {
+		c.separate()
+	}
+	c.started = true
+	if c.opts.Header {
+		c.write(c.proj.Apply(&command.QueryRows{Columns: columns, Types: types}).Columns)
+	}
+	return c.err
+}
+
+// WriteRow implements db.RowsWriter.
+func (c *csvWriter) WriteRow(values *command.Values) error {
+	r := c.proj.Apply(&command.QueryRows{
+		Columns: c.columns,
+		Types:   c.types,
+		Values:  []*command.Values{values},
+	})
+	row := make([][]interface{}, 1)
+	if err := encoding.NewValuesFromQueryValues(row, r.Values); err != nil {
+		return err
+	}
+	record := make([]string, len(row[0]))
+	for i, v := range row[0] {
+		record[i] = c.format(v)
+	}
+	c.write(record)
+	c.nRows++
+	if c.nRows == 1 {
+		// Get the first row to the client as soon as possible.
+		c.flush()
+	}
+	return c.err
+}
+
+// EndStatement implements db.RowsWriter.
+func (c *csvWriter) EndStatement(errMsg string, t float64) error {
+	if errMsg != "" {
+		c.errs = append(c.errs, fmt.Sprintf("statement %d: %s", c.nStmts, errMsg))
+	}
+	c.nStmts++
+	c.columns, c.types = nil, nil
+	return c.err
+}
+
+// hasStarted implements queryStreamer.
+func (c *csvWriter) hasStarted() bool {
+	return c.started
+}
+
+// finish implements queryStreamer. The time taken is not written.
+func (c *csvWriter) finish(err error, t float64) error {
+	if err != nil {
+		c.errs = append(c.errs, err.Error())
+	}
+	c.flush()
+	if len(c.errs) > 0 {
+		c.w.Header().Set(CSVErrorTrailer, strings.Join(c.errs, "; "))
+	}
+	return c.err
+}
+
+// writeResults writes results which were not streamed, as if they had been.
+func (c *csvWriter) writeResults(results []*command.QueryRows) error {
+	for _, r := range results {
+		if r.Columns != nil || r.Error == "" {
+			if err := c.WriteColumns(r.Columns, r.Types); err != nil {
+				return err
+			}
+			for _, v := range r.Values {
+				if err := c.WriteRow(v); err != nil {
+					return err
+				}
+			}
+		}
+		if err := c.EndStatement(r.Error, r.Time); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// format returns the CSV field for v. BLOBs are base64-encoded, as they are
+// in JSON.
+func (c *csvWriter) format(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return c.opts.Null
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// write writes record. Records without fields, such as when no columns of
+// the results are in the projection, are not written.
+func (c *csvWriter) write(record []string) {
+	if c.err != nil || len(record) == 0 {
+		return
+	}
+	c.err = c.cw.Write(record)
+}
+
+// separate writes the empty line separating the results of each statement.
+func (c *csvWriter) separate() {
+	if c.err != nil {
+		return
+	}
+	c.cw.Flush()
+	if c.err = c.cw.Error(); c.err != nil {
+		return
+	}
+	c.err = c.bw.WriteByte('\n')
+}
+
+func (c *csvWriter) flush() {
+	if c.err != nil {
+		return
+	}
+	c.cw.Flush()
+	if c.err = c.cw.Error(); c.err != nil {
+		return
+	}
+	if c.err = c.bw.Flush(); c.err != nil {
+		return
+	}
+	if f, ok := c.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
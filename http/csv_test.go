@@ -0,0 +1,128 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/rqlite/rqlite/command"
+	"github.com/rqlite/rqlite/command/encoding"
+	"github.com/rqlite/rqlite/db"
+)
+
+func Test_CSVOptions(t *testing.T) {
+	for _, tt := range []struct {
+		query string
+		exp   CSVOptions
+	}{
+		{"", CSVOptions{Header: true, Delimiter: ','}},
+		{"noheader&delimiter=%3B&null=NULL", CSVOptions{Header: false, Delimiter: ';', Null: "NULL"}},
+		{"delimiter=tab", CSVOptions{Header: true, Delimiter: '\t'}},
+		{"delimiter=%E2%82%AC", CSVOptions{Header: true, Delimiter: '€'}},
+	} {
+		req := &http.Request{URL: &url.URL{RawQuery: tt.query}}
+		opts, err := csvOptions(req)
+		if err != nil {
+			t.Fatalf("failed to get CSV options for %q: %s", tt.query, err)
+		}
+		if *opts != tt.exp {
+			t.Fatalf("wrong CSV options for %q, exp %+v, got %+v", tt.query, tt.exp, *opts)
+		}
+	}
+	for _, query := range []string{"delimiter=ab", "delimiter=%22", "delimiter=%0A"} {
+		req := &http.Request{URL: &url.URL{RawQuery: query}}
+		if _, err := csvOptions(req); err == nil {
+			t.Fatalf("expected error for CSV options %q", query)
+		}
+	}
+}
+
+func Test_CSVWriter(t *testing.T) {
+	d, err := db.Open(filepath.Join(t.TempDir(), "db.sqlite"), false, true)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err)
+	}
+	defer d.Close()
+	for _, stmt := range []string{
+		"CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT, score REAL, data BLOB)",
+		`INSERT INTO foo(name, score, data) VALUES("fiona, ""the first""", 1.5, x'0102')`,
+		`INSERT INTO foo(name, score, data) VALUES(NULL, 2, NULL)`,
+	} {
+		if _, err := d.ExecuteStringStmt(stmt); err != nil {
+			t.Fatalf("failed to execute %s: %s", stmt, err)
+		}
+	}
+
+	req := &command.Request{
+		Statements: []*command.Statement{
+			{Sql: "SELECT * FROM foo"},
+			{Sql: "SELECT * FROM bar"},
+			{Sql: "SELECT COUNT(*) AS n FROM foo"},
+		},
+	}
+	proj, err := encoding.ParseProjection("name:n,id")
+	if err != nil {
+		t.Fatalf("failed to parse projection: %s", err)
+	}
+
+	for _, tt := range []struct {
+		opts CSVOptions
+		proj *encoding.Projection
+		exp  string
+	}{
+		{
+			opts: CSVOptions{Header: true, Delimiter: ','},
+			exp:  "id,name,score,data\n1,\"fiona, \"\"the first\"\"\",1.5,AQI=\n2,,2,\n\nn\n2\n",
+		},
+		{
+			opts: CSVOptions{Header: false, Delimiter: '\t', Null: `\N`},
+			exp:  "1\t\"fiona, \"\"the first\"\"\"\t1.5\tAQI=\n2\t\\N\t2\t\\N\n\n2\n",
+		},
+		{
+			opts: CSVOptions{Header: true, Delimiter: ','},
+			proj: proj,
+			exp:  "n,id\n\"fiona, \"\"the first\"\"\",1\n,2\n\n",
+		},
+	} {
+		rr := httptest.NewRecorder()
+		cw := newCSVWriter(rr, &tt.opts, tt.proj)
+		if err := d.QueryStream(req, false, cw); err != nil {
+			t.Fatalf("failed to stream query: %s", err)
+		}
+		if err := cw.finish(errors.New("commit failed"), 0); err != nil {
+			t.Fatalf("failed to finish stream: %s", err)
+		}
+		if got := rr.Body.String(); got != tt.exp {
+			t.Fatalf("wrong streamed response\nexp: %q\ngot: %q", tt.exp, got)
+		}
+		if !rr.Flushed {
+			t.Fatalf("streamed response not flushed")
+		}
+		if exp, got := "statement 1: no such table: bar; commit failed", rr.Result().Trailer.Get(CSVErrorTrailer); exp != got {
+			t.Fatalf("wrong error trailer, exp %q, got %q", exp, got)
+		}
+
+		// Results which were not streamed are written in the same way.
+		rows, err := d.Query(req, false)
+		if err != nil {
+			t.Fatalf("failed to query: %s", err)
+		}
+		rr = httptest.NewRecorder()
+		cw = newCSVWriter(rr, &tt.opts, tt.proj)
+		if err := cw.writeResults(rows); err != nil {
+			t.Fatalf("failed to write results: %s", err)
+		}
+		if err := cw.finish(nil, 0); err != nil {
+			t.Fatalf("failed to finish stream: %s", err)
+		}
+		if got := rr.Body.String(); got != tt.exp {
+			t.Fatalf("wrong response for results not streamed\nexp: %q\ngot: %q", tt.exp, got)
+		}
+		if exp, got := "statement 1: no such table: bar", rr.Result().Trailer.Get(CSVErrorTrailer); exp != got {
+			t.Fatalf("wrong error trailer, exp %q, got %q", exp, got)
+		}
+	}
+}
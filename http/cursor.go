@@ -0,0 +1,224 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rqlite/rqlite/auth"
+	"github.com/rqlite/rqlite/command"
+	"github.com/rqlite/rqlite/store"
+)
+
+const (
+	// defaultCursorPageSize is the number of rows in each page of results
+	// read through a cursor, if the client does not request a number.
+	defaultCursorPageSize = 1000
+
+	// maxCursorPageSize is the largest number of rows a client may request
+	// in a page, which bounds the memory used by each request.
+	maxCursorPageSize = 10000
+)
+
+// CursorQuerier is the interface a store must implement to serve the results
+// of queries a page at a time, through cursors held between requests.
+type CursorQuerier interface {
+	// OpenCursor executes a query, returning the first page of at most n
+	// rows of its results, and the ID of a cursor from which the rest are
+	// read. The ID is empty if all the rows were returned. Memory reserved
+	// for the page is held until the returned release func is called.
+	OpenCursor(qr *command.QueryRequest, n int) (string, *command.QueryRows, func(), error)
+
+	// FetchCursor returns the next page of at most n rows from a cursor,
+	// and whether any rows remain. Memory reserved for the page is held
+	// until the returned release func is called.
+	FetchCursor(id string, n int) (*command.QueryRows, bool, func(), error)
+
+	// CloseCursor closes a cursor before all its rows have been read.
+	CloseCursor(id string) error
+}
+
+// handleCursor handles opening a cursor for a query (GET or POST /db/cursor),
+// fetching the next page of its results (GET /db/cursor/<id>), and closing it
+// (DELETE /db/cursor/<id>). Every response holds a single page of results,
+// and the ID of the cursor while rows remain. Since cursors are held by the
+// node which opened them, queries are never forwarded to the leader, though
+// a client may request to be redirected to it.
+func (s *Service) handleCursor(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if !s.CheckRequestPerm(r, auth.PermQuery) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if s.Cursors == nil {
+		http.Error(w, "cursors not supported", http.StatusNotFound)
+		return
+	}
+
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/db/cursor"), "/")
+	switch {
+	case id == "" && (r.Method == "GET" || r.Method == "POST"):
+		s.openCursor(w, r)
+	case id != "" && r.Method == "GET":
+		s.fetchCursor(w, r, id)
+	case id != "" && r.Method == "DELETE":
+		if err := s.Cursors.CloseCursor(id); err != nil {
+			http.Error(w, err.Error(), cursorErrorCode(err))
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Service) openCursor(w http.ResponseWriter, r *http.Request) {
+	release, ok := s.admit(w, r, true)
+	if !ok {
+		return
+	}
+	defer release()
+
+	n, err := cursorPageParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	_, frsh, lvl, _, timings, redirect, _, isAssoc, err := queryReqParams(r, defaultTimeout)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp, err := cursorResponse(r, isAssoc)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	queries, b, err := requestQueries(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	stats.Add(numQueryStmtsRx, int64(len(queries)))
+	if !s.checkPolicy(w, r, queries, b) {
+		return
+	}
+
+	qr := &command.QueryRequest{
+		Request: &command.Request{
+			Statements: queries,
+		},
+		Timings:   timings,
+		Level:     lvl,
+		Freshness: frsh.Nanoseconds(),
+	}
+
+	id, rows, release, err := s.Cursors.OpenCursor(qr, n)
+	defer release()
+	switch err {
+	case nil:
+		resp.Results.QueryRows = []*command.QueryRows{rows}
+		resp.Cursor = id
+	case store.ErrNotLeader:
+		leaderAPIAddr := s.LeaderAPIAddr()
+		if leaderAPIAddr == "" {
+			stats.Add(numLeaderNotFound, 1)
+			http.Error(w, ErrLeaderNotFound.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if !redirect {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		http.Redirect(w, r, s.FormRedirect(r, leaderAPIAddr), http.StatusMovedPermanently)
+		return
+	default:
+		if code := cursorErrorCode(err); code != http.StatusInternalServerError {
+			http.Error(w, err.Error(), code)
+			return
+		}
+		resp.Error = err.Error()
+	}
+	resp.end = time.Now()
+	s.writeResponse(w, r, resp)
+}
+
+func (s *Service) fetchCursor(w http.ResponseWriter, r *http.Request, id string) {
+	release, ok := s.admit(w, r, true)
+	if !ok {
+		return
+	}
+	defer release()
+
+	n, err := cursorPageParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	isAssoc, err := isAssociative(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp, err := cursorResponse(r, isAssoc)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rows, more, release, err := s.Cursors.FetchCursor(id, n)
+	defer release()
+	if err != nil {
+		http.Error(w, err.Error(), cursorErrorCode(err))
+		return
+	}
+	resp.Results.QueryRows = []*command.QueryRows{rows}
+	if more {
+		resp.Cursor = id
+	}
+	resp.end = time.Now()
+	s.writeResponse(w, r, resp)
+}
+
+// cursorResponse returns a Response rendering results as requested by r.
+func cursorResponse(r *http.Request, isAssoc bool) (*Response, error) {
+	proj, nulls, err := resultsFormat(r)
+	if err != nil {
+		return nil, err
+	}
+	resp := NewResponse()
+	resp.Results.AssociativeJSON = isAssoc
+	resp.Results.Projection = proj
+	resp.Results.Nulls = nulls
+	return resp, nil
+}
+
+// cursorPageParam returns the number of rows requested in each page.
+func cursorPageParam(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("page")
+	if v == "" {
+		return defaultCursorPageSize, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || n > maxCursorPageSize {
+		return 0, fmt.Errorf("page must be between 1 and %d", maxCursorPageSize)
+	}
+	return n, nil
+}
+
+// cursorErrorCode returns the HTTP status code for an error from a
+// CursorQuerier.
+func cursorErrorCode(err error) int {
+	switch err {
+	case store.ErrCursorNotFound:
+		return http.StatusNotFound
+	case store.ErrCursorStrong, store.ErrCursorStatements:
+		return http.StatusBadRequest
+	case store.ErrTooManyCursors:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,121 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/rqlite/rqlite/command"
+	"github.com/rqlite/rqlite/store"
+)
+
+type mockCursorQuerier struct {
+	openFn  func(qr *command.QueryRequest, n int) (string, *command.QueryRows, error)
+	fetchFn func(id string, n int) (*command.QueryRows, bool, error)
+	closed  []string
+}
+
+func (m *mockCursorQuerier) OpenCursor(qr *command.QueryRequest, n int) (string, *command.QueryRows, func(), error) {
+	id, rows, err := m.openFn(qr, n)
+	return id, rows, func() {}, err
+}
+
+func (m *mockCursorQuerier) FetchCursor(id string, n int) (*command.QueryRows, bool, func(), error) {
+	rows, more, err := m.fetchFn(id, n)
+	return rows, more, func() {}, err
+}
+
+func (m *mockCursorQuerier) CloseCursor(id string) error {
+	if id != "abc" {
+		return store.ErrCursorNotFound
+	}
+	m.closed = append(m.closed, id)
+	return nil
+}
+
+func Test_Cursor(t *testing.T) {
+	m := &MockStore{}
+	s := New("127.0.0.1:0", m, &mockClusterService{}, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	resp := mustDoRequest(t, "GET", host+"/db/cursor?q=SELECT+*+FROM+foo", "", "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("wrong status code when cursors not supported, exp %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	page := func(ids ...int64) *command.QueryRows {
+		rows := &command.QueryRows{Columns: []string{"id"}, Types: []string{"integer"}}
+		for _, id := range ids {
+			rows.Values = append(rows.Values, &command.Values{
+				Parameters: []*command.Parameter{{Value: &command.Parameter_I{I: id}}},
+			})
+		}
+		return rows
+	}
+	c := &mockCursorQuerier{
+		openFn: func(qr *command.QueryRequest, n int) (string, *command.QueryRows, error) {
+			if len(qr.Request.Statements) != 1 {
+				return "", nil, store.ErrCursorStatements
+			}
+			if n != 2 {
+				t.Errorf("wrong page size, exp 2, got %d", n)
+			}
+			if qr.Request.Statements[0].Sql == "SELECT * FROM bar" {
+				return "", &command.QueryRows{Error: "no such table: bar"}, nil
+			}
+			return "abc", page(1, 2), nil
+		},
+		fetchFn: func(id string, n int) (*command.QueryRows, bool, error) {
+			if id != "abc" {
+				return nil, false, store.ErrCursorNotFound
+			}
+			if n != defaultCursorPageSize {
+				t.Errorf("wrong page size, exp %d, got %d", defaultCursorPageSize, n)
+			}
+			return page(3), false, nil
+		},
+	}
+	s.Cursors = c
+
+	resp = mustDoRequest(t, "POST", host+"/db/cursor?page=2", `["SELECT * FROM foo"]`, "")
+	if exp, got := `{"results":[{"columns":["id"],"types":["integer"],"values":[[1],[2]]}],"cursor":"abc"}`, mustReadBody(t, resp); exp != got {
+		t.Fatalf("wrong response to opening cursor\nexp: %s\ngot: %s", exp, got)
+	}
+	resp = mustDoRequest(t, "GET", host+"/db/cursor/abc?associative", "", "")
+	if exp, got := `{"results":[{"types":{"id":"integer"},"rows":[{"id":3}]}]}`, mustReadBody(t, resp); exp != got {
+		t.Fatalf("wrong response to fetching from cursor\nexp: %s\ngot: %s", exp, got)
+	}
+	resp = mustDoRequest(t, "GET", host+"/db/cursor?page=2&q=SELECT+*+FROM+bar", "", "")
+	if exp, got := `{"results":[{"error":"no such table: bar"}]}`, mustReadBody(t, resp); exp != got {
+		t.Fatalf("wrong response to failed query\nexp: %s\ngot: %s", exp, got)
+	}
+
+	resp = mustDoRequest(t, "DELETE", host+"/db/cursor/abc", "", "")
+	if resp.StatusCode != http.StatusOK || len(c.closed) != 1 {
+		t.Fatalf("cursor not closed, status code %d", resp.StatusCode)
+	}
+
+	for _, tt := range []struct {
+		method string
+		path   string
+		body   string
+		code   int
+	}{
+		{"GET", "/db/cursor/xyz", "", http.StatusNotFound},
+		{"DELETE", "/db/cursor/xyz", "", http.StatusNotFound},
+		{"DELETE", "/db/cursor", "", http.StatusMethodNotAllowed},
+		{"POST", "/db/cursor/abc", "", http.StatusMethodNotAllowed},
+		{"POST", "/db/cursor", `["SELECT 1", "SELECT 2"]`, http.StatusBadRequest},
+		{"POST", "/db/cursor?page=0", `["SELECT 1"]`, http.StatusBadRequest},
+		{"GET", fmt.Sprintf("/db/cursor/abc?page=%d", maxCursorPageSize+1), "", http.StatusBadRequest},
+	} {
+		resp = mustDoRequest(t, tt.method, host+tt.path, tt.body, "")
+		if resp.StatusCode != tt.code {
+			t.Fatalf("wrong status code for %s %s, exp %d, got %d", tt.method, tt.path, tt.code, resp.StatusCode)
+		}
+	}
+}
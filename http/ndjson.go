@@ -0,0 +1,201 @@
+package http
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+
+	"github.com/rqlite/rqlite/command"
+	"github.com/rqlite/rqlite/command/encoding"
+)
+
+// ndjsonWriter is a db.RowsWriter which writes the results of queries as
+// newline-delimited JSON, one JSON object per line, so that clients can
+// process each row as it arrives. Each statement's results start with a line
+// holding the index of the statement, and its columns and their types. Each
+// row is then a line holding either its values, or with associative results,
+// a map of column to value. A statement which failed, or whose time was
+// requested, ends with a line holding the index of the statement, and its
+// error or time. An error which ended the queries, and the time taken by all
+// the queries, are on a final line without a statement index.
+type ndjsonWriter struct {
+	w     http.ResponseWriter
+	bw    *bufio.Writer
+	assoc bool
+	proj  *encoding.Projection
+	nulls encoding.NullMode
+
+	started bool
+	nStmts  int
+	nRows   int
+
+	// The columns and types of the current statement, as read, and as
+	// written once the projection is applied.
+	columns    []string
+	types      []string
+	outColumns []string
+	err        error
+}
+
+func newNDJSONWriter(w http.ResponseWriter, assoc bool, proj *encoding.Projection, nulls encoding.NullMode) *ndjsonWriter {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	return &ndjsonWriter{
+		w:     w,
+		bw:    bufio.NewWriterSize(w, streamBufferSize),
+		assoc: assoc,
+		proj:  proj,
+		nulls: nulls,
+	}
+}
+
+// WriteColumns implements db.RowsWriter.
+func (n *ndjsonWriter) WriteColumns(columns, types []string) error {
+	n.columns = columns
+	n.types = types
+	n.nRows = 0
+	r := n.proj.Apply(&command.QueryRows{Columns: columns, Types: types})
+	n.outColumns = r.Columns
+	n.writeLine(map[string]interface{}{
+		"statement": n.nStmts,
+		"columns":   nonNil(r.Columns),
+		"types":     nonNil(r.Types),
+	})
+	return n.err
+}
+
+// WriteRow implements db.RowsWriter.
+func (n *ndjsonWriter) WriteRow(values *command.Values) error {
+	r := n.proj.Apply(&command.QueryRows{
+		Columns: n.columns,
+		Types:   n.types,
+		Values:  []*command.Values{values},
+	})
+	row := make([][]interface{}, 1)
+	if err := encoding.NewValuesFromQueryValues(row, r.Values); err != nil {
+		return err
+	}
+	if n.nulls == encoding.NullsAsEmpty {
+		for i := range row[0] {
+			if row[0][i] == nil {
+				row[0][i] = ""
+			}
+		}
+	}
+
+	if n.assoc {
+		m := make(map[string]interface{}, len(n.outColumns))
+		for i, c := range n.outColumns {
+			if i >= len(row[0]) {
+				break
+			}
+			if row[0][i] == nil && n.nulls == encoding.NullsOmitted {
+				continue
+			}
+			m[c] = row[0][i]
+		}
+		n.writeLine(map[string]interface{}{"row": m})
+	} else {
+		n.writeLine(map[string]interface{}{"values": row[0]})
+	}
+	n.nRows++
+	if n.nRows == 1 {
+		// Get the first row to the client as soon as possible.
+		n.flush()
+	}
+	return n.err
+}
+
+// EndStatement implements db.RowsWriter.
+func (n *ndjsonWriter) EndStatement(errMsg string, t float64) error {
+	if errMsg != "" || t != 0 {
+		m := map[string]interface{}{"statement": n.nStmts}
+		if errMsg != "" {
+			m["error"] = errMsg
+		}
+		if t != 0 {
+			m["time"] = t
+		}
+		n.writeLine(m)
+	}
+	n.nStmts++
+	n.columns, n.types, n.outColumns = nil, nil, nil
+	return n.err
+}
+
+// hasStarted implements queryStreamer.
+func (n *ndjsonWriter) hasStarted() bool {
+	return n.started
+}
+
+// finish implements queryStreamer.
+func (n *ndjsonWriter) finish(err error, t float64) error {
+	if err != nil || t != 0 {
+		m := make(map[string]interface{})
+		if err != nil {
+			m["error"] = err.Error()
+		}
+		if t != 0 {
+			m["time"] = t
+		}
+		n.writeLine(m)
+	}
+	n.flush()
+	return n.err
+}
+
+// writeResults writes results which were not streamed, as if they had been.
+func (n *ndjsonWriter) writeResults(results []*command.QueryRows) error {
+	for _, r := range results {
+		if r.Columns != nil || r.Error == "" {
+			if err := n.WriteColumns(r.Columns, r.Types); err != nil {
+				return err
+			}
+			for _, v := range r.Values {
+				if err := n.WriteRow(v); err != nil {
+					return err
+				}
+			}
+		}
+		if err := n.EndStatement(r.Error, r.Time); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *ndjsonWriter) writeLine(v interface{}) {
+	if n.err != nil {
+		return
+	}
+	n.started = true
+	b, err := json.Marshal(v)
+	if err != nil {
+		n.err = err
+		return
+	}
+	if _, n.err = n.bw.Write(b); n.err != nil {
+		return
+	}
+	n.err = n.bw.WriteByte('\n')
+}
+
+func (n *ndjsonWriter) flush() {
+	if n.err != nil {
+		return
+	}
+	if n.err = n.bw.Flush(); n.err != nil {
+		return
+	}
+	if f, ok := n.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// nonNil returns s, or an empty slice if s is nil, so that it is written as
+// an empty JSON array rather than null.
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -0,0 +1,118 @@
+package http
+
+import (
+	"errors"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rqlite/rqlite/command"
+	"github.com/rqlite/rqlite/command/encoding"
+	"github.com/rqlite/rqlite/db"
+)
+
+func Test_NDJSONWriter(t *testing.T) {
+	d, err := db.Open(filepath.Join(t.TempDir(), "db.sqlite"), false, true)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err)
+	}
+	defer d.Close()
+	for _, stmt := range []string{
+		"CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT)",
+		`INSERT INTO foo(name) VALUES("fiona")`,
+		`INSERT INTO foo(name) VALUES(NULL)`,
+	} {
+		if _, err := d.ExecuteStringStmt(stmt); err != nil {
+			t.Fatalf("failed to execute %s: %s", stmt, err)
+		}
+	}
+
+	req := &command.Request{
+		Statements: []*command.Statement{
+			{Sql: "SELECT * FROM foo"},
+			{Sql: "SELECT * FROM foo WHERE id > 10"},
+			{Sql: "SELECT * FROM bar"},
+		},
+	}
+	proj, err := encoding.ParseProjection("name:n,id")
+	if err != nil {
+		t.Fatalf("failed to parse projection: %s", err)
+	}
+
+	for _, tt := range []struct {
+		assoc bool
+		proj  *encoding.Projection
+		nulls encoding.NullMode
+		exp   []string
+	}{
+		{
+			exp: []string{
+				`{"columns":["id","name"],"statement":0,"types":["integer","text"]}`,
+				`{"values":[1,"fiona"]}`,
+				`{"values":[2,null]}`,
+				`{"columns":["id","name"],"statement":1,"types":["integer","text"]}`,
+				`{"error":"no such table: bar","statement":2}`,
+				`{"error":"commit failed"}`,
+			},
+		},
+		{
+			assoc: true,
+			proj:  proj,
+			nulls: encoding.NullsOmitted,
+			exp: []string{
+				`{"columns":["n","id"],"statement":0,"types":["text","integer"]}`,
+				`{"row":{"id":1,"n":"fiona"}}`,
+				`{"row":{"id":2}}`,
+				`{"columns":["n","id"],"statement":1,"types":["text","integer"]}`,
+				`{"error":"no such table: bar","statement":2}`,
+				`{"error":"commit failed"}`,
+			},
+		},
+		{
+			nulls: encoding.NullsAsEmpty,
+			exp: []string{
+				`{"columns":["id","name"],"statement":0,"types":["integer","text"]}`,
+				`{"values":[1,"fiona"]}`,
+				`{"values":[2,""]}`,
+				`{"columns":["id","name"],"statement":1,"types":["integer","text"]}`,
+				`{"error":"no such table: bar","statement":2}`,
+				`{"error":"commit failed"}`,
+			},
+		},
+	} {
+		exp := strings.Join(tt.exp, "\n") + "\n"
+
+		rr := httptest.NewRecorder()
+		nw := newNDJSONWriter(rr, tt.assoc, tt.proj, tt.nulls)
+		if err := d.QueryStream(req, false, nw); err != nil {
+			t.Fatalf("failed to stream query: %s", err)
+		}
+		if err := nw.finish(errors.New("commit failed"), 0); err != nil {
+			t.Fatalf("failed to finish stream: %s", err)
+		}
+		if got := rr.Body.String(); got != exp {
+			t.Fatalf("wrong streamed response\nexp: %s\ngot: %s", exp, got)
+		}
+		if !rr.Flushed {
+			t.Fatalf("streamed response not flushed")
+		}
+
+		// Results which were not streamed are written in the same way.
+		rows, err := d.Query(req, false)
+		if err != nil {
+			t.Fatalf("failed to query: %s", err)
+		}
+		rr = httptest.NewRecorder()
+		nw = newNDJSONWriter(rr, tt.assoc, tt.proj, tt.nulls)
+		if err := nw.writeResults(rows); err != nil {
+			t.Fatalf("failed to write results: %s", err)
+		}
+		if err := nw.finish(errors.New("commit failed"), 0); err != nil {
+			t.Fatalf("failed to finish stream: %s", err)
+		}
+		if got := rr.Body.String(); got != exp {
+			t.Fatalf("wrong response for results not streamed\nexp: %s\ngot: %s", exp, got)
+		}
+	}
+}
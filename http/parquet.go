@@ -0,0 +1,204 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/rqlite/rqlite/command"
+	"github.com/rqlite/rqlite/command/encoding"
+	"github.com/rqlite/rqlite/parquet"
+)
+
+// ParquetErrorTrailer is the HTTP trailer holding any error which occurred
+// after writing of query results as Parquet began. The file is then left
+// incomplete, so readers reject it.
+const ParquetErrorTrailer = "X-RQLITE-ERROR"
+
+// errParquetStatements is returned when results written as Parquet are not
+// those of exactly one statement, since a Parquet file holds a single table.
+var errParquetStatements = errors.New("parquet results must be of exactly one query")
+
+// parquetColumnType returns the Parquet type of the values of a column with
+// the declared type t, following SQLite's rules for type affinity, after
+// recognising the types SQLite uses for booleans, dates, and times.
+func parquetColumnType(t string) parquet.Type {
+	t = strings.ToLower(t)
+	switch {
+	case t == "bool" || t == "boolean":
+		return parquet.Boolean
+	case strings.HasPrefix(t, "datetime") || strings.HasPrefix(t, "timestamp"):
+		return parquet.Timestamp
+	case t == "date":
+		return parquet.Date
+	case strings.Contains(t, "int"):
+		return parquet.Int64
+	case strings.Contains(t, "char") || strings.Contains(t, "clob") || strings.Contains(t, "text") || t == "json" || t == "":
+		return parquet.String
+	case strings.Contains(t, "blob"):
+		return parquet.Bytes
+	default:
+		return parquet.Double
+	}
+}
+
+// parquetWriter is a db.RowsWriter which writes the results of a single
+// query as a Parquet file. Each column is typed by its declared type. Rows
+// are written in row groups, so nothing reaches the client until the first
+// row group is complete, and any error before then is returned as a plain
+// HTTP error rather than a file.
+type parquetWriter struct {
+	w    http.ResponseWriter
+	cw   *countingWriter
+	proj *encoding.Projection
+
+	pw      *parquet.Writer
+	nStmts  int
+	columns []string
+	types   []string
+	errs    []string
+	err     error
+}
+
+func newParquetWriter(w http.ResponseWriter, proj *encoding.Projection) *parquetWriter {
+	w.Header().Set("Content-Type", parquet.ContentType)
+	w.Header().Add("Trailer", ParquetErrorTrailer)
+	return &parquetWriter{
+		w:    w,
+		cw:   &countingWriter{w: w},
+		proj: proj,
+	}
+}
+
+// WriteColumns implements db.RowsWriter.
+func (p *parquetWriter) WriteColumns(columns, types []string) error {
+	if p.nStmts > 0 {
+		return errParquetStatements
+	}
+	p.columns = columns
+	p.types = types
+	r := p.proj.Apply(&command.QueryRows{Columns: columns, Types: types})
+	cols := make([]parquet.Column, len(r.Columns))
+	for i := range r.Columns {
+		var t string
+		if i < len(r.Types) {
+			t = r.Types[i]
+		}
+		cols[i] = parquet.Column{Name: r.Columns[i], Type: parquetColumnType(t)}
+	}
+	p.pw, p.err = parquet.NewWriter(p.cw, cols)
+	return p.err
+}
+
+// WriteRow implements db.RowsWriter. Once a row cannot be written, the
+// remaining rows are discarded, and the error reported when the response
+// is finished.
+func (p *parquetWriter) WriteRow(values *command.Values) error {
+	if p.err != nil || len(p.errs) > 0 {
+		return p.err
+	}
+	r := p.proj.Apply(&command.QueryRows{
+		Columns: p.columns,
+		Types:   p.types,
+		Values:  []*command.Values{values},
+	})
+	row := make([][]interface{}, 1)
+	if err := encoding.NewValuesFromQueryValues(row, r.Values); err != nil {
+		return err
+	}
+	if err := p.pw.Write(row[0]); err != nil {
+		if p.cw.err != nil {
+			p.err = p.cw.err
+			return p.err
+		}
+		p.errs = append(p.errs, err.Error())
+	}
+	return nil
+}
+
+// EndStatement implements db.RowsWriter.
+func (p *parquetWriter) EndStatement(errMsg string, t float64) error {
+	if errMsg != "" {
+		p.errs = append(p.errs, errMsg)
+	}
+	p.nStmts++
+	return p.err
+}
+
+// hasStarted implements queryStreamer.
+func (p *parquetWriter) hasStarted() bool {
+	return p.cw.n > 0
+}
+
+// finish implements queryStreamer. The time taken is not written.
+func (p *parquetWriter) finish(err error, t float64) error {
+	if p.err != nil {
+		return p.err
+	}
+	if err == nil && len(p.errs) == 0 && p.pw == nil {
+		err = errParquetStatements
+	}
+	if err != nil {
+		p.errs = append(p.errs, err.Error())
+	}
+	if len(p.errs) > 0 {
+		msg := strings.Join(p.errs, "; ")
+		if !p.hasStarted() {
+			removeTrailer(p.w.Header(), ParquetErrorTrailer)
+			code := http.StatusBadRequest
+			if err != nil {
+				code = http.StatusInternalServerError
+			}
+			http.Error(p.w, msg, code)
+			return nil
+		}
+		p.w.Header().Set(ParquetErrorTrailer, msg)
+		return nil
+	}
+	if p.err = p.pw.Close(); p.err != nil {
+		return p.err
+	}
+	if f, ok := p.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// writeResults writes results which were not streamed, as if they had been.
+func (p *parquetWriter) writeResults(results []*command.QueryRows) error {
+	for _, r := range results {
+		if r.Columns != nil || r.Error == "" {
+			if err := p.WriteColumns(r.Columns, r.Types); err != nil {
+				return err
+			}
+			for _, v := range r.Values {
+				if err := p.WriteRow(v); err != nil {
+					return err
+				}
+			}
+		}
+		if err := p.EndStatement(r.Error, r.Time); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countingWriter counts the bytes written to w, and records any error.
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	if err != nil {
+		c.err = fmt.Errorf("writing response: %w", err)
+		return n, c.err
+	}
+	return n, nil
+}
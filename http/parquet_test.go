@@ -0,0 +1,128 @@
+package http
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rqlite/rqlite/command"
+	"github.com/rqlite/rqlite/db"
+	"github.com/rqlite/rqlite/parquet"
+)
+
+func Test_ParquetColumnType(t *testing.T) {
+	for decl, exp := range map[string]parquet.Type{
+		"integer":      parquet.Int64,
+		"BIGINT":       parquet.Int64,
+		"text":         parquet.String,
+		"varchar(255)": parquet.String,
+		"json":         parquet.String,
+		"":             parquet.String,
+		"blob":         parquet.Bytes,
+		"real":         parquet.Double,
+		"numeric":      parquet.Double,
+		"boolean":      parquet.Boolean,
+		"datetime":     parquet.Timestamp,
+		"timestamp":    parquet.Timestamp,
+		"date":         parquet.Date,
+	} {
+		if got := parquetColumnType(decl); exp != got {
+			t.Fatalf("wrong Parquet type for %q, exp %s, got %s", decl, exp, got)
+		}
+	}
+}
+
+func Test_ParquetWriter(t *testing.T) {
+	d, err := db.Open(filepath.Join(t.TempDir(), "db.sqlite"), false, true)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err)
+	}
+	defer d.Close()
+	for _, stmt := range []string{
+		"CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT, score REAL, data BLOB, ok BOOLEAN, at DATETIME)",
+		`INSERT INTO foo(name, score, data, ok, at) VALUES("fiona", 1.5, x'0102', 1, "2024-01-02 03:04:05")`,
+		`INSERT INTO foo(name, score, data, ok, at) VALUES(NULL, 2, NULL, 0, NULL)`,
+		`INSERT INTO foo(id, score) VALUES(3, "high")`,
+	} {
+		if _, err := d.ExecuteStringStmt(stmt); err != nil {
+			t.Fatalf("failed to execute %s: %s", stmt, err)
+		}
+	}
+
+	query := func(sql string) *command.Request {
+		return &command.Request{Statements: []*command.Statement{{Sql: sql}}}
+	}
+
+	// The results are the same whether or not they are streamed.
+	req := query("SELECT * FROM foo WHERE id < 3")
+	rr := httptest.NewRecorder()
+	pw := newParquetWriter(rr, nil)
+	if err := d.QueryStream(req, false, pw); err != nil {
+		t.Fatalf("failed to stream query: %s", err)
+	}
+	if pw.hasStarted() {
+		t.Fatalf("results written before row group complete")
+	}
+	if err := pw.finish(nil, 0); err != nil {
+		t.Fatalf("failed to finish stream: %s", err)
+	}
+	streamed := rr.Body.Bytes()
+	if !bytes.HasPrefix(streamed, []byte("PAR1")) || !bytes.HasSuffix(streamed, []byte("PAR1")) {
+		t.Fatalf("response is not a Parquet file: %q", streamed)
+	}
+	if exp, got := parquet.ContentType, rr.Header().Get("Content-Type"); exp != got {
+		t.Fatalf("wrong content type, exp %s, got %s", exp, got)
+	}
+
+	rows, err := d.Query(req, false)
+	if err != nil {
+		t.Fatalf("failed to query: %s", err)
+	}
+	rr = httptest.NewRecorder()
+	pw = newParquetWriter(rr, nil)
+	if err := pw.writeResults(rows); err != nil {
+		t.Fatalf("failed to write results: %s", err)
+	}
+	if err := pw.finish(nil, 0); err != nil {
+		t.Fatalf("failed to finish stream: %s", err)
+	}
+	if !bytes.Equal(streamed, rr.Body.Bytes()) {
+		t.Fatalf("results not streamed differ from those streamed")
+	}
+
+	// Errors before the first row group is written are plain HTTP errors.
+	for _, tt := range []struct {
+		req *command.Request
+		exp string
+	}{
+		{query("SELECT * FROM bar"), "no such table: bar"},
+		{query("SELECT id, score FROM foo"), "column score: cannot write string value high as double"},
+		{query("SELECT id FROM foo WHERE id > 10"), ""},
+		{
+			&command.Request{Statements: []*command.Statement{{Sql: "SELECT id FROM foo"}, {Sql: "SELECT 1"}}},
+			errParquetStatements.Error(),
+		},
+	} {
+		rr = httptest.NewRecorder()
+		pw = newParquetWriter(rr, nil)
+		if err := d.QueryStream(tt.req, false, pw); err != nil {
+			t.Fatalf("failed to stream query: %s", err)
+		}
+		if err := pw.finish(nil, 0); err != nil {
+			t.Fatalf("failed to finish stream: %s", err)
+		}
+		sql := tt.req.Statements[0].Sql
+		if tt.exp == "" {
+			if rr.Code != http.StatusOK || !bytes.HasPrefix(rr.Body.Bytes(), []byte("PAR1")) {
+				t.Fatalf("expected empty Parquet file for %s, got %d %q", sql, rr.Code, rr.Body.String())
+			}
+			continue
+		}
+		if rr.Code != http.StatusBadRequest || strings.TrimSpace(rr.Body.String()) != tt.exp {
+			t.Fatalf("wrong response for %s, exp error %s, got %d %q", sql, tt.exp, rr.Code, rr.Body.String())
+		}
+	}
+}
@@ -5,10 +5,18 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"mime"
+	"net/http"
 
 	"github.com/rqlite/rqlite/command"
+	"google.golang.org/protobuf/proto"
 )
 
+// ProtobufContentType is the content type of request bodies holding a
+// Protocol Buffers encoded command.Request. BLOB parameters are sent as raw
+// bytes, and so without the overhead of base64-encoding them in JSON.
+const ProtobufContentType = "application/x-protobuf"
+
 var (
 	// ErrNoStatements is returned when a request is empty
 	ErrNoStatements = errors.New("no statements")
@@ -16,6 +24,10 @@ var (
 	// ErrInvalidJSON is returned when a body is not valid JSON
 	ErrInvalidJSON = errors.New("invalid JSON body")
 
+	// ErrInvalidProtobuf is returned when a body is not a valid Protocol
+	// Buffers encoded request.
+	ErrInvalidProtobuf = errors.New("invalid Protocol Buffers body")
+
 	// ErrInvalidRequest is returned when a request cannot be parsed.
 	ErrInvalidRequest = errors.New("invalid request")
 
@@ -23,14 +35,45 @@ var (
 	ErrUnsupportedType = errors.New("unsupported type")
 )
 
+// parseRequestBody generates a set of Statements from b, the body of r, which
+// is JSON unless the content type of r is ProtobufContentType.
+func parseRequestBody(r *http.Request, b []byte) ([]*command.Statement, error) {
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		if mt, _, err := mime.ParseMediaType(ct); err == nil && mt == ProtobufContentType {
+			return ParseProtobufRequest(b)
+		}
+	}
+	return ParseRequest(b)
+}
+
+// ParseProtobufRequest generates a set of Statements from a Protocol Buffers
+// encoded command.Request. Only the Statements of the Request are used.
+func ParseProtobufRequest(b []byte) ([]*command.Statement, error) {
+	if len(b) == 0 {
+		return nil, ErrNoStatements
+	}
+	var req command.Request
+	if err := proto.Unmarshal(b, &req); err != nil {
+		return nil, ErrInvalidProtobuf
+	}
+	if len(req.Statements) == 0 {
+		return nil, ErrNoStatements
+	}
+	for _, stmt := range req.Statements {
+		if stmt == nil {
+			return nil, ErrInvalidRequest
+		}
+	}
+	return req.Statements, nil
+}
+
 // ParseRequest generates a set of Statements for a given byte slice.
 func ParseRequest(b []byte) ([]*command.Statement, error) {
 	if len(b) == 0 {
 		return nil, ErrNoStatements
 	}
 
-	var simple []string               // Represents a set of unparameterized queries
-	var parameterized [][]interface{} // Represents a set of parameterized queries
+	var simple []string // Represents a set of unparameterized queries
 
 	// Try simple form first.
 	err := json.Unmarshal(b, &simple)
@@ -48,7 +91,9 @@ func ParseRequest(b []byte) ([]*command.Statement, error) {
 		return stmts, nil
 	}
 
-	// Next try parameterized form.
+	// Next try parameterized form, in which a statement may also be an object
+	// holding SQL to be executed once for each of a set of parameters.
+	var parameterized []interface{}
 	dec := json.NewDecoder(bytes.NewReader(b))
 	dec.UseNumber()
 	if err := dec.Decode(&parameterized); err != nil {
@@ -57,44 +102,114 @@ func ParseRequest(b []byte) ([]*command.Statement, error) {
 	stmts := make([]*command.Statement, len(parameterized))
 
 	for i := range parameterized {
-		if len(parameterized[i]) == 0 {
-			return nil, ErrNoStatements
+		var err error
+		switch v := parameterized[i].(type) {
+		case []interface{}:
+			stmts[i], err = makeStatement(v)
+		case map[string]interface{}:
+			stmts[i], err = makeParamSetsStatement(v)
+		default:
+			return nil, ErrInvalidJSON
+		}
+		if err != nil {
+			return nil, err
 		}
+	}
+	return stmts, nil
+}
 
-		sql, ok := parameterized[i][0].(string)
-		if !ok {
+// makeStatement generates a Statement from its SQL followed by its parameters.
+func makeStatement(v []interface{}) (*command.Statement, error) {
+	if len(v) == 0 {
+		return nil, ErrNoStatements
+	}
+	sql, ok := v[0].(string)
+	if !ok {
+		return nil, ErrInvalidRequest
+	}
+	stmt := &command.Statement{
+		Sql:        sql,
+		Parameters: nil,
+	}
+	if len(v) == 1 {
+		// No actual parameters after the SQL string
+		return stmt, nil
+	}
+
+	params, err := makeParameters(v[1:])
+	if err != nil {
+		return nil, err
+	}
+	stmt.Parameters = params
+	return stmt, nil
+}
+
+// makeParamSetsStatement generates a Statement from an object of the form
+// {"sql": "INSERT ...", "param_sets": [[...], [...]]}, which is executed once
+// for each parameter set. A set is either an array of parameters, in the
+// same form as those of a parameterized statement, or an object of named
+// parameters.
+func makeParamSetsStatement(m map[string]interface{}) (*command.Statement, error) {
+	for k := range m {
+		if k != "sql" && k != "param_sets" {
 			return nil, ErrInvalidRequest
 		}
-		stmts[i] = &command.Statement{
-			Sql:        sql,
-			Parameters: nil,
+	}
+	sql, ok := m["sql"].(string)
+	if !ok {
+		return nil, ErrInvalidRequest
+	}
+	sets, ok := m["param_sets"].([]interface{})
+	if !ok || len(sets) == 0 {
+		return nil, ErrInvalidRequest
+	}
+
+	stmt := &command.Statement{
+		Sql:       sql,
+		ParamSets: make([]*command.ParameterSet, len(sets)),
+	}
+	for i := range sets {
+		var params []*command.Parameter
+		var err error
+		switch v := sets[i].(type) {
+		case []interface{}:
+			params, err = makeParameters(v)
+		case map[string]interface{}:
+			params, err = makeParameters([]interface{}{v})
+		default:
+			return nil, ErrInvalidRequest
 		}
-		if len(parameterized[i]) == 1 {
-			// No actual parameters after the SQL string
-			continue
+		if err != nil {
+			return nil, err
 		}
+		stmt.ParamSets[i] = &command.ParameterSet{Parameters: params}
+	}
+	return stmt, nil
+}
 
-		stmts[i].Parameters = make([]*command.Parameter, 0)
-		for j := range parameterized[i][1:] {
-			m, ok := parameterized[i][j+1].(map[string]interface{})
-			if ok {
-				for k, v := range m {
-					p, err := makeParameter(k, v)
-					if err != nil {
-						return nil, err
-					}
-					stmts[i].Parameters = append(stmts[i].Parameters, p)
-				}
-			} else {
-				p, err := makeParameter("", parameterized[i][j+1])
+// makeParameters generates Parameters from values, each of which is either a
+// positional parameter or an object of named parameters.
+func makeParameters(values []interface{}) ([]*command.Parameter, error) {
+	params := make([]*command.Parameter, 0)
+	for j := range values {
+		m, ok := values[j].(map[string]interface{})
+		if ok {
+			for k, v := range m {
+				p, err := makeParameter(k, v)
 				if err != nil {
 					return nil, err
 				}
-				stmts[i].Parameters = append(stmts[i].Parameters, p)
+				params = append(params, p)
 			}
+		} else {
+			p, err := makeParameter("", values[j])
+			if err != nil {
+				return nil, err
+			}
+			params = append(params, p)
 		}
 	}
-	return stmts, nil
+	return params, nil
 }
 
 func makeParameter(name string, i interface{}) (*command.Parameter, error) {
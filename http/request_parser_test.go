@@ -1,11 +1,15 @@
 package http
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"reflect"
 	"strconv"
 	"testing"
+
+	"github.com/rqlite/rqlite/command"
+	"google.golang.org/protobuf/proto"
 )
 
 func Test_NilRequest(t *testing.T) {
@@ -380,6 +384,60 @@ func Test_SingleInvalidTypeRequests(t *testing.T) {
 	}
 }
 
+func Test_ParamSetsRequest(t *testing.T) {
+	b := []byte(`[
+		["CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT, age INTEGER)"],
+		{"sql": "INSERT INTO foo(name, age) VALUES(?, ?)", "param_sets": [["fiona", 20], ["declan", null]]},
+		{"sql": "INSERT INTO foo(name) VALUES(:name)", "param_sets": [{"name": "aoife"}, [{"name": "sinead"}]]},
+		["SELECT * FROM foo WHERE age > ?", 10]
+	]`)
+	stmts, err := ParseRequest(b)
+	if err != nil {
+		t.Fatalf("failed to parse request: %s", err.Error())
+	}
+	if len(stmts) != 4 {
+		t.Fatalf("incorrect number of statements returned: %d", len(stmts))
+	}
+	if stmts[0].ParamSets != nil || stmts[3].ParamSets != nil {
+		t.Fatalf("statements without parameter sets have parameter sets")
+	}
+	if exp, got := "INSERT INTO foo(name, age) VALUES(?, ?)", stmts[1].Sql; exp != got {
+		t.Fatalf("incorrect statement parsed, exp %s, got %s", exp, got)
+	}
+	if stmts[1].Parameters != nil {
+		t.Fatalf("statement with parameter sets has parameters")
+	}
+	sets := stmts[1].ParamSets
+	if len(sets) != 2 || len(sets[0].Parameters) != 2 || len(sets[1].Parameters) != 2 {
+		t.Fatalf("incorrect parameter sets parsed: %v", sets)
+	}
+	if sets[0].Parameters[0].GetS() != "fiona" || sets[0].Parameters[1].GetI() != 20 {
+		t.Fatalf("incorrect parameters parsed: %v", sets[0].Parameters)
+	}
+	if sets[1].Parameters[0].GetS() != "declan" || sets[1].Parameters[1].GetValue() != nil {
+		t.Fatalf("incorrect parameters parsed: %v", sets[1].Parameters)
+	}
+	for i, exp := range []string{"aoife", "sinead"} {
+		p := stmts[2].ParamSets[i].Parameters
+		if len(p) != 1 || p[0].Name != "name" || p[0].GetS() != exp {
+			t.Fatalf("incorrect named parameters parsed: %v", p)
+		}
+	}
+
+	for _, b := range []string{
+		`[{"sql": "INSERT INTO foo(name) VALUES(?)"}]`,
+		`[{"sql": "INSERT INTO foo(name) VALUES(?)", "param_sets": []}]`,
+		`[{"sql": "INSERT INTO foo(name) VALUES(?)", "param_sets": ["fiona"]}]`,
+		`[{"sql": "INSERT INTO foo(name) VALUES(?)", "param_sets": [["fiona"]], "params": []}]`,
+		`[{"sql": 1, "param_sets": [[1]]}]`,
+		`[{"param_sets": [[1]]}]`,
+	} {
+		if _, err := ParseRequest([]byte(b)); err != ErrInvalidRequest {
+			t.Fatalf("got unexpected error for invalid request %s: %v", b, err)
+		}
+	}
+}
+
 func mustJSONMarshal(v interface{}) []byte {
 	b, err := json.Marshal(v)
 	if err != nil {
@@ -387,3 +445,49 @@ func mustJSONMarshal(v interface{}) []byte {
 	}
 	return b
 }
+
+func Test_ProtobufRequest(t *testing.T) {
+	if _, err := ParseProtobufRequest(nil); err != ErrNoStatements {
+		t.Fatalf("nil request did not result in correct error")
+	}
+	if _, err := ParseProtobufRequest([]byte("not protobuf")); err != ErrInvalidProtobuf {
+		t.Fatalf("invalid request did not result in correct error")
+	}
+
+	blob := []byte{0x00, 0x01, 0xfe, 0xff}
+	req := &command.Request{
+		Statements: []*command.Statement{
+			{
+				Sql: "INSERT INTO foo(data) VALUES(?)",
+				Parameters: []*command.Parameter{
+					{Value: &command.Parameter_Y{Y: blob}},
+				},
+			},
+		},
+	}
+	b, err := proto.Marshal(req)
+	if err != nil {
+		t.Fatalf("failed to marshal request: %s", err.Error())
+	}
+	stmts, err := ParseProtobufRequest(b)
+	if err != nil {
+		t.Fatalf("failed to parse request: %s", err.Error())
+	}
+	if len(stmts) != 1 {
+		t.Fatalf("incorrect number of statements returned: %d", len(stmts))
+	}
+	if stmts[0].Sql != "INSERT INTO foo(data) VALUES(?)" {
+		t.Fatalf("incorrect statement parsed, exp %s, got %s", "INSERT INTO foo(data) VALUES(?)", stmts[0].Sql)
+	}
+	if !bytes.Equal(stmts[0].Parameters[0].GetY(), blob) {
+		t.Fatalf("incorrect BLOB parameter parsed: %v", stmts[0].Parameters[0].GetY())
+	}
+
+	b, err = proto.Marshal(&command.Request{})
+	if err != nil {
+		t.Fatalf("failed to marshal request: %s", err.Error())
+	}
+	if _, err := ParseProtobufRequest(b); err != ErrNoStatements {
+		t.Fatalf("empty request did not result in correct error")
+	}
+}
@@ -4,6 +4,8 @@ package http
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/tls"
 	"encoding/json"
@@ -12,25 +14,33 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net"
 	"net/http"
 	"net/http/pprof"
 	"os"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/hashicorp/raft"
 	"github.com/rqlite/rqlite/auth"
 	"github.com/rqlite/rqlite/cluster"
 	"github.com/rqlite/rqlite/command"
 	"github.com/rqlite/rqlite/command/chunking"
 	"github.com/rqlite/rqlite/command/encoding"
 	"github.com/rqlite/rqlite/db"
+	"github.com/rqlite/rqlite/overload"
+	"github.com/rqlite/rqlite/parquet"
 	"github.com/rqlite/rqlite/queue"
+	"github.com/rqlite/rqlite/random"
 	"github.com/rqlite/rqlite/rtls"
+	"github.com/rqlite/rqlite/standby"
 	"github.com/rqlite/rqlite/store"
+	"github.com/rqlite/rqlite/trash"
 )
 
 const (
@@ -73,6 +83,18 @@ type Database interface {
 type Store interface {
 	Database
 
+	// QueryReserved is the same as Query, but memory reserved for the
+	// results is held until the returned release func is called.
+	QueryReserved(qr *command.QueryRequest) ([]*command.QueryRows, func(), error)
+
+	// RequestReserved is the same as Request, but memory reserved for the
+	// results is held until the returned release func is called.
+	RequestReserved(eqr *command.ExecuteQueryRequest) ([]*command.ExecuteQueryResponse, func(), error)
+
+	// CheckInternal returns an error if any of the statements accesses a
+	// table reserved for rqlite's own use.
+	CheckInternal(stmts []*command.Statement) error
+
 	// Join joins the node with the given ID, reachable at addr, to this node.
 	Join(jr *command.JoinRequest) error
 
@@ -85,6 +107,9 @@ type Store interface {
 	// LeaderAddr returns the Raft address of the leader of the cluster.
 	LeaderAddr() (string, error)
 
+	// IsLeader returns whether this node is the leader of the cluster.
+	IsLeader() bool
+
 	// Ready returns whether the Store is ready to service requests.
 	Ready() bool
 
@@ -131,6 +156,220 @@ type CredentialStore interface {
 	AA(username, password, perm string) bool
 }
 
+// StatementPolicy is the interface statement policy engines must support.
+type StatementPolicy interface {
+	// Check returns an error if username is not permitted to execute the
+	// given statements. If confirmed is true, the client has confirmed that
+	// the statements should be executed.
+	Check(username string, stmts []*command.Statement, confirmed bool) error
+}
+
+// OverloadController is the interface overload controllers must support.
+type OverloadController interface {
+	// Admit returns an error if a request of the given priority should be
+	// shed. It may block until the request can proceed, or done is closed.
+	Admit(priority string, done <-chan struct{}) error
+
+	// ObserveApply records the latency of a write.
+	ObserveApply(d time.Duration)
+
+	// ObserveQuery records the latency of a read.
+	ObserveQuery(d time.Duration)
+
+	// RetryAfter returns how long clients should wait before retrying
+	// a shed request.
+	RetryAfter() time.Duration
+}
+
+// ConcurrencyPools is the interface concurrency pools must support.
+type ConcurrencyPools interface {
+	// Acquire acquires a slot in the named pool, returning a function which
+	// releases it. It may block until a slot is free, or done is closed.
+	Acquire(name string, done <-chan struct{}) (func(), error)
+}
+
+// QueueMonitor is the interface queue monitors must support.
+type QueueMonitor interface {
+	// Register registers a queue whose depth is reported by depth.
+	Register(name string, depth func() int)
+
+	// Saturated returns why a queue is saturated, or the empty string if
+	// none is.
+	Saturated() string
+}
+
+// ChangeFeed is the interface a cluster must implement to act as the primary
+// of a warm standby cluster.
+type ChangeFeed interface {
+	// Changes returns up to max changes after the log index since, and the
+	// index from which to request the next changes.
+	Changes(since uint64, max int) ([]*store.Change, uint64, error)
+
+	// BackupWithIndex writes a copy of the database to dst, and returns the
+	// index of the last change reflected in it.
+	BackupWithIndex(dst io.Writer) (uint64, error)
+
+	// Fence permanently stops the cluster accepting writes.
+	Fence(id string) error
+
+	// Fenced returns whether the cluster is fenced, and by whom.
+	Fenced() (bool, string)
+}
+
+// StandbyConsumer is the interface the consumer of a warm standby cluster
+// must implement.
+type StandbyConsumer interface {
+	// Promote promotes the standby cluster to be a primary, fencing the old
+	// primary first. If force is set, promotion proceeds even if the old
+	// primary cannot be fenced.
+	Promote(force bool) error
+}
+
+// RollingRestarter is the interface the orchestrator of cluster-wide rolling
+// restarts must implement.
+type RollingRestarter interface {
+	// Start starts restarting the given nodes, one at a time, in the
+	// background.
+	Start(nodes []cluster.RestartNode, creds *cluster.Credentials) error
+
+	// Stats returns the status of the current, or most recent, rolling
+	// restart.
+	Stats() (map[string]interface{}, error)
+}
+
+// NodeReplacer is the interface the orchestrator of node replacements must
+// implement.
+type NodeReplacer interface {
+	// Start starts replacing one node with another in the background.
+	Start(rp cluster.Replacement) error
+
+	// Stats returns the status of the current, or most recent, replacement.
+	Stats() (map[string]interface{}, error)
+}
+
+// NodeResyncer is the interface the system which resyncs this node's
+// database from the leader's must implement.
+type NodeResyncer interface {
+	// Resync starts resyncing this node in the background.
+	Resync(creds *cluster.Credentials) error
+
+	// Stats returns the status of the current, or most recent, resync.
+	Stats() (map[string]interface{}, error)
+}
+
+// SchemaNotifier is the interface a store must implement to report changes
+// to the schema of the database.
+type SchemaNotifier interface {
+	// SchemaVersion returns the schema version of the database.
+	SchemaVersion() int64
+
+	// WaitSchemaChange waits until the schema version differs from version,
+	// the timeout expires, or done is closed, and returns the schema version.
+	WaitSchemaChange(version int64, timeout time.Duration, done <-chan struct{}) int64
+}
+
+// TableAccess is the interface a store must implement to report the
+// reads of each table by queries.
+type TableAccess interface {
+	// TableReads returns the reads of each table, including those never
+	// read, and when counting began.
+	TableReads() (map[string]db.TableReads, time.Time, error)
+
+	// ResetTableReads discards the counts, so counting begins again.
+	ResetTableReads()
+}
+
+// Validator is the interface a store must implement to validate statements
+// without executing them.
+type Validator interface {
+	// Validate compiles each statement against the schema of the database,
+	// and reports whether it could be executed.
+	Validate(req *command.Request) ([]*db.Validation, error)
+}
+
+// SandboxQuerier is the interface a store must implement to execute queries
+// on a sandboxed connection, which can only read the database.
+type SandboxQuerier interface {
+	// QuerySandboxed executes queries which can only read the database.
+	// Memory reserved for the results is held until the returned release
+	// func is called.
+	QuerySandboxed(qr *command.QueryRequest) ([]*command.QueryRows, func(), error)
+}
+
+// QueryMirror is the interface the mirror of queries to a second cluster
+// must implement.
+type QueryMirror interface {
+	// Mirror sends a copy of the queries, and the results returned for them,
+	// to the second cluster. results is nil if they were streamed to the
+	// client. Mirror must not block.
+	Mirror(qr *command.QueryRequest, results []*command.QueryRows)
+}
+
+// SnapshotSource is the interface a store must implement to serve its latest
+// Raft snapshot to joining nodes and operators.
+type SnapshotSource interface {
+	// LatestSnapshot opens the most recent snapshot for reading, returning
+	// its meta.
+	LatestSnapshot() (*raft.SnapshotMeta, io.ReadCloser, error)
+
+	// LatestSnapshotDB opens the SQLite database held by the most recent
+	// snapshot for reading, returning the snapshot's meta.
+	LatestSnapshotDB() (*raft.SnapshotMeta, io.ReadCloser, error)
+
+	// Snapshots returns the meta of the snapshots held, most recent first.
+	Snapshots() ([]*raft.SnapshotMeta, error)
+}
+
+// Archiver is the interface a store must implement to move rows into an
+// archive database.
+type Archiver interface {
+	// ArchiveRows moves the rows of the table matching the condition where
+	// into the archive database, returning the number of rows moved.
+	ArchiveRows(table, where string) (int64, error)
+
+	// BackupArchive writes a consistent copy of the archive database to dst.
+	BackupArchive(dst io.Writer) error
+}
+
+// Partitioner is the interface a store must implement to manage sets of
+// time-partitioned tables.
+type Partitioner interface {
+	// CreatePartitionSet defines a new partition set, and creates its
+	// initial partitions.
+	CreatePartitionSet(ps store.PartitionSet) error
+
+	// DropPartitionSet drops a partition set, and all its partitions.
+	DropPartitionSet(name string) error
+
+	// PartitionSets returns every partition set, with its partitions.
+	PartitionSets() ([]store.PartitionSet, error)
+}
+
+// ClusterEventLog is the interface a store must implement to serve the log
+// of significant cluster events.
+type ClusterEventLog interface {
+	// ClusterEvents returns at most limit events, oldest first, whose IDs
+	// are greater than since.
+	ClusterEvents(since int64, limit int) ([]store.ClusterEvent, error)
+}
+
+// WriteBatcher is the interface a store must implement to allow the windows
+// in which Raft log entries are batched to be tuned at runtime.
+type WriteBatcher interface {
+	// SetBatchWindow sets the batching window of a consistency domain.
+	SetBatchWindow(domain string, window time.Duration) error
+
+	// BatchStats returns the batching window of each consistency domain,
+	// and the effect of batching on it.
+	BatchStats() map[string]interface{}
+}
+
+// approvalRequirer is the interface errors implement if the operation which
+// caused them may proceed once approved by a second user.
+type approvalRequirer interface {
+	ApprovalRequired() bool
+}
+
 // StatusReporter is the interface status providers must implement.
 type StatusReporter interface {
 	Stats() (map[string]interface{}, error)
@@ -143,7 +382,9 @@ type DBResults struct {
 	QueryRows            []*command.QueryRows
 	ExecuteQueryResponse []*command.ExecuteQueryResponse
 
-	AssociativeJSON bool // Render in associative form
+	AssociativeJSON bool                 // Render in associative form
+	Projection      *encoding.Projection // Columns of query results to render. May be nil.
+	Nulls           encoding.NullMode    // Rendering of NULL values in query results.
 }
 
 // Responser is the interface response objects must implement.
@@ -155,6 +396,8 @@ type Responser interface {
 func (d *DBResults) MarshalJSON() ([]byte, error) {
 	enc := encoding.Encoder{
 		Associative: d.AssociativeJSON,
+		Projection:  d.Projection,
+		Nulls:       d.Nulls,
 	}
 
 	if d.ExecuteResult != nil {
@@ -173,6 +416,8 @@ type Response struct {
 	Error       string     `json:"error,omitempty"`
 	Time        float64    `json:"time,omitempty"`
 	SequenceNum int64      `json:"sequence_number,omitempty"`
+	Cursor      string     `json:"cursor,omitempty"`
+	Transaction string     `json:"transaction,omitempty"`
 
 	start time.Time
 	end   time.Time
@@ -192,6 +437,23 @@ func NewResponse() *Response {
 	}
 }
 
+// pendingApproval is an operation awaiting approval by a second user.
+type pendingApproval struct {
+	ID        string    `json:"id"`
+	Requester string    `json:"requester"`
+	Method    string    `json:"method"`
+	URL       string    `json:"url"`
+	Reason    string    `json:"reason"`
+	Created   time.Time `json:"created"`
+	Expires   time.Time `json:"expires"`
+
+	body   []byte
+	header http.Header
+}
+
+// approvedCtxKey is the context key marking a request as approved.
+type approvedCtxKey struct{}
+
 // stats captures stats for the HTTP service.
 var stats *expvar.Map
 
@@ -211,6 +473,7 @@ const (
 	numQueuedExecutionsWait           = "queued_executions_wait"
 	numQueries                        = "queries"
 	numQueryStmtsRx                   = "query_stmts_rx"
+	numQueryStreams                   = "query_streams"
 	numRequests                       = "requests"
 	numRequestStmtsRx                 = "request_stmts_rx"
 	numRemoteExecutions               = "remote_executions"
@@ -227,20 +490,141 @@ const (
 	numBackups                        = "backups"
 	numLoad                           = "loads"
 	numJoins                          = "joins"
+	numJoinSnapshots                  = "join_snapshots"
+	numSnapshotExports                = "snapshot_exports"
 	numNotifies                       = "notifies"
 	numAuthOK                         = "authOK"
 	numAuthFail                       = "authFail"
+	numPolicyDenied                   = "policy_denied"
+	numNonDeterministicRejected       = "nondeterministic_rejected"
+	numNonDeterministicWarned         = "nondeterministic_warned"
+	numApprovalsRequested             = "approvals_requested"
+	numApprovalsGranted               = "approvals_granted"
+	numApprovalsRejected              = "approvals_rejected"
+	numApprovalsExpired               = "approvals_expired"
+	numTrashRestores                  = "trash_restores"
+	numTrashPurges                    = "trash_purges"
+	numTrashPurgesFailed              = "trash_purges_failed"
+	numOverloadShed                   = "overload_shed"
+	numReadyzSaturated                = "readyz_saturated"
+	numPoolRejected                   = "pool_rejected"
+	numEndpointLimited                = "endpoint_limited"
+	numChangesServed                  = "changes_served"
+	numFences                         = "fences"
+	numPromotions                     = "promotions"
+	numRollingRestarts                = "rolling_restarts"
+	numReplacements                   = "replacements"
+	numResyncs                        = "resyncs"
+	numSandboxQueries                 = "sandbox_queries"
+	numCursorRequests                 = "cursor_requests"
+	numTransactionRequests            = "transaction_requests"
+	numSubscribeRequests              = "subscribe_requests"
+	numSubscribeEvents                = "subscribe_events"
+	numBulkWrites                     = "bulk_writes"
+	numBulkRows                       = "bulk_rows"
+	numBulkThrottled                  = "bulk_throttled"
+	numUpserts                        = "upserts"
+	numUpsertRows                     = "upsert_rows"
+	numSchemaPolls                    = "schema_polls"
+	numTableStatsRequests             = "table_stats_requests"
+	numValidations                    = "validations"
+	numArchives                       = "archives"
+	numPartitionSetChanges            = "partition_set_changes"
+	numConnectionsTerminated          = "connections_terminated"
+	numBundles                        = "support_bundles"
+
+	// Default time after which operations awaiting approval are discarded.
+	defaultApprovalTimeout = time.Hour
+
+	// Interval between checks for expired tables in the trash.
+	trashPurgeInterval = time.Minute
 
 	// Default timeout for cluster communications.
 	defaultTimeout = 30 * time.Second
 
+	// Default maximum number of changes returned by the change feed.
+	defaultMaxChanges = 1000
+
+	// Default maximum number of cluster events returned.
+	defaultMaxClusterEvents = 100
+
+	// Default and maximum times a request for a schema change waits.
+	defaultSchemaWait = 30 * time.Second
+	maxSchemaWait     = 5 * time.Minute
+
+	// Maximum length of an idempotency key.
+	maxIdempotencyKeyLen = 256
+
 	// VersionHTTPHeader is the HTTP header key for the version.
 	VersionHTTPHeader = "X-RQLITE-VERSION"
 
+	// StatusVersion is the version of the schema of /status responses.
+	// Within a version, keys are only ever added, never renamed, removed,
+	// or changed in type.
+	StatusVersion = 2
+
+	// StatusVersionLegacy is the version of /status responses before they
+	// were versioned, which may still be requested for compatibility.
+	StatusVersionLegacy = 1
+
+	// SchemaVersionHTTPHeader is the HTTP header key for the schema version
+	// of the database.
+	SchemaVersionHTTPHeader = "X-RQLITE-SCHEMA-VERSION"
+
 	// ServedByHTTPHeader is the HTTP header used to report which
 	// node (by node Raft address) actually served the request if
 	// it wasn't served by this node.
 	ServedByHTTPHeader = "X-RQLITE-SERVED-BY"
+
+	// SnapshotIDHTTPHeader and SnapshotIndexHTTPHeader are the HTTP headers
+	// reporting the ID and Raft index of an exported snapshot.
+	SnapshotIDHTTPHeader    = "X-RQLITE-SNAPSHOT-ID"
+	SnapshotIndexHTTPHeader = "X-RQLITE-SNAPSHOT-INDEX"
+
+	// PriorityHTTPHeader is the HTTP header clients use to set the
+	// priority of a request.
+	PriorityHTTPHeader = "X-RQLITE-PRIORITY"
+
+	// NonDeterministicHTTPHeader is the HTTP header listing the
+	// non-deterministic functions and keywords used by a write, when
+	// it is allowed with a warning.
+	NonDeterministicHTTPHeader = "X-RQLITE-NONDETERMINISTIC"
+
+	// ChecksumHTTPHeader is the HTTP header holding the checksum of the body
+	// of a response, when requested. It is a trailer if the response is
+	// streamed.
+	ChecksumHTTPHeader = "X-RQLITE-CHECKSUM"
+
+	// IdempotencyKeyHTTPHeader is the HTTP header clients use to attach an
+	// idempotency key to a write, so that retrying it never applies it twice.
+	IdempotencyKeyHTTPHeader = "X-RQLITE-IDEMPOTENCY-KEY"
+
+	// NonDeterministicAllow, NonDeterministicWarn, NonDeterministicRewrite
+	// and NonDeterministicReject are the policies for writes which may apply
+	// differently on each node. Allow executes them without checking, Warn
+	// executes them with a warning header, Rewrite replaces the current date
+	// and time in them first, warning of anything left, and Reject refuses
+	// them. RANDOM is rewritten, unless disabled, before any is applied.
+	NonDeterministicAllow   = "allow"
+	NonDeterministicWarn    = "warn"
+	NonDeterministicRewrite = "rewrite"
+	NonDeterministicReject  = "reject"
+
+	// EndpointBackup, EndpointLoad, and EndpointQuery name the endpoints
+	// whose concurrency may be limited.
+	EndpointBackup = "backup"
+	EndpointLoad   = "load"
+	EndpointQuery  = "query"
+
+	// QueryFormatJSON, QueryFormatNDJSON, QueryFormatCSV, and
+	// QueryFormatParquet name the formats in which query results may be
+	// returned. NDJSON results are newline-delimited JSON, with one line per
+	// row. Parquet results are an Apache Parquet file, and must be of a
+	// single query.
+	QueryFormatJSON    = "json"
+	QueryFormatNDJSON  = "ndjson"
+	QueryFormatCSV     = "csv"
+	QueryFormatParquet = "parquet"
 )
 
 func init() {
@@ -251,6 +635,8 @@ func init() {
 // ResetStats resets the expvar stats for this module. Mostly for test purposes.
 func ResetStats() {
 	stats.Init()
+	tags.Reset()
+	stats.Set("tags", expvar.Func(tags.Stats))
 	stats.Add(numLeaderNotFound, 0)
 	stats.Add(numExecutions, 0)
 	stats.Add(numExecuteStmtsRx, 0)
@@ -266,6 +652,7 @@ func ResetStats() {
 	stats.Add(numQueuedExecutionsWait, 0)
 	stats.Add(numQueries, 0)
 	stats.Add(numQueryStmtsRx, 0)
+	stats.Add(numQueryStreams, 0)
 	stats.Add(numRequests, 0)
 	stats.Add(numRequestStmtsRx, 0)
 	stats.Add(numRemoteExecutions, 0)
@@ -282,14 +669,54 @@ func ResetStats() {
 	stats.Add(numBackups, 0)
 	stats.Add(numLoad, 0)
 	stats.Add(numJoins, 0)
+	stats.Add(numJoinSnapshots, 0)
+	stats.Add(numSnapshotExports, 0)
 	stats.Add(numNotifies, 0)
 	stats.Add(numAuthOK, 0)
 	stats.Add(numAuthFail, 0)
+	stats.Add(numPolicyDenied, 0)
+	stats.Add(numNonDeterministicRejected, 0)
+	stats.Add(numNonDeterministicWarned, 0)
+	stats.Add(numApprovalsRequested, 0)
+	stats.Add(numApprovalsGranted, 0)
+	stats.Add(numApprovalsRejected, 0)
+	stats.Add(numApprovalsExpired, 0)
+	stats.Add(numTrashRestores, 0)
+	stats.Add(numTrashPurges, 0)
+	stats.Add(numTrashPurgesFailed, 0)
+	stats.Add(numOverloadShed, 0)
+	stats.Add(numReadyzSaturated, 0)
+	stats.Add(numPoolRejected, 0)
+	stats.Add(numEndpointLimited, 0)
+	stats.Add(numChangesServed, 0)
+	stats.Add(numFences, 0)
+	stats.Add(numPromotions, 0)
+	stats.Add(numRollingRestarts, 0)
+	stats.Add(numResyncs, 0)
+	stats.Add(numSandboxQueries, 0)
+	stats.Add(numCursorRequests, 0)
+	stats.Add(numTransactionRequests, 0)
+	stats.Add(numSubscribeRequests, 0)
+	stats.Add(numSubscribeEvents, 0)
+	stats.Add(numReplacements, 0)
+	stats.Add(numBulkWrites, 0)
+	stats.Add(numBulkRows, 0)
+	stats.Add(numBulkThrottled, 0)
+	stats.Add(numUpserts, 0)
+	stats.Add(numUpsertRows, 0)
+	stats.Add(numSchemaPolls, 0)
+	stats.Add(numTableStatsRequests, 0)
+	stats.Add(numValidations, 0)
+	stats.Add(numArchives, 0)
+	stats.Add(numPartitionSetChanges, 0)
+	stats.Add(numConnectionsTerminated, 0)
+	stats.Add(numBundles, 0)
 }
 
 // Service provides HTTP service.
 type Service struct {
 	httpServer http.Server
+	conns      *connTracker // Client connections, and the requests on each.
 	closeCh    chan struct{}
 	addr       string       // Bind address of the HTTP service.
 	ln         net.Listener // Service listener
@@ -317,12 +744,75 @@ type Service struct {
 	DefaultQueueBatchSz int
 	DefaultQueueTimeout time.Duration
 	DefaultQueueTx      bool
+	DefaultQueueWAL     string // Path of on-disk log backing the queue. If not set, the queue is only in memory.
 
 	seqNumMu sync.Mutex
 	seqNum   int64 // Last sequence number written OK.
 
 	credentialStore CredentialStore
 
+	Policy StatementPolicy // Policy checked before any statement is executed. May be nil.
+
+	NonDeterministic string // Policy for non-deterministic writes. If not set, they are allowed.
+
+	RemoveNeedsApproval bool          // Whether node removal, including replacement, must be approved by a second user.
+	ApprovalTimeout     time.Duration // Time after which operations awaiting approval are discarded.
+
+	// Operations awaiting approval are held in memory on the Leader only, so
+	// are lost if the Leader restarts or leadership changes.
+	approvalsMu sync.Mutex
+	approvals   map[string]*pendingApproval
+
+	// TrashRetention is how long dropped tables are retained in the trash.
+	// If zero, the trash is disabled and DROP TABLE drops tables immediately.
+	TrashRetention time.Duration
+	trashDone      chan struct{}
+
+	Overload OverloadController // Sheds low-priority reads when the node is overloaded. May be nil.
+	Pools    ConcurrencyPools   // Limits the concurrency of requests of each priority. May be nil.
+
+	// EndpointPools limits the concurrency of expensive endpoints, so that
+	// they cannot consume all the capacity needed by other requests. Pools
+	// are named by endpoint. May be nil.
+	EndpointPools ConcurrencyPools
+
+	// Queues monitors the depths of the node's queues against their SLOs.
+	// While any queue is saturated the node is not ready. May be nil.
+	Queues QueueMonitor
+
+	// UserPriorities maps usernames to the priority of their requests. Users
+	// not present make requests at normal priority.
+	UserPriorities map[string]string
+
+	// BulkChunkSize is the target size, in bytes, of the rows applied by each
+	// Raft entry during a bulk write. If zero, DefaultBulkChunkSize is used.
+	BulkChunkSize int
+
+	ChangeFeed   ChangeFeed       // Serves the change feed to warm standby clusters. May be nil.
+	Standby      StandbyConsumer  // Set if this node is part of a warm standby cluster. May be nil.
+	Restarter    RollingRestarter // Orchestrates rolling restarts of the cluster. May be nil.
+	Replacer     NodeReplacer     // Orchestrates replacement of nodes. May be nil.
+	Resyncer     NodeResyncer     // Resyncs this node's database from the leader's. May be nil.
+	Sandbox      SandboxQuerier   // Executes queries which can only read the database. May be nil.
+	Cursors      CursorQuerier    // Serves query results a page at a time through cursors. May be nil.
+	Transactions Transactor       // Holds interactive transactions which span many requests. May be nil.
+	Subscriber   RowSubscriber    // Pushes changes to rows to clients as they are applied. May be nil.
+	Batching     WriteBatcher     // Tunes the windows in which Raft log entries are batched. May be nil.
+	Snapshots    SnapshotSource   // Serves the latest Raft snapshot to joining nodes and operators. May be nil.
+	Schema       SchemaNotifier   // Reports changes to the database schema. May be nil.
+	TableStats   TableAccess      // Reports the reads of each table by queries. May be nil.
+	Validator    Validator        // Validates statements without executing them. May be nil.
+	Archive      Archiver         // Moves rows into the archive database. May be nil.
+	Partitions   Partitioner      // Manages time-partitioned tables. May be nil.
+	Mirror       QueryMirror      // Mirrors queries to a second cluster. May be nil.
+	Jobs         StatusReporter   // Reports the ownership and status of leader-only background jobs. May be nil.
+	Events       ClusterEventLog  // Serves the log of significant cluster events. May be nil.
+	Logs         RecentLogs       // Lines recently logged by this node, included in support bundles. May be nil.
+
+	// StatusVersion is the version of the /status schema served to requests
+	// which do not ask for one. If zero, the current StatusVersion is served.
+	StatusVersion int
+
 	BuildInfo map[string]interface{}
 
 	logger *log.Logger
@@ -338,9 +828,12 @@ func New(addr string, store Store, cluster Cluster, credentials CredentialStore)
 		DefaultQueueBatchSz: 128,
 		DefaultQueueTimeout: 100 * time.Millisecond,
 		cluster:             cluster,
+		conns:               newConnTracker(),
 		start:               time.Now(),
 		statuses:            make(map[string]StatusReporter),
 		credentialStore:     credentials,
+		ApprovalTimeout:     defaultApprovalTimeout,
+		approvals:           make(map[string]*pendingApproval),
 		logger:              log.New(os.Stderr, "[http] ", log.LstdFlags),
 	}
 }
@@ -348,7 +841,9 @@ func New(addr string, store Store, cluster Cluster, credentials CredentialStore)
 // Start starts the service.
 func (s *Service) Start() error {
 	s.httpServer = http.Server{
-		Handler: s,
+		Handler:     s,
+		ConnState:   s.conns.connState,
+		ConnContext: s.conns.connContext,
 	}
 
 	var ln net.Listener
@@ -385,10 +880,31 @@ func (s *Service) Start() error {
 	s.closeCh = make(chan struct{})
 	s.queueDone = make(chan struct{})
 
-	s.stmtQueue = queue.New(s.DefaultQueueCap, s.DefaultQueueBatchSz, s.DefaultQueueTimeout)
+	if s.DefaultQueueWAL != "" {
+		s.stmtQueue, err = queue.NewDurable(s.DefaultQueueCap, s.DefaultQueueBatchSz, s.DefaultQueueTimeout, s.DefaultQueueWAL)
+		if err != nil {
+			ln.Close()
+			return fmt.Errorf("failed to open execute queue log: %s", err)
+		}
+		s.seqNum = s.stmtQueue.Acked()
+	} else {
+		s.stmtQueue = queue.New(s.DefaultQueueCap, s.DefaultQueueBatchSz, s.DefaultQueueTimeout)
+	}
 	go s.runQueue()
+	if s.Queues != nil {
+		s.Queues.Register(overload.QueueWrites, s.stmtQueue.Depth)
+	}
 	s.logger.Printf("execute queue processing started with capacity %d, batch size %d, timeout %s",
 		s.DefaultQueueCap, s.DefaultQueueBatchSz, s.DefaultQueueTimeout.String())
+	if s.DefaultQueueWAL != "" {
+		s.logger.Printf("execute queue durable, backed by log at %s", s.DefaultQueueWAL)
+	}
+
+	if s.TrashRetention > 0 {
+		s.trashDone = make(chan struct{})
+		go s.runTrashPurge()
+		s.logger.Printf("trash enabled, dropped tables retained for %s", s.TrashRetention)
+	}
 
 	go func() {
 		err := s.httpServer.Serve(s.ln)
@@ -413,6 +929,9 @@ func (s *Service) Close() {
 		close(s.closeCh)
 	}
 	<-s.queueDone
+	if s.trashDone != nil {
+		<-s.trashDone
+	}
 
 	s.ln.Close()
 }
@@ -424,34 +943,86 @@ func (s *Service) HTTPS() bool {
 
 // ServeHTTP allows Service to serve HTTP requests.
 func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer s.conns.begin(r)()
 	s.addBuildVersion(w)
+	s.addSchemaVersion(w)
 
 	switch {
 	case r.URL.Path == "/" || r.URL.Path == "":
 		http.Redirect(w, r, "/status", http.StatusFound)
 	case strings.HasPrefix(r.URL.Path, "/db/execute"):
 		stats.Add(numExecutions, 1)
-		s.handleExecute(w, r)
+		s.serveTagged(w, r, s.handleExecute)
 	case strings.HasPrefix(r.URL.Path, "/db/query"):
 		stats.Add(numQueries, 1)
-		s.handleQuery(w, r)
+		s.serveTagged(w, r, s.handleQuery)
 	case strings.HasPrefix(r.URL.Path, "/db/request"):
 		stats.Add(numRequests, 1)
-		s.handleRequest(w, r)
+		s.serveTagged(w, r, s.handleRequest)
 	case strings.HasPrefix(r.URL.Path, "/db/backup"):
 		stats.Add(numBackups, 1)
 		s.handleBackup(w, r)
 	case strings.HasPrefix(r.URL.Path, "/db/load"):
 		stats.Add(numLoad, 1)
 		s.handleLoad(w, r)
+	case strings.HasPrefix(r.URL.Path, "/db/bulk"):
+		s.serveTagged(w, r, s.handleBulk)
+	case strings.HasPrefix(r.URL.Path, "/db/upsert"):
+		s.serveTagged(w, r, s.handleUpsert)
+	case strings.HasPrefix(r.URL.Path, "/db/trash"):
+		s.handleTrash(w, r)
+	case strings.HasPrefix(r.URL.Path, "/db/archive"):
+		s.handleArchive(w, r)
+	case strings.HasPrefix(r.URL.Path, "/db/partitions"):
+		s.handlePartitions(w, r)
+	case strings.HasPrefix(r.URL.Path, "/db/stats"):
+		stats.Add(numTableStatsRequests, 1)
+		s.handleTableStats(w, r)
+	case strings.HasPrefix(r.URL.Path, "/db/validate"):
+		stats.Add(numValidations, 1)
+		s.handleValidate(w, r)
+	case strings.HasPrefix(r.URL.Path, "/db/schema"):
+		s.handleSchema(w, r)
+	case strings.HasPrefix(r.URL.Path, "/db/changes"):
+		s.handleChanges(w, r)
+	case strings.HasPrefix(r.URL.Path, "/db/fence"):
+		s.handleFence(w, r)
+	case strings.HasPrefix(r.URL.Path, "/db/resync"):
+		s.handleResync(w, r)
+	case strings.HasPrefix(r.URL.Path, "/db/sandbox"):
+		stats.Add(numSandboxQueries, 1)
+		s.handleSandbox(w, r)
+	case strings.HasPrefix(r.URL.Path, "/db/cursor"):
+		stats.Add(numCursorRequests, 1)
+		s.handleCursor(w, r)
+	case strings.HasPrefix(r.URL.Path, "/db/transaction"):
+		stats.Add(numTransactionRequests, 1)
+		s.handleTransaction(w, r)
+	case strings.HasPrefix(r.URL.Path, "/db/subscribe"):
+		stats.Add(numSubscribeRequests, 1)
+		s.handleSubscribe(w, r)
+	case strings.HasPrefix(r.URL.Path, "/snapshots/latest"):
+		stats.Add(numSnapshotExports, 1)
+		s.handleSnapshotExport(w, r)
+	case strings.HasPrefix(r.URL.Path, "/standby/promote"):
+		s.handlePromote(w, r)
+	case strings.HasPrefix(r.URL.Path, "/join/snapshot"):
+		stats.Add(numJoinSnapshots, 1)
+		s.handleJoinSnapshot(w, r)
 	case strings.HasPrefix(r.URL.Path, "/join"):
 		stats.Add(numJoins, 1)
 		s.handleJoin(w, r)
 	case strings.HasPrefix(r.URL.Path, "/notify"):
 		stats.Add(numNotifies, 1)
 		s.handleNotify(w, r)
+	case strings.HasPrefix(r.URL.Path, "/cluster/events"):
+		s.handleClusterEvents(w, r)
 	case strings.HasPrefix(r.URL.Path, "/remove"):
 		s.handleRemove(w, r)
+	case strings.HasPrefix(r.URL.Path, "/restart"):
+		s.handleRestart(w, r)
+	case strings.HasPrefix(r.URL.Path, "/replace"):
+		s.handleReplace(w, r)
 	case strings.HasPrefix(r.URL.Path, "/status"):
 		stats.Add(numStatus, 1)
 		s.handleStatus(w, r)
@@ -460,6 +1031,17 @@ func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case strings.HasPrefix(r.URL.Path, "/readyz"):
 		stats.Add(numReadyz, 1)
 		s.handleReadyz(w, r)
+	case strings.HasPrefix(r.URL.Path, "/approvals"):
+		s.handleApprovals(w, r)
+	case strings.HasPrefix(r.URL.Path, "/jobs"):
+		s.handleJobs(w, r)
+	case strings.HasPrefix(r.URL.Path, "/batching"):
+		s.handleBatching(w, r)
+	case strings.HasPrefix(r.URL.Path, "/debug/bundle"):
+		stats.Add(numBundles, 1)
+		s.handleBundle(w, r)
+	case strings.HasPrefix(r.URL.Path, "/debug/connections"):
+		s.handleConnections(w, r)
 	case r.URL.Path == "/debug/vars":
 		s.handleExpvar(w, r)
 	case strings.HasPrefix(r.URL.Path, "/debug/pprof"):
@@ -527,6 +1109,7 @@ func (s *Service) handleJoin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	remoteID, remoteAddr := rID.(string), rAddr.(string)
+	zone, _ := md["zone"].(string)
 
 	s.logger.Printf("received join request from node with ID %s at %s",
 		remoteID, remoteAddr)
@@ -546,6 +1129,7 @@ func (s *Service) handleJoin(w http.ResponseWriter, r *http.Request) {
 		Id:      remoteID,
 		Address: remoteAddr,
 		Voter:   voter.(bool),
+		Zone:    zone,
 	}
 	if err := s.store.Join(jr); err != nil {
 		if err == store.ErrNotLeader {
@@ -560,10 +1144,141 @@ func (s *Service) handleJoin(w http.ResponseWriter, r *http.Request) {
 			http.Redirect(w, r, redirect, http.StatusMovedPermanently)
 			return
 		}
+		if errors.Is(err, store.ErrPlacementViolation) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleJoinSnapshot streams the Leader's latest Raft snapshot to a node about
+// to join the cluster, so that it can install the snapshot before it joins,
+// and need only catch up on the log entries which follow it. The Raft meta of
+// the snapshot is sent, JSON-encoded, in the cluster.SnapshotMetaHeader header.
+func (s *Service) handleJoinSnapshot(w http.ResponseWriter, r *http.Request) {
+	if !s.CheckRequestPerm(r, auth.PermJoin) && !s.CheckRequestPerm(r, auth.PermJoinReadOnly) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.Snapshots == nil {
+		http.Error(w, "join snapshots not supported", http.StatusNotFound)
+		return
+	}
+
+	if !s.store.IsLeader() {
+		leaderAPIAddr := s.LeaderAPIAddr()
+		if leaderAPIAddr == "" {
+			stats.Add(numLeaderNotFound, 1)
+			http.Error(w, ErrLeaderNotFound.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		redirect := s.FormRedirect(r, leaderAPIAddr)
+		http.Redirect(w, r, redirect, http.StatusMovedPermanently)
+		return
+	}
+
+	release, ok := s.limitEndpoint(w, r, EndpointBackup)
+	if !ok {
+		return
+	}
+	defer release()
+
+	meta, rc, err := s.Snapshots.LatestSnapshot()
+	if err != nil {
+		if errors.Is(err, store.ErrNoSnapshot) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
+	b, err := json.Marshal(meta)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
+	w.Header().Set(cluster.SnapshotMetaHeader, string(b))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(meta.Size, 10))
+	if _, err := io.Copy(w, rc); err != nil {
+		s.logger.Printf("failed to stream snapshot %s to joining node: %s", meta.ID, err)
+		return
+	}
+	s.logger.Printf("streamed snapshot %s (%d bytes) to joining node at %s", meta.ID, meta.Size, r.RemoteAddr)
+}
+
+// handleSnapshotExport streams the SQLite database held by this node's latest
+// Raft snapshot, gzip-compressed if requested, so that operators can seed new
+// clusters or analyze the data offline without touching the data directory.
+// Unlike a backup, the database is that of the snapshot, and so may trail the
+// latest writes, but serving it places no load on the live database.
+func (s *Service) handleSnapshotExport(w http.ResponseWriter, r *http.Request) {
+	if !s.CheckRequestPerm(r, auth.PermBackup) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.Snapshots == nil {
+		http.Error(w, "snapshot export not supported", http.StatusNotFound)
+		return
+	}
+
+	compress, err := queryParam(r, "compress")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	release, ok := s.limitEndpoint(w, r, EndpointBackup)
+	if !ok {
+		return
+	}
+	defer release()
+
+	meta, rc, err := s.Snapshots.LatestSnapshotDB()
+	if err != nil {
+		if errors.Is(err, store.ErrNoSnapshot) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	defer rc.Close()
+
+	w.Header().Set(SnapshotIDHTTPHeader, meta.ID)
+	w.Header().Set(SnapshotIndexHTTPHeader, strconv.FormatUint(meta.Index, 10))
+	var dst io.Writer = w
+	if compress {
+		w.Header().Set("Content-Type", "application/gzip")
+		gw := gzip.NewWriter(w)
+		defer gw.Close()
+		dst = gw
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.FormatInt(meta.Size, 10))
+	}
+	if _, err := io.Copy(dst, rc); err != nil {
+		s.logger.Printf("failed to export snapshot %s: %s", meta.ID, err)
+		return
+	}
+	s.logger.Printf("exported snapshot %s (%d bytes) to %s", meta.ID, meta.Size, r.RemoteAddr)
 }
 
 // handleNotify handles node-notify requests from other nodes.
@@ -600,6 +1315,7 @@ func (s *Service) handleNotify(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	remoteID, remoteAddr := rID.(string), rAddr.(string)
+	zone, _ := md["zone"].(string)
 
 	s.logger.Printf("received notify request from node with ID %s at %s",
 		remoteID, remoteAddr)
@@ -618,6 +1334,7 @@ func (s *Service) handleNotify(w http.ResponseWriter, r *http.Request) {
 	if err := s.store.Notify(&command.NotifyRequest{
 		Id:      remoteID,
 		Address: remoteAddr,
+		Zone:    zone,
 	}); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
@@ -669,6 +1386,11 @@ func (s *Service) handleRemove(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if s.RemoveNeedsApproval && !isApproved(r) {
+		s.requestApproval(w, r, b, fmt.Sprintf("removal of node %s requires approval", remoteID))
+		return
+	}
+
 	rn := &command.RemoveNodeRequest{
 		Id: remoteID,
 	}
@@ -736,6 +1458,12 @@ func (s *Service) handleBackup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	release, ok := s.limitEndpoint(w, r, EndpointBackup)
+	if !ok {
+		return
+	}
+	defer release()
+
 	noLeader, err := noLeader(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -831,6 +1559,12 @@ func (s *Service) handleLoad(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	release, ok := s.limitEndpoint(w, r, EndpointLoad)
+	if !ok {
+		return
+	}
+	defer release()
+
 	resp := NewResponse()
 
 	timings, err := isTimings(r)
@@ -886,6 +1620,9 @@ func (s *Service) handleLoad(w http.ResponseWriter, r *http.Request) {
 
 		queries := []string{string(b)}
 		er := executeRequestFromStrings(queries, timings, false)
+		if !s.checkPolicy(w, r, er.Request.Statements, b) {
+			return
+		}
 
 		results, err := s.store.Execute(er)
 		if err != nil {
@@ -990,6 +1727,12 @@ func (s *Service) handleStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	version, err := s.statusVersionParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	storeStatus, err := s.store.Stats()
 	if err != nil {
 		http.Error(w, fmt.Sprintf("store stats: %s", err.Error()),
@@ -1042,6 +1785,8 @@ func (s *Service) handleStatus(w http.ResponseWriter, r *http.Request) {
 	httpStatus := map[string]interface{}{
 		"bind_addr": s.Addr().String(),
 		"auth":      prettyEnabled(s.credentialStore != nil),
+		"policy":    prettyEnabled(s.Policy != nil),
+		"approvals": s.numPendingApprovals(),
 		"cluster":   clusterStatus,
 		"queue":     queueStats,
 		"tls":       s.tlsStats(),
@@ -1068,7 +1813,9 @@ func (s *Service) handleStatus(w http.ResponseWriter, r *http.Request) {
 		status["build"] = s.BuildInfo
 	}
 
-	// Add any registered StatusReporters.
+	// Add any registered StatusReporters. Since version 2 they are held
+	// under their own key, so that they cannot replace any other key.
+	reporters := make(map[string]interface{})
 	func() {
 		s.statusMu.RLock()
 		defer s.statusMu.RUnlock()
@@ -1079,9 +1826,18 @@ func (s *Service) handleStatus(w http.ResponseWriter, r *http.Request) {
 					http.StatusInternalServerError)
 				return
 			}
-			status[k] = stat
+			reporters[k] = stat
 		}
 	}()
+	if version == StatusVersionLegacy {
+		for k, v := range reporters {
+			status[k] = v
+		}
+	} else {
+		status["status_version"] = version
+		status["reporters"] = reporters
+		status["metrics"] = expvarMetrics()
+	}
 
 	pretty, _ := isPretty(r)
 	var b []byte
@@ -1112,6 +1868,39 @@ func (s *Service) handleStatus(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// statusVersionParam returns the version of the /status schema requested by
+// the version query parameter, or the version served by default.
+func (s *Service) statusVersionParam(r *http.Request) (int, error) {
+	v := strings.TrimSpace(r.URL.Query().Get("version"))
+	if v == "" {
+		if s.StatusVersion != 0 {
+			return s.StatusVersion, nil
+		}
+		return StatusVersion, nil
+	}
+	version, err := strconv.Atoi(v)
+	if err != nil || version < StatusVersionLegacy || version > StatusVersion {
+		return 0, fmt.Errorf("status version must be between %d and %d", StatusVersionLegacy, StatusVersion)
+	}
+	return version, nil
+}
+
+// expvarMetrics returns the counters of every subsystem which publishes them
+// through expvar, by the name under which they are published.
+func expvarMetrics() map[string]interface{} {
+	metrics := make(map[string]interface{})
+	expvar.Do(func(kv expvar.KeyValue) {
+		if _, ok := kv.Value.(*expvar.Map); !ok {
+			return
+		}
+		var v interface{}
+		if err := json.Unmarshal([]byte(kv.Value.String()), &v); err == nil {
+			metrics[kv.Key] = v
+		}
+	})
+	return metrics
+}
+
 // handleNodes returns status on the other voting nodes in the system.
 // This attempts to contact all the nodes in the cluster, so may take
 // some time to return.
@@ -1229,12 +2018,31 @@ func (s *Service) handleReadyz(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if noLeader {
-		// Simply handling the HTTP request is enough.
+		// Simply handling the HTTP request is enough, unless the node is
+		// saturated.
+		if reason := s.queuesSaturated(); reason != "" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("[+]node ok\n[+]" + reason))
+			return
+		}
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("[+]node ok"))
 		return
 	}
 
+	// A node is only ready for a leader-only check if it is the leader. This
+	// allows a Kubernetes Service to route requests to the leader alone.
+	isLeader, err := leaderParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if isLeader && !s.store.IsLeader() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("[+]node ok\n[+]not leader"))
+		return
+	}
+
 	timeout, err := timeoutParam(r, defaultTimeout)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -1267,10 +2075,29 @@ func (s *Service) handleReadyz(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if reason := s.queuesSaturated(); reason != "" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("[+]node ok\n[+]leader ok\n[+]store ok\n[+]" + reason))
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("[+]node ok\n[+]leader ok\n[+]store ok"))
 }
 
+// queuesSaturated returns why a queue of the node is saturated, or the empty
+// string if none is.
+func (s *Service) queuesSaturated() string {
+	if s.Queues == nil {
+		return ""
+	}
+	if reason := s.Queues.Saturated(); reason != "" {
+		stats.Add(numReadyzSaturated, 1)
+		return "queue saturated: " + reason
+	}
+	return ""
+}
+
 func (s *Service) handleExecute(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 
@@ -1284,6 +2111,11 @@ func (s *Service) handleExecute(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if id := transactionParam(r); id != "" {
+		s.transactionExecute(w, r, id)
+		return
+	}
+
 	queue, err := isQueue(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -1331,22 +2163,36 @@ func (s *Service) queuedExecute(w http.ResponseWriter, r *http.Request) {
 	}
 	r.Body.Close()
 
-	stmts, err := ParseRequest(b)
+	stmts, err := parseRequestBody(r, b)
 	if err != nil {
 		if errors.Is(err, ErrNoStatements) && !wait {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 	}
+	if !s.checkPolicy(w, r, stmts, b) {
+		return
+	}
+	if !s.checkTrash(w, stmts, true) {
+		return
+	}
 	noRewriteRandom, err := noRewriteRandom(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	key, err := idempotencyKey(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err := command.Rewrite(stmts, !noRewriteRandom); err != nil {
 		http.Error(w, fmt.Sprintf("SQL rewrite: %s", err.Error()), http.StatusInternalServerError)
 		return
 	}
+	if !s.checkNonDeterministic(w, stmts) {
+		return
+	}
 
 	timeout, err := timeoutParam(r, defaultTimeout)
 	if err != nil {
@@ -1360,7 +2206,7 @@ func (s *Service) queuedExecute(w http.ResponseWriter, r *http.Request) {
 		fc = make(queue.FlushChannel)
 	}
 
-	seqNum, err := s.stmtQueue.Write(stmts, fc)
+	seqNum, err := s.stmtQueue.WriteWithKey(stmts, key, fc)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -1391,6 +2237,22 @@ func (s *Service) execute(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	isTx, stopOnError, err := atomicity(r, isTx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	key, err := idempotencyKey(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	release, ok := s.admit(w, r, false)
+	if !ok {
+		return
+	}
+	defer release()
 
 	b, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -1399,26 +2261,41 @@ func (s *Service) execute(w http.ResponseWriter, r *http.Request) {
 	}
 	r.Body.Close()
 
-	stmts, err := ParseRequest(b)
+	stmts, err := parseRequestBody(r, b)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	stats.Add(numExecuteStmtsRx, int64(len(stmts)))
+	if !s.checkPolicy(w, r, stmts, b) {
+		return
+	}
+	if !s.checkTrash(w, stmts, true) {
+		return
+	}
 	if err := command.Rewrite(stmts, !noRewriteRandom); err != nil {
 		http.Error(w, fmt.Sprintf("SQL rewrite: %s", err.Error()), http.StatusInternalServerError)
 		return
 	}
+	if !s.checkNonDeterministic(w, stmts) {
+		return
+	}
 
 	er := &command.ExecuteRequest{
 		Request: &command.Request{
-			Transaction: isTx,
-			Statements:  stmts,
+			Transaction:    isTx,
+			Statements:     stmts,
+			StopOnError:    stopOnError,
+			IdempotencyKey: key,
 		},
 		Timings: timings,
 	}
 
+	start := time.Now()
 	results, resultsErr := s.store.Execute(er)
+	if resultsErr == nil && s.Overload != nil {
+		s.Overload.ObserveApply(time.Since(start))
+	}
 	if resultsErr != nil && resultsErr == store.ErrNotLeader {
 		if redirect {
 			leaderAPIAddr := s.LeaderAPIAddr()
@@ -1484,19 +2361,80 @@ func (s *Service) handleQuery(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	timeout, frsh, lvl, isTx, timings, redirect, noRewriteRandom, isAssoc, err := queryReqParams(r, defaultTimeout)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if id := transactionParam(r); id != "" {
+		s.transactionQuery(w, r, id)
 		return
 	}
 
-	// Get the query statement(s), and do tx if necessary.
-	queries, err := requestQueries(r)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	release, ok := s.admit(w, r, true)
+	if !ok {
+		return
+	}
+	defer release()
+	releaseEndpoint, ok := s.limitEndpoint(w, r, EndpointQuery)
+	if !ok {
+		return
+	}
+	defer releaseEndpoint()
+
+	timeout, frsh, lvl, isTx, timings, redirect, noRewriteRandom, isAssoc, err := queryReqParams(r, defaultTimeout)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	proj, nulls, err := resultsFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	format, err := queryFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var csvOpts *CSVOptions
+	if format == QueryFormatCSV {
+		if csvOpts, err = csvOptions(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	sum, err := isChecksum(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	metadata, err := isMetadata(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The checksum of a response which may be streamed is not known until
+	// the response is complete, so it follows the body as a trailer.
+	if sum && (format != QueryFormatJSON || canStreamJSON(r)) {
+		cw := newChecksumWriter(w)
+		defer cw.finish()
+		w = cw
+	}
+
+	// Get the query statement(s), and do tx if necessary.
+	queries, b, err := requestQueries(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	stats.Add(numQueryStmtsRx, int64(len(queries)))
+	if format == QueryFormatParquet && len(queries) != 1 {
+		http.Error(w, errParquetStatements.Error(), http.StatusBadRequest)
+		return
+	}
+	if !s.checkPolicy(w, r, queries, b) {
+		return
+	}
+	if !s.checkTrash(w, queries, false) {
+		return
+	}
 
 	// No point rewriting queries if they don't go through the Raft log, since they
 	// will never be replayed from the log anyway.
@@ -1509,6 +2447,8 @@ func (s *Service) handleQuery(w http.ResponseWriter, r *http.Request) {
 
 	resp := NewResponse()
 	resp.Results.AssociativeJSON = isAssoc
+	resp.Results.Projection = proj
+	resp.Results.Nulls = nulls
 
 	qr := &command.QueryRequest{
 		Request: &command.Request{
@@ -1518,9 +2458,42 @@ func (s *Service) handleQuery(w http.ResponseWriter, r *http.Request) {
 		Timings:   timings,
 		Level:     lvl,
 		Freshness: frsh.Nanoseconds(),
+		Metadata:  metadata,
+	}
+
+	newFormatWriter := func() formatWriter {
+		switch format {
+		case QueryFormatCSV:
+			return newCSVWriter(w, csvOpts, proj)
+		case QueryFormatParquet:
+			return newParquetWriter(w, proj)
+		default:
+			return newNDJSONWriter(w, isAssoc, proj, nulls)
+		}
+	}
+	var sw queryStreamer
+	if format != QueryFormatJSON {
+		sw = newFormatWriter()
+	} else if canStreamJSON(r) {
+		sw = newQueryStreamWriter(w, isAssoc)
 	}
 
-	results, resultsErr := s.store.Query(qr)
+	start := time.Now()
+	if sw != nil && s.streamQuery(r, qr, sw, resp.start) {
+		if s.Overload != nil {
+			s.Overload.ObserveQuery(time.Since(start))
+		}
+		if s.Mirror != nil {
+			s.Mirror.Mirror(qr, nil)
+		}
+		return
+	}
+	// Hold the memory reserved for the results until they are written.
+	results, release, resultsErr := s.store.QueryReserved(qr)
+	defer release()
+	if resultsErr == nil && s.Overload != nil {
+		s.Overload.ObserveQuery(time.Since(start))
+	}
 	if resultsErr != nil && resultsErr == store.ErrNotLeader {
 		if redirect {
 			leaderAPIAddr := s.LeaderAPIAddr()
@@ -1565,6 +2538,121 @@ func (s *Service) handleQuery(w http.ResponseWriter, r *http.Request) {
 		resp.Error = resultsErr.Error()
 	} else {
 		resp.Results.QueryRows = results
+		if s.Mirror != nil {
+			s.Mirror.Mirror(qr, results)
+		}
+	}
+	resp.end = time.Now()
+	if format != QueryFormatJSON {
+		// Any writer used for streaming may hold state, so use another.
+		s.writeFormatted(r, resp, resultsErr, newFormatWriter())
+		return
+	}
+	s.writeResponse(w, r, resp)
+}
+
+// writeFormatted writes the query results of resp using fw.
+func (s *Service) writeFormatted(r *http.Request, resp *Response, resultsErr error, fw formatWriter) {
+	var t float64
+	if timings, _ := isTimings(r); timings {
+		t = resp.end.Sub(resp.start).Seconds()
+	}
+	err := fw.writeResults(resp.Results.QueryRows)
+	if err == nil {
+		err = fw.finish(resultsErr, t)
+	}
+	if err != nil {
+		s.logger.Println("writing response failed:", err.Error())
+	}
+}
+
+// handleSandbox handles queries which are executed on a sandboxed
+// connection, so that any statement which would change the database fails.
+// Since the sandbox is local to each node, queries are never forwarded to
+// the leader, though a client may request to be redirected to it.
+func (s *Service) handleSandbox(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if !s.CheckRequestPerm(r, auth.PermSandbox) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if s.Sandbox == nil {
+		http.Error(w, "sandbox not supported", http.StatusNotFound)
+		return
+	}
+
+	if r.Method != "GET" && r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	release, ok := s.admit(w, r, true)
+	if !ok {
+		return
+	}
+	defer release()
+
+	_, frsh, lvl, isTx, timings, redirect, _, isAssoc, err := queryReqParams(r, defaultTimeout)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	proj, nulls, err := resultsFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	queries, b, err := requestQueries(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	stats.Add(numQueryStmtsRx, int64(len(queries)))
+	if !s.checkPolicy(w, r, queries, b) {
+		return
+	}
+
+	resp := NewResponse()
+	resp.Results.AssociativeJSON = isAssoc
+	resp.Results.Projection = proj
+	resp.Results.Nulls = nulls
+
+	qr := &command.QueryRequest{
+		Request: &command.Request{
+			Transaction: isTx,
+			Statements:  queries,
+		},
+		Timings:   timings,
+		Level:     lvl,
+		Freshness: frsh.Nanoseconds(),
+	}
+
+	// Hold the memory reserved for the results until they are written.
+	results, release, resultsErr := s.Sandbox.QuerySandboxed(qr)
+	defer release()
+	switch resultsErr {
+	case nil:
+		resp.Results.QueryRows = results
+	case store.ErrSandboxStrong:
+		http.Error(w, resultsErr.Error(), http.StatusBadRequest)
+		return
+	case store.ErrNotLeader:
+		leaderAPIAddr := s.LeaderAPIAddr()
+		if leaderAPIAddr == "" {
+			stats.Add(numLeaderNotFound, 1)
+			http.Error(w, ErrLeaderNotFound.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if !redirect {
+			http.Error(w, resultsErr.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		http.Redirect(w, r, s.FormRedirect(r, leaderAPIAddr), http.StatusMovedPermanently)
+		return
+	default:
+		resp.Error = resultsErr.Error()
 	}
 	resp.end = time.Now()
 	s.writeResponse(w, r, resp)
@@ -1588,56 +2676,1377 @@ func (s *Service) handleRequest(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	isTx, stopOnError, err := atomicity(r, isTx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	key, err := idempotencyKey(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	proj, nulls, err := resultsFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	metadata, err := isMetadata(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	release, ok := s.admit(w, r, false)
+	if !ok {
+		return
+	}
+	defer release()
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.Body.Close()
+
+	stmts, err := parseRequestBody(r, b)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	stats.Add(numRequestStmtsRx, int64(len(stmts)))
+	if !s.checkPolicy(w, r, stmts, b) {
+		return
+	}
+	if !s.checkTrash(w, stmts, true) {
+		return
+	}
+
+	if err := command.Rewrite(stmts, noRewriteRandom); err != nil {
+		http.Error(w, fmt.Sprintf("SQL rewrite: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if !s.checkNonDeterministic(w, stmts) {
+		return
+	}
+
+	resp := NewResponse()
+	resp.Results.AssociativeJSON = isAssoc
+	resp.Results.Projection = proj
+	resp.Results.Nulls = nulls
+
+	eqr := &command.ExecuteQueryRequest{
+		Request: &command.Request{
+			Transaction:    isTx,
+			Statements:     stmts,
+			StopOnError:    stopOnError,
+			IdempotencyKey: key,
+		},
+		Timings:   timings,
+		Level:     lvl,
+		Freshness: frsh.Nanoseconds(),
+		Metadata:  metadata,
+	}
+
+	// Hold the memory reserved for the results until they are written.
+	results, release, resultErr := s.store.RequestReserved(eqr)
+	defer release()
+	if resultErr != nil && resultErr == store.ErrNotLeader {
+		if redirect {
+			leaderAPIAddr := s.LeaderAPIAddr()
+			if leaderAPIAddr == "" {
+				stats.Add(numLeaderNotFound, 1)
+				http.Error(w, ErrLeaderNotFound.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			loc := s.FormRedirect(r, leaderAPIAddr)
+			http.Redirect(w, r, loc, http.StatusMovedPermanently)
+			return
+		}
+
+		addr, err := s.store.LeaderAddr()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if addr == "" {
+			stats.Add(numLeaderNotFound, 1)
+			http.Error(w, ErrLeaderNotFound.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		username, password, ok := r.BasicAuth()
+		if !ok {
+			username = ""
+		}
+
+		w.Header().Add(ServedByHTTPHeader, addr)
+		results, resultErr = s.cluster.Request(eqr, addr, makeCredentials(username, password), timeout)
+		if resultErr != nil {
+			stats.Add(numRemoteRequestsFailed, 1)
+			if resultErr.Error() == "unauthorized" {
+				http.Error(w, "remote request not authorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		stats.Add(numRemoteRequests, 1)
+	}
+
+	if resultErr != nil {
+		resp.Error = resultErr.Error()
+	} else {
+		resp.Results.ExecuteQueryResponse = results
+	}
+	resp.end = time.Now()
+	s.writeResponse(w, r, resp)
+}
+
+// handleExpvar serves registered expvar information over HTTP.
+func (s *Service) handleExpvar(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if !s.CheckRequestPerm(r, auth.PermStatus) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	key := keyParam(r)
+
+	fmt.Fprintf(w, "{\n")
+	first := true
+	expvar.Do(func(kv expvar.KeyValue) {
+		if key != "" && key != kv.Key {
+			return
+		}
+		if !first {
+			fmt.Fprintf(w, ",\n")
+		}
+		first = false
+		fmt.Fprintf(w, "%q: %s", kv.Key, kv.Value)
+	})
+	fmt.Fprintf(w, "\n}\n")
+}
+
+// handlePprof serves pprof information over HTTP.
+func (s *Service) handlePprof(w http.ResponseWriter, r *http.Request) {
+	if !s.CheckRequestPerm(r, auth.PermStatus) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/debug/pprof/cmdline":
+		pprof.Cmdline(w, r)
+	case "/debug/pprof/profile":
+		pprof.Profile(w, r)
+	case "/debug/pprof/symbol":
+		pprof.Symbol(w, r)
+	default:
+		pprof.Index(w, r)
+	}
+}
+
+// handleConnections lists the client connections to this node, and the
+// requests being served on each. A DELETE request for
+// /debug/connections/<id> terminates the connection with that ID.
+func (s *Service) handleConnections(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	switch r.Method {
+	case "GET":
+		if !s.CheckRequestPerm(r, auth.PermStatus) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		s.writeJSON(w, r, http.StatusOK, map[string]interface{}{
+			"connections": s.conns.list(),
+		})
+	case "DELETE":
+		if !s.CheckRequestPerm(r, auth.PermAll) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		v := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/debug/connections"), "/")
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			http.Error(w, "connection ID must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		found, err := s.conns.terminate(id)
+		if !found {
+			http.Error(w, fmt.Sprintf("connection %d not found", id), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		stats.Add(numConnectionsTerminated, 1)
+		s.logger.Printf("connection %d terminated", id)
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Addr returns the address on which the Service is listening
+func (s *Service) Addr() net.Addr {
+	return s.ln.Addr()
+}
+
+// FormRedirect returns the value for the "Location" header for a 301 response.
+func (s *Service) FormRedirect(r *http.Request, url string) string {
+	rq := r.URL.RawQuery
+	if rq != "" {
+		rq = fmt.Sprintf("?%s", rq)
+	}
+	return fmt.Sprintf("%s%s%s", url, r.URL.Path, rq)
+}
+
+// CheckRequestPerm checks if the request is authenticated and authorized
+// with the given Perm.
+func (s *Service) CheckRequestPerm(r *http.Request, perm string) (b bool) {
+	defer func() {
+		if b {
+			stats.Add(numAuthOK, 1)
+		} else {
+			stats.Add(numAuthFail, 1)
+		}
+	}()
+
+	// No auth store set, so no checking required.
+	if s.credentialStore == nil {
+		return true
+	}
+
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		username = ""
+	}
+
+	return s.credentialStore.AA(username, password, perm)
+}
+
+// CheckRequestPermAll checksif the request is authenticated and authorized
+// with all the given Perms.
+func (s *Service) CheckRequestPermAll(r *http.Request, perms ...string) (b bool) {
+	defer func() {
+		if b {
+			stats.Add(numAuthOK, 1)
+		} else {
+			stats.Add(numAuthFail, 1)
+		}
+	}()
+
+	// No auth store set, so no checking required.
+	if s.credentialStore == nil {
+		return true
+	}
+
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		username = ""
+	}
+
+	for _, perm := range perms {
+		if !s.credentialStore.AA(username, password, perm) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkPolicy checks the given statements against any statement policy. If
+// the statements are not permitted, an error is written to w and false is
+// returned. If the statements may be executed once approved by a second user,
+// the request, with body b, is held for approval instead.
+func (s *Service) checkPolicy(w http.ResponseWriter, r *http.Request, stmts []*command.Statement, b []byte) bool {
+	code, approvable, err := s.policyCheck(r, stmts)
+	if err == nil {
+		return true
+	}
+	if approvable {
+		s.requestApproval(w, r, b, err.Error())
+		return false
+	}
+	http.Error(w, err.Error(), code)
+	return false
+}
+
+// policyCheck checks the given statements against any statement policy, and
+// that none accesses a table reserved for rqlite, and returns nil if they may
+// be executed. Otherwise it returns the error, the
+// status code with which it should be reported, and whether the statements
+// may be executed once approved by a second user.
+func (s *Service) policyCheck(r *http.Request, stmts []*command.Statement) (int, bool, error) {
+	if err := s.store.CheckInternal(stmts); err != nil {
+		stats.Add(numPolicyDenied, 1)
+		return http.StatusForbidden, false, err
+	}
+	if s.Policy == nil {
+		return 0, false, nil
+	}
+
+	confirmed, err := isConfirmed(r)
+	if err != nil {
+		return http.StatusBadRequest, false, err
+	}
+
+	username, _, ok := r.BasicAuth()
+	if !ok {
+		username = ""
+	}
+
+	if err := s.Policy.Check(username, stmts, confirmed); err != nil {
+		var ar approvalRequirer
+		if errors.As(err, &ar) && ar.ApprovalRequired() {
+			if isApproved(r) {
+				return 0, false, nil
+			}
+			return http.StatusForbidden, true, err
+		}
+		stats.Add(numPolicyDenied, 1)
+		return http.StatusForbidden, false, err
+	}
+	return 0, false, nil
+}
+
+// admit returns whether a request may proceed given its priority and the
+// load on the node, and if so, a function which must be called once the
+// request completes. Reads are first checked with the overload controller,
+// which may shed them. The request then waits for a slot in the concurrency
+// pool for its priority. If the request may not proceed, an error response
+// is written, telling the client when to retry.
+func (s *Service) admit(w http.ResponseWriter, r *http.Request, read bool) (func(), bool) {
+	if s.Overload == nil && s.Pools == nil {
+		return func() {}, true
+	}
+	priority, err := s.requestPriority(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if read && s.Overload != nil {
+		if err := s.Overload.Admit(priority, r.Context().Done()); err != nil {
+			stats.Add(numOverloadShed, 1)
+			retry := int(math.Ceil(s.Overload.RetryAfter().Seconds()))
+			if retry < 1 {
+				retry = 1
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(retry))
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return nil, false
+		}
+	}
+	if s.Pools == nil {
+		return func() {}, true
+	}
+	release, err := s.Pools.Acquire(priority, r.Context().Done())
+	if err != nil {
+		stats.Add(numPoolRejected, 1)
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return nil, false
+	}
+	return release, true
+}
+
+// limitEndpoint acquires a slot in the concurrency pool of the named endpoint,
+// returning a function which must be called once the request completes. If
+// no slot becomes free in time, a 503 response is written and false returned.
+func (s *Service) limitEndpoint(w http.ResponseWriter, r *http.Request, endpoint string) (func(), bool) {
+	if s.EndpointPools == nil {
+		return func() {}, true
+	}
+	release, err := s.EndpointPools.Acquire(endpoint, r.Context().Done())
+	if err != nil {
+		stats.Add(numEndpointLimited, 1)
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, fmt.Sprintf("%s: %s", endpoint, err.Error()), http.StatusServiceUnavailable)
+		return nil, false
+	}
+	return release, true
+}
+
+// requestPriority returns the priority of the request. It is the priority
+// assigned to the requesting user, or normal if none is assigned. The
+// priority header can lower, but never raise, that priority.
+func (s *Service) requestPriority(r *http.Request) (string, error) {
+	username, _, _ := r.BasicAuth()
+	priority, ok := s.UserPriorities[username]
+	if !ok {
+		priority = overload.PriorityNormal
+	}
+	h := r.Header.Get(PriorityHTTPHeader)
+	if h == "" {
+		return priority, nil
+	}
+	p, err := overload.ParsePriority(h)
+	if err != nil {
+		return "", err
+	}
+	return overload.Lower(priority, p), nil
+}
+
+// checkNonDeterministic applies the policy for non-deterministic writes to the
+// statements, which must already have had any RANDOM rewritten. If the
+// statements are rejected, an error is written to w and false is returned.
+func (s *Service) checkNonDeterministic(w http.ResponseWriter, stmts []*command.Statement) bool {
+	if s.NonDeterministic == "" || s.NonDeterministic == NonDeterministicAllow {
+		return true
+	}
+	if s.NonDeterministic == NonDeterministicRewrite {
+		if err := command.RewriteTime(stmts, time.Now()); err != nil {
+			http.Error(w, fmt.Sprintf("SQL rewrite: %s", err.Error()), http.StatusInternalServerError)
+			return false
+		}
+	}
+
+	var found []string
+	for _, stmt := range stmts {
+		found = append(found, command.NonDeterministic(stmt.Sql)...)
+	}
+	if len(found) == 0 {
+		return true
+	}
+	if s.NonDeterministic == NonDeterministicReject {
+		stats.Add(numNonDeterministicRejected, 1)
+		http.Error(w, fmt.Sprintf("non-deterministic write rejected, uses %s", strings.Join(found, ", ")),
+			http.StatusBadRequest)
+		return false
+	}
+	stats.Add(numNonDeterministicWarned, 1)
+	w.Header().Set(NonDeterministicHTTPHeader, strings.Join(found, ", "))
+	return true
+}
+
+// checkTrash ensures no statement references a table in the trash, and if
+// rewrite is true, rewrites any DROP TABLE statements so the table is moved
+// to the trash instead. It writes an error to w, and returns false, if the
+// statements cannot be executed. It does nothing if the trash is disabled.
+func (s *Service) checkTrash(w http.ResponseWriter, stmts []*command.Statement, rewrite bool) bool {
+	if s.TrashRetention == 0 {
+		return true
+	}
+	for _, stmt := range stmts {
+		if trash.References(stmt.Sql) {
+			http.Error(w, "tables in the trash may only be accessed via /db/trash", http.StatusBadRequest)
+			return false
+		}
+	}
+	if rewrite {
+		if err := trash.Rewrite(stmts, time.Now(), s.trashSchema); err != nil {
+			if errors.Is(err, trash.ErrUnsupportedDrop) || errors.Is(err, trash.ErrReferenced) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return false
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return false
+		}
+	}
+	return true
+}
+
+// trashEntry describes a table in the trash.
+type trashEntry struct {
+	Name      string    `json:"name"`
+	Table     string    `json:"table"`
+	DroppedAt time.Time `json:"dropped_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+
+	placeholder bool
+}
+
+// trashSchema returns the objects in the database, as known by this node.
+func (s *Service) trashSchema() ([]trash.Object, error) {
+	rows, err := s.trashQuery(&command.Statement{
+		Sql: "SELECT type, name, tbl_name, sql FROM sqlite_master",
+	})
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]trash.Object, 0, len(rows.Values))
+	for _, v := range rows.Values {
+		if len(v.Parameters) != 4 {
+			continue
+		}
+		objects = append(objects, trash.Object{
+			Type:  v.Parameters[0].GetS(),
+			Name:  v.Parameters[1].GetS(),
+			Table: v.Parameters[2].GetS(),
+			SQL:   v.Parameters[3].GetS(),
+		})
+	}
+	return objects, nil
+}
+
+// trashObjects returns the SQL of the indexes and triggers recorded for the
+// table name in the trash, as known by this node.
+func (s *Service) trashObjects(name string) ([]string, error) {
+	rows, err := s.trashQuery(&command.Statement{
+		Sql: "SELECT name FROM sqlite_master WHERE type = 'table' AND name = ?",
+		Parameters: []*command.Parameter{
+			{Value: &command.Parameter_S{S: trash.ObjectsTable}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(rows.Values) == 0 {
+		return nil, nil
+	}
+	rows, err = s.trashQuery(&command.Statement{
+		Sql: fmt.Sprintf(`SELECT sql FROM "%s" WHERE trash_name = ? ORDER BY rowid`, trash.ObjectsTable),
+		Parameters: []*command.Parameter{
+			{Value: &command.Parameter_S{S: name}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]string, 0, len(rows.Values))
+	for _, v := range rows.Values {
+		if len(v.Parameters) == 1 {
+			objects = append(objects, v.Parameters[0].GetS())
+		}
+	}
+	return objects, nil
+}
+
+// trashQuery runs the query on this node, for managing the trash.
+func (s *Service) trashQuery(stmt *command.Statement) (*command.QueryRows, error) {
+	rows, err := s.store.Query(&command.QueryRequest{
+		Request: &command.Request{
+			Statements: []*command.Statement{stmt},
+		},
+		Level: command.QueryRequest_QUERY_REQUEST_LEVEL_NONE,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) != 1 {
+		return nil, fmt.Errorf("unexpected number of result sets: %d", len(rows))
+	}
+	if rows[0].Error != "" {
+		return nil, errors.New(rows[0].Error)
+	}
+	return rows[0], nil
+}
+
+// trashEntries returns the tables in the trash, as known by this node,
+// oldest first.
+func (s *Service) trashEntries() ([]*trashEntry, error) {
+	rows, err := s.trashQuery(&command.Statement{
+		Sql: "SELECT name, sql FROM sqlite_master WHERE type = 'table'",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*trashEntry, 0)
+	for _, v := range rows.Values {
+		if len(v.Parameters) != 2 {
+			continue
+		}
+		name := v.Parameters[0].GetS()
+		table, t, ok := trash.Parse(name)
+		if !ok {
+			continue
+		}
+		entries = append(entries, &trashEntry{
+			Name:        name,
+			Table:       table,
+			DroppedAt:   t,
+			ExpiresAt:   t.Add(s.TrashRetention),
+			placeholder: trash.IsPlaceholder(v.Parameters[1].GetS()),
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].DroppedAt.Before(entries[j].DroppedAt)
+	})
+	return entries, nil
+}
+
+// handleTrash handles listing tables in the trash, restoring a table from
+// the trash (POST /db/trash/<name>), and purging a table from the trash
+// (DELETE /db/trash/<name>).
+func (s *Service) handleTrash(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if !s.CheckRequestPerm(r, auth.PermAll) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if s.TrashRetention == 0 {
+		http.Error(w, "trash is not enabled", http.StatusNotFound)
+		return
+	}
+
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/db/trash"), "/")
+	switch r.Method {
+	case "GET":
+		entries, err := s.trashEntries()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		listed := make([]*trashEntry, 0, len(entries))
+		for _, e := range entries {
+			if !e.placeholder {
+				listed = append(listed, e)
+			}
+		}
+		s.writeJSON(w, r, http.StatusOK, map[string]interface{}{
+			"trash": listed,
+		})
+	case "POST", "DELETE":
+		table, _, ok := trash.Parse(name)
+		if !ok {
+			http.Error(w, fmt.Sprintf("%s is not a table in the trash", name), http.StatusBadRequest)
+			return
+		}
+		objects, err := s.trashObjects(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		stmts := trash.Purge(name, objects)
+		if r.Method == "POST" {
+			stats.Add(numTrashRestores, 1)
+			stmts = trash.Restore(name, table, objects)
+		}
+		s.executeDirect(w, r, stmts)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// handleChanges serves the change feed, and copies of the database from
+// which a consumer of the feed can resync.
+func (s *Service) handleChanges(w http.ResponseWriter, r *http.Request) {
+	if !s.CheckRequestPerm(r, auth.PermStandby) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if s.ChangeFeed == nil {
+		http.Error(w, "change feed is not enabled", http.StatusNotFound)
+		return
+	}
+
+	if strings.TrimSuffix(r.URL.Path, "/") == "/db/changes/snapshot" {
+		// The index is only known once the copy is taken, and must be sent
+		// as a header, so stage the copy on disk.
+		f, err := os.CreateTemp("", "rqlite-changes-snapshot-*")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		idx, err := s.ChangeFeed.BackupWithIndex(f)
+		if err == nil {
+			_, err = f.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set(standby.IndexHTTPHeader, strconv.FormatUint(idx, 10))
+		io.Copy(w, f)
+		return
+	}
+
+	since, err := uint64Param(r, "since")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	max := defaultMaxChanges
+	if m := r.URL.Query().Get("max"); m != "" {
+		max, err = strconv.Atoi(m)
+		if err != nil || max <= 0 {
+			http.Error(w, "max must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	changes, next, err := s.ChangeFeed.Changes(since, max)
+	if err != nil {
+		if err == store.ErrChangesUnavailable {
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if changes == nil {
+		changes = []*store.Change{}
+	}
+	stats.Add(numChangesServed, int64(len(changes)))
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	s.writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"changes": changes,
+		"next":    next,
+	})
+}
+
+// handleArchive moves rows into the archive database, or with GET, returns a
+// copy of the archive database.
+func (s *Service) handleArchive(w http.ResponseWriter, r *http.Request) {
+	if s.Archive == nil {
+		http.Error(w, "archive database is not enabled", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		if !s.CheckRequestPerm(r, auth.PermBackup) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		// The copy is complete before it is written, so a failure to make
+		// it can still be reported with an error status.
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if err := s.Archive.BackupArchive(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	case "POST":
+		if !s.CheckRequestPerm(r, auth.PermExecute) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var req struct {
+			Table string `json:"table"`
+			Where string `json:"where"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, ErrInvalidJSON.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Table == "" || req.Where == "" {
+			http.Error(w, "table and where must be set", http.StatusBadRequest)
+			return
+		}
+		n, err := s.Archive.ArchiveRows(req.Table, req.Where)
+		if err != nil {
+			if err == store.ErrNotLeader {
+				s.redirectToLeader(w, r)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		stats.Add(numArchives, 1)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		s.writeJSON(w, r, http.StatusOK, map[string]interface{}{
+			"rows": n,
+		})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// handlePartitions lists the partition sets with GET, creates a partition
+// set with POST, and drops the partition set named by the name query
+// parameter with DELETE.
+func (s *Service) handlePartitions(w http.ResponseWriter, r *http.Request) {
+	if s.Partitions == nil {
+		http.Error(w, "partitioning is not enabled", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	switch r.Method {
+	case "GET":
+		if !s.CheckRequestPerm(r, auth.PermQuery) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		sets, err := s.Partitions.PartitionSets()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if sets == nil {
+			sets = []store.PartitionSet{}
+		}
+		s.writeJSON(w, r, http.StatusOK, map[string]interface{}{
+			"partition_sets": sets,
+		})
+	case "POST", "DELETE":
+		if !s.CheckRequestPerm(r, auth.PermExecute) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var err error
+		if r.Method == "POST" {
+			var ps store.PartitionSet
+			if err := json.NewDecoder(r.Body).Decode(&ps); err != nil {
+				http.Error(w, ErrInvalidJSON.Error(), http.StatusBadRequest)
+				return
+			}
+			err = s.Partitions.CreatePartitionSet(ps)
+		} else {
+			name := r.URL.Query().Get("name")
+			if name == "" {
+				http.Error(w, "name must be set", http.StatusBadRequest)
+				return
+			}
+			err = s.Partitions.DropPartitionSet(name)
+		}
+		if err != nil {
+			switch err {
+			case store.ErrNotLeader:
+				s.redirectToLeader(w, r)
+			case store.ErrPartitionSetNotFound:
+				http.Error(w, err.Error(), http.StatusNotFound)
+			default:
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			}
+			return
+		}
+		stats.Add(numPartitionSetChanges, 1)
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// handleSchema returns the schema version of the database. If the version
+// known to the client is passed, it waits for the version to change, up
+// to the timeout, before returning.
+func (s *Service) handleSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if !s.CheckRequestPerm(r, auth.PermQuery) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if s.Schema == nil {
+		http.Error(w, "schema notifications are not enabled", http.StatusNotFound)
+		return
+	}
+
+	version := s.Schema.SchemaVersion()
+	if v := r.URL.Query().Get("version"); v != "" {
+		known, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			http.Error(w, "version must be an integer", http.StatusBadRequest)
+			return
+		}
+		timeout, err := timeoutParam(r, defaultSchemaWait)
+		if err != nil || timeout <= 0 || timeout > maxSchemaWait {
+			http.Error(w, fmt.Sprintf("timeout must be positive and at most %s", maxSchemaWait),
+				http.StatusBadRequest)
+			return
+		}
+		version = s.Schema.WaitSchemaChange(known, timeout, r.Context().Done())
+	}
+	stats.Add(numSchemaPolls, 1)
+	// The header set before waiting may be stale.
+	w.Header().Set(SchemaVersionHTTPHeader, strconv.FormatInt(version, 10))
+	s.writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"version": version,
+	})
+}
+
+// handleTableStats returns the reads of each table by the queries this node
+// has executed, so that tables which are never read, and those read most,
+// can be found. DELETE resets the counts.
+func (s *Service) handleTableStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if !s.CheckRequestPerm(r, auth.PermStatus) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if s.TableStats == nil {
+		http.Error(w, "table stats are not enabled", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		reads, since, err := s.TableStats.TableReads()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		tables := make(map[string]interface{}, len(reads))
+		for name, tr := range reads {
+			t := map[string]interface{}{
+				"reads":         tr.Reads,
+				"rows_returned": tr.RowsReturned,
+			}
+			if !tr.LastRead.IsZero() {
+				t["last_read"] = tr.LastRead.UTC().Format(time.RFC3339)
+			}
+			tables[name] = t
+		}
+		s.writeJSON(w, r, http.StatusOK, map[string]interface{}{
+			"since":  since.UTC().Format(time.RFC3339),
+			"tables": tables,
+		})
+	case "DELETE":
+		s.TableStats.ResetTableReads()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// handleValidate compiles statements against the schema of the database,
+// without executing them, and reports for each whether it could be executed,
+// whether it only reads the database, and any non-deterministic functions it
+// uses, so that migrations can be checked before they are applied.
+func (s *Service) handleValidate(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if !s.CheckRequestPerm(r, auth.PermQuery) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if s.Validator == nil {
+		http.Error(w, "validation not supported", http.StatusNotFound)
+		return
+	}
+
+	if r.Method != "GET" && r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	stmts, _, err := requestQueries(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	vals, err := s.Validator.Validate(&command.Request{Statements: stmts})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	valid := true
+	for _, v := range vals {
+		// Writes which would be rejected by the non-deterministic policy are
+		// reported as invalid, as executing them would fail.
+		if v.Error == "" && len(v.NonDeterministic) > 0 && s.NonDeterministic == NonDeterministicReject {
+			v.Error = fmt.Sprintf("non-deterministic write rejected, uses %s", strings.Join(v.NonDeterministic, ", "))
+		}
+		if v.Error != "" {
+			valid = false
+		}
+	}
+	s.writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"results": vals,
+		"valid":   valid,
+	})
+}
+
+// handleFence fences the cluster, or reports whether it is fenced.
+func (s *Service) handleFence(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if !s.CheckRequestPerm(r, auth.PermStandby) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if s.ChangeFeed == nil {
+		http.Error(w, "change feed is not enabled", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		fenced, by := s.ChangeFeed.Fenced()
+		s.writeJSON(w, r, http.StatusOK, map[string]interface{}{
+			"fenced":    fenced,
+			"fenced_by": by,
+		})
+	case "POST":
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			http.Error(w, "id must be set", http.StatusBadRequest)
+			return
+		}
+		if err := s.ChangeFeed.Fence(id); err != nil {
+			if err == store.ErrNotLeader {
+				s.redirectToLeader(w, r)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		stats.Add(numFences, 1)
+		s.logger.Printf("cluster fenced by %s", id)
+		s.writeJSON(w, r, http.StatusOK, map[string]interface{}{})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// handlePromote promotes a warm standby cluster to be a primary.
+func (s *Service) handlePromote(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if !s.CheckRequestPerm(r, auth.PermAll) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if s.Standby == nil {
+		http.Error(w, "node is not part of a standby cluster", http.StatusNotFound)
+		return
+	}
+
+	force, err := isForce(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.Standby.Promote(force); err != nil {
+		if err == standby.ErrNotLeader {
+			s.redirectToLeader(w, r)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	stats.Add(numPromotions, 1)
+	s.writeJSON(w, r, http.StatusOK, map[string]interface{}{})
+}
+
+// handleResync handles requests to resync this node's database from the
+// leader's, discarding its own. A GET request returns the status of the
+// current, or most recent, resync.
+func (s *Service) handleResync(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if !s.CheckRequestPerm(r, auth.PermLoad) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if s.Resyncer == nil {
+		http.Error(w, "resync not supported", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		st, err := s.Resyncer.Stats()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.writeJSON(w, r, http.StatusOK, st)
+	case "POST":
+		username, password, ok := r.BasicAuth()
+		if !ok {
+			username = ""
+		}
+		if err := s.Resyncer.Resync(makeCredentials(username, password)); err != nil {
+			switch err {
+			case cluster.ErrResyncLeader:
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			case cluster.ErrResyncInProgress:
+				http.Error(w, err.Error(), http.StatusConflict)
+			default:
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			}
+			return
+		}
+		stats.Add(numResyncs, 1)
+		s.writeJSON(w, r, http.StatusAccepted, map[string]interface{}{})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// handleJobs returns the ownership and status of the background jobs which
+// only run on the leader.
+func (s *Service) handleJobs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if !s.CheckRequestPerm(r, auth.PermStatus) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if s.Jobs == nil {
+		http.Error(w, "leader jobs not supported", http.StatusNotFound)
+		return
+	}
+
+	st, err := s.Jobs.Stats()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.writeJSON(w, r, http.StatusOK, st)
+}
+
+// handleBatching returns the batching window of each consistency domain, and
+// its effect (GET), or sets the windows given as a JSON object mapping domain
+// to duration (PUT), such as {"writes": "2ms"}, on this node. Setting them
+// requires all permissions.
+func (s *Service) handleBatching(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	perm := auth.PermStatus
+	if r.Method == "PUT" {
+		perm = auth.PermAll
+	}
+	if !s.CheckRequestPerm(r, perm) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if s.Batching == nil {
+		http.Error(w, "batching not supported", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+	case "PUT":
+		var windows map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&windows); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for domain, v := range windows {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid batching window for %s: %s", domain, err.Error()),
+					http.StatusBadRequest)
+				return
+			}
+			if err := s.Batching.SetBatchWindow(domain, d); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	s.writeJSON(w, r, http.StatusOK, s.Batching.BatchStats())
+}
+
+// handleClusterEvents returns the events in the cluster event log with IDs
+// greater than the URL param 'since', oldest first, up to the number given
+// by the URL param 'limit'.
+func (s *Service) handleClusterEvents(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if !s.CheckRequestPerm(r, auth.PermStatus) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if s.Events == nil {
+		http.Error(w, "cluster event log not supported", http.StatusNotFound)
+		return
+	}
+
+	since, err := uint64Param(r, "since")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit := defaultMaxClusterEvents
+	if l := r.URL.Query().Get("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit <= 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	events, err := s.Events.ClusterEvents(int64(since), limit)
+	if err != nil {
+		if err == store.ErrClusterEventsDisabled {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"events": events,
+	})
+}
+
+// handleRestart handles requests to restart every node in the cluster, one
+// at a time. A GET request returns the status of the current, or most recent,
+// rolling restart.
+func (s *Service) handleRestart(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if !s.CheckRequestPerm(r, auth.PermRestart) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if s.Restarter == nil {
+		http.Error(w, "rolling restarts not supported", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		st, err := s.Restarter.Stats()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.writeJSON(w, r, http.StatusOK, st)
+	case "POST":
+		servers, err := s.store.Nodes()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		nodes := make([]cluster.RestartNode, len(servers))
+		for i := range servers {
+			nodes[i] = cluster.RestartNode{ID: servers[i].ID, Addr: servers[i].Addr}
+		}
+
+		username, password, ok := r.BasicAuth()
+		if !ok {
+			username = ""
+		}
+		if err := s.Restarter.Start(nodes, makeCredentials(username, password)); err != nil {
+			switch err {
+			case cluster.ErrRestartNotLeader:
+				s.redirectToLeader(w, r)
+			case cluster.ErrRestartInProgress:
+				http.Error(w, err.Error(), http.StatusConflict)
+			default:
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			}
+			return
+		}
+		stats.Add(numRollingRestarts, 1)
+		s.writeJSON(w, r, http.StatusAccepted, map[string]interface{}{})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// handleReplace handles requests to replace one node in the cluster with
+// another. A GET request returns the status of the current, or most recent,
+// replacement.
+func (s *Service) handleReplace(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if !s.CheckRequestPerm(r, auth.PermJoin) || !s.CheckRequestPerm(r, auth.PermRemove) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if s.Replacer == nil {
+		http.Error(w, "node replacement not supported", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		st, err := s.Replacer.Stats()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.writeJSON(w, r, http.StatusOK, st)
+	case "POST":
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var rp cluster.Replacement
+		if err := json.Unmarshal(b, &rp); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if rp.OldID == "" || rp.NewID == "" || rp.NewAddr == "" {
+			http.Error(w, "old_id, new_id, and new_addr must be set", http.StatusBadRequest)
+			return
+		}
+		if s.RemoveNeedsApproval && !isApproved(r) {
+			s.requestApproval(w, r, b, fmt.Sprintf("replacement of node %s requires approval", rp.OldID))
+			return
+		}
+		if err := s.Replacer.Start(rp); err != nil {
+			switch err {
+			case cluster.ErrReplaceNotLeader:
+				s.redirectToLeader(w, r)
+			case cluster.ErrReplaceInProgress:
+				http.Error(w, err.Error(), http.StatusConflict)
+			default:
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			}
+			return
+		}
+		stats.Add(numReplacements, 1)
+		s.writeJSON(w, r, http.StatusAccepted, map[string]interface{}{})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// redirectToLeader redirects the request to the leader, or returns a 503 if
+// there is no leader.
+func (s *Service) redirectToLeader(w http.ResponseWriter, r *http.Request) {
+	leaderAPIAddr := s.LeaderAPIAddr()
+	if leaderAPIAddr == "" {
+		stats.Add(numLeaderNotFound, 1)
+		http.Error(w, ErrLeaderNotFound.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	http.Redirect(w, r, s.FormRedirect(r, leaderAPIAddr), http.StatusMovedPermanently)
+}
+
+// executeDirect executes the given statements in a single transaction,
+// without any rewriting or policy checks, forwarding the request to the
+// Leader if necessary. The results are written to w.
+func (s *Service) executeDirect(w http.ResponseWriter, r *http.Request, stmts []string) {
+	resp := NewResponse()
 
-	b, err := io.ReadAll(r.Body)
+	timeout, err := timeoutParam(r, defaultTimeout)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	r.Body.Close()
-
-	stmts, err := ParseRequest(b)
+	timings, err := isTimings(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	stats.Add(numRequestStmtsRx, int64(len(stmts)))
-
-	if err := command.Rewrite(stmts, noRewriteRandom); err != nil {
-		http.Error(w, fmt.Sprintf("SQL rewrite: %s", err.Error()), http.StatusInternalServerError)
-		return
-	}
-
-	resp := NewResponse()
-	resp.Results.AssociativeJSON = isAssoc
-
-	eqr := &command.ExecuteQueryRequest{
-		Request: &command.Request{
-			Transaction: isTx,
-			Statements:  stmts,
-		},
-		Timings:   timings,
-		Level:     lvl,
-		Freshness: frsh.Nanoseconds(),
-	}
-
-	results, resultErr := s.store.Request(eqr)
-	if resultErr != nil && resultErr == store.ErrNotLeader {
-		if redirect {
-			leaderAPIAddr := s.LeaderAPIAddr()
-			if leaderAPIAddr == "" {
-				stats.Add(numLeaderNotFound, 1)
-				http.Error(w, ErrLeaderNotFound.Error(), http.StatusServiceUnavailable)
-				return
-			}
-			loc := s.FormRedirect(r, leaderAPIAddr)
-			http.Redirect(w, r, loc, http.StatusMovedPermanently)
-			return
-		}
+	er := executeRequestFromStrings(stmts, timings, true)
 
+	results, resultsErr := s.store.Execute(er)
+	if resultsErr != nil && resultsErr == store.ErrNotLeader {
 		addr, err := s.store.LeaderAddr()
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, fmt.Sprintf("leader address: %s", err.Error()),
+				http.StatusInternalServerError)
 			return
 		}
 		if addr == "" {
@@ -1645,140 +4054,256 @@ func (s *Service) handleRequest(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, ErrLeaderNotFound.Error(), http.StatusServiceUnavailable)
 			return
 		}
+
 		username, password, ok := r.BasicAuth()
 		if !ok {
 			username = ""
 		}
 
 		w.Header().Add(ServedByHTTPHeader, addr)
-		results, resultErr = s.cluster.Request(eqr, addr, makeCredentials(username, password), timeout)
-		if resultErr != nil {
-			stats.Add(numRemoteRequestsFailed, 1)
-			if resultErr.Error() == "unauthorized" {
-				http.Error(w, "remote request not authorized", http.StatusUnauthorized)
+		results, resultsErr = s.cluster.Execute(er, addr, makeCredentials(username, password), timeout)
+		if resultsErr != nil {
+			stats.Add(numRemoteExecutionsFailed, 1)
+			if resultsErr.Error() == "unauthorized" {
+				http.Error(w, "remote execute not authorized", http.StatusUnauthorized)
 				return
 			}
 		}
-		stats.Add(numRemoteRequests, 1)
+		stats.Add(numRemoteExecutions, 1)
 	}
 
-	if resultErr != nil {
-		resp.Error = resultErr.Error()
+	if resultsErr != nil {
+		resp.Error = resultsErr.Error()
 	} else {
-		resp.Results.ExecuteQueryResponse = results
+		resp.Results.ExecuteResult = results
 	}
 	resp.end = time.Now()
 	s.writeResponse(w, r, resp)
 }
 
-// handleExpvar serves registered expvar information over HTTP.
-func (s *Service) handleExpvar(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	if !s.CheckRequestPerm(r, auth.PermStatus) {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
+// runTrashPurge periodically purges tables which have been in the trash for
+// longer than the retention period. Only the Leader can purge tables, so
+// this is a no-op on other nodes.
+func (s *Service) runTrashPurge() {
+	defer close(s.trashDone)
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.closeCh:
+			return
+		case <-ticker.C:
+			s.purgeTrash(time.Now())
+		}
 	}
-	key := keyParam(r)
+}
 
-	fmt.Fprintf(w, "{\n")
-	first := true
-	expvar.Do(func(kv expvar.KeyValue) {
-		if key != "" && key != kv.Key {
+// purgeTrash purges any expired tables, as of now, from the trash.
+func (s *Service) purgeTrash(now time.Time) {
+	entries, err := s.trashEntries()
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if !e.placeholder && now.Before(e.ExpiresAt) {
+			continue
+		}
+		objects, err := s.trashObjects(e.Name)
+		if err != nil {
 			return
 		}
-		if !first {
-			fmt.Fprintf(w, ",\n")
+		er := executeRequestFromStrings(trash.Purge(e.Name, objects), false, true)
+		results, err := s.store.Execute(er)
+		if err == store.ErrNotLeader || err == store.ErrNotOpen || err == store.ErrNotReady {
+			return
 		}
-		first = false
-		fmt.Fprintf(w, "%q: %s", kv.Key, kv.Value)
-	})
-	fmt.Fprintf(w, "\n}\n")
+		if err == nil {
+			for _, r := range results {
+				if r.Error != "" {
+					err = errors.New(r.Error)
+					break
+				}
+			}
+		}
+		if err != nil {
+			stats.Add(numTrashPurgesFailed, 1)
+			s.logger.Printf("failed to purge %s from trash: %s", e.Name, err.Error())
+			continue
+		}
+		stats.Add(numTrashPurges, 1)
+		if !e.placeholder {
+			s.logger.Printf("purged table %s, dropped at %s, from trash", e.Table, e.DroppedAt)
+		}
+	}
 }
 
-// handlePprof serves pprof information over HTTP.
-func (s *Service) handlePprof(w http.ResponseWriter, r *http.Request) {
-	if !s.CheckRequestPerm(r, auth.PermStatus) {
-		w.WriteHeader(http.StatusUnauthorized)
+// requestApproval holds the request r, with body b, until it is approved by
+// a second user, and informs the client that approval is pending. Operations
+// are only held by the Leader, so the client is redirected there if this node
+// is not the Leader.
+func (s *Service) requestApproval(w http.ResponseWriter, r *http.Request, b []byte, reason string) {
+	if !s.store.IsLeader() {
+		s.redirectToLeader(w, r)
 		return
 	}
 
-	switch r.URL.Path {
-	case "/debug/pprof/cmdline":
-		pprof.Cmdline(w, r)
-	case "/debug/pprof/profile":
-		pprof.Profile(w, r)
-	case "/debug/pprof/symbol":
-		pprof.Symbol(w, r)
-	default:
-		pprof.Index(w, r)
+	username, _, ok := r.BasicAuth()
+	if !ok {
+		username = ""
 	}
-}
 
-// Addr returns the address on which the Service is listening
-func (s *Service) Addr() net.Addr {
-	return s.ln.Addr()
+	now := time.Now()
+	pa := &pendingApproval{
+		ID:        random.String(),
+		Requester: username,
+		Method:    r.Method,
+		URL:       r.URL.RequestURI(),
+		Reason:    reason,
+		Created:   now,
+		Expires:   now.Add(s.ApprovalTimeout),
+		body:      b,
+		header:    r.Header.Clone(),
+	}
+
+	s.approvalsMu.Lock()
+	s.purgeApprovals(now)
+	s.approvals[pa.ID] = pa
+	s.approvalsMu.Unlock()
+	stats.Add(numApprovalsRequested, 1)
+	s.logger.Printf("operation %s %s by user %q held for approval with ID %s: %s",
+		pa.Method, pa.URL, pa.Requester, pa.ID, reason)
+
+	s.writeJSON(w, r, http.StatusAccepted, map[string]interface{}{
+		"approval": pa,
+	})
 }
 
-// FormRedirect returns the value for the "Location" header for a 301 response.
-func (s *Service) FormRedirect(r *http.Request, url string) string {
-	rq := r.URL.RawQuery
-	if rq != "" {
-		rq = fmt.Sprintf("?%s", rq)
+// handleApprovals handles listing, approving, and rejecting operations
+// awaiting approval. Approving an operation executes it, and the response
+// is that of the original operation. Operations awaiting approval are held
+// by the Leader, so the client is redirected there if this node is not the
+// Leader.
+func (s *Service) handleApprovals(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if !s.CheckRequestPerm(r, auth.PermApprove) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
 	}
-	return fmt.Sprintf("%s%s%s", url, r.URL.Path, rq)
-}
 
-// CheckRequestPerm checks if the request is authenticated and authorized
-// with the given Perm.
-func (s *Service) CheckRequestPerm(r *http.Request, perm string) (b bool) {
-	defer func() {
-		if b {
-			stats.Add(numAuthOK, 1)
-		} else {
-			stats.Add(numAuthFail, 1)
+	if !s.store.IsLeader() {
+		// Anything held while this node was Leader can no longer be
+		// reached, so discard it.
+		s.approvalsMu.Lock()
+		for id := range s.approvals {
+			delete(s.approvals, id)
 		}
-	}()
+		s.approvalsMu.Unlock()
+		s.redirectToLeader(w, r)
+		return
+	}
 
-	// No auth store set, so no checking required.
-	if s.credentialStore == nil {
-		return true
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/approvals"), "/")
+
+	s.approvalsMu.Lock()
+	s.purgeApprovals(time.Now())
+	pa, ok := s.approvals[id]
+	if r.Method == "GET" && id == "" {
+		pending := make([]*pendingApproval, 0, len(s.approvals))
+		for _, p := range s.approvals {
+			pending = append(pending, p)
+		}
+		s.approvalsMu.Unlock()
+		sort.Slice(pending, func(i, j int) bool {
+			return pending[i].Created.Before(pending[j].Created)
+		})
+		s.writeJSON(w, r, http.StatusOK, map[string]interface{}{
+			"approvals": pending,
+		})
+		return
 	}
+	s.approvalsMu.Unlock()
 
-	username, password, ok := r.BasicAuth()
+	if r.Method != "GET" && r.Method != "POST" && r.Method != "DELETE" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
 	if !ok {
-		username = ""
+		http.Error(w, fmt.Sprintf("no operation awaiting approval with ID %s", id), http.StatusNotFound)
+		return
 	}
 
-	return s.credentialStore.AA(username, password, perm)
-}
+	username, _, ok := r.BasicAuth()
+	if !ok {
+		username = ""
+	}
 
-// CheckRequestPermAll checksif the request is authenticated and authorized
-// with all the given Perms.
-func (s *Service) CheckRequestPermAll(r *http.Request, perms ...string) (b bool) {
-	defer func() {
-		if b {
-			stats.Add(numAuthOK, 1)
-		} else {
-			stats.Add(numAuthFail, 1)
+	switch r.Method {
+	case "GET":
+		s.writeJSON(w, r, http.StatusOK, map[string]interface{}{
+			"approval": pa,
+		})
+	case "DELETE":
+		if !s.takeApproval(id) {
+			http.Error(w, fmt.Sprintf("no operation awaiting approval with ID %s", id), http.StatusNotFound)
+			return
 		}
-	}()
+		stats.Add(numApprovalsRejected, 1)
+		s.logger.Printf("operation with ID %s rejected by user %q", id, username)
+	case "POST":
+		if username == "" || username == pa.Requester {
+			http.Error(w, "operation must be approved by a different user", http.StatusForbidden)
+			return
+		}
+		if !s.takeApproval(id) {
+			http.Error(w, fmt.Sprintf("no operation awaiting approval with ID %s", id), http.StatusNotFound)
+			return
+		}
+		stats.Add(numApprovalsGranted, 1)
+		s.logger.Printf("operation with ID %s approved by user %q, executing", id, username)
 
-	// No auth store set, so no checking required.
-	if s.credentialStore == nil {
-		return true
+		// Replay the original request, as the original user.
+		ctx := context.WithValue(r.Context(), approvedCtxKey{}, true)
+		req, err := http.NewRequestWithContext(ctx, pa.Method, pa.URL, bytes.NewReader(pa.body))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		req.Header = pa.header
+		req.RemoteAddr = r.RemoteAddr
+		s.ServeHTTP(w, req)
 	}
+}
 
-	username, password, ok := r.BasicAuth()
-	if !ok {
-		username = ""
+// takeApproval removes the operation with the given ID from the set
+// awaiting approval. It returns false if no such operation exists.
+func (s *Service) takeApproval(id string) bool {
+	s.approvalsMu.Lock()
+	defer s.approvalsMu.Unlock()
+	if _, ok := s.approvals[id]; !ok {
+		return false
 	}
+	delete(s.approvals, id)
+	return true
+}
 
-	for _, perm := range perms {
-		if !s.credentialStore.AA(username, password, perm) {
-			return false
+// purgeApprovals discards operations whose approval has expired. The
+// caller must hold approvalsMu.
+func (s *Service) purgeApprovals(now time.Time) {
+	for id, pa := range s.approvals {
+		if now.After(pa.Expires) {
+			delete(s.approvals, id)
+			stats.Add(numApprovalsExpired, 1)
+			s.logger.Printf("approval for operation with ID %s expired", id)
 		}
 	}
-	return true
+}
+
+func (s *Service) numPendingApprovals() int {
+	s.approvalsMu.Lock()
+	defer s.approvalsMu.Unlock()
+	return len(s.approvals)
 }
 
 // LeaderAPIAddr returns the API address of the leader, as known by this node.
@@ -1808,8 +4333,9 @@ func (s *Service) runQueue() {
 		case req := <-s.stmtQueue.C:
 			er := &command.ExecuteRequest{
 				Request: &command.Request{
-					Statements:  req.Statements,
-					Transaction: s.DefaultQueueTx,
+					Statements:     req.Statements,
+					Transaction:    s.DefaultQueueTx,
+					IdempotencyKey: req.IdempotencyKey,
 				},
 			}
 			stats.Add(numQueuedExecutionsStmtsRx, int64(len(req.Statements)))
@@ -1818,9 +4344,13 @@ func (s *Service) runQueue() {
 			// a "checkpoint" through the queue.
 			if er.Request.Statements != nil {
 				for {
+					start := time.Now()
 					_, err = s.store.Execute(er)
 					if err == nil {
 						// Success!
+						if s.Overload != nil {
+							s.Overload.ObserveApply(time.Since(start))
+						}
 						break
 					}
 
@@ -1909,6 +4439,14 @@ func (s *Service) checkNodes(nodes []*store.Server, timeout time.Duration) (map[
 }
 
 // addBuildVersion adds the build version to the HTTP response.
+func (s *Service) addSchemaVersion(w http.ResponseWriter) {
+	// Add schema version header to every response, if available.
+	if s.Schema == nil {
+		return
+	}
+	w.Header().Set(SchemaVersionHTTPHeader, strconv.FormatInt(s.Schema.SchemaVersion(), 10))
+}
+
 func (s *Service) addBuildVersion(w http.ResponseWriter) {
 	// Add version header to every response, if available.
 	version := "unknown"
@@ -1933,6 +4471,27 @@ func (s *Service) tlsStats() map[string]interface{} {
 	return m
 }
 
+// writeJSON writes v to w as JSON, with the given status code.
+func (s *Service) writeJSON(w http.ResponseWriter, r *http.Request, code int, v interface{}) {
+	var b []byte
+	var err error
+	pretty, _ := isPretty(r)
+	if pretty {
+		b, err = json.MarshalIndent(v, "", "    ")
+	} else {
+		b, err = json.Marshal(v)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(code)
+	_, err = w.Write(b)
+	if err != nil {
+		s.logger.Println("writing response failed:", err.Error())
+	}
+}
+
 // writeResponse writes the given response to the given writer.
 func (s *Service) writeResponse(w http.ResponseWriter, r *http.Request, j Responser) {
 	var b []byte
@@ -1954,32 +4513,38 @@ func (s *Service) writeResponse(w http.ResponseWriter, r *http.Request, j Respon
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if _, ok := w.(*checksumWriter); !ok {
+		if sum, _ := isChecksum(r); sum {
+			w.Header().Set(ChecksumHTTPHeader, checksum(b))
+		}
+	}
 	_, err = w.Write(b)
 	if err != nil {
 		s.logger.Println("writing response failed:", err.Error())
 	}
 }
 
-func requestQueries(r *http.Request) ([]*command.Statement, error) {
+func requestQueries(r *http.Request) ([]*command.Statement, []byte, error) {
 	if r.Method == "GET" {
 		query, err := stmtParam(r)
 		if err != nil || query == "" {
-			return nil, errors.New("bad query GET request")
+			return nil, nil, errors.New("bad query GET request")
 		}
 		return []*command.Statement{
 			{
 				Sql: query,
 			},
-		}, nil
+		}, nil, nil
 	}
 
 	b, err := io.ReadAll(r.Body)
 	if err != nil {
-		return nil, errors.New("bad query POST request")
+		return nil, nil, errors.New("bad query POST request")
 	}
 	r.Body.Close()
 
-	return ParseRequest(b)
+	stmts, err := parseRequestBody(r, b)
+	return stmts, b, err
 }
 
 // queryParam returns whether the given query param is present.
@@ -2007,6 +4572,31 @@ func fmtParam(req *http.Request) (string, error) {
 	return strings.TrimSpace(q.Get("fmt")), nil
 }
 
+// queryFormat returns the requested format of query results, set by the
+// URL param 'format', or if not set, by the Accept header. If neither is set,
+// it is QueryFormatJSON.
+func queryFormat(req *http.Request) (string, error) {
+	f := strings.ToLower(strings.TrimSpace(req.URL.Query().Get("format")))
+	switch f {
+	case "":
+		accept := req.Header.Get("Accept")
+		if strings.Contains(accept, "text/csv") {
+			return QueryFormatCSV, nil
+		}
+		if strings.Contains(accept, "application/x-ndjson") {
+			return QueryFormatNDJSON, nil
+		}
+		if strings.Contains(accept, parquet.ContentType) {
+			return QueryFormatParquet, nil
+		}
+		return QueryFormatJSON, nil
+	case QueryFormatJSON, QueryFormatNDJSON, QueryFormatCSV, QueryFormatParquet:
+		return f, nil
+	default:
+		return "", fmt.Errorf("invalid query format %q", f)
+	}
+}
+
 // isPretty returns whether the HTTP response body should be pretty-printed.
 func isPretty(req *http.Request) (bool, error) {
 	return queryParam(req, "pretty")
@@ -2018,6 +4608,25 @@ func isRedirect(req *http.Request) (bool, error) {
 	return queryParam(req, "redirect")
 }
 
+// isForce returns whether the operation should be forced.
+func isForce(req *http.Request) (bool, error) {
+	return queryParam(req, "force")
+}
+
+// uint64Param returns the value of the given URL param as a uint64, or
+// zero if it is not present.
+func uint64Param(req *http.Request, param string) (uint64, error) {
+	v := req.URL.Query().Get(param)
+	if v == "" {
+		return 0, nil
+	}
+	u, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a non-negative integer", param)
+	}
+	return u, nil
+}
+
 func keyParam(req *http.Request) string {
 	q := req.URL.Query()
 	return strings.TrimSpace(q.Get("key"))
@@ -2094,6 +4703,41 @@ func isTx(req *http.Request) (bool, error) {
 	return queryParam(req, "transaction")
 }
 
+// atomicity returns whether the statements of the HTTP request are to be
+// executed as a single transaction, and whether processing is to stop at the
+// first statement which fails, as set by the atomicity param. Without it, a
+// transaction is used only if tx is true. Requesting any atomicity other than
+// "all" along with a transaction is an error.
+func atomicity(req *http.Request, tx bool) (bool, bool, error) {
+	a := strings.TrimSpace(req.URL.Query().Get("atomicity"))
+	switch strings.ToLower(a) {
+	case "":
+		return tx, false, nil
+	case "all":
+		return true, false, nil
+	case "statement", "stop":
+		if tx {
+			return false, false, fmt.Errorf("atomicity %s conflicts with transaction", a)
+		}
+		return false, strings.EqualFold(a, "stop"), nil
+	default:
+		return false, false, fmt.Errorf("invalid atomicity %q, must be all, statement, or stop", a)
+	}
+}
+
+// idempotencyKey returns the idempotency key of the HTTP request, if any,
+// set by header or by the idempotency_key param.
+func idempotencyKey(req *http.Request) (string, error) {
+	key := req.Header.Get(IdempotencyKeyHTTPHeader)
+	if key == "" {
+		key = req.URL.Query().Get("idempotency_key")
+	}
+	if len(key) > maxIdempotencyKeyLen {
+		return "", fmt.Errorf("idempotency key longer than %d bytes", maxIdempotencyKeyLen)
+	}
+	return key, nil
+}
+
 // isQueue returns whether the HTTP request is requesting a queue.
 func isQueue(req *http.Request) (bool, error) {
 	return queryParam(req, "queue")
@@ -2163,6 +4807,12 @@ func noLeader(req *http.Request) (bool, error) {
 	return queryParam(req, "noleader")
 }
 
+// leaderParam returns whether the client requested that the node only be
+// considered ready if it is the leader.
+func leaderParam(req *http.Request) (bool, error) {
+	return queryParam(req, "leader")
+}
+
 // nonVoters returns whether a query is requesting to include non-voter results
 func nonVoters(req *http.Request) (bool, error) {
 	return queryParam(req, "nonvoters")
@@ -2178,10 +4828,46 @@ func isWait(req *http.Request) (bool, error) {
 	return queryParam(req, "wait")
 }
 
+// isApproved returns whether the HTTP request is the replay of an approved operation.
+func isApproved(req *http.Request) bool {
+	return req.Context().Value(approvedCtxKey{}) != nil
+}
+
+// isConfirmed returns whether the HTTP request confirms the statements should
+// be executed.
+func isConfirmed(req *http.Request) (bool, error) {
+	return queryParam(req, "confirm")
+}
+
 func isAssociative(req *http.Request) (bool, error) {
 	return queryParam(req, "associative")
 }
 
+// isMetadata returns whether metadata of the columns of query results is
+// requested.
+func isMetadata(req *http.Request) (bool, error) {
+	return queryParam(req, "metadata")
+}
+
+// resultsFormat returns the projection of the columns of query results, and
+// the rendering of NULL values in them, requested by the URL params 'columns'
+// and 'nulls'. The projection is nil if it is not requested.
+func resultsFormat(req *http.Request) (*encoding.Projection, encoding.NullMode, error) {
+	q := req.URL.Query()
+	nulls, err := encoding.ParseNullMode(q.Get("nulls"))
+	if err != nil {
+		return nil, encoding.NullsAsNull, err
+	}
+	if _, ok := q["columns"]; !ok {
+		return nil, nulls, nil
+	}
+	proj, err := encoding.ParseProjection(q.Get("columns"))
+	if err != nil {
+		return nil, encoding.NullsAsNull, err
+	}
+	return proj, nulls, nil
+}
+
 // noRewriteRandom returns whether a rewrite of RANDOM is disabled.
 func noRewriteRandom(req *http.Request) (bool, error) {
 	return queryParam(req, "norwrandom")
@@ -2199,6 +4885,8 @@ func level(req *http.Request) (command.QueryRequest_Level, error) {
 		return command.QueryRequest_QUERY_REQUEST_LEVEL_WEAK, nil
 	case "strong":
 		return command.QueryRequest_QUERY_REQUEST_LEVEL_STRONG, nil
+	case "linearizable":
+		return command.QueryRequest_QUERY_REQUEST_LEVEL_LINEARIZABLE, nil
 	default:
 		return command.QueryRequest_QUERY_REQUEST_LEVEL_WEAK, nil
 	}
@@ -10,13 +10,20 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/hashicorp/raft"
 	"github.com/rqlite/rqlite/cluster"
 	"github.com/rqlite/rqlite/command"
+	"github.com/rqlite/rqlite/db"
+	"github.com/rqlite/rqlite/overload"
+	"github.com/rqlite/rqlite/standby"
 	"github.com/rqlite/rqlite/store"
+	"google.golang.org/protobuf/proto"
 )
 
 func Test_ResponseJSONMarshal(t *testing.T) {
@@ -371,6 +378,7 @@ func Test_401Routes_BasicAuthBadPassword(t *testing.T) {
 		"/status",
 		"/nodes",
 		"/readyz",
+		"/approvals",
 		"/debug/vars",
 		"/debug/pprof/cmdline",
 		"/debug/pprof/profile",
@@ -522,6 +530,50 @@ func Test_401JoinReadOnly(t *testing.T) {
 	}
 }
 
+func Test_JoinPlacementViolation(t *testing.T) {
+	m := &MockStore{}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	var zone string
+	m.joinFn = func(jr *command.JoinRequest) error {
+		zone = jr.Zone
+		if jr.Voter {
+			return fmt.Errorf("%w: test", store.ErrPlacementViolation)
+		}
+		return nil
+	}
+
+	resp, err := http.Post(host+"/join", "application/json",
+		strings.NewReader(`{"id": "1", "addr":"localhost:4001", "voter": true, "zone": "zone1"}`))
+	if err != nil {
+		t.Fatalf("failed to make join request")
+	}
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("failed to get expected StatusConflict for join, got %d", resp.StatusCode)
+	}
+	if zone != "zone1" {
+		t.Fatalf("wrong zone passed to store, exp zone1, got %s", zone)
+	}
+
+	resp, err = http.Post(host+"/join", "application/json",
+		strings.NewReader(`{"id": "1", "addr":"localhost:4001", "voter": false}`))
+	if err != nil {
+		t.Fatalf("failed to make join request")
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK for join, got %d", resp.StatusCode)
+	}
+	if zone != "" {
+		t.Fatalf("wrong zone passed to store, exp none, got %s", zone)
+	}
+}
+
 func Test_BackupOK(t *testing.T) {
 	m := &MockStore{}
 	c := &mockClusterService{}
@@ -864,6 +916,76 @@ func Test_RegisterStatus(t *testing.T) {
 	}
 }
 
+func Test_StatusVersion(t *testing.T) {
+	m := &MockStore{}
+	s := New("127.0.0.1:0", m, &mockClusterService{}, nil)
+	if err := s.RegisterStatus("foo", &mockStatusReporter{map[string]interface{}{"bar": "qux"}}); err != nil {
+		t.Fatalf("failed to register statusReporter: %s", err.Error())
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	getStatus := func(query string) map[string]interface{} {
+		t.Helper()
+		resp := mustDoRequest(t, "GET", host+"/status"+query, "", "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("wrong status code for status%s, exp %d, got %d", query, http.StatusOK, resp.StatusCode)
+		}
+		var status map[string]interface{}
+		if err := json.Unmarshal([]byte(mustReadBody(t, resp)), &status); err != nil {
+			t.Fatalf("failed to decode status: %s", err)
+		}
+		return status
+	}
+
+	status := getStatus("")
+	if v, ok := status["status_version"].(float64); !ok || int(v) != StatusVersion {
+		t.Fatalf("wrong status version, exp %d, got %v", StatusVersion, status["status_version"])
+	}
+	if _, ok := status["foo"]; ok {
+		t.Fatalf("reporter present at top level of versioned status")
+	}
+	reporters, ok := status["reporters"].(map[string]interface{})
+	if !ok || !reflect.DeepEqual(reporters["foo"], map[string]interface{}{"bar": "qux"}) {
+		t.Fatalf("reporter missing from versioned status: %v", status["reporters"])
+	}
+	metrics, ok := status["metrics"].(map[string]interface{})
+	if !ok || metrics["http"] == nil {
+		t.Fatalf("HTTP metrics missing from versioned status: %v", status["metrics"])
+	}
+	if _, ok := status["store"]; !ok {
+		t.Fatalf("store missing from versioned status")
+	}
+
+	legacy := getStatus("?version=1")
+	for _, k := range []string{"status_version", "reporters", "metrics"} {
+		if _, ok := legacy[k]; ok {
+			t.Fatalf("key %s present in legacy status", k)
+		}
+	}
+	if !reflect.DeepEqual(legacy["foo"], map[string]interface{}{"bar": "qux"}) {
+		t.Fatalf("reporter missing from legacy status: %v", legacy)
+	}
+
+	for _, q := range []string{"?version=0", "?version=3", "?version=x"} {
+		resp := mustDoRequest(t, "GET", host+"/status"+q, "", "")
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("wrong status code for status%s, exp %d, got %d", q, http.StatusBadRequest, resp.StatusCode)
+		}
+	}
+
+	s.StatusVersion = StatusVersionLegacy
+	if _, ok := getStatus("")["status_version"]; ok {
+		t.Fatalf("legacy status not served by default")
+	}
+	if _, ok := getStatus("?version=2")["status_version"]; !ok {
+		t.Fatalf("versioned status not served when requested")
+	}
+}
+
 func Test_FormRedirect(t *testing.T) {
 	m := &MockStore{}
 	c := &mockClusterService{}
@@ -993,6 +1115,263 @@ func Test_Readyz(t *testing.T) {
 
 }
 
+func Test_ReadyzLeader(t *testing.T) {
+	m := &MockStore{
+		leaderAddr: "foo:1234",
+	}
+	c := &mockClusterService{
+		apiAddr: "https://bar:5678",
+	}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	client := &http.Client{}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	resp, err := client.Get(host + "/readyz?leader")
+	if err != nil {
+		t.Fatalf("failed to make readyz request")
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("failed to get expected StatusServiceUnavailable for follower, got %d", resp.StatusCode)
+	}
+
+	m.isLeader = true
+	resp, err = client.Get(host + "/readyz?leader")
+	if err != nil {
+		t.Fatalf("failed to make readyz request")
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected StatusOK for leader, got %d", resp.StatusCode)
+	}
+
+	m.notReady = true
+	resp, err = client.Get(host + "/readyz?leader")
+	if err != nil {
+		t.Fatalf("failed to make readyz request")
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("failed to get expected StatusServiceUnavailable for unready leader, got %d", resp.StatusCode)
+	}
+}
+
+func Test_ReadyzQueueSaturated(t *testing.T) {
+	m := &MockStore{
+		leaderAddr: "foo:1234",
+	}
+	c := &mockClusterService{
+		apiAddr: "https://bar:5678",
+	}
+	s := New("127.0.0.1:0", m, c, nil)
+	queues := overload.NewQueues(map[string]overload.QueueSLO{
+		overload.QueueRaftApply: {MaxDepth: 1},
+	}, 0)
+	s.Queues = queues
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	for _, path := range []string{"/readyz", "/readyz?noleader"} {
+		resp := mustDoRequest(t, "GET", host+path, "", "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("wrong status code for %s with queues empty, exp %d, got %d", path, http.StatusOK, resp.StatusCode)
+		}
+	}
+
+	apply := queues.Queue(overload.QueueRaftApply)
+	leave1, leave2 := apply.Enter(), apply.Enter()
+	queues.Sample()
+	for _, path := range []string{"/readyz", "/readyz?noleader"} {
+		resp := mustDoRequest(t, "GET", host+path, "", "")
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("wrong status code for %s with queue saturated, exp %d, got %d", path, http.StatusServiceUnavailable, resp.StatusCode)
+		}
+		if body := mustReadBody(t, resp); !strings.HasSuffix(body, "[+]queue saturated: raft_apply depth 2 exceeds 1") {
+			t.Fatalf("wrong body for %s with queue saturated: %s", path, body)
+		}
+	}
+
+	leave1()
+	leave2()
+	queues.Sample()
+	resp := mustDoRequest(t, "GET", host+"/readyz", "", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status code with queues drained, exp %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+}
+
+func Test_QueryFormatNDJSON(t *testing.T) {
+	m := &MockStore{}
+	m.queryFn = func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+		return []*command.QueryRows{{
+			Columns: []string{"id"},
+			Types:   []string{"integer"},
+			Values: []*command.Values{
+				{Parameters: []*command.Parameter{{Value: &command.Parameter_I{I: 1}}}},
+				{Parameters: []*command.Parameter{{Value: &command.Parameter_I{I: 2}}}},
+			},
+		}}, nil
+	}
+	s := New("127.0.0.1:0", m, &mockClusterService{}, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	resp := mustDoRequest(t, "GET", host+"/db/query?format=ndjson&associative&q=SELECT%20id%20FROM%20foo", "", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status code, exp %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if exp, got := "application/x-ndjson", resp.Header.Get("Content-Type"); exp != got {
+		t.Fatalf("wrong content type, exp %s, got %s", exp, got)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read response body: %s", err)
+	}
+	exp := `{"columns":["id"],"statement":0,"types":["integer"]}
+{"row":{"id":1}}
+{"row":{"id":2}}
+`
+	if got := string(body); exp != got {
+		t.Fatalf("wrong response\nexp: %s\ngot: %s", exp, got)
+	}
+
+	resp = mustDoRequest(t, "GET", host+"/db/query?format=xml&q=SELECT%20id%20FROM%20foo", "", "")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("wrong status code for invalid format, exp %d, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+}
+
+func Test_QueryFormatCSV(t *testing.T) {
+	m := &MockStore{}
+	m.queryFn = func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+		return []*command.QueryRows{{
+			Columns: []string{"id", "name"},
+			Types:   []string{"integer", "text"},
+			Values: []*command.Values{
+				{Parameters: []*command.Parameter{{Value: &command.Parameter_I{I: 1}}, {Value: &command.Parameter_S{S: "fiona"}}}},
+				{Parameters: []*command.Parameter{{Value: &command.Parameter_I{I: 2}}, {Value: nil}}},
+			},
+		}}, nil
+	}
+	s := New("127.0.0.1:0", m, &mockClusterService{}, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	for _, tt := range []struct {
+		query  string
+		accept string
+		exp    string
+	}{
+		{"", "text/csv", "id,name\n1,fiona\n2,\n"},
+		{"format=csv&noheader&delimiter=tab&null=NULL", "", "1\tfiona\n2\tNULL\n"},
+	} {
+		req, err := http.NewRequest("GET", host+"/db/query?q=SELECT%20*%20FROM%20foo&"+tt.query, nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %s", err)
+		}
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to make request: %s", err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("failed to read response body: %s", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("wrong status code, exp %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		if exp, got := "text/csv; charset=utf-8", resp.Header.Get("Content-Type"); exp != got {
+			t.Fatalf("wrong content type, exp %s, got %s", exp, got)
+		}
+		if got := string(body); tt.exp != got {
+			t.Fatalf("wrong response\nexp: %q\ngot: %q", tt.exp, got)
+		}
+		if got := resp.Trailer.Get(CSVErrorTrailer); got != "" {
+			t.Fatalf("unexpected error trailer: %s", got)
+		}
+	}
+
+	resp := mustDoRequest(t, "GET", host+"/db/query?format=csv&delimiter=ab&q=SELECT%20id%20FROM%20foo", "", "")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("wrong status code for invalid delimiter, exp %d, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+}
+
+func Test_QueryFormatParquet(t *testing.T) {
+	m := &MockStore{}
+	m.queryFn = func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+		return []*command.QueryRows{{
+			Columns: []string{"id", "name"},
+			Types:   []string{"integer", "text"},
+			Values: []*command.Values{
+				{Parameters: []*command.Parameter{{Value: &command.Parameter_I{I: 1}}, {Value: &command.Parameter_S{S: "fiona"}}}},
+			},
+		}}, nil
+	}
+	s := New("127.0.0.1:0", m, &mockClusterService{}, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	for _, tt := range []struct {
+		query  string
+		accept string
+	}{
+		{"format=parquet", ""},
+		{"", "application/vnd.apache.parquet"},
+	} {
+		req, err := http.NewRequest("GET", host+"/db/query?q=SELECT%20*%20FROM%20foo&"+tt.query, nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %s", err)
+		}
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to make request: %s", err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("failed to read response body: %s", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("wrong status code, exp %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		if exp, got := "application/vnd.apache.parquet", resp.Header.Get("Content-Type"); exp != got {
+			t.Fatalf("wrong content type, exp %s, got %s", exp, got)
+		}
+		if !bytes.HasPrefix(body, []byte("PAR1")) || !bytes.HasSuffix(body, []byte("PAR1")) {
+			t.Fatalf("response is not a Parquet file: %q", body)
+		}
+		if got := resp.Trailer.Get(ParquetErrorTrailer); got != "" {
+			t.Fatalf("unexpected error trailer: %s", got)
+		}
+	}
+
+	resp := mustDoRequest(t, "POST", host+"/db/query?format=parquet", `["SELECT * FROM foo", "SELECT 1"]`, "")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("wrong status code for multiple statements, exp %d, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+}
+
 func Test_ForwardingRedirectQuery(t *testing.T) {
 	m := &MockStore{
 		leaderAddr: "foo:1234",
@@ -1177,208 +1556,2196 @@ func Test_ForwardingRedirectExecuteQuery(t *testing.T) {
 	}
 }
 
-func Test_timeoutQueryParam(t *testing.T) {
-	var req http.Request
+func Test_StatementPolicy(t *testing.T) {
+	m := &MockStore{}
+	n := &mockClusterService{}
+	s := New("127.0.0.1:0", m, n, nil)
 
-	defStr := "10s"
-	def := mustParseDuration(defStr)
-	tests := []struct {
-		u   string
-		dur string
-		err bool
-	}{
-		{
-			u:   "http://localhost:4001/nodes?timeout=5s",
-			dur: "5s",
-		},
-		{
-			u:   "http://localhost:4001/nodes?timeout=2m",
-			dur: "2m",
-		},
-		{
-			u:   "http://localhost:4001/nodes?x=777&timeout=5s",
-			dur: "5s",
-		},
-		{
-			u:   "http://localhost:4001/nodes",
-			dur: defStr,
-		},
-		{
-			u:   "http://localhost:4001/nodes?timeout=zdfjkh",
-			err: true,
+	var confirmedStmts []*command.Statement
+	s.Policy = &mockStatementPolicy{
+		checkFn: func(username string, stmts []*command.Statement, confirmed bool) error {
+			if username != "username1" {
+				t.Fatalf("wrong username passed to policy: %s", username)
+			}
+			if !confirmed {
+				return fmt.Errorf("confirmation required")
+			}
+			confirmedStmts = stmts
+			return nil
 		},
 	}
+	executeCalled := false
+	m.executeFn = func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+		executeCalled = true
+		return nil, nil
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
 
-	for _, tt := range tests {
-		req.URL = mustURLParse(tt.u)
-		timeout, err := timeoutParam(&req, def)
+	client := &http.Client{}
+	for _, path := range []string{
+		"/db/execute",
+		"/db/execute?queue&noleader",
+		"/db/query",
+		"/db/request",
+		"/db/load",
+	} {
+		req, err := http.NewRequest("POST", host+path, strings.NewReader(`["DROP TABLE foo"]`))
 		if err != nil {
-			if tt.err {
-				// Error is expected, all is OK.
-				continue
-			}
-			t.Fatalf("failed to get timeout as expected: %s", err)
+			t.Fatalf("failed to create request: %s", err.Error())
 		}
-		if timeout != mustParseDuration(tt.dur) {
-			t.Fatalf("got wrong timeout, expected %s, got %s", mustParseDuration(tt.dur), timeout)
+		req.SetBasicAuth("username1", "password1")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("failed to make request: %s", err.Error())
+		}
+		if resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("failed to get expected 403 for path %s, got %d", path, resp.StatusCode)
 		}
 	}
-}
+	if executeCalled {
+		t.Fatalf("execute called despite policy violation")
+	}
 
-type MockStore struct {
+	req, err := http.NewRequest("POST", host+"/db/execute?confirm", strings.NewReader(`["DROP TABLE foo"]`))
+	if err != nil {
+		t.Fatalf("failed to create request: %s", err.Error())
+	}
+	req.SetBasicAuth("username1", "password1")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("failed to make request: %s", err.Error())
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected 200 for confirmed request, got %d", resp.StatusCode)
+	}
+	if !executeCalled {
+		t.Fatalf("execute not called for confirmed request")
+	}
+	if len(confirmedStmts) != 1 || confirmedStmts[0].Sql != "DROP TABLE foo" {
+		t.Fatalf("wrong statements passed to policy: %v", confirmedStmts)
+	}
+}
+
+func Test_ApprovalExecute(t *testing.T) {
+	m := &MockStore{isLeader: true}
+	n := &mockClusterService{}
+	s := New("127.0.0.1:0", m, n, nil)
+	s.Policy = &mockStatementPolicy{
+		checkFn: func(username string, stmts []*command.Statement, confirmed bool) error {
+			return &mockApprovalError{}
+		},
+	}
+	executeCalled := false
+	m.executeFn = func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+		executeCalled = true
+		if er.Request.Statements[0].Sql != "DROP TABLE foo" {
+			t.Fatalf("wrong statement executed: %s", er.Request.Statements[0].Sql)
+		}
+		return nil, nil
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	resp := mustDoRequest(t, "POST", host+"/db/execute", `["DROP TABLE foo"]`, "alice")
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("failed to get expected 202, got %d", resp.StatusCode)
+	}
+	id := mustApprovalID(t, resp)
+	if executeCalled {
+		t.Fatalf("execute called before approval")
+	}
+
+	resp = mustDoRequest(t, "GET", host+"/approvals", "", "bob")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected 200 listing approvals, got %d", resp.StatusCode)
+	}
+	var list map[string][]map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatalf("failed to decode approvals: %s", err.Error())
+	}
+	if len(list["approvals"]) != 1 || list["approvals"][0]["id"] != id || list["approvals"][0]["requester"] != "alice" {
+		t.Fatalf("wrong approvals listed: %v", list)
+	}
+
+	resp = mustDoRequest(t, "POST", host+"/approvals/"+id, "", "alice")
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("failed to get expected 403 for self-approval, got %d", resp.StatusCode)
+	}
+	if executeCalled {
+		t.Fatalf("execute called after self-approval")
+	}
+
+	resp = mustDoRequest(t, "POST", host+"/approvals/"+id, "", "bob")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected 200 for approval, got %d", resp.StatusCode)
+	}
+	if !executeCalled {
+		t.Fatalf("execute not called after approval")
+	}
+
+	resp = mustDoRequest(t, "POST", host+"/approvals/"+id, "", "bob")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("failed to get expected 404 for second approval, got %d", resp.StatusCode)
+	}
+}
+
+func Test_ApprovalRemoveReject(t *testing.T) {
+	m := &MockStore{isLeader: true}
+	n := &mockClusterService{}
+	s := New("127.0.0.1:0", m, n, nil)
+	s.RemoveNeedsApproval = true
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	resp := mustDoRequest(t, "DELETE", host+"/remove", `{"id": "node1"}`, "alice")
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("failed to get expected 202, got %d", resp.StatusCode)
+	}
+	id := mustApprovalID(t, resp)
+
+	resp = mustDoRequest(t, "DELETE", host+"/approvals/"+id, "", "bob")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected 200 for rejection, got %d", resp.StatusCode)
+	}
+	resp = mustDoRequest(t, "POST", host+"/approvals/"+id, "", "bob")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("failed to get expected 404 for approval after rejection, got %d", resp.StatusCode)
+	}
+}
+
+func Test_ApprovalExpired(t *testing.T) {
+	m := &MockStore{isLeader: true}
+	n := &mockClusterService{}
+	s := New("127.0.0.1:0", m, n, nil)
+	s.RemoveNeedsApproval = true
+	s.ApprovalTimeout = time.Nanosecond
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	resp := mustDoRequest(t, "DELETE", host+"/remove", `{"id": "node1"}`, "alice")
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("failed to get expected 202, got %d", resp.StatusCode)
+	}
+	id := mustApprovalID(t, resp)
+
+	time.Sleep(time.Millisecond)
+	resp = mustDoRequest(t, "POST", host+"/approvals/"+id, "", "bob")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("failed to get expected 404 for expired approval, got %d", resp.StatusCode)
+	}
+}
+
+func Test_ApprovalRedirectLeader(t *testing.T) {
+	m := &MockStore{
+		leaderAddr: "foo:1234",
+	}
+	c := &mockClusterService{
+		apiAddr: "https://bar:5678",
+	}
+	s := New("127.0.0.1:0", m, c, nil)
+	s.RemoveNeedsApproval = true
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	req, err := http.NewRequest("DELETE", host+"/remove", strings.NewReader(`{"id": "node1"}`))
+	if err != nil {
+		t.Fatalf("failed to create request: %s", err.Error())
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("failed to make remove request: %s", err.Error())
+	}
+	if resp.StatusCode != http.StatusMovedPermanently {
+		t.Fatalf("failed to get expected StatusMovedPermanently for remove, got %d", resp.StatusCode)
+	}
+	if s.numPendingApprovals() != 0 {
+		t.Fatalf("operation held for approval on non-leader")
+	}
+
+	resp, err = client.Get(host + "/approvals")
+	if err != nil {
+		t.Fatalf("failed to make approvals request: %s", err.Error())
+	}
+	if resp.StatusCode != http.StatusMovedPermanently {
+		t.Fatalf("failed to get expected StatusMovedPermanently for approvals, got %d", resp.StatusCode)
+	}
+
+	c.apiAddr = ""
+	resp, err = client.Get(host + "/approvals")
+	if err != nil {
+		t.Fatalf("failed to make approvals request: %s", err.Error())
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("failed to get expected StatusServiceUnavailable for node with no leader, got %d", resp.StatusCode)
+	}
+}
+
+func Test_timeoutQueryParam(t *testing.T) {
+	var req http.Request
+
+	defStr := "10s"
+	def := mustParseDuration(defStr)
+	tests := []struct {
+		u   string
+		dur string
+		err bool
+	}{
+		{
+			u:   "http://localhost:4001/nodes?timeout=5s",
+			dur: "5s",
+		},
+		{
+			u:   "http://localhost:4001/nodes?timeout=2m",
+			dur: "2m",
+		},
+		{
+			u:   "http://localhost:4001/nodes?x=777&timeout=5s",
+			dur: "5s",
+		},
+		{
+			u:   "http://localhost:4001/nodes",
+			dur: defStr,
+		},
+		{
+			u:   "http://localhost:4001/nodes?timeout=zdfjkh",
+			err: true,
+		},
+	}
+
+	for _, tt := range tests {
+		req.URL = mustURLParse(tt.u)
+		timeout, err := timeoutParam(&req, def)
+		if err != nil {
+			if tt.err {
+				// Error is expected, all is OK.
+				continue
+			}
+			t.Fatalf("failed to get timeout as expected: %s", err)
+		}
+		if timeout != mustParseDuration(tt.dur) {
+			t.Fatalf("got wrong timeout, expected %s, got %s", mustParseDuration(tt.dur), timeout)
+		}
+	}
+}
+
+func Test_atomicityQueryParam(t *testing.T) {
+	tests := []struct {
+		u           string
+		tx          bool
+		stopOnError bool
+		err         bool
+	}{
+		{
+			u: "http://localhost:4001/db/request",
+		},
+		{
+			u:  "http://localhost:4001/db/request?transaction",
+			tx: true,
+		},
+		{
+			u:  "http://localhost:4001/db/request?atomicity=all",
+			tx: true,
+		},
+		{
+			u:  "http://localhost:4001/db/request?atomicity=all&transaction",
+			tx: true,
+		},
+		{
+			u: "http://localhost:4001/db/request?atomicity=statement",
+		},
+		{
+			u:           "http://localhost:4001/db/request?atomicity=STOP",
+			stopOnError: true,
+		},
+		{
+			u:   "http://localhost:4001/db/request?atomicity=stop&transaction",
+			err: true,
+		},
+		{
+			u:   "http://localhost:4001/db/request?atomicity=some",
+			err: true,
+		},
+	}
+
+	for _, tt := range tests {
+		req := &http.Request{URL: mustURLParse(tt.u)}
+		isTx, err := isTx(req)
+		if err != nil {
+			t.Fatalf("failed to get transaction param: %s", err)
+		}
+		tx, stopOnError, err := atomicity(req, isTx)
+		if err != nil {
+			if tt.err {
+				// Error is expected, all is OK.
+				continue
+			}
+			t.Fatalf("failed to get atomicity as expected for %s: %s", tt.u, err)
+		}
+		if tt.err {
+			t.Fatalf("expected error for %s", tt.u)
+		}
+		if tx != tt.tx || stopOnError != tt.stopOnError {
+			t.Fatalf("wrong atomicity for %s, got tx %v, stop on error %v", tt.u, tx, stopOnError)
+		}
+	}
+}
+
+func Test_levelQueryParam(t *testing.T) {
+	tests := []struct {
+		u   string
+		lvl command.QueryRequest_Level
+	}{
+		{"http://localhost:4001/db/query", command.QueryRequest_QUERY_REQUEST_LEVEL_WEAK},
+		{"http://localhost:4001/db/query?level=none", command.QueryRequest_QUERY_REQUEST_LEVEL_NONE},
+		{"http://localhost:4001/db/query?level=weak", command.QueryRequest_QUERY_REQUEST_LEVEL_WEAK},
+		{"http://localhost:4001/db/query?level=strong", command.QueryRequest_QUERY_REQUEST_LEVEL_STRONG},
+		{"http://localhost:4001/db/query?level=linearizable", command.QueryRequest_QUERY_REQUEST_LEVEL_LINEARIZABLE},
+		{"http://localhost:4001/db/query?level=LINEARIZABLE", command.QueryRequest_QUERY_REQUEST_LEVEL_LINEARIZABLE},
+	}
+
+	for _, tt := range tests {
+		lvl, err := level(&http.Request{URL: mustURLParse(tt.u)})
+		if err != nil {
+			t.Fatalf("failed to get level for %s: %s", tt.u, err)
+		}
+		if lvl != tt.lvl {
+			t.Fatalf("wrong level for %s, exp %s, got %s", tt.u, tt.lvl, lvl)
+		}
+	}
+}
+
+func Test_RequestAtomicity(t *testing.T) {
+	m := &MockStore{
+		leaderAddr: "foo:1234",
+	}
+	var got *command.Request
+	m.executeFn = func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+		got = er.Request
+		return nil, nil
+	}
+	m.requestFn = func(er *command.ExecuteQueryRequest) ([]*command.ExecuteQueryResponse, error) {
+		got = er.Request
+		return nil, nil
+	}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	for _, path := range []string{"/db/execute", "/db/request"} {
+		resp := mustDoRequest(t, "POST", host+path+"?atomicity=stop", `["INSERT INTO foo VALUES(1)"]`, "")
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("failed to get expected 200 for %s, got %d", path, resp.StatusCode)
+		}
+		if got == nil || !got.StopOnError || got.Transaction {
+			t.Fatalf("wrong atomicity passed to store for %s: %v", path, got)
+		}
+
+		resp = mustDoRequest(t, "POST", host+path+"?atomicity=stop&transaction", `["INSERT INTO foo VALUES(1)"]`, "")
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("failed to get expected 400 for %s, got %d", path, resp.StatusCode)
+		}
+	}
+}
+
+func Test_IdempotencyKey(t *testing.T) {
+	m := &MockStore{
+		leaderAddr: "foo:1234",
+	}
+	keys := make(chan string, 1)
+	m.executeFn = func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+		keys <- er.Request.IdempotencyKey
+		return nil, nil
+	}
+	m.requestFn = func(er *command.ExecuteQueryRequest) ([]*command.ExecuteQueryResponse, error) {
+		keys <- er.Request.IdempotencyKey
+		return nil, nil
+	}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	for _, path := range []string{"/db/execute", "/db/execute?queue", "/db/request"} {
+		req, err := http.NewRequest("POST", host+path, strings.NewReader(`["INSERT INTO foo VALUES(1)"]`))
+		if err != nil {
+			t.Fatalf("failed to create request: %s", err.Error())
+		}
+		req.Header.Set(IdempotencyKeyHTTPHeader, "key1")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to make request: %s", err.Error())
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("failed to get expected 200 for %s, got %d", path, resp.StatusCode)
+		}
+		select {
+		case key := <-keys:
+			if key != "key1" {
+				t.Fatalf("wrong idempotency key passed to store for %s: %q", path, key)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for write to %s", path)
+		}
+	}
+
+	resp := mustDoRequest(t, "POST", host+"/db/execute?idempotency_key=key2", `["INSERT INTO foo VALUES(1)"]`, "")
+	resp.Body.Close()
+	if key := <-keys; key != "key2" {
+		t.Fatalf("wrong idempotency key passed to store from param: %q", key)
+	}
+
+	resp = mustDoRequest(t, "POST", host+"/db/execute?idempotency_key="+strings.Repeat("k", 257), `["INSERT INTO foo VALUES(1)"]`, "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("failed to get expected 400 for long idempotency key, got %d", resp.StatusCode)
+	}
+}
+
+func Test_QueuedDurable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.wal")
+
+	// The first service never flushes its queue, so the write is still
+	// queued when the service is closed.
+	m := &MockStore{
+		leaderAddr: "foo:1234",
+	}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	s.DefaultQueueWAL = path
+	s.DefaultQueueBatchSz = 1024
+	s.DefaultQueueTimeout = time.Hour
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service: %s", err.Error())
+	}
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	resp := mustDoRequest(t, "POST", host+"/db/execute?queue", `["INSERT INTO foo VALUES(1)"]`, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected 200 for queued write, got %d", resp.StatusCode)
+	}
+	var qr struct {
+		SequenceNum int64 `json:"sequence_number"`
+	}
+	if err := json.Unmarshal([]byte(mustReadBody(t, resp)), &qr); err != nil || qr.SequenceNum == 0 {
+		t.Fatalf("failed to get sequence number from queued write response")
+	}
+	s.Close()
+
+	// The write is applied once the service is started again.
+	executed := make(chan *command.ExecuteRequest, 1)
+	m = &MockStore{
+		executeFn: func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+			executed <- er
+			return nil, nil
+		},
+	}
+	s = New("127.0.0.1:0", m, c, nil)
+	s.DefaultQueueWAL = path
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service: %s", err.Error())
+	}
+	defer s.Close()
+	select {
+	case er := <-executed:
+		if len(er.Request.Statements) != 1 || er.Request.Statements[0].Sql != "INSERT INTO foo VALUES(1)" {
+			t.Fatalf("wrong statements replayed: %v", er.Request.Statements)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for queued write to be replayed")
+	}
+}
+
+type MockStore struct {
 	executeFn   func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error)
 	queryFn     func(qr *command.QueryRequest) ([]*command.QueryRows, error)
 	requestFn   func(eqr *command.ExecuteQueryRequest) ([]*command.ExecuteQueryResponse, error)
 	backupFn    func(br *command.BackupRequest, dst io.Writer) error
 	loadChunkFn func(lr *command.LoadChunkRequest) error
+	joinFn      func(jr *command.JoinRequest) error
+	nodesFn     func() ([]*store.Server, error)
+	stats       map[string]interface{}
 	leaderAddr  string
+	isLeader    bool
 	notReady    bool // Default value is true, easier to test.
 }
 
-func (m *MockStore) Execute(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
-	if m.executeFn != nil {
-		return m.executeFn(er)
+func (m *MockStore) Execute(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+	if m.executeFn != nil {
+		return m.executeFn(er)
+	}
+	return nil, nil
+}
+
+func (m *MockStore) Query(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+	if m.queryFn != nil {
+		return m.queryFn(qr)
+	}
+	return nil, nil
+}
+
+func (m *MockStore) CheckInternal(stmts []*command.Statement) error {
+	for _, stmt := range stmts {
+		if strings.Contains(stmt.Sql, "_rqlite_") {
+			return db.ErrInternalTable
+		}
+	}
+	return nil
+}
+
+func (m *MockStore) QueryReserved(qr *command.QueryRequest) ([]*command.QueryRows, func(), error) {
+	rows, err := m.Query(qr)
+	return rows, func() {}, err
+}
+
+func (m *MockStore) Request(eqr *command.ExecuteQueryRequest) ([]*command.ExecuteQueryResponse, error) {
+	if m.requestFn != nil {
+		return m.requestFn(eqr)
+	}
+	return nil, nil
+}
+
+func (m *MockStore) RequestReserved(eqr *command.ExecuteQueryRequest) ([]*command.ExecuteQueryResponse, func(), error) {
+	results, err := m.Request(eqr)
+	return results, func() {}, err
+}
+
+func (m *MockStore) Join(jr *command.JoinRequest) error {
+	if m.joinFn != nil {
+		return m.joinFn(jr)
+	}
+	return nil
+}
+
+func (m *MockStore) Notify(nr *command.NotifyRequest) error {
+	return nil
+}
+
+func (m *MockStore) Remove(rn *command.RemoveNodeRequest) error {
+	return nil
+}
+
+func (m *MockStore) LeaderAddr() (string, error) {
+	return m.leaderAddr, nil
+}
+
+func (m *MockStore) IsLeader() bool {
+	return m.isLeader
+}
+
+func (m *MockStore) Ready() bool {
+	return !m.notReady
+}
+
+func (m *MockStore) Stats() (map[string]interface{}, error) {
+	return m.stats, nil
+}
+
+func (m *MockStore) Nodes() ([]*store.Server, error) {
+	if m.nodesFn != nil {
+		return m.nodesFn()
+	}
+	return nil, nil
+}
+
+func (m *MockStore) Backup(br *command.BackupRequest, w io.Writer) error {
+	if m.backupFn == nil {
+		return nil
+	}
+	return m.backupFn(br, w)
+}
+
+func (m *MockStore) LoadChunk(lc *command.LoadChunkRequest) error {
+	if m.loadChunkFn != nil {
+		return m.loadChunkFn(lc)
+	}
+	return nil
+}
+
+type mockClusterService struct {
+	apiAddr      string
+	executeFn    func(er *command.ExecuteRequest, addr string, t time.Duration) ([]*command.ExecuteResult, error)
+	queryFn      func(qr *command.QueryRequest, addr string, t time.Duration) ([]*command.QueryRows, error)
+	requestFn    func(eqr *command.ExecuteQueryRequest, nodeAddr string, timeout time.Duration) ([]*command.ExecuteQueryResponse, error)
+	backupFn     func(br *command.BackupRequest, addr string, t time.Duration, w io.Writer) error
+	loadChunkFn  func(lc *command.LoadChunkRequest, addr string, t time.Duration) error
+	removeNodeFn func(rn *command.RemoveNodeRequest, nodeAddr string, t time.Duration) error
+}
+
+func (m *mockClusterService) GetNodeAPIAddr(a string, t time.Duration) (string, error) {
+	return m.apiAddr, nil
+}
+
+func (m *mockClusterService) Execute(er *command.ExecuteRequest, addr string, creds *cluster.Credentials, t time.Duration) ([]*command.ExecuteResult, error) {
+	if m.executeFn != nil {
+		return m.executeFn(er, addr, t)
+	}
+	return nil, nil
+}
+
+func (m *mockClusterService) Query(qr *command.QueryRequest, addr string, creds *cluster.Credentials, t time.Duration) ([]*command.QueryRows, error) {
+	if m.queryFn != nil {
+		return m.queryFn(qr, addr, t)
+	}
+	return nil, nil
+}
+
+func (m *mockClusterService) Request(eqr *command.ExecuteQueryRequest, nodeAddr string, creds *cluster.Credentials, timeout time.Duration) ([]*command.ExecuteQueryResponse, error) {
+	if m.requestFn != nil {
+		return m.requestFn(eqr, nodeAddr, timeout)
+	}
+	return nil, nil
+}
+
+func (m *mockClusterService) Backup(br *command.BackupRequest, addr string, creds *cluster.Credentials, t time.Duration, w io.Writer) error {
+	if m.backupFn != nil {
+		return m.backupFn(br, addr, t, w)
+	}
+	return nil
+}
+
+func (m *mockClusterService) LoadChunk(lc *command.LoadChunkRequest, addr string, creds *cluster.Credentials, t time.Duration) error {
+	if m.loadChunkFn != nil {
+		return m.loadChunkFn(lc, addr, t)
+	}
+	return nil
+}
+
+func (m *mockClusterService) RemoveNode(rn *command.RemoveNodeRequest, addr string, creds *cluster.Credentials, t time.Duration) error {
+	if m.removeNodeFn != nil {
+		return m.removeNodeFn(rn, addr, t)
+	}
+	return nil
+}
+
+type mockCredentialStore struct {
+	HasPermOK bool
+	aaFunc    func(username, password, perm string) bool
+}
+
+func (m *mockCredentialStore) AA(username, password, perm string) bool {
+	if m == nil {
+		return true
+	}
+
+	if m.aaFunc != nil {
+		return m.aaFunc(username, password, perm)
+	}
+	return m.HasPermOK
+}
+
+func (m *mockClusterService) Stats() (map[string]interface{}, error) {
+	return nil, nil
+}
+
+func Test_OverloadShed(t *testing.T) {
+	m := &MockStore{}
+	n := &mockClusterService{}
+	s := New("127.0.0.1:0", m, n, nil)
+
+	var priorities []string
+	s.Overload = &mockOverloadController{
+		admitFn: func(priority string) error {
+			priorities = append(priorities, priority)
+			if priority == overload.PriorityLow {
+				return fmt.Errorf("node overloaded")
+			}
+			return nil
+		},
+	}
+	m.queryFn = func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+		return nil, nil
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	for _, tt := range []struct {
+		priority string
+		code     int
+	}{
+		{"", http.StatusOK},
+		{"high", http.StatusOK},
+		{"low", http.StatusServiceUnavailable},
+		{"bogus", http.StatusBadRequest},
+	} {
+		req, err := http.NewRequest("GET", host+"/db/query?q=SELECT%20*%20FROM%20foo", nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %s", err.Error())
+		}
+		if tt.priority != "" {
+			req.Header.Set(PriorityHTTPHeader, tt.priority)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to make request: %s", err.Error())
+		}
+		if resp.StatusCode != tt.code {
+			t.Fatalf("wrong status code for priority %q, exp %d, got %d", tt.priority, tt.code, resp.StatusCode)
+		}
+		if tt.code == http.StatusServiceUnavailable && resp.Header.Get("Retry-After") != "2" {
+			t.Fatalf("wrong Retry-After header: %s", resp.Header.Get("Retry-After"))
+		}
+	}
+	// Without a priority assigned to the user, the header cannot raise priority.
+	if exp := []string{"normal", "normal", "low"}; !reflect.DeepEqual(priorities, exp) {
+		t.Fatalf("wrong priorities passed to controller, exp %v, got %v", exp, priorities)
+	}
+}
+
+func Test_PriorityPools(t *testing.T) {
+	m := &MockStore{}
+	n := &mockClusterService{}
+	s := New("127.0.0.1:0", m, n, nil)
+
+	var acquired []string
+	released := 0
+	s.Pools = &mockConcurrencyPools{
+		acquireFn: func(name string) (func(), error) {
+			acquired = append(acquired, name)
+			if name == overload.PriorityHigh {
+				return nil, fmt.Errorf("concurrency limit reached")
+			}
+			return func() { released++ }, nil
+		},
+	}
+	s.UserPriorities = map[string]string{"analyst": overload.PriorityLow, "app": overload.PriorityHigh}
+	m.queryFn = func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+		return nil, nil
+	}
+	m.executeFn = func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+		return nil, nil
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	for _, tt := range []struct {
+		username string
+		priority string
+		path     string
+		code     int
+	}{
+		{"someone", "", "/db/query", http.StatusOK},
+		{"analyst", "", "/db/query", http.StatusOK},
+		{"analyst", "high", "/db/execute", http.StatusOK},
+		{"app", "normal", "/db/request", http.StatusOK},
+		{"app", "", "/db/query", http.StatusServiceUnavailable},
+	} {
+		req, err := http.NewRequest("POST", host+tt.path, strings.NewReader(`["SELECT * FROM foo"]`))
+		if err != nil {
+			t.Fatalf("failed to create request: %s", err.Error())
+		}
+		req.SetBasicAuth(tt.username, "password")
+		if tt.priority != "" {
+			req.Header.Set(PriorityHTTPHeader, tt.priority)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to make request: %s", err.Error())
+		}
+		if resp.StatusCode != tt.code {
+			t.Fatalf("wrong status code for user %s on %s, exp %d, got %d", tt.username, tt.path, tt.code, resp.StatusCode)
+		}
+	}
+	if exp := []string{"normal", "low", "low", "normal", "high"}; !reflect.DeepEqual(acquired, exp) {
+		t.Fatalf("wrong pools acquired, exp %v, got %v", exp, acquired)
+	}
+	if released != 4 {
+		t.Fatalf("wrong number of pool slots released, exp 4, got %d", released)
+	}
+}
+
+func Test_EndpointLimits(t *testing.T) {
+	m := &MockStore{}
+	n := &mockClusterService{}
+	s := New("127.0.0.1:0", m, n, nil)
+
+	var acquired []string
+	s.EndpointPools = &mockConcurrencyPools{
+		acquireFn: func(name string) (func(), error) {
+			acquired = append(acquired, name)
+			if name == EndpointBackup || name == EndpointLoad {
+				return nil, fmt.Errorf("concurrency limit reached")
+			}
+			return func() {}, nil
+		},
+	}
+	m.queryFn = func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+		return nil, nil
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	for _, tt := range []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{"GET", "/db/backup", http.StatusServiceUnavailable},
+		{"POST", "/db/load", http.StatusServiceUnavailable},
+		{"POST", "/db/query", http.StatusOK},
+	} {
+		resp := mustDoRequest(t, tt.method, host+tt.path, `["SELECT * FROM foo"]`, "")
+		if resp.StatusCode != tt.code {
+			t.Fatalf("wrong status code for %s, exp %d, got %d", tt.path, tt.code, resp.StatusCode)
+		}
+	}
+	if exp := []string{EndpointBackup, EndpointLoad, EndpointQuery}; !reflect.DeepEqual(acquired, exp) {
+		t.Fatalf("wrong endpoint pools acquired, exp %v, got %v", exp, acquired)
+	}
+}
+
+func Test_ChangeFeed(t *testing.T) {
+	m := &MockStore{}
+	n := &mockClusterService{}
+	s := New("127.0.0.1:0", m, n, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	resp := mustDoRequest(t, "GET", host+"/db/changes", "", "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("wrong status code with change feed disabled, exp %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	feed := &mockChangeFeed{}
+	s.ChangeFeed = feed
+	feed.changesFn = func(since uint64, max int) ([]*store.Change, uint64, error) {
+		if since == 1 {
+			return nil, 0, store.ErrChangesUnavailable
+		}
+		if max != 7 {
+			t.Fatalf("wrong max, exp 7, got %d", max)
+		}
+		return []*store.Change{{Index: 5, Data: []byte("x")}}, 6, nil
+	}
+	resp = mustDoRequest(t, "GET", host+"/db/changes?since=4&max=7", "", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status code, exp %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read body: %s", err)
+	}
+	if got, exp := string(body), `{"changes":[{"index":5,"data":"eA=="}],"next":6}`; got != exp {
+		t.Fatalf("wrong changes, exp %s, got %s", exp, got)
+	}
+	resp = mustDoRequest(t, "GET", host+"/db/changes?since=1", "", "")
+	if resp.StatusCode != http.StatusGone {
+		t.Fatalf("wrong status code for unavailable changes, exp %d, got %d", http.StatusGone, resp.StatusCode)
+	}
+
+	feed.backupFn = func(dst io.Writer) (uint64, error) {
+		_, err := dst.Write([]byte("database"))
+		return 42, err
+	}
+	resp = mustDoRequest(t, "GET", host+"/db/changes/snapshot", "", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status code for snapshot, exp %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if got := resp.Header.Get(standby.IndexHTTPHeader); got != "42" {
+		t.Fatalf("wrong snapshot index, exp 42, got %s", got)
+	}
+
+	var fencedBy string
+	feed.fenceFn = func(id string) error {
+		fencedBy = id
+		return nil
+	}
+	resp = mustDoRequest(t, "POST", host+"/db/fence", "", "")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("wrong status code for fence without id, exp %d, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+	resp = mustDoRequest(t, "POST", host+"/db/fence?id=standby-1", "", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status code for fence, exp %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if fencedBy != "standby-1" {
+		t.Fatalf("wrong fence id, exp standby-1, got %s", fencedBy)
+	}
+}
+
+func Test_StandbyPromote(t *testing.T) {
+	m := &MockStore{}
+	n := &mockClusterService{}
+	s := New("127.0.0.1:0", m, n, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	resp := mustDoRequest(t, "POST", host+"/standby/promote", "", "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("wrong status code when not a standby, exp %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	var forced []bool
+	s.Standby = &mockStandbyConsumer{
+		promoteFn: func(force bool) error {
+			forced = append(forced, force)
+			if !force {
+				return fmt.Errorf("failed to fence primary")
+			}
+			return nil
+		},
+	}
+	resp = mustDoRequest(t, "POST", host+"/standby/promote", "", "")
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("wrong status code for failed promotion, exp %d, got %d", http.StatusServiceUnavailable, resp.StatusCode)
+	}
+	resp = mustDoRequest(t, "POST", host+"/standby/promote?force", "", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status code for forced promotion, exp %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if exp := []bool{false, true}; !reflect.DeepEqual(forced, exp) {
+		t.Fatalf("wrong force values, exp %v, got %v", exp, forced)
+	}
+}
+
+func Test_SchemaVersion(t *testing.T) {
+	m := &MockStore{}
+	s := New("127.0.0.1:0", m, &mockClusterService{}, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	resp := mustDoRequest(t, "GET", host+"/db/schema", "", "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("wrong status code when schema notifications not enabled, exp %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+	if resp.Header.Get(SchemaVersionHTTPHeader) != "" {
+		t.Fatalf("schema version header set when schema notifications not enabled")
+	}
+
+	s.Schema = &mockSchemaNotifier{
+		version: 3,
+		waitFn: func(version int64, timeout time.Duration) int64 {
+			if version != 3 || timeout != 2*time.Second {
+				t.Errorf("wrong wait for schema change, version %d, timeout %s", version, timeout)
+			}
+			return 4
+		},
+	}
+	resp = mustDoRequest(t, "GET", host+"/status", "", "")
+	if got := resp.Header.Get(SchemaVersionHTTPHeader); got != "3" {
+		t.Fatalf("wrong schema version header, exp 3, got %s", got)
+	}
+	resp = mustDoRequest(t, "GET", host+"/db/schema", "", "")
+	if body := mustReadBody(t, resp); body != `{"version":3}` {
+		t.Fatalf("wrong schema version response: %s", body)
+	}
+	resp = mustDoRequest(t, "GET", host+"/db/schema?version=3&timeout=2s", "", "")
+	if got := resp.Header.Get(SchemaVersionHTTPHeader); got != "4" {
+		t.Fatalf("wrong schema version header after change, exp 4, got %s", got)
+	}
+	if body := mustReadBody(t, resp); body != `{"version":4}` {
+		t.Fatalf("wrong schema version response after change: %s", body)
+	}
+	for _, q := range []string{"version=x", "version=3&timeout=1h"} {
+		resp = mustDoRequest(t, "GET", host+"/db/schema?"+q, "", "")
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("wrong status code for %s, exp %d, got %d", q, http.StatusBadRequest, resp.StatusCode)
+		}
+	}
+}
+
+func Test_Archive(t *testing.T) {
+	s := New("127.0.0.1:0", &MockStore{}, &mockClusterService{}, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	resp := mustDoRequest(t, "POST", host+"/db/archive", `{"table": "foo", "where": "id < 3"}`, "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("wrong status code when archive not enabled, exp %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	var archived string
+	s.Archive = &mockArchiver{
+		archiveFn: func(table, where string) (int64, error) {
+			archived = table + " " + where
+			return 2, nil
+		},
+	}
+	resp = mustDoRequest(t, "POST", host+"/db/archive", `{"table": "foo", "where": "id < 3"}`, "")
+	if body := mustReadBody(t, resp); body != `{"rows":2}` {
+		t.Fatalf("wrong response to archive request: %s", body)
+	}
+	if archived != "foo id < 3" {
+		t.Fatalf("wrong rows archived: %s", archived)
+	}
+	resp = mustDoRequest(t, "POST", host+"/db/archive", `{"table": "foo"}`, "")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("wrong status code for archive without condition, exp %d, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+	resp = mustDoRequest(t, "GET", host+"/db/archive", "", "")
+	if body := mustReadBody(t, resp); body != "archive" {
+		t.Fatalf("wrong copy of archive: %s", body)
+	}
+}
+
+func Test_Partitions(t *testing.T) {
+	s := New("127.0.0.1:0", &MockStore{}, &mockClusterService{}, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	resp := mustDoRequest(t, "GET", host+"/db/partitions", "", "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("wrong status code when partitioning not enabled, exp %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	p := &mockPartitioner{}
+	s.Partitions = p
+	resp = mustDoRequest(t, "GET", host+"/db/partitions", "", "")
+	if body := mustReadBody(t, resp); body != `{"partition_sets":[]}` {
+		t.Fatalf("wrong response listing no partition sets: %s", body)
+	}
+
+	resp = mustDoRequest(t, "POST", host+"/db/partitions",
+		`{"name": "metrics", "columns": "ts INTEGER, value REAL", "time_column": "ts", "interval": 3600, "ahead": 1}`, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status code creating partition set, exp %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	resp = mustDoRequest(t, "GET", host+"/db/partitions", "", "")
+	exp := `{"partition_sets":[{"name":"metrics","columns":"ts INTEGER, value REAL","time_column":"ts","interval":3600,"ahead":1,"partitions":["metrics_p0"]}]}`
+	if body := mustReadBody(t, resp); body != exp {
+		t.Fatalf("wrong response listing partition sets\nexp: %s\ngot: %s", exp, body)
+	}
+
+	resp = mustDoRequest(t, "POST", host+"/db/partitions", `{"name":`, "")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("wrong status code for invalid partition set, exp %d, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+	resp = mustDoRequest(t, "DELETE", host+"/db/partitions?name=foo", "", "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("wrong status code dropping unknown partition set, exp %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+	resp = mustDoRequest(t, "DELETE", host+"/db/partitions?name=metrics", "", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status code dropping partition set, exp %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if len(p.sets) != 0 {
+		t.Fatalf("partition set not dropped")
+	}
+}
+
+func Test_RollingRestart(t *testing.T) {
+	m := &MockStore{
+		nodesFn: func() ([]*store.Server, error) {
+			return []*store.Server{
+				{ID: "1", Addr: "localhost:4002"},
+				{ID: "2", Addr: "localhost:4004"},
+			}, nil
+		},
+	}
+	n := &mockClusterService{}
+	s := New("127.0.0.1:0", m, n, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	resp := mustDoRequest(t, "POST", host+"/restart", "", "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("wrong status code when restarts not supported, exp %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	var started [][]cluster.RestartNode
+	s.Restarter = &mockRollingRestarter{
+		startFn: func(nodes []cluster.RestartNode, creds *cluster.Credentials) error {
+			if len(started) > 0 {
+				return cluster.ErrRestartInProgress
+			}
+			started = append(started, nodes)
+			return nil
+		},
+	}
+	resp = mustDoRequest(t, "POST", host+"/restart", "", "")
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("wrong status code for rolling restart, exp %d, got %d", http.StatusAccepted, resp.StatusCode)
+	}
+	exp := []cluster.RestartNode{{ID: "1", Addr: "localhost:4002"}, {ID: "2", Addr: "localhost:4004"}}
+	if len(started) != 1 || !reflect.DeepEqual(started[0], exp) {
+		t.Fatalf("wrong nodes restarted, exp %v, got %v", exp, started)
+	}
+	resp = mustDoRequest(t, "POST", host+"/restart", "", "")
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("wrong status code for restart in progress, exp %d, got %d", http.StatusConflict, resp.StatusCode)
+	}
+	resp = mustDoRequest(t, "GET", host+"/restart", "", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status code for restart status, exp %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	resp = mustDoRequest(t, "DELETE", host+"/restart", "", "")
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("wrong status code for bad method, exp %d, got %d", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+}
+
+func Test_Sandbox(t *testing.T) {
+	m := &MockStore{}
+	n := &mockClusterService{}
+	s := New("127.0.0.1:0", m, n, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	resp := mustDoRequest(t, "GET", host+"/db/sandbox?q=SELECT+1", "", "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("wrong status code when sandbox not supported, exp %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	var got *command.QueryRequest
+	var sbErr error
+	s.Sandbox = &mockSandbox{
+		queryFn: func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+			got = qr
+			if sbErr != nil {
+				return nil, sbErr
+			}
+			return []*command.QueryRows{{Columns: []string{"1"}, Types: []string{"integer"}}}, nil
+		},
+	}
+	resp = mustDoRequest(t, "POST", host+"/db/sandbox?level=weak", `["SELECT 1", "SELECT 2"]`, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status code for sandbox query, exp %d, got %d: %s", http.StatusOK, resp.StatusCode, mustReadBody(t, resp))
+	}
+	if len(got.Request.Statements) != 2 || got.Level != command.QueryRequest_QUERY_REQUEST_LEVEL_WEAK {
+		t.Fatalf("wrong query request passed to sandbox: %v", got)
+	}
+	if body := mustReadBody(t, resp); !strings.Contains(body, `"columns":["1"]`) {
+		t.Fatalf("unexpected response body: %s", body)
+	}
+
+	sbErr = store.ErrSandboxStrong
+	resp = mustDoRequest(t, "GET", host+"/db/sandbox?q=SELECT+1&level=strong", "", "")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("wrong status code for strong sandbox query, exp %d, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+
+	resp = mustDoRequest(t, "PUT", host+"/db/sandbox", "", "")
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("wrong status code for PUT, exp %d, got %d", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+}
+
+func Test_NonDeterministicPolicy(t *testing.T) {
+	m := &MockStore{
+		leaderAddr: "foo:1234",
+	}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	var executed []*command.Statement
+	m.executeFn = func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+		executed = er.Request.Statements
+		return nil, nil
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	body := `["INSERT INTO foo(id, ts, b) VALUES(1, datetime('now'), randomblob(4))"]`
+
+	// By default, non-deterministic writes are executed as they are.
+	resp := mustDoRequest(t, "POST", host+"/db/execute", body, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected 200, got %d", resp.StatusCode)
+	}
+	if h := resp.Header.Get(NonDeterministicHTTPHeader); h != "" {
+		t.Fatalf("unexpected non-deterministic header: %s", h)
+	}
+
+	s.NonDeterministic = NonDeterministicWarn
+	resp = mustDoRequest(t, "POST", host+"/db/execute", body, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected 200, got %d", resp.StatusCode)
+	}
+	if exp, got := "datetime('now'), randomblob()", resp.Header.Get(NonDeterministicHTTPHeader); exp != got {
+		t.Fatalf("wrong non-deterministic header, exp %s, got %s", exp, got)
+	}
+
+	s.NonDeterministic = NonDeterministicRewrite
+	resp = mustDoRequest(t, "POST", host+"/db/execute", body, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected 200, got %d", resp.StatusCode)
+	}
+	if exp, got := "randomblob()", resp.Header.Get(NonDeterministicHTTPHeader); exp != got {
+		t.Fatalf("wrong non-deterministic header, exp %s, got %s", exp, got)
+	}
+	if strings.Contains(executed[0].Sql, "'now'") {
+		t.Fatalf("current time not rewritten: %s", executed[0].Sql)
+	}
+
+	s.NonDeterministic = NonDeterministicReject
+	executed = nil
+	for _, path := range []string{"/db/execute", "/db/execute?queue", "/db/request"} {
+		resp = mustDoRequest(t, "POST", host+path, body, "")
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("failed to get expected 400 for path %s, got %d", path, resp.StatusCode)
+		}
+	}
+	if executed != nil {
+		t.Fatalf("execute called for rejected write")
+	}
+	resp = mustDoRequest(t, "POST", host+"/db/execute", `["INSERT INTO foo(id) VALUES(RANDOM())"]`, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected 200 for rewritten RANDOM, got %d", resp.StatusCode)
+	}
+	if exp, got := "3", stats.Get(numNonDeterministicRejected).String(); exp != got {
+		t.Fatalf("wrong number of rejected writes, exp %s, got %s", exp, got)
+	}
+}
+
+func Test_QueryMirror(t *testing.T) {
+	rows := []*command.QueryRows{{Columns: []string{"id"}, Types: []string{"integer"}}}
+	queryErr := false
+	m := &MockStore{
+		leaderAddr: "foo:1234",
+		queryFn: func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+			if queryErr {
+				return nil, fmt.Errorf("query failed")
+			}
+			return rows, nil
+		},
+	}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	mirror := &mockQueryMirror{}
+	s.Mirror = mirror
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	resp := mustDoRequest(t, "POST", host+"/db/query?level=none", `["SELECT id FROM foo"]`, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected 200, got %d", resp.StatusCode)
+	}
+	if exp, got := 1, len(mirror.qrs); exp != got {
+		t.Fatalf("expected %d mirrored queries, got %d", exp, got)
+	}
+	if exp, got := "SELECT id FROM foo", mirror.qrs[0].Request.Statements[0].Sql; exp != got {
+		t.Fatalf("wrong query mirrored, exp %s, got %s", exp, got)
+	}
+	if mirror.qrs[0].Level != command.QueryRequest_QUERY_REQUEST_LEVEL_NONE {
+		t.Fatalf("wrong level mirrored: %s", mirror.qrs[0].Level)
+	}
+	if len(mirror.results[0]) != 1 || mirror.results[0][0] != rows[0] {
+		t.Fatalf("wrong results mirrored")
+	}
+
+	// Queries which fail are not mirrored.
+	queryErr = true
+	mustDoRequest(t, "POST", host+"/db/query", `["SELECT id FROM foo"]`, "")
+	if exp, got := 1, len(mirror.qrs); exp != got {
+		t.Fatalf("expected %d mirrored queries, got %d", exp, got)
+	}
+}
+
+func Test_QueryResultsFormat(t *testing.T) {
+	m := &MockStore{
+		leaderAddr: "foo:1234",
+		queryFn: func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+			return []*command.QueryRows{{
+				Columns: []string{"id", "name", "age"},
+				Types:   []string{"integer", "text", "integer"},
+				Values: []*command.Values{{
+					Parameters: []*command.Parameter{
+						{Value: &command.Parameter_I{I: 1}},
+						{Value: nil},
+						{Value: &command.Parameter_I{I: 20}},
+					},
+				}},
+			}}, nil
+		},
+	}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	for _, tt := range []struct {
+		params string
+		exp    string
+	}{
+		{
+			params: "",
+			exp:    `{"results":[{"columns":["id","name","age"],"types":["integer","text","integer"],"values":[[1,null,20]]}]}`,
+		},
+		{
+			params: "columns=name:n,id",
+			exp:    `{"results":[{"columns":["n","id"],"types":["text","integer"],"values":[[null,1]]}]}`,
+		},
+		{
+			params: "columns=name,age&nulls=empty",
+			exp:    `{"results":[{"columns":["name","age"],"types":["text","integer"],"values":[["",20]]}]}`,
+		},
+		{
+			params: "associative&nulls=omit",
+			exp:    `{"results":[{"types":{"age":"integer","id":"integer","name":"text"},"rows":[{"age":20,"id":1}]}]}`,
+		},
+	} {
+		resp := mustDoRequest(t, "GET", host+"/db/query?q=SELECT+*+FROM+foo&"+tt.params, "", "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("failed to get expected 200 for %s, got %d", tt.params, resp.StatusCode)
+		}
+		if got := mustReadBody(t, resp); tt.exp != got {
+			t.Fatalf("wrong response for %s\nexp: %s\ngot: %s", tt.params, tt.exp, got)
+		}
+	}
+
+	for _, params := range []string{"columns=", "columns=id,name:id", "nulls=zero"} {
+		resp := mustDoRequest(t, "GET", host+"/db/query?q=SELECT+*+FROM+foo&"+params, "", "")
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("failed to get expected 400 for %s, got %d", params, resp.StatusCode)
+		}
+	}
+}
+
+func Test_QueryMetadata(t *testing.T) {
+	rows := func(metadata bool) *command.QueryRows {
+		r := &command.QueryRows{
+			Columns: []string{"id"},
+			Types:   []string{"integer"},
+			Values: []*command.Values{{
+				Parameters: []*command.Parameter{{Value: &command.Parameter_I{I: 1}}},
+			}},
+		}
+		if metadata {
+			r.Metadata = []*command.ColumnMetadata{
+				{Table: "foo", Column: "id", DeclType: "INTEGER", NotNull: true, PrimaryKey: true},
+			}
+		}
+		return r
+	}
+	m := &MockStore{
+		leaderAddr: "foo:1234",
+		queryFn: func(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+			return []*command.QueryRows{rows(qr.Metadata)}, nil
+		},
+		requestFn: func(er *command.ExecuteQueryRequest) ([]*command.ExecuteQueryResponse, error) {
+			return []*command.ExecuteQueryResponse{{
+				Result: &command.ExecuteQueryResponse_Q{Q: rows(er.Metadata)},
+			}}, nil
+		},
+	}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	exp := `{"results":[{"columns":["id"],"types":["integer"],` +
+		`"metadata":[{"table":"foo","column":"id","decl_type":"INTEGER","nullable":false,"primary_key":true}],` +
+		`"values":[[1]]}]}`
+	for _, path := range []string{"/db/query", "/db/request"} {
+		resp := mustDoRequest(t, "POST", host+path+"?metadata", `["SELECT id FROM foo"]`, "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("failed to get expected 200 for %s, got %d", path, resp.StatusCode)
+		}
+		if got := mustReadBody(t, resp); exp != got {
+			t.Fatalf("wrong response for %s\nexp: %s\ngot: %s", path, exp, got)
+		}
+	}
+
+	resp := mustDoRequest(t, "GET", host+"/db/query?q=SELECT+id+FROM+foo", "", "")
+	if exp, got := `{"results":[{"columns":["id"],"types":["integer"],"values":[[1]]}]}`, mustReadBody(t, resp); exp != got {
+		t.Fatalf("wrong response without metadata\nexp: %s\ngot: %s", exp, got)
+	}
+}
+
+func Test_ExecuteProtobuf(t *testing.T) {
+	var stmts []*command.Statement
+	m := &MockStore{
+		leaderAddr: "foo:1234",
+		executeFn: func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+			stmts = er.Request.Statements
+			return []*command.ExecuteResult{{LastInsertId: 1, RowsAffected: 1}}, nil
+		},
+	}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	blob := []byte{0x00, 0x01, 0xfe, 0xff}
+	b, err := proto.Marshal(&command.Request{
+		Statements: []*command.Statement{{
+			Sql:        "INSERT INTO foo(data) VALUES(?)",
+			Parameters: []*command.Parameter{{Value: &command.Parameter_Y{Y: blob}}},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal request: %s", err.Error())
+	}
+	resp, err := http.Post(host+"/db/execute", ProtobufContentType, bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("failed to make execute request: %s", err.Error())
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get expected 200, got %d", resp.StatusCode)
+	}
+	if exp, got := `{"results":[{"last_insert_id":1,"rows_affected":1}]}`, mustReadBody(t, resp); exp != got {
+		t.Fatalf("wrong response, exp %s, got %s", exp, got)
+	}
+	if len(stmts) != 1 || !bytes.Equal(stmts[0].Parameters[0].GetY(), blob) {
+		t.Fatalf("wrong statements executed: %v", stmts)
+	}
+
+	// A JSON body is not accepted as Protocol Buffers.
+	resp, err = http.Post(host+"/db/execute", ProtobufContentType, strings.NewReader(`["INSERT INTO foo(data) VALUES(1)"]`))
+	if err != nil {
+		t.Fatalf("failed to make execute request: %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("failed to get expected 400, got %d", resp.StatusCode)
+	}
+}
+
+func Test_JoinSnapshot(t *testing.T) {
+	m := &MockStore{
+		leaderAddr: "foo:1234",
+	}
+	c := &mockClusterService{
+		apiAddr: "http://1.2.3.4:999",
+	}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	resp := mustDoRequest(t, "GET", host+"/join/snapshot", "", "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("wrong status code when join snapshots not supported, exp %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	var snapErr error
+	s.Snapshots = &mockSnapshotSource{
+		latestFn: func() (*raft.SnapshotMeta, io.ReadCloser, error) {
+			if snapErr != nil {
+				return nil, nil, snapErr
+			}
+			return &raft.SnapshotMeta{ID: "2-18-1686659761026", Index: 18, Term: 2, Size: 8},
+				io.NopCloser(strings.NewReader("snapshot")), nil
+		},
+	}
+
+	// Only the Leader serves its snapshot.
+	client := &http.Client{}
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	resp, err := client.Get(host + "/join/snapshot")
+	if err != nil {
+		t.Fatalf("failed to make join snapshot request: %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMovedPermanently {
+		t.Fatalf("wrong status code from follower, exp %d, got %d", http.StatusMovedPermanently, resp.StatusCode)
+	}
+	if exp, got := "http://1.2.3.4:999/join/snapshot", resp.Header.Get("location"); exp != got {
+		t.Fatalf("wrong redirect location, exp %s, got %s", exp, got)
+	}
+
+	m.isLeader = true
+	resp = mustDoRequest(t, "GET", host+"/join/snapshot", "", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status code for join snapshot, exp %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if exp, got := `{"Version":0,"ID":"2-18-1686659761026","Index":18,"Term":2,"Peers":null,"Configuration":{"Servers":null},"ConfigurationIndex":0,"Size":8}`,
+		resp.Header.Get(cluster.SnapshotMetaHeader); exp != got {
+		t.Fatalf("wrong snapshot meta header, exp %s, got %s", exp, got)
+	}
+	if exp, got := "snapshot", mustReadBody(t, resp); exp != got {
+		t.Fatalf("wrong snapshot data, exp %s, got %s", exp, got)
+	}
+
+	snapErr = store.ErrNoSnapshot
+	resp = mustDoRequest(t, "GET", host+"/join/snapshot", "", "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("wrong status code when no snapshot, exp %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	resp = mustDoRequest(t, "POST", host+"/join/snapshot", "", "")
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("wrong status code for POST, exp %d, got %d", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+}
+
+func Test_SnapshotExport(t *testing.T) {
+	m := &MockStore{}
+	c := &mockClusterService{}
+	s := New("127.0.0.1:0", m, c, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	resp := mustDoRequest(t, "GET", host+"/snapshots/latest", "", "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("wrong status code when snapshot export not supported, exp %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	var snapErr error
+	s.Snapshots = &mockSnapshotSource{
+		latestDBFn: func() (*raft.SnapshotMeta, io.ReadCloser, error) {
+			if snapErr != nil {
+				return nil, nil, snapErr
+			}
+			return &raft.SnapshotMeta{ID: "2-18-1686659761026", Index: 18, Term: 2, Size: 8},
+				io.NopCloser(strings.NewReader("database")), nil
+		},
+	}
+
+	// Any node serves its own snapshot.
+	resp = mustDoRequest(t, "GET", host+"/snapshots/latest", "", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status code for snapshot export, exp %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if exp, got := "2-18-1686659761026", resp.Header.Get(SnapshotIDHTTPHeader); exp != got {
+		t.Fatalf("wrong snapshot ID header, exp %s, got %s", exp, got)
+	}
+	if exp, got := "18", resp.Header.Get(SnapshotIndexHTTPHeader); exp != got {
+		t.Fatalf("wrong snapshot index header, exp %s, got %s", exp, got)
+	}
+	if exp, got := "database", mustReadBody(t, resp); exp != got {
+		t.Fatalf("wrong snapshot data, exp %s, got %s", exp, got)
+	}
+
+	resp = mustDoRequest(t, "GET", host+"/snapshots/latest?compress", "", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status code for compressed snapshot export, exp %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if exp, got := "application/gzip", resp.Header.Get("Content-Type"); exp != got {
+		t.Fatalf("wrong content type, exp %s, got %s", exp, got)
+	}
+	gr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to create gzip reader: %s", err.Error())
+	}
+	b, err := io.ReadAll(gr)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("failed to decompress snapshot data: %s", err.Error())
+	}
+	if exp, got := "database", string(b); exp != got {
+		t.Fatalf("wrong snapshot data, exp %s, got %s", exp, got)
+	}
+
+	snapErr = store.ErrNoSnapshot
+	resp = mustDoRequest(t, "GET", host+"/snapshots/latest", "", "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("wrong status code when no snapshot, exp %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	resp = mustDoRequest(t, "POST", host+"/snapshots/latest", "", "")
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("wrong status code for POST, exp %d, got %d", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+}
+
+func Test_Resync(t *testing.T) {
+	m := &MockStore{}
+	n := &mockClusterService{}
+	s := New("127.0.0.1:0", m, n, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	resp := mustDoRequest(t, "POST", host+"/db/resync", "", "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("wrong status code when resync not supported, exp %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	resyncs := 0
+	leader := false
+	s.Resyncer = &mockNodeResyncer{
+		resyncFn: func(creds *cluster.Credentials) error {
+			if leader {
+				return cluster.ErrResyncLeader
+			}
+			if resyncs > 0 {
+				return cluster.ErrResyncInProgress
+			}
+			resyncs++
+			return nil
+		},
+	}
+	resp = mustDoRequest(t, "POST", host+"/db/resync", "", "")
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("wrong status code for resync, exp %d, got %d", http.StatusAccepted, resp.StatusCode)
+	}
+	if resyncs != 1 {
+		t.Fatalf("resync not started")
+	}
+	resp = mustDoRequest(t, "POST", host+"/db/resync", "", "")
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("wrong status code for resync in progress, exp %d, got %d", http.StatusConflict, resp.StatusCode)
+	}
+	leader = true
+	resp = mustDoRequest(t, "POST", host+"/db/resync", "", "")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("wrong status code for resync of leader, exp %d, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+	resp = mustDoRequest(t, "GET", host+"/db/resync", "", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status code for resync status, exp %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+}
+
+func Test_Jobs(t *testing.T) {
+	m := &MockStore{}
+	n := &mockClusterService{}
+	s := New("127.0.0.1:0", m, n, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	resp := mustDoRequest(t, "GET", host+"/jobs", "", "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("wrong status code when jobs not supported, exp %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	s.Jobs = &mockStatusReporter{map[string]interface{}{"owner": "node1"}}
+	resp = mustDoRequest(t, "GET", host+"/jobs", "", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status code for jobs status, exp %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read response body: %s", err)
+	}
+	if exp, got := `{"owner":"node1"}`, strings.TrimSpace(string(body)); exp != got {
+		t.Fatalf("wrong jobs status, exp %s, got %s", exp, got)
+	}
+	resp = mustDoRequest(t, "POST", host+"/jobs", "", "")
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("wrong status code for POST, exp %d, got %d", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+}
+
+type mockWriteBatcher struct {
+	windows map[string]time.Duration
+}
+
+func (m *mockWriteBatcher) SetBatchWindow(domain string, window time.Duration) error {
+	if domain != store.BatchWrites {
+		return store.ErrUnknownBatchDomain
+	}
+	m.windows[domain] = window
+	return nil
+}
+
+func (m *mockWriteBatcher) BatchStats() map[string]interface{} {
+	st := make(map[string]interface{})
+	for d, w := range m.windows {
+		st[d] = map[string]interface{}{"window": w.String()}
+	}
+	return st
+}
+
+func Test_Batching(t *testing.T) {
+	m := &MockStore{}
+	n := &mockClusterService{}
+	s := New("127.0.0.1:0", m, n, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	resp := mustDoRequest(t, "GET", host+"/batching", "", "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("wrong status code when batching not supported, exp %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	s.Batching = &mockWriteBatcher{windows: map[string]time.Duration{store.BatchWrites: 0}}
+	resp = mustDoRequest(t, "PUT", host+"/batching", `{"writes": "2ms"}`, "")
+	if exp, got := `{"writes":{"window":"2ms"}}`, strings.TrimSpace(mustReadBody(t, resp)); exp != got {
+		t.Fatalf("wrong batching stats after setting window, exp %s, got %s", exp, got)
+	}
+	resp = mustDoRequest(t, "GET", host+"/batching", "", "")
+	if exp, got := `{"writes":{"window":"2ms"}}`, strings.TrimSpace(mustReadBody(t, resp)); exp != got {
+		t.Fatalf("wrong batching stats, exp %s, got %s", exp, got)
+	}
+
+	for _, body := range []string{`{"writes": "x"}`, `{"reads": "1ms"}`, `nonsense`} {
+		resp = mustDoRequest(t, "PUT", host+"/batching", body, "")
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("wrong status code for %s, exp %d, got %d", body, http.StatusBadRequest, resp.StatusCode)
+		}
+	}
+	resp = mustDoRequest(t, "POST", host+"/batching", "", "")
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("wrong status code for POST, exp %d, got %d", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+}
+
+func Test_ClusterEvents(t *testing.T) {
+	m := &MockStore{}
+	n := &mockClusterService{}
+	s := New("127.0.0.1:0", m, n, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	resp := mustDoRequest(t, "GET", host+"/cluster/events", "", "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("wrong status code when cluster events not supported, exp %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	el := &mockClusterEventLog{}
+	s.Events = el
+	resp = mustDoRequest(t, "GET", host+"/cluster/events?since=5&limit=2", "", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status code for cluster events, exp %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read response body: %s", err)
+	}
+	if exp, got := `{"events":[{"id":6,"time":"2024-01-02T03:04:05Z","type":"leader_change","node_id":"node1","message":"node node1 became leader"}]}`,
+		strings.TrimSpace(string(body)); exp != got {
+		t.Fatalf("wrong cluster events, exp %s, got %s", exp, got)
+	}
+	if el.since != 5 || el.limit != 2 {
+		t.Fatalf("wrong since or limit passed, got %d and %d", el.since, el.limit)
+	}
+
+	resp = mustDoRequest(t, "GET", host+"/cluster/events", "", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status code for cluster events, exp %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if el.since != 0 || el.limit != defaultMaxClusterEvents {
+		t.Fatalf("wrong default since or limit passed, got %d and %d", el.since, el.limit)
+	}
+
+	for _, q := range []string{"since=-1", "limit=0", "limit=x"} {
+		resp = mustDoRequest(t, "GET", host+"/cluster/events?"+q, "", "")
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("wrong status code for %s, exp %d, got %d", q, http.StatusBadRequest, resp.StatusCode)
+		}
+	}
+	resp = mustDoRequest(t, "POST", host+"/cluster/events", "", "")
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("wrong status code for POST, exp %d, got %d", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+
+	el.err = store.ErrClusterEventsDisabled
+	resp = mustDoRequest(t, "GET", host+"/cluster/events", "", "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("wrong status code when cluster events disabled, exp %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+}
+
+type mockClusterEventLog struct {
+	since int64
+	limit int
+	err   error
+}
+
+func (m *mockClusterEventLog) ClusterEvents(since int64, limit int) ([]store.ClusterEvent, error) {
+	m.since, m.limit = since, limit
+	if m.err != nil {
+		return nil, m.err
 	}
-	return nil, nil
+	return []store.ClusterEvent{{
+		ID:      since + 1,
+		Time:    time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Type:    store.EventLeaderChange,
+		NodeID:  "node1",
+		Message: "node node1 became leader",
+	}}, nil
 }
 
-func (m *MockStore) Query(qr *command.QueryRequest) ([]*command.QueryRows, error) {
-	if m.queryFn != nil {
-		return m.queryFn(qr)
+func Test_ReplaceNode(t *testing.T) {
+	m := &MockStore{}
+	n := &mockClusterService{}
+	s := New("127.0.0.1:0", m, n, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	body := `{"old_id": "1", "new_id": "4", "new_addr": "localhost:4008", "zone": "a"}`
+
+	resp := mustDoRequest(t, "POST", host+"/replace", body, "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("wrong status code when replacement not supported, exp %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	var started []cluster.Replacement
+	s.Replacer = &mockNodeReplacer{
+		startFn: func(rp cluster.Replacement) error {
+			if len(started) > 0 {
+				return cluster.ErrReplaceInProgress
+			}
+			started = append(started, rp)
+			return nil
+		},
+	}
+	resp = mustDoRequest(t, "POST", host+"/replace", `{"old_id": "1"}`, "")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("wrong status code for incomplete replacement, exp %d, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+	resp = mustDoRequest(t, "POST", host+"/replace", body, "")
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("wrong status code for replacement, exp %d, got %d", http.StatusAccepted, resp.StatusCode)
+	}
+	exp := cluster.Replacement{OldID: "1", NewID: "4", NewAddr: "localhost:4008", Zone: "a"}
+	if len(started) != 1 || started[0] != exp {
+		t.Fatalf("wrong replacement started, exp %v, got %v", exp, started)
+	}
+	resp = mustDoRequest(t, "POST", host+"/replace", body, "")
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("wrong status code for replacement in progress, exp %d, got %d", http.StatusConflict, resp.StatusCode)
+	}
+	resp = mustDoRequest(t, "GET", host+"/replace", "", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status code for replacement status, exp %d, got %d", http.StatusOK, resp.StatusCode)
 	}
-	return nil, nil
 }
 
-func (m *MockStore) Request(eqr *command.ExecuteQueryRequest) ([]*command.ExecuteQueryResponse, error) {
-	if m.requestFn != nil {
-		return m.requestFn(eqr)
+func Test_ReplaceNodeApproval(t *testing.T) {
+	m := &MockStore{isLeader: true}
+	n := &mockClusterService{}
+	s := New("127.0.0.1:0", m, n, nil)
+	s.RemoveNeedsApproval = true
+	var started []cluster.Replacement
+	s.Replacer = &mockNodeReplacer{
+		startFn: func(rp cluster.Replacement) error {
+			started = append(started, rp)
+			return nil
+		},
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+	body := `{"old_id": "1", "new_id": "4", "new_addr": "localhost:4008"}`
+
+	resp := mustDoRequest(t, "POST", host+"/replace", body, "alice")
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("wrong status code for replacement needing approval, exp %d, got %d", http.StatusAccepted, resp.StatusCode)
+	}
+	id := mustApprovalID(t, resp)
+	if len(started) != 0 {
+		t.Fatalf("replacement started before approval")
+	}
+
+	resp = mustDoRequest(t, "POST", host+"/approvals/"+id, "", "bob")
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("wrong status code for approved replacement, exp %d, got %d", http.StatusAccepted, resp.StatusCode)
+	}
+	if len(started) != 1 || started[0].OldID != "1" {
+		t.Fatalf("approved replacement not started: %v", started)
 	}
-	return nil, nil
 }
 
-func (m *MockStore) Join(jr *command.JoinRequest) error {
-	return nil
+type mockStatementPolicy struct {
+	checkFn func(username string, stmts []*command.Statement, confirmed bool) error
 }
 
-func (m *MockStore) Notify(nr *command.NotifyRequest) error {
+func (m *mockStatementPolicy) Check(username string, stmts []*command.Statement, confirmed bool) error {
+	if m.checkFn != nil {
+		return m.checkFn(username, stmts, confirmed)
+	}
 	return nil
 }
 
-func (m *MockStore) Remove(rn *command.RemoveNodeRequest) error {
+type mockOverloadController struct {
+	admitFn func(priority string) error
+}
+
+func (m *mockOverloadController) Admit(priority string, done <-chan struct{}) error {
+	if m.admitFn != nil {
+		return m.admitFn(priority)
+	}
 	return nil
 }
 
-func (m *MockStore) LeaderAddr() (string, error) {
-	return m.leaderAddr, nil
+func (m *mockOverloadController) ObserveApply(d time.Duration) {}
+
+func (m *mockOverloadController) ObserveQuery(d time.Duration) {}
+
+func (m *mockOverloadController) RetryAfter() time.Duration {
+	return 1500 * time.Millisecond
 }
 
-func (m *MockStore) Ready() bool {
-	return !m.notReady
+type mockConcurrencyPools struct {
+	acquireFn func(name string) (func(), error)
 }
 
-func (m *MockStore) Stats() (map[string]interface{}, error) {
-	return nil, nil
+func (m *mockConcurrencyPools) Acquire(name string, done <-chan struct{}) (func(), error) {
+	if m.acquireFn != nil {
+		return m.acquireFn(name)
+	}
+	return func() {}, nil
 }
 
-func (m *MockStore) Nodes() ([]*store.Server, error) {
-	return nil, nil
+type mockChangeFeed struct {
+	changesFn func(since uint64, max int) ([]*store.Change, uint64, error)
+	backupFn  func(dst io.Writer) (uint64, error)
+	fenceFn   func(id string) error
 }
 
-func (m *MockStore) Backup(br *command.BackupRequest, w io.Writer) error {
-	if m.backupFn == nil {
-		return nil
+func (m *mockChangeFeed) Changes(since uint64, max int) ([]*store.Change, uint64, error) {
+	if m.changesFn != nil {
+		return m.changesFn(since, max)
 	}
-	return m.backupFn(br, w)
+	return nil, since, nil
 }
 
-func (m *MockStore) LoadChunk(lc *command.LoadChunkRequest) error {
-	if m.loadChunkFn != nil {
-		return m.loadChunkFn(lc)
+func (m *mockChangeFeed) BackupWithIndex(dst io.Writer) (uint64, error) {
+	if m.backupFn != nil {
+		return m.backupFn(dst)
+	}
+	return 0, nil
+}
+
+func (m *mockChangeFeed) Fence(id string) error {
+	if m.fenceFn != nil {
+		return m.fenceFn(id)
 	}
 	return nil
 }
 
-type mockClusterService struct {
-	apiAddr      string
-	executeFn    func(er *command.ExecuteRequest, addr string, t time.Duration) ([]*command.ExecuteResult, error)
-	queryFn      func(qr *command.QueryRequest, addr string, t time.Duration) ([]*command.QueryRows, error)
-	requestFn    func(eqr *command.ExecuteQueryRequest, nodeAddr string, timeout time.Duration) ([]*command.ExecuteQueryResponse, error)
-	backupFn     func(br *command.BackupRequest, addr string, t time.Duration, w io.Writer) error
-	loadChunkFn  func(lc *command.LoadChunkRequest, addr string, t time.Duration) error
-	removeNodeFn func(rn *command.RemoveNodeRequest, nodeAddr string, t time.Duration) error
+func (m *mockChangeFeed) Fenced() (bool, string) {
+	return false, ""
 }
 
-func (m *mockClusterService) GetNodeAPIAddr(a string, t time.Duration) (string, error) {
-	return m.apiAddr, nil
+type mockStandbyConsumer struct {
+	promoteFn func(force bool) error
 }
 
-func (m *mockClusterService) Execute(er *command.ExecuteRequest, addr string, creds *cluster.Credentials, t time.Duration) ([]*command.ExecuteResult, error) {
-	if m.executeFn != nil {
-		return m.executeFn(er, addr, t)
+func (m *mockStandbyConsumer) Promote(force bool) error {
+	if m.promoteFn != nil {
+		return m.promoteFn(force)
 	}
-	return nil, nil
+	return nil
 }
 
-func (m *mockClusterService) Query(qr *command.QueryRequest, addr string, creds *cluster.Credentials, t time.Duration) ([]*command.QueryRows, error) {
-	if m.queryFn != nil {
-		return m.queryFn(qr, addr, t)
-	}
-	return nil, nil
+type mockArchiver struct {
+	archiveFn func(table, where string) (int64, error)
 }
 
-func (m *mockClusterService) Request(eqr *command.ExecuteQueryRequest, nodeAddr string, creds *cluster.Credentials, timeout time.Duration) ([]*command.ExecuteQueryResponse, error) {
-	if m.requestFn != nil {
-		return m.requestFn(eqr, nodeAddr, timeout)
+func (m *mockArchiver) ArchiveRows(table, where string) (int64, error) {
+	return m.archiveFn(table, where)
+}
+
+func (m *mockArchiver) BackupArchive(dst io.Writer) error {
+	_, err := dst.Write([]byte("archive"))
+	return err
+}
+
+type mockPartitioner struct {
+	sets []store.PartitionSet
+}
+
+func (m *mockPartitioner) CreatePartitionSet(ps store.PartitionSet) error {
+	ps.Partitions = []string{ps.Name + "_p0"}
+	m.sets = append(m.sets, ps)
+	return nil
+}
+
+func (m *mockPartitioner) DropPartitionSet(name string) error {
+	for i := range m.sets {
+		if m.sets[i].Name == name {
+			m.sets = append(m.sets[:i], m.sets[i+1:]...)
+			return nil
+		}
 	}
-	return nil, nil
+	return store.ErrPartitionSetNotFound
 }
 
-func (m *mockClusterService) Backup(br *command.BackupRequest, addr string, creds *cluster.Credentials, t time.Duration, w io.Writer) error {
-	if m.backupFn != nil {
-		return m.backupFn(br, addr, t, w)
+func (m *mockPartitioner) PartitionSets() ([]store.PartitionSet, error) {
+	return m.sets, nil
+}
+
+type mockSchemaNotifier struct {
+	version int64
+	waitFn  func(version int64, timeout time.Duration) int64
+}
+
+func (m *mockSchemaNotifier) SchemaVersion() int64 {
+	return m.version
+}
+
+func (m *mockSchemaNotifier) WaitSchemaChange(version int64, timeout time.Duration, done <-chan struct{}) int64 {
+	return m.waitFn(version, timeout)
+}
+
+type mockRollingRestarter struct {
+	startFn func(nodes []cluster.RestartNode, creds *cluster.Credentials) error
+}
+
+func (m *mockRollingRestarter) Start(nodes []cluster.RestartNode, creds *cluster.Credentials) error {
+	if m.startFn != nil {
+		return m.startFn(nodes, creds)
 	}
 	return nil
 }
 
-func (m *mockClusterService) LoadChunk(lc *command.LoadChunkRequest, addr string, creds *cluster.Credentials, t time.Duration) error {
-	if m.loadChunkFn != nil {
-		return m.loadChunkFn(lc, addr, t)
+func (m *mockRollingRestarter) Stats() (map[string]interface{}, error) {
+	return map[string]interface{}{"running": false}, nil
+}
+
+type mockNodeReplacer struct {
+	startFn func(rp cluster.Replacement) error
+}
+
+func (m *mockNodeReplacer) Start(rp cluster.Replacement) error {
+	if m.startFn != nil {
+		return m.startFn(rp)
 	}
 	return nil
 }
 
-func (m *mockClusterService) RemoveNode(rn *command.RemoveNodeRequest, addr string, creds *cluster.Credentials, t time.Duration) error {
-	if m.removeNodeFn != nil {
-		return m.removeNodeFn(rn, addr, t)
+func (m *mockNodeReplacer) Stats() (map[string]interface{}, error) {
+	return map[string]interface{}{"running": false}, nil
+}
+
+type mockSandbox struct {
+	queryFn func(qr *command.QueryRequest) ([]*command.QueryRows, error)
+}
+
+func (m *mockSandbox) QuerySandboxed(qr *command.QueryRequest) ([]*command.QueryRows, func(), error) {
+	rows, err := m.queryFn(qr)
+	return rows, func() {}, err
+}
+
+type mockSnapshotSource struct {
+	latestFn   func() (*raft.SnapshotMeta, io.ReadCloser, error)
+	latestDBFn func() (*raft.SnapshotMeta, io.ReadCloser, error)
+	listFn     func() ([]*raft.SnapshotMeta, error)
+}
+
+func (m *mockSnapshotSource) LatestSnapshot() (*raft.SnapshotMeta, io.ReadCloser, error) {
+	return m.latestFn()
+}
+
+func (m *mockSnapshotSource) LatestSnapshotDB() (*raft.SnapshotMeta, io.ReadCloser, error) {
+	return m.latestDBFn()
+}
+
+func (m *mockSnapshotSource) Snapshots() ([]*raft.SnapshotMeta, error) {
+	return m.listFn()
+}
+
+type mockQueryMirror struct {
+	qrs     []*command.QueryRequest
+	results [][]*command.QueryRows
+}
+
+func (m *mockQueryMirror) Mirror(qr *command.QueryRequest, results []*command.QueryRows) {
+	m.qrs = append(m.qrs, qr)
+	m.results = append(m.results, results)
+}
+
+type mockNodeResyncer struct {
+	resyncFn func(creds *cluster.Credentials) error
+}
+
+func (m *mockNodeResyncer) Resync(creds *cluster.Credentials) error {
+	if m.resyncFn != nil {
+		return m.resyncFn(creds)
 	}
 	return nil
 }
 
-type mockCredentialStore struct {
-	HasPermOK bool
-	aaFunc    func(username, password, perm string) bool
+func (m *mockNodeResyncer) Stats() (map[string]interface{}, error) {
+	return map[string]interface{}{"running": false}, nil
 }
 
-func (m *mockCredentialStore) AA(username, password, perm string) bool {
-	if m == nil {
-		return true
-	}
+type mockApprovalError struct{}
 
-	if m.aaFunc != nil {
-		return m.aaFunc(username, password, perm)
-	}
-	return m.HasPermOK
+func (m *mockApprovalError) Error() string {
+	return "approval required"
 }
 
-func (m *mockClusterService) Stats() (map[string]interface{}, error) {
-	return nil, nil
+func (m *mockApprovalError) ApprovalRequired() bool {
+	return true
 }
 
 type mockStatusReporter struct {
+	stats map[string]interface{}
 }
 
 func (m *mockStatusReporter) Stats() (map[string]interface{}, error) {
-	return nil, nil
+	return m.stats, nil
 }
 
 func mustNewHTTPRequest(url string) *http.Request {
@@ -1389,6 +3756,43 @@ func mustNewHTTPRequest(url string) *http.Request {
 	return req
 }
 
+func mustDoRequest(t *testing.T, method, url, body, username string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to create request: %s", err.Error())
+	}
+	req.SetBasicAuth(username, "password")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to make request: %s", err.Error())
+	}
+	return resp
+}
+
+func mustReadBody(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read response body: %s", err.Error())
+	}
+	return string(body)
+}
+
+func mustApprovalID(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	var m map[string]map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		t.Fatalf("failed to decode approval: %s", err.Error())
+	}
+	id, ok := m["approval"]["id"].(string)
+	if !ok || id == "" {
+		t.Fatalf("no approval ID in response: %v", m)
+	}
+	return id
+}
+
 func mustURLParse(s string) *url.URL {
 	u, err := url.Parse(s)
 	if err != nil {
@@ -1419,3 +3823,116 @@ func mustGunzip(b []byte) []byte {
 
 	return dec
 }
+
+func Test_TableStats(t *testing.T) {
+	s := New("127.0.0.1:0", &MockStore{}, &mockClusterService{}, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	resp := mustDoRequest(t, "GET", host+"/db/stats", "", "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("wrong status code when table stats not enabled, exp %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	since := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	ts := &mockTableAccess{
+		reads: map[string]db.TableReads{
+			"foo":    {Reads: 3, RowsReturned: 10, LastRead: since.Add(time.Hour)},
+			"unused": {},
+		},
+		since: since,
+	}
+	s.TableStats = ts
+	resp = mustDoRequest(t, "GET", host+"/db/stats", "", "")
+	exp := `{"since":"2024-03-01T12:00:00Z","tables":{"foo":{"last_read":"2024-03-01T13:00:00Z","reads":3,"rows_returned":10},"unused":{"reads":0,"rows_returned":0}}}`
+	if body := mustReadBody(t, resp); body != exp {
+		t.Fatalf("wrong table stats response, exp %s, got %s", exp, body)
+	}
+
+	resp = mustDoRequest(t, "DELETE", host+"/db/stats", "", "")
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("wrong status code for reset, exp %d, got %d", http.StatusNoContent, resp.StatusCode)
+	}
+	if !ts.reset {
+		t.Fatalf("table stats not reset")
+	}
+	resp = mustDoRequest(t, "POST", host+"/db/stats", "", "")
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("wrong status code for POST, exp %d, got %d", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+}
+
+type mockTableAccess struct {
+	reads map[string]db.TableReads
+	since time.Time
+	reset bool
+}
+
+func (m *mockTableAccess) TableReads() (map[string]db.TableReads, time.Time, error) {
+	return m.reads, m.since, nil
+}
+
+func (m *mockTableAccess) ResetTableReads() {
+	m.reset = true
+}
+
+func Test_Validate(t *testing.T) {
+	s := New("127.0.0.1:0", &MockStore{}, &mockClusterService{}, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	body := `["SELECT * FROM foo", "INSERT INTO bar(ts) VALUES(datetime('now'))"]`
+	resp := mustDoRequest(t, "POST", host+"/db/validate", body, "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("wrong status code when validation not supported, exp %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	v := &mockValidator{
+		vals: []*db.Validation{
+			{ReadOnly: true},
+			{NonDeterministic: []string{"datetime('now')"}},
+		},
+	}
+	s.Validator = v
+	resp = mustDoRequest(t, "POST", host+"/db/validate", body, "")
+	exp := `{"results":[{"read_only":true},{"read_only":false,"non_deterministic":["datetime('now')"]}],"valid":true}`
+	if got := mustReadBody(t, resp); got != exp {
+		t.Fatalf("wrong validate response, exp %s, got %s", exp, got)
+	}
+	if len(v.stmts) != 2 || v.stmts[1].Sql != "INSERT INTO bar(ts) VALUES(datetime('now'))" {
+		t.Fatalf("wrong statements validated: %v", v.stmts)
+	}
+
+	s.NonDeterministic = NonDeterministicReject
+	resp = mustDoRequest(t, "POST", host+"/db/validate", body, "")
+	exp = `{"results":[{"read_only":true},{"error":"non-deterministic write rejected, uses datetime('now')","read_only":false,"non_deterministic":["datetime('now')"]}],"valid":false}`
+	if got := mustReadBody(t, resp); got != exp {
+		t.Fatalf("wrong validate response, exp %s, got %s", exp, got)
+	}
+
+	resp = mustDoRequest(t, "DELETE", host+"/db/validate", "", "")
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("wrong status code for DELETE, exp %d, got %d", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+}
+
+type mockValidator struct {
+	vals  []*db.Validation
+	stmts []*command.Statement
+}
+
+func (m *mockValidator) Validate(req *command.Request) ([]*db.Validation, error) {
+	m.stmts = req.Statements
+	vals := make([]*db.Validation, len(m.vals))
+	for i := range m.vals {
+		v := *m.vals[i]
+		vals[i] = &v
+	}
+	return vals, nil
+}
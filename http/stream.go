@@ -0,0 +1,283 @@
+package http
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/rqlite/rqlite/command"
+	"github.com/rqlite/rqlite/command/encoding"
+	"github.com/rqlite/rqlite/db"
+)
+
+// streamBufferSize is the size of the buffer in which streamed query results
+// are accumulated before being written to the client.
+const streamBufferSize = 64 * 1024
+
+// StreamQuerier is the interface a Store must implement for the results of
+// queries to be streamed to clients as they are read.
+type StreamQuerier interface {
+	// QueryStream executes queries that return rows, writing the results to
+	// w as they are read.
+	QueryStream(qr *command.QueryRequest, w db.RowsWriter) error
+}
+
+// queryStreamer is a db.RowsWriter which writes the results of queries to
+// the client in some format.
+type queryStreamer interface {
+	db.RowsWriter
+
+	// hasStarted returns whether anything has been written to the client.
+	hasStarted() bool
+
+	// finish ends the response, with the error which ended the queries, if
+	// any, and the time taken, if not zero.
+	finish(err error, t float64) error
+}
+
+// formatWriter is a queryStreamer which writes results in a format other
+// than JSON. Since the results of a query are written in that format even if
+// they could not be streamed, it can also write results held in memory.
+type formatWriter interface {
+	queryStreamer
+
+	// writeResults writes results which were not streamed, as if they
+	// had been.
+	writeResults(results []*command.QueryRows) error
+}
+
+// canStreamJSON returns whether the results of queries may be streamed to
+// the client as JSON, which is not possible if the results must be rendered
+// in ways queryStreamWriter does not support.
+func canStreamJSON(r *http.Request) bool {
+	if pretty, _ := isPretty(r); pretty {
+		return false
+	}
+	if proj, nulls, _ := resultsFormat(r); proj != nil || nulls != encoding.NullsAsNull {
+		return false
+	}
+	if metadata, _ := isMetadata(r); metadata {
+		return false
+	}
+	return true
+}
+
+// streamQuery streams the results of qr to the client using sw, so that the
+// first rows reach the client while later rows are still being read. It
+// returns false if the results were not streamed, because the store does not
+// support it, or because the query failed before any results were written,
+// in which case the query should be served as usual. The response is the same
+// as if it were not streamed.
+func (s *Service) streamQuery(r *http.Request, qr *command.QueryRequest, sw queryStreamer, start time.Time) bool {
+	sq, ok := s.store.(StreamQuerier)
+	if !ok || qr.Level == command.QueryRequest_QUERY_REQUEST_LEVEL_STRONG {
+		return false
+	}
+
+	err := sq.QueryStream(qr, sw)
+	if err != nil && !sw.hasStarted() {
+		return false
+	}
+	stats.Add(numQueryStreams, 1)
+
+	var t float64
+	if timings, _ := isTimings(r); timings {
+		t = time.Since(start).Seconds()
+	}
+	if err := sw.finish(err, t); err != nil {
+		s.logger.Println("writing response failed:", err.Error())
+	}
+	return true
+}
+
+// queryStreamWriter is a db.RowsWriter which writes the results of queries
+// as JSON, in the same form as a Response holding them.
+type queryStreamWriter struct {
+	w     http.ResponseWriter
+	bw    *bufio.Writer
+	assoc bool
+
+	started bool
+	nStmts  int
+	inStmt  bool
+	nFields int
+	nRows   int
+	columns []string
+	err     error
+}
+
+func newQueryStreamWriter(w http.ResponseWriter, assoc bool) *queryStreamWriter {
+	return &queryStreamWriter{
+		w:     w,
+		bw:    bufio.NewWriterSize(w, streamBufferSize),
+		assoc: assoc,
+	}
+}
+
+// WriteColumns implements db.RowsWriter.
+func (q *queryStreamWriter) WriteColumns(columns, types []string) error {
+	q.openStmt()
+	q.columns = columns
+	if q.assoc {
+		m := make(map[string]string, len(types))
+		for i := range types {
+			m[columns[i]] = types[i]
+		}
+		if len(m) > 0 {
+			q.field("types", m)
+		}
+		return q.err
+	}
+	if len(columns) > 0 {
+		q.field("columns", columns)
+	}
+	if len(types) > 0 {
+		q.field("types", types)
+	}
+	return q.err
+}
+
+// WriteRow implements db.RowsWriter.
+func (q *queryStreamWriter) WriteRow(values *command.Values) error {
+	q.openStmt()
+	row := make([][]interface{}, 1)
+	if err := encoding.NewValuesFromQueryValues(row, []*command.Values{values}); err != nil {
+		return err
+	}
+	var v interface{} = row[0]
+	if q.assoc {
+		m := make(map[string]interface{}, len(q.columns))
+		for i, c := range q.columns {
+			m[c] = row[0][i]
+		}
+		v = m
+	}
+
+	if q.nRows == 0 {
+		if q.assoc {
+			q.fieldName("rows")
+		} else {
+			q.fieldName("values")
+		}
+		q.write("[")
+	} else {
+		q.write(",")
+	}
+	q.writeJSON(v)
+	q.nRows++
+	if q.nRows == 1 {
+		// Get the first row to the client as soon as possible.
+		q.flush()
+	}
+	return q.err
+}
+
+// EndStatement implements db.RowsWriter.
+func (q *queryStreamWriter) EndStatement(errMsg string, t float64) error {
+	q.openStmt()
+	if q.nRows > 0 {
+		q.write("]")
+	} else if q.assoc {
+		q.fieldName("rows")
+		q.write("[]")
+	}
+	if errMsg != "" {
+		q.field("error", errMsg)
+	}
+	if t != 0 {
+		q.field("time", t)
+	}
+	q.write("}")
+	q.inStmt = false
+	q.nStmts++
+	return q.err
+}
+
+// hasStarted implements queryStreamer.
+func (q *queryStreamWriter) hasStarted() bool {
+	return q.started
+}
+
+// finish implements queryStreamer.
+func (q *queryStreamWriter) finish(err error, t float64) error {
+	q.begin()
+	q.write("]")
+	if err != nil {
+		q.write(`,"error":`)
+		q.writeJSON(err.Error())
+	}
+	if t != 0 {
+		q.write(`,"time":`)
+		q.writeJSON(t)
+	}
+	q.write("}")
+	q.flush()
+	return q.err
+}
+
+func (q *queryStreamWriter) begin() {
+	if !q.started {
+		q.started = true
+		q.write(`{"results":[`)
+	}
+}
+
+func (q *queryStreamWriter) openStmt() {
+	q.begin()
+	if q.inStmt {
+		return
+	}
+	if q.nStmts > 0 {
+		q.write(",")
+	}
+	q.write("{")
+	q.inStmt = true
+	q.nFields = 0
+	q.nRows = 0
+	q.columns = nil
+}
+
+func (q *queryStreamWriter) fieldName(name string) {
+	if q.nFields > 0 {
+		q.write(",")
+	}
+	q.write(`"` + name + `":`)
+	q.nFields++
+}
+
+func (q *queryStreamWriter) field(name string, v interface{}) {
+	q.fieldName(name)
+	q.writeJSON(v)
+}
+
+func (q *queryStreamWriter) writeJSON(v interface{}) {
+	if q.err != nil {
+		return
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		q.err = err
+		return
+	}
+	_, q.err = q.bw.Write(b)
+}
+
+func (q *queryStreamWriter) write(s string) {
+	if q.err != nil {
+		return
+	}
+	_, q.err = q.bw.WriteString(s)
+}
+
+func (q *queryStreamWriter) flush() {
+	if q.err != nil {
+		return
+	}
+	if q.err = q.bw.Flush(); q.err != nil {
+		return
+	}
+	if f, ok := q.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,86 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/rqlite/rqlite/command"
+	"github.com/rqlite/rqlite/db"
+)
+
+func Test_QueryStreamWriter(t *testing.T) {
+	d, err := db.Open(filepath.Join(t.TempDir(), "db.sqlite"), false, true)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err)
+	}
+	defer d.Close()
+	for _, stmt := range []string{
+		"CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT, score REAL, data BLOB)",
+		`INSERT INTO foo(name, score, data) VALUES("fiona <&>", 1.5, x'0102')`,
+		`INSERT INTO foo(name, score, data) VALUES(NULL, 2, NULL)`,
+	} {
+		if _, err := d.ExecuteStringStmt(stmt); err != nil {
+			t.Fatalf("failed to execute %s: %s", stmt, err)
+		}
+	}
+
+	req := &command.Request{
+		Statements: []*command.Statement{
+			{Sql: "SELECT * FROM foo"},
+			{Sql: "SELECT * FROM foo WHERE id > 10"},
+			{Sql: "SELECT COUNT(*), 1+1 FROM foo"},
+			{Sql: "SELECT * FROM bar"},
+			{Sql: "INSERT INTO foo(name) VALUES('x')"},
+		},
+	}
+
+	for _, assoc := range []bool{false, true} {
+		for _, streamErr := range []error{nil, errors.New("commit failed")} {
+			rows, err := d.Query(req, false)
+			if err != nil {
+				t.Fatalf("failed to query: %s", err)
+			}
+			resp := NewResponse()
+			resp.Results.QueryRows = rows
+			resp.Results.AssociativeJSON = assoc
+			if streamErr != nil {
+				resp.Error = streamErr.Error()
+			}
+			exp, err := json.Marshal(resp)
+			if err != nil {
+				t.Fatalf("failed to marshal response: %s", err)
+			}
+
+			rr := httptest.NewRecorder()
+			sw := newQueryStreamWriter(rr, assoc)
+			if err := d.QueryStream(req, false, sw); err != nil {
+				t.Fatalf("failed to stream query: %s", err)
+			}
+			if err := sw.finish(streamErr, 0); err != nil {
+				t.Fatalf("failed to finish stream: %s", err)
+			}
+			if got := rr.Body.String(); got != string(exp) {
+				t.Fatalf("streamed response differs (assoc %v)\nexp: %s\ngot: %s", assoc, exp, got)
+			}
+			if !rr.Flushed {
+				t.Fatalf("streamed response not flushed")
+			}
+		}
+	}
+
+	// No statements gives empty results.
+	rr := httptest.NewRecorder()
+	sw := newQueryStreamWriter(rr, false)
+	if err := d.QueryStream(&command.Request{}, false, sw); err != nil {
+		t.Fatalf("failed to stream query: %s", err)
+	}
+	if err := sw.finish(nil, 0); err != nil {
+		t.Fatalf("failed to finish stream: %s", err)
+	}
+	if exp, got := `{"results":[]}`, rr.Body.String(); exp != got {
+		t.Fatalf("wrong response for no statements, exp %s, got %s", exp, got)
+	}
+}
@@ -0,0 +1,107 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rqlite/rqlite/auth"
+	"github.com/rqlite/rqlite/store"
+)
+
+// subscribeKeepalive is how often a comment is sent on an idle subscription,
+// so that intermediaries do not close the connection.
+const subscribeKeepalive = 15 * time.Second
+
+// RowSubscriber is the interface a store must implement to push
+// changes to rows to clients, as they are applied.
+type RowSubscriber interface {
+	// Subscribe subscribes to the changes to rows of the given tables, or
+	// of every table if none are given.
+	Subscribe(tables []string) (store.Subscription, error)
+
+	// Unsubscribe ends a subscription.
+	Unsubscribe(sub store.Subscription)
+}
+
+// handleSubscribe streams the changes to rows of the tables given by the
+// tables parameter, or of every table, to the client as Server-Sent Events,
+// as they are applied by this node. Each change is sent as a change event,
+// whose ID is the index of the log entry which made it. If the subscription
+// ends, because changes could not be delivered fast enough, an error event
+// is sent before the stream is closed, after which the client must reread
+// any rows it depends on before subscribing again.
+func (s *Service) handleSubscribe(w http.ResponseWriter, r *http.Request) {
+	if !s.CheckRequestPerm(r, auth.PermQuery) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if s.Subscriber == nil {
+		http.Error(w, "subscriptions not supported", http.StatusNotFound)
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	var tables []string
+	for _, t := range strings.Split(r.URL.Query().Get("tables"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tables = append(tables, t)
+		}
+	}
+	sub, err := s.Subscriber.Subscribe(tables)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer s.Subscriber.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(subscribeKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case rc, ok := <-sub.C():
+			if !ok {
+				if err := sub.Err(); err != nil {
+					b, _ := json.Marshal(map[string]string{"error": err.Error()})
+					fmt.Fprintf(w, "event: error\ndata: %s\n\n", b)
+					flusher.Flush()
+				}
+				return
+			}
+			b, err := json.Marshal(rc)
+			if err != nil {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "event: change\nid: %d\ndata: %s\n\n", rc.Index, b); err != nil {
+				return
+			}
+			stats.Add(numSubscribeEvents, 1)
+			// Send every change available before flushing.
+			if len(sub.C()) == 0 {
+				flusher.Flush()
+			}
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
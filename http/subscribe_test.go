@@ -0,0 +1,102 @@
+package http
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/rqlite/rqlite/store"
+)
+
+type mockSubscription struct {
+	ch  chan *store.RowChange
+	err error
+}
+
+func (m *mockSubscription) C() <-chan *store.RowChange {
+	return m.ch
+}
+
+func (m *mockSubscription) Err() error {
+	return m.err
+}
+
+type mockSubscriber struct {
+	tables       []string
+	sub          *mockSubscription
+	unsubscribed bool
+}
+
+func (m *mockSubscriber) Subscribe(tables []string) (store.Subscription, error) {
+	m.tables = tables
+	return m.sub, nil
+}
+
+func (m *mockSubscriber) Unsubscribe(sub store.Subscription) {
+	m.unsubscribed = true
+}
+
+func Test_Subscribe(t *testing.T) {
+	m := &MockStore{}
+	s := New("127.0.0.1:0", m, &mockClusterService{}, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	resp := mustDoRequest(t, "GET", host+"/db/subscribe", "", "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("wrong status code when subscriptions not supported, exp %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	sub := &mockSubscription{
+		ch:  make(chan *store.RowChange, 2),
+		err: store.ErrSubscriptionOverflow,
+	}
+	sub.ch <- &store.RowChange{Index: 5, Table: "foo", Op: "insert", RowID: 1}
+	sub.ch <- &store.RowChange{Index: 6, Table: "bar", Op: "delete", RowID: 2}
+	close(sub.ch)
+	sr := &mockSubscriber{sub: sub}
+	s.Subscriber = sr
+
+	resp = mustDoRequest(t, "POST", host+"/db/subscribe", "", "")
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("wrong status code for POST, exp %d, got %d", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+
+	resp = mustDoRequest(t, "GET", host+"/db/subscribe?tables=foo,+bar", "", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status code, exp %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("wrong content type: %s", ct)
+	}
+	var lines []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	exp := `event: change
+id: 5
+data: {"index":5,"table":"foo","op":"insert","rowid":1}
+
+event: change
+id: 6
+data: {"index":6,"table":"bar","op":"delete","rowid":2}
+
+event: error
+data: {"error":"subscription overflowed, changes were missed"}
+`
+	if got := strings.Join(lines, "\n"); got != exp {
+		t.Fatalf("wrong events\nexp: %s\ngot: %s", exp, got)
+	}
+	if fmt.Sprint(sr.tables) != "[foo bar]" {
+		t.Fatalf("wrong tables subscribed to: %v", sr.tables)
+	}
+	if !sr.unsubscribed {
+		t.Fatalf("subscription not ended")
+	}
+}
@@ -0,0 +1,167 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+const (
+	// TagHTTPHeader is the HTTP header clients use to tag a request with
+	// the name of the application making it, so load on the cluster may be
+	// attributed to applications. The tag may also be set with the tag query
+	// parameter.
+	TagHTTPHeader = "X-RQLITE-TAG"
+
+	// maxTags is the maximum number of distinct tags for which stats are
+	// kept. Requests with further tags are counted under otherTag.
+	maxTags  = 256
+	otherTag = "_other"
+)
+
+// validTag matches tags which may be set on a request.
+var validTag = regexp.MustCompile(`^[A-Za-z0-9_.:-]{1,64}$`)
+
+// tags holds the stats of tagged requests served by this node.
+var tags = newTagStats()
+
+// tagStat holds the stats of requests with a given tag.
+type tagStat struct {
+	requests   int64
+	errors     int64
+	latency    time.Duration
+	maxLatency time.Duration
+}
+
+// tagStats aggregates the stats of requests by tag.
+type tagStats struct {
+	mu    sync.Mutex
+	stats map[string]*tagStat
+}
+
+func newTagStats() *tagStats {
+	return &tagStats{
+		stats: make(map[string]*tagStat),
+	}
+}
+
+// Observe records a request with the given tag, which took d to serve. The
+// request failed if failed is true.
+func (t *tagStats) Observe(tag string, d time.Duration, failed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st, ok := t.stats[tag]
+	if !ok {
+		if len(t.stats) >= maxTags {
+			tag = otherTag
+			st = t.stats[tag]
+		}
+		if st == nil {
+			st = &tagStat{}
+			t.stats[tag] = st
+		}
+	}
+	st.requests++
+	if failed {
+		st.errors++
+	}
+	st.latency += d
+	if d > st.maxLatency {
+		st.maxLatency = d
+	}
+}
+
+// Stats returns the stats of requests, by tag.
+func (t *tagStats) Stats() interface{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	m := make(map[string]interface{}, len(t.stats))
+	for tag, st := range t.stats {
+		m[tag] = map[string]interface{}{
+			"requests":        st.requests,
+			"errors":          st.errors,
+			"latency_ms":      st.latency.Milliseconds(),
+			"mean_latency_ms": float64(st.latency.Microseconds()) / float64(st.requests) / 1000,
+			"max_latency_ms":  st.maxLatency.Milliseconds(),
+		}
+	}
+	return m
+}
+
+// Reset discards all stats.
+func (t *tagStats) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stats = make(map[string]*tagStat)
+}
+
+// requestTag returns the tag of the request, if any.
+func requestTag(r *http.Request) (string, error) {
+	tag := r.Header.Get(TagHTTPHeader)
+	if tag == "" {
+		tag = r.URL.Query().Get("tag")
+	}
+	if tag == "" {
+		return "", nil
+	}
+	if !validTag.MatchString(tag) {
+		return "", fmt.Errorf("tag must be 1 to 64 letters, digits, or any of _.:-")
+	}
+	return tag, nil
+}
+
+// statusRecorder records the status code of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.code == 0 {
+		s.code = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.code == 0 {
+		s.code = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// Flush flushes the response, if the underlying writer supports it, so
+// that streamed responses are not held back by tagging.
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// serveTagged serves a request to the database with handler, recording the
+// stats of the request under its tag, if it has one. Failed tagged requests
+// are logged with their tag.
+func (s *Service) serveTagged(w http.ResponseWriter, r *http.Request, handler http.HandlerFunc) {
+	tag, err := requestTag(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if tag == "" {
+		handler(w, r)
+		return
+	}
+
+	w.Header().Set(TagHTTPHeader, tag)
+	sr := &statusRecorder{ResponseWriter: w}
+	start := time.Now()
+	handler(sr, r)
+	d := time.Since(start)
+	failed := sr.code >= http.StatusBadRequest
+	tags.Observe(tag, d, failed)
+	if failed {
+		s.logger.Printf("request to %s tagged %s failed with status %d after %s", r.URL.Path, tag, sr.code, d)
+	}
+}
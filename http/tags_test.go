@@ -0,0 +1,107 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rqlite/rqlite/command"
+)
+
+func Test_RequestTag(t *testing.T) {
+	for _, tt := range []struct {
+		header string
+		query  string
+		exp    string
+		err    bool
+	}{
+		{},
+		{header: "billing", exp: "billing"},
+		{query: "tag=reports.v2", exp: "reports.v2"},
+		{header: "billing", query: "tag=reports", exp: "billing"},
+		{header: "bad tag", err: true},
+		{header: strings.Repeat("a", 65), err: true},
+	} {
+		r, err := http.NewRequest("GET", "http://localhost/db/query?"+tt.query, nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %s", err.Error())
+		}
+		if tt.header != "" {
+			r.Header.Set(TagHTTPHeader, tt.header)
+		}
+		tag, err := requestTag(r)
+		if tt.err {
+			if err == nil {
+				t.Fatalf("expected error for tag %q", tt.header)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unexpected error for tag %q: %s", tt.header, err.Error())
+		}
+		if tag != tt.exp {
+			t.Fatalf("wrong tag, exp %q, got %q", tt.exp, tag)
+		}
+	}
+}
+
+func Test_TagStatsOverflow(t *testing.T) {
+	ts := newTagStats()
+	for i := 0; i < maxTags+10; i++ {
+		ts.Observe(fmt.Sprintf("tag%d", i), time.Millisecond, false)
+	}
+	m := ts.Stats().(map[string]interface{})
+	if len(m) != maxTags+1 {
+		t.Fatalf("wrong number of tags tracked, exp %d, got %d", maxTags+1, len(m))
+	}
+	if got := m[otherTag].(map[string]interface{})["requests"]; got != int64(10) {
+		t.Fatalf("wrong number of requests under %s, exp 10, got %v", otherTag, got)
+	}
+}
+
+func Test_TaggedRequests(t *testing.T) {
+	ResetStats()
+	m := &MockStore{
+		executeFn: func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+			return nil, nil
+		},
+	}
+	s := New("127.0.0.1:0", m, &mockClusterService{}, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	for i := 0; i < 2; i++ {
+		resp := mustDoRequest(t, "POST", host+"/db/execute?tag=billing", `["INSERT INTO foo VALUES(1)"]`, "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("wrong status code for tagged request, exp %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		if resp.Header.Get(TagHTTPHeader) != "billing" {
+			t.Fatalf("tag not returned in response header")
+		}
+	}
+	resp := mustDoRequest(t, "POST", host+"/db/execute?tag=billing", `not JSON`, "")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("wrong status code for bad tagged request, exp %d, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+	resp = mustDoRequest(t, "POST", host+"/db/execute?tag=bad%20tag", `["INSERT INTO foo VALUES(1)"]`, "")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("wrong status code for invalid tag, exp %d, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+
+	var st map[string]map[string]float64
+	if err := json.Unmarshal([]byte(stats.Get("tags").String()), &st); err != nil {
+		t.Fatalf("failed to decode tag stats: %s", err.Error())
+	}
+	if st["billing"]["requests"] != 3 || st["billing"]["errors"] != 1 {
+		t.Fatalf("wrong stats for tag: %v", st)
+	}
+	if len(st) != 1 {
+		t.Fatalf("stats kept for requests with invalid tag: %v", st)
+	}
+}
@@ -0,0 +1,238 @@
+package http
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rqlite/rqlite/auth"
+	"github.com/rqlite/rqlite/command"
+	"github.com/rqlite/rqlite/store"
+)
+
+// Transactor is the interface a store must implement to support interactive
+// transactions, which span many requests.
+type Transactor interface {
+	// BeginTransaction begins a transaction, returning its ID.
+	BeginTransaction() (string, error)
+
+	// TransactionQuery executes queries in a transaction. Memory reserved
+	// for the results is held until the returned release func is called.
+	TransactionQuery(id string, qr *command.QueryRequest) ([]*command.QueryRows, func(), error)
+
+	// TransactionExecute adds statements to a transaction, to be executed
+	// when it is committed.
+	TransactionExecute(id string, stmts []*command.Statement) error
+
+	// CommitTransaction commits a transaction, returning the results of
+	// its statements.
+	CommitTransaction(id string) ([]*command.ExecuteResult, error)
+
+	// RollbackTransaction rolls back a transaction.
+	RollbackTransaction(id string) error
+}
+
+// handleTransaction handles beginning an interactive transaction (POST
+// /db/transaction), committing it (POST /db/transaction/<id>), and rolling
+// it back (DELETE /db/transaction/<id>). Between beginning and ending it,
+// a client executes statements and queries in the transaction by passing
+// its ID in the transaction parameter of /db/execute and /db/query
+// requests. Since transactions are held by the leader, requests are never
+// forwarded to it, though a client may request to be redirected to it.
+func (s *Service) handleTransaction(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if !s.CheckRequestPerm(r, auth.PermExecute) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if s.Transactions == nil {
+		http.Error(w, "transactions not supported", http.StatusNotFound)
+		return
+	}
+
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/db/transaction"), "/")
+	switch {
+	case id == "" && r.Method == "POST":
+		txID, err := s.Transactions.BeginTransaction()
+		if err != nil {
+			s.transactionError(w, r, err)
+			return
+		}
+		resp := NewResponse()
+		resp.Results = nil
+		resp.Transaction = txID
+		resp.end = time.Now()
+		s.writeResponse(w, r, resp)
+	case id != "" && r.Method == "POST":
+		results, err := s.Transactions.CommitTransaction(id)
+		if err != nil {
+			s.transactionError(w, r, err)
+			return
+		}
+		resp := NewResponse()
+		resp.Results.ExecuteResult = results
+		resp.end = time.Now()
+		s.writeResponse(w, r, resp)
+	case id != "" && r.Method == "DELETE":
+		if err := s.Transactions.RollbackTransaction(id); err != nil {
+			s.transactionError(w, r, err)
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// transactionExecute adds the statements of an execute request to the
+// transaction with the given ID.
+func (s *Service) transactionExecute(w http.ResponseWriter, r *http.Request, id string) {
+	if s.Transactions == nil {
+		http.Error(w, "transactions not supported", http.StatusNotFound)
+		return
+	}
+	release, ok := s.admit(w, r, false)
+	if !ok {
+		return
+	}
+	defer release()
+
+	noRewriteRandom, err := noRewriteRandom(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.Body.Close()
+
+	stmts, err := parseRequestBody(r, b)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	stats.Add(numExecuteStmtsRx, int64(len(stmts)))
+	if !s.checkPolicy(w, r, stmts, b) {
+		return
+	}
+	if !s.checkTrash(w, stmts, true) {
+		return
+	}
+	if err := command.Rewrite(stmts, !noRewriteRandom); err != nil {
+		http.Error(w, fmt.Sprintf("SQL rewrite: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if !s.checkNonDeterministic(w, stmts) {
+		return
+	}
+
+	if err := s.Transactions.TransactionExecute(id, stmts); err != nil {
+		s.transactionError(w, r, err)
+		return
+	}
+	resp := NewResponse()
+	resp.Results = nil
+	resp.Transaction = id
+	resp.end = time.Now()
+	s.writeResponse(w, r, resp)
+}
+
+// transactionQuery executes the queries of a query request in the
+// transaction with the given ID.
+func (s *Service) transactionQuery(w http.ResponseWriter, r *http.Request, id string) {
+	if s.Transactions == nil {
+		http.Error(w, "transactions not supported", http.StatusNotFound)
+		return
+	}
+	release, ok := s.admit(w, r, true)
+	if !ok {
+		return
+	}
+	defer release()
+
+	_, _, _, _, timings, _, _, isAssoc, err := queryReqParams(r, defaultTimeout)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp, err := cursorResponse(r, isAssoc)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	queries, b, err := requestQueries(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	stats.Add(numQueryStmtsRx, int64(len(queries)))
+	if !s.checkPolicy(w, r, queries, b) {
+		return
+	}
+	if !s.checkTrash(w, queries, false) {
+		return
+	}
+
+	qr := &command.QueryRequest{
+		Request: &command.Request{
+			Statements: queries,
+		},
+		Timings: timings,
+	}
+	rows, release, err := s.Transactions.TransactionQuery(id, qr)
+	defer release()
+	if err != nil {
+		s.transactionError(w, r, err)
+		return
+	}
+	resp.Results.QueryRows = rows
+	resp.Transaction = id
+	resp.end = time.Now()
+	s.writeResponse(w, r, resp)
+}
+
+// transactionError writes the response for an error from a Transactor,
+// redirecting the client to the leader, if it requested so, when this node
+// is not the leader.
+func (s *Service) transactionError(w http.ResponseWriter, r *http.Request, err error) {
+	if err != store.ErrNotLeader {
+		http.Error(w, err.Error(), transactionErrorCode(err))
+		return
+	}
+	leaderAPIAddr := s.LeaderAPIAddr()
+	if leaderAPIAddr == "" {
+		stats.Add(numLeaderNotFound, 1)
+		http.Error(w, ErrLeaderNotFound.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if redirect, _ := isRedirect(r); !redirect {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	http.Redirect(w, r, s.FormRedirect(r, leaderAPIAddr), http.StatusMovedPermanently)
+}
+
+// transactionParam returns the ID of the transaction in which a request is
+// made, or the empty string if it is not made in one.
+func transactionParam(r *http.Request) string {
+	return r.URL.Query().Get("transaction")
+}
+
+// transactionErrorCode returns the HTTP status code for an error from a
+// Transactor.
+func transactionErrorCode(err error) int {
+	switch err {
+	case store.ErrTransactionNotFound:
+		return http.StatusNotFound
+	case store.ErrTransactionConflict:
+		return http.StatusConflict
+	case store.ErrTooManyTransactions, store.ErrNotReady:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
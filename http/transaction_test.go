@@ -0,0 +1,121 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/rqlite/rqlite/command"
+	"github.com/rqlite/rqlite/store"
+)
+
+type mockTransactor struct {
+	stmts      []*command.Statement
+	rolledBack bool
+}
+
+func (m *mockTransactor) BeginTransaction() (string, error) {
+	return "abc", nil
+}
+
+func (m *mockTransactor) TransactionQuery(id string, qr *command.QueryRequest) ([]*command.QueryRows, func(), error) {
+	if id != "abc" {
+		return nil, func() {}, store.ErrTransactionNotFound
+	}
+	return []*command.QueryRows{{
+		Columns: []string{"balance"},
+		Types:   []string{"integer"},
+		Values: []*command.Values{
+			{Parameters: []*command.Parameter{{Value: &command.Parameter_I{I: 100}}}},
+		},
+	}}, func() {}, nil
+}
+
+func (m *mockTransactor) TransactionExecute(id string, stmts []*command.Statement) error {
+	if id != "abc" {
+		return store.ErrTransactionNotFound
+	}
+	m.stmts = append(m.stmts, stmts...)
+	return nil
+}
+
+func (m *mockTransactor) CommitTransaction(id string) ([]*command.ExecuteResult, error) {
+	switch id {
+	case "abc":
+		return []*command.ExecuteResult{{RowsAffected: int64(len(m.stmts))}}, nil
+	case "stale":
+		return nil, store.ErrTransactionConflict
+	}
+	return nil, store.ErrTransactionNotFound
+}
+
+func (m *mockTransactor) RollbackTransaction(id string) error {
+	if id != "abc" {
+		return store.ErrTransactionNotFound
+	}
+	m.rolledBack = true
+	return nil
+}
+
+func Test_Transaction(t *testing.T) {
+	m := &MockStore{}
+	s := New("127.0.0.1:0", m, &mockClusterService{}, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	resp := mustDoRequest(t, "POST", host+"/db/transaction", "", "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("wrong status code when transactions not supported, exp %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	tx := &mockTransactor{}
+	s.Transactions = tx
+
+	resp = mustDoRequest(t, "POST", host+"/db/transaction", "", "")
+	if exp, got := `{"transaction":"abc"}`, mustReadBody(t, resp); exp != got {
+		t.Fatalf("wrong response to beginning transaction\nexp: %s\ngot: %s", exp, got)
+	}
+	resp = mustDoRequest(t, "GET", host+"/db/query?transaction=abc&q=SELECT+balance+FROM+foo", "", "")
+	if exp, got := `{"results":[{"columns":["balance"],"types":["integer"],"values":[[100]]}],"transaction":"abc"}`, mustReadBody(t, resp); exp != got {
+		t.Fatalf("wrong response to query in transaction\nexp: %s\ngot: %s", exp, got)
+	}
+	resp = mustDoRequest(t, "POST", host+"/db/execute?transaction=abc", `["UPDATE foo SET balance = 50", "INSERT INTO bar VALUES(50)"]`, "")
+	if exp, got := `{"transaction":"abc"}`, mustReadBody(t, resp); exp != got {
+		t.Fatalf("wrong response to execute in transaction\nexp: %s\ngot: %s", exp, got)
+	}
+	if len(tx.stmts) != 2 {
+		t.Fatalf("wrong number of statements in transaction, exp 2, got %d", len(tx.stmts))
+	}
+	resp = mustDoRequest(t, "POST", host+"/db/transaction/abc", "", "")
+	if exp, got := `{"results":[{"rows_affected":2}]}`, mustReadBody(t, resp); exp != got {
+		t.Fatalf("wrong response to committing transaction\nexp: %s\ngot: %s", exp, got)
+	}
+	resp = mustDoRequest(t, "DELETE", host+"/db/transaction/abc", "", "")
+	if resp.StatusCode != http.StatusOK || !tx.rolledBack {
+		t.Fatalf("transaction not rolled back, status code %d", resp.StatusCode)
+	}
+
+	for _, tt := range []struct {
+		method string
+		path   string
+		body   string
+		code   int
+	}{
+		{"POST", "/db/transaction/stale", "", http.StatusConflict},
+		{"POST", "/db/transaction/xyz", "", http.StatusNotFound},
+		{"DELETE", "/db/transaction/xyz", "", http.StatusNotFound},
+		{"GET", "/db/query?transaction=xyz&q=SELECT+1", "", http.StatusNotFound},
+		{"POST", "/db/execute?transaction=xyz", `["DELETE FROM foo"]`, http.StatusNotFound},
+		{"GET", "/db/transaction", "", http.StatusMethodNotAllowed},
+		{"DELETE", "/db/transaction", "", http.StatusMethodNotAllowed},
+		{"GET", "/db/transaction/abc", "", http.StatusMethodNotAllowed},
+	} {
+		resp = mustDoRequest(t, tt.method, host+tt.path, tt.body, "")
+		if resp.StatusCode != tt.code {
+			t.Fatalf("wrong status code for %s %s, exp %d, got %d", tt.method, tt.path, tt.code, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rqlite/rqlite/auth"
+	"github.com/rqlite/rqlite/command"
+)
+
+const (
+	// DefaultUpsertBatchSize is the default number of rows applied by each
+	// Raft entry during an upsert.
+	DefaultUpsertBatchSize = 500
+
+	// maxUpsertBatchSize is the largest batch size a client may request.
+	maxUpsertBatchSize = 10000
+)
+
+// upsertRequest is the body of an upsert. Each row maps column names to
+// values, and must include every key column.
+type upsertRequest struct {
+	Table string                   `json:"table"`
+	Keys  []string                 `json:"keys"`
+	Rows  []map[string]interface{} `json:"rows"`
+}
+
+// handleUpsert handles structured upserts of rows into a table. Rows are
+// inserted, or if a row with the same key columns already exists, its other
+// columns are updated. Rows are applied in batches, each a single transaction,
+// in the same way as a bulk write, and the response is that of a bulk write.
+// All rows are checked against any statement policy before any are applied,
+// so an upsert which needs approval is held as a whole.
+func (s *Service) handleUpsert(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if !s.CheckRequestPerm(r, auth.PermExecute) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	batchSize, err := upsertBatchSize(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.Body.Close()
+
+	var ur upsertRequest
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&ur); err != nil {
+		http.Error(w, ErrInvalidJSON.Error(), http.StatusBadRequest)
+		return
+	}
+	stmts, err := upsertStatements(&ur)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !s.checkPolicy(w, r, stmts, b) {
+		return
+	}
+	if !s.checkTrash(w, stmts, false) {
+		return
+	}
+
+	release, ok := s.admit(w, r, false)
+	if !ok {
+		return
+	}
+	defer release()
+
+	stats.Add(numUpserts, 1)
+	start := time.Now()
+	resp := &bulkResponse{}
+	for len(stmts) > 0 {
+		n := batchSize
+		if n > len(stmts) {
+			n = len(stmts)
+		}
+		if !s.applyBulkChunk(w, r, resp, stmts[:n]) {
+			return
+		}
+		stats.Add(numUpsertRows, int64(n))
+		stmts = stmts[n:]
+	}
+
+	resp.Time = time.Since(start).Seconds()
+	s.writeJSON(w, r, http.StatusOK, resp)
+}
+
+// upsertStatements returns the statements upserting the rows of ur.
+func upsertStatements(ur *upsertRequest) ([]*command.Statement, error) {
+	table := strings.TrimSpace(ur.Table)
+	if table == "" {
+		return nil, errors.New("table must be set")
+	}
+	if len(ur.Keys) == 0 {
+		return nil, errors.New("at least one key column must be set")
+	}
+
+	sqls := make(map[string]string)
+	stmts := make([]*command.Statement, len(ur.Rows))
+	for i, row := range ur.Rows {
+		for _, k := range ur.Keys {
+			if _, ok := row[k]; !ok {
+				return nil, fmt.Errorf("row %d: key column %s not set", i+1, k)
+			}
+		}
+		cols := make([]string, 0, len(row))
+		for c := range row {
+			cols = append(cols, c)
+		}
+		sort.Strings(cols)
+
+		key := strings.Join(cols, "\x00")
+		sql, ok := sqls[key]
+		if !ok {
+			sql = upsertSQL(table, ur.Keys, cols)
+			sqls[key] = sql
+		}
+		stmt := &command.Statement{
+			Sql:        sql,
+			Parameters: make([]*command.Parameter, len(cols)),
+		}
+		for j, c := range cols {
+			p, err := makeParameter("", row[c])
+			if err != nil {
+				return nil, fmt.Errorf("row %d: %w", i+1, err)
+			}
+			stmt.Parameters[j] = p
+		}
+		stmts[i] = stmt
+	}
+	return stmts, nil
+}
+
+// upsertSQL returns the parameterized statement inserting a row with the
+// given columns into table, or updating the columns which are not keys if
+// a row with the same keys exists.
+func upsertSQL(table string, keys, cols []string) string {
+	isKey := make(map[string]bool, len(keys))
+	quotedKeys := make([]string, len(keys))
+	for i := range keys {
+		isKey[keys[i]] = true
+		quotedKeys[i] = quoteIdentifier(keys[i])
+	}
+	var sets []string
+	for _, c := range cols {
+		if !isKey[c] {
+			q := quoteIdentifier(c)
+			sets = append(sets, fmt.Sprintf("%s = excluded.%s", q, q))
+		}
+	}
+	action := "DO NOTHING"
+	if len(sets) > 0 {
+		action = "DO UPDATE SET " + strings.Join(sets, ", ")
+	}
+	return fmt.Sprintf("%s ON CONFLICT (%s) %s", bulkInsertSQL(table, cols),
+		strings.Join(quotedKeys, ", "), action)
+}
+
+// upsertBatchSize returns the number of rows applied by each Raft entry
+// during an upsert.
+func upsertBatchSize(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("batch_size")
+	if v == "" {
+		return DefaultUpsertBatchSize, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 || n > maxUpsertBatchSize {
+		return 0, fmt.Errorf("batch_size must be between 1 and %d", maxUpsertBatchSize)
+	}
+	return n, nil
+}
@@ -0,0 +1,121 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/rqlite/rqlite/command"
+)
+
+func Test_UpsertSQL(t *testing.T) {
+	if got, exp := upsertSQL("foo", []string{"id"}, []string{"age", "id", "name"}),
+		`INSERT INTO "foo" ("age", "id", "name") VALUES (?, ?, ?) ON CONFLICT ("id") DO UPDATE SET "age" = excluded."age", "name" = excluded."name"`; got != exp {
+		t.Fatalf("wrong SQL, exp %s, got %s", exp, got)
+	}
+	if got, exp := upsertSQL("foo", []string{"a", "b"}, []string{"a", "b"}),
+		`INSERT INTO "foo" ("a", "b") VALUES (?, ?) ON CONFLICT ("a", "b") DO NOTHING`; got != exp {
+		t.Fatalf("wrong SQL, exp %s, got %s", exp, got)
+	}
+}
+
+func Test_UpsertStatementsBad(t *testing.T) {
+	for i, ur := range []*upsertRequest{
+		{Keys: []string{"id"}},
+		{Table: "foo"},
+		{Table: "foo", Keys: []string{"id"}, Rows: []map[string]interface{}{{"name": "fiona"}}},
+	} {
+		if _, err := upsertStatements(ur); err == nil {
+			t.Fatalf("expected error for request %d", i)
+		}
+	}
+}
+
+func Test_Upsert(t *testing.T) {
+	var chunks [][]*command.Statement
+	m := &MockStore{
+		executeFn: func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+			if !er.Request.Transaction {
+				t.Errorf("upsert batch not applied as a transaction")
+			}
+			chunks = append(chunks, er.Request.Statements)
+			return make([]*command.ExecuteResult, len(er.Request.Statements)), nil
+		},
+	}
+	s := New("127.0.0.1:0", m, &mockClusterService{}, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	var rows []string
+	for i := 0; i < 5; i++ {
+		rows = append(rows, fmt.Sprintf(`{"id": %d, "name": "name%d"}`, i, i))
+	}
+	body := fmt.Sprintf(`{"table": "foo", "keys": ["id"], "rows": [%s]}`, strings.Join(rows, ","))
+	resp := mustDoRequest(t, "POST", host+"/db/upsert?batch_size=2", body, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status code for upsert, exp %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if br := mustDecodeBulkResponse(t, resp); br.Rows != 5 || br.Chunks != 3 || len(chunks) != 3 {
+		t.Fatalf("wrong upsert response %+v for %d batches", br, len(chunks))
+	}
+	stmt := chunks[2][0]
+	if stmt.Sql != `INSERT INTO "foo" ("id", "name") VALUES (?, ?) ON CONFLICT ("id") DO UPDATE SET "name" = excluded."name"` {
+		t.Fatalf("wrong SQL for upsert: %s", stmt.Sql)
+	}
+	if stmt.Parameters[0].GetI() != 4 || stmt.Parameters[1].GetS() != "name4" {
+		t.Fatalf("wrong parameters for upsert: %v", stmt.Parameters)
+	}
+
+	resp = mustDoRequest(t, "POST", host+"/db/upsert", `{"table": "foo", "rows": []}`, "")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("wrong status code for upsert without keys, exp %d, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+	resp = mustDoRequest(t, "POST", host+"/db/upsert?batch_size=0", body, "")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("wrong status code for bad batch size, exp %d, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+}
+
+func Test_UpsertApproval(t *testing.T) {
+	executed := 0
+	m := &MockStore{
+		isLeader: true,
+		executeFn: func(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+			executed++
+			return make([]*command.ExecuteResult, len(er.Request.Statements)), nil
+		},
+	}
+	s := New("127.0.0.1:0", m, &mockClusterService{}, nil)
+	s.Policy = &mockStatementPolicy{
+		checkFn: func(username string, stmts []*command.Statement, confirmed bool) error {
+			return &mockApprovalError{}
+		},
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start service")
+	}
+	defer s.Close()
+	host := fmt.Sprintf("http://%s", s.Addr().String())
+
+	body := `{"table": "foo", "keys": ["id"], "rows": [{"id": 1}, {"id": 2}, {"id": 3}]}`
+	resp := mustDoRequest(t, "POST", host+"/db/upsert?batch_size=1", body, "alice")
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("wrong status code for upsert needing approval, exp %d, got %d", http.StatusAccepted, resp.StatusCode)
+	}
+	id := mustApprovalID(t, resp)
+	if executed != 0 {
+		t.Fatalf("upsert executed before approval")
+	}
+
+	resp = mustDoRequest(t, "POST", host+"/approvals/"+id, "", "bob")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status code for approved upsert, exp %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if br := mustDecodeBulkResponse(t, resp); br.Rows != 3 || executed != 3 {
+		t.Fatalf("wrong upsert response %+v for approved upsert, %d batches executed", br, executed)
+	}
+}
@@ -0,0 +1,25 @@
+// Package offset adapts random-access writers for use by code which writes
+// sequentially.
+package offset
+
+import "io"
+
+// Writer adapts an io.WriterAt into an io.Writer, writing sequentially
+// from offset zero.
+type Writer struct {
+	w   io.WriterAt
+	off int64
+}
+
+// NewWriter returns a Writer which writes to w, starting at offset zero.
+func NewWriter(w io.WriterAt) *Writer {
+	return &Writer{w: w}
+}
+
+// Write writes p at the current offset, and advances the offset by the
+// number of bytes written.
+func (o *Writer) Write(p []byte) (int, error) {
+	n, err := o.w.WriteAt(p, o.off)
+	o.off += int64(n)
+	return n, err
+}
@@ -0,0 +1,33 @@
+package offset
+
+import (
+	"os"
+	"testing"
+)
+
+func Test_Writer(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "offset")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %s", err.Error())
+	}
+	defer f.Close()
+
+	w := NewWriter(f)
+	for _, s := range []string{"foo", "bar", "baz"} {
+		n, err := w.Write([]byte(s))
+		if err != nil {
+			t.Fatalf("failed to write: %s", err.Error())
+		}
+		if n != len(s) {
+			t.Fatalf("wrong number of bytes written, exp %d, got %d", len(s), n)
+		}
+	}
+
+	b, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatalf("failed to read file: %s", err.Error())
+	}
+	if exp, got := "foobarbaz", string(b); exp != got {
+		t.Fatalf("wrong contents, exp %s, got %s", exp, got)
+	}
+}
@@ -0,0 +1,292 @@
+// Package k8s labels the Kubernetes Pod running this node with whether the
+// node is the leader of the cluster. A Kubernetes Service selecting on the
+// label then routes requests only to the leader, without the need for a
+// sidecar. The Pod is patched through the Kubernetes API, using the Pod's
+// service account, which must be allowed to patch Pods in its namespace.
+package k8s
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultLabel is the default label set on the Pod.
+	DefaultLabel = "rqlite.io/leader"
+
+	// DefaultReportInterval is the default interval at which the label is
+	// set, in addition to doing so on leadership changes.
+	DefaultReportInterval = time.Minute
+
+	// ServiceAccountDir is the directory in which Kubernetes mounts the
+	// Pod's service account token, CA certificate, and namespace.
+	ServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+	// PodNameEnv is the environment variable from which the Pod name is
+	// read, if set. It is usually set using the Downward API. If not set,
+	// the hostname is used, which is the Pod name unless overridden.
+	PodNameEnv = "POD_NAME"
+
+	// PodNamespaceEnv is the environment variable from which the Pod
+	// namespace is read, if set. If not set, the namespace of the service
+	// account is used.
+	PodNamespaceEnv = "POD_NAMESPACE"
+
+	leaderChanLen  = 5 // Support any fast back-to-back leadership changes.
+	requestTimeout = 10 * time.Second
+)
+
+var (
+	// ErrNotInCluster is returned when this node is not running in a
+	// Kubernetes cluster.
+	ErrNotInCluster = errors.New("not running in a Kubernetes cluster")
+)
+
+// stats captures stats for the Pod labeler.
+var stats *expvar.Map
+
+const (
+	numLabels        = "num_labels"
+	numLabelFailures = "num_label_failures"
+)
+
+func init() {
+	stats = expvar.NewMap("k8s")
+	ResetStats()
+}
+
+// ResetStats resets the expvar stats for this module. Mostly for test purposes.
+func ResetStats() {
+	stats.Init()
+	stats.Add(numLabels, 0)
+	stats.Add(numLabelFailures, 0)
+}
+
+// Store is the interface the consensus system must implement.
+type Store interface {
+	IsLeader() bool
+	RegisterLeaderChange(c chan<- struct{})
+}
+
+// Labeler sets a label on the Pod running this node, to "true" if this node
+// is the leader, and "false" otherwise.
+type Labeler struct {
+	// ReportInterval is the interval at which the label is set, in addition
+	// to doing so on leadership changes. This corrects the label if it was
+	// changed by anything else, or if a previous update failed.
+	ReportInterval time.Duration
+
+	s         Store
+	label     string
+	apiURL    string
+	namespace string
+	pod       string
+	tokenFile string
+	client    *http.Client
+
+	logger *log.Logger
+
+	mu          sync.Mutex
+	leader      bool
+	lastLabeled time.Time
+	lastErr     error
+}
+
+// NewLabeler returns a Labeler which sets label on the Pod pod in namespace,
+// through the Kubernetes API at apiURL. Requests are authenticated with the
+// bearer token read from tokenFile, if set. The file is read for every
+// request, as Kubernetes rotates the token.
+func NewLabeler(s Store, label, apiURL, namespace, pod, tokenFile string, client *http.Client) *Labeler {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Labeler{
+		ReportInterval: DefaultReportInterval,
+		s:              s,
+		label:          label,
+		apiURL:         strings.TrimSuffix(apiURL, "/"),
+		namespace:      namespace,
+		pod:            pod,
+		tokenFile:      tokenFile,
+		client:         client,
+		logger:         log.New(os.Stderr, "[k8s] ", log.LstdFlags),
+	}
+}
+
+// NewInClusterLabeler returns a Labeler which sets label on the Pod running
+// this node, using the Pod's service account. It returns ErrNotInCluster if
+// this node is not running in a Kubernetes cluster.
+func NewInClusterLabeler(s Store, label string) (*Labeler, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, ErrNotInCluster
+	}
+
+	pod := os.Getenv(PodNameEnv)
+	if pod == "" {
+		var err error
+		pod, err = os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get Pod name: %w", err)
+		}
+	}
+	namespace := os.Getenv(PodNamespaceEnv)
+	if namespace == "" {
+		b, err := ioutil.ReadFile(filepath.Join(ServiceAccountDir, "namespace"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Pod namespace: %w", err)
+		}
+		namespace = strings.TrimSpace(string(b))
+	}
+
+	caPEM, err := ioutil.ReadFile(filepath.Join(ServiceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Kubernetes CA certificate: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("failed to parse Kubernetes CA certificate")
+	}
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		},
+	}
+
+	apiURL := "https://" + net.JoinHostPort(host, port)
+	return NewLabeler(s, label, apiURL, namespace, pod,
+		filepath.Join(ServiceAccountDir, "token"), client), nil
+}
+
+// Start starts setting the label, whenever leadership changes and
+// periodically, until ctx is cancelled. The label is then set to "false",
+// so that the Service stops routing requests to this node as it shuts down.
+func (l *Labeler) Start(ctx context.Context) {
+	ticker := time.NewTicker(l.ReportInterval)
+	defer ticker.Stop()
+	obCh := make(chan struct{}, leaderChanLen)
+	l.s.RegisterLeaderChange(obCh)
+
+	l.update(ctx, l.s.IsLeader())
+	for {
+		select {
+		case <-ticker.C:
+			l.update(ctx, l.s.IsLeader())
+		case <-obCh:
+			l.update(ctx, l.s.IsLeader())
+		case <-ctx.Done():
+			if l.isLeader() {
+				shutdownCtx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+				l.update(shutdownCtx, false)
+				cancel()
+			}
+			return
+		}
+	}
+}
+
+// Stats returns diagnostic information on the labeler.
+func (l *Labeler) Stats() (map[string]interface{}, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	m := map[string]interface{}{
+		"label":           l.label,
+		"namespace":       l.namespace,
+		"pod":             l.pod,
+		"leader":          l.leader,
+		"report_interval": l.ReportInterval.String(),
+		"last_labeled":    l.lastLabeled,
+	}
+	if l.lastErr != nil {
+		m["last_error"] = l.lastErr.Error()
+	}
+	return m, nil
+}
+
+func (l *Labeler) isLeader() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.leader
+}
+
+func (l *Labeler) update(ctx context.Context, leader bool) {
+	err := l.patch(ctx, leader)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lastErr = err
+	if err != nil {
+		stats.Add(numLabelFailures, 1)
+		l.logger.Printf("failed to set label %s on Pod %s/%s: %s", l.label, l.namespace, l.pod, err.Error())
+		return
+	}
+	stats.Add(numLabels, 1)
+	l.lastLabeled = time.Now()
+	if leader != l.leader {
+		l.logger.Printf("set label %s=%t on Pod %s/%s due to leadership change",
+			l.label, leader, l.namespace, l.pod)
+	}
+	l.leader = leader
+}
+
+// patch sets the label on the Pod, using a JSON merge patch so that no other
+// labels are changed.
+func (l *Labeler) patch(ctx context.Context, leader bool) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": map[string]string{
+				l.label: strconv.FormatBool(leader),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	u := fmt.Sprintf("%s/api/v1/namespaces/%s/pods/%s", l.apiURL,
+		url.PathEscape(l.namespace), url.PathEscape(l.pod))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req.Header.Set("Accept", "application/json")
+	if l.tokenFile != "" {
+		token, err := ioutil.ReadFile(l.tokenFile)
+		if err != nil {
+			return fmt.Errorf("failed to read service account token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
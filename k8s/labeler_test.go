@@ -0,0 +1,214 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_LabelerLeaderChange(t *testing.T) {
+	ResetStats()
+	api := newMockAPI()
+	defer api.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("secret\n"), 0600); err != nil {
+		t.Fatalf("failed to write token file: %s", err)
+	}
+
+	s := &mockStore{}
+	l := NewLabeler(s, DefaultLabel, api.URL, "default", "rqlite-0", tokenFile, nil)
+	l.ReportInterval = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		l.Start(ctx)
+		close(done)
+	}()
+
+	// Not leader, so the label is set false.
+	if exp, got := "false", api.next(t); exp != got {
+		t.Fatalf("wrong label value, exp %s, got %s", exp, got)
+	}
+	waitForObserver(t, s)
+
+	s.setLeader(true)
+	s.notify()
+	if exp, got := "true", api.next(t); exp != got {
+		t.Fatalf("wrong label value, exp %s, got %s", exp, got)
+	}
+
+	// The Pod is patched before the labeler records the update.
+	var st map[string]interface{}
+	for i := 0; i < 100; i++ {
+		var err error
+		st, err = l.Stats()
+		if err != nil {
+			t.Fatalf("failed to get stats: %s", err)
+		}
+		if st["leader"] == true {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st["leader"] != true {
+		t.Fatalf("leader not reported in stats")
+	}
+	if st["last_labeled"].(time.Time).IsZero() {
+		t.Fatalf("last labeled time not set")
+	}
+
+	// The label is cleared on shutdown.
+	cancel()
+	if exp, got := "false", api.next(t); exp != got {
+		t.Fatalf("wrong label value on shutdown, exp %s, got %s", exp, got)
+	}
+	<-done
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if api.path != "/api/v1/namespaces/default/pods/rqlite-0" {
+		t.Fatalf("wrong path patched: %s", api.path)
+	}
+	if api.auth != "Bearer secret" {
+		t.Fatalf("wrong authorization header: %s", api.auth)
+	}
+	if api.contentType != "application/merge-patch+json" {
+		t.Fatalf("wrong content type: %s", api.contentType)
+	}
+}
+
+func Test_LabelerFailure(t *testing.T) {
+	ResetStats()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "pods is forbidden", http.StatusForbidden)
+	}))
+	defer ts.Close()
+
+	l := NewLabeler(&mockStore{}, DefaultLabel, ts.URL, "default", "rqlite-0", "", nil)
+	l.update(context.Background(), true)
+
+	st, _ := l.Stats()
+	if st["last_error"] == nil {
+		t.Fatalf("expected last error to be reported")
+	}
+	if st["leader"] != false {
+		t.Fatalf("leader reported after failed update")
+	}
+	if stats.Get(numLabelFailures).String() != "1" {
+		t.Fatalf("failure not counted")
+	}
+}
+
+func Test_NewInClusterLabelerNotInCluster(t *testing.T) {
+	host := os.Getenv("KUBERNETES_SERVICE_HOST")
+	os.Unsetenv("KUBERNETES_SERVICE_HOST")
+	defer os.Setenv("KUBERNETES_SERVICE_HOST", host)
+
+	if _, err := NewInClusterLabeler(&mockStore{}, DefaultLabel); !errors.Is(err, ErrNotInCluster) {
+		t.Fatalf("expected ErrNotInCluster, got %v", err)
+	}
+}
+
+type mockAPI struct {
+	*httptest.Server
+	ch chan string
+
+	mu          sync.Mutex
+	path        string
+	auth        string
+	contentType string
+}
+
+func newMockAPI() *mockAPI {
+	m := &mockAPI{ch: make(chan string, 10)}
+	m.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		var patch struct {
+			Metadata struct {
+				Labels map[string]string `json:"labels"`
+			} `json:"metadata"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		m.mu.Lock()
+		m.path = r.URL.Path
+		m.auth = r.Header.Get("Authorization")
+		m.contentType = r.Header.Get("Content-Type")
+		m.mu.Unlock()
+		w.Write([]byte(`{}`))
+		m.ch <- patch.Metadata.Labels[DefaultLabel]
+	}))
+	return m
+}
+
+func (m *mockAPI) next(t *testing.T) string {
+	t.Helper()
+	select {
+	case v := <-m.ch:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for Pod to be patched")
+	}
+	return ""
+}
+
+func waitForObserver(t *testing.T, s *mockStore) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		s.mu.Lock()
+		n := len(s.chans)
+		s.mu.Unlock()
+		if n > 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("leader change not observed")
+}
+
+type mockStore struct {
+	mu     sync.Mutex
+	leader bool
+	chans  []chan<- struct{}
+}
+
+func (m *mockStore) IsLeader() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.leader
+}
+
+func (m *mockStore) RegisterLeaderChange(c chan<- struct{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.chans = append(m.chans, c)
+}
+
+func (m *mockStore) setLeader(b bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.leader = b
+}
+
+func (m *mockStore) notify() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, c := range m.chans {
+		c <- struct{}{}
+	}
+}
@@ -0,0 +1,106 @@
+package leaderdns
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rqlite/rqlite/auto"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// DefaultEtcdPrefix is the default prefix of the keys of records stored in
+// etcd, which is also the default of CoreDNS's etcd plugin.
+const DefaultEtcdPrefix = "/skydns"
+
+// EtcdConfig is the subconfig for the etcd provider.
+type EtcdConfig struct {
+	Endpoints   []string      `json:"endpoints"`
+	Username    string        `json:"username,omitempty"`
+	Password    string        `json:"password,omitempty"`
+	DialTimeout auto.Duration `json:"dial_timeout,omitempty"`
+
+	// Prefix is the prefix of the keys of records. If not set
+	// DefaultEtcdPrefix is used.
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// EtcdClient publishes the record to etcd, in the SkyDNS format read by
+// CoreDNS's etcd plugin.
+type EtcdClient struct {
+	key string
+	ttl time.Duration
+
+	kv etcdKV
+}
+
+// skyDNSRecord is the value of a SkyDNS record.
+type skyDNSRecord struct {
+	Host string `json:"host"`
+	Port int    `json:"port,omitempty"`
+	TTL  uint32 `json:"ttl,omitempty"`
+}
+
+// NewEtcdClient returns a client which publishes the record name, with the
+// given TTL, to the etcd cluster set in cfg.
+func NewEtcdClient(cfg *EtcdConfig, name string, ttl time.Duration) (*EtcdClient, error) {
+	if len(cfg.Endpoints) == 0 {
+		return nil, fmt.Errorf("%w: etcd endpoints not set", ErrInvalidConfig)
+	}
+	c, err := clientv3.New(clientv3.Config{
+		Endpoints:   cfg.Endpoints,
+		Username:    cfg.Username,
+		Password:    cfg.Password,
+		DialTimeout: time.Duration(cfg.DialTimeout),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create etcd client: %w", err)
+	}
+	prefix := cfg.Prefix
+	if prefix == "" {
+		prefix = DefaultEtcdPrefix
+	}
+	return &EtcdClient{
+		key: skyDNSKey(prefix, name),
+		ttl: ttl,
+		kv:  c,
+	}, nil
+}
+
+// Publish creates or updates the record.
+func (e *EtcdClient) Publish(ctx context.Context, host string, port int) error {
+	b, err := json.Marshal(skyDNSRecord{
+		Host: host,
+		Port: port,
+		TTL:  uint32(e.ttl / time.Second),
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := e.kv.Put(ctx, e.key, string(b)); err != nil {
+		return fmt.Errorf("failed to put %s: %w", e.key, err)
+	}
+	return nil
+}
+
+// String returns a string representation of the client.
+func (e *EtcdClient) String() string {
+	return fmt.Sprintf("etcd://%s", e.key)
+}
+
+// skyDNSKey returns the key under prefix of the record for name, which is
+// made up of the labels of name in reverse order. For example the key of
+// leader.example.com is /skydns/com/example/leader.
+func skyDNSKey(prefix, name string) string {
+	labels := strings.Split(strings.TrimSuffix(name, "."), ".")
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return strings.TrimSuffix(prefix, "/") + "/" + strings.Join(labels, "/")
+}
+
+type etcdKV interface {
+	Put(ctx context.Context, key, val string, opts ...clientv3.OpOption) (*clientv3.PutResponse, error)
+}
@@ -0,0 +1,57 @@
+package leaderdns
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func Test_SkyDNSKey(t *testing.T) {
+	for _, tc := range []struct {
+		prefix string
+		name   string
+		exp    string
+	}{
+		{"/skydns", "leader.example.com", "/skydns/com/example/leader"},
+		{"/skydns/", "leader.example.com.", "/skydns/com/example/leader"},
+		{"/dns", "leader", "/dns/leader"},
+	} {
+		if got := skyDNSKey(tc.prefix, tc.name); got != tc.exp {
+			t.Fatalf("wrong key for %s, exp %s, got %s", tc.name, tc.exp, got)
+		}
+	}
+}
+
+func Test_EtcdPublish(t *testing.T) {
+	m := &mockKV{}
+	e := &EtcdClient{
+		key: skyDNSKey(DefaultEtcdPrefix, "leader.example.com"),
+		ttl: 30 * time.Second,
+		kv:  m,
+	}
+	if err := e.Publish(context.Background(), "10.0.0.1", 4001); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+	if exp, got := "/skydns/com/example/leader", m.key; exp != got {
+		t.Fatalf("wrong key, exp %s, got %s", exp, got)
+	}
+	if exp, got := `{"host":"10.0.0.1","port":4001,"ttl":30}`, m.val; exp != got {
+		t.Fatalf("wrong value, exp %s, got %s", exp, got)
+	}
+	if exp, got := "etcd:///skydns/com/example/leader", e.String(); exp != got {
+		t.Fatalf("wrong string, exp %s, got %s", exp, got)
+	}
+}
+
+type mockKV struct {
+	key string
+	val string
+}
+
+func (m *mockKV) Put(ctx context.Context, key, val string, opts ...clientv3.OpOption) (*clientv3.PutResponse, error) {
+	m.key = key
+	m.val = val
+	return &clientv3.PutResponse{}, nil
+}
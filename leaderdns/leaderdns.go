@@ -0,0 +1,231 @@
+// Package leaderdns publishes a DNS record pointing at the leader of the
+// cluster. Whenever a node becomes leader it updates the record to point at
+// its own HTTP API address, so that clients which cannot follow redirects
+// still reach the leader by connecting to a well-known name. The record is
+// published through a DNS provider, such as Route 53, or etcd for CoreDNS
+// and other SkyDNS-compatible servers.
+package leaderdns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// TypeRoute53 is the provider type for Amazon Route 53.
+	TypeRoute53 = "route53"
+
+	// TypeEtcd is the provider type for records stored in etcd, in the
+	// SkyDNS format read by CoreDNS's etcd plugin.
+	TypeEtcd = "etcd"
+
+	// DefaultTTL is the default TTL of the published record.
+	DefaultTTL = 30 * time.Second
+
+	// DefaultReportInterval is the default interval at which the leader
+	// publishes the record, in addition to doing so on becoming leader.
+	DefaultReportInterval = time.Minute
+
+	leaderChanLen = 5 // Support any fast back-to-back leadership changes.
+)
+
+var (
+	// ErrInvalidConfig is returned when the configuration is invalid.
+	ErrInvalidConfig = errors.New("invalid leader DNS configuration")
+)
+
+// stats captures stats for the leader DNS publisher.
+var stats *expvar.Map
+
+const (
+	numPublishes       = "num_publishes"
+	numPublishFailures = "num_publish_failures"
+)
+
+func init() {
+	stats = expvar.NewMap("leader_dns")
+	ResetStats()
+}
+
+// ResetStats resets the expvar stats for this module. Mostly for test purposes.
+func ResetStats() {
+	stats.Init()
+	stats.Add(numPublishes, 0)
+	stats.Add(numPublishFailures, 0)
+}
+
+// Provider is the interface DNS providers must implement.
+type Provider interface {
+	// Publish points the record at host, serving on port. If host is an IP
+	// address, the record is an address record, otherwise an alias.
+	Publish(ctx context.Context, host string, port int) error
+	fmt.Stringer
+}
+
+// Store is the interface the consensus system must implement.
+type Store interface {
+	IsLeader() bool
+	RegisterLeaderChange(c chan<- struct{})
+}
+
+// Config is the config file format for leader DNS publishing.
+type Config struct {
+	// Type is the DNS provider type.
+	Type string `json:"type"`
+
+	// Name is the DNS name of the record, for example
+	// leader.rqlite.example.com.
+	Name string `json:"name"`
+
+	// TTL is the TTL of the record in seconds. If not set DefaultTTL is used.
+	TTL int `json:"ttl,omitempty"`
+
+	// Sub is the configuration of the DNS provider.
+	Sub json.RawMessage `json:"sub"`
+}
+
+// ParseConfig parses the config read from r, expanding any environment
+// variables in it.
+func ParseConfig(r io.Reader) (*Config, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := json.Unmarshal([]byte(os.ExpandEnv(string(b))), cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("%w: record name not set", ErrInvalidConfig)
+	}
+	if cfg.TTL < 0 {
+		return nil, fmt.Errorf("%w: negative TTL", ErrInvalidConfig)
+	}
+	return cfg, nil
+}
+
+// NewProvider returns the DNS provider set in the config, configured by the
+// config's subconfig.
+func NewProvider(cfg *Config) (Provider, error) {
+	ttl := DefaultTTL
+	if cfg.TTL > 0 {
+		ttl = time.Duration(cfg.TTL) * time.Second
+	}
+	switch cfg.Type {
+	case TypeRoute53:
+		r53Cfg := &Route53Config{}
+		if err := json.Unmarshal(cfg.Sub, r53Cfg); err != nil {
+			return nil, err
+		}
+		return NewRoute53Client(r53Cfg, cfg.Name, ttl)
+	case TypeEtcd:
+		etcdCfg := &EtcdConfig{}
+		if err := json.Unmarshal(cfg.Sub, etcdCfg); err != nil {
+			return nil, err
+		}
+		return NewEtcdClient(etcdCfg, cfg.Name, ttl)
+	}
+	return nil, fmt.Errorf("%w: unsupported provider type %q", ErrInvalidConfig, cfg.Type)
+}
+
+// Publisher publishes the record pointing at the leader, if this node is the
+// leader.
+type Publisher struct {
+	// ReportInterval is the interval at which the leader publishes the
+	// record, in addition to doing so on becoming leader. This corrects the
+	// record if a former leader published it after losing leadership.
+	ReportInterval time.Duration
+
+	p    Provider
+	s    Store
+	host string
+	port int
+
+	logger *log.Logger
+
+	mu            sync.Mutex
+	lastPublished time.Time
+	lastErr       error
+}
+
+// NewPublisher returns a Publisher which publishes the record using p,
+// pointing at host and port when this node is the leader.
+func NewPublisher(p Provider, s Store, host string, port int) *Publisher {
+	return &Publisher{
+		ReportInterval: DefaultReportInterval,
+		p:              p,
+		s:              s,
+		host:           host,
+		port:           port,
+		logger:         log.New(os.Stderr, "[leader-dns] ", log.LstdFlags),
+	}
+}
+
+// Start starts publishing the record, whenever this node becomes leader
+// and periodically while it remains leader, until ctx is cancelled.
+func (p *Publisher) Start(ctx context.Context) {
+	ticker := time.NewTicker(p.ReportInterval)
+	defer ticker.Stop()
+	obCh := make(chan struct{}, leaderChanLen)
+	p.s.RegisterLeaderChange(obCh)
+
+	// This node may already be leader.
+	p.publish(ctx, false)
+	for {
+		select {
+		case <-ticker.C:
+			p.publish(ctx, false)
+		case <-obCh:
+			p.publish(ctx, true)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Stats returns diagnostic information on the publisher.
+func (p *Publisher) Stats() (map[string]interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	m := map[string]interface{}{
+		"provider":        p.p.String(),
+		"host":            p.host,
+		"port":            p.port,
+		"report_interval": p.ReportInterval.String(),
+		"last_published":  p.lastPublished,
+	}
+	if p.lastErr != nil {
+		m["last_error"] = p.lastErr.Error()
+	}
+	return m, nil
+}
+
+func (p *Publisher) publish(ctx context.Context, changed bool) {
+	if !p.s.IsLeader() {
+		return
+	}
+	err := p.p.Publish(ctx, p.host, p.port)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastErr = err
+	if err != nil {
+		stats.Add(numPublishFailures, 1)
+		p.logger.Printf("failed to publish leader record to %s: %s", p.p, err.Error())
+		return
+	}
+	stats.Add(numPublishes, 1)
+	p.lastPublished = time.Now()
+	if changed {
+		p.logger.Printf("published leader record to %s pointing at %s due to leadership change",
+			p.p, p.host)
+	}
+}
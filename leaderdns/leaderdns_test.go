@@ -0,0 +1,183 @@
+package leaderdns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_ParseConfig(t *testing.T) {
+	if err := os.Setenv("LEADER_DNS_ZONE", "Z123"); err != nil {
+		t.Fatalf("failed to set env var: %s", err)
+	}
+	defer os.Unsetenv("LEADER_DNS_ZONE")
+	cfg, err := ParseConfig(strings.NewReader(`{
+		"type": "route53",
+		"name": "leader.example.com",
+		"ttl": 10,
+		"sub": {"hosted_zone_id": "$LEADER_DNS_ZONE"}
+	}`))
+	if err != nil {
+		t.Fatalf("failed to parse config: %s", err)
+	}
+	if cfg.Type != TypeRoute53 || cfg.Name != "leader.example.com" || cfg.TTL != 10 {
+		t.Fatalf("unexpected config: %+v", cfg)
+	}
+	p, err := NewProvider(cfg)
+	if err != nil {
+		t.Fatalf("failed to create provider: %s", err)
+	}
+	if exp, got := "route53://Z123/leader.example.com", p.String(); exp != got {
+		t.Fatalf("wrong provider, exp %s, got %s", exp, got)
+	}
+
+	for _, s := range []string{
+		`{"type": "route53", "sub": {"hosted_zone_id": "Z123"}}`,
+		`{"type": "route53", "name": "leader.example.com", "ttl": -1}`,
+	} {
+		if _, err := ParseConfig(strings.NewReader(s)); !errors.Is(err, ErrInvalidConfig) {
+			t.Fatalf("expected ErrInvalidConfig for %s, got %v", s, err)
+		}
+	}
+}
+
+func Test_NewProviderInvalid(t *testing.T) {
+	for _, cfg := range []*Config{
+		{Type: "unknown", Name: "leader.example.com"},
+		{Type: TypeRoute53, Name: "leader.example.com", Sub: []byte(`{}`)},
+		{Type: TypeEtcd, Name: "leader.example.com", Sub: []byte(`{}`)},
+	} {
+		if _, err := NewProvider(cfg); !errors.Is(err, ErrInvalidConfig) {
+			t.Fatalf("expected ErrInvalidConfig for %+v, got %v", cfg, err)
+		}
+	}
+}
+
+func Test_PublisherLeaderChange(t *testing.T) {
+	ResetStats()
+	p := &mockProvider{ch: make(chan string, 10)}
+	s := &mockStore{}
+	pub := NewPublisher(p, s, "10.0.0.1", 4001)
+	pub.ReportInterval = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go pub.Start(ctx)
+	waitForObserver(t, s)
+
+	// Not leader, so the record must not be published.
+	s.notify()
+	select {
+	case <-p.ch:
+		t.Fatalf("record published by follower")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	s.setLeader(true)
+	s.notify()
+	select {
+	case host := <-p.ch:
+		if host != "10.0.0.1:4001" {
+			t.Fatalf("wrong record published: %s", host)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("record not published on leadership change")
+	}
+
+	st, err := pub.Stats()
+	if err != nil {
+		t.Fatalf("failed to get stats: %s", err)
+	}
+	if st["last_published"].(time.Time).IsZero() {
+		t.Fatalf("last published time not set")
+	}
+}
+
+func Test_PublisherPeriodic(t *testing.T) {
+	p := &mockProvider{ch: make(chan string, 100), err: errors.New("some error")}
+	s := &mockStore{leader: true}
+	pub := NewPublisher(p, s, "leader-host", 4001)
+	pub.ReportInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go pub.Start(ctx)
+
+	// Failed publishes are retried.
+	for i := 0; i < 3; i++ {
+		select {
+		case <-p.ch:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("record not published periodically")
+		}
+	}
+	st, _ := pub.Stats()
+	if st["last_error"] != "some error" {
+		t.Fatalf("expected last error to be reported, got %v", st["last_error"])
+	}
+}
+
+func waitForObserver(t *testing.T, s *mockStore) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		s.mu.Lock()
+		n := len(s.chans)
+		s.mu.Unlock()
+		if n > 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("leader change not observed")
+}
+
+type mockProvider struct {
+	ch  chan string
+	err error
+}
+
+func (m *mockProvider) Publish(ctx context.Context, host string, port int) error {
+	m.ch <- fmt.Sprintf("%s:%d", host, port)
+	return m.err
+}
+
+func (m *mockProvider) String() string {
+	return "mock"
+}
+
+type mockStore struct {
+	mu     sync.Mutex
+	leader bool
+	chans  []chan<- struct{}
+}
+
+func (m *mockStore) IsLeader() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.leader
+}
+
+func (m *mockStore) RegisterLeaderChange(c chan<- struct{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.chans = append(m.chans, c)
+}
+
+func (m *mockStore) setLeader(b bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.leader = b
+}
+
+func (m *mockStore) notify() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, c := range m.chans {
+		c <- struct{}{}
+	}
+}
@@ -0,0 +1,107 @@
+package leaderdns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// Route53Config is the subconfig for the Route 53 provider. If no access key
+// is set, credentials are taken from the environment.
+type Route53Config struct {
+	Endpoint        string `json:"endpoint,omitempty"`
+	Region          string `json:"region,omitempty"`
+	AccessKeyID     string `json:"access_key_id,omitempty"`
+	SecretAccessKey string `json:"secret_access_key,omitempty"`
+	HostedZoneID    string `json:"hosted_zone_id"`
+}
+
+// Route53Client publishes the record to Amazon Route 53.
+type Route53Client struct {
+	zoneID string
+	name   string
+	ttl    time.Duration
+
+	api route53API
+}
+
+// NewRoute53Client returns a client which publishes the record name, with the
+// given TTL, in the hosted zone set in cfg.
+func NewRoute53Client(cfg *Route53Config, name string, ttl time.Duration) (*Route53Client, error) {
+	if cfg.HostedZoneID == "" {
+		return nil, fmt.Errorf("%w: hosted zone ID not set", ErrInvalidConfig)
+	}
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	awsCfg := &aws.Config{
+		Endpoint: aws.String(cfg.Endpoint),
+		Region:   aws.String(region),
+	}
+	if cfg.AccessKeyID != "" {
+		awsCfg.Credentials = credentials.NewStaticCredentials(cfg.AccessKeyID, cfg.SecretAccessKey, "")
+	}
+	sess, err := session.NewSession(awsCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Route 53 session: %w", err)
+	}
+	return &Route53Client{
+		zoneID: cfg.HostedZoneID,
+		name:   name,
+		ttl:    ttl,
+		api:    route53.New(sess),
+	}, nil
+}
+
+// Publish creates or updates the record. Route 53 records carry no port, so
+// port is ignored.
+func (r *Route53Client) Publish(ctx context.Context, host string, port int) error {
+	_, err := r.api.ChangeResourceRecordSetsWithContext(ctx, &route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(r.zoneID),
+		ChangeBatch: &route53.ChangeBatch{
+			Comment: aws.String("rqlite leader"),
+			Changes: []*route53.Change{{
+				Action: aws.String(route53.ChangeActionUpsert),
+				ResourceRecordSet: &route53.ResourceRecordSet{
+					Name:            aws.String(r.name),
+					Type:            aws.String(recordType(host)),
+					TTL:             aws.Int64(int64(r.ttl / time.Second)),
+					ResourceRecords: []*route53.ResourceRecord{{Value: aws.String(host)}},
+				},
+			}},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update %s in hosted zone %s: %w", r.name, r.zoneID, err)
+	}
+	return nil
+}
+
+// String returns a string representation of the client.
+func (r *Route53Client) String() string {
+	return fmt.Sprintf("route53://%s/%s", r.zoneID, r.name)
+}
+
+// recordType returns the type of record pointing at host.
+func recordType(host string) string {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return route53.RRTypeCname
+	}
+	if ip.To4() != nil {
+		return route53.RRTypeA
+	}
+	return route53.RRTypeAaaa
+}
+
+type route53API interface {
+	ChangeResourceRecordSetsWithContext(ctx aws.Context, input *route53.ChangeResourceRecordSetsInput, opts ...request.Option) (*route53.ChangeResourceRecordSetsOutput, error)
+}
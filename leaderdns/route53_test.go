@@ -0,0 +1,60 @@
+package leaderdns
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+func Test_Route53Publish(t *testing.T) {
+	m := &mockRoute53{}
+	r := &Route53Client{
+		zoneID: "Z123",
+		name:   "leader.example.com",
+		ttl:    10 * time.Second,
+		api:    m,
+	}
+
+	for _, tc := range []struct {
+		host string
+		typ  string
+	}{
+		{"10.0.0.1", route53.RRTypeA},
+		{"fd00::1", route53.RRTypeAaaa},
+		{"node1.example.com", route53.RRTypeCname},
+	} {
+		if err := r.Publish(context.Background(), tc.host, 4001); err != nil {
+			t.Fatalf("failed to publish: %s", err)
+		}
+		if exp, got := "Z123", aws.StringValue(m.input.HostedZoneId); exp != got {
+			t.Fatalf("wrong hosted zone, exp %s, got %s", exp, got)
+		}
+		c := m.input.ChangeBatch.Changes[0]
+		if exp, got := route53.ChangeActionUpsert, aws.StringValue(c.Action); exp != got {
+			t.Fatalf("wrong action, exp %s, got %s", exp, got)
+		}
+		rrs := c.ResourceRecordSet
+		if aws.StringValue(rrs.Name) != "leader.example.com" || aws.Int64Value(rrs.TTL) != 10 {
+			t.Fatalf("wrong record set: %v", rrs)
+		}
+		if exp, got := tc.typ, aws.StringValue(rrs.Type); exp != got {
+			t.Fatalf("wrong record type for %s, exp %s, got %s", tc.host, exp, got)
+		}
+		if exp, got := tc.host, aws.StringValue(rrs.ResourceRecords[0].Value); exp != got {
+			t.Fatalf("wrong record value, exp %s, got %s", exp, got)
+		}
+	}
+}
+
+type mockRoute53 struct {
+	input *route53.ChangeResourceRecordSetsInput
+}
+
+func (m *mockRoute53) ChangeResourceRecordSetsWithContext(ctx aws.Context, input *route53.ChangeResourceRecordSetsInput, opts ...request.Option) (*route53.ChangeResourceRecordSetsOutput, error) {
+	m.input = input
+	return &route53.ChangeResourceRecordSetsOutput{}, nil
+}
@@ -0,0 +1,332 @@
+// Package leaderjob runs background jobs on the leader of the cluster only.
+// Jobs are registered with a Runner, which starts every job when this node
+// becomes leader, and stops them when it loses leadership, so that the jobs
+// of the cluster run on exactly one node at a time and move with the
+// leadership. Jobs should make any changes to the database through Raft,
+// which fails on a node that is no longer leader, since a deposed leader may
+// not learn it has lost leadership immediately.
+package leaderjob
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCheckInterval is the default interval at which the Runner checks
+	// whether this node is leader, in addition to doing so on every
+	// leadership change.
+	DefaultCheckInterval = 5 * time.Second
+
+	// DefaultRestartDelay is the default delay before a job which failed is
+	// restarted, if this node is still leader.
+	DefaultRestartDelay = 10 * time.Second
+
+	leaderChanLen = 5 // Support any fast back-to-back leadership changes.
+)
+
+var (
+	// ErrJobExists is returned when a job is registered with the name of a
+	// job already registered.
+	ErrJobExists = errors.New("job already registered")
+)
+
+// Job states, as reported by Runner.Stats.
+const (
+	StateStopped   = "stopped"
+	StateRunning   = "running"
+	StateFailed    = "failed"
+	StateCompleted = "completed"
+)
+
+// stats captures stats for the leader job runner.
+var stats *expvar.Map
+
+const (
+	numJobStarts   = "num_job_starts"
+	numJobStops    = "num_job_stops"
+	numJobFailures = "num_job_failures"
+)
+
+func init() {
+	stats = expvar.NewMap("leader_jobs")
+	ResetStats()
+}
+
+// ResetStats resets the expvar stats for this module. Mostly for test purposes.
+func ResetStats() {
+	stats.Init()
+	stats.Add(numJobStarts, 0)
+	stats.Add(numJobStops, 0)
+	stats.Add(numJobFailures, 0)
+}
+
+// Store is the interface the consensus system must implement.
+type Store interface {
+	ID() string
+	IsLeader() bool
+	LeaderID() (string, error)
+	RegisterLeaderChange(c chan<- struct{})
+}
+
+// Job is a background job which must only run on the leader.
+type Job interface {
+	// Run runs the job until ctx is cancelled, which happens when this node
+	// loses leadership, or the Runner is stopped. If Run returns an error
+	// while this node is still leader, it is called again after a delay. If
+	// it returns nil, the job is complete, and is not run again until this
+	// node next becomes leader.
+	Run(ctx context.Context) error
+}
+
+// JobFunc is an adapter allowing an ordinary function to be used as a Job.
+type JobFunc func(ctx context.Context) error
+
+// Run calls f(ctx).
+func (f JobFunc) Run(ctx context.Context) error {
+	return f(ctx)
+}
+
+// job is a registered job, and its state on this node.
+type job struct {
+	name string
+	j    Job
+
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	state     string
+	startedAt time.Time
+	stoppedAt time.Time
+	starts    int
+	failures  int
+	lastErr   error
+}
+
+// Runner runs registered jobs while this node is leader.
+type Runner struct {
+	// CheckInterval is the interval at which the Runner checks whether this
+	// node is leader, in addition to doing so on every leadership change.
+	CheckInterval time.Duration
+
+	// RestartDelay is the delay before a job which failed is restarted.
+	RestartDelay time.Duration
+
+	s Store
+
+	logger *log.Logger
+
+	mu     sync.Mutex
+	ctx    context.Context // Set once the Runner is started.
+	leader bool
+	jobs   map[string]*job
+}
+
+// New returns a Runner which runs jobs while this node is leader of the
+// cluster of s.
+func New(s Store) *Runner {
+	return &Runner{
+		CheckInterval: DefaultCheckInterval,
+		RestartDelay:  DefaultRestartDelay,
+		s:             s,
+		logger:        log.New(os.Stderr, "[leader-jobs] ", log.LstdFlags),
+		jobs:          make(map[string]*job),
+	}
+}
+
+// Register registers j under the given name. Jobs may be registered before or
+// after the Runner is started. If this node is already leader, the job is
+// started immediately.
+func (r *Runner) Register(name string, j Job) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.jobs[name]; ok {
+		return fmt.Errorf("%w: %s", ErrJobExists, name)
+	}
+	jb := &job{
+		name:  name,
+		j:     j,
+		state: StateStopped,
+	}
+	r.jobs[name] = jb
+	if r.leader {
+		r.startJob(jb)
+	}
+	return nil
+}
+
+// Start starts running jobs whenever this node is leader, until ctx is
+// cancelled. It blocks until then, and all jobs have stopped.
+func (r *Runner) Start(ctx context.Context) {
+	ticker := time.NewTicker(r.CheckInterval)
+	defer ticker.Stop()
+	obCh := make(chan struct{}, leaderChanLen)
+	r.s.RegisterLeaderChange(obCh)
+
+	r.mu.Lock()
+	r.ctx = ctx
+	r.mu.Unlock()
+
+	// This node may already be leader.
+	r.check()
+	for {
+		select {
+		case <-ticker.C:
+			r.check()
+		case <-obCh:
+			r.check()
+		case <-ctx.Done():
+			r.setLeader(false)
+			return
+		}
+	}
+}
+
+// Stats returns the ownership and status of the jobs. The owner is the node
+// running the jobs, which is the leader.
+func (r *Runner) Stats() (map[string]interface{}, error) {
+	leaderID, err := r.s.LeaderID()
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, 0, len(r.jobs))
+	for name := range r.jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	jobs := make([]map[string]interface{}, 0, len(names))
+	for _, name := range names {
+		jb := r.jobs[name]
+		m := map[string]interface{}{
+			"name":     jb.name,
+			"state":    jb.state,
+			"starts":   jb.starts,
+			"failures": jb.failures,
+		}
+		if !jb.startedAt.IsZero() {
+			m["started_at"] = jb.startedAt
+		}
+		if !jb.stoppedAt.IsZero() {
+			m["stopped_at"] = jb.stoppedAt
+		}
+		if jb.lastErr != nil {
+			m["last_error"] = jb.lastErr.Error()
+		}
+		jobs = append(jobs, m)
+	}
+	return map[string]interface{}{
+		"node_id":        r.s.ID(),
+		"owner":          leaderID,
+		"leader":         r.leader,
+		"check_interval": r.CheckInterval.String(),
+		"restart_delay":  r.RestartDelay.String(),
+		"jobs":           jobs,
+	}, nil
+}
+
+// check starts or stops the jobs, as this node has become, or is no longer,
+// leader.
+func (r *Runner) check() {
+	r.setLeader(r.s.IsLeader())
+}
+
+func (r *Runner) setLeader(leader bool) {
+	r.mu.Lock()
+	if leader == r.leader {
+		r.mu.Unlock()
+		return
+	}
+	r.leader = leader
+	if leader {
+		defer r.mu.Unlock()
+		r.logger.Printf("this node is leader, starting %d jobs", len(r.jobs))
+		for _, jb := range r.jobs {
+			r.startJob(jb)
+		}
+		return
+	}
+
+	r.logger.Printf("this node is no longer leader, stopping %d jobs", len(r.jobs))
+	var dones []chan struct{}
+	for _, jb := range r.jobs {
+		if jb.cancel != nil {
+			jb.cancel()
+			dones = append(dones, jb.done)
+		}
+	}
+	r.mu.Unlock()
+
+	// Don't consider the jobs stopped until they have actually returned, so
+	// that this node does not start them again in the meantime.
+	for _, d := range dones {
+		<-d
+	}
+}
+
+// startJob starts running jb. It must be called with mu held.
+func (r *Runner) startJob(jb *job) {
+	ctx, cancel := context.WithCancel(r.ctx)
+	jb.cancel = cancel
+	jb.done = make(chan struct{})
+	go r.runJob(ctx, jb)
+}
+
+// runJob runs jb until ctx is cancelled, restarting it whenever it fails.
+func (r *Runner) runJob(ctx context.Context, jb *job) {
+	defer func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		jb.cancel()
+		jb.cancel = nil
+		if jb.state != StateCompleted {
+			jb.state = StateStopped
+		}
+		jb.stoppedAt = time.Now()
+		stats.Add(numJobStops, 1)
+		close(jb.done)
+	}()
+
+	for {
+		r.mu.Lock()
+		jb.state = StateRunning
+		jb.startedAt = time.Now()
+		jb.starts++
+		r.mu.Unlock()
+		stats.Add(numJobStarts, 1)
+
+		err := jb.j.Run(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		r.mu.Lock()
+		jb.lastErr = err
+		if err == nil {
+			jb.state = StateCompleted
+			r.mu.Unlock()
+			r.logger.Printf("job %s completed", jb.name)
+			return
+		}
+		jb.state = StateFailed
+		jb.failures++
+		r.mu.Unlock()
+		stats.Add(numJobFailures, 1)
+		r.logger.Printf("job %s failed, restarting in %s: %s", jb.name, r.RestartDelay, err.Error())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(r.RestartDelay):
+		}
+	}
+}
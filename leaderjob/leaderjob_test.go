@@ -0,0 +1,228 @@
+package leaderjob
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_RunnerLeaderChange(t *testing.T) {
+	ResetStats()
+	s := &mockStore{id: "node1"}
+	r := New(s)
+	r.CheckInterval = time.Hour
+
+	started := make(chan struct{}, 10)
+	stopped := make(chan struct{}, 10)
+	if err := r.Register("reaper", JobFunc(func(ctx context.Context) error {
+		started <- struct{}{}
+		<-ctx.Done()
+		stopped <- struct{}{}
+		return nil
+	})); err != nil {
+		t.Fatalf("failed to register job: %s", err)
+	}
+	if err := r.Register("reaper", JobFunc(nil)); !errors.Is(err, ErrJobExists) {
+		t.Fatalf("expected ErrJobExists, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.Start(ctx)
+		close(done)
+	}()
+	waitForObserver(t, s)
+
+	// Not leader, so the job must not run.
+	s.notify()
+	select {
+	case <-started:
+		t.Fatalf("job started on follower")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	s.setLeader(true, "node1")
+	s.notify()
+	waitFor(t, started, "job not started on becoming leader")
+	st := mustStats(t, r)
+	if st["owner"] != "node1" || st["leader"] != true {
+		t.Fatalf("wrong ownership reported: %v", st)
+	}
+	if exp, got := StateRunning, jobStats(st, "reaper")["state"]; exp != got {
+		t.Fatalf("wrong job state, exp %s, got %s", exp, got)
+	}
+
+	// Jobs registered while leader start immediately.
+	if err := r.Register("scheduler", JobFunc(func(ctx context.Context) error {
+		started <- struct{}{}
+		<-ctx.Done()
+		return nil
+	})); err != nil {
+		t.Fatalf("failed to register job: %s", err)
+	}
+	waitFor(t, started, "job not started on registration")
+
+	s.setLeader(false, "node2")
+	s.notify()
+	waitFor(t, stopped, "job not stopped on losing leadership")
+	waitForState(t, r, "scheduler", StateStopped)
+	st = mustStats(t, r)
+	if st["owner"] != "node2" || st["leader"] != false {
+		t.Fatalf("wrong ownership reported: %v", st)
+	}
+	if exp, got := 1, jobStats(st, "reaper")["starts"]; exp != got {
+		t.Fatalf("wrong number of job starts, exp %d, got %d", exp, got)
+	}
+
+	// Jobs are stopped when the Runner is.
+	s.setLeader(true, "node1")
+	s.notify()
+	waitFor(t, started, "job not started on becoming leader again")
+	cancel()
+	waitFor(t, stopped, "job not stopped on Runner stopping")
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Runner did not stop")
+	}
+}
+
+func Test_RunnerRestartFailed(t *testing.T) {
+	ResetStats()
+	s := &mockStore{id: "node1", leader: true, leaderID: "node1"}
+	r := New(s)
+	r.RestartDelay = 10 * time.Millisecond
+
+	var mu sync.Mutex
+	n := 0
+	completed := make(chan struct{})
+	if err := r.Register("publisher", JobFunc(func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		n++
+		if n < 3 {
+			return errors.New("some error")
+		}
+		close(completed)
+		return nil
+	})); err != nil {
+		t.Fatalf("failed to register job: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Start(ctx)
+	waitFor(t, completed, "failed job not restarted")
+	waitForState(t, r, "publisher", StateCompleted)
+
+	js := jobStats(mustStats(t, r), "publisher")
+	if exp, got := 2, js["failures"]; exp != got {
+		t.Fatalf("wrong number of failures, exp %d, got %d", exp, got)
+	}
+	if _, ok := js["last_error"]; ok {
+		t.Fatalf("last error reported for completed job")
+	}
+	if exp, got := int64(2), stats.Get(numJobFailures).(interface{ Value() int64 }).Value(); exp != got {
+		t.Fatalf("wrong failures stat, exp %d, got %d", exp, got)
+	}
+}
+
+func mustStats(t *testing.T, r *Runner) map[string]interface{} {
+	t.Helper()
+	st, err := r.Stats()
+	if err != nil {
+		t.Fatalf("failed to get stats: %s", err)
+	}
+	return st
+}
+
+func jobStats(st map[string]interface{}, name string) map[string]interface{} {
+	for _, js := range st["jobs"].([]map[string]interface{}) {
+		if js["name"] == name {
+			return js
+		}
+	}
+	return nil
+}
+
+func waitFor(t *testing.T, ch <-chan struct{}, msg string) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatalf(msg)
+	}
+}
+
+func waitForState(t *testing.T, r *Runner, name, state string) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if jobStats(mustStats(t, r), name)["state"] == state {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not reach state %s", name, state)
+}
+
+func waitForObserver(t *testing.T, s *mockStore) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		s.mu.Lock()
+		n := len(s.chans)
+		s.mu.Unlock()
+		if n > 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("leader change not observed")
+}
+
+type mockStore struct {
+	mu       sync.Mutex
+	id       string
+	leader   bool
+	leaderID string
+	chans    []chan<- struct{}
+}
+
+func (m *mockStore) ID() string {
+	return m.id
+}
+
+func (m *mockStore) IsLeader() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.leader
+}
+
+func (m *mockStore) LeaderID() (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.leaderID, nil
+}
+
+func (m *mockStore) RegisterLeaderChange(c chan<- struct{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.chans = append(m.chans, c)
+}
+
+func (m *mockStore) setLeader(b bool, id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.leader = b
+	m.leaderID = id
+}
+
+func (m *mockStore) notify() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, c := range m.chans {
+		c <- struct{}{}
+	}
+}
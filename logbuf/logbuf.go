@@ -0,0 +1,104 @@
+// Package logbuf holds the most recent lines logged by a process in memory,
+// so that they can be retrieved without access to wherever the process's
+// logs are kept.
+package logbuf
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"sync"
+)
+
+// maxLineLen is the length beyond which a line is held in parts, so that
+// output without newlines cannot grow the buffer without bound.
+const maxLineLen = 64 * 1024
+
+// Buffer is an io.Writer which holds the last lines written to it.
+type Buffer struct {
+	mu      sync.Mutex
+	lines   []string
+	next    int
+	full    bool
+	partial []byte
+}
+
+// New returns a Buffer holding the last n lines written to it.
+func New(n int) *Buffer {
+	if n < 1 {
+		n = 1
+	}
+	return &Buffer{lines: make([]string, n)}
+}
+
+// Write implements io.Writer. A line is held once its newline is written.
+func (b *Buffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			b.partial = append(b.partial, p...)
+			if len(b.partial) >= maxLineLen {
+				b.add(string(b.partial))
+				b.partial = b.partial[:0]
+			}
+			break
+		}
+		b.partial = append(b.partial, p[:i]...)
+		b.add(string(b.partial))
+		b.partial = b.partial[:0]
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+// Lines returns the lines held, oldest first.
+func (b *Buffer) Lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.full {
+		return append([]string(nil), b.lines[:b.next]...)
+	}
+	return append(append([]string(nil), b.lines[b.next:]...), b.lines[:b.next]...)
+}
+
+func (b *Buffer) add(line string) {
+	b.lines[b.next] = line
+	b.next++
+	if b.next == len(b.lines) {
+		b.next = 0
+		b.full = true
+	}
+}
+
+// CaptureStderr copies everything subsequently written to the standard error
+// of the process, and by the standard logger, to b as well. Loggers created
+// before it is called are not captured. The returned function stops the
+// capture, once everything written has been copied.
+func CaptureStderr(b *Buffer) (func(), error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr := os.Stderr
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		io.Copy(io.MultiWriter(stderr, b), r)
+	}()
+
+	// The standard logger writes directly, so that nothing is lost if the
+	// process exits because of a fatal error.
+	os.Stderr = w
+	log.SetOutput(io.MultiWriter(stderr, b))
+	return func() {
+		log.SetOutput(stderr)
+		os.Stderr = stderr
+		w.Close()
+		<-done
+		r.Close()
+	}, nil
+}
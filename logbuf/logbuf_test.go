@@ -0,0 +1,55 @@
+package logbuf
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_Buffer(t *testing.T) {
+	b := New(3)
+	if got := b.Lines(); len(got) != 0 {
+		t.Fatalf("expected no lines, got %v", got)
+	}
+
+	fmt.Fprint(b, "one\ntw")
+	if exp, got := []string{"one"}, b.Lines(); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("wrong lines, exp %v, got %v", exp, got)
+	}
+	fmt.Fprint(b, "o\nthree\nfour\n")
+	if exp, got := []string{"two", "three", "four"}, b.Lines(); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("wrong lines, exp %v, got %v", exp, got)
+	}
+
+	fmt.Fprint(b, strings.Repeat("x", maxLineLen))
+	if got := b.Lines(); len(got[2]) != maxLineLen {
+		t.Fatalf("long line not held")
+	}
+}
+
+func Test_CaptureStderr(t *testing.T) {
+	b := New(10)
+	stop, err := CaptureStderr(b)
+	if err != nil {
+		t.Fatalf("failed to capture stderr: %s", err)
+	}
+	l := log.New(os.Stderr, "[test] ", 0)
+	l.Print("from a logger")
+	log.SetFlags(0)
+	log.Print("from the standard logger")
+	stop()
+	log.SetFlags(log.LstdFlags)
+
+	lines := b.Lines()
+	if len(lines) != 2 {
+		t.Fatalf("wrong number of lines captured: %v", lines)
+	}
+	for _, exp := range []string{"[test] from a logger", "from the standard logger"} {
+		if lines[0] != exp && lines[1] != exp {
+			t.Fatalf("line %q not captured: %v", exp, lines)
+		}
+	}
+}
@@ -0,0 +1,379 @@
+// Package mirror implements carbon-copy query mode. Queries received by a
+// node are also sent, asynchronously, to a second rqlite cluster, such as one
+// running a new version of rqlite, or with a new schema. The results from the
+// second cluster are discarded or, if requested, compared with those returned
+// by this node, so that the second cluster can be validated against real
+// queries before cutover. Mirroring never delays or fails the original query.
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rqlite/rqlite/command"
+	"github.com/rqlite/rqlite/command/encoding"
+)
+
+const (
+	// DefaultQueueSize is the default number of queries which may be waiting
+	// to be mirrored. Queries received while the queue is full are dropped.
+	DefaultQueueSize = 1024
+
+	// DefaultTimeout is the default timeout for a mirrored query.
+	DefaultTimeout = 10 * time.Second
+
+	maxRedirects = 5
+
+	// maxLoggedSQL is the number of characters of SQL logged when the
+	// results of a mirrored query differ.
+	maxLoggedSQL = 128
+)
+
+// errUnsupportedParameter is returned when a query has a parameter which
+// cannot be sent to the mirror.
+var errUnsupportedParameter = errors.New("unsupported parameter type")
+
+// stats captures stats for the mirror.
+var stats *expvar.Map
+
+const (
+	numMirrored     = "mirrored"
+	numDropped      = "dropped"
+	numSkipped      = "skipped"
+	numFailed       = "failed"
+	numCompared     = "compared"
+	numMismatches   = "mismatches"
+	numUncomparable = "uncomparable"
+)
+
+func init() {
+	stats = expvar.NewMap("mirror")
+	ResetStats()
+}
+
+// ResetStats resets the expvar stats for this module. Mostly for test purposes.
+func ResetStats() {
+	stats.Init()
+	stats.Add(numMirrored, 0)
+	stats.Add(numDropped, 0)
+	stats.Add(numSkipped, 0)
+	stats.Add(numFailed, 0)
+	stats.Add(numCompared, 0)
+	stats.Add(numMismatches, 0)
+	stats.Add(numUncomparable, 0)
+}
+
+// query is a query waiting to be mirrored, with the results this node
+// returned for it, if they are to be compared.
+type query struct {
+	qr      *command.QueryRequest
+	results []*command.QueryRows
+}
+
+// Mirror sends copies of queries to a second cluster.
+type Mirror struct {
+	target   *url.URL
+	username string
+	password string
+	client   *http.Client
+	compare  bool
+
+	queue chan *query
+
+	mu           sync.Mutex
+	lastErr      error
+	lastMismatch string
+
+	logger *log.Logger
+}
+
+// New returns a Mirror which sends queries to the cluster reachable at the
+// HTTP API URL target. Credentials for the target may be included in the URL.
+// If compare is set, the results returned by the target are compared with
+// those returned by this node, otherwise they are discarded. Up to queueSize
+// queries may wait to be mirrored. If queueSize is 0, DefaultQueueSize is used.
+func New(target string, compare bool, queueSize int) (*Mirror, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mirror URL: %s", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid mirror URL %s: scheme must be http or https", target)
+	}
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+
+	m := &Mirror{
+		target:  u,
+		compare: compare,
+		queue:   make(chan *query, queueSize),
+		logger:  log.New(os.Stderr, "[mirror] ", log.LstdFlags),
+	}
+	if u.User != nil {
+		m.username = u.User.Username()
+		m.password, _ = u.User.Password()
+		u.User = nil
+	}
+	m.client = &http.Client{
+		Timeout: DefaultTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			// Redirects are followed by do(), so that the body is resent.
+			return http.ErrUseLastResponse
+		},
+	}
+	return m, nil
+}
+
+// Mirror queues the query for sending to the target, along with the results
+// this node returned for it. results may be nil, if they are not available,
+// in which case the results from the target are not compared. Mirror never
+// blocks. If the queue is full the query is dropped.
+func (m *Mirror) Mirror(qr *command.QueryRequest, results []*command.QueryRows) {
+	if !m.compare {
+		results = nil
+	}
+	select {
+	case m.queue <- &query{qr: qr, results: results}:
+	default:
+		stats.Add(numDropped, 1)
+	}
+}
+
+// Start starts sending queued queries to the target, until ctx is done.
+func (m *Mirror) Start(ctx context.Context) {
+	m.logger.Printf("mirroring queries to %s, comparing results: %v", m.target, m.compare)
+	for {
+		select {
+		case <-ctx.Done():
+			m.logger.Println("mirror service shutting down")
+			return
+		case q := <-m.queue:
+			err := m.send(q)
+			m.mu.Lock()
+			m.lastErr = err
+			m.mu.Unlock()
+			if err != nil && err != errUnsupportedParameter {
+				stats.Add(numFailed, 1)
+				m.logger.Printf("failed to mirror query to %s: %s", m.target, err)
+			}
+		}
+	}
+}
+
+// Stats returns stats on the Mirror.
+func (m *Mirror) Stats() (map[string]interface{}, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := map[string]interface{}{
+		"target":  m.target.String(),
+		"compare": m.compare,
+		"queued":  len(m.queue),
+	}
+	if m.lastErr != nil {
+		s["last_error"] = m.lastErr.Error()
+	}
+	if m.lastMismatch != "" {
+		s["last_mismatch"] = m.lastMismatch
+	}
+	return s, nil
+}
+
+// send sends the query to the target, and compares the results if needed.
+func (m *Mirror) send(q *query) error {
+	body, err := requestBody(q.qr.GetRequest().GetStatements())
+	if err != nil {
+		stats.Add(numSkipped, 1)
+		return err
+	}
+
+	v := url.Values{}
+	switch q.qr.Level {
+	case command.QueryRequest_QUERY_REQUEST_LEVEL_NONE:
+		v.Set("level", "none")
+		if q.qr.Freshness > 0 {
+			v.Set("freshness", time.Duration(q.qr.Freshness).String())
+		}
+	case command.QueryRequest_QUERY_REQUEST_LEVEL_STRONG:
+		v.Set("level", "strong")
+	case command.QueryRequest_QUERY_REQUEST_LEVEL_LINEARIZABLE:
+		v.Set("level", "linearizable")
+	default:
+		v.Set("level", "weak")
+	}
+	if q.qr.GetRequest().GetTransaction() {
+		v.Set("transaction", "")
+	}
+
+	resp, err := m.do("/db/query", v, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	stats.Add(numMirrored, 1)
+
+	if q.results == nil {
+		return nil
+	}
+	equal, err := sameResults(q.results, b)
+	if err != nil {
+		stats.Add(numUncomparable, 1)
+		return fmt.Errorf("failed to compare results: %s", err)
+	}
+	stats.Add(numCompared, 1)
+	if !equal {
+		stats.Add(numMismatches, 1)
+		sql := statementsSQL(q.qr.GetRequest().GetStatements())
+		m.mu.Lock()
+		m.lastMismatch = sql
+		m.mu.Unlock()
+		m.logger.Printf("results from %s differ for query: %s", m.target, sql)
+	}
+	return nil
+}
+
+// do performs a POST request against the target, following any redirects to
+// the target's leader.
+func (m *Mirror) do(path string, v url.Values, body []byte) (*http.Response, error) {
+	u := *m.target
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	u.RawQuery = v.Encode()
+	target := u.String()
+
+	for i := 0; i < maxRedirects; i++ {
+		req, err := http.NewRequest("POST", target, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if m.username != "" {
+			req.SetBasicAuth(m.username, m.password)
+		}
+		resp, err := m.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		switch resp.StatusCode {
+		case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+			resp.Body.Close()
+			loc, err := resp.Location()
+			if err != nil {
+				return nil, err
+			}
+			target = loc.String()
+			continue
+		}
+		return resp, nil
+	}
+	return nil, fmt.Errorf("too many redirects")
+}
+
+// requestBody returns the body of a request to the HTTP API executing stmts.
+func requestBody(stmts []*command.Statement) ([]byte, error) {
+	body := make([]interface{}, len(stmts))
+	for i, stmt := range stmts {
+		if len(stmt.ParamSets) > 0 {
+			// Parameter sets are only supported by statements which
+			// modify the database, so are never mirrored.
+			return nil, errUnsupportedParameter
+		}
+		if len(stmt.Parameters) == 0 {
+			body[i] = stmt.Sql
+			continue
+		}
+		s := []interface{}{stmt.Sql}
+		for _, p := range stmt.Parameters {
+			var val interface{}
+			switch w := p.GetValue().(type) {
+			case *command.Parameter_I:
+				val = w.I
+			case *command.Parameter_D:
+				val = w.D
+			case *command.Parameter_B:
+				val = w.B
+			case *command.Parameter_S:
+				val = w.S
+			case nil:
+				val = nil
+			default:
+				return nil, errUnsupportedParameter
+			}
+			if p.Name != "" {
+				val = map[string]interface{}{p.Name: val}
+			}
+			s = append(s, val)
+		}
+		body[i] = s
+	}
+	return json.Marshal(body)
+}
+
+// sameResults returns whether results are the same as the results in the
+// body of a response from the HTTP API. Timings are ignored.
+func sameResults(results []*command.QueryRows, body []byte) (bool, error) {
+	enc := encoding.Encoder{}
+	b, err := enc.JSONMarshal(results)
+	if err != nil {
+		return false, err
+	}
+	var local []interface{}
+	if err := unmarshal(b, &local); err != nil {
+		return false, err
+	}
+
+	var resp struct {
+		Results []interface{} `json:"results"`
+		Error   string        `json:"error"`
+	}
+	if err := unmarshal(body, &resp); err != nil {
+		return false, err
+	}
+	if resp.Error != "" {
+		return false, errors.New(resp.Error)
+	}
+	for _, r := range append(local, resp.Results...) {
+		if m, ok := r.(map[string]interface{}); ok {
+			delete(m, "time")
+		}
+	}
+	return reflect.DeepEqual(local, resp.Results), nil
+}
+
+func unmarshal(b []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// statementsSQL returns the SQL of stmts, for logging.
+func statementsSQL(stmts []*command.Statement) string {
+	sqls := make([]string, len(stmts))
+	for i := range stmts {
+		sqls[i] = stmts[i].Sql
+	}
+	s := strings.Join(sqls, "; ")
+	if len(s) > maxLoggedSQL {
+		s = s[:maxLoggedSQL] + "..."
+	}
+	return s
+}
@@ -0,0 +1,159 @@
+package mirror
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rqlite/rqlite/command"
+)
+
+func Test_NewInvalidTarget(t *testing.T) {
+	if _, err := New("ftp://localhost:4001", false, 0); err == nil {
+		t.Fatalf("expected error for invalid mirror URL")
+	}
+}
+
+func Test_MirrorSends(t *testing.T) {
+	ResetStats()
+	target := newFakeTarget(`{"results":[{"columns":["id"],"types":["integer"],"values":[[1]],"time":0.1}]}`)
+	defer target.Close()
+
+	m, err := New("http://bob:secret@"+target.Listener.Addr().String(), false, 0)
+	if err != nil {
+		t.Fatalf("failed to create mirror: %s", err)
+	}
+	qr := queryRequest(command.QueryRequest_QUERY_REQUEST_LEVEL_NONE, "SELECT * FROM foo WHERE id=?",
+		&command.Parameter{Value: &command.Parameter_I{I: 1}})
+	if err := m.send(&query{qr: qr}); err != nil {
+		t.Fatalf("failed to mirror query: %s", err)
+	}
+	r := target.last()
+	if exp, got := `[["SELECT * FROM foo WHERE id=?",1]]`, r.body; exp != got {
+		t.Fatalf("wrong body sent, exp %s, got %s", exp, got)
+	}
+	if exp, got := "level=none", r.query; exp != got {
+		t.Fatalf("wrong query string sent, exp %s, got %s", exp, got)
+	}
+	if r.username != "bob" || r.password != "secret" {
+		t.Fatalf("wrong credentials sent: %s:%s", r.username, r.password)
+	}
+	if exp, got := "1", stats.Get(numMirrored).String(); exp != got {
+		t.Fatalf("wrong number of mirrored queries, exp %s, got %s", exp, got)
+	}
+
+	// Queries with parameters which cannot be sent are skipped.
+	qr = queryRequest(command.QueryRequest_QUERY_REQUEST_LEVEL_WEAK, "SELECT * FROM foo WHERE b=?",
+		&command.Parameter{Value: &command.Parameter_Y{Y: []byte("abc")}})
+	if err := m.send(&query{qr: qr}); err != errUnsupportedParameter {
+		t.Fatalf("expected unsupported parameter error, got %v", err)
+	}
+	if exp, got := "1", stats.Get(numSkipped).String(); exp != got {
+		t.Fatalf("wrong number of skipped queries, exp %s, got %s", exp, got)
+	}
+}
+
+func Test_MirrorCompares(t *testing.T) {
+	ResetStats()
+	target := newFakeTarget(`{"results":[{"columns":["id"],"types":["integer"],"values":[[1]],"time":0.1}]}`)
+	defer target.Close()
+
+	m, err := New(target.URL, true, 1)
+	if err != nil {
+		t.Fatalf("failed to create mirror: %s", err)
+	}
+	qr := queryRequest(command.QueryRequest_QUERY_REQUEST_LEVEL_WEAK, "SELECT id FROM foo")
+	rows := func(id int64) []*command.QueryRows {
+		return []*command.QueryRows{{
+			Columns: []string{"id"},
+			Types:   []string{"integer"},
+			Values: []*command.Values{{
+				Parameters: []*command.Parameter{{Value: &command.Parameter_I{I: id}}},
+			}},
+			Time: 0.5,
+		}}
+	}
+	if err := m.send(&query{qr: qr, results: rows(1)}); err != nil {
+		t.Fatalf("failed to mirror query: %s", err)
+	}
+	if err := m.send(&query{qr: qr, results: rows(2)}); err != nil {
+		t.Fatalf("failed to mirror query: %s", err)
+	}
+	if exp, got := "2", stats.Get(numCompared).String(); exp != got {
+		t.Fatalf("wrong number of compared queries, exp %s, got %s", exp, got)
+	}
+	if exp, got := "1", stats.Get(numMismatches).String(); exp != got {
+		t.Fatalf("wrong number of mismatches, exp %s, got %s", exp, got)
+	}
+	st, err := m.Stats()
+	if err != nil {
+		t.Fatalf("failed to get stats: %s", err)
+	}
+	if exp, got := "SELECT id FROM foo", st["last_mismatch"]; exp != got {
+		t.Fatalf("wrong last mismatch, exp %s, got %v", exp, got)
+	}
+
+	// Mirror never blocks, dropping queries once the queue is full.
+	m.Mirror(qr, rows(1))
+	m.Mirror(qr, rows(1))
+	if exp, got := "1", stats.Get(numDropped).String(); exp != got {
+		t.Fatalf("wrong number of dropped queries, exp %s, got %s", exp, got)
+	}
+
+	// The queued query is sent once the mirror is started.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Start(ctx)
+	for i := 0; stats.Get(numCompared).String() != "3"; i++ {
+		if i == 100 {
+			t.Fatalf("timed out waiting for queued query to be mirrored")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+type request struct {
+	body     string
+	query    string
+	username string
+	password string
+}
+
+type fakeTarget struct {
+	*httptest.Server
+	mu   sync.Mutex
+	reqs []request
+}
+
+// newFakeTarget returns a server which responds to every query with resp.
+func newFakeTarget(resp string) *fakeTarget {
+	f := &fakeTarget{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		u, p, _ := r.BasicAuth()
+		f.mu.Lock()
+		f.reqs = append(f.reqs, request{body: string(b), query: r.URL.RawQuery, username: u, password: p})
+		f.mu.Unlock()
+		w.Write([]byte(resp))
+	}))
+	return f
+}
+
+func (f *fakeTarget) last() request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.reqs[len(f.reqs)-1]
+}
+
+func queryRequest(lvl command.QueryRequest_Level, sql string, params ...*command.Parameter) *command.QueryRequest {
+	return &command.QueryRequest{
+		Request: &command.Request{
+			Statements: []*command.Statement{{Sql: sql, Parameters: params}},
+		},
+		Level: lvl,
+	}
+}
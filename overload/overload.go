@@ -0,0 +1,391 @@
+// Package overload provides a controller which detects when a node is
+// overloaded, so that low-priority work can be queued or shed before the
+// node falls over.
+package overload
+
+import (
+	"container/list"
+	"errors"
+	"expvar"
+	"runtime"
+	"runtime/metrics"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// PriorityLow is the priority of work which may be delayed, or shed
+	// entirely, while the node is overloaded.
+	PriorityLow = "low"
+
+	// PriorityNormal is the priority of work for which no priority is given.
+	PriorityNormal = "normal"
+
+	// PriorityHigh is the priority of latency-sensitive work.
+	PriorityHigh = "high"
+
+	// DefaultSampleInterval is the default interval at which the controller
+	// re-evaluates whether the node is overloaded.
+	DefaultSampleInterval = 100 * time.Millisecond
+
+	// DefaultQueueTimeout is the default time low-priority work waits for
+	// overload to clear before it is shed.
+	DefaultQueueTimeout = time.Second
+
+	// DefaultQueueRelease is the default number of queued low-priority
+	// requests admitted each sample interval once overload clears.
+	DefaultQueueRelease = 16
+
+	// heapObjectsMetric is the runtime metric for the bytes occupied by
+	// heap objects, the equivalent of runtime.MemStats.HeapAlloc.
+	heapObjectsMetric = "/memory/classes/heap/objects:bytes"
+)
+
+var (
+	// ErrOverloaded is returned when work is shed because the node is overloaded.
+	ErrOverloaded = errors.New("node overloaded")
+
+	// ErrUnknownPriority is returned when a priority is not recognized.
+	ErrUnknownPriority = errors.New("unknown priority")
+)
+
+// stats captures stats for the overload controller.
+var stats *expvar.Map
+
+const (
+	numOverloaded = "overloaded"
+	numAdmitted   = "admitted"
+	numQueued     = "queued"
+	numShed       = "shed"
+
+	numPoolAcquired = "pool_acquired"
+	numPoolWaited   = "pool_waited"
+	numPoolRejected = "pool_rejected"
+
+	numQueueSaturated = "queue_saturated"
+)
+
+func init() {
+	stats = expvar.NewMap("overload")
+	ResetStats()
+}
+
+// ResetStats resets the expvar stats for this module. Mostly for test purposes.
+func ResetStats() {
+	stats.Init()
+	stats.Add(numOverloaded, 0)
+	stats.Add(numAdmitted, 0)
+	stats.Add(numQueued, 0)
+	stats.Add(numShed, 0)
+	stats.Add(numPoolAcquired, 0)
+	stats.Add(numPoolWaited, 0)
+	stats.Add(numPoolRejected, 0)
+	stats.Add(numQueueSaturated, 0)
+}
+
+// ParsePriority returns the priority named by s. An empty string is
+// normal priority.
+func ParsePriority(s string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "":
+		return PriorityNormal, nil
+	case PriorityLow:
+		return PriorityLow, nil
+	case PriorityNormal:
+		return PriorityNormal, nil
+	case PriorityHigh:
+		return PriorityHigh, nil
+	}
+	return "", ErrUnknownPriority
+}
+
+// Lower returns the lower of the two priorities.
+func Lower(a, b string) string {
+	if rank[b] < rank[a] {
+		return b
+	}
+	return a
+}
+
+// rank orders the priorities.
+var rank = map[string]int{
+	PriorityLow:    0,
+	PriorityNormal: 1,
+	PriorityHigh:   2,
+}
+
+// Config sets the thresholds above which a node is considered overloaded.
+// A zero threshold is not checked.
+type Config struct {
+	// MaxApplyLatency is the smoothed latency of writes above which the
+	// node is overloaded.
+	MaxApplyLatency time.Duration
+
+	// MaxQueryLatency is the smoothed latency of reads above which the
+	// node is overloaded.
+	MaxQueryLatency time.Duration
+
+	// MaxGoroutines is the number of goroutines above which the node
+	// is overloaded.
+	MaxGoroutines int
+
+	// MaxHeap is the number of bytes of allocated heap above which the
+	// node is overloaded.
+	MaxHeap uint64
+
+	// QueueTimeout is how long low-priority work waits for overload to
+	// clear before it is shed. If zero, DefaultQueueTimeout is used.
+	QueueTimeout time.Duration
+
+	// SampleInterval is how often the controller re-evaluates whether the
+	// node is overloaded. If zero, DefaultSampleInterval is used.
+	SampleInterval time.Duration
+
+	// QueueRelease is how many queued low-priority requests are admitted
+	// each sample interval once overload clears, so that the backlog does
+	// not immediately overload the node again. If zero,
+	// DefaultQueueRelease is used.
+	QueueRelease int
+}
+
+// Enabled returns whether any threshold is set.
+func (c Config) Enabled() bool {
+	return c.MaxApplyLatency > 0 || c.MaxQueryLatency > 0 || c.MaxGoroutines > 0 || c.MaxHeap > 0
+}
+
+// latency tracks the smoothed latency of a single kind of operation. The
+// mean latency of each sample interval is folded into an exponentially
+// weighted moving average, so latency decays when there is no traffic.
+type latency struct {
+	sum      time.Duration
+	n        int64
+	smoothed time.Duration
+}
+
+func (l *latency) observe(d time.Duration) {
+	l.sum += d
+	l.n++
+}
+
+func (l *latency) sample() time.Duration {
+	var mean time.Duration
+	if l.n > 0 {
+		mean = l.sum / time.Duration(l.n)
+	}
+	l.smoothed = (l.smoothed + mean) / 2
+	l.sum, l.n = 0, 0
+	return l.smoothed
+}
+
+// Controller monitors apply and query latency, as well as goroutine and
+// memory pressure, and decides whether work should be admitted. Safe for
+// use from multiple goroutines.
+type Controller struct {
+	cfg Config
+
+	mu         sync.Mutex
+	apply      latency
+	query      latency
+	goroutines int
+	heap       uint64
+	overloaded bool
+	reason     string
+	since      time.Time
+	waiters    *list.List // Channels of queued low-priority work, oldest first.
+
+	heapAlloc    func() uint64
+	numGoroutine func() int
+
+	wg    sync.WaitGroup
+	close chan struct{}
+}
+
+// New returns a new Controller using the given thresholds.
+func New(cfg Config) *Controller {
+	if cfg.QueueTimeout == 0 {
+		cfg.QueueTimeout = DefaultQueueTimeout
+	}
+	if cfg.SampleInterval == 0 {
+		cfg.SampleInterval = DefaultSampleInterval
+	}
+	if cfg.QueueRelease == 0 {
+		cfg.QueueRelease = DefaultQueueRelease
+	}
+	return &Controller{
+		cfg:          cfg,
+		waiters:      list.New(),
+		heapAlloc:    readHeapAlloc,
+		numGoroutine: runtime.NumGoroutine,
+		close:        make(chan struct{}),
+	}
+}
+
+// Start starts the controller sampling load.
+func (c *Controller) Start() {
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		ticker := time.NewTicker(c.cfg.SampleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.close:
+				return
+			case <-ticker.C:
+				c.Sample()
+			}
+		}
+	}()
+}
+
+// Close stops the controller sampling load.
+func (c *Controller) Close() {
+	close(c.close)
+	c.wg.Wait()
+}
+
+// ObserveApply records the latency of a write.
+func (c *Controller) ObserveApply(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.apply.observe(d)
+}
+
+// ObserveQuery records the latency of a read.
+func (c *Controller) ObserveQuery(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.query.observe(d)
+}
+
+// Sample re-evaluates whether the node is overloaded, and if it is not,
+// admits the next batch of queued low-priority work. It is called
+// periodically once the controller is started.
+func (c *Controller) Sample() {
+	heap := c.heapAlloc()
+	goroutines := c.numGoroutine()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	applyLat := c.apply.sample()
+	queryLat := c.query.sample()
+	c.goroutines = goroutines
+	c.heap = heap
+
+	reason := ""
+	switch {
+	case c.cfg.MaxApplyLatency > 0 && applyLat > c.cfg.MaxApplyLatency:
+		reason = "apply latency"
+	case c.cfg.MaxQueryLatency > 0 && queryLat > c.cfg.MaxQueryLatency:
+		reason = "query latency"
+	case c.cfg.MaxGoroutines > 0 && goroutines > c.cfg.MaxGoroutines:
+		reason = "goroutines"
+	case c.cfg.MaxHeap > 0 && heap > c.cfg.MaxHeap:
+		reason = "heap"
+	}
+
+	c.reason = reason
+	if reason != "" && !c.overloaded {
+		stats.Add(numOverloaded, 1)
+		c.overloaded = true
+		c.since = time.Now()
+	} else if reason == "" && c.overloaded {
+		c.overloaded = false
+	}
+
+	if !c.overloaded {
+		for i := 0; i < c.cfg.QueueRelease && c.waiters.Len() > 0; i++ {
+			close(c.waiters.Remove(c.waiters.Front()).(chan struct{}))
+		}
+	}
+}
+
+// Overloaded returns whether the node is currently overloaded.
+func (c *Controller) Overloaded() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.overloaded
+}
+
+// Admit returns nil if work of the given priority may proceed. Normal and
+// high-priority work is always admitted. While the node is overloaded, or
+// work queued during an overload remains, low-priority work is queued.
+// Once the overload clears, queued work is admitted in batches each sample
+// interval, oldest first. ErrOverloaded is returned if work is not admitted
+// within the queue timeout, or if done is closed first.
+func (c *Controller) Admit(priority string, done <-chan struct{}) error {
+	c.mu.Lock()
+	if priority != PriorityLow || (!c.overloaded && c.waiters.Len() == 0) {
+		c.mu.Unlock()
+		stats.Add(numAdmitted, 1)
+		return nil
+	}
+	ch := make(chan struct{})
+	e := c.waiters.PushBack(ch)
+	c.mu.Unlock()
+
+	stats.Add(numQueued, 1)
+	timer := time.NewTimer(c.cfg.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case <-ch:
+		stats.Add(numAdmitted, 1)
+		return nil
+	case <-timer.C:
+	case <-done:
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-ch:
+		// Released while giving up, so the work may proceed after all.
+		stats.Add(numAdmitted, 1)
+		return nil
+	default:
+	}
+	c.waiters.Remove(e)
+	stats.Add(numShed, 1)
+	return ErrOverloaded
+}
+
+// RetryAfter returns how long clients should wait before retrying shed work.
+func (c *Controller) RetryAfter() time.Duration {
+	return c.cfg.QueueTimeout
+}
+
+// readHeapAlloc returns the bytes occupied by heap objects. Unlike
+// runtime.ReadMemStats, reading it does not stop the world.
+func readHeapAlloc() uint64 {
+	s := []metrics.Sample{{Name: heapObjectsMetric}}
+	metrics.Read(s)
+	if s[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return s[0].Value.Uint64()
+}
+
+// Stats returns stats on the Controller.
+func (c *Controller) Stats() (map[string]interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m := map[string]interface{}{
+		"overloaded":        c.overloaded,
+		"apply_latency":     c.apply.smoothed.String(),
+		"query_latency":     c.query.smoothed.String(),
+		"goroutines":        c.goroutines,
+		"heap_alloc":        c.heap,
+		"queue_length":      c.waiters.Len(),
+		"queue_timeout":     c.cfg.QueueTimeout.String(),
+		"max_apply_latency": c.cfg.MaxApplyLatency.String(),
+		"max_query_latency": c.cfg.MaxQueryLatency.String(),
+		"max_goroutines":    c.cfg.MaxGoroutines,
+		"max_heap":          c.cfg.MaxHeap,
+	}
+	if c.overloaded {
+		m["reason"] = c.reason
+		m["since"] = c.since
+	}
+	return m, nil
+}
@@ -0,0 +1,215 @@
+package overload
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_ParsePriority(t *testing.T) {
+	for _, tt := range []struct {
+		s   string
+		exp string
+		err error
+	}{
+		{"", PriorityNormal, nil},
+		{"low", PriorityLow, nil},
+		{"LOW", PriorityLow, nil},
+		{" normal ", PriorityNormal, nil},
+		{"high", PriorityHigh, nil},
+		{"urgent", "", ErrUnknownPriority},
+	} {
+		p, err := ParsePriority(tt.s)
+		if err != tt.err {
+			t.Fatalf("wrong error for %q, exp %v, got %v", tt.s, tt.err, err)
+		}
+		if p != tt.exp {
+			t.Fatalf("wrong priority for %q, exp %s, got %s", tt.s, tt.exp, p)
+		}
+	}
+}
+
+func Test_Config_Enabled(t *testing.T) {
+	if (Config{}).Enabled() {
+		t.Fatalf("empty config is enabled")
+	}
+	if (Config{QueueTimeout: time.Second}).Enabled() {
+		t.Fatalf("config without thresholds is enabled")
+	}
+	if !(Config{MaxGoroutines: 10}).Enabled() {
+		t.Fatalf("config with threshold is not enabled")
+	}
+}
+
+func Test_Controller_Latency(t *testing.T) {
+	c := newTestController(Config{MaxQueryLatency: 100 * time.Millisecond}, 1, 0)
+	c.Sample()
+	if c.Overloaded() {
+		t.Fatalf("controller overloaded without load")
+	}
+
+	for i := 0; i < 10; i++ {
+		c.ObserveQuery(time.Second)
+	}
+	c.Sample()
+	if !c.Overloaded() {
+		t.Fatalf("controller not overloaded with high query latency")
+	}
+
+	// With no further traffic latency decays, clearing the overload.
+	for i := 0; i < 10 && c.Overloaded(); i++ {
+		c.Sample()
+	}
+	if c.Overloaded() {
+		t.Fatalf("controller still overloaded after latency decayed")
+	}
+}
+
+func Test_Controller_GoroutinesHeap(t *testing.T) {
+	c := newTestController(Config{MaxGoroutines: 100}, 101, 0)
+	c.Sample()
+	if !c.Overloaded() {
+		t.Fatalf("controller not overloaded with too many goroutines")
+	}
+	c.numGoroutine = func() int { return 50 }
+	c.Sample()
+	if c.Overloaded() {
+		t.Fatalf("controller overloaded with few goroutines")
+	}
+
+	c = newTestController(Config{MaxHeap: 1000}, 1, 1001)
+	c.Sample()
+	if !c.Overloaded() {
+		t.Fatalf("controller not overloaded with large heap")
+	}
+	m, err := c.Stats()
+	if err != nil {
+		t.Fatalf("failed to get stats: %s", err.Error())
+	}
+	if got, exp := m["reason"], "heap"; got != exp {
+		t.Fatalf("wrong reason, exp %s, got %v", exp, got)
+	}
+}
+
+func Test_Controller_Admit(t *testing.T) {
+	c := newTestController(Config{MaxGoroutines: 100, QueueTimeout: 100 * time.Millisecond}, 1, 0)
+	c.Sample()
+	for _, p := range []string{PriorityLow, PriorityNormal, PriorityHigh} {
+		if err := c.Admit(p, nil); err != nil {
+			t.Fatalf("%s priority not admitted without load: %s", p, err)
+		}
+	}
+
+	c.numGoroutine = func() int { return 101 }
+	c.Sample()
+	if err := c.Admit(PriorityNormal, nil); err != nil {
+		t.Fatalf("normal priority not admitted under load: %s", err)
+	}
+	if err := c.Admit(PriorityHigh, nil); err != nil {
+		t.Fatalf("high priority not admitted under load: %s", err)
+	}
+	if err := c.Admit(PriorityLow, nil); err != ErrOverloaded {
+		t.Fatalf("low priority not shed under load, got: %v", err)
+	}
+
+	done := make(chan struct{})
+	close(done)
+	c.cfg.QueueTimeout = time.Minute
+	if err := c.Admit(PriorityLow, done); err != ErrOverloaded {
+		t.Fatalf("low priority not shed when done, got: %v", err)
+	}
+
+	// Queued low-priority work is admitted once the overload clears.
+	errCh := make(chan error)
+	go func() {
+		errCh <- c.Admit(PriorityLow, nil)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	c.numGoroutine = func() int { return 1 }
+	c.Sample()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("queued low priority not admitted: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for queued low priority work")
+	}
+}
+
+func Test_Controller_AdmitGradual(t *testing.T) {
+	c := newTestController(Config{MaxGoroutines: 100, QueueTimeout: time.Minute, QueueRelease: 2}, 101, 0)
+	c.Sample()
+
+	errCh := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		go func() {
+			errCh <- c.Admit(PriorityLow, nil)
+		}()
+	}
+	waitQueued := func(n int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			c.mu.Lock()
+			l := c.waiters.Len()
+			c.mu.Unlock()
+			if l == n {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %d queued, have %d", n, l)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitQueued(5)
+
+	// Only a batch of queued work is admitted each sample once the
+	// overload clears, and new work queues behind it.
+	c.numGoroutine = func() int { return 1 }
+	c.Sample()
+	waitQueued(3)
+	go func() {
+		errCh <- c.Admit(PriorityLow, nil)
+	}()
+	waitQueued(4)
+	c.Sample()
+	waitQueued(2)
+	c.Sample()
+	c.Sample()
+	waitQueued(0)
+	for i := 0; i < 6; i++ {
+		if err := <-errCh; err != nil {
+			t.Fatalf("queued low priority not admitted: %s", err)
+		}
+	}
+	if err := c.Admit(PriorityLow, nil); err != nil {
+		t.Fatalf("low priority not admitted with empty queue: %s", err)
+	}
+}
+
+func Test_readHeapAlloc(t *testing.T) {
+	if readHeapAlloc() == 0 {
+		t.Fatalf("heap allocation not read")
+	}
+}
+
+func Test_Controller_StartClose(t *testing.T) {
+	c := New(Config{MaxGoroutines: 1, SampleInterval: 10 * time.Millisecond})
+	c.Start()
+	defer c.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for !c.Overloaded() {
+		if time.Now().After(deadline) {
+			t.Fatalf("controller never sampled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func newTestController(cfg Config, goroutines int, heap uint64) *Controller {
+	c := New(cfg)
+	c.numGoroutine = func() int { return goroutines }
+	c.heapAlloc = func() uint64 { return heap }
+	return c
+}
@@ -0,0 +1,105 @@
+package overload
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ErrPoolExhausted is returned when a slot in a pool could not be acquired.
+var ErrPoolExhausted = errors.New("concurrency limit reached")
+
+// pool is a fixed-size pool of slots.
+type pool struct {
+	sem     chan struct{}
+	waiting int64
+}
+
+// Pools is a set of named concurrency pools. Work acquires a slot in the
+// pool for its class before it runs, so that one class of work cannot
+// consume all the capacity needed by another. Safe for use from multiple
+// goroutines.
+type Pools struct {
+	timeout time.Duration
+	pools   map[string]*pool
+	queue   *Queue
+}
+
+// NewPools returns a new set of pools, each with the given number of slots.
+// Names with a size of zero or less, and names not in sizes, are not limited.
+// Work waits at most timeout for a slot. If timeout is zero, work waits for
+// as long as it takes.
+func NewPools(sizes map[string]int, timeout time.Duration) *Pools {
+	p := &Pools{
+		timeout: timeout,
+		pools:   make(map[string]*pool),
+	}
+	for name, sz := range sizes {
+		if sz > 0 {
+			p.pools[name] = &pool{sem: make(chan struct{}, sz)}
+		}
+	}
+	return p
+}
+
+// SetQueue sets the queue which tracks work waiting for a slot. It must be
+// called before the pools are used.
+func (p *Pools) SetQueue(q *Queue) {
+	p.queue = q
+}
+
+// Acquire acquires a slot in the named pool, returning a function which
+// must be called to release it. If no slot becomes free before the timeout
+// expires, or done is closed, ErrPoolExhausted is returned.
+func (p *Pools) Acquire(name string, done <-chan struct{}) (func(), error) {
+	pl, ok := p.pools[name]
+	if !ok {
+		return func() {}, nil
+	}
+	release := func() { <-pl.sem }
+	leave := p.queue.Enter()
+	defer leave()
+
+	select {
+	case pl.sem <- struct{}{}:
+		stats.Add(numPoolAcquired, 1)
+		return release, nil
+	default:
+	}
+
+	stats.Add(numPoolWaited, 1)
+	atomic.AddInt64(&pl.waiting, 1)
+	defer atomic.AddInt64(&pl.waiting, -1)
+
+	var timeoutCh <-chan time.Time
+	if p.timeout > 0 {
+		timer := time.NewTimer(p.timeout)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+	select {
+	case pl.sem <- struct{}{}:
+		stats.Add(numPoolAcquired, 1)
+		return release, nil
+	case <-timeoutCh:
+	case <-done:
+	}
+	stats.Add(numPoolRejected, 1)
+	return nil, ErrPoolExhausted
+}
+
+// Stats returns stats on the Pools.
+func (p *Pools) Stats() (map[string]interface{}, error) {
+	m := make(map[string]interface{}, len(p.pools))
+	for name, pl := range p.pools {
+		m[name] = map[string]interface{}{
+			"size":    cap(pl.sem),
+			"in_use":  len(pl.sem),
+			"waiting": atomic.LoadInt64(&pl.waiting),
+		}
+	}
+	return map[string]interface{}{
+		"timeout": p.timeout.String(),
+		"pools":   m,
+	}, nil
+}
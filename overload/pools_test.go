@@ -0,0 +1,93 @@
+package overload
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_Lower(t *testing.T) {
+	if got := Lower(PriorityHigh, PriorityLow); got != PriorityLow {
+		t.Fatalf("wrong lower priority: %s", got)
+	}
+	if got := Lower(PriorityLow, PriorityHigh); got != PriorityLow {
+		t.Fatalf("wrong lower priority: %s", got)
+	}
+	if got := Lower(PriorityNormal, PriorityHigh); got != PriorityNormal {
+		t.Fatalf("wrong lower priority: %s", got)
+	}
+}
+
+func Test_Pools_Unlimited(t *testing.T) {
+	p := NewPools(map[string]int{PriorityLow: 0}, time.Millisecond)
+	for i := 0; i < 100; i++ {
+		if _, err := p.Acquire(PriorityLow, nil); err != nil {
+			t.Fatalf("failed to acquire from unlimited pool: %s", err)
+		}
+		if _, err := p.Acquire("unknown", nil); err != nil {
+			t.Fatalf("failed to acquire from unknown pool: %s", err)
+		}
+	}
+}
+
+func Test_Pools_Limited(t *testing.T) {
+	p := NewPools(map[string]int{PriorityLow: 2, PriorityHigh: 1}, 50*time.Millisecond)
+
+	r1, err := p.Acquire(PriorityLow, nil)
+	if err != nil {
+		t.Fatalf("failed to acquire: %s", err)
+	}
+	r2, err := p.Acquire(PriorityLow, nil)
+	if err != nil {
+		t.Fatalf("failed to acquire: %s", err)
+	}
+	if _, err := p.Acquire(PriorityLow, nil); err != ErrPoolExhausted {
+		t.Fatalf("acquired from exhausted pool, got: %v", err)
+	}
+
+	// A full pool for one priority does not affect another.
+	r3, err := p.Acquire(PriorityHigh, nil)
+	if err != nil {
+		t.Fatalf("failed to acquire from separate pool: %s", err)
+	}
+	r3()
+
+	// Waiting work acquires a slot once one is released.
+	errCh := make(chan error)
+	go func() {
+		release, err := p.Acquire(PriorityLow, nil)
+		if err == nil {
+			release()
+		}
+		errCh <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	r1()
+	if err := <-errCh; err != nil {
+		t.Fatalf("waiting work failed to acquire: %s", err)
+	}
+	r2()
+
+	m, err := p.Stats()
+	if err != nil {
+		t.Fatalf("failed to get stats: %s", err)
+	}
+	low := m["pools"].(map[string]interface{})[PriorityLow].(map[string]interface{})
+	if got, exp := low["in_use"], 0; got != exp {
+		t.Fatalf("wrong in_use, exp %d, got %v", exp, got)
+	}
+	if got, exp := low["size"], 2; got != exp {
+		t.Fatalf("wrong size, exp %d, got %v", exp, got)
+	}
+}
+
+func Test_Pools_Done(t *testing.T) {
+	p := NewPools(map[string]int{PriorityNormal: 1}, 0)
+	if _, err := p.Acquire(PriorityNormal, nil); err != nil {
+		t.Fatalf("failed to acquire: %s", err)
+	}
+	done := make(chan struct{})
+	close(done)
+	if _, err := p.Acquire(PriorityNormal, done); err != ErrPoolExhausted {
+		t.Fatalf("acquired from exhausted pool, got: %v", err)
+	}
+}
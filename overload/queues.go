@@ -0,0 +1,281 @@
+package overload
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Names of the queues monitored on a node.
+const (
+	// QueueRaftApply holds writes waiting to be committed and applied
+	// through the Raft log.
+	QueueRaftApply = "raft_apply"
+
+	// QueueHTTPPool holds HTTP requests waiting for a slot in their
+	// concurrency pool.
+	QueueHTTPPool = "http_pool"
+
+	// QueueWrites holds queued writes waiting to be batched.
+	QueueWrites = "queued_writes"
+
+	// QueueForward holds requests forwarded to other nodes, waiting for
+	// their responses.
+	QueueForward = "forward"
+
+	// QueueCDC holds committed log entries whose changes are waiting to be
+	// published by change data capture.
+	QueueCDC = "cdc"
+)
+
+// queueNames are the names of the queues for which SLOs may be set.
+var queueNames = map[string]bool{
+	QueueRaftApply: true,
+	QueueHTTPPool:  true,
+	QueueWrites:    true,
+	QueueForward:   true,
+	QueueCDC:       true,
+}
+
+// QueueSLO sets the thresholds above which a queue is saturated. A zero
+// threshold is not checked.
+type QueueSLO struct {
+	// MaxDepth is the number of entries above which the queue is saturated.
+	MaxDepth int
+
+	// MaxWait is the smoothed time entries wait in the queue above which
+	// the queue is saturated.
+	MaxWait time.Duration
+}
+
+// ParseQueueSLOs parses a comma-separated list of queue SLOs, each of the
+// form name=depth/wait, for example "raft_apply=1000/500ms,forward=/2s".
+// Either threshold may be omitted. Only the queues named by the Queue
+// constants may be given.
+func ParseQueueSLOs(s string) (map[string]QueueSLO, error) {
+	slos := make(map[string]QueueSLO)
+	for _, spec := range strings.Split(s, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		parts := strings.SplitN(spec, "=", 2)
+		thresholds := strings.SplitN(parts[len(parts)-1], "/", 2)
+		if len(parts) != 2 || parts[0] == "" || len(thresholds) != 2 {
+			return nil, fmt.Errorf("invalid queue SLO %q, must be name=depth/wait", spec)
+		}
+		if !queueNames[parts[0]] {
+			return nil, fmt.Errorf("unknown queue %q in queue SLO", parts[0])
+		}
+		var slo QueueSLO
+		if thresholds[0] != "" {
+			d, err := strconv.Atoi(thresholds[0])
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("invalid depth in queue SLO %q", spec)
+			}
+			slo.MaxDepth = d
+		}
+		if thresholds[1] != "" {
+			w, err := time.ParseDuration(thresholds[1])
+			if err != nil || w < 0 {
+				return nil, fmt.Errorf("invalid wait in queue SLO %q", spec)
+			}
+			slo.MaxWait = w
+		}
+		slos[parts[0]] = slo
+	}
+	return slos, nil
+}
+
+// Queue tracks the number of entries in a queue, and the time they wait in
+// it. Safe for use from multiple goroutines. A nil Queue tracks nothing.
+type Queue struct {
+	depth   int64
+	depthFn func() int
+
+	mu   sync.Mutex
+	wait latency
+}
+
+// Enter records an entry joining the queue, returning a function which must
+// be called once it leaves.
+func (q *Queue) Enter() func() {
+	if q == nil {
+		return func() {}
+	}
+	atomic.AddInt64(&q.depth, 1)
+	start := time.Now()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			atomic.AddInt64(&q.depth, -1)
+			q.mu.Lock()
+			defer q.mu.Unlock()
+			q.wait.observe(time.Since(start))
+		})
+	}
+}
+
+// Depth returns the number of entries in the queue.
+func (q *Queue) Depth() int {
+	if q.depthFn != nil {
+		return q.depthFn()
+	}
+	return int(atomic.LoadInt64(&q.depth))
+}
+
+// Queues monitors the queues of a node, each against its SLO, so that a
+// node whose queues are saturated can stop being sent work. Safe for use
+// from multiple goroutines.
+type Queues struct {
+	slos     map[string]QueueSLO
+	interval time.Duration
+
+	mu        sync.Mutex
+	queues    map[string]*Queue
+	depths    map[string]int
+	waits     map[string]time.Duration
+	saturated string
+	since     time.Time
+
+	wg    sync.WaitGroup
+	close chan struct{}
+}
+
+// NewQueues returns a monitor which checks queues against the given SLOs
+// every interval. If interval is zero, DefaultSampleInterval is used.
+func NewQueues(slos map[string]QueueSLO, interval time.Duration) *Queues {
+	if interval == 0 {
+		interval = DefaultSampleInterval
+	}
+	return &Queues{
+		slos:     slos,
+		interval: interval,
+		queues:   make(map[string]*Queue),
+		depths:   make(map[string]int),
+		waits:    make(map[string]time.Duration),
+		close:    make(chan struct{}),
+	}
+}
+
+// Queue returns the named queue, creating it if necessary.
+func (qs *Queues) Queue(name string) *Queue {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	q, ok := qs.queues[name]
+	if !ok {
+		q = &Queue{}
+		qs.queues[name] = q
+	}
+	return q
+}
+
+// Register registers a queue whose depth is reported by depth, rather than
+// tracked by Enter. The time entries wait in it is not tracked.
+func (qs *Queues) Register(name string, depth func() int) {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	qs.queues[name] = &Queue{depthFn: depth}
+}
+
+// Start starts the monitor sampling the queues.
+func (qs *Queues) Start() {
+	qs.wg.Add(1)
+	go func() {
+		defer qs.wg.Done()
+		ticker := time.NewTicker(qs.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-qs.close:
+				return
+			case <-ticker.C:
+				qs.Sample()
+			}
+		}
+	}()
+}
+
+// Close stops the monitor sampling the queues.
+func (qs *Queues) Close() {
+	close(qs.close)
+	qs.wg.Wait()
+}
+
+// Sample re-evaluates whether any queue is saturated. It is called
+// periodically once the monitor is started.
+func (qs *Queues) Sample() {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+
+	names := make([]string, 0, len(qs.queues))
+	for name := range qs.queues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	reason := ""
+	for _, name := range names {
+		q := qs.queues[name]
+		depth := q.Depth()
+		q.mu.Lock()
+		wait := q.wait.sample()
+		q.mu.Unlock()
+		qs.depths[name] = depth
+		qs.waits[name] = wait
+
+		slo := qs.slos[name]
+		if reason != "" {
+			continue
+		}
+		if slo.MaxDepth > 0 && depth > slo.MaxDepth {
+			reason = fmt.Sprintf("%s depth %d exceeds %d", name, depth, slo.MaxDepth)
+		} else if slo.MaxWait > 0 && wait > slo.MaxWait {
+			reason = fmt.Sprintf("%s wait %s exceeds %s", name, wait, slo.MaxWait)
+		}
+	}
+
+	if reason != "" && qs.saturated == "" {
+		stats.Add(numQueueSaturated, 1)
+		qs.since = time.Now()
+	}
+	qs.saturated = reason
+}
+
+// Saturated returns why a queue is saturated, or the empty string if none is.
+func (qs *Queues) Saturated() string {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	return qs.saturated
+}
+
+// Stats returns stats on the Queues.
+func (qs *Queues) Stats() (map[string]interface{}, error) {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	queues := make(map[string]interface{}, len(qs.queues))
+	for name := range qs.queues {
+		m := map[string]interface{}{
+			"depth": qs.depths[name],
+			"wait":  qs.waits[name].String(),
+		}
+		if slo, ok := qs.slos[name]; ok {
+			m["max_depth"] = slo.MaxDepth
+			m["max_wait"] = slo.MaxWait.String()
+		}
+		queues[name] = m
+	}
+	m := map[string]interface{}{
+		"saturated": qs.saturated != "",
+		"queues":    queues,
+	}
+	if qs.saturated != "" {
+		m["reason"] = qs.saturated
+		m["since"] = qs.since
+	}
+	return m, nil
+}
@@ -0,0 +1,85 @@
+package overload
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_ParseQueueSLOs(t *testing.T) {
+	slos, err := ParseQueueSLOs("raft_apply=1000/500ms, forward=/2s,http_pool=10/")
+	if err != nil {
+		t.Fatalf("failed to parse queue SLOs: %s", err)
+	}
+	exp := map[string]QueueSLO{
+		QueueRaftApply: {MaxDepth: 1000, MaxWait: 500 * time.Millisecond},
+		QueueForward:   {MaxWait: 2 * time.Second},
+		QueueHTTPPool:  {MaxDepth: 10},
+	}
+	if !reflect.DeepEqual(exp, slos) {
+		t.Fatalf("wrong queue SLOs, exp %v, got %v", exp, slos)
+	}
+	if slos, err := ParseQueueSLOs(""); err != nil || len(slos) != 0 {
+		t.Fatalf("wrong result for no queue SLOs: %v, %v", slos, err)
+	}
+
+	for _, s := range []string{"raft_apply", "raft_apply=10", "=10/1s", "foo=10/1s", "forward=x/1s", "forward=-1/", "forward=/x"} {
+		if _, err := ParseQueueSLOs(s); err == nil {
+			t.Fatalf("parsed invalid queue SLO %q", s)
+		}
+	}
+}
+
+func Test_Queues(t *testing.T) {
+	qs := NewQueues(map[string]QueueSLO{
+		QueueRaftApply: {MaxDepth: 1},
+		QueueForward:   {MaxWait: 10 * time.Millisecond},
+	}, 0)
+	apply := qs.Queue(QueueRaftApply)
+	if qs.Queue(QueueRaftApply) != apply {
+		t.Fatalf("queue not returned by name")
+	}
+	writes := 0
+	qs.Register(QueueWrites, func() int { return writes })
+
+	qs.Sample()
+	if reason := qs.Saturated(); reason != "" {
+		t.Fatalf("queues saturated when empty: %s", reason)
+	}
+
+	leave1 := apply.Enter()
+	leave2 := apply.Enter()
+	writes = 100
+	qs.Sample()
+	if reason := qs.Saturated(); reason != "raft_apply depth 2 exceeds 1" {
+		t.Fatalf("wrong saturation reason: %q", reason)
+	}
+	stats, _ := qs.Stats()
+	queues := stats["queues"].(map[string]interface{})
+	if d := queues[QueueRaftApply].(map[string]interface{})["depth"]; d != 2 {
+		t.Fatalf("wrong raft apply depth in stats: %v", d)
+	}
+	if d := queues[QueueWrites].(map[string]interface{})["depth"]; d != 100 {
+		t.Fatalf("wrong queued writes depth in stats: %v", d)
+	}
+	leave1()
+	leave1()
+	leave2()
+	qs.Sample()
+	if reason := qs.Saturated(); reason != "" {
+		t.Fatalf("queues saturated once drained: %s", reason)
+	}
+
+	leave := qs.Queue(QueueForward).Enter()
+	time.Sleep(50 * time.Millisecond)
+	leave()
+	qs.Sample()
+	if reason := qs.Saturated(); !strings.HasPrefix(reason, "forward wait") {
+		t.Fatalf("wrong saturation reason: %q", reason)
+	}
+
+	// A nil queue tracks nothing.
+	var q *Queue
+	q.Enter()()
+}
@@ -0,0 +1,591 @@
+// Package parquet writes tables in the Apache Parquet file format, so that
+// query results can be loaded by analytics tools with the types of their
+// columns intact. Only what is needed for flat tables is supported: every
+// column is optional, values are PLAIN-encoded, and pages are compressed with
+// zstd.
+package parquet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ContentType is the media type of Parquet files.
+const ContentType = "application/vnd.apache.parquet"
+
+// DefaultRowGroupSize is the default size, in bytes, of the uncompressed
+// values buffered before they are written as a row group.
+const DefaultRowGroupSize = 16 * 1024 * 1024
+
+// magic starts and ends every Parquet file.
+const magic = "PAR1"
+
+// Physical types, encodings, and other enumerations of the Parquet format.
+const (
+	typeBoolean   = 0
+	typeInt32     = 1
+	typeInt64     = 2
+	typeDouble    = 5
+	typeByteArray = 6
+
+	repetitionOptional = 1
+
+	convertedUTF8            = 0
+	convertedDate            = 6
+	convertedTimestampMicros = 10
+
+	encodingPlain = 0
+	encodingRLE   = 3
+
+	codecZstd = 6
+
+	pageTypeData = 0
+
+	formatVersion = 1
+)
+
+// Type is the type of the values of a column, which decides how they are
+// stored, and how readers interpret them.
+type Type int
+
+const (
+	// String values are UTF-8 text.
+	String Type = iota
+
+	// Bytes values are arbitrary byte strings.
+	Bytes
+
+	// Int64 values are signed 64-bit integers.
+	Int64
+
+	// Double values are 64-bit floating point numbers.
+	Double
+
+	// Boolean values are true or false.
+	Boolean
+
+	// Timestamp values are instants, stored as microseconds since the Unix
+	// epoch, in UTC.
+	Timestamp
+
+	// Date values are calendar dates, stored as days since the Unix epoch.
+	Date
+)
+
+// String returns the name of the type.
+func (t Type) String() string {
+	switch t {
+	case String:
+		return "string"
+	case Bytes:
+		return "bytes"
+	case Int64:
+		return "int64"
+	case Double:
+		return "double"
+	case Boolean:
+		return "boolean"
+	case Timestamp:
+		return "timestamp"
+	case Date:
+		return "date"
+	default:
+		return fmt.Sprintf("Type(%d)", int(t))
+	}
+}
+
+func (t Type) physical() int32 {
+	switch t {
+	case Int64, Timestamp:
+		return typeInt64
+	case Double:
+		return typeDouble
+	case Boolean:
+		return typeBoolean
+	case Date:
+		return typeInt32
+	default:
+		return typeByteArray
+	}
+}
+
+// Column is a column of a table.
+type Column struct {
+	Name string
+	Type Type
+}
+
+// columnBuffer holds the values of a column for the row group being built.
+type columnBuffer struct {
+	defined []bool // Whether each row has a value, rather than NULL.
+	values  []byte // PLAIN-encoded values, other than of BOOLEAN columns.
+	bools   []bool // Values of BOOLEAN columns.
+}
+
+type rowGroup struct {
+	numRows       int64
+	totalByteSize int64
+	chunks        []columnChunk
+}
+
+type columnChunk struct {
+	offset           int64
+	uncompressedSize int64
+	compressedSize   int64
+}
+
+// Writer writes the rows of a table as a Parquet file. Rows are buffered, and
+// written in row groups, so a large table is written without being held in
+// memory. The file is complete once Close has been called.
+type Writer struct {
+	// RowGroupSize is the size, in bytes, of the uncompressed values
+	// buffered before they are written as a row group. If zero,
+	// DefaultRowGroupSize is used.
+	RowGroupSize int
+
+	w       io.Writer
+	offset  int64
+	columns []Column
+	enc     *zstd.Encoder
+
+	buffers  []*columnBuffer
+	buffered int   // Rows buffered.
+	size     int   // Bytes of values buffered.
+	numRows  int64 // Rows written, including those buffered.
+	groups   []rowGroup
+	closed   bool
+	writeErr error
+}
+
+// NewWriter returns a Writer which writes a table with the given columns
+// to w.
+func NewWriter(w io.Writer, columns []Column) (*Writer, error) {
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, err
+	}
+	pw := &Writer{
+		w:       w,
+		columns: columns,
+		enc:     enc,
+		buffers: make([]*columnBuffer, len(columns)),
+	}
+	for i := range pw.buffers {
+		pw.buffers[i] = &columnBuffer{}
+	}
+	return pw, nil
+}
+
+// Write adds a row to the table. The row must hold a value for each column,
+// which is nil for NULL, or a bool, int64, float64, string, []byte, or
+// time.Time. Values are converted to the type of their column where this
+// loses nothing, such as an integer written to a Double column, and an error
+// is returned otherwise.
+func (w *Writer) Write(row []interface{}) error {
+	if w.closed {
+		return fmt.Errorf("parquet writer closed")
+	}
+	if w.writeErr != nil {
+		return w.writeErr
+	}
+	if len(row) != len(w.columns) {
+		return fmt.Errorf("row has %d values, table has %d columns", len(row), len(w.columns))
+	}
+
+	// Check every value before buffering any, so a row is written whole
+	// or not at all.
+	converted := make([]interface{}, len(row))
+	for i, v := range row {
+		cv, err := convert(w.columns[i].Type, v)
+		if err != nil {
+			return fmt.Errorf("column %s: %s", w.columns[i].Name, err.Error())
+		}
+		converted[i] = cv
+	}
+	for i, v := range converted {
+		w.size += w.buffers[i].append(v)
+	}
+	w.buffered++
+	w.numRows++
+
+	limit := w.RowGroupSize
+	if limit <= 0 {
+		limit = DefaultRowGroupSize
+	}
+	if w.size >= limit {
+		return w.flush()
+	}
+	return nil
+}
+
+// Close writes any buffered rows, and the metadata which completes the
+// file. It does not close the underlying io.Writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	defer w.enc.Close()
+	if w.buffered > 0 || w.offset == 0 {
+		if err := w.flush(); err != nil {
+			return err
+		}
+	}
+	footer := w.fileMetadata()
+	var n [4]byte
+	binary.LittleEndian.PutUint32(n[:], uint32(len(footer)))
+	for _, b := range [][]byte{footer, n[:], []byte(magic)} {
+		if err := w.write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flush writes the buffered rows as a row group, with a single page for
+// each column. If no rows are buffered, only the magic bytes which start the
+// file are written, if they have not been already.
+func (w *Writer) flush() error {
+	if w.offset == 0 {
+		if err := w.write([]byte(magic)); err != nil {
+			return err
+		}
+	}
+	if w.buffered == 0 {
+		return nil
+	}
+
+	rg := rowGroup{numRows: int64(w.buffered)}
+	for i, buf := range w.buffers {
+		page := buf.page()
+		compressed := w.enc.EncodeAll(page, nil)
+		header := pageHeader(len(page), len(compressed), w.buffered)
+		cc := columnChunk{
+			offset:           w.offset,
+			uncompressedSize: int64(len(header) + len(page)),
+			compressedSize:   int64(len(header) + len(compressed)),
+		}
+		if err := w.write(header); err != nil {
+			return err
+		}
+		if err := w.write(compressed); err != nil {
+			return err
+		}
+		rg.chunks = append(rg.chunks, cc)
+		rg.totalByteSize += cc.uncompressedSize
+		w.buffers[i] = &columnBuffer{}
+	}
+	w.groups = append(w.groups, rg)
+	w.buffered = 0
+	w.size = 0
+	return nil
+}
+
+func (w *Writer) write(b []byte) error {
+	if w.writeErr != nil {
+		return w.writeErr
+	}
+	n, err := w.w.Write(b)
+	w.offset += int64(n)
+	w.writeErr = err
+	return err
+}
+
+// fileMetadata returns the Thrift-encoded FileMetaData of the file.
+func (w *Writer) fileMetadata() []byte {
+	c := &compactWriter{}
+	c.structBegin()
+	c.i32Field(1, formatVersion)
+	c.listField(2, ctStruct, len(w.columns)+1, func(i int) {
+		c.structElem(func() {
+			if i == 0 {
+				// The root of the schema, whose children are the columns.
+				c.stringField(4, "schema")
+				c.i32Field(5, int32(len(w.columns)))
+				return
+			}
+			col := w.columns[i-1]
+			c.i32Field(1, col.Type.physical())
+			c.i32Field(3, repetitionOptional)
+			c.stringField(4, col.Name)
+			switch col.Type {
+			case String:
+				c.i32Field(6, convertedUTF8)
+				c.structField(10, func() {
+					c.structField(1, func() {}) // STRING
+				})
+			case Date:
+				c.i32Field(6, convertedDate)
+				c.structField(10, func() {
+					c.structField(6, func() {}) // DATE
+				})
+			case Timestamp:
+				c.i32Field(6, convertedTimestampMicros)
+				c.structField(10, func() {
+					c.structField(8, func() { // TIMESTAMP
+						c.boolField(1, true) // isAdjustedToUTC
+						c.structField(2, func() {
+							c.structField(2, func() {}) // MICROS
+						})
+					})
+				})
+			}
+		})
+	})
+	c.i64Field(3, w.numRows)
+	c.listField(4, ctStruct, len(w.groups), func(i int) {
+		rg := w.groups[i]
+		c.structElem(func() {
+			c.listField(1, ctStruct, len(rg.chunks), func(j int) {
+				cc := rg.chunks[j]
+				col := w.columns[j]
+				c.structElem(func() {
+					c.i64Field(2, cc.offset)
+					c.structField(3, func() {
+						c.i32Field(1, col.Type.physical())
+						c.listField(2, ctI32, 2, func(k int) {
+							c.zigzag([]int64{encodingPlain, encodingRLE}[k])
+						})
+						c.listField(3, ctBinary, 1, func(int) {
+							c.binary(col.Name)
+						})
+						c.i32Field(4, codecZstd)
+						c.i64Field(5, rg.numRows)
+						c.i64Field(6, cc.uncompressedSize)
+						c.i64Field(7, cc.compressedSize)
+						c.i64Field(9, cc.offset)
+					})
+				})
+			})
+			c.i64Field(2, rg.totalByteSize)
+			c.i64Field(3, rg.numRows)
+		})
+	})
+	c.stringField(6, "rqlite")
+	c.structEnd()
+	return c.bytes()
+}
+
+// pageHeader returns the Thrift-encoded PageHeader of a data page holding
+// numValues values, including NULLs.
+func pageHeader(uncompressedSize, compressedSize, numValues int) []byte {
+	c := &compactWriter{}
+	c.structBegin()
+	c.i32Field(1, pageTypeData)
+	c.i32Field(2, int32(uncompressedSize))
+	c.i32Field(3, int32(compressedSize))
+	c.structField(5, func() {
+		c.i32Field(1, int32(numValues))
+		c.i32Field(2, encodingPlain)
+		c.i32Field(3, encodingRLE)
+		c.i32Field(4, encodingRLE)
+	})
+	c.structEnd()
+	return c.bytes()
+}
+
+// append adds v, already converted to the type of the column, returning
+// the number of bytes it adds to the buffered values.
+func (b *columnBuffer) append(v interface{}) int {
+	b.defined = append(b.defined, v != nil)
+	n := len(b.values)
+	switch v := v.(type) {
+	case nil:
+		return 0
+	case bool:
+		b.bools = append(b.bools, v)
+		return 1
+	case int32:
+		b.values = appendUint32(b.values, uint32(v))
+	case int64:
+		b.values = appendUint64(b.values, uint64(v))
+	case float64:
+		b.values = appendUint64(b.values, math.Float64bits(v))
+	case []byte:
+		b.values = appendUint32(b.values, uint32(len(v)))
+		b.values = append(b.values, v...)
+	}
+	return len(b.values) - n
+}
+
+// page returns the uncompressed data of the page holding the buffered
+// values: the definition levels, which are RLE-encoded, and preceded by
+// their length, then the values which are not NULL.
+func (b *columnBuffer) page() []byte {
+	levels := rleBits(b.defined)
+	page := appendUint32(nil, uint32(len(levels)))
+	page = append(page, levels...)
+	if b.bools != nil {
+		return append(page, packBits(b.bools)...)
+	}
+	return append(page, b.values...)
+}
+
+// rleBits encodes bits with the RLE part of the RLE/bit-packing hybrid
+// encoding, with a bit width of 1. Each run of equal bits is written as its
+// length, shifted left by one, and then its value.
+func rleBits(bits []bool) []byte {
+	var out []byte
+	for i := 0; i < len(bits); {
+		j := i
+		for j < len(bits) && bits[j] == bits[i] {
+			j++
+		}
+		var n [binary.MaxVarintLen64]byte
+		out = append(out, n[:binary.PutUvarint(n[:], uint64(j-i)<<1)]...)
+		if bits[i] {
+			out = append(out, 1)
+		} else {
+			out = append(out, 0)
+		}
+		i = j
+	}
+	return out
+}
+
+// packBits packs bits into bytes, least significant bit first, as BOOLEAN
+// values are PLAIN-encoded.
+func packBits(bits []bool) []byte {
+	out := make([]byte, (len(bits)+7)/8)
+	for i, b := range bits {
+		if b {
+			out[i/8] |= 1 << (i % 8)
+		}
+	}
+	return out
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// timeLayouts are the layouts of text which may be written to Timestamp and
+// Date columns, being those SQLite uses for dates and times.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// convert returns v converted to the Go type in which values of type t are
+// buffered, or nil if v is nil.
+func convert(t Type, v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	fail := func() (interface{}, error) {
+		return nil, fmt.Errorf("cannot write %T value %v as %s", v, v, t)
+	}
+
+	switch t {
+	case String:
+		switch v := v.(type) {
+		case string:
+			return []byte(v), nil
+		case []byte:
+			return v, nil
+		case int64:
+			return []byte(strconv.FormatInt(v, 10)), nil
+		case float64:
+			return []byte(strconv.FormatFloat(v, 'g', -1, 64)), nil
+		case bool:
+			return []byte(strconv.FormatBool(v)), nil
+		case time.Time:
+			return []byte(v.Format(time.RFC3339Nano)), nil
+		}
+	case Bytes:
+		switch v := v.(type) {
+		case []byte:
+			return v, nil
+		case string:
+			return []byte(v), nil
+		}
+	case Int64:
+		switch v := v.(type) {
+		case int64:
+			return v, nil
+		case float64:
+			if v == math.Trunc(v) && v >= math.MinInt64 && v < math.MaxInt64 {
+				return int64(v), nil
+			}
+		case bool:
+			if v {
+				return int64(1), nil
+			}
+			return int64(0), nil
+		case string:
+			if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return i, nil
+			}
+		}
+	case Double:
+		switch v := v.(type) {
+		case float64:
+			return v, nil
+		case int64:
+			return float64(v), nil
+		case string:
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return f, nil
+			}
+		}
+	case Boolean:
+		switch v := v.(type) {
+		case bool:
+			return v, nil
+		case int64:
+			return v != 0, nil
+		}
+	case Timestamp, Date:
+		tm, ok := toTime(v)
+		if !ok {
+			return fail()
+		}
+		if t == Date {
+			days := tm.Unix() / 86400
+			if tm.Unix() < 0 && tm.Unix()%86400 != 0 {
+				days--
+			}
+			return int32(days), nil
+		}
+		return tm.Unix()*1e6 + int64(tm.Nanosecond()/1e3), nil
+	}
+	return fail()
+}
+
+// toTime returns v as a time. Numbers are seconds since the Unix epoch.
+func toTime(v interface{}) (time.Time, bool) {
+	switch v := v.(type) {
+	case time.Time:
+		return v.UTC(), true
+	case int64:
+		return time.Unix(v, 0).UTC(), true
+	case float64:
+		sec, frac := math.Modf(v)
+		return time.Unix(int64(sec), int64(frac*1e9)).UTC(), true
+	case string:
+		for _, layout := range timeLayouts {
+			if tm, err := time.Parse(layout, v); err == nil {
+				return tm.UTC(), true
+			}
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,302 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func Test_WriterRoundTrip(t *testing.T) {
+	columns := []Column{
+		{Name: "id", Type: Int64},
+		{Name: "name", Type: String},
+		{Name: "score", Type: Double},
+		{Name: "data", Type: Bytes},
+		{Name: "ok", Type: Boolean},
+		{Name: "at", Type: Timestamp},
+		{Name: "day", Type: Date},
+	}
+	at := time.Date(2024, 1, 2, 3, 4, 5, 6000, time.UTC)
+	rows := [][]interface{}{
+		{int64(1), "fiona", 1.5, []byte{1, 2}, true, at, "2024-01-02"},
+		{int64(2), nil, int64(2), nil, false, "2024-01-02 03:04:05.000006", nil},
+		{float64(3), "declan", nil, "abc", int64(1), int64(1704164645), at},
+	}
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, columns)
+	if err != nil {
+		t.Fatalf("failed to create writer: %s", err)
+	}
+	w.RowGroupSize = 1 // A row group for each row.
+	for _, row := range rows {
+		if err := w.Write(row); err != nil {
+			t.Fatalf("failed to write row: %s", err)
+		}
+	}
+	if err := w.Write([]interface{}{"x", nil, nil, nil, nil, nil, nil}); err == nil {
+		t.Fatalf("expected error writing text to Int64 column")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to close writer: %s", err)
+	}
+
+	got := mustReadFile(t, buf.Bytes())
+	if exp := []string{"id", "name", "score", "data", "ok", "at", "day"}; !reflect.DeepEqual(exp, got.names) {
+		t.Fatalf("wrong columns, exp %v, got %v", exp, got.names)
+	}
+	if exp := []int64{typeInt64, typeByteArray, typeDouble, typeByteArray, typeBoolean, typeInt64, typeInt32}; !reflect.DeepEqual(exp, got.types) {
+		t.Fatalf("wrong column types, exp %v, got %v", exp, got.types)
+	}
+	if got.numRowGroups != 3 {
+		t.Fatalf("wrong number of row groups, exp 3, got %d", got.numRowGroups)
+	}
+	micros := at.UnixNano() / 1000
+	days := int32(at.Unix() / 86400)
+	exp := [][]interface{}{
+		{int64(1), "fiona", 1.5, "\x01\x02", true, micros, days},
+		{int64(2), nil, 2.0, nil, false, micros, nil},
+		{int64(3), "declan", nil, "abc", true, int64(1704164645000000), days},
+	}
+	if !reflect.DeepEqual(exp, got.rows) {
+		t.Fatalf("wrong rows\nexp: %v\ngot: %v", exp, got.rows)
+	}
+}
+
+func Test_WriterEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, []Column{{Name: "id", Type: Int64}})
+	if err != nil {
+		t.Fatalf("failed to create writer: %s", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to close writer: %s", err)
+	}
+	got := mustReadFile(t, buf.Bytes())
+	if len(got.rows) != 0 || got.numRowGroups != 0 || !reflect.DeepEqual([]string{"id"}, got.names) {
+		t.Fatalf("wrong empty file: %+v", got)
+	}
+}
+
+func Test_RLEBits(t *testing.T) {
+	bits := []bool{true, true, true, false, true}
+	if exp, got := []byte{6, 1, 2, 0, 2, 1}, rleBits(bits); !bytes.Equal(exp, got) {
+		t.Fatalf("wrong RLE encoding, exp %v, got %v", exp, got)
+	}
+	if exp, got := []byte{0x17}, packBits(bits); !bytes.Equal(exp, got) {
+		t.Fatalf("wrong bit packing, exp %v, got %v", exp, got)
+	}
+}
+
+type parquetFile struct {
+	names        []string
+	types        []int64
+	numRowGroups int
+	rows         [][]interface{}
+}
+
+// mustReadFile decodes a Parquet file as written by Writer.
+func mustReadFile(t *testing.T, b []byte) *parquetFile {
+	t.Helper()
+	if len(b) < 12 || string(b[:4]) != magic || string(b[len(b)-4:]) != magic {
+		t.Fatalf("file does not start and end with magic bytes")
+	}
+	n := int(binary.LittleEndian.Uint32(b[len(b)-8:]))
+	r := &compactReader{b: b[len(b)-8-n : len(b)-8]}
+	md := r.readStruct()
+	if r.err != nil || len(r.b) != 0 {
+		t.Fatalf("failed to decode file metadata: %v", r.err)
+	}
+
+	f := &parquetFile{}
+	schema := md[2].([]interface{})
+	for _, e := range schema[1:] {
+		se := e.(map[int16]interface{})
+		f.names = append(f.names, string(se[4].([]byte)))
+		f.types = append(f.types, se[1].(int64))
+		if se[3].(int64) != repetitionOptional {
+			t.Fatalf("column %s not optional", se[4])
+		}
+	}
+	if schema[0].(map[int16]interface{})[5].(int64) != int64(len(f.names)) {
+		t.Fatalf("wrong number of children of schema root")
+	}
+
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		t.Fatalf("failed to create zstd decoder: %s", err)
+	}
+	defer dec.Close()
+	var groups []interface{}
+	if md[4] != nil {
+		groups = md[4].([]interface{})
+	}
+	f.numRowGroups = len(groups)
+	for _, g := range groups {
+		rg := g.(map[int16]interface{})
+		numRows := int(rg[3].(int64))
+		rows := make([][]interface{}, numRows)
+		for i := range rows {
+			rows[i] = make([]interface{}, len(f.names))
+		}
+		for c, cc := range rg[1].([]interface{}) {
+			cmd := cc.(map[int16]interface{})[3].(map[int16]interface{})
+			if cmd[4].(int64) != codecZstd || cmd[5].(int64) != int64(numRows) {
+				t.Fatalf("wrong column chunk metadata: %v", cmd)
+			}
+			off := cmd[9].(int64)
+			pr := &compactReader{b: b[off:]}
+			ph := pr.readStruct()
+			if pr.err != nil {
+				t.Fatalf("failed to decode page header: %s", pr.err)
+			}
+			hdrLen := len(b[off:]) - len(pr.b)
+			if int64(hdrLen)+ph[3].(int64) != cmd[7].(int64) {
+				t.Fatalf("wrong compressed size of column chunk")
+			}
+			page, err := dec.DecodeAll(pr.b[:ph[3].(int64)], nil)
+			if err != nil {
+				t.Fatalf("failed to decompress page: %s", err)
+			}
+			if int64(len(page)) != ph[2].(int64) {
+				t.Fatalf("wrong uncompressed page size")
+			}
+			defined := decodeRLEBits(t, page[4:4+binary.LittleEndian.Uint32(page)], numRows)
+			values := page[4+binary.LittleEndian.Uint32(page):]
+			nBool := 0
+			for i := 0; i < numRows; i++ {
+				if !defined[i] {
+					continue
+				}
+				switch f.types[c] {
+				case typeInt64:
+					rows[i][c] = int64(binary.LittleEndian.Uint64(values))
+					values = values[8:]
+				case typeInt32:
+					rows[i][c] = int32(binary.LittleEndian.Uint32(values))
+					values = values[4:]
+				case typeDouble:
+					rows[i][c] = math.Float64frombits(binary.LittleEndian.Uint64(values))
+					values = values[8:]
+				case typeBoolean:
+					rows[i][c] = values[nBool/8]&(1<<(nBool%8)) != 0
+					nBool++
+				case typeByteArray:
+					l := binary.LittleEndian.Uint32(values)
+					rows[i][c] = string(values[4 : 4+l])
+					values = values[4+l:]
+				}
+			}
+		}
+		f.rows = append(f.rows, rows...)
+	}
+	return f
+}
+
+func decodeRLEBits(t *testing.T, b []byte, n int) []bool {
+	t.Helper()
+	var bits []bool
+	for len(b) > 0 {
+		h, l := binary.Uvarint(b)
+		if h&1 != 0 {
+			t.Fatalf("unexpected bit-packed run")
+		}
+		for i := 0; i < int(h>>1); i++ {
+			bits = append(bits, b[l] == 1)
+		}
+		b = b[l+1:]
+	}
+	if len(bits) != n {
+		t.Fatalf("wrong number of definition levels, exp %d, got %d", n, len(bits))
+	}
+	return bits
+}
+
+// compactReader decodes Thrift structs written with the compact protocol,
+// into maps of field ID to value.
+type compactReader struct {
+	b   []byte
+	err error
+}
+
+func (r *compactReader) varint() uint64 {
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		r.err = fmt.Errorf("bad varint")
+		r.b = nil
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *compactReader) zigzag() int64 {
+	v := r.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *compactReader) readStruct() map[int16]interface{} {
+	m := make(map[int16]interface{})
+	var id int16
+	for r.err == nil && len(r.b) > 0 {
+		h := r.b[0]
+		r.b = r.b[1:]
+		if h == 0 {
+			return m
+		}
+		typ := h & 0x0f
+		if delta := int16(h >> 4); delta != 0 {
+			id += delta
+		} else {
+			id = int16(r.zigzag())
+		}
+		m[id] = r.readValue(typ)
+	}
+	if r.err == nil {
+		r.err = fmt.Errorf("unterminated struct")
+	}
+	return m
+}
+
+func (r *compactReader) readValue(typ byte) interface{} {
+	switch typ {
+	case ctBoolTrue:
+		return true
+	case ctBoolFalse:
+		return false
+	case ctI32, ctI64:
+		return r.zigzag()
+	case ctBinary:
+		l := int(r.varint())
+		if l > len(r.b) {
+			r.err = fmt.Errorf("binary too long")
+			return nil
+		}
+		v := r.b[:l]
+		r.b = r.b[l:]
+		return v
+	case ctList:
+		h := r.b[0]
+		r.b = r.b[1:]
+		n := int(h >> 4)
+		if n == 15 {
+			n = int(r.varint())
+		}
+		l := make([]interface{}, n)
+		for i := range l {
+			l[i] = r.readValue(h & 0x0f)
+		}
+		return l
+	case ctStruct:
+		return r.readStruct()
+	default:
+		r.err = fmt.Errorf("unsupported type %d", typ)
+		return nil
+	}
+}
@@ -0,0 +1,121 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Types of field in the Thrift compact protocol.
+const (
+	ctBoolTrue  = 1
+	ctBoolFalse = 2
+	ctI32       = 5
+	ctI64       = 6
+	ctBinary    = 8
+	ctList      = 9
+	ctStruct    = 12
+)
+
+// compactWriter encodes Thrift structs with the compact protocol, in which
+// Parquet page headers and file metadata are written. Each field is written
+// with a header holding the difference between its ID and that of the
+// previous field of the struct.
+type compactWriter struct {
+	buf     bytes.Buffer
+	lastID  int16
+	lastIDs []int16
+}
+
+func (c *compactWriter) bytes() []byte {
+	return c.buf.Bytes()
+}
+
+func (c *compactWriter) structBegin() {
+	c.lastIDs = append(c.lastIDs, c.lastID)
+	c.lastID = 0
+}
+
+func (c *compactWriter) structEnd() {
+	c.buf.WriteByte(0)
+	c.lastID = c.lastIDs[len(c.lastIDs)-1]
+	c.lastIDs = c.lastIDs[:len(c.lastIDs)-1]
+}
+
+func (c *compactWriter) fieldHeader(id int16, typ byte) {
+	if delta := id - c.lastID; delta > 0 && delta <= 15 {
+		c.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		c.buf.WriteByte(typ)
+		c.varint(uint64(uint16(id<<1) ^ uint16(id>>15)))
+	}
+	c.lastID = id
+}
+
+func (c *compactWriter) varint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	c.buf.Write(b[:n])
+}
+
+func (c *compactWriter) zigzag(v int64) {
+	c.varint(uint64(v<<1) ^ uint64(v>>63))
+}
+
+func (c *compactWriter) i32Field(id int16, v int32) {
+	c.fieldHeader(id, ctI32)
+	c.zigzag(int64(v))
+}
+
+func (c *compactWriter) i64Field(id int16, v int64) {
+	c.fieldHeader(id, ctI64)
+	c.zigzag(v)
+}
+
+func (c *compactWriter) boolField(id int16, v bool) {
+	if v {
+		c.fieldHeader(id, ctBoolTrue)
+	} else {
+		c.fieldHeader(id, ctBoolFalse)
+	}
+}
+
+func (c *compactWriter) stringField(id int16, s string) {
+	c.fieldHeader(id, ctBinary)
+	c.binary(s)
+}
+
+func (c *compactWriter) binary(s string) {
+	c.varint(uint64(len(s)))
+	c.buf.WriteString(s)
+}
+
+// structField writes a field holding a struct, whose fields are written by f.
+func (c *compactWriter) structField(id int16, f func()) {
+	c.fieldHeader(id, ctStruct)
+	c.structBegin()
+	f()
+	c.structEnd()
+}
+
+// listField writes a field holding a list of n elements of type typ, each
+// written by f.
+func (c *compactWriter) listField(id int16, typ byte, n int, f func(i int)) {
+	c.fieldHeader(id, ctList)
+	if n < 15 {
+		c.buf.WriteByte(byte(n)<<4 | typ)
+	} else {
+		c.buf.WriteByte(0xf0 | typ)
+		c.varint(uint64(n))
+	}
+	for i := 0; i < n; i++ {
+		f(i)
+	}
+}
+
+// structElem writes a struct element of a list, whose fields are written
+// by f.
+func (c *compactWriter) structElem(f func()) {
+	c.structBegin()
+	f()
+	c.structEnd()
+}
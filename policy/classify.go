@@ -0,0 +1,226 @@
+package policy
+
+import (
+	"strings"
+
+	"github.com/rqlite/sql"
+)
+
+const (
+	// ClassDropTable is the class of statements which drop a table.
+	ClassDropTable = "drop-table"
+
+	// ClassDeleteAll is the class of DELETE statements without a WHERE clause,
+	// which therefore remove every row from a table.
+	ClassDeleteAll = "delete-all"
+
+	// ClassAttach is the class of statements which attach another database
+	// to the connection.
+	ClassAttach = "attach"
+)
+
+// Classes is the set of all statement classes understood by the policy engine.
+var Classes = []string{ClassDropTable, ClassDeleteAll, ClassAttach}
+
+func isClass(c string) bool {
+	for _, cc := range Classes {
+		if c == cc {
+			return true
+		}
+	}
+	return false
+}
+
+// Classify returns the policy classes of the SQL text in stmt. The text may
+// contain multiple SQLite statements, separated by semicolons, so the
+// returned slice may contain the same class more than once. Statements which
+// fall into no class are ignored, and a nil slice is returned if nothing
+// in stmt is classified.
+//
+// Classification works at the token level, rather than requiring a full
+// parse, so that statements the SQL parser does not support (ATTACH, for
+// example) are still classified.
+func Classify(stmt string) []string {
+	var classes []string
+	for _, toks := range segments(normalize(stmt)) {
+		if c := classifySegment(toks); c != "" {
+			classes = append(classes, c)
+		}
+	}
+	return classes
+}
+
+// classifySegment classifies the tokens of a single statement.
+func classifySegment(toks []sql.Token) string {
+	if len(toks) == 0 {
+		return ""
+	}
+
+	// Find the verb of the statement. Any CTEs are parenthesized, so the verb
+	// of a statement starting with WITH is the first DML keyword at the top level.
+	verb := 0
+	if toks[0] == sql.WITH {
+		verb = -1
+		depth := 0
+		for i, t := range toks {
+			switch t {
+			case sql.LP:
+				depth++
+			case sql.RP:
+				depth--
+			case sql.SELECT, sql.INSERT, sql.REPLACE, sql.UPDATE, sql.DELETE:
+				if depth == 0 && verb == -1 {
+					verb = i
+				}
+			}
+		}
+		if verb == -1 {
+			return ""
+		}
+	}
+
+	switch toks[verb] {
+	case sql.ATTACH:
+		return ClassAttach
+	case sql.DROP:
+		if verb+1 < len(toks) && toks[verb+1] == sql.TABLE {
+			return ClassDropTable
+		}
+	case sql.DELETE:
+		depth := 0
+		for _, t := range toks[verb:] {
+			switch t {
+			case sql.LP:
+				depth++
+			case sql.RP:
+				depth--
+			case sql.WHERE:
+				if depth == 0 {
+					return ""
+				}
+			}
+		}
+		return ClassDeleteAll
+	}
+	return ""
+}
+
+// segments splits the SQL text into statements, returning the tokens
+// of each non-empty statement.
+func segments(s string) [][]sql.Token {
+	var segs [][]sql.Token
+	var cur []sql.Token
+	scanner := sql.NewScanner(strings.NewReader(s))
+	for {
+		_, tok, _ := scanner.Scan()
+		if tok == sql.EOF || tok == sql.SEMI {
+			if len(cur) > 0 {
+				segs = append(segs, cur)
+			}
+			if tok == sql.EOF {
+				return segs
+			}
+			cur = nil
+			continue
+		}
+		cur = append(cur, tok)
+	}
+}
+
+// split splits the SQL text into the text of each non-empty statement, with
+// any comments removed. Semicolons in the body of a CREATE TRIGGER statement
+// do not end it.
+func split(s string) []string {
+	rs := []rune(normalize(s))
+	var stmts []string
+	var toks []sql.Token
+	start := 0
+	inTrigger, triggerDone := false, false
+	caseDepth := 0
+	scanner := sql.NewScanner(strings.NewReader(string(rs)))
+	for {
+		pos, tok, _ := scanner.Scan()
+		if tok == sql.EOF || (tok == sql.SEMI && (!inTrigger || triggerDone)) {
+			end := len(rs)
+			if tok == sql.SEMI {
+				end = pos.Offset
+			}
+			if len(toks) > 0 {
+				stmts = append(stmts, strings.TrimSpace(string(rs[start:end])))
+			}
+			if tok == sql.EOF {
+				return stmts
+			}
+			start = end + 1
+			toks = nil
+			inTrigger, triggerDone = false, false
+			caseDepth = 0
+			continue
+		}
+		toks = append(toks, tok)
+		switch {
+		case len(toks) <= 3 && tok == sql.TRIGGER && toks[0] == sql.CREATE:
+			inTrigger = true
+		case inTrigger && tok == sql.CASE:
+			caseDepth++
+		case inTrigger && tok == sql.END:
+			if caseDepth > 0 {
+				caseDepth--
+			} else {
+				triggerDone = true
+			}
+		}
+	}
+}
+
+// normalize replaces any SQL comments in s with whitespace, and rewrites
+// identifiers quoted with backticks or square brackets using double quotes,
+// so the scanner sees them as single quoted identifiers. Comment markers
+// inside string literals and quoted identifiers are left alone.
+func normalize(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\'' || c == '"':
+			j := strings.IndexByte(s[i+1:], c)
+			if j == -1 {
+				b.WriteString(s[i:])
+				return b.String()
+			}
+			b.WriteString(s[i : i+j+2])
+			i += j + 1
+		case c == '`' || c == '[':
+			end := c
+			if c == '[' {
+				end = ']'
+			}
+			j := strings.IndexByte(s[i+1:], end)
+			if j == -1 {
+				b.WriteString(s[i:])
+				return b.String()
+			}
+			b.WriteByte('"')
+			b.WriteString(strings.ReplaceAll(s[i+1:i+j+1], `"`, `""`))
+			b.WriteByte('"')
+			i += j + 1
+		case c == '-' && i+1 < len(s) && s[i+1] == '-':
+			j := strings.IndexByte(s[i:], '\n')
+			if j == -1 {
+				return b.String()
+			}
+			b.WriteByte(' ')
+			i += j - 1
+		case c == '/' && i+1 < len(s) && s[i+1] == '*':
+			j := strings.Index(s[i+2:], "*/")
+			if j == -1 {
+				return b.String()
+			}
+			b.WriteByte(' ')
+			i += j + 3
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,79 @@
+package policy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_Classify(t *testing.T) {
+	tests := []struct {
+		name string
+		stmt string
+		exp  []string
+	}{
+		{"empty", "", nil},
+		{"select", "SELECT * FROM foo", nil},
+		{"insert", `INSERT INTO foo(name) VALUES("fiona")`, nil},
+		{"drop table", "DROP TABLE foo", []string{ClassDropTable}},
+		{"drop table lower", "drop table if exists foo", []string{ClassDropTable}},
+		{"drop view", "DROP VIEW foo", nil},
+		{"drop index", "DROP INDEX foo", nil},
+		{"delete all", "DELETE FROM foo", []string{ClassDeleteAll}},
+		{"delete where", "DELETE FROM foo WHERE id=1", nil},
+		{"delete subquery where", "DELETE FROM foo RETURNING (SELECT id FROM bar WHERE id=1)", []string{ClassDeleteAll}},
+		{"delete with CTE", "WITH x AS (SELECT id FROM bar WHERE id=1) DELETE FROM foo", []string{ClassDeleteAll}},
+		{"delete with CTE where", "WITH x AS (SELECT id FROM bar) DELETE FROM foo WHERE id IN x", nil},
+		{"delete where in comment", "DELETE FROM foo -- WHERE id=1", []string{ClassDeleteAll}},
+		{"delete where in block comment", "DELETE FROM foo /* WHERE id=1 */", []string{ClassDeleteAll}},
+		{"delete where as bracket identifier", "DELETE FROM [where]", []string{ClassDeleteAll}},
+		{"delete where as backtick identifier", "DELETE FROM `where`", []string{ClassDeleteAll}},
+		{"delete in string", `INSERT INTO foo(name) VALUES('DELETE FROM foo')`, nil},
+		{"comment marker in string", `INSERT INTO foo(name) VALUES('--'); DROP TABLE foo`, []string{ClassDropTable}},
+		{"attach", "ATTACH DATABASE 'other.db' AS other", []string{ClassAttach}},
+		{"explain drop", "EXPLAIN DROP TABLE foo", nil},
+		{"multiple", "SELECT 1; DROP TABLE foo; DELETE FROM bar; ATTACH 'x.db' AS x",
+			[]string{ClassDropTable, ClassDeleteAll, ClassAttach}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Classify(tt.stmt); !reflect.DeepEqual(got, tt.exp) {
+				t.Fatalf("wrong classes for %q, exp %v, got %v", tt.stmt, tt.exp, got)
+			}
+		})
+	}
+}
+
+func Test_Split(t *testing.T) {
+	tests := []struct {
+		name string
+		stmt string
+		exp  []string
+	}{
+		{"empty", "", nil},
+		{"single", "SELECT * FROM foo", []string{"SELECT * FROM foo"}},
+		{"trailing semicolon", "SELECT * FROM foo;", []string{"SELECT * FROM foo"}},
+		{"multiple", "SELECT 1; SELECT 2 ;;", []string{"SELECT 1", "SELECT 2"}},
+		{"semicolon in string", "SELECT ';'; SELECT 2", []string{"SELECT ';'", "SELECT 2"}},
+		{"comment", "SELECT 1 -- one; two\n; SELECT 2", []string{"SELECT 1", "SELECT 2"}},
+		{"non-ASCII", "SELECT 'ü'; SELECT 2", []string{"SELECT 'ü'", "SELECT 2"}},
+		{
+			"trigger",
+			"CREATE TRIGGER t AFTER INSERT ON foo BEGIN UPDATE foo SET x = CASE WHEN 1 THEN 2 END; DELETE FROM bar; END; SELECT 1",
+			[]string{
+				"CREATE TRIGGER t AFTER INSERT ON foo BEGIN UPDATE foo SET x = CASE WHEN 1 THEN 2 END; DELETE FROM bar; END",
+				"SELECT 1",
+			},
+		},
+		{
+			"temp trigger",
+			"CREATE TEMP TRIGGER t AFTER INSERT ON foo BEGIN DELETE FROM bar; END",
+			[]string{"CREATE TEMP TRIGGER t AFTER INSERT ON foo BEGIN DELETE FROM bar; END"},
+		},
+	}
+	for _, tt := range tests {
+		if got := split(tt.stmt); !reflect.DeepEqual(got, tt.exp) {
+			t.Fatalf("test %s: exp %q, got %q", tt.name, tt.exp, got)
+		}
+	}
+}
@@ -0,0 +1,420 @@
+// Package policy provides a statement policy engine. It allows classes of
+// SQL statements, such as DROP TABLE, to be blocked outright, to require
+// explicit confirmation, or to require approval by a second user before they are
+// executed, on a per-user basis. Specific operations, such as reading a
+// given column, can also be denied per user. These are enforced by SQLite's
+// authorizer as each statement is compiled, rather than by parsing the SQL.
+package policy
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rqlite/rqlite/auth"
+	"github.com/rqlite/rqlite/command"
+	"github.com/rqlite/rqlite/db"
+)
+
+const (
+	// ActionAllow means statements in the class may be executed.
+	ActionAllow = "allow"
+
+	// ActionConfirm means statements in the class must be explicitly
+	// confirmed by the client before they will be executed. Any client may
+	// confirm its own statements, so this guards against mistakes only, and
+	// ActionApprove must be used if a second user is required.
+	ActionConfirm = "confirm"
+
+	// ActionApprove means statements in the class will only be executed once
+	// a second user has approved them.
+	ActionApprove = "approve"
+
+	// ActionBlock means statements in the class will never be executed.
+	ActionBlock = "block"
+
+	// ClassAuthorizer is the class of violations raised by SQLite's authorizer.
+	// It may not be used in rules.
+	ClassAuthorizer = "authorizer"
+
+	// DefaultAuditLen is the default number of violations retained for auditing.
+	DefaultAuditLen = 100
+)
+
+// severity orders the actions which result in a violation.
+var severity = map[string]int{
+	ActionConfirm: 1,
+	ActionApprove: 2,
+	ActionBlock:   3,
+}
+
+// stats captures stats for the policy engine.
+var stats *expvar.Map
+
+const (
+	numChecks          = "checks"
+	numBlocked         = "blocked"
+	numConfirmRequired = "confirm_required"
+	numConfirmed       = "confirmed"
+	numApprovalNeeded  = "approval_required"
+	numAuthDenied      = "authorizer_denied"
+)
+
+func init() {
+	stats = expvar.NewMap("policy")
+	ResetStats()
+}
+
+// ResetStats resets the expvar stats for this module. Mostly for test purposes.
+func ResetStats() {
+	stats.Init()
+	stats.Add(numChecks, 0)
+	stats.Add(numBlocked, 0)
+	stats.Add(numConfirmRequired, 0)
+	stats.Add(numConfirmed, 0)
+	stats.Add(numApprovalNeeded, 0)
+	stats.Add(numAuthDenied, 0)
+}
+
+// Violation is returned when a statement is not permitted by policy.
+type Violation struct {
+	Username string `json:"username"`
+	SQL      string `json:"sql"`
+	Class    string `json:"class"`
+	Action   string `json:"action"`
+
+	// Operation is the operation denied by the authorizer, if any.
+	Operation string `json:"operation,omitempty"`
+	// Reason is why the statement could not be authorized, if it could
+	// not be compiled.
+	Reason string `json:"reason,omitempty"`
+}
+
+// Error implements the error interface.
+func (v *Violation) Error() string {
+	if v.Class == ClassAuthorizer {
+		if v.Operation != "" {
+			return fmt.Sprintf("operation %s denied by policy", v.Operation)
+		}
+		return fmt.Sprintf("statement could not be authorized: %s", v.Reason)
+	}
+	switch v.Action {
+	case ActionConfirm:
+		return fmt.Sprintf("statement class %s requires confirmation", v.Class)
+	case ActionApprove:
+		return fmt.Sprintf("statement class %s requires approval", v.Class)
+	}
+	return fmt.Sprintf("statement class %s blocked by policy", v.Class)
+}
+
+// ApprovalRequired returns whether the statement may be executed once
+// approved by a second user.
+func (v *Violation) ApprovalRequired() bool {
+	return v.Action == ActionApprove
+}
+
+// AuditEntry records a single statement which was stopped by policy.
+type AuditEntry struct {
+	Time time.Time `json:"time"`
+	Violation
+}
+
+// Rule represents the statement policy for a single user. Each slice
+// lists the statement classes to which that action applies. Deny lists
+// the operations the user may not perform.
+type Rule struct {
+	Username string       `json:"username,omitempty"`
+	Allow    []string     `json:"allow,omitempty"`
+	Confirm  []string     `json:"confirm,omitempty"`
+	Approve  []string     `json:"approve,omitempty"`
+	Block    []string     `json:"block,omitempty"`
+	Deny     []*Operation `json:"deny,omitempty"`
+}
+
+// Operation is an operation checked by SQLite's authorizer. Action is one
+// of the authorizer actions, such as "read", "insert", "create_temp_table"
+// or "attach". Name is the object of the action, such as the table read or
+// the pragma run, and Column is the column read or updated. If Name or
+// Column is empty, the operation matches any object or column.
+type Operation struct {
+	Action string `json:"action"`
+	Name   string `json:"name,omitempty"`
+	Column string `json:"column,omitempty"`
+}
+
+// Matches returns whether the operation matches the given action on the
+// given object and column. Names are matched without regard to case, as
+// SQLite does.
+func (o *Operation) Matches(action, name, column string) bool {
+	return o.Action == action &&
+		(o.Name == "" || strings.EqualFold(o.Name, name)) &&
+		(o.Column == "" || strings.EqualFold(o.Column, column))
+}
+
+// Authorizer compiles SQL statements with a SQLite authorizer, without
+// executing them.
+type Authorizer interface {
+	// Authorize returns an error wrapping db.ErrNotAuthorized if allow denies
+	// any action needed by the first statement in stmt.
+	Authorize(stmt string, allow db.AuthorizeFunc) error
+}
+
+// Engine checks statements against per-user policy rules. Rules for
+// auth.AllUsers apply to every user, unless a rule for the specific user
+// sets an action for the same class. Operations denied to auth.AllUsers are
+// denied to every user, in addition to those denied to the specific user.
+// Safe for use from multiple goroutines.
+type Engine struct {
+	actions map[string]map[string]string
+	denied  map[string][]*Operation
+
+	authorizer Authorizer
+
+	AuditLen int
+
+	mu    sync.RWMutex
+	audit []*AuditEntry
+
+	logger *log.Logger
+}
+
+// NewEngine returns a new instance of an Engine, without any rules.
+func NewEngine() *Engine {
+	return &Engine{
+		actions:  make(map[string]map[string]string),
+		denied:   make(map[string][]*Operation),
+		AuditLen: DefaultAuditLen,
+		logger:   log.New(os.Stderr, "[policy] ", log.LstdFlags),
+	}
+}
+
+// NewEngineFromFile returns a new instance of an Engine loaded from a file.
+func NewEngineFromFile(path string) (*Engine, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	e := NewEngine()
+	return e, e.Load(f)
+}
+
+// Load loads policy rules from a reader.
+func (e *Engine) Load(r io.Reader) error {
+	dec := json.NewDecoder(r)
+	// Read open bracket
+	_, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	for dec.More() {
+		var rule Rule
+		if err := dec.Decode(&rule); err != nil {
+			return err
+		}
+		m := make(map[string]string)
+		for action, classes := range map[string][]string{
+			ActionAllow:   rule.Allow,
+			ActionConfirm: rule.Confirm,
+			ActionApprove: rule.Approve,
+			ActionBlock:   rule.Block,
+		} {
+			for _, c := range classes {
+				if !isClass(c) {
+					return fmt.Errorf("unknown statement class %s for user %s", c, rule.Username)
+				}
+				if a, ok := m[c]; ok && a != action {
+					return fmt.Errorf("conflicting actions for statement class %s for user %s", c, rule.Username)
+				}
+				m[c] = action
+			}
+		}
+		e.actions[rule.Username] = m
+
+		for _, op := range rule.Deny {
+			if !db.IsAuthAction(op.Action) {
+				return fmt.Errorf("unknown operation %s for user %s", op.Action, rule.Username)
+			}
+		}
+		if len(rule.Deny) > 0 {
+			e.denied[rule.Username] = rule.Deny
+		}
+	}
+
+	// Read closing bracket.
+	_, err = dec.Token()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SetAuthorizer sets the Authorizer used to check statements against any
+// denied operations. It must be called before Check if any are loaded,
+// otherwise every statement from the users concerned is denied.
+func (e *Engine) SetAuthorizer(a Authorizer) {
+	e.authorizer = a
+}
+
+// Action returns the action which applies to the given class of statement
+// when executed by username.
+func (e *Engine) Action(username, class string) string {
+	if m, ok := e.actions[username]; ok {
+		if a, ok := m[class]; ok {
+			return a
+		}
+	}
+	if m, ok := e.actions[auth.AllUsers]; ok {
+		if a, ok := m[class]; ok {
+			return a
+		}
+	}
+	return ActionAllow
+}
+
+// Check returns a *Violation if any of the statements may not be executed
+// by username. If confirmed is true then statements in classes requiring
+// confirmation are permitted. Statements requiring approval always result in a
+// violation, and it is up to the caller to obtain that approval. If more
+// than one statement violates policy, the most severe violation is returned,
+// so a request containing a blocked statement can never be approved. Every
+// violation is recorded for audit. Statements which perform an operation
+// denied to username are blocked.
+func (e *Engine) Check(username string, stmts []*command.Statement, confirmed bool) error {
+	stats.Add(numChecks, 1)
+	var worst *Violation
+	denied := e.deniedOps(username)
+	for _, stmt := range stmts {
+		if len(denied) > 0 {
+			if v := e.authorize(username, stmt.Sql, denied); v != nil {
+				stats.Add(numAuthDenied, 1)
+				worst = v
+				break
+			}
+		}
+		for _, c := range Classify(stmt.Sql) {
+			a := e.Action(username, c)
+			if a == ActionAllow || (a == ActionConfirm && confirmed) {
+				continue
+			}
+			if worst == nil || severity[a] > severity[worst.Action] {
+				worst = &Violation{
+					Username: username,
+					SQL:      stmt.Sql,
+					Class:    c,
+					Action:   a,
+				}
+			}
+		}
+	}
+
+	if worst == nil {
+		if confirmed {
+			stats.Add(numConfirmed, 1)
+		}
+		return nil
+	}
+	switch worst.Action {
+	case ActionBlock:
+		stats.Add(numBlocked, 1)
+	case ActionApprove:
+		stats.Add(numApprovalNeeded, 1)
+	case ActionConfirm:
+		stats.Add(numConfirmRequired, 1)
+	}
+	e.record(worst)
+	return worst
+}
+
+// deniedOps returns the operations denied to username.
+func (e *Engine) deniedOps(username string) []*Operation {
+	ops := e.denied[auth.AllUsers]
+	if username != auth.AllUsers {
+		ops = append(ops[:len(ops):len(ops)], e.denied[username]...)
+	}
+	return ops
+}
+
+// authorize returns a *Violation if any statement in the SQL text performs
+// one of the denied operations, or cannot be compiled, and so cannot be
+// checked. Since each statement is compiled against the database as it is,
+// a statement referring to a table created earlier in the same request is
+// rejected.
+func (e *Engine) authorize(username, sql string, denied []*Operation) *Violation {
+	v := &Violation{
+		Username: username,
+		SQL:      sql,
+		Class:    ClassAuthorizer,
+		Action:   ActionBlock,
+	}
+	if e.authorizer == nil {
+		v.Reason = "no authorizer is set"
+		return v
+	}
+	allow := func(action, name, column string) bool {
+		for _, op := range denied {
+			if op.Matches(action, name, column) {
+				v.Operation = describeOp(action, name, column)
+				return false
+			}
+		}
+		return true
+	}
+	for _, s := range split(sql) {
+		if err := e.authorizer.Authorize(s, allow); err != nil {
+			if v.Operation == "" {
+				v.Reason = err.Error()
+			}
+			return v
+		}
+	}
+	return nil
+}
+
+func describeOp(action, name, column string) string {
+	if column != "" {
+		name = name + "." + column
+	}
+	if name == "" {
+		return action
+	}
+	return action + " " + name
+}
+
+// Audit returns the most recent violations, oldest first.
+func (e *Engine) Audit() []*AuditEntry {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	a := make([]*AuditEntry, len(e.audit))
+	copy(a, e.audit)
+	return a
+}
+
+// Stats returns stats on the Engine.
+func (e *Engine) Stats() (map[string]interface{}, error) {
+	return map[string]interface{}{
+		"num_rules":      len(e.actions),
+		"num_deny_rules": len(e.denied),
+		"audit":          e.Audit(),
+	}, nil
+}
+
+func (e *Engine) record(v *Violation) {
+	e.logger.Printf("user %q denied statement of class %s (%s): %s", v.Username, v.Class, v.Action, v.SQL)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.audit = append(e.audit, &AuditEntry{Time: time.Now(), Violation: *v})
+	if len(e.audit) > e.AuditLen {
+		e.audit = e.audit[len(e.audit)-e.AuditLen:]
+	}
+}
@@ -0,0 +1,240 @@
+package policy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rqlite/rqlite/command"
+	"github.com/rqlite/rqlite/db"
+)
+
+func Test_EngineLoadEmpty(t *testing.T) {
+	e := NewEngine()
+	if err := e.Load(strings.NewReader(`[]`)); err != nil {
+		t.Fatalf("failed to load empty JSON: %s", err.Error())
+	}
+	if err := e.Check("", stmts("DROP TABLE foo"), false); err != nil {
+		t.Fatalf("empty policy blocked statement: %s", err.Error())
+	}
+}
+
+func Test_EngineLoadBad(t *testing.T) {
+	for _, s := range []string{
+		`[{"username": "*", "block": ["truncate"]}]`,
+		`[{"username": "*", "block": ["drop-table"], "allow": ["drop-table"]}]`,
+		`[{"username": "*", "block": ["drop-table"]`,
+	} {
+		if err := NewEngine().Load(strings.NewReader(s)); err == nil {
+			t.Fatalf("loaded bad policy %s without error", s)
+		}
+	}
+}
+
+func Test_EngineCheck(t *testing.T) {
+	const jsonStream = `
+		[
+			{
+				"username": "*",
+				"block": ["drop-table", "attach"],
+				"confirm": ["delete-all"]
+			},
+			{
+				"username": "admin",
+				"allow": ["delete-all"],
+				"confirm": ["drop-table"]
+			},
+			{
+				"username": "ops",
+				"approve": ["drop-table", "delete-all"]
+			}
+		]
+	`
+	e := NewEngine()
+	if err := e.Load(strings.NewReader(jsonStream)); err != nil {
+		t.Fatalf("failed to load policy: %s", err.Error())
+	}
+
+	tests := []struct {
+		username  string
+		stmt      string
+		confirmed bool
+		action    string
+	}{
+		{"", "SELECT * FROM foo", false, ""},
+		{"", "DELETE FROM foo WHERE id=1", false, ""},
+		{"", "DROP TABLE foo", false, ActionBlock},
+		{"", "DROP TABLE foo", true, ActionBlock},
+		{"", "ATTACH 'x.db' AS x", false, ActionBlock},
+		{"", "DELETE FROM foo", false, ActionConfirm},
+		{"", "DELETE FROM foo", true, ""},
+		{"bob", "DELETE FROM foo", false, ActionConfirm},
+		{"admin", "DELETE FROM foo", false, ""},
+		{"admin", "DROP TABLE foo", false, ActionConfirm},
+		{"admin", "DROP TABLE foo", true, ""},
+		{"admin", "ATTACH 'x.db' AS x", true, ActionBlock},
+		{"ops", "DROP TABLE foo", false, ActionApprove},
+		{"ops", "DELETE FROM foo", true, ActionApprove},
+		{"ops", "ATTACH 'x.db' AS x", false, ActionBlock},
+		{"ops", "DROP TABLE foo; ATTACH 'x.db' AS x", false, ActionBlock},
+		{"bob", "DELETE FROM foo; DROP TABLE foo", true, ActionBlock},
+	}
+	for _, tt := range tests {
+		err := e.Check(tt.username, stmts(tt.stmt), tt.confirmed)
+		if tt.action == "" {
+			if err != nil {
+				t.Fatalf("user %q statement %q (confirmed %v) unexpectedly denied: %s",
+					tt.username, tt.stmt, tt.confirmed, err.Error())
+			}
+			continue
+		}
+		var v *Violation
+		if !errors.As(err, &v) {
+			t.Fatalf("user %q statement %q (confirmed %v) not denied", tt.username, tt.stmt, tt.confirmed)
+		}
+		if v.Action != tt.action {
+			t.Fatalf("user %q statement %q (confirmed %v) wrong action, exp %s, got %s",
+				tt.username, tt.stmt, tt.confirmed, tt.action, v.Action)
+		}
+		if v.ApprovalRequired() != (tt.action == ActionApprove) {
+			t.Fatalf("user %q statement %q (confirmed %v) wrong approval requirement",
+				tt.username, tt.stmt, tt.confirmed)
+		}
+	}
+}
+
+func Test_EngineAudit(t *testing.T) {
+	e := NewEngine()
+	e.AuditLen = 2
+	if err := e.Load(strings.NewReader(`[{"username": "*", "block": ["drop-table"]}]`)); err != nil {
+		t.Fatalf("failed to load policy: %s", err.Error())
+	}
+
+	if len(e.Audit()) != 0 {
+		t.Fatalf("audit not empty")
+	}
+	for _, s := range []string{"DROP TABLE foo", "SELECT 1", "DROP TABLE bar", "DROP TABLE qux"} {
+		e.Check("bob", stmts(s), false)
+	}
+	a := e.Audit()
+	if len(a) != 2 {
+		t.Fatalf("wrong audit length, exp 2, got %d", len(a))
+	}
+	if a[0].SQL != "DROP TABLE bar" || a[1].SQL != "DROP TABLE qux" {
+		t.Fatalf("wrong audit entries: %s, %s", a[0].SQL, a[1].SQL)
+	}
+	if a[1].Username != "bob" || a[1].Class != ClassDropTable || a[1].Action != ActionBlock {
+		t.Fatalf("wrong audit entry: %v", a[1])
+	}
+}
+
+func Test_EngineLoadBadDeny(t *testing.T) {
+	if err := NewEngine().Load(strings.NewReader(`[{"username": "*", "deny": [{"action": "truncate"}]}]`)); err == nil {
+		t.Fatalf("loaded policy with unknown operation without error")
+	}
+}
+
+func Test_EngineCheckDeny(t *testing.T) {
+	const jsonStream = `
+		[
+			{
+				"username": "*",
+				"deny": [{"action": "attach"}]
+			},
+			{
+				"username": "bob",
+				"deny": [
+					{"action": "read", "name": "foo", "column": "secret"},
+					{"action": "create_temp_table"}
+				]
+			}
+		]
+	`
+	e := NewEngine()
+	if err := e.Load(strings.NewReader(jsonStream)); err != nil {
+		t.Fatalf("failed to load policy: %s", err.Error())
+	}
+
+	// Without an authorizer every statement from a user with denied
+	// operations is blocked.
+	if err := e.Check("bob", stmts("SELECT 1"), false); err == nil {
+		t.Fatalf("statement allowed without authorizer")
+	}
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "db"), false, true)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err.Error())
+	}
+	defer database.Close()
+	if _, err := database.ExecuteStringStmt("CREATE TABLE foo (id INTEGER, name TEXT, secret TEXT)"); err != nil {
+		t.Fatalf("failed to create table: %s", err.Error())
+	}
+	e.SetAuthorizer(database)
+
+	tests := []struct {
+		username  string
+		stmt      string
+		operation string
+	}{
+		{"alice", "SELECT * FROM foo", ""},
+		{"alice", "CREATE TEMP TABLE bar (id INTEGER)", ""},
+		{"alice", "ATTACH ':memory:' AS x", "attach :memory:"},
+		{"bob", "SELECT id, name FROM foo", ""},
+		{"bob", "SELECT * FROM foo", "read foo.secret"},
+		{"bob", "SELECT id FROM foo; SELECT SECRET FROM FOO", "read foo.secret"},
+		{"bob", "CREATE TEMP TABLE bar (id INTEGER)", "create_temp_table bar"},
+		{"bob", "ATTACH ':memory:' AS x", "attach :memory:"},
+	}
+	for _, tt := range tests {
+		err := e.Check(tt.username, stmts(tt.stmt), false)
+		if tt.operation == "" {
+			if err != nil {
+				t.Fatalf("user %q statement %q unexpectedly denied: %s", tt.username, tt.stmt, err.Error())
+			}
+			continue
+		}
+		var v *Violation
+		if !errors.As(err, &v) {
+			t.Fatalf("user %q statement %q not denied", tt.username, tt.stmt)
+		}
+		if v.Class != ClassAuthorizer || v.Action != ActionBlock || v.Operation != tt.operation {
+			t.Fatalf("user %q statement %q wrong violation: %+v", tt.username, tt.stmt, v)
+		}
+	}
+
+	// Statements which cannot be compiled cannot be checked.
+	err = e.Check("bob", stmts("SELECT * FROM qux"), false)
+	var v *Violation
+	if !errors.As(err, &v) || v.Reason == "" {
+		t.Fatalf("statement on missing table not denied, got %v", err)
+	}
+}
+
+func Test_NewEngineFromFile(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "policy")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %s", err.Error())
+	}
+	if _, err := f.WriteString(`[{"username": "*", "block": ["attach"]}]`); err != nil {
+		t.Fatalf("failed to write policy: %s", err.Error())
+	}
+	f.Close()
+
+	e, err := NewEngineFromFile(f.Name())
+	if err != nil {
+		t.Fatalf("failed to create engine from file: %s", err.Error())
+	}
+	if e.Action("anyone", ClassAttach) != ActionBlock {
+		t.Fatalf("attach not blocked")
+	}
+}
+
+func stmts(s ...string) []*command.Statement {
+	stmts := make([]*command.Statement, len(s))
+	for i := range s {
+		stmts[i] = &command.Statement{Sql: s[i]}
+	}
+	return stmts
+}
@@ -17,6 +17,8 @@ const (
 	numStatementsTx = "statements_tx"
 	numTimeout      = "num_timeout"
 	numFlush        = "num_flush"
+	numReplayed     = "statements_replayed"
+	numAckErrors    = "ack_errors"
 )
 
 func init() {
@@ -31,6 +33,8 @@ func ResetStats() {
 	stats.Add(numStatementsTx, 0)
 	stats.Add(numTimeout, 0)
 	stats.Add(numFlush, 0)
+	stats.Add(numReplayed, 0)
+	stats.Add(numAckErrors, 0)
 }
 
 // FlushChannel is the type passed to the Queue, if caller wants
@@ -41,11 +45,20 @@ type FlushChannel chan bool
 type Request struct {
 	SequenceNumber int64
 	Statements     []*command.Statement
+	IdempotencyKey string // Set only if the batch is a single write, written with a key.
 	flushChans     []FlushChannel
+	wal            *wal
 }
 
-// Close closes a request, closing any associated flush channels.
+// Close closes a request, closing any associated flush channels. If the
+// queue is durable, the request is recorded as processed, so it is not
+// replayed when the queue is next opened.
 func (r *Request) Close() {
+	if r.wal != nil {
+		if err := r.wal.Ack(r.SequenceNumber); err != nil {
+			stats.Add(numAckErrors, 1)
+		}
+	}
 	for _, c := range r.flushChans {
 		close(c)
 	}
@@ -55,6 +68,7 @@ type queuedStatements struct {
 	SequenceNumber int64
 	Statements     []*command.Statement
 	flushChan      FlushChannel
+	IdempotencyKey string
 }
 
 func mergeQueued(qs []*queuedStatements) *Request {
@@ -66,6 +80,9 @@ func mergeQueued(qs []*queuedStatements) *Request {
 		}
 	}
 
+	if len(qs) == 1 {
+		o.IdempotencyKey = qs[0].IdempotencyKey
+	}
 	for i := range qs {
 		if o.SequenceNumber < qs[i].SequenceNumber {
 			o.SequenceNumber = qs[i].SequenceNumber
@@ -96,6 +113,8 @@ type Queue struct {
 	seqMu  sync.Mutex
 	seqNum int64
 
+	wal *wal
+
 	// Whitebox unit-testing
 	numTimeouts int
 }
@@ -119,6 +138,41 @@ func New(maxSize, batchSize int, t time.Duration) *Queue {
 	return q
 }
 
+// NewDurable returns a Queue backed by an on-disk log at path. Statements
+// are written to the log before Write returns, so statements which were
+// accepted but never processed survive a restart, and are queued again,
+// ahead of any new writes, when the queue is next opened. Since a request is
+// only recorded as processed once it is closed, statements may be processed
+// more than once if the process stops between the two.
+func NewDurable(maxSize, batchSize int, t time.Duration, path string) (*Queue, error) {
+	w, pending, err := openWAL(path)
+	if err != nil {
+		return nil, err
+	}
+	q := New(maxSize, batchSize, t)
+	q.wal = w
+	if q.seqNum < w.last {
+		q.seqNum = w.last
+	}
+
+	// Hold the sequence lock until every pending write is queued, so new
+	// writes follow them. The queue may be full until its requests are read,
+	// so this can't block the caller.
+	q.seqMu.Lock()
+	go func() {
+		defer q.seqMu.Unlock()
+		for _, qs := range pending {
+			select {
+			case q.batchCh <- qs:
+				stats.Add(numReplayed, int64(len(qs.Statements)))
+			case <-q.done:
+				return
+			}
+		}
+	}()
+	return q, nil
+}
+
 // Write queues a request, and returns a monotonically incrementing
 // sequence number associated with the slice of statements. If one
 // slice has a larger sequence number than a number, the former slice
@@ -127,6 +181,13 @@ func New(maxSize, batchSize int, t time.Duration) *Queue {
 // c is an optional channel. If non-nil, it will be closed when the Request
 // containing these statements is closed.
 func (q *Queue) Write(stmts []*command.Statement, c FlushChannel) (int64, error) {
+	return q.WriteWithKey(stmts, "", c)
+}
+
+// WriteWithKey is the same as Write, but the statements carry an idempotency
+// key. They are never batched with any other statements, so that the key
+// applies to them alone.
+func (q *Queue) WriteWithKey(stmts []*command.Statement, key string, c FlushChannel) (int64, error) {
 	select {
 	case <-q.done:
 		return 0, errors.New("queue is closed")
@@ -137,10 +198,17 @@ func (q *Queue) Write(stmts []*command.Statement, c FlushChannel) (int64, error)
 	defer q.seqMu.Unlock()
 	q.seqNum++
 
+	if q.wal != nil {
+		if err := q.wal.Write(q.seqNum, stmts, key); err != nil {
+			return 0, err
+		}
+	}
+
 	q.batchCh <- &queuedStatements{
 		SequenceNumber: q.seqNum,
 		Statements:     stmts,
 		flushChan:      c,
+		IdempotencyKey: key,
 	}
 	stats.Add(numStatementsRx, int64(len(stmts)))
 	return q.seqNum, nil
@@ -159,10 +227,30 @@ func (q *Queue) Close() error {
 	default:
 		close(q.done)
 		<-q.closed
+		if q.wal != nil {
+			return q.wal.Close()
+		}
 	}
 	return nil
 }
 
+// Durable returns whether the queue is backed by an on-disk log.
+func (q *Queue) Durable() bool {
+	return q.wal != nil
+}
+
+// Acked returns the sequence number of the last request recorded as
+// processed in the on-disk log, including before the queue was opened. It
+// is zero if the queue is not durable.
+func (q *Queue) Acked() int64 {
+	if q.wal == nil {
+		return 0
+	}
+	q.wal.mu.Lock()
+	defer q.wal.mu.Unlock()
+	return q.wal.acked
+}
+
 // Depth returns the number of queued requests
 func (q *Queue) Depth() int {
 	return len(q.batchCh)
@@ -170,11 +258,16 @@ func (q *Queue) Depth() int {
 
 // Stats returns stats on this queue.
 func (q *Queue) Stats() (map[string]interface{}, error) {
-	return map[string]interface{}{
+	s := map[string]interface{}{
 		"max_size":   q.maxSize,
 		"batch_size": q.batchSize,
 		"timeout":    q.timeout.String(),
-	}, nil
+		"durable":    q.wal != nil,
+	}
+	if q.wal != nil {
+		s["wal_path"] = q.wal.path
+	}
+	return s, nil
 }
 
 func (q *Queue) run() {
@@ -189,6 +282,7 @@ func (q *Queue) run() {
 		// mergeQueued returns a new object, ownership will pass
 		// implicitly to the other side of sendCh.
 		req := mergeQueued(queuedStmts)
+		req.wal = q.wal
 		q.sendCh <- req
 		stats.Add(numStatementsTx, int64(len(req.Statements)))
 		queuedStmts = queuedStmts[:0] // Better on the GC than setting to nil.
@@ -197,6 +291,18 @@ func (q *Queue) run() {
 	for {
 		select {
 		case s := <-q.batchCh:
+			if s.IdempotencyKey != "" {
+				// Written alone, after anything already queued.
+				if len(queuedStmts) > 0 {
+					if !timer.Stop() {
+						<-timer.C
+					}
+					writeFn()
+				}
+				queuedStmts = append(queuedStmts, s)
+				writeFn()
+				continue
+			}
 			queuedStmts = append(queuedStmts, s)
 			if len(queuedStmts) == 1 {
 				// First item in queue, start the timer so that if
@@ -1,6 +1,8 @@
 package queue
 
 import (
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 	"time"
@@ -30,57 +32,57 @@ func Test_MergeQueuedStatements(t *testing.T) {
 	}{
 		{
 			qs: []*queuedStatements{
-				{1, nil, flushChan1},
+				{1, nil, flushChan1, ""},
 			},
-			exp: &Request{1, nil, []FlushChannel{flushChan1}},
+			exp: &Request{1, nil, "", []FlushChannel{flushChan1}, nil},
 		},
 		{
 			qs: []*queuedStatements{
-				{1, nil, flushChan1},
-				{2, testStmtsFoo, nil},
+				{1, nil, flushChan1, ""},
+				{2, testStmtsFoo, nil, ""},
 			},
-			exp: &Request{2, testStmtsFoo, []FlushChannel{flushChan1}},
+			exp: &Request{2, testStmtsFoo, "", []FlushChannel{flushChan1}, nil},
 		},
 		{
 			qs: []*queuedStatements{
-				{1, testStmtsFoo, nil},
+				{1, testStmtsFoo, nil, ""},
 			},
-			exp: &Request{1, testStmtsFoo, nil},
+			exp: &Request{1, testStmtsFoo, "", nil, nil},
 		},
 		{
 			qs: []*queuedStatements{
-				{1, testStmtsFoo, nil},
-				{2, testStmtsBar, nil},
+				{1, testStmtsFoo, nil, ""},
+				{2, testStmtsBar, nil, ""},
 			},
-			exp: &Request{2, testStmtsFooBar, nil},
+			exp: &Request{2, testStmtsFooBar, "", nil, nil},
 		},
 		{
 			qs: []*queuedStatements{
-				{1, testStmtsFooBar, nil},
-				{2, testStmtsFoo, nil},
+				{1, testStmtsFooBar, nil, ""},
+				{2, testStmtsFoo, nil, ""},
 			},
-			exp: &Request{2, testStmtsFooBarFoo, nil},
+			exp: &Request{2, testStmtsFooBarFoo, "", nil, nil},
 		},
 		{
 			qs: []*queuedStatements{
-				{1, testStmtsFooBar, flushChan1},
-				{2, testStmtsFoo, flushChan2},
+				{1, testStmtsFooBar, flushChan1, ""},
+				{2, testStmtsFoo, flushChan2, ""},
 			},
-			exp: &Request{2, testStmtsFooBarFoo, []FlushChannel{flushChan1, flushChan2}},
+			exp: &Request{2, testStmtsFooBarFoo, "", []FlushChannel{flushChan1, flushChan2}, nil},
 		},
 		{
 			qs: []*queuedStatements{
-				{1, testStmtsFooBar, nil},
-				{2, testStmtsFoo, flushChan2},
+				{1, testStmtsFooBar, nil, ""},
+				{2, testStmtsFoo, flushChan2, ""},
 			},
-			exp: &Request{2, testStmtsFooBarFoo, []FlushChannel{flushChan2}},
+			exp: &Request{2, testStmtsFooBarFoo, "", []FlushChannel{flushChan2}, nil},
 		},
 		{
 			qs: []*queuedStatements{
-				{2, testStmtsFooBar, nil},
-				{1, testStmtsFoo, flushChan2},
+				{2, testStmtsFooBar, nil, ""},
+				{1, testStmtsFoo, flushChan2, ""},
 			},
-			exp: &Request{2, testStmtsFooBarFoo, []FlushChannel{flushChan2}},
+			exp: &Request{2, testStmtsFooBarFoo, "", []FlushChannel{flushChan2}, nil},
 		},
 	}
 
@@ -427,3 +429,152 @@ func Test_NewQueueWriteTimeoutBatch(t *testing.T) {
 		t.Fatalf("timed out waiting for statement")
 	}
 }
+
+func Test_DurableQueueReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.wal")
+
+	q, err := NewDurable(1024, 1, 60*time.Second, path)
+	if err != nil {
+		t.Fatalf("failed to create durable queue: %s", err.Error())
+	}
+	if !q.Durable() {
+		t.Fatalf("queue not durable")
+	}
+	seqFoo, err := q.Write(testStmtsFoo, nil)
+	if err != nil {
+		t.Fatalf("failed to write: %s", err.Error())
+	}
+	seqBar, err := q.Write(testStmtsBar, nil)
+	if err != nil {
+		t.Fatalf("failed to write: %s", err.Error())
+	}
+
+	// Process only the first request before the queue is closed.
+	select {
+	case req := <-q.C:
+		if req.SequenceNumber != seqFoo {
+			t.Fatalf("wrong sequence number, exp %d, got %d", seqFoo, req.SequenceNumber)
+		}
+		req.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for statement")
+	}
+	q.Close()
+
+	q, err = NewDurable(1024, 1, 60*time.Second, path)
+	if err != nil {
+		t.Fatalf("failed to reopen durable queue: %s", err.Error())
+	}
+	defer q.Close()
+	if exp, got := seqFoo, q.Acked(); exp != got {
+		t.Fatalf("wrong acked sequence number, exp %d, got %d", exp, got)
+	}
+	seqFoo2, err := q.Write(testStmtsFoo, nil)
+	if err != nil {
+		t.Fatalf("failed to write: %s", err.Error())
+	}
+	if seqFoo2 <= seqBar {
+		t.Fatalf("sequence number %d not greater than replayed %d", seqFoo2, seqBar)
+	}
+
+	// The unprocessed write is replayed, ahead of the new write.
+	for _, exp := range []struct {
+		seq int64
+		sql string
+	}{
+		{seqBar, "SELECT * FROM bar"},
+		{seqFoo2, "SELECT * FROM foo"},
+	} {
+		select {
+		case req := <-q.C:
+			if req.SequenceNumber != exp.seq {
+				t.Fatalf("wrong sequence number, exp %d, got %d", exp.seq, req.SequenceNumber)
+			}
+			if req.Statements[0].Sql != exp.sql {
+				t.Fatalf("received wrong SQL, exp %s, got %s", exp.sql, req.Statements[0].Sql)
+			}
+			req.Close()
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for statement")
+		}
+	}
+
+	// Every write was processed, so the log holds just the acknowledgement.
+	if fi, err := os.Stat(path); err != nil || fi.Size() != 17 {
+		t.Fatalf("log not truncated once every write was processed")
+	}
+	if exp, got := seqFoo2, q.Acked(); exp != got {
+		t.Fatalf("wrong acked sequence number, exp %d, got %d", exp, got)
+	}
+}
+
+func Test_DurableQueueTornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.wal")
+
+	q, err := NewDurable(1024, 1, 60*time.Second, path)
+	if err != nil {
+		t.Fatalf("failed to create durable queue: %s", err.Error())
+	}
+	if _, err := q.Write(testStmtsFoo, nil); err != nil {
+		t.Fatalf("failed to write: %s", err.Error())
+	}
+	q.Close()
+
+	// Simulate a crash part way through appending a second write.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("failed to open log: %s", err.Error())
+	}
+	if _, err := f.Write([]byte{0, 0, 0, 100, 1, 2, 3}); err != nil {
+		t.Fatalf("failed to write to log: %s", err.Error())
+	}
+	f.Close()
+
+	q, err = NewDurable(1024, 1, 60*time.Second, path)
+	if err != nil {
+		t.Fatalf("failed to reopen durable queue: %s", err.Error())
+	}
+	defer q.Close()
+	select {
+	case req := <-q.C:
+		if len(req.Statements) != 1 || req.Statements[0].Sql != "SELECT * FROM foo" {
+			t.Fatalf("received wrong statements")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for statement")
+	}
+}
+
+func Test_NewQueueWriteWithKey(t *testing.T) {
+	q := New(1024, 10, 60*time.Second)
+	defer q.Close()
+
+	if _, err := q.Write(testStmtsFoo, nil); err != nil {
+		t.Fatalf("failed to write: %s", err.Error())
+	}
+	if _, err := q.WriteWithKey(testStmtsBar, "key1", nil); err != nil {
+		t.Fatalf("failed to write: %s", err.Error())
+	}
+
+	// The statements already queued are written first, then the keyed
+	// statements alone, without waiting for the timeout.
+	for _, exp := range []struct {
+		sql string
+		key string
+	}{
+		{"SELECT * FROM foo", ""},
+		{"SELECT * FROM bar", "key1"},
+	} {
+		select {
+		case req := <-q.C:
+			if len(req.Statements) != 1 || req.Statements[0].Sql != exp.sql {
+				t.Fatalf("received wrong statements, exp %s", exp.sql)
+			}
+			if req.IdempotencyKey != exp.key {
+				t.Fatalf("wrong idempotency key, exp %q, got %q", exp.key, req.IdempotencyKey)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for statement")
+		}
+	}
+}
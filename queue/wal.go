@@ -0,0 +1,251 @@
+package queue
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/rqlite/rqlite/command"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	walRecordWrite = 1
+	walRecordAck   = 2
+
+	// walCompactSize is the size above which the log is rewritten, keeping
+	// only the writes which have not been acknowledged.
+	walCompactSize = 64 * 1024 * 1024
+
+	// walMaxRecordSize bounds the length of a record, so that a corrupt
+	// length is not trusted.
+	walMaxRecordSize = 1024 * 1024 * 1024
+)
+
+// wal is an append-only on-disk log of the statements written to a Queue,
+// and of which of them have been processed.
+//
+// Each record is the length of its payload as a uint32, the payload, and the
+// CRC32 of the payload. A payload is the record type, a sequence number as a
+// uint64 and, for a write, a marshalled command.Request holding the
+// statements, and any idempotency key, written with that sequence number. An acknowledgement records
+// that every write up to and including its sequence number was processed.
+type wal struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+	size int64
+
+	last  int64 // Sequence number of the last write.
+	acked int64 // Sequence number of the last acknowledgement.
+}
+
+// openWAL opens the log at path, creating it if it does not exist. It
+// returns the writes in the log which were never acknowledged, in the order
+// they were written. A record left incomplete by a crash while it was being
+// appended is discarded.
+func openWAL(path string) (*wal, []*queuedStatements, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	w := &wal{path: path, f: f}
+
+	var writes []*queuedStatements
+	err = readWAL(f, func(typ byte, seq int64, data []byte, end int64) error {
+		w.size = end
+		switch typ {
+		case walRecordWrite:
+			var req command.Request
+			if err := proto.Unmarshal(data, &req); err != nil {
+				return err
+			}
+			writes = append(writes, &queuedStatements{
+				SequenceNumber: seq,
+				Statements:     req.Statements,
+				IdempotencyKey: req.IdempotencyKey,
+			})
+			if seq > w.last {
+				w.last = seq
+			}
+		case walRecordAck:
+			if seq > w.acked {
+				w.acked = seq
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if err := f.Truncate(w.size); err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if _, err := f.Seek(w.size, io.SeekStart); err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	pending := writes[:0]
+	for _, qs := range writes {
+		if qs.SequenceNumber > w.acked {
+			pending = append(pending, qs)
+		}
+	}
+	return w, pending, nil
+}
+
+// readWAL calls fn with each complete record read from r, along with the
+// offset of the end of the record. It stops, without error, at the first
+// record which is incomplete or corrupt.
+func readWAL(r io.Reader, fn func(typ byte, seq int64, data []byte, end int64) error) error {
+	br := bufio.NewReader(r)
+	var off int64
+	var hdr [4]byte
+	for {
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			return nil
+		}
+		n := binary.BigEndian.Uint32(hdr[:])
+		if n < 9 || n > walMaxRecordSize {
+			return nil
+		}
+		buf := make([]byte, n+4)
+		if _, err := io.ReadFull(br, buf); err != nil {
+			return nil
+		}
+		payload := buf[:n]
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(buf[n:]) {
+			return nil
+		}
+		off += int64(len(hdr) + len(buf))
+		seq := int64(binary.BigEndian.Uint64(payload[1:9]))
+		if err := fn(payload[0], seq, payload[9:], off); err != nil {
+			return err
+		}
+	}
+}
+
+// Write appends the statements written with the given sequence number and
+// idempotency key to the log, and syncs it to disk.
+func (w *wal) Write(seq int64, stmts []*command.Statement, key string) error {
+	data, err := proto.Marshal(&command.Request{Statements: stmts, IdempotencyKey: key})
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.append(walRecordWrite, seq, data); err != nil {
+		return err
+	}
+	w.last = seq
+	return nil
+}
+
+// Ack records that every write up to and including the given sequence
+// number was processed. Once every write in the log has been processed the
+// log is truncated to just the acknowledgement, and once it grows large it
+// is compacted.
+func (w *wal) Ack(seq int64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if seq <= w.acked {
+		return nil
+	}
+	w.acked = seq
+
+	if w.acked >= w.last {
+		if err := w.f.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := w.f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		w.size = 0
+	}
+	if err := w.append(walRecordAck, seq, nil); err != nil {
+		return err
+	}
+	if w.size > walCompactSize {
+		return w.compact()
+	}
+	return nil
+}
+
+// Close closes the log.
+func (w *wal) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.f.Close()
+}
+
+// append writes a record to the end of the log, and syncs it to disk. It
+// must be called with mu held.
+func (w *wal) append(typ byte, seq int64, data []byte) error {
+	buf := make([]byte, 4+9+len(data)+4)
+	binary.BigEndian.PutUint32(buf, uint32(9+len(data)))
+	buf[4] = typ
+	binary.BigEndian.PutUint64(buf[5:], uint64(seq))
+	copy(buf[13:], data)
+	binary.BigEndian.PutUint32(buf[13+len(data):], crc32.ChecksumIEEE(buf[4:13+len(data)]))
+
+	if _, err := w.f.Write(buf); err != nil {
+		// Don't leave a partial record for later records to follow.
+		w.f.Truncate(w.size)
+		w.f.Seek(w.size, io.SeekStart)
+		return err
+	}
+	if err := w.f.Sync(); err != nil {
+		return err
+	}
+	w.size += int64(len(buf))
+	return nil
+}
+
+// compact rewrites the log, keeping only the last acknowledgement and the
+// writes which have not been acknowledged. It must be called with mu held.
+func (w *wal) compact() error {
+	if _, err := w.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	tmpPath := w.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	nw := &wal{path: w.path, f: tmp, last: w.last, acked: w.acked}
+	err = nw.append(walRecordAck, w.acked, nil)
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	err = readWAL(w.f, func(typ byte, seq int64, data []byte, end int64) error {
+		if typ != walRecordWrite || seq <= w.acked {
+			return nil
+		}
+		return nw.append(typ, seq, data)
+	})
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, w.path); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	w.f.Close()
+	w.f = tmp
+	w.size = nw.size
+	if _, err := w.f.Seek(w.size, io.SeekStart); err != nil {
+		return err
+	}
+	return nil
+}
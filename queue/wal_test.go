@@ -0,0 +1,64 @@
+package queue
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/rqlite/rqlite/command"
+)
+
+func Test_WALCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.wal")
+	w, pending, err := openWAL(path)
+	if err != nil {
+		t.Fatalf("failed to open log: %s", err.Error())
+	}
+	if len(pending) != 0 {
+		t.Fatalf("new log has pending writes")
+	}
+
+	for i, stmts := range [][]*command.Statement{testStmtsFoo, testStmtsBar, testStmtsFooBar} {
+		if err := w.Write(int64(i+1), stmts, ""); err != nil {
+			t.Fatalf("failed to write to log: %s", err.Error())
+		}
+	}
+	if err := w.Ack(1); err != nil {
+		t.Fatalf("failed to ack: %s", err.Error())
+	}
+	sz := w.size
+	if err := w.compact(); err != nil {
+		t.Fatalf("failed to compact log: %s", err.Error())
+	}
+	if w.size >= sz {
+		t.Fatalf("log not smaller after compaction, was %d, now %d", sz, w.size)
+	}
+
+	// The log is still usable once compacted.
+	if err := w.Write(4, testStmtsFoo, ""); err != nil {
+		t.Fatalf("failed to write to log: %s", err.Error())
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to close log: %s", err.Error())
+	}
+
+	w, pending, err = openWAL(path)
+	if err != nil {
+		t.Fatalf("failed to reopen log: %s", err.Error())
+	}
+	defer w.Close()
+	if exp, got := 3, len(pending); exp != got {
+		t.Fatalf("wrong number of pending writes, exp %d, got %d", exp, got)
+	}
+	for i, exp := range []struct {
+		seq int64
+		n   int
+	}{{2, 1}, {3, 2}, {4, 1}} {
+		if pending[i].SequenceNumber != exp.seq || len(pending[i].Statements) != exp.n {
+			t.Fatalf("wrong pending write %d: sequence number %d, %d statements",
+				i, pending[i].SequenceNumber, len(pending[i].Statements))
+		}
+	}
+	if w.last != 4 || w.acked != 1 {
+		t.Fatalf("wrong sequence numbers, last %d, acked %d", w.last, w.acked)
+	}
+}
@@ -0,0 +1,305 @@
+// Package sftp provides a client for SFTP servers, used for automatic
+// backups to, and restores from, remote hosts over SSH.
+package sftp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+
+	pkgsftp "github.com/pkg/sftp"
+	"github.com/rqlite/rqlite/auto"
+	"github.com/rqlite/rqlite/internal/offset"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	// defaultPort is the port used if the host does not include one.
+	defaultPort = "22"
+
+	// tmpSuffix is appended to the path while an upload is in progress, so
+	// that a partial upload never replaces a complete one.
+	tmpSuffix = ".tmp"
+
+	// copyBufferSize is the size of the buffer used when downloading.
+	copyBufferSize = 32 * 1024
+)
+
+// SFTPConfig is the subconfig for the SFTP storage type
+type SFTPConfig struct {
+	Host           string `json:"host"`
+	Username       string `json:"username"`
+	PrivateKeyFile string `json:"private_key_file"`
+	Passphrase     string `json:"passphrase,omitempty"`
+	KnownHostsFile string `json:"known_hosts_file"`
+	Path           string `json:"path"`
+}
+
+// SFTPClient is a client for uploading data to, and downloading data from,
+// an SFTP server. It authenticates with a private key, and verifies the
+// server against a known_hosts file.
+type SFTPClient struct {
+	host           string
+	username       string
+	privateKeyFile string
+	passphrase     string
+	knownHostsFile string
+	path           string
+}
+
+// NewSFTPClient returns an instance of an SFTPClient. If host does not
+// include a port, port 22 is used. passphrase is only needed if the private
+// key is encrypted.
+func NewSFTPClient(host, username, privateKeyFile, passphrase, knownHostsFile, path string) *SFTPClient {
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, defaultPort)
+	}
+	return &SFTPClient{
+		host:           host,
+		username:       username,
+		privateKeyFile: privateKeyFile,
+		passphrase:     passphrase,
+		knownHostsFile: knownHostsFile,
+		path:           path,
+	}
+}
+
+// String returns a string representation of the SFTPClient.
+func (s *SFTPClient) String() string {
+	return fmt.Sprintf("sftp://%s@%s/%s", s.username, s.host, strings.TrimPrefix(s.path, "/"))
+}
+
+// Upload uploads data to the SFTP server. The data is first written to a
+// temporary file alongside the destination, which is renamed over the
+// destination once the upload is complete.
+func (s *SFTPClient) Upload(ctx context.Context, reader io.Reader) error {
+	return s.upload(ctx, s.path, reader)
+}
+
+// Name returns the path to which data is uploaded.
+func (s *SFTPClient) Name() string {
+	return s.path
+}
+
+// UploadTo uploads data to the given path, rather than the client's own.
+func (s *SFTPClient) UploadTo(ctx context.Context, dst string, reader io.Reader) error {
+	return s.upload(ctx, dst, reader)
+}
+
+// UploadDelta uploads the delta of an incremental backup to the SFTP server,
+// alongside the full backup.
+func (s *SFTPClient) UploadDelta(ctx context.Context, reader io.Reader) error {
+	return s.upload(ctx, s.path+auto.DeltaSuffix, reader)
+}
+
+func (s *SFTPClient) upload(ctx context.Context, dst string, reader io.Reader) error {
+	client, closeFn, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	if dir := path.Dir(dst); dir != "." && dir != "/" {
+		if err := client.MkdirAll(dir); err != nil {
+			return fmt.Errorf("failed to create directory %s on %s: %w", dir, s.host, err)
+		}
+	}
+
+	tmp := dst + tmpSuffix
+	f, err := client.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return fmt.Errorf("failed to create %s on %s: %w", tmp, s.host, err)
+	}
+	if _, err := f.ReadFrom(reader); err != nil {
+		f.Close()
+		client.Remove(tmp)
+		return fmt.Errorf("failed to upload %s to %s: %w", dst, s.host, err)
+	}
+	if err := f.Close(); err != nil {
+		client.Remove(tmp)
+		return fmt.Errorf("failed to upload %s to %s: %w", dst, s.host, err)
+	}
+
+	if err := client.PosixRename(tmp, dst); err != nil {
+		// Not all servers support atomic renames. Fall back to removing
+		// the destination first, since a plain rename will not replace it.
+		client.Remove(dst)
+		if err := client.Rename(tmp, dst); err != nil {
+			return fmt.Errorf("failed to rename %s to %s on %s: %w", tmp, dst, s.host, err)
+		}
+	}
+	return nil
+}
+
+// Download downloads data from the SFTP server.
+func (s *SFTPClient) Download(ctx context.Context, writer io.WriterAt) error {
+	return s.DownloadSequential(ctx, offset.NewWriter(writer))
+}
+
+// DownloadSequential downloads data from the SFTP server, writing it to w in
+// order.
+func (s *SFTPClient) DownloadSequential(ctx context.Context, w io.Writer) error {
+	client, closeFn, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	f, err := client.Open(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to download from %v: %w", s, err)
+	}
+	defer f.Close()
+
+	buf := make([]byte, copyBufferSize)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to download from %v: %w", s, err)
+		}
+	}
+}
+
+// CopyVersion copies the file on the SFTP server to the given version of
+// it, which is stored alongside it. The copy is a hard link, so no data is
+// transferred, and later uploads, which replace the file, leave the version
+// unchanged.
+func (s *SFTPClient) CopyVersion(ctx context.Context, version string) error {
+	client, closeFn, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	if err := client.Link(s.path, s.versionPath(version)); err != nil {
+		return fmt.Errorf("failed to copy %v to version %s: %w", s, version, err)
+	}
+	return nil
+}
+
+// ListVersions returns the versions of the file stored on the SFTP server.
+func (s *SFTPClient) ListVersions(ctx context.Context) ([]string, error) {
+	client, closeFn, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFn()
+
+	infos, err := client.ReadDir(path.Dir(s.path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list versions of %v: %w", s, err)
+	}
+	prefix := path.Base(s.versionPath(""))
+	var versions []string
+	for _, info := range infos {
+		if !info.IsDir() && strings.HasPrefix(info.Name(), prefix) {
+			versions = append(versions, strings.TrimPrefix(info.Name(), prefix))
+		}
+	}
+	return versions, nil
+}
+
+// DeleteVersion deletes the given version of the file from the SFTP server.
+func (s *SFTPClient) DeleteVersion(ctx context.Context, version string) error {
+	client, closeFn, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	if err := client.Remove(s.versionPath(version)); err != nil {
+		return fmt.Errorf("failed to delete version %s of %v: %w", version, s, err)
+	}
+	return nil
+}
+
+func (s *SFTPClient) versionPath(version string) string {
+	return s.path + "." + version
+}
+
+// connect connects to the SFTP server. The returned function closes the
+// connection, which is also closed if ctx is done first.
+func (s *SFTPClient) connect(ctx context.Context) (*pkgsftp.Client, func(), error) {
+	cfg, err := s.sshConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.host)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to %s: %w", s.host, err)
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, s.host, cfg)
+	if err != nil {
+		close(done)
+		conn.Close()
+		return nil, nil, fmt.Errorf("failed to establish SSH connection to %s: %w", s.host, err)
+	}
+	sshClient := ssh.NewClient(c, chans, reqs)
+	client, err := pkgsftp.NewClient(sshClient)
+	if err != nil {
+		close(done)
+		sshClient.Close()
+		return nil, nil, fmt.Errorf("failed to start SFTP session with %s: %w", s.host, err)
+	}
+	return client, func() {
+		close(done)
+		client.Close()
+		sshClient.Close()
+	}, nil
+}
+
+// sshConfig returns the configuration for connecting to the server.
+func (s *SFTPClient) sshConfig() (*ssh.ClientConfig, error) {
+	if s.knownHostsFile == "" {
+		return nil, fmt.Errorf("known hosts file must be set to verify %s", s.host)
+	}
+	hostKeyCallback, err := knownhosts.New(s.knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known hosts file: %w", err)
+	}
+
+	key, err := ioutil.ReadFile(s.privateKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key file: %w", err)
+	}
+	var signer ssh.Signer
+	if s.passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(s.passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	return &ssh.ClientConfig{
+		User:            s.username,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+	}, nil
+}
@@ -0,0 +1,295 @@
+package sftp
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	pkgsftp "github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func Test_NewSFTPClient(t *testing.T) {
+	c := NewSFTPClient("example.com", "user1", "/key", "", "/known_hosts", "/backups/db.sqlite")
+	if c.host != "example.com:22" {
+		t.Fatalf("expected default port to be added, got %q", c.host)
+	}
+	if c.String() != "sftp://user1@example.com:22/backups/db.sqlite" {
+		t.Fatalf("unexpected String() %q", c.String())
+	}
+
+	c = NewSFTPClient("example.com:2222", "user1", "/key", "", "/known_hosts", "db.sqlite")
+	if c.host != "example.com:2222" {
+		t.Fatalf("expected port to be kept, got %q", c.host)
+	}
+}
+
+func Test_SFTPClientUploadDownload(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	dst := filepath.Join(t.TempDir(), "backups", "db.sqlite")
+	c := NewSFTPClient(srv.Addr(), "rqlite", srv.keyFile, "", srv.knownHostsFile, dst)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, data := range []string{"first upload", "second upload"} {
+		if err := c.Upload(ctx, strings.NewReader(data)); err != nil {
+			t.Fatalf("failed to upload: %s", err.Error())
+		}
+		b, err := ioutil.ReadFile(dst)
+		if err != nil {
+			t.Fatalf("failed to read uploaded file: %s", err.Error())
+		}
+		if string(b) != data {
+			t.Fatalf("unexpected uploaded data, exp %q, got %q", data, string(b))
+		}
+	}
+	if _, err := os.Stat(dst + tmpSuffix); !os.IsNotExist(err) {
+		t.Fatalf("temporary upload file not removed")
+	}
+
+	f := mustTempFile(t)
+	defer f.Close()
+	if err := c.Download(ctx, f); err != nil {
+		t.Fatalf("failed to download: %s", err.Error())
+	}
+	b, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatalf("failed to read downloaded file: %s", err.Error())
+	}
+	if string(b) != "second upload" {
+		t.Fatalf("unexpected downloaded data %q", string(b))
+	}
+}
+
+func Test_SFTPClientVersions(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	dst := filepath.Join(t.TempDir(), "db.sqlite")
+	c := NewSFTPClient(srv.Addr(), "rqlite", srv.keyFile, "", srv.knownHostsFile, dst)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, v := range []string{"v1", "v2"} {
+		if err := c.Upload(ctx, strings.NewReader("upload "+v)); err != nil {
+			t.Fatalf("failed to upload: %s", err.Error())
+		}
+		if err := c.CopyVersion(ctx, v); err != nil {
+			t.Fatalf("failed to copy version: %s", err.Error())
+		}
+	}
+	if err := c.UploadDelta(ctx, strings.NewReader("delta")); err != nil {
+		t.Fatalf("failed to upload delta: %s", err.Error())
+	}
+
+	// Each version is unchanged by later uploads.
+	for _, v := range []string{"v1", "v2"} {
+		b, err := ioutil.ReadFile(dst + "." + v)
+		if err != nil {
+			t.Fatalf("failed to read version: %s", err.Error())
+		}
+		if exp := "upload " + v; string(b) != exp {
+			t.Fatalf("unexpected version data, exp %q, got %q", exp, string(b))
+		}
+	}
+
+	versions, err := c.ListVersions(ctx)
+	if err != nil {
+		t.Fatalf("failed to list versions: %s", err.Error())
+	}
+	sort.Strings(versions)
+	if exp, got := "delta,v1,v2", strings.Join(versions, ","); exp != got {
+		t.Fatalf("wrong versions, exp %s, got %s", exp, got)
+	}
+	if err := c.DeleteVersion(ctx, "v1"); err != nil {
+		t.Fatalf("failed to delete version: %s", err.Error())
+	}
+	if _, err := os.Stat(dst + ".v1"); !os.IsNotExist(err) {
+		t.Fatalf("version not deleted")
+	}
+}
+
+func Test_SFTPClientDownloadMissing(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	c := NewSFTPClient(srv.Addr(), "rqlite", srv.keyFile, "", srv.knownHostsFile,
+		filepath.Join(t.TempDir(), "missing"))
+	f := mustTempFile(t)
+	defer f.Close()
+	if err := c.Download(context.Background(), f); err == nil {
+		t.Fatalf("expected error downloading missing file")
+	}
+}
+
+func Test_SFTPClientUnknownHost(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	otherKnownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	_, other := mustGenerateKey(t)
+	line := knownhosts.Line([]string{knownhosts.Normalize(srv.Addr())}, other.PublicKey())
+	if err := ioutil.WriteFile(otherKnownHosts, []byte(line+"\n"), 0600); err != nil {
+		t.Fatalf("failed to write known hosts file: %s", err.Error())
+	}
+
+	c := NewSFTPClient(srv.Addr(), "rqlite", srv.keyFile, "", otherKnownHosts,
+		filepath.Join(t.TempDir(), "db.sqlite"))
+	if err := c.Upload(context.Background(), strings.NewReader("data")); err == nil {
+		t.Fatalf("expected error connecting to server with unknown host key")
+	}
+
+	c = NewSFTPClient(srv.Addr(), "rqlite", srv.keyFile, "", "",
+		filepath.Join(t.TempDir(), "db.sqlite"))
+	if err := c.Upload(context.Background(), strings.NewReader("data")); err == nil {
+		t.Fatalf("expected error connecting without known hosts file")
+	}
+}
+
+func Test_SFTPClientUnauthorizedKey(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	keyFile := filepath.Join(t.TempDir(), "id_ed25519")
+	pemBlock, _ := mustGenerateKey(t)
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(pemBlock), 0600); err != nil {
+		t.Fatalf("failed to write key file: %s", err.Error())
+	}
+
+	c := NewSFTPClient(srv.Addr(), "rqlite", keyFile, "", srv.knownHostsFile,
+		filepath.Join(t.TempDir(), "db.sqlite"))
+	if err := c.Upload(context.Background(), strings.NewReader("data")); err == nil {
+		t.Fatalf("expected error authenticating with unauthorized key")
+	}
+}
+
+// testServer is an SFTP server which accepts a single client key.
+type testServer struct {
+	ln             net.Listener
+	keyFile        string
+	knownHostsFile string
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	dir := t.TempDir()
+
+	_, hostSigner := mustGenerateKey(t)
+	clientPEM, clientSigner := mustGenerateKey(t)
+	authorized := clientSigner.PublicKey().Marshal()
+
+	cfg := &ssh.ServerConfig{
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(key.Marshal(), authorized) {
+				return nil, nil
+			}
+			return nil, ssh.ErrNoAuth
+		},
+	}
+	cfg.AddHostKey(hostSigner)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err.Error())
+	}
+	s := &testServer{
+		ln:             ln,
+		keyFile:        filepath.Join(dir, "id_ed25519"),
+		knownHostsFile: filepath.Join(dir, "known_hosts"),
+	}
+	if err := ioutil.WriteFile(s.keyFile, pem.EncodeToMemory(clientPEM), 0600); err != nil {
+		t.Fatalf("failed to write key file: %s", err.Error())
+	}
+	line := knownhosts.Line([]string{knownhosts.Normalize(s.Addr())}, hostSigner.PublicKey())
+	if err := ioutil.WriteFile(s.knownHostsFile, []byte(line+"\n"), 0600); err != nil {
+		t.Fatalf("failed to write known hosts file: %s", err.Error())
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveConn(conn, cfg)
+		}
+	}()
+	return s
+}
+
+func (s *testServer) Addr() string {
+	return s.ln.Addr().String()
+}
+
+func (s *testServer) Close() error {
+	return s.ln.Close()
+}
+
+func serveConn(conn net.Conn, cfg *ssh.ServerConfig) {
+	defer conn.Close()
+	_, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		ch, requests, err := nc.Accept()
+		if err != nil {
+			return
+		}
+		go func(in <-chan *ssh.Request) {
+			for req := range in {
+				req.Reply(req.Type == "subsystem" && string(req.Payload[4:]) == "sftp", nil)
+			}
+		}(requests)
+		srv, err := pkgsftp.NewServer(ch)
+		if err != nil {
+			return
+		}
+		srv.Serve()
+		srv.Close()
+	}
+}
+
+func mustGenerateKey(t *testing.T) (*pem.Block, ssh.Signer) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err.Error())
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatalf("failed to marshal key: %s", err.Error())
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("failed to create signer: %s", err.Error())
+	}
+	return block, signer
+}
+
+func mustTempFile(t *testing.T) *os.File {
+	t.Helper()
+	f, err := ioutil.TempFile(t.TempDir(), "rqlite-sftp-test")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %s", err.Error())
+	}
+	return f
+}
@@ -0,0 +1,133 @@
+package snapshot
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	// DefaultChunkSize is the default size of the chunks in which the files
+	// of a full snapshot are streamed by a Store.
+	DefaultChunkSize = 1024 * 1024
+
+	chunkChecksumSize = 4
+)
+
+// ErrChunkChecksum is returned when a chunk of snapshot data does not match
+// the checksum sent with it.
+var ErrChunkChecksum = errors.New("snapshot data chunk checksum mismatch")
+
+// chunkedSize returns the number of bytes needed to stream size bytes of
+// file data in chunks of chunkSize bytes, each followed by its checksum.
+func chunkedSize(size, chunkSize int64) int64 {
+	nChunks := (size + chunkSize - 1) / chunkSize
+	return size + nChunks*chunkChecksumSize
+}
+
+// chunkingReader reads the data from an underlying reader in chunks, with
+// each chunk followed by its CRC32 checksum. Only a single chunk is ever
+// held in memory.
+type chunkingReader struct {
+	rc      io.ReadCloser
+	buf     []byte
+	pending []byte
+	err     error
+}
+
+func newChunkingReader(rc io.ReadCloser, chunkSize int64) *chunkingReader {
+	return &chunkingReader{
+		rc:  rc,
+		buf: make([]byte, chunkSize+chunkChecksumSize),
+	}
+}
+
+// Read reads chunked data.
+func (c *chunkingReader) Read(p []byte) (int, error) {
+	if len(c.pending) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		dataSize := len(c.buf) - chunkChecksumSize
+		n, err := io.ReadFull(c.rc, c.buf[:dataSize])
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		c.err = err
+		if n == 0 {
+			return 0, c.err
+		}
+		binary.LittleEndian.PutUint32(c.buf[n:], crc32.Checksum(c.buf[:n], castagnoli))
+		c.pending = c.buf[:n+chunkChecksumSize]
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// Close closes the underlying reader.
+func (c *chunkingReader) Close() error {
+	return c.rc.Close()
+}
+
+// chunkCheckingReader reads size bytes of file data from chunked data,
+// checking the checksum of every chunk before returning any of its data.
+type chunkCheckingReader struct {
+	r         io.Reader
+	remaining int64
+	buf       []byte
+	pending   []byte
+}
+
+func newChunkCheckingReader(r io.Reader, size, chunkSize int64) *chunkCheckingReader {
+	bufSize := chunkSize
+	if size < bufSize {
+		bufSize = size
+	}
+	return &chunkCheckingReader{
+		r:         r,
+		remaining: size,
+		buf:       make([]byte, bufSize+chunkChecksumSize),
+	}
+}
+
+// Read reads checked file data.
+func (c *chunkCheckingReader) Read(p []byte) (int, error) {
+	if len(c.pending) == 0 {
+		if c.remaining == 0 {
+			return 0, io.EOF
+		}
+		dataSize := int64(len(c.buf) - chunkChecksumSize)
+		if c.remaining < dataSize {
+			dataSize = c.remaining
+		}
+		chunk := c.buf[:dataSize+chunkChecksumSize]
+		if _, err := io.ReadFull(c.r, chunk); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, fmt.Errorf("error reading snapshot data chunk: %v", err)
+		}
+		sum := binary.LittleEndian.Uint32(chunk[dataSize:])
+		if crc32.Checksum(chunk[:dataSize], castagnoli) != sum {
+			stats.Add(numChunkChecksumFailures, 1)
+			return 0, ErrChunkChecksum
+		}
+		c.pending = chunk[:dataSize]
+		c.remaining -= dataSize
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// fileReader returns a reader of the size bytes of data of the next file
+// in the full snapshot data read from r, checking the data if it is chunked.
+func (x *FullSnapshot) fileReader(r io.Reader, size int64) io.Reader {
+	if x.GetChunkSize() <= 0 {
+		return r
+	}
+	return newChunkCheckingReader(r, size, x.GetChunkSize())
+}
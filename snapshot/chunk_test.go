@@ -0,0 +1,74 @@
+package snapshot
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_ChunkedFullStream(t *testing.T) {
+	contents := [][]byte{
+		[]byte("test1.db contents, longer than a chunk"),
+		[]byte(""),
+		[]byte("test1.db-wal1 contents"),
+	}
+	files := make([]string, len(contents))
+	for i, c := range contents {
+		files[i] = mustWriteToTemp(c)
+	}
+	defer func() {
+		for _, f := range files {
+			os.Remove(f)
+		}
+	}()
+
+	if _, err := NewChunkedFullStream(0, files...); err == nil {
+		t.Fatalf("expected error creating stream with zero chunk size")
+	}
+	str, err := NewChunkedFullStream(5, files...)
+	if err != nil {
+		t.Fatalf("unexpected error creating chunked stream: %v", err)
+	}
+	defer str.Close()
+	data, err := io.ReadAll(str)
+	if err != nil {
+		t.Fatalf("failed to read chunked stream: %v", err)
+	}
+	if exp, got := str.Size(), int64(len(data)); exp != got {
+		t.Fatalf("expected stream size %d, got %d", exp, got)
+	}
+
+	strHdr, _, err := NewStreamHeaderFromReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to read stream header: %v", err)
+	}
+	if exp, got := int32(chunkedStreamVersion), strHdr.GetVersion(); exp != got {
+		t.Fatalf("expected stream version %d, got %d", exp, got)
+	}
+	if exp, got := int64(5), strHdr.GetFullSnapshot().GetChunkSize(); exp != got {
+		t.Fatalf("expected chunk size %d, got %d", exp, got)
+	}
+
+	dbPath, walPaths, err := FilesFromStream(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to read files from chunked stream: %v", err)
+	}
+	defer os.Remove(dbPath)
+	for _, p := range walPaths {
+		defer os.Remove(p)
+	}
+	for i, p := range append([]string{dbPath}, walPaths...) {
+		if got := mustReadFile(p); !bytes.Equal(got, contents[i]) {
+			t.Fatalf("file %d has unexpected contents, exp %s, got %s", i, contents[i], got)
+		}
+	}
+
+	// Corrupt a byte of the last file's data.
+	data[len(data)-chunkChecksumSize-1] ^= 0xff
+	_, _, err = FilesFromStream(bytes.NewReader(data))
+	if err == nil || !strings.Contains(err.Error(), ErrChunkChecksum.Error()) {
+		t.Fatalf("expected checksum error reading corrupt stream, got %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+package snapshot
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressedSuffix is the suffix of zstd-compressed files in the store. A
+// compressed file has the name of the uncompressed file, plus this suffix.
+const compressedSuffix = ".zst"
+
+func isCompressedPath(path string) bool {
+	return strings.HasSuffix(path, compressedSuffix)
+}
+
+// dataFilePath returns the path of the data file which would be at path if
+// uncompressed. If the uncompressed file does not exist, but a compressed
+// one does, the path of the compressed file is returned. If both exist, the
+// uncompressed file takes precedence, since the compressed file is only
+// removed once a change to the uncompressed file is complete.
+func dataFilePath(path string) string {
+	if !fileExists(path) && fileExists(path+compressedSuffix) {
+		return path + compressedSuffix
+	}
+	return path
+}
+
+// compressFileSync writes a zstd-compressed copy of the file at src to dst,
+// and syncs it to disk. The size of src is recorded in the header of the
+// compressed data, so that it is known without decompressing.
+func compressFileSync(src, dst string) error {
+	srcFD, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFD.Close()
+	fi, err := srcFD.Stat()
+	if err != nil {
+		return err
+	}
+	return writeCompressedSync(dst, srcFD, fi.Size())
+}
+
+// writeCompressedSync writes the size bytes read from r to the file at path,
+// compressed, and syncs the file to disk.
+func writeCompressedSync(path string, r io.Reader, size int64) error {
+	fd, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	zw, err := zstd.NewWriter(nil)
+	if err != nil {
+		return err
+	}
+	zw.ResetContentSize(fd, size)
+	if _, err := io.Copy(zw, r); err != nil {
+		zw.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return fd.Sync()
+}
+
+// decompressFileSync writes the decompressed contents of the compressed
+// file at src to dst, and syncs it to disk.
+func decompressFileSync(src, dst string) error {
+	rc, _, err := openDataFile(src)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	fd, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	if _, err := io.Copy(fd, rc); err != nil {
+		return err
+	}
+	return fd.Sync()
+}
+
+// openDataFile opens the data file at path for reading, and returns its
+// size. If the file is compressed, reads return the decompressed data, and
+// the decompressed size is returned.
+func openDataFile(path string) (io.ReadCloser, int64, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !isCompressedPath(path) {
+		fi, err := fd.Stat()
+		if err != nil {
+			fd.Close()
+			return nil, 0, err
+		}
+		return fd, fi.Size(), nil
+	}
+
+	br := bufio.NewReader(fd)
+	size, err := compressedContentSize(br)
+	if err != nil {
+		fd.Close()
+		return nil, 0, fmt.Errorf("compressed file %s: %s", path, err)
+	}
+	if size < 0 {
+		// Without the size in the header, it can only be found by
+		// decompressing the data.
+		if size, err = decompressedSize(br); err != nil {
+			fd.Close()
+			return nil, 0, fmt.Errorf("compressed file %s: %s", path, err)
+		}
+		if _, err := fd.Seek(0, io.SeekStart); err != nil {
+			fd.Close()
+			return nil, 0, err
+		}
+		br.Reset(fd)
+	}
+	zr, err := zstd.NewReader(br)
+	if err != nil {
+		fd.Close()
+		return nil, 0, err
+	}
+	return &zstdFileReader{Decoder: zr, fd: fd}, size, nil
+}
+
+// compressedContentSize returns the decompressed size recorded in the
+// header of the zstd data in br, or -1 if none is recorded.
+func compressedContentSize(br *bufio.Reader) (int64, error) {
+	b, err := br.Peek(zstd.HeaderMaxSize)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	var hdr zstd.Header
+	if err := hdr.Decode(b); err != nil {
+		return 0, err
+	}
+	if !hdr.HasFCS || hdr.Skippable {
+		return -1, nil
+	}
+	return int64(hdr.FrameContentSize), nil
+}
+
+func decompressedSize(r io.Reader) (int64, error) {
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+	defer zr.Close()
+	return io.Copy(ioutil.Discard, zr)
+}
+
+// zstdFileReader decompresses a file, closing it when closed.
+type zstdFileReader struct {
+	*zstd.Decoder
+	fd *os.File
+}
+
+// Close closes the decoder and the file.
+func (z *zstdFileReader) Close() error {
+	z.Decoder.Close()
+	return z.fd.Close()
+}
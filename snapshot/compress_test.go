@@ -0,0 +1,82 @@
+package snapshot
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func Test_CompressFileSync(t *testing.T) {
+	src := "testdata/db-and-wals/backup.db"
+	dst := filepath.Join(t.TempDir(), baseSqliteFile+compressedSuffix)
+	if err := compressFileSync(src, dst); err != nil {
+		t.Fatalf("failed to compress file: %s", err)
+	}
+	exp := mustReadFile(src)
+	if fi, err := os.Stat(dst); err != nil || fi.Size() >= int64(len(exp)) {
+		t.Fatalf("compressed file is not smaller than original")
+	}
+
+	rc, size, err := openDataFile(dst)
+	if err != nil {
+		t.Fatalf("failed to open compressed file: %s", err)
+	}
+	defer rc.Close()
+	if size != int64(len(exp)) {
+		t.Fatalf("expected size %d, got %d", len(exp), size)
+	}
+	got, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("failed to read compressed file: %s", err)
+	}
+	if !bytes.Equal(exp, got) {
+		t.Fatalf("decompressed data does not match original")
+	}
+
+	plain := filepath.Join(t.TempDir(), baseSqliteFile)
+	if err := decompressFileSync(dst, plain); err != nil {
+		t.Fatalf("failed to decompress file: %s", err)
+	}
+	if !bytes.Equal(exp, mustReadFile(plain)) {
+		t.Fatalf("decompressed file does not match original")
+	}
+}
+
+func Test_OpenDataFile_NoContentSize(t *testing.T) {
+	exp := mustReadFile("testdata/db-and-wals/wal-00")
+	var buf bytes.Buffer
+	zw, err := zstd.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("failed to create writer: %s", err)
+	}
+	if _, err := zw.Write(exp); err != nil {
+		t.Fatalf("failed to write data: %s", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to close writer: %s", err)
+	}
+	path := filepath.Join(t.TempDir(), snapWALFile+compressedSuffix)
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("failed to write file: %s", err)
+	}
+
+	rc, size, err := openDataFile(path)
+	if err != nil {
+		t.Fatalf("failed to open compressed file: %s", err)
+	}
+	defer rc.Close()
+	if size != int64(len(exp)) {
+		t.Fatalf("expected size %d, got %d", len(exp), size)
+	}
+	got, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("failed to read compressed file: %s", err)
+	}
+	if !bytes.Equal(exp, got) {
+		t.Fatalf("decompressed data does not match original")
+	}
+}
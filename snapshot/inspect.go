@@ -0,0 +1,261 @@
+package snapshot
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rqlite/rqlite/db"
+)
+
+// SnapshotInfo describes a snapshot found by Inspect.
+type SnapshotInfo struct {
+	ID    string
+	Index uint64
+	Term  uint64
+	Full  bool
+
+	// Errors lists the problems found with the snapshot.
+	Errors []string
+}
+
+// GenerationInfo describes a generation found by Inspect.
+type GenerationInfo struct {
+	Name           string
+	BaseSize       int64
+	BaseCompressed bool
+	Snapshots      []*SnapshotInfo
+
+	// Errors lists the problems found with the generation as a whole,
+	// including with its base SQLite file.
+	Errors []string
+}
+
+// InspectReport is the result of inspecting a snapshot store.
+type InspectReport struct {
+	Dir         string
+	Generations []*GenerationInfo
+}
+
+// OK returns whether no problems were found.
+func (r *InspectReport) OK() bool {
+	for _, g := range r.Generations {
+		if len(g.Errors) > 0 {
+			return false
+		}
+		for _, s := range g.Snapshots {
+			if len(s.Errors) > 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// String returns a human-readable form of the report.
+func (r *InspectReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "snapshot store at %s, %d generation(s)\n", r.Dir, len(r.Generations))
+	for _, g := range r.Generations {
+		compressed := ""
+		if g.BaseCompressed {
+			compressed = ", compressed"
+		}
+		fmt.Fprintf(&b, "generation %s: base SQLite file %d bytes%s, %d snapshot(s)\n",
+			g.Name, g.BaseSize, compressed, len(g.Snapshots))
+		for _, s := range g.Snapshots {
+			kind := "incremental"
+			if s.Full {
+				kind = "full"
+			}
+			fmt.Fprintf(&b, "  snapshot %s: term %d, index %d, %s\n", s.ID, s.Term, s.Index, kind)
+			for _, e := range s.Errors {
+				fmt.Fprintf(&b, "    ERROR: %s\n", e)
+			}
+		}
+		for _, e := range g.Errors {
+			fmt.Fprintf(&b, "  ERROR: %s\n", e)
+		}
+	}
+	if r.OK() {
+		b.WriteString("no problems found\n")
+	} else {
+		b.WriteString("problems found\n")
+	}
+	return b.String()
+}
+
+// Inspect checks the snapshot store at dir, without changing it, and
+// without needing a Store. The meta of every snapshot is read and checked,
+// as is the WAL file of every incremental snapshot. A copy of the base
+// SQLite file of every generation is made, and integrity checked, first on
+// its own and then with the WAL files of the generation replayed into it.
+// If full is false a quick check is run, rather than a full integrity check.
+//
+// Any problems found are recorded in the report. An error is returned only
+// if dir cannot be inspected at all.
+func Inspect(dir string, full bool) (*InspectReport, error) {
+	genRoot := filepath.Join(dir, generationsDir)
+	if !dirExists(genRoot) {
+		return nil, fmt.Errorf("%s is not a snapshot store", dir)
+	}
+	entries, err := os.ReadDir(genRoot)
+	if err != nil {
+		return nil, err
+	}
+	report := &InspectReport{Dir: dir}
+	for _, entry := range entries {
+		if !entry.IsDir() || isTmpName(entry.Name()) {
+			continue
+		}
+		g, err := inspectGeneration(filepath.Join(genRoot, entry.Name()), full)
+		if err != nil {
+			return nil, err
+		}
+		report.Generations = append(report.Generations, g)
+	}
+	return report, nil
+}
+
+// inspectGeneration checks the generation at genDir.
+func inspectGeneration(genDir string, full bool) (*GenerationInfo, error) {
+	g := &GenerationInfo{Name: filepath.Base(genDir)}
+	entries, err := os.ReadDir(genDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || isTmpName(entry.Name()) {
+			continue
+		}
+		g.Snapshots = append(g.Snapshots, inspectSnapshot(filepath.Join(genDir, entry.Name())))
+	}
+	sort.SliceStable(g.Snapshots, func(i, j int) bool {
+		if g.Snapshots[i].Term != g.Snapshots[j].Term {
+			return g.Snapshots[i].Term < g.Snapshots[j].Term
+		}
+		return g.Snapshots[i].Index < g.Snapshots[j].Index
+	})
+
+	basePath := dataFilePath(filepath.Join(genDir, baseSqliteFile))
+	if !fileExists(basePath) {
+		if len(g.Snapshots) > 0 {
+			g.Errors = append(g.Errors, ErrSnapshotBaseMissing.Error())
+		}
+		return g, nil
+	}
+	g.BaseCompressed = isCompressedPath(basePath)
+	rc, sz, err := openDataFile(basePath)
+	if err != nil {
+		g.Errors = append(g.Errors, fmt.Sprintf("failed to open base SQLite file: %s", err))
+		return g, nil
+	}
+	rc.Close()
+	g.BaseSize = sz
+
+	// The checks are run on copies, since opening a SQLite file may change it.
+	tmpDir, err := ioutil.TempDir("", "rqlite-inspect-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+	dbPath := filepath.Join(tmpDir, baseSqliteFile)
+	if err := decompressFileSync(basePath, dbPath); err != nil {
+		g.Errors = append(g.Errors, fmt.Sprintf("failed to read base SQLite file: %s", err))
+		return g, nil
+	}
+	if !db.IsValidSQLiteFile(dbPath) {
+		g.Errors = append(g.Errors, "base SQLite file is not a valid SQLite file")
+		return g, nil
+	}
+	if err := checkIntegrity(dbPath, full); err != nil {
+		g.Errors = append(g.Errors, fmt.Sprintf("base SQLite file %s", err))
+		return g, nil
+	}
+
+	var wals []string
+	for _, s := range g.Snapshots {
+		if s.Full {
+			continue
+		}
+		if len(s.Errors) > 0 {
+			// Already reported, and the WALs cannot be replayed without it.
+			return g, nil
+		}
+		walPath := filepath.Join(tmpDir, s.ID+"-"+snapWALFile)
+		if err := decompressFileSync(dataFilePath(filepath.Join(genDir, s.ID, snapWALFile)), walPath); err != nil {
+			g.Errors = append(g.Errors, fmt.Sprintf("failed to read WAL file of snapshot %s: %s", s.ID, err))
+			return g, nil
+		}
+		wals = append(wals, walPath)
+	}
+	if len(wals) == 0 {
+		return g, nil
+	}
+	if err := db.ReplayWAL(dbPath, wals, false); err != nil {
+		g.Errors = append(g.Errors, fmt.Sprintf("failed to replay WAL files: %s", err))
+		return g, nil
+	}
+	if err := checkIntegrity(dbPath, full); err != nil {
+		g.Errors = append(g.Errors, fmt.Sprintf("SQLite file with WAL files replayed %s", err))
+	}
+	return g, nil
+}
+
+// inspectSnapshot checks the snapshot at snapDir.
+func inspectSnapshot(snapDir string) *SnapshotInfo {
+	s := &SnapshotInfo{ID: filepath.Base(snapDir)}
+	meta, err := readMeta(snapDir)
+	if err != nil {
+		s.Errors = append(s.Errors, fmt.Sprintf("failed to read meta: %s", err))
+		return s
+	}
+	s.Index, s.Term, s.Full = meta.Index, meta.Term, meta.Full
+	if meta.ID != s.ID {
+		s.Errors = append(s.Errors, fmt.Sprintf("meta has ID %s", meta.ID))
+	}
+	if !strings.HasPrefix(s.ID, fmt.Sprintf("%d-%d-", meta.Term, meta.Index)) {
+		s.Errors = append(s.Errors, "ID does not match term and index in meta")
+	}
+	if s.Full {
+		return s
+	}
+
+	walPath := dataFilePath(filepath.Join(snapDir, snapWALFile))
+	if !fileExists(walPath) {
+		s.Errors = append(s.Errors, "WAL file missing")
+		return s
+	}
+	rc, _, err := openDataFile(walPath)
+	if err != nil {
+		s.Errors = append(s.Errors, fmt.Sprintf("failed to open WAL file: %s", err))
+		return s
+	}
+	defer rc.Close()
+	hdr := make([]byte, walHeaderSize)
+	if _, err := io.ReadFull(rc, hdr); err != nil || !db.IsValidSQLiteWALData(hdr) {
+		s.Errors = append(s.Errors, "WAL file is not a valid SQLite WAL file")
+		return s
+	}
+	if err := checkWALFile(walPath, walChecksumPath(walPath)); err != nil {
+		s.Errors = append(s.Errors, err.Error())
+	}
+	return s
+}
+
+// checkIntegrity checks the integrity of the SQLite file at path, returning
+// an error describing the failure if the check does not pass.
+func checkIntegrity(path string, full bool) error {
+	ok, err := db.CheckIntegrity(path, full)
+	if err != nil {
+		return fmt.Errorf("could not be checked: %s", err)
+	}
+	if !ok {
+		return fmt.Errorf("failed integrity check")
+	}
+	return nil
+}
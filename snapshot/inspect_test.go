@@ -0,0 +1,127 @@
+package snapshot
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_Inspect(t *testing.T) {
+	if _, err := Inspect(t.TempDir(), true); err == nil {
+		t.Fatalf("expected error inspecting directory which is not a snapshot store")
+	}
+
+	dir := t.TempDir()
+	str, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("failed to create snapshot store: %s", err)
+	}
+	str.noAutoreap = true
+	str.EnableCompression()
+	testConfig := makeTestConfiguration("1", "2")
+
+	report, err := Inspect(dir, true)
+	if err != nil {
+		t.Fatalf("failed to inspect empty store: %s", err)
+	}
+	if !report.OK() || len(report.Generations) != 0 {
+		t.Fatalf("unexpected report for empty store:\n%s", report)
+	}
+
+	createSnapshot := func(index, term uint64, snapshot *Snapshot) {
+		sink, err := str.Create(1, index, term, testConfig, 4, nil)
+		if err != nil {
+			t.Fatalf("failed to create snapshot sink: %s", err)
+		}
+		stream, err := snapshot.OpenStream()
+		if err != nil {
+			t.Fatalf("failed to open snapshot stream: %s", err)
+		}
+		if _, err := io.Copy(sink, stream); err != nil {
+			t.Fatalf("failed to write snapshot: %s", err)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatalf("failed to close snapshot sink: %s", err)
+		}
+	}
+	createSnapshot(1, 1, NewFullSnapshot("testdata/db-and-wals/backup.db"))
+	createSnapshot(3, 2, NewWALSnapshot(mustReadFile("testdata/db-and-wals/wal-00")))
+	createSnapshot(5, 3, NewWALSnapshot(mustReadFile("testdata/db-and-wals/wal-01")))
+
+	report, err = Inspect(dir, true)
+	if err != nil {
+		t.Fatalf("failed to inspect store: %s", err)
+	}
+	if !report.OK() {
+		t.Fatalf("expected no problems, got:\n%s", report)
+	}
+	if exp, got := 1, len(report.Generations); exp != got {
+		t.Fatalf("expected %d generations, got %d", exp, got)
+	}
+	g := report.Generations[0]
+	if exp, got := firstGeneration, g.Name; exp != got {
+		t.Fatalf("expected generation %s, got %s", exp, got)
+	}
+	if !g.BaseCompressed || g.BaseSize == 0 {
+		t.Fatalf("unexpected base SQLite file details: compressed %v, size %d", g.BaseCompressed, g.BaseSize)
+	}
+	if exp, got := 3, len(g.Snapshots); exp != got {
+		t.Fatalf("expected %d snapshots, got %d", exp, got)
+	}
+	for i, exp := range []struct {
+		index, term uint64
+		full        bool
+	}{{1, 1, true}, {3, 2, false}, {5, 3, false}} {
+		s := g.Snapshots[i]
+		if s.Index != exp.index || s.Term != exp.term || s.Full != exp.full {
+			t.Fatalf("unexpected snapshot %d: index %d, term %d, full %v", i, s.Index, s.Term, s.Full)
+		}
+	}
+	if !strings.Contains(report.String(), "no problems found") {
+		t.Fatalf("unexpected report string:\n%s", report)
+	}
+
+	// Corrupt the meta of one snapshot, and tear the WAL of another.
+	genDir := filepath.Join(dir, generationsDir, firstGeneration)
+	if err := os.WriteFile(filepath.Join(genDir, g.Snapshots[1].ID, metaFileName), []byte("{"), 0644); err != nil {
+		t.Fatalf("failed to corrupt meta: %s", err)
+	}
+	walPath := filepath.Join(genDir, g.Snapshots[2].ID, snapWALFile)
+	if err := decompressFileSync(walPath+compressedSuffix, walPath); err != nil {
+		t.Fatalf("failed to decompress WAL file: %s", err)
+	}
+	if err := os.Remove(walPath + compressedSuffix); err != nil {
+		t.Fatalf("failed to remove compressed WAL file: %s", err)
+	}
+	fi, err := os.Stat(walPath)
+	if err != nil {
+		t.Fatalf("failed to stat WAL file: %s", err)
+	}
+	if err := os.Truncate(walPath, fi.Size()-1); err != nil {
+		t.Fatalf("failed to truncate WAL file: %s", err)
+	}
+
+	report, err = Inspect(dir, false)
+	if err != nil {
+		t.Fatalf("failed to inspect store: %s", err)
+	}
+	if report.OK() {
+		t.Fatalf("expected problems, got:\n%s", report)
+	}
+	for _, s := range report.Generations[0].Snapshots {
+		if s.Full {
+			if len(s.Errors) != 0 {
+				t.Fatalf("unexpected errors for full snapshot: %v", s.Errors)
+			}
+			continue
+		}
+		if len(s.Errors) == 0 {
+			t.Fatalf("expected errors for snapshot %s", s.ID)
+		}
+	}
+	if !strings.Contains(report.String(), "problems found") {
+		t.Fatalf("unexpected report string:\n%s", report)
+	}
+}
@@ -0,0 +1,82 @@
+package snapshot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+)
+
+// ErrSnapshotChecksumMismatch is returned when the SHA-256 of the base SQLite
+// file of a generation, or of the WAL file of a snapshot, does not match the
+// SHA-256 recorded in the meta of a snapshot.
+var ErrSnapshotChecksumMismatch = errors.New("snapshot SHA-256 mismatch")
+
+// dataSHA256 returns the hex-encoded SHA-256 of b.
+func dataSHA256(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// fileSHA256 returns the hex-encoded SHA-256 of the contents of the data file
+// at path. The SHA-256 of a compressed file is that of its uncompressed
+// contents, so it does not change when the file is compressed.
+func fileSHA256(path string) (string, error) {
+	rc, _, err := openDataFile(path)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, rc); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// checkFileSHA256 checks that the SHA-256 of the data file at path is exp. If
+// exp is empty, for example because the snapshot was written by an earlier
+// version, nothing is checked.
+func checkFileSHA256(path, exp string) (retErr error) {
+	if exp == "" {
+		return nil
+	}
+	defer func() {
+		if retErr != nil {
+			stats.Add(numSHA256Failures, 1)
+		}
+	}()
+	got, err := fileSHA256(path)
+	if err != nil {
+		return fmt.Errorf("failed to compute SHA-256 of %s: %s", path, err)
+	}
+	if got != exp {
+		return fmt.Errorf("%w: %s", ErrSnapshotChecksumMismatch, path)
+	}
+	return nil
+}
+
+// updateBaseSHA256 records the SHA-256 of the base SQLite file of the
+// generation at dir in meta, which must be the meta of the oldest snapshot in
+// the generation, since that is the meta checked when the base SQLite file is
+// next used. It must be called whenever the base SQLite file changes.
+func updateBaseSHA256(dir string, meta *Meta) error {
+	sum, err := fileSHA256(dataFilePath(filepath.Join(dir, baseSqliteFile)))
+	if err != nil {
+		return err
+	}
+	meta.BaseSHA256 = sum
+
+	// Replace the meta file in one step, so that a crash never leaves it
+	// partially written.
+	metaPath := filepath.Join(dir, meta.ID, metaFileName)
+	if err := writeMetaFile(tmpName(metaPath), meta); err != nil {
+		return err
+	}
+	if _, err := moveFromTmpSync(tmpName(metaPath)); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package snapshot
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_Store_SHA256(t *testing.T) {
+	dir := t.TempDir()
+	str, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("failed to create snapshot store: %s", err)
+	}
+	str.noAutoreap = true
+	testConfig := makeTestConfiguration("1", "2")
+
+	createSnapshot := func(index, term uint64, snap interface{ OpenStream() (*Stream, error) }) {
+		t.Helper()
+		sink, err := str.Create(1, index, term, testConfig, 4, nil)
+		if err != nil {
+			t.Fatalf("failed to create snapshot sink: %s", err)
+		}
+		stream, err := snap.OpenStream()
+		if err != nil {
+			t.Fatalf("failed to open snapshot stream: %s", err)
+		}
+		if _, err := io.Copy(sink, stream); err != nil {
+			t.Fatalf("failed to write snapshot: %s", err)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatalf("failed to close snapshot sink: %s", err)
+		}
+	}
+	mustOpen := func(id string) error {
+		t.Helper()
+		_, rc, err := str.Open(id)
+		if err != nil {
+			return err
+		}
+		return rc.Close()
+	}
+
+	createSnapshot(1, 1, NewFullSnapshot("testdata/db-and-wals/backup.db"))
+	createSnapshot(3, 2, NewWALSnapshot(mustReadFile("testdata/db-and-wals/wal-00")))
+	createSnapshot(5, 3, NewWALSnapshot(mustReadFile("testdata/db-and-wals/wal-01")))
+	createSnapshot(7, 4, NewWALSnapshot(mustReadFile("testdata/db-and-wals/wal-02")))
+
+	genDir, _, err := str.GetCurrentGenerationDir()
+	if err != nil {
+		t.Fatalf("failed to get current generation dir: %s", err)
+	}
+	snaps, err := str.getSnapshots(genDir)
+	if err != nil {
+		t.Fatalf("failed to list snapshots: %s", err)
+	}
+	for _, snap := range snaps {
+		if snap.Full {
+			if snap.BaseSHA256 == "" || snap.WALSHA256 != "" {
+				t.Fatalf("full snapshot %s has wrong SHA-256s", snap.ID)
+			}
+		} else if snap.WALSHA256 == "" {
+			t.Fatalf("incremental snapshot %s has no WAL SHA-256", snap.ID)
+		}
+	}
+	if err := mustOpen(snaps[0].ID); err != nil {
+		t.Fatalf("failed to open snapshot: %s", err)
+	}
+
+	// Reaping checkpoints WAL files into the base SQLite file, so its SHA-256
+	// must be recorded again.
+	if n, err := str.ReapSnapshots(genDir, 2); err != nil || n != 2 {
+		t.Fatalf("failed to reap snapshots, n=%d: %v", n, err)
+	}
+	snaps, err = str.getSnapshots(genDir)
+	if err != nil {
+		t.Fatalf("failed to list snapshots: %s", err)
+	}
+	oldest := snaps[1]
+	baseSum, err := fileSHA256(filepath.Join(genDir, baseSqliteFile))
+	if err != nil {
+		t.Fatalf("failed to compute SHA-256 of base SQLite file: %s", err)
+	}
+	if oldest.BaseSHA256 != baseSum {
+		t.Fatalf("base SHA-256 not updated after reaping, exp %s, got %s", baseSum, oldest.BaseSHA256)
+	}
+	if err := mustOpen(snaps[0].ID); err != nil {
+		t.Fatalf("failed to open snapshot after reaping: %s", err)
+	}
+
+	// A WAL file which does not match its recorded SHA-256 is detected.
+	newest := snaps[0]
+	sum := newest.WALSHA256
+	newest.WALSHA256 = dataSHA256([]byte("wrong"))
+	if err := writeMeta(filepath.Join(genDir, newest.ID), newest); err != nil {
+		t.Fatalf("failed to write meta: %s", err)
+	}
+	if err := mustOpen(newest.ID); !errors.Is(err, ErrSnapshotChecksumMismatch) {
+		t.Fatalf("expected ErrSnapshotChecksumMismatch for WAL file, got %v", err)
+	}
+	newest.WALSHA256 = sum
+	if err := writeMeta(filepath.Join(genDir, newest.ID), newest); err != nil {
+		t.Fatalf("failed to write meta: %s", err)
+	}
+
+	// So is a corrupt base SQLite file.
+	f, err := os.OpenFile(filepath.Join(genDir, baseSqliteFile), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("failed to open base SQLite file: %s", err)
+	}
+	if _, err := f.Write([]byte("corrupt")); err != nil {
+		t.Fatalf("failed to corrupt base SQLite file: %s", err)
+	}
+	f.Close()
+	if err := mustOpen(newest.ID); !errors.Is(err, ErrSnapshotChecksumMismatch) {
+		t.Fatalf("expected ErrSnapshotChecksumMismatch for base SQLite file, got %v", err)
+	}
+}
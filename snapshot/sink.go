@@ -1,11 +1,26 @@
 package snapshot
 
 import (
+	"bufio"
 	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"time"
+)
+
+var (
+	// errSinkCancelled is returned by the processing of a sink's data when
+	// the sink is cancelled.
+	errSinkCancelled = errors.New("snapshot sink cancelled")
+
+	// errSinkDataUnexpected is returned by writes to a sink once all of the
+	// snapshot data has been processed.
+	errSinkDataUnexpected = errors.New("unexpected data written to snapshot sink")
 )
 
 // Sink is a sink for writing snapshot data to a Snapshot store.
@@ -16,11 +31,17 @@ type Sink struct {
 	nextGenDir string
 	meta       *Meta
 
+	// Data written to the sink is piped to a goroutine which processes it
+	// as it arrives, so the snapshot is never held in memory, or written to
+	// disk more than once.
 	nWritten int64
-	dataFD   *os.File
+	pw       *io.PipeWriter
+	doneCh   chan error
+	finish   func() error
 
 	logger *log.Logger
 	closed bool
+	startT time.Time
 }
 
 // NewSink creates a new Sink object.
@@ -37,19 +58,29 @@ func NewSink(str *Store, workDir, currGenDir, nextGenDir string, meta *Meta) *Si
 
 // Open opens the sink for writing.
 func (s *Sink) Open() error {
-	dataPath := filepath.Join(s.workDir, "snapshot-data.tmp")
-	dataFD, err := os.Create(dataPath)
-	if err != nil {
-		return err
-	}
-	s.dataFD = dataFD
+	pr, pw := io.Pipe()
+	s.pw = pw
+	s.doneCh = make(chan error, 1)
+	s.startT = time.Now()
+	go func() {
+		err := s.processSnapshotData(pr)
+		if err == nil {
+			err = errSinkDataUnexpected
+		}
+		// Unblock any writer, and fail any further writes.
+		pr.CloseWithError(err)
+		if err == errSinkDataUnexpected {
+			err = nil
+		}
+		s.doneCh <- err
+	}()
 	return nil
 }
 
 // Write writes snapshot data to the sink. The snapshot is not in place
 // until Close is called.
 func (s *Sink) Write(p []byte) (n int, err error) {
-	n, err = s.dataFD.Write(p)
+	n, err = s.pw.Write(p)
 	s.nWritten += int64(n)
 	return
 }
@@ -62,7 +93,15 @@ func (s *Sink) ID() string {
 // Cancel cancels the snapshot. Cancel must be called if the snapshot is not
 // going to be closed.
 func (s *Sink) Cancel() error {
+	if !s.closed {
+		stats.Add(numCancelled, 1)
+	}
 	s.closed = true
+	if s.pw != nil {
+		s.pw.CloseWithError(errSinkCancelled)
+		<-s.doneCh
+		s.pw = nil
+	}
 	s.cleanup() // Best effort, ignore errors.
 	return nil
 }
@@ -75,9 +114,21 @@ func (s *Sink) Close() error {
 	}
 	s.closed = true
 	defer s.cleanup()
-	if err := s.processSnapshotData(); err != nil {
+	s.pw.Close()
+	err := <-s.doneCh
+	s.pw = nil
+	if err == nil && s.finish != nil {
+		err = s.finish()
+	}
+	if err != nil {
+		stats.Add(numCreateFailures, 1)
 		return err
 	}
+	if s.nWritten > 0 {
+		stats.Add(numCreated, 1)
+		stats.Get(createSize).(*expvar.Int).Set(s.nWritten)
+		stats.Get(createDuration).(*expvar.Int).Set(time.Since(s.startT).Milliseconds())
+	}
 
 	if !s.str.noAutoreap {
 		return s.str.Reap()
@@ -85,20 +136,19 @@ func (s *Sink) Close() error {
 	return nil
 }
 
-func (s *Sink) processSnapshotData() error {
-	if s.nWritten == 0 {
+// processSnapshotData reads snapshot data from r as it is written to the
+// sink. No snapshot is written if no data is written to the sink.
+func (s *Sink) processSnapshotData(r io.Reader) error {
+	br := bufio.NewReader(r)
+	if _, err := br.Peek(1); err == io.EOF {
 		return nil
 	}
 
-	if _, err := s.dataFD.Seek(0, 0); err != nil {
-		return err
-	}
-
-	strHdr, _, err := NewStreamHeaderFromReader(s.dataFD)
+	strHdr, _, err := NewStreamHeaderFromReader(br)
 	if err != nil {
 		return fmt.Errorf("error reading stream header: %v", err)
 	}
-	if strHdr.GetVersion() != streamVersion {
+	if !supportedStreamVersion(strHdr.GetVersion()) {
 		return fmt.Errorf("unsupported snapshot version %d", strHdr.GetVersion())
 	}
 
@@ -109,36 +159,55 @@ func (s *Sink) processSnapshotData() error {
 	if fullSnap == nil {
 		return fmt.Errorf("got nil FullSnapshot")
 	}
-	return s.processFullSnapshot(fullSnap)
+	return s.processFullSnapshot(fullSnap, br)
 }
 
 func (s *Sink) processIncrementalSnapshot(incSnap *IncrementalSnapshot) error {
 	s.logger.Printf("processing incremental snapshot")
 
+	// Check the WAL data before it goes anywhere near the store. Streams from
+	// older nodes carry no checksums, so at least check the WAL framing.
+	sums, err := WALFrameChecksums(incSnap.Data)
+	if err != nil {
+		stats.Add(numWALChecksumFailures, 1)
+		return fmt.Errorf("error checking WAL data: %v", err)
+	}
+	if exp := incSnap.GetFrameChecksums(); exp != nil {
+		if err := compareFrameChecksums(sums, exp); err != nil {
+			stats.Add(numWALChecksumFailures, 1)
+			return fmt.Errorf("error checking WAL data: %v", err)
+		}
+	}
+
 	incSnapDir := tmpName(filepath.Join(s.curGenDir, s.meta.ID))
 	if err := os.Mkdir(incSnapDir, 0755); err != nil {
 		return fmt.Errorf("error creating incremental snapshot directory: %v", err)
 	}
 
+	s.meta.WALSHA256 = dataSHA256(incSnap.Data)
 	walPath := filepath.Join(incSnapDir, snapWALFile)
-	if err := os.WriteFile(walPath, incSnap.Data, 0644); err != nil {
+	if err := writeWALFileSync(walPath, incSnap.Data, sums, s.str.compress); err != nil {
 		return fmt.Errorf("error writing WAL data: %v", err)
 	}
 	if err := s.writeMeta(incSnapDir, false); err != nil {
 		return err
 	}
 
-	// We're done! Move the directory into place.
-	dstDir, err := moveFromTmpSync(incSnapDir)
-	if err != nil {
-		s.logger.Printf("failed to move incremental snapshot directory into place: %s", err)
-		return err
+	// We're done! Move the directory into place once the sink is closed.
+	s.finish = func() error {
+		dstDir, err := moveFromTmpSync(incSnapDir)
+		if err != nil {
+			s.logger.Printf("failed to move incremental snapshot directory into place: %s", err)
+			return err
+		}
+		s.logger.Printf("incremental snapshot (ID %s) written to %s", s.meta.ID, dstDir)
+		stats.Add(numCreatedIncremental, 1)
+		return nil
 	}
-	s.logger.Printf("incremental snapshot (ID %s) written to %s", s.meta.ID, dstDir)
 	return nil
 }
 
-func (s *Sink) processFullSnapshot(fullSnap *FullSnapshot) error {
+func (s *Sink) processFullSnapshot(fullSnap *FullSnapshot, r io.Reader) error {
 	s.logger.Printf("processing full snapshot")
 
 	// We need a new generational directory, and need to create the first
@@ -150,9 +219,22 @@ func (s *Sink) processFullSnapshot(fullSnap *FullSnapshot) error {
 
 	// Rebuild the SQLite database from the snapshot data.
 	sqliteBasePath := filepath.Join(nextGenDir, baseSqliteFile)
-	if err := ReplayDB(fullSnap, s.dataFD, sqliteBasePath); err != nil {
+	if err := ReplayDB(fullSnap, r, sqliteBasePath); err != nil {
 		return fmt.Errorf("error replaying DB: %v", err)
 	}
+	sum, err := fileSHA256(sqliteBasePath)
+	if err != nil {
+		return fmt.Errorf("error computing SHA-256 of SQLite file: %v", err)
+	}
+	s.meta.BaseSHA256 = sum
+	if s.str.compress {
+		if err := compressFileSync(sqliteBasePath, sqliteBasePath+compressedSuffix); err != nil {
+			return fmt.Errorf("error compressing SQLite file: %v", err)
+		}
+		if err := os.Remove(sqliteBasePath); err != nil {
+			return fmt.Errorf("error removing uncompressed SQLite file: %v", err)
+		}
+	}
 
 	// Now create the first snapshot directory in the new generation.
 	snapDir := filepath.Join(nextGenDir, s.meta.ID)
@@ -163,19 +245,24 @@ func (s *Sink) processFullSnapshot(fullSnap *FullSnapshot) error {
 		return err
 	}
 
-	// We're done! Move the generational directory into place.
-	dstDir, err := moveFromTmpSync(nextGenDir)
-	if err != nil {
-		s.logger.Printf("failed to move full snapshot directory into place: %s", err)
-		return err
-	}
+	// We're done! Move the generational directory into place once the sink
+	// is closed.
+	s.finish = func() error {
+		dstDir, err := moveFromTmpSync(nextGenDir)
+		if err != nil {
+			s.logger.Printf("failed to move full snapshot directory into place: %s", err)
+			return err
+		}
 
-	// XXXX need to clear out any snaphot directories older than the one
-	// we just created. Maybe this should be done at startup? It's an edge case.
-	// Yeah, this is why empty snap directories need the "full" flag.
-	// Any snapshot directories older than a full snapshot directory can be
-	// removed.
-	s.logger.Printf("full snapshot (ID %s) written to %s", s.meta.ID, dstDir)
+		// XXXX need to clear out any snaphot directories older than the one
+		// we just created. Maybe this should be done at startup? It's an edge case.
+		// Yeah, this is why empty snap directories need the "full" flag.
+		// Any snapshot directories older than a full snapshot directory can be
+		// removed.
+		s.logger.Printf("full snapshot (ID %s) written to %s", s.meta.ID, dstDir)
+		stats.Add(numCreatedFull, 1)
+		return nil
+	}
 	return nil
 }
 
@@ -185,15 +272,6 @@ func (s *Sink) writeMeta(dir string, full bool) error {
 }
 
 func (s *Sink) cleanup() error {
-	if s.dataFD != nil {
-		if err := s.dataFD.Close(); err != nil {
-			return err
-		}
-		if err := os.Remove(s.dataFD.Name()); err != nil {
-			return err
-		}
-	}
-
 	if err := os.RemoveAll(tmpName(s.nextGenDir)); err != nil {
 		return err
 	}
@@ -204,7 +282,11 @@ func (s *Sink) cleanup() error {
 }
 
 func writeMeta(dir string, meta *Meta) error {
-	fh, err := os.Create(filepath.Join(dir, metaFileName))
+	return writeMetaFile(filepath.Join(dir, metaFileName), meta)
+}
+
+func writeMetaFile(path string, meta *Meta) error {
+	fh, err := os.Create(path)
 	if err != nil {
 		return fmt.Errorf("error creating meta file: %v", err)
 	}
@@ -133,6 +133,9 @@ func Test_SinkIncrementalSnapshot(t *testing.T) {
 	if !bytes.Equal(walData, mustReadFile(expWALPath)) {
 		t.Fatalf("WAL file data does not match")
 	}
+	if err := checkWALFile(expWALPath, walChecksumPath(expWALPath)); err != nil {
+		t.Fatalf("WAL file failed check: %s", err)
+	}
 
 	expMetaPath := filepath.Join(currGenDir, "snap-1234", metaFileName)
 	if !fileExists(expMetaPath) {
@@ -140,6 +143,41 @@ func Test_SinkIncrementalSnapshot(t *testing.T) {
 	}
 }
 
+func Test_SinkIncrementalSnapshot_BadChecksums(t *testing.T) {
+	tmpDir := t.TempDir()
+	workDir := filepath.Join(tmpDir, "work")
+	mustCreateDir(workDir)
+	currGenDir := filepath.Join(tmpDir, "curr")
+	mustCreateDir(currGenDir)
+	nextGenDir := filepath.Join(tmpDir, "next")
+	str := mustNewStoreForSinkTest(t)
+
+	s := NewSink(str, workDir, currGenDir, nextGenDir, makeMeta("snap-1234", 3, 2, 1))
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	walData := mustReadFile("testdata/db-and-wals/wal-00")
+	stream, err := newIncrementalStream(&IncrementalSnapshot{
+		Data:           walData,
+		FrameChecksums: []uint32{1234},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	if _, err := io.Copy(s, stream); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err == nil {
+		t.Fatalf("expected error closing sink with bad WAL checksums")
+	}
+	if dirExists(filepath.Join(currGenDir, "snap-1234")) {
+		t.Fatalf("snapshot directory exists despite bad WAL checksums")
+	}
+}
+
 func Test_SinkIncrementalSnapshot_NoWALData(t *testing.T) {
 	tmpDir := t.TempDir()
 	workDir := filepath.Join(tmpDir, "work")
@@ -185,6 +223,86 @@ func Test_SinkIncrementalSnapshot_NoWALData(t *testing.T) {
 	}
 }
 
+func Test_SinkChunkedFullSnapshot(t *testing.T) {
+	tmpDir := t.TempDir()
+	workDir := filepath.Join(tmpDir, "work")
+	mustCreateDir(workDir)
+	currGenDir := filepath.Join(tmpDir, "curr")
+	nextGenDir := filepath.Join(tmpDir, "next")
+	str := mustNewStoreForSinkTest(t)
+
+	mustStreamData := func(files ...string) []byte {
+		stream, err := NewChunkedFullStream(1000, files...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stream.Close()
+		b, err := io.ReadAll(stream)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	data := mustStreamData("testdata/db-and-wals/backup.db", "testdata/db-and-wals/wal-00",
+		"testdata/db-and-wals/wal-01", "testdata/db-and-wals/wal-02", "testdata/db-and-wals/wal-03")
+
+	// A sink which is cancelled part way through must not leave a snapshot.
+	s := NewSink(str, workDir, currGenDir, nextGenDir, makeMeta("snap-1234", 3, 2, 1))
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Write(data[:len(data)/2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Cancel(); err != nil {
+		t.Fatal(err)
+	}
+	if dirExists(nextGenDir) {
+		t.Fatalf("next generation directory %s exists after cancel", nextGenDir)
+	}
+
+	// Corrupt data must be refused.
+	corrupt := append([]byte(nil), data...)
+	corrupt[len(corrupt)/2] ^= 0xff
+	s = NewSink(str, workDir, currGenDir, nextGenDir, makeMeta("snap-1234", 3, 2, 1))
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	_, err := s.Write(corrupt)
+	if cErr := s.Close(); err == nil && cErr == nil {
+		t.Fatalf("expected error writing corrupt snapshot data")
+	}
+	if dirExists(nextGenDir) {
+		t.Fatalf("next generation directory %s exists after corrupt data", nextGenDir)
+	}
+
+	s = NewSink(str, workDir, currGenDir, nextGenDir, makeMeta("snap-1234", 3, 2, 1))
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(s, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if dirExists(nextGenDir) {
+		t.Fatalf("next generation directory %s exists before sink is closed", nextGenDir)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err := db.Open(filepath.Join(nextGenDir, baseSqliteFile), false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.QueryStringStmt("SELECT COUNT(*) FROM foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, got := `[{"columns":["COUNT(*)"],"types":["integer"],"values":[[4]]}]`, asJSON(rows); exp != got {
+		t.Fatalf("unexpected results for query, expected %s, got %s", exp, got)
+	}
+}
+
 func mustNewStoreForSinkTest(t *testing.T) *Store {
 	tmpDir := t.TempDir()
 	str, err := NewStore(tmpDir)
@@ -16,6 +16,12 @@ import (
 type Snapshot struct {
 	walData []byte
 	files   []string
+
+	// Files opened when the snapshot was created, and the number of bytes
+	// of each in the snapshot, if the snapshot is a view.
+	fds     []*os.File
+	sizes   []int64
+	release func()
 }
 
 // NewWALSnapshot creates a new snapshot from a WAL.
@@ -32,6 +38,40 @@ func NewFullSnapshot(files ...string) *Snapshot {
 	}
 }
 
+// NewFullSnapshotView creates a new snapshot from a SQLite file, and the first
+// walSize bytes of its WAL file. Both files are opened immediately, so the
+// snapshot is of the database as it is now, even if the files are later
+// replaced. The caller must ensure that the SQLite file, and the first walSize
+// bytes of the WAL file, do not change until the snapshot is released, when
+// release, if not nil, is called. If walSize is zero, no WAL file is included.
+func NewFullSnapshotView(dbPath, walPath string, walSize int64, release func()) (*Snapshot, error) {
+	s := &Snapshot{release: release}
+	add := func(path string, size int64) error {
+		fd, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		s.fds = append(s.fds, fd)
+		s.sizes = append(s.sizes, size)
+		return nil
+	}
+
+	fi, err := os.Stat(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := add(dbPath, fi.Size()); err != nil {
+		return nil, err
+	}
+	if walSize > 0 {
+		if err := add(walPath, walSize); err != nil {
+			s.closeFiles()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
 // Persist writes the snapshot to the given sink.
 func (s *Snapshot) Persist(sink raft.SnapshotSink) error {
 	startT := time.Now()
@@ -51,15 +91,35 @@ func (s *Snapshot) Persist(sink raft.SnapshotSink) error {
 	return err
 }
 
-// Release is a no-op.
-func (s *Snapshot) Release() {}
+// Release releases any files held by the snapshot.
+func (s *Snapshot) Release() {
+	s.closeFiles()
+	if s.release != nil {
+		s.release()
+		s.release = nil
+	}
+}
+
+func (s *Snapshot) closeFiles() {
+	for _, fd := range s.fds {
+		fd.Close()
+	}
+	s.fds = nil
+}
 
 // OpenStream returns a stream for reading the snapshot.
 func (s *Snapshot) OpenStream() (*Stream, error) {
+	if len(s.fds) > 0 {
+		rcs := make([]io.ReadCloser, len(s.fds))
+		for i, fd := range s.fds {
+			rcs[i] = io.NopCloser(io.NewSectionReader(fd, 0, s.sizes[i]))
+		}
+		return newFullStreamFromReaders(0, rcs, s.sizes)
+	}
 	if len(s.files) > 0 {
 		return NewFullStream(s.files...)
 	}
-	return NewIncrementalStream(s.walData)
+	return NewChecksummedIncrementalStream(s.walData)
 }
 
 // ReplayDB reconstructs the database from the given reader, and writes it to
@@ -75,7 +135,7 @@ func ReplayDB(fullSnap *FullSnapshot, r io.Reader, path string) error {
 		return fmt.Errorf("error creating SQLite file: %v", err)
 	}
 	defer sqliteBaseFD.Close()
-	if _, err := io.CopyN(sqliteBaseFD, r, dbInfo.Size); err != nil {
+	if _, err := io.CopyN(sqliteBaseFD, fullSnap.fileReader(r, dbInfo.Size), dbInfo.Size); err != nil {
 		return fmt.Errorf("error writing SQLite file data: %v", err)
 	}
 	if sqliteBaseFD.Sync() != nil {
@@ -99,7 +159,7 @@ func ReplayDB(fullSnap *FullSnapshot, r io.Reader, path string) error {
 				return fmt.Errorf("error creating WAL file: %v", err)
 			}
 			defer walFD.Close()
-			if _, err := io.CopyN(walFD, r, wal.Size); err != nil {
+			if _, err := io.CopyN(walFD, fullSnap.fileReader(r, wal.Size), wal.Size); err != nil {
 				return fmt.Errorf("error writing WAL file data: %v", err)
 			}
 			if walFD.Sync() != nil {
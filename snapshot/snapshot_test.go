@@ -0,0 +1,84 @@
+package snapshot
+
+import (
+	"io"
+	"os"
+	"testing"
+)
+
+func Test_NewFullSnapshotView(t *testing.T) {
+	dbPath := mustWriteToTemp([]byte("test.db contents"))
+	defer os.Remove(dbPath)
+	walPath := mustWriteToTemp([]byte("test.db-wal contents"))
+	defer os.Remove(walPath)
+	walSize := int64(len("test.db-wal contents"))
+
+	released := 0
+	snap, err := NewFullSnapshotView(dbPath, walPath, walSize, func() { released++ })
+	if err != nil {
+		t.Fatalf("unexpected error creating snapshot view: %v", err)
+	}
+
+	// Bytes appended to the WAL after the view was created are not included,
+	// nor are changes made once the files are replaced.
+	fd, err := os.OpenFile(walPath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("failed to open WAL file: %v", err)
+	}
+	if _, err := fd.Write([]byte(" appended")); err != nil {
+		t.Fatalf("failed to append to WAL file: %v", err)
+	}
+	fd.Close()
+	if err := os.Remove(dbPath); err != nil {
+		t.Fatalf("failed to remove database file: %v", err)
+	}
+
+	str, err := snap.OpenStream()
+	if err != nil {
+		t.Fatalf("unexpected error opening stream: %v", err)
+	}
+	defer str.Close()
+	strHdr, _, err := NewStreamHeaderFromReader(str)
+	if err != nil {
+		t.Fatalf("failed to read from stream: %v", err)
+	}
+	fullSnapshot := strHdr.GetFullSnapshot()
+	if fullSnapshot == nil {
+		t.Fatalf("got nil FullSnapshot")
+	}
+	if exp, got := 1, len(fullSnapshot.GetWals()); exp != got {
+		t.Fatalf("unexpected number of WALs, exp %d, got %d", exp, got)
+	}
+	b, err := io.ReadAll(str)
+	if err != nil {
+		t.Fatalf("failed to read from stream: %v", err)
+	}
+	if exp, got := "test.db contentstest.db-wal contents", string(b); exp != got {
+		t.Fatalf("unexpected stream contents, exp %s, got %s", exp, got)
+	}
+
+	snap.Release()
+	snap.Release()
+	if exp, got := 1, released; exp != got {
+		t.Fatalf("release called wrong number of times, exp %d, got %d", exp, got)
+	}
+
+	// No WAL is included if its size is zero.
+	snap, err = NewFullSnapshotView(walPath, walPath, 0, nil)
+	if err != nil {
+		t.Fatalf("unexpected error creating snapshot view: %v", err)
+	}
+	defer snap.Release()
+	str, err = snap.OpenStream()
+	if err != nil {
+		t.Fatalf("unexpected error opening stream: %v", err)
+	}
+	defer str.Close()
+	strHdr, _, err = NewStreamHeaderFromReader(str)
+	if err != nil {
+		t.Fatalf("failed to read from stream: %v", err)
+	}
+	if exp, got := 0, len(strHdr.GetFullSnapshot().GetWals()); exp != got {
+		t.Fatalf("unexpected number of WALs, exp %d, got %d", exp, got)
+	}
+}
@@ -39,11 +39,28 @@ const (
 )
 
 const (
-	persistSize             = "latest_persist_size"
-	persistDuration         = "latest_persist_duration"
-	reap_snapshots_duration = "reap_snapshots_duration"
-	numSnapshotsReaped      = "num_snapshots_reaped"
-	numGenerationsReaped    = "num_generations_reaped"
+	persistSize              = "latest_persist_size"
+	persistDuration          = "latest_persist_duration"
+	reap_snapshots_duration  = "reap_snapshots_duration"
+	numSnapshotsReaped       = "num_snapshots_reaped"
+	numGenerationsReaped     = "num_generations_reaped"
+	numWALChecksumFailures   = "num_wal_checksum_failures"
+	numCreated               = "num_snapshots_created"
+	numCreatedFull           = "num_snapshots_created_full"
+	numCreatedIncremental    = "num_snapshots_created_incremental"
+	numCreateFailures        = "num_snapshot_create_failures"
+	numCancelled             = "num_snapshots_cancelled"
+	createSize               = "latest_create_size"
+	createDuration           = "latest_create_duration"
+	numOpened                = "num_snapshots_opened"
+	numOpenFailures          = "num_snapshot_open_failures"
+	numReapFailures          = "num_reap_failures"
+	numUpgrades              = "num_upgrades"
+	numUpgradeFailures       = "num_upgrade_failures"
+	numSizeReaps             = "num_size_triggered_reaps"
+	numOverMaxSize           = "num_reaps_over_max_size"
+	numChunkChecksumFailures = "num_chunk_checksum_failures"
+	numSHA256Failures        = "num_sha256_failures"
 )
 
 var (
@@ -68,12 +85,41 @@ func ResetStats() {
 	stats.Add(reap_snapshots_duration, 0)
 	stats.Add(numSnapshotsReaped, 0)
 	stats.Add(numGenerationsReaped, 0)
+	stats.Add(numWALChecksumFailures, 0)
+	stats.Add(numCreated, 0)
+	stats.Add(numCreatedFull, 0)
+	stats.Add(numCreatedIncremental, 0)
+	stats.Add(numCreateFailures, 0)
+	stats.Add(numCancelled, 0)
+	stats.Add(createSize, 0)
+	stats.Add(createDuration, 0)
+	stats.Add(numOpened, 0)
+	stats.Add(numOpenFailures, 0)
+	stats.Add(numReapFailures, 0)
+	stats.Add(numUpgrades, 0)
+	stats.Add(numUpgradeFailures, 0)
+	stats.Add(numSizeReaps, 0)
+	stats.Add(numOverMaxSize, 0)
+	stats.Add(numChunkChecksumFailures, 0)
+	stats.Add(numSHA256Failures, 0)
 }
 
 // Meta represents the metadata for a snapshot.
 type Meta struct {
 	raft.SnapshotMeta
 	Full bool
+
+	// BaseSHA256 is the SHA-256 of the uncompressed base SQLite file of the
+	// generation, recorded when a full snapshot is written, and again in the
+	// meta of the oldest remaining snapshot whenever snapshots are reaped.
+	// Only the BaseSHA256 of the oldest snapshot in a generation is checked.
+	// Empty if the snapshot was written by an earlier version.
+	BaseSHA256 string `json:",omitempty"`
+
+	// WALSHA256 is the SHA-256 of the uncompressed WAL file of an incremental
+	// snapshot. Empty for full snapshots, and if the snapshot was written by
+	// an earlier version.
+	WALSHA256 string `json:",omitempty"`
 }
 
 // LockingSink is a wrapper around a SnapshotSink that ensures that the
@@ -86,7 +132,13 @@ type LockingSink struct {
 // Close closes the sink, unlocking the Store for creation of a new sink.
 func (s *LockingSink) Close() error {
 	s.str.sinkMu.Unlock()
-	return s.SnapshotSink.Close()
+	if err := s.SnapshotSink.Close(); err != nil {
+		return err
+	}
+	if s.str.onPersisted != nil {
+		s.str.onPersisted(s.ID())
+	}
+	return nil
 }
 
 // Cancel cancels the sink, unlocking the Store for creation of a new sink.
@@ -103,8 +155,13 @@ type Store struct {
 
 	sinkMu sync.Mutex
 
-	noAutoreap bool
-	logger     *log.Logger
+	noAutoreap           bool
+	compress             bool
+	consolidateThreshold int
+	maxSize              int64
+	chunkSize            int64
+	onPersisted          func(id string)
+	logger               *log.Logger
 }
 
 // NewStore creates a new Store object.
@@ -114,10 +171,12 @@ func NewStore(dir string) (*Store, error) {
 		return nil, err
 	}
 	s := &Store{
-		rootDir:        dir,
-		workDir:        filepath.Join(dir, "scratchpad"),
-		generationsDir: genDir,
-		logger:         log.New(os.Stderr, "[snapshot-store] ", log.LstdFlags),
+		rootDir:              dir,
+		workDir:              filepath.Join(dir, "scratchpad"),
+		generationsDir:       genDir,
+		consolidateThreshold: minSnapshotRetain,
+		chunkSize:            DefaultChunkSize,
+		logger:               log.New(os.Stderr, "[snapshot-store] ", log.LstdFlags),
 	}
 
 	if err := s.check(); err != nil {
@@ -126,6 +185,54 @@ func NewStore(dir string) (*Store, error) {
 	return s, nil
 }
 
+// EnableCompression enables zstd compression of the base SQLite file and
+// WAL files of snapshots subsequently written to the Store. Files already in
+// the Store are compressed, if they are not already, only when they are next
+// rewritten. Compressed and uncompressed files may be mixed in the Store,
+// and are decompressed transparently when read.
+func (s *Store) EnableCompression() {
+	s.compress = true
+}
+
+// SetConsolidateThreshold sets the number of snapshots the current generation
+// may hold before the WAL files of all but the newest are consolidated into
+// the base SQLite file. Consolidating less often means the base SQLite file
+// is rewritten less often, at the cost of the disk space taken by the WAL
+// files, and of replaying them when the newest snapshot is opened. By
+// default the WAL files are consolidated after every snapshot.
+func (s *Store) SetConsolidateThreshold(n int) error {
+	if n < minSnapshotRetain {
+		return ErrRetainCountTooLow
+	}
+	s.consolidateThreshold = n
+	return nil
+}
+
+// SetMaxSize sets the number of bytes the snapshots in the Store may use. If
+// they use more after a snapshot is written, the WAL files of all but the
+// newest snapshots are consolidated, regardless of the consolidation
+// threshold. The base SQLite file, which holds the only full copy of the
+// database, is never removed, so the snapshots may still use more than n
+// bytes. If n is 0, the size is not limited.
+func (s *Store) SetMaxSize(n int64) {
+	s.maxSize = n
+}
+
+// SetChunkSize sets the size of the chunks in which the files of a snapshot
+// are streamed when it is opened, such as when it is sent to a node which
+// needs it. Each chunk is followed by its checksum. If n is 0 the files are
+// streamed unchunked, which nodes which do not support chunking require.
+func (s *Store) SetChunkSize(n int64) {
+	s.chunkSize = n
+}
+
+// SetOnPersisted sets fn to be called with the ID of each snapshot once it
+// has been written to the Store. fn must not block, and must not create a
+// snapshot.
+func (s *Store) SetOnPersisted(fn func(id string)) {
+	s.onPersisted = fn
+}
+
 // Create creates a new Sink object, ready for writing a snapshot. Sinks make certain assumptions about
 // the state of the store, and if those assumptions were changed by another Sink writing to the store
 // it could cause failures. Therefore we only allow 1 Sink to be in existence at a time. This shouldn't
@@ -195,7 +302,14 @@ func (s *Store) List() ([]*raft.SnapshotMeta, error) {
 }
 
 // Open opens the snapshot with the given ID.
-func (s *Store) Open(id string) (*raft.SnapshotMeta, io.ReadCloser, error) {
+func (s *Store) Open(id string) (retMeta *raft.SnapshotMeta, retRC io.ReadCloser, retErr error) {
+	defer func() {
+		if retErr != nil {
+			stats.Add(numOpenFailures, 1)
+		} else {
+			stats.Add(numOpened, 1)
+		}
+	}()
 	generations, err := s.GetGenerations()
 	if err != nil {
 		return nil, nil, err
@@ -218,19 +332,28 @@ func (s *Store) Open(id string) (*raft.SnapshotMeta, io.ReadCloser, error) {
 
 		// Always include the base SQLite file. There may not be a snapshot directory
 		// if it's been checkpointed due to snapshot-reaping.
-		baseSqliteFilePath := filepath.Join(genDir, baseSqliteFile)
+		baseSqliteFilePath := dataFilePath(filepath.Join(genDir, baseSqliteFile))
 		if !fileExists(baseSqliteFilePath) {
 			return nil, nil, ErrSnapshotBaseMissing
 		}
+		if err := checkFileSHA256(baseSqliteFilePath, snapshots[0].BaseSHA256); err != nil {
+			return nil, nil, err
+		}
 		files := []string{baseSqliteFilePath}
 		for _, snap := range snapshots {
 			if !snap.Full {
 				// Only include WAL files for incremental snapshots, since base SQLite database
 				// is always included
-				snapWALFilePath := filepath.Join(genDir, snap.ID, snapWALFile)
+				snapWALFilePath := dataFilePath(filepath.Join(genDir, snap.ID, snapWALFile))
 				if !fileExists(snapWALFilePath) {
 					return nil, nil, fmt.Errorf("WAL file %s does not exist", snapWALFilePath)
 				}
+				if err := checkWALFile(snapWALFilePath, walChecksumPath(snapWALFilePath)); err != nil {
+					return nil, nil, err
+				}
+				if err := checkFileSHA256(snapWALFilePath, snap.WALSHA256); err != nil {
+					return nil, nil, err
+				}
 				files = append(files, snapWALFilePath)
 			}
 			if snap.ID == id {
@@ -247,7 +370,12 @@ func (s *Store) Open(id string) (*raft.SnapshotMeta, io.ReadCloser, error) {
 			}
 		}
 
-		str, err := NewFullStream(files...)
+		var str *Stream
+		if s.chunkSize > 0 {
+			str, err = NewChunkedFullStream(s.chunkSize, files...)
+		} else {
+			str, err = NewFullStream(files...)
+		}
 		if err != nil {
 			return nil, nil, err
 		}
@@ -314,11 +442,15 @@ func (s *Store) Stats() (map[string]interface{}, error) {
 
 	}
 	stats := map[string]interface{}{
-		"root_dir":        s.rootDir,
-		"size":            dirSize,
-		"full_needed":     s.FullNeeded(),
-		"next_generation": ng,
-		"auto_reap":       !s.noAutoreap,
+		"root_dir":              s.rootDir,
+		"size":                  dirSize,
+		"full_needed":           s.FullNeeded(),
+		"next_generation":       ng,
+		"auto_reap":             !s.noAutoreap,
+		"compression":           s.compress,
+		"consolidate_threshold": s.consolidateThreshold,
+		"max_size":              s.maxSize,
+		"chunk_size":            s.chunkSize,
 	}
 
 	snaps, err := s.List()
@@ -351,7 +483,7 @@ func (s *Store) FullNeeded() bool {
 	if err != nil {
 		return false
 	}
-	return !ok || !fileExists(filepath.Join(currGenDir, baseSqliteFile))
+	return !ok || !fileExists(dataFilePath(filepath.Join(currGenDir, baseSqliteFile)))
 }
 
 // GetNextGeneration returns the name of the next generation.
@@ -415,8 +547,15 @@ func (s *Store) GetCurrentGenerationDir() (string, bool, error) {
 	return filepath.Join(s.generationsDir, generations[len(generations)-1]), true, nil
 }
 
-// Reap reaps old generations, and reaps snapshots within the remaining generation.
-func (s *Store) Reap() error {
+// Reap reaps old generations, and reaps snapshots within the remaining generation
+// once it holds more than the consolidation threshold, or once the snapshots
+// use more than the maximum size.
+func (s *Store) Reap() (retErr error) {
+	defer func() {
+		if retErr != nil {
+			stats.Add(numReapFailures, 1)
+		}
+	}()
 	if _, err := s.ReapGenerations(); err != nil {
 		return fmt.Errorf("failed to reap generations during reap: %s", err)
 	}
@@ -425,15 +564,49 @@ func (s *Store) Reap() error {
 	if err != nil {
 		return fmt.Errorf("failed to get current generation directory during reap: %s", err)
 	}
-	if ok {
-		_, err = s.ReapSnapshots(currDir, 2)
-		if err != nil {
+	if !ok {
+		return nil
+	}
+	snapshots, err := s.getSnapshots(currDir)
+	if err != nil {
+		return fmt.Errorf("failed to get snapshots during reap: %s", err)
+	}
+	overSize, err := s.overMaxSize()
+	if err != nil {
+		return fmt.Errorf("failed to get size during reap: %s", err)
+	}
+	if len(snapshots) > s.consolidateThreshold || (overSize && len(snapshots) > minSnapshotRetain) {
+		if len(snapshots) <= s.consolidateThreshold {
+			stats.Add(numSizeReaps, 1)
+			s.logger.Printf("snapshots exceed maximum size of %d bytes, consolidating", s.maxSize)
+		}
+		if _, err = s.ReapSnapshots(currDir, minSnapshotRetain); err != nil {
 			return fmt.Errorf("failed to reap snapshots during reap: %s", err)
 		}
+		if overSize, err = s.overMaxSize(); err != nil {
+			return fmt.Errorf("failed to get size during reap: %s", err)
+		}
+	}
+	if overSize {
+		stats.Add(numOverMaxSize, 1)
+		s.logger.Printf("snapshots still exceed maximum size of %d bytes after reaping", s.maxSize)
 	}
 	return nil
 }
 
+// overMaxSize returns whether the snapshots in the Store use more than the
+// maximum size, if one is set.
+func (s *Store) overMaxSize() (bool, error) {
+	if s.maxSize <= 0 {
+		return false, nil
+	}
+	sz, err := dirSize(s.generationsDir)
+	if err != nil {
+		return false, err
+	}
+	return sz > s.maxSize, nil
+}
+
 // ReapGenerations removes old generations. It returns the number of generations
 // removed, or an error.
 func (s *Store) ReapGenerations() (int, error) {
@@ -492,13 +665,23 @@ func (s *Store) ReapSnapshots(dir string, retain int) (n int, err error) {
 	// We'll then delete each snapshot once we've checkpointed it.
 	sort.Sort(metaSlice(snapshots))
 
+	// WAL files can only be checkpointed into an uncompressed base SQLite file.
 	baseSqliteFilePath := filepath.Join(dir, baseSqliteFile)
+	if err := decompressBase(dir); err != nil {
+		s.logger.Printf("failed to decompress base SQLite file in %s: %s", dir, err)
+		return 0, err
+	}
+	defer func() {
+		if err == nil && s.compress {
+			err = compressBase(dir)
+		}
+	}()
 
 	n = 0
 	for _, snap := range snapshots[0 : len(snapshots)-retain] {
-		snapDirPath := filepath.Join(dir, snap.ID)                       // Path to the snapshot directory
-		walFileInSnapshot := filepath.Join(snapDirPath, snapWALFile)     // Path to the WAL file in the snapshot
-		walToCheckpointFilePath := filepath.Join(dir, baseSqliteWALFile) // Path to the WAL file to checkpoint
+		snapDirPath := filepath.Join(dir, snap.ID)                                 // Path to the snapshot directory
+		walFileInSnapshot := dataFilePath(filepath.Join(snapDirPath, snapWALFile)) // Path to the WAL file in the snapshot
+		walToCheckpointFilePath := filepath.Join(dir, baseSqliteWALFile)           // Path to the WAL file to checkpoint
 
 		// If the snapshot directory doesn't contain a WAL file, then the base SQLite
 		// file is the snapshot state, and there is no checkpointing to do.
@@ -528,6 +711,13 @@ func (s *Store) ReapSnapshots(dir string, retain int) (n int, err error) {
 		n++
 		s.logger.Printf("reaped snapshot %s successfully", snap.ID)
 	}
+
+	// The base SQLite file has changed, and the snapshot which recorded its
+	// SHA-256 may have been reaped.
+	if err := updateBaseSHA256(dir, snapshots[len(snapshots)-retain]); err != nil {
+		s.logger.Printf("failed to update SHA-256 of base SQLite file in %s: %s", dir, err)
+		return n, err
+	}
 	return n, nil
 }
 
@@ -553,7 +743,7 @@ func (s *Store) getSnapshots(dir string) ([]*Meta, error) {
 		}
 
 		// Try to read the meta data
-		meta, err := s.readMeta(filepath.Join(dir, entry.Name()))
+		meta, err := readMeta(filepath.Join(dir, entry.Name()))
 		if err != nil {
 			return nil, fmt.Errorf("failed to read meta for snapshot %s: %s", entry.Name(), err)
 		}
@@ -566,7 +756,7 @@ func (s *Store) getSnapshots(dir string) ([]*Meta, error) {
 }
 
 // readMeta is used to read the meta data in a given snapshot directory.
-func (s *Store) readMeta(dir string) (*Meta, error) {
+func readMeta(dir string) (*Meta, error) {
 	// Open the meta file
 	metaPath := filepath.Join(dir, metaFileName)
 	fh, err := os.Open(metaPath)
@@ -661,10 +851,32 @@ func (s *Store) check() (retError error) {
 
 	// If we have no base file, we shouldn't have any snapshot directories. If we
 	// do it's an inconsistent state which we cannot repair, and needs to be flagged.
-	if !fileExists(baseSqliteFilePath) {
+	if !fileExists(dataFilePath(baseSqliteFilePath)) {
 		return ErrSnapshotBaseMissing
 	}
-	s.logger.Printf("found base SQLite file at %s", baseSqliteFilePath)
+	s.logger.Printf("found base SQLite file at %s", dataFilePath(baseSqliteFilePath))
+
+	// If we have both an uncompressed and a compressed base SQLite file, we were
+	// interrupted while compressing or decompressing it. The uncompressed file
+	// is complete in either case.
+	if fileExists(baseSqliteFilePath) && fileExists(baseSqliteFilePath+compressedSuffix) {
+		if err := os.Remove(baseSqliteFilePath + compressedSuffix); err != nil {
+			return fmt.Errorf("failed to remove compressed base SQLite file: %s", err)
+		}
+		s.logger.Printf("removed compressed copy of base SQLite file")
+	}
+
+	// Check every WAL file in the current generation, so that any torn write
+	// is detected now, and not later when the WAL is replayed.
+	for _, snap := range snapshots {
+		walPath := dataFilePath(filepath.Join(currGenDir, snap.ID, snapWALFile))
+		if snap.Full || !fileExists(walPath) {
+			continue
+		}
+		if err := checkWALFile(walPath, walChecksumPath(walPath)); err != nil {
+			return fmt.Errorf("snapshot %s failed WAL check: %s", snap.ID, err)
+		}
+	}
 
 	// If we have a WAL file in the current generation which ends with the same ID as
 	// the oldest snapshot, then the copy of the WAL from the snapshot and subsequent
@@ -691,6 +903,11 @@ func (s *Store) check() (retError error) {
 	// If we have a base SQLite file, and a WAL file sitting beside it, this implies
 	// that we were interrupted before completing a checkpoint operation, as part of
 	// reaping snapshots. Complete the checkpoint operation now.
+	if fileExists(baseSqliteWALFilePath) {
+		if err := decompressBase(currGenDir); err != nil {
+			return fmt.Errorf("failed to decompress base SQLite file: %s", err)
+		}
+	}
 	if fileExists(baseSqliteFilePath) && fileExists(baseSqliteWALFilePath) {
 		if err := db.ReplayWAL(baseSqliteFilePath, []string{baseSqliteWALFilePath},
 			false); err != nil {
@@ -700,6 +917,19 @@ func (s *Store) check() (retError error) {
 			return fmt.Errorf("failed to remove WAL file %s: %s", baseSqliteWALFilePath, err)
 		}
 		s.logger.Printf("completed checkpoint of WAL file %s", baseSqliteWALFilePath)
+
+		// The checkpoint completed an interrupted reap, so record the SHA-256
+		// of the base SQLite file as the reap would have.
+		snapshots, err = s.getSnapshots(currGenDir)
+		if err != nil {
+			return fmt.Errorf("failed to get snapshots: %s", err)
+		}
+		if len(snapshots) > 0 {
+			sort.Sort(metaSlice(snapshots))
+			if err := updateBaseSHA256(currGenDir, snapshots[0]); err != nil {
+				return fmt.Errorf("failed to update SHA-256 of base SQLite file: %s", err)
+			}
+		}
 	}
 	return nil
 }
@@ -718,14 +948,24 @@ func (s *Store) resetWorkDir() error {
 // to the file at the given path. It does this in stages, so that we can be sure
 // that the copy is complete before deleting the snapshot directory.
 func copyWALFromSnapshot(srcWALPath string, dstWALPath string) error {
-	snapName := filepath.Base(srcWALPath)
+	snapName := filepath.Base(strings.TrimSuffix(srcWALPath, compressedSuffix))
 	snapDirPath := filepath.Dir(srcWALPath)
 	dstWALDir := filepath.Dir(dstWALPath)
 	walFileInSnapshotCopy := walSnapCopyName(dstWALDir, snapName)
-	if err := copyFileSync(srcWALPath, walFileInSnapshotCopy); err != nil {
+	copyFn := copyFileSync
+	if isCompressedPath(srcWALPath) {
+		copyFn = decompressFileSync
+	}
+	if err := copyFn(srcWALPath, walFileInSnapshotCopy); err != nil {
 		return fmt.Errorf("failed to copy WAL file %s from snapshot: %s", srcWALPath, err)
 	}
 
+	// Check the copy before the snapshot directory, and the original WAL
+	// file, are deleted.
+	if err := checkWALFile(walFileInSnapshotCopy, walChecksumPath(srcWALPath)); err != nil {
+		return err
+	}
+
 	// Delete the snapshot directory, since we have what we need now.
 	if err := removeDirSync(snapDirPath); err != nil {
 		return fmt.Errorf("failed to remove incremental snapshot directory %s: %s", snapDirPath, err)
@@ -740,6 +980,38 @@ func copyWALFromSnapshot(srcWALPath string, dstWALPath string) error {
 	return nil
 }
 
+// decompressBase replaces the compressed base SQLite file in dir, if there
+// is one, with the uncompressed file.
+func decompressBase(dir string) error {
+	path := filepath.Join(dir, baseSqliteFile)
+	if fileExists(path) || !fileExists(path+compressedSuffix) {
+		return nil
+	}
+	if err := decompressFileSync(path+compressedSuffix, tmpName(path)); err != nil {
+		return err
+	}
+	if _, err := moveFromTmpSync(tmpName(path)); err != nil {
+		return err
+	}
+	return os.Remove(path + compressedSuffix)
+}
+
+// compressBase replaces the uncompressed base SQLite file in dir, if there
+// is one, with a compressed file.
+func compressBase(dir string) error {
+	path := filepath.Join(dir, baseSqliteFile)
+	if !fileExists(path) {
+		return nil
+	}
+	if err := compressFileSync(path, tmpName(path+compressedSuffix)); err != nil {
+		return err
+	}
+	if _, err := moveFromTmpSync(tmpName(path + compressedSuffix)); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
 // walSnapCopyName returns the path of the file used for the intermediate copy of
 // the WAL file, for a given source snapshot. dstDir is the directory where the
 // copy will be placed, and snapName is the name of the source snapshot.
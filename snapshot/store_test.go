@@ -3,6 +3,7 @@ package snapshot
 import (
 	"bytes"
 	"io"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -134,7 +135,7 @@ func Test_Store_CreateFullThenIncremental(t *testing.T) {
 	if dbInfo == nil {
 		t.Fatal("got nil DB info")
 	}
-	if !compareReaderToFile(crc, "testdata/db-and-wals/backup.db") {
+	if !compareReaderToFile(streamSnap.fileReader(crc, dbInfo.Size), "testdata/db-and-wals/backup.db") {
 		t.Fatalf("database file does not match what is in snapshot")
 	}
 	// should be no more data
@@ -529,12 +530,49 @@ func (c *countingReadCloser) Close() error {
 }
 
 func Test_StoreReaping(t *testing.T) {
+	testStoreReaping(t, false)
+}
+
+func Test_StoreReaping_Compressed(t *testing.T) {
+	testStoreReaping(t, true)
+}
+
+func testStoreReaping(t *testing.T, compress bool) {
 	dir := t.TempDir()
 	str, err := NewStore(dir)
 	if err != nil {
 		t.Fatalf("failed to create snapshot store: %s", err)
 	}
 	str.noAutoreap = true
+	if compress {
+		str.EnableCompression()
+	}
+
+	// checkCompressed checks that the data files in the generation directory
+	// are compressed, or not, as the store is configured.
+	checkCompressed := func(genDir string) {
+		t.Helper()
+		basePath := filepath.Join(genDir, baseSqliteFile)
+		if fileExists(basePath) == compress || fileExists(basePath+compressedSuffix) != compress {
+			t.Fatalf("base SQLite file compression is not %v", compress)
+		}
+		snaps, err := str.getSnapshots(genDir)
+		if err != nil {
+			t.Fatalf("failed to list snapshots: %s", err)
+		}
+		for _, snap := range snaps {
+			if snap.Full {
+				continue
+			}
+			walPath := filepath.Join(genDir, snap.ID, snapWALFile)
+			if fileExists(walPath) == compress || fileExists(walPath+compressedSuffix) != compress {
+				t.Fatalf("WAL file of snapshot %s compression is not %v", snap.ID, compress)
+			}
+			if !fileExists(walChecksumPath(walPath)) {
+				t.Fatalf("WAL checksum file of snapshot %s missing", snap.ID)
+			}
+		}
+	}
 	testConfig := makeTestConfiguration("1", "2")
 
 	// Create a full snapshot.
@@ -602,6 +640,7 @@ func Test_StoreReaping(t *testing.T) {
 	if !snaps[4].Full {
 		t.Fatalf("snapshot %s is incremental", snaps[4].ID)
 	}
+	checkCompressed(generationsDir)
 
 	// Reap just the first snapshot, which is full.
 	n, err := str.ReapSnapshots(generationsDir, 4)
@@ -646,6 +685,12 @@ func Test_StoreReaping(t *testing.T) {
 	if snaps[1].Index != 7 && snaps[1].Term != 3 {
 		t.Fatal("snap 1 is wrong, exp:", snaps[1].Index, snaps[1].Term)
 	}
+	checkCompressed(generationsDir)
+
+	// Check the store can be reopened.
+	if _, err := NewStore(dir); err != nil {
+		t.Fatalf("failed to reopen snapshot store: %s", err)
+	}
 
 	// Open the latest snapshot, write it to disk, and check its contents.
 	_, rc, err := str.Open(snaps[0].ID)
@@ -681,3 +726,213 @@ func Test_StoreReaping(t *testing.T) {
 		t.Fatalf("unexpected results for query exp: %s got: %s", exp, got)
 	}
 }
+
+func Test_StoreConsolidateThreshold(t *testing.T) {
+	dir := t.TempDir()
+	str, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("failed to create snapshot store: %s", err)
+	}
+	if err := str.SetConsolidateThreshold(1); err != ErrRetainCountTooLow {
+		t.Fatalf("expected ErrRetainCountTooLow, got %v", err)
+	}
+	if err := str.SetConsolidateThreshold(4); err != nil {
+		t.Fatalf("failed to set consolidation threshold: %s", err)
+	}
+	testConfig := makeTestConfiguration("1", "2")
+
+	createSnapshot := func(index, term uint64, snapshot *Snapshot) {
+		sink, err := str.Create(1, index, term, testConfig, 4, nil)
+		if err != nil {
+			t.Fatalf("failed to create snapshot sink: %s", err)
+		}
+		stream, err := snapshot.OpenStream()
+		if err != nil {
+			t.Fatalf("failed to open snapshot stream: %s", err)
+		}
+		if _, err := io.Copy(sink, stream); err != nil {
+			t.Fatalf("failed to write snapshot: %s", err)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatalf("failed to close snapshot sink: %s", err)
+		}
+	}
+	numSnapshots := func() int {
+		genDir, ok, err := str.GetCurrentGenerationDir()
+		if err != nil || !ok {
+			t.Fatalf("failed to get current generation dir: %v", err)
+		}
+		snaps, err := str.getSnapshots(genDir)
+		if err != nil {
+			t.Fatalf("failed to list snapshots: %s", err)
+		}
+		return len(snaps)
+	}
+
+	createSnapshot(1, 1, NewFullSnapshot("testdata/db-and-wals/backup.db"))
+	createSnapshot(3, 2, NewWALSnapshot(mustReadFile("testdata/db-and-wals/wal-00")))
+	createSnapshot(5, 3, NewWALSnapshot(mustReadFile("testdata/db-and-wals/wal-01")))
+	createSnapshot(7, 4, NewWALSnapshot(mustReadFile("testdata/db-and-wals/wal-02")))
+	if exp, got := 4, numSnapshots(); exp != got {
+		t.Fatalf("expected %d snapshots before consolidation, got %d", exp, got)
+	}
+
+	createSnapshot(9, 5, NewWALSnapshot(mustReadFile("testdata/db-and-wals/wal-03")))
+	if exp, got := 2, numSnapshots(); exp != got {
+		t.Fatalf("expected %d snapshots after consolidation, got %d", exp, got)
+	}
+
+	// The newest snapshot must hold all the changes.
+	snaps, err := str.List()
+	if err != nil {
+		t.Fatalf("failed to list snapshots: %s", err)
+	}
+	path, err := str.Restore(snaps[0].ID, t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to restore snapshot: %s", err)
+	}
+	checkDB, err := db.Open(path, false, true)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err)
+	}
+	defer checkDB.Close()
+	rows, err := checkDB.QueryStringStmt("SELECT COUNT(*) FROM foo")
+	if err != nil {
+		t.Fatalf("failed to query database: %s", err)
+	}
+	if exp, got := `[{"columns":["COUNT(*)"],"types":["integer"],"values":[[4]]}]`, asJSON(rows); exp != got {
+		t.Fatalf("unexpected results for query exp: %s got: %s", exp, got)
+	}
+}
+
+func Test_StoreExpvarStats(t *testing.T) {
+	ResetStats()
+	str, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create snapshot store: %s", err)
+	}
+	testConfig := makeTestConfiguration("1", "2")
+
+	for i, snapshot := range []*Snapshot{
+		NewFullSnapshot("testdata/db-and-wals/backup.db"),
+		NewWALSnapshot(mustReadFile("testdata/db-and-wals/wal-00")),
+	} {
+		sink, err := str.Create(1, uint64(i+1), 1, testConfig, 4, nil)
+		if err != nil {
+			t.Fatalf("failed to create snapshot sink: %s", err)
+		}
+		stream, err := snapshot.OpenStream()
+		if err != nil {
+			t.Fatalf("failed to open snapshot stream: %s", err)
+		}
+		if _, err := io.Copy(sink, stream); err != nil {
+			t.Fatalf("failed to write snapshot: %s", err)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatalf("failed to close snapshot sink: %s", err)
+		}
+	}
+	sink, err := str.Create(1, 3, 1, testConfig, 4, nil)
+	if err != nil {
+		t.Fatalf("failed to create snapshot sink: %s", err)
+	}
+	if err := sink.Cancel(); err != nil {
+		t.Fatalf("failed to cancel snapshot sink: %s", err)
+	}
+
+	snaps, err := str.List()
+	if err != nil {
+		t.Fatalf("failed to list snapshots: %s", err)
+	}
+	_, rc, err := str.Open(snaps[0].ID)
+	if err != nil {
+		t.Fatalf("failed to open snapshot: %s", err)
+	}
+	rc.Close()
+	if _, _, err := str.Open("non-existent"); err == nil {
+		t.Fatalf("expected error opening non-existent snapshot")
+	}
+
+	for name, exp := range map[string]string{
+		numCreated:            "2",
+		numCreatedFull:        "1",
+		numCreatedIncremental: "1",
+		numCreateFailures:     "0",
+		numCancelled:          "1",
+		numOpened:             "1",
+		numOpenFailures:       "1",
+		numReapFailures:       "0",
+	} {
+		if got := stats.Get(name).String(); exp != got {
+			t.Fatalf("expected %s to be %s, got %s", name, exp, got)
+		}
+	}
+	if stats.Get(createSize).String() == "0" {
+		t.Fatalf("expected non-zero latest create size")
+	}
+}
+
+func Test_StoreMaxSize(t *testing.T) {
+	ResetStats()
+	str, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create snapshot store: %s", err)
+	}
+	if err := str.SetConsolidateThreshold(10); err != nil {
+		t.Fatalf("failed to set consolidation threshold: %s", err)
+	}
+	testConfig := makeTestConfiguration("1", "2")
+
+	createSnapshot := func(index, term uint64, snapshot *Snapshot) {
+		sink, err := str.Create(1, index, term, testConfig, 4, nil)
+		if err != nil {
+			t.Fatalf("failed to create snapshot sink: %s", err)
+		}
+		stream, err := snapshot.OpenStream()
+		if err != nil {
+			t.Fatalf("failed to open snapshot stream: %s", err)
+		}
+		if _, err := io.Copy(sink, stream); err != nil {
+			t.Fatalf("failed to write snapshot: %s", err)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatalf("failed to close snapshot sink: %s", err)
+		}
+	}
+	numSnapshots := func() int {
+		genDir, ok, err := str.GetCurrentGenerationDir()
+		if err != nil || !ok {
+			t.Fatalf("failed to get current generation dir: %v", err)
+		}
+		snaps, err := str.getSnapshots(genDir)
+		if err != nil {
+			t.Fatalf("failed to list snapshots: %s", err)
+		}
+		return len(snaps)
+	}
+
+	// Without a maximum size, snapshots are held up to the threshold.
+	createSnapshot(1, 1, NewFullSnapshot("testdata/db-and-wals/backup.db"))
+	createSnapshot(3, 2, NewWALSnapshot(mustReadFile("testdata/db-and-wals/wal-00")))
+	createSnapshot(5, 3, NewWALSnapshot(mustReadFile("testdata/db-and-wals/wal-01")))
+	if exp, got := 3, numSnapshots(); exp != got {
+		t.Fatalf("expected %d snapshots, got %d", exp, got)
+	}
+
+	// Once over the maximum size, snapshots are consolidated, but the base
+	// SQLite file always remains.
+	str.SetMaxSize(1)
+	createSnapshot(7, 4, NewWALSnapshot(mustReadFile("testdata/db-and-wals/wal-02")))
+	if exp, got := 2, numSnapshots(); exp != got {
+		t.Fatalf("expected %d snapshots, got %d", exp, got)
+	}
+	if str.FullNeeded() {
+		t.Fatalf("base SQLite file removed by reaping")
+	}
+	if exp, got := "1", stats.Get(numSizeReaps).String(); exp != got {
+		t.Fatalf("expected %s size-triggered reaps, got %s", exp, got)
+	}
+	if exp, got := "1", stats.Get(numOverMaxSize).String(); exp != got {
+		t.Fatalf("expected %s reaps over maximum size, got %s", exp, got)
+	}
+}
@@ -14,6 +14,11 @@ import (
 const (
 	strHeaderLenSize = 8
 	streamVersion    = 1
+
+	// chunkedStreamVersion is the version of streams whose file data is
+	// chunked. Nodes which do not support chunking refuse such streams,
+	// rather than misreading them.
+	chunkedStreamVersion = 2
 )
 
 // NewStreamHeader creates a new StreamHeader.
@@ -47,12 +52,16 @@ func NewStreamHeaderFromReader(r io.Reader) (*StreamHeader, int64, error) {
 	if err != nil {
 		return nil, 0, fmt.Errorf("error unmarshaling FSM snapshot: %v", err)
 	}
-	if strHdr.GetVersion() != streamVersion {
+	if !supportedStreamVersion(strHdr.GetVersion()) {
 		return nil, 0, fmt.Errorf("unsupported snapshot version %d", strHdr.GetVersion())
 	}
 	return strHdr, totalSizeRead, nil
 }
 
+func supportedStreamVersion(v int32) bool {
+	return v == streamVersion || v == chunkedStreamVersion
+}
+
 // FileSize returns the total size of the files in the snapshot.
 func (s *StreamHeader) FileSize() int64 {
 	if fs := s.GetFullSnapshot(); fs != nil {
@@ -78,11 +87,28 @@ type Stream struct {
 // NewIncrementalStream creates a new stream from a byte slice, presumably
 // representing WAL data.
 func NewIncrementalStream(data []byte) (*Stream, error) {
+	return newIncrementalStream(&IncrementalSnapshot{Data: data})
+}
+
+// NewChecksummedIncrementalStream creates a new stream from WAL data, which
+// also carries the checksum of every frame in the WAL. This allows the
+// receiver to detect any corruption of the WAL data. An error is returned
+// if data is not a well-formed WAL.
+func NewChecksummedIncrementalStream(data []byte) (*Stream, error) {
+	sums, err := WALFrameChecksums(data)
+	if err != nil {
+		return nil, err
+	}
+	return newIncrementalStream(&IncrementalSnapshot{
+		Data:           data,
+		FrameChecksums: sums,
+	})
+}
+
+func newIncrementalStream(incSnap *IncrementalSnapshot) (*Stream, error) {
 	strHdr := NewStreamHeader()
 	strHdr.Payload = &StreamHeader_IncrementalSnapshot{
-		IncrementalSnapshot: &IncrementalSnapshot{
-			Data: data,
-		},
+		IncrementalSnapshot: incSnap,
 	}
 	strHdrPb, err := proto.Marshal(strHdr)
 	if err != nil {
@@ -99,67 +125,102 @@ func NewIncrementalStream(data []byte) (*Stream, error) {
 }
 
 // NewFullStream creates a new stream from a SQLite file and 0 or more
-// WAL files.
+// WAL files. Any file may be compressed, in which case its decompressed
+// data is streamed.
 func NewFullStream(files ...string) (*Stream, error) {
+	return newFullStream(0, files...)
+}
+
+// NewChunkedFullStream creates a new stream from a SQLite file and 0 or more
+// WAL files, like NewFullStream. The data of each file is streamed in chunks
+// of chunkSize bytes, each followed by its checksum, so that the receiver
+// can check the data as it arrives, without needing all of it in memory.
+func NewChunkedFullStream(chunkSize int64, files ...string) (*Stream, error) {
+	if chunkSize <= 0 {
+		return nil, errors.New("chunk size must be positive")
+	}
+	return newFullStream(chunkSize, files...)
+}
+
+func newFullStream(chunkSize int64, files ...string) (*Stream, error) {
 	if len(files) == 0 {
 		return nil, errors.New("no files provided")
 	}
 
-	var totalFileSize int64
-	// First file must be the SQLite database file.
-	fi, err := os.Stat(files[0])
-	if err != nil {
-		return nil, err
-	}
-	dbDataInfo := &FullSnapshot_DataInfo{
-		Size: fi.Size(),
+	var readClosers []io.ReadCloser
+	closeAll := func() {
+		for _, rc := range readClosers {
+			rc.Close() // Ignore the error during cleanup
+		}
 	}
-	totalFileSize += fi.Size()
-
-	// Rest, if any, are WAL files.
-	walDataInfos := make([]*FullSnapshot_DataInfo, len(files)-1)
-	for i := 1; i < len(files); i++ {
-		fi, err := os.Stat(files[i])
+	sizes := make([]int64, len(files))
+	for i, file := range files {
+		rc, size, err := openDataFile(file)
 		if err != nil {
+			closeAll()
 			return nil, err
 		}
+		readClosers = append(readClosers, rc)
+		sizes[i] = size
+	}
+	return newFullStreamFromReaders(chunkSize, readClosers, sizes)
+}
+
+// newFullStreamFromReaders creates a new stream from the data of a SQLite
+// file and 0 or more WAL files, read from readClosers, which are closed
+// when the stream is closed. sizes are the sizes of the data.
+func newFullStreamFromReaders(chunkSize int64, readClosers []io.ReadCloser, sizes []int64) (*Stream, error) {
+	closeAll := func() {
+		for _, rc := range readClosers {
+			rc.Close() // Ignore the error during cleanup
+		}
+	}
+	if chunkSize > 0 {
+		for i := range readClosers {
+			readClosers[i] = newChunkingReader(readClosers[i], chunkSize)
+		}
+	}
+
+	// First file must be the SQLite database file. Rest, if any, are WAL files.
+	dbDataInfo := &FullSnapshot_DataInfo{
+		Size: sizes[0],
+	}
+	walDataInfos := make([]*FullSnapshot_DataInfo, len(sizes)-1)
+	for i := 1; i < len(sizes); i++ {
 		walDataInfos[i-1] = &FullSnapshot_DataInfo{
-			Size: fi.Size(),
+			Size: sizes[i],
 		}
-		totalFileSize += fi.Size()
 	}
 	strHdr := NewStreamHeader()
 	strHdr.Payload = &StreamHeader_FullSnapshot{
 		FullSnapshot: &FullSnapshot{
-			Db:   dbDataInfo,
-			Wals: walDataInfos,
+			Db:        dbDataInfo,
+			Wals:      walDataInfos,
+			ChunkSize: chunkSize,
 		},
 	}
+	streamedSize := strHdr.FileSize()
+	if chunkSize > 0 {
+		strHdr.Version = chunkedStreamVersion
+		streamedSize = 0
+		for _, sz := range sizes {
+			streamedSize += chunkedSize(sz, chunkSize)
+		}
+	}
 
 	strHdrPb, err := proto.Marshal(strHdr)
 	if err != nil {
+		closeAll()
 		return nil, err
 	}
 	buf := make([]byte, strHeaderLenSize)
 	binary.LittleEndian.PutUint64(buf, uint64(len(strHdrPb)))
 	buf = append(buf, strHdrPb...)
 
-	var readClosers []io.ReadCloser
-	readClosers = append(readClosers, newRCBuffer(buf))
-	for _, file := range files {
-		fd, err := os.Open(file)
-		if err != nil {
-			for _, rc := range readClosers {
-				rc.Close() // Ignore the error during cleanup
-			}
-			return nil, err
-		}
-		readClosers = append(readClosers, fd)
-	}
 	return &Stream{
 		headerLen:     int64(len(strHdrPb)),
-		readClosers:   readClosers,
-		totalFileSize: strHdr.FileSize(),
+		readClosers:   append([]io.ReadCloser{newRCBuffer(buf)}, readClosers...),
+		totalFileSize: streamedSize,
 	}, nil
 }
 
@@ -217,7 +278,7 @@ func FilesFromStream(r io.Reader) (string, []string, error) {
 	}
 
 	sqliteFd, err := os.CreateTemp("", "stream-db.sqlite3")
-	if _, err := io.CopyN(sqliteFd, r, dbInfo.Size); err != nil {
+	if _, err := io.CopyN(sqliteFd, fullSnap.fileReader(r, dbInfo.Size), dbInfo.Size); err != nil {
 		return "", nil, fmt.Errorf("error writing SQLite file data: %v", err)
 	}
 	if sqliteFd.Close(); err != nil {
@@ -230,7 +291,7 @@ func FilesFromStream(r io.Reader) (string, []string, error) {
 		if err != nil {
 			return "", nil, fmt.Errorf("error creating WAL file: %v", err)
 		}
-		if _, err := io.CopyN(tmpFd, r, di.Size); err != nil {
+		if _, err := io.CopyN(tmpFd, fullSnap.fileReader(r, di.Size), di.Size); err != nil {
 			return "", nil, fmt.Errorf("error writing WAL file data: %v", err)
 		}
 		if err := tmpFd.Close(); err != nil {
@@ -2,7 +2,7 @@
 // versions:
 // 	protoc-gen-go v1.31.0
 // 	protoc        v3.6.1
-// source: snapshot/stream_header.pb
+// source: stream_header.proto
 
 package snapshot
 
@@ -25,13 +25,14 @@ type IncrementalSnapshot struct {
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Data []byte `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+	Data           []byte   `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+	FrameChecksums []uint32 `protobuf:"varint,2,rep,packed,name=frame_checksums,json=frameChecksums,proto3" json:"frame_checksums,omitempty"`
 }
 
 func (x *IncrementalSnapshot) Reset() {
 	*x = IncrementalSnapshot{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_snapshot_stream_header_pb_msgTypes[0]
+		mi := &file_stream_header_proto_msgTypes[0]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -44,7 +45,7 @@ func (x *IncrementalSnapshot) String() string {
 func (*IncrementalSnapshot) ProtoMessage() {}
 
 func (x *IncrementalSnapshot) ProtoReflect() protoreflect.Message {
-	mi := &file_snapshot_stream_header_pb_msgTypes[0]
+	mi := &file_stream_header_proto_msgTypes[0]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -57,7 +58,7 @@ func (x *IncrementalSnapshot) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use IncrementalSnapshot.ProtoReflect.Descriptor instead.
 func (*IncrementalSnapshot) Descriptor() ([]byte, []int) {
-	return file_snapshot_stream_header_pb_rawDescGZIP(), []int{0}
+	return file_stream_header_proto_rawDescGZIP(), []int{0}
 }
 
 func (x *IncrementalSnapshot) GetData() []byte {
@@ -67,19 +68,27 @@ func (x *IncrementalSnapshot) GetData() []byte {
 	return nil
 }
 
+func (x *IncrementalSnapshot) GetFrameChecksums() []uint32 {
+	if x != nil {
+		return x.FrameChecksums
+	}
+	return nil
+}
+
 type FullSnapshot struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Db   *FullSnapshot_DataInfo   `protobuf:"bytes,3,opt,name=db,proto3" json:"db,omitempty"`
-	Wals []*FullSnapshot_DataInfo `protobuf:"bytes,4,rep,name=wals,proto3" json:"wals,omitempty"`
+	Db        *FullSnapshot_DataInfo   `protobuf:"bytes,3,opt,name=db,proto3" json:"db,omitempty"`
+	Wals      []*FullSnapshot_DataInfo `protobuf:"bytes,4,rep,name=wals,proto3" json:"wals,omitempty"`
+	ChunkSize int64                    `protobuf:"varint,5,opt,name=chunk_size,json=chunkSize,proto3" json:"chunk_size,omitempty"`
 }
 
 func (x *FullSnapshot) Reset() {
 	*x = FullSnapshot{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_snapshot_stream_header_pb_msgTypes[1]
+		mi := &file_stream_header_proto_msgTypes[1]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -92,7 +101,7 @@ func (x *FullSnapshot) String() string {
 func (*FullSnapshot) ProtoMessage() {}
 
 func (x *FullSnapshot) ProtoReflect() protoreflect.Message {
-	mi := &file_snapshot_stream_header_pb_msgTypes[1]
+	mi := &file_stream_header_proto_msgTypes[1]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -105,7 +114,7 @@ func (x *FullSnapshot) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use FullSnapshot.ProtoReflect.Descriptor instead.
 func (*FullSnapshot) Descriptor() ([]byte, []int) {
-	return file_snapshot_stream_header_pb_rawDescGZIP(), []int{1}
+	return file_stream_header_proto_rawDescGZIP(), []int{1}
 }
 
 func (x *FullSnapshot) GetDb() *FullSnapshot_DataInfo {
@@ -122,6 +131,13 @@ func (x *FullSnapshot) GetWals() []*FullSnapshot_DataInfo {
 	return nil
 }
 
+func (x *FullSnapshot) GetChunkSize() int64 {
+	if x != nil {
+		return x.ChunkSize
+	}
+	return 0
+}
+
 type StreamHeader struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -138,7 +154,7 @@ type StreamHeader struct {
 func (x *StreamHeader) Reset() {
 	*x = StreamHeader{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_snapshot_stream_header_pb_msgTypes[2]
+		mi := &file_stream_header_proto_msgTypes[2]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -151,7 +167,7 @@ func (x *StreamHeader) String() string {
 func (*StreamHeader) ProtoMessage() {}
 
 func (x *StreamHeader) ProtoReflect() protoreflect.Message {
-	mi := &file_snapshot_stream_header_pb_msgTypes[2]
+	mi := &file_stream_header_proto_msgTypes[2]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -164,7 +180,7 @@ func (x *StreamHeader) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use StreamHeader.ProtoReflect.Descriptor instead.
 func (*StreamHeader) Descriptor() ([]byte, []int) {
-	return file_snapshot_stream_header_pb_rawDescGZIP(), []int{2}
+	return file_stream_header_proto_rawDescGZIP(), []int{2}
 }
 
 func (x *StreamHeader) GetVersion() int32 {
@@ -222,7 +238,7 @@ type FullSnapshot_DataInfo struct {
 func (x *FullSnapshot_DataInfo) Reset() {
 	*x = FullSnapshot_DataInfo{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_snapshot_stream_header_pb_msgTypes[3]
+		mi := &file_stream_header_proto_msgTypes[3]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -235,7 +251,7 @@ func (x *FullSnapshot_DataInfo) String() string {
 func (*FullSnapshot_DataInfo) ProtoMessage() {}
 
 func (x *FullSnapshot_DataInfo) ProtoReflect() protoreflect.Message {
-	mi := &file_snapshot_stream_header_pb_msgTypes[3]
+	mi := &file_stream_header_proto_msgTypes[3]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -248,7 +264,7 @@ func (x *FullSnapshot_DataInfo) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use FullSnapshot_DataInfo.ProtoReflect.Descriptor instead.
 func (*FullSnapshot_DataInfo) Descriptor() ([]byte, []int) {
-	return file_snapshot_stream_header_pb_rawDescGZIP(), []int{1, 0}
+	return file_stream_header_proto_rawDescGZIP(), []int{1, 0}
 }
 
 func (x *FullSnapshot_DataInfo) GetSize() int64 {
@@ -258,61 +274,65 @@ func (x *FullSnapshot_DataInfo) GetSize() int64 {
 	return 0
 }
 
-var File_snapshot_stream_header_pb protoreflect.FileDescriptor
+var File_stream_header_proto protoreflect.FileDescriptor
 
-var file_snapshot_stream_header_pb_rawDesc = []byte{
-	0x0a, 0x19, 0x73, 0x6e, 0x61, 0x70, 0x73, 0x68, 0x6f, 0x74, 0x2f, 0x73, 0x74, 0x72, 0x65, 0x61,
-	0x6d, 0x5f, 0x68, 0x65, 0x61, 0x64, 0x65, 0x72, 0x2e, 0x70, 0x62, 0x12, 0x08, 0x73, 0x74, 0x72,
-	0x65, 0x61, 0x6d, 0x65, 0x72, 0x22, 0x29, 0x0a, 0x13, 0x49, 0x6e, 0x63, 0x72, 0x65, 0x6d, 0x65,
-	0x6e, 0x74, 0x61, 0x6c, 0x53, 0x6e, 0x61, 0x70, 0x73, 0x68, 0x6f, 0x74, 0x12, 0x12, 0x0a, 0x04,
-	0x64, 0x61, 0x74, 0x61, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61,
-	0x22, 0x94, 0x01, 0x0a, 0x0c, 0x46, 0x75, 0x6c, 0x6c, 0x53, 0x6e, 0x61, 0x70, 0x73, 0x68, 0x6f,
-	0x74, 0x12, 0x2f, 0x0a, 0x02, 0x64, 0x62, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1f, 0x2e,
-	0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x65, 0x72, 0x2e, 0x46, 0x75, 0x6c, 0x6c, 0x53, 0x6e, 0x61,
-	0x70, 0x73, 0x68, 0x6f, 0x74, 0x2e, 0x44, 0x61, 0x74, 0x61, 0x49, 0x6e, 0x66, 0x6f, 0x52, 0x02,
-	0x64, 0x62, 0x12, 0x33, 0x0a, 0x04, 0x77, 0x61, 0x6c, 0x73, 0x18, 0x04, 0x20, 0x03, 0x28, 0x0b,
+var file_stream_header_proto_rawDesc = []byte{
+	0x0a, 0x13, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x5f, 0x68, 0x65, 0x61, 0x64, 0x65, 0x72, 0x2e,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x08, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x65, 0x72, 0x22,
+	0x52, 0x0a, 0x13, 0x49, 0x6e, 0x63, 0x72, 0x65, 0x6d, 0x65, 0x6e, 0x74, 0x61, 0x6c, 0x53, 0x6e,
+	0x61, 0x70, 0x73, 0x68, 0x6f, 0x74, 0x12, 0x12, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x0c, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x12, 0x27, 0x0a, 0x0f, 0x66, 0x72,
+	0x61, 0x6d, 0x65, 0x5f, 0x63, 0x68, 0x65, 0x63, 0x6b, 0x73, 0x75, 0x6d, 0x73, 0x18, 0x02, 0x20,
+	0x03, 0x28, 0x0d, 0x52, 0x0e, 0x66, 0x72, 0x61, 0x6d, 0x65, 0x43, 0x68, 0x65, 0x63, 0x6b, 0x73,
+	0x75, 0x6d, 0x73, 0x22, 0xb3, 0x01, 0x0a, 0x0c, 0x46, 0x75, 0x6c, 0x6c, 0x53, 0x6e, 0x61, 0x70,
+	0x73, 0x68, 0x6f, 0x74, 0x12, 0x2f, 0x0a, 0x02, 0x64, 0x62, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b,
 	0x32, 0x1f, 0x2e, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x65, 0x72, 0x2e, 0x46, 0x75, 0x6c, 0x6c,
 	0x53, 0x6e, 0x61, 0x70, 0x73, 0x68, 0x6f, 0x74, 0x2e, 0x44, 0x61, 0x74, 0x61, 0x49, 0x6e, 0x66,
-	0x6f, 0x52, 0x04, 0x77, 0x61, 0x6c, 0x73, 0x1a, 0x1e, 0x0a, 0x08, 0x44, 0x61, 0x74, 0x61, 0x49,
-	0x6e, 0x66, 0x6f, 0x12, 0x12, 0x0a, 0x04, 0x73, 0x69, 0x7a, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28,
-	0x03, 0x52, 0x04, 0x73, 0x69, 0x7a, 0x65, 0x22, 0xc6, 0x01, 0x0a, 0x0c, 0x53, 0x74, 0x72, 0x65,
-	0x61, 0x6d, 0x48, 0x65, 0x61, 0x64, 0x65, 0x72, 0x12, 0x18, 0x0a, 0x07, 0x76, 0x65, 0x72, 0x73,
-	0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x05, 0x52, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69,
-	0x6f, 0x6e, 0x12, 0x52, 0x0a, 0x14, 0x69, 0x6e, 0x63, 0x72, 0x65, 0x6d, 0x65, 0x6e, 0x74, 0x61,
-	0x6c, 0x5f, 0x73, 0x6e, 0x61, 0x70, 0x73, 0x68, 0x6f, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b,
-	0x32, 0x1d, 0x2e, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x65, 0x72, 0x2e, 0x49, 0x6e, 0x63, 0x72,
-	0x65, 0x6d, 0x65, 0x6e, 0x74, 0x61, 0x6c, 0x53, 0x6e, 0x61, 0x70, 0x73, 0x68, 0x6f, 0x74, 0x48,
-	0x00, 0x52, 0x13, 0x69, 0x6e, 0x63, 0x72, 0x65, 0x6d, 0x65, 0x6e, 0x74, 0x61, 0x6c, 0x53, 0x6e,
-	0x61, 0x70, 0x73, 0x68, 0x6f, 0x74, 0x12, 0x3d, 0x0a, 0x0d, 0x66, 0x75, 0x6c, 0x6c, 0x5f, 0x73,
-	0x6e, 0x61, 0x70, 0x73, 0x68, 0x6f, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x16, 0x2e,
-	0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x65, 0x72, 0x2e, 0x46, 0x75, 0x6c, 0x6c, 0x53, 0x6e, 0x61,
-	0x70, 0x73, 0x68, 0x6f, 0x74, 0x48, 0x00, 0x52, 0x0c, 0x66, 0x75, 0x6c, 0x6c, 0x53, 0x6e, 0x61,
-	0x70, 0x73, 0x68, 0x6f, 0x74, 0x42, 0x09, 0x0a, 0x07, 0x70, 0x61, 0x79, 0x6c, 0x6f, 0x61, 0x64,
-	0x42, 0x23, 0x5a, 0x21, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x72,
-	0x71, 0x6c, 0x69, 0x74, 0x65, 0x2f, 0x72, 0x71, 0x6c, 0x69, 0x74, 0x65, 0x2f, 0x73, 0x6e, 0x61,
-	0x70, 0x73, 0x68, 0x6f, 0x74, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x6f, 0x52, 0x02, 0x64, 0x62, 0x12, 0x33, 0x0a, 0x04, 0x77, 0x61, 0x6c, 0x73, 0x18, 0x04, 0x20,
+	0x03, 0x28, 0x0b, 0x32, 0x1f, 0x2e, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x65, 0x72, 0x2e, 0x46,
+	0x75, 0x6c, 0x6c, 0x53, 0x6e, 0x61, 0x70, 0x73, 0x68, 0x6f, 0x74, 0x2e, 0x44, 0x61, 0x74, 0x61,
+	0x49, 0x6e, 0x66, 0x6f, 0x52, 0x04, 0x77, 0x61, 0x6c, 0x73, 0x12, 0x1d, 0x0a, 0x0a, 0x63, 0x68,
+	0x75, 0x6e, 0x6b, 0x5f, 0x73, 0x69, 0x7a, 0x65, 0x18, 0x05, 0x20, 0x01, 0x28, 0x03, 0x52, 0x09,
+	0x63, 0x68, 0x75, 0x6e, 0x6b, 0x53, 0x69, 0x7a, 0x65, 0x1a, 0x1e, 0x0a, 0x08, 0x44, 0x61, 0x74,
+	0x61, 0x49, 0x6e, 0x66, 0x6f, 0x12, 0x12, 0x0a, 0x04, 0x73, 0x69, 0x7a, 0x65, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x03, 0x52, 0x04, 0x73, 0x69, 0x7a, 0x65, 0x22, 0xc6, 0x01, 0x0a, 0x0c, 0x53, 0x74,
+	0x72, 0x65, 0x61, 0x6d, 0x48, 0x65, 0x61, 0x64, 0x65, 0x72, 0x12, 0x18, 0x0a, 0x07, 0x76, 0x65,
+	0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x05, 0x52, 0x07, 0x76, 0x65, 0x72,
+	0x73, 0x69, 0x6f, 0x6e, 0x12, 0x52, 0x0a, 0x14, 0x69, 0x6e, 0x63, 0x72, 0x65, 0x6d, 0x65, 0x6e,
+	0x74, 0x61, 0x6c, 0x5f, 0x73, 0x6e, 0x61, 0x70, 0x73, 0x68, 0x6f, 0x74, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x0b, 0x32, 0x1d, 0x2e, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x65, 0x72, 0x2e, 0x49, 0x6e,
+	0x63, 0x72, 0x65, 0x6d, 0x65, 0x6e, 0x74, 0x61, 0x6c, 0x53, 0x6e, 0x61, 0x70, 0x73, 0x68, 0x6f,
+	0x74, 0x48, 0x00, 0x52, 0x13, 0x69, 0x6e, 0x63, 0x72, 0x65, 0x6d, 0x65, 0x6e, 0x74, 0x61, 0x6c,
+	0x53, 0x6e, 0x61, 0x70, 0x73, 0x68, 0x6f, 0x74, 0x12, 0x3d, 0x0a, 0x0d, 0x66, 0x75, 0x6c, 0x6c,
+	0x5f, 0x73, 0x6e, 0x61, 0x70, 0x73, 0x68, 0x6f, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32,
+	0x16, 0x2e, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x65, 0x72, 0x2e, 0x46, 0x75, 0x6c, 0x6c, 0x53,
+	0x6e, 0x61, 0x70, 0x73, 0x68, 0x6f, 0x74, 0x48, 0x00, 0x52, 0x0c, 0x66, 0x75, 0x6c, 0x6c, 0x53,
+	0x6e, 0x61, 0x70, 0x73, 0x68, 0x6f, 0x74, 0x42, 0x09, 0x0a, 0x07, 0x70, 0x61, 0x79, 0x6c, 0x6f,
+	0x61, 0x64, 0x42, 0x23, 0x5a, 0x21, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d,
+	0x2f, 0x72, 0x71, 0x6c, 0x69, 0x74, 0x65, 0x2f, 0x72, 0x71, 0x6c, 0x69, 0x74, 0x65, 0x2f, 0x73,
+	0x6e, 0x61, 0x70, 0x73, 0x68, 0x6f, 0x74, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
-	file_snapshot_stream_header_pb_rawDescOnce sync.Once
-	file_snapshot_stream_header_pb_rawDescData = file_snapshot_stream_header_pb_rawDesc
+	file_stream_header_proto_rawDescOnce sync.Once
+	file_stream_header_proto_rawDescData = file_stream_header_proto_rawDesc
 )
 
-func file_snapshot_stream_header_pb_rawDescGZIP() []byte {
-	file_snapshot_stream_header_pb_rawDescOnce.Do(func() {
-		file_snapshot_stream_header_pb_rawDescData = protoimpl.X.CompressGZIP(file_snapshot_stream_header_pb_rawDescData)
+func file_stream_header_proto_rawDescGZIP() []byte {
+	file_stream_header_proto_rawDescOnce.Do(func() {
+		file_stream_header_proto_rawDescData = protoimpl.X.CompressGZIP(file_stream_header_proto_rawDescData)
 	})
-	return file_snapshot_stream_header_pb_rawDescData
+	return file_stream_header_proto_rawDescData
 }
 
-var file_snapshot_stream_header_pb_msgTypes = make([]protoimpl.MessageInfo, 4)
-var file_snapshot_stream_header_pb_goTypes = []interface{}{
+var file_stream_header_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_stream_header_proto_goTypes = []interface{}{
 	(*IncrementalSnapshot)(nil),   // 0: streamer.IncrementalSnapshot
 	(*FullSnapshot)(nil),          // 1: streamer.FullSnapshot
 	(*StreamHeader)(nil),          // 2: streamer.StreamHeader
 	(*FullSnapshot_DataInfo)(nil), // 3: streamer.FullSnapshot.DataInfo
 }
-var file_snapshot_stream_header_pb_depIdxs = []int32{
+var file_stream_header_proto_depIdxs = []int32{
 	3, // 0: streamer.FullSnapshot.db:type_name -> streamer.FullSnapshot.DataInfo
 	3, // 1: streamer.FullSnapshot.wals:type_name -> streamer.FullSnapshot.DataInfo
 	0, // 2: streamer.StreamHeader.incremental_snapshot:type_name -> streamer.IncrementalSnapshot
@@ -324,13 +344,13 @@ var file_snapshot_stream_header_pb_depIdxs = []int32{
 	0, // [0:4] is the sub-list for field type_name
 }
 
-func init() { file_snapshot_stream_header_pb_init() }
-func file_snapshot_stream_header_pb_init() {
-	if File_snapshot_stream_header_pb != nil {
+func init() { file_stream_header_proto_init() }
+func file_stream_header_proto_init() {
+	if File_stream_header_proto != nil {
 		return
 	}
 	if !protoimpl.UnsafeEnabled {
-		file_snapshot_stream_header_pb_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+		file_stream_header_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*IncrementalSnapshot); i {
 			case 0:
 				return &v.state
@@ -342,7 +362,7 @@ func file_snapshot_stream_header_pb_init() {
 				return nil
 			}
 		}
-		file_snapshot_stream_header_pb_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+		file_stream_header_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*FullSnapshot); i {
 			case 0:
 				return &v.state
@@ -354,7 +374,7 @@ func file_snapshot_stream_header_pb_init() {
 				return nil
 			}
 		}
-		file_snapshot_stream_header_pb_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+		file_stream_header_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*StreamHeader); i {
 			case 0:
 				return &v.state
@@ -366,7 +386,7 @@ func file_snapshot_stream_header_pb_init() {
 				return nil
 			}
 		}
-		file_snapshot_stream_header_pb_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+		file_stream_header_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*FullSnapshot_DataInfo); i {
 			case 0:
 				return &v.state
@@ -379,7 +399,7 @@ func file_snapshot_stream_header_pb_init() {
 			}
 		}
 	}
-	file_snapshot_stream_header_pb_msgTypes[2].OneofWrappers = []interface{}{
+	file_stream_header_proto_msgTypes[2].OneofWrappers = []interface{}{
 		(*StreamHeader_IncrementalSnapshot)(nil),
 		(*StreamHeader_FullSnapshot)(nil),
 	}
@@ -387,18 +407,18 @@ func file_snapshot_stream_header_pb_init() {
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
-			RawDescriptor: file_snapshot_stream_header_pb_rawDesc,
+			RawDescriptor: file_stream_header_proto_rawDesc,
 			NumEnums:      0,
 			NumMessages:   4,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
-		GoTypes:           file_snapshot_stream_header_pb_goTypes,
-		DependencyIndexes: file_snapshot_stream_header_pb_depIdxs,
-		MessageInfos:      file_snapshot_stream_header_pb_msgTypes,
+		GoTypes:           file_stream_header_proto_goTypes,
+		DependencyIndexes: file_stream_header_proto_depIdxs,
+		MessageInfos:      file_stream_header_proto_msgTypes,
 	}.Build()
-	File_snapshot_stream_header_pb = out.File
-	file_snapshot_stream_header_pb_rawDesc = nil
-	file_snapshot_stream_header_pb_goTypes = nil
-	file_snapshot_stream_header_pb_depIdxs = nil
+	File_stream_header_proto = out.File
+	file_stream_header_proto_rawDesc = nil
+	file_stream_header_proto_goTypes = nil
+	file_stream_header_proto_depIdxs = nil
 }
@@ -2,10 +2,12 @@ package snapshot
 
 import (
 	"compress/gzip"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
@@ -18,10 +20,99 @@ const (
 	v7StateFile = "state.bin"
 )
 
-// Upgrade writes a copy of the 7.x-format Snapshot dircectory at 'old' to a
-// new Snapshot directory at 'new'. If the upgrade is successful, the
+// Upgrade actions, as reported by UpgradeWithOptions.
+const (
+	// UpgradeActionNone means there is no old snapshot directory to upgrade.
+	UpgradeActionNone = "none"
+
+	// UpgradeActionRemove means the old snapshot directory holds nothing
+	// which needs upgrading, and is removed.
+	UpgradeActionRemove = "remove"
+
+	// UpgradeActionConvert means the newest snapshot in the old snapshot
+	// directory is converted into the new snapshot directory, and the old
+	// snapshot directory is removed.
+	UpgradeActionConvert = "convert"
+)
+
+// UpgradeOptions sets how an upgrade is performed.
+type UpgradeOptions struct {
+	// DryRun, if set, performs the conversion into the temporary directory,
+	// and validates the result, but neither moves it into place nor removes
+	// the old directory. The temporary directory is left for inspection, and
+	// is removed by the next upgrade.
+	DryRun bool
+}
+
+// UpgradeReport describes what an upgrade did, or would do if a dry run.
+type UpgradeReport struct {
+	Old    string
+	New    string
+	DryRun bool
+	Action string
+
+	// The following are set only if a snapshot is converted. TmpDir is the
+	// directory holding the converted snapshot, and is set only for a dry run.
+	TmpDir     string
+	SnapshotID string
+	Index      uint64
+	Term       uint64
+	Format     string
+	SQLiteSize int64
+}
+
+// String returns a description of the report.
+func (r *UpgradeReport) String() string {
+	verb := "upgrade"
+	if r.DryRun {
+		verb = "dry-run upgrade"
+	}
+	switch r.Action {
+	case UpgradeActionNone:
+		return fmt.Sprintf("%s: nothing to upgrade in %s", verb, r.Old)
+	case UpgradeActionRemove:
+		return fmt.Sprintf("%s: remove %s, nothing to convert to %s", verb, r.Old, r.New)
+	}
+	desc := fmt.Sprintf("%s: convert %s-format snapshot %s (index %d, term %d, %d bytes of SQLite data) from %s to %s, and remove %s",
+		verb, r.Format, r.SnapshotID, r.Index, r.Term, r.SQLiteSize, r.Old, r.New, r.Old)
+	if r.TmpDir != "" {
+		desc += fmt.Sprintf(", converted snapshot left in %s", r.TmpDir)
+	}
+	return desc
+}
+
+// Upgrade writes a copy of the 6.x- or 7.x-format Snapshot dircectory at 'old'
+// to a new Snapshot directory at 'new'. If the upgrade is successful, the
 // 'old' directory is removed before the function returns.
+//
+// Both formats share the same layout, a directory per snapshot holding the
+// Raft meta and a state file, and differ only in the contents of the state
+// file. The 7.x state file holds the SQLite database gzip-compressed, while
+// the 6.x state file holds it uncompressed, followed by cluster metadata
+// which is no longer needed.
 func Upgrade(old, new string, logger *log.Logger) error {
+	_, err := UpgradeWithOptions(old, new, UpgradeOptions{}, logger)
+	return err
+}
+
+// UpgradeWithOptions upgrades the Snapshot directory at 'old' as Upgrade does,
+// as set by opts, and returns a report of what was done. If opts.DryRun is
+// set, the returned report is of what would be done, and the converted SQLite
+// file and meta are validated.
+func UpgradeWithOptions(old, new string, opts UpgradeOptions, logger *log.Logger) (retReport *UpgradeReport, retErr error) {
+	defer func() {
+		if retErr != nil {
+			stats.Add(numUpgradeFailures, 1)
+		} else if !opts.DryRun && retReport.Action == UpgradeActionConvert {
+			stats.Add(numUpgrades, 1)
+		}
+	}()
+	report := &UpgradeReport{
+		Old:    old,
+		New:    new,
+		DryRun: opts.DryRun,
+		Action: UpgradeActionNone,
+	}
 	newTmpDir := tmpName(new)
 	newGenerationDir := filepath.Join(newTmpDir, generationsDir, firstGeneration)
 
@@ -29,7 +120,7 @@ func Upgrade(old, new string, logger *log.Logger) error {
 	// previous upgrade attempt was interrupted. We will need to start over.
 	if dirExists(newTmpDir) {
 		if err := os.RemoveAll(newTmpDir); err != nil {
-			return fmt.Errorf("failed to remove temporary upgraded snapshot directory %s: %s", newTmpDir, err)
+			return nil, fmt.Errorf("failed to remove temporary upgraded snapshot directory %s: %s", newTmpDir, err)
 		}
 		logger.Println("detected temporary upgraded snapshot directory, removing")
 	}
@@ -37,56 +128,64 @@ func Upgrade(old, new string, logger *log.Logger) error {
 	if dirExists(old) {
 		oldIsEmpty, err := dirIsEmpty(old)
 		if err != nil {
-			return fmt.Errorf("failed to check if old snapshot directory %s is empty: %s", old, err)
+			return nil, fmt.Errorf("failed to check if old snapshot directory %s is empty: %s", old, err)
 		}
 
 		if oldIsEmpty {
 			logger.Printf("old snapshot directory %s is empty, nothing to upgrade", old)
+			report.Action = UpgradeActionRemove
+			if opts.DryRun {
+				return report, nil
+			}
 			if err := os.RemoveAll(old); err != nil {
-				return fmt.Errorf("failed to remove old snapshot directory %s: %s", old, err)
+				return nil, fmt.Errorf("failed to remove old snapshot directory %s: %s", old, err)
 			}
-			return nil
+			return report, nil
 		}
 
 		if dirExists(new) {
 			logger.Printf("new snapshot directory %s exists", old)
+			report.Action = UpgradeActionRemove
+			if opts.DryRun {
+				return report, nil
+			}
 			if err := os.RemoveAll(old); err != nil {
-				return fmt.Errorf("failed to remove old snapshot directory %s: %s", old, err)
+				return nil, fmt.Errorf("failed to remove old snapshot directory %s: %s", old, err)
 			}
 			logger.Printf("removed old snapshot directory %s as no upgrade is needed", old)
-			return nil
+			return report, nil
 		}
 	} else {
 		logger.Printf("old snapshot directory %s does not exist, nothing to upgrade", old)
-		return nil
+		return report, nil
 	}
 
 	// Start the upgrade process.
 	if err := os.MkdirAll(newTmpDir, 0755); err != nil {
-		return fmt.Errorf("failed to create temporary snapshot directory %s: %s", newTmpDir, err)
+		return nil, fmt.Errorf("failed to create temporary snapshot directory %s: %s", newTmpDir, err)
 	}
 
 	oldMeta, err := getNewest7Snapshot(old)
 	if err != nil {
-		return fmt.Errorf("failed to get newest snapshot from old snapshots directory %s: %s", old, err)
+		return nil, fmt.Errorf("failed to get newest snapshot from old snapshots directory %s: %s", old, err)
 	}
 	if oldMeta == nil {
 		// No snapshot to upgrade, this shouldn't happen since we checked for an empty old
 		// directory earlier.
-		return fmt.Errorf("no snapshot to upgrade in old snapshots directory %s", old)
+		return nil, fmt.Errorf("no snapshot to upgrade in old snapshots directory %s", old)
 	}
 
 	// Write out the new meta file.
 	newSnapshotPath := filepath.Join(newGenerationDir, oldMeta.ID)
 	if err := os.MkdirAll(newSnapshotPath, 0755); err != nil {
-		return fmt.Errorf("failed to create new snapshot directory %s: %s", newSnapshotPath, err)
+		return nil, fmt.Errorf("failed to create new snapshot directory %s: %s", newSnapshotPath, err)
 	}
 	newMeta := &Meta{
 		SnapshotMeta: *oldMeta,
 		Full:         true,
 	}
 	if err := writeMeta(newSnapshotPath, newMeta); err != nil {
-		return fmt.Errorf("failed to write new snapshot meta file: %s", err)
+		return nil, fmt.Errorf("failed to write new snapshot meta file: %s", err)
 	}
 
 	// Ensure all file handles are closed before any directory is renamed or removed.
@@ -107,45 +206,117 @@ func Upgrade(old, new string, logger *log.Logger) error {
 		}
 		defer stateFd.Close()
 
-		// Skip past the header and length of the old state file.
-		if _, err := stateFd.Seek(16, 0); err != nil {
-			return fmt.Errorf("failed to seek to beginning of old SQLite data %s: %s", oldStatePath, err)
-		}
-		gzipReader, err := gzip.NewReader(stateFd)
+		version, err := copyStateData(newSqliteFd, stateFd)
 		if err != nil {
-			return fmt.Errorf("failed to create gzip reader for new SQLite file %s: %s", newSqliteBasePath, err)
-		}
-		defer gzipReader.Close()
-		if _, err := io.Copy(newSqliteFd, gzipReader); err != nil {
 			return fmt.Errorf("failed to copy old SQLite file %s to new SQLite file %s: %s", oldStatePath,
 				newSqliteBasePath, err)
 		}
+		logger.Printf("upgrading %s-format snapshot %s", version, oldMeta.ID)
+		report.Format = version
 
 		// Sanity-check the SQLite data.
 		if !db.IsValidSQLiteFile(newSqliteBasePath) {
 			return fmt.Errorf("migrated SQLite file %s is not valid", newSqliteBasePath)
 		}
+		fi, err := newSqliteFd.Stat()
+		if err != nil {
+			return err
+		}
+		report.SQLiteSize = fi.Size()
 		return nil
 	}(); err != nil {
-		return err
+		return nil, err
+	}
+	report.Action = UpgradeActionConvert
+	report.SnapshotID = oldMeta.ID
+	report.Index = oldMeta.Index
+	report.Term = oldMeta.Term
+
+	if opts.DryRun {
+		if err := verifyUpgrade(newGenerationDir, oldMeta); err != nil {
+			return nil, fmt.Errorf("failed to verify upgraded snapshot in %s: %s", newTmpDir, err)
+		}
+		report.TmpDir = newTmpDir
+		logger.Printf("dry-run upgrade of snapshot directory %s verified, leaving %s in place", old, old)
+		return report, nil
 	}
 
 	// Move the upgraded snapshot directory into place.
 	if err := os.Rename(newTmpDir, new); err != nil {
-		return fmt.Errorf("failed to move temporary snapshot directory %s to %s: %s", newTmpDir, new, err)
+		return nil, fmt.Errorf("failed to move temporary snapshot directory %s to %s: %s", newTmpDir, new, err)
 	}
 	if err := syncDirParentMaybe(new); err != nil {
-		return fmt.Errorf("failed to sync parent directory of new snapshot directory %s: %s", new, err)
+		return nil, fmt.Errorf("failed to sync parent directory of new snapshot directory %s: %s", new, err)
 	}
 
 	// We're done! Remove old.
 	if err := removeDirSync(old); err != nil {
-		return fmt.Errorf("failed to remove old snapshot directory %s: %s", old, err)
+		return nil, fmt.Errorf("failed to remove old snapshot directory %s: %s", old, err)
 	}
 	logger.Printf("upgraded snapshot directory %s to %s", old, new)
+	return report, nil
+}
+
+// verifyUpgrade checks the snapshot upgraded into the generation directory
+// genDir from the snapshot with meta oldMeta. The meta of the upgraded
+// snapshot must match, and the SQLite file must pass an integrity check.
+func verifyUpgrade(genDir string, oldMeta *raft.SnapshotMeta) error {
+	fh, err := os.Open(filepath.Join(genDir, oldMeta.ID, metaFileName))
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	meta := &Meta{}
+	if err := json.NewDecoder(fh).Decode(meta); err != nil {
+		return fmt.Errorf("failed to read meta: %s", err)
+	}
+	if meta.ID != oldMeta.ID || meta.Index != oldMeta.Index || meta.Term != oldMeta.Term || !meta.Full {
+		return fmt.Errorf("meta of snapshot %s does not match original", oldMeta.ID)
+	}
+
+	ok, err := db.CheckIntegrity(filepath.Join(genDir, baseSqliteFile), true)
+	if err != nil {
+		return fmt.Errorf("failed to check integrity of SQLite file: %s", err)
+	}
+	if !ok {
+		return fmt.Errorf("SQLite file failed integrity check")
+	}
 	return nil
 }
 
+// copyStateData copies the SQLite data in the 6.x- or 7.x-format state file
+// read from r to w. It returns the version of the format, "6.x" or "7.x".
+func copyStateData(w io.Writer, r io.Reader) (string, error) {
+	// Both formats start with a uint64. In the 7.x format it is a marker,
+	// showing the data is compressed, and the compressed size follows. In the
+	// 6.x format it is the size of the uncompressed data.
+	var sz uint64
+	if err := binary.Read(r, binary.LittleEndian, &sz); err != nil {
+		return "", fmt.Errorf("failed to read state header: %s", err)
+	}
+
+	if sz == math.MaxUint64 {
+		if err := binary.Read(r, binary.LittleEndian, &sz); err != nil {
+			return "", fmt.Errorf("failed to read compressed size: %s", err)
+		}
+		gzipReader, err := gzip.NewReader(io.LimitReader(r, int64(sz)))
+		if err != nil {
+			return "", fmt.Errorf("failed to create gzip reader: %s", err)
+		}
+		defer gzipReader.Close()
+		if _, err := io.Copy(w, gzipReader); err != nil {
+			return "", err
+		}
+		return "7.x", nil
+	}
+
+	// Any cluster metadata following the SQLite data is left unread.
+	if _, err := io.CopyN(w, r, int64(sz)); err != nil {
+		return "", err
+	}
+	return "6.x", nil
+}
+
 // getNewest7Snapshot returns the newest snapshot Raft meta in the given directory.
 func getNewest7Snapshot(dir string) (*raft.SnapshotMeta, error) {
 	entries, err := os.ReadDir(dir)
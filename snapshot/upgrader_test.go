@@ -1,12 +1,15 @@
 package snapshot
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -76,6 +79,164 @@ func Test_Upgrade_OK(t *testing.T) {
 	}
 }
 
+func Test_Upgrade_V6_OK(t *testing.T) {
+	logger := log.New(os.Stderr, "[snapshot-store-upgrader] ", 0)
+	v6SnapshotID := "2-18-1686659761026"
+	oldTemp := filepath.Join(t.TempDir(), "snapshots")
+	newTemp := filepath.Join(t.TempDir(), "rsnapshots")
+
+	// Build a 6.x-format snapshot. The state file is the size of the SQLite
+	// data, the data itself, and then the cluster metadata.
+	snapDir := filepath.Join(oldTemp, v6SnapshotID)
+	if err := os.MkdirAll(snapDir, 0755); err != nil {
+		t.Fatalf("failed to create snapshot directory: %s", err)
+	}
+	copyFile(filepath.Join("testdata/upgrade/v7.20.3-snapshots", v6SnapshotID, metaFileName),
+		filepath.Join(snapDir, metaFileName))
+	sqliteData := mustReadFile("testdata/db-and-wals/backup.db")
+	var state bytes.Buffer
+	if err := binary.Write(&state, binary.LittleEndian, uint64(len(sqliteData))); err != nil {
+		t.Fatalf("failed to write state size: %s", err)
+	}
+	state.Write(sqliteData)
+	state.WriteString(`{"1":{"api_addr":"localhost:4001"}}`)
+	if err := os.WriteFile(filepath.Join(snapDir, v7StateFile), state.Bytes(), 0644); err != nil {
+		t.Fatalf("failed to write state file: %s", err)
+	}
+
+	if err := Upgrade(oldTemp, newTemp, logger); err != nil {
+		t.Fatalf("failed to upgrade 6.x snapshot: %s", err)
+	}
+	if dirExists(oldTemp) {
+		t.Fatalf("old snapshot directory still exists")
+	}
+
+	store, err := NewStore(newTemp)
+	if err != nil {
+		t.Fatalf("failed to create new snapshot store: %s", err)
+	}
+	snapshots, err := store.List()
+	if err != nil {
+		t.Fatalf("failed to list snapshots: %s", err)
+	}
+	if len(snapshots) != 1 || snapshots[0].ID != v6SnapshotID {
+		t.Fatalf("expected snapshot %s, got %v", v6SnapshotID, snapshots)
+	}
+	currGen, _, err := store.GetCurrentGenerationDir()
+	if err != nil {
+		t.Fatalf("failed to get current generation directory: %s", err)
+	}
+	if !bytes.Equal(sqliteData, mustReadFile(filepath.Join(currGen, baseSqliteFile))) {
+		t.Fatalf("upgraded SQLite data does not match original")
+	}
+}
+
+func Test_Upgrade_DryRun(t *testing.T) {
+	logger := log.New(os.Stderr, "[snapshot-store-upgrader] ", 0)
+	v7SnapshotID := "2-18-1686659761026"
+	oldTemp := filepath.Join(t.TempDir(), "snapshots")
+	newTemp := filepath.Join(t.TempDir(), "rsnapshots")
+	copyDir("testdata/upgrade/v7.20.3-snapshots", oldTemp)
+	ResetStats()
+
+	report, err := UpgradeWithOptions(oldTemp, newTemp, UpgradeOptions{DryRun: true}, logger)
+	if err != nil {
+		t.Fatalf("failed to dry-run upgrade: %s", err)
+	}
+	if exp, got := UpgradeActionConvert, report.Action; exp != got {
+		t.Fatalf("expected action %s, got %s", exp, got)
+	}
+	if !report.DryRun {
+		t.Fatalf("expected report of dry run")
+	}
+	if exp, got := v7SnapshotID, report.SnapshotID; exp != got {
+		t.Fatalf("expected snapshot ID %s, got %s", exp, got)
+	}
+	if exp, got := "7.x", report.Format; exp != got {
+		t.Fatalf("expected format %s, got %s", exp, got)
+	}
+	if report.Index == 0 || report.Term == 0 || report.SQLiteSize == 0 {
+		t.Fatalf("expected index, term and size to be set, got %+v", report)
+	}
+	if exp, got := tmpName(newTemp), report.TmpDir; exp != got {
+		t.Fatalf("expected temporary directory %s, got %s", exp, got)
+	}
+	if !strings.Contains(report.String(), "dry-run upgrade: convert 7.x-format snapshot "+v7SnapshotID) {
+		t.Fatalf("unexpected report description: %s", report)
+	}
+
+	// Nothing should have been moved into place or removed.
+	if !dirExists(oldTemp) {
+		t.Fatalf("old snapshot directory removed by dry run")
+	}
+	if dirExists(newTemp) {
+		t.Fatalf("new snapshot directory created by dry run")
+	}
+	if !dirExists(report.TmpDir) {
+		t.Fatalf("temporary directory not left by dry run")
+	}
+	if v := stats.Get(numUpgrades).String(); v != "0" {
+		t.Fatalf("expected dry run not to count as an upgrade, got %s", v)
+	}
+
+	// A real upgrade should then succeed, replacing the dry run's work.
+	if err := Upgrade(oldTemp, newTemp, logger); err != nil {
+		t.Fatalf("failed to upgrade after dry run: %s", err)
+	}
+	if dirExists(oldTemp) || dirExists(report.TmpDir) || !dirExists(newTemp) {
+		t.Fatalf("upgrade after dry run left unexpected directories")
+	}
+	if v := stats.Get(numUpgrades).String(); v != "1" {
+		t.Fatalf("expected 1 upgrade, got %s", v)
+	}
+
+	// With the new directory in place, a dry run should only report the
+	// removal of the old directory, and not remove it.
+	copyDir("testdata/upgrade/v7.20.3-snapshots", oldTemp)
+	report, err = UpgradeWithOptions(oldTemp, newTemp, UpgradeOptions{DryRun: true}, logger)
+	if err != nil {
+		t.Fatalf("failed to dry-run upgrade: %s", err)
+	}
+	if exp, got := UpgradeActionRemove, report.Action; exp != got {
+		t.Fatalf("expected action %s, got %s", exp, got)
+	}
+	if !dirExists(oldTemp) {
+		t.Fatalf("old snapshot directory removed by dry run")
+	}
+
+	report, err = UpgradeWithOptions("/does/not/exist", newTemp, UpgradeOptions{DryRun: true}, logger)
+	if err != nil {
+		t.Fatalf("failed to dry-run upgrade: %s", err)
+	}
+	if exp, got := UpgradeActionNone, report.Action; exp != got {
+		t.Fatalf("expected action %s, got %s", exp, got)
+	}
+}
+
+func Test_CopyStateData(t *testing.T) {
+	// A 7.x state file, as written by the V1 encoder.
+	var state bytes.Buffer
+	if _, err := NewV1Encoder([]byte("hello world")).WriteTo(&state); err != nil {
+		t.Fatalf("failed to encode state: %s", err)
+	}
+	var got bytes.Buffer
+	version, err := copyStateData(&got, &state)
+	if err != nil {
+		t.Fatalf("failed to copy 7.x state: %s", err)
+	}
+	if version != "7.x" || got.String() != "hello world" {
+		t.Fatalf("wrong 7.x state copy, version %s, data %q", version, got.String())
+	}
+
+	// A truncated 6.x state file.
+	state.Reset()
+	binary.Write(&state, binary.LittleEndian, uint64(100))
+	state.WriteString("too short")
+	if _, err := copyStateData(&got, &state); err == nil {
+		t.Fatalf("expected error copying truncated 6.x state")
+	}
+}
+
 /* MIT License
  *
  * Copyright (c) 2017 Roland Singer [roland.singer@desertbit.com]
@@ -0,0 +1,217 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	walHeaderSize      = 32
+	walFrameHeaderSize = 24
+	walMagicLE         = 0x377f0682
+	walMagicBE         = 0x377f0683
+
+	// walChecksumSuffix is appended to the path of a WAL file to form the
+	// path of the file holding its frame checksums.
+	walChecksumSuffix = ".crc"
+)
+
+var (
+	// ErrWALTorn is returned when WAL data does not consist of a valid header
+	// followed by a whole number of frames.
+	ErrWALTorn = errors.New("WAL data is torn or malformed")
+
+	// ErrWALChecksumMismatch is returned when the checksum of a WAL frame does
+	// not match the checksum recorded for it.
+	ErrWALChecksumMismatch = errors.New("WAL frame checksum mismatch")
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// WALFrameChecksums returns the CRC32 (Castagnoli) checksum of each frame in
+// the given SQLite WAL data. Empty data has no frames. An error is returned if
+// the data does not consist of a WAL header followed by whole frames, which is
+// what a torn write looks like.
+func WALFrameChecksums(b []byte) ([]uint32, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+	pageSize, err := walPageSize(b)
+	if err != nil {
+		return nil, err
+	}
+	frameSize := walFrameHeaderSize + pageSize
+	body := b[walHeaderSize:]
+	if len(body)%frameSize != 0 {
+		return nil, fmt.Errorf("%w: %d trailing bytes", ErrWALTorn, len(body)%frameSize)
+	}
+	sums := make([]uint32, 0, len(body)/frameSize)
+	for off := 0; off < len(body); off += frameSize {
+		sums = append(sums, crc32.Checksum(body[off:off+frameSize], castagnoli))
+	}
+	return sums, nil
+}
+
+// CheckWALFrames checks that the frames in the given WAL data match the
+// given checksums.
+func CheckWALFrames(b []byte, sums []uint32) error {
+	got, err := WALFrameChecksums(b)
+	if err != nil {
+		return err
+	}
+	return compareFrameChecksums(got, sums)
+}
+
+func compareFrameChecksums(got, exp []uint32) error {
+	if len(got) != len(exp) {
+		return fmt.Errorf("%w: expected %d frames, got %d", ErrWALChecksumMismatch, len(exp), len(got))
+	}
+	for i := range got {
+		if got[i] != exp[i] {
+			return fmt.Errorf("%w: frame %d", ErrWALChecksumMismatch, i)
+		}
+	}
+	return nil
+}
+
+// walPageSize returns the page size recorded in the WAL header.
+func walPageSize(hdr []byte) (int, error) {
+	if len(hdr) < walHeaderSize {
+		return 0, fmt.Errorf("%w: short header", ErrWALTorn)
+	}
+	magic := binary.BigEndian.Uint32(hdr[0:4])
+	if magic != walMagicLE && magic != walMagicBE {
+		return 0, fmt.Errorf("%w: bad magic 0x%x", ErrWALTorn, magic)
+	}
+	pageSize := int(binary.BigEndian.Uint32(hdr[8:12]))
+	if pageSize < 512 || pageSize > 65536 || pageSize&(pageSize-1) != 0 {
+		return 0, fmt.Errorf("%w: bad page size %d", ErrWALTorn, pageSize)
+	}
+	return pageSize, nil
+}
+
+// walFileChecksums computes the frame checksums of the WAL file at path,
+// without reading the entire file into memory.
+func walFileChecksums(path string) ([]uint32, error) {
+	rc, _, err := openDataFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	r := bufio.NewReader(rc)
+
+	hdr := make([]byte, walHeaderSize)
+	n, err := io.ReadFull(r, hdr)
+	if n == 0 && err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: short header", ErrWALTorn)
+	}
+	pageSize, err := walPageSize(hdr)
+	if err != nil {
+		return nil, err
+	}
+
+	var sums []uint32
+	frame := make([]byte, walFrameHeaderSize+pageSize)
+	for {
+		n, err := io.ReadFull(r, frame)
+		if err == io.EOF {
+			return sums, nil
+		} else if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("%w: %d trailing bytes", ErrWALTorn, n)
+		} else if err != nil {
+			return nil, err
+		}
+		sums = append(sums, crc32.Checksum(frame, castagnoli))
+	}
+}
+
+// walChecksumPath returns the path of the checksum file for the given WAL
+// file. A compressed WAL file has the same checksum file as it would if
+// uncompressed, since the checksums are of the uncompressed frames.
+func walChecksumPath(walPath string) string {
+	return strings.TrimSuffix(walPath, compressedSuffix) + walChecksumSuffix
+}
+
+// writeWALFileSync writes the WAL data, and its frame checksums, to the given
+// path. If compress is set, the WAL data is compressed, and written to the
+// path of the compressed file instead. Both files are synced to disk before
+// returning.
+func writeWALFileSync(path string, data []byte, sums []uint32, compress bool) error {
+	if compress {
+		if err := writeCompressedSync(path+compressedSuffix, bytes.NewReader(data), int64(len(data))); err != nil {
+			return err
+		}
+	} else if err := writeFileSync(path, data); err != nil {
+		return err
+	}
+	buf := make([]byte, 4*len(sums))
+	for i, s := range sums {
+		binary.BigEndian.PutUint32(buf[4*i:], s)
+	}
+	return writeFileSync(walChecksumPath(path), buf)
+}
+
+// readWALChecksums reads the frame checksums stored in the given file.
+func readWALChecksums(path string) ([]uint32, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(b)%4 != 0 {
+		return nil, fmt.Errorf("checksum file %s is malformed", path)
+	}
+	sums := make([]uint32, len(b)/4)
+	for i := range sums {
+		sums[i] = binary.BigEndian.Uint32(b[4*i:])
+	}
+	return sums, nil
+}
+
+// checkWALFile validates the WAL file at walPath against the checksums
+// stored in crcPath. If crcPath does not exist, for example because the
+// snapshot was written by an earlier version, only the framing of the WAL
+// file is checked.
+func checkWALFile(walPath, crcPath string) (retErr error) {
+	defer func() {
+		if retErr != nil {
+			stats.Add(numWALChecksumFailures, 1)
+		}
+	}()
+
+	got, err := walFileChecksums(walPath)
+	if err != nil {
+		return fmt.Errorf("WAL file %s: %w", walPath, err)
+	}
+	if !fileExists(crcPath) {
+		return nil
+	}
+	exp, err := readWALChecksums(crcPath)
+	if err != nil {
+		return err
+	}
+	if err := compareFrameChecksums(got, exp); err != nil {
+		return fmt.Errorf("WAL file %s: %w", walPath, err)
+	}
+	return nil
+}
+
+func writeFileSync(path string, data []byte) error {
+	fd, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	if _, err := fd.Write(data); err != nil {
+		return err
+	}
+	return fd.Sync()
+}
@@ -0,0 +1,87 @@
+package snapshot
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_WALFrameChecksums(t *testing.T) {
+	sums, err := WALFrameChecksums(nil)
+	if err != nil {
+		t.Fatalf("unexpected error for empty WAL: %s", err)
+	}
+	if len(sums) != 0 {
+		t.Fatalf("expected no checksums for empty WAL, got %d", len(sums))
+	}
+
+	walData := mustReadFile("testdata/db-and-wals/wal-00")
+	sums, err = WALFrameChecksums(walData)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(sums) != 1 {
+		t.Fatalf("expected 1 checksum, got %d", len(sums))
+	}
+	if err := CheckWALFrames(walData, sums); err != nil {
+		t.Fatalf("unexpected error checking WAL frames: %s", err)
+	}
+
+	// A torn write leaves a partial frame at the end of the WAL.
+	if _, err := WALFrameChecksums(walData[:len(walData)-100]); !errors.Is(err, ErrWALTorn) {
+		t.Fatalf("expected ErrWALTorn for truncated WAL, got %v", err)
+	}
+	if _, err := WALFrameChecksums([]byte("not a WAL file at all, not at all")); !errors.Is(err, ErrWALTorn) {
+		t.Fatalf("expected ErrWALTorn for non-WAL data, got %v", err)
+	}
+
+	corrupt := make([]byte, len(walData))
+	copy(corrupt, walData)
+	corrupt[len(corrupt)-1] ^= 0xff
+	if err := CheckWALFrames(corrupt, sums); !errors.Is(err, ErrWALChecksumMismatch) {
+		t.Fatalf("expected ErrWALChecksumMismatch for corrupt WAL, got %v", err)
+	}
+}
+
+func Test_CheckWALFile(t *testing.T) {
+	walData := mustReadFile("testdata/db-and-wals/wal-01")
+	sums, err := WALFrameChecksums(walData)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	walPath := filepath.Join(t.TempDir(), snapWALFile)
+	if err := writeWALFileSync(walPath, walData, sums, false); err != nil {
+		t.Fatalf("failed to write WAL file: %s", err)
+	}
+	if !fileExists(walChecksumPath(walPath)) {
+		t.Fatalf("checksum file was not written")
+	}
+	if err := checkWALFile(walPath, walChecksumPath(walPath)); err != nil {
+		t.Fatalf("unexpected error checking WAL file: %s", err)
+	}
+
+	// Corrupt a byte in the middle of the frame.
+	walData[len(walData)/2] ^= 0xff
+	if err := os.WriteFile(walPath, walData, 0644); err != nil {
+		t.Fatalf("failed to write WAL file: %s", err)
+	}
+	if err := checkWALFile(walPath, walChecksumPath(walPath)); !errors.Is(err, ErrWALChecksumMismatch) {
+		t.Fatalf("expected ErrWALChecksumMismatch, got %v", err)
+	}
+
+	// Without a checksum file only the framing is checked.
+	if err := os.Remove(walChecksumPath(walPath)); err != nil {
+		t.Fatalf("failed to remove checksum file: %s", err)
+	}
+	if err := checkWALFile(walPath, walChecksumPath(walPath)); err != nil {
+		t.Fatalf("unexpected error checking WAL file without checksums: %s", err)
+	}
+	if err := os.WriteFile(walPath, walData[:len(walData)-1], 0644); err != nil {
+		t.Fatalf("failed to write WAL file: %s", err)
+	}
+	if err := checkWALFile(walPath, walChecksumPath(walPath)); !errors.Is(err, ErrWALTorn) {
+		t.Fatalf("expected ErrWALTorn, got %v", err)
+	}
+}
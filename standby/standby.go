@@ -0,0 +1,566 @@
+// Package standby implements a warm standby cluster. A standby cluster
+// continuously consumes the change feed of a primary cluster, applying each
+// change to its own database, and rejects writes from clients. When the
+// primary is lost, or as part of a planned failover, the standby can be
+// promoted to be a primary. Promotion fences the old primary, so that it
+// rejects any further writes, before the standby starts accepting them.
+package standby
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rqlite/rqlite/command"
+)
+
+const (
+	// DefaultPollInterval is the default interval between polls of the
+	// primary's change feed.
+	DefaultPollInterval = time.Second
+
+	// DefaultBatchSize is the default maximum number of changes requested
+	// from the primary in a single poll.
+	DefaultBatchSize = 1000
+
+	// IndexHTTPHeader is the HTTP header set by the primary on a copy of its
+	// database, containing the index of the last change reflected in it.
+	IndexHTTPHeader = "X-RQLITE-CHANGES-INDEX"
+
+	// positionTable is the table in which the standby records the index of
+	// the last change applied, and whether it has been promoted. Because it
+	// is written through the Raft log, it is shared by every node of the
+	// standby cluster, and survives leader changes.
+	positionTable = "_rqlite_standby"
+
+	maxRedirects = 5
+)
+
+var (
+	// ErrNotLeader is returned when a standby operation must be performed on
+	// the leader of the standby cluster.
+	ErrNotLeader = errors.New("not leader")
+
+	// errResync is returned by the primary's change feed when the standby
+	// must resync from a full copy of the primary's database.
+	errResync = errors.New("resync required")
+)
+
+// stats captures stats for the standby service.
+var stats *expvar.Map
+
+const (
+	numChangesApplied = "changes_applied"
+	numResyncs        = "resyncs"
+	numPollErrors     = "poll_errors"
+	numPromotions     = "promotions"
+	numFenceFailures  = "fence_failures"
+)
+
+func init() {
+	stats = expvar.NewMap("standby")
+	ResetStats()
+}
+
+// ResetStats resets the expvar stats for this module. Mostly for test purposes.
+func ResetStats() {
+	stats.Init()
+	stats.Add(numChangesApplied, 0)
+	stats.Add(numResyncs, 0)
+	stats.Add(numPollErrors, 0)
+	stats.Add(numPromotions, 0)
+	stats.Add(numFenceFailures, 0)
+}
+
+// Store is the interface the Store of a standby cluster must implement.
+type Store interface {
+	// ApplyChange executes a change consumed from the primary.
+	ApplyChange(er *command.ExecuteRequest) ([]*command.ExecuteResult, error)
+
+	// ApplyResync replaces the database with a copy of the primary's.
+	ApplyResync(lr *command.LoadRequest) error
+
+	// Query executes a query against the local database.
+	Query(qr *command.QueryRequest) ([]*command.QueryRows, error)
+
+	// SetStandby sets whether the Store rejects writes from clients.
+	SetStandby(b bool)
+
+	// IsLeader returns whether this node is the leader of its cluster.
+	IsLeader() bool
+
+	// ID returns the ID of this node.
+	ID() string
+}
+
+// Consumer consumes the change feed of a primary cluster, and applies the
+// changes to the standby cluster. Every node of a standby cluster runs a
+// Consumer, but only the Consumer on the leader applies changes.
+type Consumer struct {
+	str      Store
+	primary  *url.URL
+	username string
+	password string
+	client   *http.Client
+
+	// PollInterval is the interval between polls of the primary.
+	PollInterval time.Duration
+
+	// BatchSize is the maximum number of changes requested in a single poll.
+	BatchSize int
+
+	mu       sync.Mutex // Held while changes are applied, or during promotion.
+	cursor   uint64     // Index of the last change examined on the primary.
+	promoted bool
+	lastErr  error
+	lastPoll time.Time
+
+	logger *log.Logger
+}
+
+// New returns a Consumer which applies the changes of the primary cluster,
+// reachable at the HTTP API URL primary, to str. Credentials for the primary
+// may be included in the URL. str is placed in standby mode immediately.
+func New(str Store, primary string) (*Consumer, error) {
+	u, err := url.Parse(primary)
+	if err != nil {
+		return nil, fmt.Errorf("invalid primary URL: %s", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid primary URL %s: scheme must be http or https", primary)
+	}
+
+	c := &Consumer{
+		str:          str,
+		primary:      u,
+		PollInterval: DefaultPollInterval,
+		BatchSize:    DefaultBatchSize,
+		logger:       log.New(os.Stderr, "[standby] ", log.LstdFlags),
+	}
+	if u.User != nil {
+		c.username = u.User.Username()
+		c.password, _ = u.User.Password()
+		u.User = nil
+	}
+	c.client = &http.Client{
+		Timeout: 30 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			// Redirects are followed by do(), so that the method is preserved.
+			return http.ErrUseLastResponse
+		},
+	}
+	str.SetStandby(true)
+	return c, nil
+}
+
+// Start starts consuming changes, until ctx is done or the standby cluster
+// is promoted.
+func (c *Consumer) Start(ctx context.Context) {
+	c.logger.Printf("consuming changes from primary at %s every %s", c.primary, c.PollInterval)
+	ticker := time.NewTicker(c.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			c.logger.Println("standby service shutting down")
+			return
+		case <-ticker.C:
+			promoted, err := c.poll()
+			c.mu.Lock()
+			c.lastErr = err
+			c.lastPoll = time.Now()
+			c.mu.Unlock()
+			if err != nil {
+				stats.Add(numPollErrors, 1)
+				c.logger.Printf("failed to consume changes from %s: %s", c.primary, err)
+			}
+			if promoted {
+				c.logger.Println("standby cluster promoted, no longer consuming changes")
+				return
+			}
+		}
+	}
+}
+
+// Active returns whether the Consumer is still acting as a standby, that is
+// the standby cluster has not been promoted.
+func (c *Consumer) Active() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !c.promoted
+}
+
+// Promote promotes the standby cluster to be a primary. The old primary is
+// first fenced, and any changes not yet consumed are applied. If the old
+// primary cannot be fenced, promotion fails unless force is set, in which
+// case the standby is promoted regardless, and any unconsumed changes are
+// lost. Promote must be called on the leader of the standby cluster.
+func (c *Consumer) Promote(force bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.promoted {
+		return nil
+	}
+	if !c.str.IsLeader() {
+		return ErrNotLeader
+	}
+
+	idx, _, exists, err := c.position()
+	if err != nil {
+		return err
+	}
+	if idx > c.cursor {
+		c.cursor = idx
+	}
+
+	if err := c.fence(); err != nil {
+		stats.Add(numFenceFailures, 1)
+		if !force {
+			return fmt.Errorf("failed to fence primary at %s: %s", c.primary, err)
+		}
+		c.logger.Printf("failed to fence primary at %s, promoting anyway: %s", c.primary, err)
+	} else {
+		// The primary accepts no more writes, so drain its remaining changes.
+		if exists, err = c.consume(exists); err != nil {
+			if !force {
+				return fmt.Errorf("failed to apply final changes from primary: %s", err)
+			}
+			c.logger.Printf("failed to apply final changes from primary, promoting anyway: %s", err)
+		}
+	}
+
+	stmts := []string{}
+	if !exists {
+		stmts = append(stmts, createPositionTable)
+	}
+	stmts = append(stmts, fmt.Sprintf(updatePosition, c.cursor, 1))
+	if _, err := c.str.ApplyChange(executeRequest(stmts)); err != nil {
+		return fmt.Errorf("failed to record promotion: %s", err)
+	}
+	c.promoted = true
+	c.str.SetStandby(false)
+	stats.Add(numPromotions, 1)
+	c.logger.Printf("standby cluster promoted at primary index %d", c.cursor)
+	return nil
+}
+
+// Stats returns stats on the Consumer.
+func (c *Consumer) Stats() (map[string]interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m := map[string]interface{}{
+		"primary":       c.primary.String(),
+		"poll_interval": c.PollInterval.String(),
+		"active":        !c.promoted,
+		"primary_index": c.cursor,
+	}
+	if !c.lastPoll.IsZero() {
+		m["last_poll"] = c.lastPoll.Format(time.RFC3339)
+	}
+	if c.lastErr != nil {
+		m["last_error"] = c.lastErr.Error()
+	}
+	return m, nil
+}
+
+// poll reads the position of the standby cluster and, if this node is the
+// leader and the cluster has not been promoted, applies any new changes. It
+// returns whether the cluster has been promoted.
+func (c *Consumer) poll() (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	idx, promoted, exists, err := c.position()
+	if err != nil {
+		return false, err
+	}
+	if promoted {
+		c.promoted = true
+		c.str.SetStandby(false)
+		return true, nil
+	}
+	if !c.str.IsLeader() {
+		return false, nil
+	}
+	if idx > c.cursor {
+		c.cursor = idx
+	}
+	_, err = c.consume(exists)
+	return false, err
+}
+
+// consume applies changes from the primary until it has no more. exists is
+// whether the position table exists, and the same is returned once done.
+func (c *Consumer) consume(exists bool) (bool, error) {
+	for {
+		changes, next, err := c.changes(c.cursor)
+		if err == errResync {
+			if err := c.resync(); err != nil {
+				return false, err
+			}
+			exists = true
+			continue
+		}
+		if err != nil {
+			return exists, err
+		}
+
+		for _, ch := range changes {
+			if !exists {
+				if _, err := c.str.ApplyChange(executeRequest([]string{createPositionTable})); err != nil {
+					return exists, err
+				}
+				exists = true
+			}
+			if err := c.apply(ch); err != nil {
+				return exists, err
+			}
+			c.cursor = ch.Index
+			stats.Add(numChangesApplied, 1)
+		}
+		if next > c.cursor {
+			// Entries which were not changes were examined, so skip them next
+			// time. Not recorded in the position table, as it would cost a
+			// write, and examining them again is harmless.
+			c.cursor = next
+		}
+		if len(changes) < c.BatchSize {
+			return exists, nil
+		}
+	}
+}
+
+// apply applies a single change, recording its index in the same request.
+// Since the request is a single Raft log entry, the change and the position
+// are always committed together.
+func (c *Consumer) apply(ch *change) error {
+	var cmd command.Command
+	if err := command.Unmarshal(ch.Data, &cmd); err != nil {
+		return err
+	}
+	var req *command.Request
+	switch cmd.Type {
+	case command.Command_COMMAND_TYPE_EXECUTE:
+		var er command.ExecuteRequest
+		if err := command.UnmarshalSubCommand(&cmd, &er); err != nil {
+			return err
+		}
+		req = er.Request
+	case command.Command_COMMAND_TYPE_EXECUTE_QUERY:
+		var eqr command.ExecuteQueryRequest
+		if err := command.UnmarshalSubCommand(&cmd, &eqr); err != nil {
+			return err
+		}
+		req = eqr.Request
+	default:
+		return fmt.Errorf("unexpected change type %s at index %d", cmd.Type, ch.Index)
+	}
+
+	// The idempotency key is kept, so that a request the primary turned into
+	// a no-op is one here too. The position is then not updated, but
+	// applying the change again is harmless.
+	pos := &command.Statement{Sql: fmt.Sprintf(updatePosition, ch.Index, 0)}
+	stmts := append(req.Statements, pos)
+	results, err := c.str.ApplyChange(&command.ExecuteRequest{
+		Request: &command.Request{
+			Transaction:    req.Transaction,
+			Statements:     stmts,
+			StopOnError:    req.StopOnError,
+			IdempotencyKey: req.IdempotencyKey,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if len(results) == len(stmts) && results[len(results)-1].Error == "" {
+		return nil
+	}
+
+	// A statement failed inside a transaction, so the position update was
+	// rolled back with it, or processing stopped at the failed statement
+	// before reaching it. The primary failed the same way, so record the
+	// change as applied.
+	_, err = c.str.ApplyChange(executeRequest([]string{pos.Sql}))
+	return err
+}
+
+// resync replaces the standby's database with a copy of the primary's.
+func (c *Consumer) resync() error {
+	resp, err := c.do("GET", "/db/changes/snapshot", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to copy database from primary: %s", resp.Status)
+	}
+	idx, err := strconv.ParseUint(resp.Header.Get(IndexHTTPHeader), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid index in copy of primary database: %s", err)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	c.logger.Printf("resyncing from copy of primary database at index %d", idx)
+	if err := c.str.ApplyResync(&command.LoadRequest{Data: b}); err != nil {
+		return err
+	}
+	if _, err := c.str.ApplyChange(executeRequest([]string{
+		createPositionTable,
+		fmt.Sprintf(updatePosition, idx, 0),
+	})); err != nil {
+		return err
+	}
+	c.cursor = idx
+	stats.Add(numResyncs, 1)
+	return nil
+}
+
+// change is a single change read from the primary's change feed.
+type change struct {
+	Index uint64 `json:"index"`
+	Data  []byte `json:"data"`
+}
+
+// changes returns the changes after since from the primary, as well as the
+// index to request from next.
+func (c *Consumer) changes(since uint64) ([]*change, uint64, error) {
+	v := url.Values{}
+	v.Set("since", strconv.FormatUint(since, 10))
+	v.Set("max", strconv.Itoa(c.BatchSize))
+	resp, err := c.do("GET", "/db/changes", v)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusGone {
+		return nil, 0, errResync
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("failed to read changes from primary: %s", resp.Status)
+	}
+
+	var cs struct {
+		Changes []*change `json:"changes"`
+		Next    uint64    `json:"next"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&cs); err != nil {
+		return nil, 0, err
+	}
+	return cs.Changes, cs.Next, nil
+}
+
+// fence fences the primary cluster.
+func (c *Consumer) fence() error {
+	v := url.Values{}
+	v.Set("id", c.str.ID())
+	resp, err := c.do("POST", "/db/fence", v)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fence request failed: %s", resp.Status)
+	}
+	return nil
+}
+
+// position returns the index of the last change applied from the primary,
+// whether the standby has been promoted, and whether the position table
+// exists at all.
+func (c *Consumer) position() (uint64, bool, bool, error) {
+	rows, err := c.str.Query(&command.QueryRequest{
+		Request: &command.Request{
+			Statements: []*command.Statement{{Sql: queryPosition}},
+		},
+		Level: command.QueryRequest_QUERY_REQUEST_LEVEL_NONE,
+	})
+	if err != nil {
+		return 0, false, false, err
+	}
+	if len(rows) != 1 {
+		return 0, false, false, fmt.Errorf("unexpected position query result")
+	}
+	if rows[0].Error != "" {
+		if strings.Contains(rows[0].Error, "no such table") {
+			return 0, false, false, nil
+		}
+		return 0, false, false, errors.New(rows[0].Error)
+	}
+	if len(rows[0].Values) == 0 {
+		return 0, false, true, nil
+	}
+	params := rows[0].Values[0].Parameters
+	if len(params) != 2 {
+		return 0, false, true, fmt.Errorf("unexpected position row")
+	}
+	return uint64(params[0].GetI()), params[1].GetI() != 0, true, nil
+}
+
+// do performs a request against the primary, following any redirects to
+// the primary's leader while preserving the method.
+func (c *Consumer) do(method, path string, v url.Values) (*http.Response, error) {
+	u := *c.primary
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	u.RawQuery = v.Encode()
+	target := u.String()
+
+	for i := 0; i < maxRedirects; i++ {
+		req, err := http.NewRequest(method, target, nil)
+		if err != nil {
+			return nil, err
+		}
+		if c.username != "" {
+			req.SetBasicAuth(c.username, c.password)
+		}
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		switch resp.StatusCode {
+		case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+			resp.Body.Close()
+			loc, err := resp.Location()
+			if err != nil {
+				return nil, err
+			}
+			target = loc.String()
+			continue
+		}
+		return resp, nil
+	}
+	return nil, fmt.Errorf("too many redirects")
+}
+
+const (
+	createPositionTable = `CREATE TABLE IF NOT EXISTS ` + positionTable +
+		` (id INTEGER NOT NULL PRIMARY KEY, idx INTEGER NOT NULL, promoted INTEGER NOT NULL)`
+	updatePosition = `INSERT OR REPLACE INTO ` + positionTable + `(id, idx, promoted) VALUES(1, %d, %d)`
+	queryPosition  = `SELECT idx, promoted FROM ` + positionTable + ` WHERE id = 1`
+)
+
+func executeRequest(stmts []string) *command.ExecuteRequest {
+	ss := make([]*command.Statement, len(stmts))
+	for i := range stmts {
+		ss[i] = &command.Statement{Sql: stmts[i]}
+	}
+	return &command.ExecuteRequest{
+		Request: &command.Request{
+			Statements: ss,
+		},
+	}
+}
@@ -0,0 +1,346 @@
+package standby
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rqlite/rqlite/command"
+	sql "github.com/rqlite/rqlite/db"
+)
+
+func Test_NewInvalidPrimary(t *testing.T) {
+	str := mustNewMockStore(t)
+	if _, err := New(str, "ftp://localhost:4001"); err == nil {
+		t.Fatalf("expected error for invalid primary URL")
+	}
+}
+
+func Test_ConsumerApplies(t *testing.T) {
+	str := mustNewMockStore(t)
+	p := newFakePrimary(t)
+	defer p.Close()
+	p.addChange(false, `CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`)
+	p.addChange(false, `INSERT INTO foo(id, name) VALUES(1, "fiona")`)
+
+	c := mustNewConsumer(t, str, p.URL)
+	if !str.isStandby() {
+		t.Fatalf("store not placed in standby mode")
+	}
+	if _, err := c.poll(); err != nil {
+		t.Fatalf("failed to poll primary: %s", err)
+	}
+	if got, exp := str.count(t), 1; got != exp {
+		t.Fatalf("wrong row count, exp %d, got %d", exp, got)
+	}
+
+	// A failed transaction on the primary is still recorded as applied.
+	p.addChange(true, `INSERT INTO foo(id, name) VALUES(2, "declan")`, `INSERT INTO foo(id, name) VALUES(1, "fiona")`)
+	p.addChange(false, `INSERT INTO foo(id, name) VALUES(3, "aoife")`)
+	if _, err := c.poll(); err != nil {
+		t.Fatalf("failed to poll primary: %s", err)
+	}
+	if got, exp := str.count(t), 2; got != exp {
+		t.Fatalf("wrong row count, exp %d, got %d", exp, got)
+	}
+
+	idx, promoted, exists, err := c.position()
+	if err != nil {
+		t.Fatalf("failed to read position: %s", err)
+	}
+	if idx != 4 || promoted || !exists {
+		t.Fatalf("wrong position, idx %d, promoted %v, exists %v", idx, promoted, exists)
+	}
+
+	// Nothing is applied on a follower.
+	str.leader = false
+	p.addChange(false, `INSERT INTO foo(id, name) VALUES(4, "siobhan")`)
+	if _, err := c.poll(); err != nil {
+		t.Fatalf("failed to poll primary: %s", err)
+	}
+	if got, exp := str.count(t), 2; got != exp {
+		t.Fatalf("wrong row count on follower, exp %d, got %d", exp, got)
+	}
+}
+
+func Test_ConsumerResync(t *testing.T) {
+	str := mustNewMockStore(t)
+	p := newFakePrimary(t)
+	defer p.Close()
+	p.snapshotIdx = 10
+	p.snapshot = mustSnapshot(t,
+		`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`,
+		`INSERT INTO foo(id, name) VALUES(1, "fiona")`,
+		`INSERT INTO foo(id, name) VALUES(2, "declan")`,
+	)
+	p.firstIdx = 11
+
+	c := mustNewConsumer(t, str, p.URL)
+	if _, err := c.poll(); err != nil {
+		t.Fatalf("failed to poll primary: %s", err)
+	}
+	if got, exp := str.count(t), 2; got != exp {
+		t.Fatalf("wrong row count after resync, exp %d, got %d", exp, got)
+	}
+	if str.resyncs != 1 {
+		t.Fatalf("expected 1 resync, got %d", str.resyncs)
+	}
+	if idx, _, _, _ := c.position(); idx != 10 {
+		t.Fatalf("wrong position after resync, exp 10, got %d", idx)
+	}
+}
+
+func Test_ConsumerPromote(t *testing.T) {
+	str := mustNewMockStore(t)
+	p := newFakePrimary(t)
+	defer p.Close()
+	p.addChange(false, `CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`)
+
+	c := mustNewConsumer(t, str, p.URL)
+	if _, err := c.poll(); err != nil {
+		t.Fatalf("failed to poll primary: %s", err)
+	}
+
+	// Promotion fails if the primary cannot be fenced.
+	p.fenceFail = true
+	if err := c.Promote(false); err == nil {
+		t.Fatalf("expected promotion to fail when fencing fails")
+	}
+	if !c.Active() || !str.isStandby() {
+		t.Fatalf("standby promoted despite failure")
+	}
+
+	// A change made just before promotion is still applied.
+	p.fenceFail = false
+	p.addChange(false, `INSERT INTO foo(id, name) VALUES(1, "fiona")`)
+	if err := c.Promote(false); err != nil {
+		t.Fatalf("failed to promote: %s", err)
+	}
+	if p.fencedBy != "node1" {
+		t.Fatalf("primary not fenced by standby, fenced by %q", p.fencedBy)
+	}
+	if c.Active() || str.isStandby() {
+		t.Fatalf("standby still active after promotion")
+	}
+	if got, exp := str.count(t), 1; got != exp {
+		t.Fatalf("wrong row count after promotion, exp %d, got %d", exp, got)
+	}
+
+	// Other nodes learn of the promotion from the position table.
+	c2 := mustNewConsumer(t, str, p.URL)
+	promoted, err := c2.poll()
+	if err != nil {
+		t.Fatalf("failed to poll: %s", err)
+	}
+	if !promoted || c2.Active() {
+		t.Fatalf("promotion not detected by other consumer")
+	}
+}
+
+func Test_ConsumerPromoteForce(t *testing.T) {
+	str := mustNewMockStore(t)
+	p := newFakePrimary(t)
+	defer p.Close()
+	p.fenceFail = true
+
+	c := mustNewConsumer(t, str, p.URL)
+	if err := c.Promote(true); err != nil {
+		t.Fatalf("failed to force promotion: %s", err)
+	}
+	if c.Active() {
+		t.Fatalf("standby still active after forced promotion")
+	}
+}
+
+func Test_ConsumerPromoteNotLeader(t *testing.T) {
+	str := mustNewMockStore(t)
+	str.leader = false
+	c := mustNewConsumer(t, str, "http://localhost:1")
+	if err := c.Promote(false); err != ErrNotLeader {
+		t.Fatalf("expected ErrNotLeader, got %v", err)
+	}
+}
+
+type fakePrimary struct {
+	*httptest.Server
+	t *testing.T
+
+	mu          sync.Mutex
+	changes     []map[string]interface{}
+	firstIdx    uint64
+	snapshot    []byte
+	snapshotIdx uint64
+	fenceFail   bool
+	fencedBy    string
+}
+
+func newFakePrimary(t *testing.T) *fakePrimary {
+	p := &fakePrimary{t: t, firstIdx: 1}
+	p.Server = httptest.NewServer(http.HandlerFunc(p.serve))
+	return p
+}
+
+// addChange adds a change, made up of the given statements, at the next index.
+func (p *fakePrimary) addChange(tx bool, stmts ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ss := make([]*command.Statement, len(stmts))
+	for i := range stmts {
+		ss[i] = &command.Statement{Sql: stmts[i]}
+	}
+	b, compressed, err := command.NewRequestMarshaler().Marshal(&command.ExecuteRequest{
+		Request: &command.Request{Transaction: tx, Statements: ss},
+	})
+	if err != nil {
+		p.t.Fatalf("failed to marshal request: %s", err)
+	}
+	data, err := command.Marshal(&command.Command{
+		Type:       command.Command_COMMAND_TYPE_EXECUTE,
+		SubCommand: b,
+		Compressed: compressed,
+	})
+	if err != nil {
+		p.t.Fatalf("failed to marshal command: %s", err)
+	}
+	p.changes = append(p.changes, map[string]interface{}{
+		"index": uint64(len(p.changes)) + 1,
+		"data":  data,
+	})
+}
+
+func (p *fakePrimary) serve(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch r.URL.Path {
+	case "/db/changes":
+		since, _ := strconv.ParseUint(r.URL.Query().Get("since"), 10, 64)
+		if since+1 < p.firstIdx {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		var changes []map[string]interface{}
+		next := since
+		for _, c := range p.changes {
+			if idx := c["index"].(uint64); idx > since {
+				changes = append(changes, c)
+				next = idx
+			}
+		}
+		b, _ := json.Marshal(map[string]interface{}{"changes": changes, "next": next})
+		w.Write(b)
+	case "/db/changes/snapshot":
+		w.Header().Set(IndexHTTPHeader, strconv.FormatUint(p.snapshotIdx, 10))
+		w.Write(p.snapshot)
+	case "/db/fence":
+		if r.Method != "POST" || p.fenceFail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		p.fencedBy = r.URL.Query().Get("id")
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+type mockStore struct {
+	dir     string
+	db      *sql.DB
+	leader  bool
+	standby bool
+	resyncs int
+	mu      sync.Mutex
+}
+
+func mustNewMockStore(t *testing.T) *mockStore {
+	dir := t.TempDir()
+	db, err := sql.Open(filepath.Join(dir, "db.sqlite"), false, false)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err)
+	}
+	s := &mockStore{dir: dir, db: db, leader: true}
+	t.Cleanup(func() { s.db.Close() })
+	return s
+}
+
+func (m *mockStore) ApplyChange(er *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+	return m.db.Execute(er.Request, false)
+}
+
+func (m *mockStore) ApplyResync(lr *command.LoadRequest) error {
+	m.resyncs++
+	path := filepath.Join(m.dir, "resync-"+strconv.Itoa(m.resyncs)+".sqlite")
+	if err := os.WriteFile(path, lr.Data, 0644); err != nil {
+		return err
+	}
+	db, err := sql.Open(path, false, false)
+	if err != nil {
+		return err
+	}
+	m.db.Close()
+	m.db = db
+	return nil
+}
+
+func (m *mockStore) Query(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+	return m.db.Query(qr.Request, false)
+}
+
+func (m *mockStore) SetStandby(b bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.standby = b
+}
+
+func (m *mockStore) isStandby() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.standby
+}
+
+func (m *mockStore) IsLeader() bool { return m.leader }
+
+func (m *mockStore) ID() string { return "node1" }
+
+func (m *mockStore) count(t *testing.T) int {
+	t.Helper()
+	rows, err := m.db.QueryStringStmt(`SELECT COUNT(*) FROM foo`)
+	if err != nil {
+		t.Fatalf("failed to count rows: %s", err)
+	}
+	if rows[0].Error != "" {
+		t.Fatalf("failed to count rows: %s", rows[0].Error)
+	}
+	return int(rows[0].Values[0].Parameters[0].GetI())
+}
+
+func mustNewConsumer(t *testing.T, str Store, primary string) *Consumer {
+	t.Helper()
+	c, err := New(str, primary)
+	if err != nil {
+		t.Fatalf("failed to create consumer: %s", err)
+	}
+	return c
+}
+
+func mustSnapshot(t *testing.T, stmts ...string) []byte {
+	t.Helper()
+	db, err := sql.Open(filepath.Join(t.TempDir(), "snap.sqlite"), false, false)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err)
+	}
+	defer db.Close()
+	if _, err := db.ExecuteStringStmt(strings.Join(stmts, ";")); err != nil {
+		t.Fatalf("failed to build snapshot: %s", err)
+	}
+	b, err := db.Serialize()
+	if err != nil {
+		t.Fatalf("failed to serialize snapshot: %s", err)
+	}
+	return b
+}
@@ -0,0 +1,133 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rqlite/rqlite/command"
+)
+
+// ErrArchiveNotEnabled is returned when an operation on the archive database
+// is requested of a Store without one.
+var ErrArchiveNotEnabled = errors.New("archive database not enabled")
+
+// ArchiveRows moves the rows of the table matching the condition where, an
+// SQL expression, into a table of the same name and schema in the archive
+// database, creating it if necessary. Archived rows remain queryable, by
+// qualifying the table name with ArchiveSchema, but are not part of the
+// main database, so are excluded from snapshots and backups of it, and no
+// longer add to the size of the main database once it is vacuumed.
+//
+// Rows are moved by statements applied through Raft, so the archive
+// database of every node holds the same rows. Rows replace any with the same
+// primary key in the archive, so moves replayed from the Raft log when a
+// node restarts do not duplicate rows, as long as the table has a primary key.
+// ArchiveRows returns the number of rows moved.
+func (s *Store) ArchiveRows(table, where string) (int64, error) {
+	if s.dbConf.ArchivePath == "" {
+		return 0, ErrArchiveNotEnabled
+	}
+	if strings.TrimSpace(where) == "" {
+		return 0, fmt.Errorf("condition selecting rows to archive must be set")
+	}
+
+	create, err := s.archiveTableSQL(table)
+	if err != nil {
+		return 0, err
+	}
+	qt := quoteIdentifier(table)
+	qs := quoteIdentifier(ArchiveSchema)
+	results, err := s.Execute(&command.ExecuteRequest{
+		Request: &command.Request{
+			Transaction: true,
+			Statements: []*command.Statement{
+				{Sql: create},
+				{Sql: fmt.Sprintf("INSERT OR REPLACE INTO %s.%s SELECT * FROM main.%s WHERE %s", qs, qt, qt, where)},
+				{Sql: fmt.Sprintf("DELETE FROM main.%s WHERE %s", qt, where)},
+			},
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, r := range results {
+		if r.Error != "" {
+			return 0, fmt.Errorf("failed to archive rows of %s: %s", table, r.Error)
+		}
+	}
+	if len(results) != 3 {
+		return 0, fmt.Errorf("failed to archive rows of %s: unexpected results", table)
+	}
+	n := results[2].RowsAffected
+	stats.Add(numArchivedRows, n)
+	s.logger.Printf("archived %d rows of table %s", n, table)
+	return n, nil
+}
+
+// BackupArchive writes a consistent copy of the archive database to dst.
+func (s *Store) BackupArchive(dst io.Writer) error {
+	if !s.open {
+		return ErrNotOpen
+	}
+	if s.dbConf.ArchivePath == "" {
+		return ErrArchiveNotEnabled
+	}
+
+	dir, err := os.MkdirTemp("", "rqlite-archive-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "archive.db")
+	if err := s.db.BackupAttached(ArchiveSchema, path); err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(dst, f)
+	return err
+}
+
+// archiveTableSQL returns the statement creating the table in the archive
+// database, with the schema of the table in the main database, if it does
+// not exist.
+func (s *Store) archiveTableSQL(table string) (string, error) {
+	rows, err := s.db.Query(&command.Request{
+		Statements: []*command.Statement{
+			{
+				Sql: "SELECT sql FROM main.sqlite_master WHERE type = 'table' AND name = ?",
+				Parameters: []*command.Parameter{
+					{Value: &command.Parameter_S{S: table}},
+				},
+			},
+		},
+	}, false)
+	if err != nil {
+		return "", err
+	}
+	if len(rows) != 1 || rows[0].Error != "" || len(rows[0].Values) != 1 {
+		return "", fmt.Errorf("table %s does not exist", table)
+	}
+	create := rows[0].Values[0].Parameters[0].GetS()
+
+	// The definition follows the table name, which may not contain an
+	// opening parenthesis unless quoted.
+	i := strings.Index(create, "(")
+	if i < 0 || strings.Count(create[:i], `"`)%2 != 0 {
+		return "", fmt.Errorf("unable to determine schema of table %s", table)
+	}
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s.%s %s", quoteIdentifier(ArchiveSchema),
+		quoteIdentifier(table), create[i:]), nil
+}
+
+// quoteIdentifier quotes a SQLite identifier.
+func quoteIdentifier(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
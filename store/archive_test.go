@@ -0,0 +1,118 @@
+package store
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rqlite/rqlite/command"
+)
+
+func Test_SingleNodeArchiveRows(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+	s.dbConf.ArchivePath = filepath.Join(t.TempDir(), "archive.db")
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	er := executeRequestFromStrings([]string{
+		`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`,
+		`INSERT INTO foo(id, name) VALUES(1, "fiona")`,
+		`INSERT INTO foo(id, name) VALUES(2, "declan")`,
+		`INSERT INTO foo(id, name) VALUES(3, "aoife")`,
+	}, false, false)
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+
+	if _, err := s.ArchiveRows("foo", ""); err == nil {
+		t.Fatalf("expected error archiving rows without condition")
+	}
+	if _, err := s.ArchiveRows("bar", "id < 3"); err == nil {
+		t.Fatalf("expected error archiving rows of table which does not exist")
+	}
+	n, err := s.ArchiveRows("foo", "id < 3")
+	if err != nil {
+		t.Fatalf("failed to archive rows: %s", err.Error())
+	}
+	if n != 2 {
+		t.Fatalf("wrong number of rows archived, exp 2, got %d", n)
+	}
+
+	checkRows := func(sql, exp string) {
+		t.Helper()
+		qr := queryRequestFromString(sql, false, false)
+		qr.Level = command.QueryRequest_QUERY_REQUEST_LEVEL_STRONG
+		r, err := s.Query(qr)
+		if err != nil {
+			t.Fatalf("failed to query single node: %s", err.Error())
+		}
+		if got := asJSON(r[0].Values); exp != got {
+			t.Fatalf("unexpected results for query %s\nexp: %s\ngot: %s", sql, exp, got)
+		}
+	}
+	checkRows("SELECT * FROM foo", `[[3,"aoife"]]`)
+	checkRows("SELECT * FROM archive.foo ORDER BY id", `[[1,"fiona"],[2,"declan"]]`)
+
+	// Archiving again, as happens when the log is replayed, does not
+	// duplicate rows.
+	er = executeRequestFromStrings([]string{
+		`INSERT INTO foo(id, name) VALUES(2, "declan")`,
+	}, false, false)
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+	if _, err := s.ArchiveRows("foo", "id < 3"); err != nil {
+		t.Fatalf("failed to archive rows: %s", err.Error())
+	}
+	checkRows("SELECT * FROM archive.foo ORDER BY id", `[[1,"fiona"],[2,"declan"]]`)
+
+	// Restarting the node replays the moves without duplicating rows.
+	fsmIdx, err := s.WaitForAppliedFSM(5 * time.Second)
+	if err != nil {
+		t.Fatalf("failed to wait for fsmIndex: %s", err.Error())
+	}
+	if err := s.Close(true); err != nil {
+		t.Fatalf("failed to close single-node store: %s", err.Error())
+	}
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+	if _, err := s.WaitForFSMIndex(fsmIdx, 5*time.Second); err != nil {
+		t.Fatalf("error waiting for FSM index: %s", err.Error())
+	}
+	checkRows("SELECT * FROM foo", `[[3,"aoife"]]`)
+	checkRows("SELECT * FROM archive.foo ORDER BY id", `[[1,"fiona"],[2,"declan"]]`)
+
+	var buf bytes.Buffer
+	if err := s.BackupArchive(&buf); err != nil {
+		t.Fatalf("failed to back up archive: %s", err.Error())
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("SQLite format 3")) {
+		t.Fatalf("backup of archive is not a SQLite database")
+	}
+}
+
+func Test_SingleNodeArchiveNotEnabled(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+	if _, err := s.ArchiveRows("foo", "id < 3"); err != ErrArchiveNotEnabled {
+		t.Fatalf("expected archive not enabled error, got %v", err)
+	}
+	if err := s.BackupArchive(&bytes.Buffer{}); err != ErrNotOpen {
+		t.Fatalf("expected not open error, got %v", err)
+	}
+}
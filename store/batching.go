@@ -0,0 +1,186 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Consistency domains, the kinds of Raft log entry which are batched
+// separately.
+const (
+	// BatchWrites is the domain of requests which change the database.
+	BatchWrites = "writes"
+
+	// BatchStrongReads is the domain of queries with strong read
+	// consistency, which go through the Raft log.
+	BatchStrongReads = "strong_reads"
+)
+
+const (
+	// MaxBatchWindow is the longest batching window which may be set, which
+	// bounds the latency added to each request.
+	MaxBatchWindow = 50 * time.Millisecond
+
+	// maxBatchSize is the number of entries after which a batch is released
+	// without waiting for its window to close. It matches the number of
+	// entries Raft appends to its log at once.
+	maxBatchSize = 64
+)
+
+// ErrUnknownBatchDomain is returned when a batching window is set for an
+// unknown consistency domain.
+var ErrUnknownBatchDomain = errors.New("unknown batching domain")
+
+// ParseBatchWindows parses a comma-separated list of batching windows, each
+// of the form domain=window, for example "writes=2ms,strong_reads=1ms".
+func ParseBatchWindows(s string) (map[string]time.Duration, error) {
+	windows := make(map[string]time.Duration)
+	for _, spec := range strings.Split(s, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid batching window %q, must be domain=window", spec)
+		}
+		d, err := time.ParseDuration(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid batching window %q: %s", spec, err.Error())
+		}
+		if err := checkBatchWindow(parts[0], d); err != nil {
+			return nil, err
+		}
+		windows[parts[0]] = d
+	}
+	return windows, nil
+}
+
+func checkBatchWindow(domain string, window time.Duration) error {
+	if domain != BatchWrites && domain != BatchStrongReads {
+		return fmt.Errorf("%w %q", ErrUnknownBatchDomain, domain)
+	}
+	if window < 0 || window > MaxBatchWindow {
+		return fmt.Errorf("batching window must be between 0 and %s", MaxBatchWindow)
+	}
+	return nil
+}
+
+// batcher holds the entries of a consistency domain for up to a window,
+// before releasing them to be written to the Raft log together, so that Raft
+// appends them to its log, and replicates them, as one. Safe for use from
+// multiple goroutines.
+type batcher struct {
+	mu      sync.Mutex
+	window  time.Duration
+	release chan struct{}
+	size    int
+
+	batches uint64
+	entries uint64
+	waited  time.Duration
+}
+
+// wait waits until the batch the caller joins is released, which is at once
+// if the window is zero.
+func (b *batcher) wait() {
+	b.mu.Lock()
+	if b.window <= 0 {
+		b.mu.Unlock()
+		return
+	}
+	if b.release == nil {
+		release := make(chan struct{})
+		b.release = release
+		b.size = 0
+		time.AfterFunc(b.window, func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			b.releaseLocked(release)
+		})
+	}
+	release := b.release
+	b.size++
+	b.entries++
+	stats.Add(numBatchedEntries, 1)
+	if b.size >= maxBatchSize {
+		b.releaseLocked(release)
+	}
+	b.mu.Unlock()
+
+	start := time.Now()
+	<-release
+	b.mu.Lock()
+	b.waited += time.Since(start)
+	b.mu.Unlock()
+}
+
+// releaseLocked releases the batch, unless it already has been. mu must be
+// held.
+func (b *batcher) releaseLocked(release chan struct{}) {
+	if b.release != release {
+		return
+	}
+	close(release)
+	b.release = nil
+	b.batches++
+	stats.Add(numBatchesReleased, 1)
+}
+
+func (b *batcher) setWindow(window time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.window = window
+	if window <= 0 && b.release != nil {
+		b.releaseLocked(b.release)
+	}
+}
+
+func (b *batcher) stats() map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	m := map[string]interface{}{
+		"window":  b.window.String(),
+		"batches": b.batches,
+		"entries": b.entries,
+	}
+	if b.batches > 0 {
+		m["mean_batch_size"] = float64(b.entries) / float64(b.batches)
+	}
+	if b.entries > 0 {
+		m["mean_wait"] = (b.waited / time.Duration(b.entries)).String()
+	}
+	return m
+}
+
+// SetBatchWindow sets the window for which entries of the given consistency
+// domain are held, so that they are written to the Raft log together. A
+// window of zero disables batching. Only the Leader writes entries, but the
+// window takes effect on this node alone.
+func (s *Store) SetBatchWindow(domain string, window time.Duration) error {
+	if err := checkBatchWindow(domain, window); err != nil {
+		return err
+	}
+	s.batchers[domain].setWindow(window)
+	s.logger.Printf("batching window for %s set to %s", domain, window)
+	return nil
+}
+
+// BatchStats returns the batching window of each consistency domain, and
+// the effect of batching on it.
+func (s *Store) BatchStats() map[string]interface{} {
+	m := make(map[string]interface{}, len(s.batchers))
+	for d, b := range s.batchers {
+		m[d] = b.stats()
+	}
+	return m
+}
+
+// batchWait waits until the batch of the given domain, which the caller
+// joins, is released.
+func (s *Store) batchWait(domain string) {
+	s.batchers[domain].wait()
+}
@@ -0,0 +1,82 @@
+package store
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_ParseBatchWindows(t *testing.T) {
+	windows, err := ParseBatchWindows("writes=2ms, strong_reads=0s")
+	if err != nil {
+		t.Fatalf("failed to parse batching windows: %s", err)
+	}
+	exp := map[string]time.Duration{
+		BatchWrites:      2 * time.Millisecond,
+		BatchStrongReads: 0,
+	}
+	if !reflect.DeepEqual(exp, windows) {
+		t.Fatalf("wrong batching windows, exp %v, got %v", exp, windows)
+	}
+	if windows, err := ParseBatchWindows(""); err != nil || len(windows) != 0 {
+		t.Fatalf("wrong result for no batching windows: %v, %v", windows, err)
+	}
+
+	for _, s := range []string{"writes", "writes=x", "writes=-1ms", "writes=1s", "reads=1ms"} {
+		if _, err := ParseBatchWindows(s); err == nil {
+			t.Fatalf("parsed invalid batching window %q", s)
+		}
+	}
+	if _, err := ParseBatchWindows("reads=1ms"); !errors.Is(err, ErrUnknownBatchDomain) {
+		t.Fatalf("expected ErrUnknownBatchDomain, got %v", err)
+	}
+}
+
+func Test_Batcher(t *testing.T) {
+	b := &batcher{}
+
+	// With no window, entries are never held.
+	b.wait()
+	if st := b.stats(); st["batches"] != uint64(0) || st["entries"] != uint64(0) {
+		t.Fatalf("entries batched with no window: %v", st)
+	}
+
+	b.setWindow(20 * time.Millisecond)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.wait()
+		}()
+	}
+	wg.Wait()
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Fatalf("batch released before its window closed, after %s", d)
+	}
+	st := b.stats()
+	if st["batches"] != uint64(1) || st["entries"] != uint64(3) || st["mean_batch_size"] != float64(3) {
+		t.Fatalf("wrong batching stats: %v", st)
+	}
+
+	// A full batch is released at once.
+	b.setWindow(MaxBatchWindow)
+	start = time.Now()
+	for i := 0; i < maxBatchSize; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.wait()
+		}()
+	}
+	wg.Wait()
+	if d := time.Since(start); d >= MaxBatchWindow {
+		t.Fatalf("full batch not released early, after %s", d)
+	}
+	if st := b.stats(); st["batches"] != uint64(2) {
+		t.Fatalf("wrong number of batches: %v", st["batches"])
+	}
+}
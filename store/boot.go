@@ -0,0 +1,162 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/raft"
+	"github.com/rqlite/rqlite/snapshot"
+)
+
+var (
+	// ErrNoSnapshot is returned when a snapshot is requested of a Store which
+	// has none.
+	ErrNoSnapshot = errors.New("no snapshot available")
+
+	// ErrNotNewNode is returned when a snapshot is installed in a Store which
+	// already has Raft state.
+	ErrNotNewNode = errors.New("node already has Raft state")
+)
+
+// Snapshots returns the meta of the snapshots in the Store, most recent
+// first.
+func (s *Store) Snapshots() ([]*raft.SnapshotMeta, error) {
+	if !s.open {
+		return nil, ErrNotOpen
+	}
+	return s.snapshotStore.List()
+}
+
+// LatestSnapshot opens the most recent snapshot in the Store for reading,
+// returning its meta. What is read is the stream Raft sends to install the
+// snapshot on another node. The Size of the meta is the size of the stream.
+func (s *Store) LatestSnapshot() (*raft.SnapshotMeta, io.ReadCloser, error) {
+	if !s.open {
+		return nil, nil, ErrNotOpen
+	}
+	snaps, err := s.snapshotStore.List()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(snaps) == 0 {
+		return nil, nil, ErrNoSnapshot
+	}
+	return s.snapshotStore.Open(snaps[0].ID)
+}
+
+// LatestSnapshotDB opens the SQLite database held by the most recent snapshot
+// in the Store for reading, returning the snapshot's meta. The database is
+// rebuilt from the snapshot in a temporary directory, which is removed when
+// the returned ReadCloser is closed. The Size of the meta is the size of the
+// database.
+func (s *Store) LatestSnapshotDB() (*raft.SnapshotMeta, io.ReadCloser, error) {
+	meta, rc, err := s.LatestSnapshot()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rc.Close()
+
+	strHdr, _, err := snapshot.NewStreamHeaderFromReader(rc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read snapshot stream header: %s", err)
+	}
+	fullSnap := strHdr.GetFullSnapshot()
+	if fullSnap == nil {
+		return nil, nil, fmt.Errorf("snapshot %s is not a full snapshot", meta.ID)
+	}
+
+	dir, err := os.MkdirTemp("", "rqlite-snapshot-db-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	path := filepath.Join(dir, "db.sqlite")
+	if err := snapshot.ReplayDB(fullSnap, rc, path); err != nil {
+		os.RemoveAll(dir)
+		return nil, nil, fmt.Errorf("failed to rebuild database from snapshot %s: %s", meta.ID, err)
+	}
+	fd, err := os.Open(path)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, nil, err
+	}
+	fi, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		os.RemoveAll(dir)
+		return nil, nil, err
+	}
+	meta.Size = fi.Size()
+	stats.Add(numSnapshotDBsOpened, 1)
+	return meta, &tempFile{File: fd, dir: dir}, nil
+}
+
+// InstallSnapshot installs the snapshot with the given meta, read from r, in
+// the snapshot store of a brand-new node, before the Store is opened. When
+// the Store is then opened, Raft restores the database from the snapshot, and
+// only the log entries which follow it need be replicated to this node once
+// it joins the cluster. The snapshot is typically the latest of the Leader
+// of the cluster the node is joining, and so the Raft configuration in the
+// meta is that cluster's, which does not include this node until it joins.
+func (s *Store) InstallSnapshot(meta *raft.SnapshotMeta, r io.Reader) error {
+	if s.open {
+		return ErrOpen
+	}
+	if !IsNewNode(s.logDir) {
+		return ErrNotNewNode
+	}
+
+	snapshotStore, err := snapshot.NewStore(s.snapshotDir)
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot store: %s", err)
+	}
+	if s.SnapshotCompression {
+		snapshotStore.EnableCompression()
+	}
+	snaps, err := snapshotStore.List()
+	if err != nil {
+		return fmt.Errorf("failed to list snapshots: %s", err)
+	}
+	if len(snaps) > 0 {
+		return ErrNotNewNode
+	}
+
+	sink, err := snapshotStore.Create(meta.Version, meta.Index, meta.Term, meta.Configuration,
+		meta.ConfigurationIndex, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot: %s", err)
+	}
+	n, err := io.Copy(sink, r)
+	if err != nil {
+		sink.Cancel()
+		return fmt.Errorf("failed to write snapshot: %s", err)
+	}
+	if meta.Size > 0 && n != meta.Size {
+		sink.Cancel()
+		return fmt.Errorf("snapshot is %d bytes, expected %d", n, meta.Size)
+	}
+	if err := sink.Close(); err != nil {
+		return fmt.Errorf("failed to finalize snapshot: %s", err)
+	}
+	stats.Add(numSnapshotsInstalled, 1)
+	s.logger.Printf("installed snapshot %s (index %d, term %d, %d bytes)", meta.ID, meta.Index, meta.Term, n)
+	return nil
+}
+
+// tempFile is a file in a temporary directory, which is removed when the
+// file is closed.
+type tempFile struct {
+	*os.File
+	dir string
+}
+
+// Close closes the file and removes its directory.
+func (t *tempFile) Close() error {
+	err := t.File.Close()
+	if rerr := os.RemoveAll(t.dir); err == nil {
+		err = rerr
+	}
+	return err
+}
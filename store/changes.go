@@ -0,0 +1,258 @@
+package store
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync/atomic"
+
+	"github.com/hashicorp/raft"
+	"github.com/rqlite/rqlite/command"
+)
+
+var (
+	// ErrFenced is returned when a write is attempted on a fenced cluster.
+	ErrFenced = errors.New("cluster is fenced")
+
+	// ErrStandby is returned when a write is attempted on a node of a warm
+	// standby cluster, other than by the standby consumer itself.
+	ErrStandby = errors.New("cluster is a warm standby")
+
+	// ErrChangesUnavailable is returned when the requested changes can no
+	// longer be read from the Raft log, either because the log has been
+	// truncated or because the database was loaded from a file. Consumers
+	// must resync from a full copy of the database.
+	ErrChangesUnavailable = errors.New("changes unavailable")
+)
+
+// fencedKey is the key in the stable store recording that this node has
+// applied a fence command, and by whom, so that the fence applies as soon as
+// the node restarts, before its log is replayed. The state table, which
+// snapshots include, records the fence for the cluster.
+var fencedKey = []byte("rqlite_fenced_by")
+
+// Change is a committed change to the database, as read from the Raft log.
+type Change struct {
+	// Index is the index of the change in the Raft log.
+	Index uint64 `json:"index"`
+
+	// Data is the marshaled command.Command. Its type is always Execute
+	// or ExecuteQuery.
+	Data []byte `json:"data"`
+}
+
+// Changes returns up to max changes to the database, in log order, with an
+// index greater than since. It also returns the index of the last log entry
+// examined, which the caller should pass as since on its next call. Changes
+// are only returned once they have been applied to the database on this node,
+// so any node may serve them.
+func (s *Store) Changes(since uint64, max int) ([]*Change, uint64, error) {
+	if !s.open {
+		return nil, 0, ErrNotOpen
+	}
+
+	applied := atomic.LoadUint64(&s.changesIndex)
+	if since >= applied {
+		return nil, since, nil
+	}
+
+	first, err := s.raftLog.FirstIndex()
+	if err != nil {
+		return nil, 0, err
+	}
+	if first == 0 || since+1 < first {
+		return nil, 0, ErrChangesUnavailable
+	}
+
+	var changes []*Change
+	next := since
+	for idx := since + 1; idx <= applied && len(changes) < max; idx++ {
+		var l raft.Log
+		if err := s.raftLog.GetLog(idx, &l); err != nil {
+			if err == raft.ErrLogNotFound {
+				return nil, 0, ErrChangesUnavailable
+			}
+			return nil, 0, err
+		}
+		if l.Type == raft.LogCommand {
+			var c command.Command
+			if err := command.Unmarshal(l.Data, &c); err != nil {
+				return nil, 0, err
+			}
+			switch c.Type {
+			case command.Command_COMMAND_TYPE_EXECUTE, command.Command_COMMAND_TYPE_EXECUTE_QUERY:
+				changes = append(changes, &Change{Index: idx, Data: l.Data})
+			case command.Command_COMMAND_TYPE_LOAD, command.Command_COMMAND_TYPE_LOAD_CHUNK:
+				// The database was replaced wholesale. Return any changes before
+				// it, so the consumer learns it must resync on its next call.
+				if len(changes) > 0 {
+					return changes, next, nil
+				}
+				return nil, 0, ErrChangesUnavailable
+			case command.Command_COMMAND_TYPE_EXECUTE_CHUNK:
+				// A very large execute request, split across many entries.
+				// Like a load, it is cheaper for the consumer to resync than
+				// to reassemble it.
+				if len(changes) > 0 {
+					return changes, next, nil
+				}
+				return nil, 0, ErrChangesUnavailable
+			}
+		}
+		next = idx
+	}
+	return changes, next, nil
+}
+
+// BackupWithIndex writes a consistent binary copy of the database to dst,
+// and returns the index of the last log entry reflected in that copy. A
+// consumer of Changes can resync using this copy, and then continue from
+// the returned index.
+func (s *Store) BackupWithIndex(dst io.Writer) (uint64, error) {
+	if !s.open {
+		return 0, ErrNotOpen
+	}
+
+	f, err := os.CreateTemp("", "rqlite-changes-*")
+	if err != nil {
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+
+	s.changesMu.Lock()
+	idx := atomic.LoadUint64(&s.changesIndex)
+	err = s.db.Backup(f.Name())
+	s.changesMu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	of, err := os.Open(f.Name())
+	if err != nil {
+		return 0, err
+	}
+	defer of.Close()
+	if _, err := io.Copy(dst, of); err != nil {
+		return 0, err
+	}
+	stats.Add(numBackups, 1)
+	return idx, nil
+}
+
+// ApplyChange executes a change consumed from a primary cluster. Unlike
+// Execute, it is permitted while the node is in standby mode.
+func (s *Store) ApplyChange(ex *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+	if !s.open {
+		return nil, ErrNotOpen
+	}
+	if s.raft.State() != raft.Leader {
+		return nil, ErrNotLeader
+	}
+	if !s.Ready() {
+		return nil, ErrNotReady
+	}
+	return s.execute(ex)
+}
+
+// ApplyResync replaces the database with a copy taken from a primary
+// cluster. Unlike Load, it is permitted while the node is in standby mode.
+func (s *Store) ApplyResync(lr *command.LoadRequest) error {
+	if !s.open {
+		return ErrNotOpen
+	}
+	if !s.Ready() {
+		return ErrNotReady
+	}
+	return s.load(lr)
+}
+
+// SetStandby sets whether the node is part of a warm standby cluster. While
+// in standby mode, all writes other than those made through ApplyChange and
+// ApplyResync are rejected with ErrStandby.
+func (s *Store) SetStandby(b bool) {
+	s.writableMu.Lock()
+	defer s.writableMu.Unlock()
+	s.standby = b
+}
+
+// Standby returns whether the node is in standby mode.
+func (s *Store) Standby() bool {
+	s.writableMu.RLock()
+	defer s.writableMu.RUnlock()
+	return s.standby
+}
+
+// checkWritable returns an error if writes are not currently permitted,
+// because the cluster is fenced or in standby mode.
+func (s *Store) checkWritable() error {
+	s.writableMu.RLock()
+	defer s.writableMu.RUnlock()
+	if s.fencedBy != "" {
+		return ErrFenced
+	}
+	if s.standby {
+		return ErrStandby
+	}
+	return nil
+}
+
+// Fence fences the cluster, so that all subsequent writes are rejected with
+// ErrFenced. The fence is sent through the Raft log, so it takes effect on
+// every node, and is persisted by each node that applies it. id identifies
+// who fenced the cluster, usually a standby cluster which has been promoted.
+// A fenced cluster cannot be unfenced, and should be rebuilt.
+func (s *Store) Fence(id string) error {
+	if !s.open {
+		return ErrNotOpen
+	}
+	if id == "" {
+		return errors.New("fence ID must be set")
+	}
+
+	b, err := command.MarshalFenceRequest(&command.FenceRequest{Id: id})
+	if err != nil {
+		return err
+	}
+	c := &command.Command{
+		Type:       command.Command_COMMAND_TYPE_FENCE,
+		SubCommand: b,
+	}
+	bc, err := command.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	af := s.raftApply(bc)
+	if af.Error() != nil {
+		if af.Error() == raft.ErrNotLeader {
+			return ErrNotLeader
+		}
+		return af.Error()
+	}
+	return af.Response().(*fsmGenericResponse).error
+}
+
+// Fenced returns whether this node has applied a fence, and if so, who
+// fenced it.
+func (s *Store) Fenced() (bool, string) {
+	s.writableMu.RLock()
+	defer s.writableMu.RUnlock()
+	return s.fencedBy != "", s.fencedBy
+}
+
+// setFenced records that the cluster was fenced by id.
+func (s *Store) setFenced(id string) error {
+	if err := s.setState(stateKeyFencedBy, id); err != nil {
+		return err
+	}
+	s.writableMu.Lock()
+	defer s.writableMu.Unlock()
+	if s.fencedBy == "" {
+		s.logger.Printf("cluster fenced by %s, writes will be rejected", id)
+	}
+	s.fencedBy = id
+	return s.raftStable.Set(fencedKey, []byte(id))
+}
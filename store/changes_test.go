@@ -0,0 +1,335 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rqlite/rqlite/command"
+	sql "github.com/rqlite/rqlite/db"
+)
+
+func Test_SingleNodeChanges(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	stmts := []string{
+		`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`,
+		`INSERT INTO foo(id, name) VALUES(1, "fiona")`,
+		`INSERT INTO foo(id, name) VALUES(2, "declan")`,
+	}
+	for _, stmt := range stmts {
+		if _, err := s.Execute(executeRequestFromString(stmt, false, false)); err != nil {
+			t.Fatalf("failed to execute on single node: %s", err.Error())
+		}
+	}
+	// Reads are not changes.
+	if _, err := s.Query(queryRequestFromString("SELECT * FROM foo", false, false)); err != nil {
+		t.Fatalf("failed to query single node: %s", err.Error())
+	}
+
+	changes, next, err := s.Changes(0, 2)
+	if err != nil {
+		t.Fatalf("failed to get changes: %s", err.Error())
+	}
+	if len(changes) != 2 {
+		t.Fatalf("wrong number of changes, exp 2, got %d", len(changes))
+	}
+	if next != changes[1].Index {
+		t.Fatalf("wrong next index, exp %d, got %d", changes[1].Index, next)
+	}
+	if got := mustChangeSQL(t, changes[0]); got != stmts[0] {
+		t.Fatalf("wrong first change, exp %s, got %s", stmts[0], got)
+	}
+
+	changes, next, err = s.Changes(next, 100)
+	if err != nil {
+		t.Fatalf("failed to get changes: %s", err.Error())
+	}
+	if len(changes) != 1 {
+		t.Fatalf("wrong number of changes, exp 1, got %d", len(changes))
+	}
+	if got := mustChangeSQL(t, changes[0]); got != stmts[2] {
+		t.Fatalf("wrong last change, exp %s, got %s", stmts[2], got)
+	}
+
+	changes, last, err := s.Changes(next, 100)
+	if err != nil {
+		t.Fatalf("failed to get changes: %s", err.Error())
+	}
+	if len(changes) != 0 || last < next {
+		t.Fatalf("expected no changes, got %d, next index %d", len(changes), last)
+	}
+
+	var buf bytes.Buffer
+	idx, err := s.BackupWithIndex(&buf)
+	if err != nil {
+		t.Fatalf("failed to backup with index: %s", err.Error())
+	}
+	if idx < next {
+		t.Fatalf("backup index %d is before last change at %d", idx, next)
+	}
+	if buf.Len() == 0 {
+		t.Fatalf("backup is empty")
+	}
+}
+
+func Test_SingleNodeFence(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	if _, err := s.Execute(executeRequestFromString(`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`,
+		false, false)); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+	if fenced, _ := s.Fenced(); fenced {
+		t.Fatalf("store is fenced before fence applied")
+	}
+
+	if err := s.Fence("standby-1"); err != nil {
+		t.Fatalf("failed to fence store: %s", err.Error())
+	}
+	if fenced, by := s.Fenced(); !fenced || by != "standby-1" {
+		t.Fatalf("store not fenced correctly, fenced %v by %s", fenced, by)
+	}
+
+	_, err := s.Execute(executeRequestFromString(`INSERT INTO foo(id, name) VALUES(1, "fiona")`, false, false))
+	if err != ErrFenced {
+		t.Fatalf("expected ErrFenced for execute, got %v", err)
+	}
+	eqr := executeQueryRequestFromStrings([]string{`INSERT INTO foo(id, name) VALUES(1, "fiona")`},
+		command.QueryRequest_QUERY_REQUEST_LEVEL_WEAK, false, false)
+	if _, err := s.Request(eqr); err != ErrFenced {
+		t.Fatalf("expected ErrFenced for request, got %v", err)
+	}
+
+	// Reads are still allowed.
+	eqr = executeQueryRequestFromStrings([]string{`SELECT * FROM foo`},
+		command.QueryRequest_QUERY_REQUEST_LEVEL_WEAK, false, false)
+	if _, err := s.Request(eqr); err != nil {
+		t.Fatalf("failed to read from fenced store: %s", err.Error())
+	}
+
+	// The fence survives a restart.
+	if err := s.Close(true); err != nil {
+		t.Fatalf("failed to close single-node store: %s", err.Error())
+	}
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to reopen single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if fenced, by := s.Fenced(); !fenced || by != "standby-1" {
+		t.Fatalf("store not fenced after restart, fenced %v by %s", fenced, by)
+	}
+}
+
+func Test_SingleNodeStandbyMode(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	s.SetStandby(true)
+	if !s.Standby() {
+		t.Fatalf("store not in standby mode")
+	}
+	er := executeRequestFromString(`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`, false, false)
+	if _, err := s.Execute(er); err != ErrStandby {
+		t.Fatalf("expected ErrStandby for execute, got %v", err)
+	}
+	if _, err := s.ApplyChange(er); err != nil {
+		t.Fatalf("failed to apply change in standby mode: %s", err.Error())
+	}
+
+	s.SetStandby(false)
+	er = executeRequestFromString(`INSERT INTO foo(id, name) VALUES(1, "fiona")`, false, false)
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute after leaving standby mode: %s", err.Error())
+	}
+}
+
+func mustChangeSQL(t *testing.T, c *Change) string {
+	t.Helper()
+	var cmd command.Command
+	if err := command.Unmarshal(c.Data, &cmd); err != nil {
+		t.Fatalf("failed to unmarshal change: %s", err.Error())
+	}
+	var er command.ExecuteRequest
+	if err := command.UnmarshalSubCommand(&cmd, &er); err != nil {
+		t.Fatalf("failed to unmarshal change subcommand: %s", err.Error())
+	}
+	return er.Request.Statements[0].Sql
+}
+
+func Test_SingleNodeFenceInstallSnapshot(t *testing.T) {
+	s0, ln0 := mustNewStore(t)
+	defer ln0.Close()
+	if err := s0.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s0.Close(true)
+	if err := s0.Bootstrap(NewServer(s0.ID(), s0.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	if _, err := s0.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+	if err := s0.Fence("standby-1"); err != nil {
+		t.Fatalf("failed to fence store: %s", err.Error())
+	}
+	state, err := s0.readState()
+	if err != nil {
+		t.Fatalf("failed to read state: %s", err.Error())
+	}
+	if state[stateKeyFencedBy] != "standby-1" {
+		t.Fatalf("fence not recorded in state table, got %v", state)
+	}
+	if err := s0.raft.Snapshot().Error(); err != nil {
+		t.Fatalf("failed to snapshot store: %s", err.Error())
+	}
+
+	// A node which catches up by installing the snapshot is fenced too, even
+	// though it never applied the log entry which fenced the cluster.
+	meta, rc, err := s0.LatestSnapshot()
+	if err != nil {
+		t.Fatalf("failed to open latest snapshot: %s", err.Error())
+	}
+	defer rc.Close()
+	s1, ln1 := mustNewStore(t)
+	defer ln1.Close()
+	if err := s1.InstallSnapshot(meta, rc); err != nil {
+		t.Fatalf("failed to install snapshot: %s", err.Error())
+	}
+	if err := s1.Open(); err != nil {
+		t.Fatalf("failed to open store: %s", err.Error())
+	}
+	defer s1.Close(true)
+	if fenced, by := s1.Fenced(); !fenced || by != "standby-1" {
+		t.Fatalf("store not fenced after installing snapshot, fenced %v by %s", fenced, by)
+	}
+}
+
+func Test_SingleNodeFenceLoad(t *testing.T) {
+	openLeader := func() (*Store, func()) {
+		s, ln := mustNewStore(t)
+		if err := s.Open(); err != nil {
+			t.Fatalf("failed to open single-node store: %s", err.Error())
+		}
+		if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+			t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+		}
+		if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+			t.Fatalf("Error waiting for leader: %s", err)
+		}
+		return s, func() {
+			s.Close(true)
+			ln.Close()
+		}
+	}
+
+	// Take a database from a fenced cluster.
+	s0, close0 := openLeader()
+	defer close0()
+	if err := s0.Fence("standby-1"); err != nil {
+		t.Fatalf("failed to fence store: %s", err.Error())
+	}
+	fencedDB, err := s0.Database(false)
+	if err != nil {
+		t.Fatalf("failed to read database: %s", err.Error())
+	}
+
+	// Loading it into an unfenced cluster does not fence that cluster, now
+	// or once the database is installed from a snapshot.
+	s1, close1 := openLeader()
+	defer close1()
+	if err := s1.Load(&command.LoadRequest{Data: fencedDB}); err != nil {
+		t.Fatalf("failed to load database: %s", err.Error())
+	}
+	if fenced, _ := s1.Fenced(); fenced {
+		t.Fatalf("store fenced by loaded database")
+	}
+	state, err := s1.readState()
+	if err != nil {
+		t.Fatalf("failed to read state: %s", err.Error())
+	}
+	if _, ok := state[stateKeyFencedBy]; ok {
+		t.Fatalf("loaded fence kept in state table: %v", state)
+	}
+	if err := s1.raft.Snapshot().Error(); err != nil {
+		t.Fatalf("failed to snapshot store: %s", err.Error())
+	}
+	meta, rc, err := s1.LatestSnapshot()
+	if err != nil {
+		t.Fatalf("failed to open latest snapshot: %s", err.Error())
+	}
+	defer rc.Close()
+	s2, ln2 := mustNewStore(t)
+	defer ln2.Close()
+	if err := s2.InstallSnapshot(meta, rc); err != nil {
+		t.Fatalf("failed to install snapshot: %s", err.Error())
+	}
+	if err := s2.Open(); err != nil {
+		t.Fatalf("failed to open store: %s", err.Error())
+	}
+	defer s2.Close(true)
+	if fenced, _ := s2.Fenced(); fenced {
+		t.Fatalf("store fenced after installing snapshot of loaded database")
+	}
+}
+
+func Test_SingleNodeCheckInternal(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+	if err := s.Fence("standby-1"); err != nil {
+		t.Fatalf("failed to fence store: %s", err.Error())
+	}
+
+	if err := s.CheckInternal(executeRequestFromString(`SELECT * FROM foo`, false, false).Request.Statements); err != nil {
+		t.Fatalf("user statement rejected: %s", err.Error())
+	}
+	err := s.CheckInternal(executeRequestFromString(`DELETE FROM _rqlite_state`, false, false).Request.Statements)
+	if !errors.Is(err, sql.ErrInternalTable) {
+		t.Fatalf("statement changing state table not rejected, got %v", err)
+	}
+}
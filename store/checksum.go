@@ -0,0 +1,83 @@
+package store
+
+import (
+	"errors"
+
+	"github.com/hashicorp/raft"
+	"github.com/rqlite/rqlite/command"
+)
+
+// maxChecksums is the number of most recent checksums each node keeps.
+const maxChecksums = 16
+
+// ErrChecksumNotFound is returned when no checksum was taken at the
+// requested index, or it is no longer kept.
+var ErrChecksumNotFound = errors.New("checksum not found")
+
+type fsmChecksumResponse struct {
+	sums  map[string]string
+	error error
+}
+
+// checksum is a checksum of the database taken at a given log index.
+type checksum struct {
+	index uint64
+	sums  map[string]string
+	err   error
+}
+
+// Checksum takes a checksum of the database on every node, at the same
+// point in the log, and returns the index of that point and this node's
+// checksum, keyed by table name. The checksums of other nodes can then be
+// compared with it, using ChecksumAt on each, to detect any divergence. It
+// must be called on the Leader.
+func (s *Store) Checksum() (uint64, map[string]string, error) {
+	if !s.open {
+		return 0, nil, ErrNotOpen
+	}
+
+	b, err := command.Marshal(&command.Command{
+		Type: command.Command_COMMAND_TYPE_CHECKSUM,
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	af := s.raftApply(b)
+	if af.Error() != nil {
+		if af.Error() == raft.ErrNotLeader {
+			return 0, nil, ErrNotLeader
+		}
+		return 0, nil, af.Error()
+	}
+	r := af.Response().(*fsmChecksumResponse)
+	if r.error != nil {
+		return 0, nil, r.error
+	}
+	return af.Index(), r.sums, nil
+}
+
+// ChecksumAt returns this node's checksum of the database taken at the
+// given log index, keyed by table name. It returns ErrChecksumNotFound if no
+// checksum was taken at the index, possibly because this node has not yet
+// applied it.
+func (s *Store) ChecksumAt(idx uint64) (map[string]string, error) {
+	s.checksumsMu.Lock()
+	defer s.checksumsMu.Unlock()
+	for _, c := range s.checksums {
+		if c.index == idx {
+			return c.sums, c.err
+		}
+	}
+	return nil, ErrChecksumNotFound
+}
+
+// recordChecksum keeps the checksum taken at idx.
+func (s *Store) recordChecksum(idx uint64, r *fsmChecksumResponse) {
+	s.checksumsMu.Lock()
+	defer s.checksumsMu.Unlock()
+	s.checksums = append(s.checksums, &checksum{index: idx, sums: r.sums, err: r.error})
+	if len(s.checksums) > maxChecksums {
+		s.checksums = s.checksums[len(s.checksums)-maxChecksums:]
+	}
+	stats.Add(numChecksums, 1)
+}
@@ -0,0 +1,240 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/rqlite/rqlite/command"
+	sql "github.com/rqlite/rqlite/db"
+)
+
+const (
+	// DefaultCursorTimeout is the time a cursor may go unused before it is
+	// closed, if CursorTimeout is not set.
+	DefaultCursorTimeout = 30 * time.Second
+
+	// DefaultMaxCursors is the number of cursors which may be open at once,
+	// if MaxCursors is not set.
+	DefaultMaxCursors = 64
+)
+
+var (
+	// ErrCursorNotFound is returned when a cursor is not open, either because
+	// it never was, or because it has been closed.
+	ErrCursorNotFound = errors.New("cursor not found")
+
+	// ErrCursorStrong is returned when a cursor is requested with strong read
+	// consistency, which requires the query to go through the Raft log.
+	ErrCursorStrong = errors.New("cursors do not support strong read consistency")
+
+	// ErrCursorStatements is returned when a cursor is requested for other
+	// than exactly one statement.
+	ErrCursorStatements = errors.New("a cursor must be opened for exactly one query")
+
+	// ErrTooManyCursors is returned when a cursor is requested while the
+	// maximum number are open.
+	ErrTooManyCursors = errors.New("too many open cursors")
+)
+
+// openCursor is a cursor held by the Store between requests for its pages.
+type openCursor struct {
+	c        *sql.Cursor
+	lastUsed time.Time
+}
+
+// OpenCursor executes a query, returning the first page of at most n rows of
+// its results, and the ID of a cursor from which the rest are read. The ID is
+// empty if all the rows were returned. Pages are read from the database as it
+// was when the cursor was opened. Any error executing the query is returned
+// in the results.
+//
+// Open cursors are closed, so that their pages are lost, when they go unused
+// for CursorTimeout, and whenever the WAL is checkpointed, which their reads
+// would otherwise prevent. Memory used by the page is held as for
+// QueryReserved, and the caller must call release, which is never nil.
+func (s *Store) OpenCursor(qr *command.QueryRequest, n int) (string, *command.QueryRows, func(), error) {
+	noop := func() {}
+	if !s.open {
+		return "", nil, noop, ErrNotOpen
+	}
+	if qr.Level == command.QueryRequest_QUERY_REQUEST_LEVEL_STRONG {
+		return "", nil, noop, ErrCursorStrong
+	}
+	if len(qr.Request.Statements) != 1 {
+		return "", nil, noop, ErrCursorStatements
+	}
+	if err := s.checkLocalQuery(qr); err != nil {
+		return "", nil, noop, err
+	}
+
+	// Reads are excluded from checkpoints, which close all cursors.
+	s.queryTxMu.RLock()
+	defer s.queryTxMu.RUnlock()
+
+	if s.numCursors() >= s.maxCursors() {
+		return "", nil, noop, ErrTooManyCursors
+	}
+	c, err := s.db.OpenCursor(qr.Request.Statements[0])
+	if err != nil {
+		return "", &command.QueryRows{Error: err.Error()}, noop, nil
+	}
+	rows, release, err := c.Next(n)
+	if err != nil || c.Done() {
+		c.Close()
+		if err != nil {
+			return "", &command.QueryRows{Error: err.Error()}, release, nil
+		}
+		return "", rows, release, nil
+	}
+
+	id, err := newID()
+	if err != nil {
+		c.Close()
+		release()
+		return "", nil, noop, err
+	}
+	s.cursorsMu.Lock()
+	defer s.cursorsMu.Unlock()
+	if s.cursors == nil {
+		s.cursors = make(map[string]*openCursor)
+	}
+	s.cursors[id] = &openCursor{c: c, lastUsed: time.Now()}
+	stats.Add(numCursorsOpened, 1)
+	return id, rows, release, nil
+}
+
+// FetchCursor returns the next page of at most n rows from the cursor with
+// the given ID, and whether any rows remain. Once none remain the cursor is
+// closed. A cursor is only open on the node which opened it. The caller must
+// call release, which is never nil, once it has finished with the page, as
+// for OpenCursor.
+func (s *Store) FetchCursor(id string, n int) (*command.QueryRows, bool, func(), error) {
+	noop := func() {}
+	if !s.open {
+		return nil, false, noop, ErrNotOpen
+	}
+
+	s.queryTxMu.RLock()
+	defer s.queryTxMu.RUnlock()
+
+	// The cursor is removed while its page is read, so that it is neither
+	// read concurrently nor closed meanwhile.
+	oc := s.takeCursor(id)
+	if oc == nil {
+		return nil, false, noop, ErrCursorNotFound
+	}
+	rows, release, err := oc.c.Next(n)
+	if err != nil || oc.c.Done() {
+		oc.c.Close()
+		if err != nil {
+			return &command.QueryRows{Error: err.Error()}, false, release, nil
+		}
+		return rows, false, release, nil
+	}
+
+	s.cursorsMu.Lock()
+	defer s.cursorsMu.Unlock()
+	oc.lastUsed = time.Now()
+	s.cursors[id] = oc
+	return rows, true, release, nil
+}
+
+// CloseCursor closes the cursor with the given ID, before all its rows have
+// been read.
+func (s *Store) CloseCursor(id string) error {
+	oc := s.takeCursor(id)
+	if oc == nil {
+		return ErrCursorNotFound
+	}
+	return oc.c.Close()
+}
+
+func (s *Store) takeCursor(id string) *openCursor {
+	s.cursorsMu.Lock()
+	defer s.cursorsMu.Unlock()
+	oc, ok := s.cursors[id]
+	if !ok {
+		return nil
+	}
+	delete(s.cursors, id)
+	return oc
+}
+
+func (s *Store) numCursors() int {
+	s.cursorsMu.Lock()
+	defer s.cursorsMu.Unlock()
+	return len(s.cursors)
+}
+
+// closeCursors closes every open cursor which has not been used since
+// before, or all of them if before is zero. It returns the number closed.
+func (s *Store) closeCursors(before time.Time) int {
+	s.cursorsMu.Lock()
+	defer s.cursorsMu.Unlock()
+	n := 0
+	for id, oc := range s.cursors {
+		if !before.IsZero() && !oc.lastUsed.Before(before) {
+			continue
+		}
+		if err := oc.c.Close(); err != nil {
+			s.logger.Printf("failed to close cursor: %s", err.Error())
+		}
+		delete(s.cursors, id)
+		n++
+	}
+	return n
+}
+
+// invalidateCursors closes every open cursor, since its reads would prevent
+// the database from being checkpointed or replaced. queryTxMu must be held
+// for writing.
+func (s *Store) invalidateCursors() {
+	if n := s.closeCursors(time.Time{}); n > 0 {
+		stats.Add(numCursorsInvalidated, int64(n))
+		s.logger.Printf("closed %d open cursors", n)
+	}
+}
+
+// expireCursorsLoop periodically closes cursors which have gone unused for
+// longer than the cursor timeout.
+func (s *Store) expireCursorsLoop() chan struct{} {
+	done := make(chan struct{})
+	timeout := s.cursorTimeout()
+	go func() {
+		ticker := time.NewTicker(timeout / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				stats.Add(numCursorsExpired, int64(s.closeCursors(time.Now().Add(-timeout))))
+			case <-done:
+				return
+			}
+		}
+	}()
+	return done
+}
+
+func (s *Store) cursorTimeout() time.Duration {
+	if s.CursorTimeout > 0 {
+		return s.CursorTimeout
+	}
+	return DefaultCursorTimeout
+}
+
+func (s *Store) maxCursors() int {
+	if s.MaxCursors > 0 {
+		return s.MaxCursors
+	}
+	return DefaultMaxCursors
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
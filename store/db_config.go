@@ -1,5 +1,13 @@
 package store
 
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"path/filepath"
+)
+
 // DBConfig represents the configuration of the underlying SQLite database.
 type DBConfig struct {
 	// SQLite on-disk path
@@ -10,6 +18,57 @@ type DBConfig struct {
 
 	// Disable WAL mode if running in on-disk mode
 	DisableWAL bool `json:"disable_wal"`
+
+	// Path to the archive database, attached as ArchiveSchema, if set
+	ArchivePath string `json:"archive_path,omitempty"`
+
+	// Keep the SQLite database in memory, in a file in MemoryDir, in place
+	// of OnDiskPath. The database is rebuilt from the Raft log and snapshots
+	// each time the Store opens, so neither it nor its durability depends on
+	// the file surviving.
+	InMemory bool `json:"in_memory,omitempty"`
+
+	// Directory, on a memory-backed filesystem, in which an in-memory
+	// database is kept. If not set, DefaultMemoryDir is used.
+	MemoryDir string `json:"memory_dir,omitempty"`
+}
+
+// DefaultMemoryDir is the directory in which an in-memory database is kept,
+// if MemoryDir is not set.
+const DefaultMemoryDir = "/dev/shm"
+
+// ArchiveSchema is the schema name under which the archive database is
+// attached.
+const ArchiveSchema = "archive"
+
+// attached returns the databases to attach to the SQLite database.
+func (c *DBConfig) attached() map[string]string {
+	if c.ArchivePath == "" {
+		return nil
+	}
+	return map[string]string{ArchiveSchema: c.ArchivePath}
+}
+
+// memoryDir returns the directory in which an in-memory database is kept.
+func (c *DBConfig) memoryDir() string {
+	if c.MemoryDir != "" {
+		return c.MemoryDir
+	}
+	return DefaultMemoryDir
+}
+
+// nodeMemoryDir returns the directory in which the in-memory database of the
+// node with the given ID and data directory is kept. The directory is the
+// same each time the node opens, so that one left behind when the node was
+// not closed cleanly is reused, rather than leaked. The hash of the data
+// directory tells apart nodes on the same host which share an ID.
+func (c *DBConfig) nodeMemoryDir(id, dataDir string) string {
+	if abs, err := filepath.Abs(dataDir); err == nil {
+		dataDir = abs
+	}
+	sum := sha256.Sum256([]byte(dataDir))
+	return filepath.Join(c.memoryDir(),
+		fmt.Sprintf("rqlite-%s-%s", url.PathEscape(id), hex.EncodeToString(sum[:4])))
 }
 
 // NewDBConfig returns a new DB config instance.
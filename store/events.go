@@ -0,0 +1,263 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/rqlite/rqlite/command"
+)
+
+const (
+	// clusterEventsTable is the table holding the cluster event log. Keeping
+	// the log in the database means it is replicated, and included in
+	// snapshots, so every node can serve it, and it survives the loss of
+	// any one node. Like all rqlite tables its name carries the internal
+	// table prefix, so changes to it are not streamed to CDC subscribers.
+	clusterEventsTable = "_rqlite_cluster_events"
+
+	// clusterEventsChanLen is the number of events which may be waiting to
+	// be written to the log before further events are dropped.
+	clusterEventsChanLen = 100
+)
+
+// Types of cluster event.
+const (
+	EventLeaderChange = "leader_change"
+	EventNodeJoined   = "node_joined"
+	EventNodeRemoved  = "node_removed"
+	EventVoterSwapped = "voter_swapped"
+	EventSnapshot     = "snapshot"
+	EventRestore      = "restore"
+	EventLoad         = "load"
+)
+
+// ErrClusterEventsDisabled is returned when the cluster event log is read
+// but not enabled.
+var ErrClusterEventsDisabled = errors.New("cluster event log not enabled")
+
+// ClusterEvent is a significant event in the life of the cluster, such as a
+// change of Leader or of membership.
+type ClusterEvent struct {
+	// ID is the position of the event in the log. IDs increase with each
+	// event, so clients may read only events newer than the last they saw.
+	ID int64 `json:"id"`
+
+	// Time is when the event was recorded.
+	Time time.Time `json:"time"`
+
+	// Type is the type of the event.
+	Type string `json:"type"`
+
+	// NodeID is the ID of the node which recorded the event.
+	NodeID string `json:"node_id"`
+
+	// Message describes the event.
+	Message string `json:"message"`
+}
+
+// ClusterEvents returns at most limit events from the cluster event log,
+// oldest first, whose IDs are greater than since. If limit is zero or less,
+// all such events are returned. The log is read from the local database, so
+// may lag that of the Leader.
+func (s *Store) ClusterEvents(since int64, limit int) ([]ClusterEvent, error) {
+	if !s.open {
+		return nil, ErrNotOpen
+	}
+	if s.ClusterEventsCapacity <= 0 {
+		return nil, ErrClusterEventsDisabled
+	}
+
+	events := make([]ClusterEvent, 0)
+	exists, err := s.objectExists("table", clusterEventsTable)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return events, nil
+	}
+
+	if limit <= 0 {
+		limit = -1 // No limit.
+	}
+	rows, err := s.queryStatement(&command.Statement{
+		Sql: fmt.Sprintf("SELECT id, time, type, node_id, message FROM %s WHERE id > ? ORDER BY id LIMIT ?",
+			clusterEventsTable),
+		Parameters: []*command.Parameter{
+			{Value: &command.Parameter_I{I: since}},
+			{Value: &command.Parameter_I{I: int64(limit)}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range rows.Values {
+		p := v.Parameters
+		events = append(events, ClusterEvent{
+			ID:      p[0].GetI(),
+			Time:    time.Unix(0, p[1].GetI()).UTC(),
+			Type:    p[2].GetS(),
+			NodeID:  p[3].GetS(),
+			Message: p[4].GetS(),
+		})
+	}
+	return events, nil
+}
+
+// EventForwarder forwards requests to the Leader. It is used by nodes other
+// than the Leader to have the events they record written to the cluster
+// event log.
+type EventForwarder interface {
+	// Execute executes er on the node at the given Raft address.
+	Execute(er *command.ExecuteRequest, nodeAddr string) ([]*command.ExecuteResult, error)
+}
+
+// SetEventForwarder sets the forwarder through which events recorded while
+// this node is not the Leader are written to the cluster event log. It must
+// be called before the Store is opened. If not set, such events are dropped.
+func (s *Store) SetEventForwarder(f EventForwarder) {
+	s.eventForwarder = f
+}
+
+// recordEvent records an event in the cluster event log, if enabled. Events
+// are written by separate goroutines, so recordEvent never blocks, and may
+// be called from the FSM. Events recorded by the Leader are written by the
+// job returned by WriteClusterEvents, and those recorded by any other node
+// are forwarded to the Leader.
+func (s *Store) recordEvent(typ, format string, args ...interface{}) {
+	if s.ClusterEventsCapacity <= 0 || s.eventsCh == nil {
+		return
+	}
+	ev := ClusterEvent{
+		Time:    time.Now(),
+		Type:    typ,
+		NodeID:  s.raftID,
+		Message: fmt.Sprintf(format, args...),
+	}
+	ch := s.eventsCh
+	if s.raft == nil || s.raft.State() != raft.Leader {
+		ch = s.forwardCh
+	}
+	select {
+	case ch <- ev:
+	default:
+		stats.Add(numClusterEventsDropped, 1)
+	}
+}
+
+// WriteClusterEvents writes the events recorded by this node to the cluster
+// event log until ctx is cancelled. It is run as a leader job, so only while
+// this node is the Leader. Events recorded while this node is not the Leader
+// are forwarded to the Leader instead.
+func (s *Store) WriteClusterEvents(ctx context.Context) error {
+	if s.eventsCh == nil {
+		return nil
+	}
+	for {
+		select {
+		case ev := <-s.eventsCh:
+			if err := s.writeEvent(ev); err != nil {
+				stats.Add(numClusterEventsDropped, 1)
+				s.logger.Printf("failed to write %s event to cluster event log: %s", ev.Type, err.Error())
+				continue
+			}
+			stats.Add(numClusterEvents, 1)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// forwardEventsLoop forwards events recorded while this node is not the
+// Leader to the Leader, until the returned channel is closed.
+func (s *Store) forwardEventsLoop() chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case ev := <-s.forwardCh:
+				if err := s.forwardEvent(ev); err != nil {
+					stats.Add(numClusterEventsDropped, 1)
+					s.logger.Printf("failed to forward %s event to Leader: %s", ev.Type, err.Error())
+					continue
+				}
+				stats.Add(numClusterEvents, 1)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return done
+}
+
+// forwardEvent has the Leader append ev to the cluster event log. If this
+// node has since become the Leader, it appends ev itself.
+func (s *Store) forwardEvent(ev ClusterEvent) error {
+	if s.raft.State() == raft.Leader {
+		return s.writeEvent(ev)
+	}
+	if s.eventForwarder == nil {
+		return errors.New("no event forwarder")
+	}
+	addr, err := s.LeaderAddr()
+	if err != nil {
+		return err
+	}
+	if addr == "" {
+		return ErrNotLeader
+	}
+	results, err := s.eventForwarder.Execute(&command.ExecuteRequest{
+		Request: &command.Request{
+			Transaction: true,
+			Statements:  s.eventStatements(ev),
+		},
+	}, addr)
+	if err != nil {
+		return err
+	}
+	for _, r := range results {
+		if r.Error != "" {
+			return errors.New(r.Error)
+		}
+	}
+	return nil
+}
+
+// writeEvent appends ev to the cluster event log, through the Raft log,
+// removing the oldest events so the log holds no more than its capacity.
+func (s *Store) writeEvent(ev ClusterEvent) error {
+	return s.executeStatements(s.eventStatements(ev))
+}
+
+// eventStatements returns the statements which append ev to the cluster
+// event log.
+func (s *Store) eventStatements(ev ClusterEvent) []*command.Statement {
+	return []*command.Statement{
+		{
+			Sql: fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+id INTEGER PRIMARY KEY,
+time INTEGER NOT NULL,
+type TEXT NOT NULL,
+node_id TEXT NOT NULL,
+message TEXT NOT NULL)`, clusterEventsTable),
+		},
+		{
+			Sql: fmt.Sprintf("INSERT INTO %s(time, type, node_id, message) VALUES(?, ?, ?, ?)", clusterEventsTable),
+			Parameters: []*command.Parameter{
+				{Value: &command.Parameter_I{I: ev.Time.UnixNano()}},
+				{Value: &command.Parameter_S{S: ev.Type}},
+				{Value: &command.Parameter_S{S: ev.NodeID}},
+				{Value: &command.Parameter_S{S: ev.Message}},
+			},
+		},
+		{
+			Sql: fmt.Sprintf("DELETE FROM %s WHERE id <= (SELECT MAX(id) FROM %s) - ?",
+				clusterEventsTable, clusterEventsTable),
+			Parameters: []*command.Parameter{
+				{Value: &command.Parameter_I{I: int64(s.ClusterEventsCapacity)}},
+			},
+		},
+	}
+}
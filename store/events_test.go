@@ -0,0 +1,167 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/rqlite/rqlite/command"
+)
+
+func Test_SingleNodeClusterEvents(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+	s.ClusterEventsCapacity = 3
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.WriteClusterEvents(ctx)
+
+	waitForEvents := func(since int64, n int) []ClusterEvent {
+		t.Helper()
+		var events []ClusterEvent
+		for i := 0; i < 100; i++ {
+			var err error
+			events, err = s.ClusterEvents(since, 0)
+			if err != nil {
+				t.Fatalf("failed to get cluster events: %s", err.Error())
+			}
+			if len(events) >= n {
+				return events
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatalf("expected at least %d cluster events, got %d", n, len(events))
+		return nil
+	}
+
+	events := waitForEvents(0, 1)
+	if events[0].Type != EventLeaderChange || events[0].NodeID != s.ID() {
+		t.Fatalf("wrong first cluster event: %+v", events[0])
+	}
+
+	// The log holds no more than its capacity, dropping the oldest events.
+	for i := 0; i < 5; i++ {
+		s.recordEvent(EventLoad, "event %d", i)
+	}
+	for i := 0; i < 100; i++ {
+		events = waitForEvents(0, 1)
+		if events[len(events)-1].Message == "event 4" {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if exp, got := 3, len(events); exp != got {
+		t.Fatalf("wrong number of cluster events, exp %d, got %d", exp, got)
+	}
+	for i, ev := range events {
+		if exp := fmt.Sprintf("event %d", i+2); ev.Message != exp || ev.Type != EventLoad {
+			t.Fatalf("wrong cluster event %d, exp message %s, got %+v", i, exp, ev)
+		}
+		if i > 0 && ev.ID <= events[i-1].ID {
+			t.Fatalf("cluster event IDs not increasing: %+v", events)
+		}
+	}
+
+	// Events may be read from after a given ID, and limited in number.
+	events, err := s.ClusterEvents(events[0].ID, 1)
+	if err != nil {
+		t.Fatalf("failed to get cluster events: %s", err.Error())
+	}
+	if len(events) != 1 || events[0].Message != "event 3" {
+		t.Fatalf("wrong cluster events after ID with limit: %+v", events)
+	}
+}
+
+// storeForwarder forwards requests directly to a Store.
+type storeForwarder struct {
+	s *Store
+}
+
+func (f *storeForwarder) Execute(er *command.ExecuteRequest, nodeAddr string) ([]*command.ExecuteResult, error) {
+	if nodeAddr != f.s.Addr() {
+		return nil, fmt.Errorf("forwarded to %s, not Leader at %s", nodeAddr, f.s.Addr())
+	}
+	return f.s.Execute(er)
+}
+
+func Test_MultiNodeClusterEventsForwarded(t *testing.T) {
+	s0, ln0 := mustNewStore(t)
+	defer ln0.Close()
+	s0.ClusterEventsCapacity = 10
+	if err := s0.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s0.Close(true)
+	if err := s0.Bootstrap(NewServer(s0.ID(), s0.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	if _, err := s0.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s0.WriteClusterEvents(ctx)
+
+	s1, ln1 := mustNewStore(t)
+	defer ln1.Close()
+	s1.ClusterEventsCapacity = 10
+	s1.SetEventForwarder(&storeForwarder{s0})
+	if err := s1.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s1.Close(true)
+	if err := s0.Join(joinRequest(s1.ID(), s1.Addr(), true)); err != nil {
+		t.Fatalf("failed to join to node at %s: %s", s0.Addr(), err.Error())
+	}
+	if _, err := s1.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("failed to get leader address on follower: %s", err.Error())
+	}
+
+	// An event recorded by the follower is written to the log by the Leader,
+	// and so appears on every node.
+	s1.recordEvent(EventSnapshot, "follower event")
+	for _, s := range []*Store{s0, s1} {
+		var events []ClusterEvent
+		for i := 0; i < 100; i++ {
+			var err error
+			events, err = s.ClusterEvents(0, 0)
+			if err != nil {
+				t.Fatalf("failed to get cluster events: %s", err.Error())
+			}
+			if n := len(events); n > 0 && events[n-1].Message == "follower event" {
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		ev := events[len(events)-1]
+		if ev.Message != "follower event" || ev.Type != EventSnapshot || ev.NodeID != s1.ID() {
+			t.Fatalf("follower event not in cluster event log of node %s: %+v", s.ID(), events)
+		}
+	}
+}
+
+func Test_SingleNodeClusterEventsDisabled(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if _, err := s.ClusterEvents(0, 0); !errors.Is(err, ErrClusterEventsDisabled) {
+		t.Fatalf("expected ErrClusterEventsDisabled, got %v", err)
+	}
+}
@@ -13,6 +13,7 @@ import (
 type FSMSnapshot struct {
 	raft.FSMSnapshot
 	logger *log.Logger
+	startT time.Time // When the snapshot was started.
 }
 
 // Persist writes the snapshot to the given sink.
@@ -22,13 +23,14 @@ func (f *FSMSnapshot) Persist(sink raft.SnapshotSink) (retError error) {
 		if retError == nil {
 			dur := time.Since(startT)
 			stats.Get(snapshotPersistDuration).(*expvar.Int).Set(dur.Milliseconds())
+			stats.Get(snapshotDuration).(*expvar.Int).Set(time.Since(f.startT).Milliseconds())
 			f.logger.Printf("persisted snapshot %s in %s", sink.ID(), time.Since(startT))
 		}
 	}()
 	return f.FSMSnapshot.Persist(sink)
 }
 
-// Release is a no-op.
+// Release releases the snapshot.
 func (f *FSMSnapshot) Release() {
 	f.FSMSnapshot.Release()
 }
@@ -0,0 +1,194 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rqlite/rqlite/command"
+	sql "github.com/rqlite/rqlite/db"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// idempotencyKeysTable is the table recording the results of requests
+	// which carried an idempotency key. Keeping it in the database means it
+	// is replicated, and included in snapshots, so every node turns the same
+	// requests into no-ops.
+	idempotencyKeysTable = "_rqlite_idempotency_keys"
+
+	// DefaultIdempotencyKeysCapacity is the default number of idempotency
+	// keys recorded, after which the oldest are forgotten.
+	DefaultIdempotencyKeysCapacity = 10000
+
+	idempotencyKindExecute = "execute"
+	idempotencyKindRequest = "request"
+)
+
+// ErrIdempotencyKeyReused is returned when an idempotency key is used by a
+// request of a different kind from the request which first used it.
+var ErrIdempotencyKeyReused = errors.New("idempotency key already used by a different kind of request")
+
+// setIdempotencyKeysCapacity sets, in a request with an idempotency key, the
+// number of keys recorded by this node, so every node applying the request
+// forgets the same keys.
+func (s *Store) setIdempotencyKeysCapacity(req *command.Request) {
+	if req == nil || req.IdempotencyKey == "" {
+		return
+	}
+	req.IdempotencyKeysCapacity = int64(s.IdempotencyKeysCapacity)
+	if req.IdempotencyKeysCapacity <= 0 {
+		req.IdempotencyKeysCapacity = DefaultIdempotencyKeysCapacity
+	}
+}
+
+// executeIdempotent executes the request on db. If the request carries an
+// idempotency key which was recorded by an earlier request, it is not
+// executed, and the results of the earlier request are returned instead.
+// Otherwise the key is recorded in the same transaction as the request.
+func executeIdempotent(db *sql.DB, req *command.Request, xTime bool) ([]*command.ExecuteResult, error) {
+	if req.IdempotencyKey == "" {
+		return db.Execute(req, xTime)
+	}
+	rec, exists, err := lookupIdempotencyKey(db, req.IdempotencyKey, idempotencyKindExecute)
+	if err != nil {
+		return nil, err
+	}
+	if rec != nil {
+		stats.Add(numIdempotentDuplicates, 1)
+		return rec.ExecuteResults, nil
+	}
+
+	return db.ExecuteRecorded(req, xTime, func(results []*command.ExecuteResult) ([]*command.Statement, error) {
+		return recordIdempotencyKey(req, idempotencyKindExecute, !exists,
+			&command.IdempotencyRecord{ExecuteResults: results})
+	})
+}
+
+// requestIdempotent is the same as executeIdempotent, for requests which may
+// contain both executes and queries.
+func requestIdempotent(db *sql.DB, req *command.Request, xTime bool) ([]*command.ExecuteQueryResponse, error) {
+	if req.IdempotencyKey == "" {
+		return db.Request(req, xTime)
+	}
+	rec, exists, err := lookupIdempotencyKey(db, req.IdempotencyKey, idempotencyKindRequest)
+	if err != nil {
+		return nil, err
+	}
+	if rec != nil {
+		stats.Add(numIdempotentDuplicates, 1)
+		return rec.ExecuteQueryResponses, nil
+	}
+
+	return db.RequestRecorded(req, xTime, func(results []*command.ExecuteQueryResponse) ([]*command.Statement, error) {
+		return recordIdempotencyKey(req, idempotencyKindRequest, !exists,
+			&command.IdempotencyRecord{ExecuteQueryResponses: results})
+	})
+}
+
+// lookupIdempotencyKey returns the record of the earlier request of the given
+// kind with the key, or nil if there was none. exists is whether the table
+// recording keys exists. It is only created by the first request with a key,
+// so that databases of clusters which never use keys don't contain it.
+func lookupIdempotencyKey(db *sql.DB, key, kind string) (rec *command.IdempotencyRecord, exists bool, err error) {
+	rows, err := db.Query(&command.Request{
+		Statements: []*command.Statement{
+			{
+				Sql: "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?",
+				Parameters: []*command.Parameter{
+					{Value: &command.Parameter_S{S: idempotencyKeysTable}},
+				},
+			},
+		},
+	}, false)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(rows) != 1 || len(rows[0].Values) != 1 {
+		return nil, false, fmt.Errorf("unexpected results for idempotency keys table lookup")
+	}
+	if rows[0].Error != "" {
+		return nil, false, errors.New(rows[0].Error)
+	}
+	if rows[0].Values[0].Parameters[0].GetI() == 0 {
+		return nil, false, nil
+	}
+
+	rows, err = db.Query(&command.Request{
+		Statements: []*command.Statement{
+			{
+				Sql: fmt.Sprintf("SELECT kind, record FROM %s WHERE key = ?", idempotencyKeysTable),
+				Parameters: []*command.Parameter{
+					{Value: &command.Parameter_S{S: key}},
+				},
+			},
+		},
+	}, false)
+	if err != nil {
+		return nil, true, err
+	}
+	if len(rows) != 1 {
+		return nil, true, fmt.Errorf("unexpected results for idempotency key lookup")
+	}
+	if rows[0].Error != "" {
+		return nil, true, errors.New(rows[0].Error)
+	}
+	if len(rows[0].Values) == 0 {
+		return nil, true, nil
+	}
+
+	p := rows[0].Values[0].Parameters
+	if p[0].GetS() != kind {
+		return nil, true, ErrIdempotencyKeyReused
+	}
+	rec = &command.IdempotencyRecord{}
+	if err := proto.Unmarshal(p[1].GetY(), rec); err != nil {
+		return nil, true, err
+	}
+	return rec, true, nil
+}
+
+// recordIdempotencyKey returns the statements which record the results of
+// the request with its key, and remove the oldest keys so no more than the
+// capacity set in the request are recorded. If create is set, they first
+// create the table recording keys.
+func recordIdempotencyKey(req *command.Request, kind string, create bool,
+	rec *command.IdempotencyRecord) ([]*command.Statement, error) {
+	b, err := proto.Marshal(rec)
+	if err != nil {
+		stats.Add(numIdempotencyRecordErrors, 1)
+		return nil, err
+	}
+	capacity := req.IdempotencyKeysCapacity
+	if capacity <= 0 {
+		// The request was written to the log by a version which did not set
+		// the capacity.
+		capacity = DefaultIdempotencyKeysCapacity
+	}
+	var stmts []*command.Statement
+	if create {
+		stmts = append(stmts, &command.Statement{
+			Sql: fmt.Sprintf(`CREATE TABLE %s (
+id INTEGER PRIMARY KEY,
+key TEXT NOT NULL UNIQUE,
+kind TEXT NOT NULL,
+record BLOB NOT NULL)`, idempotencyKeysTable),
+		})
+	}
+	return append(stmts, []*command.Statement{
+		{
+			Sql: fmt.Sprintf("INSERT INTO %s(key, kind, record) VALUES(?, ?, ?)", idempotencyKeysTable),
+			Parameters: []*command.Parameter{
+				{Value: &command.Parameter_S{S: req.IdempotencyKey}},
+				{Value: &command.Parameter_S{S: kind}},
+				{Value: &command.Parameter_Y{Y: b}},
+			},
+		},
+		{
+			Sql: fmt.Sprintf("DELETE FROM %s WHERE id <= (SELECT MAX(id) FROM %s) - ?",
+				idempotencyKeysTable, idempotencyKeysTable),
+			Parameters: []*command.Parameter{
+				{Value: &command.Parameter_I{I: capacity}},
+			},
+		},
+	}...), nil
+}
@@ -0,0 +1,510 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/rqlite/rqlite/command"
+)
+
+const (
+	// partitionSetsTable is the table recording the definition of each
+	// partition set. Keeping definitions in the database means they are
+	// replicated, and included in snapshots, along with the partitions. The
+	// internal table prefix keeps changes to it out of the CDC stream.
+	partitionSetsTable = "_rqlite_partition_sets"
+
+	// DefaultPartitionMaintenanceInterval is the default interval between
+	// checks by the Leader for partitions to create or drop.
+	DefaultPartitionMaintenanceInterval = time.Minute
+
+	// maxPartitionsAhead is the largest number of partitions which may be
+	// created ahead of the current one.
+	maxPartitionsAhead = 100
+)
+
+// ErrPartitionSetNotFound is returned when a partition set does not exist.
+var ErrPartitionSetNotFound = errors.New("partition set not found")
+
+// PartitionSet defines a set of tables, the partitions, each holding the rows
+// of a time series whose times fall within a fixed interval. The partitions
+// are queried through a view, which also routes inserted rows into the
+// partition for their time. While this node is the Leader it creates
+// partitions ahead of time, and drops partitions once they expire, through
+// the Raft log.
+type PartitionSet struct {
+	// Name is the name of the view over the partitions. Each partition is
+	// named by appending _p and the Unix time at which its interval starts.
+	Name string `json:"name"`
+
+	// Columns is the definition of the columns, and any table constraints,
+	// of each partition.
+	Columns string `json:"columns"`
+
+	// TimeColumn is the column holding the time of each row, in seconds
+	// since the Unix epoch, which decides the partition holding the row.
+	TimeColumn string `json:"time_column"`
+
+	// Interval is the length of time covered by each partition, in seconds.
+	Interval int64 `json:"interval"`
+
+	// Retention is how long, in seconds, a partition is kept after its
+	// interval ends. If zero, partitions are never dropped.
+	Retention int64 `json:"retention,omitempty"`
+
+	// Ahead is the number of partitions kept ahead of the current one.
+	Ahead int `json:"ahead,omitempty"`
+
+	// Partitions are the names of the partitions of the set, oldest first.
+	// It is only set by PartitionSets.
+	Partitions []string `json:"partitions,omitempty"`
+}
+
+func (p *PartitionSet) validate() error {
+	if strings.TrimSpace(p.Name) == "" {
+		return errors.New("partition set name must be set")
+	}
+	if strings.TrimSpace(p.Columns) == "" {
+		return errors.New("partition set columns must be set")
+	}
+	if strings.TrimSpace(p.TimeColumn) == "" {
+		return errors.New("partition set time column must be set")
+	}
+	if p.Interval <= 0 {
+		return errors.New("partition interval must be greater than zero")
+	}
+	if p.Retention < 0 {
+		return errors.New("partition retention must not be negative")
+	}
+	if p.Ahead < 0 || p.Ahead > maxPartitionsAhead {
+		return fmt.Errorf("partitions ahead must be between 0 and %d", maxPartitionsAhead)
+	}
+	return nil
+}
+
+// CreatePartitionSet defines a new partition set, and creates its current
+// partition, any partitions ahead of it, and the view over them.
+func (s *Store) CreatePartitionSet(ps PartitionSet) error {
+	if !s.open {
+		return ErrNotOpen
+	}
+	if err := ps.validate(); err != nil {
+		return err
+	}
+	ps.Partitions = nil
+
+	s.partitionMu.Lock()
+	defer s.partitionMu.Unlock()
+
+	sets, err := s.partitionSets()
+	if err != nil {
+		return err
+	}
+	for i := range sets {
+		if sets[i].Name == ps.Name {
+			return fmt.Errorf("partition set %s already exists", ps.Name)
+		}
+	}
+	if exists, err := s.objectExists("table", ps.Name); err != nil {
+		return err
+	} else if exists {
+		return fmt.Errorf("table %s already exists", ps.Name)
+	}
+	return s.maintainPartitionSet(ps, time.Now(),
+		&command.Statement{
+			Sql: fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (name TEXT NOT NULL PRIMARY KEY, `+
+				`columns TEXT NOT NULL, time_column TEXT NOT NULL, interval INTEGER NOT NULL, `+
+				`retention INTEGER NOT NULL, ahead INTEGER NOT NULL)`, partitionSetsTable),
+		},
+		&command.Statement{
+			Sql: fmt.Sprintf(`INSERT INTO %s (name, columns, time_column, interval, retention, ahead) `+
+				`VALUES (?, ?, ?, ?, ?, ?)`, partitionSetsTable),
+			Parameters: []*command.Parameter{
+				{Value: &command.Parameter_S{S: ps.Name}},
+				{Value: &command.Parameter_S{S: ps.Columns}},
+				{Value: &command.Parameter_S{S: ps.TimeColumn}},
+				{Value: &command.Parameter_I{I: ps.Interval}},
+				{Value: &command.Parameter_I{I: ps.Retention}},
+				{Value: &command.Parameter_I{I: int64(ps.Ahead)}},
+			},
+		})
+}
+
+// DropPartitionSet drops the view over the partitions of the named set, all
+// its partitions, and its definition.
+func (s *Store) DropPartitionSet(name string) error {
+	if !s.open {
+		return ErrNotOpen
+	}
+	s.partitionMu.Lock()
+	defer s.partitionMu.Unlock()
+
+	sets, err := s.partitionSets()
+	if err != nil {
+		return err
+	}
+	var ps *PartitionSet
+	for i := range sets {
+		if sets[i].Name == name {
+			ps = &sets[i]
+		}
+	}
+	if ps == nil {
+		return ErrPartitionSetNotFound
+	}
+
+	stmts := []*command.Statement{{Sql: fmt.Sprintf("DROP VIEW IF EXISTS %s", quoteIdentifier(name))}}
+	for _, p := range ps.Partitions {
+		stmts = append(stmts, &command.Statement{Sql: fmt.Sprintf("DROP TABLE IF EXISTS %s", quoteIdentifier(p))})
+	}
+	stmts = append(stmts, &command.Statement{
+		Sql:        fmt.Sprintf("DELETE FROM %s WHERE name = ?", partitionSetsTable),
+		Parameters: []*command.Parameter{{Value: &command.Parameter_S{S: name}}},
+	})
+	if err := s.executeStatements(stmts); err != nil {
+		return fmt.Errorf("failed to drop partition set %s: %w", name, err)
+	}
+	stats.Add(numPartitionsDropped, int64(len(ps.Partitions)))
+	s.logger.Printf("dropped partition set %s and its %d partitions", name, len(ps.Partitions))
+	return nil
+}
+
+// PartitionSets returns the definition of every partition set, with its
+// current partitions.
+func (s *Store) PartitionSets() ([]PartitionSet, error) {
+	if !s.open {
+		return nil, ErrNotOpen
+	}
+	return s.partitionSets()
+}
+
+// MaintainPartitions creates the partitions of every partition set which are
+// due as of now, and drops those which have expired.
+func (s *Store) MaintainPartitions(now time.Time) error {
+	if !s.open {
+		return ErrNotOpen
+	}
+	s.partitionMu.Lock()
+	defer s.partitionMu.Unlock()
+
+	sets, err := s.partitionSets()
+	if err != nil {
+		return err
+	}
+	for _, ps := range sets {
+		if err := s.maintainPartitionSet(ps, now); err != nil {
+			return fmt.Errorf("failed to maintain partition set %s: %w", ps.Name, err)
+		}
+	}
+	return nil
+}
+
+// maintainPartitionSet creates the partitions of ps which are due as of now,
+// drops those which have expired, and recreates the view over them if they
+// changed. Any statements in pre are applied in the same transaction as the
+// new partitions are created.
+func (s *Store) maintainPartitionSet(ps PartitionSet, now time.Time, pre ...*command.Statement) error {
+	existing, err := s.partitionStarts(ps.Name)
+	if err != nil {
+		return err
+	}
+	have := make(map[int64]bool, len(existing))
+	for _, start := range existing {
+		have[start] = true
+	}
+
+	// Partitions are created before the view over them is, since the columns
+	// of the partitions are needed to route inserts.
+	stmts := pre
+	current := floorDiv(now.Unix(), ps.Interval) * ps.Interval
+	var created []int64
+	for i := 0; i <= ps.Ahead; i++ {
+		start := current + int64(i)*ps.Interval
+		if !have[start] {
+			stmts = append(stmts, &command.Statement{
+				Sql: fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)",
+					quoteIdentifier(partitionName(ps.Name, start)), ps.Columns),
+			})
+			created = append(created, start)
+		}
+	}
+	if len(stmts) > 0 {
+		if err := s.executeStatements(stmts); err != nil {
+			return err
+		}
+	}
+
+	var keep, expired []int64
+	for _, start := range existing {
+		if ps.Retention > 0 && start+ps.Interval+ps.Retention <= now.Unix() {
+			expired = append(expired, start)
+		} else {
+			keep = append(keep, start)
+		}
+	}
+	keep = append(keep, created...)
+	sort.Slice(keep, func(i, j int) bool { return keep[i] < keep[j] })
+	if len(created) == 0 && len(expired) == 0 {
+		exists, err := s.viewExists(ps.Name)
+		if err != nil || exists {
+			return err
+		}
+	}
+
+	cols, err := s.tableColumns(partitionName(ps.Name, keep[len(keep)-1]))
+	if err != nil {
+		return err
+	}
+	stmts = []*command.Statement{{Sql: fmt.Sprintf("DROP VIEW IF EXISTS %s", quoteIdentifier(ps.Name))}}
+	for _, start := range expired {
+		stmts = append(stmts, &command.Statement{
+			Sql: fmt.Sprintf("DROP TABLE IF EXISTS %s", quoteIdentifier(partitionName(ps.Name, start))),
+		})
+	}
+	stmts = append(stmts,
+		&command.Statement{Sql: partitionViewSQL(ps, keep)},
+		&command.Statement{Sql: partitionTriggerSQL(ps, keep, cols)})
+	if err := s.executeStatements(stmts); err != nil {
+		return err
+	}
+
+	stats.Add(numPartitionsCreated, int64(len(created)))
+	stats.Add(numPartitionsDropped, int64(len(expired)))
+	if len(created) > 0 || len(expired) > 0 {
+		s.logger.Printf("partition set %s: created %d partitions, dropped %d expired partitions",
+			ps.Name, len(created), len(expired))
+	}
+	return nil
+}
+
+// partitionSets returns the definition of every partition set, with its
+// current partitions.
+func (s *Store) partitionSets() ([]PartitionSet, error) {
+	exists, err := s.objectExists("table", partitionSetsTable)
+	if err != nil || !exists {
+		return nil, err
+	}
+	rows, err := s.queryStatement(&command.Statement{
+		Sql: fmt.Sprintf("SELECT name, columns, time_column, interval, retention, ahead FROM %s ORDER BY name",
+			partitionSetsTable),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sets := make([]PartitionSet, 0, len(rows.Values))
+	for _, v := range rows.Values {
+		p := v.Parameters
+		if len(p) != 6 {
+			return nil, fmt.Errorf("unexpected definition of partition set")
+		}
+		ps := PartitionSet{
+			Name:       p[0].GetS(),
+			Columns:    p[1].GetS(),
+			TimeColumn: p[2].GetS(),
+			Interval:   p[3].GetI(),
+			Retention:  p[4].GetI(),
+			Ahead:      int(p[5].GetI()),
+		}
+		starts, err := s.partitionStarts(ps.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, start := range starts {
+			ps.Partitions = append(ps.Partitions, partitionName(ps.Name, start))
+		}
+		sets = append(sets, ps)
+	}
+	return sets, nil
+}
+
+// partitionStarts returns the start of the interval of each existing
+// partition of the named set, in order.
+func (s *Store) partitionStarts(name string) ([]int64, error) {
+	prefix := partitionName(name, 0)
+	prefix = prefix[:len(prefix)-1]
+	rows, err := s.queryStatement(&command.Statement{
+		Sql: "SELECT name FROM sqlite_master WHERE type = 'table' AND substr(name, 1, ?) = ?",
+		Parameters: []*command.Parameter{
+			{Value: &command.Parameter_I{I: int64(len(prefix))}},
+			{Value: &command.Parameter_S{S: prefix}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var starts []int64
+	for _, v := range rows.Values {
+		suffix := strings.TrimPrefix(v.Parameters[0].GetS(), prefix)
+		start, err := strconv.ParseInt(suffix, 10, 64)
+		if err != nil || strconv.FormatInt(start, 10) != suffix {
+			continue
+		}
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+	return starts, nil
+}
+
+// viewExists returns whether the named view exists.
+func (s *Store) viewExists(name string) (bool, error) {
+	return s.objectExists("view", name)
+}
+
+// objectExists returns whether the schema object of the given type exists.
+func (s *Store) objectExists(typ, name string) (bool, error) {
+	rows, err := s.queryStatement(&command.Statement{
+		Sql: "SELECT COUNT(*) FROM sqlite_master WHERE type = ? AND name = ?",
+		Parameters: []*command.Parameter{
+			{Value: &command.Parameter_S{S: typ}},
+			{Value: &command.Parameter_S{S: name}},
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+	return len(rows.Values) == 1 && rows.Values[0].Parameters[0].GetI() > 0, nil
+}
+
+// tableColumns returns the names of the columns of the table.
+func (s *Store) tableColumns(table string) ([]string, error) {
+	rows, err := s.queryStatement(&command.Statement{
+		Sql:        "SELECT name FROM pragma_table_info(?) ORDER BY cid",
+		Parameters: []*command.Parameter{{Value: &command.Parameter_S{S: table}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	cols := make([]string, len(rows.Values))
+	for i, v := range rows.Values {
+		cols[i] = v.Parameters[0].GetS()
+	}
+	if len(cols) == 0 {
+		return nil, fmt.Errorf("table %s does not exist", table)
+	}
+	return cols, nil
+}
+
+// queryStatement runs a read-only statement against the local database.
+func (s *Store) queryStatement(stmt *command.Statement) (*command.QueryRows, error) {
+	rows, err := s.db.Query(&command.Request{Statements: []*command.Statement{stmt}}, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) != 1 {
+		return nil, fmt.Errorf("unexpected results for query")
+	}
+	if rows[0].Error != "" {
+		return nil, errors.New(rows[0].Error)
+	}
+	return rows[0], nil
+}
+
+// executeStatements executes the statements as a single transaction, through
+// the Raft log, returning the first error of any statement.
+func (s *Store) executeStatements(stmts []*command.Statement) error {
+	results, err := s.Execute(&command.ExecuteRequest{
+		Request: &command.Request{
+			Transaction: true,
+			Statements:  stmts,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	for _, r := range results {
+		if r.Error != "" {
+			return errors.New(r.Error)
+		}
+	}
+	return nil
+}
+
+// maintainPartitionsLoop periodically maintains the partition sets while
+// this node is the Leader, until the returned channel is closed.
+func (s *Store) maintainPartitionsLoop() chan struct{} {
+	done := make(chan struct{})
+	interval := s.PartitionMaintenanceInterval
+	if interval <= 0 {
+		interval = DefaultPartitionMaintenanceInterval
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if s.raft.State() != raft.Leader || !s.Ready() {
+					continue
+				}
+				if err := s.MaintainPartitions(time.Now()); err != nil {
+					s.logger.Printf("failed to maintain partitions: %s", err.Error())
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return done
+}
+
+// partitionName returns the name of the partition of the named set whose
+// interval starts at start.
+func partitionName(name string, start int64) string {
+	return fmt.Sprintf("%s_p%d", name, start)
+}
+
+// partitionViewSQL returns the statement creating the view over the
+// partitions of ps with the given starts.
+func partitionViewSQL(ps PartitionSet, starts []int64) string {
+	selects := make([]string, len(starts))
+	for i, start := range starts {
+		selects[i] = "SELECT * FROM " + quoteIdentifier(partitionName(ps.Name, start))
+	}
+	return fmt.Sprintf("CREATE VIEW %s AS %s", quoteIdentifier(ps.Name), strings.Join(selects, " UNION ALL "))
+}
+
+// partitionTriggerSQL returns the statement creating the trigger which
+// routes rows inserted into the view over the partitions of ps into the
+// partition for their time. Inserting a row whose time is not covered by any
+// partition fails.
+func partitionTriggerSQL(ps PartitionSet, starts []int64, cols []string) string {
+	quoted := make([]string, len(cols))
+	values := make([]string, len(cols))
+	for i, c := range cols {
+		quoted[i] = quoteIdentifier(c)
+		values[i] = "NEW." + quoteIdentifier(c)
+	}
+	tc := "NEW." + quoteIdentifier(ps.TimeColumn)
+
+	var b strings.Builder
+	conds := make([]string, len(starts))
+	for i, start := range starts {
+		conds[i] = fmt.Sprintf("(%s >= %d AND %s < %d)", tc, start, tc, start+ps.Interval)
+	}
+	fmt.Fprintf(&b, "CREATE TRIGGER %s INSTEAD OF INSERT ON %s BEGIN ",
+		quoteIdentifier(ps.Name+"_insert"), quoteIdentifier(ps.Name))
+	fmt.Fprintf(&b, "SELECT RAISE(ABORT, 'no partition for time of row') WHERE %s IS NULL OR NOT (%s); ",
+		tc, strings.Join(conds, " OR "))
+	for i, start := range starts {
+		fmt.Fprintf(&b, "INSERT INTO %s (%s) SELECT %s WHERE %s; ",
+			quoteIdentifier(partitionName(ps.Name, start)), strings.Join(quoted, ", "),
+			strings.Join(values, ", "), conds[i])
+	}
+	b.WriteString("END")
+	return b.String()
+}
+
+// floorDiv returns a divided by b, rounded down.
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
@@ -0,0 +1,131 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/rqlite/rqlite/command"
+)
+
+func Test_SingleNodePartitionSet(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	if err := s.CreatePartitionSet(PartitionSet{Name: "metrics", Columns: "ts INTEGER, value REAL"}); err == nil {
+		t.Fatalf("expected error creating partition set without time column")
+	}
+	ps := PartitionSet{
+		Name:       "metrics",
+		Columns:    "ts INTEGER NOT NULL, value REAL",
+		TimeColumn: "ts",
+		Interval:   3600,
+		Retention:  3600,
+		Ahead:      1,
+	}
+	if err := s.CreatePartitionSet(ps); err != nil {
+		t.Fatalf("failed to create partition set: %s", err.Error())
+	}
+	if err := s.CreatePartitionSet(ps); err == nil {
+		t.Fatalf("expected error creating partition set which exists")
+	}
+
+	now := time.Now().Unix()
+	current := now / 3600 * 3600
+	sets, err := s.PartitionSets()
+	if err != nil {
+		t.Fatalf("failed to get partition sets: %s", err.Error())
+	}
+	if exp, got := fmt.Sprintf(`["metrics_p%d","metrics_p%d"]`, current, current+3600), asJSON(sets[0].Partitions); len(sets) != 1 || exp != got {
+		t.Fatalf("wrong partitions, exp %s, got %s", exp, got)
+	}
+
+	checkRows := func(sql, exp string) {
+		t.Helper()
+		qr := queryRequestFromString(sql, false, false)
+		qr.Level = command.QueryRequest_QUERY_REQUEST_LEVEL_STRONG
+		r, err := s.Query(qr)
+		if err != nil {
+			t.Fatalf("failed to query single node: %s", err.Error())
+		}
+		if got := asJSON(r[0].Values); exp != got {
+			t.Fatalf("unexpected results for %s\nexp: %s\ngot: %s", sql, exp, got)
+		}
+	}
+
+	// Rows inserted through the view are routed to their partition.
+	er := executeRequestFromStrings([]string{
+		fmt.Sprintf(`INSERT INTO metrics(ts, value) VALUES(%d, 1.5)`, current),
+		fmt.Sprintf(`INSERT INTO metrics(ts, value) VALUES(%d, 2.5)`, current+3600),
+	}, false, false)
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+	checkRows("SELECT COUNT(*) FROM metrics", "[[2]]")
+	checkRows(fmt.Sprintf("SELECT value FROM metrics_p%d", current), "[[1.5]]")
+	checkRows(fmt.Sprintf("SELECT value FROM metrics_p%d", current+3600), "[[2.5]]")
+
+	// Rows with no partition are rejected.
+	er = executeRequestFromStrings([]string{
+		fmt.Sprintf(`INSERT INTO metrics(ts, value) VALUES(%d, 3.5)`, current+7200),
+	}, false, false)
+	r, err := s.Execute(er)
+	if err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+	if r[0].Error == "" {
+		t.Fatalf("expected error inserting row with no partition")
+	}
+
+	// Two hours on, a further partition is due, and the first has expired.
+	if err := s.MaintainPartitions(time.Unix(now+7200, 0)); err != nil {
+		t.Fatalf("failed to maintain partitions: %s", err.Error())
+	}
+	sets, err = s.PartitionSets()
+	if err != nil {
+		t.Fatalf("failed to get partition sets: %s", err.Error())
+	}
+	if exp, got := fmt.Sprintf(`["metrics_p%d","metrics_p%d","metrics_p%d"]`, current+3600, current+7200, current+10800),
+		asJSON(sets[0].Partitions); exp != got {
+		t.Fatalf("wrong partitions after maintenance, exp %s, got %s", exp, got)
+	}
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+	checkRows("SELECT value FROM metrics ORDER BY ts", "[[2.5],[3.5]]")
+
+	// Maintenance with nothing due changes nothing.
+	created := stats.Get(numPartitionsCreated).String()
+	if err := s.MaintainPartitions(time.Unix(now+7200, 0)); err != nil {
+		t.Fatalf("failed to maintain partitions: %s", err.Error())
+	}
+	if got := stats.Get(numPartitionsCreated).String(); got != created {
+		t.Fatalf("partitions created by maintenance with nothing due, was %s, now %s", created, got)
+	}
+
+	if err := s.DropPartitionSet("foo"); err != ErrPartitionSetNotFound {
+		t.Fatalf("expected ErrPartitionSetNotFound, got %v", err)
+	}
+	if err := s.DropPartitionSet("metrics"); err != nil {
+		t.Fatalf("failed to drop partition set: %s", err.Error())
+	}
+	sets, err = s.PartitionSets()
+	if err != nil {
+		t.Fatalf("failed to get partition sets: %s", err.Error())
+	}
+	if len(sets) != 0 {
+		t.Fatalf("expected no partition sets, got %d", len(sets))
+	}
+	checkRows("SELECT COUNT(*) FROM sqlite_master WHERE name LIKE 'metrics%'", "[[0]]")
+}
@@ -0,0 +1,253 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/hashicorp/raft"
+	"github.com/rqlite/rqlite/command"
+)
+
+// ErrPlacementViolation is returned when a node cannot join the cluster, or
+// change whether it votes, without violating the placement constraints.
+var ErrPlacementViolation = errors.New("placement constraint violation")
+
+// zonesKey is the key in the stable store recording the zone of each node,
+// as applied by this node, so that the zones are known as soon as the node
+// restarts. The state table, which snapshots include, records the zones for
+// the cluster.
+var zonesKey = []byte("rqlite_zones")
+
+// PlacementConstraints constrain how voting nodes are placed across zones.
+// Zones are learned from the nodes themselves, when they join the cluster or
+// notify other nodes during bootstrap. The zero value imposes no constraints.
+type PlacementConstraints struct {
+	// MinVotersPerZone is the minimum number of voters each known zone should
+	// have. While any zone has fewer, voters may only be added to zones below
+	// the minimum, and no voter may leave such a zone by rejoining as a
+	// non-voter.
+	MinVotersPerZone int
+
+	// MaxVotersPerZone is the maximum number of voters any zone may have.
+	MaxVotersPerZone int
+}
+
+// Enabled returns whether any constraint is set.
+func (p PlacementConstraints) Enabled() bool {
+	return p.MinVotersPerZone > 0 || p.MaxVotersPerZone > 0
+}
+
+// Zones returns the zone of each node, keyed by node ID, as known to this
+// node.
+func (s *Store) Zones() map[string]string {
+	s.zonesMu.RLock()
+	defer s.zonesMu.RUnlock()
+	zones := make(map[string]string, len(s.zones))
+	for id, z := range s.zones {
+		zones[id] = z
+	}
+	return zones
+}
+
+// PlacementViolations returns a description of each way in which the current
+// cluster configuration violates the placement constraints. Violations can
+// arise when nodes leave the cluster, or are reaped, since removals are never
+// blocked.
+func (s *Store) PlacementViolations() ([]string, error) {
+	if !s.open {
+		return nil, ErrNotOpen
+	}
+	if !s.Placement.Enabled() {
+		return nil, nil
+	}
+	cf := s.raft.GetConfiguration()
+	if err := cf.Error(); err != nil {
+		return nil, err
+	}
+
+	var violations []string
+	voters, zones := s.votersPerZone(cf.Configuration().Servers, "")
+	for _, z := range zones {
+		if z == "" {
+			if voters[z] > 0 {
+				violations = append(violations, fmt.Sprintf("%d voters have no zone", voters[z]))
+			}
+			continue
+		}
+		if s.Placement.MinVotersPerZone > 0 && voters[z] < s.Placement.MinVotersPerZone {
+			violations = append(violations, fmt.Sprintf("zone %s has %d voters, fewer than minimum of %d",
+				z, voters[z], s.Placement.MinVotersPerZone))
+		}
+		if s.Placement.MaxVotersPerZone > 0 && voters[z] > s.Placement.MaxVotersPerZone {
+			violations = append(violations, fmt.Sprintf("zone %s has %d voters, more than maximum of %d",
+				z, voters[z], s.Placement.MaxVotersPerZone))
+		}
+	}
+	return violations, nil
+}
+
+// checkPlacement checks whether the node with the given ID and address can
+// join the cluster, in the given zone and as a voter or not, without
+// violating the placement constraints. servers is the current cluster
+// configuration. A node which is already a member at the same address is
+// always allowed, since its join will be ignored.
+func (s *Store) checkPlacement(servers []raft.Server, id, addr, zone string, voter bool) error {
+	if !s.Placement.Enabled() {
+		return nil
+	}
+	for _, srv := range servers {
+		if srv.ID == raft.ServerID(id) && srv.Address == raft.ServerAddress(addr) {
+			return nil
+		}
+	}
+
+	voters, zones := s.votersPerZone(servers, id)
+	if !voter {
+		// A non-voter only violates the constraints if it is leaving a
+		// zone which cannot spare a voter.
+		wasVoter := false
+		for _, srv := range servers {
+			if srv.ID == raft.ServerID(id) && srv.Suffrage == raft.Voter {
+				wasVoter = true
+			}
+		}
+		if wasVoter && zone != "" && voters[zone] < s.Placement.MinVotersPerZone {
+			return fmt.Errorf("%w: zone %s would be left with %d voters, fewer than minimum of %d",
+				ErrPlacementViolation, zone, voters[zone], s.Placement.MinVotersPerZone)
+		}
+		return nil
+	}
+
+	if zone == "" {
+		return fmt.Errorf("%w: voters must have a zone", ErrPlacementViolation)
+	}
+	if s.Placement.MaxVotersPerZone > 0 && voters[zone] >= s.Placement.MaxVotersPerZone {
+		return fmt.Errorf("%w: zone %s already has %d voters, the maximum",
+			ErrPlacementViolation, zone, voters[zone])
+	}
+	if s.Placement.MinVotersPerZone > 0 && voters[zone] >= s.Placement.MinVotersPerZone {
+		for _, z := range zones {
+			if z != "" && z != zone && voters[z] < s.Placement.MinVotersPerZone {
+				return fmt.Errorf("%w: zone %s has %d voters, fewer than minimum of %d, so voters may only join zones below the minimum",
+					ErrPlacementViolation, z, voters[z], s.Placement.MinVotersPerZone)
+			}
+		}
+	}
+	return nil
+}
+
+// votersPerZone returns the number of voters in each zone, and the sorted
+// list of zones of all nodes, in the given configuration. The node with the
+// ID exclude, if any, is left out. Nodes of unknown zone are counted in the
+// zone "".
+func (s *Store) votersPerZone(servers []raft.Server, exclude string) (map[string]int, []string) {
+	s.zonesMu.RLock()
+	defer s.zonesMu.RUnlock()
+
+	voters := make(map[string]int)
+	for _, srv := range servers {
+		if srv.ID == raft.ServerID(exclude) {
+			continue
+		}
+		z := s.zones[string(srv.ID)]
+		if _, ok := voters[z]; !ok {
+			voters[z] = 0
+		}
+		if srv.Suffrage == raft.Voter {
+			voters[z]++
+		}
+	}
+	zones := make([]string, 0, len(voters))
+	for z := range voters {
+		zones = append(zones, z)
+	}
+	sort.Strings(zones)
+	return voters, zones
+}
+
+// recordZone records the zone of the node with the given ID, through the
+// Raft log, so that every node learns it. It is a no-op if the zone is
+// already known.
+func (s *Store) recordZone(id, zone string) error {
+	if zone == "" {
+		return nil
+	}
+	s.zonesMu.RLock()
+	known := s.zones[id] == zone
+	s.zonesMu.RUnlock()
+	if known {
+		return nil
+	}
+
+	b, err := command.MarshalZoneRequest(&command.ZoneRequest{Id: id, Zone: zone})
+	if err != nil {
+		return err
+	}
+	bc, err := command.Marshal(&command.Command{
+		Type:       command.Command_COMMAND_TYPE_ZONE,
+		SubCommand: b,
+	})
+	if err != nil {
+		return err
+	}
+
+	af := s.raftApply(bc)
+	if af.Error() != nil {
+		if af.Error() == raft.ErrNotLeader {
+			return ErrNotLeader
+		}
+		return af.Error()
+	}
+	return af.Response().(*fsmGenericResponse).error
+}
+
+// recordKnownZones records the zone of this node, and of any nodes which
+// notified this node during bootstrap. It must be called on the leader.
+func (s *Store) recordKnownZones() {
+	s.notifyMu.Lock()
+	zones := make(map[string]string, len(s.notifyingZones)+1)
+	for id, z := range s.notifyingZones {
+		zones[id] = z
+	}
+	s.notifyMu.Unlock()
+	zones[s.raftID] = s.Zone
+
+	for id, z := range zones {
+		if err := s.recordZone(id, z); err != nil {
+			s.logger.Printf("failed to record zone %s of node %s: %s", z, id, err.Error())
+		}
+	}
+}
+
+// setZone sets the zone of the node with the given ID.
+func (s *Store) setZone(id, zone string) error {
+	if err := s.setState(stateKeyZonePrefix+id, zone); err != nil {
+		return err
+	}
+	s.zonesMu.Lock()
+	defer s.zonesMu.Unlock()
+	s.zones[id] = zone
+	b, err := json.Marshal(s.zones)
+	if err != nil {
+		return err
+	}
+	return s.raftStable.Set(zonesKey, b)
+}
+
+// placementStats returns the placement status of the cluster.
+func (s *Store) placementStats() map[string]interface{} {
+	violations, err := s.PlacementViolations()
+	stats := map[string]interface{}{
+		"zone":                s.Zone,
+		"zones":               s.Zones(),
+		"min_voters_per_zone": s.Placement.MinVotersPerZone,
+		"max_voters_per_zone": s.Placement.MaxVotersPerZone,
+		"violations":          violations,
+	}
+	if err != nil {
+		stats["error"] = err.Error()
+	}
+	return stats
+}
@@ -0,0 +1,162 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/rqlite/rqlite/command"
+)
+
+func Test_CheckPlacement(t *testing.T) {
+	s := &Store{
+		zones: map[string]string{
+			"1": "a",
+			"2": "a",
+			"3": "b",
+			"4": "c",
+		},
+		Placement: PlacementConstraints{MinVotersPerZone: 1, MaxVotersPerZone: 2},
+	}
+	servers := []raft.Server{
+		{ID: "1", Address: "addr1", Suffrage: raft.Voter},
+		{ID: "2", Address: "addr2", Suffrage: raft.Voter},
+		{ID: "3", Address: "addr3", Suffrage: raft.Voter},
+		{ID: "4", Address: "addr4", Suffrage: raft.Nonvoter},
+	}
+
+	for _, tc := range []struct {
+		name  string
+		id    string
+		addr  string
+		zone  string
+		voter bool
+		ok    bool
+	}{
+		{"existing member", "1", "addr1", "a", true, true},
+		{"no zone", "5", "addr5", "", true, false},
+		{"zone at maximum", "5", "addr5", "a", true, false},
+		{"other zone below minimum", "5", "addr5", "b", true, false},
+		{"zone below minimum", "5", "addr5", "c", true, true},
+		{"new zone", "5", "addr5", "d", true, true},
+		{"non-voter", "5", "addr5", "a", false, true},
+		{"demote last voter in zone", "3", "addr3-new", "b", false, false},
+		{"demote spare voter in zone", "1", "addr1-new", "a", false, true},
+		{"promote in zone below minimum", "4", "addr4-new", "c", true, true},
+	} {
+		err := s.checkPlacement(servers, tc.id, tc.addr, tc.zone, tc.voter)
+		if tc.ok && err != nil {
+			t.Fatalf("%s: unexpected placement error: %s", tc.name, err)
+		}
+		if !tc.ok && !errors.Is(err, ErrPlacementViolation) {
+			t.Fatalf("%s: expected placement violation, got %v", tc.name, err)
+		}
+	}
+
+	s.Placement = PlacementConstraints{}
+	if err := s.checkPlacement(servers, "5", "addr5", "", true); err != nil {
+		t.Fatalf("unexpected placement error with no constraints: %s", err)
+	}
+}
+
+func Test_SingleNodePlacement(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+	s.Zone = "a"
+	s.Placement = PlacementConstraints{MinVotersPerZone: 1}
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+	testPoll(t, func() bool {
+		return s.Zones()[s.ID()] == "a"
+	}, 100*time.Millisecond, 5*time.Second)
+
+	// Once a zone with too few voters is known, voters may not join other zones.
+	err := s.Join(&command.JoinRequest{Id: "3", Address: "localhost:1003", Voter: false, Zone: "b"})
+	if err != nil {
+		t.Fatalf("failed to join non-voter in zone b: %s", err)
+	}
+	if s.Zones()["3"] != "b" {
+		t.Fatalf("zone of joined node not recorded, got %v", s.Zones())
+	}
+	violations, err := s.PlacementViolations()
+	if err != nil {
+		t.Fatalf("failed to get placement violations: %s", err)
+	}
+	if len(violations) != 1 {
+		t.Fatalf("expected 1 placement violation, got %v", violations)
+	}
+	err = s.Join(&command.JoinRequest{Id: "4", Address: "localhost:1004", Voter: true, Zone: "a"})
+	if !errors.Is(err, ErrPlacementViolation) {
+		t.Fatalf("expected placement violation, got %v", err)
+	}
+
+	// Zones survive a restart.
+	if err := s.Close(true); err != nil {
+		t.Fatalf("failed to close single-node store: %s", err.Error())
+	}
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to reopen single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if s.Zones()["3"] != "b" {
+		t.Fatalf("zone of joined node not recorded after restart, got %v", s.Zones())
+	}
+}
+
+func Test_SingleNodePlacementInstallSnapshot(t *testing.T) {
+	s0, ln0 := mustNewStore(t)
+	defer ln0.Close()
+	s0.Zone = "a"
+	if err := s0.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s0.Close(true)
+	if err := s0.Bootstrap(NewServer(s0.ID(), s0.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	if _, err := s0.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+	err := s0.Join(&command.JoinRequest{Id: "3", Address: "localhost:1003", Voter: false, Zone: "b"})
+	if err != nil {
+		t.Fatalf("failed to join non-voter in zone b: %s", err)
+	}
+	state, err := s0.readState()
+	if err != nil {
+		t.Fatalf("failed to read state: %s", err.Error())
+	}
+	if state[stateKeyZonePrefix+"3"] != "b" {
+		t.Fatalf("zone not recorded in state table, got %v", state)
+	}
+	if err := s0.raft.Snapshot().Error(); err != nil {
+		t.Fatalf("failed to snapshot store: %s", err.Error())
+	}
+
+	// A node which catches up by installing the snapshot knows the zones too.
+	meta, rc, err := s0.LatestSnapshot()
+	if err != nil {
+		t.Fatalf("failed to open latest snapshot: %s", err.Error())
+	}
+	defer rc.Close()
+	s1, ln1 := mustNewStore(t)
+	defer ln1.Close()
+	if err := s1.InstallSnapshot(meta, rc); err != nil {
+		t.Fatalf("failed to install snapshot: %s", err.Error())
+	}
+	if err := s1.Open(); err != nil {
+		t.Fatalf("failed to open store: %s", err.Error())
+	}
+	defer s1.Close(true)
+	if s1.Zones()["3"] != "b" {
+		t.Fatalf("zone of joined node not known after installing snapshot, got %v", s1.Zones())
+	}
+}
@@ -0,0 +1,183 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// readIndexWaitDelay is how often the FSM is checked while a linearizable
+// read waits for it to reach the read index. It is much shorter than
+// appliedWaitDelay since every linearizable read may wait.
+const readIndexWaitDelay = time.Millisecond
+
+// readIndexBatcher confirms that this node is the Leader on behalf of
+// linearizable reads, once per batch of reads. Reads arriving while a
+// confirmation is in progress join the next batch, since a confirmation
+// which started before a read arrived does not prove this node was Leader
+// when it did. Safe for use from multiple goroutines.
+type readIndexBatcher struct {
+	mu      sync.Mutex
+	pending *readIndexBatch
+	running bool
+
+	batches uint64
+	reads   uint64
+}
+
+// readIndexBatch is a batch of reads sharing one confirmation of leadership.
+type readIndexBatch struct {
+	done  chan struct{}
+	index uint64
+	err   error
+}
+
+// readIndex returns the read index of the batch the caller joins, as
+// returned by confirm, which is called once for the batch.
+func (b *readIndexBatcher) readIndex(confirm func() (uint64, error)) (uint64, error) {
+	b.mu.Lock()
+	if b.pending == nil {
+		b.pending = &readIndexBatch{done: make(chan struct{})}
+	}
+	batch := b.pending
+	b.reads++
+	if !b.running {
+		b.running = true
+		go b.run(confirm)
+	}
+	b.mu.Unlock()
+
+	<-batch.done
+	return batch.index, batch.err
+}
+
+// run confirms pending batches, one at a time, until none remain.
+func (b *readIndexBatcher) run(confirm func() (uint64, error)) {
+	for {
+		b.mu.Lock()
+		batch := b.pending
+		if batch == nil {
+			b.running = false
+			b.mu.Unlock()
+			return
+		}
+		b.pending = nil
+		b.batches++
+		b.mu.Unlock()
+
+		batch.index, batch.err = confirm()
+		close(batch.done)
+	}
+}
+
+// Stats returns stats on the batches of reads confirmed.
+func (b *readIndexBatcher) Stats() map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return map[string]interface{}{
+		"batches": b.batches,
+		"reads":   b.reads,
+	}
+}
+
+// readIndex returns the Raft ReadIndex for a linearizable read: an index such
+// that once every log entry up to it is reflected by the FSM, the FSM reflects
+// every write acknowledged before the read arrived. It confirms, with a quorum
+// of the cluster, that this node is still the Leader, but shares the
+// confirmation among the reads which arrive together.
+func (s *Store) readIndex() (uint64, error) {
+	if s.raft.State() != raft.Leader {
+		return 0, ErrNotLeader
+	}
+	return s.readIndexer.readIndex(func() (uint64, error) {
+		// The last index, rather than the commit index, which hashicorp/raft
+		// does not expose. It includes the entry every new Leader appends,
+		// so that the reads also wait for it to be committed.
+		idx := s.raft.LastIndex()
+		if err := s.raft.VerifyLeader().Error(); err != nil {
+			if err == raft.ErrNotLeader || err == raft.ErrLeadershipLost {
+				return 0, ErrNotLeader
+			}
+			return 0, err
+		}
+		stats.Add(numReadIndexConfirms, 1)
+		return idx, nil
+	})
+}
+
+// waitForReadIndex blocks until the FSM reflects every log entry up to and
+// including idx, or the timeout expires.
+func (s *Store) waitForReadIndex(idx uint64, timeout time.Duration) error {
+	tck := time.NewTicker(readIndexWaitDelay)
+	defer tck.Stop()
+	tmr := time.NewTimer(timeout)
+	defer tmr.Stop()
+
+	// Wait for Raft to pass every entry up to idx to the FSM. Raft does
+	// that before the FSM applies them.
+	for s.raft.AppliedIndex() < idx {
+		select {
+		case <-tck.C:
+		case <-tmr.C:
+			return ErrReadIndexTimeout
+		}
+	}
+
+	// Only commands are applied by the FSM, so wait for the last command at
+	// or before idx.
+	target, err := s.lastCommandIndex(idx)
+	if err != nil {
+		return err
+	}
+	for {
+		s.fsmIndexMu.RLock()
+		fsmIdx := s.fsmIndex
+		s.fsmIndexMu.RUnlock()
+		if fsmIdx >= target {
+			return nil
+		}
+		select {
+		case <-tck.C:
+		case <-tmr.C:
+			return ErrReadIndexTimeout
+		}
+	}
+}
+
+// lastCommandIndex returns the index of the last command log entry at or
+// before idx, which the FSM has not already applied. It returns 0 if there
+// is no such entry, including if the entries were compacted into a snapshot,
+// which the FSM must then reflect.
+func (s *Store) lastCommandIndex(idx uint64) (uint64, error) {
+	s.fsmIndexMu.RLock()
+	fsmIdx := s.fsmIndex
+	s.fsmIndexMu.RUnlock()
+
+	for ; idx > fsmIdx; idx-- {
+		var l raft.Log
+		if err := s.raftLog.GetLog(idx, &l); err != nil {
+			if errors.Is(err, raft.ErrLogNotFound) {
+				return 0, nil
+			}
+			return 0, fmt.Errorf("failed to get log entry %d: %s", idx, err)
+		}
+		if l.Type == raft.LogCommand {
+			return idx, nil
+		}
+	}
+	return 0, nil
+}
+
+// linearizableBarrier blocks until the database on this node, which must be
+// the Leader, reflects every write acknowledged before it was called, so
+// that a read served from it is linearizable.
+func (s *Store) linearizableBarrier() error {
+	idx, err := s.readIndex()
+	if err != nil {
+		return err
+	}
+	return s.waitForReadIndex(idx, s.ApplyTimeout)
+}
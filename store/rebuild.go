@@ -0,0 +1,154 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/raft"
+	rlog "github.com/rqlite/rqlite/log"
+	"github.com/rqlite/rqlite/snapshot"
+)
+
+// raftCurrentTermKey is the key under which Raft keeps the current term in
+// its stable store.
+const raftCurrentTermKey = "CurrentTerm"
+
+// RebuildNode creates the data directory of a fresh, single-node cluster from
+// the snapshot store at snapshotDir, which is typically rescued from a node
+// which can no longer be started. The latest snapshot in the store is
+// installed in the snapshot store of dataDir, with a Raft configuration made
+// up of only the node with the given ID and Raft address. The Raft term is
+// carried over, so that the node's log continues from the snapshot. When the
+// node is started using dataDir it elects itself Leader and restores the
+// database from the snapshot. Any Raft log entries which followed the
+// snapshot on the original node are lost.
+//
+// snapshotDir is not changed, since it is copied before it is read. The meta
+// of the installed snapshot is returned.
+func RebuildNode(snapshotDir, dataDir, id, addr string) (*raft.SnapshotMeta, error) {
+	if !IsNewNode(dataDir) {
+		return nil, ErrNotNewNode
+	}
+	conf := raft.Configuration{
+		Servers: []raft.Server{{
+			ID:       raft.ServerID(id),
+			Address:  raft.ServerAddress(addr),
+			Suffrage: raft.Voter,
+		}},
+	}
+	if err := checkRaftConfiguration(conf); err != nil {
+		return nil, err
+	}
+
+	// Opening a snapshot store may repair it, so work on a copy.
+	tmpDir, err := os.MkdirTemp("", "rqlite-rebuild-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+	srcDir := filepath.Join(tmpDir, snapshotsDirName)
+	if err := copyDir(snapshotDir, srcDir); err != nil {
+		return nil, fmt.Errorf("failed to copy snapshot store: %s", err)
+	}
+	srcStore, err := snapshot.NewStore(srcDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open snapshot store: %s", err)
+	}
+	snaps, err := srcStore.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %s", err)
+	}
+	if len(snaps) == 0 {
+		return nil, ErrNoSnapshot
+	}
+	meta, rc, err := srcStore.Open(snaps[0].ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open snapshot %s: %s", snaps[0].ID, err)
+	}
+	defer rc.Close()
+
+	dstStore, err := snapshot.NewStore(filepath.Join(dataDir, snapshotsDirName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create snapshot store: %s", err)
+	}
+	dstSnaps, err := dstStore.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %s", err)
+	}
+	if len(dstSnaps) > 0 {
+		return nil, ErrNotNewNode
+	}
+	sink, err := dstStore.Create(meta.Version, meta.Index, meta.Term, conf, meta.Index, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create snapshot: %s", err)
+	}
+	n, err := io.Copy(sink, rc)
+	if err != nil {
+		sink.Cancel()
+		return nil, fmt.Errorf("failed to write snapshot: %s", err)
+	}
+	if n != meta.Size {
+		sink.Cancel()
+		return nil, fmt.Errorf("snapshot is %d bytes, expected %d", n, meta.Size)
+	}
+	if err := sink.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finalize snapshot: %s", err)
+	}
+
+	// Raft starts elections from the current term, which must not be behind
+	// the term of the snapshot.
+	l, err := rlog.New(filepath.Join(dataDir, raftDBPath), false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Raft log: %s", err)
+	}
+	defer l.Close()
+	if err := l.SetUint64([]byte(raftCurrentTermKey), meta.Term); err != nil {
+		return nil, fmt.Errorf("failed to set Raft term: %s", err)
+	}
+
+	meta.Configuration = conf
+	meta.ConfigurationIndex = meta.Index
+	return meta, nil
+}
+
+// copyDir recursively copies the directory src to dst, which must not exist.
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.Mkdir(target, 0755)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		return copyFile(path, target)
+	})
+}
+
+// copyFile copies the file src to dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,75 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rqlite/rqlite/command"
+)
+
+func Test_RebuildNode(t *testing.T) {
+	s0, ln0 := mustNewStore(t)
+	defer ln0.Close()
+	if err := s0.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s0.Bootstrap(NewServer(s0.ID(), s0.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	if _, err := s0.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+	queries := []string{
+		`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`,
+		`INSERT INTO foo(id, name) VALUES(1, "fiona")`,
+		`INSERT INTO foo(id, name) VALUES(2, "fiona")`,
+	}
+	for i := range queries {
+		if _, err := s0.Execute(executeRequestFromString(queries[i], false, false)); err != nil {
+			t.Fatalf("failed to execute on single node: %s", err.Error())
+		}
+	}
+	if err := s0.raft.Snapshot().Error(); err != nil {
+		t.Fatalf("failed to snapshot single-node store: %s", err.Error())
+	}
+	if err := s0.Close(true); err != nil {
+		t.Fatalf("failed to close single-node store: %s", err.Error())
+	}
+
+	// Rebuild a new node from the first node's snapshot store.
+	s1, ln1 := mustNewStore(t)
+	defer ln1.Close()
+	if _, err := RebuildNode(t.TempDir(), s1.Path(), s1.ID(), ln1.Addr().String()); err == nil {
+		t.Fatalf("expected error rebuilding from empty directory")
+	}
+	meta, err := RebuildNode(s0.SnapshotDir(), s1.Path(), s1.ID(), ln1.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to rebuild node: %s", err.Error())
+	}
+	if exp, got := 1, len(meta.Configuration.Servers); exp != got {
+		t.Fatalf("wrong number of servers in configuration, exp %d, got %d", exp, got)
+	}
+	if _, err := RebuildNode(s0.SnapshotDir(), s1.Path(), s1.ID(), ln1.Addr().String()); err != ErrNotNewNode {
+		t.Fatalf("expected ErrNotNewNode, got %v", err)
+	}
+
+	if err := s1.Open(); err != nil {
+		t.Fatalf("failed to open rebuilt store: %s", err.Error())
+	}
+	defer s1.Close(true)
+	if _, err := s1.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+	if _, err := s1.Execute(executeRequestFromString(`INSERT INTO foo(id, name) VALUES(3, "fiona")`, false, false)); err != nil {
+		t.Fatalf("failed to execute on rebuilt node: %s", err.Error())
+	}
+	qr := queryRequestFromString("SELECT count(*) FROM foo", false, false)
+	qr.Level = command.QueryRequest_QUERY_REQUEST_LEVEL_NONE
+	r, err := s1.Query(qr)
+	if err != nil {
+		t.Fatalf("failed to query rebuilt node: %s", err.Error())
+	}
+	if exp, got := `[[3]]`, asJSON(r[0].Values); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+}
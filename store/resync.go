@@ -0,0 +1,87 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	sql "github.com/rqlite/rqlite/db"
+)
+
+var (
+	// ErrResyncLeader is returned when a resync is requested of the Leader,
+	// which has no other database to resync from.
+	ErrResyncLeader = errors.New("leader cannot be resynced")
+
+	// ErrResyncStale is returned when the copy of the database offered for a
+	// resync is older than this node's database, so a newer copy is needed.
+	ErrResyncStale = errors.New("resync copy is older than this node's database")
+)
+
+// Resync discards this node's database, and replaces it with the SQLite
+// database read from r, a copy of the Leader's database written by
+// BackupWithIndex on the Leader, which reflects every log entry up to and
+// including idx. Log entries up to idx not yet applied by this node are then
+// skipped, rather than applied again. The node remains part of the cluster
+// throughout. It returns ErrResyncStale if this node has already applied log
+// entries beyond idx.
+func (s *Store) Resync(r io.Reader, idx uint64) error {
+	if !s.open {
+		return ErrNotOpen
+	}
+	if s.IsLeader() {
+		return ErrResyncLeader
+	}
+	startT := time.Now()
+
+	// Stage the copy next to the database, so it can be moved into place.
+	f, err := os.CreateTemp(filepath.Dir(s.db.Path()), "rqlite-resync-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if !sql.IsValidSQLiteFile(f.Name()) {
+		return fmt.Errorf("resync copy is not a SQLite file")
+	}
+
+	// Block the application of log entries, and queries involving
+	// transactions, while the database is replaced.
+	s.changesMu.Lock()
+	defer s.changesMu.Unlock()
+	s.queryTxMu.Lock()
+	defer s.queryTxMu.Unlock()
+	s.invalidateCursors()
+	s.invalidateTransactions()
+
+	s.fsmIndexMu.RLock()
+	fsmIdx := s.fsmIndex
+	s.fsmIndexMu.RUnlock()
+	if fsmIdx > idx || atomic.LoadUint64(&s.changesIndex) > idx {
+		return ErrResyncStale
+	}
+
+	if err := s.replaceDatabase(f.Name()); err != nil {
+		return fmt.Errorf("resync: %s", err)
+	}
+	s.resyncIndex = idx
+	atomic.StoreUint64(&s.changesIndex, idx)
+	s.resetRowLog(idx)
+	// Any incremental snapshot would be taken against the discarded database.
+	s.fullSnapshotNeeded = true
+
+	stats.Add(numResyncs, 1)
+	s.logger.Printf("node resynced from copy of leader's database at log index %d in %s",
+		idx, time.Since(startT))
+	return nil
+}
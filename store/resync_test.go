@@ -0,0 +1,95 @@
+package store
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/rqlite/rqlite/command"
+)
+
+func Test_MultiNodeResync(t *testing.T) {
+	s0, ln0 := mustNewStore(t)
+	defer ln0.Close()
+	if err := s0.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s0.Close(true)
+	if err := s0.Bootstrap(NewServer(s0.ID(), s0.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	if _, err := s0.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	s1, ln1 := mustNewStore(t)
+	defer ln1.Close()
+	if err := s1.Open(); err != nil {
+		t.Fatalf("failed to open node for multi-node test: %s", err.Error())
+	}
+	defer s1.Close(true)
+	if err := s0.Join(joinRequest(s1.ID(), s1.Addr(), true)); err != nil {
+		t.Fatalf("failed to join to node at %s: %s", s0.Addr(), err.Error())
+	}
+
+	er := executeRequestFromStrings([]string{
+		`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`,
+		`INSERT INTO foo(id, name) VALUES(1, "fiona")`,
+	}, false, false)
+	if _, err := s0.Execute(er); err != nil {
+		t.Fatalf("failed to execute on leader: %s", err.Error())
+	}
+	idx, err := s0.WaitForAppliedFSM(5 * time.Second)
+	if err != nil {
+		t.Fatalf("failed to wait for fsmIndex: %s", err.Error())
+	}
+	if _, err := s1.WaitForFSMIndex(idx, 5*time.Second); err != nil {
+		t.Fatalf("error waiting for follower to apply index: %s", err.Error())
+	}
+
+	// Silently diverge the follower.
+	if _, err := s1.db.ExecuteStringStmt(`INSERT INTO foo(id, name) VALUES(99, "declan")`); err != nil {
+		t.Fatalf("failed to write directly to follower: %s", err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := s0.Resync(&buf, 1); err != ErrResyncLeader {
+		t.Fatalf("expected ErrResyncLeader, got %v", err)
+	}
+	copyIdx, err := s0.BackupWithIndex(&buf)
+	if err != nil {
+		t.Fatalf("failed to backup leader with index: %s", err.Error())
+	}
+	if err := s1.Resync(bytes.NewReader(buf.Bytes()), 1); err != ErrResyncStale {
+		t.Fatalf("expected ErrResyncStale, got %v", err)
+	}
+	if err := s1.Resync(&buf, copyIdx); err != nil {
+		t.Fatalf("failed to resync follower: %s", err.Error())
+	}
+
+	// Writes made after the resync must be applied on top of the copy.
+	er = executeRequestFromString(`INSERT INTO foo(id, name) VALUES(2, "fiona")`, false, false)
+	if _, err := s0.Execute(er); err != nil {
+		t.Fatalf("failed to execute on leader: %s", err.Error())
+	}
+	idx, err = s0.WaitForAppliedFSM(5 * time.Second)
+	if err != nil {
+		t.Fatalf("failed to wait for fsmIndex: %s", err.Error())
+	}
+	if _, err := s1.WaitForFSMIndex(idx, 5*time.Second); err != nil {
+		t.Fatalf("error waiting for follower to apply index: %s", err.Error())
+	}
+
+	qr := queryRequestFromString("SELECT * FROM foo", false, false)
+	qr.Level = command.QueryRequest_QUERY_REQUEST_LEVEL_NONE
+	r, err := s1.Query(qr)
+	if err != nil {
+		t.Fatalf("failed to query follower: %s", err.Error())
+	}
+	if exp, got := `[[1,"fiona"],[2,"fiona"]]`, asJSON(r[0].Values); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+	if stats.Get(numResyncs).String() == "0" {
+		t.Fatalf("resync not counted")
+	}
+}
@@ -0,0 +1,93 @@
+package store
+
+import (
+	"errors"
+	"sort"
+	"sync/atomic"
+)
+
+// ErrRowChangesMissed is returned by RowChanges when changes after the
+// given index are no longer retained, or were never recorded.
+var ErrRowChangesMissed = errors.New("row changes no longer retained")
+
+// RowChanges returns, in log order, the changes to rows made by the log
+// entries with an index greater than since, as retained by this node. The
+// changes of an entry are never split across calls, so more than max may be
+// returned. It also returns the index of the last log entry examined, which
+// the caller should pass as since on its next call.
+//
+// Up to RowChangeLogSize of the latest changes are retained, including those
+// made by entries replayed when the node starts. If changes after since have
+// been missed, because too many have been made since, because the database
+// was replaced wholesale, or because a single transaction changed more rows
+// than are reported, ErrRowChangesMissed is returned, along with the index
+// from which changes are retained.
+func (s *Store) RowChanges(since uint64, max int) ([]*RowChange, uint64, error) {
+	if !s.open {
+		return nil, 0, ErrNotOpen
+	}
+	if s.RowChangeLogSize <= 0 {
+		return nil, 0, ErrChangesUnavailable
+	}
+
+	// Only return the changes of entries which have been applied in full.
+	applied := atomic.LoadUint64(&s.changesIndex)
+
+	s.rowLogMu.Lock()
+	defer s.rowLogMu.Unlock()
+	if since < s.rowLogStart {
+		return nil, s.rowLogStart, ErrRowChangesMissed
+	}
+	if since >= applied {
+		return nil, since, nil
+	}
+
+	i := sort.Search(len(s.rowLog), func(i int) bool {
+		return s.rowLog[i].Index > since
+	})
+	var changes []*RowChange
+	for ; i < len(s.rowLog) && s.rowLog[i].Index <= applied; i++ {
+		rc := s.rowLog[i]
+		if len(changes) >= max && rc.Index != changes[len(changes)-1].Index {
+			return changes, changes[len(changes)-1].Index, nil
+		}
+		changes = append(changes, rc)
+	}
+	return changes, applied, nil
+}
+
+// logRowChanges retains row changes made while applying the log entry at
+// applyingIndex. If the changes are not complete, all retained changes are
+// discarded, so that they are reported as missed.
+func (s *Store) logRowChanges(changes []*RowChange, complete bool) {
+	if s.RowChangeLogSize <= 0 {
+		return
+	}
+	s.rowLogMu.Lock()
+	defer s.rowLogMu.Unlock()
+	if !complete {
+		s.resetRowLogLocked(s.applyingIndex)
+		return
+	}
+	s.rowLog = append(s.rowLog, changes...)
+
+	// Discard the oldest changes in bulk, so that they are not copied each
+	// time a change is added.
+	if n := len(s.rowLog) - s.RowChangeLogSize; n > s.RowChangeLogSize/4 {
+		s.rowLogStart = s.rowLog[n-1].Index
+		s.rowLog = append([]*RowChange(nil), s.rowLog[n:]...)
+	}
+}
+
+// resetRowLog discards all retained row changes, since the database was
+// replaced wholesale as of the log entry at idx.
+func (s *Store) resetRowLog(idx uint64) {
+	s.rowLogMu.Lock()
+	defer s.rowLogMu.Unlock()
+	s.resetRowLogLocked(idx)
+}
+
+func (s *Store) resetRowLogLocked(idx uint64) {
+	s.rowLog = nil
+	s.rowLogStart = idx
+}
@@ -0,0 +1,57 @@
+package store
+
+import (
+	"time"
+)
+
+// SchemaVersion returns the schema version of the database. It changes
+// whenever the schema of the database changes, so clients may cache schema
+// metadata for as long as the version they read it at is current. As the
+// version is that recorded by SQLite in the database itself, all nodes agree
+// on it once they have applied the same log entries. Clients should treat
+// any change, rather than only an increase, as invalidating their cache, as
+// loading a database from a file sets the version to that of the file.
+func (s *Store) SchemaVersion() int64 {
+	s.schemaMu.Lock()
+	defer s.schemaMu.Unlock()
+	return s.schemaVersion
+}
+
+// WaitSchemaChange waits until the schema version differs from version, the
+// timeout expires, or done is closed, and returns the schema version.
+func (s *Store) WaitSchemaChange(version int64, timeout time.Duration, done <-chan struct{}) int64 {
+	s.schemaMu.Lock()
+	v, ch := s.schemaVersion, s.schemaChangedCh
+	s.schemaMu.Unlock()
+	if v != version {
+		return v
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-ch:
+	case <-timer.C:
+	case <-done:
+	}
+	return s.SchemaVersion()
+}
+
+// updateSchemaVersion reads the schema version from the database, waking
+// anyone waiting for it to change if it has.
+func (s *Store) updateSchemaVersion() {
+	v, err := s.db.SchemaVersion()
+	if err != nil {
+		s.logger.Printf("failed to read schema version: %s", err.Error())
+		return
+	}
+	s.schemaMu.Lock()
+	defer s.schemaMu.Unlock()
+	if v == s.schemaVersion {
+		return
+	}
+	s.schemaVersion = v
+	close(s.schemaChangedCh)
+	s.schemaChangedCh = make(chan struct{})
+	stats.Add(numSchemaChanges, 1)
+}
@@ -0,0 +1,59 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_SingleNodeSchemaVersion(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	v0 := s.SchemaVersion()
+	if v := s.WaitSchemaChange(v0, 10*time.Millisecond, nil); v != v0 {
+		t.Fatalf("schema version changed without DDL, was %d, now %d", v0, v)
+	}
+
+	ch := make(chan int64)
+	go func() {
+		ch <- s.WaitSchemaChange(v0, 10*time.Second, nil)
+	}()
+	er := executeRequestFromString(`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`, false, false)
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+	select {
+	case v1 := <-ch:
+		if v1 == v0 {
+			t.Fatalf("waiter woken without schema version changing")
+		}
+		if v1 != s.SchemaVersion() {
+			t.Fatalf("waiter returned wrong schema version, exp %d, got %d", s.SchemaVersion(), v1)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timed out waiting for schema change")
+	}
+
+	v1 := s.SchemaVersion()
+	er = executeRequestFromString(`INSERT INTO foo(id, name) VALUES(1, "fiona")`, false, false)
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+	if v := s.SchemaVersion(); v != v1 {
+		t.Fatalf("schema version changed by DML, was %d, now %d", v1, v)
+	}
+	if v := s.WaitSchemaChange(v0, time.Second, nil); v != v1 {
+		t.Fatalf("expected immediate return of changed schema version %d, got %d", v1, v)
+	}
+}
@@ -0,0 +1,142 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// ErrSelfCheckFailed is returned when the startup self-check finds the state
+// of the Store to be inconsistent.
+var ErrSelfCheckFailed = errors.New("startup self-check failed")
+
+const (
+	selfCheckPending = "pending"
+	selfCheckOK      = "ok"
+	selfCheckFailed  = "failed"
+)
+
+// selfCheck is the state of the startup self-check.
+type selfCheck struct {
+	mu     sync.Mutex
+	status string
+	err    error
+	dur    time.Duration
+}
+
+func (c *selfCheck) set(status string, err error, dur time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.status = status
+	c.err = err
+	c.dur = dur
+}
+
+func (c *selfCheck) stats() map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m := map[string]interface{}{
+		"status":   c.status,
+		"duration": c.dur.String(),
+	}
+	if c.err != nil {
+		m["error"] = c.err.Error()
+	}
+	return m
+}
+
+// checkLogConsistency checks that the Raft log and the snapshots are
+// consistent with each other, and with the applied index recorded on the
+// log, so that the database can be rebuilt from them.
+func (s *Store) checkLogConsistency() error {
+	snaps, err := s.snapshotStore.List()
+	if err != nil {
+		return fmt.Errorf("list snapshots: %s", err)
+	}
+	var snapIdx uint64
+	if len(snaps) > 0 {
+		snapIdx = snaps[0].Index
+	}
+	first, last, err := s.boltStore.Indexes()
+	if err != nil {
+		return fmt.Errorf("get log indexes: %s", err)
+	}
+	applied, err := s.boltStore.GetAppliedIndex()
+	if err != nil {
+		return fmt.Errorf("get applied index: %s", err)
+	}
+
+	if last != 0 {
+		// Any entries not in the latest snapshot must be in the log.
+		if first > snapIdx+1 {
+			return fmt.Errorf("%w: log starts at index %d, but latest snapshot is at index %d",
+				ErrSelfCheckFailed, first, snapIdx)
+		}
+		var l raft.Log
+		for _, idx := range []uint64{first, last} {
+			if err := s.boltStore.GetLog(idx, &l); err != nil {
+				return fmt.Errorf("%w: failed to read log at index %d: %s", ErrSelfCheckFailed, idx, err)
+			}
+		}
+	}
+
+	// Entries recorded as applied must not have been lost.
+	maxIdx := last
+	if snapIdx > maxIdx {
+		maxIdx = snapIdx
+	}
+	if applied > maxIdx {
+		return fmt.Errorf("%w: applied index %d is beyond last log index %d and latest snapshot index %d",
+			ErrSelfCheckFailed, applied, last, snapIdx)
+	}
+	return nil
+}
+
+// checkDatabase waits for the log entries present when the Store opened to be
+// applied, and then checks the integrity of the database. The Store only
+// becomes ready if the database passes the check.
+func (s *Store) checkDatabase(start time.Time, readyCh chan struct{}, done <-chan struct{}) {
+	tck := time.NewTicker(appliedWaitDelay)
+	defer tck.Stop()
+	for {
+		s.fsmIndexMu.RLock()
+		fsmIdx := s.fsmIndex
+		s.fsmIndexMu.RUnlock()
+		if fsmIdx >= s.lastCommandIdxOnOpen {
+			break
+		}
+		select {
+		case <-tck.C:
+		case <-done:
+			// Don't leave the Store unready if it is opened again.
+			close(readyCh)
+			return
+		}
+	}
+
+	err := func() error {
+		rows, err := s.db.QueryStringStmt("PRAGMA quick_check")
+		if err != nil {
+			return fmt.Errorf("%w: quick check: %s", ErrSelfCheckFailed, err)
+		}
+		if len(rows) != 1 || rows[0].Error != "" {
+			return fmt.Errorf("%w: quick check failed to run", ErrSelfCheckFailed)
+		}
+		if len(rows[0].Values) != 1 || len(rows[0].Values[0].Parameters) != 1 ||
+			rows[0].Values[0].Parameters[0].GetS() != "ok" {
+			return fmt.Errorf("%w: database failed quick check", ErrSelfCheckFailed)
+		}
+		return nil
+	}()
+	if err != nil {
+		s.selfCheck.set(selfCheckFailed, err, time.Since(start))
+		s.logger.Printf("%s, refusing to serve requests", err.Error())
+		return
+	}
+	s.selfCheck.set(selfCheckOK, nil, time.Since(start))
+	s.logger.Printf("startup self-check passed in %s", time.Since(start))
+	close(readyCh)
+}
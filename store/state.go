@@ -0,0 +1,177 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	raftboltdb "github.com/rqlite/raft-boltdb/v2"
+	"github.com/rqlite/rqlite/command"
+)
+
+const (
+	// stateTable is the table holding cluster state set through the Raft
+	// log, such as any fence, and the zone of each node. Keeping it in the
+	// database means it is included in snapshots, so a node which catches up
+	// by installing a snapshot has the same state as a node which applied
+	// every log entry.
+	stateTable = "_rqlite_state"
+
+	stateKeyFencedBy   = "fenced_by"
+	stateKeyZonePrefix = "zone/"
+)
+
+// setState sets the value of the key in the state table. It must be called
+// only when applying a log entry, so that every node sets the same state.
+func (s *Store) setState(key, value string) error {
+	results, err := s.db.Execute(&command.Request{
+		Transaction: true,
+		Statements: []*command.Statement{
+			{
+				Sql: fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (key TEXT NOT NULL PRIMARY KEY, value TEXT NOT NULL)`,
+					stateTable),
+			},
+			{
+				Sql: fmt.Sprintf("INSERT OR REPLACE INTO %s(key, value) VALUES(?, ?)", stateTable),
+				Parameters: []*command.Parameter{
+					{Value: &command.Parameter_S{S: key}},
+					{Value: &command.Parameter_S{S: value}},
+				},
+			},
+		},
+	}, false)
+	if err != nil {
+		return err
+	}
+	for _, r := range results {
+		if r.Error != "" {
+			return fmt.Errorf("failed to set state %s: %s", key, r.Error)
+		}
+	}
+	return nil
+}
+
+// readState returns every key and value in the state table. It returns nil
+// if the table does not exist.
+func (s *Store) readState() (map[string]string, error) {
+	exists, err := s.objectExists("table", stateTable)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, nil
+	}
+	state := make(map[string]string)
+	rows, err := s.queryStatement(&command.Statement{
+		Sql: fmt.Sprintf("SELECT key, value FROM %s", stateTable),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range rows.Values {
+		state[v.Parameters[0].GetS()] = v.Parameters[1].GetS()
+	}
+	return state, nil
+}
+
+// loadState sets the fence and zones of the Store from the stable store and,
+// if fromDB is set, from the state table of the database. If the database
+// has a state table, it replaces the state held in the stable store, and is
+// written there so that it applies as soon as the node next restarts.
+func (s *Store) loadState(fromDB bool) error {
+	fencedBy, err := s.raftStable.Get(fencedKey)
+	if err != nil && err != raftboltdb.ErrKeyNotFound {
+		return err
+	}
+	zones := make(map[string]string)
+	b, err := s.raftStable.Get(zonesKey)
+	if err != nil && err != raftboltdb.ErrKeyNotFound {
+		return err
+	}
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &zones); err != nil {
+			return err
+		}
+	}
+
+	if fromDB {
+		state, err := s.readState()
+		if err != nil {
+			return err
+		}
+		if state != nil {
+			fencedBy = nil
+			zones = make(map[string]string)
+		}
+		for k, v := range state {
+			if k == stateKeyFencedBy {
+				fencedBy = []byte(v)
+			} else if strings.HasPrefix(k, stateKeyZonePrefix) {
+				zones[strings.TrimPrefix(k, stateKeyZonePrefix)] = v
+			}
+		}
+		if err := s.raftStable.Set(fencedKey, fencedBy); err != nil {
+			return err
+		}
+		b, err := json.Marshal(zones)
+		if err != nil {
+			return err
+		}
+		if err := s.raftStable.Set(zonesKey, b); err != nil {
+			return err
+		}
+	}
+
+	s.writableMu.Lock()
+	s.fencedBy = string(fencedBy)
+	s.writableMu.Unlock()
+	s.zonesMu.Lock()
+	s.zones = zones
+	s.zonesMu.Unlock()
+	return nil
+}
+
+// restoreState writes the fence and zones of the Store to the state table,
+// after the database was replaced by one which may not hold them, such as
+// by a load. Any state table in the new database came from wherever that
+// database was taken, perhaps another cluster, so it is replaced. It must be
+// called only when applying a log entry.
+func (s *Store) restoreState() error {
+	stmts := []*command.Statement{
+		{Sql: fmt.Sprintf(`DROP TABLE IF EXISTS %s`, stateTable)},
+	}
+	set := func(key, value string) {
+		if len(stmts) == 1 {
+			stmts = append(stmts, &command.Statement{
+				Sql: fmt.Sprintf(`CREATE TABLE %s (key TEXT NOT NULL PRIMARY KEY, value TEXT NOT NULL)`, stateTable),
+			})
+		}
+		stmts = append(stmts, &command.Statement{
+			Sql: fmt.Sprintf("INSERT INTO %s(key, value) VALUES(?, ?)", stateTable),
+			Parameters: []*command.Parameter{
+				{Value: &command.Parameter_S{S: key}},
+				{Value: &command.Parameter_S{S: value}},
+			},
+		})
+	}
+	if fenced, id := s.Fenced(); fenced {
+		set(stateKeyFencedBy, id)
+	}
+	for id, zone := range s.Zones() {
+		set(stateKeyZonePrefix+id, zone)
+	}
+
+	results, err := s.db.Execute(&command.Request{
+		Transaction: true,
+		Statements:  stmts,
+	}, false)
+	if err != nil {
+		return err
+	}
+	for _, r := range results {
+		if r.Error != "" {
+			return fmt.Errorf("failed to restore state: %s", r.Error)
+		}
+	}
+	return nil
+}
@@ -16,6 +16,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hashicorp/raft"
@@ -23,6 +24,7 @@ import (
 	"github.com/rqlite/rqlite/command/chunking"
 	sql "github.com/rqlite/rqlite/db"
 	rlog "github.com/rqlite/rqlite/log"
+	"github.com/rqlite/rqlite/overload"
 	"github.com/rqlite/rqlite/snapshot"
 )
 
@@ -33,6 +35,14 @@ var (
 	// ErrOpen is returned when a Store is already open.
 	ErrOpen = errors.New("store already open")
 
+	// ErrSandboxStrong is returned when a sandboxed query requests strong
+	// read consistency, which requires the query to go through the Raft log.
+	ErrSandboxStrong = errors.New("sandboxed queries do not support strong read consistency")
+
+	// ErrAutoRestoreSkipped is returned by AutoRestoreErr when another node
+	// became leader first, so the auto-restore was not performed.
+	ErrAutoRestoreSkipped = errors.New("auto-restore skipped")
+
 	// ErrNotReady is returned when a Store is not ready to accept requests.
 	ErrNotReady = errors.New("store not ready")
 
@@ -44,6 +54,14 @@ var (
 	// requested freshness.
 	ErrStaleRead = errors.New("stale read")
 
+	// ErrReadIndexTimeout is returned when a linearizable read times out
+	// waiting for the database to reflect the read index.
+	ErrReadIndexTimeout = errors.New("timeout waiting for read index")
+
+	// ErrStreamNotSupported is returned when the results of a query with
+	// strong read consistency are requested as a stream.
+	ErrStreamNotSupported = errors.New("streaming not supported for strong read consistency")
+
 	// ErrOpenTimeout is returned when the Store does not apply its initial
 	// logs within the specified time.
 	ErrOpenTimeout = errors.New("timeout waiting for initial logs application")
@@ -59,10 +77,15 @@ var (
 	// ErrInvalidBackupFormat is returned when the requested backup format
 	// is not valid.
 	ErrInvalidBackupFormat = errors.New("invalid backup format")
+
+	// ErrChunkedExecutePending is returned when a snapshot is requested while
+	// the chunks of an execute request are still being applied.
+	ErrChunkedExecutePending = errors.New("chunked execute request pending")
 )
 
 const (
 	raftDBPath                 = "raft.db" // Changing this will break backwards compatibility.
+	snapshotsDirName           = "rsnapshots"
 	peersPath                  = "raft/peers.json"
 	peersInfoPath              = "raft/peers.info"
 	retainSnapshotCount        = 1
@@ -79,35 +102,75 @@ const (
 	observerChanLen            = 50
 
 	defaultChunkSize = 512 * 1024 * 1024 // 512 MB
+
+	// Settings used in place of the above when the Store runs in low-memory
+	// mode.
+	lowMemoryConnectionPoolCount = 1
+	lowMemoryRaftLogCacheSize    = 64
+	lowMemoryMaxAppendEntries    = 16
+	lowMemoryChunkSize           = 16 * 1024 * 1024 // 16 MB
 )
 
 const (
-	numSnapshots            = "num_snapshots"
-	numSnapshotsFull        = "num_snapshots_full"
-	numSnapshotsIncremental = "num_snapshots_incremental"
-	numProvides             = "num_provides"
-	numBackups              = "num_backups"
-	numLoads                = "num_loads"
-	numRestores             = "num_restores"
-	numAutoRestores         = "num_auto_restores"
-	numAutoRestoresSkipped  = "num_auto_restores_skipped"
-	numAutoRestoresFailed   = "num_auto_restores_failed"
-	numRecoveries           = "num_recoveries"
-	numUncompressedCommands = "num_uncompressed_commands"
-	numCompressedCommands   = "num_compressed_commands"
-	numJoins                = "num_joins"
-	numIgnoredJoins         = "num_ignored_joins"
-	numRemovedBeforeJoins   = "num_removed_before_joins"
-	numDBStatsErrors        = "num_db_stats_errors"
-	snapshotCreateDuration  = "snapshot_create_duration"
-	snapshotPersistDuration = "snapshot_persist_duration"
-	snapshotWALSize         = "snapshot_wal_size"
-	snapshotDBOnDiskSize    = "snapshot_db_ondisk_size"
-	leaderChangesObserved   = "leader_changes_observed"
-	leaderChangesDropped    = "leader_changes_dropped"
-	failedHeartbeatObserved = "failed_heartbeat_observed"
-	nodesReapedOK           = "nodes_reaped_ok"
-	nodesReapedFailed       = "nodes_reaped_failed"
+	numSnapshots                  = "num_snapshots"
+	numSnapshotsFull              = "num_snapshots_full"
+	numSnapshotsIncremental       = "num_snapshots_incremental"
+	numProvides                   = "num_provides"
+	numBackups                    = "num_backups"
+	numLoads                      = "num_loads"
+	numRestores                   = "num_restores"
+	numAutoRestores               = "num_auto_restores"
+	numAutoRestoresSkipped        = "num_auto_restores_skipped"
+	numAutoRestoresFailed         = "num_auto_restores_failed"
+	numRecoveries                 = "num_recoveries"
+	numUncompressedCommands       = "num_uncompressed_commands"
+	numCompressedCommands         = "num_compressed_commands"
+	numJoins                      = "num_joins"
+	numIgnoredJoins               = "num_ignored_joins"
+	numRemovedBeforeJoins         = "num_removed_before_joins"
+	numPlacementViolations        = "num_placement_violations"
+	numVoterSwaps                 = "num_voter_swaps"
+	numSchemaChanges              = "num_schema_changes"
+	numSubscriptions              = "num_subscriptions"
+	numSubscriptionsOverflowed    = "num_subscriptions_overflowed"
+	numBatchesReleased            = "num_batches_released"
+	numBatchedEntries             = "num_batched_entries"
+	numReadIndexConfirms          = "num_read_index_confirms"
+	numArchivedRows               = "num_archived_rows"
+	numPartitionsCreated          = "num_partitions_created"
+	numPartitionsDropped          = "num_partitions_dropped"
+	numDBStatsErrors              = "num_db_stats_errors"
+	numClusterEvents              = "num_cluster_events"
+	numClusterEventsDropped       = "num_cluster_events_dropped"
+	numIdempotentDuplicates       = "num_idempotent_duplicates"
+	numIdempotencyRecordErrors    = "num_idempotency_record_errors"
+	snapshotCreateDuration        = "snapshot_create_duration"
+	snapshotPersistDuration       = "snapshot_persist_duration"
+	snapshotDuration              = "snapshot_duration"
+	snapshotWritesBlockedDuration = "snapshot_writes_blocked_duration"
+	snapshotWALSize               = "snapshot_wal_size"
+	snapshotDBOnDiskSize          = "snapshot_db_ondisk_size"
+	leaderChangesObserved         = "leader_changes_observed"
+	leaderChangesDropped          = "leader_changes_dropped"
+	snapshotsObserved             = "snapshots_observed"
+	snapshotsDropped              = "snapshots_dropped"
+	numSnapshotsInstalled         = "num_snapshots_installed"
+	numSnapshotDBsOpened          = "num_snapshot_dbs_opened"
+	failedHeartbeatObserved       = "failed_heartbeat_observed"
+	nodesReapedOK                 = "nodes_reaped_ok"
+	nodesReapedFailed             = "nodes_reaped_failed"
+	numChecksums                  = "num_checksums"
+	numResyncs                    = "num_resyncs"
+	numChunkedExecutes            = "num_chunked_executes"
+	numExecuteChunks              = "num_execute_chunks"
+	numCursorsOpened              = "num_cursors_opened"
+	numCursorsExpired             = "num_cursors_expired"
+	numCursorsInvalidated         = "num_cursors_invalidated"
+	numTransactionsBegun          = "num_transactions_begun"
+	numTransactionsCommitted      = "num_transactions_committed"
+	numTransactionsConflicted     = "num_transactions_conflicted"
+	numTransactionsExpired        = "num_transactions_expired"
+	numTransactionsInvalidated    = "num_transactions_invalidated"
 )
 
 // stats captures stats for the Store.
@@ -136,16 +199,49 @@ func ResetStats() {
 	stats.Add(numJoins, 0)
 	stats.Add(numIgnoredJoins, 0)
 	stats.Add(numRemovedBeforeJoins, 0)
+	stats.Add(numPlacementViolations, 0)
+	stats.Add(numVoterSwaps, 0)
+	stats.Add(numSchemaChanges, 0)
+	stats.Add(numSubscriptions, 0)
+	stats.Add(numSubscriptionsOverflowed, 0)
+	stats.Add(numBatchesReleased, 0)
+	stats.Add(numBatchedEntries, 0)
+	stats.Add(numReadIndexConfirms, 0)
+	stats.Add(numArchivedRows, 0)
+	stats.Add(numPartitionsCreated, 0)
+	stats.Add(numPartitionsDropped, 0)
 	stats.Add(numDBStatsErrors, 0)
+	stats.Add(numClusterEvents, 0)
+	stats.Add(numClusterEventsDropped, 0)
+	stats.Add(numIdempotentDuplicates, 0)
+	stats.Add(numIdempotencyRecordErrors, 0)
 	stats.Add(snapshotCreateDuration, 0)
 	stats.Add(snapshotPersistDuration, 0)
+	stats.Add(snapshotDuration, 0)
+	stats.Add(snapshotWritesBlockedDuration, 0)
 	stats.Add(snapshotWALSize, 0)
 	stats.Add(snapshotDBOnDiskSize, 0)
 	stats.Add(leaderChangesObserved, 0)
 	stats.Add(leaderChangesDropped, 0)
+	stats.Add(snapshotsObserved, 0)
+	stats.Add(snapshotsDropped, 0)
+	stats.Add(numSnapshotsInstalled, 0)
+	stats.Add(numSnapshotDBsOpened, 0)
 	stats.Add(failedHeartbeatObserved, 0)
 	stats.Add(nodesReapedOK, 0)
 	stats.Add(nodesReapedFailed, 0)
+	stats.Add(numChecksums, 0)
+	stats.Add(numResyncs, 0)
+	stats.Add(numChunkedExecutes, 0)
+	stats.Add(numExecuteChunks, 0)
+	stats.Add(numCursorsOpened, 0)
+	stats.Add(numCursorsExpired, 0)
+	stats.Add(numCursorsInvalidated, 0)
+	stats.Add(numTransactionsBegun, 0)
+	stats.Add(numTransactionsCommitted, 0)
+	stats.Add(numTransactionsConflicted, 0)
+	stats.Add(numTransactionsExpired, 0)
+	stats.Add(numTransactionsInvalidated, 0)
 }
 
 // SnapshotStore is the interface Snapshot stores must implement.
@@ -175,28 +271,41 @@ const (
 type Store struct {
 	open          bool
 	raftDir       string
+	logDir        string // Directory containing the Raft log.
+	snapshotDir   string // Directory of the snapshot store.
 	peersPath     string
 	peersInfoPath string
 
 	restoreChunkSize int64
 	restorePath      string
+	restoreIsDump    bool
 	restoreDoneCh    chan struct{}
+	restoreErr       error
 
 	raft   *raft.Raft // The consensus mechanism.
 	ln     Listener
 	raftTn *NodeTransport
 	raftID string    // Node ID.
 	dbConf *DBConfig // SQLite database config.
-	dbPath string    // Path to underlying SQLite file, if not in-memory.
+	dbPath string    // Path to underlying SQLite file.
+	memDir string    // Directory of an in-memory database, removed on close.
 	db     *sql.DB   // The underlying SQLite store.
 
 	queryTxMu sync.RWMutex
 
+	// Whether the next snapshot must be full, regardless of the Snapshot
+	// Store, because the database was replaced by a resync. Set by a resync
+	// with changesMu held for writing, and cleared by Snapshot.
+	fullSnapshotNeeded bool
+
 	dbAppliedIndexMu     sync.RWMutex
 	dbAppliedIndex       uint64
 	appliedIdxUpdateDone chan struct{}
+	partitionMaintDone   chan struct{}
+	partitionMu          sync.Mutex
 
-	dechunkManager *chunking.DechunkerManager
+	dechunkManager     *chunking.DechunkerManager
+	execDechunkManager *chunking.ExecuteDechunkerManager
 
 	// Channels that must be closed for the Store to be considered ready.
 	readyChans             []<-chan struct{}
@@ -208,6 +317,54 @@ type Store struct {
 	fsmIndex   uint64
 	fsmIndexMu sync.RWMutex
 
+	// Latest log entry index reflected in the database, including after a
+	// Snapshot-restore. Accessed atomically, since it is written while
+	// changesMu is held only for reading. changesMu is held for writing
+	// while a consistent copy of the database is taken for consumers of the
+	// change feed.
+	changesIndex uint64
+	changesMu    sync.RWMutex
+
+	// Index of the last log entry reflected in the copy of the Leader's
+	// database installed by the latest resync. Log entries up to it are not
+	// applied. Protected by changesMu.
+	resyncIndex uint64
+
+	// Schema version of the database, and a channel closed, and replaced,
+	// whenever it changes.
+	schemaVersion   int64
+	schemaChangedCh chan struct{}
+	schemaMu        sync.Mutex
+
+	// Subscriptions to row changes, and the index of the log entry being
+	// applied, to which the changes reported while applying it belong. The
+	// index is only accessed while applying log entries.
+	subscriptions   map[*subscription]struct{}
+	subscriptionsMu sync.Mutex
+	applyingIndex   uint64
+
+	// RowChangeLogSize is the number of the latest row changes retained,
+	// so that they can be read with RowChanges. If zero, none are retained.
+	RowChangeLogSize int
+	rowLog           []*RowChange
+	rowLogStart      uint64 // Changes of entries up to this index may be missing.
+	rowLogMu         sync.Mutex
+
+	// BatchWindows sets the batching window of each consistency domain at
+	// open. Domains not set are not batched.
+	BatchWindows map[string]time.Duration
+	batchers     map[string]*batcher
+	readIndexer  readIndexBatcher
+
+	// Most recent checksums of the database, taken when checksum commands
+	// are applied.
+	checksums   []*checksum
+	checksumsMu sync.Mutex
+
+	fencedBy   string // ID of whoever fenced the cluster, if fenced.
+	standby    bool   // Whether this node is part of a warm standby cluster.
+	writableMu sync.RWMutex
+
 	reqMarshaller *command.RequestMarshaler // Request marshaler for writing to log.
 	raftLog       raft.LogStore             // Persistent log store.
 	raftStable    raft.StableStore          // Persistent k-v store.
@@ -217,10 +374,15 @@ type Store struct {
 	// Raft changes observer
 	leaderObserversMu sync.RWMutex
 	leaderObservers   []chan<- struct{}
-	observerClose     chan struct{}
-	observerDone      chan struct{}
-	observerChan      chan raft.Observation
-	observer          *raft.Observer
+
+	// Snapshot observers
+	snapshotObserversMu sync.RWMutex
+	snapshotObservers   []chan<- string
+
+	observerClose chan struct{}
+	observerDone  chan struct{}
+	observerChan  chan raft.Observation
+	observer      *raft.Observer
 
 	firstIdxOnOpen       uint64    // First index on log when Store opens.
 	lastIdxOnOpen        uint64    // Last index on log when Store opens.
@@ -236,10 +398,31 @@ type Store struct {
 	BootstrapExpect int
 	bootstrapped    bool
 	notifyingNodes  map[string]*Server
-
-	ShutdownOnRemove   bool
-	SnapshotThreshold  uint64
-	SnapshotInterval   time.Duration
+	notifyingZones  map[string]string
+
+	ShutdownOnRemove    bool
+	SnapshotThreshold   uint64
+	SnapshotInterval    time.Duration
+	SnapshotCompression bool
+	// SnapshotConsolidateThreshold is the number of snapshots the snapshot
+	// store may hold before their WAL files are consolidated. If not set,
+	// the snapshot store default is used.
+	SnapshotConsolidateThreshold int
+	// SnapshotMaxSize is the number of bytes the snapshot store may use
+	// before snapshots are consolidated early. If not set, not limited.
+	SnapshotMaxSize int64
+	// SnapshotChunkSize is the size of the chunks in which snapshot data is
+	// streamed to other nodes. If not set, the snapshot store default is
+	// used. If negative, snapshot data is streamed unchunked.
+	SnapshotChunkSize int64
+	// DeterministicTime enables the rewriting of the current date and time in
+	// statements by the Leader, so that all nodes apply the same values.
+	DeterministicTime bool
+	// ExecuteChunkSize is the maximum size, in bytes, of the statements of an
+	// execute request written to a single Raft log entry. Larger requests are
+	// split across multiple entries, and executed once the last is applied.
+	// If zero, requests are never split.
+	ExecuteChunkSize   int64
 	LeaderLeaseTimeout time.Duration
 	HeartbeatTimeout   time.Duration
 	ElectionTimeout    time.Duration
@@ -247,10 +430,93 @@ type Store struct {
 	RaftLogLevel       string
 	NoFreeListSync     bool
 
+	// StartupCheck enables a self-check of the Store's state when it opens.
+	// The Store fails to open if the Raft log and snapshots are inconsistent,
+	// and does not become ready if the rebuilt database fails a quick check.
+	StartupCheck  bool
+	selfCheck     selfCheck
+	selfCheckDone chan struct{}
+
 	// Node-reaping configuration
 	ReapTimeout         time.Duration
 	ReapReadOnlyTimeout time.Duration
 
+	// Zone is the zone in which this node runs, and Placement constrains
+	// how voters are placed across zones.
+	Zone      string
+	Placement PlacementConstraints
+	zones     map[string]string
+	zonesMu   sync.RWMutex
+
+	// PartitionMaintenanceInterval is the interval between checks, while
+	// this node is the Leader, for partitions to create or drop. If zero,
+	// DefaultPartitionMaintenanceInterval is used.
+	PartitionMaintenanceInterval time.Duration
+
+	// ClusterEventsCapacity is the number of events the cluster event log
+	// holds before the oldest are removed. If zero, no events are recorded.
+	ClusterEventsCapacity int
+	eventsCh              chan ClusterEvent // Events written while Leader.
+	forwardCh             chan ClusterEvent // Events forwarded to the Leader.
+	forwardDone           chan struct{}
+	eventForwarder        EventForwarder
+
+	// IdempotencyKeysCapacity is the number of idempotency keys recorded
+	// before the oldest are forgotten. It is set in each request with a key,
+	// so the Leader's setting applies. If zero,
+	// DefaultIdempotencyKeysCapacity is used.
+	IdempotencyKeysCapacity int
+
+	// QueryMemoryBudget is the approximate number of bytes the results of
+	// all in-flight queries may use. If zero, not limited.
+	QueryMemoryBudget int64
+	queryBudget       *sql.MemoryBudget
+
+	// Counts the reads of each table by queries made on this node, across
+	// every database the store opens.
+	tableAccess *sql.TableAccess
+
+	// LowMemory trades throughput for a smaller memory footprint, by
+	// caching fewer Raft log entries, sending fewer in each append, pooling
+	// fewer connections to other nodes, and restoring in smaller chunks.
+	LowMemory bool
+
+	// ApplyQueue tracks the entries waiting to be committed and applied
+	// through the Raft log. May be nil.
+	ApplyQueue *overload.Queue
+
+	// CursorTimeout is the time a cursor may go unused before it is closed,
+	// and MaxCursors the number which may be open at once. If zero,
+	// DefaultCursorTimeout and DefaultMaxCursors are used.
+	CursorTimeout     time.Duration
+	MaxCursors        int
+	cursors           map[string]*openCursor
+	cursorsMu         sync.Mutex
+	cursorsExpireDone chan struct{}
+
+	// TransactionTimeout is the time a transaction may go unused before it
+	// is rolled back, and MaxTransactions the number which may be open at
+	// once. If zero, DefaultTransactionTimeout and DefaultMaxTransactions
+	// are used.
+	TransactionTimeout     time.Duration
+	MaxTransactions        int
+	transactions           map[string]*transaction
+	transactionsMu         sync.Mutex
+	transactionsExpireDone chan struct{}
+
+	// Entries proposed to the Raft log by this node, so that a transaction
+	// can detect any change since it began. proposeMu is held for writing
+	// to check the count and propose an entry as one.
+	numProposed uint64
+	proposeMu   sync.RWMutex
+
+	// Index of the latest log entry which may have written each table, by
+	// lower-cased name, and of the latest which may have written any table,
+	// so that a transaction can detect changes to the tables it read.
+	tableWrites     map[string]uint64
+	anyTableWritten uint64
+	tableWritesMu   sync.Mutex
+
 	numTrailingLogs uint64
 
 	// For whitebox testing
@@ -270,11 +536,13 @@ func IsNewNode(raftDir string) bool {
 
 // Config represents the configuration of the underlying Store.
 type Config struct {
-	DBConf *DBConfig   // The DBConfig object for this Store.
-	Dir    string      // The working directory for raft.
-	Tn     Transport   // The underlying Transport for raft.
-	ID     string      // Node ID.
-	Logger *log.Logger // The logger to use to log stuff.
+	DBConf      *DBConfig   // The DBConfig object for this Store.
+	Dir         string      // The working directory for raft.
+	LogDir      string      // The directory for the Raft log. If not set, Dir is used.
+	SnapshotDir string      // The directory for the snapshot store. If not set, a directory in Dir is used.
+	Tn          Transport   // The underlying Transport for raft.
+	ID          string      // Node ID.
+	Logger      *log.Logger // The logger to use to log stuff.
 }
 
 // New returns a new Store.
@@ -288,10 +556,20 @@ func New(ln Listener, c *Config) *Store {
 	if c.DBConf.OnDiskPath != "" {
 		dbPath = c.DBConf.OnDiskPath
 	}
+	logDir := c.LogDir
+	if logDir == "" {
+		logDir = c.Dir
+	}
+	snapshotDir := c.SnapshotDir
+	if snapshotDir == "" {
+		snapshotDir = filepath.Join(c.Dir, snapshotsDirName)
+	}
 
 	return &Store{
 		ln:               ln,
 		raftDir:          c.Dir,
+		logDir:           logDir,
+		snapshotDir:      snapshotDir,
 		peersPath:        filepath.Join(c.Dir, peersPath),
 		peersInfoPath:    filepath.Join(c.Dir, peersInfoPath),
 		restoreChunkSize: defaultChunkSize,
@@ -303,14 +581,22 @@ func New(ln Listener, c *Config) *Store {
 		reqMarshaller:    command.NewRequestMarshaler(),
 		logger:           logger,
 		notifyingNodes:   make(map[string]*Server),
+		notifyingZones:   make(map[string]string),
+		schemaChangedCh:  make(chan struct{}),
+		tableAccess:      sql.NewTableAccess(),
 		ApplyTimeout:     applyTimeout,
+		batchers: map[string]*batcher{
+			BatchWrites:      {},
+			BatchStrongReads: {},
+		},
 	}
 }
 
 // SetRestorePath sets the path to a file containing a copy of a
-// SQLite database. This database will be loaded if and when the
-// node becomes the Leader for the first time only. The Store will
-// also delete the file when it's finished with it.
+// SQLite database, or a SQL dump of one. This database will be loaded,
+// or the statements of the dump executed, if and when the node becomes
+// the Leader for the first time only. The Store will also delete the
+// file when it's finished with it.
 //
 // This function should only be called before the Store is opened
 // and setting the restore path means the Store will not report
@@ -320,15 +606,19 @@ func (s *Store) SetRestorePath(path string) error {
 		return ErrOpen
 	}
 
+	isDump := false
 	if !sql.IsValidSQLiteFile(path) {
-		return fmt.Errorf("file %s is not a valid SQLite file", path)
-	}
-	if sql.IsWALModeEnabledSQLiteFile(path) {
+		if !sql.IsSQLDumpFile(path) {
+			return fmt.Errorf("file %s is not a valid SQLite file or SQL dump", path)
+		}
+		isDump = true
+	} else if sql.IsWALModeEnabledSQLiteFile(path) {
 		return fmt.Errorf("file %s is in WAL mode - convert to DELETE mode", path)
 	}
 
 	s.RegisterReadyChannel(s.restoreDoneCh)
 	s.restorePath = path
+	s.restoreIsDump = isDump
 	return nil
 }
 
@@ -343,6 +633,9 @@ func (s *Store) Open() (retErr error) {
 	defer func() {
 		if retErr == nil {
 			s.open = true
+		} else if s.memDir != "" {
+			os.RemoveAll(s.memDir)
+			s.memDir = ""
 		}
 	}()
 
@@ -353,7 +646,29 @@ func (s *Store) Open() (retErr error) {
 	s.openT = time.Now()
 	s.logger.Printf("opening store with node ID %s, listening on %s", s.raftID, s.ln.Addr().String())
 
-	s.logger.Printf("configured for an on-disk database at %s", s.dbPath)
+	for domain, window := range s.BatchWindows {
+		if err := s.SetBatchWindow(domain, window); err != nil {
+			return err
+		}
+	}
+
+	if s.dbConf.InMemory {
+		// The database does not outlive the Store, so clear out any left
+		// behind by an earlier run which was not closed cleanly.
+		dir := s.dbConf.nodeMemoryDir(s.raftID, s.raftDir)
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("failed to clear directory for in-memory database: %s", err)
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create directory for in-memory database: %s", err)
+		}
+		s.memDir = dir
+		s.dbPath = filepath.Join(dir, sqliteFile)
+		s.logger.Printf("configured for an in-memory database at %s, durable through the Raft log and snapshots only",
+			s.dbPath)
+	} else {
+		s.logger.Printf("configured for an on-disk database at %s", s.dbPath)
+	}
 	parentDir := filepath.Dir(s.dbPath)
 	s.logger.Printf("ensuring directory for on-disk database exists at %s", parentDir)
 	err := os.MkdirAll(parentDir, 0755)
@@ -366,6 +681,9 @@ func (s *Store) Open() (retErr error) {
 	if err := os.MkdirAll(s.raftDir, 0755); err != nil {
 		return err
 	}
+	if err := os.MkdirAll(s.logDir, 0755); err != nil {
+		return err
+	}
 	if err := os.MkdirAll(filepath.Dir(s.peersPath), 0755); err != nil {
 		return err
 	}
@@ -374,9 +692,19 @@ func (s *Store) Open() (retErr error) {
 		return err
 	}
 	s.dechunkManager = decMgmr
+	s.execDechunkManager = chunking.NewExecuteDechunkerManager()
+
+	poolCount, logCacheSize := connectionPoolCount, raftLogCacheSize
+	if s.LowMemory {
+		poolCount, logCacheSize = lowMemoryConnectionPoolCount, lowMemoryRaftLogCacheSize
+		if s.restoreChunkSize == defaultChunkSize {
+			s.restoreChunkSize = lowMemoryChunkSize
+		}
+		s.logger.Printf("low-memory mode enabled")
+	}
 
 	// Create Raft-compatible network layer.
-	nt := raft.NewNetworkTransport(NewTransport(s.ln), connectionPoolCount, connectionTimeout, nil)
+	nt := raft.NewNetworkTransport(NewTransport(s.ln), poolCount, connectionTimeout, nil)
 	s.raftTn = NewNodeTransport(nt)
 
 	// Don't allow control over trailing logs directly, just implement a policy.
@@ -386,17 +714,31 @@ func (s *Store) Open() (retErr error) {
 	config.LocalID = raft.ServerID(s.raftID)
 
 	// Upgrade any pre-existing snapshots.
-	oldSnapshotDir := filepath.Join(s.raftDir, "snapshots")
-	snapshotDir := filepath.Join(s.raftDir, "rsnapshots")
-	if err := snapshot.Upgrade(oldSnapshotDir, snapshotDir, s.logger); err != nil {
+	if err := snapshot.Upgrade(s.oldSnapshotDir(), s.snapshotDir, s.logger); err != nil {
 		return fmt.Errorf("failed to upgrade snapshots: %s", err)
 	}
 
 	// Create store for the Snapshots.
-	snapshotStore, err := snapshot.NewStore(filepath.Join(snapshotDir))
+	s.logger.Printf("snapshot store at %s, Raft log at %s", s.snapshotDir, s.logDir)
+	snapshotStore, err := snapshot.NewStore(s.snapshotDir)
 	if err != nil {
 		return fmt.Errorf("failed to create snapshot store: %s", err)
 	}
+	if s.SnapshotCompression {
+		snapshotStore.EnableCompression()
+	}
+	if s.SnapshotConsolidateThreshold != 0 {
+		if err := snapshotStore.SetConsolidateThreshold(s.SnapshotConsolidateThreshold); err != nil {
+			return fmt.Errorf("failed to set snapshot consolidation threshold: %s", err)
+		}
+	}
+	snapshotStore.SetMaxSize(s.SnapshotMaxSize)
+	if s.SnapshotChunkSize < 0 {
+		snapshotStore.SetChunkSize(0)
+	} else if s.SnapshotChunkSize > 0 {
+		snapshotStore.SetChunkSize(s.SnapshotChunkSize)
+	}
+	snapshotStore.SetOnPersisted(s.notifySnapshotObservers)
 	s.snapshotStore = snapshotStore
 	snaps, err := s.snapshotStore.List()
 	if err != nil {
@@ -405,15 +747,18 @@ func (s *Store) Open() (retErr error) {
 	s.logger.Printf("%d preexisting snapshots present", len(snaps))
 
 	// Create the Raft log store and stable store.
-	s.boltStore, err = rlog.New(filepath.Join(s.raftDir, raftDBPath), s.NoFreeListSync)
+	s.boltStore, err = rlog.New(filepath.Join(s.logDir, raftDBPath), s.NoFreeListSync)
 	if err != nil {
 		return fmt.Errorf("new log store: %s", err)
 	}
 	s.raftStable = s.boltStore
-	s.raftLog, err = raft.NewLogCache(raftLogCacheSize, s.boltStore)
+	s.raftLog, err = raft.NewLogCache(logCacheSize, s.boltStore)
 	if err != nil {
 		return fmt.Errorf("new cached store: %s", err)
 	}
+	if err := s.loadState(false); err != nil {
+		return fmt.Errorf("load state: %s", err)
+	}
 
 	// Request to recover node?
 	if pathExists(s.peersPath) {
@@ -432,6 +777,15 @@ func (s *Store) Open() (retErr error) {
 		stats.Add(numRecoveries, 1)
 	}
 
+	// Check the log before relying on it to rebuild the database.
+	if s.StartupCheck {
+		s.selfCheck.set(selfCheckPending, nil, 0)
+		if err := s.checkLogConsistency(); err != nil {
+			s.selfCheck.set(selfCheckFailed, err, time.Since(s.openT))
+			return err
+		}
+	}
+
 	// Get some info about the log, before any more entries are committed.
 	if err := s.setLogInfo(); err != nil {
 		return fmt.Errorf("set log info: %s", err)
@@ -439,11 +793,24 @@ func (s *Store) Open() (retErr error) {
 	s.logger.Printf("first log index: %d, last log index: %d, last applied index: %d, last command log index: %d:",
 		s.firstIdxOnOpen, s.lastIdxOnOpen, s.lastAppliedIdxOnOpen, s.lastCommandIdxOnOpen)
 
-	s.db, err = createOnDisk(nil, s.dbPath, s.dbConf.FKConstraints, !s.dbConf.DisableWAL)
+	s.db, err = createOnDisk(nil, s.dbPath, s.dbConf.FKConstraints, !s.dbConf.DisableWAL, s.dbConf.attached())
 	if err != nil {
 		return fmt.Errorf("failed to create on-disk database: %s", err)
 	}
 	s.logger.Printf("created on-disk database at open")
+	s.updateSchemaVersion()
+	s.registerRowChangeHook()
+	s.db.SetTableAccess(s.tableAccess)
+	if s.QueryMemoryBudget > 0 {
+		s.queryBudget = sql.NewMemoryBudget(s.QueryMemoryBudget)
+		s.db.SetMemoryBudget(s.queryBudget)
+		s.logger.Printf("query results limited to %d bytes of memory", s.QueryMemoryBudget)
+	}
+
+	if s.ClusterEventsCapacity > 0 {
+		s.eventsCh = make(chan ClusterEvent, clusterEventsChanLen)
+		s.forwardCh = make(chan ClusterEvent, clusterEventsChanLen)
+	}
 
 	// Instantiate the Raft system.
 	ra, err := raft.NewRaft(config, s, s.raftLog, s.raftStable, s.snapshotStore, s.raftTn)
@@ -467,6 +834,28 @@ func (s *Store) Open() (retErr error) {
 	// Periodically update the applied index for faster startup.
 	s.appliedIdxUpdateDone = s.updateAppliedIndex()
 
+	// Periodically create and drop partitions, while Leader.
+	s.partitionMaintDone = s.maintainPartitionsLoop()
+
+	// Close cursors which go unused.
+	s.cursorsExpireDone = s.expireCursorsLoop()
+
+	// Roll back transactions which go unused.
+	s.transactionsExpireDone = s.expireTransactionsLoop()
+
+	// Forward cluster events recorded while not Leader.
+	if s.forwardCh != nil {
+		s.forwardDone = s.forwardEventsLoop()
+	}
+
+	// Check the database once it has been rebuilt, and only then serve requests.
+	if s.StartupCheck {
+		readyCh := make(chan struct{})
+		s.RegisterReadyChannel(readyCh)
+		s.selfCheckDone = make(chan struct{})
+		go s.checkDatabase(s.openT, readyCh, s.selfCheckDone)
+	}
+
 	return nil
 }
 
@@ -547,6 +936,20 @@ func (s *Store) Close(wait bool) (retErr error) {
 	s.logger.Printf("closing store with node ID %s, listening on %s", s.raftID, s.ln.Addr().String())
 
 	close(s.appliedIdxUpdateDone)
+	close(s.partitionMaintDone)
+	close(s.cursorsExpireDone)
+	s.closeCursors(time.Time{})
+	close(s.transactionsExpireDone)
+	s.rollbackTransactions(time.Time{})
+	s.endSubscriptions(ErrNotOpen)
+	if s.forwardDone != nil {
+		close(s.forwardDone)
+		s.forwardDone = nil
+	}
+	if s.selfCheckDone != nil {
+		close(s.selfCheckDone)
+		s.selfCheckDone = nil
+	}
 	close(s.observerClose)
 	<-s.observerDone
 
@@ -582,6 +985,15 @@ func (s *Store) Close(wait bool) (retErr error) {
 		}
 	}
 
+	// An in-memory database is rebuilt when the Store next opens, so free
+	// the memory it occupies now.
+	if s.memDir != "" {
+		if err := os.RemoveAll(s.memDir); err != nil {
+			return err
+		}
+		s.memDir = ""
+	}
+
 	return nil
 }
 
@@ -614,6 +1026,11 @@ func (s *Store) WaitForApplied(timeout time.Duration) error {
 	return s.WaitForAppliedIndex(s.raft.LastIndex(), timeout)
 }
 
+// AppliedIndex returns the index of the last log entry applied by this node.
+func (s *Store) AppliedIndex() uint64 {
+	return s.raft.AppliedIndex()
+}
+
 // WaitForAppliedIndex blocks until a given log index has been applied,
 // or the timeout expires.
 func (s *Store) WaitForAppliedIndex(idx uint64, timeout time.Duration) error {
@@ -677,6 +1094,34 @@ func (s *Store) Path() string {
 	return s.raftDir
 }
 
+// LogDir returns the directory containing the Raft log.
+func (s *Store) LogDir() string {
+	return s.logDir
+}
+
+// SnapshotDir returns the directory of the snapshot store.
+func (s *Store) SnapshotDir() string {
+	return s.snapshotDir
+}
+
+// UpgradeSnapshotsDryRun rehearses the upgrade of any snapshots written by
+// earlier versions, which otherwise happens when the Store is opened. Nothing
+// is changed, other than the writing of the upgraded snapshot to a temporary
+// directory, where it is validated. It must be called before the Store is
+// opened.
+func (s *Store) UpgradeSnapshotsDryRun() (*snapshot.UpgradeReport, error) {
+	if s.open {
+		return nil, ErrOpen
+	}
+	return snapshot.UpgradeWithOptions(s.oldSnapshotDir(), s.snapshotDir,
+		snapshot.UpgradeOptions{DryRun: true}, s.logger)
+}
+
+// oldSnapshotDir returns the directory of the snapshots of earlier versions.
+func (s *Store) oldSnapshotDir() string {
+	return filepath.Join(s.raftDir, "snapshots")
+}
+
 // Addr returns the address of the store.
 func (s *Store) Addr() string {
 	if !s.open {
@@ -939,8 +1384,22 @@ func (s *Store) Stats() (map[string]interface{}, error) {
 		"nodes":                  nodes,
 		"dir":                    s.raftDir,
 		"dir_size":               dirSz,
+		"log_dir":                s.logDir,
+		"snapshot_dir":           s.snapshotDir,
 		"sqlite3":                dbStatus,
 		"db_conf":                s.dbConf,
+		"placement":              s.placementStats(),
+		"schema_version":         s.SchemaVersion(),
+		"open_cursors":           s.numCursors(),
+		"open_transactions":      s.numTransactions(),
+		"subscriptions":          s.numSubscriptions(),
+		"batching":               s.BatchStats(),
+		"read_index":             s.readIndexer.Stats(),
+		"low_memory":             s.LowMemory,
+		"in_memory":              s.dbConf.InMemory,
+	}
+	if s.StartupCheck {
+		status["self_check"] = s.selfCheck.stats()
 	}
 	return status, nil
 }
@@ -957,11 +1416,38 @@ func (s *Store) Execute(ex *command.ExecuteRequest) ([]*command.ExecuteResult, e
 	if !s.Ready() {
 		return nil, ErrNotReady
 	}
+	if err := s.checkWritable(); err != nil {
+		return nil, err
+	}
+	if err := s.rewriteTime(ex.Request); err != nil {
+		return nil, err
+	}
 
 	return s.execute(ex)
 }
 
+// rewriteTime replaces the current date and time in the statements of the
+// request with the time now, if DeterministicTime is set, so that every node
+// applying the request uses the same date and time as the Leader.
+func (s *Store) rewriteTime(req *command.Request) error {
+	if !s.DeterministicTime || req == nil {
+		return nil
+	}
+	return command.RewriteTime(req.Statements, time.Now())
+}
+
 func (s *Store) execute(ex *command.ExecuteRequest) ([]*command.ExecuteResult, error) {
+	s.setIdempotencyKeysCapacity(ex.Request)
+	if chunks := chunking.SplitExecuteRequest(ex, s.ExecuteChunkSize); chunks != nil {
+		return s.executeChunked(chunks)
+	}
+
+	return s.executeEntry(ex, s.raftApply)
+}
+
+// executeEntry writes an execute request to the Raft log as a single entry,
+// using apply, and returns its results once applied.
+func (s *Store) executeEntry(ex *command.ExecuteRequest, apply func(b []byte) raft.ApplyFuture) ([]*command.ExecuteResult, error) {
 	b, compressed, err := s.tryCompress(ex)
 	if err != nil {
 		return nil, err
@@ -978,7 +1464,8 @@ func (s *Store) execute(ex *command.ExecuteRequest) ([]*command.ExecuteResult, e
 		return nil, err
 	}
 
-	af := s.raft.Apply(b, s.ApplyTimeout)
+	s.batchWait(BatchWrites)
+	af := apply(b)
 	if af.Error() != nil {
 		if af.Error() == raft.ErrNotLeader {
 			return nil, ErrNotLeader
@@ -993,24 +1480,104 @@ func (s *Store) execute(ex *command.ExecuteRequest) ([]*command.ExecuteResult, e
 	return r.results, r.error
 }
 
+// executeChunked writes the chunks of an execute request to the Raft log, one
+// per entry. No statement is executed until the last chunk is applied, when
+// all are executed together, exactly as if the request had been written to
+// the log as a single entry.
+func (s *Store) executeChunked(chunks []*command.ExecuteChunkRequest) ([]*command.ExecuteResult, error) {
+	for i, chunk := range chunks {
+		r, err := s.applyExecuteChunk(chunk)
+		if err == nil {
+			if gr, ok := r.(*fsmGenericResponse); ok {
+				err = gr.error
+			}
+		}
+		if err != nil {
+			if i > 0 {
+				// Release the chunks already applied. If this fails, because
+				// this node is no longer the Leader, the chunks are released
+				// by every node once the next Leader writes to the log.
+				abort := &command.ExecuteChunkRequest{StreamId: chunk.StreamId, Abort: true}
+				if _, err := s.applyExecuteChunk(abort); err != nil {
+					s.logger.Printf("failed to abort chunked execute request %s: %s", chunk.StreamId, err)
+				}
+			}
+			return nil, err
+		}
+		if chunk.IsLast {
+			stats.Add(numChunkedExecutes, 1)
+			stats.Add(numExecuteChunks, int64(len(chunks)))
+			er := r.(*fsmExecuteResponse)
+			return er.results, er.error
+		}
+	}
+	return nil, fmt.Errorf("chunked execute request has no last chunk")
+}
+
+// applyExecuteChunk writes a single chunk of an execute request to the Raft
+// log, and returns the response of the FSM.
+func (s *Store) applyExecuteChunk(chunk *command.ExecuteChunkRequest) (interface{}, error) {
+	b, compressed, err := s.tryCompress(chunk)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &command.Command{
+		Type:       command.Command_COMMAND_TYPE_EXECUTE_CHUNK,
+		SubCommand: b,
+		Compressed: compressed,
+	}
+
+	b, err = command.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+
+	af := s.raftApply(b)
+	if af.Error() != nil {
+		if af.Error() == raft.ErrNotLeader {
+			return nil, ErrNotLeader
+		}
+		return nil, af.Error()
+	}
+
+	s.dbAppliedIndexMu.Lock()
+	s.dbAppliedIndex = af.Index()
+	s.dbAppliedIndexMu.Unlock()
+	return af.Response(), nil
+}
+
 // Query executes queries that return rows, and do not modify the database.
 func (s *Store) Query(qr *command.QueryRequest) ([]*command.QueryRows, error) {
+	rows, release, err := s.QueryReserved(qr)
+	release()
+	return rows, err
+}
+
+// QueryReserved is the same as Query, but the memory reserved from the
+// query memory budget for the results is held until the returned release
+// func is called. The caller must call release, which is never nil, once it
+// has finished with the results. Queries with strong read consistency are
+// executed by every node as the Raft log is applied, so their reservations
+// are released as soon as they are executed.
+func (s *Store) QueryReserved(qr *command.QueryRequest) ([]*command.QueryRows, func(), error) {
+	noop := func() {}
 	if !s.open {
-		return nil, ErrNotOpen
+		return nil, noop, ErrNotOpen
 	}
 
 	if qr.Level == command.QueryRequest_QUERY_REQUEST_LEVEL_STRONG {
 		if s.raft.State() != raft.Leader {
-			return nil, ErrNotLeader
+			return nil, noop, ErrNotLeader
 		}
 
 		if !s.Ready() {
-			return nil, ErrNotReady
+			return nil, noop, ErrNotReady
 		}
 
 		b, compressed, err := s.tryCompress(qr)
 		if err != nil {
-			return nil, err
+			return nil, noop, err
 		}
 		c := &command.Command{
 			Type:       command.Command_COMMAND_TYPE_QUERY,
@@ -1020,31 +1587,30 @@ func (s *Store) Query(qr *command.QueryRequest) ([]*command.QueryRows, error) {
 
 		b, err = command.Marshal(c)
 		if err != nil {
-			return nil, err
+			return nil, noop, err
 		}
 
-		af := s.raft.Apply(b, s.ApplyTimeout)
+		s.batchWait(BatchStrongReads)
+		af := s.raftApply(b)
 		if af.Error() != nil {
 			if af.Error() == raft.ErrNotLeader {
-				return nil, ErrNotLeader
+				return nil, noop, ErrNotLeader
 			}
-			return nil, af.Error()
+			return nil, noop, af.Error()
 		}
 
 		s.dbAppliedIndexMu.Lock()
 		s.dbAppliedIndex = af.Index()
 		s.dbAppliedIndexMu.Unlock()
 		r := af.Response().(*fsmQueryResponse)
-		return r.rows, r.error
-	}
-
-	if qr.Level == command.QueryRequest_QUERY_REQUEST_LEVEL_WEAK && s.raft.State() != raft.Leader {
-		return nil, ErrNotLeader
+		if r.error == nil && qr.Metadata {
+			s.addColumnMetadata(qr.Request.Statements, r.rows)
+		}
+		return r.rows, noop, r.error
 	}
 
-	if s.raft.State() != raft.Leader && qr.Level == command.QueryRequest_QUERY_REQUEST_LEVEL_NONE &&
-		qr.Freshness > 0 && time.Since(s.raft.LastContact()).Nanoseconds() > qr.Freshness {
-		return nil, ErrStaleRead
+	if err := s.checkLocalQuery(qr); err != nil {
+		return nil, noop, err
 	}
 
 	if qr.Request.Transaction {
@@ -1054,19 +1620,166 @@ func (s *Store) Query(qr *command.QueryRequest) ([]*command.QueryRows, error) {
 		defer s.queryTxMu.RUnlock()
 	}
 
-	return s.db.Query(qr.Request, qr.Timings)
+	rows, release, err := s.db.QueryReserved(qr.Request, qr.Timings)
+	if err == nil && qr.Metadata {
+		s.addColumnMetadata(qr.Request.Statements, rows)
+	}
+	return rows, release, err
+}
+
+// addColumnMetadata sets the metadata of the result columns of each of rows,
+// the results of the given statements.
+func (s *Store) addColumnMetadata(stmts []*command.Statement, rows []*command.QueryRows) {
+	for i, r := range rows {
+		if r == nil || r.Error != "" || i >= len(stmts) {
+			continue
+		}
+		r.Metadata = s.db.ColumnMetadata(stmts[i].Sql, r.Columns)
+	}
+}
+
+// addRequestColumnMetadata sets the metadata of the result columns of each
+// query of results, the results of the given statements.
+func (s *Store) addRequestColumnMetadata(stmts []*command.Statement, results []*command.ExecuteQueryResponse) {
+	rows := make([]*command.QueryRows, len(results))
+	for i, r := range results {
+		rows[i] = r.GetQ()
+	}
+	s.addColumnMetadata(stmts, rows)
+}
+
+// Authorize checks that every action the SQL statement needs is allowed by
+// allow, by compiling it with allow as SQLite's authorizer, without executing
+// it. Only the first statement in the SQL text is checked.
+func (s *Store) Authorize(stmt string, allow sql.AuthorizeFunc) error {
+	if !s.open {
+		return ErrNotOpen
+	}
+	return s.db.Authorize(stmt, allow)
+}
+
+// CheckInternal returns an error wrapping sql.ErrInternalTable if any of the
+// statements accesses a table the Store, or any other part of rqlite, keeps
+// in the database for its own use. Such tables must only be changed through
+// the Raft log by rqlite itself, so that every node agrees on their contents.
+func (s *Store) CheckInternal(stmts []*command.Statement) error {
+	if !s.open {
+		return ErrNotOpen
+	}
+	for _, stmt := range stmts {
+		if err := s.db.CheckInternal(stmt.Sql); err != nil {
+			return fmt.Errorf("%w: %s", err, stmt.Sql)
+		}
+	}
+	return nil
+}
+
+// QuerySandboxed executes queries on a sandboxed connection, which can only
+// read the database, so that any statement which would change it fails.
+// Since sandboxed queries never go through the Raft log, strong read
+// consistency is not supported. Memory used by the results is held as for
+// QueryReserved, and the caller must call release, which is never nil.
+func (s *Store) QuerySandboxed(qr *command.QueryRequest) ([]*command.QueryRows, func(), error) {
+	noop := func() {}
+	if !s.open {
+		return nil, noop, ErrNotOpen
+	}
+	if qr.Level == command.QueryRequest_QUERY_REQUEST_LEVEL_STRONG {
+		return nil, noop, ErrSandboxStrong
+	}
+	if err := s.checkLocalQuery(qr); err != nil {
+		return nil, noop, err
+	}
+	if qr.Request.Transaction {
+		s.queryTxMu.RLock()
+		defer s.queryTxMu.RUnlock()
+	}
+	return s.db.QuerySandboxed(qr.Request, qr.Timings)
+}
+
+// Validate compiles each statement of the request against the schema of the
+// database on this node, without executing it, and reports whether it could
+// be executed. The schema may lag that of the Leader if this node is not it.
+func (s *Store) Validate(req *command.Request) ([]*sql.Validation, error) {
+	if !s.open {
+		return nil, ErrNotOpen
+	}
+	return s.db.Validate(req)
+}
+
+// QueryStream executes queries that return rows, and do not modify the
+// database, writing the results to w as they are read. Queries with strong
+// read consistency go through the Raft log, so their results are not
+// available until all have been read, and are not supported.
+func (s *Store) QueryStream(qr *command.QueryRequest, w sql.RowsWriter) error {
+	if !s.open {
+		return ErrNotOpen
+	}
+
+	if qr.Level == command.QueryRequest_QUERY_REQUEST_LEVEL_STRONG {
+		return ErrStreamNotSupported
+	}
+
+	if err := s.checkLocalQuery(qr); err != nil {
+		return err
+	}
+
+	if qr.Request.Transaction {
+		s.queryTxMu.RLock()
+		defer s.queryTxMu.RUnlock()
+	}
+
+	return s.db.QueryStream(qr.Request, qr.Timings, w)
+}
+
+// checkLocalQuery checks that a query which does not go through the Raft log
+// may be served by this node at the requested read consistency. For a
+// linearizable read, it waits until the database reflects the read index.
+func (s *Store) checkLocalQuery(qr *command.QueryRequest) error {
+	if qr.Level == command.QueryRequest_QUERY_REQUEST_LEVEL_LINEARIZABLE {
+		return s.linearizableBarrier()
+	}
+	if qr.Level == command.QueryRequest_QUERY_REQUEST_LEVEL_WEAK && s.raft.State() != raft.Leader {
+		return ErrNotLeader
+	}
+
+	if s.raft.State() != raft.Leader && qr.Level == command.QueryRequest_QUERY_REQUEST_LEVEL_NONE &&
+		qr.Freshness > 0 && time.Since(s.raft.LastContact()).Nanoseconds() > qr.Freshness {
+		return ErrStaleRead
+	}
+	return nil
 }
 
 // Request processes a request that may contain both Executes and Queries.
 func (s *Store) Request(eqr *command.ExecuteQueryRequest) ([]*command.ExecuteQueryResponse, error) {
+	results, release, err := s.RequestReserved(eqr)
+	release()
+	return results, err
+}
+
+// RequestReserved is the same as Request, but the memory reserved from the
+// query memory budget for the results of any queries is held until the
+// returned release func is called. The caller must call release, which is
+// never nil, once it has finished with the results. Requests which go
+// through the Raft log are executed by every node as it is applied, so
+// their reservations are released as soon as they are executed.
+func (s *Store) RequestReserved(eqr *command.ExecuteQueryRequest) ([]*command.ExecuteQueryResponse, func(), error) {
+	noop := func() {}
 	if !s.open {
-		return nil, ErrNotOpen
+		return nil, noop, ErrNotOpen
 	}
 
-	if !s.RequiresLeader(eqr) {
+	linearizable := eqr.Level == command.QueryRequest_QUERY_REQUEST_LEVEL_LINEARIZABLE &&
+		s.readOnly(eqr.Request.Statements)
+	if !s.RequiresLeader(eqr) || linearizable {
+		if linearizable {
+			if err := s.linearizableBarrier(); err != nil {
+				return nil, noop, err
+			}
+		}
 		if eqr.Level == command.QueryRequest_QUERY_REQUEST_LEVEL_NONE && eqr.Freshness > 0 &&
 			time.Since(s.raft.LastContact()).Nanoseconds() > eqr.Freshness {
-			return nil, ErrStaleRead
+			return nil, noop, ErrStaleRead
 		}
 		if eqr.Request.Transaction {
 			// Transaction requested during query, but not going through consensus. This means
@@ -1074,20 +1787,32 @@ func (s *Store) Request(eqr *command.ExecuteQueryRequest) ([]*command.ExecuteQue
 			s.queryTxMu.RLock()
 			defer s.queryTxMu.RUnlock()
 		}
-		return s.db.Request(eqr.Request, eqr.Timings)
+		results, release, err := s.db.RequestReserved(eqr.Request, eqr.Timings)
+		if err == nil && eqr.Metadata {
+			s.addRequestColumnMetadata(eqr.Request.Statements, results)
+		}
+		return results, release, err
 	}
 
 	if s.raft.State() != raft.Leader {
-		return nil, ErrNotLeader
+		return nil, noop, ErrNotLeader
 	}
 
 	if !s.Ready() {
-		return nil, ErrNotReady
+		return nil, noop, ErrNotReady
 	}
 
+	if err := s.checkWritable(); err != nil && !s.readOnly(eqr.Request.Statements) {
+		return nil, noop, err
+	}
+	if err := s.rewriteTime(eqr.Request); err != nil {
+		return nil, noop, err
+	}
+	s.setIdempotencyKeysCapacity(eqr.Request)
+
 	b, compressed, err := s.tryCompress(eqr)
 	if err != nil {
-		return nil, err
+		return nil, noop, err
 	}
 
 	c := &command.Command{
@@ -1098,22 +1823,26 @@ func (s *Store) Request(eqr *command.ExecuteQueryRequest) ([]*command.ExecuteQue
 
 	b, err = command.Marshal(c)
 	if err != nil {
-		return nil, err
+		return nil, noop, err
 	}
 
-	af := s.raft.Apply(b, s.ApplyTimeout)
+	s.batchWait(BatchWrites)
+	af := s.raftApply(b)
 	if af.Error() != nil {
 		if af.Error() == raft.ErrNotLeader {
-			return nil, ErrNotLeader
+			return nil, noop, ErrNotLeader
 		}
-		return nil, af.Error()
+		return nil, noop, af.Error()
 	}
 
 	s.dbAppliedIndexMu.Lock()
 	s.dbAppliedIndex = af.Index()
 	s.dbAppliedIndexMu.Unlock()
 	r := af.Response().(*fsmExecuteQueryResponse)
-	return r.results, r.error
+	if r.error == nil && eqr.Metadata {
+		s.addRequestColumnMetadata(eqr.Request.Statements, r.results)
+	}
+	return r.results, noop, r.error
 }
 
 // Backup writes a consistent snapshot of the underlying database to dst.
@@ -1182,6 +1911,9 @@ func (s *Store) LoadFromReader(r io.Reader, chunkSize int64) error {
 	if !s.Ready() {
 		return ErrNotReady
 	}
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
 
 	return s.loadFromReader(r, chunkSize)
 }
@@ -1215,6 +1947,9 @@ func (s *Store) LoadChunk(lcr *command.LoadChunkRequest) error {
 	if !s.Ready() {
 		return ErrNotReady
 	}
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
 
 	return s.loadChunk(lcr)
 }
@@ -1237,7 +1972,7 @@ func (s *Store) loadChunk(lcr *command.LoadChunkRequest) error {
 		return err
 	}
 
-	af := s.raft.Apply(b, s.ApplyTimeout)
+	af := s.raftApply(b)
 	if af.Error() != nil {
 		if af.Error() == raft.ErrNotLeader {
 			return ErrNotLeader
@@ -1248,6 +1983,9 @@ func (s *Store) loadChunk(lcr *command.LoadChunkRequest) error {
 	s.dbAppliedIndexMu.Lock()
 	s.dbAppliedIndex = af.Index()
 	s.dbAppliedIndexMu.Unlock()
+	if lcr.IsLast {
+		s.recordEvent(EventLoad, "database loaded in chunks")
+	}
 	return nil
 }
 
@@ -1261,6 +1999,9 @@ func (s *Store) Load(lr *command.LoadRequest) error {
 	if !s.Ready() {
 		return ErrNotReady
 	}
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
 
 	if err := s.load(lr); err != nil {
 		return err
@@ -1290,7 +2031,7 @@ func (s *Store) load(lr *command.LoadRequest) error {
 		return err
 	}
 
-	af := s.raft.Apply(b, s.ApplyTimeout)
+	af := s.raftApply(b)
 	if af.Error() != nil {
 		if af.Error() == raft.ErrNotLeader {
 			return ErrNotLeader
@@ -1303,6 +2044,7 @@ func (s *Store) load(lr *command.LoadRequest) error {
 	s.dbAppliedIndex = af.Index()
 	s.dbAppliedIndexMu.Unlock()
 	s.logger.Printf("node loaded in %s (%d bytes)", time.Since(startT), len(b))
+	s.recordEvent(EventLoad, "database loaded (%d bytes)", len(lr.Data))
 
 	return nil
 }
@@ -1331,6 +2073,9 @@ func (s *Store) Notify(nr *command.NotifyRequest) error {
 		return nil
 	}
 	s.notifyingNodes[nr.Id] = &Server{nr.Id, nr.Address, "voter"}
+	if nr.Zone != "" {
+		s.notifyingZones[nr.Id] = nr.Zone
+	}
 	if len(s.notifyingNodes) < s.BootstrapExpect {
 		return nil
 	}
@@ -1378,7 +2123,14 @@ func (s *Store) Join(jr *command.JoinRequest) error {
 		return err
 	}
 
-	for _, srv := range configFuture.Configuration().Servers {
+	servers := configFuture.Configuration().Servers
+	if err := s.checkPlacement(servers, id, addr, jr.Zone, voter); err != nil {
+		stats.Add(numPlacementViolations, 1)
+		s.logger.Printf("rejecting join request from node %s at %s: %s", id, addr, err.Error())
+		return err
+	}
+
+	for _, srv := range servers {
 		// If a node already exists with either the joining node's ID or address,
 		// that node may need to be removed from the config first.
 		if srv.ID == raft.ServerID(id) || srv.Address == raft.ServerAddress(addr) {
@@ -1388,7 +2140,7 @@ func (s *Store) Join(jr *command.JoinRequest) error {
 				stats.Add(numIgnoredJoins, 1)
 				s.numIgnoredJoins++
 				s.logger.Printf("node %s at %s already member of cluster, ignoring join request", id, addr)
-				return nil
+				return s.recordZone(id, jr.Zone)
 			}
 
 			if err := s.remove(id); err != nil {
@@ -1415,6 +2167,69 @@ func (s *Store) Join(jr *command.JoinRequest) error {
 
 	stats.Add(numJoins, 1)
 	s.logger.Printf("node with ID %s, at %s, joined successfully as %s", id, addr, prettyVoter(voter))
+	s.recordEvent(EventNodeJoined, "node %s at %s joined as %s", id, addr, prettyVoter(voter))
+	return s.recordZone(id, jr.Zone)
+}
+
+// SwapVoter hands the voting rights of the node oldID to the node newID,
+// which must already be a member of the cluster, and then removes oldID from
+// the cluster. newID is made a voter before oldID is removed, so the cluster
+// never has fewer voters than before the swap. If newID is already a voter,
+// oldID is simply removed.
+func (s *Store) SwapVoter(oldID, newID string) error {
+	if !s.open {
+		return ErrNotOpen
+	}
+	if s.raft.State() != raft.Leader {
+		return ErrNotLeader
+	}
+
+	cf := s.raft.GetConfiguration()
+	if err := cf.Error(); err != nil {
+		return err
+	}
+	servers := cf.Configuration().Servers
+	var oldSrv, newSrv *raft.Server
+	remaining := make([]raft.Server, 0, len(servers))
+	for i := range servers {
+		switch servers[i].ID {
+		case raft.ServerID(oldID):
+			oldSrv = &servers[i]
+			continue
+		case raft.ServerID(newID):
+			newSrv = &servers[i]
+		}
+		remaining = append(remaining, servers[i])
+	}
+	if oldSrv == nil {
+		return fmt.Errorf("node %s is not a member of the cluster", oldID)
+	}
+	if newSrv == nil {
+		return fmt.Errorf("node %s is not a member of the cluster", newID)
+	}
+
+	if newSrv.Suffrage != raft.Voter && oldSrv.Suffrage == raft.Voter {
+		zone := s.Zones()[newID]
+		if err := s.checkPlacement(remaining, newID, "", zone, true); err != nil {
+			stats.Add(numPlacementViolations, 1)
+			return err
+		}
+		f := s.raft.AddVoter(newSrv.ID, newSrv.Address, 0, 0)
+		if f.Error() != nil {
+			if f.Error() == raft.ErrNotLeader {
+				return ErrNotLeader
+			}
+			return f.Error()
+		}
+		s.logger.Printf("node %s at %s promoted to voter, replacing node %s", newID, newSrv.Address, oldID)
+	}
+
+	if err := s.remove(oldID); err != nil {
+		return err
+	}
+	stats.Add(numVoterSwaps, 1)
+	s.logger.Printf("node %s removed, replaced by node %s", oldID, newID)
+	s.recordEvent(EventVoterSwapped, "node %s removed, replaced by node %s", oldID, newID)
 	return nil
 }
 
@@ -1431,6 +2246,7 @@ func (s *Store) Remove(rn *command.RemoveNodeRequest) error {
 	}
 
 	s.logger.Printf("node %s removed successfully", id)
+	s.recordEvent(EventNodeRemoved, "node %s removed", id)
 	return nil
 }
 
@@ -1455,7 +2271,7 @@ func (s *Store) Noop(id string) error {
 		return err
 	}
 
-	af := s.raft.Apply(bc, s.ApplyTimeout)
+	af := s.raftApply(bc)
 	if af.Error() != nil {
 		if af.Error() == raft.ErrNotLeader {
 			return ErrNotLeader
@@ -1471,18 +2287,22 @@ func (s *Store) RequiresLeader(eqr *command.ExecuteQueryRequest) bool {
 	if eqr.Level != command.QueryRequest_QUERY_REQUEST_LEVEL_NONE {
 		return true
 	}
+	return !s.readOnly(eqr.Request.Statements)
+}
 
-	for _, stmt := range eqr.Request.Statements {
+// readOnly returns whether all the given statements are read-only.
+func (s *Store) readOnly(stmts []*command.Statement) bool {
+	for _, stmt := range stmts {
 		sql := stmt.Sql
 		if sql == "" {
 			continue
 		}
 		ro, err := s.db.StmtReadOnly(sql)
 		if !ro || err != nil {
-			return true
+			return false
 		}
 	}
-	return false
+	return true
 }
 
 // setLogInfo records some key indexs about the log.
@@ -1537,6 +2357,9 @@ func (s *Store) raftConfig() *raft.Config {
 	if s.ElectionTimeout != 0 {
 		config.ElectionTimeout = s.ElectionTimeout
 	}
+	if s.LowMemory {
+		config.MaxAppendEntries = lowMemoryMaxAppendEntries
+	}
 	return config
 }
 
@@ -1584,9 +2407,22 @@ type fsmGenericResponse struct {
 	error error
 }
 
+type fsmFenceResponse struct {
+	id string
+}
+
+type fsmZoneResponse struct {
+	id   string
+	zone string
+}
+
 // Apply applies a Raft log entry to the database.
 func (s *Store) Apply(l *raft.Log) (e interface{}) {
+	s.changesMu.RLock()
+	defer s.changesMu.RUnlock()
 	defer func() {
+		atomic.StoreUint64(&s.changesIndex, l.Index)
+
 		s.fsmIndexMu.Lock()
 		defer s.fsmIndexMu.Unlock()
 		s.fsmIndex = l.Index
@@ -1607,9 +2443,48 @@ func (s *Store) Apply(l *raft.Log) (e interface{}) {
 		s.logger.Printf("first log applied since node start, log at index %d", l.Index)
 	}
 
-	typ, r := applyCommand(l.Data, &s.db, s.dechunkManager)
-	if typ == command.Command_COMMAND_TYPE_NOOP {
+	if l.Index <= s.resyncIndex {
+		// Already reflected in the database installed by a resync.
+		return &fsmGenericResponse{}
+	}
+
+	if n := s.execDechunkManager.SetTerm(l.Term); n > 0 {
+		s.logger.Printf("discarded %d incomplete chunked execute requests of earlier terms", n)
+	}
+	s.applyingIndex = l.Index
+	typ, r := applyCommand(l.Data, &s.db, s.dechunkManager, s.execDechunkManager)
+	switch typ {
+	case command.Command_COMMAND_TYPE_NOOP:
 		s.numNoops++
+	case command.Command_COMMAND_TYPE_EXECUTE, command.Command_COMMAND_TYPE_EXECUTE_QUERY,
+		command.Command_COMMAND_TYPE_EXECUTE_CHUNK:
+		s.updateSchemaVersion()
+	case command.Command_COMMAND_TYPE_LOAD, command.Command_COMMAND_TYPE_LOAD_CHUNK:
+		// The database may have been replaced.
+		s.updateSchemaVersion()
+		s.registerRowChangeHook()
+		s.resetRowLog(l.Index)
+		s.recordTableWrites(l.Index, nil, true)
+		// Every node must hold the same state, so a node which cannot
+		// record it must not continue applying the log.
+		if err := s.restoreState(); err != nil {
+			panic(fmt.Sprintf("failed to restore state after load: %s", err))
+		}
+	}
+	if fr, ok := r.(*fsmFenceResponse); ok {
+		if err := s.setFenced(fr.id); err != nil {
+			panic(fmt.Sprintf("failed to record fence: %s", err))
+		}
+		return &fsmGenericResponse{}
+	}
+	if zr, ok := r.(*fsmZoneResponse); ok {
+		if err := s.setZone(zr.id, zr.zone); err != nil {
+			panic(fmt.Sprintf("failed to record zone: %s", err))
+		}
+		return &fsmGenericResponse{}
+	}
+	if cr, ok := r.(*fsmChecksumResponse); ok {
+		s.recordChecksum(l.Index, cr)
 	}
 	return r
 }
@@ -1634,15 +2509,32 @@ func (s *Store) Database(leader bool) ([]byte, error) {
 // The system must ensure that no transaction is taking place during this call.
 // Hashicorp Raft guarantees that this function will not be called concurrently
 // with Apply, as it states Apply() and Snapshot() are always called from the same
-// thread. This means there is no need to synchronize this function with Execute().
-// However, queries that involve a transaction must be blocked.
+// thread. This means there is no need to synchronize this function with Execute(),
+// but also that writes are blocked until it returns. So that they are blocked for
+// as short a time as possible, the database files are only copied here for
+// incremental snapshots, which must checkpoint the WAL. A full snapshot is a view
+// of the files, which are copied when the snapshot is persisted, while writes
+// continue.
 //
 // http://sqlite.org/howtocorrupt.html states it is safe to copy or serialize the
 // database as long as no writes to the database are in progress.
 func (s *Store) Snapshot() (raft.FSMSnapshot, error) {
 	startT := time.Now()
+	defer func() {
+		stats.Get(snapshotWritesBlockedDuration).(*expvar.Int).Set(time.Since(startT).Milliseconds())
+	}()
 
-	fNeeded := s.snapshotStore.FullNeeded()
+	// The chunks of a chunked execute request not yet executed are only in
+	// the Raft log, so the log must not be truncated until it is executed.
+	if n := s.execDechunkManager.Len(); n > 0 {
+		return nil, fmt.Errorf("%w: %d in progress", ErrChunkedExecutePending, n)
+	}
+
+	// Like Apply, exclude a resync, which replaces the database.
+	s.changesMu.RLock()
+	defer s.changesMu.RUnlock()
+
+	fNeeded := s.snapshotStore.FullNeeded() || s.fullSnapshotNeeded
 	fPLog := fullPretty(fNeeded)
 	s.logger.Printf("initiating %s snapshot on node ID %s", fPLog, s.raftID)
 	defer func() {
@@ -1651,15 +2543,32 @@ func (s *Store) Snapshot() (raft.FSMSnapshot, error) {
 		s.numSnapshots++
 	}()
 
-	s.queryTxMu.Lock()
-	defer s.queryTxMu.Unlock()
-
 	var fsmSnapshot raft.FSMSnapshot
 	if fNeeded {
-		if err := s.db.Checkpoint(); err != nil {
-			return nil, err
+		if s.db.WALEnabled() {
+			// Once the files are pinned, writes only append to the WAL, so
+			// the SQLite file, and the WAL up to its current size, remain a
+			// consistent view of the database as it is now. The WAL is not
+			// checkpointed, so the next incremental snapshot includes the
+			// frames of this snapshot too, which is harmless.
+			unpin := s.db.PinFiles()
+			var walSize int64
+			if fi, err := os.Stat(s.db.WALPath()); err == nil {
+				walSize = fi.Size()
+			}
+			snap, err := snapshot.NewFullSnapshotView(s.db.Path(), s.db.WALPath(), walSize, unpin)
+			if err != nil {
+				unpin()
+				return nil, err
+			}
+			fsmSnapshot = snap
+		} else {
+			if err := s.checkpoint(); err != nil {
+				return nil, err
+			}
+			fsmSnapshot = snapshot.NewFullSnapshot(s.db.Path())
 		}
-		fsmSnapshot = snapshot.NewFullSnapshot(s.db.Path())
+		s.fullSnapshotNeeded = false
 		stats.Add(numSnapshotsFull, 1)
 	} else {
 		var b []byte
@@ -1671,7 +2580,7 @@ func (s *Store) Snapshot() (raft.FSMSnapshot, error) {
 			}
 			stats.Get(snapshotWALSize).(*expvar.Int).Set(int64(len(b)))
 			s.logger.Printf("%s snapshot is %d bytes on node ID %s", fPLog, len(b), s.raftID)
-			if err := s.db.Checkpoint(); err != nil {
+			if err := s.checkpoint(); err != nil {
 				return nil, err
 			}
 		}
@@ -1689,9 +2598,53 @@ func (s *Store) Snapshot() (raft.FSMSnapshot, error) {
 	return &FSMSnapshot{
 		FSMSnapshot: fsmSnapshot,
 		logger:      s.logger,
+		startT:      startT,
 	}, nil
 }
 
+// raftApply applies b through the Raft log. The entry is tracked by the
+// apply queue until the error of the returned future is read.
+func (s *Store) raftApply(b []byte) raft.ApplyFuture {
+	s.proposeMu.RLock()
+	defer s.proposeMu.RUnlock()
+	return s.propose(b)
+}
+
+// propose writes b to the Raft log, counting it among the entries proposed.
+// proposeMu must be held.
+func (s *Store) propose(b []byte) raft.ApplyFuture {
+	atomic.AddUint64(&s.numProposed, 1)
+	leave := s.ApplyQueue.Enter()
+	return &queuedApplyFuture{
+		ApplyFuture: s.raft.Apply(b, s.ApplyTimeout),
+		leave:       leave,
+	}
+}
+
+// queuedApplyFuture is an ApplyFuture which leaves the apply queue once its
+// error is read.
+type queuedApplyFuture struct {
+	raft.ApplyFuture
+	leave func()
+}
+
+func (f *queuedApplyFuture) Error() error {
+	err := f.ApplyFuture.Error()
+	f.leave()
+	return err
+}
+
+// checkpoint checkpoints the WAL into the SQLite file. Queries which involve a
+// transaction are blocked meanwhile, and open cursors and transactions closed,
+// as they would cause the checkpoint to fail.
+func (s *Store) checkpoint() error {
+	s.queryTxMu.Lock()
+	defer s.queryTxMu.Unlock()
+	s.invalidateCursors()
+	s.invalidateTransactions()
+	return s.db.Checkpoint()
+}
+
 // Restore restores the node to a previous state. The Hashicorp docs state this
 // will not be called concurrently with Apply(), so synchronization with Execute()
 // is not necessary.
@@ -1719,28 +2672,51 @@ func (s *Store) Restore(rc io.ReadCloser) error {
 	}
 
 	// Must wipe out all pre-existing state if being asked to do a restore.
+	if err := s.replaceDatabase(tmpFile.Name()); err != nil {
+		return fmt.Errorf("restore: %s", err)
+	}
+	s.execDechunkManager.Reset()
+	s.logger.Printf("successfully opened database at %s due to restore", s.db.Path())
+
+	// The snapshot being restored is always the latest in the store.
+	if snaps, err := s.snapshotStore.List(); err == nil && len(snaps) > 0 {
+		atomic.StoreUint64(&s.changesIndex, snaps[0].Index)
+	}
+	s.resetRowLog(atomic.LoadUint64(&s.changesIndex))
+	s.recordTableWrites(atomic.LoadUint64(&s.changesIndex), nil, true)
+
+	stats.Add(numRestores, 1)
+	s.logger.Printf("node restored in %s", time.Since(startT))
+	s.recordEvent(EventRestore, "node %s restored from snapshot", s.raftID)
+	rc.Close()
+	return nil
+}
+
+// replaceDatabase replaces the database with the SQLite file at path, which
+// is moved into place.
+func (s *Store) replaceDatabase(path string) error {
 	if err := s.db.Close(); err != nil {
-		return fmt.Errorf("failed to close pre-restore database: %s", err)
+		return fmt.Errorf("failed to close existing database: %s", err)
 	}
 	if err := sql.RemoveFiles(s.db.Path()); err != nil {
-		return fmt.Errorf("failed to remove pre-restore database files: %s", err)
+		return fmt.Errorf("failed to remove existing database files: %s", err)
 	}
-	if err := os.Rename(tmpFile.Name(), s.db.Path()); err != nil {
-		return fmt.Errorf("failed to rename restored database: %s", err)
+	if err := os.Rename(path, s.db.Path()); err != nil {
+		return fmt.Errorf("failed to rename new database: %s", err)
 	}
 
-	var db *sql.DB
-	db, err = sql.Open(s.dbPath, s.dbConf.FKConstraints, !s.dbConf.DisableWAL)
+	db, err := sql.OpenWithAttached(s.dbPath, s.dbConf.FKConstraints, !s.dbConf.DisableWAL, s.dbConf.attached())
 	if err != nil {
-		return fmt.Errorf("open SQLite file during restore: %s", err)
+		return fmt.Errorf("open SQLite file: %s", err)
 	}
+	db.SetMemoryBudget(s.queryBudget)
+	db.SetTableAccess(s.tableAccess)
 	s.db = db
-	s.logger.Printf("successfully opened database at %s due to restore", s.db.Path())
+	s.updateSchemaVersion()
+	s.registerRowChangeHook()
 
-	stats.Add(numRestores, 1)
-	s.logger.Printf("node restored in %s", time.Since(startT))
-	rc.Close()
-	return nil
+	// The fence and zones are those recorded in the new database.
+	return s.loadState(true)
 }
 
 // RegisterObserver registers an observer of Raft events
@@ -1753,6 +2729,40 @@ func (s *Store) DeregisterObserver(o *raft.Observer) {
 	s.raft.DeregisterObserver(o)
 }
 
+// RegisterSnapshotPersisted registers the given channel which will receive
+// the ID of each snapshot written to this node's snapshot store, whether
+// taken by this node or installed from the Leader. Sends do not block, so an
+// ID is dropped if the channel is not ready for it.
+func (s *Store) RegisterSnapshotPersisted(c chan<- string) {
+	s.snapshotObserversMu.Lock()
+	defer s.snapshotObserversMu.Unlock()
+	s.snapshotObservers = append(s.snapshotObservers, c)
+}
+
+// OpenSnapshot opens the snapshot with the given ID for reading. What is read
+// is the stream Raft sends to install the snapshot on another node.
+func (s *Store) OpenSnapshot(id string) (io.ReadCloser, error) {
+	if !s.open {
+		return nil, ErrNotOpen
+	}
+	_, rc, err := s.snapshotStore.Open(id)
+	return rc, err
+}
+
+func (s *Store) notifySnapshotObservers(id string) {
+	s.recordEvent(EventSnapshot, "snapshot %s persisted", id)
+	s.snapshotObserversMu.RLock()
+	defer s.snapshotObserversMu.RUnlock()
+	for i := range s.snapshotObservers {
+		select {
+		case s.snapshotObservers[i] <- id:
+			stats.Add(snapshotsObserved, 1)
+		default:
+			stats.Add(snapshotsDropped, 1)
+		}
+	}
+}
+
 // RegisterLeaderChange registers the given channel which will
 // receive a signal when this node detects that the Leader changes.
 func (s *Store) RegisterLeaderChange(c chan<- struct{}) {
@@ -1800,6 +2810,7 @@ func (s *Store) observe() (closeCh, doneCh chan struct{}) {
 						} else {
 							stats.Add(nodesReapedOK, 1)
 							s.logger.Printf("successfully reaped %s %s", pn, id)
+							s.recordEvent(EventNodeRemoved, "%s %s reaped after %s without contact", pn, id, dur)
 						}
 					}
 				case raft.LeaderObservation:
@@ -1824,9 +2835,27 @@ func (s *Store) observe() (closeCh, doneCh chan struct{}) {
 	return closeCh, doneCh
 }
 
+// AutoRestoreDone returns a channel which is closed once the auto-restore
+// set by SetRestorePath has been attempted, or skipped. It is never closed
+// if no restore path is set.
+func (s *Store) AutoRestoreDone() <-chan struct{} {
+	return s.restoreDoneCh
+}
+
+// AutoRestoreErr returns the outcome of the auto-restore, which is only
+// meaningful once the channel returned by AutoRestoreDone is closed.
+func (s *Store) AutoRestoreErr() error {
+	return s.restoreErr
+}
+
 // selfLeaderChange is called when this node detects that its leadership
 // status has changed.
 func (s *Store) selfLeaderChange(leader bool) {
+	if leader {
+		s.recordKnownZones()
+		s.recordEvent(EventLeaderChange, "node %s became leader", s.raftID)
+	}
+
 	if s.restorePath != "" {
 		defer func() {
 			// Whatever happens, this is a one-shot attempt to perform a restore
@@ -1842,11 +2871,13 @@ func (s *Store) selfLeaderChange(leader bool) {
 		if !leader {
 			s.logger.Printf("different node became leader, not performing auto-restore")
 			stats.Add(numAutoRestoresSkipped, 1)
+			s.restoreErr = ErrAutoRestoreSkipped
 		} else {
 			s.logger.Printf("this node is now leader, auto-restoring from %s", s.restorePath)
 			if err := s.installRestore(); err != nil {
 				s.logger.Printf("failed to auto-restore from %s: %s", s.restorePath, err.Error())
 				stats.Add(numAutoRestoresFailed, 1)
+				s.restoreErr = err
 				return
 			}
 			stats.Add(numAutoRestores, 1)
@@ -1856,6 +2887,9 @@ func (s *Store) selfLeaderChange(leader bool) {
 }
 
 func (s *Store) installRestore() error {
+	if s.restoreIsDump {
+		return s.installRestoreDump()
+	}
 	f, err := os.Open(s.restorePath)
 	if err != nil {
 		return err
@@ -1864,9 +2898,34 @@ func (s *Store) installRestore() error {
 	return s.loadFromReader(f, s.restoreChunkSize)
 }
 
+// installRestoreDump executes the statements of the SQL dump at the restore
+// path, through the Raft log, in the same way as a dump loaded over HTTP.
+// Unlike loading a SQLite database, executing a dump does not replace the
+// existing database, so it fails if the dump creates tables which exist.
+func (s *Store) installRestoreDump() error {
+	b, err := os.ReadFile(s.restorePath)
+	if err != nil {
+		return err
+	}
+	results, err := s.execute(&command.ExecuteRequest{
+		Request: &command.Request{
+			Statements: []*command.Statement{{Sql: string(b)}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	for _, r := range results {
+		if r.Error != "" {
+			return fmt.Errorf("failed to execute SQL dump: %s", r.Error)
+		}
+	}
+	return nil
+}
+
 // logSize returns the size of the Raft log on disk.
 func (s *Store) logSize() (int64, error) {
-	fi, err := os.Stat(filepath.Join(s.raftDir, raftDBPath))
+	fi, err := os.Stat(filepath.Join(s.logDir, raftDBPath))
 	if err != nil {
 		return 0, err
 	}
@@ -1961,11 +3020,13 @@ func RecoverNode(dataDir string, logger *log.Logger, logs raft.LogStore, stable
 	}
 	defer db.Close()
 
-	// Need a dechunker manager to handle any chunked load requests.
+	// Need a dechunker manager to handle any chunked load requests, and
+	// another for any chunked execute requests.
 	decMgmr, err := chunking.NewDechunkerManager(dataDir)
 	if err != nil {
 		return fmt.Errorf("failed to create dechunker manager: %s", err.Error())
 	}
+	execDecMgmr := chunking.NewExecuteDechunkerManager()
 
 	// The snapshot information is the best known end point for the data
 	// until we play back the Raft log entries.
@@ -1985,7 +3046,8 @@ func RecoverNode(dataDir string, logger *log.Logger, logs raft.LogStore, stable
 			return fmt.Errorf("failed to get log at index %d: %v", index, err)
 		}
 		if entry.Type == raft.LogCommand {
-			applyCommand(entry.Data, &db, decMgmr)
+			execDecMgmr.SetTerm(entry.Term)
+			applyCommand(entry.Data, &db, decMgmr, execDecMgmr)
 		}
 		lastIndex = entry.Index
 		lastTerm = entry.Term
@@ -2026,7 +3088,8 @@ func RecoverNode(dataDir string, logger *log.Logger, logs raft.LogStore, stable
 	return nil
 }
 
-func applyCommand(data []byte, pDB **sql.DB, decMgmr *chunking.DechunkerManager) (command.Command_Type, interface{}) {
+func applyCommand(data []byte, pDB **sql.DB, decMgmr *chunking.DechunkerManager,
+	execDecMgmr *chunking.ExecuteDechunkerManager) (command.Command_Type, interface{}) {
 	var c command.Command
 	db := *pDB
 
@@ -2047,14 +3110,37 @@ func applyCommand(data []byte, pDB **sql.DB, decMgmr *chunking.DechunkerManager)
 		if err := command.UnmarshalSubCommand(&c, &er); err != nil {
 			panic(fmt.Sprintf("failed to unmarshal execute subcommand: %s", err.Error()))
 		}
-		r, err := db.Execute(er.Request, er.Timings)
+		r, err := executeIdempotent(db, er.Request, er.Timings)
+		return c.Type, &fsmExecuteResponse{results: r, error: err}
+	case command.Command_COMMAND_TYPE_EXECUTE_CHUNK:
+		var ecr command.ExecuteChunkRequest
+		if err := command.UnmarshalSubCommand(&c, &ecr); err != nil {
+			panic(fmt.Sprintf("failed to unmarshal execute-chunk subcommand: %s", err.Error()))
+		}
+		if ecr.Abort {
+			execDecMgmr.Delete(ecr.StreamId)
+			return c.Type, &fsmGenericResponse{}
+		}
+
+		dec := execDecMgmr.Get(ecr.StreamId)
+		last, err := dec.WriteChunk(&ecr)
+		if err != nil {
+			execDecMgmr.Delete(ecr.StreamId)
+			return c.Type, &fsmGenericResponse{error: fmt.Errorf("failed to write chunk: %s", err)}
+		}
+		if !last {
+			return c.Type, &fsmGenericResponse{}
+		}
+		execDecMgmr.Delete(ecr.StreamId)
+		er := dec.Request()
+		r, err := executeIdempotent(db, er.Request, er.Timings)
 		return c.Type, &fsmExecuteResponse{results: r, error: err}
 	case command.Command_COMMAND_TYPE_EXECUTE_QUERY:
 		var eqr command.ExecuteQueryRequest
 		if err := command.UnmarshalSubCommand(&c, &eqr); err != nil {
 			panic(fmt.Sprintf("failed to unmarshal execute-query subcommand: %s", err.Error()))
 		}
-		r, err := db.Request(eqr.Request, eqr.Timings)
+		r, err := requestIdempotent(db, eqr.Request, eqr.Timings)
 		return c.Type, &fsmExecuteQueryResponse{results: r, error: err}
 	case command.Command_COMMAND_TYPE_LOAD:
 		var lr command.LoadRequest
@@ -2070,13 +3156,30 @@ func applyCommand(data []byte, pDB **sql.DB, decMgmr *chunking.DechunkerManager)
 			return c.Type, &fsmGenericResponse{error: fmt.Errorf("failed to remove existing database files: %s", err)}
 		}
 
-		newDB, err := createOnDisk(lr.Data, db.Path(), db.FKEnabled(), db.WALEnabled())
+		newDB, err := createOnDisk(lr.Data, db.Path(), db.FKEnabled(), db.WALEnabled(), db.Attached())
 		if err != nil {
 			return c.Type, &fsmGenericResponse{error: fmt.Errorf("failed to create on-disk database: %s", err)}
 		}
+		newDB.SetMemoryBudget(db.MemoryBudget())
+		newDB.SetTableAccess(db.TableAccess())
 
 		*pDB = newDB
 		return c.Type, &fsmGenericResponse{}
+	case command.Command_COMMAND_TYPE_FENCE:
+		var fr command.FenceRequest
+		if err := command.UnmarshalFenceRequest(c.SubCommand, &fr); err != nil {
+			panic(fmt.Sprintf("failed to unmarshal fence subcommand: %s", err.Error()))
+		}
+		return c.Type, &fsmFenceResponse{id: fr.Id}
+	case command.Command_COMMAND_TYPE_ZONE:
+		var zr command.ZoneRequest
+		if err := command.UnmarshalZoneRequest(c.SubCommand, &zr); err != nil {
+			panic(fmt.Sprintf("failed to unmarshal zone subcommand: %s", err.Error()))
+		}
+		return c.Type, &fsmZoneResponse{id: zr.Id, zone: zr.Zone}
+	case command.Command_COMMAND_TYPE_CHECKSUM:
+		sums, err := db.Checksums()
+		return c.Type, &fsmChecksumResponse{sums: sums, error: err}
 	case command.Command_COMMAND_TYPE_LOAD_CHUNK:
 		var lcr command.LoadChunkRequest
 		if err := command.UnmarshalLoadChunkRequest(c.SubCommand, &lcr); err != nil {
@@ -2110,10 +3213,12 @@ func applyCommand(data []byte, pDB **sql.DB, decMgmr *chunking.DechunkerManager)
 			if err := os.Rename(path, db.Path()); err != nil {
 				return c.Type, &fsmGenericResponse{error: fmt.Errorf("failed to rename temporary database file: %s", err)}
 			}
-			newDB, err := sql.Open(db.Path(), db.FKEnabled(), db.WALEnabled())
+			newDB, err := sql.OpenWithAttached(db.Path(), db.FKEnabled(), db.WALEnabled(), db.Attached())
 			if err != nil {
 				return c.Type, &fsmGenericResponse{error: fmt.Errorf("failed to open new on-disk database: %s", err)}
 			}
+			newDB.SetMemoryBudget(db.MemoryBudget())
+			newDB.SetTableAccess(db.TableAccess())
 
 			// Swap the underlying database to the new one.
 			*pDB = newDB
@@ -2167,7 +3272,7 @@ func checkRaftConfiguration(configuration raft.Configuration) error {
 // createOnDisk opens an on-disk database file at the configured path. If b is
 // non-nil, any preexisting file will first be overwritten with those contents.
 // Otherwise, any preexisting file will be removed before the database is opened.
-func createOnDisk(b []byte, path string, fkConstraints, wal bool) (*sql.DB, error) {
+func createOnDisk(b []byte, path string, fkConstraints, wal bool, attached map[string]string) (*sql.DB, error) {
 	if err := sql.RemoveFiles(path); err != nil {
 		return nil, err
 	}
@@ -2176,7 +3281,7 @@ func createOnDisk(b []byte, path string, fkConstraints, wal bool) (*sql.DB, erro
 			return nil, err
 		}
 	}
-	return sql.Open(path, fkConstraints, wal)
+	return sql.OpenWithAttached(path, fkConstraints, wal, attached)
 }
 
 // prettyVoter converts bool to "voter" or "non-voter"
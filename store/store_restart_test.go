@@ -1,11 +1,15 @@
 package store
 
 import (
+	"errors"
 	"fmt"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/rqlite/rqlite/command"
+	rlog "github.com/rqlite/rqlite/log"
+	"github.com/rqlite/rqlite/random"
 )
 
 func test_OpenStoreCloseStartup(t *testing.T, s *Store) {
@@ -214,6 +218,127 @@ func Test_OpenStoreCloseStartupSingleNode(t *testing.T) {
 	test_OpenStoreCloseStartup(t, s)
 }
 
+// Test_OpenStoreCloseStartupSingleNodeCompressed tests that on-disk works
+// fine during various restart scenarios, with snapshots compressed.
+func Test_OpenStoreCloseStartupSingleNodeCompressed(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+	s.SnapshotCompression = true
+	test_OpenStoreCloseStartup(t, s)
+}
+
+// Test_OpenStoreCloseStartupSingleNodeSeparateDirs tests that on-disk works
+// fine during various restart scenarios, with the SQLite file, Raft log, and
+// snapshot store each in their own directory.
+func Test_OpenStoreCloseStartupSingleNodeSeparateDirs(t *testing.T) {
+	dataDir := t.TempDir()
+	logDir := filepath.Join(t.TempDir(), "log")
+	snapDir := filepath.Join(t.TempDir(), "snapshots")
+	cfg := NewDBConfig()
+	cfg.OnDiskPath = filepath.Join(t.TempDir(), "db.sqlite")
+
+	ln := mustMockLister("localhost:0")
+	defer ln.Close()
+	s := New(ln, &Config{
+		DBConf:      cfg,
+		Dir:         dataDir,
+		LogDir:      logDir,
+		SnapshotDir: snapDir,
+		ID:          random.String(),
+	})
+	test_OpenStoreCloseStartup(t, s)
+
+	if !pathExists(filepath.Join(logDir, raftDBPath)) {
+		t.Fatalf("Raft log not in log directory")
+	}
+	if pathExists(filepath.Join(dataDir, raftDBPath)) {
+		t.Fatalf("Raft log in data directory")
+	}
+	if IsNewNode(s.LogDir()) {
+		t.Fatalf("store with Raft log in log directory is a new node")
+	}
+	if !pathExists(filepath.Join(snapDir, "generations")) {
+		t.Fatalf("snapshot store not in snapshot directory")
+	}
+	if pathExists(filepath.Join(dataDir, snapshotsDirName)) {
+		t.Fatalf("snapshot store in data directory")
+	}
+	if !pathExists(cfg.OnDiskPath) {
+		t.Fatalf("SQLite file not at on-disk path")
+	}
+}
+
+// Test_OpenStoreStartupCheck tests that the startup self-check passes for a
+// consistent Store, and refuses to open a Store whose log has lost entries.
+func Test_OpenStoreStartupCheck(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+	s.StartupCheck = true
+
+	waitForReady := func() {
+		t.Helper()
+		if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+			t.Fatalf("Error waiting for leader: %s", err)
+		}
+		for i := 0; !s.Ready(); i++ {
+			if i == 100 {
+				t.Fatalf("store did not become ready")
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	waitForReady()
+	er := executeRequestFromStrings([]string{
+		`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`,
+		`INSERT INTO foo(id, name) VALUES(1, "fiona")`,
+	}, false, false)
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+	if err := s.Close(true); err != nil {
+		t.Fatalf("failed to close single-node store: %s", err.Error())
+	}
+
+	// Reopen it, and confirm the check passes.
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	waitForReady()
+	stats, err := s.Stats()
+	if err != nil {
+		t.Fatalf("failed to get store stats: %s", err.Error())
+	}
+	if exp, got := selfCheckOK, stats["self_check"].(map[string]interface{})["status"]; exp != got {
+		t.Fatalf("wrong self-check status, exp %s, got %s", exp, got)
+	}
+	if err := s.Close(true); err != nil {
+		t.Fatalf("failed to close single-node store: %s", err.Error())
+	}
+
+	// Record an applied index beyond the end of the log, as if entries had
+	// been lost, and confirm the Store refuses to open.
+	l, err := rlog.New(filepath.Join(s.raftDir, raftDBPath), false)
+	if err != nil {
+		t.Fatalf("failed to open log: %s", err.Error())
+	}
+	if err := l.SetAppliedIndex(1000); err != nil {
+		t.Fatalf("failed to set applied index: %s", err.Error())
+	}
+	if err := l.Close(); err != nil {
+		t.Fatalf("failed to close log: %s", err.Error())
+	}
+	if err := s.Open(); !errors.Is(err, ErrSelfCheckFailed) {
+		t.Fatalf("expected ErrSelfCheckFailed opening store, got %v", err)
+	}
+}
+
 func test_SnapshotStress(t *testing.T, s *Store) {
 	s.SnapshotInterval = 100 * time.Millisecond
 	s.SnapshotThreshold = 4
@@ -5,10 +5,14 @@ import (
 	"errors"
 	"expvar"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"path/filepath"
+	"reflect"
 	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -299,6 +303,7 @@ func Test_SingleNodeSnapshot(t *testing.T) {
 	if err := f.Persist(sink); err != nil {
 		t.Fatalf("failed to persist snapshot to disk: %s", err.Error())
 	}
+	f.Release()
 
 	// Check restoration.
 	snapFile, err = os.Open(filepath.Join(snapDir, "snapshot"))
@@ -323,6 +328,111 @@ func Test_SingleNodeSnapshot(t *testing.T) {
 	}
 }
 
+// Test_SingleNodeSnapshotPersisted tests that registered channels receive the
+// ID of each snapshot persisted, and that the snapshot can then be opened.
+func Test_SingleNodeDeterministicTime(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+	s.DeterministicTime = true
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	er := executeRequestFromStrings([]string{
+		`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, ts TEXT DEFAULT CURRENT_TIMESTAMP)`,
+		`INSERT INTO foo(id, ts) VALUES(1, CURRENT_TIMESTAMP)`,
+	}, false, false)
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+	if !strings.Contains(er.Request.Statements[0].Sql, "CURRENT_TIMESTAMP") {
+		t.Fatalf("CREATE TABLE statement rewritten: %s", er.Request.Statements[0].Sql)
+	}
+	if strings.Contains(er.Request.Statements[1].Sql, "CURRENT_TIMESTAMP") {
+		t.Fatalf("INSERT statement not rewritten: %s", er.Request.Statements[1].Sql)
+	}
+
+	r, err := s.Query(queryRequestFromString("SELECT ts = datetime(ts) FROM foo", false, false))
+	if err != nil {
+		t.Fatalf("failed to query single node: %s", err.Error())
+	}
+	if exp, got := `[[1]]`, asJSON(r[0].Values); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+}
+
+func Test_SingleNodeSnapshotPersisted(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+	s.SnapshotThreshold = 4
+	s.SnapshotInterval = 100 * time.Millisecond
+
+	ch := make(chan string, 1)
+	s.RegisterSnapshotPersisted(ch)
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	queries := []string{
+		`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`,
+		`INSERT INTO foo(id, name) VALUES(1, "fiona")`,
+		`INSERT INTO foo(id, name) VALUES(2, "fiona")`,
+		`INSERT INTO foo(id, name) VALUES(3, "fiona")`,
+		`INSERT INTO foo(id, name) VALUES(4, "fiona")`,
+	}
+	for i := range queries {
+		_, err := s.Execute(executeRequestFromString(queries[i], false, false))
+		if err != nil {
+			t.Fatalf("failed to execute on single node: %s", err.Error())
+		}
+	}
+
+	var id string
+	select {
+	case id = <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for snapshot to be persisted")
+	}
+	rc, err := s.OpenSnapshot(id)
+	if err != nil {
+		t.Fatalf("failed to open snapshot %s: %s", id, err.Error())
+	}
+	defer rc.Close()
+
+	// Ensure the snapshot opened holds the database.
+	if _, err := s.Execute(executeRequestFromString(`DELETE FROM foo`, false, false)); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+	if err := s.Restore(rc); err != nil {
+		t.Fatalf("failed to restore snapshot: %s", err.Error())
+	}
+	r, err := s.Query(queryRequestFromString("SELECT count(*) FROM foo", false, false))
+	if err != nil {
+		t.Fatalf("failed to query single node: %s", err.Error())
+	}
+	if exp, got := `[[4]]`, asJSON(r[0].Values); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+
+	if _, err := s.OpenSnapshot("non-existent"); err == nil {
+		t.Fatalf("expected error opening non-existent snapshot")
+	}
+}
+
 // Test_StoreSingleNodeNotOpen tests that various methods called on a
 // closed Store return ErrNotOpen.
 func Test_StoreSingleNodeNotOpen(t *testing.T) {
@@ -547,21 +657,1013 @@ func Test_SingleNodeExecuteQuery(t *testing.T) {
 	defer s.Close(true)
 	_, err := s.WaitForLeader(10 * time.Second)
 	if err != nil {
-		t.Fatalf("Error waiting for leader: %s", err)
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	er := executeRequestFromStrings([]string{
+		`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`,
+		`INSERT INTO foo(id, name) VALUES(1, "fiona")`,
+	}, false, false)
+	_, err = s.Execute(er)
+	if err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+
+	qr := queryRequestFromString("SELECT * FROM foo", false, false)
+	qr.Level = command.QueryRequest_QUERY_REQUEST_LEVEL_NONE
+	r, err := s.Query(qr)
+	if err != nil {
+		t.Fatalf("failed to query single node: %s", err.Error())
+	}
+	if exp, got := `["id","name"]`, asJSON(r[0].Columns); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+	if exp, got := `[[1,"fiona"]]`, asJSON(r[0].Values); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+}
+
+func Test_SingleNodeLinearizableQuery(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	er := executeRequestFromStrings([]string{
+		`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`,
+		`INSERT INTO foo(id, name) VALUES(1, "fiona")`,
+	}, false, false)
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+	lastIdx := s.raft.LastIndex()
+
+	// Linearizable reads are served locally, so don't add to the log.
+	var wg sync.WaitGroup
+	errCh := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			qr := queryRequestFromString("SELECT * FROM foo", false, false)
+			qr.Level = command.QueryRequest_QUERY_REQUEST_LEVEL_LINEARIZABLE
+			r, err := s.Query(qr)
+			if err != nil {
+				errCh <- err
+				return
+			}
+			if exp, got := `[[1,"fiona"]]`, asJSON(r[0].Values); exp != got {
+				errCh <- fmt.Errorf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		t.Fatalf("linearizable query failed: %s", err)
+	}
+
+	eqr := executeQueryRequestFromString("SELECT * FROM foo", command.QueryRequest_QUERY_REQUEST_LEVEL_LINEARIZABLE, false, false)
+	r, err := s.Request(eqr)
+	if err != nil {
+		t.Fatalf("failed to perform linearizable request: %s", err.Error())
+	}
+	if exp, got := `[[1,"fiona"]]`, asJSON(r[0].GetQ().Values); exp != got {
+		t.Fatalf("unexpected results for request\nexp: %s\ngot: %s", exp, got)
+	}
+	if exp, got := lastIdx, s.raft.LastIndex(); exp != got {
+		t.Fatalf("linearizable reads added to the log, last index was %d, now %d", exp, got)
+	}
+
+	st := s.readIndexer.Stats()
+	if exp, got := uint64(11), st["reads"].(uint64); exp != got {
+		t.Fatalf("expected %d reads, got %d", exp, got)
+	}
+	if got := st["batches"].(uint64); got == 0 || got > 11 {
+		t.Fatalf("unexpected number of batches %d", got)
+	}
+}
+
+func Test_SingleNodeQuerySandboxed(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	er := executeRequestFromStrings([]string{
+		`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`,
+		`INSERT INTO foo(id, name) VALUES(1, "fiona")`,
+	}, false, false)
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+
+	qr := queryRequestFromString("SELECT * FROM foo", false, false)
+	qr.Level = command.QueryRequest_QUERY_REQUEST_LEVEL_WEAK
+	r, _, err := s.QuerySandboxed(qr)
+	if err != nil {
+		t.Fatalf("failed to query single node: %s", err.Error())
+	}
+	if exp, got := `[[1,"fiona"]]`, asJSON(r[0].Values); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+
+	qr = queryRequestFromString(`DELETE FROM foo`, false, false)
+	qr.Level = command.QueryRequest_QUERY_REQUEST_LEVEL_NONE
+	r, _, err = s.QuerySandboxed(qr)
+	if err != nil {
+		t.Fatalf("failed to query single node: %s", err.Error())
+	}
+	if r[0].Error == "" {
+		t.Fatalf("write was not rejected by sandbox")
+	}
+
+	qr.Level = command.QueryRequest_QUERY_REQUEST_LEVEL_STRONG
+	if _, _, err := s.QuerySandboxed(qr); err != ErrSandboxStrong {
+		t.Fatalf("expected ErrSandboxStrong, got %v", err)
+	}
+}
+
+func Test_SingleNodeValidate(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	er := executeRequestFromString(`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`, false, false)
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+
+	vals, err := s.Validate(executeRequestFromStrings([]string{
+		`INSERT INTO foo(name) VALUES('fiona')`,
+		`INSERT INTO bar(name) VALUES('fiona')`,
+	}, false, false).Request)
+	if err != nil {
+		t.Fatalf("failed to validate on single node: %s", err.Error())
+	}
+	if vals[0].Error != "" {
+		t.Fatalf("valid statement reported invalid: %s", vals[0].Error)
+	}
+	if exp, got := "no such table: bar", vals[1].Error; exp != got {
+		t.Fatalf("wrong error, exp %s, got %s", exp, got)
+	}
+
+	qr := queryRequestFromString(`SELECT COUNT(*) FROM foo`, false, false)
+	r, err := s.Query(qr)
+	if err != nil {
+		t.Fatalf("failed to query single node: %s", err.Error())
+	}
+	if exp, got := `[[0]]`, asJSON(r[0].Values); exp != got {
+		t.Fatalf("validation changed the database\nexp: %s\ngot: %s", exp, got)
+	}
+}
+
+func Test_SingleNodeQueryMetadata(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	er := executeRequestFromString(`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`, false, false)
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+
+	for _, lvl := range []command.QueryRequest_Level{
+		command.QueryRequest_QUERY_REQUEST_LEVEL_NONE,
+		command.QueryRequest_QUERY_REQUEST_LEVEL_STRONG,
+	} {
+		qr := queryRequestFromString(`SELECT id, name AS n FROM foo`, false, false)
+		qr.Level = lvl
+		qr.Metadata = true
+		r, err := s.Query(qr)
+		if err != nil {
+			t.Fatalf("failed to query single node: %s", err.Error())
+		}
+		md := r[0].Metadata
+		if len(md) != 2 {
+			t.Fatalf("wrong number of column metadata: %d", len(md))
+		}
+		if md[0].Table != "foo" || md[0].Column != "id" || !md[0].PrimaryKey || !md[0].NotNull {
+			t.Fatalf("wrong metadata for id: %v", md[0])
+		}
+		if md[1].Table != "foo" || md[1].Column != "name" || md[1].DeclType != "TEXT" || md[1].NotNull {
+			t.Fatalf("wrong metadata for name: %v", md[1])
+		}
+	}
+
+	eqr := executeQueryRequestFromString(`SELECT name FROM foo`, command.QueryRequest_QUERY_REQUEST_LEVEL_WEAK, false, false)
+	eqr.Metadata = true
+	results, err := s.Request(eqr)
+	if err != nil {
+		t.Fatalf("failed to request on single node: %s", err.Error())
+	}
+	if md := results[0].GetQ().Metadata; len(md) != 1 || md[0].Column != "name" {
+		t.Fatalf("wrong metadata for request: %v", md)
+	}
+
+	qr := queryRequestFromString(`SELECT id FROM foo`, false, false)
+	r, err := s.Query(qr)
+	if err != nil {
+		t.Fatalf("failed to query single node: %s", err.Error())
+	}
+	if r[0].Metadata != nil {
+		t.Fatalf("metadata returned when not requested: %v", r[0].Metadata)
+	}
+}
+
+func Test_SingleNodeIdempotencyKey(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	er := executeRequestFromString(`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`, false, false)
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+
+	for i := 0; i < 2; i++ {
+		er := executeRequestFromString(`INSERT INTO foo(name) VALUES("fiona")`, false, false)
+		er.Request.IdempotencyKey = "key1"
+		r, err := s.Execute(er)
+		if err != nil {
+			t.Fatalf("failed to execute on single node: %s", err.Error())
+		}
+		if exp, got := `[{"last_insert_id":1,"rows_affected":1}]`, asJSON(r); exp != got {
+			t.Fatalf("unexpected results for execute %d\nexp: %s\ngot: %s", i, exp, got)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		eqr := executeQueryRequestFromStrings([]string{
+			`INSERT INTO foo(name) VALUES("declan")`,
+			`SELECT COUNT(*) FROM foo`,
+		}, command.QueryRequest_QUERY_REQUEST_LEVEL_WEAK, false, false)
+		eqr.Request.IdempotencyKey = "key2"
+		r, err := s.Request(eqr)
+		if err != nil {
+			t.Fatalf("failed to request on single node: %s", err.Error())
+		}
+		if exp, got := `[{"last_insert_id":2,"rows_affected":1},{"columns":["COUNT(*)"],"types":["integer"],"values":[[2]]}]`, asJSON(r); exp != got {
+			t.Fatalf("unexpected results for request %d\nexp: %s\ngot: %s", i, exp, got)
+		}
+	}
+
+	er = executeRequestFromString(`INSERT INTO foo(name) VALUES("fiona")`, false, false)
+	er.Request.IdempotencyKey = "key2"
+	if _, err := s.Execute(er); err != ErrIdempotencyKeyReused {
+		t.Fatalf("wrong error for reused idempotency key: %v", err)
+	}
+
+	qr := queryRequestFromString(`SELECT COUNT(*) FROM foo`, false, false)
+	r, err := s.Query(qr)
+	if err != nil {
+		t.Fatalf("failed to query single node: %s", err.Error())
+	}
+	if exp, got := `[[2]]`, asJSON(r[0].Values); exp != got {
+		t.Fatalf("duplicate requests were applied\nexp: %s\ngot: %s", exp, got)
+	}
+}
+
+func Test_SingleNodeIdempotencyKeyCapacity(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+	s.IdempotencyKeysCapacity = 1
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	er := executeRequestFromString(`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`, false, false)
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+
+	// Only the most recent key is recorded, so the first is forgotten, and
+	// its request applied again.
+	for _, key := range []string{"key1", "key2", "key1"} {
+		er := executeRequestFromString(`INSERT INTO foo(name) VALUES("fiona")`, false, false)
+		er.Request.IdempotencyKey = key
+		if _, err := s.Execute(er); err != nil {
+			t.Fatalf("failed to execute on single node: %s", err.Error())
+		}
+	}
+
+	qr := queryRequestFromString(`SELECT COUNT(*) FROM foo`, false, false)
+	r, err := s.Query(qr)
+	if err != nil {
+		t.Fatalf("failed to query single node: %s", err.Error())
+	}
+	if exp, got := `[[3]]`, asJSON(r[0].Values); exp != got {
+		t.Fatalf("forgotten key not applied again\nexp: %s\ngot: %s", exp, got)
+	}
+	qr = queryRequestFromString(`SELECT key FROM _rqlite_idempotency_keys`, false, false)
+	r, err = s.Query(qr)
+	if err != nil {
+		t.Fatalf("failed to query single node: %s", err.Error())
+	}
+	if exp, got := `[["key1"]]`, asJSON(r[0].Values); exp != got {
+		t.Fatalf("unexpected keys recorded\nexp: %s\ngot: %s", exp, got)
+	}
+}
+
+func Test_SingleNodeCursor(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	er := executeRequestFromStrings([]string{
+		`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`,
+		`INSERT INTO foo(id, name) VALUES(1, "fiona")`,
+		`INSERT INTO foo(id, name) VALUES(2, "declan")`,
+		`INSERT INTO foo(id, name) VALUES(3, "aoife")`,
+	}, false, false)
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+
+	qr := queryRequestFromString("SELECT * FROM foo ORDER BY id", false, false)
+	id, r, _, err := s.OpenCursor(qr, 2)
+	if err != nil {
+		t.Fatalf("failed to open cursor: %s", err.Error())
+	}
+	if id == "" {
+		t.Fatalf("cursor closed with rows remaining")
+	}
+	if exp, got := `[[1,"fiona"],[2,"declan"]]`, asJSON(r.Values); exp != got {
+		t.Fatalf("unexpected first page\nexp: %s\ngot: %s", exp, got)
+	}
+
+	// Rows written after the cursor is opened are not read.
+	er = executeRequestFromString(`INSERT INTO foo(id, name) VALUES(4, "cian")`, false, false)
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+	r, more, _, err := s.FetchCursor(id, 2)
+	if err != nil {
+		t.Fatalf("failed to fetch from cursor: %s", err.Error())
+	}
+	if exp, got := `[[3,"aoife"]]`, asJSON(r.Values); exp != got || more {
+		t.Fatalf("unexpected last page\nexp: %s\ngot: %s (more %v)", exp, got, more)
+	}
+	if _, _, _, err := s.FetchCursor(id, 2); err != ErrCursorNotFound {
+		t.Fatalf("expected ErrCursorNotFound for read cursor, got %v", err)
+	}
+
+	// All the rows fit in the first page, so no cursor is held.
+	id, r, _, err = s.OpenCursor(qr, 10)
+	if err != nil || id != "" || len(r.Values) != 4 {
+		t.Fatalf("unexpected cursor for single page: %q, %v, %v", id, r, err)
+	}
+
+	// Cursors are closed by request, and by checkpoints.
+	id, _, _, err = s.OpenCursor(qr, 1)
+	if err != nil {
+		t.Fatalf("failed to open cursor: %s", err.Error())
+	}
+	if err := s.CloseCursor(id); err != nil {
+		t.Fatalf("failed to close cursor: %s", err.Error())
+	}
+	if _, _, _, err := s.FetchCursor(id, 1); err != ErrCursorNotFound {
+		t.Fatalf("expected ErrCursorNotFound for closed cursor, got %v", err)
+	}
+	id, _, _, err = s.OpenCursor(qr, 1)
+	if err != nil {
+		t.Fatalf("failed to open cursor: %s", err.Error())
+	}
+	if err := s.checkpoint(); err != nil {
+		t.Fatalf("failed to checkpoint with cursor open: %s", err.Error())
+	}
+	if _, _, _, err := s.FetchCursor(id, 1); err != ErrCursorNotFound {
+		t.Fatalf("expected ErrCursorNotFound after checkpoint, got %v", err)
+	}
+
+	// Errors executing the query are returned in the results.
+	id, r, _, err = s.OpenCursor(queryRequestFromString("SELECT * FROM bar", false, false), 1)
+	if err != nil || id != "" || r.Error == "" {
+		t.Fatalf("expected error in results for bad query: %q, %v, %v", id, r, err)
+	}
+
+	s.MaxCursors = 1
+	if _, _, _, err := s.OpenCursor(qr, 1); err != nil {
+		t.Fatalf("failed to open cursor: %s", err.Error())
+	}
+	if _, _, _, err := s.OpenCursor(qr, 1); err != ErrTooManyCursors {
+		t.Fatalf("expected ErrTooManyCursors, got %v", err)
+	}
+	if _, _, _, err := s.OpenCursor(queryRequestFromStrings([]string{"SELECT 1", "SELECT 2"}, false, false), 1); err != ErrCursorStatements {
+		t.Fatalf("expected ErrCursorStatements, got %v", err)
+	}
+	qr.Level = command.QueryRequest_QUERY_REQUEST_LEVEL_STRONG
+	if _, _, _, err := s.OpenCursor(qr, 1); err != ErrCursorStrong {
+		t.Fatalf("expected ErrCursorStrong, got %v", err)
+	}
+}
+
+func Test_SingleNodeTransaction(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	er := executeRequestFromStrings([]string{
+		`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, balance INTEGER)`,
+		`INSERT INTO foo(id, balance) VALUES(1, 100)`,
+		`CREATE TABLE bar (id INTEGER NOT NULL PRIMARY KEY)`,
+	}, false, false)
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+	qr := queryRequestFromString("SELECT balance FROM foo WHERE id = 1", false, false)
+
+	id, err := s.BeginTransaction()
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %s", err.Error())
+	}
+	r, _, err := s.TransactionQuery(id, qr)
+	if err != nil {
+		t.Fatalf("failed to query in transaction: %s", err.Error())
+	}
+	if exp, got := `[[100]]`, asJSON(r[0].Values); exp != got {
+		t.Fatalf("unexpected results in transaction\nexp: %s\ngot: %s", exp, got)
+	}
+	if err := s.TransactionExecute(id, []*command.Statement{{Sql: `UPDATE foo SET balance = 50 WHERE id = 1`}}); err != nil {
+		t.Fatalf("failed to execute in transaction: %s", err.Error())
+	}
+	if err := s.TransactionExecute(id, []*command.Statement{{Sql: `INSERT INTO foo(id, balance) VALUES(2, 50)`}}); err != nil {
+		t.Fatalf("failed to execute in transaction: %s", err.Error())
+	}
+
+	// Writes are visible to the transaction, but not elsewhere until the
+	// transaction commits.
+	r, _, err = s.TransactionQuery(id, queryRequestFromString("SELECT COUNT(*), SUM(balance) FROM foo", false, false))
+	if err != nil || asJSON(r[0].Values) != `[[2,100]]` {
+		t.Fatalf("transaction did not read its writes: %v, %v", r, err)
+	}
+	r, err = s.Query(queryRequestFromString("SELECT COUNT(*) FROM foo", false, false))
+	if err != nil || asJSON(r[0].Values) != `[[1]]` {
+		t.Fatalf("uncommitted writes visible: %v, %v", r, err)
+	}
+	results, err := s.CommitTransaction(id)
+	if err != nil {
+		t.Fatalf("failed to commit transaction: %s", err.Error())
+	}
+	if len(results) != 2 || results[1].LastInsertId != 2 {
+		t.Fatalf("unexpected commit results: %v", results)
+	}
+	r, err = s.Query(queryRequestFromString("SELECT SUM(balance) FROM foo", false, false))
+	if err != nil || asJSON(r[0].Values) != `[[100]]` {
+		t.Fatalf("unexpected results after commit: %v, %v", r, err)
+	}
+	if _, err := s.CommitTransaction(id); err != ErrTransactionNotFound {
+		t.Fatalf("expected ErrTransactionNotFound for committed transaction, got %v", err)
+	}
+
+	// A transaction does not conflict with writes made since it began to
+	// tables it did not read.
+	id, err = s.BeginTransaction()
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %s", err.Error())
+	}
+	if _, _, err := s.TransactionQuery(id, qr); err != nil {
+		t.Fatalf("failed to query in transaction: %s", err.Error())
+	}
+	if err := s.TransactionExecute(id, []*command.Statement{{Sql: `UPDATE foo SET balance = 40 WHERE id = 1`}}); err != nil {
+		t.Fatalf("failed to execute in transaction: %s", err.Error())
+	}
+	if _, err := s.Execute(executeRequestFromString(`INSERT INTO bar(id) VALUES(1)`, false, false)); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+	if _, err := s.CommitTransaction(id); err != nil {
+		t.Fatalf("failed to commit transaction: %s", err.Error())
+	}
+
+	// A transaction conflicts with writes made since it began to tables it
+	// read, whether it commits or queries after its own writes.
+	id, err = s.BeginTransaction()
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %s", err.Error())
+	}
+	if _, err := s.Execute(executeRequestFromString(`UPDATE foo SET balance = 60 WHERE id = 1`, false, false)); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+	r, _, err = s.TransactionQuery(id, qr)
+	if err != nil || asJSON(r[0].Values) != `[[40]]` {
+		t.Fatalf("transaction read write made since it began: %v, %v", r, err)
+	}
+	if err := s.TransactionExecute(id, []*command.Statement{{Sql: `UPDATE foo SET balance = 0 WHERE id = 1`}}); err != nil {
+		t.Fatalf("failed to execute in transaction: %s", err.Error())
+	}
+	if _, _, err := s.TransactionQuery(id, qr); err != ErrTransactionConflict {
+		t.Fatalf("expected ErrTransactionConflict for query after writes, got %v", err)
+	}
+	if _, err := s.CommitTransaction(id); err != ErrTransactionConflict {
+		t.Fatalf("expected ErrTransactionConflict, got %v", err)
+	}
+	r, err = s.Query(qr)
+	if err != nil || asJSON(r[0].Values) != `[[60]]` {
+		t.Fatalf("conflicting transaction committed: %v, %v", r, err)
+	}
+
+	// A table emptied by a DELETE without a WHERE clause is written too.
+	id, err = s.BeginTransaction()
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %s", err.Error())
+	}
+	if _, _, err := s.TransactionQuery(id, queryRequestFromString("SELECT COUNT(*) FROM bar", false, false)); err != nil {
+		t.Fatalf("failed to query in transaction: %s", err.Error())
+	}
+	if err := s.TransactionExecute(id, []*command.Statement{{Sql: `INSERT INTO foo(id, balance) VALUES(3, 0)`}}); err != nil {
+		t.Fatalf("failed to execute in transaction: %s", err.Error())
+	}
+	if _, err := s.Execute(executeRequestFromString(`DELETE FROM bar`, false, false)); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+	if _, err := s.CommitTransaction(id); err != ErrTransactionConflict {
+		t.Fatalf("expected ErrTransactionConflict after table emptied, got %v", err)
+	}
+
+	// Transactions are rolled back by request, and by checkpoints.
+	id, err = s.BeginTransaction()
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %s", err.Error())
+	}
+	if err := s.RollbackTransaction(id); err != nil {
+		t.Fatalf("failed to roll back transaction: %s", err.Error())
+	}
+	if _, _, err := s.TransactionQuery(id, qr); err != ErrTransactionNotFound {
+		t.Fatalf("expected ErrTransactionNotFound for rolled back transaction, got %v", err)
+	}
+	id, err = s.BeginTransaction()
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %s", err.Error())
+	}
+	if err := s.checkpoint(); err != nil {
+		t.Fatalf("failed to checkpoint with transaction open: %s", err.Error())
+	}
+	if err := s.TransactionExecute(id, nil); err != ErrTransactionNotFound {
+		t.Fatalf("expected ErrTransactionNotFound after checkpoint, got %v", err)
+	}
+
+	s.MaxTransactions = 1
+	if _, err := s.BeginTransaction(); err != nil {
+		t.Fatalf("failed to begin transaction: %s", err.Error())
+	}
+	if _, err := s.BeginTransaction(); err != ErrTooManyTransactions {
+		t.Fatalf("expected ErrTooManyTransactions, got %v", err)
+	}
+}
+
+func Test_SingleNodeLowMemory(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+	s.LowMemory = true
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+	if n := s.raftConfig().MaxAppendEntries; n != lowMemoryMaxAppendEntries {
+		t.Fatalf("wrong max append entries, exp %d, got %d", lowMemoryMaxAppendEntries, n)
+	}
+	if s.restoreChunkSize != lowMemoryChunkSize {
+		t.Fatalf("wrong restore chunk size, exp %d, got %d", lowMemoryChunkSize, s.restoreChunkSize)
+	}
+
+	er := executeRequestFromStrings([]string{
+		`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`,
+		`INSERT INTO foo(id, name) VALUES(1, "fiona")`,
+	}, false, false)
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+	qr := queryRequestFromString("SELECT * FROM foo", false, false)
+	r, err := s.Query(qr)
+	if err != nil {
+		t.Fatalf("failed to query single node: %s", err.Error())
+	}
+	if exp, got := `[[1,"fiona"]]`, asJSON(r[0].Values); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+}
+
+func Test_SingleNodeInMemory(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+	memDir := t.TempDir()
+	s.dbConf.InMemory = true
+	s.dbConf.MemoryDir = memDir
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+	if filepath.Dir(filepath.Dir(s.db.Path())) != memDir {
+		t.Fatalf("database not in memory directory, path is %s", s.db.Path())
+	}
+	er := executeRequestFromStrings([]string{
+		`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`,
+		`INSERT INTO foo(id, name) VALUES(1, "fiona")`,
+	}, false, false)
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+	fsmIdx, err := s.WaitForAppliedFSM(5 * time.Second)
+	if err != nil {
+		t.Fatalf("failed to wait for fsmIndex: %s", err.Error())
+	}
+
+	// Closing frees the database, and reopening rebuilds it from the log.
+	dbPath := s.db.Path()
+	if err := s.Close(true); err != nil {
+		t.Fatalf("failed to close single-node store: %s", err.Error())
+	}
+	if entries, err := os.ReadDir(memDir); err != nil || len(entries) != 0 {
+		t.Fatalf("in-memory database not removed on close: %v, %v", entries, err)
+	}
+
+	// A database left behind, as if the Store was not closed cleanly, is
+	// cleared when the Store next opens, rather than leaked.
+	stale := filepath.Join(filepath.Dir(dbPath), "stale")
+	if err := os.MkdirAll(filepath.Dir(stale), 0755); err != nil {
+		t.Fatalf("failed to create memory directory: %s", err.Error())
+	}
+	if err := os.WriteFile(stale, []byte("stale"), 0644); err != nil {
+		t.Fatalf("failed to write stale file: %s", err.Error())
+	}
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if s.db.Path() != dbPath {
+		t.Fatalf("in-memory database moved on reopen, exp %s, got %s", dbPath, s.db.Path())
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Fatalf("stale file not cleared from memory directory: %v", err)
+	}
+	defer s.Close(true)
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+	if _, err := s.WaitForFSMIndex(fsmIdx, 5*time.Second); err != nil {
+		t.Fatalf("error waiting for index to be applied: %s:", err.Error())
+	}
+	qr := queryRequestFromString("SELECT * FROM foo", false, false)
+	qr.Level = command.QueryRequest_QUERY_REQUEST_LEVEL_NONE
+	r, err := s.Query(qr)
+	if err != nil {
+		t.Fatalf("failed to query single node: %s", err.Error())
+	}
+	if exp, got := `[[1,"fiona"]]`, asJSON(r[0].Values); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+}
+
+func Test_SingleNodeSubscribe(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	er := executeRequestFromStrings([]string{
+		`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`,
+		`CREATE TABLE bar (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`,
+	}, false, false)
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+
+	sub, err := s.Subscribe([]string{"FOO"})
+	if err != nil {
+		t.Fatalf("failed to subscribe: %s", err.Error())
+	}
+	er = executeRequestFromStrings([]string{
+		`INSERT INTO foo(id, name) VALUES(1, "fiona")`,
+		`INSERT INTO bar(id, name) VALUES(1, "declan")`,
+		`UPDATE foo SET name = "aoife" WHERE id = 1`,
+		`DELETE FROM foo WHERE id = 1`,
+	}, false, false)
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+	for _, op := range []string{"insert", "update", "delete"} {
+		select {
+		case rc := <-sub.C():
+			if rc.Table != "foo" || rc.Op != op || rc.RowID != 1 || rc.Index == 0 {
+				t.Fatalf("unexpected row change, exp %s of row 1 of foo, got %v", op, rc)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s", op)
+		}
+	}
+	s.Unsubscribe(sub)
+	if _, ok := <-sub.C(); ok || sub.Err() != nil {
+		t.Fatalf("subscription not ended cleanly: %v", sub.Err())
+	}
+
+	// A subscription which falls behind overflows.
+	sub, err = s.Subscribe(nil)
+	if err != nil {
+		t.Fatalf("failed to subscribe: %s", err.Error())
+	}
+	er = executeRequestFromString(`WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x+1 FROM c LIMIT 2000) INSERT INTO bar(id, name) SELECT x+1, "x" FROM c`, false, false)
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+	n := 0
+	for range sub.C() {
+		n++
+	}
+	if n != subscriptionBuffer || sub.Err() != ErrSubscriptionOverflow {
+		t.Fatalf("expected overflow after %d changes, got %v after %d", subscriptionBuffer, sub.Err(), n)
+	}
+}
+
+func Test_SingleNodeRowChanges(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+	s.RowChangeLogSize = 8
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	for _, stmts := range [][]string{
+		{`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`},
+		{`INSERT INTO foo(id, name) VALUES(1, "fiona")`, `INSERT INTO foo(id, name) VALUES(2, "declan")`, `UPDATE foo SET name = "aoife" WHERE id = 1`},
+		{`DELETE FROM foo WHERE id = 2`},
+	} {
+		if _, err := s.Execute(executeRequestFromStrings(stmts, false, false)); err != nil {
+			t.Fatalf("failed to execute on single node: %s", err.Error())
+		}
+	}
+
+	changes, next, err := s.RowChanges(0, 100)
+	if err != nil {
+		t.Fatalf("failed to get row changes: %s", err.Error())
+	}
+	var got []string
+	for _, rc := range changes {
+		got = append(got, fmt.Sprintf("%s %s %d", rc.Op, rc.Table, rc.RowID))
+	}
+	if exp := "insert foo 1,insert foo 2,update foo 1,delete foo 2"; strings.Join(got, ",") != exp {
+		t.Fatalf("unexpected row changes\nexp: %s\ngot: %s", exp, strings.Join(got, ","))
+	}
+	if next != changes[3].Index {
+		t.Fatalf("wrong next index, exp %d, got %d", changes[3].Index, next)
+	}
+
+	// The changes of a single entry are never split.
+	changes, next, err = s.RowChanges(0, 1)
+	if err != nil {
+		t.Fatalf("failed to get row changes: %s", err.Error())
+	}
+	if len(changes) != 3 || next != changes[2].Index {
+		t.Fatalf("expected the 3 changes of the first entry, got %d, next %d", len(changes), next)
+	}
+	changes, _, err = s.RowChanges(next, 1)
+	if err != nil || len(changes) != 1 || changes[0].Op != "delete" {
+		t.Fatalf("expected the change of the second entry, got %v, %v", changes, err)
+	}
+
+	// Changes beyond the log size are discarded.
+	er := executeRequestFromString(`WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x+1 FROM c LIMIT 20) INSERT INTO foo(id, name) SELECT x+10, "x" FROM c`, false, false)
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+	_, start, err := s.RowChanges(0, 100)
+	if err != ErrRowChangesMissed {
+		t.Fatalf("expected changes to be missed, got %v", err)
+	}
+	if changes, _, err = s.RowChanges(start, 100); err != nil || len(changes) != 0 {
+		t.Fatalf("expected no changes after %d, got %v, %v", start, changes, err)
+	}
+}
+
+func Test_SingleNodeChecksum(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	er := executeRequestFromStrings([]string{
+		`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`,
+		`INSERT INTO foo(id, name) VALUES(1, "fiona")`,
+	}, false, false)
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+
+	idx, sums, err := s.Checksum()
+	if err != nil {
+		t.Fatalf("failed to take checksum: %s", err.Error())
+	}
+	if _, ok := sums["foo"]; !ok || len(sums) != 1 {
+		t.Fatalf("unexpected checksums: %v", sums)
+	}
+	got, err := s.ChecksumAt(idx)
+	if err != nil {
+		t.Fatalf("failed to get checksum at index %d: %s", idx, err.Error())
+	}
+	if !reflect.DeepEqual(sums, got) {
+		t.Fatalf("checksums differ, exp %v, got %v", sums, got)
+	}
+	if _, err := s.ChecksumAt(idx - 1); err != ErrChecksumNotFound {
+		t.Fatalf("expected ErrChecksumNotFound, got %v", err)
+	}
+
+	// Changing the table must change its checksum.
+	er = executeRequestFromString(`INSERT INTO foo(id, name) VALUES(2, "fiona")`, false, false)
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+	_, sums2, err := s.Checksum()
+	if err != nil {
+		t.Fatalf("failed to take checksum: %s", err.Error())
+	}
+	if sums2["foo"] == sums["foo"] {
+		t.Fatalf("checksum unchanged after insert")
+	}
+}
+
+// Test_SingleNodeExecuteQueryFail ensures database level errors are presented by the store.
+func Test_SingleNodeExecuteQueryFail(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	er := executeRequestFromStrings([]string{
+		`INSERT INTO foo(id, name) VALUES(1, "fiona")`,
+	}, false, false)
+	r, err := s.Execute(er)
+	if err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+	if exp, got := "no such table: foo", r[0].Error; exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+}
+
+// Test_SingleNodeExecuteQueryTx tests that a Store correctly responds to a simple
+// Execute and Query request, when the request is wrapped in a transaction.
+func Test_SingleNodeExecuteQueryTx(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	er := executeRequestFromStrings([]string{
+		`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`,
+		`INSERT INTO foo(id, name) VALUES(1, "fiona")`,
+	}, false, true)
+	_, err := s.Execute(er)
+	if err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+
+	qr := queryRequestFromString("SELECT * FROM foo", false, true)
+	var r []*command.QueryRows
+
+	qr.Level = command.QueryRequest_QUERY_REQUEST_LEVEL_NONE
+	_, err = s.Query(qr)
+	if err != nil {
+		t.Fatalf("failed to query single node: %s", err.Error())
 	}
 
-	er := executeRequestFromStrings([]string{
-		`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`,
-		`INSERT INTO foo(id, name) VALUES(1, "fiona")`,
-	}, false, false)
-	_, err = s.Execute(er)
+	qr.Level = command.QueryRequest_QUERY_REQUEST_LEVEL_WEAK
+	_, err = s.Query(qr)
 	if err != nil {
-		t.Fatalf("failed to execute on single node: %s", err.Error())
+		t.Fatalf("failed to query single node: %s", err.Error())
 	}
 
-	qr := queryRequestFromString("SELECT * FROM foo", false, false)
-	qr.Level = command.QueryRequest_QUERY_REQUEST_LEVEL_NONE
-	r, err := s.Query(qr)
+	qr.Level = command.QueryRequest_QUERY_REQUEST_LEVEL_STRONG
+	r, err = s.Query(qr)
 	if err != nil {
 		t.Fatalf("failed to query single node: %s", err.Error())
 	}
@@ -573,10 +1675,12 @@ func Test_SingleNodeExecuteQuery(t *testing.T) {
 	}
 }
 
-// Test_SingleNodeExecuteQueryFail ensures database level errors are presented by the store.
-func Test_SingleNodeExecuteQueryFail(t *testing.T) {
+// Test_SingleNodeExecuteChunked tests that execute requests larger than the
+// chunk size are split across log entries, and applied as a single request.
+func Test_SingleNodeExecuteChunked(t *testing.T) {
 	s, ln := mustNewStore(t)
 	defer ln.Close()
+	s.ExecuteChunkSize = 256
 
 	if err := s.Open(); err != nil {
 		t.Fatalf("failed to open single-node store: %s", err.Error())
@@ -590,20 +1694,83 @@ func Test_SingleNodeExecuteQueryFail(t *testing.T) {
 	}
 
 	er := executeRequestFromStrings([]string{
-		`INSERT INTO foo(id, name) VALUES(1, "fiona")`,
+		`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`,
 	}, false, false)
-	r, err := s.Execute(er)
-	if err != nil {
+	if _, err := s.Execute(er); err != nil {
 		t.Fatalf("failed to execute on single node: %s", err.Error())
 	}
-	if exp, got := "no such table: foo", r[0].Error; exp != got {
+
+	inserts := func(start, n int) []string {
+		var stmts []string
+		for i := start; i < start+n; i++ {
+			stmts = append(stmts, fmt.Sprintf(`INSERT INTO foo(id, name) VALUES(%d, "fiona")`, i))
+		}
+		return stmts
+	}
+	count := func() string {
+		t.Helper()
+		qr := queryRequestFromString("SELECT COUNT(*) FROM foo", false, false)
+		qr.Level = command.QueryRequest_QUERY_REQUEST_LEVEL_STRONG
+		r, err := s.Query(qr)
+		if err != nil {
+			t.Fatalf("failed to query single node: %s", err.Error())
+		}
+		return asJSON(r[0].Values)
+	}
+
+	nChunked := stats.Get(numChunkedExecutes).(*expvar.Int).Value()
+	idx := s.raft.LastIndex()
+	res, err := s.Execute(executeRequestFromStrings(inserts(1, 50), false, true))
+	if err != nil {
+		t.Fatalf("failed to execute chunked request: %s", err.Error())
+	}
+	if exp, got := 50, len(res); exp != got {
+		t.Fatalf("wrong number of results, exp %d, got %d", exp, got)
+	}
+	if exp, got := int64(50), res[49].LastInsertId; exp != got {
+		t.Fatalf("wrong last insert ID, exp %d, got %d", exp, got)
+	}
+	if s.raft.LastIndex()-idx < 2 {
+		t.Fatalf("chunked request was written as a single log entry")
+	}
+	if exp, got := nChunked+1, stats.Get(numChunkedExecutes).(*expvar.Int).Value(); exp != got {
+		t.Fatalf("wrong number of chunked executes, exp %d, got %d", exp, got)
+	}
+	if exp, got := `[[50]]`, count(); exp != got {
 		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
 	}
+
+	// The final statement fails, so the whole transaction is rolled back,
+	// including the statements of earlier chunks.
+	res, err = s.Execute(executeRequestFromStrings(append(inserts(51, 50), inserts(1, 1)...), false, true))
+	if err != nil {
+		t.Fatalf("failed to execute chunked request: %s", err.Error())
+	}
+	if res[len(res)-1].Error == "" {
+		t.Fatalf("expected error for final statement")
+	}
+	if exp, got := `[[50]]`, count(); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+	if exp, got := 0, s.execDechunkManager.Len(); exp != got {
+		t.Fatalf("chunked requests still pending: %d", got)
+	}
+
+	// No snapshot may be taken while a chunked request is pending.
+	s.execDechunkManager.Get("pending")
+	if _, err := s.Snapshot(); !errors.Is(err, ErrChunkedExecutePending) {
+		t.Fatalf("expected ErrChunkedExecutePending, got %v", err)
+	}
+	s.execDechunkManager.Delete("pending")
+	if err := s.raft.Snapshot().Error(); err != nil {
+		t.Fatalf("failed to snapshot: %s", err.Error())
+	}
 }
 
-// Test_SingleNodeExecuteQueryTx tests that a Store correctly responds to a simple
-// Execute and Query request, when the request is wrapped in a transaction.
-func Test_SingleNodeExecuteQueryTx(t *testing.T) {
+// Test_SingleNodeSnapshotWritesContinue tests that writes are not blocked
+// while a full snapshot is persisted, and that the snapshot does not include
+// them.
+func Test_SingleNodeSnapshotWritesContinue(t *testing.T) {
 	s, ln := mustNewStore(t)
 	defer ln.Close()
 
@@ -621,35 +1788,43 @@ func Test_SingleNodeExecuteQueryTx(t *testing.T) {
 	er := executeRequestFromStrings([]string{
 		`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`,
 		`INSERT INTO foo(id, name) VALUES(1, "fiona")`,
-	}, false, true)
-	_, err := s.Execute(er)
-	if err != nil {
+	}, false, false)
+	if _, err := s.Execute(er); err != nil {
 		t.Fatalf("failed to execute on single node: %s", err.Error())
 	}
 
-	qr := queryRequestFromString("SELECT * FROM foo", false, true)
-	var r []*command.QueryRows
-
-	qr.Level = command.QueryRequest_QUERY_REQUEST_LEVEL_NONE
-	_, err = s.Query(qr)
+	// No snapshot has been taken, so this snapshot is full.
+	f, err := s.Snapshot()
 	if err != nil {
-		t.Fatalf("failed to query single node: %s", err.Error())
+		t.Fatalf("failed to snapshot node: %s", err.Error())
+	}
+	er = executeRequestFromStrings([]string{
+		`INSERT INTO foo(id, name) VALUES(2, "fiona")`,
+	}, false, false)
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute while snapshot held: %s", err.Error())
 	}
 
-	qr.Level = command.QueryRequest_QUERY_REQUEST_LEVEL_WEAK
-	_, err = s.Query(qr)
+	snapFile, err := os.Create(filepath.Join(t.TempDir(), "snapshot"))
 	if err != nil {
-		t.Fatalf("failed to query single node: %s", err.Error())
+		t.Fatalf("failed to create snapshot file: %s", err.Error())
 	}
+	defer snapFile.Close()
+	if err := f.Persist(&mockSnapshotSink{snapFile}); err != nil {
+		t.Fatalf("failed to persist snapshot to disk: %s", err.Error())
+	}
+	f.Release()
 
-	qr.Level = command.QueryRequest_QUERY_REQUEST_LEVEL_STRONG
-	r, err = s.Query(qr)
+	if _, err := snapFile.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("failed to seek snapshot file: %s", err.Error())
+	}
+	if err := s.Restore(snapFile); err != nil {
+		t.Fatalf("failed to restore snapshot from disk: %s", err.Error())
+	}
+	r, err := s.Query(queryRequestFromString("SELECT * FROM foo", false, false))
 	if err != nil {
 		t.Fatalf("failed to query single node: %s", err.Error())
 	}
-	if exp, got := `["id","name"]`, asJSON(r[0].Columns); exp != got {
-		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
-	}
 	if exp, got := `[[1,"fiona"]]`, asJSON(r[0].Values); exp != got {
 		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
 	}
@@ -1462,6 +2637,14 @@ func Test_SingleNodeAutoRestore(t *testing.T) {
 	}
 
 	testPoll(t, s.Ready, 100*time.Millisecond, 2*time.Second)
+	select {
+	case <-s.AutoRestoreDone():
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for auto-restore")
+	}
+	if err := s.AutoRestoreErr(); err != nil {
+		t.Fatalf("unexpected auto-restore error: %s", err)
+	}
 	qr := queryRequestFromString("SELECT * FROM foo WHERE id=2", false, true)
 	r, err := s.Query(qr)
 	if err != nil {
@@ -1475,6 +2658,50 @@ func Test_SingleNodeAutoRestore(t *testing.T) {
 	}
 }
 
+func Test_SingleNodeAutoRestoreDump(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+
+	path := mustCreateTempFile()
+	dump := `PRAGMA foreign_keys=OFF;
+BEGIN TRANSACTION;
+CREATE TABLE foo (id integer not null primary key, name text);
+INSERT INTO "foo" VALUES(1,'fiona');
+INSERT INTO "foo" VALUES(2,'declan');
+COMMIT;
+`
+	if err := os.WriteFile(path, []byte(dump), 0644); err != nil {
+		t.Fatalf("failed to write dump: %s", err.Error())
+	}
+	if err := s.SetRestorePath(path); err != nil {
+		t.Fatalf("failed to set restore path: %s", err.Error())
+	}
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	testPoll(t, s.Ready, 100*time.Millisecond, 2*time.Second)
+	qr := queryRequestFromString("SELECT * FROM foo WHERE id=2", false, true)
+	r, err := s.Query(qr)
+	if err != nil {
+		t.Fatalf("failed to query single node: %s", err.Error())
+	}
+	if exp, got := `[[2,"declan"]]`, asJSON(r[0].Values); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("restore file not removed after restore")
+	}
+}
+
 func Test_SingleNodeSetRestoreFailStoreOpen(t *testing.T) {
 	s, ln := mustNewStore(t)
 	defer ln.Close()
@@ -1850,6 +3077,133 @@ func Test_SingleNodeWaitForRemove(t *testing.T) {
 	}
 }
 
+func Test_MultiNodeJoinInstallSnapshot(t *testing.T) {
+	s0, ln0 := mustNewStore(t)
+	defer ln0.Close()
+	s0.SnapshotThreshold = 4
+	s0.SnapshotInterval = 100 * time.Millisecond
+	if err := s0.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s0.Close(true)
+	if err := s0.Bootstrap(NewServer(s0.ID(), s0.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	if _, err := s0.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+	if _, _, err := s0.LatestSnapshot(); err != ErrNoSnapshot {
+		t.Fatalf("expected ErrNoSnapshot, got %v", err)
+	}
+
+	queries := []string{
+		`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`,
+		`INSERT INTO foo(id, name) VALUES(1, "fiona")`,
+		`INSERT INTO foo(id, name) VALUES(2, "fiona")`,
+		`INSERT INTO foo(id, name) VALUES(3, "fiona")`,
+		`INSERT INTO foo(id, name) VALUES(4, "fiona")`,
+	}
+	for i := range queries {
+		_, err := s0.Execute(executeRequestFromString(queries[i], false, false))
+		if err != nil {
+			t.Fatalf("failed to execute on single node: %s", err.Error())
+		}
+	}
+	testPoll(t, func() bool {
+		_, rc, err := s0.LatestSnapshot()
+		if err != nil {
+			return false
+		}
+		rc.Close()
+		return true
+	}, 100*time.Millisecond, 5*time.Second)
+	meta, rc, err := s0.LatestSnapshot()
+	if err != nil {
+		t.Fatalf("failed to open latest snapshot: %s", err.Error())
+	}
+	defer rc.Close()
+	snaps, err := s0.Snapshots()
+	if err != nil {
+		t.Fatalf("failed to list snapshots: %s", err.Error())
+	}
+	if len(snaps) == 0 || snaps[0].ID != meta.ID {
+		t.Fatalf("latest snapshot not listed first")
+	}
+
+	// The database held by the snapshot can be read too.
+	dbMeta, dbRC, err := s0.LatestSnapshotDB()
+	if err != nil {
+		t.Fatalf("failed to open latest snapshot database: %s", err.Error())
+	}
+	if dbMeta.ID != meta.ID {
+		t.Fatalf("wrong snapshot database opened, exp %s, got %s", meta.ID, dbMeta.ID)
+	}
+	f, err := os.CreateTemp("", "rqlite-snapdbtest-")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %s", err.Error())
+	}
+	defer os.Remove(f.Name())
+	n, err := io.Copy(f, dbRC)
+	if err != nil {
+		t.Fatalf("failed to read snapshot database: %s", err.Error())
+	}
+	f.Close()
+	if err := dbRC.Close(); err != nil {
+		t.Fatalf("failed to close snapshot database: %s", err.Error())
+	}
+	if n != dbMeta.Size {
+		t.Fatalf("wrong snapshot database size, exp %d, got %d", dbMeta.Size, n)
+	}
+	snapDB, err := db.Open(f.Name(), false, false)
+	if err != nil {
+		t.Fatalf("failed to open snapshot database: %s", err.Error())
+	}
+	rows, err := snapDB.QueryStringStmt("SELECT count(*) FROM foo")
+	snapDB.Close()
+	if err != nil {
+		t.Fatalf("failed to query snapshot database: %s", err.Error())
+	}
+	if exp, got := `[[4]]`, asJSON(rows[0].Values); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+
+	// Install the snapshot in a new node, and join it to the first.
+	s1, ln1 := mustNewStore(t)
+	defer ln1.Close()
+	if err := s1.InstallSnapshot(meta, rc); err != nil {
+		t.Fatalf("failed to install snapshot: %s", err.Error())
+	}
+	if err := s1.InstallSnapshot(meta, strings.NewReader("")); err != ErrNotNewNode {
+		t.Fatalf("expected ErrNotNewNode, got %v", err)
+	}
+	if err := s1.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s1.Close(true)
+	if err := s1.InstallSnapshot(meta, strings.NewReader("")); err != ErrOpen {
+		t.Fatalf("expected ErrOpen, got %v", err)
+	}
+	if err := s0.Join(joinRequest(s1.ID(), s1.Addr(), true)); err != nil {
+		t.Fatalf("failed to join to node at %s: %s", s0.Addr(), err.Error())
+	}
+	if _, err := s1.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("failed to get leader address on follower: %s", err.Error())
+	}
+	if err := s1.WaitForAppliedIndex(meta.Index, 5*time.Second); err != nil {
+		t.Fatalf("error waiting for follower to apply index: %s", err.Error())
+	}
+
+	qr := queryRequestFromString("SELECT count(*) FROM foo", false, false)
+	qr.Level = command.QueryRequest_QUERY_REQUEST_LEVEL_NONE
+	r, err := s1.Query(qr)
+	if err != nil {
+		t.Fatalf("failed to query follower: %s", err.Error())
+	}
+	if exp, got := `[[4]]`, asJSON(r[0].Values); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+}
+
 func Test_MultiNodeJoinRemove(t *testing.T) {
 	s0, ln0 := mustNewStore(t)
 	defer ln0.Close()
@@ -2207,6 +3561,70 @@ func Test_MultiNodeJoinNonVoterRemove(t *testing.T) {
 	}
 }
 
+func Test_MultiNodeSwapVoter(t *testing.T) {
+	s0, ln0 := mustNewStore(t)
+	defer ln0.Close()
+	if err := s0.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s0.Close(true)
+	if err := s0.Bootstrap(NewServer(s0.ID(), s0.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	if _, err := s0.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	s1, ln1 := mustNewStore(t)
+	defer ln1.Close()
+	if err := s1.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s1.Close(true)
+	if err := s0.Join(joinRequest(s1.ID(), s1.Addr(), true)); err != nil {
+		t.Fatalf("failed to join to node at %s: %s", s0.Addr(), err.Error())
+	}
+
+	s2, ln2 := mustNewStore(t)
+	defer ln2.Close()
+	if err := s2.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s2.Close(true)
+	if err := s0.Join(joinRequest(s2.ID(), s2.Addr(), false)); err != nil {
+		t.Fatalf("failed to join to node at %s: %s", s0.Addr(), err.Error())
+	}
+	if _, err := s2.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	if err := s0.SwapVoter(s1.ID(), "unknown"); err == nil {
+		t.Fatalf("expected error swapping for node which is not a member")
+	}
+	if err := s1.SwapVoter(s1.ID(), s2.ID()); err != ErrNotLeader {
+		t.Fatalf("expected not leader error, got %v", err)
+	}
+
+	if err := s0.SwapVoter(s1.ID(), s2.ID()); err != nil {
+		t.Fatalf("failed to swap %s for %s: %s", s1.ID(), s2.ID(), err.Error())
+	}
+	nodes, err := s0.Nodes()
+	if err != nil {
+		t.Fatalf("failed to get nodes post swap: %s", err.Error())
+	}
+	if len(nodes) != 2 {
+		t.Fatalf("size of cluster is not correct post swap, got %d", len(nodes))
+	}
+	for _, n := range nodes {
+		if n.ID == s1.ID() {
+			t.Fatalf("replaced node still member of cluster")
+		}
+		if n.Suffrage != "Voter" {
+			t.Fatalf("node %s is not a voter post swap", n.ID)
+		}
+	}
+}
+
 func Test_MultiNodeExecuteQuery(t *testing.T) {
 	s0, ln0 := mustNewStore(t)
 	defer ln0.Close()
@@ -2287,6 +3705,10 @@ func Test_MultiNodeExecuteQuery(t *testing.T) {
 	if err == nil {
 		t.Fatalf("successfully queried non-leader node")
 	}
+	qr.Level = command.QueryRequest_QUERY_REQUEST_LEVEL_LINEARIZABLE
+	if _, err = s1.Query(qr); err != ErrNotLeader {
+		t.Fatalf("expected ErrNotLeader for linearizable query of non-leader node, got %v", err)
+	}
 	qr.Level = command.QueryRequest_QUERY_REQUEST_LEVEL_NONE
 	r, err = s1.Query(qr)
 	if err != nil {
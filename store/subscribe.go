@@ -0,0 +1,187 @@
+package store
+
+import (
+	"errors"
+	"strings"
+
+	sql "github.com/rqlite/rqlite/db"
+)
+
+const (
+	// subscriptionBuffer is the number of row changes buffered for each
+	// subscription, beyond which it overflows.
+	subscriptionBuffer = 1024
+
+	// maxTransactionRowChanges is the number of row changes reported for a
+	// single transaction, beyond which every subscription overflows.
+	maxTransactionRowChanges = 10000
+)
+
+// ErrSubscriptionOverflow is returned by a subscription which has ended
+// because row changes could not be delivered to it. The subscriber must
+// reread any rows it depends on before subscribing again.
+var ErrSubscriptionOverflow = errors.New("subscription overflowed, changes were missed")
+
+// RowChange is a committed change to a row of a table, as applied to the
+// database of this node.
+type RowChange struct {
+	// Index is the index of the log entry which made the change.
+	Index uint64 `json:"index"`
+
+	Table string `json:"table"`
+	Op    string `json:"op"`
+	RowID int64  `json:"rowid"`
+}
+
+// Subscription delivers the changes to rows of the tables subscribed to, as
+// they are applied to the database of this node.
+type Subscription interface {
+	// C returns the channel on which changes are delivered. It is closed
+	// when the subscription ends, after which Err returns why.
+	C() <-chan *RowChange
+
+	// Err returns why the subscription ended, once C is closed. It is nil
+	// if the subscription was ended by Unsubscribe.
+	Err() error
+}
+
+type subscription struct {
+	tables map[string]bool
+	ch     chan *RowChange
+	err    error
+}
+
+func (sub *subscription) C() <-chan *RowChange {
+	return sub.ch
+}
+
+func (sub *subscription) Err() error {
+	return sub.err
+}
+
+// Subscribe subscribes to the changes to rows of the given tables, or of
+// every table if none are given, as they are applied to the database of this
+// node. Tables are matched without regard to case. A subscription which
+// falls behind the changes, or which would miss any because a single
+// transaction changed too many rows, ends with ErrSubscriptionOverflow. Rows
+// are not reported as changed when the database is replaced wholesale, such
+// as by a load, and SQLite does not report changes to rows of WITHOUT ROWID
+// tables, nor rows deleted by a DELETE without a WHERE clause.
+func (s *Store) Subscribe(tables []string) (Subscription, error) {
+	if !s.open {
+		return nil, ErrNotOpen
+	}
+	sub := &subscription{ch: make(chan *RowChange, subscriptionBuffer)}
+	if len(tables) > 0 {
+		sub.tables = make(map[string]bool, len(tables))
+		for _, t := range tables {
+			sub.tables[strings.ToLower(t)] = true
+		}
+	}
+
+	s.subscriptionsMu.Lock()
+	defer s.subscriptionsMu.Unlock()
+	if s.subscriptions == nil {
+		s.subscriptions = make(map[*subscription]struct{})
+	}
+	s.subscriptions[sub] = struct{}{}
+	stats.Add(numSubscriptions, 1)
+	return sub, nil
+}
+
+// Unsubscribe ends the subscription, if it has not already ended.
+func (s *Store) Unsubscribe(sub Subscription) {
+	if sub, ok := sub.(*subscription); ok {
+		s.endSubscription(sub, nil)
+	}
+}
+
+func (s *Store) numSubscriptions() int {
+	s.subscriptionsMu.Lock()
+	defer s.subscriptionsMu.Unlock()
+	return len(s.subscriptions)
+}
+
+func (s *Store) endSubscription(sub *subscription, err error) {
+	s.subscriptionsMu.Lock()
+	defer s.subscriptionsMu.Unlock()
+	s.endSubscriptionLocked(sub, err)
+}
+
+// endSubscriptionLocked ends the subscription with the given error.
+// subscriptionsMu must be held.
+func (s *Store) endSubscriptionLocked(sub *subscription, err error) {
+	if _, ok := s.subscriptions[sub]; !ok {
+		return
+	}
+	delete(s.subscriptions, sub)
+	sub.err = err
+	close(sub.ch)
+	if err == ErrSubscriptionOverflow {
+		stats.Add(numSubscriptionsOverflowed, 1)
+	}
+}
+
+// endSubscriptions ends every subscription with the given error.
+func (s *Store) endSubscriptions(err error) {
+	s.subscriptionsMu.Lock()
+	defer s.subscriptionsMu.Unlock()
+	for sub := range s.subscriptions {
+		s.endSubscriptionLocked(sub, err)
+	}
+}
+
+// publishRowChanges delivers the rows changed by a committed transaction to
+// every subscription to their tables, and retains them for RowChanges. It
+// also records the tables the transaction wrote, for transactions to check
+// against. It is called by the database as the transaction commits, while
+// the log entry at applyingIndex is applied, so it never blocks.
+func (s *Store) publishRowChanges(changes []sql.RowChange, tables []string, complete bool) {
+	written := append([]string(nil), tables...)
+	rcs := make([]*RowChange, len(changes))
+	for i := range changes {
+		written = append(written, changes[i].Table)
+		rcs[i] = &RowChange{
+			Index: s.applyingIndex,
+			Table: changes[i].Table,
+			Op:    changes[i].Op,
+			RowID: changes[i].RowID,
+		}
+	}
+	s.recordTableWrites(s.applyingIndex, written, !complete)
+	s.logRowChanges(rcs, complete)
+
+	s.subscriptionsMu.Lock()
+	defer s.subscriptionsMu.Unlock()
+	if len(s.subscriptions) == 0 {
+		return
+	}
+	if !complete {
+		for sub := range s.subscriptions {
+			s.endSubscriptionLocked(sub, ErrSubscriptionOverflow)
+		}
+		return
+	}
+
+	for _, rc := range rcs {
+		table := strings.ToLower(rc.Table)
+		for sub := range s.subscriptions {
+			if sub.tables != nil && !sub.tables[table] {
+				continue
+			}
+			select {
+			case sub.ch <- rc:
+			default:
+				s.endSubscriptionLocked(sub, ErrSubscriptionOverflow)
+			}
+		}
+	}
+}
+
+// registerRowChangeHook registers the hook through which row changes are
+// published. It must be called whenever the database is opened.
+func (s *Store) registerRowChangeHook() {
+	if err := s.db.RegisterRowChangeHook(s.publishRowChanges, maxTransactionRowChanges); err != nil {
+		s.logger.Printf("failed to register row change hook: %s", err.Error())
+	}
+}
@@ -0,0 +1,25 @@
+package store
+
+import (
+	"time"
+
+	sql "github.com/rqlite/rqlite/db"
+)
+
+// TableReads returns the reads of each table of the database, including
+// those never read, by the queries made on this node, and when counting
+// began. Each node counts only the queries it executes, so queries forwarded
+// to the leader are counted there. Counts are not persisted, so begin again
+// when the node restarts.
+func (s *Store) TableReads() (map[string]sql.TableReads, time.Time, error) {
+	if !s.open {
+		return nil, time.Time{}, ErrNotOpen
+	}
+	return s.db.TableReads()
+}
+
+// ResetTableReads discards the counts of reads of each table, so counting
+// begins again.
+func (s *Store) ResetTableReads() {
+	s.tableAccess.Reset()
+}
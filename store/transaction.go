@@ -0,0 +1,400 @@
+package store
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/rqlite/rqlite/command"
+	sql "github.com/rqlite/rqlite/db"
+)
+
+const (
+	// DefaultTransactionTimeout is the time a transaction may go unused
+	// before it is rolled back, if TransactionTimeout is not set.
+	DefaultTransactionTimeout = 30 * time.Second
+
+	// DefaultMaxTransactions is the number of transactions which may be
+	// open at once, if MaxTransactions is not set.
+	DefaultMaxTransactions = 64
+)
+
+var (
+	// ErrTransactionNotFound is returned when a transaction is not open,
+	// either because it never was, or because it has ended.
+	ErrTransactionNotFound = errors.New("transaction not found")
+
+	// ErrTransactionConflict is returned when a transaction is committed,
+	// or queried after its writes, once a table it read may have been
+	// changed since it began. A transaction which fails to commit is rolled
+	// back, and may be retried.
+	ErrTransactionConflict = errors.New("transaction conflicts with a change made since it began")
+
+	// ErrTooManyTransactions is returned when a transaction is begun while
+	// the maximum number are open.
+	ErrTooManyTransactions = errors.New("too many open transactions")
+)
+
+// transaction is an interactive transaction held by the Leader between the
+// requests which make it up.
+type transaction struct {
+	rtx      *sql.ReadTx
+	proposed uint64 // Entries proposed when the transaction began.
+	index    uint64 // Index of the latest entry applied when it began.
+	term     string
+	stmts    []*command.Statement
+	lastUsed time.Time
+}
+
+// BeginTransaction begins an interactive transaction on the Leader, returning
+// its ID. Queries in the transaction read the database as it was when the
+// transaction began, while its writes are held by the Leader until it is
+// committed, when they are written to the Raft log as a single entry, and
+// executed atomically. Queries see the uncommitted writes of the
+// transaction.
+//
+// Transactions are optimistic. Committing one fails with
+// ErrTransactionConflict if any table its queries read may have been written
+// since it began, or if leadership has changed meanwhile, so that a
+// transaction never commits writes based on stale reads. Open transactions
+// are rolled back when they go unused for TransactionTimeout, and whenever
+// the WAL is checkpointed, which their reads would otherwise prevent.
+func (s *Store) BeginTransaction() (string, error) {
+	if !s.open {
+		return "", ErrNotOpen
+	}
+	if s.raft.State() != raft.Leader {
+		return "", ErrNotLeader
+	}
+	if !s.Ready() {
+		return "", ErrNotReady
+	}
+	if err := s.checkWritable(); err != nil {
+		return "", err
+	}
+	if s.numTransactions() >= s.maxTransactions() {
+		return "", ErrTooManyTransactions
+	}
+
+	// Note the entries proposed so far, and wait until they are all
+	// applied, so that the transaction reads every change it would
+	// conflict with otherwise.
+	s.proposeMu.Lock()
+	proposed := atomic.LoadUint64(&s.numProposed)
+	term := s.raft.Stats()["term"]
+	bf := s.raft.Barrier(s.ApplyTimeout)
+	s.proposeMu.Unlock()
+	if err := bf.Error(); err != nil {
+		if err == raft.ErrNotLeader {
+			return "", ErrNotLeader
+		}
+		return "", err
+	}
+
+	// Every entry applied by now may be seen by the transaction's reads,
+	// so only later entries can conflict with it.
+	index := atomic.LoadUint64(&s.changesIndex)
+
+	s.queryTxMu.RLock()
+	defer s.queryTxMu.RUnlock()
+	rtx, err := s.db.BeginRead()
+	if err != nil {
+		return "", err
+	}
+	id, err := newID()
+	if err != nil {
+		rtx.Close()
+		return "", err
+	}
+
+	s.transactionsMu.Lock()
+	defer s.transactionsMu.Unlock()
+	if s.transactions == nil {
+		s.transactions = make(map[string]*transaction)
+	}
+	s.transactions[id] = &transaction{
+		rtx:      rtx,
+		proposed: proposed,
+		index:    index,
+		term:     term,
+		lastUsed: time.Now(),
+	}
+	stats.Add(numTransactionsBegun, 1)
+	return id, nil
+}
+
+// TransactionQuery executes queries in the transaction with the given ID.
+// The consistency level of the request is ignored, as every query reads the
+// database as it was when the transaction began, changed by the writes of
+// the transaction so far. Those writes are executed before the queries, in
+// a transaction which is then rolled back, against the database as it is
+// now, so the queries fail with ErrTransactionConflict if any table they
+// read may have been written since the transaction began. Memory used by
+// the results is held as for QueryReserved, and the caller must call
+// release, which is never nil.
+func (s *Store) TransactionQuery(id string, qr *command.QueryRequest) ([]*command.QueryRows, func(), error) {
+	noop := func() {}
+	if !s.open {
+		return nil, noop, ErrNotOpen
+	}
+
+	s.queryTxMu.RLock()
+	defer s.queryTxMu.RUnlock()
+
+	// The transaction is removed while it is used, so that it is neither
+	// used concurrently nor rolled back meanwhile.
+	tx := s.takeTransaction(id)
+	if tx == nil {
+		return nil, noop, s.transactionNotFound()
+	}
+	defer s.putTransaction(id, tx)
+	if len(tx.stmts) == 0 {
+		return tx.rtx.Query(qr.Request, qr.Timings)
+	}
+	rows, release, err := tx.rtx.QueryAfter(tx.stmts, qr.Request, qr.Timings)
+	if err != nil {
+		return nil, release, err
+	}
+	if s.tablesWrittenSince(tx.rtx.Reads(), tx.index) {
+		release()
+		stats.Add(numTransactionsConflicted, 1)
+		return nil, noop, ErrTransactionConflict
+	}
+	return rows, release, nil
+}
+
+// TransactionExecute adds statements to the transaction with the given ID,
+// to be executed when it is committed.
+func (s *Store) TransactionExecute(id string, stmts []*command.Statement) error {
+	if !s.open {
+		return ErrNotOpen
+	}
+	tx := s.takeTransaction(id)
+	if tx == nil {
+		return s.transactionNotFound()
+	}
+	tx.stmts = append(tx.stmts, stmts...)
+	s.putTransaction(id, tx)
+	return nil
+}
+
+// CommitTransaction commits the transaction with the given ID, executing
+// all its statements atomically, and returning their results. If any
+// statement fails, none takes effect. The transaction ends whether or not
+// it is committed.
+func (s *Store) CommitTransaction(id string) ([]*command.ExecuteResult, error) {
+	if !s.open {
+		return nil, ErrNotOpen
+	}
+	tx := s.takeTransaction(id)
+	if tx == nil {
+		return nil, s.transactionNotFound()
+	}
+	if err := tx.rtx.Close(); err != nil {
+		s.logger.Printf("failed to close transaction reads: %s", err.Error())
+	}
+	if len(tx.stmts) == 0 {
+		stats.Add(numTransactionsCommitted, 1)
+		return nil, nil
+	}
+
+	if err := s.checkWritable(); err != nil {
+		return nil, err
+	}
+	ex := &command.ExecuteRequest{
+		Request: &command.Request{
+			Statements:  tx.stmts,
+			Transaction: true,
+		},
+	}
+	if err := s.rewriteTime(ex.Request); err != nil {
+		return nil, err
+	}
+	results, err := s.executeEntry(ex, func(b []byte) raft.ApplyFuture {
+		return s.raftApplyUnchanged(b, tx)
+	})
+	switch err {
+	case nil:
+		stats.Add(numTransactionsCommitted, 1)
+	case ErrTransactionConflict, ErrNotLeader:
+		stats.Add(numTransactionsConflicted, 1)
+		return nil, ErrTransactionConflict
+	}
+	return results, err
+}
+
+// RollbackTransaction rolls back the transaction with the given ID,
+// discarding its statements.
+func (s *Store) RollbackTransaction(id string) error {
+	tx := s.takeTransaction(id)
+	if tx == nil {
+		return s.transactionNotFound()
+	}
+	return tx.rtx.Close()
+}
+
+// raftApplyUnchanged writes b, the statements of tx, to the Raft log,
+// unless a table read by tx may have been written since it began, or the
+// term has changed, in which case the returned future fails with
+// ErrTransactionConflict.
+func (s *Store) raftApplyUnchanged(b []byte, tx *transaction) raft.ApplyFuture {
+	s.proposeMu.Lock()
+	defer s.proposeMu.Unlock()
+	if s.raft.Stats()["term"] != tx.term {
+		return &failedApplyFuture{err: ErrTransactionConflict}
+	}
+	if atomic.LoadUint64(&s.numProposed) != tx.proposed {
+		// Wait until the entries proposed since the transaction began are
+		// applied, so the tables they wrote are known. No other entry can
+		// be proposed meanwhile.
+		if err := s.raft.Barrier(s.ApplyTimeout).Error(); err != nil {
+			return &failedApplyFuture{err: err}
+		}
+		if s.tablesWrittenSince(tx.rtx.Reads(), tx.index) {
+			return &failedApplyFuture{err: ErrTransactionConflict}
+		}
+	}
+	return s.propose(b)
+}
+
+// recordTableWrites records that the log entry at idx may have written
+// tables, or if all is set, any table.
+func (s *Store) recordTableWrites(idx uint64, tables []string, all bool) {
+	s.tableWritesMu.Lock()
+	defer s.tableWritesMu.Unlock()
+	if all {
+		s.anyTableWritten = idx
+	}
+	if s.tableWrites == nil {
+		s.tableWrites = make(map[string]uint64)
+	}
+	for _, t := range tables {
+		s.tableWrites[strings.ToLower(t)] = idx
+	}
+}
+
+// tablesWrittenSince returns whether any of tables may have been written by
+// a log entry later than that at idx.
+func (s *Store) tablesWrittenSince(tables []string, idx uint64) bool {
+	s.tableWritesMu.Lock()
+	defer s.tableWritesMu.Unlock()
+	if s.anyTableWritten > idx {
+		return len(tables) > 0
+	}
+	for _, t := range tables {
+		if s.tableWrites[strings.ToLower(t)] > idx {
+			return true
+		}
+	}
+	return false
+}
+
+// transactionNotFound returns the error for a transaction which is not open.
+// Since transactions are only open on the Leader, on any other node it is
+// ErrNotLeader.
+func (s *Store) transactionNotFound() error {
+	if s.raft.State() != raft.Leader {
+		return ErrNotLeader
+	}
+	return ErrTransactionNotFound
+}
+
+func (s *Store) takeTransaction(id string) *transaction {
+	s.transactionsMu.Lock()
+	defer s.transactionsMu.Unlock()
+	tx, ok := s.transactions[id]
+	if !ok {
+		return nil
+	}
+	delete(s.transactions, id)
+	return tx
+}
+
+func (s *Store) putTransaction(id string, tx *transaction) {
+	s.transactionsMu.Lock()
+	defer s.transactionsMu.Unlock()
+	tx.lastUsed = time.Now()
+	s.transactions[id] = tx
+}
+
+func (s *Store) numTransactions() int {
+	s.transactionsMu.Lock()
+	defer s.transactionsMu.Unlock()
+	return len(s.transactions)
+}
+
+// rollbackTransactions rolls back every open transaction which has not been
+// used since before, or all of them if before is zero. It returns the number
+// rolled back.
+func (s *Store) rollbackTransactions(before time.Time) int {
+	s.transactionsMu.Lock()
+	defer s.transactionsMu.Unlock()
+	n := 0
+	for id, tx := range s.transactions {
+		if !before.IsZero() && !tx.lastUsed.Before(before) {
+			continue
+		}
+		if err := tx.rtx.Close(); err != nil {
+			s.logger.Printf("failed to roll back transaction: %s", err.Error())
+		}
+		delete(s.transactions, id)
+		n++
+	}
+	return n
+}
+
+// invalidateTransactions rolls back every open transaction, since its reads
+// would prevent the database from being checkpointed or replaced. queryTxMu
+// must be held for writing.
+func (s *Store) invalidateTransactions() {
+	if n := s.rollbackTransactions(time.Time{}); n > 0 {
+		stats.Add(numTransactionsInvalidated, int64(n))
+		s.logger.Printf("rolled back %d open transactions", n)
+	}
+}
+
+// expireTransactionsLoop periodically rolls back transactions which have
+// gone unused for longer than the transaction timeout.
+func (s *Store) expireTransactionsLoop() chan struct{} {
+	done := make(chan struct{})
+	timeout := s.transactionTimeout()
+	go func() {
+		ticker := time.NewTicker(timeout / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				stats.Add(numTransactionsExpired, int64(s.rollbackTransactions(time.Now().Add(-timeout))))
+			case <-done:
+				return
+			}
+		}
+	}()
+	return done
+}
+
+func (s *Store) transactionTimeout() time.Duration {
+	if s.TransactionTimeout > 0 {
+		return s.TransactionTimeout
+	}
+	return DefaultTransactionTimeout
+}
+
+func (s *Store) maxTransactions() int {
+	if s.MaxTransactions > 0 {
+		return s.MaxTransactions
+	}
+	return DefaultMaxTransactions
+}
+
+// failedApplyFuture is an ApplyFuture for an entry which was never written
+// to the log.
+type failedApplyFuture struct {
+	err error
+}
+
+func (f *failedApplyFuture) Error() error          { return f.err }
+func (f *failedApplyFuture) Index() uint64         { return 0 }
+func (f *failedApplyFuture) Response() interface{} { return nil }
@@ -395,6 +395,58 @@ func (n *Node) ConfirmRedirect(host string) bool {
 	return true
 }
 
+// Trash returns the tables in the node's trash.
+func (n *Node) Trash() (string, error) {
+	return n.doTrash("GET", "")
+}
+
+// RestoreTrash restores the given table from the node's trash.
+func (n *Node) RestoreTrash(name string) (string, error) {
+	return n.doTrash("POST", name)
+}
+
+// PurgeTrash purges the given table from the node's trash.
+func (n *Node) PurgeTrash(name string) (string, error) {
+	return n.doTrash("DELETE", name)
+}
+
+func (n *Node) doTrash(method, name string) (string, error) {
+	req, err := http.NewRequest(method, "http://"+n.APIAddr+"/db/trash/"+url.PathEscape(name), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("trash endpoint returned: %s", resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// Promote promotes the node's standby cluster to be a primary.
+func (n *Node) Promote() (string, error) {
+	resp, err := http.Post("http://"+n.APIAddr+"/standby/promote", "application/json", nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("promote endpoint returned: %s (%s)", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return string(body), nil
+}
+
 func (n *Node) postExecute(stmt string) (string, error) {
 	resp, err := http.Post("http://"+n.APIAddr+"/db/execute", "application/json", strings.NewReader(stmt))
 	if err != nil {
@@ -4,16 +4,22 @@ Package system runs system-level testing of rqlite. This includes testing of sin
 package system
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/rqlite/rqlite/client"
 	"github.com/rqlite/rqlite/cluster"
 	httpd "github.com/rqlite/rqlite/http"
+	"github.com/rqlite/rqlite/standby"
 	"github.com/rqlite/rqlite/store"
 	"github.com/rqlite/rqlite/tcp"
 )
@@ -408,6 +414,30 @@ func Test_SingleNodeRequestParameterized(t *testing.T) {
 	}
 }
 
+func Test_SingleNodeParamSets(t *testing.T) {
+	node := mustNewLeaderNode()
+	defer node.Deprovision()
+
+	_, err := node.Execute(`CREATE TABLE foo (id integer not null primary key, name text, age integer)`)
+	if err != nil {
+		t.Fatalf(`CREATE TABLE failed: %s`, err.Error())
+	}
+	r, err := node.postExecute(`[{"sql": "INSERT INTO foo(name, age) VALUES(?, ?)", "param_sets": [["fiona", 20], ["declan", 30], ["aoife", null]]}]`)
+	if err != nil {
+		t.Fatalf(`INSERT failed: %s`, err.Error())
+	}
+	if got, exp := r, `{"results":[{"last_insert_id":3,"rows_affected":3}]}`; got != exp {
+		t.Fatalf("wrong execute results, exp %s, got %s", exp, got)
+	}
+	r, err = node.Query(`SELECT * FROM foo`)
+	if err != nil {
+		t.Fatalf(`queried failed: %s`, err.Error())
+	}
+	if got, exp := r, `{"results":[{"columns":["id","name","age"],"types":["integer","text","integer"],"values":[[1,"fiona",20],[2,"declan",30],[3,"aoife",null]]}]}`; got != exp {
+		t.Fatalf("wrong query results, exp %s, got %s", exp, got)
+	}
+}
+
 func Test_SingleNodeParameterizedNull(t *testing.T) {
 	node := mustNewLeaderNode()
 	defer node.Deprovision()
@@ -536,6 +566,257 @@ func Test_SingleNodeRewriteRandom(t *testing.T) {
 	}
 }
 
+func Test_SingleNodeStandby(t *testing.T) {
+	primary := mustNewLeaderNode()
+	defer primary.Deprovision()
+	primary.Service.ChangeFeed = primary.Store
+
+	if _, err := primary.Execute(`CREATE TABLE foo (id integer not null primary key, name text)`); err != nil {
+		t.Fatalf(`CREATE TABLE failed: %s`, err.Error())
+	}
+	if _, err := primary.Execute(`INSERT INTO foo(id, name) VALUES(1, "fiona")`); err != nil {
+		t.Fatalf(`INSERT failed: %s`, err.Error())
+	}
+
+	node := mustNewLeaderNode()
+	defer node.Deprovision()
+	consumer, err := standby.New(node.Store, "http://"+primary.APIAddr)
+	if err != nil {
+		t.Fatalf("failed to create standby consumer: %s", err.Error())
+	}
+	consumer.PollInterval = 50 * time.Millisecond
+	node.Service.Standby = consumer
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go consumer.Start(ctx)
+
+	waitForCount := func(n *Node, exp string) {
+		t.Helper()
+		var r string
+		for i := 0; i < 100; i++ {
+			r, err = n.Query(`SELECT COUNT(*) FROM foo`)
+			if err == nil && strings.Contains(r, exp) {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatalf("timed out waiting for count %s, last result %s", exp, r)
+	}
+
+	// Changes made on the primary reach the standby.
+	waitForCount(node, `[[1]]`)
+	if _, err := primary.Execute(`INSERT INTO foo(id, name) VALUES(2, "declan")`); err != nil {
+		t.Fatalf(`INSERT failed: %s`, err.Error())
+	}
+	waitForCount(node, `[[2]]`)
+
+	// Clients cannot write to the standby.
+	r, err := node.Execute(`INSERT INTO foo(id, name) VALUES(3, "aoife")`)
+	if err != nil {
+		t.Fatalf(`INSERT failed: %s`, err.Error())
+	}
+	if !strings.Contains(r, store.ErrStandby.Error()) {
+		t.Fatalf("write to standby not rejected, got %s", r)
+	}
+
+	if _, err := node.Promote(); err != nil {
+		t.Fatalf("failed to promote standby: %s", err.Error())
+	}
+
+	// The old primary is fenced, and the promoted standby accepts writes.
+	r, err = primary.Execute(`INSERT INTO foo(id, name) VALUES(3, "aoife")`)
+	if err != nil {
+		t.Fatalf(`INSERT failed: %s`, err.Error())
+	}
+	if !strings.Contains(r, store.ErrFenced.Error()) {
+		t.Fatalf("write to fenced primary not rejected, got %s", r)
+	}
+	r, err = node.Execute(`INSERT INTO foo(id, name) VALUES(3, "aoife")`)
+	if err != nil {
+		t.Fatalf(`INSERT failed: %s`, err.Error())
+	}
+	if got, exp := r, `{"results":[{"last_insert_id":3,"rows_affected":1}]}`; got != exp {
+		t.Fatalf("wrong execute result on promoted standby, exp %s, got %s", exp, got)
+	}
+	waitForCount(node, `[[3]]`)
+}
+
+func Test_SingleNodeTrash(t *testing.T) {
+	node := mustNewLeaderNode()
+	defer node.Deprovision()
+	node.Service.TrashRetention = time.Hour
+
+	_, err := node.Execute(`CREATE TABLE foo (id integer not null primary key, name text)`)
+	if err != nil {
+		t.Fatalf(`CREATE TABLE failed: %s`, err.Error())
+	}
+	_, err = node.Execute(`INSERT INTO foo(id, name) VALUES(1, "fiona")`)
+	if err != nil {
+		t.Fatalf(`INSERT failed: %s`, err.Error())
+	}
+
+	_, err = node.Execute(`DROP TABLE foo`)
+	if err != nil {
+		t.Fatalf(`DROP TABLE failed: %s`, err.Error())
+	}
+	r, err := node.Query(`SELECT * FROM foo`)
+	if err != nil {
+		t.Fatalf(`query failed: %s`, err.Error())
+	}
+	if got, exp := r, `{"results":[{"error":"no such table: foo"}]}`; got != exp {
+		t.Fatalf("wrong query result, exp %s, got %s", exp, got)
+	}
+
+	// Dropping a non-existent table with IF EXISTS must still succeed.
+	if _, err := node.Execute(`DROP TABLE IF EXISTS bar`); err != nil {
+		t.Fatalf(`DROP TABLE IF EXISTS failed: %s`, err.Error())
+	}
+
+	r, err = node.Trash()
+	if err != nil {
+		t.Fatalf("failed to list trash: %s", err.Error())
+	}
+	var list struct {
+		Trash []struct {
+			Name  string `json:"name"`
+			Table string `json:"table"`
+		} `json:"trash"`
+	}
+	if err := json.Unmarshal([]byte(r), &list); err != nil {
+		t.Fatalf("failed to unmarshal trash list: %s", err.Error())
+	}
+	if len(list.Trash) != 1 || list.Trash[0].Table != "foo" {
+		t.Fatalf("wrong trash contents: %s", r)
+	}
+	name := list.Trash[0].Name
+
+	if _, err := node.Query(`SELECT * FROM ` + name); err == nil {
+		t.Fatalf("query of trash table succeeded")
+	}
+
+	if _, err := node.RestoreTrash(name); err != nil {
+		t.Fatalf("failed to restore table: %s", err.Error())
+	}
+	r, err = node.Query(`SELECT * FROM foo`)
+	if err != nil {
+		t.Fatalf(`query failed: %s`, err.Error())
+	}
+	if got, exp := r, `{"results":[{"columns":["id","name"],"types":["integer","text"],"values":[[1,"fiona"]]}]}`; got != exp {
+		t.Fatalf("wrong query result after restore, exp %s, got %s", exp, got)
+	}
+
+	if _, err := node.Execute(`DROP TABLE foo`); err != nil {
+		t.Fatalf(`DROP TABLE failed: %s`, err.Error())
+	}
+	r, err = node.Trash()
+	if err != nil {
+		t.Fatalf("failed to list trash: %s", err.Error())
+	}
+	if err := json.Unmarshal([]byte(r), &list); err != nil {
+		t.Fatalf("failed to unmarshal trash list: %s", err.Error())
+	}
+	if len(list.Trash) != 1 {
+		t.Fatalf("wrong trash contents: %s", r)
+	}
+	if _, err := node.PurgeTrash(list.Trash[0].Name); err != nil {
+		t.Fatalf("failed to purge table: %s", err.Error())
+	}
+	r, err = node.Trash()
+	if err != nil {
+		t.Fatalf("failed to list trash: %s", err.Error())
+	}
+	if got, exp := r, `{"trash":[]}`; got != exp {
+		t.Fatalf("wrong trash contents after purge, exp %s, got %s", exp, got)
+	}
+}
+
+func Test_SingleNodeTrashDependents(t *testing.T) {
+	node := mustNewLeaderNode()
+	defer node.Deprovision()
+	node.Service.TrashRetention = time.Hour
+
+	for _, stmt := range []string{
+		`CREATE TABLE log (name text)`,
+		`CREATE TABLE foo (id integer not null primary key, name text)`,
+		`CREATE INDEX foo_name ON foo(name)`,
+		`CREATE TRIGGER foo_ins AFTER INSERT ON foo BEGIN INSERT INTO log(name) VALUES(new.name); END`,
+	} {
+		if r, err := node.Execute(stmt); err != nil || strings.Contains(r, "error") {
+			t.Fatalf(`%s failed: %s %v`, stmt, r, err)
+		}
+	}
+	if _, err := node.Execute(`DROP TABLE foo`); err != nil {
+		t.Fatalf(`DROP TABLE failed: %s`, err.Error())
+	}
+
+	// The index and trigger moved out of the way, so a new table may be
+	// created under the same name, with an index of the same name.
+	for _, stmt := range []string{
+		`CREATE TABLE foo (id integer not null primary key, name text)`,
+		`CREATE INDEX foo_name ON foo(name)`,
+		`INSERT INTO foo(id, name) VALUES(1, "fiona")`,
+	} {
+		if r, err := node.Execute(stmt); err != nil || strings.Contains(r, "error") {
+			t.Fatalf(`%s failed: %s %v`, stmt, r, err)
+		}
+	}
+	r, err := node.Query(`SELECT COUNT(*) FROM log`)
+	if err != nil {
+		t.Fatalf(`query failed: %s`, err.Error())
+	}
+	if got, exp := r, `{"results":[{"columns":["COUNT(*)"],"types":["integer"],"values":[[0]]}]}`; got != exp {
+		t.Fatalf("trigger of dropped table fired, exp %s, got %s", exp, got)
+	}
+
+	// Restoring the table restores its index and trigger.
+	if _, err := node.Execute(`DROP TABLE foo`); err != nil {
+		t.Fatalf(`DROP TABLE failed: %s`, err.Error())
+	}
+	r, err = node.Trash()
+	if err != nil {
+		t.Fatalf("failed to list trash: %s", err.Error())
+	}
+	var list struct {
+		Trash []struct {
+			Name string `json:"name"`
+		} `json:"trash"`
+	}
+	if err := json.Unmarshal([]byte(r), &list); err != nil {
+		t.Fatalf("failed to unmarshal trash list: %s", err.Error())
+	}
+	if len(list.Trash) != 2 {
+		t.Fatalf("wrong trash contents: %s", r)
+	}
+	if r, err := node.RestoreTrash(list.Trash[0].Name); err != nil || strings.Contains(r, "error") {
+		t.Fatalf("failed to restore table: %s %v", r, err)
+	}
+	if r, err := node.Execute(`INSERT INTO foo(id, name) VALUES(2, "declan")`); err != nil || strings.Contains(r, "error") {
+		t.Fatalf(`INSERT failed: %s %v`, r, err)
+	}
+	r, err = node.Query(`SELECT name FROM log`)
+	if err != nil {
+		t.Fatalf(`query failed: %s`, err.Error())
+	}
+	if got, exp := r, `{"results":[{"columns":["name"],"types":["text"],"values":[["declan"]]}]}`; got != exp {
+		t.Fatalf("trigger not restored, exp %s, got %s", exp, got)
+	}
+	r, err = node.Query(`SELECT name FROM sqlite_master WHERE type = 'index' AND tbl_name = 'foo'`)
+	if err != nil {
+		t.Fatalf(`query failed: %s`, err.Error())
+	}
+	if got, exp := r, `{"results":[{"columns":["name"],"types":["text"],"values":[["foo_name"]]}]}`; got != exp {
+		t.Fatalf("index not restored, exp %s, got %s", exp, got)
+	}
+
+	// A table which another table refers to by foreign key can't be dropped.
+	if r, err := node.Execute(`CREATE TABLE child (id integer, foo_id integer REFERENCES foo(id))`); err != nil || strings.Contains(r, "error") {
+		t.Fatalf(`CREATE TABLE failed: %s %v`, r, err)
+	}
+	if _, err := node.Execute(`DROP TABLE foo`); err == nil {
+		t.Fatalf("DROP TABLE of referenced table not rejected")
+	}
+}
+
 func Test_SingleNodeQueued(t *testing.T) {
 	node := mustNewLeaderNode()
 	defer node.Deprovision()
@@ -1366,3 +1647,54 @@ func Test_SingleNodeAutoRestore(t *testing.T) {
 		t.Fatalf("test received wrong result got %s", r)
 	}
 }
+
+// Test_SingleNodeGoClient tests that the Go client package executes and
+// queries statements on a real node.
+func Test_SingleNodeGoClient(t *testing.T) {
+	node := mustNewLeaderNode()
+	defer node.Deprovision()
+
+	c, err := client.New([]string{node.APIAddr}, &client.Config{Level: client.LevelStrong})
+	if err != nil {
+		t.Fatalf("failed to create client: %s", err.Error())
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	_, err = c.Execute(ctx, client.NewStatement("CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT, data BLOB)"))
+	if err != nil {
+		t.Fatalf("failed to create table: %s", err.Error())
+	}
+	results, err := c.Execute(ctx,
+		client.NewStatement("INSERT INTO foo(id, name, data) VALUES(?, ?, ?)", int64(9007199254740993), "fiona", []byte{0, 1}),
+		client.NewStatement("INSERT INTO foo(name) VALUES(:name)", client.Named("name", "declan")))
+	if err != nil {
+		t.Fatalf("failed to insert records: %s", err.Error())
+	}
+	if results[0].LastInsertID != 9007199254740993 || results[1].RowsAffected != 1 {
+		t.Fatalf("wrong execute results: %+v", results)
+	}
+
+	rows, err := c.Query(ctx, client.NewStatement("SELECT id, name, data FROM foo WHERE name = ?", "fiona"))
+	if err != nil {
+		t.Fatalf("failed to query: %s", err.Error())
+	}
+	var id int64
+	var name string
+	var data []byte
+	if !rows[0].Next() {
+		t.Fatalf("query returned no rows")
+	}
+	if err := rows[0].Scan(&id, &name, &data); err != nil {
+		t.Fatalf("failed to scan row: %s", err.Error())
+	}
+	if id != 9007199254740993 || name != "fiona" || string(data) != "\x00\x01" {
+		t.Fatalf("wrong row: %d %s %v", id, name, data)
+	}
+
+	_, err = c.Query(ctx, client.NewStatement("SELECT * FROM bar"))
+	var se *client.StatementError
+	if !errors.As(err, &se) || !strings.Contains(se.Message, "no such table") {
+		t.Fatalf("expected statement error, got %v", err)
+	}
+}
@@ -6,17 +6,59 @@ import (
 	"net"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
+// Config configures a pool.
+type Config struct {
+	// InitialCap is the number of connections created when the pool is
+	// created.
+	InitialCap int
+
+	// MaxIdle is the maximum number of idle connections kept in the pool.
+	MaxIdle int
+
+	// IdleTimeout is the time after which an idle connection is closed. If
+	// zero idle connections are never closed.
+	IdleTimeout time.Duration
+
+	// MaxLifetime is the time after which a connection is closed, instead
+	// of being returned to the pool. If zero connections are reused for as
+	// long as they remain usable.
+	MaxLifetime time.Duration
+
+	// HealthCheck, if set, is called on an idle connection before it is
+	// returned by Get. If it returns an error the connection is closed.
+	HealthCheck func(net.Conn) error
+
+	// HealthCheckAfter is the time a connection must have been idle before
+	// HealthCheck is called on it. Connections idle for only a short time
+	// are unlikely to have failed, and checking them may not be cheap.
+	HealthCheckAfter time.Duration
+}
+
+// idleConn is a connection in the pool.
+type idleConn struct {
+	conn      net.Conn
+	created   time.Time
+	idleSince time.Time
+}
+
 // channelPool implements the Pool interface based on buffered channels.
 type channelPool struct {
 	// storage for our net.Conn connections
 	mu    sync.RWMutex
-	conns chan net.Conn
+	conns chan *idleConn
 
 	// net.Conn generator
 	factory    Factory
 	nOpenConns int64
+
+	cfg  Config
+	done chan struct{}
+
+	nExpired   int64
+	nUnhealthy int64
 }
 
 // Factory is a function to create new connections.
@@ -29,31 +71,51 @@ type Factory func() (net.Conn, error)
 // available in the pool, a new connection will be created via the Factory()
 // method.
 func NewChannelPool(initialCap, maxCap int, factory Factory) (Pool, error) {
-	if initialCap < 0 || maxCap <= 0 || initialCap > maxCap {
+	return NewChannelPoolWithConfig(Config{
+		InitialCap: initialCap,
+		MaxIdle:    maxCap,
+	}, factory)
+}
+
+// NewChannelPoolWithConfig returns a new pool based on buffered channels,
+// configured by cfg. If cfg sets an idle timeout or maximum lifetime,
+// connections which exceed either are closed in the background, as well as
+// when they are next used.
+func NewChannelPoolWithConfig(cfg Config, factory Factory) (Pool, error) {
+	if cfg.InitialCap < 0 || cfg.MaxIdle <= 0 || cfg.InitialCap > cfg.MaxIdle {
 		return nil, errors.New("invalid capacity settings")
 	}
+	if cfg.IdleTimeout < 0 || cfg.MaxLifetime < 0 {
+		return nil, errors.New("invalid timeout settings")
+	}
 
 	c := &channelPool{
-		conns:   make(chan net.Conn, maxCap),
+		conns:   make(chan *idleConn, cfg.MaxIdle),
 		factory: factory,
+		cfg:     cfg,
+		done:    make(chan struct{}),
 	}
 
 	// create initial connections, if something goes wrong,
 	// just close the pool error out.
-	for i := 0; i < initialCap; i++ {
+	for i := 0; i < cfg.InitialCap; i++ {
 		conn, err := factory()
 		if err != nil {
 			c.Close()
 			return nil, fmt.Errorf("factory is not able to fill the pool: %s", err)
 		}
 		atomic.AddInt64(&c.nOpenConns, 1)
-		c.conns <- conn
+		now := time.Now()
+		c.conns <- &idleConn{conn: conn, created: now, idleSince: now}
 	}
 
+	if interval := reapInterval(cfg); interval > 0 {
+		go c.reapLoop(interval)
+	}
 	return c, nil
 }
 
-func (c *channelPool) getConnsAndFactory() (chan net.Conn, Factory) {
+func (c *channelPool) getConnsAndFactory() (chan *idleConn, Factory) {
 	c.mu.RLock()
 	conns := c.conns
 	factory := c.factory
@@ -72,27 +134,39 @@ func (c *channelPool) Get() (net.Conn, error) {
 
 	// wrap our connections without custom net.Conn implementation (wrapConn
 	// method) that puts the connection back to the pool if it's closed.
-	select {
-	case conn := <-conns:
-		if conn == nil {
-			return nil, ErrClosed
-		}
+	for {
+		select {
+		case ic := <-conns:
+			if ic == nil {
+				return nil, ErrClosed
+			}
+			if c.expired(ic, time.Now()) {
+				atomic.AddInt64(&c.nExpired, 1)
+				c.closeConn(ic.conn)
+				continue
+			}
+			if !c.healthy(ic) {
+				atomic.AddInt64(&c.nUnhealthy, 1)
+				c.closeConn(ic.conn)
+				continue
+			}
+			return c.wrapConn(ic.conn, ic.created), nil
+		default:
+			conn, err := factory()
+			if err != nil {
+				return nil, err
+			}
+			atomic.AddInt64(&c.nOpenConns, 1)
 
-		return c.wrapConn(conn), nil
-	default:
-		conn, err := factory()
-		if err != nil {
-			return nil, err
+			return c.wrapConn(conn, time.Now()), nil
 		}
-		atomic.AddInt64(&c.nOpenConns, 1)
-
-		return c.wrapConn(conn), nil
 	}
 }
 
 // put puts the connection back to the pool. If the pool is full or closed,
-// conn is simply closed. A nil conn will be rejected.
-func (c *channelPool) put(conn net.Conn) error {
+// or the connection has exceeded its maximum lifetime, conn is simply closed.
+// A nil conn will be rejected.
+func (c *channelPool) put(conn net.Conn, created time.Time) error {
 	if conn == nil {
 		return errors.New("connection is nil. rejecting")
 	}
@@ -102,20 +176,23 @@ func (c *channelPool) put(conn net.Conn) error {
 
 	if c.conns == nil {
 		// pool is closed, close passed connection
-		atomic.AddInt64(&c.nOpenConns, -1)
-		return conn.Close()
+		return c.closeConn(conn)
+	}
+
+	now := time.Now()
+	if c.cfg.MaxLifetime > 0 && now.Sub(created) >= c.cfg.MaxLifetime {
+		atomic.AddInt64(&c.nExpired, 1)
+		return c.closeConn(conn)
 	}
 
 	// put the resource back into the pool. If the pool is full, this will
 	// block and the default case will be executed.
 	select {
-	case c.conns <- conn:
+	case c.conns <- &idleConn{conn: conn, created: created, idleSince: now}:
 		return nil
 	default:
 		// pool is full, close passed connection
-		atomic.AddInt64(&c.nOpenConns, -1)
-
-		return conn.Close()
+		return c.closeConn(conn)
 	}
 }
 
@@ -131,11 +208,11 @@ func (c *channelPool) Close() {
 		return
 	}
 
+	close(c.done)
 	close(conns)
-	for conn := range conns {
-		conn.Close()
+	for ic := range conns {
+		c.closeConn(ic.conn)
 	}
-	atomic.AddInt64(&c.nOpenConns, 0)
 }
 
 // Len returns the number of idle connections.
@@ -149,7 +226,98 @@ func (c *channelPool) Stats() (map[string]interface{}, error) {
 	conns, _ := c.getConnsAndFactory()
 	return map[string]interface{}{
 		"idle":                 len(conns),
-		"open_connections":     c.nOpenConns,
+		"open_connections":     atomic.LoadInt64(&c.nOpenConns),
 		"max_open_connections": cap(conns),
+		"idle_timeout":         c.cfg.IdleTimeout.String(),
+		"max_lifetime":         c.cfg.MaxLifetime.String(),
+		"expired":              atomic.LoadInt64(&c.nExpired),
+		"unhealthy":            atomic.LoadInt64(&c.nUnhealthy),
 	}, nil
 }
+
+// expired returns whether the idle connection has exceeded either its idle
+// timeout or maximum lifetime.
+func (c *channelPool) expired(ic *idleConn, now time.Time) bool {
+	if c.cfg.IdleTimeout > 0 && now.Sub(ic.idleSince) >= c.cfg.IdleTimeout {
+		return true
+	}
+	return c.cfg.MaxLifetime > 0 && now.Sub(ic.created) >= c.cfg.MaxLifetime
+}
+
+// healthy returns whether the idle connection passes the health check, if
+// one is configured and the connection has been idle long enough to need it.
+func (c *channelPool) healthy(ic *idleConn) bool {
+	if c.cfg.HealthCheck == nil || time.Since(ic.idleSince) < c.cfg.HealthCheckAfter {
+		return true
+	}
+	return c.cfg.HealthCheck(ic.conn) == nil
+}
+
+// closeConn closes a connection which was opened by the pool.
+func (c *channelPool) closeConn(conn net.Conn) error {
+	atomic.AddInt64(&c.nOpenConns, -1)
+	return conn.Close()
+}
+
+// reapLoop periodically closes idle connections which have expired, until
+// the pool is closed.
+func (c *channelPool) reapLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.reap()
+		}
+	}
+}
+
+// reap closes idle connections which have expired, returning the rest to
+// the pool.
+func (c *channelPool) reap() {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.conns == nil {
+		return
+	}
+
+	now := time.Now()
+	n := len(c.conns)
+	for i := 0; i < n; i++ {
+		var ic *idleConn
+		select {
+		case ic = <-c.conns:
+		default:
+			return
+		}
+		if c.expired(ic, now) {
+			atomic.AddInt64(&c.nExpired, 1)
+			c.closeConn(ic.conn)
+			continue
+		}
+		select {
+		case c.conns <- ic:
+		default:
+			c.closeConn(ic.conn)
+		}
+	}
+}
+
+// reapInterval returns the interval at which expired connections should be
+// reaped, or zero if connections never expire.
+func reapInterval(cfg Config) time.Duration {
+	d := cfg.IdleTimeout
+	if d == 0 || (cfg.MaxLifetime > 0 && cfg.MaxLifetime < d) {
+		d = cfg.MaxLifetime
+	}
+	if d == 0 {
+		return 0
+	}
+	d /= 2
+	if d < 100*time.Millisecond {
+		d = 100 * time.Millisecond
+	}
+	return d
+}
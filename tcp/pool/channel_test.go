@@ -266,6 +266,131 @@ func TestPoolConcurrent3(t *testing.T) {
 	wg.Wait()
 }
 
+func TestPool_IdleTimeout(t *testing.T) {
+	p, err := NewChannelPoolWithConfig(Config{
+		InitialCap:  InitialCap,
+		MaxIdle:     MaximumCap,
+		IdleTimeout: 200 * time.Millisecond,
+	}, factory)
+	if err != nil {
+		t.Fatalf("failed to create pool: %s", err)
+	}
+	defer p.Close()
+
+	if p.Len() != InitialCap {
+		t.Fatalf("expected %d idle connections, got %d", InitialCap, p.Len())
+	}
+	time.Sleep(500 * time.Millisecond)
+	if p.Len() != 0 {
+		t.Fatalf("expected idle connections to be reaped, got %d", p.Len())
+	}
+	s, _ := p.Stats()
+	if s["expired"].(int64) != int64(InitialCap) {
+		t.Fatalf("expected %d expired connections, got %v", InitialCap, s["expired"])
+	}
+	if s["open_connections"].(int64) != 0 {
+		t.Fatalf("expected no open connections, got %v", s["open_connections"])
+	}
+}
+
+func TestPool_MaxLifetime(t *testing.T) {
+	p, err := NewChannelPoolWithConfig(Config{
+		MaxIdle:     MaximumCap,
+		MaxLifetime: 100 * time.Millisecond,
+	}, factory)
+	if err != nil {
+		t.Fatalf("failed to create pool: %s", err)
+	}
+	defer p.Close()
+
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get error: %s", err)
+	}
+	conn.Close()
+	if p.Len() != 1 {
+		t.Fatalf("expected connection to be returned to pool")
+	}
+
+	// A connection older than the maximum lifetime is not returned to the
+	// pool, even if in use when it expires.
+	conn, err = p.Get()
+	if err != nil {
+		t.Fatalf("Get error: %s", err)
+	}
+	time.Sleep(150 * time.Millisecond)
+	conn.Close()
+	if p.Len() != 0 {
+		t.Fatalf("expected expired connection to be closed, got %d idle", p.Len())
+	}
+}
+
+func TestPool_HealthCheck(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	defer ln.Close()
+	serverConns := make(chan net.Conn, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			serverConns <- conn
+		}
+	}()
+
+	var nDials int
+	p, err := NewChannelPoolWithConfig(Config{
+		MaxIdle:     MaximumCap,
+		HealthCheck: CheckAlive,
+	}, func() (net.Conn, error) {
+		nDials++
+		return net.Dial("tcp", ln.Addr().String())
+	})
+	if err != nil {
+		t.Fatalf("failed to create pool: %s", err)
+	}
+	defer p.Close()
+
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get error: %s", err)
+	}
+	conn.Close()
+
+	// A healthy connection is reused.
+	conn, err = p.Get()
+	if err != nil {
+		t.Fatalf("Get error: %s", err)
+	}
+	conn.Close()
+	if nDials != 1 {
+		t.Fatalf("expected healthy connection to be reused, got %d dials", nDials)
+	}
+
+	// A connection closed by the remote end is not.
+	(<-serverConns).Close()
+	time.Sleep(100 * time.Millisecond)
+	conn, err = p.Get()
+	if err != nil {
+		t.Fatalf("Get error: %s", err)
+	}
+	defer conn.Close()
+	if nDials != 2 {
+		t.Fatalf("expected failed connection to be replaced, got %d dials", nDials)
+	}
+	s, _ := p.Stats()
+	if s["unhealthy"].(int64) != 1 {
+		t.Fatalf("expected 1 unhealthy connection, got %v", s["unhealthy"])
+	}
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatalf("failed to write to replacement connection: %s", err)
+	}
+}
+
 func newChannelPool() (Pool, error) {
 	return NewChannelPool(InitialCap, MaximumCap, factory)
 }
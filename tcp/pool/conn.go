@@ -1,8 +1,10 @@
 package pool
 
 import (
+	"errors"
 	"net"
 	"sync"
+	"time"
 )
 
 // Conn is a wrapper around net.Conn to modify the behavior of
@@ -11,6 +13,7 @@ type Conn struct {
 	net.Conn
 	mu       sync.RWMutex
 	c        *channelPool
+	created  time.Time
 	unusable bool
 }
 
@@ -21,11 +24,11 @@ func (p *Conn) Close() error {
 
 	if p.unusable {
 		if p.Conn != nil {
-			return p.Conn.Close()
+			return p.c.closeConn(p.Conn)
 		}
 		return nil
 	}
-	return p.c.put(p.Conn)
+	return p.c.put(p.Conn, p.created)
 }
 
 // MarkUnusable marks the connection not usable anymore, to let the pool close it instead of returning it to pool.
@@ -36,8 +39,28 @@ func (p *Conn) MarkUnusable() {
 }
 
 // newConn wraps a standard net.Conn to a poolConn net.Conn.
-func (c *channelPool) wrapConn(conn net.Conn) net.Conn {
-	p := &Conn{c: c}
+func (c *channelPool) wrapConn(conn net.Conn, created time.Time) net.Conn {
+	p := &Conn{c: c, created: created}
 	p.Conn = conn
 	return p
 }
+
+// CheckAlive returns an error if conn has been closed by the remote end, or
+// has data waiting to be read. It is suitable as a health check for idle
+// connections carrying request-response protocols, on which the remote end
+// should never send unprompted. It waits briefly for data to arrive, so
+// should only be called on connections which have been idle for a while.
+func CheckAlive(conn net.Conn) error {
+	if err := conn.SetReadDeadline(time.Now().Add(time.Millisecond)); err != nil {
+		return err
+	}
+	var b [1]byte
+	_, err := conn.Read(b[:])
+	if err == nil {
+		return errors.New("unexpected data on idle connection")
+	}
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		return err
+	}
+	return conn.SetReadDeadline(time.Time{})
+}
@@ -0,0 +1,257 @@
+// Package trash supports soft-deletion of tables. When enabled, DROP TABLE
+// statements are rewritten so the table is renamed into a hidden trash
+// namespace instead, from where it can be restored or purged. The indexes and
+// triggers of the table are dropped, and recorded so they can be recreated
+// when the table is restored.
+package trash
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rqlite/rqlite/command"
+	"github.com/rqlite/rqlite/policy"
+	"github.com/rqlite/sql"
+)
+
+const (
+	// Prefix is the prefix of the name of every table in the trash.
+	Prefix = "_rqlite_trash_"
+
+	// placeholderColumn is the single column of the empty table moved to the
+	// trash when DROP TABLE IF EXISTS is executed for a table which does not
+	// exist. Tables with this column are never listed, and are always purged.
+	placeholderColumn = "_rqlite_trash_placeholder"
+
+	// ObjectsTable is the table recording the SQL of the indexes and triggers
+	// of each table in the trash. Its name is not that of a table in the trash.
+	ObjectsTable = Prefix + "objects"
+)
+
+var (
+	// ErrUnsupportedDrop is returned when a statement drops a table, but
+	// cannot be rewritten to move the table to the trash.
+	ErrUnsupportedDrop = errors.New("DROP TABLE must be the only statement in its SQL text when trash is enabled")
+
+	// ErrReferenced is returned when a statement drops a table which other
+	// tables, views or triggers refer to. Once the table was moved to the
+	// trash they would refer to it there, rather than to any new table
+	// created under its name.
+	ErrReferenced = errors.New("DROP TABLE of a table referred to by other tables, views or triggers is not supported when trash is enabled")
+)
+
+// Object is a table, index, trigger or view, as listed in sqlite_master.
+type Object struct {
+	Type  string
+	Name  string
+	Table string
+	SQL   string
+}
+
+// SchemaFunc returns the objects in the database.
+type SchemaFunc func() ([]Object, error)
+
+// Name returns the name of table when it is moved to the trash at time t.
+func Name(table string, t time.Time) string {
+	return fmt.Sprintf("%s%d_%s", Prefix, t.UnixNano(), table)
+}
+
+// Parse returns the original name of the table, and the time it was moved
+// to the trash, for the given trash table name. ok is false if name is not
+// the name of a table in the trash.
+func Parse(name string) (table string, t time.Time, ok bool) {
+	if !strings.HasPrefix(name, Prefix) {
+		return "", time.Time{}, false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(name, Prefix), "_", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", time.Time{}, false
+	}
+	ns, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return parts[1], time.Unix(0, ns).UTC(), true
+}
+
+// IsPlaceholder returns whether the given CREATE TABLE SQL, as stored in
+// sqlite_master, is that of a placeholder table.
+func IsPlaceholder(createSQL string) bool {
+	return strings.Contains(createSQL, placeholderColumn)
+}
+
+// Rewrite rewrites any DROP TABLE statements so that the table is moved to
+// the trash instead, stamped with time now. Since the rewritten statements
+// are stored in the Raft log, every node moves the table under the same name.
+// schema is called, at most once, only if a statement drops a table.
+func Rewrite(stmts []*command.Statement, now time.Time, schema SchemaFunc) error {
+	var objects []Object
+	var schemaRead bool
+	for i := range stmts {
+		classes := policy.Classify(stmts[i].Sql)
+		if !hasDropTable(classes) {
+			continue
+		}
+		if len(classes) != 1 {
+			return ErrUnsupportedDrop
+		}
+
+		s, err := sql.NewParser(strings.NewReader(stmts[i].Sql)).ParseStatement()
+		if err != nil {
+			return fmt.Errorf("%s: %s", ErrUnsupportedDrop.Error(), err.Error())
+		}
+		dts, ok := s.(*sql.DropTableStatement)
+		if !ok {
+			return ErrUnsupportedDrop
+		}
+
+		if !schemaRead {
+			if objects, err = schema(); err != nil {
+				return err
+			}
+			schemaRead = true
+		}
+		owned, err := dependents(dts.Name.Name, objects)
+		if err != nil {
+			return err
+		}
+		stmts[i].Sql = rewriteDrop(dts.Name.Name, Name(dts.Name.Name, now), dts.IfExists.IsValid(), owned)
+	}
+	return nil
+}
+
+// Restore returns the statements which restore the table name from the
+// trash, and recreate its indexes and triggers from their SQL, as recorded
+// in ObjectsTable.
+func Restore(name, table string, objects []string) []string {
+	stmts := []string{fmt.Sprintf("ALTER TABLE %s RENAME TO %s", quote(name), quote(table))}
+	stmts = append(stmts, objects...)
+	if len(objects) > 0 {
+		stmts = append(stmts, deleteObjects(name))
+	}
+	return stmts
+}
+
+// Purge returns the statements which purge the table name from the trash,
+// along with any of its indexes and triggers recorded in ObjectsTable.
+func Purge(name string, objects []string) []string {
+	stmts := []string{fmt.Sprintf("DROP TABLE %s", quote(name))}
+	if len(objects) > 0 {
+		stmts = append(stmts, deleteObjects(name))
+	}
+	return stmts
+}
+
+// rewriteDrop returns the SQL which moves table to the trash under name,
+// first recording and dropping the given indexes and triggers of the table.
+func rewriteDrop(table, name string, ifExists bool, owned []Object) string {
+	var b strings.Builder
+	if len(owned) > 0 {
+		fmt.Fprintf(&b, "CREATE TABLE IF NOT EXISTS %s (trash_name TEXT NOT NULL, sql TEXT NOT NULL); ",
+			quote(ObjectsTable))
+	}
+	for _, o := range owned {
+		fmt.Fprintf(&b, "INSERT INTO %s(trash_name, sql) VALUES(%s, %s); ",
+			quote(ObjectsTable), quoteString(name), quoteString(o.SQL))
+	}
+	for _, o := range owned {
+		fmt.Fprintf(&b, "DROP %s %s; ", strings.ToUpper(o.Type), quote(o.Name))
+	}
+	if ifExists {
+		// ALTER TABLE fails if the table does not exist, so ensure it does. If
+		// it didn't, an empty placeholder table is moved to the trash instead.
+		fmt.Fprintf(&b, "CREATE TABLE IF NOT EXISTS %s (%s); ", quote(table), placeholderColumn)
+	}
+	fmt.Fprintf(&b, "ALTER TABLE %s RENAME TO %s", quote(table), quote(name))
+	return b.String()
+}
+
+// dependents returns the indexes and triggers of table, which must be moved
+// out of the way along with the table. It returns ErrReferenced if any other
+// table refers to table in a foreign key, or if any view, or trigger of
+// another table, refers to it at all.
+func dependents(table string, objects []Object) ([]Object, error) {
+	var owned []Object
+	for _, o := range objects {
+		if o.SQL == "" {
+			// Indexes created by SQLite, such as for a UNIQUE constraint, have
+			// no SQL and move with the table.
+			continue
+		}
+		if _, _, ok := Parse(o.Table); ok {
+			// Tables in the trash may still refer to the table, but are not
+			// used.
+			continue
+		}
+		if strings.EqualFold(o.Table, table) {
+			if o.Type == "index" || o.Type == "trigger" {
+				owned = append(owned, o)
+			}
+			continue
+		}
+		if referencesTable(o.SQL, table, o.Type == "table") {
+			return nil, fmt.Errorf("%w: %s %s refers to %s", ErrReferenced, o.Type, o.Name, table)
+		}
+	}
+	return owned, nil
+}
+
+// referencesTable returns whether the SQL text refers to table. If fkOnly is
+// set, only references in REFERENCES clauses are considered.
+func referencesTable(stmt, table string, fkOnly bool) bool {
+	scanner := sql.NewScanner(strings.NewReader(stmt))
+	prev := sql.ILLEGAL
+	for {
+		_, tok, lit := scanner.Scan()
+		switch tok {
+		case sql.EOF:
+			return false
+		case sql.IDENT, sql.QIDENT:
+			if (!fkOnly || prev == sql.REFERENCES) && strings.EqualFold(lit, table) {
+				return true
+			}
+		}
+		prev = tok
+	}
+}
+
+func deleteObjects(name string) string {
+	return fmt.Sprintf("DELETE FROM %s WHERE trash_name = %s", quote(ObjectsTable), quoteString(name))
+}
+
+// References returns whether the given SQL text references any table in
+// the trash.
+func References(stmt string) bool {
+	scanner := sql.NewScanner(strings.NewReader(stmt))
+	for {
+		_, tok, lit := scanner.Scan()
+		switch tok {
+		case sql.EOF:
+			return false
+		case sql.IDENT, sql.QIDENT, sql.STRING:
+			if strings.HasPrefix(strings.ToLower(lit), Prefix) {
+				return true
+			}
+		}
+	}
+}
+
+func hasDropTable(classes []string) bool {
+	for _, c := range classes {
+		if c == policy.ClassDropTable {
+			return true
+		}
+	}
+	return false
+}
+
+func quote(ident string) string {
+	return `"` + strings.ReplaceAll(ident, `"`, `""`) + `"`
+}
+
+func quoteString(s string) string {
+	return `'` + strings.ReplaceAll(s, `'`, `''`) + `'`
+}
@@ -0,0 +1,169 @@
+package trash
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rqlite/rqlite/command"
+)
+
+func Test_NameParse(t *testing.T) {
+	now := time.Unix(0, 1700000000123456789).UTC()
+	n := Name("foo_bar", now)
+	if n != "_rqlite_trash_1700000000123456789_foo_bar" {
+		t.Fatalf("wrong trash name: %s", n)
+	}
+	table, ts, ok := Parse(n)
+	if !ok {
+		t.Fatalf("failed to parse trash name %s", n)
+	}
+	if table != "foo_bar" {
+		t.Fatalf("wrong table name, exp foo_bar, got %s", table)
+	}
+	if !ts.Equal(now) {
+		t.Fatalf("wrong time, exp %s, got %s", now, ts)
+	}
+
+	for _, s := range []string{"foo", "_rqlite_trash_", "_rqlite_trash_123", "_rqlite_trash_123_", "_rqlite_trash_abc_foo"} {
+		if _, _, ok := Parse(s); ok {
+			t.Fatalf("parsed invalid trash name %s", s)
+		}
+	}
+}
+
+func Test_Rewrite(t *testing.T) {
+	now := time.Unix(0, 100)
+	tests := []struct {
+		in  string
+		exp string
+		err bool
+	}{
+		{in: "SELECT * FROM foo", exp: "SELECT * FROM foo"},
+		{in: "DELETE FROM foo", exp: "DELETE FROM foo"},
+		{in: "DROP VIEW foo", exp: "DROP VIEW foo"},
+		{in: "DROP TABLE foo", exp: `ALTER TABLE "foo" RENAME TO "_rqlite_trash_100_foo"`},
+		{in: `drop table "my table"`, exp: `ALTER TABLE "my table" RENAME TO "_rqlite_trash_100_my table"`},
+		{in: "DROP TABLE IF EXISTS foo", exp: `CREATE TABLE IF NOT EXISTS "foo" (_rqlite_trash_placeholder); ALTER TABLE "foo" RENAME TO "_rqlite_trash_100_foo"`},
+		{in: "DROP TABLE main.foo", err: true},
+		{in: "DROP TABLE foo; DROP TABLE bar", err: true},
+		{in: "SELECT 1; DROP TABLE bar", err: true},
+	}
+
+	for _, tt := range tests {
+		stmts := []*command.Statement{{Sql: tt.in}}
+		err := Rewrite(stmts, now, noSchema)
+		if tt.err {
+			if err == nil {
+				t.Fatalf("expected error rewriting %q", tt.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("failed to rewrite %q: %s", tt.in, err.Error())
+		}
+		if stmts[0].Sql != tt.exp {
+			t.Fatalf("wrong rewrite of %q, exp %q, got %q", tt.in, tt.exp, stmts[0].Sql)
+		}
+	}
+}
+
+func Test_RewriteDependents(t *testing.T) {
+	now := time.Unix(0, 100)
+	objects := []Object{
+		{Type: "table", Name: "foo", Table: "foo", SQL: "CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT UNIQUE)"},
+		{Type: "index", Name: "sqlite_autoindex_foo_1", Table: "foo"},
+		{Type: "index", Name: "foo_name", Table: "foo", SQL: "CREATE INDEX foo_name ON foo(name)"},
+		{Type: "trigger", Name: "foo_ins", Table: "foo", SQL: "CREATE TRIGGER foo_ins AFTER INSERT ON foo BEGIN INSERT INTO log VALUES('it''s'); END"},
+		{Type: "table", Name: "log", Table: "log", SQL: "CREATE TABLE log (foo TEXT)"},
+		{Type: "table", Name: "_rqlite_trash_50_child", Table: "_rqlite_trash_50_child", SQL: "CREATE TABLE child (id INTEGER, p INTEGER REFERENCES foo(id))"},
+	}
+	schema := func() ([]Object, error) { return objects, nil }
+
+	// The indexes and triggers of the table are recorded and dropped, while
+	// tables which only mention the table by name, and tables in the trash,
+	// do not stop it being dropped.
+	stmts := []*command.Statement{{Sql: "DROP TABLE foo"}}
+	if err := Rewrite(stmts, now, schema); err != nil {
+		t.Fatalf("failed to rewrite: %s", err.Error())
+	}
+	exp := `CREATE TABLE IF NOT EXISTS "_rqlite_trash_objects" (trash_name TEXT NOT NULL, sql TEXT NOT NULL); ` +
+		`INSERT INTO "_rqlite_trash_objects"(trash_name, sql) VALUES('_rqlite_trash_100_foo', 'CREATE INDEX foo_name ON foo(name)'); ` +
+		`INSERT INTO "_rqlite_trash_objects"(trash_name, sql) VALUES('_rqlite_trash_100_foo', 'CREATE TRIGGER foo_ins AFTER INSERT ON foo BEGIN INSERT INTO log VALUES(''it''''s''); END'); ` +
+		`DROP INDEX "foo_name"; DROP TRIGGER "foo_ins"; ` +
+		`ALTER TABLE "foo" RENAME TO "_rqlite_trash_100_foo"`
+	if stmts[0].Sql != exp {
+		t.Fatalf("wrong rewrite, exp %q, got %q", exp, stmts[0].Sql)
+	}
+
+	for _, o := range []Object{
+		{Type: "table", Name: "child", Table: "child", SQL: "CREATE TABLE child (id INTEGER, p INTEGER REFERENCES FOO(id))"},
+		{Type: "view", Name: "v", Table: "v", SQL: `CREATE VIEW v AS SELECT * FROM "foo"`},
+		{Type: "trigger", Name: "log_ins", Table: "log", SQL: "CREATE TRIGGER log_ins AFTER INSERT ON log BEGIN DELETE FROM foo; END"},
+	} {
+		referenced := append(objects[:len(objects):len(objects)], o)
+		stmts := []*command.Statement{{Sql: "DROP TABLE foo"}}
+		err := Rewrite(stmts, now, func() ([]Object, error) { return referenced, nil })
+		if !errors.Is(err, ErrReferenced) {
+			t.Fatalf("expected ErrReferenced for %s %s, got %v", o.Type, o.Name, err)
+		}
+	}
+}
+
+func Test_RestorePurge(t *testing.T) {
+	objects := []string{"CREATE INDEX foo_name ON foo(name)"}
+	exp := []string{
+		`ALTER TABLE "_rqlite_trash_100_foo" RENAME TO "foo"`,
+		"CREATE INDEX foo_name ON foo(name)",
+		`DELETE FROM "_rqlite_trash_objects" WHERE trash_name = '_rqlite_trash_100_foo'`,
+	}
+	if got := Restore("_rqlite_trash_100_foo", "foo", objects); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("wrong restore statements, exp %v, got %v", exp, got)
+	}
+	exp = []string{`ALTER TABLE "_rqlite_trash_100_foo" RENAME TO "foo"`}
+	if got := Restore("_rqlite_trash_100_foo", "foo", nil); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("wrong restore statements, exp %v, got %v", exp, got)
+	}
+
+	exp = []string{
+		`DROP TABLE "_rqlite_trash_100_foo"`,
+		`DELETE FROM "_rqlite_trash_objects" WHERE trash_name = '_rqlite_trash_100_foo'`,
+	}
+	if got := Purge("_rqlite_trash_100_foo", objects); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("wrong purge statements, exp %v, got %v", exp, got)
+	}
+}
+
+func noSchema() ([]Object, error) {
+	return nil, nil
+}
+
+func Test_References(t *testing.T) {
+	tests := []struct {
+		in  string
+		exp bool
+	}{
+		{"SELECT * FROM foo", false},
+		{"SELECT * FROM _rqlite_trash_100_foo", true},
+		{`SELECT * FROM "_rqlite_trash_100_foo"`, true},
+		{"SELECT * FROM [_rqlite_trash_100_foo]", true},
+		{"DROP TABLE _RQLITE_TRASH_100_foo", true},
+		{"SELECT * FROM '_rqlite_trash_100_foo'", true},
+		{"SELECT * FROM rqlite_trash", false},
+	}
+	for _, tt := range tests {
+		if got := References(tt.in); got != tt.exp {
+			t.Fatalf("wrong result for %q, exp %v, got %v", tt.in, tt.exp, got)
+		}
+	}
+}
+
+func Test_IsPlaceholder(t *testing.T) {
+	if !IsPlaceholder(`CREATE TABLE "foo" (_rqlite_trash_placeholder)`) {
+		t.Fatalf("placeholder not detected")
+	}
+	if IsPlaceholder(`CREATE TABLE foo (id INTEGER)`) {
+		t.Fatalf("non-placeholder detected as placeholder")
+	}
+}